@@ -3,12 +3,14 @@
 package main
 
 import (
+	"crypto/sha256"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
@@ -147,9 +149,40 @@ func buildAll() {
 		success("Built %s/%s", target.OS, target.Arch)
 	}
 
+	if err := writeChecksums(); err != nil {
+		fatal("Failed to write checksums: %v", err)
+	}
+
 	success("All builds completed successfully!")
 }
 
+// writeChecksums writes the SHA256 of every release binary to bin/SHA256SUMS, in
+// the format of sha256sum. dotfiles update verifies the binaries it downloads
+// against it.
+func writeChecksums() error {
+	matches, err := filepath.Glob(filepath.Join(buildDir, binaryName+"-*"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+
+	var sums strings.Builder
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&sums, "%x  %s\n", sha256.Sum256(data), filepath.Base(match))
+	}
+
+	outputPath := filepath.Join(buildDir, "SHA256SUMS")
+	if err := os.WriteFile(outputPath, []byte(sums.String()), 0644); err != nil {
+		return err
+	}
+	success("Wrote %s", outputPath)
+	return nil
+}
+
 func build(target BuildTarget, suffix string) error {
 	// Ensure build directory exists
 	if err := os.MkdirAll(buildDir, 0755); err != nil {
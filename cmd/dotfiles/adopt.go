@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultAdoptMaxSize is the largest file adopt copies into the repository
+const defaultAdoptMaxSize = 1 << 20
+
+// adoptedJobsHeader starts a jobs file created by adopt
+const adoptedJobsHeader = `# Adopted dotfiles
+# Files copied from this machine with 'dotfiles adopt', their content is in files/configs.
+# Move the tasks to other jobs files as you organize them.
+`
+
+// adoptedFile is the ensure_file task of an adopted file, in the order its fields
+// are written to the jobs file
+type adoptedFile struct {
+	Path          string `yaml:"path"`
+	ContentSource string `yaml:"content_source"`
+	Render        bool   `yaml:"render"`
+	Mode          string `yaml:"mode,omitempty"`
+
+	original string // Path of the adopted file
+	content  []byte // Content of the adopted file
+}
+
+// createAdoptCommand creates the adopt command
+func createAdoptCommand() *cobra.Command {
+	var (
+		jobsFile   string
+		asTemplate bool
+		replace    bool
+		maxSize    int64
+	)
+
+	adoptCmd := &cobra.Command{
+		Use:   "adopt <path>...",
+		Short: "Copy existing files into the repository and manage them with ensure_file",
+		Long: `Copy files from the home directory into files/configs of the repository, keeping
+their path relative to the home directory, and add an ensure_file task for each to
+a jobs file. The jobs file is created when it does not exist yet and imported from
+the jobs index.
+
+Symlinks, directories and files larger than --max-size are refused, as are files
+that a task already manages.
+
+--as-template adopts the files as templates (render: true), escaping {{, {% and {#
+so they render to the same content. --replace writes what apply produces for the
+new tasks over the original files, after checking it is the same content, to
+confirm the files round-trip.`,
+		Example: `  dotfiles adopt ~/.bashrc ~/.config/starship.toml
+  dotfiles adopt --as-template ~/.gitconfig
+  dotfiles adopt --jobs-file jobs/shell.yaml --replace ~/.zshrc`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				os.Exit(1)
+			}
+
+			backupDir, err := cfg.GetBackupPath(basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to resolve backup directory")
+				os.Exit(1)
+			}
+
+			ctx := &modules.ExecutionContext{
+				BasePath:      basePath,
+				Variables:     variables,
+				DryRun:        true,
+				Offline:       offline,
+				CreateBackups: cfg.Settings.CreateBackups,
+				BackupDir:     backupDir,
+				TemplatesDir:  cfg.GetTemplatesPath(basePath),
+			}
+
+			jobsIndexPath := cfg.GetJobsIndexPath(basePath)
+			tasksList, err := jobs.LoadJobsFromFileWithConditions(jobsIndexPath, variables, cfg.GetProfiles(nil))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				os.Exit(1)
+			}
+
+			home, err := os.UserHomeDir()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to determine home directory")
+				os.Exit(1)
+			}
+
+			targets := managedTargets(registry, tasksList, ctx)
+			configsDir := filepath.Join(cfg.GetFilesPath(basePath), "configs")
+
+			var adopted []*adoptedFile
+			failed := false
+			for _, arg := range args {
+				file, err := adoptFile(arg, home, basePath, configsDir, targets, asTemplate, maxSize)
+				if err != nil {
+					fmt.Printf("❌ %v\n", err)
+					failed = true
+					continue
+				}
+				adopted = append(adopted, file)
+				fmt.Printf("📥 Adopted %s → %s\n", file.original, file.ContentSource)
+			}
+
+			if len(adopted) > 0 {
+				jobsPath := jobsFile
+				if !filepath.IsAbs(jobsPath) {
+					jobsPath = filepath.Join(basePath, jobsPath)
+				}
+				if err := addAdoptedTasks(jobsPath, jobsIndexPath, adopted); err != nil {
+					log.Error().Err(err).Msg("Failed to add the tasks of the adopted files")
+					os.Exit(1)
+				}
+				relJobsPath, _ := filepath.Rel(basePath, jobsPath)
+				fmt.Printf("\n📝 Added %d ensure_file tasks to %s\n", len(adopted), filepath.ToSlash(relJobsPath))
+			}
+
+			if replace && len(adopted) > 0 {
+				fmt.Println()
+				if !replaceAdoptedFiles(registry, jobsIndexPath, variables, cfg, ctx, adopted) {
+					failed = true
+				}
+			}
+
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	adoptCmd.Flags().StringVar(&jobsFile, "jobs-file", "jobs/adopted.yaml", "Jobs file the ensure_file tasks are added to, relative to the repository")
+	adoptCmd.Flags().BoolVar(&asTemplate, "as-template", false, "Adopt the files as templates, escaping template syntax in their content")
+	adoptCmd.Flags().BoolVar(&replace, "replace", false, "Write what apply produces over the original files to confirm they round-trip")
+	adoptCmd.Flags().Int64Var(&maxSize, "max-size", defaultAdoptMaxSize, "Largest file in bytes that is adopted")
+
+	return adoptCmd
+}
+
+// adoptFile copies a file into the configs directory of the repository and returns
+// the ensure_file task that puts it back in place
+func adoptFile(arg, home, basePath, configsDir string, targets []*managedTarget, asTemplate bool, maxSize int64) (*adoptedFile, error) {
+	path, err := utils.ExpandPath(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", arg, err)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", arg, err)
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return nil, fmt.Errorf("%s is a symlink, adopt the file it points to instead", path)
+	case info.IsDir():
+		return nil, fmt.Errorf("%s is a directory, adopt the files in it instead", path)
+	case !info.Mode().IsRegular():
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	case info.Size() > maxSize:
+		return nil, fmt.Errorf("%s is %d bytes, larger than --max-size %d", path, info.Size(), maxSize)
+	}
+
+	for _, managed := range targetsAt(targets, path) {
+		return nil, fmt.Errorf("%s is already managed by '%s' (%s)", path, managed.Task.ID, managed.Task.Location())
+	}
+
+	rel, err := filepath.Rel(home, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside the home directory %s", path, home)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	stored := content
+	if asTemplate {
+		stored = []byte(templating.EscapeTemplate(string(content)))
+	}
+
+	source := filepath.Join(configsDir, rel)
+	if existing, err := os.ReadFile(source); err == nil {
+		if string(existing) != string(stored) {
+			return nil, fmt.Errorf("%s already exists in the repository with other content", source)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", source, err)
+		}
+		if err := os.WriteFile(source, stored, 0644); err != nil {
+			return nil, fmt.Errorf("failed to copy %s into the repository: %w", path, err)
+		}
+	}
+
+	relSource, _ := filepath.Rel(basePath, source)
+	file := &adoptedFile{
+		Path:          "~/" + filepath.ToSlash(rel),
+		ContentSource: filepath.ToSlash(relSource),
+		Render:        asTemplate,
+		original:      path,
+		content:       content,
+	}
+	// Windows has no permission bits to keep
+	if perm := info.Mode().Perm(); runtime.GOOS != "windows" && perm != 0644 {
+		file.Mode = fmt.Sprintf("%04o", perm)
+	}
+	return file, nil
+}
+
+// addAdoptedTasks adds the ensure_file tasks of adopted files to a jobs file, creating
+// it when needed, and imports the jobs file from the jobs index
+func addAdoptedTasks(jobsPath, jobsIndexPath string, adopted []*adoptedFile) error {
+	content, err := os.ReadFile(jobsPath)
+	if os.IsNotExist(err) {
+		content = []byte(adoptedJobsHeader)
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", jobsPath, err)
+	}
+
+	tasks := make([]interface{}, 0, len(adopted))
+	for _, file := range adopted {
+		tasks = append(tasks, file)
+	}
+	updated, err := config.AddTasksToJobsYAML(content, "ensure_file", tasks)
+	if err != nil {
+		return fmt.Errorf("%s: %w", jobsPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(jobsPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", jobsPath, err)
+	}
+	if err := os.WriteFile(jobsPath, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", jobsPath, err)
+	}
+
+	if filepath.Clean(jobsPath) == filepath.Clean(jobsIndexPath) {
+		return nil
+	}
+	importPath, err := filepath.Rel(filepath.Dir(jobsIndexPath), jobsPath)
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", jobsPath, err)
+	}
+	index, err := os.ReadFile(jobsIndexPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", jobsIndexPath, err)
+	}
+	updated, added, err := config.AddImportToJobsYAML(index, filepath.ToSlash(importPath))
+	if err != nil {
+		return fmt.Errorf("%s: %w", jobsIndexPath, err)
+	}
+	if !added {
+		return nil
+	}
+	if err := os.WriteFile(jobsIndexPath, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", jobsIndexPath, err)
+	}
+	fmt.Printf("📝 Imported %s from %s\n", filepath.ToSlash(importPath), filepath.Base(jobsIndexPath))
+	return nil
+}
+
+// replaceAdoptedFiles runs the new tasks of adopted files over the originals when
+// they produce the same content, and shows the difference when they do not. It
+// returns false when a file does not round-trip.
+func replaceAdoptedFiles(registry *modules.ModuleRegistry, jobsIndexPath string, variables map[string]interface{}, cfg *config.Config, ctx *modules.ExecutionContext, adopted []*adoptedFile) bool {
+	tasksList, err := jobs.LoadJobsFromFileWithConditions(jobsIndexPath, variables, cfg.GetProfiles(nil))
+	if err != nil {
+		fmt.Printf("❌ Failed to load the jobs with the adopted files: %v\n", err)
+		return false
+	}
+	targets := managedTargets(registry, tasksList, ctx)
+
+	applyCtx := *ctx
+	applyCtx.DryRun = false
+
+	ok := true
+	for _, file := range adopted {
+		matches := targetsAt(targets, file.original)
+		if len(matches) != 1 {
+			fmt.Printf("❌ %s: the new task is not loaded, check the conditions of the jobs file import\n", file.original)
+			ok = false
+			continue
+		}
+		managed := matches[0]
+
+		if managed.Target.Unknown || managed.Target.Content != string(file.content) {
+			fmt.Printf("❌ %s does not round-trip, apply would write:\n", file.original)
+			diff := utils.UnifiedDiff(string(file.content), managed.Target.Content, utils.DiffOptions{
+				Context:   3,
+				IntraLine: true,
+				Color:     ui.NewPalette(os.Stdout).Enabled(),
+			})
+			for _, line := range diff {
+				fmt.Printf("   %s\n", line)
+			}
+			ok = false
+			continue
+		}
+
+		if _, err := registry.ExecuteTask(managed.Task, &applyCtx); err != nil {
+			fmt.Printf("❌ %s: %v\n", file.original, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("✅ %s round-trips, it is now written by '%s'\n", file.original, managed.Task.ID)
+	}
+	return ok
+}
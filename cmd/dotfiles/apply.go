@@ -1,20 +1,32 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/journal"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/lock"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/commands"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/env"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/files"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/fonts"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/services"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/ssh"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/symlinks"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
 
 	"github.com/spf13/cobra"
 )
@@ -22,12 +34,29 @@ import (
 // createApplyCommand creates the apply command
 func createApplyCommand() *cobra.Command {
 	var (
-		platform    string
-		shell       string
-		environment []string
-		dryRun      bool
-		showDiff    bool
-		hideSkipped bool
+		platform     string
+		shell        string
+		environment  []string
+		profiles     []string
+		tags         []string
+		skipTags     []string
+		dryRun       bool
+		planExec     bool
+		showDiff     bool
+		diffContext  int
+		hideSkipped  bool
+		keepGoing    bool
+		rollback     bool
+		prune        bool
+		preflight    bool
+		assume       string
+		reportPath   string
+		reportFormat string
+		wait         time.Duration
+		strict       bool
+		forceWild    bool
+		skipElev     bool
+		repo         repoCheck
 	)
 
 	applyCmd := &cobra.Command{
@@ -40,38 +69,115 @@ This command will:
 - Install packages
 - Process templates
 - Run any configured scripts
+- Run the handlers notified by jobs that changed something, once each
 
-Use --dry-run to see what would be done without making changes (replaces the plan command).
+Use --dry-run to see what would be done without making changes (see also the plan command).
+Use --profile to also run jobs limited to a profile, instead of settings.default_profiles.
+Use --tags to only run the tasks with one of the given tags, --skip-tags to skip them.
 Use --hide-skipped to only show jobs that will make changes.
-Use --show-diff with --dry-run to see detailed file content differences.`,
+Use --show-diff with --dry-run to see detailed file content differences.
+Use --plan-exec with --dry-run to run the content_command of ensure_file tasks.
+Use --keep-going to continue with the remaining jobs when a job times out.
+Use --assume to answer on_conflict prompts in non-interactive runs.
+Use --rollback-on-failure to stop at the first failed job and restore every file
+changed so far (see also the rollback command).
+Use --prune to remove files and symlinks left behind by removed jobs after a
+successful apply (see also the cleanup command).
+Use --preflight to first check that the system has what the jobs need and stop
+before changing anything when a check fails (see also the doctor command).
+Use --wait to wait for another apply, cleanup or rollback to finish instead of
+failing right away; only one run may change the dotfiles at a time.
+Apply warns when the dotfiles repository is behind its upstream branch or has
+uncommitted changes to the jobs, variables or files; use --require-up-to-date and
+--require-clean to fail instead, and --no-fetch to skip fetching the upstream branch.
+Use --report to write a JSON or YAML report of every job for automation; the
+notifications section of dotfiles.yaml sends it to a webhook or shows a desktop
+notification when jobs changed something or failed.
+Use --strict to fail on imports whose path references variables that are not
+defined, instead of warning about them (see settings.strict_imports).`,
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 
+			if err := validateReportFormat(reportFormat); err != nil {
+				log.Error().Err(err).Msg("Invalid report format")
+				os.Exit(1)
+			}
+			if err := validateAssume(assume); err != nil {
+				log.Error().Err(err).Msg("Invalid --assume")
+				os.Exit(1)
+			}
+
+			// The report is written and sent to the notification targets on every
+			// exit path so automation can tell exactly where apply stopped
+			report := newApplyReport(dryRun)
+			var notificationTargets []*config.Notification
+			writeReport := func() {
+				defer sendNotifications(notificationTargets, report)
+				if reportPath == "" {
+					return
+				}
+				if err := report.write(reportPath, reportFormat); err != nil {
+					log.Error().Err(err).Str("path", reportPath).Msg("Failed to write apply report")
+				}
+			}
+			// os.Exit skips deferred calls, the lock is released before every exit
+			var runLock *lock.Lock
+			defer func() { releaseLock(runLock) }()
+			exit := func(err error) {
+				report.abort(err)
+				writeReport()
+				releaseLock(runLock)
+				os.Exit(1)
+			}
+
 			// Find and load configuration
 			configPath, err := findConfigFile()
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to find configuration file")
-				os.Exit(1)
+				handleConfigFileError(err)
+				exit(err)
 			}
 
 			cfg, err := config.Load(configPath)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to load configuration")
-				os.Exit(1)
+				exit(err)
+			}
+			notificationTargets = cfg.Notifications
+			if strict {
+				config.ConfigureStrictImports(true)
+			}
+			if cfg.Settings.LegacyOrdering {
+				log.Warn().Msg("settings.legacy_ordering runs the actions of every jobs file sorted by name and will be removed, order your jobs files the way they should run instead")
 			}
 
 			// Get base path
 			basePath := filepath.Dir(configPath)
 
+			// Only one run may change the dotfiles at a time
+			if !dryRun {
+				if runLock, err = acquireLock(basePath, "apply", wait); err != nil {
+					log.Error().Err(err).Msg("Failed to lock the dotfiles")
+					exit(err)
+				}
+			}
+
+			// Warn about running jobs that are not what the repository has upstream
+			state, err := repo.run(cfg, configPath, basePath)
+			report.Commit, report.Dirty = state.Commit, len(state.Changed) > 0
+			if err != nil {
+				log.Error().Err(err).Msg("Dotfiles repository check failed")
+				exit(err)
+			}
+
 			// Load variables
 			vloader, err := config.NewVariableLoader(cfg, basePath)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to create variable loader")
-				os.Exit(1)
+				exit(err)
 			}
 
 			// Prepare variable load options
-			opts := &config.VariableLoadOptions{}
+			opts := &config.VariableLoadOptions{UseCache: !noCache}
 			if platform != "" {
 				opts.Platform = platform
 			}
@@ -91,49 +197,120 @@ Use --show-diff with --dry-run to see detailed file content differences.`,
 			variables, err := vloader.LoadAllVariables(opts)
 			if err != nil {
 				handleVariableError(err)
-				os.Exit(1)
+				exit(err)
 			}
 
 			// Load jobs with condition filtering
 			jobsIndexPath := cfg.GetJobsIndexPath(basePath)
-			tasksList, err := jobs.LoadJobsFromFileWithConditions(jobsIndexPath, variables)
+			selection := &taskSelection{Profiles: cfg.GetProfiles(profiles), Tags: tags, SkipTags: skipTags}
+			tasksList, handlers, err := jobs.LoadJobsAndHandlers(jobsIndexPath, variables, selection.Profiles)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to load jobs")
-				os.Exit(1)
+				exit(err)
+			}
+
+			// Tasks left out by --tags are still managed, --prune must not remove their files
+			allTasks := tasksList
+			tasksList, err = filterTasksByTags(jobsIndexPath, variables, tasksList, selection)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to select tasks by tag")
+				exit(err)
 			}
 
 			if len(tasksList) == 0 {
 				log.Info().Msg("No jobs found. Check your jobs/index.yaml file.")
+				writeReport()
 				return
 			}
 
 			// Create module registry
-			registry := modules.NewModuleRegistry()
-			if err := registry.Register(commands.New()); err != nil {
-				log.Error().Err(err).Msg("Failed to register commands module")
-				os.Exit(1)
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				exit(err)
 			}
-			if err := registry.Register(files.New()); err != nil {
-				log.Error().Err(err).Msg("Failed to register files module")
-				os.Exit(1)
+
+			// Two tasks putting different things at one path would flip it on every run
+			if err := checkTargetConflicts(registry, tasksList, basePath, cfg.GetTemplatesPath(basePath), variables); err != nil {
+				exit(err)
 			}
-			if err := registry.Register(packages.New()); err != nil {
-				log.Error().Err(err).Msg("Failed to register packages module")
-				os.Exit(1)
+
+			backupDir, err := cfg.GetBackupPath(basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to resolve backup directory")
+				exit(err)
 			}
-			if err := registry.Register(symlinks.New()); err != nil {
-				log.Error().Err(err).Msg("Failed to register symlinks module")
-				os.Exit(1)
+
+			defaultTimeout, err := cfg.GetDefaultTaskTimeout()
+			if err != nil {
+				log.Error().Err(err).Msg("Invalid default task timeout")
+				exit(err)
+			}
+			defaultRetryDelay, err := cfg.GetDefaultRetryDelay()
+			if err != nil {
+				log.Error().Err(err).Msg("Invalid default retry delay")
+				exit(err)
+			}
+			defaultRetry := modules.RetryPolicy{
+				Retries: cfg.Settings.DefaultRetries,
+				Delay:   defaultRetryDelay,
+				Backoff: cfg.Settings.DefaultRetryBackoff,
+			}
+			slowThreshold, err := cfg.GetSlowApplyThreshold()
+			if err != nil {
+				log.Error().Err(err).Msg("Invalid slow apply threshold")
+				exit(err)
+			}
+
+			// Record the previous state of everything apply changes so a failed
+			// apply can be rolled back, also by a later `dotfiles rollback`
+			var txn *journal.Journal
+			if rollback && !dryRun {
+				if txn, err = beginRollbackJournal(basePath); err != nil {
+					log.Error().Err(err).Msg("Failed to start rollback journal")
+					exit(err)
+				}
+			} else if !dryRun && journal.Exists(basePath) {
+				log.Warn().Msg("An earlier apply did not finish, run 'dotfiles rollback' to restore the files it changed")
 			}
 
+			// Commands that are still running are killed on Ctrl+C
+			runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
 			// Create execution context
 			ctx := &modules.ExecutionContext{
-				BasePath:    basePath,
-				Variables:   variables,
-				DryRun:      dryRun,
-				Verbose:     verbose,
-				ShowDiff:    showDiff,
-				HideSkipped: hideSkipped,
+				BasePath:        basePath,
+				Variables:       variables,
+				DryRun:          dryRun,
+				Verbose:         verbose,
+				ShowDiff:        showDiff,
+				DiffContext:     diffContext,
+				DiffColor:       ui.NewPalette(os.Stdout).Enabled(),
+				HideSkipped:     hideSkipped,
+				CreateBackups:   cfg.Settings.CreateBackups,
+				BackupDir:       backupDir,
+				TemplatesDir:    cfg.GetTemplatesPath(basePath),
+				Offline:         offline,
+				SudoCommand:     cfg.Settings.SudoCommand,
+				PackageManagers: cfg.Settings.PackageManagers,
+				Context:         runCtx,
+				DefaultTimeout:  defaultTimeout,
+				DefaultRetry:    defaultRetry,
+				AssumeConflict:  assume,
+				Prompt:          newTerminalPrompt(),
+				PlanExec:        planExec,
+				ForceWildcards:  forceWild,
+				Elevated:        processElevated(),
+				SkipElevated:    skipElev,
+			}
+
+			if preflight {
+				fmt.Printf("🩺 Running pre-flight checks...\n\n")
+				if !runPreflight(basePath, registry, tasksList, ctx) {
+					fmt.Printf("⛔ Pre-flight checks failed, nothing was changed\n\n")
+					exit(fmt.Errorf("pre-flight checks failed"))
+				}
 			}
 
 			// Show what we're about to do
@@ -142,44 +319,151 @@ Use --show-diff with --dry-run to see detailed file content differences.`,
 			} else {
 				fmt.Printf("🚀 Applying dotfiles configuration...\n\n")
 			}
+			selection.print()
 
 			// Execute all jobs
 			successCount := 0
 			skipCount := 0
 			failCount := 0
 
+			aborted := false
+			var attention []string
+			notifications := make(handlerNotifications)
+
+			// Tasks whose files and symlinks are in place, recorded in the state
+			// so cleanup can find them once the task is removed
+			var applied []*config.Task
+
+			// On a terminal, apply shows a progress bar and one line per finished job
+			// instead of every job's details. The output of a job is captured and
+			// only shown when it fails.
+			var progress *ui.Progress
+			if !dryRun && !verbose {
+				progress = ui.NewProgress(os.Stdout, len(tasksList))
+			}
+			var capture *ui.Capture
+			if progress != nil && ctx.Prompt != nil {
+				prompt := ctx.Prompt
+				ctx.Prompt = func(question string, choices []string) (answer string, err error) {
+					progress.Stop()
+					defer progress.Resume()
+					if capture == nil {
+						return prompt(question, choices)
+					}
+					capture.Passthrough(func() { answer, err = prompt(question, choices) })
+					return answer, err
+				}
+			}
+
+			// details prints the line by line output that the progress bar replaces
+			details := func(format string, args ...interface{}) {
+				if progress == nil {
+					fmt.Printf(format, args...)
+				}
+			}
+
+			// finishTask replaces the progress bar with the result of a job
+			finishTask := func(i int, task *config.Task, displayName, icon, message string, failed bool) {
+				if progress == nil {
+					return
+				}
+				progress.Stop()
+				output := ""
+				if capture != nil {
+					output = strings.TrimSpace(capture.Stop())
+					capture = nil
+				}
+				if icon == "" {
+					return
+				}
+
+				line := fmt.Sprintf("%s [%d/%d] %s (%s)", icon, i+1, len(tasksList), displayName, task.Action)
+				if message != "" {
+					line += ": " + message
+				}
+				fmt.Println(line)
+				if failed && task.Source != "" {
+					fmt.Printf("      source: %s\n", task.Location())
+				}
+				if failed && output != "" {
+					for _, outputLine := range strings.Split(output, "\n") {
+						fmt.Printf("      %s\n", outputLine)
+					}
+				}
+			}
+
 			for i, task := range tasksList {
+				if runCtx.Err() != nil {
+					fmt.Printf("⛔ Interrupted, skipping remaining %d jobs\n\n", len(tasksList)-i)
+					aborted = true
+					report.abort(runCtx.Err())
+					report.addNotRun(tasksList[i:])
+					break
+				}
+
+				displayName := renderTaskDisplayName(task, variables)
+				if progress != nil {
+					progress.Start(i+1, fmt.Sprintf("%s (%s)", displayName, task.Action))
+					if capture, err = ui.StartCapture(); err != nil {
+						log.Debug().Err(err).Msg("Failed to capture job output")
+					}
+				}
+
 				// Plan the task first
+				taskStart := time.Now()
 				plan, err := registry.PlanTask(task, ctx)
+				planTime := time.Since(taskStart)
+				var executeTime time.Duration
+				group := timingGroup(registry, task, plan)
 				if err != nil {
-					log.Error().Err(err).Str("task", task.ID).Msg("Failed to plan task")
+					finishTask(i, task, displayName, "❌", err.Error(), true)
+					log.Error().Err(err).Str("task", task.ID).Str("source", task.Location()).Msg("Failed to plan task")
 					failCount++
+					report.addTask(task, nil, "failed", time.Since(taskStart), err).setTiming(displayName, group, planTime, 0)
+					if txn != nil {
+						fmt.Printf("⛔ Rolling back, skipping remaining %d jobs\n\n", len(tasksList)-i-1)
+						aborted = true
+						report.abort(err)
+						report.addNotRun(tasksList[i+1:])
+						break
+					}
+					if shouldAbort(err, keepGoing) {
+						fmt.Printf("⛔ Aborting after timeout, skipping remaining %d jobs (use --keep-going to continue)\n\n", len(tasksList)-i-1)
+						aborted = true
+						report.abort(err)
+						report.addNotRun(tasksList[i+1:])
+						break
+					}
 					continue
 				}
 
 				// Check if we should skip this task
+				sourceInfo := ""
+				if task.Source != "" {
+					sourceInfo = fmt.Sprintf(" [from: %s]", task.Location())
+				}
 				if plan.WillSkip {
-					if !hideSkipped {
-						displayName := renderTaskDisplayName(task, variables)
-						sourceInfo := ""
-						if task.Source != "" {
-							sourceInfo = fmt.Sprintf(" [from: %s]", task.Source)
+					if hideSkipped {
+						finishTask(i, task, displayName, "", "", false)
+					} else {
+						finishTask(i, task, displayName, "⏭️ ", plan.SkipReason, false)
+						details("[%d/%d] %s (%s)%s\n", i+1, len(tasksList), displayName, task.Action, sourceInfo)
+						details("   ⏭️  SKIP: %s\n", plan.SkipReason)
+						if verbose {
+							printTaskTiming(planTime, 0, false)
 						}
-						fmt.Printf("[%d/%d] %s (%s)%s\n", i+1, len(tasksList), displayName, task.Action, sourceInfo)
-						fmt.Printf("   ⏭️  SKIP: %s\n", plan.SkipReason)
-						fmt.Println()
+						details("\n")
 					}
 					skipCount++
+					report.addTask(task, plan, "skipped", time.Since(taskStart), nil).setTiming(displayName, group, planTime, 0)
+					if plan.Conflict == "" {
+						applied = append(applied, task)
+					}
 					continue
 				}
 
 				// Show job header for tasks that will execute
-				displayName := renderTaskDisplayName(task, variables)
-				sourceInfo := ""
-				if task.Source != "" {
-					sourceInfo = fmt.Sprintf(" [from: %s]", task.Source)
-				}
-				fmt.Printf("[%d/%d] %s (%s)%s\n", i+1, len(tasksList), displayName, task.Action, sourceInfo)
+				details("[%d/%d] %s (%s)%s\n", i+1, len(tasksList), displayName, task.Action, sourceInfo)
 
 				if dryRun {
 					fmt.Printf("   📋 Would do:\n")
@@ -187,7 +471,7 @@ Use --show-diff with --dry-run to see detailed file content differences.`,
 						fmt.Printf("      - %s\n", change)
 					}
 				} else {
-					fmt.Printf("   📋 Description: %s\n", plan.Description)
+					details("   📋 Description: %s\n", plan.Description)
 					if verbose && len(plan.Changes) > 0 {
 						fmt.Printf("   Changes:\n")
 						for _, change := range plan.Changes {
@@ -198,25 +482,142 @@ Use --show-diff with --dry-run to see detailed file content differences.`,
 
 				// Execute the task (unless dry run)
 				if !dryRun {
-					result, err := registry.ExecuteTask(task, ctx)
+					var result *modules.TaskResult
+					executeStart := time.Now()
+					if txn != nil {
+						err = journalTask(txn, registry, task, ctx, displayName)
+					}
+					if err == nil {
+						result, err = registry.ExecuteTask(task, ctx)
+					}
+					executeTime = time.Since(executeStart)
 					if err != nil {
-						log.Error().Err(err).Str("task", task.ID).Msg("Failed to execute task")
-						fmt.Printf("   ❌ FAILED: %v\n", err)
+						finishTask(i, task, displayName, "❌", err.Error(), true)
+						log.Error().Err(err).Str("task", task.ID).Str("source", task.Location()).Msg("Failed to execute task")
+						details("   ❌ FAILED: %v\n", err)
 						failCount++
+						entry := report.addTask(task, plan, "failed", time.Since(taskStart), err)
+						entry.setTiming(displayName, group, planTime, executeTime)
+						if result != nil {
+							entry.setResult(result)
+						}
+						if txn != nil {
+							fmt.Printf("\n⛔ Rolling back, skipping remaining %d jobs\n\n", len(tasksList)-i-1)
+							aborted = true
+							report.abort(err)
+							report.addNotRun(tasksList[i+1:])
+							break
+						}
+						if shouldAbort(err, keepGoing) {
+							fmt.Printf("\n⛔ Aborting after timeout, skipping remaining %d jobs (use --keep-going to continue)\n\n", len(tasksList)-i-1)
+							aborted = true
+							report.abort(err)
+							report.addNotRun(tasksList[i+1:])
+							break
+						}
+					} else if result.Skipped {
+						finishTask(i, task, displayName, "⏭️ ", result.Message, false)
+						details("   ⏭️  SKIP: %s\n", result.Message)
+						skipCount++
+						report.addResult(task, plan, result, time.Since(taskStart)).setTiming(displayName, group, planTime, executeTime)
+					} else if result.NeedsAttention {
+						finishTask(i, task, displayName, "⚠️ ", result.Message, false)
+						details("   ⚠️  NEEDS ATTENTION: %s\n", result.Message)
+						successCount++
+						notifications.notify(task, displayName)
+						applied = append(applied, task)
+						attention = append(attention, fmt.Sprintf("%s: %s", displayName, result.Message))
+						report.addResult(task, plan, result, time.Since(taskStart)).setTiming(displayName, group, planTime, executeTime)
 					} else if result.Success {
-						fmt.Printf("   ✅ SUCCESS\n")
+						finishTask(i, task, displayName, "✅", "", false)
+						details("   ✅ SUCCESS\n")
 						successCount++
+						notifications.notify(task, displayName)
+						applied = append(applied, task)
+						report.addResult(task, plan, result, time.Since(taskStart)).setTiming(displayName, group, planTime, executeTime)
 					} else {
-						fmt.Printf("   ❌ FAILED: %s\n", result.Message)
+						finishTask(i, task, displayName, "❌", result.Message, true)
+						details("   ❌ FAILED: %s\n", result.Message)
 						failCount++
+						entry := report.addTask(task, plan, "failed", time.Since(taskStart), errors.New(result.Message))
+						entry.setResult(result)
+						entry.setTiming(displayName, group, planTime, executeTime)
+						if txn != nil {
+							fmt.Printf("\n⛔ Rolling back, skipping remaining %d jobs\n\n", len(tasksList)-i-1)
+							aborted = true
+							report.abort(errors.New(result.Message))
+							report.addNotRun(tasksList[i+1:])
+							break
+						}
 					}
 				} else {
 					successCount++
+					notifications.notify(task, displayName)
+					report.addTask(task, plan, "planned", time.Since(taskStart), nil).setTiming(displayName, group, planTime, 0)
 				}
 
+				if verbose {
+					printTaskTiming(planTime, executeTime, !dryRun)
+				}
+				details("\n")
+			}
+			if progress != nil {
 				fmt.Println()
 			}
 
+			// Handlers run once at the end, after every task that notified them
+			handlerFailCount := 0
+			if notified := notifications.notified(handlers); len(notified) > 0 && !aborted {
+				if dryRun {
+					printNotifiedHandlers(notified, notifications)
+				} else {
+					handlerFailCount = runHandlers(registry, notified, ctx, report)
+				}
+			}
+
+			rolledBack := false
+			if txn != nil {
+				if failCount > 0 || aborted {
+					fmt.Printf("↩️  Rolling back changes...\n")
+					printRollbackResult(txn.Rollback())
+					rolledBack = true
+				} else if err := txn.Discard(); err != nil {
+					log.Warn().Err(err).Msg("Failed to remove rollback journal")
+				}
+			}
+
+			if !dryRun && !rolledBack {
+				if err := recordState(basePath, registry, applied, ctx); err != nil {
+					log.Warn().Err(err).Msg("Failed to record the files apply put in place")
+				}
+			}
+
+			pruneFailed := false
+			if prune && failCount == 0 && handlerFailCount == 0 && !aborted {
+				ok, err := cleanupState(basePath, registry, allTasks, ctx, cleanupOptions{DryRun: dryRun})
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to prune files left behind by removed jobs")
+				}
+				pruneFailed = err != nil || !ok
+			}
+
+			writeReport()
+
+			// How long apply and its slowest tasks took, kept for status --verbose
+			timings := report.timings()
+			if !dryRun {
+				saveApplyTimings(basePath, timings)
+			}
+
+			// Prompts show the drift through status --porcelain, unless only some tasks ran
+			if len(profiles) == 0 && len(tags) == 0 && len(skipTags) == 0 && platform == "" && !aborted && !rolledBack {
+				if dryRun {
+					writeStatusSummary(basePath, "plan", state, successCount)
+				} else {
+					writeStatusSummary(basePath, "apply", state, failCount)
+				}
+			}
+
 			// Summary
 			if dryRun {
 				fmt.Printf("📊 Dry Run Summary:\n")
@@ -225,12 +626,26 @@ Use --show-diff with --dry-run to see detailed file content differences.`,
 				if failCount > 0 {
 					fmt.Printf("   Failed to plan: %d jobs\n", failCount)
 				}
+				printSlowestTasks(timings, slowThreshold)
 			} else {
 				fmt.Printf("📊 Execution Summary:\n")
 				fmt.Printf("   Successful: %d jobs\n", successCount)
 				fmt.Printf("   Skipped: %d jobs\n", skipCount)
 				if failCount > 0 {
 					fmt.Printf("   Failed: %d jobs\n", failCount)
+				}
+				if handlerFailCount > 0 {
+					fmt.Printf("   Failed handlers: %d\n", handlerFailCount)
+				}
+				if len(attention) > 0 {
+					fmt.Printf("   Needs attention: %d jobs\n", len(attention))
+					for _, message := range attention {
+						fmt.Printf("      ⚠️  %s\n", message)
+					}
+				}
+				printSlowestTasks(timings, slowThreshold)
+				if failCount > 0 || handlerFailCount > 0 || aborted || pruneFailed {
+					releaseLock(runLock)
 					os.Exit(1)
 				}
 			}
@@ -239,6 +654,9 @@ Use --show-diff with --dry-run to see detailed file content differences.`,
 				if dryRun {
 					log.Info().Msg("Dry run completed successfully!")
 				} else {
+					if err := writeLastApplied(basePath); err != nil {
+						log.Warn().Err(err).Msg("Failed to record when the dotfiles were applied")
+					}
 					log.Info().Msg("All jobs completed successfully!")
 				}
 			}
@@ -248,13 +666,105 @@ Use --show-diff with --dry-run to see detailed file content differences.`,
 	applyCmd.Flags().StringVar(&platform, "platform", "", "Override platform detection (windows, linux, darwin)")
 	applyCmd.Flags().StringVar(&shell, "shell", "", "Override shell detection (bash, zsh, powershell)")
 	applyCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
+	applyCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles to apply, jobs limited to other profiles are skipped (default settings.default_profiles)")
+	applyCmd.Flags().StringSliceVar(&tags, "tags", nil, "Only apply tasks with one of these tags")
+	applyCmd.Flags().StringSliceVar(&skipTags, "skip-tags", nil, "Skip tasks with one of these tags")
+	applyCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	applyCmd.RegisterFlagCompletionFunc("tags", completeTags)
+	applyCmd.RegisterFlagCompletionFunc("skip-tags", completeTags)
 	applyCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show what would be done without making changes")
+	applyCmd.Flags().BoolVar(&planExec, "plan-exec", false, "Run content_command of ensure_file tasks to show their actual changes (use with --dry-run)")
+	applyCmd.Flags().BoolVar(&forceWild, "force-wildcards", false, "Let wildcard uninstalls remove more packages than max_removals (essential packages are never removed)")
+	applyCmd.Flags().BoolVar(&skipElev, "skip-elevated", false, "Skip tasks with become when they cannot be elevated (Windows without Administrator) instead of failing them")
 	applyCmd.Flags().BoolVar(&showDiff, "show-diff", false, "Show detailed diffs of file changes (use with --dry-run)")
+	applyCmd.Flags().IntVar(&diffContext, "diff-context", 3, "Unchanged lines shown around each change in diffs")
 	applyCmd.Flags().BoolVar(&hideSkipped, "hide-skipped", false, "Hide skipped jobs from output")
+	applyCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "Continue with the remaining jobs when a job times out")
+	applyCmd.Flags().StringVar(&assume, "assume", "", "Answer on_conflict prompts without asking (overwrite, keep, merge-markers)")
+	applyCmd.Flags().BoolVar(&rollback, "rollback-on-failure", false, "Stop at the first failed job and restore the files changed so far")
+	applyCmd.Flags().BoolVar(&prune, "prune", false, "Remove files and symlinks left behind by removed jobs after a successful apply")
+	applyCmd.Flags().BoolVar(&preflight, "preflight", false, "Check that the system has what the jobs need first and stop when a check fails")
+	applyCmd.Flags().StringVar(&reportPath, "report", "", "Write a machine-readable report of all jobs to this file")
+	applyCmd.Flags().DurationVar(&wait, "wait", 0, "Wait up to this long for another run holding the lock to finish (e.g. 5m)")
+	applyCmd.Flags().BoolVar(&strict, "strict", false, "Fail on imports whose path does not resolve instead of warning (default settings.strict_imports)")
+	repo.addFlags(applyCmd)
+	applyCmd.Flags().StringVar(&reportFormat, "report-format", "json", "Format of the report written by --report (json, yaml)")
 
 	return applyCmd
 }
 
+// newModuleRegistry creates a registry with all modules apply can run
+func newModuleRegistry() (*modules.ModuleRegistry, error) {
+	registry := modules.NewModuleRegistry()
+	for _, module := range []modules.Module{commands.New(), env.New(), files.New(), fonts.New(), packages.New(), services.New(), ssh.New(), symlinks.New()} {
+		if err := registry.Register(module); err != nil {
+			return nil, fmt.Errorf("failed to register %s module: %w", module.Name(), err)
+		}
+	}
+	return registry, nil
+}
+
+// writeLastApplied updates the marker dotfiles status reads the last apply from
+func writeLastApplied(basePath string) error {
+	path := filepath.Join(config.StateDir(basePath), config.LastAppliedFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// validateAssume checks the value of --assume
+func validateAssume(assume string) error {
+	if assume == "" {
+		return nil
+	}
+	for _, choice := range files.ConflictResolutions {
+		if assume == choice {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid choice '%s', expected one of %s", assume, strings.Join(files.ConflictResolutions, ", "))
+}
+
+// newTerminalPrompt returns a prompt reading answers from stdin, or nil when stdin
+// is not a terminal and nobody can answer
+func newTerminalPrompt() modules.PromptFunc {
+	if !ui.IsTerminal(os.Stdin) {
+		return nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	return func(question string, choices []string) (string, error) {
+		for {
+			fmt.Printf("   %s [%s] ", question, strings.Join(choices, "/"))
+			answer, err := reader.ReadString('\n')
+
+			// A prefix of a choice is accepted, e.g. "k" for keep
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "" {
+				for _, choice := range choices {
+					if strings.HasPrefix(choice, answer) {
+						return choice, nil
+					}
+				}
+			}
+			if err != nil {
+				return "", fmt.Errorf("no answer given: %w", err)
+			}
+			fmt.Printf("   Please answer one of %s\n", strings.Join(choices, ", "))
+		}
+	}
+}
+
+// shouldAbort reports whether apply should stop after a failed job. Jobs that
+// time out abort the run unless --keep-going is set, other failures never do.
+func shouldAbort(err error, keepGoing bool) bool {
+	return !keepGoing && errors.Is(err, context.DeadlineExceeded)
+}
+
+// displayNameEngine renders the templates in task IDs
+var displayNameEngine = templating.NewTemplatingEngine("")
+
 // renderTaskDisplayName processes templates in task ID to show actual paths
 func renderTaskDisplayName(task *config.Task, variables map[string]interface{}) string {
 	// Create better display names for package tasks
@@ -277,27 +787,36 @@ func renderTaskDisplayName(task *config.Task, variables map[string]interface{})
 		}
 	}
 
-	tmpl := template.New("taskID").Option("missingkey=zero").Funcs(template.FuncMap{
-		"pathJoin":  filepath.Join,
-		"pathSep":   func() string { return string(filepath.Separator) },
-		"pathClean": filepath.Clean,
-	})
-
-	parsedTmpl, err := tmpl.Parse(task.ID)
+	// If the template fails, show the ID as written
+	renderedID, err := displayNameEngine.ProcessPath(task.ID, task.ScopedVariables(variables))
 	if err != nil {
-		// If template parsing fails, return original ID
 		return task.ID
 	}
+	return renderedID
+}
 
-	var result strings.Builder
-	if err := parsedTmpl.Execute(&result, variables); err != nil {
-		// If template execution fails, return original ID
-		return task.ID
-	}
+// checkTargetConflicts fails when tasks put different content at the same path and
+// warns about tasks that put the same content there
+func checkTargetConflicts(registry *modules.ModuleRegistry, tasksList []*config.Task, basePath, templatesDir string, variables map[string]interface{}) error {
+	log := logger.Get()
 
-	// Convert all path separators to OS-specific ones
-	renderedID := result.String()
-	return filepath.FromSlash(renderedID)
+	ctx := &modules.ExecutionContext{
+		BasePath:     basePath,
+		Variables:    variables,
+		DryRun:       true,
+		TemplatesDir: templatesDir,
+	}
+	warnings, err := registry.CheckTargetConflicts(tasksList, ctx)
+	for _, warning := range warnings {
+		log.Warn().
+			Str("first", warning.First.Location()).
+			Str("second", warning.Second.Location()).
+			Msgf("%s is managed by both '%s' and '%s' with the same content", warning.Path, warning.First.ID, warning.Second.ID)
+	}
+	if conflictErr, isConflict := modules.IsTargetConflictError(err); isConflict {
+		fmt.Print(conflictErr.PrettyPrint())
+	}
+	return err
 }
 
 // handleVariableError handles variable loading errors with special formatting for conflicts
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/backup"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// createBackupCommand creates the backup command
+func createBackupCommand() *cobra.Command {
+	var (
+		platform    string
+		shell       string
+		environment []string
+		prune       int
+	)
+
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Backup current configuration files",
+		Long: `Snapshot every file that apply would overwrite.
+
+The jobs configuration is parsed to find the targets of all ensure_file,
+line_in_file, block_in_file and symlink tasks. Existing files are copied into a timestamped directory inside
+the configured backup_dir together with a manifest.yaml that records their
+original paths, modes and SHA-256 hashes. Targets that do not exist yet are skipped.
+
+Use --prune N to keep only the N most recent snapshots.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			backupDir, err := cfg.GetBackupPath(basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to resolve backup directory")
+				os.Exit(1)
+			}
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{
+				Platform:    platform,
+				Shell:       shell,
+				Environment: parseEnvironmentVariables(environment),
+				UseCache:    !noCache,
+			})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			tasksList, err := jobs.LoadJobsFromFileWithConditions(cfg.GetJobsIndexPath(basePath), variables, cfg.GetProfiles(nil))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				os.Exit(1)
+			}
+
+			targets, err := collectBackupTargets(tasksList, basePath, variables)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to determine backup targets")
+				os.Exit(1)
+			}
+
+			fmt.Printf("💾 Backing up %d target(s) to %s...\n\n", len(targets), backupDir)
+
+			result, err := backup.CreateSnapshot(backupDir, targets, time.Now())
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create backup")
+				os.Exit(1)
+			}
+
+			for _, entry := range result.Manifest.Entries {
+				if entry.Symlink != "" {
+					fmt.Printf("   🔗 %s -> %s\n", entry.Path, entry.Symlink)
+				} else {
+					fmt.Printf("   ✅ %s\n", entry.Path)
+				}
+			}
+			if verbose {
+				for _, path := range result.Missing {
+					fmt.Printf("   ⏭️  %s (does not exist)\n", path)
+				}
+			}
+			for _, path := range result.Skipped {
+				fmt.Printf("   ⏭️  %s (not a regular file or symlink)\n", path)
+			}
+
+			fmt.Printf("\n📊 Backup Summary:\n")
+			fmt.Printf("   Saved: %d files\n", len(result.Manifest.Entries))
+			fmt.Printf("   Missing: %d files\n", len(result.Missing))
+			if len(result.Skipped) > 0 {
+				fmt.Printf("   Skipped: %d paths\n", len(result.Skipped))
+			}
+			fmt.Printf("   Location: %s\n", result.Dir)
+
+			if cmd.Flags().Changed("prune") {
+				removed, err := backup.Prune(backupDir, prune)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to prune old backups")
+					os.Exit(1)
+				}
+				fmt.Printf("   Pruned: %d old snapshot(s)\n", len(removed))
+			}
+		},
+	}
+
+	backupCmd.Flags().StringVar(&platform, "platform", "", "Override platform detection (windows, linux, darwin)")
+	backupCmd.Flags().StringVar(&shell, "shell", "", "Override shell detection (bash, zsh, powershell)")
+	backupCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
+	backupCmd.Flags().IntVar(&prune, "prune", 0, "Keep only the N most recent snapshots")
+
+	return backupCmd
+}
+
+// collectBackupTargets returns the destination paths of all tasks that write files
+func collectBackupTargets(tasksList []*config.Task, basePath string, variables map[string]interface{}) ([]backup.Target, error) {
+	engine := templating.NewTemplatingEngine(basePath)
+
+	var targets []backup.Target
+	for _, task := range tasksList {
+		var key string
+		switch task.Action {
+		case "ensure_file", "line_in_file", "block_in_file":
+			key = "path"
+		case "symlink":
+			key = "dst"
+		default:
+			continue
+		}
+
+		rawPath, ok := task.Config[key].(string)
+		if !ok {
+			continue
+		}
+
+		rendered, err := engine.ProcessPath(rawPath, variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process %s template for job '%s': %w", key, task.ID, err)
+		}
+
+		path, err := utils.ExpandPath(rendered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path for job '%s': %w", task.ID, err)
+		}
+
+		targets = append(targets, backup.Target{Path: path, Source: task.Source})
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+
+	"github.com/spf13/cobra"
+)
+
+// createBecomeHelperCommand creates the hidden command apply runs through
+// settings.sudo_command for tasks with become: true
+func createBecomeHelperCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:         modules.BecomeHelperCommand,
+		Short:       "Run a single task as root (used by tasks with become)",
+		Hidden:      true,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipSettingsAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			// The result is the only thing written to stdout, what the task prints
+			// goes to stderr and is shown by the apply that started the helper
+			result := os.Stdout
+			os.Stdout = os.Stderr
+
+			// Templates of the task may read secrets
+			if configPath, err := findConfigFile(); err == nil {
+				if cfg, err := config.Load(configPath); err == nil && cfg.Settings != nil {
+					if secretsPath, err := cfg.GetSecretsPath(filepath.Dir(configPath)); err == nil {
+						templating.ConfigureSecrets(secretsPath, cfg.Settings.SecretCommand)
+					}
+				}
+			}
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				os.Exit(1)
+			}
+			if err := registry.ServeBecome(os.Stdin, result); err != nil {
+				log.Error().Err(err).Msg("Failed to run the task as root")
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// processElevated reports whether dotfiles runs as root or Administrator, so
+// tasks with become run without elevating them again
+func processElevated() bool {
+	info, err := platform.GetPlatformInfo()
+	return err == nil && info.IsElevated
+}
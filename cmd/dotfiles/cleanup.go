@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/lock"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/state"
+
+	"github.com/spf13/cobra"
+)
+
+// createCleanupCommand creates the cleanup command
+func createCleanupCommand() *cobra.Command {
+	var (
+		profiles []string
+		dryRun   bool
+		force    bool
+		yes      bool
+		wait     time.Duration
+	)
+
+	cleanupCmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove files left behind by jobs that were removed",
+		Long: `Remove the files and symlinks an earlier apply put in place that no job
+manages anymore, e.g. because an ensure_file task was renamed or deleted.
+
+apply records every file and symlink created by ensure_file, ensure_tree,
+copy_file and symlink tasks in the state directory (settings.state_dir). cleanup
+compares that state with the current jobs and removes the paths no job puts in
+place anymore.
+Files edited since apply wrote them are listed but kept, unless --force is given.
+Directories are never removed.
+
+cleanup asks before removing anything, use --yes to remove without asking and
+--dry-run to only list the paths. Use the same --profile as apply, or the jobs of
+other profiles count as removed. Use --wait to wait for a running apply to finish.
+See also apply --prune.`,
+		Example: `  dotfiles cleanup --dry-run
+  dotfiles cleanup
+  dotfiles cleanup --profile work --yes`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			// Only one run may change the dotfiles at a time. os.Exit skips deferred
+			// calls, fail releases the lock first.
+			var runLock *lock.Lock
+			if !dryRun {
+				if runLock, err = acquireLock(basePath, "cleanup", wait); err != nil {
+					log.Error().Err(err).Msg("Failed to lock the dotfiles")
+					os.Exit(1)
+				}
+			}
+			defer releaseLock(runLock)
+			fail := func() {
+				releaseLock(runLock)
+				os.Exit(1)
+			}
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				fail()
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
+			if err != nil {
+				handleVariableError(err)
+				fail()
+			}
+
+			tasksList, err := jobs.LoadJobsFromFileWithConditions(cfg.GetJobsIndexPath(basePath), variables, cfg.GetProfiles(profiles))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				fail()
+			}
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				fail()
+			}
+
+			ctx := &modules.ExecutionContext{
+				BasePath:     basePath,
+				Variables:    variables,
+				DryRun:       true,
+				Offline:      offline,
+				TemplatesDir: cfg.GetTemplatesPath(basePath),
+			}
+
+			ok, err := cleanupState(basePath, registry, tasksList, ctx, cleanupOptions{DryRun: dryRun, Force: force, Yes: yes})
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to clean up")
+				fail()
+			}
+			if !ok {
+				fail()
+			}
+		},
+	}
+
+	cleanupCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles whose jobs are still managed (default settings.default_profiles)")
+	cleanupCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Only list the paths that would be removed")
+	cleanupCmd.Flags().BoolVar(&force, "force", false, "Also remove paths that were changed since apply put them in place")
+	cleanupCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Remove without asking")
+	cleanupCmd.Flags().DurationVar(&wait, "wait", 0, "Wait up to this long for another run holding the lock to finish (e.g. 5m)")
+	cleanupCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+
+	return cleanupCmd
+}
+
+// cleanupOptions controls how cleanupState removes paths that are no longer managed
+type cleanupOptions struct {
+	DryRun bool // Only list the paths
+	Force  bool // Also remove paths changed since apply put them in place
+	Yes    bool // Remove without asking
+}
+
+// recordState records the files and symlinks put in place by the tasks that ran,
+// keeping what is recorded for every other path
+func recordState(basePath string, registry *modules.ModuleRegistry, tasks []*config.Task, ctx *modules.ExecutionContext) error {
+	st, err := state.Load(basePath)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		paths, err := registry.ManagedPaths(task, ctx)
+		if err != nil {
+			return fmt.Errorf("task '%s': %w", task.ID, err)
+		}
+		for _, path := range paths {
+			if err := st.Record(renderTaskDisplayName(task, ctx.Variables), path); err != nil {
+				return err
+			}
+		}
+	}
+	return st.Save(time.Now())
+}
+
+// managedPaths returns the absolute paths the tasks put in place. A task whose
+// paths cannot be determined is an error, since its paths would look unmanaged.
+func managedPaths(registry *modules.ModuleRegistry, tasks []*config.Task, ctx *modules.ExecutionContext) (map[string]bool, error) {
+	managed := make(map[string]bool)
+	for _, task := range tasks {
+		paths, err := registry.ManagedPaths(task, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot tell which paths task '%s' manages: %w", task.ID, err)
+		}
+		for _, path := range paths {
+			path, err := filepath.Abs(path)
+			if err != nil {
+				return nil, err
+			}
+			managed[path] = true
+		}
+	}
+	return managed, nil
+}
+
+// cleanupState removes the recorded paths that none of the tasks put in place
+// anymore. It returns false when some paths could not be removed.
+func cleanupState(basePath string, registry *modules.ModuleRegistry, tasks []*config.Task, ctx *modules.ExecutionContext, opts cleanupOptions) (bool, error) {
+	st, err := state.Load(basePath)
+	if err != nil {
+		return false, err
+	}
+	managed, err := managedPaths(registry, tasks, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var remove, modified []*state.Entry
+	changed := make(map[string]bool)
+	for _, entry := range st.Orphans(managed) {
+		status, err := entry.Check()
+		if err != nil {
+			return false, err
+		}
+		switch status {
+		case state.StatusGone:
+			st.Forget(entry.Path)
+		case state.StatusModified:
+			modified = append(modified, entry)
+			changed[entry.Path] = true
+			if opts.Force {
+				remove = append(remove, entry)
+			}
+		default:
+			remove = append(remove, entry)
+		}
+	}
+
+	if len(remove) == 0 && len(modified) == 0 {
+		fmt.Printf("✨ No files left behind by removed jobs\n\n")
+		if opts.DryRun {
+			return true, nil
+		}
+		return true, st.Save(time.Now())
+	}
+
+	if len(remove) > 0 {
+		fmt.Printf("🧹 No longer managed by any job:\n")
+		for _, entry := range remove {
+			note := ""
+			if changed[entry.Path] {
+				note = ", changed since apply"
+			}
+			fmt.Printf("   - %s (%s, from %s%s)\n", entry.Path, entry.Kind, entry.Task, note)
+		}
+		fmt.Println()
+	}
+	if !opts.Force && len(modified) > 0 {
+		fmt.Printf("✏️  No longer managed but changed since apply, kept (use --force to remove them):\n")
+		for _, entry := range modified {
+			fmt.Printf("   - %s (%s, from %s)\n", entry.Path, entry.Kind, entry.Task)
+		}
+		fmt.Println()
+	}
+
+	if opts.DryRun {
+		return true, nil
+	}
+	if len(remove) == 0 {
+		return true, st.Save(time.Now())
+	}
+
+	if !opts.Yes {
+		prompt := newTerminalPrompt()
+		if prompt == nil {
+			return false, fmt.Errorf("cannot ask whether to remove %s, run 'dotfiles cleanup --yes' to remove them without asking", pluralize(len(remove), "path"))
+		}
+		answer, err := prompt(fmt.Sprintf("Remove %s?", pluralize(len(remove), "path")), []string{"yes", "no"})
+		if err != nil {
+			return false, err
+		}
+		if answer != "yes" {
+			fmt.Printf("   Nothing was removed\n\n")
+			return true, nil
+		}
+	}
+
+	ok := true
+	for _, entry := range remove {
+		if err := entry.Remove(); err != nil {
+			fmt.Printf("   ❌ Could not remove %s: %v\n", entry.Path, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("   🗑️  Removed %s\n", entry.Path)
+		st.Forget(entry.Path)
+	}
+	fmt.Println()
+	return ok, st.Save(time.Now())
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// createCompletionCommand creates the completion command
+func createCompletionCommand() *cobra.Command {
+	var install bool
+
+	completionCmd := &cobra.Command{
+		Use:         "completion [bash|zsh|fish|powershell]",
+		Short:       "Generate the shell completion script",
+		Annotations: map[string]string{skipSettingsAnnotation: "true"},
+		Long: `Generate the completion script for a shell and write it to stdout.
+
+Besides commands and flags, the script completes the profiles of --profile, the
+tags of --tags and --skip-tags and the variable names of variables get and trace
+from the dotfiles repository in use.
+
+Use --install to write the script to where the shell loads completions of the
+current user from:
+  bash:       ~/.local/share/bash-completion/completions/dotfiles
+  zsh:        ~/.local/share/zsh/site-functions/_dotfiles
+  fish:       ~/.config/fish/completions/dotfiles.fish
+  powershell: Completions/dotfiles.ps1 next to the PowerShell profile`,
+		Example: `  dotfiles completion bash --install
+  source <(dotfiles completion zsh)
+  dotfiles completion fish > ~/.config/fish/completions/dotfiles.fish`,
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+			shell := args[0]
+
+			var script bytes.Buffer
+			if err := generateCompletion(cmd.Root(), shell, &script); err != nil {
+				log.Error().Err(err).Msg("Failed to generate completion script")
+				os.Exit(1)
+			}
+
+			if !install {
+				os.Stdout.Write(script.Bytes())
+				return
+			}
+
+			path, err := completionInstallPath(shell)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to find where to install the completion script")
+				os.Exit(1)
+			}
+			if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+				log.Error().Err(err).Msg("Failed to create completion directory")
+				os.Exit(1)
+			}
+			if err := os.WriteFile(path, script.Bytes(), 0644); err != nil {
+				log.Error().Err(err).Msg("Failed to write completion script")
+				os.Exit(1)
+			}
+
+			fmt.Printf("✅ Installed %s completion to %s\n", shell, path)
+			switch shell {
+			case "zsh":
+				fmt.Printf("   Make sure %s is in your fpath before compinit runs, e.g. in ~/.zshrc:\n", filepath.Dir(path))
+				fmt.Printf("   fpath=(%s $fpath)\n", filepath.Dir(path))
+			case "powershell":
+				fmt.Printf("   Load it from your PowerShell profile ($PROFILE):\n")
+				fmt.Printf("   . %s\n", path)
+			default:
+				fmt.Printf("   Start a new shell to use it\n")
+			}
+		},
+	}
+
+	completionCmd.Flags().BoolVar(&install, "install", false, "Write the script to the completion directory of the current user instead of stdout")
+
+	return completionCmd
+}
+
+// generateCompletion writes the completion script of a shell
+func generateCompletion(rootCmd *cobra.Command, shell string, script *bytes.Buffer) error {
+	switch shell {
+	case "bash":
+		return rootCmd.GenBashCompletionV2(script, true)
+	case "zsh":
+		return rootCmd.GenZshCompletion(script)
+	case "fish":
+		return rootCmd.GenFishCompletion(script, true)
+	case "powershell":
+		return rootCmd.GenPowerShellCompletionWithDesc(script)
+	}
+	return fmt.Errorf("unsupported shell '%s'", shell)
+}
+
+// completionInstallPath returns where a shell loads the completion scripts of the
+// current user from
+func completionInstallPath(shell string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	switch shell {
+	case "bash":
+		return filepath.Join(dataHome, "bash-completion", "completions", "dotfiles"), nil
+	case "zsh":
+		return filepath.Join(dataHome, "zsh", "site-functions", "_dotfiles"), nil
+	case "fish":
+		return filepath.Join(configHome, "fish", "completions", "dotfiles.fish"), nil
+	case "powershell":
+		if runtime.GOOS == "windows" {
+			return filepath.Join(home, "Documents", "PowerShell", "Completions", "dotfiles.ps1"), nil
+		}
+		return filepath.Join(configHome, "powershell", "Completions", "dotfiles.ps1"), nil
+	}
+	return "", fmt.Errorf("unsupported shell '%s'", shell)
+}
+
+// completionContext loads the configuration and variables for completions. Logging
+// is disabled and remote imports are not fetched, so completing stays quiet and quick.
+func completionContext() (*config.Config, string, map[string]interface{}, error) {
+	logger.Disable()
+	config.ConfigureRemoteImports(true)
+
+	configPath, err := findConfigFile()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	basePath := filepath.Dir(configPath)
+
+	vloader, err := config.NewVariableLoader(cfg, basePath)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: true})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return cfg, basePath, variables, nil
+}
+
+// completeProfiles completes the profiles declared in dotfiles.yaml or used by jobs
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cfg, basePath, variables, err := completionContext()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	names := declaredProfiles(cfg)
+	if refs, err := jobs.CollectProfiles(cfg.GetJobsIndexPath(basePath), variables); err == nil {
+		for profile := range refs {
+			names[profile] = true
+		}
+	}
+	return completeListItem(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTags completes the tags used by jobs
+func completeTags(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cfg, basePath, variables, err := completionContext()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	refs, err := jobs.CollectTags(cfg.GetJobsIndexPath(basePath), variables)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := make(map[string]bool)
+	for tag := range refs {
+		names[tag] = true
+	}
+	return completeListItem(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeVariableKeys completes the variable key argument of variables get and trace
+func completeVariableKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	_, _, variables, err := completionContext()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var keys []string
+	for _, key := range variableKeys("", variables) {
+		if strings.HasPrefix(key, toComplete) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, cobra.ShellCompDirectiveNoFileComp
+}
+
+// variableKeys returns the dot notation keys of a variables map and every map in
+// it, in alphabetical order
+func variableKeys(prefix string, variables map[string]interface{}) []string {
+	var keys []string
+	for name, value := range variables {
+		key := prefix + name
+		keys = append(keys, key)
+		if nested, ok := value.(map[string]interface{}); ok {
+			keys = append(keys, variableKeys(key+".", nested)...)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// completeListItem completes the last item of a comma separated flag value, like
+// "work,pe" for --profile
+func completeListItem(names map[string]bool, toComplete string) []string {
+	done := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		done, toComplete = toComplete[:i+1], toComplete[i+1:]
+	}
+
+	var completions []string
+	for name := range names {
+		if strings.HasPrefix(name, toComplete) {
+			completions = append(completions, done+name)
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the diff command, the same as diff(1)
+const (
+	diffExitDifferent = 1 // A target differs from its desired state
+	diffExitTrouble   = 2 // A target is not managed or could not be compared
+)
+
+// managedTarget is a file or symlink put in place by a task
+type managedTarget struct {
+	Task   *config.Task
+	Target *modules.DesiredTarget
+}
+
+// createDiffCommand creates the diff command
+func createDiffCommand() *cobra.Command {
+	var (
+		all         bool
+		reverse     bool
+		profiles    []string
+		diffContext int
+		planExec    bool
+	)
+
+	diffCmd := &cobra.Command{
+		Use:   "diff [path...]",
+		Short: "Compare deployed files with what apply would put in place",
+		Long: `Show a unified diff between a deployed file and the content apply would write
+to it, without planning every job. Each path is looked up in the ensure_file,
+ensure_tree, copy_file and symlink tasks; a directory selects every target below
+it. Binary files are only reported as different.
+
+--reverse shows the diff in the other direction, from the desired content to the
+file on disk, which is what to change in the repository to keep a local edit.
+
+Exits with 0 when every target is up to date, 1 when one differs or is missing and
+2 when a path is not managed by any task or cannot be compared.`,
+		Example: `  dotfiles diff ~/.gitconfig
+  dotfiles diff --reverse ~/.config/nvim
+  dotfiles diff --all`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all && len(args) > 0 {
+				return fmt.Errorf("--all cannot be combined with paths")
+			}
+			if !all && len(args) == 0 {
+				return fmt.Errorf("requires at least one path, or --all")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(diffExitTrouble)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(diffExitTrouble)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(diffExitTrouble)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(diffExitTrouble)
+			}
+
+			tasksList, err := jobs.LoadJobsFromFileWithConditions(cfg.GetJobsIndexPath(basePath), variables, cfg.GetProfiles(profiles))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				os.Exit(diffExitTrouble)
+			}
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				os.Exit(diffExitTrouble)
+			}
+
+			ctx := &modules.ExecutionContext{
+				BasePath:     basePath,
+				Variables:    variables,
+				DryRun:       true,
+				Offline:      offline,
+				PlanExec:     planExec,
+				TemplatesDir: cfg.GetTemplatesPath(basePath),
+			}
+
+			targets := managedTargets(registry, tasksList, ctx)
+			opts := utils.DiffOptions{
+				Context:   diffContext,
+				IntraLine: true,
+				Color:     ui.NewPalette(os.Stdout).Enabled(),
+			}
+
+			var selected []*managedTarget
+			trouble := false
+			if all {
+				selected = targets
+			} else {
+				for _, arg := range args {
+					path, err := utils.ExpandPath(arg)
+					if err == nil {
+						path, err = filepath.Abs(path)
+					}
+					if err != nil {
+						fmt.Printf("%s: %v\n", arg, err)
+						trouble = true
+						continue
+					}
+					matches := targetsAt(targets, path)
+					if len(matches) == 0 {
+						fmt.Printf("%s: not managed by any task\n", path)
+						trouble = true
+					}
+					selected = append(selected, matches...)
+				}
+			}
+
+			different := false
+			for _, managed := range selected {
+				state, err := printTargetDiff(managed, reverse, opts)
+				switch {
+				case err != nil:
+					fmt.Printf("%s: %v\n", managed.Target.Path, err)
+					trouble = true
+				case state != modules.DriftInSync:
+					different = true
+				}
+			}
+
+			switch {
+			case trouble:
+				os.Exit(diffExitTrouble)
+			case different:
+				os.Exit(diffExitDifferent)
+			}
+		},
+	}
+
+	diffCmd.Flags().BoolVar(&all, "all", false, "Compare every file and symlink the jobs put in place")
+	diffCmd.Flags().BoolVarP(&reverse, "reverse", "R", false, "Show the diff from the desired content to the file on disk")
+	diffCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles whose jobs are compared (default settings.default_profiles)")
+	diffCmd.Flags().IntVar(&diffContext, "diff-context", 3, "Unchanged lines shown around each change")
+	diffCmd.Flags().BoolVar(&planExec, "plan-exec", false, "Run content_command of ensure_file tasks to compare their output")
+	diffCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+
+	return diffCmd
+}
+
+// managedTargets returns every file and symlink the tasks put in place. Tasks whose
+// targets cannot be determined are reported and left out.
+func managedTargets(registry *modules.ModuleRegistry, tasksList []*config.Task, ctx *modules.ExecutionContext) []*managedTarget {
+	log := logger.Get()
+
+	var targets []*managedTarget
+	for _, task := range tasksList {
+		desired, err := registry.DesiredTargets(task, ctx)
+		if err != nil {
+			log.Warn().Err(err).Str("source", task.Location()).Msgf("Failed to determine the targets of '%s'", task.ID)
+			continue
+		}
+		for _, target := range desired {
+			// Edits leave the rest of the file alone, there is no content to compare
+			if target.Kind == "edit" {
+				continue
+			}
+			targets = append(targets, &managedTarget{Task: task, Target: target})
+		}
+	}
+	return targets
+}
+
+// targetsAt returns the targets at path, or below it when path is a directory
+func targetsAt(targets []*managedTarget, path string) []*managedTarget {
+	var matches []*managedTarget
+	for _, managed := range targets {
+		rel, err := filepath.Rel(path, filepath.Clean(managed.Target.Path))
+		if err == nil && (rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))) {
+			matches = append(matches, managed)
+		}
+	}
+	return matches
+}
+
+// printTargetDiff prints how a target on disk differs from what its task puts in
+// place and returns its drift state. Nothing is printed for targets that are up to date.
+func printTargetDiff(managed *managedTarget, reverse bool, opts utils.DiffOptions) (modules.DriftState, error) {
+	target := managed.Target
+	desiredLabel := fmt.Sprintf("%s (%s, %s)", target.Path, managed.Task.ID, managed.Task.Location())
+	deployedLabel := fmt.Sprintf("%s (on disk)", target.Path)
+
+	if target.Unknown {
+		return modules.DriftUnknown, fmt.Errorf("desired content is only known at apply time (use --plan-exec to run content_command)")
+	}
+
+	info, err := os.Lstat(target.Path)
+	if os.IsNotExist(err) {
+		fmt.Printf("%s: missing on disk\n", target.Path)
+		return modules.DriftMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var deployed, desired string
+	switch {
+	case target.Kind == "symlink" && info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(target.Path)
+		if err != nil {
+			return "", err
+		}
+		deployed, desired = "-> "+link+"\n", "-> "+target.Content+"\n"
+	case target.Kind == "symlink":
+		fmt.Printf("%s: is a %s on disk, apply replaces it with a symlink to %s\n", target.Path, fileKind(info), target.Content)
+		return modules.DriftOutOfDate, nil
+	case !info.Mode().IsRegular():
+		fmt.Printf("%s: is a %s on disk, apply replaces it with a file\n", target.Path, fileKind(info))
+		return modules.DriftOutOfDate, nil
+	case target.SHA256 != "":
+		// Files copied by copy_file are compared by size and hash, never read whole
+		if info.Size() == target.Size {
+			hash, err := fileSHA256(target.Path)
+			if err != nil {
+				return "", err
+			}
+			if hash == target.SHA256 {
+				return modules.DriftInSync, nil
+			}
+		}
+		oldLabel, newLabel := deployedLabel, desiredLabel
+		if reverse {
+			oldLabel, newLabel = desiredLabel, deployedLabel
+		}
+		fmt.Printf("Files %s and %s differ\n", oldLabel, newLabel)
+		return modules.DriftOutOfDate, nil
+	default:
+		data, err := os.ReadFile(target.Path)
+		if err != nil {
+			return "", err
+		}
+		deployed, desired = string(data), target.Content
+	}
+
+	if deployed == desired {
+		return modules.DriftInSync, nil
+	}
+
+	oldLabel, newLabel, oldContent, newContent := deployedLabel, desiredLabel, deployed, desired
+	if reverse {
+		oldLabel, newLabel, oldContent, newContent = desiredLabel, deployedLabel, desired, deployed
+	}
+	// Fonts, images and binaries copied by copy_file or ensure_tree have no lines
+	if !utf8.ValidString(oldContent) || !utf8.ValidString(newContent) {
+		fmt.Printf("Binary files %s and %s differ\n", oldLabel, newLabel)
+		return modules.DriftOutOfDate, nil
+	}
+	fmt.Printf("--- %s\n", oldLabel)
+	fmt.Printf("+++ %s\n", newLabel)
+	for _, line := range utils.UnifiedDiff(oldContent, newContent, opts) {
+		fmt.Println(line)
+	}
+	return modules.DriftOutOfDate, nil
+}
+
+// fileSHA256 streams the file at path through SHA-256 and returns the hex hash
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileKind describes what kind of file info is
+func fileKind(info os.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	case info.Mode().IsRegular():
+		return "regular file"
+	default:
+		return "special file"
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDiffCommandHelper runs the diff command for TestDiffCommand. It runs in a
+// process of its own, because the command exits with its exit code.
+func TestDiffCommandHelper(t *testing.T) {
+	args := os.Getenv("DOTFILES_TEST_DIFF_ARGS")
+	if args == "" {
+		return
+	}
+	configFile = os.Getenv("DOTFILES_TEST_CONFIG")
+	noCache = true
+	cmd := createDiffCommand()
+	cmd.SetArgs(strings.Split(args, "\n"))
+	if err := cmd.Execute(); err != nil {
+		os.Exit(diffExitTrouble)
+	}
+	os.Exit(0)
+}
+
+// runDiffCommand runs dotfiles diff with args on the repository of configPath and
+// returns what it printed and its exit code
+func runDiffCommand(t *testing.T, configPath string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDiffCommandHelper$")
+	cmd.Env = append(os.Environ(), "DOTFILES_TEST_DIFF_ARGS="+strings.Join(args, "\n"), "DOTFILES_TEST_CONFIG="+configPath, "NO_COLOR=1")
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return string(output), exitErr.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return string(output), 0
+}
+
+func TestDiffCommand(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	if err := initializeRepository(dir, &initOptions{Template: "empty"}); err != nil {
+		t.Fatal(err)
+	}
+	jobsIndex := `ensure_file:
+  - path: "~/.gitconfig"
+    content: "[user]\n\tname = Jane\n"
+  - path: "~/.profile"
+    content: "export EDITOR=vim\n"
+copy_file:
+  - source: "files/logo.png"
+    path: "~/.logo.png"
+`
+	if err := os.WriteFile(filepath.Join(dir, "jobs", "index.yaml"), []byte(jobsIndex), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "dotfiles.yaml")
+	gitconfig := filepath.Join(home, ".gitconfig")
+	profile := filepath.Join(home, ".profile")
+	if err := os.WriteFile(profile, []byte("export EDITOR=vim\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logo := filepath.Join(home, ".logo.png")
+	for _, path := range []string{filepath.Join(dir, "files", "logo.png"), logo} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("\x89PNG\r\n\x1a\n\x00"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		content  string   // Content of ~/.gitconfig, none when empty
+		args     []string // Paths are relative to the home directory
+		want     []string // Lines that must be printed, changed words are marked without colors
+		exitCode int
+	}{
+		{
+			name:     "Missing",
+			args:     []string{".gitconfig"},
+			want:     []string{gitconfig + ": missing on disk"},
+			exitCode: diffExitDifferent,
+		},
+		{
+			name:     "Clean",
+			content:  "[user]\n\tname = Jane\n",
+			args:     []string{".gitconfig", ".profile", ".logo.png"},
+			exitCode: 0,
+		},
+		{
+			name:     "Different",
+			content:  "[user]\n\tname = John\n",
+			args:     []string{".gitconfig"},
+			want:     []string{"--- " + gitconfig + " (on disk)", "+++ " + gitconfig + " (", "-\tname = [-John-]", "+\tname = {+Jane+}"},
+			exitCode: diffExitDifferent,
+		},
+		{
+			name:     "Reverse",
+			content:  "[user]\n\tname = John\n",
+			args:     []string{"--reverse", ".gitconfig"},
+			want:     []string{"--- " + gitconfig + " (", "+++ " + gitconfig + " (on disk)", "-\tname = [-Jane-]", "+\tname = {+John+}"},
+			exitCode: diffExitDifferent,
+		},
+		{
+			name:     "Directory",
+			content:  "[user]\n\tname = John\n",
+			args:     []string{"."},
+			want:     []string{"+\tname = {+Jane+}"},
+			exitCode: diffExitDifferent,
+		},
+		{
+			name:     "NotManaged",
+			content:  "[user]\n\tname = Jane\n",
+			args:     []string{".bashrc", ".gitconfig"},
+			want:     []string{filepath.Join(home, ".bashrc") + ": not managed by any task"},
+			exitCode: diffExitTrouble,
+		},
+		{
+			name:     "All",
+			args:     []string{"--all"},
+			want:     []string{gitconfig + ": missing on disk"},
+			exitCode: diffExitDifferent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(gitconfig)
+			if tt.content != "" {
+				if err := os.WriteFile(gitconfig, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var args []string
+			for _, arg := range tt.args {
+				if !strings.HasPrefix(arg, "-") {
+					arg = filepath.Join(home, arg)
+				}
+				args = append(args, arg)
+			}
+
+			output, exitCode := runDiffCommand(t, configPath, args...)
+			if exitCode != tt.exitCode {
+				t.Errorf("exit code = %d, want %d, output:\n%s", exitCode, tt.exitCode, output)
+			}
+			for _, line := range tt.want {
+				if !strings.Contains(output, line) {
+					t.Errorf("output does not contain %q:\n%s", line, output)
+				}
+			}
+			if tt.exitCode == 0 && output != "" {
+				t.Errorf("printed %q for files that are up to date", output)
+			}
+		})
+	}
+
+	// Copies are compared by size and hash
+	if err := os.WriteFile(logo, []byte("\x89PNG\r\n\x1a\n\x01"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, exitCode := runDiffCommand(t, configPath, logo)
+	if want := "Files " + logo + " (on disk) and " + logo + " ("; exitCode != diffExitDifferent || !strings.Contains(output, want) {
+		t.Errorf("diff of a changed copy = %d, %q, want %d and %q", exitCode, output, diffExitDifferent, want)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+
+	"github.com/spf13/cobra"
+)
+
+// createDoctorCommand creates the doctor command
+func createDoctorCommand() *cobra.Command {
+	var (
+		profiles []string
+		tags     []string
+		skipTags []string
+	)
+
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the system has what apply needs",
+		Long: `Check that the system has everything the configured jobs need before apply
+changes anything:
+- Package managers used by package tasks are installed, respond and can run as root
+- Source files of ensure_file, ensure_tree, copy_file, symlink and install_font tasks exist
+- Directories targets are written to are writable
+- Hosts that downloads and remote imports come from can be reached, unless --offline
+- The state directory is writable
+
+Every check passes, warns or fails, with a hint on how to fix warnings and failures.
+doctor exits with status 1 when a check fails. Use apply --preflight to run the
+checks of the jobs apply runs first and stop before changing anything.`,
+		Example: `  dotfiles doctor
+  dotfiles doctor --profile work
+  dotfiles doctor --tags packages`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			jobsIndexPath := cfg.GetJobsIndexPath(basePath)
+			selection := &taskSelection{Profiles: cfg.GetProfiles(profiles), Tags: tags, SkipTags: skipTags}
+			tasksList, err := jobs.LoadJobsFromFileWithConditions(jobsIndexPath, variables, selection.Profiles)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				os.Exit(1)
+			}
+			tasksList, err = filterTasksByTags(jobsIndexPath, variables, tasksList, selection)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to select tasks by tag")
+				os.Exit(1)
+			}
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				os.Exit(1)
+			}
+
+			runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			ctx := &modules.ExecutionContext{
+				BasePath:        basePath,
+				Variables:       variables,
+				DryRun:          true,
+				Offline:         offline,
+				SudoCommand:     cfg.Settings.SudoCommand,
+				PackageManagers: cfg.Settings.PackageManagers,
+				Context:         runCtx,
+				TemplatesDir:    cfg.GetTemplatesPath(basePath),
+			}
+
+			fmt.Printf("🩺 Checking what the jobs need...\n\n")
+			selection.print()
+			if !runPreflight(basePath, registry, tasksList, ctx) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	doctorCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles whose jobs are checked (default settings.default_profiles)")
+	doctorCmd.Flags().StringSliceVar(&tags, "tags", nil, "Only check tasks with one of these tags")
+	doctorCmd.Flags().StringSliceVar(&skipTags, "skip-tags", nil, "Skip tasks with one of these tags")
+	doctorCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	doctorCmd.RegisterFlagCompletionFunc("tags", completeTags)
+	doctorCmd.RegisterFlagCompletionFunc("skip-tags", completeTags)
+
+	return doctorCmd
+}
+
+// preflightChecks returns the checks the tasks need, followed by the checks of the
+// repository itself: the state directory is writable and remote imports can be
+// updated
+func preflightChecks(basePath string, registry *modules.ModuleRegistry, tasks []*config.Task, ctx *modules.ExecutionContext) ([]*modules.PreflightCheck, error) {
+	var checks []*modules.PreflightCheck
+	for _, task := range tasks {
+		taskChecks, err := registry.PreflightChecks(task, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("task '%s': %w", task.ID, err)
+		}
+		checks = append(checks, taskChecks...)
+	}
+
+	checks = append(checks, modules.WritableDirCheck(config.StateDir(basePath)))
+
+	if !ctx.Offline {
+		imports, err := config.RemoteImportStatuses(basePath, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, remote := range imports {
+			// Imports from local repositories have no host to reach
+			if check, err := modules.NetworkCheck(remote.Repo); err == nil {
+				checks = append(checks, check)
+			}
+		}
+	}
+	return checks, nil
+}
+
+// runPreflight runs the checks the tasks need and prints their results as they
+// come in. It returns false when a check failed.
+func runPreflight(basePath string, registry *modules.ModuleRegistry, tasks []*config.Task, ctx *modules.ExecutionContext) bool {
+	checks, err := preflightChecks(basePath, registry, tasks, ctx)
+	if err != nil {
+		fmt.Printf("   ❌ Failed to determine the checks: %v\n\n", err)
+		return false
+	}
+
+	reports := modules.RunPreflightChecks(ctx.RunContext(), checks, func(report *modules.PreflightReport) {
+		icon := "✅"
+		switch report.Status {
+		case modules.CheckWarn:
+			icon = "⚠️ "
+		case modules.CheckFail:
+			icon = "❌"
+		}
+		fmt.Printf("   %s %s: %s\n", icon, report.Name, report.Message)
+		if report.Status != modules.CheckPass && report.Hint != "" {
+			fmt.Printf("      💡 %s\n", report.Hint)
+		}
+	})
+
+	var warnings, failures int
+	for _, report := range reports {
+		switch report.Status {
+		case modules.CheckWarn:
+			warnings++
+		case modules.CheckFail:
+			failures++
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case len(reports) == 0:
+		fmt.Printf("✨ Nothing to check\n\n")
+	case failures > 0:
+		fmt.Printf("❌ %d of %s failed", failures, pluralize(len(reports), "check"))
+		if warnings > 0 {
+			fmt.Printf(", %s", pluralize(warnings, "warning"))
+		}
+		fmt.Printf("\n\n")
+	case warnings > 0:
+		fmt.Printf("⚠️  All %s passed with %s\n\n", pluralize(len(reports), "check"), pluralize(warnings, "warning"))
+	default:
+		fmt.Printf("✅ All %s passed\n\n", pluralize(len(reports), "check"))
+	}
+	return failures == 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/files"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/symlinks"
+)
+
+// DriftStatus describes how the targets of the configured jobs compare to what
+// apply would produce
+type DriftStatus struct {
+	Checked   bool         // Whether the jobs could be loaded and checked
+	Error     string       // Why the jobs could not be checked
+	InSync    int          // ensure_file targets that match
+	Missing   int          // ensure_file targets that do not exist
+	OutOfDate int          // ensure_file targets with different content
+	Unknown   int          // ensure_file targets that cannot be compared without downloading
+	Failed    int          // ensure_file targets that could not be checked
+	Files     []*FileDrift // Per-target state of ensure_file tasks
+	Symlinks  []*FileDrift // Per-target state of symlink tasks
+}
+
+// FileDrift is the drift state of a single task target
+type FileDrift struct {
+	TaskID string `json:"task_id"`
+	Action string `json:"action"`
+	Source string `json:"source,omitempty"`
+	Path   string `json:"path"`
+	State  string `json:"state"` // a modules.DriftState, or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// getDriftStatus checks the targets of all ensure_file and symlink jobs for drift
+func getDriftStatus(cfg *config.Config, basePath string) *DriftStatus {
+	status := &DriftStatus{Files: []*FileDrift{}, Symlinks: []*FileDrift{}}
+
+	vloader, err := config.NewVariableLoader(cfg, basePath)
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to create variable loader: %v", err)
+		return status
+	}
+	variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to load variables: %v", err)
+		return status
+	}
+
+	tasksList, err := jobs.LoadJobsFromFileWithConditions(cfg.GetJobsIndexPath(basePath), variables, cfg.GetProfiles(nil))
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to load jobs: %v", err)
+		return status
+	}
+
+	registry := modules.NewModuleRegistry()
+	if err := registry.Register(files.New()); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if err := registry.Register(symlinks.New()); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	ctx := &modules.ExecutionContext{
+		BasePath:     basePath,
+		Variables:    variables,
+		DryRun:       true,
+		TemplatesDir: cfg.GetTemplatesPath(basePath),
+	}
+
+	// A task with several targets is checked per target
+	var targets []*config.Task
+	for _, task := range tasksList {
+		if task.Action == "ensure_file" || task.Action == "copy_file" || task.Action == "symlink" {
+			targets = append(targets, registry.SplitTask(task)...)
+		}
+	}
+
+	for _, task := range targets {
+
+		entry := &FileDrift{
+			TaskID: task.ID,
+			Action: task.Action,
+			Source: task.Source,
+		}
+		result, _, err := registry.CheckDrift(task, ctx)
+		if result != nil {
+			entry.Path = result.Path
+			entry.State = string(result.State)
+		}
+		if err != nil {
+			entry.State = "error"
+			entry.Error = err.Error()
+		}
+
+		if task.Action == "symlink" {
+			status.Symlinks = append(status.Symlinks, entry)
+			continue
+		}
+
+		status.Files = append(status.Files, entry)
+		switch modules.DriftState(entry.State) {
+		case modules.DriftInSync:
+			status.InSync++
+		case modules.DriftMissing:
+			status.Missing++
+		case modules.DriftOutOfDate:
+			status.OutOfDate++
+		case modules.DriftUnknown:
+			status.Unknown++
+		default:
+			status.Failed++
+		}
+	}
+
+	status.Checked = true
+	return status
+}
+
+// symlinkCounts returns the number of valid, broken, missing and out of date symlinks
+func (d *DriftStatus) symlinkCounts() (valid, broken, missing, outOfDate int) {
+	for _, link := range d.Symlinks {
+		switch modules.DriftState(link.State) {
+		case modules.DriftInSync:
+			valid++
+		case modules.DriftBroken:
+			broken++
+		case modules.DriftMissing:
+			missing++
+		case modules.DriftOutOfDate:
+			outOfDate++
+		}
+	}
+	return valid, broken, missing, outOfDate
+}
+
+// drifted returns the entries that do not match the configuration
+func (d *DriftStatus) drifted() []*FileDrift {
+	var entries []*FileDrift
+	for _, entry := range append(append([]*FileDrift{}, d.Files...), d.Symlinks...) {
+		if entry.State != string(modules.DriftInSync) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
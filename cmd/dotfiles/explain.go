@@ -10,8 +10,12 @@ import (
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/commands"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/env"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/files"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/fonts"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/services"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/ssh"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/symlinks"
 
 	"github.com/spf13/cobra"
@@ -41,14 +45,30 @@ Examples:
 				log.Error().Err(err).Msg("Failed to register commands module")
 				os.Exit(1)
 			}
+			if err := registry.Register(env.New()); err != nil {
+				log.Error().Err(err).Msg("Failed to register env module")
+				os.Exit(1)
+			}
 			if err := registry.Register(files.New()); err != nil {
 				log.Error().Err(err).Msg("Failed to register files module")
 				os.Exit(1)
 			}
+			if err := registry.Register(fonts.New()); err != nil {
+				log.Error().Err(err).Msg("Failed to register fonts module")
+				os.Exit(1)
+			}
 			if err := registry.Register(packages.New()); err != nil {
 				log.Error().Err(err).Msg("Failed to register packages module")
 				os.Exit(1)
 			}
+			if err := registry.Register(services.New()); err != nil {
+				log.Error().Err(err).Msg("Failed to register services module")
+				os.Exit(1)
+			}
+			if err := registry.Register(ssh.New()); err != nil {
+				log.Error().Err(err).Msg("Failed to register ssh module")
+				os.Exit(1)
+			}
 			if err := registry.Register(symlinks.New()); err != nil {
 				log.Error().Err(err).Msg("Failed to register symlinks module")
 				os.Exit(1)
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// defaultMaxContentSize is how much of the content of a file --include-content
+// inlines by default
+const defaultMaxContentSize = 64 * 1024
+
+// Statuses of the tasks in an export bundle
+const (
+	exportRun      = "run"
+	exportFiltered = "filtered"
+)
+
+// ExportBundle is the effective configuration of this machine: what dotfiles would
+// apply and everything it decided that on
+type ExportBundle struct {
+	Dotfiles  ExportTool             `yaml:"dotfiles"`
+	Platform  map[string]interface{} `yaml:"platform"`
+	User      map[string]interface{} `yaml:"user"`
+	Profiles  []string               `yaml:"profiles"`
+	Variables map[string]interface{} `yaml:"variables"`
+	Tasks     []*ExportTask          `yaml:"tasks"`
+}
+
+// ExportTool is the build of dotfiles that wrote a bundle
+type ExportTool struct {
+	Version string `yaml:"version"`
+	Commit  string `yaml:"commit"`
+	Date    string `yaml:"date"`
+}
+
+// ExportTask is a task of a bundle, with whether it runs on this machine and why not
+type ExportTask struct {
+	ID        string                 `yaml:"id"`
+	Action    string                 `yaml:"action"`
+	Source    string                 `yaml:"source,omitempty"`
+	Condition string                 `yaml:"condition,omitempty"`
+	Profiles  []string               `yaml:"profiles,omitempty"`
+	Tags      []string               `yaml:"tags,omitempty"`
+	Status    string                 `yaml:"status"`
+	Reason    string                 `yaml:"reason,omitempty"`
+	Config    map[string]interface{} `yaml:"config"`
+	Content   []*ExportContent       `yaml:"content,omitempty"`
+
+	task *config.Task
+}
+
+// ExportContent is the rendered content an ensure_file task writes to a path
+type ExportContent struct {
+	Path      string `yaml:"path"`
+	Size      int    `yaml:"size"`
+	Content   string `yaml:"content,omitempty"`
+	Truncated bool   `yaml:"truncated,omitempty"`
+	Binary    bool   `yaml:"binary,omitempty"`
+	Unknown   string `yaml:"unknown,omitempty"` // Why the content is only known at apply time
+}
+
+// createExportCommand creates the export command
+func createExportCommand() *cobra.Command {
+	var (
+		out            string
+		profiles       []string
+		includeContent bool
+		maxContentSize int
+	)
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the effective configuration of this machine as one document",
+		Long: `Export the effective configuration of this machine as one YAML document, to
+debug it or to share it in a bug report. The document contains:
+
+  - the version of dotfiles
+  - the platform and user information
+  - the variables after templates are rendered
+  - every task in job order, with whether it runs on this machine and why not
+
+Values from encrypted (.enc.yaml) variable files and secrets resolved with secret()
+are redacted, environment variables are left out. Use --include-content to also
+inline the rendered content of every ensure_file task, up to --max-content-size
+bytes per file.
+
+The output is the same on every run as long as the configuration and the machine
+do not change, so two bundles can be compared with diff.
+
+Examples:
+  dotfiles export --out bundle.yaml
+  dotfiles export --include-content --profile work`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			// Find and load configuration
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			selected := cfg.GetProfiles(profiles)
+			tasks, err := exportTasks(cfg.GetJobsIndexPath(basePath), variables, selected)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				os.Exit(1)
+			}
+
+			if includeContent {
+				registry, err := newModuleRegistry()
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to register modules")
+					os.Exit(1)
+				}
+				ctx := &modules.ExecutionContext{
+					BasePath:     basePath,
+					Variables:    variables,
+					DryRun:       true,
+					Offline:      true,
+					TemplatesDir: cfg.GetTemplatesPath(basePath),
+				}
+				if err := exportContent(registry, tasks, ctx, maxContentSize); err != nil {
+					log.Error().Err(err).Msg("Failed to render the content of files")
+					os.Exit(1)
+				}
+			}
+
+			bundle := newExportBundle(variables, vloader.GetVariableSources(), selected, tasks)
+			data, err := bundle.encode()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to encode bundle")
+				os.Exit(1)
+			}
+
+			if out == "" {
+				os.Stdout.Write(data)
+				return
+			}
+			path, err := utils.ExpandPath(out)
+			if err != nil {
+				log.Error().Err(err).Str("path", out).Msg("Failed to resolve output path")
+				os.Exit(1)
+			}
+			// Bundles describe the machine, they are only readable by the user
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				log.Error().Err(err).Str("path", path).Msg("Failed to write bundle")
+				os.Exit(1)
+			}
+			fmt.Printf("📦 Exported %d tasks to %s\n", len(tasks), path)
+		},
+	}
+
+	exportCmd.Flags().StringVarP(&out, "out", "o", "", "File to write the bundle to (default standard output)")
+	exportCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles to export, tasks limited to other profiles are filtered (default settings.default_profiles)")
+	exportCmd.Flags().BoolVar(&includeContent, "include-content", false, "Inline the rendered content of every ensure_file task")
+	exportCmd.Flags().IntVar(&maxContentSize, "max-content-size", defaultMaxContentSize, "Bytes of content inlined per file, longer content is truncated")
+	exportCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+
+	return exportCmd
+}
+
+// exportTasks returns every task of the jobs in job order, whatever the conditions
+// and profiles, with whether it runs on this machine with the selected profiles
+func exportTasks(jobsIndexPath string, variables map[string]interface{}, profiles []string) ([]*ExportTask, error) {
+	allTasks, _, err := jobs.LoadAllJobs(jobsIndexPath, variables)
+	if err != nil {
+		return nil, err
+	}
+	runTasks, skippedTasks, _, err := jobs.LoadJobsWithSkipped(jobsIndexPath, variables, profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	run := make(map[string]bool, len(runTasks))
+	for _, task := range runTasks {
+		run[exportTaskKey(task)] = true
+	}
+	skipped := make(map[string]bool, len(skippedTasks))
+	for _, task := range skippedTasks {
+		skipped[exportTaskKey(task)] = true
+	}
+
+	tasks := make([]*ExportTask, 0, len(allTasks))
+	for _, task := range allTasks {
+		exported := &ExportTask{
+			ID:        task.ID,
+			Action:    task.Action,
+			Source:    task.Location(),
+			Condition: task.Condition,
+			Profiles:  task.Profiles,
+			Tags:      task.Tags,
+			Status:    exportFiltered,
+			Config:    task.Config,
+			task:      task,
+		}
+		switch key := exportTaskKey(task); {
+		case run[key]:
+			exported.Status = exportRun
+		case skipped[key]:
+			exported.Reason = conditionSkipped(task).Plan.SkipReason
+		case !jobs.ProfileSelected(task.Profiles, profiles):
+			exported.Reason = fmt.Sprintf("profiles %s are not selected", strings.Join(task.Profiles, ", "))
+		default:
+			exported.Reason = "the import it is in is not loaded, its condition is false or its profiles are not selected"
+		}
+		tasks = append(tasks, exported)
+	}
+	return tasks, nil
+}
+
+// exportTaskKey identifies a task across the loads of the jobs
+func exportTaskKey(task *config.Task) string {
+	return task.Source + ":" + strconv.Itoa(task.Line) + ":" + task.ID
+}
+
+// exportContent inlines the rendered content of the ensure_file tasks that run, up
+// to maxSize bytes per file
+func exportContent(registry *modules.ModuleRegistry, tasks []*ExportTask, ctx *modules.ExecutionContext, maxSize int) error {
+	for _, exported := range tasks {
+		if exported.Action != "ensure_file" || exported.Status != exportRun {
+			continue
+		}
+		targets, err := registry.DesiredTargets(exported.task, ctx)
+		if err != nil {
+			return fmt.Errorf("task '%s': %w", exported.ID, err)
+		}
+		for _, target := range targets {
+			exported.Content = append(exported.Content, newExportContent(target, maxSize))
+		}
+	}
+	return nil
+}
+
+// newExportContent returns the content of a file a task writes, cut to maxSize bytes
+func newExportContent(target *modules.DesiredTarget, maxSize int) *ExportContent {
+	content := &ExportContent{Path: target.Path, Size: len(target.Content)}
+	switch {
+	case target.Unknown:
+		content.Size = 0
+		content.Unknown = "the content comes from a command or download that has not run yet"
+	case !utf8.ValidString(target.Content):
+		content.Binary = true
+	case len(target.Content) > maxSize:
+		cut := maxSize
+		// Cut between characters so the content stays valid text
+		for cut > 0 && !utf8.RuneStart(target.Content[cut]) {
+			cut--
+		}
+		content.Content = target.Content[:cut]
+		content.Truncated = true
+	default:
+		content.Content = target.Content
+	}
+	return content
+}
+
+// newExportBundle returns the bundle of the loaded variables and tasks. Values from
+// encrypted variable files are redacted.
+func newExportBundle(variables map[string]interface{}, sources []*config.VariableSource, profiles []string, tasks []*ExportTask) *ExportBundle {
+	bundle := &ExportBundle{
+		Dotfiles:  ExportTool{Version: version, Commit: commit, Date: date},
+		Profiles:  profiles,
+		Variables: make(map[string]interface{}),
+		Tasks:     tasks,
+	}
+	for _, section := range templateContextSections(variables, true) {
+		switch section.Name {
+		case "Platform":
+			bundle.Platform = section.Values
+		case "User":
+			bundle.User = section.Values
+		case config.VariablesSection:
+			bundle.Variables = section.Values
+		}
+	}
+
+	for _, source := range sources {
+		if source.Encrypted {
+			if value, exists := bundle.Variables[source.Key]; exists {
+				bundle.Variables[source.Key] = redactDefined(value, source.RawValue)
+			}
+		}
+	}
+	return bundle
+}
+
+// redactDefined redacts the parts of value that defined sets, so keys merged in from
+// files that are not encrypted stay readable
+func redactDefined(value, defined interface{}) interface{} {
+	valueMap, isMap := value.(map[string]interface{})
+	definedMap, definesMap := defined.(map[string]interface{})
+	if !isMap || !definesMap {
+		return config.RedactValue(value)
+	}
+
+	redacted := make(map[string]interface{}, len(valueMap))
+	for key, item := range valueMap {
+		redacted[key] = item
+		if definedItem, exists := definedMap[key]; exists {
+			redacted[key] = redactDefined(item, definedItem)
+		}
+	}
+	return redacted
+}
+
+// encode encodes the bundle as YAML. Secrets resolved while loading it are
+// redacted wherever they ended up.
+func (b *ExportBundle) encode() ([]byte, error) {
+	var data bytes.Buffer
+	encoder := yaml.NewEncoder(&data)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(b); err != nil {
+		return nil, fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	return []byte(templating.RedactSecrets(data.String())), nil
+}
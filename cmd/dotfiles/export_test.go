@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestExportTasks(t *testing.T) {
+	jobsDir := filepath.Join(t.TempDir(), "jobs")
+	if err := os.MkdirAll(jobsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"index.yaml": `imports:
+  - path: work.yaml
+    profiles: [work]
+  - path: never.yaml
+    condition: "false"
+run_command:
+  - name: always
+    command: "true"
+  - name: disabled
+    command: "true"
+    condition: "enabled"
+  - name: gaming
+    command: "true"
+    profiles: [gaming]
+`,
+		"work.yaml": `run_command:
+  - name: vpn
+    command: "true"
+`,
+		"never.yaml": `run_command:
+  - name: never
+    command: "true"
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(jobsDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tasks, err := exportTasks(filepath.Join(jobsDir, "index.yaml"), map[string]interface{}{"enabled": false}, []string{"work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every task is listed in job order, whether it runs or not
+	var got []string
+	for _, task := range tasks {
+		got = append(got, task.ID+" "+task.Status)
+	}
+	want := []string{
+		"run_command: vpn run",
+		"run_command: never filtered",
+		"run_command: always run",
+		"run_command: disabled filtered",
+		"run_command: gaming filtered",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tasks = %q, want %q", got, want)
+	}
+
+	reasons := map[string]string{
+		"run_command: never":    "import it is in is not loaded",
+		"run_command: disabled": "condition is false: enabled",
+		"run_command: gaming":   "profiles gaming are not selected",
+	}
+	for _, task := range tasks {
+		if want, filtered := reasons[task.ID]; filtered && !strings.Contains(task.Reason, want) {
+			t.Errorf("reason of %s = %q, want it to contain %q", task.ID, task.Reason, want)
+		}
+	}
+}
+
+func TestExportBundleRedactsEncryptedVariables(t *testing.T) {
+	variables := map[string]interface{}{
+		"Platform": map[string]interface{}{"OS": "linux"},
+		"User":     map[string]interface{}{"Home": "/home/user"},
+		"Env":      map[string]string{"TOKEN": "env secret"},
+		"git":      map[string]interface{}{"name": "User", "token": "encrypted secret"},
+		"api_key":  "encrypted secret",
+	}
+	sources := []*config.VariableSource{
+		{Key: "git", RawValue: map[string]interface{}{"name": "User"}, Source: "variables/global.yaml"},
+		{Key: "git", RawValue: map[string]interface{}{"token": "{{ x }}"}, Source: "variables/secrets.enc.yaml", Encrypted: true},
+		{Key: "api_key", RawValue: "encrypted secret", Source: "variables/secrets.enc.yaml", Encrypted: true},
+	}
+
+	bundle := newExportBundle(variables, sources, nil, nil)
+	data, err := bundle.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("bundle contains a secret:\n%s", data)
+	}
+
+	wantVariables := map[string]interface{}{
+		"git":     map[string]interface{}{"name": "User", "token": config.RedactedValue},
+		"api_key": config.RedactedValue,
+	}
+	if !reflect.DeepEqual(bundle.Variables, wantVariables) {
+		t.Errorf("variables = %v, want %v", bundle.Variables, wantVariables)
+	}
+	if bundle.Platform["OS"] != "linux" || bundle.User["Home"] != "/home/user" {
+		t.Errorf("platform = %v, user = %v", bundle.Platform, bundle.User)
+	}
+}
+
+func TestExportContent(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		target *modules.DesiredTarget
+		want   ExportContent
+	}{
+		{"Short", &modules.DesiredTarget{Path: "a", Content: "hello"}, ExportContent{Path: "a", Size: 5, Content: "hello"}},
+		{"Truncated", &modules.DesiredTarget{Path: "a", Content: "hello world"}, ExportContent{Path: "a", Size: 11, Content: "hello", Truncated: true}},
+		{"BetweenCharacters", &modules.DesiredTarget{Path: "a", Content: "abcd€"}, ExportContent{Path: "a", Size: 7, Content: "abcd", Truncated: true}},
+		{"Binary", &modules.DesiredTarget{Path: "a", Content: "\xff\xfe"}, ExportContent{Path: "a", Size: 2, Binary: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newExportContent(tt.target, 5); *got != tt.want {
+				t.Errorf("content = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	unknown := newExportContent(&modules.DesiredTarget{Path: "a", Unknown: true}, 5)
+	if unknown.Unknown == "" || unknown.Content != "" {
+		t.Errorf("content only known at apply time = %+v", *unknown)
+	}
+}
@@ -35,7 +35,7 @@ Use --force to force pull even if there are uncommitted changes (stashes them fi
 			// Find the dotfiles directory
 			dotfilesDir, err := findDotfilesDirectory()
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to find dotfiles directory")
+				handleConfigFileError(err)
 				os.Exit(1)
 			}
 
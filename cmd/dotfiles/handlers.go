@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// handlerNotifications collects the handlers notified by the tasks that changed
+// something, with the tasks that notified them
+type handlerNotifications map[string][]string
+
+// notify records that a task changed something
+func (n handlerNotifications) notify(task *config.Task, displayName string) {
+	for _, name := range task.Notify {
+		n[name] = append(n[name], displayName)
+	}
+}
+
+// notified returns the notified handlers in the order they are defined, so each
+// runs once however many tasks notified it
+func (n handlerNotifications) notified(handlers []*config.Handler) []*config.Handler {
+	var notified []*config.Handler
+	for _, handler := range handlers {
+		if len(n[handler.Name]) > 0 {
+			notified = append(notified, handler)
+		}
+	}
+	return notified
+}
+
+// printNotifiedHandlers lists the handlers that would run at the end of an apply
+func printNotifiedHandlers(handlers []*config.Handler, notifications handlerNotifications) {
+	fmt.Printf("🔔 Handlers that would run:\n")
+	for _, handler := range handlers {
+		fmt.Printf("   - %s (notified by %s)\n", handler.Name, strings.Join(notifications[handler.Name], ", "))
+	}
+	fmt.Println()
+}
+
+// runHandlers runs the notified handlers one after the other and returns how many
+// failed. A failed handler does not stop the ones after it.
+func runHandlers(registry *modules.ModuleRegistry, handlers []*config.Handler, ctx *modules.ExecutionContext, report *ApplyReport) int {
+	fmt.Printf("🔔 Running %s...\n", pluralize(len(handlers), "handler"))
+
+	failed := 0
+	for _, handler := range handlers {
+		start := time.Now()
+		plan, err := registry.PlanTask(handler.Task, ctx)
+		if err == nil && plan.WillSkip {
+			fmt.Printf("   ⏭️  %s: %s\n", handler.Name, plan.SkipReason)
+			report.addHandler(handler, plan, "skipped", time.Since(start), nil)
+			continue
+		}
+
+		var result *modules.TaskResult
+		if err == nil {
+			result, err = registry.ExecuteTask(handler.Task, ctx)
+		}
+		if err != nil {
+			fmt.Printf("   ❌ %s: %v\n", handler.Name, err)
+			report.addHandler(handler, plan, "failed", time.Since(start), err)
+			failed++
+			continue
+		}
+		if result.Skipped {
+			fmt.Printf("   ⏭️  %s: %s\n", handler.Name, result.Message)
+			report.addHandler(handler, plan, "skipped", time.Since(start), nil)
+			continue
+		}
+		fmt.Printf("   ✅ %s\n", handler.Name)
+		report.addHandler(handler, plan, "success", time.Since(start), nil)
+	}
+	fmt.Println()
+	return failed
+}
@@ -1,46 +1,93 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
 	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 
 	"github.com/spf13/cobra"
 )
 
+// initTemplates are the repositories init can create
+var initTemplates = []string{"minimal", "full", "empty"}
+
+// initPlatforms are the platforms init can scaffold variables for
+var initPlatforms = []string{"linux", "darwin", "windows"}
+
+// emailPlaceholder is the email global.yaml gets when nobody was asked for one
+const emailPlaceholder = "user@example.com"
+
+// initOptions describe the repository init creates
+type initOptions struct {
+	Template        string   // minimal, full or empty
+	Name            string   // Name of the repository, metadata.name
+	Author          string   // metadata.author and user.name
+	Email           string   // user.email, a placeholder when empty
+	Platforms       []string // Platforms the full template has variables for
+	SampleTemplates bool     // Whether the full template has the sample files and their jobs
+	Git             bool     // Run git init and commit the repository
+}
+
 // createInitCommand creates the init command
 func createInitCommand() *cobra.Command {
 	var (
 		targetDir string
 		force     bool
+		yes       bool
+		opts      = initOptions{Platforms: initPlatforms, SampleTemplates: true}
 	)
 
 	initCmd := &cobra.Command{
-		Use:   "init [directory]",
-		Short: "Initialize a new dotfiles repository",
+		Use:         "init [directory]",
+		Short:       "Initialize a new dotfiles repository",
+		Annotations: map[string]string{skipSettingsAnnotation: "true"},
 		Long: `Initialize a new dotfiles repository with sample configuration files.
 
-Creates the following structure:
+--template full (the default) creates the following structure:
   dotfiles.yaml        - Main configuration file
   variables/
     index.yaml         - Variables entry point
     global.yaml        - Global variables
     platforms/         - Platform-specific variables
-  templates/
-    index.yaml         - Templates entry point
-    shell/             - Shell configuration templates
-    git/               - Git configuration templates
-  files/               - Static files (no templating)
+    hosts/             - Per-host overrides (hosts/<hostname>.yaml)
+  jobs/
+    index.yaml         - Jobs entry point
+  files/
+    templates/         - Sample templates (shell, git, ssh, editors)
+    configs/           - Static files (no templating)
+  scripts/             - Setup scripts
+
+--template minimal only creates dotfiles.yaml, variables/index.yaml,
+variables/global.yaml and an empty jobs/index.yaml. --template empty creates
+dotfiles.yaml and empty index files.
+
+In a terminal init asks for the name of the repository, your name and email, the
+platforms to scaffold and whether to include the sample templates. Use --yes to
+create the repository without asking. Use --git to run git init and commit it.
 
 If no directory is specified, initializes in the current directory.`,
+		Example: `  dotfiles init ~/dotfiles
+  dotfiles init --template minimal --git
+  dotfiles init --template full --yes`,
 		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 
+			if !slices.Contains(initTemplates, opts.Template) {
+				log.Error().Msgf("Invalid --template '%s', expected one of %s", opts.Template, strings.Join(initTemplates, ", "))
+				os.Exit(1)
+			}
+
 			// Determine target directory
 			if len(args) > 0 {
 				targetDir = args[0]
@@ -68,14 +115,29 @@ If no directory is specified, initializes in the current directory.`,
 				os.Exit(1)
 			}
 
+			if !yes && ui.IsTerminal(os.Stdin) {
+				if err := askInitOptions(bufio.NewReader(os.Stdin), &opts); err != nil {
+					log.Error().Err(err).Msg("Failed to initialize dotfiles repository")
+					os.Exit(1)
+				}
+			}
+
 			// Initialize repository
-			if err := initializeRepository(expandedDir); err != nil {
+			if err := initializeRepository(expandedDir, &opts); err != nil {
 				log.Error().Err(err).Msg("Failed to initialize dotfiles repository")
 				os.Exit(1)
 			}
 
+			if opts.Git {
+				if err := commitRepository(expandedDir, &opts); err != nil {
+					log.Error().Err(err).Msg("Failed to create the git repository")
+					os.Exit(1)
+				}
+			}
+
 			log.Info().
 				Str("directory", expandedDir).
+				Str("template", opts.Template).
 				Msg("Successfully initialized dotfiles repository")
 
 			// Show next steps
@@ -85,12 +147,105 @@ If no directory is specified, initializes in the current directory.`,
 
 	initCmd.Flags().StringVarP(&targetDir, "directory", "d", "", "Target directory for initialization")
 	initCmd.Flags().BoolVar(&force, "force", false, "Force initialization even if directory is not empty")
+	initCmd.Flags().StringVar(&opts.Template, "template", "full", "Repository to create (minimal, full, empty)")
+	initCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Create the repository without asking questions")
+	initCmd.Flags().BoolVar(&opts.Git, "git", false, "Run git init and commit the new repository")
 
 	return initCmd
 }
 
-// initializeRepository creates the complete dotfiles repository structure
-func initializeRepository(targetDir string) error {
+// askInitOptions asks for the name, author and email of the repository and, for
+// the full template, which platforms to scaffold and whether to add the samples
+func askInitOptions(reader *bufio.Reader, opts *initOptions) error {
+	var err error
+	if opts.Name, err = askInitValue(reader, "Name of the dotfiles repository", "My Dotfiles"); err != nil {
+		return err
+	}
+	if opts.Author, err = askInitValue(reader, "Your name", defaultAuthor()); err != nil {
+		return err
+	}
+	if opts.Email, err = askInitValue(reader, "Your email", ""); err != nil {
+		return err
+	}
+	if opts.Template != "full" {
+		return nil
+	}
+
+	for {
+		answer, err := askInitValue(reader, "Platforms to scaffold", strings.Join(opts.Platforms, ","))
+		if err != nil {
+			return err
+		}
+		platforms, err := parseInitPlatforms(answer)
+		if err == nil {
+			opts.Platforms = platforms
+			break
+		}
+		fmt.Printf("   %v\n", err)
+	}
+
+	for {
+		answer, err := askInitValue(reader, "Include the sample templates? (yes/no)", "yes")
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			opts.SampleTemplates = true
+			return nil
+		case "n", "no":
+			opts.SampleTemplates = false
+			return nil
+		}
+		fmt.Printf("   Please answer yes or no\n")
+	}
+}
+
+// askInitValue asks a question, an empty answer takes the default shown with it
+func askInitValue(reader *bufio.Reader, question, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Printf("   %s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Printf("   %s: ", question)
+	}
+
+	answer, err := reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("no answer given: %w", err)
+	}
+	if answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+// parseInitPlatforms parses a comma separated list of platforms
+func parseInitPlatforms(answer string) ([]string, error) {
+	var platforms []string
+	for _, platform := range strings.Split(answer, ",") {
+		platform = strings.ToLower(strings.TrimSpace(platform))
+		if platform == "" {
+			continue
+		}
+		if platform == "macos" {
+			platform = "darwin"
+		}
+		if !slices.Contains(initPlatforms, platform) {
+			return nil, fmt.Errorf("unknown platform '%s', expected %s", platform, strings.Join(initPlatforms, ", "))
+		}
+		if !slices.Contains(platforms, platform) {
+			platforms = append(platforms, platform)
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platforms given, expected %s", strings.Join(initPlatforms, ", "))
+	}
+	return platforms, nil
+}
+
+// initializeRepository creates the dotfiles repository structure of a template
+func initializeRepository(targetDir string, opts *initOptions) error {
 	log := logger.Get()
 
 	// Create base directory
@@ -99,16 +254,18 @@ func initializeRepository(targetDir string) error {
 	}
 
 	// Create directory structure
-	dirs := []string{
-		"variables",
-		"variables/platforms",
-		"variables/environments",
-		"jobs",
-		"files",
-		"files/templates",
-		"files/configs",
-		"files/bin",
-		"scripts",
+	dirs := []string{"variables", "jobs"}
+	if opts.Template == "full" {
+		dirs = append(dirs,
+			"variables/platforms",
+			"variables/environments",
+			"variables/hosts",
+			"files",
+			"files/templates",
+			"files/configs",
+			"files/bin",
+			"scripts",
+		)
 	}
 
 	for _, dir := range dirs {
@@ -120,28 +277,44 @@ func initializeRepository(targetDir string) error {
 	}
 
 	// Create configuration files
-	if err := createMainConfig(targetDir); err != nil {
+	if err := createMainConfig(targetDir, opts); err != nil {
 		return fmt.Errorf("failed to create main configuration: %w", err)
 	}
 
-	if err := createVariablesIndex(targetDir); err != nil {
+	if opts.Template == "empty" {
+		if err := createEmptyIndexes(targetDir); err != nil {
+			return fmt.Errorf("failed to create index files: %w", err)
+		}
+		return nil
+	}
+
+	if err := createVariablesIndex(targetDir, opts); err != nil {
 		return fmt.Errorf("failed to create variables index: %w", err)
 	}
 
-	if err := createGlobalVariables(targetDir); err != nil {
+	if err := createGlobalVariables(targetDir, opts); err != nil {
 		return fmt.Errorf("failed to create global variables: %w", err)
 	}
 
-	if err := createPlatformVariables(targetDir); err != nil {
+	if opts.Template == "minimal" {
+		if err := os.WriteFile(filepath.Join(targetDir, "jobs", "index.yaml"), []byte(emptyJobsIndex), 0644); err != nil {
+			return fmt.Errorf("failed to create jobs index: %w", err)
+		}
+		return nil
+	}
+
+	if err := createPlatformVariables(targetDir, opts.Platforms); err != nil {
 		return fmt.Errorf("failed to create platform variables: %w", err)
 	}
 
-	if err := createJobsIndex(targetDir); err != nil {
+	if err := createJobsIndex(targetDir, opts.SampleTemplates); err != nil {
 		return fmt.Errorf("failed to create jobs index: %w", err)
 	}
 
-	if err := createSampleFiles(targetDir); err != nil {
-		return fmt.Errorf("failed to create sample files: %w", err)
+	if opts.SampleTemplates {
+		if err := createSampleFiles(targetDir); err != nil {
+			return fmt.Errorf("failed to create sample files: %w", err)
+		}
 	}
 
 	if err := createSampleScripts(targetDir); err != nil {
@@ -159,19 +332,65 @@ func initializeRepository(targetDir string) error {
 	return nil
 }
 
+// commitRepository runs git init in a new repository and commits everything in it.
+// Without a git identity the commit is made with the name and email given to init.
+func commitRepository(targetDir string, opts *initOptions) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git is not installed")
+	}
+
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "--all"},
+		{"commit", "--quiet", "-m", "Initialize dotfiles repository"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = targetDir
+		if args[0] == "commit" && opts.Author != "" && opts.Email != "" && !hasGitIdentity(targetDir) {
+			cmd.Env = append(os.Environ(),
+				"GIT_AUTHOR_NAME="+opts.Author, "GIT_AUTHOR_EMAIL="+opts.Email,
+				"GIT_COMMITTER_NAME="+opts.Author, "GIT_COMMITTER_EMAIL="+opts.Email)
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// hasGitIdentity reports whether git knows the email to commit with in a directory
+func hasGitIdentity(dir string) bool {
+	cmd := exec.Command("git", "config", "user.email")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(output)) != ""
+}
+
+// defaultAuthor returns the name of the user running init
+func defaultAuthor() string {
+	author := os.Getenv("USER")
+	if author == "" {
+		author = os.Getenv("USERNAME") // Windows
+	}
+	if author == "" {
+		author = "User"
+	}
+	return author
+}
+
 // createMainConfig creates the main dotfiles.yaml configuration file
-func createMainConfig(targetDir string) error {
+func createMainConfig(targetDir string, opts *initOptions) error {
 	config := config.DefaultConfig()
 
 	// Customize metadata
 	config.Metadata.Name = "My Dotfiles"
-	config.Metadata.Version = "1.0.0"
-	config.Metadata.Author = os.Getenv("USER")
-	if config.Metadata.Author == "" {
-		config.Metadata.Author = os.Getenv("USERNAME") // Windows
+	if opts.Name != "" {
+		config.Metadata.Name = opts.Name
 	}
+	config.Metadata.Version = "1.0.0"
+	config.Metadata.Author = opts.Author
 	if config.Metadata.Author == "" {
-		config.Metadata.Author = "User"
+		config.Metadata.Author = defaultAuthor()
 	}
 	config.Metadata.Description = "Personal dotfiles configuration"
 
@@ -179,18 +398,23 @@ func createMainConfig(targetDir string) error {
 	return config.Save(configPath)
 }
 
-// createVariablesIndex creates the variables/index.yaml file
-func createVariablesIndex(targetDir string) error {
+// createVariablesIndex creates the variables/index.yaml file, the minimal template
+// only imports global.yaml
+func createVariablesIndex(targetDir string, opts *initOptions) error {
 	content := `# Variables Index
 # This file defines which variable files to load and in what order
-# Variables are merged with later files overriding earlier ones
+# Variables are merged by precedence tier: global < platform < environment < host.
+# Files in a higher tier override lower ones, differing values within the
+# same tier are reported as a conflict.
 
 imports:
   - path: "global.yaml"
-  - path: "platforms/{{ .Platform.OS }}.yaml"
-    condition: "ne .Platform.OS \"\""
-  - path: "environments/{{ .Env.DOTFILES_ENV }}.yaml"
-    condition: "ne .Env.DOTFILES_ENV \"\""
+  - path: "platforms/{{ Platform.OS }}.yaml"
+    condition: "Platform.OS != \"\""
+  - path: "environments/{{ Env.DOTFILES_ENV|default:\"\" }}.yaml"
+    condition: "Env.DOTFILES_ENV != \"\""
+  - path: "hosts/{{ Platform.Hostname }}.yaml"
+    condition: "Platform.Hostname != \"\"" # Skipped when this host has no file
 
 # Direct variables can also be defined here
 variables:
@@ -198,20 +422,43 @@ variables:
     version: "1.0.0"
     initialized: true
 `
+	if opts.Template == "minimal" {
+		content = `# Variables Index
+# This file defines which variable files to load and in what order
+
+imports:
+  - path: "global.yaml"
+`
+	}
 
 	indexPath := filepath.Join(targetDir, "variables", "index.yaml")
 	return os.WriteFile(indexPath, []byte(content), 0644)
 }
 
-// createGlobalVariables creates the variables/global.yaml file
-func createGlobalVariables(targetDir string) error {
-	content := `# Global Variables
+// createGlobalVariables creates the variables/global.yaml file with the name and
+// email given to init, the minimal template only has the user
+func createGlobalVariables(targetDir string, opts *initOptions) error {
+	name := strconv.Quote(defaultAuthor())
+	if opts.Author != "" {
+		name = strconv.Quote(opts.Author)
+	}
+	email := strconv.Quote(emailPlaceholder)
+	if opts.Email != "" {
+		email = strconv.Quote(opts.Email)
+	}
+
+	user := `# Global Variables
 # These variables are available across all platforms and templates
 
 user:
-  name: "{{ .Env.USER }}{{ .Env.USERNAME }}"
-  email: "user@example.com"
-  github: "username"
+  name: ` + name + `
+  email: ` + email + `
+`
+	if opts.Template == "minimal" {
+		return os.WriteFile(filepath.Join(targetDir, "variables", "global.yaml"), []byte(user), 0644)
+	}
+
+	content := user + `  github: "username"
 
 editor:
   default: "code"
@@ -234,63 +481,64 @@ colors:
   accent: "blue"
 
 directories:
-  projects: "{{ pathJoin .User.Home \"Projects\" }}"
-  downloads: "{{ pathJoin .User.Home \"Downloads\" }}"
-  documents: "{{ pathJoin .User.Home \"Documents\" }}"
+  projects: "{{ pathJoin(User.Home, \"Projects\") }}"
+  downloads: "{{ pathJoin(User.Home, \"Downloads\") }}"
+  documents: "{{ pathJoin(User.Home, \"Documents\") }}"
 `
 
 	globalPath := filepath.Join(targetDir, "variables", "global.yaml")
 	return os.WriteFile(globalPath, []byte(content), 0644)
 }
 
-// createPlatformVariables creates platform-specific variable files
-func createPlatformVariables(targetDir string) error {
+// createPlatformVariables creates the variable files of platforms
+func createPlatformVariables(targetDir string, selected []string) error {
 	platforms := map[string]string{
 		"windows": `# Windows-specific variables
 
 paths:
-  home: "{{ .Env.USERPROFILE }}"
-  config: "{{ .Env.APPDATA }}"
+  home: "{{ Env.USERPROFILE }}"
+  config: "{{ Env.APPDATA }}"
 
 shell:
   type: "powershell"
-  profile: "{{ pathJoin .Env.USERPROFILE \"Documents\" \"PowerShell\" \"profile.ps1\" }}"
+  profile: "{{ pathJoin(Env.USERPROFILE, \"Documents\", \"PowerShell\", \"profile.ps1\") }}"
 
 editor:
-  vscode_settings: "{{ pathJoin .Env.APPDATA \"Code\" \"User\" \"settings.json\" }}"
+  vscode_settings: "{{ pathJoin(Env.APPDATA, \"Code\", \"User\", \"settings.json\") }}"
 `,
 		"linux": `# Linux-specific variables
 
 paths:
-  home: "{{ .Env.HOME }}"
-  config: "{{ pathJoin .Env.HOME \".config\" }}"
+  home: "{{ Env.HOME }}"
+  config: "{{ pathJoin(Env.HOME, \".config\") }}"
 
 shell:
   type: "bash"
-  profile: "{{ pathJoin .Env.HOME \".bashrc\" }}"
+  profile: "{{ pathJoin(Env.HOME, \".bashrc\") }}"
 
 editor:
-  vscode_settings: "{{ pathJoin .Env.HOME \".config\" \"Code\" \"User\" \"settings.json\" }}"
+  vscode_settings: "{{ pathJoin(Env.HOME, \".config\", \"Code\", \"User\", \"settings.json\") }}"
 `,
 		"darwin": `# macOS-specific variables
 
 paths:
-  home: "{{ .Env.HOME }}"
-  config: "{{ pathJoin .Env.HOME \".config\" }}"
+  home: "{{ Env.HOME }}"
+  config: "{{ pathJoin(Env.HOME, \".config\") }}"
 
 shell:
   type: "zsh"
-  profile: "{{ pathJoin .Env.HOME \".zshrc\" }}"
+  profile: "{{ pathJoin(Env.HOME, \".zshrc\") }}"
 
 package_managers:
   - "brew"
 
 editor:
-  vscode_settings: "{{ pathJoin .Env.HOME \"Library\" \"Application Support\" \"Code\" \"User\" \"settings.json\" }}"
+  vscode_settings: "{{ pathJoin(Env.HOME, \"Library\", \"Application Support\", \"Code\", \"User\", \"settings.json\") }}"
 `,
 	}
 
-	for platform, content := range platforms {
+	for _, platform := range selected {
+		content := platforms[platform]
 		platformPath := filepath.Join(targetDir, "variables", "platforms", platform+".yaml")
 		if err := os.WriteFile(platformPath, []byte(content), 0644); err != nil {
 			return err
@@ -300,84 +548,115 @@ editor:
 	return nil
 }
 
-// createJobsIndex creates the jobs/index.yaml file
-func createJobsIndex(targetDir string) error {
+// createJobsIndex creates the jobs/index.yaml file, with the jobs deploying the
+// sample files when they are created
+func createJobsIndex(targetDir string, samples bool) error {
 	content := `# Jobs Configuration
 # Define what operations to perform for your dotfiles setup
 
 # Ensure directories exist
 ensure_dir:
-  - "{{ .paths.home }}/.ssh"
-  - path: "{{ .paths.config }}/git"
+  - "{{ paths.home }}/.ssh"
+  - path: "{{ paths.config }}/git"
     mode: "0755"
-
+`
+	if samples {
+		content += `
 # Process and deploy templates using ensure_file
 ensure_file:
-  - path: "{{ .paths.home }}/.gitconfig"
+  - path: "{{ paths.home }}/.gitconfig"
     content_source: "files/templates/git/config.tmpl"
     render: true
-  - path: "{{ .paths.home }}/.ssh/config"
+  - path: "{{ paths.home }}/.ssh/config"
     content_source: "files/templates/ssh/config.tmpl"
     render: true
     mode: "0600"
-    condition: "ne .Platform.OS \"windows\""
-  - path: "{{ .paths.home }}/.bashrc"
+    condition: "Platform.OS != \"windows\""
+  - path: "{{ paths.home }}/.bashrc"
     content_source: "files/templates/shell/bashrc.tmpl"
     render: true
-    condition: "eq .Platform.OS \"linux\""
-  - path: "{{ .paths.home }}/.zshrc"
+    condition: "Platform.OS == \"linux\""
+  - path: "{{ paths.home }}/.zshrc"
     content_source: "files/templates/shell/zshrc.tmpl"
     render: true
-    condition: "eq .Platform.OS \"darwin\""
-  - path: "{{ pathJoin .paths.home \"Documents\" \"PowerShell\" \"profile.ps1\" }}"
+    condition: "Platform.OS == \"darwin\""
+  - path: "{{ pathJoin(paths.home, \"Documents\", \"PowerShell\", \"profile.ps1\") }}"
     content_source: "files/templates/shell/profile.ps1.tmpl"
     render: true
-    condition: "eq .Platform.OS \"windows\""
-  - path: "{{ .paths.config }}/code/settings.json"
+    condition: "Platform.OS == \"windows\""
+  - path: "{{ paths.config }}/code/settings.json"
     content_source: "files/templates/vscode/settings.json.tmpl"
     render: true
-    condition: "eq .Env.INSTALL_VSCODE \"true\""
+    condition: "Env.INSTALL_VSCODE == \"true\""
   # Copy static configuration files without rendering
-  - path: "{{ .paths.home }}/.vimrc"
+  - path: "{{ paths.home }}/.vimrc"
     content_source: "files/configs/.vimrc"
     render: false
-  - path: "{{ .paths.home }}/.editorconfig"
+  - path: "{{ paths.home }}/.editorconfig"
     content_source: "files/configs/.editorconfig"
     render: false
 
 # Create symlinks for files that should be linked rather than copied
 symlink:
   - src: "files/bin/custom-script.sh"
-    dst: "{{ .paths.home }}/bin/custom-script"
-    condition: "ne .Platform.OS \"windows\""
+    dst: "{{ paths.home }}/bin/custom-script"
+    condition: "Platform.OS != \"windows\""
   - src: "files/configs/tmux.conf"
-    dst: "{{ .paths.home }}/.tmux.conf"
-    condition: "ne .Platform.OS \"windows\""
-
+    dst: "{{ paths.home }}/.tmux.conf"
+    condition: "Platform.OS != \"windows\""
+`
+	}
+	content += `
 # Install packages
-install:
-  - ["git", "vim", "curl"]
-  - packages: ["code"]
-    condition: "eq .Env.INSTALL_VSCODE \"true\""
+install_package:
+  - git
+  - vim
+  - curl
+  - name: code
+    condition: "Env.INSTALL_VSCODE == \"true\""
 `
 
 	indexPath := filepath.Join(targetDir, "jobs", "index.yaml")
 	return os.WriteFile(indexPath, []byte(content), 0644)
 }
 
+// emptyJobsIndex is the jobs/index.yaml of the minimal and empty templates
+const emptyJobsIndex = `# Jobs Configuration
+# Define what operations to perform for your dotfiles setup, e.g.
+#
+# ensure_file:
+#   - path: "~/.gitconfig"
+#     content_source: "files/gitconfig.tmpl"
+#     render: true
+`
+
+// createEmptyIndexes creates the variables and jobs index files of the empty template
+func createEmptyIndexes(targetDir string) error {
+	variables := `# Variables Index
+# This file defines which variable files to load and in what order, e.g.
+#
+# imports:
+#   - path: "global.yaml"
+`
+	if err := os.WriteFile(filepath.Join(targetDir, "variables", "index.yaml"), []byte(variables), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(targetDir, "jobs", "index.yaml"), []byte(emptyJobsIndex), 0644)
+}
+
 // createSampleFiles creates sample template and config files
 func createSampleFiles(targetDir string) error {
 	templates := map[string]string{
 		"files/templates/git/config.tmpl": `[user]
-    name = {{ .user.name }}
-    email = {{ .user.email }}
+    name = {{ user.name }}
+    email = {{ user.email }}
 
 [init]
-    defaultBranch = {{ .git.default_branch }}
+    defaultBranch = {{ git.default_branch }}
 
 [core]
-    editor = {{ .editor.default }}
-    autocrlf = {{ if eq .Platform.OS "windows" }}true{{ else }}input{{ end }}
+    editor = {{ editor.default }}
+    autocrlf = {% if Platform.OS == "windows" %}true{% else %}input{% endif %}
 
 [push]
     default = simple
@@ -393,50 +672,50 @@ func createSampleFiles(targetDir string) error {
     df = diff
     lg = log --oneline --graph --decorate --all
 `,
-		"files/templates/ssh/config.tmpl": `# SSH Configuration for {{ .user.name }}
+		"files/templates/ssh/config.tmpl": `# SSH Configuration for {{ user.name }}
 # Generated by dotfiles manager
 
 Host github.com
     HostName github.com
     User git
     Port 22
-    IdentityFile {{ .paths.home }}/.ssh/id_{{ .ssh.key_type | default "ed25519" }}
+    IdentityFile {{ paths.home }}/.ssh/id_{{ ssh.key_type|default:"ed25519" }}
 
 Host *.example.com
-    User {{ .user.name }}
+    User {{ user.name }}
     Port 22
     ForwardAgent yes
 `,
-		"files/templates/shell/bashrc.tmpl": `# {{ .user.name }}'s Bash Configuration
+		"files/templates/shell/bashrc.tmpl": `# {{ user.name }}'s Bash Configuration
 # Generated by dotfiles manager
 
 # Aliases
-{{ range $alias, $command := .shell.aliases }}alias {{ $alias }}="{{ $command }}"
-{{ end }}
+{% for alias, command in shell.aliases sorted %}alias {{ alias }}="{{ command }}"
+{% endfor %}
 
 # Environment
-export EDITOR="{{ .editor.default }}"
-export PROJECTS_DIR="{{ .directories.projects }}"
+export EDITOR="{{ editor.default }}"
+export PROJECTS_DIR="{{ directories.projects }}"
 
 # Prompt
 PS1='\[\033[01;32m\]\u@\h\[\033[00m\]:\[\033[01;34m\]\w\[\033[00m\]\$ '
 
 # Platform-specific settings
-{{ if eq .Platform.OS "linux" }}
+{% if Platform.OS == "linux" %}
 # Linux-specific bash settings
 export PATH="$PATH:/usr/local/bin"
-{{ end }}
+{% endif %}
 `,
-		"files/templates/shell/zshrc.tmpl": `# {{ .user.name }}'s Zsh Configuration
+		"files/templates/shell/zshrc.tmpl": `# {{ user.name }}'s Zsh Configuration
 # Generated by dotfiles manager
 
 # Aliases
-{{ range $alias, $command := .shell.aliases }}alias {{ $alias }}="{{ $command }}"
-{{ end }}
+{% for alias, command in shell.aliases sorted %}alias {{ alias }}="{{ command }}"
+{% endfor %}
 
 # Environment
-export EDITOR="{{ .editor.default }}"
-export PROJECTS_DIR="{{ .directories.projects }}"
+export EDITOR="{{ editor.default }}"
+export PROJECTS_DIR="{{ directories.projects }}"
 
 # Oh My Zsh (if installed)
 if [[ -d "$HOME/.oh-my-zsh" ]]; then
@@ -446,16 +725,16 @@ if [[ -d "$HOME/.oh-my-zsh" ]]; then
     source $ZSH/oh-my-zsh.sh
 fi
 `,
-		"files/templates/shell/profile.ps1.tmpl": `# {{ .user.name }}'s PowerShell Profile
+		"files/templates/shell/profile.ps1.tmpl": `# {{ user.name }}'s PowerShell Profile
 # Generated by dotfiles manager
 
 # Aliases
-{{ range $alias, $command := .shell.aliases }}Set-Alias {{ $alias }} "{{ $command }}"
-{{ end }}
+{% for alias, command in shell.aliases sorted %}Set-Alias {{ alias }} "{{ command }}"
+{% endfor %}
 
 # Environment
-$env:EDITOR = "{{ .editor.default }}"
-$env:PROJECTS_DIR = "{{ .directories.projects }}"
+$env:EDITOR = "{{ editor.default }}"
+$env:PROJECTS_DIR = "{{ directories.projects }}"
 
 # Functions
 function Get-GitStatus { git status $args }
@@ -470,7 +749,7 @@ function prompt {
     return " "
 }
 `,
-		"files/templates/editors/vimrc.tmpl": `" {{ .user.name }}'s Vim Configuration
+		"files/templates/editors/vimrc.tmpl": `" {{ user.name }}'s Vim Configuration
 " Generated by dotfiles manager
 
 set number
@@ -487,7 +766,7 @@ set smartcase
 
 " Color scheme
 syntax on
-set background={{ .colors.theme }}
+set background={{ colors.theme }}
 
 " Leader key
 let mapleader = ","
@@ -504,8 +783,8 @@ nnoremap <leader>wq :wq<CR>
     "editor.tabSize": 4,
     "editor.insertSpaces": true,
     "editor.rulers": [80, 120],
-    "workbench.colorTheme": "{{ if eq .colors.theme \"dark\" }}Dark+ (default dark){{ else }}Default Light+{{ end }}",
-    "terminal.integrated.shell.{{ if eq .Platform.OS \"windows\" }}windows{{ else }}linux{{ end }}": "{{ if eq .Platform.OS \"windows\" }}powershell.exe{{ else }}/bin/bash{{ end }}",
+    "workbench.colorTheme": "{% if colors.theme == "dark" %}Dark+ (default dark){% else %}Default Light+{% endif %}",
+    "terminal.integrated.shell.{% if Platform.OS == "windows" %}windows{% else %}linux{% endif %}": "{% if Platform.OS == "windows" %}powershell.exe{% else %}/bin/bash{% endif %}",
     "git.enableSmartCommit": true,
     "git.confirmSync": false,
     "files.autoSave": "onFocusChange"
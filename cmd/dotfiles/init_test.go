@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isolateGit makes git use an empty global configuration until the test ends, so
+// the identity and settings of the developer running it are not used. It skips the
+// test when git is not installed.
+func isolateGit(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	globalConfig := filepath.Join(t.TempDir(), "gitconfig")
+	if err := os.WriteFile(globalConfig, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", globalConfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL", "EMAIL"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	return globalConfig
+}
+
+// runGit runs git in dir and returns its trimmed output, failing the test when it fails
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestParseInitPlatforms(t *testing.T) {
+	tests := []struct {
+		answer  string
+		want    []string
+		wantErr string
+	}{
+		{answer: "linux", want: []string{"linux"}},
+		{answer: " Linux , macOS,windows ", want: []string{"linux", "darwin", "windows"}},
+		{answer: "darwin,macos,linux,,", want: []string{"darwin", "linux"}},
+		{answer: "linux,freebsd", wantErr: "unknown platform 'freebsd'"},
+		{answer: " , ", wantErr: "no platforms given"},
+		{answer: "", wantErr: "no platforms given"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			got, err := parseInitPlatforms(tt.answer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseInitPlatforms(%q) = %v, %v, want an error containing %q", tt.answer, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInitPlatforms(%q) = %v, %v, want %v", tt.answer, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAskInitValue(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		defaultValue string
+		want         string
+		wantErr      bool
+	}{
+		{name: "Answer", input: "Jane Doe\n", defaultValue: "jane", want: "Jane Doe"},
+		{name: "Default", input: "\n", defaultValue: "jane", want: "jane"},
+		{name: "Spaces", input: "   \n", defaultValue: "jane", want: "jane"},
+		{name: "NoDefault", input: "\n", want: ""},
+		{name: "AnswerWithoutNewline", input: "jane@example.com", want: "jane@example.com"},
+		{name: "ClosedInput", input: "", defaultValue: "jane", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := askInitValue(bufio.NewReader(strings.NewReader(tt.input)), "Your name", tt.defaultValue)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("askInitValue(%q) = %q, %v, want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAskInitOptions(t *testing.T) {
+	t.Setenv("USER", "jane")
+	t.Setenv("USERNAME", "jane")
+
+	tests := []struct {
+		name     string
+		template string
+		input    string
+		want     initOptions
+		wantErr  bool
+	}{
+		{
+			name:     "Defaults",
+			template: "full",
+			input:    "\n\n\n\n\n",
+			want:     initOptions{Template: "full", Name: "My Dotfiles", Author: "jane", Platforms: initPlatforms, SampleTemplates: true},
+		},
+		{
+			name:     "Answers",
+			template: "full",
+			input:    "Work Dotfiles\nJane Doe\njane@example.com\nlinux, macOS\nno\n",
+			want: initOptions{Template: "full", Name: "Work Dotfiles", Author: "Jane Doe", Email: "jane@example.com",
+				Platforms: []string{"linux", "darwin"}, SampleTemplates: false},
+		},
+		{
+			name:     "AsksAgain",
+			template: "full",
+			input:    "\n\n\nbeos\nwindows\nmaybe\ny\n",
+			want:     initOptions{Template: "full", Name: "My Dotfiles", Author: "jane", Platforms: []string{"windows"}, SampleTemplates: true},
+		},
+		{
+			// Platforms and samples are only asked for the full template
+			name:     "Minimal",
+			template: "minimal",
+			input:    "Dots\n\njane@example.com\n",
+			want:     initOptions{Template: "minimal", Name: "Dots", Author: "jane", Email: "jane@example.com", Platforms: initPlatforms, SampleTemplates: true},
+		},
+		{
+			name:     "InputEnds",
+			template: "full",
+			input:    "Dots\nJane\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initOptions{Template: tt.template, Platforms: initPlatforms, SampleTemplates: true}
+			err := askInitOptions(bufio.NewReader(strings.NewReader(tt.input)), &opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("askInitOptions() = %+v, want an error for the missing answers", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opts, tt.want) {
+				t.Errorf("askInitOptions() = %+v, want %+v", opts, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializeRepositoryTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      initOptions
+		want      []string // Files that must exist
+		notWanted []string // Files that must not exist
+		email     string   // user.email of variables/global.yaml, empty when it has none
+	}{
+		{
+			name: "Full",
+			opts: initOptions{Template: "full", Author: "Jane", Email: "jane@example.com", Platforms: initPlatforms, SampleTemplates: true},
+			want: []string{"dotfiles.yaml", "variables/index.yaml", "variables/global.yaml", "variables/platforms/linux.yaml",
+				"variables/platforms/darwin.yaml", "variables/platforms/windows.yaml", "jobs/index.yaml",
+				"files/templates/git/config.tmpl", "README.md", ".gitignore"},
+			email: "jane@example.com",
+		},
+		{
+			name:      "FullSelectedPlatformsWithoutSamples",
+			opts:      initOptions{Template: "full", Author: "Jane", Platforms: []string{"linux"}},
+			want:      []string{"variables/platforms/linux.yaml", "jobs/index.yaml"},
+			notWanted: []string{"variables/platforms/darwin.yaml", "variables/platforms/windows.yaml", "files/templates/git/config.tmpl"},
+			email:     emailPlaceholder,
+		},
+		{
+			name:      "Minimal",
+			opts:      initOptions{Template: "minimal", Author: "Jane", Email: "jane@example.com", Platforms: initPlatforms, SampleTemplates: true},
+			want:      []string{"dotfiles.yaml", "variables/index.yaml", "variables/global.yaml", "jobs/index.yaml"},
+			notWanted: []string{"variables/platforms", "files", "scripts", "README.md"},
+			email:     "jane@example.com",
+		},
+		{
+			name:      "Empty",
+			opts:      initOptions{Template: "empty", Email: "jane@example.com", Platforms: initPlatforms, SampleTemplates: true},
+			want:      []string{"dotfiles.yaml", "variables/index.yaml", "jobs/index.yaml"},
+			notWanted: []string{"variables/global.yaml", "variables/platforms", "files", "scripts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := initializeRepository(dir, &tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.want {
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+					t.Errorf("%s was not created: %v", name, err)
+				}
+			}
+			for _, name := range tt.notWanted {
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+					t.Errorf("%s was created by the %s template", name, tt.opts.Template)
+				}
+			}
+
+			if tt.email == "" {
+				return
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "variables", "global.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var global struct {
+				User struct {
+					Name  string `yaml:"name"`
+					Email string `yaml:"email"`
+				} `yaml:"user"`
+			}
+			if err := yaml.Unmarshal(data, &global); err != nil {
+				t.Fatal(err)
+			}
+			if global.User.Email != tt.email || global.User.Name != tt.opts.Author {
+				t.Errorf("user in global.yaml = %+v, want %s <%s>", global.User, tt.opts.Author, tt.email)
+			}
+		})
+	}
+}
+
+func TestCommitRepository(t *testing.T) {
+	globalConfig := isolateGit(t)
+
+	// Without a git identity the commit is made as the author given to init
+	dir := t.TempDir()
+	if hasGitIdentity(dir) {
+		t.Fatal("hasGitIdentity() = true with an empty git configuration")
+	}
+	opts := &initOptions{Template: "minimal", Author: "Jane Doe", Email: "jane@example.com"}
+	if err := initializeRepository(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitRepository(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if author := runGit(t, dir, "log", "-1", "--format=%an <%ae>"); author != "Jane Doe <jane@example.com>" {
+		t.Errorf("commit author = %s, want the author given to init", author)
+	}
+	if status := runGit(t, dir, "status", "--porcelain"); status != "" {
+		t.Errorf("files left uncommitted:\n%s", status)
+	}
+	if tracked := runGit(t, dir, "ls-files"); !strings.Contains(tracked, "variables/global.yaml") {
+		t.Errorf("committed files = %s, want variables/global.yaml", tracked)
+	}
+
+	// A configured identity is kept
+	if err := os.WriteFile(globalConfig, []byte("[user]\n\tname = Git User\n\temail = git@example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir = t.TempDir()
+	if !hasGitIdentity(dir) {
+		t.Fatal("hasGitIdentity() = false with user.email configured")
+	}
+	if err := initializeRepository(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitRepository(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if author := runGit(t, dir, "log", "-1", "--format=%an <%ae>"); author != "Git User <git@example.com>" {
+		t.Errorf("commit author = %s, want the configured git identity", author)
+	}
+}
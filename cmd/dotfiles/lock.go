@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/lock"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+)
+
+// acquireLock takes the lock that keeps apply, cleanup and rollback from changing
+// the dotfiles at the same time, waiting up to wait for the run holding it to finish.
+// The lock of a run that no longer runs is broken with a warning.
+func acquireLock(basePath, command string, wait time.Duration) (*lock.Lock, error) {
+	runLock, err := lock.AcquireWait(basePath, command, wait)
+	var heldErr *lock.HeldError
+	if errors.As(err, &heldErr) {
+		if wait > 0 {
+			return nil, fmt.Errorf("%w, gave up after waiting %s", err, wait)
+		}
+		return nil, fmt.Errorf("%w, use --wait to wait for it to finish", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if runLock.Broken != nil {
+		logger.Get().Warn().
+			Int("pid", runLock.Broken.PID).
+			Time("since", runLock.Broken.StartedAt).
+			Msgf("Broke the lock of dotfiles %s, the process holding it no longer runs", runLock.Broken.Command)
+	}
+	return runLock, nil
+}
+
+// releaseLock gives the lock taken by acquireLock up
+func releaseLock(runLock *lock.Lock) {
+	if err := runLock.Release(); err != nil {
+		logger.Get().Warn().Err(err).Msg("Failed to release lock")
+	}
+}
@@ -2,21 +2,29 @@ package main
 
 import (
 	"os"
-	"os/exec"
 
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages/drivers"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 )
 
 var (
-	verbose bool
-	quiet   bool
-	version = "dev"
-	commit  = "none"
-	date    = "unknown"
+	verbose    bool
+	quiet      bool
+	offline    bool
+	noCache    bool
+	noColor    bool
+	ascii      bool
+	configFile string
+	version    = "dev"
+	commit     = "none"
+	date       = "unknown"
 )
 
 func main() {
@@ -26,8 +34,12 @@ func main() {
 		Long: `A powerful cross-platform dotfiles manager that supports templating,
 package management integration, and works seamlessly across Windows, macOS, and Linux.`,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			// Initialize logger based on flags
+			// Initialize output styling and the logger based on flags
+			ui.Configure(noColor, ascii)
 			logger.Init(verbose, quiet)
+			if !skipsSettings(cmd) {
+				configureSettings()
+			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.Help()
@@ -37,11 +49,17 @@ package management integration, and works seamlessly across Windows, macOS, and
 	// Global flags
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
 	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Enable quiet mode (errors only)")
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "Never access the network; tasks that need to download are skipped, remote imports use their cached copy")
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Load variables from their files instead of the variable cache")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored output (also disabled by NO_COLOR or when output is not a terminal)")
+	rootCmd.PersistentFlags().BoolVar(&ascii, "ascii", false, "Use plain text instead of emoji and other non-ASCII glyphs in output")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Configuration file to use instead of searching for dotfiles.yaml (default $"+config.ConfigEnvVar+")")
 
 	// Add version command
 	versionCmd := &cobra.Command{
-		Use:   "version",
-		Short: "Show version information",
+		Use:         "version",
+		Short:       "Show version information",
+		Annotations: map[string]string{skipSettingsAnnotation: "true"},
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 			log.Info().
@@ -54,8 +72,9 @@ package management integration, and works seamlessly across Windows, macOS, and
 
 	// Add info command to show platform details
 	infoCmd := &cobra.Command{
-		Use:   "info",
-		Short: "Show platform and environment information",
+		Use:         "info",
+		Short:       "Show platform and environment information",
+		Annotations: map[string]string{skipSettingsAnnotation: "true"},
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 
@@ -70,8 +89,28 @@ package management integration, and works seamlessly across Windows, macOS, and
 				Str("arch", info.Arch).
 				Str("shell", info.Shell).
 				Strs("package_managers", info.PackageManagers).
+				Str("homebrew_prefix", info.HomebrewPrefix).
 				Str("home_dir", info.HomeDir).
+				Bool("wsl", info.IsWSL).
+				Int("wsl_version", info.WSLVersion).
+				Bool("container", info.IsContainer).
 				Msg("Platform information")
+
+			// Package managers are picked in this order unless a task prefers another,
+			// including the preferences of the dotfiles repository when there is one
+			registry := drivers.NewDriverRegistry()
+			if configPath, err := findConfigFile(); err == nil {
+				cfg, err := config.Load(configPath)
+				if err != nil {
+					log.Warn().Err(err).Msg("Failed to load configuration, showing the default order")
+				} else {
+					registry.SetPreferences(cfg.Settings.PackageManagers.Prefer, cfg.Settings.PackageManagers.Exclude)
+				}
+			}
+			log.Info().
+				Strs("order", registry.GetAvailableDriverNames()).
+				Strs("excluded", registry.ExcludedDrivers()).
+				Msg("Package manager resolution order")
 		},
 	}
 
@@ -81,16 +120,14 @@ package management integration, and works seamlessly across Windows, macOS, and
 	// Add apply command
 	applyCmd := createApplyCommand()
 
+	// Add plan command
+	planCmd := createPlanCommand()
+
 	// Add backup command
-	backupCmd := &cobra.Command{
-		Use:   "backup",
-		Short: "Backup current configuration files",
-		Run: func(cmd *cobra.Command, args []string) {
-			log := logger.Get()
-			log.Info().Msg("Backing up current configuration...")
-			log.Warn().Msg("Command not yet implemented")
-		},
-	}
+	backupCmd := createBackupCommand()
+
+	// Add rollback command
+	rollbackCmd := createRollbackCommand()
 
 	// Add restore command
 	restoreCmd := &cobra.Command{
@@ -110,59 +147,58 @@ package management integration, and works seamlessly across Windows, macOS, and
 	validateCmd := createValidateCommand()
 
 	// Add update command
-	updateCmd := &cobra.Command{
-		Use:   "update",
-		Short: "Update dotfiles manager to the latest version",
-		Long: `Update the dotfiles manager by running 'go install' with the latest version.
-This requires Go to be installed on your system.
+	updateCmd := createUpdateCommand()
 
-Use --check to only check for updates without installing.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			log := logger.Get()
+	// Add variables command
+	variablesCmd := createVariablesCommand()
 
-			checkOnly, _ := cmd.Flags().GetBool("check")
+	// Add fetch command
+	fetchCmd := createFetchCommand()
 
-			if checkOnly {
-				log.Info().Msg("Checking for updates...")
-				log.Info().Str("current_version", version).Str("current_commit", commit).Msg("Current version")
-				log.Info().Msg("To check for the latest version, visit: https://github.com/vleeuwenmenno/dotfiles-cp/releases")
-				log.Info().Msg("To update, run: dotfiles update")
-				return
-			}
+	// Add explain command
+	explainCmd := createExplainCommand()
 
-			log.Info().Msg("Updating dotfiles manager...")
+	// Add secrets command
+	secretsCmd := createSecretsCommand()
 
-			updateCmd := exec.Command("go", "install", "github.com/vleeuwenmenno/dotfiles-cp/cmd/dotfiles@latest")
-			updateCmd.Stdout = os.Stdout
-			updateCmd.Stderr = os.Stderr
+	// Add templates command
+	templatesCmd := createTemplatesCommand()
 
-			if err := updateCmd.Run(); err != nil {
-				log.Error().Err(err).Msg("Failed to update dotfiles manager")
-				log.Info().Msg("Make sure Go is installed and you have internet connectivity")
-				os.Exit(1)
-			}
+	// Add packages command
+	packagesCmd := createPackagesCommand()
 
-			log.Info().Msg("Update completed successfully!")
-			log.Info().Msg("You may need to restart your terminal or run 'hash -r' to refresh the binary")
-		},
-	}
-	updateCmd.Flags().Bool("check", false, "Only check for updates without installing")
+	// Add cleanup command
+	cleanupCmd := createCleanupCommand()
 
-	// Add variables command
-	variablesCmd := createVariablesCommand()
+	// Add completion command
+	completionCmd := createCompletionCommand()
 
-	// Add fetch command
-	fetchCmd := createFetchCommand()
+	// Add doctor command
+	doctorCmd := createDoctorCommand()
 
-	// Add explain command
-	explainCmd := createExplainCommand()
+	// Add diff command
+	diffCmd := createDiffCommand()
+
+	// Add adopt command
+	adoptCmd := createAdoptCommand()
+
+	// Add schema command
+	schemaCmd := createSchemaCommand()
+
+	// Add export command
+	exportCmd := createExportCommand()
+
+	// Add the helper tasks with become run through
+	becomeHelperCmd := createBecomeHelperCommand()
 
 	// Add commands to root
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(infoCmd)
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(planCmd)
 	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(rollbackCmd)
 	rootCmd.AddCommand(restoreCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(validateCmd)
@@ -170,6 +206,17 @@ Use --check to only check for updates without installing.`,
 	rootCmd.AddCommand(variablesCmd)
 	rootCmd.AddCommand(fetchCmd)
 	rootCmd.AddCommand(explainCmd)
+	rootCmd.AddCommand(secretsCmd)
+	rootCmd.AddCommand(templatesCmd)
+	rootCmd.AddCommand(packagesCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(adoptCmd)
+	rootCmd.AddCommand(schemaCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(becomeHelperCmd)
 
 	// Execute the root command
 	if err := rootCmd.Execute(); err != nil {
@@ -178,3 +225,38 @@ Use --check to only check for updates without installing.`,
 		os.Exit(1)
 	}
 }
+
+// skipSettingsAnnotation marks commands that never load variables or jobs, like
+// version and init, so they run without loading the configuration and keep working
+// when it is broken
+const skipSettingsAnnotation = "dotfiles:skip-settings"
+
+// skipsSettings reports whether cmd or a command it belongs to is marked with
+// skipSettingsAnnotation
+func skipsSettings(cmd *cobra.Command) bool {
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if cmd.Annotations[skipSettingsAnnotation] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// configureSettings applies the settings every command loads variables and jobs
+// with, so they see the same jobs as apply: settings.offline runs every command as
+// with --offline, settings.strict_imports makes imports that do not resolve errors
+// and settings.legacy_ordering orders the actions of jobs files.
+// Commands report a configuration that cannot be loaded themselves.
+func configureSettings() {
+	settings := &config.Settings{}
+	if configPath, err := findConfigFile(); err == nil {
+		if cfg, err := config.Load(configPath); err == nil && cfg.Settings != nil {
+			settings = cfg.Settings
+		}
+	}
+
+	offline = offline || settings.Offline
+	config.ConfigureRemoteImports(offline)
+	config.ConfigureStrictImports(settings.StrictImports)
+	jobs.ConfigureLegacyOrdering(settings.LegacyOrdering)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+)
+
+// TestConfigureSettings checks that the settings changing how jobs are loaded are
+// applied for every command loading them, not only for the commands that run them
+func TestConfigureSettings(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "dotfiles.yaml")
+	previous, previousOffline := configFile, offline
+	configFile = configPath
+	t.Cleanup(func() {
+		configFile, offline = previous, previousOffline
+		jobs.ConfigureLegacyOrdering(false)
+		config.ConfigureStrictImports(false)
+		config.ConfigureRemoteImports(false)
+	})
+
+	if err := os.WriteFile(configPath, []byte("settings:\n  legacy_ordering: true\n  strict_imports: true\n  offline: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offline = false
+	configureSettings()
+	if !jobs.LegacyOrdering() {
+		t.Error("settings.legacy_ordering was not applied")
+	}
+	if !config.StrictImports() {
+		t.Error("settings.strict_imports was not applied")
+	}
+	if !offline {
+		t.Error("settings.offline was not applied")
+	}
+
+	// Settings left out are reset, not kept from an earlier run
+	if err := os.WriteFile(configPath, []byte("settings:\n  create_backups: false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offline = false
+	configureSettings()
+	if jobs.LegacyOrdering() || config.StrictImports() || offline {
+		t.Errorf("legacy ordering = %v, strict imports = %v, offline = %v without the settings, want all off",
+			jobs.LegacyOrdering(), config.StrictImports(), offline)
+	}
+
+	// A configuration that cannot be loaded leaves the defaults
+	configFile = filepath.Join(dir, "missing.yaml")
+	configureSettings()
+	if jobs.LegacyOrdering() {
+		t.Error("legacy ordering is on without a configuration")
+	}
+}
+
+// TestSkipsSettings checks that commands which never load jobs or variables run
+// without loading the configuration, so a broken one does not break them
+func TestSkipsSettings(t *testing.T) {
+	for _, cmd := range []*cobra.Command{createInitCommand(), createCompletionCommand(), createBecomeHelperCommand()} {
+		if !skipsSettings(cmd) {
+			t.Errorf("%s loads the settings, want it to skip them", cmd.Name())
+		}
+	}
+	for _, cmd := range []*cobra.Command{createApplyCommand(), createPlanCommand(), createValidateCommand(), createVariablesCommand()} {
+		if skipsSettings(cmd) {
+			t.Errorf("%s skips the settings, want it to load them", cmd.Name())
+		}
+	}
+
+	// Subcommands of a command that skips the settings skip them as well
+	parent := &cobra.Command{Use: "parent", Annotations: map[string]string{skipSettingsAnnotation: "true"}}
+	child := &cobra.Command{Use: "child"}
+	parent.AddCommand(child)
+	if !skipsSettings(child) {
+		t.Error("a subcommand of a command that skips the settings loads them")
+	}
+}
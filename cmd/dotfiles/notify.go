@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/notify"
+)
+
+// notificationTimeout is how long delivering a single notification may take
+const notificationTimeout = 30 * time.Second
+
+// sendNotifications tells the notification targets of dotfiles.yaml how an apply run
+// went. Notifications that cannot be delivered are only logged, so they never change
+// the exit code of apply.
+func sendNotifications(targets []*config.Notification, report *ApplyReport) {
+	if len(targets) == 0 {
+		return
+	}
+	log := logger.Get()
+
+	report.finish()
+	changed := report.Summary.Succeeded > 0
+	failed := report.Status != "success"
+
+	var message *notify.Message
+	sender := notify.NewSender()
+	for _, target := range targets {
+		if !notify.ShouldSend(target, changed, failed) {
+			continue
+		}
+		if target.Type == "webhook" && offline {
+			log.Warn().Str("url", target.URL).Msg("Not sending webhook notification, --offline disables network access")
+			continue
+		}
+
+		if message == nil {
+			payload, err := report.encode("json")
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to send notifications")
+				return
+			}
+			message = &notify.Message{
+				Title:   notificationTitle(report),
+				Body:    notificationBody(report),
+				Payload: payload,
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+		err := sender.Send(ctx, target, message)
+		cancel()
+		if err != nil {
+			log.Warn().Err(err).Str("type", target.Type).Msg("Failed to send notification")
+		}
+	}
+}
+
+// notificationTitle summarizes an apply run in a line
+func notificationTitle(report *ApplyReport) string {
+	host := ""
+	if report.Hostname != "" {
+		host = " on " + report.Hostname
+	}
+
+	switch {
+	case report.Status != "success":
+		return fmt.Sprintf("dotfiles apply %s%s", report.Status, host)
+	case report.Summary.Succeeded == 0:
+		return fmt.Sprintf("dotfiles apply found nothing to change%s", host)
+	case report.DryRun:
+		return fmt.Sprintf("dotfiles apply would change %d jobs%s", report.Summary.Succeeded, host)
+	default:
+		return fmt.Sprintf("dotfiles apply changed %d jobs%s", report.Summary.Succeeded, host)
+	}
+}
+
+// notificationBody lists the counts of an apply run and why it stopped, if it did
+func notificationBody(report *ApplyReport) string {
+	summary := report.Summary
+	counts := []string{
+		fmt.Sprintf("%d changed", summary.Succeeded),
+		fmt.Sprintf("%d skipped", summary.Skipped),
+		fmt.Sprintf("%d failed", summary.Failed),
+	}
+	if summary.NotRun > 0 {
+		counts = append(counts, fmt.Sprintf("%d not run", summary.NotRun))
+	}
+	if summary.NeedsAttention > 0 {
+		counts = append(counts, fmt.Sprintf("%d need attention", summary.NeedsAttention))
+	}
+	if summary.HandlersFailed > 0 {
+		counts = append(counts, fmt.Sprintf("%d handlers failed", summary.HandlersFailed))
+	}
+
+	body := strings.Join(counts, ", ")
+	if report.Error != "" {
+		body += "\n" + report.Error
+	}
+	return body
+}
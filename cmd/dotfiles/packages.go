@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages/drivers"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// createPackagesCommand creates the packages command with subcommands
+func createPackagesCommand() *cobra.Command {
+	packagesCmd := &cobra.Command{
+		Use:   "packages",
+		Short: "Inspect the packages your dotfiles manage",
+		Long:  `Inspect the packages and repositories your jobs install with package managers.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	packagesCmd.AddCommand(createPackagesListCommand())
+	packagesCmd.AddCommand(createPackagesExportCommand())
+
+	return packagesCmd
+}
+
+// createPackagesExportCommand creates the packages export subcommand
+func createPackagesExportCommand() *cobra.Command {
+	var (
+		manager     string
+		platform    string
+		hostname    string
+		environment []string
+		profiles    []string
+	)
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "List the packages the jobs install with a package manager",
+		Long: `List the repositories and packages the jobs install with a package manager,
+read from the jobs rather than from what is installed on this machine.
+
+For Homebrew the list is a Brewfile, so it can be compared with the output of
+brew bundle dump or installed with brew bundle. Other package managers get one
+package per line.
+
+Packages restricted to other managers with only are left out. Packages that only
+prefer another manager are listed, since the manager is picked on the machine that
+applies them. Use --platform and --hostname to export for another machine.`,
+		Example: `  dotfiles packages export --manager homebrew > Brewfile
+  dotfiles packages export --manager homebrew --platform darwin
+  diff <(dotfiles packages export --manager homebrew) <(brew bundle dump --file=-)`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			_, _, variables, tasksList := loadPackageTasks(&config.VariableLoadOptions{
+				Platform:    platform,
+				Hostname:    hostname,
+				Environment: parseEnvironmentVariables(environment),
+			}, profiles)
+
+			export, err := packages.New().ExportPackages(tasksList, manager, variables)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to export packages")
+				os.Exit(1)
+			}
+			fmt.Print(export.Format())
+		},
+	}
+
+	exportCmd.Flags().StringVarP(&manager, "manager", "m", "", "Package manager to export the packages of (e.g. homebrew)")
+	exportCmd.Flags().StringVar(&platform, "platform", "", "Override platform detection (windows, linux, darwin)")
+	exportCmd.Flags().StringVar(&hostname, "hostname", "", "Override hostname detection")
+	exportCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
+	exportCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles to export the packages of (default settings.default_profiles)")
+	exportCmd.MarkFlagRequired("manager")
+	exportCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+
+	return exportCmd
+}
+
+// loadPackageTasks loads the configuration, variables and the tasks whose
+// conditions are true, exiting when any of them fails to load
+func loadPackageTasks(options *config.VariableLoadOptions, profiles []string) (*config.Config, string, map[string]interface{}, []*config.Task) {
+	log := logger.Get()
+
+	configPath, err := findConfigFile()
+	if err != nil {
+		handleConfigFileError(err)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load configuration")
+		os.Exit(1)
+	}
+
+	basePath := filepath.Dir(configPath)
+
+	vloader, err := config.NewVariableLoader(cfg, basePath)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create variable loader")
+		os.Exit(1)
+	}
+
+	variables, err := vloader.LoadAllVariables(options)
+	if err != nil {
+		handleVariableError(err)
+		os.Exit(1)
+	}
+
+	jobsIndexPath := cfg.GetJobsIndexPath(basePath)
+	tasksList, err := jobs.LoadJobsFromFileWithConditions(jobsIndexPath, variables, cfg.GetProfiles(profiles))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load jobs")
+		os.Exit(1)
+	}
+
+	return cfg, basePath, variables, tasksList
+}
+
+// packageListEntry is a package the jobs manage with its state on this machine
+type packageListEntry struct {
+	*packages.PackageStatus
+	Source string `json:"source"`
+}
+
+// missing reports whether the package should be installed but is not
+func (e *packageListEntry) missing() bool {
+	return e.DesiredState == "present" && e.CurrentState != "installed"
+}
+
+// createPackagesListCommand creates the packages list subcommand
+func createPackagesListCommand() *cobra.Command {
+	var (
+		manager     string
+		onlyMissing bool
+		format      string
+		profiles    []string
+	)
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Show the packages the jobs manage and whether they are installed",
+		Long: `Show every package the install_package, uninstall_package and manage_packages
+tasks manage, with the package manager that manages it on this machine, the state
+the jobs want, the state it is in and what apply would do about it.
+
+Tasks whose conditions are false on this machine are left out. A package whose
+state cannot be determined is listed with the error.`,
+		Example: `  dotfiles packages list
+  dotfiles packages list --manager apt --only-missing
+  dotfiles packages list --format json`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			if format != "text" && format != "json" {
+				log.Error().Str("format", format).Msg("Unsupported format, use text or json")
+				os.Exit(1)
+			}
+			if manager != "" {
+				driver, err := drivers.NewDriverRegistry().GetDriver(manager)
+				if err != nil {
+					log.Error().Str("manager", manager).Msg("Unknown package manager")
+					os.Exit(1)
+				}
+				manager = driver.Name()
+			}
+
+			cfg, basePath, variables, tasksList := loadPackageTasks(&config.VariableLoadOptions{}, profiles)
+			ctx := &modules.ExecutionContext{
+				BasePath:        basePath,
+				Variables:       variables,
+				DryRun:          true,
+				Verbose:         verbose,
+				Offline:         offline,
+				SudoCommand:     cfg.Settings.SudoCommand,
+				PackageManagers: cfg.Settings.PackageManagers,
+			}
+
+			module := packages.New()
+			entries := []*packageListEntry{}
+			for _, task := range tasksList {
+				for _, status := range module.PackageStatuses(task, ctx) {
+					entry := &packageListEntry{PackageStatus: status, Source: task.Location()}
+					if (manager != "" && status.Manager != manager) || (onlyMissing && !entry.missing()) {
+						continue
+					}
+					entries = append(entries, entry)
+				}
+			}
+
+			if format == "json" {
+				fmt.Println(utils.ToJSONString(entries))
+				return
+			}
+			outputPackageList(entries)
+		},
+	}
+
+	listCmd.Flags().StringVarP(&manager, "manager", "m", "", "Only list the packages managed with this package manager (e.g. apt)")
+	listCmd.Flags().BoolVar(&onlyMissing, "only-missing", false, "Only list packages that should be installed but are not")
+	listCmd.Flags().StringVarP(&format, "format", "f", "text", "Output format (text, json)")
+	listCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles to list the packages of (default settings.default_profiles)")
+	listCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+
+	return listCmd
+}
+
+// outputPackageList prints the packages as a table
+func outputPackageList(entries []*packageListEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No packages found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMANAGER\tDESIRED\tACTUAL\tACTION\tSOURCE")
+	for _, entry := range entries {
+		name := entry.PackageName
+		if entry.DesiredVersion != "" {
+			name += " " + entry.DesiredVersion
+		}
+		actual := strings.ReplaceAll(entry.CurrentState, "_", " ")
+		if entry.InstalledVersion != "" {
+			actual += " (" + entry.InstalledVersion + ")"
+		}
+		action := entry.ActionNeeded
+		if entry.Error != "" {
+			action = "error: " + entry.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, entry.Manager, entry.DesiredState, actual, action, entry.Source)
+	}
+	w.Flush()
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+
+	"github.com/spf13/cobra"
+)
+
+// planExitChanges is the exit code of `plan --exit-code` when changes are pending
+const planExitChanges = 2
+
+// PlanOperation is what applying a task would do to its target
+type PlanOperation string
+
+const (
+	PlanCreate PlanOperation = "create" // The target does not exist yet
+	PlanUpdate PlanOperation = "update" // The target exists but would change
+	PlanSkip   PlanOperation = "skip"   // The target is already in the desired state
+	PlanFailed PlanOperation = "failed" // The task could not be planned
+)
+
+// PlannedTask is the plan of a single task
+type PlannedTask struct {
+	Task      *config.Task
+	Plan      *modules.TaskPlan
+	Operation PlanOperation
+	Error     error
+}
+
+// PlanGroup holds the planned tasks of one module, grouped by source file
+type PlanGroup struct {
+	Module  string
+	Sources []string                  // Source files in job order
+	Tasks   map[string][]*PlannedTask // Planned tasks per source file
+	Counts  map[PlanOperation]int
+}
+
+// createPlanCommand creates the plan command
+func createPlanCommand() *cobra.Command {
+	var (
+		platform    string
+		shell       string
+		hostname    string
+		environment []string
+		profiles    []string
+		tags        []string
+		skipTags    []string
+		showDiff    bool
+		diffContext int
+		exitCode    bool
+		planExec    bool
+		explain     string
+		strict      bool
+		forceWild   bool
+		skipElev    bool
+		repo        repoCheck
+	)
+
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show what apply would change without making changes",
+		Long: `Plan all configured jobs and show what apply would change, grouped by
+module and by the job file each task comes from.
+
+Use --platform, --hostname and --env to preview what apply would do on another machine.
+Use --profile to plan the jobs of a profile, like apply --profile.
+Use --tags and --skip-tags to plan only the tasks with or without certain tags.
+Use --show-diff to see detailed file content differences and --diff-context to
+change how many unchanged lines surround each change.
+Use --plan-exec to run the content_command of ensure_file tasks and show their
+actual changes, instead of content determined at apply time.
+Use --exit-code to exit with 2 when changes are pending and 0 when everything is in sync.
+Use --explain with a task ID to show why that task would run or be skipped: the
+file it comes from, its condition with the values of the variables it reads, the
+state plan found and the decision.
+Use --require-up-to-date and --require-clean to fail when the dotfiles repository
+is behind its upstream branch or has uncommitted changes, instead of only warning,
+and --no-fetch to skip fetching the upstream branch.
+Use --strict to fail on imports whose path references variables that are not
+defined, instead of warning about them (see settings.strict_imports).`,
+		Example: `  dotfiles plan
+  dotfiles plan --hostname work-laptop --platform darwin
+  dotfiles plan --profile work
+  dotfiles plan --exit-code || echo "dotfiles have drifted"
+  dotfiles plan --explain "ensure_file: ~/.gitconfig"`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			// Find and load configuration
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			if strict {
+				config.ConfigureStrictImports(true)
+			}
+
+			// Get base path
+			basePath := filepath.Dir(configPath)
+
+			// Warn about planning jobs that are not what the repository has upstream
+			repoState, err := repo.run(cfg, configPath, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Dotfiles repository check failed")
+				os.Exit(1)
+			}
+
+			// Load variables
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			// Prepare variable load options
+			opts := &config.VariableLoadOptions{UseCache: !noCache}
+			if platform != "" {
+				opts.Platform = platform
+			}
+			if shell != "" {
+				opts.Shell = shell
+			}
+			if hostname != "" {
+				opts.Hostname = hostname
+			}
+			if len(environment) > 0 {
+				opts.Environment = make(map[string]string)
+				for _, env := range environment {
+					parts := strings.SplitN(env, "=", 2)
+					if len(parts) == 2 {
+						opts.Environment[parts[0]] = parts[1]
+					}
+				}
+			}
+
+			variables, err := vloader.LoadAllVariables(opts)
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			// Load jobs with condition filtering
+			selection := &taskSelection{Profiles: cfg.GetProfiles(profiles), Tags: tags, SkipTags: skipTags}
+			jobsIndexPath := cfg.GetJobsIndexPath(basePath)
+			tasksList, skippedList, handlers, err := jobs.LoadJobsWithSkipped(jobsIndexPath, variables, selection.Profiles)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				os.Exit(1)
+			}
+
+			tasksList, err = filterTasksByTags(jobsIndexPath, variables, tasksList, selection)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to select tasks by tag")
+				os.Exit(1)
+			}
+			skippedList = jobs.FilterTasksByTags(skippedList, selection.Tags, selection.SkipTags)
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				os.Exit(1)
+			}
+
+			if err := checkTargetConflicts(registry, tasksList, basePath, cfg.GetTemplatesPath(basePath), variables); err != nil {
+				os.Exit(1)
+			}
+
+			backupDir, err := cfg.GetBackupPath(basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to resolve backup directory")
+				os.Exit(1)
+			}
+
+			ctx := &modules.ExecutionContext{
+				BasePath:        basePath,
+				Variables:       variables,
+				DryRun:          true,
+				Verbose:         verbose,
+				ShowDiff:        showDiff,
+				DiffContext:     diffContext,
+				DiffColor:       ui.NewPalette(os.Stdout).Enabled(),
+				CreateBackups:   cfg.Settings.CreateBackups,
+				BackupDir:       backupDir,
+				Offline:         offline,
+				SudoCommand:     cfg.Settings.SudoCommand,
+				PackageManagers: cfg.Settings.PackageManagers,
+				PlanExec:        planExec,
+				ForceWildcards:  forceWild,
+				Elevated:        processElevated(),
+				SkipElevated:    skipElev,
+				TemplatesDir:    cfg.GetTemplatesPath(basePath),
+			}
+
+			if explain != "" {
+				if err := explainTask(registry, explain, tasksList, skippedList, ctx, ui.NewPalette(os.Stdout)); err != nil {
+					log.Error().Err(err).Msg("Failed to explain task")
+					os.Exit(1)
+				}
+				return
+			}
+
+			groups, totals := planTasks(registry, tasksList, skippedList, ctx)
+			outputPlan(groups, totals, selection, variables, ui.NewPalette(os.Stdout))
+
+			// Prompts show the drift through status --porcelain, unless only some tasks were planned
+			if len(profiles) == 0 && len(tags) == 0 && len(skipTags) == 0 && platform == "" && hostname == "" {
+				writeStatusSummary(basePath, "plan", repoState, totals[PlanCreate]+totals[PlanUpdate])
+			}
+
+			// Handlers are notified by the tasks that would change something
+			notifications := make(handlerNotifications)
+			for _, group := range groups {
+				for _, source := range group.Sources {
+					for _, planned := range group.Tasks[source] {
+						if planned.Operation == PlanCreate || planned.Operation == PlanUpdate {
+							notifications.notify(planned.Task, renderTaskDisplayName(planned.Task, variables))
+						}
+					}
+				}
+			}
+			if notified := notifications.notified(handlers); len(notified) > 0 {
+				fmt.Println()
+				printNotifiedHandlers(notified, notifications)
+			}
+
+			if totals[PlanFailed] > 0 {
+				os.Exit(1)
+			}
+			if exitCode && totals[PlanCreate]+totals[PlanUpdate] > 0 {
+				os.Exit(planExitChanges)
+			}
+		},
+	}
+
+	planCmd.Flags().StringVar(&platform, "platform", "", "Override platform detection (windows, linux, darwin)")
+	planCmd.Flags().StringVar(&shell, "shell", "", "Override shell detection (bash, zsh, powershell)")
+	planCmd.Flags().StringVar(&hostname, "hostname", "", "Override hostname")
+	planCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
+	planCmd.Flags().StringSliceVar(&profiles, "profile", nil, "Profiles to plan, jobs limited to other profiles are skipped (default settings.default_profiles)")
+	planCmd.Flags().StringSliceVar(&tags, "tags", nil, "Only plan tasks with one of these tags")
+	planCmd.Flags().StringSliceVar(&skipTags, "skip-tags", nil, "Skip tasks with one of these tags")
+	planCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	planCmd.RegisterFlagCompletionFunc("tags", completeTags)
+	planCmd.RegisterFlagCompletionFunc("skip-tags", completeTags)
+	planCmd.Flags().BoolVar(&showDiff, "show-diff", false, "Show detailed diffs of file changes")
+	planCmd.Flags().IntVar(&diffContext, "diff-context", 3, "Unchanged lines shown around each change in diffs")
+	planCmd.Flags().BoolVar(&planExec, "plan-exec", false, "Run content_command of ensure_file tasks to show their actual changes")
+	planCmd.Flags().BoolVar(&forceWild, "force-wildcards", false, "Let wildcard uninstalls remove more packages than max_removals (essential packages are never removed)")
+	planCmd.Flags().BoolVar(&skipElev, "skip-elevated", false, "Skip tasks with become when they cannot be elevated (Windows without Administrator) instead of failing them")
+	planCmd.Flags().BoolVar(&exitCode, "exit-code", false, "Exit with 2 when changes are pending, 0 when everything is in sync")
+	planCmd.Flags().StringVar(&explain, "explain", "", "Explain why the task with this ID would run or be skipped")
+	planCmd.Flags().BoolVar(&strict, "strict", false, "Fail on imports whose path does not resolve instead of warning (default settings.strict_imports)")
+	repo.addFlags(planCmd)
+
+	return planCmd
+}
+
+// planTasks plans every task and groups the results by module. Tasks skipped because
+// their condition is false are not planned, they show up as skipped.
+func planTasks(registry *modules.ModuleRegistry, tasksList, skippedList []*config.Task, ctx *modules.ExecutionContext) ([]*PlanGroup, map[PlanOperation]int) {
+	groupsByModule := make(map[string]*PlanGroup)
+	totals := make(map[PlanOperation]int)
+
+	var plannedTasks []*PlannedTask
+	for _, task := range tasksList {
+		plannedTasks = append(plannedTasks, planTask(registry, task, ctx))
+	}
+	for _, task := range skippedList {
+		plannedTasks = append(plannedTasks, conditionSkipped(task))
+	}
+	// Tasks skipped by their condition go back between the others in job order
+	sort.SliceStable(plannedTasks, func(i, j int) bool {
+		return plannedTasks[i].Task.Order < plannedTasks[j].Task.Order
+	})
+
+	for _, planned := range plannedTasks {
+		task := planned.Task
+		moduleName := "unknown"
+		if module, err := registry.GetModuleByAction(task.Action); err == nil {
+			moduleName = module.Name()
+		}
+
+		group, exists := groupsByModule[moduleName]
+		if !exists {
+			group = &PlanGroup{
+				Module: moduleName,
+				Tasks:  make(map[string][]*PlannedTask),
+				Counts: make(map[PlanOperation]int),
+			}
+			groupsByModule[moduleName] = group
+		}
+
+		source := task.Source
+		if source == "" {
+			source = "(unknown source)"
+		}
+		if _, exists := group.Tasks[source]; !exists {
+			group.Sources = append(group.Sources, source)
+		}
+		group.Tasks[source] = append(group.Tasks[source], planned)
+		group.Counts[planned.Operation]++
+		totals[planned.Operation]++
+	}
+
+	groups := make([]*PlanGroup, 0, len(groupsByModule))
+	for _, group := range groupsByModule {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Module < groups[j].Module
+	})
+
+	return groups, totals
+}
+
+// planTask plans a single task
+func planTask(registry *modules.ModuleRegistry, task *config.Task, ctx *modules.ExecutionContext) *PlannedTask {
+	planned := &PlannedTask{Task: task}
+	planned.Plan, planned.Error = registry.PlanTask(task, ctx)
+	planned.Operation = planOperation(registry, planned, ctx)
+	return planned
+}
+
+// conditionSkipped returns the plan of a task that is skipped because its condition
+// is false. Conditions on the platform variables mean the task is for another platform.
+func conditionSkipped(task *config.Task) *PlannedTask {
+	code := modules.SkipConditionFalse
+	if strings.Contains(task.Condition, "Platform.") {
+		code = modules.SkipPlatformMismatch
+	}
+	return &PlannedTask{
+		Task: task,
+		Plan: &modules.TaskPlan{
+			TaskID:     task.ID,
+			Action:     task.Action,
+			WillSkip:   true,
+			SkipReason: fmt.Sprintf("condition is false: %s", task.Condition),
+			SkipCode:   code,
+		},
+		Operation: PlanSkip,
+	}
+}
+
+// planOperation classifies a planned task. Modules that can check drift tell
+// apart missing and out of date targets, for other tasks the planned changes
+// decide.
+func planOperation(registry *modules.ModuleRegistry, planned *PlannedTask, ctx *modules.ExecutionContext) PlanOperation {
+	if planned.Error != nil {
+		return PlanFailed
+	}
+	if planned.Plan.WillSkip {
+		return PlanSkip
+	}
+
+	if result, ok, err := registry.CheckDrift(planned.Task, ctx); ok && err == nil && result != nil {
+		if result.State == modules.DriftMissing {
+			return PlanCreate
+		}
+		return PlanUpdate
+	}
+
+	for _, change := range planned.Plan.Changes {
+		if strings.HasPrefix(change, "Create ") || strings.HasPrefix(change, "Install ") || strings.HasPrefix(change, "Add repository") {
+			return PlanCreate
+		}
+	}
+	return PlanUpdate
+}
+
+// outputPlan prints the planned tasks grouped by module and source file
+func outputPlan(groups []*PlanGroup, totals map[PlanOperation]int, selection *taskSelection, variables map[string]interface{}, p *ui.Palette) {
+	ui.Printf("📋 Plan - No changes will be made\n\n")
+	selection.print()
+
+	for _, group := range groups {
+		ui.Printf("📦 %s %s\n", p.Bold(group.Module), p.Dim("("+planCounts(group.Counts, p)+")"))
+
+		for _, source := range group.Sources {
+			var shown []*PlannedTask
+			for _, planned := range group.Tasks[source] {
+				if planned.Operation != PlanSkip || verbose {
+					shown = append(shown, planned)
+				}
+			}
+			if len(shown) == 0 {
+				continue
+			}
+
+			ui.Printf("   %s\n", p.Dim(source))
+			for _, planned := range shown {
+				outputPlannedTask(planned, variables, p)
+			}
+		}
+		fmt.Println()
+	}
+
+	ui.Printf("📊 Plan: %s\n", planCounts(totals, p))
+	if totals[PlanSkip] > 0 {
+		hint := ""
+		if !verbose {
+			hint = " " + p.Dim("(use --verbose to list them)")
+		}
+		ui.Printf("   Unchanged: %s%s\n", skipCounts(groups), hint)
+	}
+	if elevated := elevatedCount(groups); elevated > 0 {
+		ui.Printf("   Become: %s %s\n", pluralize(elevated, "task"), p.Dim("(run with root or Administrator rights)"))
+	}
+	if totals[PlanCreate]+totals[PlanUpdate] == 0 && totals[PlanFailed] == 0 {
+		ui.Printf("   %s\n", p.Green("Everything is in sync"))
+	}
+}
+
+// elevatedCount returns the number of tasks with become that would change something
+// or fail, which a run without root or Administrator rights elevates or skips
+func elevatedCount(groups []*PlanGroup) int {
+	count := 0
+	for _, group := range groups {
+		for _, tasks := range group.Tasks {
+			for _, planned := range tasks {
+				if planned.Task.Become && planned.Operation != PlanSkip {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// outputPlannedTask prints a single planned task with its changes
+func outputPlannedTask(planned *PlannedTask, variables map[string]interface{}, p *ui.Palette) {
+	displayName := renderTaskDisplayName(planned.Task, variables)
+	line := ""
+	if planned.Task.Line > 0 {
+		line = " " + p.Dim(fmt.Sprintf("(line %d)", planned.Task.Line))
+	}
+	if planned.Task.Become {
+		line += " " + p.Yellow("[become]")
+	}
+
+	switch planned.Operation {
+	case PlanCreate:
+		ui.Printf("     %s %s%s\n", p.Green("+"), displayName, line)
+	case PlanUpdate:
+		ui.Printf("     %s %s%s\n", p.Yellow("~"), displayName, line)
+	case PlanSkip:
+		ui.Printf("     %s %s %s\n", p.Dim("="), displayName, p.Dim("("+skipLabel(planned.Plan.SkipCode)+": "+planned.Plan.SkipReason+")"))
+		return
+	case PlanFailed:
+		ui.Printf("     %s %s%s\n", p.Red("!"), displayName, line)
+		ui.Printf("         %s\n", p.Red(planned.Error.Error()))
+		if planned.Task.Source != "" {
+			ui.Printf("         source: %s\n", planned.Task.Location())
+		}
+		return
+	}
+
+	for _, change := range planned.Plan.Changes {
+		ui.Printf("         - %s\n", change)
+	}
+}
+
+// planCounts formats the number of tasks per operation
+func planCounts(counts map[PlanOperation]int, p *ui.Palette) string {
+	parts := []string{
+		p.Green(fmt.Sprintf("%d to create", counts[PlanCreate])),
+		p.Yellow(fmt.Sprintf("%d to update", counts[PlanUpdate])),
+		fmt.Sprintf("%d unchanged", counts[PlanSkip]),
+	}
+	if counts[PlanFailed] > 0 {
+		parts = append(parts, p.Red(fmt.Sprintf("%d failed", counts[PlanFailed])))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// skipCounts formats the number of skipped tasks per skip code
+func skipCounts(groups []*PlanGroup) string {
+	counts := make(map[modules.SkipReasonCode]int)
+	for _, group := range groups {
+		for _, tasks := range group.Tasks {
+			for _, planned := range tasks {
+				if planned.Operation == PlanSkip {
+					counts[planned.Plan.SkipCode]++
+				}
+			}
+		}
+	}
+
+	var parts []string
+	for _, code := range modules.SkipReasonCodes {
+		if counts[code] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[code], skipLabel(code)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// skipLabel formats a skip code for people
+func skipLabel(code modules.SkipReasonCode) string {
+	return strings.ReplaceAll(string(code), "_", " ")
+}
+
+// explainTask prints the decision trail of the tasks matching id: where the task
+// comes from, how its condition evaluates, what state plan finds and what apply
+// would do. id matches task IDs exactly, or part of them when no ID is equal.
+func explainTask(registry *modules.ModuleRegistry, id string, tasksList, skippedList []*config.Task, ctx *modules.ExecutionContext, p *ui.Palette) error {
+	all := append(append([]*config.Task{}, tasksList...), skippedList...)
+	matches := matchTasks(all, id, ctx.Variables)
+	if len(matches) == 0 {
+		return fmt.Errorf("no task matches '%s', tasks of profiles that are not selected are not loaded", id)
+	}
+	if len(matches) > 1 {
+		ui.Printf("'%s' matches %d tasks:\n", id, len(matches))
+		for _, task := range matches {
+			ui.Printf("   %s %s\n", task.ID, p.Dim("("+task.Location()+")"))
+		}
+		return fmt.Errorf("'%s' matches %d tasks, use one of their IDs", id, len(matches))
+	}
+
+	task := matches[0]
+	conditionFalse := slices.Contains(skippedList, task)
+	variables := task.ScopedVariables(ctx.Variables)
+
+	ui.Printf("🔎 %s\n", p.Bold(renderTaskDisplayName(task, ctx.Variables)))
+	ui.Printf("   Source:     %s\n", task.Location())
+	ui.Printf("   Action:     %s\n", task.Action)
+	if len(task.Profiles) > 0 {
+		ui.Printf("   Profiles:   %s\n", strings.Join(task.Profiles, ", "))
+	}
+	if len(task.Tags) > 0 {
+		ui.Printf("   Tags:       %s\n", strings.Join(task.Tags, ", "))
+	}
+	if task.Become {
+		ui.Println("   Become:     runs with root or Administrator rights")
+	}
+
+	if task.Condition == "" {
+		ui.Printf("   Condition:  %s\n", p.Dim("none"))
+	} else {
+		ui.Printf("   Condition:  %s\n", task.Condition)
+		ui.Printf("               %s → %t\n", templating.SubstituteConditionVariables(task.Condition, variables), !conditionFalse)
+	}
+
+	var planned *PlannedTask
+	if conditionFalse {
+		planned = conditionSkipped(task)
+		ui.Printf("   State:      %s\n", p.Dim("not gathered, the condition is false"))
+	} else {
+		planned = planTask(registry, task, ctx)
+		if planned.Error == nil {
+			ui.Printf("   State:      %s\n", planned.Plan.Description)
+			if result, ok, err := registry.CheckDrift(task, ctx); ok && err == nil && result != nil {
+				ui.Printf("               %s is %s\n", result.Path, strings.ReplaceAll(string(result.State), "_", " "))
+			}
+		}
+	}
+
+	switch planned.Operation {
+	case PlanSkip:
+		ui.Printf("   Decision:   %s (%s): %s\n", p.Dim("skip"), skipLabel(planned.Plan.SkipCode), planned.Plan.SkipReason)
+	case PlanFailed:
+		ui.Printf("   Decision:   %s: %v\n", p.Red("failed"), planned.Error)
+	default:
+		color := p.Green
+		if planned.Operation == PlanUpdate {
+			color = p.Yellow
+		}
+		ui.Printf("   Decision:   %s\n", color(string(planned.Operation)))
+		for _, change := range planned.Plan.Changes {
+			ui.Printf("               - %s\n", change)
+		}
+	}
+	return nil
+}
+
+// matchTasks returns the tasks whose ID or display name is id, or contains it when
+// none is equal
+func matchTasks(tasks []*config.Task, id string, variables map[string]interface{}) []*config.Task {
+	var exact, partial []*config.Task
+	for _, task := range tasks {
+		displayName := renderTaskDisplayName(task, variables)
+		switch {
+		case task.ID == id || displayName == id:
+			exact = append(exact, task)
+		case strings.Contains(task.ID, id) || strings.Contains(displayName, id):
+			partial = append(partial, task)
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
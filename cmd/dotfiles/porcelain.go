@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/state"
+)
+
+// Exit codes of status --porcelain
+const (
+	porcelainExitClean = 0 // In sync, nothing uncommitted and not behind
+	porcelainExitDrift = 1 // Drifted, uncommitted changes or behind upstream
+	porcelainExitError = 2 // The status could not be determined
+)
+
+// The states of the dotfiles repository status --porcelain prints first
+const (
+	porcelainClean = "clean" // No uncommitted changes
+	porcelainDirty = "dirty" // Uncommitted changes
+	porcelainNoGit = "nogit" // Not a git repository
+)
+
+// porcelainStatus is the line status --porcelain prints. Its format is stable,
+// shell prompts parse it.
+type porcelainStatus struct {
+	Repo   string // porcelainClean, porcelainDirty or porcelainNoGit
+	Drift  int    // Tasks the last apply or plan found to change, -1 when neither ran
+	Behind int    // Commits behind the upstream branch as last fetched
+}
+
+// String formats the status as clean|drift=3|behind=2
+func (s *porcelainStatus) String() string {
+	drift := "?"
+	if s.Drift >= 0 {
+		drift = strconv.Itoa(s.Drift)
+	}
+	return fmt.Sprintf("%s|drift=%s|behind=%d", s.Repo, drift, s.Behind)
+}
+
+// exitCode returns porcelainExitClean when nothing needs attention. An unknown
+// drift counts as clean, so a prompt stays quiet until the first plan or apply.
+func (s *porcelainStatus) exitCode() int {
+	if s.Repo == porcelainDirty || s.Drift > 0 || s.Behind > 0 {
+		return porcelainExitDrift
+	}
+	return porcelainExitClean
+}
+
+// getPorcelainStatus gathers the status of the dotfiles cheaply: a single git
+// command that does not fetch, and the drift apply or plan recorded
+func getPorcelainStatus() (*porcelainStatus, error) {
+	configPath, err := findConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	// Loading the configuration registers settings.state_dir
+	if _, err := config.Load(configPath); err != nil {
+		return nil, err
+	}
+	basePath := filepath.Dir(configPath)
+
+	status := &porcelainStatus{Repo: porcelainNoGit, Drift: -1}
+	summary, err := state.LoadSummary(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if summary != nil {
+		status.Drift = summary.Drift
+	}
+
+	output, err := gitStatusPorcelain(basePath)
+	if err != nil {
+		// Not a git repository, or no git at all
+		return status, nil
+	}
+	status.Repo, status.Behind = parseGitStatusPorcelain(output)
+	return status, nil
+}
+
+// gitStatusPorcelain runs git status in machine-readable form with the branch
+// header, which holds the commits ahead and behind the upstream branch
+func gitStatusPorcelain(dir string) (string, error) {
+	cmd := exec.Command("git", "status", "--porcelain=v2", "--branch")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	return string(output), err
+}
+
+// parseGitStatusPorcelain reads whether the repository has uncommitted changes and
+// how many commits it is behind its upstream branch from git status --porcelain=v2
+// --branch
+func parseGitStatusPorcelain(output string) (repo string, behind int) {
+	repo = porcelainClean
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# branch.ab "):
+			// # branch.ab +<ahead> -<behind>
+			fields := strings.Fields(line)
+			if len(fields) == 4 {
+				behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			repo = porcelainDirty
+		}
+	}
+	return repo, behind
+}
+
+// writeStatusSummary records what apply or plan found for status --porcelain
+func writeStatusSummary(basePath, command string, repo *repoState, drift int) {
+	summary := &state.Summary{
+		WrittenAt: time.Now(),
+		Command:   command,
+		Commit:    repo.Commit,
+		Drift:     drift,
+	}
+	if err := state.SaveSummary(basePath, summary); err != nil {
+		logger.Get().Warn().Err(err).Msg("Failed to record the status for status --porcelain")
+	}
+}
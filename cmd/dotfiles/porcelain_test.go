@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/state"
+)
+
+// TestPorcelainFormat pins the output of status --porcelain, which shell prompts
+// parse: changing it breaks them
+func TestPorcelainFormat(t *testing.T) {
+	for _, tt := range []struct {
+		status   porcelainStatus
+		want     string
+		exitCode int
+	}{
+		{porcelainStatus{Repo: porcelainClean, Drift: 0, Behind: 0}, "clean|drift=0|behind=0", 0},
+		{porcelainStatus{Repo: porcelainClean, Drift: 3, Behind: 2}, "clean|drift=3|behind=2", 1},
+		{porcelainStatus{Repo: porcelainClean, Drift: 0, Behind: 1}, "clean|drift=0|behind=1", 1},
+		{porcelainStatus{Repo: porcelainDirty, Drift: 0, Behind: 0}, "dirty|drift=0|behind=0", 1},
+		{porcelainStatus{Repo: porcelainClean, Drift: -1, Behind: 0}, "clean|drift=?|behind=0", 0},
+		{porcelainStatus{Repo: porcelainNoGit, Drift: 5, Behind: 0}, "nogit|drift=5|behind=0", 1},
+	} {
+		if got := tt.status.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+		if got := tt.status.exitCode(); got != tt.exitCode {
+			t.Errorf("exitCode() of %s = %d, want %d", tt.want, got, tt.exitCode)
+		}
+	}
+}
+
+func TestParseGitStatusPorcelain(t *testing.T) {
+	for _, tt := range []struct {
+		name, output, repo string
+		behind             int
+	}{
+		{"clean", "# branch.oid 1a2b\n# branch.head main\n# branch.upstream origin/main\n# branch.ab +0 -0\n", porcelainClean, 0},
+		{"behind", "# branch.oid 1a2b\n# branch.head main\n# branch.upstream origin/main\n# branch.ab +1 -4\n", porcelainClean, 4},
+		{"no upstream", "# branch.oid (initial)\n# branch.head main\n", porcelainClean, 0},
+		{"modified", "# branch.head main\n1 .M N... 100644 100644 100644 1a2b 1a2b jobs/index.yaml\n", porcelainDirty, 0},
+		{"untracked", "# branch.head main\n# branch.ab +0 -2\n? notes.txt\n", porcelainDirty, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, behind := parseGitStatusPorcelain(tt.output)
+			if repo != tt.repo || behind != tt.behind {
+				t.Errorf("parseGitStatusPorcelain() = %s, %d, want %s, %d", repo, behind, tt.repo, tt.behind)
+			}
+		})
+	}
+}
+
+func TestGetPorcelainStatus(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	// The temporary directory is not a git repository, even when it is in one
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+	configPath := filepath.Join(dir, "dotfiles.yaml")
+	if err := os.WriteFile(configPath, []byte("settings:\n  create_backups: false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	previous := configFile
+	configFile = configPath
+	t.Cleanup(func() { configFile = previous })
+
+	// Before the first apply or plan the drift is unknown
+	status, err := getPorcelainStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Drift != -1 {
+		t.Errorf("drift without summary = %d, want unknown", status.Drift)
+	}
+
+	if err := state.SaveSummary(dir, &state.Summary{WrittenAt: time.Now(), Command: "plan", Drift: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = getPorcelainStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if status.String() != "nogit|drift=3|behind=0" {
+		t.Errorf("status outside a git repository = %s, want nogit|drift=3|behind=0", status)
+	}
+
+	if err := exec.Command("git", "init", "--quiet", dir).Run(); err != nil {
+		t.Skipf("git init failed: %v", err)
+	}
+	if status, err = getPorcelainStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if status.String() != "dirty|drift=3|behind=0" || status.exitCode() != porcelainExitDrift {
+		t.Errorf("status with an uncommitted dotfiles.yaml = %s, want dirty|drift=3|behind=0", status)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// declaredProfiles returns the profiles dotfiles.yaml declares in settings.profiles
+// and settings.default_profiles
+func declaredProfiles(cfg *config.Config) map[string]bool {
+	declared := make(map[string]bool)
+	if cfg.Settings == nil {
+		return declared
+	}
+	for _, profile := range append(cfg.Settings.Profiles, cfg.Settings.DefaultProfiles...) {
+		declared[profile] = true
+	}
+	return declared
+}
+
+// profileWarnings returns a warning for every profile jobs reference that is not
+// declared in dotfiles.yaml, which is most likely a typo
+func profileWarnings(declared map[string]bool, refs map[string][]string) []string {
+	var warnings []string
+	for _, profile := range sortedNames(refs) {
+		if declared[profile] {
+			continue
+		}
+		warning := fmt.Sprintf("profile '%s' used in %s is not declared in settings.profiles", profile, strings.Join(refs[profile], ", "))
+		if suggestion := closestName(profile, declared); suggestion != "" {
+			warning += fmt.Sprintf(", did you mean '%s'?", suggestion)
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+// sortedNames returns the referenced profiles or tags in alphabetical order
+func sortedNames(refs map[string][]string) []string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// closestName returns the known name that is at most two edits away from name, or ""
+// when there is none
+func closestName(name string, known map[string]bool) string {
+	best, bestDistance := "", 3
+	for candidate := range known {
+		distance := utils.EditDistance(name, candidate)
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
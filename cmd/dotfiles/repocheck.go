@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+)
+
+// maxListedChanges is how many uncommitted changes a warning lists by name
+const maxListedChanges = 5
+
+// repoCheck checks whether the dotfiles repository apply and plan run from is up
+// to date with its upstream and has no uncommitted changes to what they use
+type repoCheck struct {
+	requireClean    bool
+	requireUpToDate bool
+	noFetch         bool
+}
+
+// repoState is what the repository check found
+type repoState struct {
+	Commit  string   // Commit checked out, empty when the dotfiles are not in a git repository
+	Changed []string // Uncommitted changes to the configuration, jobs, variables and files
+}
+
+// addFlags adds the flags of the repository check to a command
+func (c *repoCheck) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&c.requireClean, "require-clean", false, "Fail when the jobs, variables or files have uncommitted changes")
+	cmd.Flags().BoolVar(&c.requireUpToDate, "require-up-to-date", false, "Fail when the dotfiles repository is behind its upstream branch")
+	cmd.Flags().BoolVar(&c.noFetch, "no-fetch", false, "Compare with the upstream branch as last fetched instead of fetching it")
+}
+
+// run warns when the dotfiles are behind their upstream branch or have uncommitted
+// changes, and returns an error instead when a --require flag asks for it
+func (c *repoCheck) run(cfg *config.Config, configPath, basePath string) (*repoState, error) {
+	state := &repoState{}
+	if !isGitRepository(basePath) {
+		if c.requireClean || c.requireUpToDate {
+			return state, fmt.Errorf("%s is not a git repository, --require-clean and --require-up-to-date need one", basePath)
+		}
+		return state, nil
+	}
+
+	git := getGitStatus(basePath, !c.noFetch && !offline)
+	state.Commit = git.Commit
+	state.Changed = changedConfiguration(git, cfg, configPath, basePath)
+
+	var warnings []string
+	if len(state.Changed) > 0 {
+		message := fmt.Sprintf("The dotfiles have uncommitted changes: %s", listFiles(state.Changed, maxListedChanges))
+		if c.requireClean {
+			return state, fmt.Errorf("%s; commit or stash them, or run without --require-clean", strings.TrimPrefix(message, "The "))
+		}
+		warnings = append(warnings, message)
+	}
+
+	switch {
+	case git.Upstream == "":
+		if c.requireUpToDate {
+			return state, fmt.Errorf("cannot tell whether the dotfiles are up to date, branch %s has no upstream branch", git.Branch)
+		}
+	case git.HasRemote && !c.noFetch && !offline && !git.CanFetch && c.requireUpToDate:
+		return state, fmt.Errorf("cannot tell whether the dotfiles are up to date, fetching %s failed; use --no-fetch to compare with the last fetch", git.Upstream)
+	case git.BehindCount > 0:
+		message := fmt.Sprintf("The dotfiles are %s behind %s", pluralize(git.BehindCount, "commit"), git.Upstream)
+		if c.requireUpToDate {
+			return state, fmt.Errorf("%s; run 'dotfiles fetch' to update them", strings.TrimPrefix(message, "The "))
+		}
+		warnings = append(warnings, message+", run 'dotfiles fetch' to update them")
+	}
+
+	if len(warnings) > 0 {
+		palette := ui.NewPalette(os.Stdout)
+		for _, warning := range warnings {
+			fmt.Printf("⚠️  %s\n", palette.Yellow(warning))
+		}
+		fmt.Println()
+	}
+	return state, nil
+}
+
+// changedConfiguration returns the uncommitted changes to the files apply uses: the
+// configuration file and the jobs, variables and files directories
+func changedConfiguration(git *GitStatus, cfg *config.Config, configPath, basePath string) []string {
+	var watched []string
+	for _, path := range []string{configPath, cfg.GetJobsPath(basePath), cfg.GetVariablesPath(basePath), cfg.GetFilesPath(basePath)} {
+		if rel, err := filepath.Rel(basePath, path); err == nil && !strings.HasPrefix(rel, "..") {
+			watched = append(watched, filepath.ToSlash(rel))
+		}
+	}
+
+	seen := make(map[string]bool)
+	var changed []string
+	for _, files := range [][]string{git.StagedFiles, git.ModifiedFiles, git.UntrackedFiles} {
+		for _, file := range files {
+			if seen[file] {
+				continue
+			}
+			for _, dir := range watched {
+				if file == dir || strings.HasPrefix(file, dir+"/") {
+					seen[file] = true
+					changed = append(changed, file)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// listFiles joins the first max files, counting the rest
+func listFiles(files []string, max int) string {
+	if len(files) <= max {
+		return strings.Join(files, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(files[:max], ", "), len(files)-max)
+}
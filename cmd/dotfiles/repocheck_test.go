@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+func TestRepoCheck(t *testing.T) {
+	globalConfig := isolateGit(t)
+	if err := os.WriteFile(globalConfig, []byte("[user]\n\tname = Jane\n\temail = jane@example.com\n[init]\n\tdefaultBranch = main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	// The temporary directories are not in a git repository, even when the tests are
+	t.Setenv("GIT_CEILING_DIRECTORIES", root)
+	previousOffline := offline
+	offline = false
+	t.Cleanup(func() { offline = previousOffline })
+
+	// A dotfiles repository tracking an upstream, and a clone that pushes to it
+	upstream := filepath.Join(root, "upstream.git")
+	runGit(t, root, "init", "--quiet", "--bare", upstream)
+	dir := filepath.Join(root, "dotfiles")
+	if err := initializeRepository(dir, &initOptions{Template: "minimal", Author: "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "init", "--quiet")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "--quiet", "-m", "Initial commit")
+	runGit(t, dir, "remote", "add", "origin", upstream)
+	runGit(t, dir, "push", "--quiet", "--set-upstream", "origin", "main")
+	other := filepath.Join(root, "other")
+	runGit(t, root, "clone", "--quiet", upstream, other)
+
+	configPath := filepath.Join(dir, "dotfiles.yaml")
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(c repoCheck) (*repoState, error) {
+		return c.run(cfg, configPath, dir)
+	}
+	strict := repoCheck{requireClean: true, requireUpToDate: true}
+
+	state, err := check(strict)
+	if err != nil {
+		t.Fatalf("check of a clean repository = %v", err)
+	}
+	if state.Commit != runGit(t, dir, "rev-parse", "HEAD") || len(state.Changed) != 0 {
+		t.Errorf("state = %+v, want the commit checked out and no changes", state)
+	}
+
+	// Only changes to the configuration, jobs, variables and files count
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("todo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := check(strict); err != nil {
+		t.Errorf("check with an untracked notes.txt = %v, want no error", err)
+	}
+	jobsIndex := filepath.Join(dir, "jobs", "index.yaml")
+	if err := os.WriteFile(jobsIndex, []byte("run_command: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "variables", "work.yaml"), []byte("work: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state, err = check(repoCheck{})
+	if err != nil {
+		t.Errorf("check without --require-clean = %v, want only a warning", err)
+	}
+	if want := []string{"jobs/index.yaml", "variables/work.yaml"}; !reflect.DeepEqual(state.Changed, want) {
+		t.Errorf("changed = %v, want %v", state.Changed, want)
+	}
+	_, err = check(repoCheck{requireClean: true})
+	if err == nil || !strings.Contains(err.Error(), "uncommitted changes: jobs/index.yaml, variables/work.yaml") {
+		t.Errorf("check with --require-clean = %v, want the uncommitted changes", err)
+	}
+	runGit(t, dir, "checkout", "--quiet", "--", "jobs/index.yaml")
+	os.Remove(filepath.Join(dir, "variables", "work.yaml"))
+
+	// Another machine pushes a commit
+	if err := os.WriteFile(filepath.Join(other, "README.md"), []byte("# Dotfiles\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, other, "add", ".")
+	runGit(t, other, "commit", "--quiet", "-m", "Add README")
+	runGit(t, other, "push", "--quiet")
+
+	// --no-fetch compares with the last fetch, which did not see it yet
+	if _, err := check(repoCheck{requireUpToDate: true, noFetch: true}); err != nil {
+		t.Errorf("check with --no-fetch = %v, want the last fetch to be up to date", err)
+	}
+	if _, err := check(repoCheck{}); err != nil {
+		t.Errorf("check without --require-up-to-date = %v, want only a warning", err)
+	}
+	_, err = check(repoCheck{requireUpToDate: true})
+	if err == nil || !strings.Contains(err.Error(), "1 commit behind origin/main") {
+		t.Errorf("check with --require-up-to-date = %v, want the commit behind", err)
+	}
+	// The commit is known once fetched
+	if _, err := check(repoCheck{requireUpToDate: true, noFetch: true}); err == nil {
+		t.Error("check with --no-fetch after a fetch = nil, want the commit behind")
+	}
+
+	// Without git the --require flags cannot be satisfied
+	plain := filepath.Join(root, "plain")
+	if err := initializeRepository(plain, &initOptions{Template: "empty"}); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := (&repoCheck{}).run(cfg, filepath.Join(plain, "dotfiles.yaml"), plain); err != nil || state.Commit != "" {
+		t.Errorf("check outside a git repository = %+v, %v, want nothing to check", state, err)
+	}
+	if _, err := strict.run(cfg, filepath.Join(plain, "dotfiles.yaml"), plain); err == nil || !strings.Contains(err.Error(), "is not a git repository") {
+		t.Errorf("check with --require-clean outside a git repository = %v, want an error", err)
+	}
+}
+
+func TestRepoCheckFlags(t *testing.T) {
+	for _, cmd := range []*cobra.Command{createApplyCommand(), createPlanCommand()} {
+		for _, flag := range []string{"require-clean", "require-up-to-date", "no-fetch"} {
+			if cmd.Flags().Lookup(flag) == nil {
+				t.Errorf("%s has no --%s flag", cmd.Name(), flag)
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/state"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ApplyReport is the machine-readable result of an apply run
+type ApplyReport struct {
+	Status     string        `json:"status" yaml:"status"` // "success", "failed" or "aborted"
+	Error      string        `json:"error,omitempty" yaml:"error,omitempty"`
+	Hostname   string        `json:"hostname,omitempty" yaml:"hostname,omitempty"` // Machine apply ran on
+	Commit     string        `json:"commit,omitempty" yaml:"commit,omitempty"`     // Commit of the dotfiles repository that was applied
+	Dirty      bool          `json:"dirty,omitempty" yaml:"dirty,omitempty"`       // Whether the jobs, variables or files had uncommitted changes
+	DryRun     bool          `json:"dry_run" yaml:"dry_run"`
+	StartedAt  time.Time     `json:"started_at" yaml:"started_at"`
+	FinishedAt time.Time     `json:"finished_at" yaml:"finished_at"`
+	DurationMs int64         `json:"duration_ms" yaml:"duration_ms"`
+	Summary    ReportSummary `json:"summary" yaml:"summary"`
+	Tasks      []*TaskReport `json:"tasks" yaml:"tasks"`
+	Handlers   []*TaskReport `json:"handlers,omitempty" yaml:"handlers,omitempty"` // Handlers notified by tasks that changed something
+
+	Timings []*state.GroupTiming `json:"timings,omitempty" yaml:"timings,omitempty"` // Time the tasks of every module and package manager took, slowest first
+}
+
+// ReportSummary holds the aggregate counts of an apply report
+type ReportSummary struct {
+	Total     int `json:"total" yaml:"total"`
+	Succeeded int `json:"succeeded" yaml:"succeeded"`
+	Skipped   int `json:"skipped" yaml:"skipped"`
+	Failed    int `json:"failed" yaml:"failed"`
+	NotRun    int `json:"not_run" yaml:"not_run"`
+
+	NeedsAttention int `json:"needs_attention" yaml:"needs_attention"` // Tasks that have to be finished by hand
+	HandlersFailed int `json:"handlers_failed" yaml:"handlers_failed"`
+}
+
+// TaskReport is the result of a single task in an apply report
+type TaskReport struct {
+	ID          string                 `json:"id" yaml:"id"`
+	Name        string                 `json:"name,omitempty" yaml:"name,omitempty"` // ID with its templates rendered, as apply shows it
+	Action      string                 `json:"action" yaml:"action"`
+	Source      string                 `json:"source,omitempty" yaml:"source,omitempty"`
+	Line        int                    `json:"line,omitempty" yaml:"line,omitempty"` // Line the task starts at in the source file
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Status      string                 `json:"status" yaml:"status"` // "success", "planned", "skipped", "failed" or "not_run"
+	Skipped     bool                   `json:"skipped" yaml:"skipped"`
+	SkipReason  string                 `json:"skip_reason,omitempty" yaml:"skip_reason,omitempty"`
+	SkipCode    modules.SkipReasonCode `json:"skip_code,omitempty" yaml:"skip_code,omitempty"`
+	Conflict    string                 `json:"conflict,omitempty" yaml:"conflict,omitempty"`   // How local changes to the target were resolved
+	Attention   string                 `json:"attention,omitempty" yaml:"attention,omitempty"` // What has to be finished by hand
+	Changes     []string               `json:"changes" yaml:"changes"`
+	DurationMs  int64                  `json:"duration_ms" yaml:"duration_ms"`
+	PlanMs      int64                  `json:"plan_ms" yaml:"plan_ms"`                 // Time spent planning, part of DurationMs
+	ExecuteMs   int64                  `json:"execute_ms" yaml:"execute_ms"`           // Time spent executing, part of DurationMs
+	Group       string                 `json:"group,omitempty" yaml:"group,omitempty"` // Module of the task, with its package manager like "packages/winget"
+	Error       string                 `json:"error,omitempty" yaml:"error,omitempty"`
+
+	Output   *modules.TaskOutput `json:"output,omitempty" yaml:"output,omitempty"`     // What the commands of the task printed
+	Attempts []string            `json:"attempts,omitempty" yaml:"attempts,omitempty"` // Errors of every failed attempt of a retried task, the last one included
+}
+
+// newApplyReport creates a report for an apply run that starts now
+func newApplyReport(dryRun bool) *ApplyReport {
+	hostname, _ := os.Hostname()
+	return &ApplyReport{
+		Status:    "success",
+		Hostname:  hostname,
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+		Tasks:     []*TaskReport{},
+	}
+}
+
+// addTask records the outcome of a task. plan may be nil when planning failed.
+func (r *ApplyReport) addTask(task *config.Task, plan *modules.TaskPlan, status string, duration time.Duration, err error) *TaskReport {
+	entry := newTaskReport(task, plan, status, duration, err)
+	r.Tasks = append(r.Tasks, entry)
+	return entry
+}
+
+// addHandler records the outcome of a handler, apart from the tasks
+func (r *ApplyReport) addHandler(handler *config.Handler, plan *modules.TaskPlan, status string, duration time.Duration, err error) {
+	r.Handlers = append(r.Handlers, newTaskReport(handler.Task, plan, status, duration, err))
+}
+
+// newTaskReport creates the report entry of a task
+func newTaskReport(task *config.Task, plan *modules.TaskPlan, status string, duration time.Duration, err error) *TaskReport {
+	entry := &TaskReport{
+		ID:         task.ID,
+		Action:     task.Action,
+		Source:     task.Source,
+		Line:       task.Line,
+		Status:     status,
+		Changes:    []string{},
+		DurationMs: duration.Milliseconds(),
+	}
+	if plan != nil {
+		entry.Description = plan.Description
+		entry.Skipped = plan.WillSkip
+		entry.SkipReason = plan.SkipReason
+		entry.SkipCode = plan.SkipCode
+		entry.Conflict = plan.Conflict
+		// Diffs are colored for the terminal, reports get them plain
+		for _, change := range plan.Changes {
+			entry.Changes = append(entry.Changes, utils.StripANSI(change))
+		}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// addResult records a task that ran, with what its commands printed. Tasks that did
+// not do what was planned, e.g. because local changes were kept or have to be merged
+// by hand, are recorded as such.
+func (r *ApplyReport) addResult(task *config.Task, plan *modules.TaskPlan, result *modules.TaskResult, duration time.Duration) *TaskReport {
+	if result.Skipped {
+		entry := r.addTask(task, plan, "skipped", duration, nil)
+		entry.Skipped = true
+		entry.SkipReason = result.Message
+		entry.setResult(result)
+		return entry
+	}
+	entry := r.addTask(task, plan, "success", duration, nil)
+	entry.setResult(result)
+	if result.NeedsAttention {
+		entry.Attention = result.Message
+	}
+	return entry
+}
+
+// setResult records what the commands of a task printed and the errors of every
+// attempt that failed, the last one included when the task failed
+func (e *TaskReport) setResult(result *modules.TaskResult) {
+	e.Output = result.Output
+	for _, err := range result.AttemptErrors {
+		e.Attempts = append(e.Attempts, err.Error())
+	}
+}
+
+// addNotRun records tasks that were never started because apply stopped early
+func (r *ApplyReport) addNotRun(tasks []*config.Task) {
+	for _, task := range tasks {
+		r.addTask(task, nil, "not_run", 0, nil)
+	}
+}
+
+// abort marks the run as stopped before all tasks ran
+func (r *ApplyReport) abort(err error) {
+	r.Status = "aborted"
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// finish computes the summary and final status of the report
+func (r *ApplyReport) finish() {
+	r.FinishedAt = time.Now()
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+
+	r.Summary = ReportSummary{Total: len(r.Tasks)}
+	for _, task := range r.Tasks {
+		switch task.Status {
+		case "success", "planned":
+			r.Summary.Succeeded++
+		case "skipped":
+			r.Summary.Skipped++
+		case "failed":
+			r.Summary.Failed++
+		case "not_run":
+			r.Summary.NotRun++
+		}
+		if task.Attention != "" {
+			r.Summary.NeedsAttention++
+		}
+	}
+
+	for _, handler := range r.Handlers {
+		if handler.Status == "failed" {
+			r.Summary.HandlersFailed++
+		}
+	}
+
+	r.Timings = state.GroupTimings(r.taskTimings())
+
+	if r.Status == "success" && r.Summary.Failed+r.Summary.HandlersFailed > 0 {
+		r.Status = "failed"
+	}
+}
+
+// validateReportFormat checks the value of --report-format
+func validateReportFormat(format string) error {
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("invalid report format '%s', expected 'json' or 'yaml'", format)
+	}
+	return nil
+}
+
+// encode encodes the report in the given format
+func (r *ApplyReport) encode(format string) ([]byte, error) {
+	var data bytes.Buffer
+	var err error
+	switch format {
+	case "yaml":
+		err = yaml.NewEncoder(&data).Encode(r)
+	default:
+		encoder := json.NewEncoder(&data)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode report: %w", err)
+	}
+	return data.Bytes(), nil
+}
+
+// write finishes the report and writes it to path in the given format
+func (r *ApplyReport) write(path, format string) error {
+	r.finish()
+
+	data, err := r.encode(format)
+	if err != nil {
+		return err
+	}
+
+	path, err = utils.ExpandPath(path)
+	if err != nil {
+		return fmt.Errorf("failed to expand report path: %w", err)
+	}
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/journal"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+
+	"github.com/spf13/cobra"
+)
+
+// createRollbackCommand creates the rollback command
+func createRollbackCommand() *cobra.Command {
+	var (
+		discard bool
+		wait    time.Duration
+	)
+
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Undo the file changes of an apply that did not finish",
+		Long: `Restore the files changed by an apply run with --rollback-on-failure that
+did not finish, e.g. because the process crashed or was killed.
+
+apply rolls back by itself when a job fails. This command finishes the job from
+the journal it keeps in the state directory (settings.state_dir). Package
+installations and commands are listed but cannot be undone automatically.
+
+Use --discard to delete the journal without restoring anything. Use --wait to wait
+for a running apply to finish.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+			basePath := filepath.Dir(configPath)
+
+			// The configuration sets where the journal and the lock are kept
+			// (settings.state_dir), a broken one must not keep the files from being restored
+			if _, err := config.Load(configPath); err != nil {
+				log.Warn().Err(err).Msg("Failed to load configuration, using the default state directory")
+			}
+
+			// Only one run may change the dotfiles at a time. os.Exit skips deferred
+			// calls, fail releases the lock first.
+			runLock, err := acquireLock(basePath, "rollback", wait)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to lock the dotfiles")
+				os.Exit(1)
+			}
+			defer releaseLock(runLock)
+			fail := func() {
+				releaseLock(runLock)
+				os.Exit(1)
+			}
+
+			txn, err := journal.Load(basePath)
+			if errors.Is(err, journal.ErrNoJournal) {
+				log.Info().Msg("Nothing to roll back")
+				return
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load rollback journal")
+				fail()
+			}
+
+			if discard {
+				if err := txn.Discard(); err != nil {
+					log.Error().Err(err).Msg("Failed to discard rollback journal")
+					fail()
+				}
+				fmt.Printf("🗑️  Discarded the journal of the apply started at %s\n", txn.StartedAt.Format("2006-01-02 15:04:05"))
+				return
+			}
+
+			fmt.Printf("↩️  Rolling back the apply started at %s\n\n", txn.StartedAt.Format("2006-01-02 15:04:05"))
+			if !printRollbackResult(txn.Rollback()) {
+				fail()
+			}
+		},
+	}
+
+	rollbackCmd.Flags().BoolVar(&discard, "discard", false, "Delete the journal without restoring anything")
+	rollbackCmd.Flags().DurationVar(&wait, "wait", 0, "Wait up to this long for another run holding the lock to finish (e.g. 5m)")
+
+	return rollbackCmd
+}
+
+// beginRollbackJournal starts the journal of an apply run with --rollback-on-failure.
+// A journal left behind by an earlier apply has to be rolled back or discarded first.
+func beginRollbackJournal(basePath string) (*journal.Journal, error) {
+	if journal.Exists(basePath) {
+		return nil, fmt.Errorf("an earlier apply did not finish, run 'dotfiles rollback' to restore its changes or 'dotfiles rollback --discard' to keep them")
+	}
+	return journal.Begin(basePath, time.Now())
+}
+
+// journalTask records the previous state of everything a task is about to change.
+// Tasks that change more than files are noted so they can be reported after a rollback.
+func journalTask(txn *journal.Journal, registry *modules.ModuleRegistry, task *config.Task, ctx *modules.ExecutionContext, displayName string) error {
+	targets, ok, err := registry.TaskTargets(task, ctx)
+	if err != nil {
+		return fmt.Errorf("failed to determine what the task changes: %w", err)
+	}
+	if !ok {
+		return txn.RecordIrreversible(task.ID, fmt.Sprintf("%s (%s)", displayName, task.Action))
+	}
+	return txn.Record(task.ID, targets...)
+}
+
+// printRollbackResult shows what a rollback restored and what has to be undone by
+// hand. It returns false when some paths could not be restored.
+func printRollbackResult(result *journal.RollbackResult) bool {
+	for _, path := range result.Restored {
+		fmt.Printf("   ↩️  Restored %s\n", path)
+	}
+	for _, err := range result.Errors {
+		fmt.Printf("   ❌ Could not restore %v\n", err)
+	}
+	if len(result.Restored) == 0 && len(result.Errors) == 0 {
+		fmt.Printf("   No files were changed\n")
+	}
+
+	if len(result.Irreversible) > 0 {
+		fmt.Printf("\n⚠️  These jobs cannot be rolled back automatically:\n")
+		for _, task := range result.Irreversible {
+			fmt.Printf("   - %s\n", task.Description)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		fmt.Printf("\nThe journal was kept, run 'dotfiles rollback' to try again\n")
+		return false
+	}
+	fmt.Println()
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/schema"
+
+	"github.com/spf13/cobra"
+)
+
+// createSchemaCommand creates the schema command
+func createSchemaCommand() *cobra.Command {
+	schemaCmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print JSON Schemas of the configuration files",
+		Long: `Print JSON Schema documents of the configuration files, for editors with a YAML
+language server and for checks in CI.
+
+The schema of jobs files is generated from the actions of the modules and their
+documented parameters, the same ones dotfiles explain shows. The output only
+changes when dotfiles does, so it can be committed to the repository.`,
+		Example: `  dotfiles schema jobs > jobs.schema.json
+  dotfiles schema variables > variables.schema.json`,
+	}
+
+	schemaCmd.AddCommand(&cobra.Command{
+		Use:   "jobs",
+		Short: "Print the JSON Schema of jobs/index.yaml and the jobs files it imports",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to register modules")
+				os.Exit(1)
+			}
+			if err := schema.Encode(os.Stdout, schema.Jobs(registeredActions(registry))); err != nil {
+				log.Error().Err(err).Msg("Failed to write the schema")
+				os.Exit(1)
+			}
+		},
+	})
+
+	schemaCmd.AddCommand(&cobra.Command{
+		Use:   "variables",
+		Short: "Print the JSON Schema of variables/index.yaml",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := schema.Encode(os.Stdout, schema.Variables()); err != nil {
+				logger.Get().Error().Err(err).Msg("Failed to write the schema")
+				os.Exit(1)
+			}
+		},
+	})
+
+	return schemaCmd
+}
+
+// registeredActions returns the documentation of every action of the registry
+func registeredActions(registry *modules.ModuleRegistry) []*modules.ActionDocumentation {
+	var actions []*modules.ActionDocumentation
+	for _, moduleActions := range registry.ListAllActions() {
+		actions = append(actions, moduleActions...)
+	}
+	return actions
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/schema"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestInitMatchesSchema checks the files dotfiles init creates against the schemas
+// dotfiles schema prints, so the samples never show what the parser rejects
+func TestInitMatchesSchema(t *testing.T) {
+	registry, err := newModuleRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas := map[string]*schema.Schema{
+		"jobs":      schema.Jobs(registeredActions(registry)),
+		"variables": schema.Variables(),
+	}
+
+	for _, template := range []string{"full", "minimal", "empty"} {
+		t.Run(template, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := &initOptions{
+				Template:        template,
+				Name:            "dotfiles",
+				Platforms:       []string{"linux", "darwin", "windows"},
+				SampleTemplates: true,
+			}
+			if err := initializeRepository(dir, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, file := range []struct{ path, schema string }{
+				{"jobs/index.yaml", "jobs"},
+				{"variables/index.yaml", "variables"},
+			} {
+				data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file.path)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var document interface{}
+				if err := yaml.Unmarshal(data, &document); err != nil {
+					t.Fatalf("%s: %v", file.path, err)
+				}
+				if document == nil {
+					continue // Only comments
+				}
+				for _, problem := range schemas[file.schema].Validate(document) {
+					t.Errorf("%s: %s", file.path, problem)
+				}
+			}
+		})
+	}
+}
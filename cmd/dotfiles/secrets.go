@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// createSecretsCommand creates the secrets command with subcommands
+func createSecretsCommand() *cobra.Command {
+	secretsCmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Encrypt and decrypt variable files",
+		Long: `Manage encrypted variable files.
+
+Variable files ending in .enc.yaml are decrypted transparently when variables
+are loaded, so machine-specific tokens can be committed to the repository.
+They are decrypted with the age identity file in settings.age_identity, or with
+the passphrase in the DOTFILES_PASSPHRASE environment variable.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	secretsCmd.AddCommand(createSecretsEncryptCommand())
+	secretsCmd.AddCommand(createSecretsDecryptCommand())
+
+	return secretsCmd
+}
+
+// createSecretsEncryptCommand creates the secrets encrypt subcommand
+func createSecretsEncryptCommand() *cobra.Command {
+	var (
+		output string
+		remove bool
+	)
+
+	encryptCmd := &cobra.Command{
+		Use:   "encrypt <file>",
+		Short: "Encrypt a variable file",
+		Long: `Encrypt a plaintext variable file into its .enc.yaml counterpart.
+
+The file is encrypted to the age identity in settings.age_identity, or with the
+passphrase in DOTFILES_PASSPHRASE when no identity is configured. Import the
+.enc.yaml file from variables/index.yaml like any other variable file.`,
+		Example: `  dotfiles secrets encrypt variables/tokens.yaml
+  DOTFILES_PASSPHRASE=... dotfiles secrets encrypt variables/tokens.yaml --remove`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+			input := args[0]
+
+			if config.IsEncryptedVariableFile(input) {
+				log.Error().Str("file", input).Msg("File is already encrypted")
+				os.Exit(1)
+			}
+
+			cipher := loadVariableCipher()
+
+			plaintext, err := os.ReadFile(input)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to read variable file")
+				os.Exit(1)
+			}
+
+			// Catch mistakes while the file can still be read
+			var variables map[string]interface{}
+			if err := yaml.Unmarshal(plaintext, &variables); err != nil {
+				log.Error().Err(err).Str("file", input).Msg("File is not a valid variable file")
+				os.Exit(1)
+			}
+
+			ciphertext, err := cipher.Encrypt(plaintext)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to encrypt variable file")
+				os.Exit(1)
+			}
+
+			if output == "" {
+				output = config.EncryptedVariablesPath(input)
+			}
+			if err := os.WriteFile(output, ciphertext, 0644); err != nil {
+				log.Error().Err(err).Msg("Failed to write encrypted file")
+				os.Exit(1)
+			}
+			fmt.Printf("🔒 Encrypted %s to %s\n", input, output)
+
+			if remove {
+				if err := os.Remove(input); err != nil {
+					log.Error().Err(err).Msg("Failed to remove plaintext file")
+					os.Exit(1)
+				}
+				fmt.Printf("🗑️  Removed %s\n", input)
+			} else {
+				fmt.Printf("   Remember not to commit the plaintext %s\n", input)
+			}
+		},
+	}
+
+	encryptCmd.Flags().StringVarP(&output, "output", "o", "", "Write the encrypted file here instead of <file>.enc.yaml")
+	encryptCmd.Flags().BoolVar(&remove, "remove", false, "Remove the plaintext file after encrypting it")
+
+	return encryptCmd
+}
+
+// createSecretsDecryptCommand creates the secrets decrypt subcommand
+func createSecretsDecryptCommand() *cobra.Command {
+	var (
+		output string
+		force  bool
+	)
+
+	decryptCmd := &cobra.Command{
+		Use:   "decrypt <file>",
+		Short: "Decrypt an encrypted variable file",
+		Long: `Decrypt an .enc.yaml variable file and print its content.
+
+Use --output to write the plaintext to a file instead, e.g. to edit it and
+encrypt it again. The plaintext file is only readable by you.`,
+		Example: `  dotfiles secrets decrypt variables/tokens.enc.yaml
+  dotfiles secrets decrypt variables/tokens.enc.yaml -o variables/tokens.yaml`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+			input := args[0]
+
+			cipher := loadVariableCipher()
+
+			plaintext, err := cipher.DecryptFile(input)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to decrypt variable file")
+				os.Exit(1)
+			}
+
+			if output == "" || output == "-" {
+				os.Stdout.Write(plaintext)
+				return
+			}
+
+			if utils.FileExists(output) && !force {
+				log.Error().Str("file", output).Msg("Output file already exists, use --force to overwrite it")
+				os.Exit(1)
+			}
+			if err := os.WriteFile(output, plaintext, 0600); err != nil {
+				log.Error().Err(err).Msg("Failed to write decrypted file")
+				os.Exit(1)
+			}
+			fmt.Printf("🔓 Decrypted %s to %s\n", input, output)
+		},
+	}
+
+	decryptCmd.Flags().StringVarP(&output, "output", "o", "", "Write the plaintext to this file instead of stdout")
+	decryptCmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite the output file if it exists")
+
+	return decryptCmd
+}
+
+// loadVariableCipher creates the cipher for the current dotfiles repository. The
+// passphrase alone is enough outside of a repository.
+func loadVariableCipher() *config.VariableCipher {
+	log := logger.Get()
+
+	cfg, basePath := &config.Config{}, ""
+	if configPath, err := findConfigFile(); err == nil {
+		if cfg, err = config.Load(configPath); err != nil {
+			log.Error().Err(err).Msg("Failed to load configuration")
+			os.Exit(1)
+		}
+		basePath = filepath.Dir(configPath)
+	}
+
+	cipher, err := config.NewVariableCipher(cfg, basePath)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up encryption")
+		os.Exit(1)
+	}
+	if cipher.IdentityPath == "" && cipher.Passphrase == "" {
+		log.Error().Msgf("No encryption method configured: set settings.age_identity in dotfiles.yaml or the %s environment variable", config.PassphraseEnvVar)
+		os.Exit(1)
+	}
+	return cipher
+}
@@ -9,8 +9,12 @@ import (
 	"time"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/lock"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/state"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
 	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 
 	"github.com/spf13/cobra"
@@ -20,6 +24,8 @@ import (
 type GitStatus struct {
 	IsRepo           bool
 	Branch           string
+	Upstream         string // Branch the current branch tracks, e.g. origin/main
+	Commit           string // Commit checked out
 	IsClean          bool
 	ModifiedFiles    []string
 	UntrackedFiles   []string
@@ -38,17 +44,23 @@ type ConfigStatus struct {
 	ManagedFiles     int
 	TemplateFiles    int
 	LastApplied      time.Time
+	LastApply        *state.Timings // How long the last apply took, nil when none recorded it
+	InProgress       *lock.Info // Run holding the lock, nil when nothing changes the dotfiles
 	ValidSymlinks    int
 	BrokenSymlinks   int
 	MissingSymlinks  int
+	OutdatedSymlinks int
+	Drift            *DriftStatus
+	RemoteImports    []*config.RemoteImportStatus
 }
 
 // createStatusCommand creates the status command
 func createStatusCommand() *cobra.Command {
 	var (
-		verbose  bool
-		jsonOut  bool
-		noFetch  bool
+		verbose   bool
+		jsonOut   bool
+		noFetch   bool
+		porcelain bool
 	)
 
 	statusCmd := &cobra.Command{
@@ -59,16 +71,47 @@ func createStatusCommand() *cobra.Command {
 - Git repository status and remote changes
 - Configuration file status and health
 - System integration status
-- Template and symlink health check
-
-Use --verbose for detailed output, --json for machine-readable format.`,
+- Drift of managed files and symlinks against the configured jobs
+- Cached remote imports that are behind their upstream ref
+
+Use --verbose for detailed output, --json for machine-readable format.
+
+Use --porcelain in shell prompts: it prints a single line in a format that stays
+the same across releases and returns within milliseconds, because it does not fetch
+and reads the drift found by the last apply or plan instead of checking the files:
+
+  clean|drift=3|behind=2
+
+The first field is clean, dirty when the dotfiles repository has uncommitted
+changes, or nogit when it is not a git repository. drift is the number of tasks the
+last apply or plan found to change, ? when neither ran yet. behind is the number of
+commits the repository is behind its upstream branch as last fetched. The exit code
+is 0 when everything is clean, 1 when anything is not and 2 on errors, which print
+nothing on stdout.`,
+		Example: `  dotfiles status
+  dotfiles status --porcelain
+  PS1='$(dotfiles status --porcelain >/dev/null || echo "* ")\$ '`,
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 
+			if porcelain {
+				status, err := getPorcelainStatus()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "dotfiles status: %v\n", err)
+					os.Exit(porcelainExitError)
+				}
+				fmt.Println(status)
+				os.Exit(status.exitCode())
+			}
+
 			// Find dotfiles root directory by locating config file
-			configPath, err := config.FindConfigFile()
+			configPath, err := findConfigFile()
 			var dotfilesDir string
-			if err != nil {
+			if _, isNotFound := config.IsConfigNotFoundError(err); err != nil && !isNotFound {
+				// A file given with --config or DOTFILES_CONFIG that is missing
+				handleConfigFileError(err)
+				os.Exit(1)
+			} else if err != nil {
 				// If no config found, fall back to current directory
 				dotfilesDir, err = os.Getwd()
 				if err != nil {
@@ -88,10 +131,17 @@ Use --verbose for detailed output, --json for machine-readable format.`,
 			}
 
 			// Get Git status
-			gitStatus := getGitStatus(dotfilesDir, !noFetch)
+			gitStatus := getGitStatus(dotfilesDir, !noFetch && !offline)
+
+			// Status compares remote imports with upstream instead of updating them
+			config.ConfigureRemoteImports(true)
 
 			// Get configuration status
 			configStatus := getConfigStatus(dotfilesDir)
+			configStatus.RemoteImports, err = config.RemoteImportStatuses(dotfilesDir, !noFetch && !offline)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to check remote imports")
+			}
 
 			// Output results
 			if jsonOut {
@@ -105,6 +155,9 @@ Use --verbose for detailed output, --json for machine-readable format.`,
 	statusCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show detailed status information")
 	statusCmd.Flags().BoolVar(&jsonOut, "json", false, "Output status in JSON format")
 	statusCmd.Flags().BoolVar(&noFetch, "no-fetch", false, "Skip fetching remote changes")
+	statusCmd.Flags().BoolVar(&porcelain, "porcelain", false, "Print a single stable line for shell prompts, without fetching or checking files")
+	statusCmd.MarkFlagsMutuallyExclusive("porcelain", "json")
+	statusCmd.MarkFlagsMutuallyExclusive("porcelain", "verbose")
 
 	return statusCmd
 }
@@ -125,6 +178,7 @@ func getGitStatus(dir string, shouldFetch bool) *GitStatus {
 	if branch := getGitBranch(dir); branch != "" {
 		status.Branch = branch
 	}
+	status.Commit = getGitCommit(dir)
 
 	// Check if we have a remote
 	status.HasRemote = hasGitRemote(dir)
@@ -135,11 +189,10 @@ func getGitStatus(dir string, shouldFetch bool) *GitStatus {
 	status.StagedFiles = getStagedFiles(dir)
 	status.IsClean = len(status.ModifiedFiles) == 0 && len(status.UntrackedFiles) == 0 && len(status.StagedFiles) == 0
 
-	// Get ahead/behind counts
 	if status.HasRemote {
-		status.AheadCount, status.BehindCount = getAheadBehindCount(dir)
+		status.Upstream = getGitUpstream(dir)
 
-		// Fetch remote changes if requested
+		// Fetch remote changes if requested, before counting the commits behind
 		if shouldFetch {
 			status.CanFetch = true
 			if err := fetchGitChanges(dir); err != nil {
@@ -150,6 +203,9 @@ func getGitStatus(dir string, shouldFetch bool) *GitStatus {
 				status.RemoteCommits = getRemoteCommits(dir, 5)
 			}
 		}
+
+		// Get ahead/behind counts
+		status.AheadCount, status.BehindCount = getAheadBehindCount(dir)
 	}
 
 	return status
@@ -194,13 +250,28 @@ func getConfigStatus(dotfilesDir string) *ConfigStatus {
 	// Count managed files and templates
 	status.ManagedFiles, status.TemplateFiles = countManagedFiles(cfg, baseDir)
 
-	// Check symlink health
-	status.ValidSymlinks, status.BrokenSymlinks, status.MissingSymlinks = checkSymlinkHealth(cfg, baseDir)
+	// Compare managed files and symlinks with the configured jobs
+	status.Drift = getDriftStatus(cfg, baseDir)
+	status.ValidSymlinks, status.BrokenSymlinks, status.MissingSymlinks, status.OutdatedSymlinks = status.Drift.symlinkCounts()
+
+	// Get last applied time from the marker apply writes, older versions kept it
+	// in the repository
+	for _, marker := range []string{
+		filepath.Join(config.StateDir(dotfilesDir), config.LastAppliedFile),
+		filepath.Join(dotfilesDir, config.LegacyLastAppliedFile),
+	} {
+		if stat, err := os.Stat(marker); err == nil {
+			status.LastApplied = stat.ModTime()
+			break
+		}
+	}
+
+	if timings, err := state.LoadTimings(dotfilesDir); err == nil {
+		status.LastApply = timings
+	}
 
-	// Get last applied time (check for .dotfiles-last-applied file)
-	lastAppliedPath := filepath.Join(dotfilesDir, ".dotfiles-last-applied")
-	if stat, err := os.Stat(lastAppliedPath); err == nil {
-		status.LastApplied = stat.ModTime()
+	if holder, held := lock.Held(dotfilesDir); held {
+		status.InProgress = holder
 	}
 
 	return status
@@ -209,11 +280,11 @@ func getConfigStatus(dotfilesDir string) *ConfigStatus {
 // outputStatusText outputs status in human-readable format
 func outputStatusText(git *GitStatus, cfg *ConfigStatus, platform *platform.PlatformInfo, verbose bool) {
 	if verbose {
-		fmt.Println("Dotfiles Status (Detailed)")
-		fmt.Println("─────────────────────────")
+		ui.Println("Dotfiles Status (Detailed)")
+		ui.Println("─────────────────────────")
 	} else {
-		fmt.Println("Dotfiles Status")
-		fmt.Println("──────────────")
+		ui.Println("Dotfiles Status")
+		ui.Println("──────────────")
 	}
 
 	// Configuration Status
@@ -224,21 +295,76 @@ func outputStatusText(git *GitStatus, cfg *ConfigStatus, platform *platform.Plat
 		}
 
 		symlinkStr := ""
-		if cfg.ValidSymlinks > 0 || cfg.BrokenSymlinks > 0 {
+		if cfg.ValidSymlinks > 0 || cfg.BrokenSymlinks > 0 || cfg.MissingSymlinks > 0 || cfg.OutdatedSymlinks > 0 {
 			symlinkStr = fmt.Sprintf(" (%d symlinks", cfg.ValidSymlinks)
 			if cfg.BrokenSymlinks > 0 {
 				symlinkStr += fmt.Sprintf(", %d broken", cfg.BrokenSymlinks)
 			}
+			if cfg.MissingSymlinks > 0 {
+				symlinkStr += fmt.Sprintf(", %d missing", cfg.MissingSymlinks)
+			}
+			if cfg.OutdatedSymlinks > 0 {
+				symlinkStr += fmt.Sprintf(", %d out of date", cfg.OutdatedSymlinks)
+			}
 			symlinkStr += ")"
 		}
 
-		fmt.Printf("📁 Configuration: %s%s\n", filesStr, symlinkStr)
+		ui.Printf("📁 Configuration: %s%s\n", filesStr, symlinkStr)
+
+		if cfg.InProgress != nil {
+			ui.Printf("⏳ In progress: %s\n", cfg.InProgress)
+		}
 
 		if verbose && !cfg.LastApplied.IsZero() {
-			fmt.Printf("  └── Last applied: %s\n", cfg.LastApplied.Format("2006-01-02 15:04 MST"))
+			ui.Printf("  └── Last applied: %s\n", cfg.LastApplied.Format("2006-01-02 15:04 MST"))
+		}
+		if verbose && cfg.LastApply != nil {
+			ui.Printf("  └── Last apply took %s (%s)\n", formatTaskDuration(cfg.LastApply.Duration()), cfg.LastApply.StartedAt.Format("2006-01-02 15:04 MST"))
+			for _, task := range cfg.LastApply.Slowest {
+				ui.Printf("      ├── %s %s (%s)\n", formatTaskDuration(time.Duration(task.DurationMs)*time.Millisecond), task.Name, task.Group)
+			}
+		}
+
+		if drift := cfg.Drift; drift != nil {
+			if drift.Checked {
+				driftStr := fmt.Sprintf("%d in sync, %d out of date, %d missing", drift.InSync, drift.OutOfDate, drift.Missing)
+				if drift.Unknown > 0 {
+					driftStr += fmt.Sprintf(", %d unknown", drift.Unknown)
+				}
+				if drift.Failed > 0 {
+					driftStr += fmt.Sprintf(", %d failed", drift.Failed)
+				}
+				ui.Printf("📝 Drift: %s\n", driftStr)
+			} else {
+				ui.Printf("📝 Drift: unavailable (%s)\n", drift.Error)
+			}
 		}
 	} else {
-		fmt.Println("📁 Configuration: No configuration found")
+		ui.Println("📁 Configuration: No configuration found")
+	}
+
+	if len(cfg.RemoteImports) > 0 {
+		behind := 0
+		for _, remote := range cfg.RemoteImports {
+			if remote.Behind {
+				behind++
+			}
+		}
+		ui.Printf("📥 Remote imports: %d cached, %d behind upstream\n", len(cfg.RemoteImports), behind)
+		for _, remote := range cfg.RemoteImports {
+			name := remote.Repo
+			if remote.Ref != "" {
+				name += "@" + remote.Ref
+			}
+			switch {
+			case remote.Error != "":
+				ui.Printf("  └── %s: %s\n", name, remote.Error)
+			case remote.Behind:
+				ui.Printf("  └── %s is behind upstream, the next apply updates it\n", name)
+			case verbose:
+				ui.Printf("  └── %s at %s\n", name, shortCommit(remote.Commit))
+			}
+		}
 	}
 
 	// Git Status
@@ -255,18 +381,18 @@ func outputStatusText(git *GitStatus, cfg *ConfigStatus, platform *platform.Plat
 			gitDetails += fmt.Sprintf(", %d commit(s) ahead", git.AheadCount)
 		}
 
-		fmt.Printf("🔄 Git: %s\n", gitDetails)
+		ui.Printf("🔄 Git: %s\n", gitDetails)
 
 		// Remote status
 		if git.HasRemote {
 			if git.BehindCount > 0 {
-				fmt.Printf("⚡ Remote: %d new commits available\n", git.BehindCount)
+				ui.Printf("⚡ Remote: %d new commits available\n", git.BehindCount)
 			} else if git.CanFetch {
-				fmt.Println("⚡ Remote: Up to date")
+				ui.Println("⚡ Remote: Up to date")
 			}
 		}
 	} else {
-		fmt.Println("🔄 Git: Not a git repository")
+		ui.Println("🔄 Git: Not a git repository")
 	}
 
 	// System Integration
@@ -274,18 +400,18 @@ func outputStatusText(git *GitStatus, cfg *ConfigStatus, platform *platform.Plat
 	if len(platform.PackageManagers) > 0 {
 		integrationStr += fmt.Sprintf(", %s", strings.Join(platform.PackageManagers, ", "))
 	}
-	fmt.Printf("🔧 Integration: %s\n", integrationStr)
+	ui.Printf("🔧 Integration: %s\n", integrationStr)
 
 	// Show modified files if any
 	if !git.IsClean && len(git.ModifiedFiles) > 0 {
 		fmt.Println()
-		fmt.Println("Modified Files:")
+		ui.Println("Modified Files:")
 		for _, file := range git.ModifiedFiles {
 			fileType := getFileType(file)
 			if fileType != "" {
-				fmt.Printf("  M %s\n    └── %s\n", file, fileType)
+				ui.Printf("  M %s\n    └── %s\n", file, fileType)
 			} else {
-				fmt.Printf("  M %s\n", file)
+				ui.Printf("  M %s\n", file)
 			}
 		}
 	}
@@ -295,34 +421,78 @@ func outputStatusText(git *GitStatus, cfg *ConfigStatus, platform *platform.Plat
 		if len(git.ModifiedFiles) == 0 {
 			fmt.Println()
 		}
-		fmt.Println("Untracked Files:")
+		ui.Println("Untracked Files:")
 		for _, file := range git.UntrackedFiles {
-			fmt.Printf("  ? %s\n", file)
+			ui.Printf("  ? %s\n", file)
 		}
 	}
 
 	// Show remote commits if available (verbose mode)
 	if verbose && len(git.RemoteCommits) > 0 {
 		fmt.Println()
-		fmt.Println("🔄 Recent Remote Commits:")
+		ui.Println("🔄 Recent Remote Commits:")
 		for _, commit := range git.RemoteCommits {
-			fmt.Printf("  ├── %s\n", commit)
+			ui.Printf("  ├── %s\n", commit)
+		}
+	}
+
+	// Show drifted files (verbose mode)
+	if verbose && cfg.Drift != nil {
+		if drifted := cfg.Drift.drifted(); len(drifted) > 0 {
+			fmt.Println()
+			ui.Println("Drifted Files:")
+			for _, entry := range drifted {
+				state := strings.ReplaceAll(entry.State, "_", " ")
+				if entry.Error != "" {
+					state += ": " + entry.Error
+				}
+				ui.Printf("  %s %s\n    └── %s (%s)\n", driftMarker(entry.State), entry.Path, state, entry.Action)
+			}
 		}
 	}
 
 	// Show broken symlinks if any
 	if cfg.BrokenSymlinks > 0 {
 		fmt.Println()
-		fmt.Printf("⚠️  %d broken symlinks detected\n", cfg.BrokenSymlinks)
+		ui.Printf("⚠️  %d broken symlinks detected\n", cfg.BrokenSymlinks)
+	}
+}
+
+// shortCommit abbreviates a commit hash for display
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// driftMarker returns the short marker shown in front of a drifted file
+func driftMarker(state string) string {
+	switch modules.DriftState(state) {
+	case modules.DriftMissing:
+		return "-"
+	case modules.DriftOutOfDate:
+		return "~"
+	case modules.DriftBroken:
+		return "!"
+	default:
+		return "?"
 	}
 }
 
 // outputStatusJSON outputs status in JSON format
 func outputStatusJSON(git *GitStatus, cfg *ConfigStatus, platform *platform.PlatformInfo) {
+	remoteImports := cfg.RemoteImports
+	if remoteImports == nil {
+		remoteImports = []*config.RemoteImportStatus{}
+	}
+
 	status := map[string]interface{}{
 		"git": map[string]interface{}{
 			"is_repo":         git.IsRepo,
 			"branch":          git.Branch,
+			"upstream":        git.Upstream,
+			"commit":          git.Commit,
 			"is_clean":        git.IsClean,
 			"modified_files":  git.ModifiedFiles,
 			"untracked_files": git.UntrackedFiles,
@@ -333,15 +503,20 @@ func outputStatusJSON(git *GitStatus, cfg *ConfigStatus, platform *platform.Plat
 			"has_remote":      git.HasRemote,
 		},
 		"config": map[string]interface{}{
-			"exists":           cfg.ConfigExists,
-			"path":             cfg.ConfigPath,
-			"managed_files":    cfg.ManagedFiles,
-			"template_files":   cfg.TemplateFiles,
-			"last_applied":     cfg.LastApplied,
-			"valid_symlinks":   cfg.ValidSymlinks,
-			"broken_symlinks":  cfg.BrokenSymlinks,
-			"missing_symlinks": cfg.MissingSymlinks,
+			"exists":            cfg.ConfigExists,
+			"path":              cfg.ConfigPath,
+			"managed_files":     cfg.ManagedFiles,
+			"template_files":    cfg.TemplateFiles,
+			"last_applied":      cfg.LastApplied,
+			"last_apply":        cfg.LastApply,
+			"in_progress":       cfg.InProgress,
+			"valid_symlinks":    cfg.ValidSymlinks,
+			"broken_symlinks":   cfg.BrokenSymlinks,
+			"missing_symlinks":  cfg.MissingSymlinks,
+			"outdated_symlinks": cfg.OutdatedSymlinks,
 		},
+		"drift":          driftJSON(cfg.Drift),
+		"remote_imports": remoteImports,
 		"platform": map[string]interface{}{
 			"os":               platform.OS,
 			"arch":             platform.Arch,
@@ -354,6 +529,24 @@ func outputStatusJSON(git *GitStatus, cfg *ConfigStatus, platform *platform.Plat
 	fmt.Println(utils.ToJSONString(status))
 }
 
+// driftJSON returns the "drift" section of the JSON status output
+func driftJSON(drift *DriftStatus) map[string]interface{} {
+	if drift == nil {
+		drift = &DriftStatus{Files: []*FileDrift{}, Symlinks: []*FileDrift{}}
+	}
+	return map[string]interface{}{
+		"checked":     drift.Checked,
+		"error":       drift.Error,
+		"in_sync":     drift.InSync,
+		"missing":     drift.Missing,
+		"out_of_date": drift.OutOfDate,
+		"unknown":     drift.Unknown,
+		"failed":      drift.Failed,
+		"files":       drift.Files,
+		"symlinks":    drift.Symlinks,
+	}
+}
+
 // Git helper functions
 func getGitBranch(dir string) string {
 	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
@@ -365,6 +558,28 @@ func getGitBranch(dir string) string {
 	return strings.TrimSpace(string(output))
 }
 
+// getGitUpstream returns the branch the current branch tracks, empty when it tracks none
+func getGitUpstream(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "@{upstream}")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// getGitCommit returns the hash of the commit checked out, empty when there is none yet
+func getGitCommit(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
 func hasGitRemote(dir string) bool {
 	cmd := exec.Command("git", "remote")
 	cmd.Dir = dir
@@ -497,32 +712,3 @@ func countManagedFiles(cfg *config.Config, baseDir string) (managedFiles, templa
 
 	return managedFiles, templateFiles
 }
-
-func checkSymlinkHealth(cfg *config.Config, baseDir string) (valid, broken, missing int) {
-	// This is a simplified check - in a real implementation, you'd want to
-	// parse the jobs configuration to find actual symlink targets
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return 0, 0, 0
-	}
-
-	// Check common dotfile locations for symlinks
-	commonDotfiles := []string{".gitconfig", ".zshrc", ".bashrc", ".vimrc", ".tmux.conf"}
-
-	for _, dotfile := range commonDotfiles {
-		path := filepath.Join(homeDir, dotfile)
-		if info, err := os.Lstat(path); err == nil {
-			if info.Mode()&os.ModeSymlink != 0 {
-				// It's a symlink, check if it's valid
-				if _, err := os.Stat(path); err == nil {
-					valid++
-				} else {
-					broken++
-				}
-			}
-		}
-	}
-
-	return valid, broken, missing
-}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+)
+
+// taskSelection is how apply and plan narrow down the tasks besides conditions
+type taskSelection struct {
+	Profiles []string // Profiles whose tasks run
+	Tags     []string // Only tasks with one of these tags run, empty for all
+	SkipTags []string // Tasks with one of these tags are skipped
+}
+
+// print shows which profiles and tags apply or plan runs with
+func (s *taskSelection) print() {
+	if len(s.Profiles) > 0 {
+		ui.Printf("🏷️  Profiles: %s\n", strings.Join(s.Profiles, ", "))
+	}
+	if len(s.Tags) > 0 {
+		ui.Printf("🔖 Tags: %s\n", strings.Join(s.Tags, ", "))
+	}
+	if len(s.SkipTags) > 0 {
+		ui.Printf("⏭️  Skipping tags: %s\n", strings.Join(s.SkipTags, ", "))
+	}
+	if len(s.Profiles) > 0 || len(s.Tags) > 0 || len(s.SkipTags) > 0 {
+		fmt.Println()
+	}
+}
+
+// filterTasksByTags applies --tags and --skip-tags to tasks that passed their
+// conditions. Tags no task in the jobs uses are reported, since they are most likely
+// typos, and selecting tags no task matches is an error.
+func filterTasksByTags(jobsIndexPath string, variables map[string]interface{}, tasksList []*config.Task, selection *taskSelection) ([]*config.Task, error) {
+	if len(selection.Tags) == 0 && len(selection.SkipTags) == 0 {
+		return tasksList, nil
+	}
+
+	tagRefs, err := jobs.CollectTags(jobsIndexPath, variables)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for tag := range tagRefs {
+		known[tag] = true
+	}
+	for _, warning := range tagWarnings(append(selection.Tags, selection.SkipTags...), known) {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
+
+	filtered := jobs.FilterTasksByTags(tasksList, selection.Tags, selection.SkipTags)
+	if len(filtered) == 0 && len(selection.Tags) > 0 {
+		if len(known) == 0 {
+			return nil, fmt.Errorf("no tasks match tags %s, no task has tags", strings.Join(selection.Tags, ", "))
+		}
+		return nil, fmt.Errorf("no tasks match tags %s (known tags: %s)", strings.Join(selection.Tags, ", "), strings.Join(sortedNames(tagRefs), ", "))
+	}
+	return filtered, nil
+}
+
+// tagWarnings returns a warning for every tag that no task uses
+func tagWarnings(tags []string, known map[string]bool) []string {
+	var warnings []string
+	for _, tag := range tags {
+		if known[tag] {
+			continue
+		}
+		warning := fmt.Sprintf("tag '%s' is not used by any task", tag)
+		if suggestion := closestName(tag, known); suggestion != "" {
+			warning += fmt.Sprintf(", did you mean '%s'?", suggestion)
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
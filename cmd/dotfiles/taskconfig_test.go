@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// wrongYAMLValues are values YAML typos turn fields into, like `name: 1.24`,
+// `check_system_wide: "no"` or an unquoted {{ template }}
+var wrongYAMLValues = []interface{}{
+	1.24,
+	42,
+	true,
+	"no",
+	nil,
+	[]interface{}{1.24, []interface{}{"nested"}},
+	map[string]interface{}{"name": nil},
+}
+
+// TestWrongTypedConfigsFailWithoutPanics gives every documented parameter of every
+// action values of the wrong type, which must fail the task instead of crashing
+func TestWrongTypedConfigsFailWithoutPanics(t *testing.T) {
+	// Examples put files in the home directory
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", t.TempDir())
+
+	registry, err := newModuleRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &modules.ExecutionContext{
+		Context:   context.Background(),
+		BasePath:  t.TempDir(),
+		Variables: map[string]interface{}{},
+		DryRun:    true,
+		Offline:   true,
+	}
+
+	actions := registry.GetSupportedActions()
+	sort.Strings(actions)
+	for _, action := range actions {
+		t.Run(action, func(t *testing.T) {
+			module, err := registry.GetModuleByAction(action)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := module.ExplainAction(action)
+			if err != nil {
+				t.Fatal(err)
+			}
+			example := map[string]interface{}{}
+			if len(doc.Examples) > 0 {
+				example = doc.Examples[0].Config
+			}
+
+			typeErrors := 0
+			for _, parameter := range doc.Parameters {
+				for _, value := range wrongYAMLValues {
+					cfg := make(map[string]interface{}, len(example)+1)
+					for key, exampleValue := range example {
+						cfg[key] = exampleValue
+					}
+					cfg[parameter.Name] = value
+					task := &config.Task{
+						ID:     fmt.Sprintf("%s: %s=%v", action, parameter.Name, value),
+						Action: action,
+						Source: "jobs/index.yaml",
+						Line:   1,
+						Config: cfg,
+					}
+
+					var typeErr *modules.ConfigTypeError
+					err := noPanic(t, task, "ValidateTask", func() error { return registry.ValidateTask(task) })
+					// Modules plan tasks apply did not validate, without the registry
+					// turning panics into errors
+					noPanic(t, task, "PlanTask", func() error { _, err := module.PlanTask(task, ctx); return err })
+					noPanic(t, task, "DesiredTargets", func() error { _, err := registry.DesiredTargets(task, ctx); return err })
+					noPanic(t, task, "PreflightChecks", func() error { _, err := registry.PreflightChecks(task, ctx); return err })
+					if !errors.As(err, &typeErr) {
+						continue
+					}
+
+					// Tasks with a config of the wrong type fail before the module runs
+					typeErrors++
+					if err := noPanic(t, task, "registry PlanTask", func() error { _, err := registry.PlanTask(task, ctx); return err }); err == nil {
+						t.Errorf("PlanTask() of %s succeeded, want %v", task.ID, typeErr)
+					}
+					if err := noPanic(t, task, "ExecuteTask", func() error { _, err := registry.ExecuteTask(task, ctx); return err }); err == nil {
+						t.Errorf("ExecuteTask() of %s succeeded, want %v", task.ID, typeErr)
+					}
+				}
+			}
+			if typeErrors == 0 {
+				t.Errorf("no wrong-typed config of %s failed the type check", action)
+			}
+		})
+	}
+}
+
+// noPanic calls fn and fails the test when it panics
+func noPanic(t *testing.T, task *config.Task, name string, fn func() error) (err error) {
+	t.Helper()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			t.Errorf("%s() of %s panicked: %v", name, task.ID, recovered)
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return fn()
+}
+
+// TestScalarConfigsValidate checks that validate accepts the scalars the modules
+// apply as the strings they were written as, and reports the rest by their value
+func TestScalarConfigsValidate(t *testing.T) {
+	registry, err := newModuleRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		action string
+		config map[string]interface{}
+		want   string // Part of the error, none when the task is valid
+	}{
+		{"ensure_file", map[string]interface{}{"path": "~/.answer", "content": 12}, ""},
+		{"line_in_file", map[string]interface{}{"path": "~/.bashrc", "line": 12, "state": "present"}, ""},
+		{"line_in_file", map[string]interface{}{"path": "~/.bashrc", "line": "export A=1", "state": 1}, "must be 'present' or 'absent'"},
+		{"ensure_env", map[string]interface{}{"name": "ANSWER", "value": "42", "profiles": "bash"}, ""},
+	}
+	for _, tt := range tests {
+		task := &config.Task{ID: fmt.Sprintf("%s %v", tt.action, tt.config), Action: tt.action, Config: tt.config}
+		err := registry.ValidateTask(task)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("ValidateTask(%s) = %v, want no error", task.ID, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("ValidateTask(%s) = %v, want %q", task.ID, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// createTemplatesCommand creates the templates command with subcommands
+func createTemplatesCommand() *cobra.Command {
+	templatesCmd := &cobra.Command{
+		Use:   "templates",
+		Short: "Inspect the templates of your dotfiles",
+		Long: `Inspect the templates rendered by your jobs and the template files in the
+files directory.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	templatesCmd.AddCommand(createTemplatesCheckCommand())
+	templatesCmd.AddCommand(createTemplatesRenderCommand())
+
+	return templatesCmd
+}
+
+// templateCheck is a template checked by templates check and the problems found in it
+type templateCheck struct {
+	Location string
+	Issues   []*templating.TemplateIssue
+}
+
+// createTemplatesCheckCommand creates the templates check subcommand
+func createTemplatesCheckCommand() *cobra.Command {
+	var (
+		platform    string
+		shell       string
+		environment []string
+		verbose     bool
+	)
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check templates for syntax errors and undefined variables",
+		Long: `Parse every template without applying anything and report syntax errors and
+references to variables that do not exist, with the nearest existing variable.
+
+Checked are the inline content of jobs, content_source files and ensure_tree
+sources rendered with render: true, and every .tmpl file in the files directory.
+Sources with render: false are copied as-is and are not parsed. Templates no job
+on this machine renders are only checked for syntax, since the variables they
+read may only exist on other machines.
+
+References in if conditions or with a default filter are reported as warnings,
+since that is how templates handle optional variables. The command exits with a
+non-zero status on any error, so it can run as a pre-commit hook.`,
+		Example: `  dotfiles templates check
+  dotfiles templates check --platform windows`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{
+				Platform:    platform,
+				Shell:       shell,
+				Environment: parseEnvironmentVariables(environment),
+			})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			// Templates of every profile are checked, not only the ones this machine selects
+			jobsIndexPath := cfg.GetJobsIndexPath(basePath)
+			profileRefs, err := jobs.CollectProfiles(jobsIndexPath, variables)
+			var tasksList []*config.Task
+			if err == nil {
+				tasksList, err = jobs.LoadJobsFromFileWithConditions(jobsIndexPath, variables, sortedNames(profileRefs))
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load jobs")
+				os.Exit(1)
+			}
+
+			registry, err := newModuleRegistry()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create module registry")
+				os.Exit(1)
+			}
+
+			fmt.Printf("🔍 Checking templates...\n\n")
+
+			checks := checkTemplates(registry, tasksList, cfg, basePath, variables)
+
+			errorCount, warningCount, failedCount := 0, 0, 0
+			for _, check := range checks {
+				if len(check.Issues) == 0 {
+					if verbose {
+						fmt.Printf("   ✅ %s\n", check.Location)
+					}
+					continue
+				}
+				failed := false
+				fmt.Printf("   📄 %s\n", check.Location)
+				for _, issue := range check.Issues {
+					if issue.Warning {
+						fmt.Printf("      ⚠️  %s\n", issue)
+						warningCount++
+					} else {
+						fmt.Printf("      ❌ %s\n", issue)
+						errorCount++
+						failed = true
+					}
+				}
+				if failed {
+					failedCount++
+				}
+			}
+
+			if errorCount > 0 {
+				fmt.Printf("\n❌ %s in %s\n", pluralize(errorCount, "error"), pluralize(failedCount, "template"))
+				os.Exit(1)
+			}
+			if warningCount > 0 {
+				fmt.Printf("\n✅ Checked %s, %s\n", pluralize(len(checks), "template"), pluralize(warningCount, "warning"))
+				return
+			}
+			fmt.Printf("✅ Checked %s, no problems found\n", pluralize(len(checks), "template"))
+		},
+	}
+
+	checkCmd.Flags().StringVar(&platform, "platform", "", "Override platform detection (windows, linux, darwin)")
+	checkCmd.Flags().StringVar(&shell, "shell", "", "Override shell detection (bash, zsh, powershell)")
+	checkCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
+	checkCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "List templates without problems too")
+
+	return checkCmd
+}
+
+// checkTemplates checks the templates jobs render with the variables and the other
+// template files in the files directory for syntax only
+func checkTemplates(registry *modules.ModuleRegistry, tasksList []*config.Task, cfg *config.Config, basePath string, variables map[string]interface{}) []*templateCheck {
+	engine := templating.NewTemplatingEngine(basePath)
+	ctx := &modules.ExecutionContext{
+		BasePath:     basePath,
+		Variables:    variables,
+		DryRun:       true,
+		TemplatesDir: cfg.GetTemplatesPath(basePath),
+	}
+
+	defaultSource := cfg.GetJobsIndexPath(basePath)
+
+	var checks []*templateCheck
+	checked := make(map[string]bool)
+	var rawPaths []string
+
+	for _, task := range tasksList {
+		sources, err := registry.TaskTemplates(task, ctx)
+		if err != nil {
+			checks = append(checks, &templateCheck{
+				Location: taskLocation(task, defaultSource, basePath, ""),
+				Issues:   []*templating.TemplateIssue{{Message: err.Error()}},
+			})
+			continue
+		}
+
+		for _, source := range sources {
+			if !source.Render {
+				if source.Path != "" {
+					rawPaths = append(rawPaths, source.Path)
+				}
+				continue
+			}
+			if source.Path == "" {
+				checks = append(checks, &templateCheck{
+					Location: taskLocation(task, defaultSource, basePath, source.Field),
+					Issues:   engine.CheckTemplate(source.Content, variables),
+				})
+				continue
+			}
+			if checked[source.Path] {
+				continue
+			}
+			checked[source.Path] = true
+			if source.SearchPath != nil {
+				checks = append(checks, &templateCheck{
+					Location: relativeToBase(source.Path, basePath),
+					Issues:   engine.CheckTemplateFile(source.Path, source.SearchPath, variables),
+				})
+				continue
+			}
+			checks = append(checks, checkTemplateFile(engine, source.Path, basePath, variables))
+		}
+	}
+
+	// Template files no job renders here may be rendered on other machines
+	filesPath := cfg.GetFilesPath(basePath)
+	filepath.WalkDir(filesPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".tmpl" {
+			return nil
+		}
+		if checked[path] || isRawSource(path, rawPaths) {
+			return nil
+		}
+		checks = append(checks, checkTemplateFile(engine, path, basePath, nil))
+		return nil
+	})
+
+	sort.SliceStable(checks, func(i, j int) bool {
+		return checks[i].Location < checks[j].Location
+	})
+	return checks
+}
+
+// checkTemplateFile checks a template file, reading variables when they are not nil
+func checkTemplateFile(engine *templating.TemplatingEngine, path, basePath string, variables map[string]interface{}) *templateCheck {
+	check := &templateCheck{Location: relativeToBase(path, basePath)}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		check.Issues = []*templating.TemplateIssue{{Message: fmt.Sprintf("failed to read template: %v", err)}}
+		return check
+	}
+	check.Issues = engine.CheckTemplate(string(content), variables)
+	return check
+}
+
+// taskLocation describes an inline template of a task, or the task itself when field is empty
+func taskLocation(task *config.Task, defaultSource, basePath, field string) string {
+	source := task.Location()
+	if source == "" {
+		source = defaultSource
+	}
+	location := fmt.Sprintf("%s (%s '%s'", relativeToBase(source, basePath), task.Action, task.ID)
+	if field != "" {
+		location += " " + field
+	}
+	return location + ")"
+}
+
+// isRawSource reports whether path is, or is inside, a source that is copied or linked as-is
+func isRawSource(path string, rawPaths []string) bool {
+	for _, raw := range rawPaths {
+		if path == raw || strings.HasPrefix(path, raw+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeToBase shows a path relative to the dotfiles directory when it is inside it
+func relativeToBase(path, basePath string) string {
+	if rel, err := filepath.Rel(basePath, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// createTemplatesRenderCommand creates the templates render subcommand
+func createTemplatesRenderCommand() *cobra.Command {
+	var (
+		platform    string
+		shell       string
+		hostname    string
+		environment []string
+		vars        []string
+		rawVars     string
+		out         string
+	)
+
+	renderCmd := &cobra.Command{
+		Use:   "render <path>",
+		Short: "Render a single template with your variables",
+		Long: `Render a template file the way ensure_file with render: true does and write the
+result to stdout, or to a file with --out, without applying anything.
+
+Relative paths that do not exist in the current directory are looked up in the
+dotfiles directory, so content_source paths from jobs work as-is.
+
+Variables are loaded as usual, with --platform, --shell, --hostname and --env
+overriding detection. --raw-vars merges a YAML file of variables on top of them
+and --var sets single variables last; dotted keys set nested variables and values
+are parsed as YAML, so --var editor.tab_width=4 sets a number.
+
+Template errors are printed with the line they occur on. References to undefined
+variables, which render as empty text, are reported as warnings.`,
+		Example: `  dotfiles templates render files/templates/gitconfig.tmpl
+  dotfiles templates render files/templates/ssh/config.tmpl --platform darwin --var user.email=me@work.com
+  dotfiles templates render files/templates/app.json.tmpl --raw-vars /tmp/test-vars.yaml --out /tmp/app.json`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			basePath := filepath.Dir(configPath)
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{
+				Platform:    platform,
+				Shell:       shell,
+				Hostname:    hostname,
+				Environment: parseEnvironmentVariables(environment),
+			})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			if rawVars != "" {
+				if err := mergeRawVariables(variables, rawVars); err != nil {
+					log.Error().Err(err).Msg("Failed to load raw variables")
+					os.Exit(1)
+				}
+			}
+			for _, assignment := range vars {
+				key, value, err := parseVariableAssignment(assignment)
+				if err != nil {
+					log.Error().Err(err).Msg("Invalid --var")
+					os.Exit(1)
+				}
+				vloader.SetVariable(key, value, variables)
+			}
+
+			templatePath := resolveTemplatePath(args[0], basePath)
+			content, err := os.ReadFile(templatePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to read template")
+				os.Exit(1)
+			}
+
+			engine := templating.NewTemplatingEngine(basePath)
+			name := relativeToBase(templatePath, basePath)
+			result, err := engine.ProcessNamedTemplate(string(content), name, variables)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				os.Exit(1)
+			}
+
+			lines := strings.Split(string(content), "\n")
+			for _, issue := range engine.CheckTemplate(string(content), variables) {
+				fmt.Fprintf(os.Stderr, "⚠️  %s: %s\n", name, issue)
+				if issue.Line > 0 && issue.Line <= len(lines) {
+					fmt.Fprintf(os.Stderr, "   %4d | %s\n", issue.Line, strings.TrimRight(lines[issue.Line-1], "\r"))
+				}
+			}
+
+			if out == "" || out == "-" {
+				fmt.Print(result)
+				return
+			}
+			if err := os.WriteFile(out, []byte(result), 0644); err != nil {
+				log.Error().Err(err).Msg("Failed to write rendered template")
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "✅ Rendered %s to %s\n", name, out)
+		},
+	}
+
+	renderCmd.Flags().StringVar(&platform, "platform", "", "Override platform detection (windows, linux, darwin)")
+	renderCmd.Flags().StringVar(&shell, "shell", "", "Override shell detection (bash, zsh, powershell)")
+	renderCmd.Flags().StringVar(&hostname, "hostname", "", "Override hostname detection")
+	renderCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
+	renderCmd.Flags().StringArrayVar(&vars, "var", nil, "Set a variable (key=value, dotted keys set nested variables)")
+	renderCmd.Flags().StringVar(&rawVars, "raw-vars", "", "YAML file of variables to merge on top of the loaded variables")
+	renderCmd.Flags().StringVarP(&out, "out", "o", "-", "File to write the result to, - for stdout")
+
+	return renderCmd
+}
+
+// resolveTemplatePath returns path when it exists, or the path relative to the
+// dotfiles directory when only that exists
+func resolveTemplatePath(path, basePath string) string {
+	if _, err := os.Stat(path); err == nil || filepath.IsAbs(path) {
+		return path
+	}
+	if inBase := filepath.Join(basePath, path); utils.FileExists(inBase) {
+		return inBase
+	}
+	return path
+}
+
+// parseVariableAssignment parses a --var key=value flag, reading the value as YAML
+// so numbers, booleans and lists keep their type
+func parseVariableAssignment(assignment string) (string, interface{}, error) {
+	key, raw, found := strings.Cut(assignment, "=")
+	if !found || strings.TrimSpace(key) == "" {
+		return "", nil, fmt.Errorf("expected key=value, got %q", assignment)
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil || value == nil {
+		return key, raw, nil
+	}
+	return key, value, nil
+}
+
+// mergeRawVariables merges the variables of a YAML file into variables, replacing
+// values that exist and merging maps key by key
+func mergeRawVariables(variables map[string]interface{}, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	overlayVariables(variables, raw)
+	return nil
+}
+
+// overlayVariables deep merges overlay into variables
+func overlayVariables(variables, overlay map[string]interface{}) {
+	for key, value := range overlay {
+		nested, isMap := value.(map[string]interface{})
+		existing, existingIsMap := variables[key].(map[string]interface{})
+		if isMap && existingIsMap {
+			overlayVariables(existing, nested)
+			continue
+		}
+		variables[key] = value
+	}
+}
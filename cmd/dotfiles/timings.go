@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/state"
+)
+
+// timingGroup returns what the time of a task is added up under: the module that
+// runs it, with the package manager it runs like "packages/winget"
+func timingGroup(registry *modules.ModuleRegistry, task *config.Task, plan *modules.TaskPlan) string {
+	group := task.Action
+	if module, err := registry.GetModuleByAction(task.Action); err == nil {
+		group = module.Name()
+	}
+	if plan != nil && plan.Manager != "" {
+		group += "/" + plan.Manager
+	}
+	return group
+}
+
+// setTiming records how long a task shown as displayName took to plan and to
+// execute, 0 when it was not executed
+func (e *TaskReport) setTiming(displayName, group string, plan, execute time.Duration) {
+	e.Name = displayName
+	e.Group = group
+	e.PlanMs = plan.Milliseconds()
+	e.ExecuteMs = execute.Milliseconds()
+}
+
+// taskTimings returns the timings of the tasks that ran
+func (r *ApplyReport) taskTimings() []*state.TaskTiming {
+	var timings []*state.TaskTiming
+	for _, task := range r.Tasks {
+		if task.Status == "not_run" {
+			continue
+		}
+		source := task.Source
+		if task.Line > 0 {
+			source = fmt.Sprintf("%s:%d", task.Source, task.Line)
+		}
+		timings = append(timings, &state.TaskTiming{
+			ID:         task.ID,
+			Name:       task.Name,
+			Action:     task.Action,
+			Group:      task.Group,
+			DurationMs: task.DurationMs,
+			PlanMs:     task.PlanMs,
+			ExecuteMs:  task.ExecuteMs,
+			Status:     task.Status,
+			Source:     source,
+		})
+	}
+	return timings
+}
+
+// timings returns how long the run took so far and its slowest tasks
+func (r *ApplyReport) timings() *state.Timings {
+	return state.NewTimings(r.StartedAt, time.Since(r.StartedAt), r.taskTimings())
+}
+
+// formatTaskDuration formats a duration to the second, or to the millisecond when
+// it is shorter than a second
+func formatTaskDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return "<1ms"
+	}
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// printTaskTiming prints how long a task took to plan and execute, for --verbose
+func printTaskTiming(plan, execute time.Duration, executed bool) {
+	if executed {
+		fmt.Printf("   ⏱️  Planned in %s, executed in %s\n", formatTaskDuration(plan), formatTaskDuration(execute))
+	} else {
+		fmt.Printf("   ⏱️  Planned in %s\n", formatTaskDuration(plan))
+	}
+}
+
+// printSlowestTasks prints the slowest tasks and the time per module and package
+// manager of an apply that ran longer than threshold
+func printSlowestTasks(timings *state.Timings, threshold time.Duration) {
+	if timings.Duration() <= threshold || len(timings.Slowest) == 0 {
+		return
+	}
+
+	fmt.Printf("\n⏱️  Top %d slowest tasks (apply took %s):\n", len(timings.Slowest), formatTaskDuration(timings.Duration()))
+	for i, task := range timings.Slowest {
+		fmt.Printf("   %d. %-10s %s (%s)\n", i+1, formatTaskDuration(time.Duration(task.DurationMs)*time.Millisecond), task.Name, task.Group)
+	}
+
+	fmt.Printf("   Time per module:\n")
+	for _, group := range timings.Groups {
+		fmt.Printf("      %s: %s across %d tasks\n", group.Group, formatTaskDuration(time.Duration(group.DurationMs)*time.Millisecond), group.Tasks)
+	}
+	fmt.Println()
+}
+
+// saveApplyTimings records how long an apply and its slowest tasks took for
+// dotfiles status --verbose
+func saveApplyTimings(basePath string, timings *state.Timings) {
+	if err := state.SaveTimings(basePath, timings); err != nil {
+		logger.Get().Warn().Err(err).Msg("Failed to record how long apply took")
+	}
+}
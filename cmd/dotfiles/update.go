@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/selfupdate"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+
+	"github.com/spf13/cobra"
+)
+
+// installPackage is the package go install builds dotfiles from
+const installPackage = "github.com/vleeuwenmenno/dotfiles-cp/cmd/dotfiles"
+
+// createUpdateCommand creates the update command
+func createUpdateCommand() *cobra.Command {
+	var (
+		checkOnly     bool
+		targetVersion string
+	)
+
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update dotfiles manager to the latest version",
+		Long: `Update the dotfiles manager to the latest release on GitHub, or to the release
+given with --version. The release binary for this platform is downloaded,
+verified against the SHA256SUMS of the release and replaces the running binary.
+When a release has no binary for this platform, dotfiles is built with
+'go install' instead, which requires Go.
+
+Use --check to only check for updates without installing. Set GITHUB_TOKEN when
+GitHub limits the requests of your address.`,
+		Example: `  dotfiles update
+  dotfiles update --check
+  dotfiles update --version v1.4.0`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			if offline {
+				log.Error().Msg("Cannot update with --offline, updating downloads the release from GitHub")
+				os.Exit(1)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			updater := selfupdate.NewUpdater()
+			release, err := updater.Release(ctx, targetVersion)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to check for updates")
+				os.Exit(1)
+			}
+
+			newer := selfupdate.IsNewer(release.Tag, version)
+			if checkOnly {
+				fmt.Printf("Current version: %s\n", version)
+				fmt.Printf("Latest version:  %s\n", release.Tag)
+				if newer {
+					fmt.Println("An update is available, run 'dotfiles update' to install it")
+				} else {
+					fmt.Println("dotfiles is up to date")
+				}
+				return
+			}
+			if targetVersion == "" && !newer {
+				log.Info().Str("version", version).Msg("dotfiles is already up to date")
+				return
+			}
+
+			if ui.IsTerminal(os.Stdout) {
+				updater.Progress = os.Stdout
+			}
+			data, err := updater.Download(ctx, release, runtime.GOOS, runtime.GOARCH)
+			if errors.Is(err, selfupdate.ErrNoAsset) {
+				log.Warn().Str("platform", runtime.GOOS+"/"+runtime.GOARCH).Msgf("Release %s has no binary for this platform, building it with go install instead", release.Tag)
+				goInstall(release.Tag)
+				return
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to download the update")
+				os.Exit(1)
+			}
+
+			executable, err := selfupdate.Executable()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to update dotfiles manager")
+				os.Exit(1)
+			}
+			if err := selfupdate.Replace(executable, data); err != nil {
+				log.Error().Err(err).Msg("Failed to update dotfiles manager")
+				os.Exit(1)
+			}
+
+			log.Info().Str("from", version).Str("to", release.Tag).Str("path", executable).Msg("Update completed successfully!")
+			printRestartHint()
+		},
+	}
+
+	updateCmd.Flags().BoolVar(&checkOnly, "check", false, "Only check for updates without installing")
+	updateCmd.Flags().StringVar(&targetVersion, "version", "", "Install this release instead of the latest, e.g. v1.4.0")
+
+	return updateCmd
+}
+
+// goInstall builds and installs release tag of dotfiles with go install, for
+// platforms releases have no binary for
+func goInstall(tag string) {
+	log := logger.Get()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		log.Error().Msg("Go is not installed, install it from https://go.dev/dl/ or download dotfiles from https://github.com/vleeuwenmenno/dotfiles-cp/releases")
+		os.Exit(1)
+	}
+
+	installCmd := exec.Command("go", "install", installPackage+"@"+tag)
+	installCmd.Stdout = os.Stdout
+	installCmd.Stderr = os.Stderr
+	if err := installCmd.Run(); err != nil {
+		log.Error().Err(err).Msg("Failed to update dotfiles manager")
+		log.Info().Msg("Make sure Go is installed and you have internet connectivity")
+		os.Exit(1)
+	}
+
+	log.Info().Str("to", tag).Msg("Update completed successfully!")
+	printRestartHint()
+}
+
+// printRestartHint tells how to make the shell run the new binary
+func printRestartHint() {
+	log := logger.Get()
+	if runtime.GOOS == "windows" {
+		log.Info().Msg("Restart your terminal to use the new version")
+		return
+	}
+	log.Info().Msg("You may need to restart your shell or run 'hash -r' to use the new version")
+}
@@ -1,17 +1,31 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/commands"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/env"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/files"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/fonts"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/services"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/ssh"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/symlinks"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 
 	"github.com/spf13/cobra"
 )
@@ -23,6 +37,8 @@ func createValidateCommand() *cobra.Command {
 		shell       string
 		environment []string
 		verbose     bool
+		strict      bool
+		format      string
 	)
 
 	validateCmd := &cobra.Command{
@@ -31,14 +47,59 @@ func createValidateCommand() *cobra.Command {
 		Long: `Validate the dotfiles configuration by checking:
 - Configuration file syntax and structure
 - Variable definitions and merging (including conflict detection)
-- Job definitions and imports
+- Job definitions and imports, including profiles that are not declared and
+  imports whose path references variables that are not defined
 - Template syntax and variable references
 - Module action validation
 
+Imports whose path does not resolve are warnings, use --strict or set
+settings.strict_imports to make them errors.
+
+With --format json the errors and warnings are written to stdout as a JSON list
+of diagnostics with their file, line, severity, action and message, for editors
+and CI annotations. The checks are then reported on stderr.
+
 This command performs all validation checks without making any changes to your system.`,
+		Example: `  dotfiles validate
+  dotfiles validate --strict
+  dotfiles validate --format json`,
 		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			if format != "text" && format != "json" {
+				log.Error().Str("format", format).Msg("Unsupported format, use text or json")
+				os.Exit(1)
+			}
+
+			// The diagnostics are the only thing written to stdout in JSON
+			stdout := os.Stdout
+			if format == "json" {
+				os.Stdout = os.Stderr
+			}
+
+			var diagnostics []validationIssue
+			report := func(severity string, issues ...validationIssue) {
+				for _, issue := range issues {
+					issue.Severity = severity
+					diagnostics = append(diagnostics, issue)
+				}
+			}
+			// finish writes the diagnostics for --format json and exits when validation failed
+			finish := func(failed bool) {
+				if format == "json" {
+					if err := writeDiagnostics(stdout, diagnostics); err != nil {
+						log.Error().Err(err).Msg("Failed to write the diagnostics")
+						os.Exit(1)
+					}
+				}
+				if failed {
+					os.Exit(1)
+				}
+			}
+
 			errorCount := 0
 			checkCount := 0
+			fileCount := 0
 
 			fmt.Printf("🔍 Validating dotfiles configuration...\n\n")
 
@@ -49,11 +110,13 @@ This command performs all validation checks without making any changes to your s
 			configPath, err := findConfigFile()
 			if err != nil {
 				fmt.Printf("   ❌ Failed to find configuration file: %v\n", err)
+				report(severityError, validationIssue{Message: fmt.Sprintf("failed to find configuration file: %v", err)})
 				errorCount++
 			} else {
 				cfg, err := config.Load(configPath)
 				if err != nil {
 					fmt.Printf("   ❌ Failed to load configuration: %v\n", err)
+					report(severityError, validationIssue{Source: filepath.Base(configPath), Message: fmt.Sprintf("failed to load configuration: %v", err)})
 					errorCount++
 				} else {
 					fmt.Printf("   ✅ Configuration file loaded successfully\n")
@@ -61,6 +124,7 @@ This command performs all validation checks without making any changes to your s
 					// Validate configuration structure
 					if err := cfg.Validate(); err != nil {
 						fmt.Printf("   ❌ Configuration validation failed: %v\n", err)
+						report(severityError, validationIssue{Source: filepath.Base(configPath), Message: fmt.Sprintf("configuration validation failed: %v", err)})
 						errorCount++
 					} else {
 						fmt.Printf("   ✅ Configuration structure is valid\n")
@@ -70,11 +134,16 @@ This command performs all validation checks without making any changes to your s
 
 			if errorCount > 0 {
 				fmt.Printf("\n❌ Cannot continue validation due to configuration errors\n")
-				os.Exit(1)
+				finish(true)
 			}
 
 			basePath := filepath.Dir(configPath)
 			cfg, _ := config.Load(configPath) // We know this works from above
+			if strict {
+				config.ConfigureStrictImports(true)
+			}
+			reportedImports := 0
+			variablesIndex := relativeSource(basePath, cfg.GetVariablesIndexPath(basePath))
 
 			// 2. Validate variables
 			fmt.Printf("\n📊 Checking variables...\n")
@@ -83,6 +152,7 @@ This command performs all validation checks without making any changes to your s
 			vloader, err := config.NewVariableLoader(cfg, basePath)
 			if err != nil {
 				fmt.Printf("   ❌ Failed to create variable loader: %v\n", err)
+				report(severityError, validationIssue{Source: variablesIndex, Message: fmt.Sprintf("failed to create variable loader: %v", err)})
 				errorCount++
 			} else {
 				// Create variable load options
@@ -98,13 +168,16 @@ This command performs all validation checks without making any changes to your s
 					if conflictErr, isConflict := config.IsVariableConflictError(err); isConflict {
 						fmt.Printf("   ❌ Variable validation failed:\n")
 						fmt.Print(conflictErr.PrettyPrint())
+						report(severityError, validationIssue{Source: relativeSource(basePath, conflictErr.NewSource), Message: conflictErr.Error()})
 					} else {
 						fmt.Printf("   ❌ Variable validation failed: %v\n", err)
+						report(severityError, errorIssue(err, variablesIndex))
 					}
 					errorCount++
 				} else {
 					fmt.Printf("   ✅ Variables loaded and merged successfully\n")
 					fmt.Printf("   ℹ️  Loaded %d variables\n", len(variables))
+					reportedImports = printUnresolvedImports(reportedImports, report)
 
 					// Show variable sources summary
 					sources := vloader.GetVariableSources()
@@ -118,6 +191,31 @@ This command performs all validation checks without making any changes to your s
 							fmt.Printf("      - %s: %d variables\n", file, count)
 						}
 					}
+
+					// Check variables against variables/schema.yaml when there is one
+					schemaIssues, err := vloader.ValidateVariables(variables)
+					if err != nil {
+						fmt.Printf("   ❌ %v\n", err)
+						report(severityError, validationIssue{Source: relativeSource(basePath, cfg.GetVariablesSchemaPath(basePath)), Message: err.Error()})
+						errorCount++
+					} else {
+						schemaErrors := 0
+						for _, issue := range schemaIssues {
+							diagnostic := validationIssue{Source: issue.Source, Line: issue.Line, Message: fmt.Sprintf("%s: %s", issue.Key, issue.Message)}
+							if issue.Warning {
+								fmt.Printf("   ⚠️  %s\n", issue)
+								report(severityWarning, diagnostic)
+								continue
+							}
+							fmt.Printf("   ❌ %s\n", issue)
+							report(severityError, diagnostic)
+							schemaErrors++
+						}
+						if schemaErrors == 0 && utils.FileExists(cfg.GetVariablesSchemaPath(basePath)) {
+							fmt.Printf("   ✅ Variables match the schema\n")
+						}
+						errorCount += schemaErrors
+					}
 				}
 			}
 
@@ -132,14 +230,23 @@ This command performs all validation checks without making any changes to your s
 					Environment: parseEnvironmentVariables(environment),
 				})
 
+				// Jobs of every profile are validated, not only the ones this machine selects
 				jobsIndexPath := cfg.GetJobsIndexPath(basePath)
-				tasksList, err := jobs.LoadJobsFromFileWithConditions(jobsIndexPath, variables)
+				defaultSource := relativeSource(basePath, jobsIndexPath)
+				profileRefs, err := jobs.CollectProfiles(jobsIndexPath, variables)
+				var tasksList []*config.Task
+				var handlers []*config.Handler
+				if err == nil {
+					tasksList, handlers, err = jobs.LoadJobsAndHandlers(jobsIndexPath, variables, sortedNames(profileRefs))
+				}
 				if err != nil {
 					fmt.Printf("   ❌ Job validation failed: %v\n", err)
+					report(severityError, errorIssue(err, defaultSource))
 					errorCount++
 				} else {
 					fmt.Printf("   ✅ Jobs loaded successfully\n")
 					fmt.Printf("   ℹ️  Loaded %d jobs (after condition filtering)\n", len(tasksList))
+					reportedImports = printUnresolvedImports(reportedImports, report)
 
 					// Group jobs by source
 					jobSources := make(map[string]int)
@@ -157,6 +264,34 @@ This command performs all validation checks without making any changes to your s
 						}
 					}
 
+					if len(handlers) > 0 {
+						names := make([]string, 0, len(handlers))
+						for _, handler := range handlers {
+							names = append(names, handler.Name)
+						}
+						fmt.Printf("   ℹ️  Handlers: %s\n", strings.Join(names, ", "))
+					}
+
+					// Profiles only exist by being referenced, so a typo silently creates a new one
+					if declared := declaredProfiles(cfg); len(declared) > 0 {
+						for _, warning := range profileWarnings(declared, profileRefs) {
+							fmt.Printf("   ⚠️  %s\n", warning)
+							report(severityWarning, validationIssue{Message: warning})
+						}
+					} else if len(profileRefs) > 0 {
+						fmt.Printf("   ℹ️  Profiles: %s (declare them in settings.profiles to catch typos)\n", strings.Join(sortedNames(profileRefs), ", "))
+					}
+
+					// Tags are listed so the names for --tags and --skip-tags are at hand
+					if tagRefs, err := jobs.CollectTags(jobsIndexPath, variables); err == nil && len(tagRefs) > 0 {
+						fmt.Printf("   ℹ️  Tags: %s\n", strings.Join(sortedNames(tagRefs), ", "))
+						if verbose {
+							for _, tag := range sortedNames(tagRefs) {
+								fmt.Printf("      - %s: %s\n", tag, strings.Join(tagRefs[tag], ", "))
+							}
+						}
+					}
+
 					// 4. Validate individual job configurations
 					fmt.Printf("\n🎯 Checking job configurations...\n")
 					checkCount++
@@ -175,56 +310,143 @@ This command performs all validation checks without making any changes to your s
 						fmt.Printf("   ❌ Failed to register symlinks module: %v\n", err)
 						errorCount++
 					}
+					if err := registry.Register(commands.New()); err != nil {
+						fmt.Printf("   ❌ Failed to register commands module: %v\n", err)
+						errorCount++
+					}
+					if err := registry.Register(env.New()); err != nil {
+						fmt.Printf("   ❌ Failed to register env module: %v\n", err)
+						errorCount++
+					}
+					if err := registry.Register(fonts.New()); err != nil {
+						fmt.Printf("   ❌ Failed to register fonts module: %v\n", err)
+						errorCount++
+					}
+					if err := registry.Register(services.New()); err != nil {
+						fmt.Printf("   ❌ Failed to register services module: %v\n", err)
+						errorCount++
+					}
+					if err := registry.Register(ssh.New()); err != nil {
+						fmt.Printf("   ❌ Failed to register ssh module: %v\n", err)
+						errorCount++
+					}
+
+					engine := templating.NewTemplatingEngine(basePath)
 
+					var issues []validationIssue
 					validJobs := 0
-					invalidJobs := 0
+					invalidTasks := make(map[*config.Task]bool)
 
 					for _, task := range tasksList {
-						if err := registry.ValidateTask(task); err != nil {
-							fmt.Printf("   ❌ Job '%s' validation failed: %v\n", task.ID, err)
-							invalidJobs++
-							errorCount++
-						} else {
+						taskIssues := validateJob(registry, engine, task, basePath, variables)
+						if len(taskIssues) == 0 {
 							validJobs++
+							continue
 						}
+						invalidTasks[task] = true
+						issues = append(issues, taskIssues...)
+					}
+					for _, handler := range handlers {
+						issues = append(issues, validateJob(registry, engine, handler.Task, basePath, variables)...)
 					}
 
-					if invalidJobs == 0 {
+					if len(issues) == 0 {
 						fmt.Printf("   ✅ All %d jobs are valid\n", validJobs)
 					} else {
-						fmt.Printf("   ⚠️  %d valid jobs, %d invalid jobs\n", validJobs, invalidJobs)
+						printValidationIssues(issues, defaultSource)
+						fmt.Printf("   ⚠️  %d valid jobs, %d invalid jobs\n", validJobs, len(invalidTasks))
+						report(severityError, withSource(issues, defaultSource)...)
+						errorCount += len(issues)
 					}
 
-					// 5. Test job planning (template validation)
-					if invalidJobs == 0 {
-						fmt.Printf("\n🎨 Checking templates and planning...\n")
-						checkCount++
-
-						ctx := &modules.ExecutionContext{
-							BasePath:    basePath,
-							Variables:   variables,
-							DryRun:      true,
-							Verbose:     false,
-							ShowDiff:    false,
-							HideSkipped: true,
+					// 5. Test job planning (template validation) for jobs that passed validation
+					fmt.Printf("\n🎨 Checking templates and planning...\n")
+					checkCount++
+
+					ctx := &modules.ExecutionContext{
+						BasePath:     basePath,
+						Variables:    variables,
+						DryRun:       true,
+						Verbose:      false,
+						ShowDiff:     false,
+						HideSkipped:  true,
+						TemplatesDir: cfg.GetTemplatesPath(basePath),
+					}
+
+					var planningIssues []validationIssue
+					for _, task := range tasksList {
+						if invalidTasks[task] {
+							continue
 						}
+						if _, err := registry.PlanTask(task, ctx); err != nil {
+							planningIssues = append(planningIssues, validationIssue{
+								Source:  task.Source,
+								Line:    task.Line,
+								TaskID:  task.ID,
+								Action:  task.Action,
+								Message: fmt.Sprintf("template/planning error: %v", err),
+							})
+						}
+					}
 
-						planningErrors := 0
-						for _, task := range tasksList {
-							_, err := registry.PlanTask(task, ctx)
-							if err != nil {
-								fmt.Printf("   ❌ Template/planning error in '%s': %v\n", task.ID, err)
-								planningErrors++
-								errorCount++
+					if len(planningIssues) == 0 {
+						fmt.Printf("   ✅ All job templates and planning successful\n")
+					} else {
+						printValidationIssues(planningIssues, defaultSource)
+						report(severityError, withSource(planningIssues, defaultSource)...)
+						errorCount += len(planningIssues)
+					}
+
+					issues = append(issues, planningIssues...)
+
+					// 6. Check that no two tasks put different things at the same path
+					fmt.Printf("\n🎯 Checking for tasks managing the same path...\n")
+					checkCount++
+
+					warnings, err := registry.CheckTargetConflicts(tasksList, ctx)
+					for _, warning := range warnings {
+						fmt.Printf("   ⚠️  %s is managed by both '%s' (%s) and '%s' (%s) with the same content\n",
+							warning.Path, warning.First.ID, warning.First.Location(), warning.Second.ID, warning.Second.Location())
+						report(severityWarning, withSource([]validationIssue{{
+							Source:  warning.Second.Source,
+							Line:    warning.Second.Line,
+							TaskID:  warning.Second.ID,
+							Action:  warning.Second.Action,
+							Message: fmt.Sprintf("%s is also managed by '%s' (%s) with the same content", warning.Path, warning.First.ID, warning.First.Location()),
+						}}, defaultSource)...)
+					}
+					var conflictIssues []validationIssue
+					if conflictErr, isConflict := modules.IsTargetConflictError(err); isConflict {
+						for _, conflict := range conflictErr.Conflicts {
+							message := fmt.Sprintf("%s is also managed by '%s' (%s) with different content", conflict.Path, conflict.First.ID, conflict.First.Location())
+							switch {
+							case conflict.SecondKind == "edit":
+								message = fmt.Sprintf("%s is written whole by '%s' (%s), which undoes this edit on every apply", conflict.Path, conflict.First.ID, conflict.First.Location())
+							case conflict.FirstKind == "edit":
+								message = fmt.Sprintf("%s is also edited by '%s' (%s), this task undoes the edit on every apply", conflict.Path, conflict.First.ID, conflict.First.Location())
+							case conflict.FirstKind != conflict.SecondKind:
+								message = fmt.Sprintf("%s is also managed as a %s by '%s' (%s)", conflict.Path, conflict.FirstKind, conflict.First.ID, conflict.First.Location())
 							}
+							conflictIssues = append(conflictIssues, validationIssue{
+								Source:  conflict.Second.Source,
+								Line:    conflict.Second.Line,
+								TaskID:  conflict.Second.ID,
+								Action:  conflict.Second.Action,
+								Message: message,
+							})
 						}
+					}
 
-						if planningErrors == 0 {
-							fmt.Printf("   ✅ All job templates and planning successful\n")
-						} else {
-							fmt.Printf("   ❌ %d template/planning errors found\n", planningErrors)
-						}
+					if len(conflictIssues) == 0 {
+						fmt.Printf("   ✅ No path is managed by conflicting tasks\n")
+					} else {
+						printValidationIssues(conflictIssues, defaultSource)
+						report(severityError, withSource(conflictIssues, defaultSource)...)
+						errorCount += len(conflictIssues)
 					}
+
+					issues = append(issues, conflictIssues...)
+					fileCount = countIssueFiles(issues, defaultSource)
 				}
 			}
 
@@ -238,10 +460,14 @@ This command performs all validation checks without making any changes to your s
 				fmt.Printf("Status: ✅ All validations passed\n")
 				fmt.Printf("\n🎉 Your dotfiles configuration is valid and ready to use!\n")
 			} else {
-				fmt.Printf("Status: ❌ %d error(s) found\n", errorCount)
+				if fileCount > 0 {
+					fmt.Printf("Status: ❌ %s in %s\n", pluralize(errorCount, "error"), pluralize(fileCount, "file"))
+				} else {
+					fmt.Printf("Status: ❌ %d error(s) found\n", errorCount)
+				}
 				fmt.Printf("\n🔧 Please fix the errors above before applying your configuration.\n")
-				os.Exit(1)
 			}
+			finish(errorCount > 0)
 		},
 	}
 
@@ -249,10 +475,24 @@ This command performs all validation checks without making any changes to your s
 	validateCmd.Flags().StringVar(&shell, "shell", "", "Override shell detection (bash, zsh, powershell)")
 	validateCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
 	validateCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show detailed information about sources and jobs")
+	validateCmd.Flags().BoolVar(&strict, "strict", false, "Fail on imports whose path does not resolve instead of warning (default settings.strict_imports)")
+	validateCmd.Flags().StringVarP(&format, "format", "f", "text", "Output format (text, json)")
 
 	return validateCmd
 }
 
+// printUnresolvedImports warns about the imports whose path does not resolve that
+// were found after the first reported ones, and returns how many are reported now
+func printUnresolvedImports(reported int, report func(string, ...validationIssue)) int {
+	unresolved := config.UnresolvedImports()
+	for _, err := range unresolved[reported:] {
+		fmt.Printf("   ⚠️  %v\n", err)
+		source, line := splitLocation(err.Source)
+		report(severityWarning, validationIssue{Source: source, Line: line, Message: err.Error()})
+	}
+	return len(unresolved)
+}
+
 // parseEnvironmentVariables parses environment variables from string slice
 func parseEnvironmentVariables(envVars []string) map[string]string {
 	result := make(map[string]string)
@@ -264,3 +504,215 @@ func parseEnvironmentVariables(envVars []string) map[string]string {
 	}
 	return result
 }
+
+// The severities of the diagnostics of --format json
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// validationIssue describes a single problem found in the configuration, and is a
+// diagnostic of --format json
+type validationIssue struct {
+	Source   string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"` // Line in Source, 0 when unknown
+	Severity string `json:"severity"`
+	TaskID   string `json:"task,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Message  string `json:"message"`
+}
+
+// writeDiagnostics writes the diagnostics of --format json, an empty list when the
+// configuration is valid
+func writeDiagnostics(w io.Writer, diagnostics []validationIssue) error {
+	if diagnostics == nil {
+		diagnostics = []validationIssue{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diagnostics)
+}
+
+// sourceLocation matches the "(source: file:line)" errors of jobs files name the
+// task or import they are about with
+var sourceLocation = regexp.MustCompile(`\(source: ([^()]+)\)`)
+
+// errorIssue returns the diagnostic of an error loading variables or jobs, located
+// at the innermost source the error names, or at defaultSource
+func errorIssue(err error, defaultSource string) validationIssue {
+	issue := validationIssue{Source: defaultSource, Message: err.Error()}
+	if matches := sourceLocation.FindAllStringSubmatch(issue.Message, -1); len(matches) > 0 {
+		issue.Source, issue.Line = splitLocation(matches[len(matches)-1][1])
+	}
+	return issue
+}
+
+// splitLocation splits a "file:line" location, a location without a line is a file
+func splitLocation(location string) (string, int) {
+	if i := strings.LastIndex(location, ":"); i > 0 {
+		if line, err := strconv.Atoi(location[i+1:]); err == nil {
+			return location[:i], line
+		}
+	}
+	return location, 0
+}
+
+// relativeSource returns path relative to the dotfiles directory, the way tasks
+// name their source
+func relativeSource(basePath, path string) string {
+	if rel, err := filepath.Rel(basePath, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// withSource returns issues with the jobs index as the file of those without one
+func withSource(issues []validationIssue, defaultSource string) []validationIssue {
+	result := make([]validationIssue, len(issues))
+	for i, issue := range issues {
+		issue.Source = issueSource(issue, defaultSource)
+		result[i] = issue
+	}
+	return result
+}
+
+// validateJob runs all job level checks for a task and returns every problem found
+func validateJob(registry *modules.ModuleRegistry, engine *templating.TemplatingEngine, task *config.Task, basePath string, variables map[string]interface{}) []validationIssue {
+	var issues []validationIssue
+	addIssue := func(format string, args ...interface{}) {
+		issues = append(issues, validationIssue{
+			Source:  task.Source,
+			Line:    task.Line,
+			TaskID:  task.ID,
+			Action:  task.Action,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Every action key must map to a registered module
+	if _, err := registry.GetModuleByAction(task.Action); err != nil {
+		addIssue("unknown action '%s' (supported: %s)", task.Action, strings.Join(sortedActions(registry), ", "))
+		return issues
+	}
+
+	if err := registry.ValidateTask(task); err != nil {
+		// The issue already names the task and where it is defined
+		var typeErr *modules.ConfigTypeError
+		if errors.As(err, &typeErr) {
+			addIssue("%s", typeErr.Problem())
+		} else {
+			addIssue("%v", err)
+		}
+		return issues
+	}
+
+	// content_source files must exist relative to the dotfiles directory
+	if task.Action == "ensure_file" {
+		if contentSource, ok := task.Config["content_source"].(string); ok {
+			sourcePath, err := engine.ProcessString(contentSource, variables)
+			if err != nil {
+				addIssue("failed to process content_source template '%s': %v", contentSource, err)
+			} else {
+				if !filepath.IsAbs(sourcePath) {
+					sourcePath = filepath.Join(basePath, sourcePath)
+				}
+				if !utils.FileExists(sourcePath) {
+					addIssue("content_source file does not exist: %s", sourcePath)
+				}
+			}
+		}
+	}
+
+	// copy_file sources must exist relative to the dotfiles directory
+	if task.Action == "copy_file" {
+		if source, ok := task.Config["source"].(string); ok {
+			sourcePath, err := engine.ProcessString(source, variables)
+			if err != nil {
+				addIssue("failed to process source template '%s': %v", source, err)
+			} else {
+				if !filepath.IsAbs(sourcePath) {
+					sourcePath = filepath.Join(basePath, sourcePath)
+				}
+				if !utils.FileExists(sourcePath) {
+					addIssue("source file does not exist: %s", sourcePath)
+				}
+			}
+		}
+	}
+
+	// ensure_tree source directories must exist relative to the dotfiles directory
+	if task.Action == "ensure_tree" {
+		if sourceDir, ok := task.Config["source_dir"].(string); ok {
+			sourcePath, err := engine.ProcessString(sourceDir, variables)
+			if err != nil {
+				addIssue("failed to process source_dir template '%s': %v", sourceDir, err)
+			} else {
+				if !filepath.IsAbs(sourcePath) {
+					sourcePath = filepath.Join(basePath, sourcePath)
+				}
+				if !utils.IsDirectory(sourcePath) {
+					addIssue("source_dir does not exist: %s", sourcePath)
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+// printValidationIssues prints issues grouped by the file they were defined in
+func printValidationIssues(issues []validationIssue, defaultSource string) {
+	grouped := make(map[string][]validationIssue)
+	var sources []string
+	for _, issue := range issues {
+		source := issueSource(issue, defaultSource)
+		if _, exists := grouped[source]; !exists {
+			sources = append(sources, source)
+		}
+		grouped[source] = append(grouped[source], issue)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		fmt.Printf("   📄 %s\n", source)
+		for _, issue := range grouped[source] {
+			fmt.Printf("      ❌ %s '%s': %s\n", issue.Action, issue.TaskID, issue.Message)
+			if issue.Line > 0 {
+				fmt.Printf("         source: %s:%d\n", source, issue.Line)
+			}
+		}
+	}
+}
+
+// countIssueFiles returns the number of distinct files that have issues
+func countIssueFiles(issues []validationIssue, defaultSource string) int {
+	files := make(map[string]bool)
+	for _, issue := range issues {
+		files[issueSource(issue, defaultSource)] = true
+	}
+	return len(files)
+}
+
+// issueSource returns the source file of an issue, falling back to the jobs index
+func issueSource(issue validationIssue, defaultSource string) string {
+	if issue.Source != "" {
+		return issue.Source
+	}
+	return defaultSource
+}
+
+// sortedActions returns the registry's supported actions in alphabetical order
+func sortedActions(registry *modules.ModuleRegistry) []string {
+	actions := registry.GetSupportedActions()
+	sort.Strings(actions)
+	return actions
+}
+
+// pluralize formats a count with a singular or plural noun
+func pluralize(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
@@ -5,10 +5,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 
 	"github.com/spf13/cobra"
 )
@@ -28,8 +35,11 @@ Variables are loaded from variables/ directory with proper precedence and inheri
 	// Add subcommands
 	variablesCmd.AddCommand(createVariablesListCommand())
 	variablesCmd.AddCommand(createVariablesGetCommand())
+	variablesCmd.AddCommand(createVariablesSetCommand())
 	variablesCmd.AddCommand(createVariablesTraceCommand())
 	variablesCmd.AddCommand(createVariablesSourcesCommand())
+	variablesCmd.AddCommand(createVariablesContextCommand())
+	variablesCmd.AddCommand(createVariablesUsagesCommand())
 
 	return variablesCmd
 }
@@ -55,7 +65,7 @@ Shows the final merged state after all imports and precedence rules are applied.
 			// Find and load configuration
 			configPath, err := findConfigFile()
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to find configuration file")
+				handleConfigFileError(err)
 				os.Exit(1)
 			}
 
@@ -76,7 +86,7 @@ Shows the final merged state after all imports and precedence rules are applied.
 			}
 
 			// Prepare load options
-			opts := &config.VariableLoadOptions{}
+			opts := &config.VariableLoadOptions{UseCache: !noCache}
 			if platform != "" {
 				opts.Platform = platform
 			}
@@ -138,7 +148,8 @@ Examples:
   dotfiles variables get user.name
   dotfiles variables get shell.theme
   dotfiles variables get platform.os`,
-		Args: cobra.ExactArgs(1),
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVariableKeys,
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 			key := args[0]
@@ -146,7 +157,7 @@ Examples:
 			// Find and load configuration
 			configPath, err := findConfigFile()
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to find configuration file")
+				handleConfigFileError(err)
 				os.Exit(1)
 			}
 
@@ -167,7 +178,7 @@ Examples:
 			}
 
 			// Prepare load options
-			opts := &config.VariableLoadOptions{}
+			opts := &config.VariableLoadOptions{UseCache: !noCache}
 			if platform != "" {
 				opts.Platform = platform
 			}
@@ -218,9 +229,177 @@ Examples:
 	return getCmd
 }
 
+// createVariablesSetCommand creates the variables set subcommand
+func createVariablesSetCommand() *cobra.Command {
+	var (
+		file     string
+		host     bool
+		platform bool
+		asString bool
+	)
+
+	setCmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a variable in a variables file",
+		Long: `Set the value of a variable using dot notation, creating the maps on the way.
+The file keeps its comments and key order.
+
+The value is written to variables/global.yaml, or with --host to the overlay of
+this machine (variables/hosts/<hostname>.yaml, created when needed), with
+--platform to the file of this platform (variables/platforms/<os>.yaml) or to
+the file given with --file.
+
+true and false are written as booleans and whole numbers as integers, use
+--string to write them as text. A value that would conflict with a definition
+of the variable in another file of the same tier is refused.
+
+Examples:
+  dotfiles variables set user.editor nvim
+  dotfiles variables set git.signing true --host
+  dotfiles variables set zip.code 01234 --string`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVariableKeys,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+			key, value := args[0], config.ParseVariableValue(args[1], asString)
+
+			// Find and load configuration
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			// Get base path
+			basePath := filepath.Dir(configPath)
+
+			path, err := variablesFileToSet(cfg, basePath, file, host, platform)
+			if err != nil {
+				log.Error().Err(err).Msg("Cannot choose the variables file")
+				os.Exit(1)
+			}
+
+			if err := setVariable(cfg, basePath, path, key, value); err != nil {
+				if conflictErr, isConflict := config.IsVariableConflictError(err); isConflict {
+					fmt.Print(conflictErr.PrettyPrint())
+				}
+				log.Error().Err(err).Msg("Failed to set variable")
+				os.Exit(1)
+			}
+		},
+	}
+
+	setCmd.Flags().StringVar(&file, "file", "", "Variables file to write to, relative to the dotfiles directory (default variables/global.yaml)")
+	setCmd.Flags().BoolVar(&host, "host", false, "Write to the variables file of this host")
+	setCmd.Flags().BoolVar(&platform, "platform", false, "Write to the variables file of this platform")
+	setCmd.Flags().BoolVar(&asString, "string", false, "Write the value as a string, also when it looks like a boolean or number")
+	setCmd.MarkFlagsMutuallyExclusive("file", "host", "platform")
+
+	return setCmd
+}
+
+// variablesFileToSet returns the variables file variables set writes to
+func variablesFileToSet(cfg *config.Config, basePath, file string, host, platform bool) (string, error) {
+	variablesPath := cfg.GetVariablesPath(basePath)
+
+	switch {
+	case file != "":
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(basePath, file)
+		}
+		return filepath.Clean(file), nil
+	case host:
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to get hostname: %w", err)
+		}
+		return config.HostVariablesFile(variablesPath, hostname), nil
+	case platform:
+		return filepath.Join(variablesPath, "platforms", runtime.GOOS+".yaml"), nil
+	default:
+		return filepath.Join(variablesPath, "global.yaml"), nil
+	}
+}
+
+// setVariable writes a variable to a variables file after checking that the
+// variables still load, so a value that conflicts with another file is refused
+func setVariable(cfg *config.Config, basePath, path, key string, value interface{}) error {
+	if path == cfg.GetVariablesIndexPath(basePath) {
+		return fmt.Errorf("cannot set variables in the index, use a file it imports such as %s", relativeToBase(filepath.Join(cfg.GetVariablesPath(basePath), "global.yaml"), basePath))
+	}
+	if config.IsEncryptedVariableFile(path) {
+		return fmt.Errorf("cannot set variables in the encrypted file %s, decrypt it with 'dotfiles secrets decrypt -o', edit it and encrypt it again", relativeToBase(path, basePath))
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", relativeToBase(path, basePath), err)
+	}
+	updated, err := config.SetVariableInYAML(content, key, value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", relativeToBase(path, basePath), err)
+	}
+
+	vloader, err := config.NewVariableLoader(cfg, basePath)
+	if err != nil {
+		return err
+	}
+	if _, err := vloader.LoadAllVariablesWithFile(path, updated, nil); err != nil {
+		if conflictErr, isConflict := config.IsVariableConflictError(err); isConflict && conflictsWithKey(conflictErr, path, key) {
+			other := conflictErr.ExistingSource
+			if other == path {
+				other = conflictErr.NewSource
+			}
+			return fmt.Errorf("cannot set %s in %s, it conflicts with its definition in %s", key, relativeToBase(path, basePath), relativeToBase(other, basePath))
+		}
+		return fmt.Errorf("variables do not load with the change: %w", err)
+	}
+
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", relativeToBase(filepath.Dir(path), basePath), err)
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(path, updated, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", relativeToBase(path, basePath), err)
+	}
+
+	fmt.Printf("✅ Set %s = %v in %s\n", key, value, relativeToBase(path, basePath))
+
+	// Tell when the value has no effect, e.g. because the file is not imported
+	traces := vloader.TraceVariable(key)
+	if winner := config.WinningSource(traces); winner == nil {
+		fmt.Printf("⚠️  %s is not loaded by the variables index, the value is not used here\n", relativeToBase(path, basePath))
+	} else if winner.Source != path {
+		fmt.Printf("⚠️  The value from %s is used instead\n", relativeToBase(winner.Source, basePath))
+	}
+	return nil
+}
+
+// conflictsWithKey reports whether a conflict is between the variable set in a file
+// and another definition of it, rather than one the variables already had
+func conflictsWithKey(conflictErr *config.VariableConflictError, path, key string) bool {
+	if conflictErr.ExistingSource != path && conflictErr.NewSource != path {
+		return false
+	}
+	variable := conflictErr.Variable
+	return variable == key || strings.HasPrefix(variable, key+".") || strings.HasPrefix(key, variable+".")
+}
+
 // createVariablesTraceCommand creates the variables trace subcommand
 func createVariablesTraceCommand() *cobra.Command {
-	var showRaw bool
+	var (
+		showRaw bool
+		reveal  bool
+	)
 
 	traceCmd := &cobra.Command{
 		Use:   "trace <key>",
@@ -232,6 +411,8 @@ Use --raw to see the original template syntax for debugging template issues.
 
 Supports dot notation for nested variables (e.g., user.details.location).
 
+Values from encrypted (.enc.yaml) variable files are redacted unless --reveal is passed.
+
 Examples:
   dotfiles variables trace user.name            # Shows a rendered value
   dotfiles variables trace user.name --raw      # Shows the original template syntax
@@ -240,7 +421,8 @@ This is useful for:
 - Understanding variable precedence and inheritance
 - Debugging template processing issues
 - Finding which file defines a specific variable`,
-		Args: cobra.ExactArgs(1),
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVariableKeys,
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 			key := args[0]
@@ -248,7 +430,7 @@ This is useful for:
 			// Find and load configuration
 			configPath, err := findConfigFile()
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to find configuration file")
+				handleConfigFileError(err)
 				os.Exit(1)
 			}
 
@@ -269,14 +451,14 @@ This is useful for:
 			}
 
 			// Load variables
-			_, err = vloader.LoadAllVariables(nil)
+			_, err = vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to load variables")
 				os.Exit(1)
 			}
 
 			// Load variables first to get processed values
-			variables, err := vloader.LoadAllVariables(nil)
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
 			if err != nil {
 				handleVariableError(err)
 				os.Exit(1)
@@ -295,30 +477,43 @@ This is useful for:
 				processedValue, _ = vloader.GetVariable(key, variables)
 			}
 
+			if !reveal {
+				if winner := config.WinningSource(traces); winner != nil && winner.Encrypted {
+					processedValue = config.RedactValue(processedValue)
+				}
+				traces = redactSources(traces)
+			}
+
 			// Display trace information
 			displayTrace(key, traces, showRaw, processedValue)
 		},
 	}
 
 	traceCmd.Flags().BoolVar(&showRaw, "raw", false, "Show raw template syntax instead of rendered values")
+	traceCmd.Flags().BoolVar(&reveal, "reveal", false, "Show values decrypted from encrypted variable files")
 
 	return traceCmd
 }
 
 // createVariablesSourcesCommand creates the variables sources subcommand
 func createVariablesSourcesCommand() *cobra.Command {
+	var reveal bool
+
 	sourcesCmd := &cobra.Command{
 		Use:   "sources",
 		Short: "Show all variable sources and load order",
 		Long: `Show all variable source files that were loaded and their precedence order.
-This helps understand the variable loading process and file hierarchy.`,
+This helps understand the variable loading process and file hierarchy.
+
+Encrypted (.enc.yaml) variable files are marked as such. Use --reveal to also
+show the values decrypted from them.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			log := logger.Get()
 
 			// Find and load configuration
 			configPath, err := findConfigFile()
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to find configuration file")
+				handleConfigFileError(err)
 				os.Exit(1)
 			}
 
@@ -339,7 +534,7 @@ This helps understand the variable loading process and file hierarchy.`,
 			}
 
 			// Load variables
-			_, err = vloader.LoadAllVariables(nil)
+			_, err = vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
 			if err != nil {
 				handleVariableError(err)
 				os.Exit(1)
@@ -347,13 +542,431 @@ This helps understand the variable loading process and file hierarchy.`,
 
 			// Display sources
 			sources := vloader.GetVariableSources()
-			displaySources(sources)
+			displaySources(sources, reveal)
 		},
 	}
 
+	sourcesCmd.Flags().BoolVar(&reveal, "reveal", false, "Show the values decrypted from encrypted variable files")
+
 	return sourcesCmd
 }
 
+// createVariablesContextCommand creates the variables context subcommand
+func createVariablesContextCommand() *cobra.Command {
+	var (
+		redactEnv bool
+		format    string
+	)
+
+	contextCmd := &cobra.Command{
+		Use:   "context",
+		Short: "Show the template context of this machine",
+		Long: `Show everything conditions and templates can use on this machine as a tree:
+the Platform, User and Env sections and the loaded variables. Every section is
+annotated with the engines it is available in:
+
+  imports     import paths and conditions in variables/index.yaml
+  variables   templates in variable values
+  conditions  task and job conditions
+  templates   template files and templated task config
+
+Use --redact-env to hide the values of the environment variables, e.g. before
+sharing the output.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			// Find and load configuration
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			// Create variable loader
+			vloader, err := config.NewVariableLoader(cfg, filepath.Dir(configPath))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			variables, err := vloader.LoadAllVariables(&config.VariableLoadOptions{UseCache: !noCache})
+			if err != nil {
+				handleVariableError(err)
+				os.Exit(1)
+			}
+
+			sections := templateContextSections(variables, redactEnv)
+			switch format {
+			case "json":
+				fmt.Println(utils.ToJSONString(sections))
+			case "text":
+				displayTemplateContext(sections)
+			default:
+				log.Error().Str("format", format).Msg("Unsupported format, use text or json")
+				os.Exit(1)
+			}
+		},
+	}
+
+	contextCmd.Flags().BoolVar(&redactEnv, "redact-env", false, "Hide the values of environment variables")
+	contextCmd.Flags().StringVarP(&format, "format", "f", "text", "Output format (text, json)")
+
+	return contextCmd
+}
+
+// templateContextSection is a section of the template context with its values
+type templateContextSection struct {
+	config.ContextSection
+	Values map[string]interface{} `json:"values"`
+}
+
+// templateContextSections splits loaded variables into the sections of the
+// template context
+func templateContextSections(variables map[string]interface{}, redactEnv bool) []templateContextSection {
+	sections := make([]templateContextSection, 0, len(config.TemplateContextSections))
+	named := make(map[string]bool)
+	for _, section := range config.TemplateContextSections {
+		named[section.Name] = true
+	}
+
+	for _, section := range config.TemplateContextSections {
+		values := make(map[string]interface{})
+		switch section.Name {
+		case config.VariablesSection:
+			for key, value := range variables {
+				if !named[key] {
+					values[key] = value
+				}
+			}
+		case "Env":
+			env, _ := variables[section.Name].(map[string]string)
+			for key, value := range env {
+				if redactEnv {
+					values[key] = "<redacted>"
+				} else {
+					values[key] = value
+				}
+			}
+		default:
+			if sectionValues, ok := variables[section.Name].(map[string]interface{}); ok {
+				values = sectionValues
+			}
+		}
+		sections = append(sections, templateContextSection{ContextSection: section, Values: values})
+	}
+	return sections
+}
+
+// displayTemplateContext prints the sections of the template context as a tree
+func displayTemplateContext(sections []templateContextSection) {
+	for i, section := range sections {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%s)\n", section.Name, strings.Join(section.Engines, ", "))
+		fmt.Printf("  %s\n", section.Description)
+		if len(section.Values) == 0 {
+			ui.Println("└── (empty)")
+			continue
+		}
+		displayContextTree(section.Values, "")
+	}
+}
+
+// displayContextTree prints the keys of values in order, nesting maps below their key
+func displayContextTree(values map[string]interface{}, indent string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		branch, nested := "├── ", "│   "
+		if i == len(keys)-1 {
+			branch, nested = "└── ", "    "
+		}
+		branch, nested = ui.Text(branch), ui.Text(nested)
+
+		switch value := values[key].(type) {
+		case map[string]interface{}:
+			ui.Printf("%s%s%s\n", indent, branch, key)
+			displayContextTree(value, indent+nested)
+		case map[string]string:
+			children := make(map[string]interface{}, len(value))
+			for k, v := range value {
+				children[k] = v
+			}
+			ui.Printf("%s%s%s\n", indent, branch, key)
+			displayContextTree(children, indent+nested)
+		default:
+			ui.Printf("%s%s%s: %v\n", indent, branch, key, value)
+		}
+	}
+}
+
+
+// createVariablesUsagesCommand creates the variables usages subcommand
+func createVariablesUsagesCommand() *cobra.Command {
+	var (
+		unused      bool
+		platform    string
+		hostname    string
+		environment []string
+		format      string
+	)
+
+	usagesCmd := &cobra.Command{
+		Use:   "usages [key]",
+		Short: "Show the tasks and templates that read a variable",
+		Long: `Show the tasks, job files and template files that read a variable, or with
+--unused the variables nothing reads.
+
+Usages are recorded while every task of every job file is planned and its
+condition, templated configuration and template files are rendered, whatever the
+conditions and profiles of the tasks and imports. A key is read by a task when
+the task reads the key itself, a key below it or the map it is in.
+
+Templates only read the variables of the branches they take on this machine, so
+use --platform, --hostname and --env to also see what other machines read.`,
+		Example: `  dotfiles variables usages editor.default
+  dotfiles variables usages --unused
+  dotfiles variables usages git --platform darwin --format json`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if unused {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		ValidArgsFunction: completeVariableKeys,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := logger.Get()
+
+			if format != "text" && format != "json" {
+				log.Error().Str("format", format).Msg("Unsupported format, use text or json")
+				os.Exit(1)
+			}
+
+			// Find and load configuration
+			configPath, err := findConfigFile()
+			if err != nil {
+				handleConfigFileError(err)
+				os.Exit(1)
+			}
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to load configuration")
+				os.Exit(1)
+			}
+
+			// Get base path
+			basePath := filepath.Dir(configPath)
+
+			vloader, err := config.NewVariableLoader(cfg, basePath)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create variable loader")
+				os.Exit(1)
+			}
+
+			opts := &config.VariableLoadOptions{Platform: platform, Hostname: hostname}
+			if len(environment) > 0 {
+				opts.Environment = parseEnvironmentVariables(environment)
+			}
+
+			usages, variables, err := recordVariableUsages(cfg, basePath, vloader, opts)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to record variable usages")
+				os.Exit(1)
+			}
+
+			if unused {
+				keys := unusedVariables(variables, usages)
+				if format == "json" {
+					fmt.Println(utils.ToJSONString(keys))
+					return
+				}
+				displayUnusedVariables(vloader, keys, basePath)
+				return
+			}
+
+			key := args[0]
+			if _, found := vloader.GetVariable(key, variables); !found {
+				log.Warn().Str("key", key).Msg("Variable is not defined on this machine")
+			}
+
+			matching := []templating.VariableUsage{}
+			for _, usage := range usages {
+				if readsKey(usage.Key, key) {
+					usage.Template = relativeToBase(usage.Template, basePath)
+					matching = append(matching, usage)
+				}
+			}
+			if format == "json" {
+				fmt.Println(utils.ToJSONString(matching))
+				return
+			}
+			displayVariableUsages(key, matching)
+		},
+	}
+
+	usagesCmd.Flags().BoolVar(&unused, "unused", false, "List the variables nothing reads")
+	usagesCmd.Flags().StringVar(&platform, "platform", "", "Override platform detection (windows, linux, darwin)")
+	usagesCmd.Flags().StringVar(&hostname, "hostname", "", "Override hostname")
+	usagesCmd.Flags().StringSliceVarP(&environment, "env", "e", []string{}, "Set environment variables (KEY=VALUE)")
+	usagesCmd.Flags().StringVarP(&format, "format", "f", "text", "Output format (text, json)")
+
+	return usagesCmd
+}
+
+// recordVariableUsages loads the variables and plans every task and handler of the
+// jobs, recording the variables they read. It returns the usages and the variables.
+func recordVariableUsages(cfg *config.Config, basePath string, vloader *config.VariableLoader, opts *config.VariableLoadOptions) ([]templating.VariableUsage, map[string]interface{}, error) {
+	recorder := templating.NewUsageRecorder()
+	templating.RecordUsages(recorder)
+	defer templating.RecordUsages(nil)
+
+	// Variables reading other variables count as usages too
+	templating.SetUsageScope("variables", relativeToBase(cfg.GetVariablesIndexPath(basePath), basePath))
+	variables, err := vloader.LoadAllVariables(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tasks, handlers, err := jobs.LoadAllJobs(cfg.GetJobsIndexPath(basePath), variables)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, handler := range handlers {
+		tasks = append(tasks, handler.Task)
+	}
+
+	registry, err := newModuleRegistry()
+	if err != nil {
+		return nil, nil, err
+	}
+	engine := templating.NewTemplatingEngine(basePath)
+	for _, task := range tasks {
+		taskVariables := task.ScopedVariables(variables)
+		templating.SetUsageScope(task.ID, task.Location())
+		engine.RecordCondition(task.Condition, taskVariables)
+
+		// Plans that fail still read what they got to, that is all that is needed here
+		ctx := &modules.ExecutionContext{
+			BasePath:     basePath,
+			Variables:    taskVariables,
+			DryRun:       true,
+			Offline:      true,
+			TemplatesDir: cfg.GetTemplatesPath(basePath),
+		}
+		_, _ = registry.PlanTask(task, ctx)
+	}
+
+	return recorder.Usages(), variables, nil
+}
+
+// readsKey reports whether reading the variable read reads key: the key itself, a
+// key below it or a map it is in
+func readsKey(read, key string) bool {
+	return read == key || strings.HasPrefix(read, key+".") || strings.HasPrefix(key, read+".")
+}
+
+// unusedVariables returns the variables nothing reads, by their dotted key. Maps
+// are descended into, the sections of the template context are left out.
+func unusedVariables(variables map[string]interface{}, usages []templating.VariableUsage) []string {
+	sections := make(map[string]bool)
+	for _, section := range config.TemplateContextSections {
+		sections[section.Name] = true
+	}
+
+	unused := []string{}
+	var walk func(prefix string, values map[string]interface{})
+	walk = func(prefix string, values map[string]interface{}) {
+		for name, value := range values {
+			key := prefix + name
+			if prefix == "" && sections[name] {
+				continue
+			}
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(key+".", nested)
+				continue
+			}
+
+			read := false
+			for _, usage := range usages {
+				if readsKey(usage.Key, key) {
+					read = true
+					break
+				}
+			}
+			if !read {
+				unused = append(unused, key)
+			}
+		}
+	}
+	walk("", variables)
+
+	sort.Strings(unused)
+	return unused
+}
+
+// displayVariableUsages prints the tasks that read a variable with what they read
+// and where
+func displayVariableUsages(key string, usages []templating.VariableUsage) {
+	if len(usages) == 0 {
+		fmt.Printf("Nothing reads %s\n", key)
+		return
+	}
+
+	fmt.Printf("Variable: %s\n\n", key)
+	var scope string
+	for _, usage := range usages {
+		if usage.Scope != scope {
+			if scope != "" {
+				fmt.Println()
+			}
+			scope = usage.Scope
+			fmt.Printf("%s\n", scope)
+			if usage.Source != "" {
+				fmt.Printf("   Source: %s\n", usage.Source)
+			}
+		}
+		if usage.Template != "" {
+			fmt.Printf("   Reads %s in %s\n", usage.Key, usage.Template)
+		} else {
+			fmt.Printf("   Reads %s\n", usage.Key)
+		}
+	}
+}
+
+// displayUnusedVariables prints the variables nothing reads with where they are
+// defined
+func displayUnusedVariables(vloader *config.VariableLoader, keys []string, basePath string) {
+	if len(keys) == 0 {
+		fmt.Println("Every variable is read by a task, template or other variable")
+		return
+	}
+
+	fmt.Printf("Variables nothing reads:\n\n")
+	for _, key := range keys {
+		winner := config.WinningSource(vloader.TraceVariable(key))
+		switch {
+		case winner == nil:
+			fmt.Printf("  %s\n", key)
+		case winner.Line > 0:
+			fmt.Printf("  %s (%s:%d)\n", key, relativeToBase(winner.Source, basePath), winner.Line)
+		default:
+			fmt.Printf("  %s (%s)\n", key, relativeToBase(winner.Source, basePath))
+		}
+	}
+}
+
 
 
 // Helper functions for display
@@ -542,16 +1155,22 @@ func displayTrace(key string, traces []*config.VariableSource, showRaw bool, pro
 		fmt.Printf("Sources (rendered values):\n\n")
 	}
 
+	winner := config.WinningSource(traces)
 	for i, trace := range traces {
 		fmt.Printf("%d. Source: %s\n", i+1, trace.Source)
+		fmt.Printf("   Tier: %s\n", trace.Tier)
 		if trace.Line > 0 {
 			fmt.Printf("   Line: %d\n", trace.Line)
 		}
 		if showRaw {
 			fmt.Printf("   Raw Value: %v\n", trace.RawValue)
 		} else {
-			// Show processed value if available, fallback to raw value
-			if trace.ProcessedValue != nil {
+			// The processed value is the merged result, so it only belongs to the
+			// winning source; overridden sources show what they defined
+			_, isMap := trace.RawValue.(map[string]interface{})
+			if trace != winner && !isMap && fmt.Sprint(trace.RawValue) != fmt.Sprint(winner.RawValue) {
+				fmt.Printf("   Value: %v (overridden)\n", trace.RawValue)
+			} else if trace.ProcessedValue != nil {
 				fmt.Printf("   Value: %v\n", trace.ProcessedValue)
 			} else {
 				fmt.Printf("   Value: %v\n", trace.RawValue)
@@ -561,14 +1180,15 @@ func displayTrace(key string, traces []*config.VariableSource, showRaw bool, pro
 	}
 
 	if len(traces) > 1 {
-		finalTrace := traces[len(traces)-1]
+		// Higher tiers win over lower ones, later files win within a tier
+		finalTrace := winner
 		if showRaw {
-			fmt.Printf("Final raw value: %v (from %s)\n", finalTrace.RawValue, finalTrace.Source)
+			fmt.Printf("Final raw value: %v (from %s, %s tier)\n", finalTrace.RawValue, finalTrace.Source, finalTrace.Tier)
 		} else {
 			if finalTrace.ProcessedValue != nil {
-				fmt.Printf("Final value: %v (from %s)\n", finalTrace.ProcessedValue, finalTrace.Source)
+				fmt.Printf("Final value: %v (from %s, %s tier)\n", finalTrace.ProcessedValue, finalTrace.Source, finalTrace.Tier)
 			} else {
-				fmt.Printf("Final value: %v (from %s)\n", finalTrace.RawValue, finalTrace.Source)
+				fmt.Printf("Final value: %v (from %s, %s tier)\n", finalTrace.RawValue, finalTrace.Source, finalTrace.Tier)
 			}
 		}
 	}
@@ -579,7 +1199,7 @@ func displayTrace(key string, traces []*config.VariableSource, showRaw bool, pro
 	}
 }
 
-func displaySources(sources []*config.VariableSource) {
+func displaySources(sources []*config.VariableSource, reveal bool) {
 	if len(sources) == 0 {
 		fmt.Println("No variable sources found")
 		return
@@ -595,7 +1215,19 @@ func displaySources(sources []*config.VariableSource) {
 
 	i := 1
 	for file, variables := range sourceFiles {
-		fmt.Printf("%d. %s (%d variables)\n", i, file, len(variables))
+		if config.IsEncryptedVariableFile(file) {
+			fmt.Printf("%d. %s (%d variables, 🔒 encrypted)\n", i, file, len(variables))
+			if reveal {
+				for _, variable := range variables {
+					fmt.Printf("     - %s: %v\n", variable.Key, variable.RawValue)
+				}
+				fmt.Println()
+				i++
+				continue
+			}
+		} else {
+			fmt.Printf("%d. %s (%d variables)\n", i, file, len(variables))
+		}
 
 		// Show first few variables as examples
 		for j, variable := range variables {
@@ -610,6 +1242,35 @@ func displaySources(sources []*config.VariableSource) {
 	}
 }
 
+// redactSources redacts the values of sources from encrypted variable files
+func redactSources(sources []*config.VariableSource) []*config.VariableSource {
+	redacted := make([]*config.VariableSource, len(sources))
+	for i, source := range sources {
+		redacted[i] = source.Redacted()
+	}
+	return redacted
+}
+
+// findConfigFile returns the configuration file given with --config or
+// DOTFILES_CONFIG, or the one found in the common locations
 func findConfigFile() (string, error) {
-	return config.FindConfigFile()
+	return config.ResolveConfigFile(configFile)
+}
+
+// handleConfigFileError reports that no configuration file was found, with where
+// dotfiles looked and how to create one or point to it
+func handleConfigFileError(err error) {
+	notFound, isNotFound := config.IsConfigNotFoundError(err)
+	if !isNotFound {
+		logger.Get().Error().Err(err).Msg("Failed to find configuration file")
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "No dotfiles configuration found. Looked for:")
+	for _, path := range notFound.Searched {
+		fmt.Fprintf(os.Stderr, "  %s\n", path)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'dotfiles init' to create a dotfiles repository in this directory, or")
+	fmt.Fprintf(os.Stderr, "point to an existing one with --config <path> or %s.\n", config.ConfigEnvVar)
 }
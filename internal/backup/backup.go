@@ -0,0 +1,313 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ManifestFile is the name of the manifest written into every snapshot
+	ManifestFile = "manifest.yaml"
+
+	// TimestampFormat is the layout used for snapshot directory names
+	TimestampFormat = "20060102-150405"
+
+	// FileBackupSuffix separates a file name from the timestamp of its backup copy
+	FileBackupSuffix = ".dotfiles-bak."
+
+	// DefaultFileBackupKeep is how many backup copies of a single file are kept
+	DefaultFileBackupKeep = 3
+
+	filesDir = "files"
+)
+
+// Entry describes a single file saved in a snapshot
+type Entry struct {
+	Path    string `yaml:"path" json:"path"`
+	Backup  string `yaml:"backup,omitempty" json:"backup,omitempty"`
+	Mode    string `yaml:"mode" json:"mode"`
+	Size    int64  `yaml:"size" json:"size"`
+	SHA256  string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
+	Symlink string `yaml:"symlink,omitempty" json:"symlink,omitempty"`
+	Source  string `yaml:"source,omitempty" json:"source,omitempty"`
+}
+
+// Manifest records the contents of a snapshot
+type Manifest struct {
+	CreatedAt time.Time `yaml:"created_at" json:"created_at"`
+	Hostname  string    `yaml:"hostname,omitempty" json:"hostname,omitempty"`
+	Entries   []Entry   `yaml:"entries" json:"entries"`
+}
+
+// Target is a path that should be included in a snapshot
+type Target struct {
+	Path   string
+	Source string
+}
+
+// Result summarizes a created snapshot
+type Result struct {
+	Dir      string
+	Manifest *Manifest
+	Missing  []string
+	Skipped  []string
+}
+
+// CreateSnapshot copies all existing targets into a new timestamped directory below backupDir
+func CreateSnapshot(backupDir string, targets []Target, now time.Time) (*Result, error) {
+	snapshotDir, err := newSnapshotDir(backupDir, now)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	result := &Result{
+		Dir: snapshotDir,
+		Manifest: &Manifest{
+			CreatedAt: now,
+			Hostname:  hostname,
+			Entries:   []Entry{},
+		},
+	}
+
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		path := filepath.Clean(target.Path)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			result.Missing = append(result.Missing, path)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		entry := Entry{
+			Path:   path,
+			Mode:   fmt.Sprintf("%04o", info.Mode().Perm()),
+			Size:   info.Size(),
+			Source: target.Source,
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read symlink %s: %w", path, err)
+			}
+			entry.Symlink = linkTarget
+		case info.Mode().IsRegular():
+			relPath := backupRelPath(path)
+			if err := utils.CopyFile(path, filepath.Join(snapshotDir, relPath)); err != nil {
+				return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+			}
+			hash, err := hashFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash %s: %w", path, err)
+			}
+			entry.Backup = filepath.ToSlash(relPath)
+			entry.SHA256 = hash
+		default:
+			result.Skipped = append(result.Skipped, path)
+			continue
+		}
+
+		result.Manifest.Entries = append(result.Manifest.Entries, entry)
+	}
+
+	if err := WriteManifest(snapshotDir, result.Manifest); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// WriteManifest writes the manifest into the snapshot directory
+func WriteManifest(snapshotDir string, manifest *Manifest) error {
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(snapshotDir, ManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// LoadManifest reads the manifest of a snapshot directory
+func LoadManifest(snapshotDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// ListSnapshots returns the snapshot directories in backupDir, oldest first
+func ListSnapshots(backupDir string) ([]string, error) {
+	entries, err := os.ReadDir(backupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if !utils.FileExists(filepath.Join(backupDir, entry.Name(), ManifestFile)) {
+			continue
+		}
+		snapshots = append(snapshots, filepath.Join(backupDir, entry.Name()))
+	}
+
+	// Directory names start with a sortable timestamp
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// Prune removes all but the newest keep snapshots and returns the removed directories
+func Prune(backupDir string, keep int) ([]string, error) {
+	if keep < 0 {
+		return nil, fmt.Errorf("number of snapshots to keep must not be negative")
+	}
+
+	snapshots, err := ListSnapshots(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) <= keep {
+		return nil, nil
+	}
+
+	var removed []string
+	for _, dir := range snapshots[:len(snapshots)-keep] {
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", dir, err)
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
+
+// FileBackupPath returns where a copy of path is stored before it is overwritten.
+// Without a backup directory the copy is placed next to the file, otherwise below
+// backupDir mirroring the absolute path of the file.
+func FileBackupPath(path, backupDir string, now time.Time) string {
+	path = filepath.Clean(path)
+	name := filepath.Base(path) + FileBackupSuffix + now.Format(TimestampFormat)
+	if backupDir == "" {
+		return filepath.Join(filepath.Dir(path), name)
+	}
+	return filepath.Join(backupDir, filepath.Dir(backupRelPath(path)), name)
+}
+
+// BackupFile copies path to its backup location and removes all but the newest
+// keep backups of the same file. It returns the path of the new copy.
+func BackupFile(path, backupDir string, now time.Time, keep int) (string, error) {
+	backupPath := FileBackupPath(path, backupDir, now)
+	if err := utils.CopyFile(path, backupPath); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	if keep > 0 {
+		if err := pruneFileBackups(path, backupPath, keep); err != nil {
+			return backupPath, err
+		}
+	}
+	return backupPath, nil
+}
+
+// pruneFileBackups removes the oldest backups of a file next to backupPath
+func pruneFileBackups(path, backupPath string, keep int) error {
+	prefix := filepath.Base(path) + FileBackupSuffix
+	dir := filepath.Dir(backupPath)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	// Names end in a sortable timestamp
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to remove old backup %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// newSnapshotDir creates a unique timestamped directory for a snapshot
+func newSnapshotDir(backupDir string, now time.Time) (string, error) {
+	base := filepath.Join(backupDir, now.Format(TimestampFormat))
+	dir := base
+	for i := 1; utils.FileExists(dir); i++ {
+		dir = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return dir, nil
+}
+
+// backupRelPath maps an absolute path to its location inside a snapshot
+func backupRelPath(path string) string {
+	volume := filepath.VolumeName(path)
+	rest := strings.TrimLeft(path[len(volume):], `/\`)
+	volume = strings.TrimRight(strings.ReplaceAll(volume, ":", ""), `/\`)
+	if volume != "" {
+		return filepath.Join(filesDir, volume, rest)
+	}
+	return filepath.Join(filesDir, rest)
+}
+
+// hashFile returns the hex encoded SHA-256 of a file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
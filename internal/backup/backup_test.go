@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateSnapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	existing := filepath.Join(tmpDir, "home", ".bashrc")
+	require.NoError(t, os.MkdirAll(filepath.Dir(existing), 0755))
+	require.NoError(t, os.WriteFile(existing, []byte("export FOO=bar\n"), 0600))
+
+	missing := filepath.Join(tmpDir, "home", ".zshrc")
+
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	result, err := CreateSnapshot(backupDir, []Target{
+		{Path: existing, Source: "jobs/index.yaml"},
+		{Path: missing},
+		{Path: existing},
+	}, now)
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(backupDir, "20240501-123000"), result.Dir)
+	assert.Equal(t, []string{missing}, result.Missing)
+	require.Len(t, result.Manifest.Entries, 1)
+
+	entry := result.Manifest.Entries[0]
+	assert.Equal(t, existing, entry.Path)
+	assert.Equal(t, "jobs/index.yaml", entry.Source)
+	assert.Equal(t, int64(15), entry.Size)
+	assert.Equal(t, "33fb77eefb6d95bc399086037a1c5904146d27224e7a1e0bf1bb7f7e8399e192", entry.SHA256)
+	assert.Equal(t, "0600", entry.Mode)
+
+	copied, err := os.ReadFile(filepath.Join(result.Dir, filepath.FromSlash(entry.Backup)))
+	require.NoError(t, err)
+	assert.Equal(t, "export FOO=bar\n", string(copied))
+
+	manifest, err := LoadManifest(result.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, result.Manifest.Entries, manifest.Entries)
+}
+
+func TestCreateSnapshotRecordsSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "source")
+	link := filepath.Join(tmpDir, "link")
+	require.NoError(t, os.WriteFile(target, []byte("content"), 0644))
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	result, err := CreateSnapshot(filepath.Join(tmpDir, "backups"), []Target{{Path: link}}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, result.Manifest.Entries, 1)
+	assert.Equal(t, target, result.Manifest.Entries[0].Symlink)
+	assert.Empty(t, result.Manifest.Entries[0].Backup)
+}
+
+func TestPrune(t *testing.T) {
+	backupDir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var dirs []string
+	for i := 0; i < 4; i++ {
+		result, err := CreateSnapshot(backupDir, nil, start.Add(time.Duration(i)*time.Hour))
+		require.NoError(t, err)
+		dirs = append(dirs, result.Dir)
+	}
+
+	removed, err := Prune(backupDir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, dirs[:2], removed)
+
+	remaining, err := ListSnapshots(backupDir)
+	require.NoError(t, err)
+	assert.Equal(t, dirs[2:], remaining)
+
+	_, err = Prune(backupDir, -1)
+	assert.Error(t, err)
+}
+
+func TestFileBackupPath(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	path := filepath.Join(string(filepath.Separator)+"home", "user", ".bashrc")
+
+	assert.Equal(t, path+".dotfiles-bak.20240501-123000", FileBackupPath(path, "", now))
+	assert.Equal(t,
+		filepath.Join("/backups", "files", "home", "user", ".bashrc.dotfiles-bak.20240501-123000"),
+		FileBackupPath(path, "/backups", now))
+}
+
+func TestBackupFileKeepsNewest(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "config")
+	require.NoError(t, os.WriteFile(path, []byte("original"), 0600))
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var created []string
+	for i := 0; i < 5; i++ {
+		backupPath, err := BackupFile(path, "", start.Add(time.Duration(i)*time.Minute), 3)
+		require.NoError(t, err)
+		created = append(created, backupPath)
+	}
+
+	for _, removed := range created[:2] {
+		assert.NoFileExists(t, removed)
+	}
+	for _, kept := range created[2:] {
+		content, err := os.ReadFile(kept)
+		require.NoError(t, err)
+		assert.Equal(t, "original", string(content))
+	}
+
+	info, err := os.Stat(created[4])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
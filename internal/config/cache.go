@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// variableCacheVersion is bumped whenever the cache format or the way variables
+// are processed changes, so old caches are ignored
+const variableCacheVersion = 2
+
+// secretCallPattern matches calls to the secret() template function. Variables
+// that read secrets or come from encrypted files are never cached, so secrets are
+// not written to disk.
+var secretCallPattern = regexp.MustCompile(`\bsecret\s*\(`)
+
+// variableCache is the content of the variable cache file
+type variableCache struct {
+	Version   int                    `json:"version"`
+	Key       string                 `json:"key"`   // Hash of the load options and template context
+	Files     map[string]string      `json:"files"` // SHA-256 of every contributing file
+	Variables map[string]interface{} `json:"variables"`
+	Sources   []*VariableSource      `json:"sources"`
+}
+
+// variableFiles is the state of the files variables are loaded from
+type variableFiles struct {
+	Hashes     map[string]string // SHA-256 by path
+	UsesEnv    bool              // Whether any file references Env
+	UsesSecret bool              // Whether any file calls secret() or is encrypted
+}
+
+// VariableCachePath returns the file processed variables are cached in
+func VariableCachePath(basePath string) string {
+	return filepath.Join(StateDir(basePath), "variables.json")
+}
+
+// hashVariableFiles hashes every file in the variables directory and the given
+// files outside of it. Files that are not imported are included too, so adding
+// e.g. a host overlay invalidates the cache.
+func (vl *VariableLoader) hashVariableFiles(extra []string) (*variableFiles, error) {
+	files := &variableFiles{Hashes: make(map[string]string)}
+
+	addFile := func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		files.Hashes[path] = hex.EncodeToString(sum[:])
+		files.UsesEnv = files.UsesEnv || bytes.Contains(data, []byte("Env"))
+		files.UsesSecret = files.UsesSecret || secretCallPattern.Match(data) || IsEncryptedVariableFile(path)
+		return nil
+	}
+
+	variablesPath := vl.config.GetVariablesPath(vl.basePath)
+	err := filepath.WalkDir(variablesPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			return addFile(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range extra {
+		if _, exists := files.Hashes[path]; exists {
+			continue
+		}
+		if err := addFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// variableCacheKey hashes everything besides the variable files that processed
+// variables depend on. The environment is only part of the key when a variable
+// file references it, otherwise every change to it would invalidate the cache.
+func variableCacheKey(opts *VariableLoadOptions, templateContext map[string]interface{}, usesEnv bool) (string, error) {
+	keyContext := make(map[string]interface{}, len(templateContext))
+	for key, value := range templateContext {
+		if key == "Env" && !usesEnv {
+			continue
+		}
+		keyContext[key] = value
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"options": opts,
+		"context": keyContext,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readVariableCache returns the cached processed variables when every file they
+// were loaded from is unchanged and they were loaded with the same options
+func (vl *VariableLoader) readVariableCache(opts *VariableLoadOptions, templateContext map[string]interface{}) (map[string]interface{}, bool) {
+	data, err := os.ReadFile(VariableCachePath(vl.basePath))
+	if err != nil {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var cache variableCache
+	if err := decoder.Decode(&cache); err != nil || cache.Version != variableCacheVersion {
+		return nil, false
+	}
+
+	// Files outside the variables directory are only known from the cache
+	variablesPath := vl.config.GetVariablesPath(vl.basePath) + string(filepath.Separator)
+	var extra []string
+	for path := range cache.Files {
+		if !strings.HasPrefix(path, variablesPath) {
+			// Remote imports are updated first, their files change upstream
+			if err := refreshRemoteImport(vl.basePath, path); err != nil {
+				return nil, false
+			}
+			extra = append(extra, path)
+		}
+	}
+
+	files, err := vl.hashVariableFiles(extra)
+	if err != nil || files.UsesSecret || !sameHashes(files.Hashes, cache.Files) {
+		return nil, false
+	}
+
+	key, err := variableCacheKey(opts, templateContext, files.UsesEnv)
+	if err != nil || key != cache.Key {
+		return nil, false
+	}
+
+	for _, source := range cache.Sources {
+		source.RawValue = normalizeCachedValue(source.RawValue)
+		source.ProcessedValue = normalizeCachedValue(source.ProcessedValue)
+	}
+	vl.sources = cache.Sources
+
+	variables, _ := normalizeCachedValue(cache.Variables).(map[string]interface{})
+	if variables == nil {
+		variables = make(map[string]interface{})
+	}
+	return variables, true
+}
+
+// writeVariableCache stores processed variables with the hashes of the files they
+// were loaded from. Variables that read secrets or are encrypted are not cached.
+func (vl *VariableLoader) writeVariableCache(opts *VariableLoadOptions, templateContext map[string]interface{}, variables map[string]interface{}) error {
+	extra := []string{vl.config.GetVariablesIndexPath(vl.basePath)}
+	for path := range vl.loadedFiles {
+		extra = append(extra, path)
+	}
+
+	files, err := vl.hashVariableFiles(extra)
+	if err != nil {
+		return err
+	}
+	if files.UsesSecret {
+		vl.removeVariableCache()
+		return nil
+	}
+
+	key, err := variableCacheKey(opts, templateContext, files.UsesEnv)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&variableCache{
+		Version:   variableCacheVersion,
+		Key:       key,
+		Files:     files.Hashes,
+		Variables: variables,
+		Sources:   vl.sources,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode variable cache: %w", err)
+	}
+
+	cachePath := VariableCachePath(vl.basePath)
+	if err := utils.EnsureDir(filepath.Dir(cachePath)); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Write to a temporary file first so concurrent runs never read a partial cache
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".variables-*")
+	if err != nil {
+		return fmt.Errorf("failed to write variable cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write variable cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write variable cache: %w", err)
+	}
+	return os.Rename(tmp.Name(), cachePath)
+}
+
+// removeVariableCache deletes the variable cache, e.g. after loading failed
+func (vl *VariableLoader) removeVariableCache() {
+	os.Remove(VariableCachePath(vl.basePath))
+}
+
+// sameHashes reports whether two sets of file hashes are identical
+func sameHashes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, hash := range a {
+		if b[path] != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeCachedValue restores the types YAML decoding produces from a decoded
+// JSON value, so cached variables behave like freshly loaded ones in templates
+func normalizeCachedValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeCachedValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeCachedValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
@@ -1,13 +1,18 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
 )
@@ -17,8 +22,21 @@ type Config struct {
 	Metadata *Metadata `yaml:"metadata" json:"metadata"`
 	Paths    *Paths    `yaml:"paths" json:"paths"`
 	Settings *Settings `yaml:"settings" json:"settings"`
+
+	Notifications []*Notification `yaml:"notifications,omitempty" json:"notifications,omitempty"` // where apply reports its outcome
+}
+
+// Notification is a target apply reports its outcome to
+type Notification struct {
+	Type     string   `yaml:"type" json:"type"`           // "webhook" or "desktop"
+	URL      string   `yaml:"url" json:"url"`             // URL a webhook posts the apply report to
+	Token    string   `yaml:"token" json:"token"`         // bearer token of a webhook, $VARIABLES are expanded
+	NotifyOn []string `yaml:"notify_on" json:"notify_on"` // "changes", "failures" or "always", changes and failures when empty
 }
 
+// NotificationEvents are the values notify_on accepts
+var NotificationEvents = []string{"changes", "failures", "always"}
+
 // Metadata contains information about the dotfiles repository
 type Metadata struct {
 	Name        string `yaml:"name" json:"name"`
@@ -37,14 +55,39 @@ type Paths struct {
 	FilesDir       string `yaml:"files_dir" json:"files_dir"`
 	ScriptsDir     string `yaml:"scripts_dir" json:"scripts_dir"`
 	BackupDir      string `yaml:"backup_dir" json:"backup_dir"`
+	TemplatesDir   string `yaml:"templates_dir" json:"templates_dir"` // searched for includes of pongo2 templates, default files_dir/templates
 }
 
 // Settings contains global configuration settings
 type Settings struct {
-	LogLevel      string `yaml:"log_level" json:"log_level"`
-	DryRun        bool   `yaml:"dry_run" json:"dry_run"`
-	CreateBackups bool   `yaml:"create_backups" json:"create_backups"`
-	AutoUpdate    bool   `yaml:"auto_update" json:"auto_update"`
+	LogLevel            string   `yaml:"log_level" json:"log_level"`
+	DryRun              bool     `yaml:"dry_run" json:"dry_run"`
+	CreateBackups       bool     `yaml:"create_backups" json:"create_backups"`
+	AutoUpdate          bool     `yaml:"auto_update" json:"auto_update"`
+	SecretsFile         string   `yaml:"secrets_file" json:"secrets_file"`
+	SecretCommand       string   `yaml:"secret_command" json:"secret_command"`
+	DefaultTaskTimeout  string   `yaml:"default_task_timeout" json:"default_task_timeout"`   // e.g. "10m", empty for no timeout
+	DefaultRetries      int      `yaml:"default_retries" json:"default_retries"`             // Times failed tasks run again, 0 to not retry
+	DefaultRetryDelay   string   `yaml:"default_retry_delay" json:"default_retry_delay"`     // Wait before running a failed task again, default 5s
+	DefaultRetryBackoff bool     `yaml:"default_retry_backoff" json:"default_retry_backoff"` // Whether the wait doubles after every retry
+	SudoCommand         string   `yaml:"sudo_command" json:"sudo_command"`                   // e.g. "doas", empty for sudo
+	AgeIdentity         string   `yaml:"age_identity" json:"age_identity"`                   // age identity file for *.enc.yaml variable files
+	Profiles            []string `yaml:"profiles" json:"profiles"`                           // profiles --profile can select, used by validate to catch typos
+	DefaultProfiles     []string `yaml:"default_profiles" json:"default_profiles"`           // profiles selected when --profile is not given
+	StateDir            string   `yaml:"state_dir" json:"state_dir"`                         // state and caches of this machine, empty for XDG_STATE_HOME/dotfiles
+	StrictImports       bool     `yaml:"strict_imports" json:"strict_imports"`               // imports whose path does not resolve fail instead of being skipped
+	LegacyOrdering      bool     `yaml:"legacy_ordering" json:"legacy_ordering"`             // actions of a jobs file run sorted by name instead of in file order, temporary
+	SlowApplyThreshold  string   `yaml:"slow_apply_threshold" json:"slow_apply_threshold"`   // apply shows its slowest tasks when it runs longer, default 30s
+	Offline             bool     `yaml:"offline" json:"offline"`                             // every command runs as with --offline
+
+	PackageManagers PackageManagerSettings `yaml:"package_managers" json:"package_managers"` // global package manager preferences
+}
+
+// PackageManagerSettings are the package manager preferences of every package task.
+// The prefer and only options of a task take precedence over them.
+type PackageManagerSettings struct {
+	Prefer  []string `yaml:"prefer" json:"prefer"`   // managers picked before the platform order
+	Exclude []string `yaml:"exclude" json:"exclude"` // managers never picked unless a task lists them in only
 }
 
 // ImportContext tracks import chain and provides context for processing
@@ -57,17 +100,23 @@ type ImportContext struct {
 
 // VariableSource tracks where a variable came from for debugging
 type VariableSource struct {
-	Key            string      `json:"key"`
-	RawValue       interface{} `json:"raw_value"`       // Original template value
-	ProcessedValue interface{} `json:"processed_value"` // Rendered template value
-	Source         string      `json:"source"`          // File path where this variable was defined
-	Line           int         `json:"line"`            // Line number in source file
+	Key            string         `json:"key"`
+	RawValue       interface{}    `json:"raw_value"`       // Original template value
+	ProcessedValue interface{}    `json:"processed_value"` // Rendered template value
+	Source         string         `json:"source"`          // File path where this variable was defined
+	Line           int            `json:"line"`            // Line number in source file
+	Lines          map[string]int `json:"lines,omitempty"` // Line numbers of nested keys, by their path below Key
+	Tier           VariableTier   `json:"tier"`            // Precedence tier of the source file
+	Encrypted      bool           `json:"encrypted"`       // Whether the source file is encrypted
 }
 
 // ImportFile represents a file that can be imported with conditions
 type ImportFile struct {
 	Path      string                 `yaml:"path" json:"path"`
+	Repo      string                 `yaml:"repo" json:"repo"` // Git repository the path is in, relative to its root
+	Ref       string                 `yaml:"ref" json:"ref"`   // Branch, tag or commit of the repository
 	Condition string                 `yaml:"condition" json:"condition"`
+	Profiles  []string               `yaml:"profiles" json:"profiles"`
 	Variables map[string]interface{} `yaml:"variables" json:"variables"`
 }
 
@@ -97,12 +146,39 @@ func NormalizeImports(imports []ImportSpec) ([]ImportFile, error) {
 				return nil, fmt.Errorf("import[%d] must have a 'path' field", i)
 			}
 
+			if repo, exists := v["repo"]; exists {
+				if repoStr, ok := repo.(string); ok && repoStr != "" {
+					importFile.Repo = repoStr
+				} else {
+					return nil, fmt.Errorf("import[%d].repo must be a git URL", i)
+				}
+			}
+
+			if ref, exists := v["ref"]; exists {
+				refStr, ok := ref.(string)
+				if !ok {
+					return nil, fmt.Errorf("import[%d].ref must be a branch, tag or commit", i)
+				}
+				if importFile.Repo == "" {
+					return nil, fmt.Errorf("import[%d].ref needs a repo", i)
+				}
+				importFile.Ref = refStr
+			}
+
 			if condition, exists := v["condition"]; exists {
 				if condStr, ok := condition.(string); ok {
 					importFile.Condition = condStr
 				}
 			}
 
+			if profiles, exists := v["profiles"]; exists {
+				profileList, err := ParseProfiles(profiles)
+				if err != nil {
+					return nil, fmt.Errorf("import[%d].%w", i, err)
+				}
+				importFile.Profiles = profileList
+			}
+
 			if variables, exists := v["variables"]; exists {
 				if varMap, ok := variables.(map[string]interface{}); ok {
 					importFile.Variables = varMap
@@ -118,26 +194,131 @@ func NormalizeImports(imports []ImportSpec) ([]ImportFile, error) {
 	return result, nil
 }
 
+// ParseProfiles converts the profiles field of a task or import, a single name or a
+// list of names, to a list
+func ParseProfiles(value interface{}) ([]string, error) {
+	return parseNames("profiles", value)
+}
+
+// ParseTags converts the tags field of a task, a single name or a list of names, to a list
+func ParseTags(value interface{}) ([]string, error) {
+	return parseNames("tags", value)
+}
+
+// ParseNotify converts the notify field of a task, a single handler name or a list of
+// names, to a list
+func ParseNotify(value interface{}) ([]string, error) {
+	return parseNames("notify", value)
+}
+
+// parseNames converts a field holding a single name or a list of names to a list
+func parseNames(field string, value interface{}) ([]string, error) {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		names = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of names, got %v", field, item)
+			}
+			names = append(names, name)
+		}
+	case []string:
+		names = append(names, v...)
+	default:
+		return nil, fmt.Errorf("%s must be a name or a list of names, got %T", field, value)
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if names[i] == "" {
+			return nil, fmt.Errorf("%s must not contain empty names", field)
+		}
+	}
+	return names, nil
+}
+
 // VariableIndex represents the structure of variables/index.yaml
 type VariableIndex struct {
 	Imports   []ImportSpec           `yaml:"imports" json:"imports"`
 	Variables map[string]interface{} `yaml:"variables" json:"variables"`
+
+	Node *yaml.Node `yaml:"-" json:"-"` // Mapping the file was decoded from, for the lines variables are defined at
 }
 
 // JobsIndex represents the structure of jobs/index.yaml
 type JobsIndex struct {
-	Imports []ImportSpec           `yaml:"imports" json:"imports"`
-	Jobs    map[string]interface{} `yaml:",inline" json:"jobs"`
+	Imports  []ImportSpec           `yaml:"imports" json:"imports"`
+	Handlers yaml.Node              `yaml:"handlers" json:"-"` // Kept as a node for the order of the handlers
+	Jobs     map[string]interface{} `yaml:",inline" json:"jobs"`
+
+	Node *yaml.Node `yaml:"-" json:"-"` // Mapping the file was decoded from, for the lines jobs start at
 }
 
 // Task represents a single task to be executed
 type Task struct {
-	ID        string                 `json:"id"`
-	Action    string                 `json:"action"`
-	Config    map[string]interface{} `json:"config"`
-	Condition string                 `json:"condition,omitempty"`
-	Source    string                 `json:"source,omitempty"`
-	Order     int                    `json:"order"`
+	ID           string                 `json:"id"`
+	Action       string                 `json:"action"`
+	Config       map[string]interface{} `json:"config"`
+	Condition    string                 `json:"condition,omitempty"`
+	Timeout      string                 `json:"timeout,omitempty"`
+	Retries      *int                   `json:"retries,omitempty"`       // Times the task runs again after failing, nil for settings.default_retries
+	RetryDelay   string                 `json:"retry_delay,omitempty"`   // Wait before running it again, e.g. "10s"
+	RetryBackoff *bool                  `json:"retry_backoff,omitempty"` // Whether the wait doubles after every retry
+	Become       bool                   `json:"become,omitempty"`        // Whether the task runs with root or Administrator rights
+	Profiles     []string               `json:"profiles,omitempty"`
+	Tags         []string               `json:"tags,omitempty"`
+	Notify       []string               `json:"notify,omitempty"`
+	Source       string                 `json:"source,omitempty"`
+	Line         int                    `json:"line,omitempty"` // Line the task starts at in Source, 0 when unknown
+	Order        int                    `json:"order"`
+	Variables    map[string]interface{} `json:"variables,omitempty"` // Variables of the imports the task is in
+}
+
+// ScopedVariables returns the variables the task is rendered and evaluated with: the
+// variables of the imports the task is in merged over variables
+func (t *Task) ScopedVariables(variables map[string]interface{}) map[string]interface{} {
+	if len(t.Variables) == 0 {
+		return variables
+	}
+	return OverlayVariables(variables, t.Variables)
+}
+
+// OverlayVariables merges overlay over variables into a new map. Maps are merged key
+// by key, other values of overlay replace those of variables. Neither map is changed.
+func OverlayVariables(variables, overlay map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(variables)+len(overlay))
+	for key, value := range variables {
+		result[key] = value
+	}
+	for key, value := range overlay {
+		existing, existingIsMap := result[key].(map[string]interface{})
+		valueMap, valueIsMap := value.(map[string]interface{})
+		if existingIsMap && valueIsMap {
+			result[key] = OverlayVariables(existing, valueMap)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// Location returns where the task is defined as "file:line", or just the file when
+// the line is unknown
+func (t *Task) Location() string {
+	if t.Source == "" || t.Line == 0 {
+		return t.Source
+	}
+	return fmt.Sprintf("%s:%d", t.Source, t.Line)
+}
+
+// Handler is a named run_command that apply runs once at the end when a task
+// notifying it changed something
+type Handler struct {
+	Name string `json:"name"`
+	Task *Task  `json:"task"`
 }
 
 // FileMapping defines how a source file should be mapped to a target location
@@ -201,6 +382,7 @@ func DefaultConfig() *Config {
 			DryRun:        false,
 			CreateBackups: true,
 			AutoUpdate:    false,
+			SecretsFile:   "~/.config/dotfiles/secrets.yaml",
 		},
 	}
 }
@@ -264,9 +446,11 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Unmarshal into config struct
+	// Unmarshal into config struct using the yaml tags so snake_case keys map correctly
 	config := DefaultConfig()
-	if err := v.Unmarshal(config); err != nil {
+	if err := v.Unmarshal(config, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+	}); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
@@ -275,6 +459,14 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	// Code that only knows the repository looks the state directory up by it
+	basePath := filepath.Dir(expandedPath)
+	stateDir, err := config.GetStateDir(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid settings.state_dir: %w", err)
+	}
+	registerStateDir(basePath, stateDir)
+
 	return config, nil
 }
 
@@ -340,6 +532,62 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("paths.jobs_index is required")
 	}
 
+	if _, err := c.GetDefaultTaskTimeout(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetDefaultRetryDelay(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetSlowApplyThreshold(); err != nil {
+		return err
+	}
+
+	if c.Settings != nil && c.Settings.DefaultRetries < 0 {
+		return fmt.Errorf("settings.default_retries must be 0 or more, got %d", c.Settings.DefaultRetries)
+	}
+
+	for _, manager := range c.Settings.PackageManagers.Prefer {
+		if slices.Contains(c.Settings.PackageManagers.Exclude, manager) {
+			return fmt.Errorf("settings.package_managers: '%s' cannot be both preferred and excluded", manager)
+		}
+	}
+
+	for i, notification := range c.Notifications {
+		if err := notification.Validate(); err != nil {
+			return fmt.Errorf("notifications[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates a notification target
+func (n *Notification) Validate() error {
+	if n == nil {
+		return fmt.Errorf("notification cannot be empty")
+	}
+
+	switch n.Type {
+	case "webhook":
+		if n.URL == "" {
+			return fmt.Errorf("url is required for webhook notifications")
+		}
+		if !strings.HasPrefix(n.URL, "http://") && !strings.HasPrefix(n.URL, "https://") {
+			return fmt.Errorf("url must start with http:// or https://, got '%s'", n.URL)
+		}
+	case "desktop":
+	default:
+		return fmt.Errorf("type must be 'webhook' or 'desktop', got '%s'", n.Type)
+	}
+
+	for _, event := range n.NotifyOn {
+		if !slices.Contains(NotificationEvents, event) {
+			return fmt.Errorf("notify_on must only contain %s, got '%s'", strings.Join(NotificationEvents, ", "), event)
+		}
+	}
+
 	return nil
 }
 
@@ -366,6 +614,11 @@ func (c *Config) GetVariablesIndexPath(basePath string) string {
 	return filepath.Join(basePath, c.Paths.VariablesDir, c.Paths.VariablesIndex)
 }
 
+// GetVariablesSchemaPath returns the full path to the optional variable schema
+func (c *Config) GetVariablesSchemaPath(basePath string) string {
+	return filepath.Join(basePath, c.Paths.VariablesDir, VariablesSchemaFile)
+}
+
 // GetJobsPath returns the full path to the jobs directory
 func (c *Config) GetJobsPath(basePath string) string {
 	return filepath.Join(basePath, c.Paths.JobsDir)
@@ -381,27 +634,157 @@ func (c *Config) GetFilesPath(basePath string) string {
 	return filepath.Join(basePath, c.Paths.FilesDir)
 }
 
+// GetTemplatesPath returns the full path to the directory the include, extends and
+// import tags of templates rendered with the pongo2 engine search
+func (c *Config) GetTemplatesPath(basePath string) string {
+	if c.Paths.TemplatesDir == "" {
+		return filepath.Join(c.GetFilesPath(basePath), "templates")
+	}
+	return filepath.Join(basePath, c.Paths.TemplatesDir)
+}
+
 // GetScriptsPath returns the full path to the scripts directory
 func (c *Config) GetScriptsPath(basePath string) string {
 	return filepath.Join(basePath, c.Paths.ScriptsDir)
 }
 
-// FindConfigFile searches for a configuration file in common locations
-func FindConfigFile() (string, error) {
+// GetBackupPath returns the full path to the backup directory, expanding ~ and
+// resolving relative paths against the dotfiles directory
+func (c *Config) GetBackupPath(basePath string) (string, error) {
+	backupDir := c.Paths.BackupDir
+	if backupDir == "" {
+		backupDir = "~/.dotfiles-backup"
+	}
+	if !strings.HasPrefix(backupDir, "~") && !filepath.IsAbs(backupDir) {
+		backupDir = filepath.Join(basePath, backupDir)
+	}
+	return utils.ExpandPath(backupDir)
+}
+
+// GetSecretsPath returns the full path to the secrets file, expanding ~ and
+// resolving relative paths against the dotfiles directory
+func (c *Config) GetSecretsPath(basePath string) (string, error) {
+	if c.Settings == nil || c.Settings.SecretsFile == "" {
+		return "", nil
+	}
+	secretsFile := c.Settings.SecretsFile
+	if !strings.HasPrefix(secretsFile, "~") && !filepath.IsAbs(secretsFile) {
+		secretsFile = filepath.Join(basePath, secretsFile)
+	}
+	return utils.ExpandPath(secretsFile)
+}
+
+// GetAgeIdentityPath returns the full path to the age identity file, expanding ~
+// and resolving relative paths against the dotfiles directory
+func (c *Config) GetAgeIdentityPath(basePath string) (string, error) {
+	if c.Settings == nil || c.Settings.AgeIdentity == "" {
+		return "", nil
+	}
+	identityFile := c.Settings.AgeIdentity
+	if !strings.HasPrefix(identityFile, "~") && !filepath.IsAbs(identityFile) {
+		identityFile = filepath.Join(basePath, identityFile)
+	}
+	return utils.ExpandPath(identityFile)
+}
+
+// GetDefaultTaskTimeout returns the timeout applied to tasks without their own
+// timeout, or 0 when tasks may run indefinitely
+func (c *Config) GetDefaultTaskTimeout() (time.Duration, error) {
+	if c.Settings == nil || c.Settings.DefaultTaskTimeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(c.Settings.DefaultTaskTimeout)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("settings.default_task_timeout must be a positive duration like \"10m\", got '%s'", c.Settings.DefaultTaskTimeout)
+	}
+	return timeout, nil
+}
+
+// DefaultRetryDelay is how long a failed task waits before it runs again when
+// neither the task nor settings.default_retry_delay say otherwise
+const DefaultRetryDelay = 5 * time.Second
+
+// GetDefaultRetryDelay returns how long a failed task waits before it runs again,
+// for tasks without their own retry_delay
+func (c *Config) GetDefaultRetryDelay() (time.Duration, error) {
+	if c.Settings == nil || c.Settings.DefaultRetryDelay == "" {
+		return DefaultRetryDelay, nil
+	}
+	delay, err := time.ParseDuration(c.Settings.DefaultRetryDelay)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("settings.default_retry_delay must be a duration like \"10s\", got '%s'", c.Settings.DefaultRetryDelay)
+	}
+	return delay, nil
+}
+
+// DefaultSlowApplyThreshold is how long apply runs before it shows its slowest
+// tasks when settings.slow_apply_threshold is not set
+const DefaultSlowApplyThreshold = 30 * time.Second
+
+// GetSlowApplyThreshold returns how long apply runs before its summary shows the
+// slowest tasks and the time spent per module
+func (c *Config) GetSlowApplyThreshold() (time.Duration, error) {
+	if c.Settings == nil || c.Settings.SlowApplyThreshold == "" {
+		return DefaultSlowApplyThreshold, nil
+	}
+	threshold, err := time.ParseDuration(c.Settings.SlowApplyThreshold)
+	if err != nil || threshold < 0 {
+		return 0, fmt.Errorf("settings.slow_apply_threshold must be a duration like \"30s\", got '%s'", c.Settings.SlowApplyThreshold)
+	}
+	return threshold, nil
+}
+
+// GetProfiles returns the profiles to apply: the ones selected on the command line,
+// or settings.default_profiles when none were
+func (c *Config) GetProfiles(selected []string) []string {
+	if len(selected) > 0 {
+		return selected
+	}
+	if c.Settings == nil {
+		return nil
+	}
+	return c.Settings.DefaultProfiles
+}
+
+// ConfigEnvVar is the environment variable naming the configuration file to use
+// instead of searching for one
+const ConfigEnvVar = "DOTFILES_CONFIG"
+
+// ConfigNotFoundError is returned when no configuration file is found in any of
+// the locations searched
+type ConfigNotFoundError struct {
+	Searched []string // Paths looked at, in order
+}
+
+func (e *ConfigNotFoundError) Error() string {
+	return "no configuration file found in common locations"
+}
+
+// IsConfigNotFoundError reports whether err is a ConfigNotFoundError
+func IsConfigNotFoundError(err error) (*ConfigNotFoundError, bool) {
+	var notFound *ConfigNotFoundError
+	if errors.As(err, &notFound) {
+		return notFound, true
+	}
+	return nil, false
+}
+
+// ConfigSearchPaths returns the locations FindConfigFile searches, in order of
+// preference
+func ConfigSearchPaths() ([]string, error) {
 	// Get current working directory
 	cwd, err := os.Getwd()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Get home directory
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Search locations in order of preference
-	searchPaths := []string{
+	return []string{
 		filepath.Join(cwd, "dotfiles.yaml"),
 		filepath.Join(cwd, "dotfiles.yml"),
 		filepath.Join(cwd, ".dotfiles.yaml"),
@@ -410,6 +793,14 @@ func FindConfigFile() (string, error) {
 		filepath.Join(homeDir, ".dotfiles", "dotfiles.yml"),
 		filepath.Join(homeDir, ".config", "dotfiles", "dotfiles.yaml"),
 		filepath.Join(homeDir, ".config", "dotfiles", "dotfiles.yml"),
+	}, nil
+}
+
+// FindConfigFile searches for a configuration file in common locations
+func FindConfigFile() (string, error) {
+	searchPaths, err := ConfigSearchPaths()
+	if err != nil {
+		return "", err
 	}
 
 	for _, path := range searchPaths {
@@ -418,7 +809,33 @@ func FindConfigFile() (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("no configuration file found in common locations")
+	return "", &ConfigNotFoundError{Searched: searchPaths}
+}
+
+// ResolveConfigFile returns the configuration file to use: path when it is not
+// empty (the --config flag), else the file DOTFILES_CONFIG names, else the one
+// FindConfigFile finds. A file that is named but does not exist is an error, it
+// is not searched for elsewhere.
+func ResolveConfigFile(path string) (string, error) {
+	origin := "--config"
+	if path == "" {
+		path, origin = os.Getenv(ConfigEnvVar), ConfigEnvVar
+	}
+	if path == "" {
+		return FindConfigFile()
+	}
+
+	path, err := utils.ExpandPath(path)
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+	if !utils.FileExists(path) {
+		return "", fmt.Errorf("configuration file %s given with %s does not exist", path, origin)
+	}
+	return path, nil
 }
 
 // LoadVariableIndex loads and parses a variables index file
@@ -433,7 +850,7 @@ func LoadVariableIndex(indexPath string) (*VariableIndex, error) {
 	}
 
 	var index VariableIndex
-	if err := yaml.Unmarshal(data, &index); err != nil {
+	if index.Node, err = decodeDocument(data, &index); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal variables index: %w", err)
 	}
 
@@ -452,9 +869,67 @@ func LoadJobsIndex(indexPath string) (*JobsIndex, error) {
 	}
 
 	var index JobsIndex
-	if err := yaml.Unmarshal(data, &index); err != nil {
+	if index.Node, err = decodeDocument(data, &index); err != nil {
 		return nil, fmt.Errorf("failed to parse jobs index: %w", err)
 	}
 
 	return &index, nil
 }
+
+// decodeDocument decodes a YAML document into out and returns the node it was
+// decoded from, nil when the document is empty
+func decodeDocument(data []byte, out interface{}) (*yaml.Node, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	if err := root.Content[0].Decode(out); err != nil {
+		return nil, err
+	}
+	return root.Content[0], nil
+}
+
+// keyLines returns the line every key of a YAML node is defined at, by its dotted
+// path, e.g. "user.name". Items of sequences are numbered from 0.
+func keyLines(node *yaml.Node) map[string]int {
+	lines := make(map[string]int)
+	var walk func(node *yaml.Node, prefix string)
+	walk = func(node *yaml.Node, prefix string) {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				path := prefix + node.Content[i].Value
+				lines[path] = node.Content[i].Line
+				walk(node.Content[i+1], path+".")
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				path := prefix + strconv.Itoa(i)
+				lines[path] = item.Line
+				walk(item, path+".")
+			}
+		}
+	}
+	if node != nil {
+		walk(node, "")
+	}
+	return lines
+}
+
+// subLines returns the lines of the keys below path, by their path relative to it
+func subLines(lines map[string]int, path string) map[string]int {
+	var sub map[string]int
+	prefix := path + "."
+	for key, line := range lines {
+		if strings.HasPrefix(key, prefix) {
+			if sub == nil {
+				sub = make(map[string]int)
+			}
+			sub[strings.TrimPrefix(key, prefix)] = line
+		}
+	}
+	return sub
+}
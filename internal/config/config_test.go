@@ -0,0 +1,302 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfiles(t *testing.T) {
+	profiles, err := ParseProfiles("work")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"work"}, profiles)
+
+	profiles, err = ParseProfiles([]interface{}{"work", " laptop "})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"work", "laptop"}, profiles)
+
+	_, err = ParseProfiles([]interface{}{"work", 1})
+	assert.Error(t, err)
+
+	_, err = ParseProfiles([]interface{}{""})
+	assert.Error(t, err)
+
+	_, err = ParseProfiles(true)
+	assert.Error(t, err)
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags([]interface{}{"shell", " git"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shell", "git"}, tags)
+
+	_, err = ParseTags(map[string]interface{}{})
+	assert.EqualError(t, err, "tags must be a name or a list of names, got map[string]interface {}")
+}
+
+func TestLoadJobsIndexHandlers(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.yaml")
+	require.NoError(t, os.WriteFile(indexPath, []byte(`handlers:
+  reload tmux: tmux source-file ~/.tmux.conf
+  refresh fonts:
+    command: fc-cache -f
+symlink:
+  - src: files/tmux.conf
+    dst: ~/.tmux.conf
+    notify: reload tmux
+`), 0644))
+
+	index, err := LoadJobsIndex(indexPath)
+	require.NoError(t, err)
+	assert.NotContains(t, index.Jobs, "handlers")
+	assert.Contains(t, index.Jobs, "symlink")
+
+	// The order of the handlers is kept
+	require.Len(t, index.Handlers.Content, 4)
+	assert.Equal(t, "reload tmux", index.Handlers.Content[0].Value)
+	assert.Equal(t, "refresh fonts", index.Handlers.Content[2].Value)
+}
+
+func TestLoadJobsIndexNode(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.yaml")
+	require.NoError(t, os.WriteFile(indexPath, []byte("ensure_dir:\n  - ~/.config\n  - ~/.cache\n"), 0644))
+
+	index, err := LoadJobsIndex(indexPath)
+	require.NoError(t, err)
+	require.NotNil(t, index.Node)
+	assert.Equal(t, 3, keyLines(index.Node)["ensure_dir.1"])
+
+	// An empty file has no jobs and no node
+	require.NoError(t, os.WriteFile(indexPath, nil, 0644))
+	index, err = LoadJobsIndex(indexPath)
+	require.NoError(t, err)
+	assert.Nil(t, index.Node)
+	assert.Empty(t, index.Jobs)
+}
+
+func TestTaskLocation(t *testing.T) {
+	assert.Equal(t, "jobs/git.yaml:14", (&Task{Source: "jobs/git.yaml", Line: 14}).Location())
+	assert.Equal(t, "jobs/git.yaml", (&Task{Source: "jobs/git.yaml"}).Location())
+	assert.Equal(t, "", (&Task{Line: 3}).Location())
+}
+
+func TestOverlayVariables(t *testing.T) {
+	variables := map[string]interface{}{
+		"toolchain": "none",
+		"tool":      map[string]interface{}{"version": "1.0", "arch": "amd64"},
+	}
+	overlay := map[string]interface{}{
+		"toolchain": "go",
+		"tool":      map[string]interface{}{"version": "1.22"},
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"toolchain": "go",
+		"tool":      map[string]interface{}{"version": "1.22", "arch": "amd64"},
+	}, OverlayVariables(variables, overlay))
+	assert.Equal(t, "1.0", variables["tool"].(map[string]interface{})["version"])
+
+	task := &Task{}
+	assert.Equal(t, variables, task.ScopedVariables(variables))
+	task.Variables = overlay
+	assert.Equal(t, "go", task.ScopedVariables(variables)["toolchain"])
+}
+
+func TestParseNotify(t *testing.T) {
+	notify, err := ParseNotify("reload tmux")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"reload tmux"}, notify)
+
+	_, err = ParseNotify(1)
+	assert.EqualError(t, err, "notify must be a name or a list of names, got int")
+}
+
+func TestNormalizeImportsProfiles(t *testing.T) {
+	imports, err := NormalizeImports([]ImportSpec{
+		"common.yaml",
+		map[string]interface{}{"path": "work.yaml", "profiles": []interface{}{"work"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, imports, 2)
+	assert.Empty(t, imports[0].Profiles)
+	assert.Equal(t, []string{"work"}, imports[1].Profiles)
+
+	_, err = NormalizeImports([]ImportSpec{
+		map[string]interface{}{"path": "work.yaml", "profiles": 42},
+	})
+	assert.ErrorContains(t, err, "import[0].profiles")
+}
+
+func TestGetProfiles(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.Empty(t, cfg.GetProfiles(nil))
+
+	cfg.Settings.DefaultProfiles = []string{"personal"}
+	assert.Equal(t, []string{"personal"}, cfg.GetProfiles(nil))
+	assert.Equal(t, []string{"work"}, cfg.GetProfiles([]string{"work"}))
+}
+
+func TestGetStateDir(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+	basePath := filepath.Join(t.TempDir(), "dotfiles")
+
+	cfg := DefaultConfig()
+	stateDir, err := cfg.GetStateDir(basePath)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(stateHome, "dotfiles"), filepath.Dir(stateDir))
+	assert.True(t, strings.HasPrefix(filepath.Base(stateDir), "dotfiles-"))
+
+	// Every repository gets a directory of its own
+	other, err := cfg.GetStateDir(filepath.Join(t.TempDir(), "dotfiles"))
+	require.NoError(t, err)
+	assert.NotEqual(t, stateDir, other)
+
+	cfg.Settings.StateDir = ".cache"
+	stateDir, err = cfg.GetStateDir(basePath)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(basePath, ".cache"), stateDir)
+}
+
+func TestLoadRegistersStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	configPath := filepath.Join(basePath, "dotfiles.yaml")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("settings:\n  state_dir: .state\n"), 0644))
+	_, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(basePath, ".state"), StateDir(basePath))
+	assert.Equal(t, filepath.Join(basePath, ".state", "variables.json"), VariableCachePath(basePath))
+}
+
+func TestValidatePackageManagers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Settings.PackageManagers = PackageManagerSettings{Prefer: []string{"scoop"}, Exclude: []string{"chocolatey"}}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Settings.PackageManagers.Exclude = append(cfg.Settings.PackageManagers.Exclude, "scoop")
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "'scoop' cannot be both preferred and excluded")
+}
+
+func TestValidateNotifications(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Notifications = []*Notification{
+		{Type: "webhook", URL: "https://hooks.example.com/dotfiles", NotifyOn: []string{"failures"}},
+		{Type: "desktop"},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	tests := []struct {
+		name         string
+		notification *Notification
+		err          string
+	}{
+		{"UnknownType", &Notification{Type: "email"}, "type must be 'webhook' or 'desktop'"},
+		{"WebhookWithoutURL", &Notification{Type: "webhook"}, "url is required"},
+		{"WebhookURLScheme", &Notification{Type: "webhook", URL: "hooks.example.com"}, "url must start with http://"},
+		{"UnknownEvent", &Notification{Type: "desktop", NotifyOn: []string{"success"}}, "notify_on must only contain changes, failures, always"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.Notifications = []*Notification{tt.notification}
+			err := cfg.Validate()
+			assert.ErrorContains(t, err, "notifications[0]: "+tt.err)
+		})
+	}
+}
+
+func TestGetDefaultRetryDelay(t *testing.T) {
+	cfg := DefaultConfig()
+	delay, err := cfg.GetDefaultRetryDelay()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultRetryDelay, delay)
+
+	cfg.Settings.DefaultRetryDelay = "30s"
+	delay, err = cfg.GetDefaultRetryDelay()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, delay)
+
+	cfg.Settings.DefaultRetryDelay = "soon"
+	assert.ErrorContains(t, cfg.Validate(), "settings.default_retry_delay")
+
+	cfg.Settings.DefaultRetryDelay = ""
+	cfg.Settings.DefaultRetries = -1
+	assert.ErrorContains(t, cfg.Validate(), "settings.default_retries")
+}
+
+func TestGetSlowApplyThreshold(t *testing.T) {
+	cfg := DefaultConfig()
+	threshold, err := cfg.GetSlowApplyThreshold()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSlowApplyThreshold, threshold)
+
+	cfg.Settings.SlowApplyThreshold = "2m"
+	threshold, err = cfg.GetSlowApplyThreshold()
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, threshold)
+
+	cfg.Settings.SlowApplyThreshold = "slow"
+	assert.ErrorContains(t, cfg.Validate(), "settings.slow_apply_threshold")
+}
+
+func TestResolveConfigFile(t *testing.T) {
+	home, work := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv(ConfigEnvVar, "")
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(work))
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	// Nothing to find
+	_, err = ResolveConfigFile("")
+	notFound, ok := IsConfigNotFoundError(err)
+	require.True(t, ok, "error = %v, want a ConfigNotFoundError", err)
+	assert.Contains(t, notFound.Searched, filepath.Join(home, ".dotfiles", "dotfiles.yaml"))
+
+	writeConfig := func(path string) string {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte("metadata:\n  name: test\n"), 0644))
+		return path
+	}
+	searched := writeConfig(filepath.Join(home, ".dotfiles", "dotfiles.yaml"))
+	fromEnv := writeConfig(filepath.Join(t.TempDir(), "env.yaml"))
+	fromFlag := writeConfig(filepath.Join(t.TempDir(), "flag.yaml"))
+
+	path, err := ResolveConfigFile("")
+	require.NoError(t, err)
+	assert.Equal(t, searched, path, "without --config and DOTFILES_CONFIG the file is searched for")
+
+	t.Setenv(ConfigEnvVar, fromEnv)
+	path, err = ResolveConfigFile("")
+	require.NoError(t, err)
+	assert.Equal(t, fromEnv, path, "DOTFILES_CONFIG wins over searching")
+
+	path, err = ResolveConfigFile(fromFlag)
+	require.NoError(t, err)
+	assert.Equal(t, fromFlag, path, "--config wins over DOTFILES_CONFIG")
+
+	// Relative paths are relative to the working directory
+	writeConfig(filepath.Join(work, "repo", "dotfiles.yaml"))
+	path, err = ResolveConfigFile(filepath.Join("repo", "dotfiles.yaml"))
+	require.NoError(t, err)
+	assert.True(t, filepath.IsAbs(path), "path %s is not absolute", path)
+	assert.Equal(t, "repo", filepath.Base(filepath.Dir(path)))
+
+	// A file that is named but missing is not searched for elsewhere
+	_, err = ResolveConfigFile(filepath.Join(work, "missing.yaml"))
+	assert.ErrorContains(t, err, "given with --config does not exist")
+	t.Setenv(ConfigEnvVar, filepath.Join(work, "missing.yaml"))
+	_, err = ResolveConfigFile("")
+	assert.ErrorContains(t, err, "given with DOTFILES_CONFIG does not exist")
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+)
+
+// The engines a template context section can be used in
+const (
+	EngineImports    = "imports"    // Import paths and conditions in variables/index.yaml
+	EngineVariables  = "variables"  // Templates in variable values
+	EngineConditions = "conditions" // Task and job conditions
+	EngineTemplates  = "templates"  // Template files and templated task config
+)
+
+// ContextSection is a top-level key of the template context
+type ContextSection struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Engines     []string `json:"engines"`
+}
+
+// VariablesSection is the name of the section holding the loaded variables, which
+// are top-level keys of the context rather than a map of their own
+const VariablesSection = "Variables"
+
+// TemplateContextSections are the sections of the template context in the order
+// they are documented
+var TemplateContextSections = []ContextSection{
+	{Name: "Platform", Description: "The platform dotfiles runs on", Engines: []string{EngineImports, EngineVariables, EngineConditions, EngineTemplates}},
+	{Name: "User", Description: "The user dotfiles runs as", Engines: []string{EngineImports, EngineVariables, EngineConditions, EngineTemplates}},
+	{Name: "Env", Description: "The environment variables of the process", Engines: []string{EngineImports, EngineVariables, EngineConditions, EngineTemplates}},
+	{Name: VariablesSection, Description: "The loaded variables, by their own name", Engines: []string{EngineVariables, EngineConditions, EngineTemplates}},
+}
+
+// PlatformContext returns the Platform section of the template context: every
+// field of info by its Go name. Hostname is added by NewTemplateContext.
+func PlatformContext(info *platform.PlatformInfo) map[string]interface{} {
+	context := make(map[string]interface{})
+	value := reflect.ValueOf(info).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if field := value.Type().Field(i); field.IsExported() {
+			context[field.Name] = value.Field(i).Interface()
+		}
+	}
+	return context
+}
+
+// NewTemplateContext returns the Platform, Env and User sections of the template
+// context for info, with the overrides of opts. It is the context import
+// conditions are evaluated in and that every loaded variable set starts from, so
+// conditions, variables and templates all see the same fields.
+func NewTemplateContext(info *platform.PlatformInfo, opts *VariableLoadOptions) map[string]interface{} {
+	if opts == nil {
+		opts = &VariableLoadOptions{}
+	}
+
+	platformInfo := PlatformContext(info)
+	if opts.Platform != "" {
+		platformInfo["OS"] = opts.Platform
+	}
+	if opts.Shell != "" {
+		platformInfo["Shell"] = opts.Shell
+	}
+	if opts.Hostname != "" {
+		platformInfo["Hostname"] = opts.Hostname
+	} else if hostname, err := os.Hostname(); err == nil {
+		platformInfo["Hostname"] = hostname
+	}
+
+	env := opts.Environment
+	if env == nil {
+		env = make(map[string]string)
+		for _, envVar := range os.Environ() {
+			parts := strings.SplitN(envVar, "=", 2)
+			if len(parts) == 2 {
+				env[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	user := make(map[string]interface{})
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		user["Home"] = homeDir
+	}
+
+	return map[string]interface{}{
+		"Platform": platformInfo,
+		"Env":      env,
+		"User":     user,
+	}
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// EncryptedVariablesSuffix marks variable files that are encrypted with age
+const EncryptedVariablesSuffix = ".enc.yaml"
+
+// PassphraseEnvVar holds the passphrase encrypted variable files are decrypted with
+// when no age identity is configured
+const PassphraseEnvVar = "DOTFILES_PASSPHRASE"
+
+// RedactedValue replaces values decrypted from encrypted variable files in output
+const RedactedValue = "<redacted>"
+
+// IsEncryptedVariableFile reports whether a variable file is encrypted
+func IsEncryptedVariableFile(path string) bool {
+	return strings.HasSuffix(path, EncryptedVariablesSuffix)
+}
+
+// EncryptedVariablesPath returns the encrypted counterpart of a plaintext variable
+// file, e.g. variables/tokens.yaml becomes variables/tokens.enc.yaml
+func EncryptedVariablesPath(path string) string {
+	for _, ext := range []string{".yaml", ".yml"} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext) + EncryptedVariablesSuffix
+		}
+	}
+	return path + EncryptedVariablesSuffix
+}
+
+// VariableCipher encrypts and decrypts variable files with an age identity file or
+// a passphrase
+type VariableCipher struct {
+	IdentityPath string // age identity file from settings.age_identity, empty when not configured
+	Passphrase   string // Passphrase from DOTFILES_PASSPHRASE, empty when not set
+
+	scryptWorkFactor int // scrypt work factor for passphrase encryption, 0 for the age default
+}
+
+// NewVariableCipher creates a cipher using the age identity configured in the
+// settings and the passphrase in the environment
+func NewVariableCipher(config *Config, basePath string) (*VariableCipher, error) {
+	identityPath, err := config.GetAgeIdentityPath(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve age identity path: %w", err)
+	}
+	return &VariableCipher{
+		IdentityPath: identityPath,
+		Passphrase:   os.Getenv(PassphraseEnvVar),
+	}, nil
+}
+
+// Methods describes the ways the cipher can decrypt, e.g. for error messages
+func (c *VariableCipher) Methods() string {
+	var methods []string
+	if c.IdentityPath != "" {
+		methods = append(methods, fmt.Sprintf("age identity %s", c.IdentityPath))
+	}
+	if c.Passphrase != "" {
+		methods = append(methods, fmt.Sprintf("passphrase from %s", PassphraseEnvVar))
+	}
+	if len(methods) == 0 {
+		return "no decryption method"
+	}
+	return strings.Join(methods, " and ")
+}
+
+// Encrypt encrypts plaintext to the identities in the age identity file, or with
+// the passphrase when no identity is configured. The result is ASCII armored so it
+// diffs and merges like any other text file.
+func (c *VariableCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	recipients, err := c.recipients()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+	w, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decrypt decrypts the content of an encrypted variable file. Errors name the file
+// and the methods that were attempted, never the ciphertext.
+func (c *VariableCipher) Decrypt(path string, ciphertext []byte) ([]byte, error) {
+	if c.IdentityPath == "" && c.Passphrase == "" {
+		return nil, fmt.Errorf("cannot decrypt %s: set settings.age_identity or the %s environment variable", path, PassphraseEnvVar)
+	}
+
+	identities, err := c.identities()
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %w", path, err)
+	}
+
+	var src io.Reader = bytes.NewReader(ciphertext)
+	if bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s using %s: %w", path, c.Methods(), decryptError(err))
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s using %s: %w", path, c.Methods(), decryptError(err))
+	}
+	return plaintext, nil
+}
+
+// DecryptFile reads and decrypts an encrypted variable file
+func (c *VariableCipher) DecryptFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+	return c.Decrypt(path, data)
+}
+
+// recipients returns who Encrypt encrypts to, preferring the age identity file
+func (c *VariableCipher) recipients() ([]age.Recipient, error) {
+	if c.IdentityPath != "" {
+		identities, err := c.parseIdentityFile()
+		if err != nil {
+			return nil, err
+		}
+
+		var recipients []age.Recipient
+		for _, identity := range identities {
+			if x25519, ok := identity.(*age.X25519Identity); ok {
+				recipients = append(recipients, x25519.Recipient())
+			}
+		}
+		if len(recipients) == 0 {
+			return nil, fmt.Errorf("age identity file %s contains no X25519 identities", c.IdentityPath)
+		}
+		return recipients, nil
+	}
+
+	if c.Passphrase != "" {
+		recipient, err := age.NewScryptRecipient(c.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase in %s: %w", PassphraseEnvVar, err)
+		}
+		if c.scryptWorkFactor > 0 {
+			recipient.SetWorkFactor(c.scryptWorkFactor)
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	return nil, fmt.Errorf("nothing to encrypt with: set settings.age_identity or the %s environment variable", PassphraseEnvVar)
+}
+
+// identities returns every identity Decrypt may use
+func (c *VariableCipher) identities() ([]age.Identity, error) {
+	var identities []age.Identity
+	if c.IdentityPath != "" {
+		parsed, err := c.parseIdentityFile()
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, parsed...)
+	}
+	if c.Passphrase != "" {
+		identity, err := age.NewScryptIdentity(c.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase in %s: %w", PassphraseEnvVar, err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+// parseIdentityFile reads the identities from the age identity file
+func (c *VariableCipher) parseIdentityFile() ([]age.Identity, error) {
+	file, err := os.Open(c.IdentityPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open age identity file: %w", err)
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse age identity file %s: %w", c.IdentityPath, err)
+	}
+	return identities, nil
+}
+
+// decryptError turns age errors into something actionable
+func decryptError(err error) error {
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return errors.New("no identity matched, the file was encrypted for a different key or passphrase")
+	}
+	return err
+}
+
+// Redacted returns a copy of the source with its values redacted when it comes
+// from an encrypted variable file
+func (s *VariableSource) Redacted() *VariableSource {
+	if !s.Encrypted {
+		return s
+	}
+	redacted := *s
+	redacted.RawValue = RedactValue(s.RawValue)
+	redacted.ProcessedValue = RedactValue(s.ProcessedValue)
+	return &redacted
+}
+
+// RedactValue replaces every scalar in a value, keeping the keys of maps so the
+// shape of the value can still be inspected
+func RedactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[key] = RedactValue(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = RedactValue(item)
+		}
+		return redacted
+	default:
+		return RedactedValue
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCipher creates a passphrase cipher that encrypts quickly
+func newTestCipher(passphrase string) *VariableCipher {
+	return &VariableCipher{Passphrase: passphrase, scryptWorkFactor: 10}
+}
+
+func TestEncryptedVariablesPath(t *testing.T) {
+	assert.Equal(t, "variables/tokens.enc.yaml", EncryptedVariablesPath("variables/tokens.yaml"))
+	assert.Equal(t, "tokens.enc.yaml", EncryptedVariablesPath("tokens.yml"))
+	assert.True(t, IsEncryptedVariableFile("variables/tokens.enc.yaml"))
+	assert.False(t, IsEncryptedVariableFile("variables/tokens.yaml"))
+}
+
+func TestVariableCipherPassphrase(t *testing.T) {
+	plaintext := []byte("github_token: \"ghp_secret\"\n")
+
+	ciphertext, err := newTestCipher("correct horse").Encrypt(plaintext)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(ciphertext), armor.Header))
+	assert.NotContains(t, string(ciphertext), "ghp_secret")
+
+	decrypted, err := newTestCipher("correct horse").Decrypt("tokens.enc.yaml", ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	_, err = newTestCipher("wrong").Decrypt("tokens.enc.yaml", ciphertext)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tokens.enc.yaml")
+	assert.Contains(t, err.Error(), "passphrase from "+PassphraseEnvVar)
+	assert.NotContains(t, err.Error(), strings.TrimSpace(strings.Split(string(ciphertext), "\n")[1]))
+
+	_, err = (&VariableCipher{}).Decrypt("tokens.enc.yaml", ciphertext)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "settings.age_identity")
+}
+
+func TestVariableCipherIdentity(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	identityPath := filepath.Join(t.TempDir(), "key.txt")
+	require.NoError(t, os.WriteFile(identityPath, []byte("# test key\n"+identity.String()+"\n"), 0600))
+
+	cipher := &VariableCipher{IdentityPath: identityPath}
+	ciphertext, err := cipher.Encrypt([]byte("token: abc\n"))
+	require.NoError(t, err)
+
+	decrypted, err := cipher.Decrypt("tokens.enc.yaml", ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "token: abc\n", string(decrypted))
+
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	otherPath := filepath.Join(t.TempDir(), "other.txt")
+	require.NoError(t, os.WriteFile(otherPath, []byte(other.String()+"\n"), 0600))
+
+	_, err = (&VariableCipher{IdentityPath: otherPath}).Decrypt("tokens.enc.yaml", ciphertext)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "age identity "+otherPath)
+}
+
+func TestEncryptedVariableFile(t *testing.T) {
+	t.Setenv(PassphraseEnvVar, "correct horse")
+	ciphertext, err := newTestCipher("correct horse").Encrypt([]byte("tokens:\n  github: \"ghp_secret\"\n"))
+	require.NoError(t, err)
+
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml":      "imports:\n  - path: \"global.yaml\"\n  - path: \"tokens.enc.yaml\"\n",
+		"global.yaml":     "editor: \"nvim\"\n",
+		"tokens.enc.yaml": string(ciphertext),
+	})
+
+	loader, err := NewVariableLoader(DefaultConfig(), basePath)
+	require.NoError(t, err)
+	variables, err := loader.LoadAllVariables(&VariableLoadOptions{UseCache: true})
+	require.NoError(t, err)
+
+	token, _ := loader.GetVariable("tokens.github", variables)
+	assert.Equal(t, "ghp_secret", token)
+	assert.NoFileExists(t, VariableCachePath(basePath), "decrypted variables must not be cached")
+
+	traces := loader.TraceVariable("tokens.github")
+	require.Len(t, traces, 1)
+	assert.True(t, traces[0].Encrypted)
+	assert.Equal(t, RedactedValue, traces[0].Redacted().ProcessedValue)
+	assert.Equal(t, "ghp_secret", traces[0].ProcessedValue)
+
+	editor := WinningSource(loader.TraceVariable("editor"))
+	require.NotNil(t, editor)
+	assert.Same(t, editor, editor.Redacted())
+
+	t.Run("WrongPassphrase", func(t *testing.T) {
+		t.Setenv(PassphraseEnvVar, "wrong")
+		loader, err := NewVariableLoader(DefaultConfig(), basePath)
+		require.NoError(t, err)
+		_, err = loader.LoadAllVariables(&VariableLoadOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), filepath.Join(basePath, "variables", "tokens.enc.yaml"))
+		assert.NotContains(t, err.Error(), "ghp_secret")
+	})
+}
+
+func TestRedactValue(t *testing.T) {
+	redacted := RedactValue(map[string]interface{}{
+		"token": "abc",
+		"hosts": []interface{}{"a", 1},
+	})
+	assert.Equal(t, map[string]interface{}{
+		"token": RedactedValue,
+		"hosts": []interface{}{RedactedValue, RedactedValue},
+	}, redacted)
+	assert.Nil(t, RedactValue(nil))
+}
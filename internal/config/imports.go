@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+)
+
+// unresolvedImports are the imports whose path does not resolve
+var unresolvedImports = struct {
+	sync.Mutex
+	strict  bool
+	skipped []*UnresolvedImportError
+	seen    map[string]bool // Imports warned about, by source and path
+}{seen: make(map[string]bool)}
+
+// UnresolvedImportError is an import whose path references variables that are not
+// defined, such as a misspelled {{ Platform.OS }}
+type UnresolvedImportError struct {
+	Path       string // Path of the import as written
+	Source     string // Where the import is defined, as "file:line"
+	Expression string // What does not resolve
+}
+
+func (e *UnresolvedImportError) Error() string {
+	return fmt.Sprintf("import '%s' in %s does not resolve: %s", e.Path, e.Source, e.Expression)
+}
+
+// ConfigureStrictImports sets whether imports whose path does not resolve are
+// errors. Otherwise they are reported with a warning.
+func ConfigureStrictImports(strict bool) {
+	unresolvedImports.Lock()
+	defer unresolvedImports.Unlock()
+	unresolvedImports.strict = strict
+}
+
+// StrictImports reports whether imports whose path does not resolve are errors
+func StrictImports() bool {
+	unresolvedImports.Lock()
+	defer unresolvedImports.Unlock()
+	return unresolvedImports.strict
+}
+
+// UnresolvedImports returns the imports found so far whose path does not resolve,
+// each once
+func UnresolvedImports() []*UnresolvedImportError {
+	unresolvedImports.Lock()
+	defer unresolvedImports.Unlock()
+	return append([]*UnresolvedImportError(nil), unresolvedImports.skipped...)
+}
+
+// unresolvedPlaceholder matches what is left of template expressions that did not render
+var unresolvedPlaceholder = regexp.MustCompile(`\{\{.*?\}\}|<no value>`)
+
+// CheckImportPath checks that the path of an import resolved. path is the import
+// path as written and rendered the path after rendering it with variables. In
+// strict mode a path that references variables that are not defined is an
+// *UnresolvedImportError. Otherwise it is reported once with a warning, and skip
+// tells the caller to leave the import out when placeholders are left in the path.
+func CheckImportPath(engine *templating.TemplatingEngine, path, rendered, source string, variables map[string]interface{}) (skip bool, err error) {
+	expression, placeholder := unresolvedExpression(engine, path, rendered, variables)
+	if expression == "" {
+		return false, nil
+	}
+	unresolved := &UnresolvedImportError{Path: path, Source: source, Expression: expression}
+
+	unresolvedImports.Lock()
+	defer unresolvedImports.Unlock()
+	if unresolvedImports.strict {
+		return true, unresolved
+	}
+	key := source + "\x00" + path
+	if !unresolvedImports.seen[key] {
+		unresolvedImports.seen[key] = true
+		unresolvedImports.skipped = append(unresolvedImports.skipped, unresolved)
+		action := "Import path does not resolve"
+		if placeholder {
+			action = "Skipping import"
+		}
+		logger.Get().Warn().Str("import", path).Str("source", source).Msgf("%s: %s (set strict_imports or use --strict to make this an error)", action, expression)
+	}
+	return placeholder, nil
+}
+
+// unresolvedExpression returns what in an import path does not resolve, empty when
+// everything does. Templates render undefined variables as nothing, so the path is
+// checked for references to them besides placeholders left in the rendered path,
+// which placeholder reports.
+func unresolvedExpression(engine *templating.TemplatingEngine, path, rendered string, variables map[string]interface{}) (expression string, placeholder bool) {
+	if left := unresolvedPlaceholder.FindString(rendered); left != "" {
+		return fmt.Sprintf("'%s' is left in the path", left), true
+	}
+	if !engine.IsTemplateContent(path) {
+		return "", false
+	}
+	for _, issue := range engine.CheckTemplate(path, variables) {
+		if issue.Warning {
+			continue
+		}
+		message := issue.Message
+		if issue.Suggestion != "" {
+			message += fmt.Sprintf(", did you mean '%s'?", issue.Suggestion)
+		}
+		return message, false
+	}
+	return "", false
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AddTasksToJobsYAML appends tasks to the list of an action in the content of a
+// jobs file, adding the action when the file has none. Comments, key order and
+// indentation of the rest of the file are kept.
+func AddTasksToJobsYAML(content []byte, action string, tasks []interface{}) ([]byte, error) {
+	doc, mapping, header, err := parseJobsDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	list := jobsMappingValue(mapping, action)
+	if list == nil {
+		list = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: action}, list)
+	}
+	if list.Kind == yaml.ScalarNode && list.Tag == "!!null" {
+		*list = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", HeadComment: list.HeadComment, LineComment: list.LineComment}
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: %s must be a list of tasks", list.Line, action)
+	}
+
+	for _, task := range tasks {
+		var node yaml.Node
+		if err := node.Encode(task); err != nil {
+			return nil, fmt.Errorf("failed to format task: %w", err)
+		}
+		list.Content = append(list.Content, &node)
+	}
+	return encodeJobsDocument(doc, header, content)
+}
+
+// AddImportToJobsYAML adds an import to the content of a jobs file. It returns the
+// content unchanged and false when the file already imports path.
+func AddImportToJobsYAML(content []byte, path string) ([]byte, bool, error) {
+	doc, mapping, header, err := parseJobsDocument(content)
+	if err != nil {
+		return nil, false, err
+	}
+
+	imports := jobsMappingValue(mapping, "imports")
+	if imports == nil {
+		imports = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		// Imports come first, like the tasks they bring in
+		mapping.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: "imports"}, imports}, mapping.Content...)
+	}
+	if imports.Kind != yaml.SequenceNode {
+		return nil, false, fmt.Errorf("line %d: imports must be a list", imports.Line)
+	}
+
+	for _, item := range imports.Content {
+		existing := item.Value
+		if item.Kind == yaml.MappingNode {
+			if value := jobsMappingValue(item, "path"); value != nil {
+				existing = value.Value
+			}
+		}
+		if existing == path {
+			return content, false, nil
+		}
+	}
+
+	var node yaml.Node
+	if err := node.Encode(map[string]string{"path": path}); err != nil {
+		return nil, false, fmt.Errorf("failed to format import: %w", err)
+	}
+	imports.Content = append(imports.Content, &node)
+
+	updated, err := encodeJobsDocument(doc, header, content)
+	return updated, err == nil, err
+}
+
+// parseJobsDocument parses the content of a jobs file, returning the document and
+// its top level mapping. Files without any tasks get an empty mapping, and their
+// comments are returned as a header to keep.
+func parseJobsDocument(content []byte) (*yaml.Node, *yaml.Node, []byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse jobs: %w", err)
+	}
+
+	var header []byte
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		header = bytes.TrimRight(content, "\n")
+		if len(header) > 0 {
+			header = append(header, "\n\n"...)
+		}
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	mapping := doc.Content[0]
+	if mapping.Kind == yaml.ScalarNode && mapping.Tag == "!!null" {
+		doc.Content[0] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: mapping.HeadComment}
+		mapping = doc.Content[0]
+	}
+	if mapping.Kind != yaml.MappingNode {
+		return nil, nil, nil, fmt.Errorf("line %d: jobs must be a mapping of actions to tasks", mapping.Line)
+	}
+	return &doc, mapping, header, nil
+}
+
+// encodeJobsDocument formats a jobs document after its header, with the indentation
+// of its original content
+func encodeJobsDocument(doc *yaml.Node, header, content []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(header)
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(jobsIndent(content))
+	if err := encoder.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to format jobs: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to format jobs: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// jobsIndent detects the indentation of a jobs file from its first indented line.
+// Lists of tasks are indented by the same amount as nested keys, files without any
+// use two spaces.
+func jobsIndent(content []byte) int {
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || len(trimmed) == len(line) || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		return len(line) - len(trimmed)
+	}
+	return 2
+}
+
+// jobsMappingValue returns the value of a key in a mapping node, nil when it has none
+func jobsMappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
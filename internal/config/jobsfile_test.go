@@ -0,0 +1,88 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adoptedTask is an ensure_file task in the order its fields are written
+type adoptedTask struct {
+	Path          string `yaml:"path"`
+	ContentSource string `yaml:"content_source"`
+	Render        bool   `yaml:"render"`
+}
+
+func TestAddTasksToJobsYAML(t *testing.T) {
+	t.Run("AppendsToExistingAction", func(t *testing.T) {
+		content := `# Shell
+ensure_file:
+  - path: "~/.bashrc" # bash
+    content_source: files/configs/.bashrc
+symlink:
+  - src: files/bin/tool
+    dst: ~/bin/tool
+`
+		updated, err := AddTasksToJobsYAML([]byte(content), "ensure_file", []interface{}{
+			adoptedTask{Path: "~/.zshrc", ContentSource: "files/configs/.zshrc"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, `# Shell
+ensure_file:
+  - path: "~/.bashrc" # bash
+    content_source: files/configs/.bashrc
+  - path: ~/.zshrc
+    content_source: files/configs/.zshrc
+    render: false
+symlink:
+  - src: files/bin/tool
+    dst: ~/bin/tool
+`, string(updated))
+	})
+
+	t.Run("FileWithOnlyComments", func(t *testing.T) {
+		updated, err := AddTasksToJobsYAML([]byte("# Adopted files\n"), "ensure_file", []interface{}{
+			adoptedTask{Path: "~/.vimrc", ContentSource: "files/configs/.vimrc", Render: true},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, `# Adopted files
+
+ensure_file:
+  - path: ~/.vimrc
+    content_source: files/configs/.vimrc
+    render: true
+`, string(updated))
+	})
+
+	t.Run("ActionIsNotAList", func(t *testing.T) {
+		_, err := AddTasksToJobsYAML([]byte("ensure_file: ~/.vimrc\n"), "ensure_file", []interface{}{adoptedTask{}})
+		assert.ErrorContains(t, err, "must be a list")
+	})
+}
+
+func TestAddImportToJobsYAML(t *testing.T) {
+	content := `imports:
+  - common.yaml
+  - path: linux.yaml
+    condition: 'Platform.OS == "linux"'
+
+ensure_dir:
+  - ~/.ssh
+`
+
+	updated, added, err := AddImportToJobsYAML([]byte(content), "linux.yaml")
+	require.NoError(t, err)
+	assert.False(t, added)
+	assert.Equal(t, content, string(updated))
+
+	updated, added, err = AddImportToJobsYAML([]byte(content), "adopted.yaml")
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.Contains(t, string(updated), "    condition: 'Platform.OS == \"linux\"'\n  - path: adopted.yaml\n")
+
+	updated, added, err = AddImportToJobsYAML([]byte("ensure_dir:\n  - ~/.ssh\n"), "adopted.yaml")
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "imports:\n  - path: adopted.yaml\nensure_dir:\n  - ~/.ssh\n", string(updated))
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// remoteImports tracks the checkouts of remote imports used by this run, so every
+// repository is updated at most once even when both variables and jobs import it
+var remoteImports = struct {
+	sync.Mutex
+	offline   bool
+	checkouts map[string]string // Checkout directory -> "<repo>@<ref>"
+}{checkouts: make(map[string]string)}
+
+// RemoteImportStatus is the state of the cached checkout of a remote import
+type RemoteImportStatus struct {
+	Repo     string `json:"repo"`
+	Ref      string `json:"ref,omitempty"`
+	Commit   string `json:"commit"`
+	Upstream string `json:"upstream,omitempty"` // Commit of the ref upstream, empty when not looked up
+	Behind   bool   `json:"behind"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ConfigureRemoteImports sets whether remote imports may be fetched. Offline, the
+// cached checkouts are used as they are.
+func ConfigureRemoteImports(offline bool) {
+	remoteImports.Lock()
+	defer remoteImports.Unlock()
+	remoteImports.offline = offline
+}
+
+// RemoteImportsPath returns the directory remote imports are checked out in
+func RemoteImportsPath(basePath string) string {
+	return filepath.Join(StateDir(basePath), "imports")
+}
+
+// remoteImportDir returns the checkout directory of a repository at a ref
+func remoteImportDir(basePath, repo, ref string) string {
+	sum := sha256.Sum256([]byte(repo + "\x00" + ref))
+	return filepath.Join(RemoteImportsPath(basePath), hex.EncodeToString(sum[:8]))
+}
+
+// FetchRemoteImport returns the checkout of a repository at ref, which is a branch,
+// tag or commit, or the default branch when empty. The repository is cloned into
+// the cache the first time and updated once per run after that. When updating
+// fails the cached checkout is used.
+func FetchRemoteImport(basePath, repo, ref string) (string, error) {
+	remoteImports.Lock()
+	defer remoteImports.Unlock()
+
+	dir := remoteImportDir(basePath, repo, ref)
+	if _, fetched := remoteImports.checkouts[dir]; fetched {
+		return dir, nil
+	}
+
+	name := remoteImportName(repo, ref)
+	cached := utils.DirExists(filepath.Join(dir, ".git"))
+	switch {
+	case remoteImports.offline && !cached:
+		return "", fmt.Errorf("cannot import from %s in offline mode, it has not been fetched yet", name)
+	case remoteImports.offline:
+		// The cached checkout is used as it is
+	case !cached:
+		if err := cloneRemoteImport(dir, repo, ref); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to fetch %s and there is no cached copy: %w", name, err)
+		}
+	default:
+		if err := updateRemoteImport(dir, ref); err != nil {
+			logger.Get().Warn().Err(err).Str("import", name).Msg("Failed to update remote import, using the cached copy")
+		}
+	}
+
+	remoteImports.checkouts[dir] = name
+	return dir, nil
+}
+
+// RemoteImportSource describes a file in the checkout of a remote import as
+// "<path> (<repo>@<ref>)", or returns "" for other files
+func RemoteImportSource(path string) string {
+	remoteImports.Lock()
+	defer remoteImports.Unlock()
+
+	for dir, name := range remoteImports.checkouts {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return fmt.Sprintf("%s (%s)", filepath.ToSlash(rel), name)
+		}
+	}
+	return ""
+}
+
+// remoteImportName names a repository at a ref for messages
+func remoteImportName(repo, ref string) string {
+	if ref == "" {
+		return repo
+	}
+	return repo + "@" + ref
+}
+
+// refreshRemoteImport updates the checkout a cached file of a remote import is in,
+// so the variable cache notices changes upstream. Other files are left alone.
+func refreshRemoteImport(basePath, path string) error {
+	rel, err := filepath.Rel(RemoteImportsPath(basePath), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	dir := filepath.Join(RemoteImportsPath(basePath), strings.SplitN(filepath.ToSlash(rel), "/", 2)[0])
+	repo, err := runGit(dir, "config", "--get", "dotfiles.repo")
+	if err != nil {
+		return err
+	}
+	ref, _ := runGit(dir, "config", "--get", "dotfiles.ref")
+	_, err = FetchRemoteImport(basePath, repo, ref)
+	return err
+}
+
+// cloneRemoteImport clones a repository and checks out ref. The repository and ref
+// are kept in the git configuration of the checkout for dotfiles status.
+func cloneRemoteImport(dir, repo, ref string) error {
+	if err := utils.EnsureDir(filepath.Dir(dir)); err != nil {
+		return fmt.Errorf("failed to create import cache directory: %w", err)
+	}
+	if _, err := runGit("", "clone", "--quiet", "--no-checkout", repo, dir); err != nil {
+		return err
+	}
+	if _, err := runGit(dir, "config", "dotfiles.repo", repo); err != nil {
+		return err
+	}
+	if _, err := runGit(dir, "config", "dotfiles.ref", ref); err != nil {
+		return err
+	}
+	return checkoutRemoteImport(dir, ref)
+}
+
+// updateRemoteImport fetches the latest commits and checks out ref again
+func updateRemoteImport(dir, ref string) error {
+	if _, err := runGit(dir, "fetch", "--quiet", "--tags", "--force", "origin"); err != nil {
+		return err
+	}
+	return checkoutRemoteImport(dir, ref)
+}
+
+// checkoutRemoteImport checks out ref, preferring the branch of origin over a local
+// ref with the same name
+func checkoutRemoteImport(dir, ref string) error {
+	candidates := []string{"origin/HEAD"}
+	if ref != "" {
+		candidates = []string{"origin/" + ref, ref}
+	}
+	for _, candidate := range candidates {
+		commit, err := runGit(dir, "rev-parse", "--verify", "--quiet", candidate+"^{commit}")
+		if err != nil {
+			continue
+		}
+		_, err = runGit(dir, "checkout", "--quiet", "--force", "--detach", commit)
+		return err
+	}
+	return fmt.Errorf("ref '%s' does not exist in the repository", ref)
+}
+
+// RemoteImportStatuses lists the cached checkouts of remote imports. With check,
+// the upstream ref is looked up to tell whether a checkout is behind it.
+func RemoteImportStatuses(basePath string, check bool) ([]*RemoteImportStatus, error) {
+	entries, err := os.ReadDir(RemoteImportsPath(basePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import cache: %w", err)
+	}
+
+	var statuses []*RemoteImportStatus
+	for _, entry := range entries {
+		dir := filepath.Join(RemoteImportsPath(basePath), entry.Name())
+		if !entry.IsDir() || !utils.DirExists(filepath.Join(dir, ".git")) {
+			continue
+		}
+
+		status := &RemoteImportStatus{}
+		status.Repo, _ = runGit(dir, "config", "--get", "dotfiles.repo")
+		status.Ref, _ = runGit(dir, "config", "--get", "dotfiles.ref")
+		status.Commit, err = runGit(dir, "rev-parse", "HEAD")
+		if err != nil {
+			status.Error = err.Error()
+		} else if check {
+			status.Upstream, err = remoteImportUpstream(dir, status.Ref)
+			if err != nil {
+				status.Error = err.Error()
+			}
+			status.Behind = status.Upstream != "" && status.Upstream != status.Commit
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return remoteImportName(statuses[i].Repo, statuses[i].Ref) < remoteImportName(statuses[j].Repo, statuses[j].Ref)
+	})
+	return statuses, nil
+}
+
+// remoteImportUpstream looks up the commit a branch or tag points to upstream. It
+// returns "" for refs that are commits, which never move.
+func remoteImportUpstream(dir, ref string) (string, error) {
+	pattern := "HEAD"
+	if ref != "" {
+		pattern = ref
+	}
+	output, err := runGit(dir, "ls-remote", "origin", pattern)
+	if err != nil {
+		return "", err
+	}
+
+	var upstream string
+	for _, line := range strings.Split(output, "\n") {
+		commit, name, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		switch name {
+		case "HEAD", "refs/heads/" + ref, "refs/tags/" + ref:
+			if upstream == "" {
+				upstream = commit
+			}
+		case "refs/tags/" + ref + "^{}":
+			// Annotated tags point to the tag object, the peeled line to the commit
+			upstream = commit
+		}
+	}
+	return upstream, nil
+}
+
+// runGit runs git in dir and returns its trimmed output
+func runGit(dir string, args ...string) (string, error) {
+	command := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("git %s: %s", command, message)
+		}
+		return "", fmt.Errorf("git: %w", err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
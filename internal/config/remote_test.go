@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUpstreamRepo creates a git repository with a variables file to import
+func newUpstreamRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	gitCommit(t, repo, "shared.yaml", "editor: vim\n")
+	return repo
+}
+
+// gitCommit writes a file to a repository and commits it
+func gitCommit(t *testing.T, repo, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(repo, name), []byte(content), 0644))
+	for _, args := range [][]string{
+		{"init", "--quiet", "--initial-branch=main"},
+		{"add", name},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "Update " + name},
+	} {
+		output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+}
+
+// resetRemoteImports forgets the checkouts used so far, like a new run
+func resetRemoteImports(offline bool) {
+	remoteImports.checkouts = make(map[string]string)
+	remoteImports.offline = offline
+}
+
+func TestFetchRemoteImport(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	repo := newUpstreamRepo(t)
+	basePath := t.TempDir()
+	resetRemoteImports(false)
+	defer resetRemoteImports(false)
+
+	checkout, err := FetchRemoteImport(basePath, repo, "main")
+	require.NoError(t, err)
+	assert.Equal(t, RemoteImportsPath(basePath), filepath.Dir(checkout))
+	assert.FileExists(t, filepath.Join(checkout, "shared.yaml"))
+	assert.Equal(t, "shared.yaml ("+repo+"@main)", RemoteImportSource(filepath.Join(checkout, "shared.yaml")))
+	assert.Empty(t, RemoteImportSource(filepath.Join(basePath, "variables", "index.yaml")))
+
+	// Updates happen once per run
+	gitCommit(t, repo, "shared.yaml", "editor: nvim\n")
+	_, err = FetchRemoteImport(basePath, repo, "main")
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(checkout, "shared.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "editor: vim\n", string(content))
+
+	statuses, err := RemoteImportStatuses(basePath, true)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, repo, statuses[0].Repo)
+	assert.Equal(t, "main", statuses[0].Ref)
+	assert.True(t, statuses[0].Behind)
+
+	resetRemoteImports(false)
+	_, err = FetchRemoteImport(basePath, repo, "main")
+	require.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(checkout, "shared.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "editor: nvim\n", string(content))
+
+	statuses, err = RemoteImportStatuses(basePath, true)
+	require.NoError(t, err)
+	assert.False(t, statuses[0].Behind)
+}
+
+func TestFetchRemoteImportOffline(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	repo := newUpstreamRepo(t)
+	basePath := t.TempDir()
+	resetRemoteImports(true)
+	defer resetRemoteImports(false)
+
+	_, err := FetchRemoteImport(basePath, repo, "")
+	assert.EqualError(t, err, "cannot import from "+repo+" in offline mode, it has not been fetched yet")
+
+	// Offline runs use the cached checkout
+	resetRemoteImports(false)
+	_, err = FetchRemoteImport(basePath, repo, "")
+	require.NoError(t, err)
+	resetRemoteImports(true)
+	checkout, err := FetchRemoteImport(basePath, repo, "")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(checkout, "shared.yaml"))
+}
+
+func TestFetchRemoteImportErrors(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	repo := newUpstreamRepo(t)
+	basePath := t.TempDir()
+	resetRemoteImports(false)
+	defer resetRemoteImports(false)
+
+	_, err := FetchRemoteImport(basePath, filepath.Join(basePath, "missing"), "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "and there is no cached copy")
+
+	_, err = FetchRemoteImport(basePath, repo, "no-such-branch")
+	assert.EqualError(t, err, "failed to fetch "+repo+"@no-such-branch and there is no cached copy: ref 'no-such-branch' does not exist in the repository")
+	assert.NoDirExists(t, remoteImportDir(basePath, repo, "no-such-branch"))
+}
+
+func TestNormalizeImportsRepo(t *testing.T) {
+	imports, err := NormalizeImports([]ImportSpec{
+		map[string]interface{}{"path": "jobs/base.yaml", "repo": "https://example.com/base.git", "ref": "v1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/base.git", imports[0].Repo)
+	assert.Equal(t, "v1", imports[0].Ref)
+
+	_, err = NormalizeImports([]ImportSpec{map[string]interface{}{"path": "base.yaml", "ref": "v1"}})
+	assert.EqualError(t, err, "import[0].ref needs a repo")
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VariablesSchemaFile is the optional schema in the variables directory
+const VariablesSchemaFile = "schema.yaml"
+
+// schemaTypes are the types a schema field can declare
+var schemaTypes = []string{"string", "bool", "int", "float", "list", "map", "any"}
+
+// templateContextKeys are added to every variable set by the loader and are never
+// reported as unknown
+var templateContextKeys = map[string]bool{"Platform": true, "Env": true, "User": true}
+
+// VariableSchema declares the variables a dotfiles repository expects
+type VariableSchema struct {
+	Strict    bool                    `yaml:"strict"`    // Report unknown keys as errors instead of warnings
+	Variables map[string]*SchemaField `yaml:"variables"` // Expected top-level variables
+}
+
+// SchemaField declares the type of a variable and, for maps, of its keys
+type SchemaField struct {
+	Type     string                  `yaml:"type"`
+	Required bool                    `yaml:"required"`
+	Keys     map[string]*SchemaField `yaml:"keys"` // Expected keys of a map, any key is allowed when empty
+}
+
+// UnmarshalYAML allows a field to be declared by its type only, e.g. `editor: string`
+func (f *SchemaField) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Type = node.Value
+		return nil
+	}
+
+	type rawField SchemaField
+	return node.Decode((*rawField)(f))
+}
+
+// SchemaIssue is a variable that does not match the schema
+type SchemaIssue struct {
+	Key     string // Dotted key of the variable
+	Message string
+	Source  string // File the offending value is defined in, empty when it is not defined
+	Line    int    // Line the offending value is defined at in Source, 0 when unknown
+	Warning bool   // Whether the issue is only a warning
+}
+
+// String formats the issue, e.g. "user.email: expected string, got map (defined in variables/global.yaml)"
+func (i *SchemaIssue) String() string {
+	if i.Source == "" {
+		return fmt.Sprintf("%s: %s", i.Key, i.Message)
+	}
+	return fmt.Sprintf("%s: %s (defined in %s)", i.Key, i.Message, i.Source)
+}
+
+// LoadVariableSchema loads a variable schema. A missing schema file is not an
+// error, nil is returned instead.
+func LoadVariableSchema(path string) (*VariableSchema, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read variable schema: %w", err)
+	}
+
+	var schema VariableSchema
+	if err := yaml.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse variable schema %s: %w", path, err)
+	}
+	if err := checkSchemaFields("", schema.Variables); err != nil {
+		return nil, fmt.Errorf("invalid variable schema %s: %w", path, err)
+	}
+	return &schema, nil
+}
+
+// checkSchemaFields makes sure every field declares a known type
+func checkSchemaFields(prefix string, fields map[string]*SchemaField) error {
+	for name, field := range fields {
+		key := prefix + name
+		if field == nil || field.Type == "" {
+			return fmt.Errorf("%s: missing type", key)
+		}
+		if !containsString(schemaTypes, field.Type) {
+			return fmt.Errorf("%s: unknown type '%s', expected one of %s", key, field.Type, strings.Join(schemaTypes, ", "))
+		}
+		if len(field.Keys) > 0 && field.Type != "map" {
+			return fmt.Errorf("%s: 'keys' can only be used with type map", key)
+		}
+		if err := checkSchemaFields(key+".", field.Keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateVariables checks the loaded variables against variables/schema.yaml.
+// Without a schema every variable set is valid.
+func (vl *VariableLoader) ValidateVariables(variables map[string]interface{}) ([]*SchemaIssue, error) {
+	schema, err := LoadVariableSchema(vl.config.GetVariablesSchemaPath(vl.basePath))
+	if err != nil || schema == nil {
+		return nil, err
+	}
+
+	var issues []*SchemaIssue
+	vl.validateFields("", schema.Variables, variables, schema.Strict, &issues)
+	return issues, nil
+}
+
+// validateFields checks the values of a map against the declared fields
+func (vl *VariableLoader) validateFields(prefix string, fields map[string]*SchemaField, values map[string]interface{}, strict bool, issues *[]*SchemaIssue) {
+	for _, name := range sortedKeys(fields) {
+		field, key := fields[name], prefix+name
+		value, exists := values[name]
+		if !exists {
+			if field.Required {
+				*issues = append(*issues, &SchemaIssue{Key: key, Message: "required variable is not defined"})
+			}
+			continue
+		}
+
+		if actual := schemaTypeOf(value); !schemaTypeMatches(field.Type, actual) {
+			source, line := vl.definedIn(key)
+			*issues = append(*issues, &SchemaIssue{
+				Key:     key,
+				Message: fmt.Sprintf("expected %s, got %s", field.Type, actual),
+				Source:  source,
+				Line:    line,
+			})
+			continue
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok && len(field.Keys) > 0 {
+			vl.validateFields(key+".", field.Keys, nested, strict, issues)
+		}
+	}
+
+	for _, name := range sortedKeys(values) {
+		if _, declared := fields[name]; declared || (prefix == "" && templateContextKeys[name]) {
+			continue
+		}
+		key := prefix + name
+		source, line := vl.definedIn(key)
+		*issues = append(*issues, &SchemaIssue{
+			Key:     key,
+			Message: "not declared in the schema",
+			Source:  source,
+			Line:    line,
+			Warning: !strict,
+		})
+	}
+}
+
+// definedIn returns the file the value of a dotted key comes from, relative to
+// the dotfiles directory, and the line it is defined at
+func (vl *VariableLoader) definedIn(key string) (string, int) {
+	winner := WinningSource(vl.TraceVariable(key))
+	if winner == nil {
+		return "", 0
+	}
+	if rel, err := filepath.Rel(vl.basePath, winner.Source); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel), winner.Line
+	}
+	return winner.Source, winner.Line
+}
+
+// schemaTypeOf returns the schema type name of a value
+func schemaTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "int"
+	case float32, float64:
+		return "float"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// schemaTypeMatches reports whether a value of the actual type satisfies the
+// expected type. Integers are valid floats.
+func schemaTypeMatches(expected, actual string) bool {
+	return expected == "any" || expected == actual || (expected == "float" && actual == "int")
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
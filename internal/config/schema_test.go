@@ -0,0 +1,90 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validateTestVariables(t *testing.T, files map[string]string) ([]*SchemaIssue, error) {
+	t.Helper()
+	basePath := writeVariableFiles(t, files)
+	loader, variables, err := loadTestVariables(t, basePath, "laptop")
+	require.NoError(t, err)
+	return loader.ValidateVariables(variables)
+}
+
+func issueStrings(issues []*SchemaIssue) []string {
+	var result []string
+	for _, issue := range issues {
+		result = append(result, issue.String())
+	}
+	return result
+}
+
+func TestValidateVariablesWithoutSchema(t *testing.T) {
+	issues, err := validateTestVariables(t, map[string]string{
+		"index.yaml": "variables:\n  anything: 1\n",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestValidateVariablesSchema(t *testing.T) {
+	files := map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+`,
+		"global.yaml": `user:
+  name: "Me"
+  email:
+    work: "me@company.com"
+editor: "nvim"
+tabs: 4
+ratio: 2
+typo_key: true
+`,
+		"schema.yaml": `variables:
+  user:
+    type: map
+    required: true
+    keys:
+      name: string
+      email: { type: string, required: true }
+      signing_key: { type: string, required: true }
+  editor: string
+  tabs: int
+  ratio: float
+  shell: { type: string, required: true }
+`,
+	}
+
+	issues, err := validateTestVariables(t, files)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"shell: required variable is not defined",
+		"user.email: expected string, got map (defined in variables/global.yaml)",
+		"user.signing_key: required variable is not defined",
+		"typo_key: not declared in the schema (defined in variables/global.yaml)",
+	}, issueStrings(issues))
+	assert.False(t, issues[0].Warning)
+	assert.True(t, issues[3].Warning)
+
+	t.Run("Strict", func(t *testing.T) {
+		files["schema.yaml"] = "strict: true\n" + files["schema.yaml"]
+		issues, err := validateTestVariables(t, files)
+		require.NoError(t, err)
+		require.Len(t, issues, 4)
+		assert.False(t, issues[3].Warning)
+	})
+}
+
+func TestInvalidVariableSchema(t *testing.T) {
+	_, err := validateTestVariables(t, map[string]string{
+		"index.yaml":  "variables:\n  editor: nvim\n",
+		"schema.yaml": "variables:\n  editor: text\n",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "editor: unknown type 'text'")
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// integerPattern matches the values ParseVariableValue reads as integers
+var integerPattern = regexp.MustCompile(`^-?[0-9]+$`)
+
+// ParseVariableValue converts a value given on the command line: true and false
+// become booleans, digits become integers and anything else, or everything when
+// asString is set, stays a string
+func ParseVariableValue(raw string, asString bool) interface{} {
+	if asString {
+		return raw
+	}
+	switch raw {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if integerPattern.MatchString(raw) {
+		if value, err := strconv.Atoi(raw); err == nil {
+			return value
+		}
+	}
+	return raw
+}
+
+// HostVariablesFile returns the host overlay of a hostname in the variables
+// directory: the existing file for the full or short hostname, or the file for the
+// full hostname when there is none yet
+func HostVariablesFile(variablesPath, hostname string) string {
+	for _, name := range hostFileNames(hostname) {
+		path := filepath.Join(variablesPath, HostsDir, name+".yaml")
+		if utils.FileExists(path) {
+			return path
+		}
+	}
+	return filepath.Join(variablesPath, HostsDir, hostname+".yaml")
+}
+
+// SetVariableInYAML sets the value at a dot notation key in the content of a
+// variables file, creating the maps on the way. Comments, key order and
+// indentation of the rest of the file are kept.
+func SetVariableInYAML(content []byte, key string, value interface{}) ([]byte, error) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid variable key '%s'", key)
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse variables: %w", err)
+	}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	mapping := doc.Content[0]
+	if mapping.Kind == yaml.ScalarNode && mapping.Tag == "!!null" {
+		doc.Content[0] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: mapping.HeadComment}
+		mapping = doc.Content[0]
+	}
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: variables must be a mapping of names to values", mapping.Line)
+	}
+
+	for i, part := range parts {
+		index := -1
+		for j := 0; j+1 < len(mapping.Content); j += 2 {
+			if mapping.Content[j].Value == part {
+				index = j
+			}
+		}
+
+		if i == len(parts)-1 {
+			var node yaml.Node
+			if err := node.Encode(value); err != nil {
+				return nil, fmt.Errorf("failed to format %s: %w", key, err)
+			}
+			if index < 0 {
+				mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part}, &node)
+				break
+			}
+			existing := mapping.Content[index+1]
+			if existing.Kind == yaml.MappingNode {
+				return nil, fmt.Errorf("cannot set %s, it is a map of other variables", key)
+			}
+			node.HeadComment, node.LineComment, node.FootComment = existing.HeadComment, existing.LineComment, existing.FootComment
+			mapping.Content[index+1] = &node
+			break
+		}
+
+		if index < 0 {
+			nested := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part}, nested)
+			mapping = nested
+			continue
+		}
+		if mapping.Content[index+1].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("cannot set %s, %s is not a map", key, strings.Join(parts[:i+1], "."))
+		}
+		mapping = mapping.Content[index+1]
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(variablesIndent(content))
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to format variables: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to format variables: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// variablesIndent detects the indentation of a variables file from its first
+// indented key. Files without nested keys use two spaces.
+func variablesIndent(content []byte) int {
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || len(trimmed) == len(line) || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		return len(line) - len(trimmed)
+	}
+	return 2
+}
+
+// LoadAllVariablesWithFile loads the variables as if a variable file had the given
+// content, without writing it. It returns the error loading would give after the
+// change, such as a *VariableConflictError with another file.
+func (vl *VariableLoader) LoadAllVariablesWithFile(path string, content []byte, opts *VariableLoadOptions) (map[string]interface{}, error) {
+	vl.overlay = map[string][]byte{path: content}
+	defer func() { vl.overlay = nil }()
+
+	uncached := VariableLoadOptions{}
+	if opts != nil {
+		uncached = *opts
+	}
+	uncached.UseCache = false
+	return vl.LoadAllVariables(&uncached)
+}
+
+// readVariableFile reads a variable file, or its content given to LoadAllVariablesWithFile
+func (vl *VariableLoader) readVariableFile(path string) ([]byte, error) {
+	if content, exists := vl.overlay[path]; exists {
+		return content, nil
+	}
+	return os.ReadFile(path)
+}
+
+// variableFileExists reports whether a variable file exists or is given to
+// LoadAllVariablesWithFile
+func (vl *VariableLoader) variableFileExists(path string) bool {
+	if _, exists := vl.overlay[path]; exists {
+		return true
+	}
+	return utils.FileExists(path)
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVariableValue(t *testing.T) {
+	assert.Equal(t, true, ParseVariableValue("true", false))
+	assert.Equal(t, false, ParseVariableValue("false", false))
+	assert.Equal(t, 8080, ParseVariableValue("8080", false))
+	assert.Equal(t, -1, ParseVariableValue("-1", false))
+	assert.Equal(t, "1.5", ParseVariableValue("1.5", false))
+	assert.Equal(t, "True", ParseVariableValue("True", false))
+	assert.Equal(t, "nvim", ParseVariableValue("nvim", false))
+	assert.Equal(t, "8080", ParseVariableValue("8080", true))
+	assert.Equal(t, "true", ParseVariableValue("true", true))
+}
+
+func TestSetVariableInYAML(t *testing.T) {
+	content := `# Global settings
+user:
+    name: menno # who
+    email: m@example.com
+editor: vim
+`
+
+	t.Run("KeepsCommentsAndOrder", func(t *testing.T) {
+		updated, err := SetVariableInYAML([]byte(content), "editor", "nvim")
+		require.NoError(t, err)
+		assert.Equal(t, `# Global settings
+user:
+    name: menno # who
+    email: m@example.com
+editor: nvim
+`, string(updated))
+
+		updated, err = SetVariableInYAML([]byte(content), "user.name", "me")
+		require.NoError(t, err)
+		assert.Contains(t, string(updated), "    name: me # who\n")
+	})
+
+	t.Run("CreatesMaps", func(t *testing.T) {
+		updated, err := SetVariableInYAML([]byte(content), "ui.theme.dark", true)
+		require.NoError(t, err)
+		assert.Contains(t, string(updated), "editor: vim\nui:\n    theme:\n        dark: true\n")
+
+		updated, err = SetVariableInYAML([]byte(content), "user.shell", "zsh")
+		require.NoError(t, err)
+		assert.Contains(t, string(updated), "    email: m@example.com\n    shell: zsh\n")
+	})
+
+	t.Run("QuotesStringsThatLookLikeOtherTypes", func(t *testing.T) {
+		updated, err := SetVariableInYAML([]byte(content), "zip", "01234")
+		require.NoError(t, err)
+		assert.Contains(t, string(updated), "zip: \"01234\"\n")
+	})
+
+	t.Run("EmptyFile", func(t *testing.T) {
+		updated, err := SetVariableInYAML(nil, "git.email", "me@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "git:\n  email: me@example.com\n", string(updated))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := SetVariableInYAML([]byte(content), "user.name.first", "x")
+		assert.EqualError(t, err, "cannot set user.name.first, user.name is not a map")
+		_, err = SetVariableInYAML([]byte(content), "user", "x")
+		assert.EqualError(t, err, "cannot set user, it is a map of other variables")
+		_, err = SetVariableInYAML([]byte(content), "user..name", "x")
+		assert.EqualError(t, err, "invalid variable key 'user..name'")
+		_, err = SetVariableInYAML([]byte("- a\n- b\n"), "a", "x")
+		assert.ErrorContains(t, err, "variables must be a mapping")
+	})
+}
+
+func TestLoadAllVariablesWithFile(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+  - path: "extra.yaml"
+`,
+		"global.yaml": "theme: \"dark\"\n",
+		"extra.yaml":  "editor: \"vim\"\n",
+	})
+	globalPath := filepath.Join(basePath, "variables", "global.yaml")
+	loader, err := NewVariableLoader(DefaultConfig(), basePath)
+	require.NoError(t, err)
+
+	variables, err := loader.LoadAllVariablesWithFile(globalPath, []byte("theme: \"light\"\n"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "light", variables["theme"])
+
+	_, err = loader.LoadAllVariablesWithFile(globalPath, []byte("theme: \"dark\"\neditor: \"nano\"\n"), nil)
+	conflict, ok := IsVariableConflictError(err)
+	require.True(t, ok, "expected a conflict, got %v", err)
+	assert.Equal(t, "editor", conflict.Variable)
+	assert.Equal(t, globalPath, conflict.ExistingSource)
+
+	// Nothing is written
+	content, err := os.ReadFile(globalPath)
+	require.NoError(t, err)
+	assert.Equal(t, "theme: \"dark\"\n", string(content))
+
+	t.Run("NewHostFile", func(t *testing.T) {
+		hostPath := HostVariablesFile(filepath.Join(basePath, "variables"), "laptop.example.com")
+		assert.Equal(t, filepath.Join(basePath, "variables", "hosts", "laptop.example.com.yaml"), hostPath)
+
+		variables, err := loader.LoadAllVariablesWithFile(hostPath, []byte("theme: \"solarized\"\n"), &VariableLoadOptions{Hostname: "laptop.example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, "solarized", variables["theme"])
+		assert.NoFileExists(t, hostPath)
+
+		// The short hostname's file is used when it exists
+		shortPath := filepath.Join(basePath, "variables", "hosts", "laptop.yaml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(shortPath), 0755))
+		require.NoError(t, os.WriteFile(shortPath, []byte("theme: \"light\"\n"), 0644))
+		assert.Equal(t, shortPath, HostVariablesFile(filepath.Join(basePath, "variables"), "laptop.example.com"))
+	})
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+const (
+	// LastAppliedFile is the marker in the state directory apply writes when it
+	// finishes, its modification time is when the dotfiles were last applied
+	LastAppliedFile = "last-applied"
+
+	// LegacyLastAppliedFile is where the marker was kept in the repository before
+	// the state directory, it is still read when present
+	LegacyLastAppliedFile = ".dotfiles-last-applied"
+)
+
+// stateDirs holds the state directories of the repositories whose dotfiles.yaml was
+// loaded, so code that only knows the repository finds settings.state_dir
+var stateDirs = struct {
+	sync.Mutex
+	dirs map[string]string // Repository -> state directory
+}{dirs: make(map[string]string)}
+
+// GetStateDir returns the directory the state and caches of the repository are kept
+// in: settings.state_dir, resolved against the dotfiles directory when relative, or
+// a directory of its own in XDG_STATE_HOME/dotfiles (%LOCALAPPDATA%\dotfiles on
+// Windows unless XDG_STATE_HOME is set)
+func (c *Config) GetStateDir(basePath string) (string, error) {
+	if c.Settings == nil || c.Settings.StateDir == "" {
+		return DefaultStateDir(basePath)
+	}
+	stateDir := c.Settings.StateDir
+	if !strings.HasPrefix(stateDir, "~") && !filepath.IsAbs(stateDir) {
+		stateDir = filepath.Join(basePath, stateDir)
+	}
+	return utils.ExpandPath(stateDir)
+}
+
+// DefaultStateDir returns the state directory of a repository without
+// settings.state_dir. Every repository gets a directory of its own, named after the
+// repository and a hash of its path.
+func DefaultStateDir(basePath string) (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" && runtime.GOOS == "windows" {
+		stateHome = os.Getenv("LOCALAPPDATA")
+	}
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		if runtime.GOOS == "windows" {
+			stateHome = filepath.Join(home, "AppData", "Local")
+		} else {
+			stateHome = filepath.Join(home, ".local", "state")
+		}
+	}
+
+	repo := stateDirKey(basePath)
+	sum := sha256.Sum256([]byte(repo))
+	return filepath.Join(stateHome, "dotfiles", filepath.Base(repo)+"-"+hex.EncodeToString(sum[:4])), nil
+}
+
+// StateDir returns the state directory of a repository. It uses settings.state_dir
+// once dotfiles.yaml is loaded and falls back to .cache in the repository when no
+// state directory can be determined.
+func StateDir(basePath string) string {
+	stateDirs.Lock()
+	dir, exists := stateDirs.dirs[stateDirKey(basePath)]
+	stateDirs.Unlock()
+	if exists {
+		return dir
+	}
+
+	dir, err := DefaultStateDir(basePath)
+	if err != nil {
+		return filepath.Join(basePath, ".cache")
+	}
+	return dir
+}
+
+// registerStateDir records the state directory of a repository for StateDir
+func registerStateDir(basePath, dir string) {
+	stateDirs.Lock()
+	defer stateDirs.Unlock()
+	stateDirs.dirs[stateDirKey(basePath)] = dir
+}
+
+// stateDirKey identifies a repository by its absolute path
+func stateDirKey(basePath string) string {
+	if abs, err := filepath.Abs(basePath); err == nil {
+		return abs
+	}
+	return filepath.Clean(basePath)
+}
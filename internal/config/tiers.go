@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// VariableTier is the precedence tier of a variable file. Values from a higher
+// tier override values from lower tiers instead of causing a conflict.
+type VariableTier int
+
+const (
+	TierGlobal      VariableTier = iota // global.yaml, the index and any other file
+	TierPlatform                        // platforms/*.yaml
+	TierEnvironment                     // environments/*.yaml
+	TierHost                            // hosts/<hostname>.yaml
+)
+
+// HostsDir is the directory below the variables directory that holds per-host overlays
+const HostsDir = "hosts"
+
+// String returns the name of the tier
+func (t VariableTier) String() string {
+	switch t {
+	case TierPlatform:
+		return "platform"
+	case TierEnvironment:
+		return "environment"
+	case TierHost:
+		return "host"
+	default:
+		return "global"
+	}
+}
+
+// MarshalText encodes the tier by name in JSON and YAML output
+func (t VariableTier) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a tier from its name
+func (t *VariableTier) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "global":
+		*t = TierGlobal
+	case "platform":
+		*t = TierPlatform
+	case "environment":
+		*t = TierEnvironment
+	case "host":
+		*t = TierHost
+	default:
+		return fmt.Errorf("unknown variable tier '%s'", text)
+	}
+	return nil
+}
+
+// sourceTier returns the tier of a variable file based on its directory
+func (vl *VariableLoader) sourceTier(source string) VariableTier {
+	rel, err := filepath.Rel(vl.config.GetVariablesPath(vl.basePath), source)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return TierGlobal
+	}
+
+	switch strings.Split(filepath.ToSlash(rel), "/")[0] {
+	case "platforms":
+		return TierPlatform
+	case "environments":
+		return TierEnvironment
+	case HostsDir:
+		return TierHost
+	default:
+		return TierGlobal
+	}
+}
+
+// setTier records the tier of the value at a dotted key and of all values nested in it
+func (vl *VariableLoader) setTier(key string, value interface{}, tier VariableTier) {
+	vl.tiers[key] = tier
+	if valueMap, ok := value.(map[string]interface{}); ok {
+		for k, v := range valueMap {
+			vl.setTier(key+"."+k, v, tier)
+		}
+	}
+}
+
+// tierOf returns the tier of the value at a dotted key, falling back to its parents
+func (vl *VariableLoader) tierOf(key string) VariableTier {
+	for {
+		if tier, exists := vl.tiers[key]; exists {
+			return tier
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return TierGlobal
+		}
+		key = key[:i]
+	}
+}
+
+// loadHostVariables loads variables/hosts/<hostname>.yaml when it exists and the
+// index did not import it already. The short hostname is tried when the full one
+// has no file.
+func (vl *VariableLoader) loadHostVariables(templateContext map[string]interface{}) error {
+	platformInfo, _ := templateContext["Platform"].(map[string]interface{})
+	hostname, _ := platformInfo["Hostname"].(string)
+	if hostname == "" {
+		return nil
+	}
+
+	for _, name := range hostFileNames(hostname) {
+		hostPath := filepath.Join(vl.config.GetVariablesPath(vl.basePath), HostsDir, name+".yaml")
+		if !vl.variableFileExists(hostPath) {
+			continue
+		}
+		if vl.loadedFiles[hostPath] {
+			return nil
+		}
+		if err := vl.loadVariableFile(hostPath); err != nil {
+			return fmt.Errorf("failed to load host variables %s: %w", hostPath, err)
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// hostFileNames returns the file names a host overlay may use, most specific first
+func hostFileNames(hostname string) []string {
+	names := []string{hostname}
+	if short, _, found := strings.Cut(hostname, "."); found && short != "" {
+		names = append(names, short)
+	}
+	return names
+}
+
+// WinningSource returns the trace whose value is used: the one from the highest
+// tier, and the last loaded one within that tier
+func WinningSource(traces []*VariableSource) *VariableSource {
+	var winner *VariableSource
+	for _, trace := range traces {
+		if winner == nil || trace.Tier >= winner.Tier {
+			winner = trace
+		}
+	}
+	return winner
+}
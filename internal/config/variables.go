@@ -3,7 +3,6 @@ package config
 import (
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
@@ -11,8 +10,8 @@ import (
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
 	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
-	"gopkg.in/yaml.v3"
 )
 
 // VariableConflictError represents a variable conflict with detailed information
@@ -36,7 +35,7 @@ func (e *VariableConflictError) PrettyPrint() string {
 	var msg strings.Builder
 
 	msg.WriteString("\n")
-	msg.WriteString("🔥 VARIABLE CONFLICT DETECTED\n")
+	msg.WriteString(ui.Text("🔥 VARIABLE CONFLICT DETECTED\n"))
 	msg.WriteString(strings.Repeat("=", 50) + "\n\n")
 
 	msg.WriteString(fmt.Sprintf("Variable: %s\n\n", e.Variable))
@@ -44,14 +43,14 @@ func (e *VariableConflictError) PrettyPrint() string {
 	msg.WriteString("Conflicting definitions found:\n\n")
 
 	// Show first definition
-	msg.WriteString(fmt.Sprintf("📁 File: %s\n", e.getRelativePath(e.ExistingSource)))
+	msg.WriteString(ui.Sprintf("📁 File: %s\n", e.getRelativePath(e.ExistingSource)))
 	msg.WriteString(fmt.Sprintf("   Value: %v\n\n", e.ExistingValue))
 
 	// Show second definition
-	msg.WriteString(fmt.Sprintf("📁 File: %s\n", e.getRelativePath(e.NewSource)))
+	msg.WriteString(ui.Sprintf("📁 File: %s\n", e.getRelativePath(e.NewSource)))
 	msg.WriteString(fmt.Sprintf("   Value: %v\n\n", e.NewValue))
 
-	msg.WriteString("💡 To fix this conflict:\n")
+	msg.WriteString(ui.Text("💡 To fix this conflict:\n"))
 	msg.WriteString("   1. Remove the duplicate definition from one of the files, OR\n")
 	msg.WriteString("   2. Use different variable names for different purposes, OR\n")
 	msg.WriteString("   3. Move one definition to a more specific scope\n\n")
@@ -88,6 +87,10 @@ type VariableLoader struct {
 	platform       *platform.PlatformInfo
 	basePath       string
 	templateEngine *templating.TemplatingEngine
+	tiers          map[string]VariableTier // Tier of every loaded value by dotted key
+	loadedFiles    map[string]bool         // Variable files loaded so far
+	cipher         *VariableCipher         // Decrypts *.enc.yaml variable files
+	overlay        map[string][]byte       // Content of variable files that is not written yet
 }
 
 // VariableLoadOptions contains options for variable loading
@@ -96,6 +99,7 @@ type VariableLoadOptions struct {
 	Shell       string            // Override shell detection
 	Environment map[string]string // Additional environment variables
 	Hostname    string            // Override hostname
+	UseCache    bool              `json:"-"` // Load from and update the variable cache
 }
 
 // NewVariableLoader creates a new variable loader
@@ -106,6 +110,23 @@ func NewVariableLoader(config *Config, basePath string) (*VariableLoader, error)
 		return nil, fmt.Errorf("failed to get platform info: %w", err)
 	}
 
+	// Point the secret() template function at the configured sources before
+	// any variable template is rendered
+	secretsPath, err := config.GetSecretsPath(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets file path: %w", err)
+	}
+	secretCommand := ""
+	if config.Settings != nil {
+		secretCommand = config.Settings.SecretCommand
+	}
+	templating.ConfigureSecrets(secretsPath, secretCommand)
+
+	cipher, err := NewVariableCipher(config, basePath)
+	if err != nil {
+		return nil, err
+	}
+
 	context := NewImportContext(config, basePath)
 
 	return &VariableLoader{
@@ -115,6 +136,9 @@ func NewVariableLoader(config *Config, basePath string) (*VariableLoader, error)
 		platform:       platformInfo,
 		basePath:       basePath,
 		templateEngine: templating.NewTemplatingEngine(basePath),
+		tiers:          make(map[string]VariableTier),
+		loadedFiles:    make(map[string]bool),
+		cipher:         cipher,
 	}, nil
 }
 
@@ -123,6 +147,8 @@ func (vl *VariableLoader) LoadAllVariables(opts *VariableLoadOptions) (map[strin
 	// Reset sources for fresh load
 	vl.sources = make([]*VariableSource, 0)
 	vl.context.Variables = make(map[string]interface{})
+	vl.tiers = make(map[string]VariableTier)
+	vl.loadedFiles = make(map[string]bool)
 
 	// Create template context for conditional imports
 	templateContext := vl.createTemplateContext(opts)
@@ -130,28 +156,66 @@ func (vl *VariableLoader) LoadAllVariables(opts *VariableLoadOptions) (map[strin
 	// Load variables index
 	variablesIndexPath := vl.config.GetVariablesIndexPath(vl.basePath)
 	if !utils.FileExists(variablesIndexPath) {
-		return vl.context.Variables, nil // No variables to load
+		// No variables to load, templates still get the platform context
+		return withTemplateContext(vl.context.Variables, templateContext), nil
 	}
 
+	var processedVariables map[string]interface{}
+	useCache, cached := opts != nil && opts.UseCache, false
+	if useCache {
+		processedVariables, cached = vl.readVariableCache(opts, templateContext)
+	}
+
+	if !cached {
+		var err error
+		processedVariables, err = vl.loadAndProcessVariables(variablesIndexPath, templateContext)
+		if err != nil {
+			// A cache from before the error must not hide it on the next run
+			if useCache {
+				vl.removeVariableCache()
+			}
+			return nil, err
+		}
+
+		// The cache is only an optimization, loading still succeeded when it
+		// cannot be written
+		if useCache {
+			_ = vl.writeVariableCache(opts, templateContext, processedVariables)
+		}
+	}
+
+	return withTemplateContext(processedVariables, templateContext), nil
+}
+
+// withTemplateContext adds platform information and other context to the final
+// variables. This ensures Platform, Env, etc. are available in job templates.
+func withTemplateContext(variables, templateContext map[string]interface{}) map[string]interface{} {
+	for key, value := range templateContext {
+		if _, exists := variables[key]; !exists {
+			variables[key] = value
+		}
+	}
+	return variables
+}
+
+// loadAndProcessVariables loads the variables index with its imports and host
+// overlay and renders all variable templates
+func (vl *VariableLoader) loadAndProcessVariables(variablesIndexPath string, templateContext map[string]interface{}) (map[string]interface{}, error) {
 	// Process variables index
 	if err := vl.processVariablesIndex(variablesIndexPath, templateContext); err != nil {
 		return nil, fmt.Errorf("failed to process variables index: %w", err)
 	}
 
+	// Host overlays are picked up even when the index does not import them
+	if err := vl.loadHostVariables(templateContext); err != nil {
+		return nil, err
+	}
+
 	// Process all variables through template engine
 	processedVariables, err := vl.processVariableTemplates(vl.context.Variables, templateContext)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process variable templates: %w", err)
 	}
-
-	// Add platform information and other context to final variables
-	// This ensures Platform, Env, etc. are available in job templates
-	for key, value := range templateContext {
-		if _, exists := processedVariables[key]; !exists {
-			processedVariables[key] = value
-		}
-	}
-
 	return processedVariables, nil
 }
 
@@ -181,13 +245,21 @@ func (vl *VariableLoader) TraceVariable(key string) []*VariableSource {
 		// Find all sources that define the root key
 		for _, source := range vl.sources {
 			if source.Key == rootKey {
+				rawValue := vl.extractNestedValue(source.RawValue, keyParts[1:])
+				if rawValue == "<not found>" {
+					// This source only defines other keys of the root variable
+					continue
+				}
+
 				// Create a new source entry that shows the specific nested value
 				nestedSource := &VariableSource{
 					Key:            key, // Use the full dot notation key
-					RawValue:       vl.extractNestedValue(source.RawValue, keyParts[1:]),
+					RawValue:       rawValue,
 					ProcessedValue: vl.extractNestedValue(source.ProcessedValue, keyParts[1:]),
 					Source:         source.Source,
-					Line:           source.Line,
+					Line:           nestedLine(source, keyParts[1:]),
+					Tier:           source.Tier,
+					Encrypted:      source.Encrypted,
 				}
 				traces = append(traces, nestedSource)
 			}
@@ -204,6 +276,15 @@ func (vl *VariableLoader) TraceVariable(key string) []*VariableSource {
 	return traces
 }
 
+// nestedLine returns the line a nested key of a variable is defined at, or the line
+// of the variable when the nested key's line is unknown
+func nestedLine(source *VariableSource, keyParts []string) int {
+	if line := source.Lines[strings.Join(keyParts, ".")]; line > 0 {
+		return line
+	}
+	return source.Line
+}
+
 // processVariablesIndex processes the main variables index file
 func (vl *VariableLoader) processVariablesIndex(indexPath string, templateContext map[string]interface{}) error {
 	// Add to import chain
@@ -224,32 +305,37 @@ func (vl *VariableLoader) processVariablesIndex(indexPath string, templateContex
 		return fmt.Errorf("failed to normalize imports: %w", err)
 	}
 
-	for _, importFile := range normalizedImports {
-		if err := vl.processImport(importFile, templateContext); err != nil {
+	lines := keyLines(index.Node)
+	for i, importFile := range normalizedImports {
+		source := vl.relativePath(indexPath)
+		if line := lines[fmt.Sprintf("imports.%d", i)]; line > 0 {
+			source = fmt.Sprintf("%s:%d", source, line)
+		}
+		if err := vl.processImport(importFile, source, subLines(lines, fmt.Sprintf("imports.%d.variables", i)), templateContext); err != nil {
 			return fmt.Errorf("failed to process import %s: %w", importFile.Path, err)
 		}
 	}
 
 	// Process variables in index file
-	if err := vl.addVariables(index.Variables, indexPath, 0); err != nil {
+	if err := vl.addVariables(index.Variables, indexPath, subLines(lines, "variables")); err != nil {
 		return fmt.Errorf("failed to add variables from %s: %w", indexPath, err)
 	}
 
 	return nil
 }
 
-// processImport processes a single import file with conditions
-func (vl *VariableLoader) processImport(importFile ImportFile, templateContext map[string]interface{}) error {
+// processImport processes a single import file with conditions. source is where the
+// import is defined and lines are the lines its variables are defined at in the index.
+func (vl *VariableLoader) processImport(importFile ImportFile, source string, lines map[string]int, templateContext map[string]interface{}) error {
 	// Process conditional imports
 	importPath, err := vl.processTemplate(importFile.Path, templateContext)
 	if err != nil {
 		return fmt.Errorf("failed to process import path template: %w", err)
 	}
 
-	// Check if the processed path contains template placeholders that couldn't be resolved
-	if strings.Contains(importPath, "<no value>") || strings.Contains(importPath, "{{") {
-		// Skip imports with unresolved variables
-		return nil
+	// Imports whose path references undefined variables fail in strict mode
+	if skip, err := CheckImportPath(vl.templateEngine, importFile.Path, importPath, source, templateContext); skip {
+		return err
 	}
 
 	// Check condition if specified
@@ -263,8 +349,20 @@ func (vl *VariableLoader) processImport(importFile ImportFile, templateContext m
 		}
 	}
 
-	// Resolve relative path
+	// Resolve relative path, paths in remote repositories are relative to their root
 	fullPath := filepath.Join(vl.config.GetVariablesPath(vl.basePath), importPath)
+	if importFile.Repo != "" {
+		checkout, err := FetchRemoteImport(vl.basePath, importFile.Repo, importFile.Ref)
+		if err != nil {
+			return err
+		}
+		fullPath = filepath.Join(checkout, importPath)
+	}
+
+	// Host overlays are optional, most hosts won't have one
+	if vl.sourceTier(fullPath) == TierHost && !vl.variableFileExists(fullPath) {
+		return nil
+	}
 
 	// Check for circular imports
 	if err := vl.context.AddToChain(fullPath); err != nil {
@@ -279,7 +377,7 @@ func (vl *VariableLoader) processImport(importFile ImportFile, templateContext m
 
 	// Add file-specific variables
 	if len(importFile.Variables) > 0 {
-		if err := vl.addVariables(importFile.Variables, fullPath, 0); err != nil {
+		if err := vl.addVariables(importFile.Variables, fullPath, lines); err != nil {
 			return fmt.Errorf("failed to add import variables from %s: %w", fullPath, err)
 		}
 	}
@@ -287,28 +385,40 @@ func (vl *VariableLoader) processImport(importFile ImportFile, templateContext m
 	return nil
 }
 
-// loadVariableFile loads variables from a YAML file
+// loadVariableFile loads variables from a YAML file, decrypting it first when it
+// is encrypted
 func (vl *VariableLoader) loadVariableFile(filePath string) error {
-	if !utils.FileExists(filePath) {
+	if !vl.variableFileExists(filePath) {
 		return fmt.Errorf("variable file does not exist: %s", filePath)
 	}
 
-	data, err := os.ReadFile(filePath)
+	data, err := vl.readVariableFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read variable file: %w", err)
 	}
 
+	if IsEncryptedVariableFile(filePath) {
+		if data, err = vl.cipher.Decrypt(filePath, data); err != nil {
+			return err
+		}
+	}
+
 	var variables map[string]interface{}
-	if err := yaml.Unmarshal(data, &variables); err != nil {
+	node, err := decodeDocument(data, &variables)
+	if err != nil {
 		return fmt.Errorf("failed to unmarshal variables: %w", err)
 	}
+	vl.loadedFiles[filePath] = true
 
 	// Add variables with source tracking
-	return vl.addVariables(variables, filePath, 0)
+	return vl.addVariables(variables, filePath, keyLines(node))
 }
 
-// addVariables adds variables to the context with source tracking and deep merging
-func (vl *VariableLoader) addVariables(variables map[string]interface{}, source string, line int) error {
+// addVariables adds variables to the context with source tracking and deep merging.
+// lines are the lines the variables are defined at, by their dotted path.
+func (vl *VariableLoader) addVariables(variables map[string]interface{}, source string, lines map[string]int) error {
+	tier := vl.sourceTier(source)
+
 	for key, value := range variables {
 		// Track variable source (store raw value, will update with processed later)
 		vl.sources = append(vl.sources, &VariableSource{
@@ -316,26 +426,31 @@ func (vl *VariableLoader) addVariables(variables map[string]interface{}, source
 			RawValue:       value,
 			ProcessedValue: nil, // Will be updated after processing
 			Source:         source,
-			Line:           line,
+			Line:           lines[key],
+			Lines:          subLines(lines, key),
+			Tier:           tier,
+			Encrypted:      IsEncryptedVariableFile(source),
 		})
 
 		// Deep merge or add to context
 		if existing, exists := vl.context.Variables[key]; exists {
-			merged, err := vl.deepMergeVariables(key, existing, value, source)
+			merged, err := vl.deepMergeVariables(key, existing, value, source, tier)
 			if err != nil {
 				return fmt.Errorf("failed to merge variable '%s' from %s: %w", key, source, err)
 			}
 			vl.context.Variables[key] = merged
 		} else {
 			vl.context.Variables[key] = value
+			vl.setTier(key, value, tier)
 		}
 	}
 
 	return nil
 }
 
-// deepMergeVariables performs deep merging of variable values with conflict detection
-func (vl *VariableLoader) deepMergeVariables(key string, existing, new interface{}, newSource string) (interface{}, error) {
+// deepMergeVariables performs deep merging of variable values with conflict detection.
+// Differing values only conflict within the same tier; otherwise the higher tier wins.
+func (vl *VariableLoader) deepMergeVariables(key string, existing, new interface{}, newSource string, tier VariableTier) (interface{}, error) {
 	// If both are maps, merge them recursively
 	existingMap, existingIsMap := existing.(map[string]interface{})
 	newMap, newIsMap := new.(map[string]interface{})
@@ -354,6 +469,15 @@ func (vl *VariableLoader) deepMergeVariables(key string, existing, new interface
 			if existingValue, exists := result[k]; exists {
 				// Check for conflicts (same key, different non-map values)
 				if !vl.isMapValue(existingValue) && !vl.isMapValue(v) && !vl.valuesEqual(existingValue, v) {
+					switch existingTier := vl.tierOf(key + "." + k); {
+					case tier > existingTier:
+						result[k] = v
+						vl.setTier(key+"."+k, v, tier)
+						continue
+					case tier < existingTier:
+						continue
+					}
+
 					// Find source of existing value
 					existingSource := vl.findVariableSource(key + "." + k)
 					return nil, &VariableConflictError{
@@ -368,7 +492,7 @@ func (vl *VariableLoader) deepMergeVariables(key string, existing, new interface
 
 				// Recursively merge if both are maps
 				if vl.isMapValue(existingValue) && vl.isMapValue(v) {
-					merged, err := vl.deepMergeVariables(key+"."+k, existingValue, v, newSource)
+					merged, err := vl.deepMergeVariables(key+"."+k, existingValue, v, newSource, tier)
 					if err != nil {
 						return nil, err
 					}
@@ -376,9 +500,13 @@ func (vl *VariableLoader) deepMergeVariables(key string, existing, new interface
 				} else {
 					// Non-map values: use the new value (precedence rule)
 					result[k] = v
+					if tier >= vl.tierOf(key+"."+k) {
+						vl.setTier(key+"."+k, v, tier)
+					}
 				}
 			} else {
 				result[k] = v
+				vl.setTier(key+"."+k, v, tier)
 			}
 		}
 
@@ -387,6 +515,14 @@ func (vl *VariableLoader) deepMergeVariables(key string, existing, new interface
 
 	// If not both maps, check for conflict
 	if !vl.valuesEqual(existing, new) {
+		switch existingTier := vl.tierOf(key); {
+		case tier > existingTier:
+			vl.setTier(key, new, tier)
+			return new, nil
+		case tier < existingTier:
+			return existing, nil
+		}
+
 		existingSource := vl.findVariableSource(key)
 		return nil, &VariableConflictError{
 			Variable:       key,
@@ -399,6 +535,9 @@ func (vl *VariableLoader) deepMergeVariables(key string, existing, new interface
 	}
 
 	// Same values, return the new one (precedence)
+	if tier > vl.tierOf(key) {
+		vl.setTier(key, new, tier)
+	}
 	return new, nil
 }
 
@@ -425,83 +564,20 @@ func (vl *VariableLoader) findVariableSource(key string) string {
 
 // createTemplateContext creates context for template processing
 func (vl *VariableLoader) createTemplateContext(opts *VariableLoadOptions) map[string]interface{} {
-	context := make(map[string]interface{})
-
-	// Platform information
-	platformInfo := map[string]interface{}{
-		"OS":                      vl.platform.OS,
-		"Arch":                    vl.platform.Arch,
-		"PackageManagers":         vl.platform.PackageManagers,
-		"AvailablePackageManagers": vl.platform.AvailablePackageManagers,
-		"HomeDir":                 vl.platform.HomeDir,
-		"ConfigDir":               vl.platform.ConfigDir,
-		"IsElevated":              vl.platform.IsElevated,
-		"IsRoot":                  vl.platform.IsRoot,
-		"IsWSL":                   vl.platform.IsWSL,
-		"Distro":                  vl.platform.Distro,
-		"DistroVersion":           vl.platform.DistroVersion,
-		"DistroCodename":          vl.platform.DistroCodename,
-		"KernelVersion":           vl.platform.KernelVersion,
-		"SystemVersion":           vl.platform.SystemVersion,
-		"UnameInfo":               vl.platform.UnameInfo,
-	}
-
-	// Override with options if provided
-	if opts != nil {
-		if opts.Platform != "" {
-			platformInfo["OS"] = opts.Platform
-		}
-		if opts.Shell != "" {
-			platformInfo["Shell"] = opts.Shell
-		} else {
-			platformInfo["Shell"] = vl.platform.Shell
-		}
-		if opts.Hostname != "" {
-			platformInfo["Hostname"] = opts.Hostname
-		} else {
-			hostname, err := os.Hostname()
-			if err == nil {
-				platformInfo["Hostname"] = hostname
-			}
-		}
-	} else {
-		platformInfo["Shell"] = vl.platform.Shell
-		hostname, err := os.Hostname()
-		if err == nil {
-			platformInfo["Hostname"] = hostname
-		}
-	}
-
-	context["Platform"] = platformInfo
-
-	// Environment variables
-	if opts != nil && opts.Environment != nil {
-		context["Env"] = opts.Environment
-	} else {
-		env := make(map[string]string)
-		for _, envVar := range os.Environ() {
-			parts := strings.SplitN(envVar, "=", 2)
-			if len(parts) == 2 {
-				env[parts[0]] = parts[1]
-			}
-		}
-		context["Env"] = env
-	}
+	return NewTemplateContext(vl.platform, opts)
+}
 
-	// User information
-	user := make(map[string]interface{})
-	if homeDir, err := os.UserHomeDir(); err == nil {
-		user["Home"] = homeDir
+// relativePath returns a path relative to the dotfiles repository when it is in it
+func (vl *VariableLoader) relativePath(path string) string {
+	if relPath, err := filepath.Rel(vl.basePath, path); err == nil && !strings.HasPrefix(relPath, "..") {
+		return relPath
 	}
-	context["User"] = user
-
-	return context
+	return path
 }
 
-// processTemplate processes a template string with the given context
 // processTemplate processes a template string with variables using Pongo2
 func (vl *VariableLoader) processTemplate(templateStr string, variables map[string]interface{}) (string, error) {
-	return vl.templateEngine.ProcessVariableTemplate(templateStr, variables)
+	return vl.templateEngine.ProcessString(templateStr, variables)
 }
 
 // evaluateCondition evaluates a condition string using the new templating engine
@@ -568,22 +644,6 @@ func (vl *VariableLoader) SetVariable(key string, value interface{}, variables m
 	}
 }
 
-// ValidateVariables validates all loaded variables
-func (vl *VariableLoader) ValidateVariables(variables map[string]interface{}) error {
-	// Check for required variables (could be configurable)
-	required := []string{
-		// Add any required variables here
-	}
-
-	for _, req := range required {
-		if _, exists := vl.GetVariable(req, variables); !exists {
-			return fmt.Errorf("required variable missing: %s", req)
-		}
-	}
-
-	return nil
-}
-
 // processVariableTemplates processes all variables through the template engine
 func (vl *VariableLoader) processVariableTemplates(variables map[string]interface{}, context map[string]interface{}) (map[string]interface{}, error) {
 	// Create a copy of the context and add the current variables to it
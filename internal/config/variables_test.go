@@ -0,0 +1,416 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeVariableFiles creates a dotfiles directory with the given variable files
+func writeVariableFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	basePath := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(basePath, "variables", name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return basePath
+}
+
+func loadTestVariables(t *testing.T, basePath, hostname string) (*VariableLoader, map[string]interface{}, error) {
+	t.Helper()
+	loader, err := NewVariableLoader(DefaultConfig(), basePath)
+	require.NoError(t, err)
+	variables, err := loader.LoadAllVariables(&VariableLoadOptions{Hostname: hostname, Environment: map[string]string{}})
+	return loader, variables, err
+}
+
+func TestHostVariableOverlay(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+  - path: "platforms/linux.yaml"
+`,
+		"global.yaml": `git:
+  email: "me@personal.dev"
+  name: "Me"
+theme: "dark"
+`,
+		"platforms/linux.yaml":   "theme: \"light\"\n",
+		"hosts/work-laptop.yaml": "git:\n  email: \"me@company.com\"\ntheme: \"solarized\"\n",
+	})
+
+	t.Run("HostOverridesLowerTiers", func(t *testing.T) {
+		loader, variables, err := loadTestVariables(t, basePath, "work-laptop")
+		require.NoError(t, err)
+
+		email, _ := loader.GetVariable("git.email", variables)
+		name, _ := loader.GetVariable("git.name", variables)
+		assert.Equal(t, "me@company.com", email)
+		assert.Equal(t, "Me", name)
+		assert.Equal(t, "solarized", variables["theme"])
+
+		winner := WinningSource(loader.TraceVariable("theme"))
+		require.NotNil(t, winner)
+		assert.Equal(t, TierHost, winner.Tier)
+
+		traces := loader.TraceVariable("git.email")
+		assert.Len(t, traces, 2)
+		assert.Equal(t, TierHost, WinningSource(traces).Tier)
+	})
+
+	t.Run("ShortHostname", func(t *testing.T) {
+		_, variables, err := loadTestVariables(t, basePath, "work-laptop.corp.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "solarized", variables["theme"])
+	})
+
+	t.Run("OtherHostUsesPlatformValue", func(t *testing.T) {
+		_, variables, err := loadTestVariables(t, basePath, "desktop")
+		require.NoError(t, err)
+		assert.Equal(t, "light", variables["theme"])
+	})
+}
+
+func TestHostImportInIndexIsLoadedOnce(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+  - path: "hosts/{{ Platform.Hostname }}.yaml"
+`,
+		"global.yaml":       "shell: \"bash\"\n",
+		"hosts/laptop.yaml": "shell: \"zsh\"\n",
+	})
+
+	loader, variables, err := loadTestVariables(t, basePath, "laptop")
+	require.NoError(t, err)
+	assert.Equal(t, "zsh", variables["shell"])
+	assert.Len(t, loader.TraceVariable("shell"), 2)
+
+	// A host without a file is not an error
+	_, variables, err = loadTestVariables(t, basePath, "desktop")
+	require.NoError(t, err)
+	assert.Equal(t, "bash", variables["shell"])
+}
+
+func TestVariableLines(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+  - path: "extra.yaml"
+    variables:
+      editor: "vim"
+variables:
+  shell: "zsh"
+`,
+		"global.yaml": `# Identity
+git:
+  name: "Me"
+  email: "me@personal.dev"
+theme: "dark"
+`,
+		"extra.yaml": "font: \"mono\"\n",
+	})
+
+	loader, _, err := loadTestVariables(t, basePath, "laptop")
+	require.NoError(t, err)
+
+	line := func(key string) int {
+		traces := loader.TraceVariable(key)
+		require.Len(t, traces, 1, key)
+		return traces[0].Line
+	}
+	assert.Equal(t, 2, line("git"))
+	assert.Equal(t, 4, line("git.email"))
+	assert.Equal(t, 5, line("theme"))
+	assert.Equal(t, 1, line("font"))
+	assert.Equal(t, 5, line("editor"), "import variables are defined in the index")
+	assert.Equal(t, 7, line("shell"))
+}
+
+func TestSameTierConflict(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+  - path: "extra.yaml"
+`,
+		"global.yaml": "theme: \"dark\"\n",
+		"extra.yaml":  "theme: \"light\"\n",
+	})
+
+	_, _, err := loadTestVariables(t, basePath, "laptop")
+	require.Error(t, err)
+	conflict, ok := IsVariableConflictError(err)
+	require.True(t, ok)
+	assert.Equal(t, "theme", conflict.Variable)
+}
+
+func TestLowerTierDoesNotOverrideHigherTier(t *testing.T) {
+	// The platform file is imported after the host file but must not win
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "hosts/laptop.yaml"
+  - path: "platforms/linux.yaml"
+`,
+		"hosts/laptop.yaml":    "editor:\n  name: \"nvim\"\n",
+		"platforms/linux.yaml": "editor:\n  name: \"vim\"\n  tabs: 4\n",
+	})
+
+	_, variables, err := loadTestVariables(t, basePath, "laptop")
+	require.NoError(t, err)
+	editor := variables["editor"].(map[string]interface{})
+	assert.Equal(t, "nvim", editor["name"])
+	assert.Equal(t, 4, editor["tabs"])
+}
+
+func TestVariableCache(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+`,
+		"global.yaml": "editor:\n  name: \"nvim\"\n  tabs: 4\n  ratio: 1.5\ngreeting: \"hello {{ editor.name }}\"\n",
+	})
+	opts := func() *VariableLoadOptions {
+		return &VariableLoadOptions{Hostname: "laptop", Environment: map[string]string{}, UseCache: true}
+	}
+	load := func(opts *VariableLoadOptions) (*VariableLoader, map[string]interface{}, error) {
+		loader, err := NewVariableLoader(DefaultConfig(), basePath)
+		require.NoError(t, err)
+		variables, err := loader.LoadAllVariables(opts)
+		return loader, variables, err
+	}
+
+	_, fresh, err := load(opts())
+	require.NoError(t, err)
+	assert.FileExists(t, VariableCachePath(basePath))
+
+	t.Run("HitKeepsTypesAndSources", func(t *testing.T) {
+		loader, cached, err := load(opts())
+		require.NoError(t, err)
+		assert.Equal(t, fresh, cached)
+		assert.Equal(t, 4, cached["editor"].(map[string]interface{})["tabs"])
+		assert.Equal(t, "hello nvim", cached["greeting"])
+		require.Len(t, loader.TraceVariable("greeting"), 1)
+		assert.Equal(t, "hello {{ editor.name }}", loader.TraceVariable("greeting")[0].RawValue)
+	})
+
+	t.Run("HitSkipsProcessing", func(t *testing.T) {
+		// Tamper with the cached value; only a cache hit can return it
+		data, err := os.ReadFile(VariableCachePath(basePath))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(VariableCachePath(basePath), []byte(strings.Replace(string(data), `"greeting":"hello nvim"`, `"greeting":"from cache"`, 1)), 0644))
+
+		_, cached, err := load(opts())
+		require.NoError(t, err)
+		assert.Equal(t, "from cache", cached["greeting"])
+
+		noCache := opts()
+		noCache.UseCache = false
+		_, uncached, err := load(noCache)
+		require.NoError(t, err)
+		assert.Equal(t, "hello nvim", uncached["greeting"])
+	})
+
+	t.Run("OptionsInvalidate", func(t *testing.T) {
+		other := opts()
+		other.Hostname = "desktop"
+		_, variables, err := load(other)
+		require.NoError(t, err)
+		assert.Equal(t, "hello nvim", variables["greeting"])
+		assert.Equal(t, "desktop", variables["Platform"].(map[string]interface{})["Hostname"])
+	})
+
+	t.Run("NewFileInvalidates", func(t *testing.T) {
+		hostFile := filepath.Join(basePath, "variables", "hosts", "laptop.yaml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(hostFile), 0755))
+		require.NoError(t, os.WriteFile(hostFile, []byte("editor:\n  name: \"hx\"\n"), 0644))
+		defer os.Remove(hostFile)
+
+		_, variables, err := load(opts())
+		require.NoError(t, err)
+		assert.Equal(t, "hello hx", variables["greeting"])
+	})
+
+	t.Run("ConflictIsNotMasked", func(t *testing.T) {
+		_, _, err := load(opts())
+		require.NoError(t, err)
+
+		extra := filepath.Join(basePath, "variables", "extra.yaml")
+		require.NoError(t, os.WriteFile(extra, []byte("greeting: \"hi\"\n"), 0644))
+		index := filepath.Join(basePath, "variables", "index.yaml")
+		require.NoError(t, os.WriteFile(index, []byte("imports:\n  - path: \"global.yaml\"\n  - path: \"extra.yaml\"\n"), 0644))
+
+		for i := 0; i < 2; i++ {
+			_, _, err = load(opts())
+			_, isConflict := IsVariableConflictError(err)
+			assert.True(t, isConflict, "load %d: %v", i, err)
+		}
+		assert.NoFileExists(t, VariableCachePath(basePath))
+	})
+}
+
+func TestVariableCacheSkipsSecrets(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml":  "imports:\n  - path: \"global.yaml\"\n",
+		"global.yaml": "user: \"me\"\ntoken: \"{% if false %}{{ secret('token') }}{% endif %}\"\n",
+	})
+
+	loader, err := NewVariableLoader(DefaultConfig(), basePath)
+	require.NoError(t, err)
+	_, err = loader.LoadAllVariables(&VariableLoadOptions{Environment: map[string]string{}, UseCache: true})
+	require.NoError(t, err)
+	assert.NoFileExists(t, VariableCachePath(basePath))
+}
+
+func TestImportConditionHelpers(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "present.yaml"
+    condition: 'fileExists("` + "{{BASE}}" + `/variables/present.yaml")'
+  - path: "missing.yaml"
+    condition: 'commandExists("definitely-not-a-command")'
+`,
+		"present.yaml": "present: true\n",
+		"missing.yaml": "missing: true\n",
+	})
+	index := filepath.Join(basePath, "variables", "index.yaml")
+	content, err := os.ReadFile(index)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(index, []byte(strings.ReplaceAll(string(content), "{{BASE}}", filepath.ToSlash(basePath))), 0644))
+
+	_, variables, err := loadTestVariables(t, basePath, "laptop")
+	require.NoError(t, err)
+	assert.Equal(t, true, variables["present"])
+	assert.NotContains(t, variables, "missing")
+}
+
+func TestUnresolvedVariableImport(t *testing.T) {
+	basePath := writeVariableFiles(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "global.yaml"
+  - path: "global{{ Platform.Oss }}.yaml"
+`,
+		"global.yaml": "shell: \"bash\"\n",
+	})
+
+	t.Run("Warns", func(t *testing.T) {
+		_, variables, err := loadTestVariables(t, basePath, "laptop")
+		require.NoError(t, err)
+		assert.Equal(t, "bash", variables["shell"])
+
+		var found *UnresolvedImportError
+		for _, unresolved := range UnresolvedImports() {
+			if unresolved.Path == "global{{ Platform.Oss }}.yaml" {
+				found = unresolved
+			}
+		}
+		require.NotNil(t, found, "the import is reported")
+		assert.Equal(t, filepath.Join("variables", "index.yaml")+":3", found.Source)
+		assert.Contains(t, found.Expression, "Platform.Oss")
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		ConfigureStrictImports(true)
+		defer ConfigureStrictImports(false)
+
+		_, _, err := loadTestVariables(t, basePath, "laptop")
+		var unresolved *UnresolvedImportError
+		require.ErrorAs(t, err, &unresolved)
+		assert.Equal(t, "global{{ Platform.Oss }}.yaml", unresolved.Path)
+		assert.Contains(t, err.Error(), "variables/index.yaml:3")
+		assert.Contains(t, err.Error(), "did you mean 'Platform.OS'")
+	})
+}
+
+func TestVariableConflictPrettyPrint(t *testing.T) {
+	conflict := &VariableConflictError{
+		Variable:       "git.email",
+		ExistingValue:  "me@home.example",
+		NewValue:       "me@work.example",
+		ExistingSource: "/dotfiles/variables/git.yaml",
+		NewSource:      "/dotfiles/variables/work/git.yaml",
+		BasePath:       "/dotfiles",
+	}
+	body := `Variable: git.email
+
+Conflicting definitions found:
+
+%s File: variables/git.yaml
+   Value: me@home.example
+
+%s File: variables/work/git.yaml
+   Value: me@work.example
+
+%s To fix this conflict:
+   1. Remove the duplicate definition from one of the files, OR
+   2. Use different variable names for different purposes, OR
+   3. Move one definition to a more specific scope
+
+Note: Variables must have the same value when defined in multiple files
+`
+	header := "\n%s VARIABLE CONFLICT DETECTED\n" + strings.Repeat("=", 50) + "\n\n"
+
+	t.Cleanup(func() { ui.Configure(false, false) })
+	tests := []struct {
+		name              string
+		ascii             bool
+		error, file, hint string
+	}{
+		{name: "emoji", error: "🔥", file: "📁", hint: "💡"},
+		{name: "ascii", ascii: true, error: "[error]", file: "[config]", hint: "[hint]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui.Configure(false, tt.ascii)
+			want := fmt.Sprintf(header, tt.error) + fmt.Sprintf(body, tt.file, tt.file, tt.hint)
+			assert.Equal(t, filepath.FromSlash(want), conflict.PrettyPrint())
+		})
+	}
+}
+
+func TestTemplateContextPlatformKeys(t *testing.T) {
+	keys := func(section interface{}) []string {
+		var names []string
+		for name := range section.(map[string]interface{}) {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	fields := []string{"Hostname"}
+	platformType := reflect.TypeOf(platform.PlatformInfo{})
+	for i := 0; i < platformType.NumField(); i++ {
+		fields = append(fields, platformType.Field(i).Name)
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{name: "variables", files: map[string]string{"index.yaml": "variables:\n  editor: vim\n"}},
+		{name: "no variables index", files: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader, variables, err := loadTestVariables(t, writeVariableFiles(t, tt.files), "")
+			require.NoError(t, err)
+
+			// Import conditions use the loader context, jobs the loaded variables
+			context := loader.createTemplateContext(nil)
+			require.Contains(t, variables, "Platform")
+			assert.ElementsMatch(t, keys(context["Platform"]), keys(variables["Platform"]))
+			assert.ElementsMatch(t, fields, keys(variables["Platform"]))
+		})
+	}
+}
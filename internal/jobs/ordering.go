@@ -0,0 +1,25 @@
+package jobs
+
+import "sync"
+
+// ordering holds how the actions of a jobs file are ordered
+var ordering = struct {
+	sync.Mutex
+	legacy bool
+}{}
+
+// ConfigureLegacyOrdering sets whether the actions of a jobs file run sorted by
+// name, as before jobs ran in the order their files list them. Set by
+// settings.legacy_ordering, which is temporary.
+func ConfigureLegacyOrdering(legacy bool) {
+	ordering.Lock()
+	defer ordering.Unlock()
+	ordering.legacy = legacy
+}
+
+// LegacyOrdering reports whether the actions of a jobs file run sorted by name
+func LegacyOrdering() bool {
+	ordering.Lock()
+	defer ordering.Unlock()
+	return ordering.legacy
+}
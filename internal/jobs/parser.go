@@ -9,6 +9,8 @@ import (
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
 	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"gopkg.in/yaml.v3"
 )
 
 // JobParser handles parsing of jobs from YAML configuration
@@ -18,6 +20,15 @@ type JobParser struct {
 	importChain  []string
 	currentFile  string
 	templateEngine *templating.TemplatingEngine
+
+	profiles    []string            // Selected profiles, imports limited to other profiles are skipped
+	allImports  bool                // Follow every import, whatever its condition and profiles
+	profileRefs map[string][]string // Profile -> files referencing it
+	tagRefs     map[string][]string // Tag -> files referencing it
+
+	handlers []*config.Handler // Handlers in the order they are defined
+
+	remoteRoot string // Checkout of the remote import the current file is in
 }
 
 // NewJobParser creates a new job parser
@@ -28,19 +39,27 @@ func NewJobParser(basePath string) *JobParser {
 		importChain:  make([]string, 0),
 		currentFile:  "",
 		templateEngine: templating.NewTemplatingEngine(basePath),
+		profileRefs:  make(map[string][]string),
+		tagRefs:      make(map[string][]string),
 	}
 }
 
 // ParseJobsConfig parses a raw configuration map into a list of tasks
 func (p *JobParser) ParseJobsConfig(rawConfig map[string]interface{}) ([]*config.Task, error) {
+	return p.parseJobs(rawConfig, nil)
+}
+
+// parseJobs parses the jobs of a file into a list of tasks. node is the mapping the
+// jobs were decoded from, tasks record the line they start at when it is given.
+func (p *JobParser) parseJobs(rawConfig map[string]interface{}, node *yaml.Node) ([]*config.Task, error) {
 	var tasks []*config.Task
 
-	// Get sorted keys to maintain order from YAML
-	keys := p.getSortedKeys(rawConfig)
+	// Actions run in the order the file lists them
+	keys := p.documentKeys(rawConfig, node)
 
 	for _, actionKey := range keys {
 		value := rawConfig[actionKey]
-		actionJobs, err := p.parseActionJobs(actionKey, value)
+		actionJobs, err := p.parseActionJobs(actionKey, value, mappingValue(node, actionKey))
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse action '%s': %w", actionKey, err)
 		}
@@ -77,16 +96,21 @@ func (p *JobParser) ParseJobsIndex(indexPath string, variables map[string]interf
 		return nil, fmt.Errorf("failed to normalize imports: %w", err)
 	}
 
-	for _, importFile := range normalizedImports {
-		importTasks, err := p.processImport(importFile, variables)
+	importsNode := mappingValue(jobsIndex.Node, "imports")
+	for i, importFile := range normalizedImports {
+		importTasks, err := p.processImport(importFile, p.location(nodeLine(sequenceItem(importsNode, i))), variables)
 		if err != nil {
 			return nil, fmt.Errorf("failed to process import %s: %w", importFile.Path, err)
 		}
 		allTasks = append(allTasks, importTasks...)
 	}
 
+	if err := p.parseHandlers(&jobsIndex.Handlers); err != nil {
+		return nil, fmt.Errorf("failed to parse handlers: %w", err)
+	}
+
 	// Process local jobs
-	localTasks, err := p.ParseJobsConfig(jobsIndex.Jobs)
+	localTasks, err := p.parseJobs(jobsIndex.Jobs, jobsIndex.Node)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse local jobs: %w", err)
 	}
@@ -95,20 +119,21 @@ func (p *JobParser) ParseJobsIndex(indexPath string, variables map[string]interf
 	return allTasks, nil
 }
 
-// parseActionJobs converts an action's configuration into tasks
-func (p *JobParser) parseActionJobs(actionKey string, value interface{}) ([]*config.Task, error) {
+// parseActionJobs converts an action's configuration into tasks. node is the YAML
+// node of the value, nil when it is unknown.
+func (p *JobParser) parseActionJobs(actionKey string, value interface{}, node *yaml.Node) ([]*config.Task, error) {
 	switch v := value.(type) {
 	case string:
 		// Single string value: ensure_dir: "path"
-		return p.createJobsFromString(actionKey, v), nil
+		return p.createJobsFromString(actionKey, v, nodeLine(node)), nil
 
 	case []interface{}:
 		// Array of items: install: ["git", "vim"] or symlink: [{src: "...", dst: "..."}]
-		return p.createJobsFromArray(actionKey, v)
+		return p.createJobsFromArray(actionKey, v, node)
 
 	case map[string]interface{}:
 		// Single object: symlink: {src: "...", dst: "..."}
-		return p.createJobsFromObject(actionKey, v), nil
+		return p.createJobsFromObject(actionKey, v, nodeLine(node))
 
 	default:
 		return nil, fmt.Errorf("unsupported value type for action '%s': %T", actionKey, value)
@@ -116,7 +141,7 @@ func (p *JobParser) parseActionJobs(actionKey string, value interface{}) ([]*con
 }
 
 // createJobsFromString creates a task from a string value
-func (p *JobParser) createJobsFromString(actionKey, value string) []*config.Task {
+func (p *JobParser) createJobsFromString(actionKey, value string, line int) []*config.Task {
 	p.orderCounter++
 	taskConfig := p.stringToConfig(actionKey, value)
 	task := &config.Task{
@@ -124,21 +149,24 @@ func (p *JobParser) createJobsFromString(actionKey, value string) []*config.Task
 		Action: actionKey,
 		Config: taskConfig,
 		Source: p.getRelativeSource(),
+		Line:   line,
 		Order:  p.orderCounter,
 	}
 	p.extractCondition(task)
+	p.extractTimeout(task)
 	return []*config.Task{task}
 }
 
 // createJobsFromArray creates tasks from an array of values
-func (p *JobParser) createJobsFromArray(actionKey string, values []interface{}) ([]*config.Task, error) {
+func (p *JobParser) createJobsFromArray(actionKey string, values []interface{}, node *yaml.Node) ([]*config.Task, error) {
 	var tasks []*config.Task
 
 	for i, item := range values {
 		p.orderCounter++
+		line := nodeLine(sequenceItem(node, i))
 		taskConfig, err := p.itemToConfig(actionKey, item)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse array item %d: %w", i, err)
+			return nil, fmt.Errorf("failed to parse array item %d (source: %s): %w", i, p.location(line), err)
 		}
 
 		task := &config.Task{
@@ -146,9 +174,26 @@ func (p *JobParser) createJobsFromArray(actionKey string, values []interface{})
 			Action: actionKey,
 			Config: taskConfig,
 			Source: p.getRelativeSource(),
+			Line:   line,
 			Order:  p.orderCounter,
 		}
 		p.extractCondition(task)
+		p.extractTimeout(task)
+		if err := p.extractRetry(task); err != nil {
+			return nil, err
+		}
+		if err := p.extractBecome(task); err != nil {
+			return nil, err
+		}
+		if err := p.extractProfiles(task); err != nil {
+			return nil, err
+		}
+		if err := p.extractTags(task); err != nil {
+			return nil, err
+		}
+		if err := p.extractNotify(task); err != nil {
+			return nil, err
+		}
 		tasks = append(tasks, task)
 	}
 
@@ -156,30 +201,58 @@ func (p *JobParser) createJobsFromArray(actionKey string, values []interface{})
 }
 
 // createJobsFromObject creates a task from an object value
-func (p *JobParser) createJobsFromObject(actionKey string, value map[string]interface{}) []*config.Task {
+func (p *JobParser) createJobsFromObject(actionKey string, value map[string]interface{}, line int) ([]*config.Task, error) {
 	p.orderCounter++
 	task := &config.Task{
 		ID:     p.generateTaskID(actionKey, value),
 		Action: actionKey,
 		Config: value,
 		Source: p.getRelativeSource(),
+		Line:   line,
 		Order:  p.orderCounter,
 	}
 	p.extractCondition(task)
-	return []*config.Task{task}
+	p.extractTimeout(task)
+	if err := p.extractRetry(task); err != nil {
+		return nil, err
+	}
+	if err := p.extractBecome(task); err != nil {
+		return nil, err
+	}
+	if err := p.extractProfiles(task); err != nil {
+		return nil, err
+	}
+	if err := p.extractTags(task); err != nil {
+		return nil, err
+	}
+	if err := p.extractNotify(task); err != nil {
+		return nil, err
+	}
+	return []*config.Task{task}, nil
 }
 
 // stringToConfig converts a string value to appropriate config based on action
-// TODO: This should be made generic by having modules register their string conversion logic
 func (p *JobParser) stringToConfig(actionKey, value string) map[string]interface{} {
+	if key, ok := ShorthandKey(actionKey); ok {
+		return map[string]interface{}{key: value}
+	}
+	// Generic fallback - modules should support this
+	return map[string]interface{}{"value": value}
+}
+
+// ShorthandKey returns the config key a plain string sets for an action, e.g. path
+// for ensure_dir: "~/.config", and whether the action has such a shorthand
+// TODO: This should be made generic by having modules register their string conversion logic
+func ShorthandKey(actionKey string) (string, bool) {
 	switch actionKey {
 	case "ensure_dir", "ensure_file":
-		return map[string]interface{}{"path": value}
-	case "install_package", "uninstall_package":
-		return map[string]interface{}{"name": value}
+		return "path", true
+	case "install_package", "uninstall_package", "ensure_service":
+		return "name", true
+	case "install_font":
+		return "source", true
 	default:
-		// Generic fallback - modules should support this
-		return map[string]interface{}{"value": value}
+		return "", false
 	}
 }
 
@@ -197,7 +270,34 @@ func (p *JobParser) itemToConfig(actionKey string, item interface{}) (map[string
 	}
 }
 
-// getSortedKeys returns map keys sorted to maintain order
+// documentKeys returns the keys of m in the order node lists them. Keys node does
+// not list, or all of them without node or with legacy_ordering, are sorted.
+func (p *JobParser) documentKeys(m map[string]interface{}, node *yaml.Node) []string {
+	if LegacyOrdering() || node == nil || node.Kind != yaml.MappingNode {
+		return p.getSortedKeys(m)
+	}
+
+	keys := make([]string, 0, len(m))
+	listed := make(map[string]bool, len(m))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if _, exists := m[key]; exists && !listed[key] {
+			keys = append(keys, key)
+			listed[key] = true
+		}
+	}
+
+	var rest []string
+	for key := range m {
+		if !listed[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// getSortedKeys returns map keys sorted alphabetically
 func (p *JobParser) getSortedKeys(m map[string]interface{}) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -222,12 +322,22 @@ func (p *JobParser) generateTaskID(actionKey string, config map[string]interface
 		}
 	}
 
+	if paths := stringList(config["paths"]); len(paths) > 0 {
+		return fmt.Sprintf("%s: %s", actionKey, strings.Join(paths, ", "))
+	}
+
 	if value, exists := config["value"]; exists {
 		if valueStr, ok := value.(string); ok {
 			return fmt.Sprintf("%s: %s", actionKey, valueStr)
 		}
 	}
 
+	if source, exists := config["source"]; exists {
+		if sourceStr, ok := source.(string); ok {
+			return fmt.Sprintf("%s: %s", actionKey, sourceStr)
+		}
+	}
+
 	if src, exists := config["src"]; exists {
 		if dst, dstExists := config["dst"]; dstExists {
 			if srcStr, srcOk := src.(string); srcOk {
@@ -236,6 +346,21 @@ func (p *JobParser) generateTaskID(actionKey string, config map[string]interface
 				}
 			}
 		}
+		if srcStr, ok := src.(string); ok {
+			if dsts := stringList(config["dsts"]); len(dsts) > 0 {
+				return fmt.Sprintf("%s: %s -> %s", actionKey, srcStr, strings.Join(dsts, ", "))
+			}
+		}
+	}
+
+	if sourceDir, exists := config["source_dir"]; exists {
+		if targetDir, targetExists := config["target_dir"]; targetExists {
+			if sourceStr, sourceOk := sourceDir.(string); sourceOk {
+				if targetStr, targetOk := targetDir.(string); targetOk {
+					return fmt.Sprintf("%s: %s -> %s", actionKey, sourceStr, targetStr)
+				}
+			}
+		}
 	}
 
 	if packages, exists := config["packages"]; exists {
@@ -248,6 +373,24 @@ func (p *JobParser) generateTaskID(actionKey string, config map[string]interface
 	return fmt.Sprintf("%s_%d", actionKey, p.orderCounter)
 }
 
+// stringList returns the items of a list of strings in a task configuration, nil
+// when value is not one
+func stringList(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			return nil
+		}
+		list = append(list, str)
+	}
+	return list
+}
+
 // extractCondition extracts the condition from task config and moves it to the Condition field
 func (p *JobParser) extractCondition(task *config.Task) {
 	if condition, exists := task.Config["condition"]; exists {
@@ -259,27 +402,242 @@ func (p *JobParser) extractCondition(task *config.Task) {
 	}
 }
 
+// extractTimeout extracts the timeout from task config and moves it to the Timeout field
+func (p *JobParser) extractTimeout(task *config.Task) {
+	if timeout, exists := task.Config["timeout"]; exists {
+		if timeoutStr, ok := timeout.(string); ok {
+			task.Timeout = timeoutStr
+			delete(task.Config, "timeout")
+		}
+	}
+}
+
+// extractRetry extracts the retry options from task config and moves them to the
+// Retries, RetryDelay and RetryBackoff fields
+func (p *JobParser) extractRetry(task *config.Task) error {
+	if value, exists := task.Config["retries"]; exists {
+		retries, ok := value.(int)
+		if !ok {
+			return fmt.Errorf("task '%s' (source: %s): retries must be a number, got %v", task.ID, task.Location(), value)
+		}
+		task.Retries = &retries
+		delete(task.Config, "retries")
+	}
+	if value, exists := task.Config["retry_delay"]; exists {
+		delay, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("task '%s' (source: %s): retry_delay must be a duration like \"10s\", got %v", task.ID, task.Location(), value)
+		}
+		task.RetryDelay = delay
+		delete(task.Config, "retry_delay")
+	}
+	if value, exists := task.Config["retry_backoff"]; exists {
+		backoff, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("task '%s' (source: %s): retry_backoff must be true or false, got %v", task.ID, task.Location(), value)
+		}
+		task.RetryBackoff = &backoff
+		delete(task.Config, "retry_backoff")
+	}
+	return nil
+}
+
+// extractBecome extracts become from task config and moves it to the Become field
+func (p *JobParser) extractBecome(task *config.Task) error {
+	value, exists := task.Config["become"]
+	if !exists {
+		return nil
+	}
+	become, ok := value.(bool)
+	if !ok {
+		return fmt.Errorf("task '%s' (source: %s): become must be true or false, got %v", task.ID, task.Location(), value)
+	}
+	task.Become = become
+	delete(task.Config, "become")
+	return nil
+}
+
+// extractProfiles extracts the profiles from task config and moves them to the Profiles field
+func (p *JobParser) extractProfiles(task *config.Task) error {
+	value, exists := task.Config["profiles"]
+	if !exists {
+		return nil
+	}
+	profiles, err := config.ParseProfiles(value)
+	if err != nil {
+		return fmt.Errorf("task '%s' (source: %s): %w", task.ID, task.Location(), err)
+	}
+	task.Profiles = profiles
+	delete(task.Config, "profiles")
+	p.addProfileRefs(profiles)
+	return nil
+}
+
+// extractTags extracts the tags from task config and moves them to the Tags field
+func (p *JobParser) extractTags(task *config.Task) error {
+	value, exists := task.Config["tags"]
+	if !exists {
+		return nil
+	}
+	tags, err := config.ParseTags(value)
+	if err != nil {
+		return fmt.Errorf("task '%s' (source: %s): %w", task.ID, task.Location(), err)
+	}
+	task.Tags = tags
+	delete(task.Config, "tags")
+	p.addRefs(p.tagRefs, tags)
+	return nil
+}
 
+// extractNotify extracts the handlers a task notifies from task config and moves them
+// to the Notify field
+func (p *JobParser) extractNotify(task *config.Task) error {
+	value, exists := task.Config["notify"]
+	if !exists {
+		return nil
+	}
+	notify, err := config.ParseNotify(value)
+	if err != nil {
+		return fmt.Errorf("task '%s' (source: %s): %w", task.ID, task.Location(), err)
+	}
+	task.Notify = notify
+	delete(task.Config, "notify")
+	return nil
+}
+
+// parseHandlers adds the handlers section of a jobs file. Handlers map a name to a
+// command, or to a run_command configuration without a name.
+func (p *JobParser) parseHandlers(node *yaml.Node) error {
+	if node.Kind == 0 || (node.Kind == yaml.ScalarNode && node.Tag == "!!null") {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: handlers must map handler names to commands", node.Line)
+	}
+
+handlers:
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		for _, handler := range p.handlers {
+			if handler.Name != name {
+				continue
+			}
+			// Imports for different platforms or profiles may define the same handler
+			if p.allImports {
+				continue handlers
+			}
+			return fmt.Errorf("handler '%s' is defined in both %s and %s", name, handler.Task.Location(), p.location(node.Content[i].Line))
+		}
+
+		var value interface{}
+		if err := node.Content[i+1].Decode(&value); err != nil {
+			return fmt.Errorf("handler '%s': %w", name, err)
+		}
+		var taskConfig map[string]interface{}
+		switch v := value.(type) {
+		case string:
+			taskConfig = map[string]interface{}{"command": v}
+		case map[string]interface{}:
+			taskConfig = v
+		default:
+			return fmt.Errorf("handler '%s' must be a command or a run_command configuration", name)
+		}
+		taskConfig["name"] = name
+
+		task := &config.Task{
+			ID:     "handler: " + name,
+			Action: "run_command",
+			Config: taskConfig,
+			Source: p.getRelativeSource(),
+			Line:   node.Content[i].Line,
+		}
+		p.extractTimeout(task)
+		if err := p.extractRetry(task); err != nil {
+			return err
+		}
+		if err := p.extractBecome(task); err != nil {
+			return err
+		}
+		p.handlers = append(p.handlers, &config.Handler{Name: name, Task: task})
+	}
+	return nil
+}
+
+// addProfileRefs records that the current file references profiles
+func (p *JobParser) addProfileRefs(profiles []string) {
+	p.addRefs(p.profileRefs, profiles)
+}
+
+// addRefs records in refs that the current file references names
+func (p *JobParser) addRefs(refs map[string][]string, names []string) {
+	source := p.getRelativeSource()
+	for _, name := range names {
+		files := refs[name]
+		if len(files) == 0 || files[len(files)-1] != source {
+			refs[name] = append(files, source)
+		}
+	}
+}
+
+// ProfileSelected reports whether a task or import limited to profiles runs with the
+// selected profiles. Without profiles it always runs.
+func ProfileSelected(profiles, selected []string) bool {
+	if len(profiles) == 0 {
+		return true
+	}
+	for _, profile := range profiles {
+		for _, s := range selected {
+			if profile == s {
+				return true
+			}
+		}
+	}
+	return false
+}
 
 // LoadJobsFromFileWithConditions loads and parses jobs from a file, filtering by conditions
-func LoadJobsFromFileWithConditions(filePath string, variables map[string]interface{}) ([]*config.Task, error) {
+// and by profiles. Tasks and imports with profiles are only included when one of them is
+// in profiles.
+func LoadJobsFromFileWithConditions(filePath string, variables map[string]interface{}, profiles []string) ([]*config.Task, error) {
+	tasks, _, err := LoadJobsAndHandlers(filePath, variables, profiles)
+	return tasks, err
+}
+
+// LoadJobsAndHandlers loads jobs like LoadJobsFromFileWithConditions, together with the
+// handlers defined in the files that are imported. Tasks notifying a handler that is
+// not defined are an error.
+func LoadJobsAndHandlers(filePath string, variables map[string]interface{}, profiles []string) ([]*config.Task, []*config.Handler, error) {
+	tasks, _, handlers, err := LoadJobsWithSkipped(filePath, variables, profiles)
+	return tasks, handlers, err
+}
 
+// LoadJobsWithSkipped loads jobs like LoadJobsAndHandlers, and also returns the tasks
+// of the selected profiles that are left out because their condition is false, so
+// plan can show them instead of leaving them out silently
+func LoadJobsWithSkipped(filePath string, variables map[string]interface{}, profiles []string) ([]*config.Task, []*config.Task, []*config.Handler, error) {
 	parser := NewJobParser(filepath.Dir(filepath.Dir(filePath))) // Go up one level to get the dotfiles root
+	parser.profiles = profiles
 	allTasks, err := parser.ParseJobsIndex(filePath, variables)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse jobs: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to parse jobs: %w", err)
 	}
 
 	// Filter tasks based on conditions
-	var filteredTasks []*config.Task
+	var filteredTasks, skippedTasks []*config.Task
 	for _, task := range allTasks {
+		if !ProfileSelected(task.Profiles, profiles) {
+			continue
+		}
+
 		// Check condition
 		if task.Condition != "" {
-			shouldExecute, err := parser.evaluateCondition(task.Condition, variables)
+			templating.SetUsageScope(task.ID, task.Location())
+			shouldExecute, err := parser.evaluateCondition(task.Condition, task.ScopedVariables(variables), task.Location())
 			if err != nil {
-				return nil, fmt.Errorf("failed to evaluate condition for task '%s': %w", task.ID, err)
+				return nil, nil, nil, fmt.Errorf("failed to evaluate condition for task '%s': %w", task.ID, err)
 			}
 			if !shouldExecute {
+				skippedTasks = append(skippedTasks, task)
 				continue
 			}
 		}
@@ -287,7 +645,96 @@ func LoadJobsFromFileWithConditions(filePath string, variables map[string]interf
 		filteredTasks = append(filteredTasks, task)
 	}
 
-	return filteredTasks, nil
+	if err := checkNotify(filteredTasks, parser.handlers); err != nil {
+		return nil, nil, nil, err
+	}
+	return filteredTasks, skippedTasks, parser.handlers, nil
+}
+
+// checkNotify checks that the handlers tasks notify are defined
+func checkNotify(tasks []*config.Task, handlers []*config.Handler) error {
+	defined := make(map[string]bool, len(handlers))
+	for _, handler := range handlers {
+		defined[handler.Name] = true
+	}
+	for _, task := range tasks {
+		for _, name := range task.Notify {
+			if !defined[name] {
+				return fmt.Errorf("task '%s' in %s notifies handler '%s', which is not defined", task.ID, task.Location(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// FilterTasksByTags keeps the tasks that have one of tags, or every task when tags is
+// empty, and drops the tasks that have one of skipTags
+func FilterTasksByTags(tasks []*config.Task, tags, skipTags []string) []*config.Task {
+	var filtered []*config.Task
+	for _, task := range tasks {
+		if len(tags) > 0 && !hasAnyTag(task.Tags, tags) {
+			continue
+		}
+		if hasAnyTag(task.Tags, skipTags) {
+			continue
+		}
+		filtered = append(filtered, task)
+	}
+	return filtered
+}
+
+// hasAnyTag reports whether one of the tags of a task is in tags
+func hasAnyTag(taskTags, tags []string) bool {
+	for _, tag := range taskTags {
+		for _, t := range tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CollectProfiles returns every profile referenced by the tasks and imports of a jobs
+// file and all files it imports, whatever their conditions, with the files referencing it
+func CollectProfiles(filePath string, variables map[string]interface{}) (map[string][]string, error) {
+	parser, err := parseAllImports(filePath, variables)
+	if err != nil {
+		return nil, err
+	}
+	return parser.profileRefs, nil
+}
+
+// CollectTags returns every tag used by the tasks of a jobs file and all files it
+// imports, whatever their conditions and profiles, with the files using it
+func CollectTags(filePath string, variables map[string]interface{}) (map[string][]string, error) {
+	parser, err := parseAllImports(filePath, variables)
+	if err != nil {
+		return nil, err
+	}
+	return parser.tagRefs, nil
+}
+
+// LoadAllJobs loads the tasks and handlers of a jobs file and all files it imports,
+// whatever their conditions and profiles
+func LoadAllJobs(filePath string, variables map[string]interface{}) ([]*config.Task, []*config.Handler, error) {
+	parser := NewJobParser(filepath.Dir(filepath.Dir(filePath)))
+	parser.allImports = true
+	tasks, err := parser.ParseJobsIndex(filePath, variables)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse jobs: %w", err)
+	}
+	return tasks, parser.handlers, nil
+}
+
+// parseAllImports parses a jobs file following every import
+func parseAllImports(filePath string, variables map[string]interface{}) (*JobParser, error) {
+	parser := NewJobParser(filepath.Dir(filepath.Dir(filePath)))
+	parser.allImports = true
+	if _, err := parser.ParseJobsIndex(filePath, variables); err != nil {
+		return nil, fmt.Errorf("failed to parse jobs: %w", err)
+	}
+	return parser, nil
 }
 
 // evaluateCondition evaluates a condition string against variables using the new templating engine
@@ -297,52 +744,77 @@ func LoadJobsFromFileWithConditions(filePath string, variables map[string]interf
 //   Platform.OS == "linux" && !Platform.IsElevated
 //   "docker" in Platform.Tags
 //   Platform.Version matches "^22\\."
-func (p *JobParser) evaluateCondition(condition string, variables map[string]interface{}) (bool, error) {
+//   commandExists("docker") && hasPackageManager("apt")
+func (p *JobParser) evaluateCondition(condition string, variables map[string]interface{}, source string) (bool, error) {
 	result, err := p.templateEngine.EvaluateCondition(condition, variables)
 	if err != nil {
-		return false, p.enhanceJobError(err, fmt.Sprintf("condition evaluation: '%s'", condition))
+		return false, p.enhanceJobError(err, fmt.Sprintf("condition evaluation: '%s'", condition), source)
 	}
 	return result, nil
 }
 
-// validateAndSuggestConditionFix provides helpful error messages for condition syntax
-func (p *JobParser) validateAndSuggestConditionFix(condition string) error {
-	// Provide help for migrating from old syntax
-	if strings.Contains(condition, "eq ") || strings.Contains(condition, "and ") || strings.Contains(condition, "or ") {
-		return fmt.Errorf("legacy template syntax detected. New syntax examples:\n  Old: eq .Platform.OS \"linux\"\n  New: Platform.OS == \"linux\"\n  Old: and (eq .Platform.OS \"linux\") (not .Platform.IsElevated)\n  New: Platform.OS == \"linux\" && !Platform.IsElevated")
+// processImport processes a single import file with conditions. source is where
+// the import is defined.
+func (p *JobParser) processImport(importFile config.ImportFile, source string, variables map[string]interface{}) ([]*config.Task, error) {
+	// The variables of the import override the ambient ones for its path, condition
+	// and everything in it, other imports don't see them
+	if len(importFile.Variables) > 0 {
+		variables = config.OverlayVariables(variables, importFile.Variables)
 	}
-	return nil
-}
 
-// processImport processes a single import file with conditions
-func (p *JobParser) processImport(importFile config.ImportFile, variables map[string]interface{}) ([]*config.Task, error) {
 	// Process import path template using Pongo2
-	importPath, err := p.templateEngine.ProcessVariableTemplate(importFile.Path, variables)
+	templating.SetUsageScope("import "+importFile.Path, source)
+	importPath, err := p.templateEngine.ProcessString(importFile.Path, variables)
 	if err != nil {
-		return nil, p.enhanceJobError(err, fmt.Sprintf("import path template: '%s'", importFile.Path))
+		return nil, p.enhanceJobError(err, fmt.Sprintf("import path template: '%s'", importFile.Path), source)
 	}
 
-	// Check if the processed path contains unresolved template placeholders
-	if strings.Contains(importPath, "<no value>") || strings.Contains(importPath, "{{") {
-		// Skip imports with unresolved variables
+	// Imports whose path references undefined variables fail in strict mode
+	if skip, err := config.CheckImportPath(p.templateEngine, importFile.Path, importPath, source, variables); skip {
+		if err != nil {
+			return nil, err
+		}
 		return []*config.Task{}, nil
 	}
 
+	p.addProfileRefs(importFile.Profiles)
+	if !p.allImports && !ProfileSelected(importFile.Profiles, p.profiles) {
+		return []*config.Task{}, nil // Skip imports for other profiles
+	}
+
+	// Conditions are not evaluated when following every import, record what they read
+	if p.allImports {
+		p.templateEngine.RecordCondition(importFile.Condition, variables)
+	}
+
 	// Check condition if specified
-	if importFile.Condition != "" {
-		shouldImport, err := p.evaluateCondition(importFile.Condition, variables)
+	if importFile.Condition != "" && !p.allImports {
+		shouldImport, err := p.evaluateCondition(importFile.Condition, variables, source)
 		if err != nil {
-			return nil, p.enhanceJobError(err, fmt.Sprintf("import condition for '%s': '%s'", importFile.Path, importFile.Condition))
+			return nil, fmt.Errorf("import condition for '%s': %w", importFile.Path, err)
 		}
 		if !shouldImport {
 			return []*config.Task{}, nil // Skip this import
 		}
 	}
 
-	// Resolve relative path
+	// Resolve relative path. Paths in remote repositories are relative to their
+	// root, relative imports inside them stay in the same repository.
 	var fullPath string
-	if filepath.IsAbs(importPath) {
+	if importFile.Repo != "" {
+		checkout, err := config.FetchRemoteImport(p.basePath, importFile.Repo, importFile.Ref)
+		if err != nil {
+			return nil, err
+		}
+		fullPath = filepath.Join(checkout, importPath)
+
+		oldRoot := p.remoteRoot
+		p.remoteRoot = checkout
+		defer func() { p.remoteRoot = oldRoot }()
+	} else if filepath.IsAbs(importPath) {
 		fullPath = importPath
+	} else if p.remoteRoot != "" {
+		fullPath = filepath.Join(p.remoteRoot, "jobs", importPath)
 	} else {
 		fullPath = filepath.Join(p.basePath, "jobs", importPath)
 	}
@@ -353,23 +825,33 @@ func (p *JobParser) processImport(importFile config.ImportFile, variables map[st
 	}
 
 	// Parse imported jobs file
+	handlerCount := len(p.handlers)
 	importedTasks, err := p.ParseJobsIndex(fullPath, variables)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse imported jobs file %s: %w", fullPath, err)
 	}
 
+	// Record the variables on the tasks so modules render them with the same
+	// variables. Variables of nested imports win over those of this one.
+	if len(importFile.Variables) > 0 {
+		for _, task := range importedTasks {
+			task.Variables = config.OverlayVariables(importFile.Variables, task.Variables)
+		}
+		for _, handler := range p.handlers[handlerCount:] {
+			handler.Task.Variables = config.OverlayVariables(importFile.Variables, handler.Task.Variables)
+		}
+	}
+
 	return importedTasks, nil
 }
 
-// processTemplate processes a template string with variables using Pongo2
+// processTemplate processes a template of a path with OS-specific path separators
 func (p *JobParser) processTemplate(templateStr string, variables map[string]interface{}) (string, error) {
-	result, err := p.templateEngine.ProcessVariableTemplate(templateStr, variables)
+	result, err := p.templateEngine.ProcessPath(templateStr, variables)
 	if err != nil {
-		return "", p.enhanceJobError(err, fmt.Sprintf("template processing: '%s'", templateStr))
+		return "", p.enhanceJobError(err, fmt.Sprintf("template processing: '%s'", templateStr), p.getRelativeSource())
 	}
-
-	// Ensure OS-specific path separators
-	return filepath.FromSlash(result), nil
+	return result, nil
 }
 
 // addToImportChain adds a file to the import chain to detect circular imports
@@ -382,6 +864,9 @@ func (p *JobParser) addToImportChain(filePath string) error {
 	// Check for circular import
 	for _, existing := range p.importChain {
 		if existing == absPath {
+			if source := config.RemoteImportSource(absPath); source != "" {
+				return fmt.Errorf("circular import detected: %s", source)
+			}
 			return fmt.Errorf("circular import detected: %s", absPath)
 		}
 	}
@@ -403,6 +888,11 @@ func (p *JobParser) getRelativeSource() string {
 		return ""
 	}
 
+	// Files of remote imports are named after their repository
+	if source := config.RemoteImportSource(p.currentFile); source != "" {
+		return source
+	}
+
 	// Try to make it relative to the base path
 	if relPath, err := filepath.Rel(p.basePath, p.currentFile); err == nil {
 		return relPath
@@ -412,16 +902,56 @@ func (p *JobParser) getRelativeSource() string {
 	return filepath.Base(p.currentFile)
 }
 
-// enhanceJobError provides better context for job-related errors
-func (p *JobParser) enhanceJobError(err error, context string) error {
+// location returns a line of the current source file as "file:line", or just the
+// file when the line is unknown
+func (p *JobParser) location(line int) string {
+	task := config.Task{Source: p.getRelativeSource(), Line: line}
+	return task.Location()
+}
+
+// mappingValue returns the value of key in a YAML mapping, nil when node is not a
+// mapping or has no such key
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sequenceItem returns item i of a YAML sequence, nil when node is not a sequence
+// or is shorter
+func sequenceItem(node *yaml.Node, i int) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return nil
+	}
+	return node.Content[i]
+}
+
+// nodeLine returns the line a YAML node starts at, 0 when node is nil
+func nodeLine(node *yaml.Node) int {
+	if node == nil {
+		return 0
+	}
+	return node.Line
+}
+
+// enhanceJobError provides better context for job-related errors. source is where
+// the job or import is defined, as "file:line" when the line is known.
+func (p *JobParser) enhanceJobError(err error, context string, source string) error {
 	var errorMsg strings.Builder
 
 	errorMsg.WriteString(fmt.Sprintf("job parsing error: %s\n", context))
 	errorMsg.WriteString(fmt.Sprintf("  error: %s\n", err.Error()))
 
-	if p.currentFile != "" {
-		errorMsg.WriteString(fmt.Sprintf("  source: %s\n", p.getRelativeSource()))
+	if source == "" {
+		source = "(unknown source)"
 	}
+	errorMsg.WriteString(fmt.Sprintf("  source: %s\n", source))
 
 	// Add helpful suggestions based on error type
 	errorStr := err.Error()
@@ -437,6 +967,10 @@ func (p *JobParser) enhanceJobError(err error, context string) error {
 		errorMsg.WriteString("  • Use Platform.OS == \"linux\" (not eq .Platform.OS \"linux\")\n")
 		errorMsg.WriteString("  • Use && for AND, || for OR, ! for NOT\n")
 		errorMsg.WriteString("  • Use \"value\" in list to check membership\n")
+		errorMsg.WriteString("  • Helper functions:\n")
+		for _, help := range templating.ConditionHelp() {
+			errorMsg.WriteString(fmt.Sprintf("      %s\n", help))
+		}
 	}
 
 	return fmt.Errorf(errorMsg.String())
@@ -0,0 +1,277 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeJobs writes jobs files relative to the jobs directory of a new repository and
+// returns the path of its index
+func writeJobs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	jobsDir := filepath.Join(t.TempDir(), "jobs")
+	for name, content := range files {
+		path := filepath.Join(jobsDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return filepath.Join(jobsDir, "index.yaml")
+}
+
+func TestImportVariables(t *testing.T) {
+	indexPath := writeJobs(t, map[string]string{
+		"index.yaml": `imports:
+  - path: devtools.yaml
+    variables:
+      toolchain: go
+      tool:
+        version: "1.22"
+  - path: devtools.yaml
+    variables:
+      toolchain: rust
+  - path: "{{ toolchain }}.yaml"
+    variables:
+      toolchain: node
+  - path: python.yaml
+    condition: 'toolchain == "python"'
+    variables:
+      toolchain: python
+run_command:
+  - name: "ambient {{ toolchain }}"
+    command: "true"
+  - name: "ambient go only"
+    command: "true"
+    condition: 'toolchain == "go"'
+`,
+		"devtools.yaml": `imports:
+  - path: nested.yaml
+    variables:
+      tool:
+        name: nested
+run_command:
+  - name: "install {{ toolchain }}"
+    command: "true"
+  - name: "go only"
+    command: "true"
+    condition: 'toolchain == "go"'
+`,
+		"nested.yaml": `run_command:
+  - name: "nested {{ toolchain }}"
+    command: "true"
+`,
+		"node.yaml": `run_command:
+  - name: "node"
+    command: "true"
+`,
+		"python.yaml": `run_command:
+  - name: "python"
+    command: "true"
+`,
+	})
+	variables := map[string]interface{}{
+		"toolchain": "none",
+		"tool":      map[string]interface{}{"version": "1.0", "arch": "amd64"},
+	}
+
+	tasks, err := LoadJobsFromFileWithConditions(indexPath, variables, nil)
+	require.NoError(t, err)
+
+	var names []string
+	for _, task := range tasks {
+		names = append(names, task.Config["name"].(string))
+	}
+	// The go only tasks are skipped outside the import setting toolchain to go, the
+	// condition of the python import sees its own variables
+	assert.Equal(t, []string{
+		"nested {{ toolchain }}", "install {{ toolchain }}", "go only",
+		"nested {{ toolchain }}", "install {{ toolchain }}",
+		"node",
+		"python",
+		"ambient {{ toolchain }}",
+	}, names)
+
+	// Nested imports merge their variables over those of the import they are in
+	assert.Equal(t, map[string]interface{}{
+		"toolchain": "go",
+		"tool":      map[string]interface{}{"version": "1.22", "name": "nested"},
+	}, tasks[0].Variables)
+	assert.Equal(t, map[string]interface{}{"toolchain": "go", "tool": map[string]interface{}{"version": "1.22"}}, tasks[1].Variables)
+	assert.Equal(t, map[string]interface{}{"toolchain": "rust"}, tasks[4].Variables)
+	assert.Equal(t, map[string]interface{}{"toolchain": "node"}, tasks[5].Variables)
+	assert.Nil(t, tasks[7].Variables)
+
+	// Modules render tasks with the variables of their imports over the loaded ones
+	assert.Equal(t, map[string]interface{}{
+		"toolchain": "go",
+		"tool":      map[string]interface{}{"version": "1.22", "arch": "amd64", "name": "nested"},
+	}, tasks[0].ScopedVariables(variables))
+	assert.Equal(t, "rust", tasks[4].ScopedVariables(variables)["toolchain"])
+	assert.Equal(t, "none", tasks[7].ScopedVariables(variables)["toolchain"])
+
+	// The loaded variables are left alone
+	assert.Equal(t, "none", variables["toolchain"])
+	assert.Equal(t, map[string]interface{}{"version": "1.0", "arch": "amd64"}, variables["tool"])
+}
+
+func TestImportVariablesHandlers(t *testing.T) {
+	indexPath := writeJobs(t, map[string]string{
+		"index.yaml": `imports:
+  - path: service.yaml
+    variables:
+      service: nginx
+`,
+		"service.yaml": `handlers:
+  restart: "systemctl restart {{ service }}"
+run_command:
+  - name: configure
+    command: "true"
+    notify: restart
+`,
+	})
+
+	tasks, handlers, err := LoadJobsAndHandlers(indexPath, map[string]interface{}{}, nil)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	require.Len(t, handlers, 1)
+	assert.Equal(t, map[string]interface{}{"service": "nginx"}, handlers[0].Task.Variables)
+}
+
+func TestExtractRetry(t *testing.T) {
+	indexPath := writeJobs(t, map[string]string{
+		"index.yaml": `install_package:
+  - name: ripgrep
+    retries: 3
+    retry_delay: 10s
+    retry_backoff: true
+  - name: fd
+`,
+	})
+
+	tasks, err := LoadJobsFromFileWithConditions(indexPath, map[string]interface{}{}, nil)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	require.NotNil(t, tasks[0].Retries)
+	assert.Equal(t, 3, *tasks[0].Retries)
+	assert.Equal(t, "10s", tasks[0].RetryDelay)
+	require.NotNil(t, tasks[0].RetryBackoff)
+	assert.True(t, *tasks[0].RetryBackoff)
+	assert.Equal(t, map[string]interface{}{"name": "ripgrep"}, tasks[0].Config)
+	assert.Nil(t, tasks[1].Retries)
+	assert.Nil(t, tasks[1].RetryBackoff)
+
+	indexPath = writeJobs(t, map[string]string{
+		"index.yaml": `install_package:
+  - name: ripgrep
+    retries: often
+`,
+	})
+	_, err = LoadJobsFromFileWithConditions(indexPath, map[string]interface{}{}, nil)
+	assert.ErrorContains(t, err, "retries must be a number")
+}
+
+func TestExtractBecome(t *testing.T) {
+	indexPath := writeJobs(t, map[string]string{
+		"index.yaml": `ensure_file:
+  - dest: /etc/hosts
+    content: "127.0.0.1 localhost"
+    become: true
+  - dest: ~/.hushlogin
+    content: ""
+`,
+	})
+
+	tasks, err := LoadJobsFromFileWithConditions(indexPath, map[string]interface{}{}, nil)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.True(t, tasks[0].Become)
+	assert.NotContains(t, tasks[0].Config, "become")
+	assert.False(t, tasks[1].Become)
+
+	indexPath = writeJobs(t, map[string]string{
+		"index.yaml": `ensure_file:
+  - dest: /etc/hosts
+    become: yes please
+`,
+	})
+	_, err = LoadJobsFromFileWithConditions(indexPath, map[string]interface{}{}, nil)
+	assert.ErrorContains(t, err, "become must be true or false")
+}
+
+func TestJobsRunInFileOrder(t *testing.T) {
+	indexPath := writeJobs(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "shell.yaml"
+  - path: "base.yaml"
+
+install_package:
+  - name: zsh
+ensure_file:
+  - path: ~/.zshrc
+    content: ""
+ensure_dir: ~/.config
+`,
+		"shell.yaml": `symlink:
+  - src: files/tmux.conf
+    dst: ~/.tmux.conf
+`,
+		"base.yaml": `run_command: echo base
+`,
+	})
+
+	actions := func() []string {
+		tasks, err := LoadJobsFromFileWithConditions(indexPath, map[string]interface{}{}, nil)
+		require.NoError(t, err)
+		var actions []string
+		for i, task := range tasks {
+			assert.Equal(t, i+1, task.Order)
+			actions = append(actions, task.Action)
+		}
+		return actions
+	}
+	assert.Equal(t, []string{"symlink", "run_command", "install_package", "ensure_file", "ensure_dir"}, actions())
+
+	ConfigureLegacyOrdering(true)
+	t.Cleanup(func() { ConfigureLegacyOrdering(false) })
+	assert.Equal(t, []string{"symlink", "run_command", "ensure_dir", "ensure_file", "install_package"}, actions())
+}
+
+func TestUnresolvedJobImport(t *testing.T) {
+	indexPath := writeJobs(t, map[string]string{
+		"index.yaml": `imports:
+  - path: "common{{ Platform.Distroo }}.yaml"
+`,
+		"common.yaml": `install_package:
+  - name: ripgrep
+`,
+	})
+	variables := map[string]interface{}{"Platform": map[string]interface{}{"OS": "linux", "Distro": "arch"}}
+
+	// Without strict imports the path is used as it renders, with a warning
+	tasks, err := LoadJobsFromFileWithConditions(indexPath, variables, nil)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+
+	var found *config.UnresolvedImportError
+	for _, unresolved := range config.UnresolvedImports() {
+		if unresolved.Path == "common{{ Platform.Distroo }}.yaml" {
+			found = unresolved
+		}
+	}
+	require.NotNil(t, found, "the import is reported")
+	assert.Equal(t, "jobs/index.yaml:2", found.Source)
+
+	config.ConfigureStrictImports(true)
+	defer config.ConfigureStrictImports(false)
+
+	_, err = LoadJobsFromFileWithConditions(indexPath, variables, nil)
+	var unresolved *config.UnresolvedImportError
+	require.ErrorAs(t, err, &unresolved)
+	assert.Equal(t, "jobs/index.yaml:2", unresolved.Source)
+	assert.Contains(t, unresolved.Expression, "did you mean 'Platform.Distro'")
+}
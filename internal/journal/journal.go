@@ -0,0 +1,321 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+const (
+	// JournalFile is the name of the journal inside the journal directory
+	JournalFile = "journal.json"
+
+	filesDir = "files"
+)
+
+// ErrNoJournal is returned by Load when no apply left a journal behind
+var ErrNoJournal = errors.New("no rollback journal found")
+
+// Kind is what existed at a path before a task changed it
+type Kind string
+
+const (
+	KindMissing Kind = "missing" // Nothing existed, rolling back removes the path
+	KindFile    Kind = "file"    // A regular file, its content is kept in the journal
+	KindSymlink Kind = "symlink" // A symbolic link
+	KindDir     Kind = "dir"     // A directory, only its mode is restored
+)
+
+// Entry records the state of a path before a task changed it
+type Entry struct {
+	TaskID     string `json:"task_id"`
+	Path       string `json:"path"`
+	Kind       Kind   `json:"kind"`
+	Mode       string `json:"mode,omitempty"`
+	Backup     string `json:"backup,omitempty"` // Copy of the content relative to the journal directory
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+// Irreversible records a task whose changes cannot be rolled back by restoring files
+type Irreversible struct {
+	TaskID      string `json:"task_id"`
+	Description string `json:"description"`
+}
+
+// Journal records the previous state of everything an apply changes, so a failed
+// apply can be rolled back. It is written to disk after every change so a later
+// `dotfiles rollback` can finish the job when the process itself crashed.
+type Journal struct {
+	StartedAt    time.Time       `json:"started_at"`
+	Entries      []*Entry        `json:"entries"`
+	Irreversible []*Irreversible `json:"irreversible"`
+
+	dir      string
+	recorded map[string]bool
+}
+
+// RollbackResult summarizes a rollback
+type RollbackResult struct {
+	Restored     []string        // Paths restored to their previous state
+	Errors       []error         // Paths that could not be restored
+	Irreversible []*Irreversible // Tasks that have to be undone by hand
+}
+
+// Dir returns the directory the journal of a dotfiles repository is kept in
+func Dir(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), "journal")
+}
+
+// Exists reports whether an unfinished apply left a journal behind
+func Exists(basePath string) bool {
+	return utils.FileExists(filepath.Join(Dir(basePath), JournalFile))
+}
+
+// Begin starts a new journal. It refuses to overwrite the journal of an earlier
+// apply that was not rolled back.
+func Begin(basePath string, now time.Time) (*Journal, error) {
+	if Exists(basePath) {
+		return nil, fmt.Errorf("a rollback journal from an earlier apply exists in %s", Dir(basePath))
+	}
+
+	j := &Journal{
+		StartedAt:    now,
+		Entries:      []*Entry{},
+		Irreversible: []*Irreversible{},
+		dir:          Dir(basePath),
+		recorded:     make(map[string]bool),
+	}
+	if err := os.MkdirAll(filepath.Join(j.dir, filesDir), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	return j, j.save()
+}
+
+// Load reads the journal an earlier apply left behind
+func Load(basePath string) (*Journal, error) {
+	dir := Dir(basePath)
+	data, err := os.ReadFile(filepath.Join(dir, JournalFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNoJournal
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	j := &Journal{dir: dir, recorded: make(map[string]bool)}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal %s: %w", filepath.Join(dir, JournalFile), err)
+	}
+	for _, entry := range j.Entries {
+		j.recorded[entry.Path] = true
+	}
+	return j, nil
+}
+
+// Record saves the current state of paths a task is about to change. Only the
+// first state of a path is kept, since that is what a rollback restores.
+func (j *Journal) Record(taskID string, paths ...string) error {
+	for _, path := range paths {
+		if err := j.record(taskID, path); err != nil {
+			return err
+		}
+	}
+	return j.save()
+}
+
+// RecordIrreversible notes a task whose changes cannot be rolled back automatically
+func (j *Journal) RecordIrreversible(taskID, description string) error {
+	j.Irreversible = append(j.Irreversible, &Irreversible{TaskID: taskID, Description: description})
+	return j.save()
+}
+
+// record adds the state of a single path to the journal
+func (j *Journal) record(taskID, path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	if j.recorded[path] {
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		// Directories created on the way to the path have to be removed as well,
+		// outermost first so rolling back in reverse removes them last
+		if parent := filepath.Dir(path); parent != path && !utils.FileExists(parent) {
+			if err := j.record(taskID, parent); err != nil {
+				return err
+			}
+		}
+		j.add(&Entry{TaskID: taskID, Path: path, Kind: KindMissing})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	entry := &Entry{TaskID: taskID, Path: path, Mode: fmt.Sprintf("%04o", info.Mode().Perm())}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		entry.Kind = KindSymlink
+		if entry.LinkTarget, err = os.Readlink(path); err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", path, err)
+		}
+		j.add(entry)
+
+		// Writing to a symlink changes the file it points to
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && utils.FileExists(resolved) && !utils.IsDirectory(resolved) {
+			return j.record(taskID, resolved)
+		}
+		return nil
+	case info.IsDir():
+		entry.Kind = KindDir
+	case info.Mode().IsRegular():
+		entry.Kind = KindFile
+		entry.Backup = filepath.ToSlash(filepath.Join(filesDir, strconv.Itoa(len(j.Entries))))
+		if err := utils.CopyFile(path, filepath.Join(j.dir, filepath.FromSlash(entry.Backup))); err != nil {
+			return fmt.Errorf("failed to save %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("cannot record %s: not a regular file, directory or symlink", path)
+	}
+
+	j.add(entry)
+	return nil
+}
+
+// add appends an entry and marks its path as recorded
+func (j *Journal) add(entry *Entry) {
+	j.Entries = append(j.Entries, entry)
+	j.recorded[entry.Path] = true
+}
+
+// Rollback restores every recorded path, newest first. The journal is removed when
+// everything was restored and kept otherwise so the rollback can be retried.
+func (j *Journal) Rollback() *RollbackResult {
+	result := &RollbackResult{Irreversible: j.Irreversible}
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		entry := j.Entries[i]
+		if err := j.restore(entry); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("%s: %w", entry.Path, err))
+			continue
+		}
+		result.Restored = append(result.Restored, entry.Path)
+	}
+
+	if len(result.Errors) == 0 {
+		if err := j.Discard(); err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+	}
+	return result
+}
+
+// restore puts a single path back into its recorded state
+func (j *Journal) restore(entry *Entry) error {
+	switch entry.Kind {
+	case KindMissing:
+		err := os.Remove(entry.Path)
+		if err != nil && !os.IsNotExist(err) {
+			if utils.IsDirectory(entry.Path) {
+				return fmt.Errorf("directory was created by the apply but is not empty, left in place")
+			}
+			return err
+		}
+		return nil
+	case KindFile:
+		mode, err := parseMode(entry.Mode)
+		if err != nil {
+			return err
+		}
+		if err := removeReplacement(entry.Path); err != nil {
+			return err
+		}
+		if err := utils.CopyFile(filepath.Join(j.dir, filepath.FromSlash(entry.Backup)), entry.Path); err != nil {
+			return err
+		}
+		return os.Chmod(entry.Path, mode)
+	case KindSymlink:
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(entry.Path), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(entry.LinkTarget, entry.Path)
+	case KindDir:
+		mode, err := parseMode(entry.Mode)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(entry.Path, mode); err != nil {
+			return err
+		}
+		if runtime.GOOS == "windows" {
+			return nil
+		}
+		return os.Chmod(entry.Path, mode)
+	default:
+		return fmt.Errorf("unknown journal entry kind '%s'", entry.Kind)
+	}
+}
+
+// removeReplacement removes whatever an apply put where a regular file used to be
+func removeReplacement(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		return nil // Overwritten in place
+	}
+	return os.Remove(path)
+}
+
+// parseMode parses an octal mode recorded in the journal
+func parseMode(mode string) (os.FileMode, error) {
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode '%s' in journal", mode)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// Discard removes the journal, e.g. after the apply it belongs to succeeded
+func (j *Journal) Discard() error {
+	if err := os.RemoveAll(j.dir); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// save writes the journal to disk. It is replaced atomically so a crash never
+// leaves a truncated journal behind.
+func (j *Journal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal: %w", err)
+	}
+
+	path := filepath.Join(j.dir, JournalFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollbackRestoresFiles(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	home := t.TempDir()
+
+	existing := filepath.Join(home, ".bashrc")
+	require.NoError(t, os.WriteFile(existing, []byte("export FOO=bar\n"), 0600))
+	created := filepath.Join(home, ".config", "app", "config.toml")
+
+	txn, err := Begin(basePath, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, txn.Record("bashrc", existing))
+	require.NoError(t, txn.Record("config", created, existing))
+	require.NoError(t, txn.RecordIrreversible("packages", "Install packages (install_packages)"))
+
+	// Recording the same path again keeps its first state
+	require.Len(t, txn.Entries, 4)
+	assert.Equal(t, KindFile, txn.Entries[0].Kind)
+	assert.Equal(t, "0600", txn.Entries[0].Mode)
+	assert.Equal(t, KindMissing, txn.Entries[1].Kind)
+	assert.Equal(t, filepath.Join(home, ".config"), txn.Entries[1].Path)
+
+	require.NoError(t, os.WriteFile(existing, []byte("changed\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Dir(created), 0755))
+	require.NoError(t, os.WriteFile(created, []byte("new\n"), 0644))
+
+	result := txn.Rollback()
+	require.Empty(t, result.Errors)
+	assert.Len(t, result.Restored, 4)
+	require.Len(t, result.Irreversible, 1)
+	assert.Equal(t, "packages", result.Irreversible[0].TaskID)
+
+	content, err := os.ReadFile(existing)
+	require.NoError(t, err)
+	assert.Equal(t, "export FOO=bar\n", string(content))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(existing)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	assert.NoDirExists(t, filepath.Join(home, ".config"))
+	assert.False(t, Exists(basePath), "journal is removed after a complete rollback")
+}
+
+func TestRollbackRestoresSymlinks(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	home := t.TempDir()
+	target := filepath.Join(home, "old-target")
+	link := filepath.Join(home, "link")
+	require.NoError(t, os.WriteFile(target, []byte("content"), 0644))
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	txn, err := Begin(t.TempDir(), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, txn.Record("link", link))
+
+	require.NoError(t, os.Remove(link))
+	require.NoError(t, os.WriteFile(link, []byte("replaced"), 0644))
+
+	result := txn.Rollback()
+	require.Empty(t, result.Errors)
+
+	linkTarget, err := os.Readlink(link)
+	require.NoError(t, err)
+	assert.Equal(t, target, linkTarget)
+}
+
+func TestLoadFinishesInterruptedRollback(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	path := filepath.Join(t.TempDir(), "profile")
+	require.NoError(t, os.WriteFile(path, []byte("original"), 0644))
+
+	_, err := Load(basePath)
+	assert.ErrorIs(t, err, ErrNoJournal)
+
+	txn, err := Begin(basePath, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, txn.Record("profile", path))
+	require.NoError(t, os.WriteFile(path, []byte("changed"), 0644))
+
+	_, err = Begin(basePath, time.Now())
+	assert.Error(t, err, "an unfinished journal must not be overwritten")
+
+	loaded, err := Load(basePath)
+	require.NoError(t, err)
+	require.Len(t, loaded.Entries, 1)
+
+	result := loaded.Rollback()
+	require.Empty(t, result.Errors)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+}
+
+func TestRollbackKeepsNonEmptyDirectories(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	dir := filepath.Join(t.TempDir(), "created")
+
+	txn, err := Begin(basePath, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, txn.Record("dir", dir))
+
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unrelated"), []byte("x"), 0644))
+
+	result := txn.Rollback()
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0].Error(), "not empty")
+	assert.DirExists(t, dir)
+	assert.True(t, Exists(basePath), "journal is kept so the rollback can be retried")
+}
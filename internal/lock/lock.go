@@ -0,0 +1,227 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// File is the name of the lock file in the state directory of a repository
+const File = "apply.lock"
+
+// PollInterval is how often AcquireWait checks whether the lock was released
+var PollInterval = 500 * time.Millisecond
+
+// staleAge is how old an unreadable lock file has to be before it is broken. A
+// lock file is briefly empty while its holder writes it.
+const staleAge = 10 * time.Second
+
+// Info describes the process holding the lock
+type Info struct {
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	Command   string    `json:"command"` // Command holding the lock, e.g. apply
+	StartedAt time.Time `json:"started_at"`
+}
+
+// String describes who holds the lock and since when
+func (i *Info) String() string {
+	if i.PID <= 0 {
+		return fmt.Sprintf("unknown holder, since %s", i.StartedAt.Format("2006-01-02 15:04:05"))
+	}
+	return fmt.Sprintf("dotfiles %s (PID %d on %s) since %s", i.Command, i.PID, i.Hostname, i.StartedAt.Format("2006-01-02 15:04:05"))
+}
+
+// HeldError is returned when another live process holds the lock
+type HeldError struct {
+	Holder *Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("another run is changing the dotfiles: %s", e.Holder)
+}
+
+// Lock is an advisory lock that keeps runs changing the dotfiles of a repository
+// from racing each other. It is a file created exclusively, which works the same on
+// every platform, holding the PID of its holder so locks of processes that died are
+// broken.
+type Lock struct {
+	// Broken is the lock of a process that no longer runs that was replaced, nil
+	// when the lock was free
+	Broken *Info
+
+	path string
+}
+
+// Path returns the path of the lock file of a dotfiles repository
+func Path(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), File)
+}
+
+// Acquire takes the lock of a dotfiles repository for command. It returns a
+// *HeldError when a live process holds it.
+func Acquire(basePath, command string) (*Lock, error) {
+	path := Path(basePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	info := &Info{PID: os.Getpid(), Hostname: hostname, Command: command, StartedAt: time.Now()}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lock: %w", err)
+	}
+
+	lock := &Lock{path: path}
+	// A stale lock is taken over, or creating the lock tried once more when another
+	// run changed it first
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.Write(data)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock %s: %w", path, err)
+			}
+			return lock, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock %s: %w", path, err)
+		}
+
+		holder, stale, held := readLock(path)
+		if held {
+			return nil, &HeldError{Holder: holder}
+		}
+		taken, err := takeOver(path, stale, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to break stale lock %s: %w", path, err)
+		}
+		if taken {
+			// An unreadable lock has no holder to report
+			if holder != nil && holder.PID > 0 {
+				lock.Broken = holder
+			}
+			return lock, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to create lock %s: another run keeps taking it", path)
+}
+
+// takeOver replaces the stale lock file at path, which held stale when it was
+// read, with data. Runs breaking the lock take turns through a second file created
+// exclusively, so a run that read the same stale lock earlier cannot remove the lock
+// another run just took. The lock file is replaced by a rename and never missing, so
+// runs creating it cannot slip in either. It reports false when another run changed
+// the lock first.
+func takeOver(path string, stale, data []byte) (bool, error) {
+	breakPath := path + ".break"
+	file, err := os.OpenFile(breakPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		// A run that died while breaking the lock leaves the file behind
+		if stat, err := os.Stat(breakPath); err == nil && time.Since(stat.ModTime()) >= staleAge {
+			os.Remove(breakPath)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	file.Close()
+	defer os.Remove(breakPath)
+
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil // Released, creating it is tried again
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, stale) {
+		return false, nil
+	}
+
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	// Confirm the lock holds this process now
+	current, err = os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(current, data), nil
+}
+
+// AcquireWait takes the lock like Acquire, waiting up to timeout for a live process
+// holding it to release it
+func AcquireWait(basePath, command string, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := Acquire(basePath, command)
+		var heldErr *HeldError
+		if !errors.As(err, &heldErr) || !time.Now().Before(deadline) {
+			return lock, err
+		}
+		time.Sleep(min(PollInterval, time.Until(deadline)))
+	}
+}
+
+// Release gives the lock up. Releasing a nil lock does nothing, so callers can
+// release a lock they only took for some runs.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release lock %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// Held returns who holds the lock of a dotfiles repository, or false when nobody
+// does or its holder no longer runs
+func Held(basePath string) (*Info, bool) {
+	info, _, held := readLock(Path(basePath))
+	return info, held
+}
+
+// readLock reads a lock file, returning its holder, its content and whether the
+// lock is still held. Locks of other hosts sharing the state directory cannot be
+// checked and count as held. A lock file that cannot be read has an unknown holder
+// since the file was last changed.
+func readLock(path string) (*Info, []byte, bool) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	var info Info
+	if err != nil || json.Unmarshal(data, &info) != nil || info.PID <= 0 {
+		// Being written right now, or left behind half written
+		unknown := &Info{StartedAt: stat.ModTime()}
+		return unknown, data, time.Since(stat.ModTime()) < staleAge
+	}
+
+	if hostname, _ := os.Hostname(); info.Hostname != hostname {
+		return &info, data, true
+	}
+	return &info, data, processRunning(info.PID)
+}
@@ -0,0 +1,202 @@
+package lock
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLock writes a lock file as another process would
+func writeLock(t *testing.T, basePath string, info Info) {
+	t.Helper()
+	data, err := json.Marshal(info)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(Path(basePath), data, 0644))
+}
+
+// stateDir creates the state directory of basePath
+func stateDir(t *testing.T, basePath string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(Path(basePath)), 0755))
+}
+
+// exitedPID returns the PID of a process that already exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func TestAcquireAndRelease(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+
+	lock, err := Acquire(basePath, "apply")
+	require.NoError(t, err)
+	assert.Nil(t, lock.Broken)
+
+	holder, held := Held(basePath)
+	require.True(t, held)
+	assert.Equal(t, os.Getpid(), holder.PID)
+	assert.Equal(t, "apply", holder.Command)
+
+	// The lock is held by a live process, this one
+	_, err = Acquire(basePath, "cleanup")
+	var heldErr *HeldError
+	require.ErrorAs(t, err, &heldErr)
+	assert.Equal(t, os.Getpid(), heldErr.Holder.PID)
+	assert.Contains(t, err.Error(), "dotfiles apply (PID")
+
+	require.NoError(t, lock.Release())
+	_, held = Held(basePath)
+	assert.False(t, held)
+
+	lock, err = Acquire(basePath, "cleanup")
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+
+	var nilLock *Lock
+	assert.NoError(t, nilLock.Release())
+}
+
+func TestAcquireBreaksStaleLock(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	hostname, _ := os.Hostname()
+
+	stateDir(t, basePath)
+	stale := Info{PID: exitedPID(t), Hostname: hostname, Command: "apply", StartedAt: time.Now().Add(-time.Hour)}
+	writeLock(t, basePath, stale)
+
+	_, held := Held(basePath)
+	assert.False(t, held)
+
+	lock, err := Acquire(basePath, "apply")
+	require.NoError(t, err)
+	require.NotNil(t, lock.Broken)
+	assert.Equal(t, stale.PID, lock.Broken.PID)
+
+	holder, held := Held(basePath)
+	require.True(t, held)
+	assert.Equal(t, os.Getpid(), holder.PID)
+	require.NoError(t, lock.Release())
+}
+
+func TestAcquireOtherHost(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+
+	lock, err := Acquire(basePath, "apply")
+	require.NoError(t, err)
+	defer lock.Release()
+
+	// Processes on other hosts cannot be checked, their locks are never broken
+	writeLock(t, basePath, Info{PID: exitedPID(t), Hostname: "other-host", Command: "apply", StartedAt: time.Now()})
+	_, err = Acquire(basePath, "apply")
+	var heldErr *HeldError
+	require.ErrorAs(t, err, &heldErr)
+	assert.Equal(t, "other-host", heldErr.Holder.Hostname)
+}
+
+func TestAcquireUnreadableLock(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+
+	stateDir(t, basePath)
+
+	// A fresh empty lock is being written by its holder
+	require.NoError(t, os.WriteFile(Path(basePath), nil, 0644))
+	_, err := Acquire(basePath, "apply")
+	var heldErr *HeldError
+	require.ErrorAs(t, err, &heldErr)
+	require.NotNil(t, heldErr.Holder)
+	assert.Contains(t, err.Error(), "another run is changing the dotfiles: unknown holder, since ")
+	assert.NotContains(t, err.Error(), "<nil>")
+	holder, held := Held(basePath)
+	require.True(t, held)
+	assert.Equal(t, 0, holder.PID)
+
+	// An old one was left behind
+	old := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(Path(basePath), old, old))
+	lock, err := Acquire(basePath, "apply")
+	require.NoError(t, err)
+	assert.Nil(t, lock.Broken)
+	require.NoError(t, lock.Release())
+}
+
+func TestAcquireWait(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	PollInterval = 10 * time.Millisecond
+	defer func() { PollInterval = 500 * time.Millisecond }()
+
+	held, err := Acquire(basePath, "apply")
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = AcquireWait(basePath, "apply", 50*time.Millisecond)
+	var heldErr *HeldError
+	require.ErrorAs(t, err, &heldErr)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		held.Release()
+	}()
+	lock, err := AcquireWait(basePath, "apply", 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
+
+func TestTakeOverStaleLock(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	hostname, _ := os.Hostname()
+	path := Path(basePath)
+
+	stateDir(t, basePath)
+	writeLock(t, basePath, Info{PID: exitedPID(t), Hostname: hostname, Command: "apply", StartedAt: time.Now().Add(-time.Hour)})
+	_, stale, held := readLock(path)
+	require.False(t, held)
+
+	// Another run broke the stale lock and took it after this one read it
+	writeLock(t, basePath, Info{PID: os.Getpid(), Hostname: hostname, Command: "cleanup", StartedAt: time.Now()})
+	live, err := os.ReadFile(path)
+	require.NoError(t, err)
+	taken, err := takeOver(path, stale, []byte(`{"pid": 1}`))
+	require.NoError(t, err)
+	assert.False(t, taken)
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(live), string(current), "the lock another run took was replaced")
+
+	// Another run is breaking the lock right now
+	require.NoError(t, os.WriteFile(path, stale, 0644))
+	require.NoError(t, os.WriteFile(path+".break", nil, 0644))
+	taken, err = takeOver(path, stale, []byte(`{"pid": 1}`))
+	require.NoError(t, err)
+	assert.False(t, taken)
+
+	// One that died while breaking it does not keep the lock stale forever
+	old := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(path+".break", old, old))
+	taken, err = takeOver(path, stale, []byte(`{"pid": 1}`))
+	require.NoError(t, err)
+	assert.False(t, taken)
+	taken, err = takeOver(path, stale, []byte(`{"pid": 1}`))
+	require.NoError(t, err)
+	assert.True(t, taken)
+	current, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"pid": 1}`, string(current))
+	assert.NoFileExists(t, path+".break")
+	assert.NoFileExists(t, path+".new")
+}
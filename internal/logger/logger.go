@@ -5,6 +5,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -26,11 +28,11 @@ func Init(verbose, quiet bool) {
 		level = zerolog.InfoLevel
 	}
 
-	// Configure output with colors for console
+	// Configure output with colors for console, unless colors are disabled
 	output = zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        stdout{},
 		TimeFormat: time.RFC3339,
-		NoColor:    false,
+		NoColor:    !ui.NewPalette(os.Stdout).Enabled(),
 	}
 
 	// Create the global logger
@@ -44,6 +46,21 @@ func Init(verbose, quiet bool) {
 	log.Logger = globalLogger
 }
 
+// Disable discards all log lines, e.g. while completing a command line, where
+// every line of output is taken for a completion
+func Disable() {
+	globalLogger = zerolog.Nop()
+	log.Logger = globalLogger
+}
+
+// stdout writes to whatever os.Stdout is when a line is logged, so output that
+// is captured while a task runs includes the task's log lines
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
 // Get returns the global logger instance
 func Get() *zerolog.Logger {
 	return &globalLogger
@@ -0,0 +1,198 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// goos is the operating system tasks with become are elevated on, replaced by tests
+var goos = runtime.GOOS
+
+// ErrRequiresElevation is the error of a task with become when dotfiles does not
+// run as Administrator on Windows, where a single task cannot be elevated
+var ErrRequiresElevation = errors.New("task requires elevation: run dotfiles from a terminal opened as Administrator, or pass --skip-elevated to skip such tasks")
+
+// BecomeHelperCommand is the hidden command of the dotfiles binary that runs a
+// single task with root privileges for a task with become
+const BecomeHelperCommand = "become-helper"
+
+// defaultBecomeCommand is the command tasks with become escalate with when
+// settings.sudo_command is empty
+const defaultBecomeCommand = "sudo"
+
+// BecomeRequest is a task handed to the privileged helper, with what it needs of
+// the execution context. The helper reads it as JSON from stdin.
+type BecomeRequest struct {
+	Task            *config.Task                  `json:"task"`
+	BasePath        string                        `json:"base_path"`
+	Variables       map[string]interface{}        `json:"variables"`
+	Verbose         bool                          `json:"verbose,omitempty"`
+	ShowDiff        bool                          `json:"show_diff,omitempty"`
+	DiffContext     int                           `json:"diff_context,omitempty"`
+	DiffColor       bool                          `json:"diff_color,omitempty"`
+	CreateBackups   bool                          `json:"create_backups,omitempty"`
+	BackupDir       string                        `json:"backup_dir,omitempty"`
+	TemplatesDir    string                        `json:"templates_dir,omitempty"`
+	Offline         bool                          `json:"offline,omitempty"`
+	SudoCommand     string                        `json:"sudo_command,omitempty"`
+	ForceWildcards  bool                          `json:"force_wildcards,omitempty"`
+	AssumeConflict  string                        `json:"assume_conflict,omitempty"`
+	PackageManagers config.PackageManagerSettings `json:"package_managers"`
+}
+
+// BecomeResponse is what the privileged helper reports back as JSON on stdout
+type BecomeResponse struct {
+	Success        bool                   `json:"success"`
+	Skipped        bool                   `json:"skipped,omitempty"`
+	NeedsAttention bool                   `json:"needs_attention,omitempty"`
+	Message        string                 `json:"message,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	Permanent      bool                   `json:"permanent,omitempty"` // Whether running the task again cannot fix the error
+	Output         *TaskOutput            `json:"output,omitempty"`
+	Variables      map[string]interface{} `json:"variables,omitempty"` // Variables the task set for the tasks after it
+}
+
+// runBecomeHelper runs the privileged helper and returns what it printed on
+// stdout. What the task prints itself is written where it would be when the task
+// runs in this process. Tests replace it.
+var runBecomeHelper = func(ctx context.Context, name string, args []string, stdin []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stdout
+	return cmd.Output()
+}
+
+// elevationUnavailable returns the error of a task with become that cannot be
+// elevated: skipped with --skip-elevated, failed otherwise
+func elevationUnavailable(task *config.Task, ctx *ExecutionContext) error {
+	if ctx.SkipElevated {
+		return &TaskOutcome{Skipped: true, Message: "requires elevation, skipped with --skip-elevated"}
+	}
+	return Permanent(ErrRequiresElevation)
+}
+
+// executeBecome runs a task with become through settings.sudo_command, by running
+// the privileged helper of this dotfiles binary for just that task. The commands
+// and file operations of the task then all run as root.
+func executeBecome(task *config.Task, ctx *ExecutionContext) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the dotfiles executable to run the task as root: %w", err)
+	}
+	request, err := json.Marshal(&BecomeRequest{
+		Task:            task,
+		BasePath:        ctx.BasePath,
+		Variables:       ctx.Variables,
+		Verbose:         ctx.Verbose,
+		ShowDiff:        ctx.ShowDiff,
+		DiffContext:     ctx.DiffContext,
+		DiffColor:       ctx.DiffColor,
+		CreateBackups:   ctx.CreateBackups,
+		BackupDir:       ctx.BackupDir,
+		TemplatesDir:    ctx.TemplatesDir,
+		Offline:         ctx.Offline,
+		SudoCommand:     ctx.SudoCommand,
+		ForceWildcards:  ctx.ForceWildcards,
+		AssumeConflict:  ctx.AssumeConflict,
+		PackageManagers: ctx.PackageManagers,
+	})
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to hand the task to the privileged helper: %w", err))
+	}
+
+	command := ctx.SudoCommand
+	if command == "" {
+		command = defaultBecomeCommand
+	}
+	if ctx.Verbose {
+		fmt.Printf("Running task as root with %s\n", command)
+	}
+
+	stdout, runErr := runBecomeHelper(ctx.RunContext(), command, []string{executable, BecomeHelperCommand}, request)
+	var response BecomeResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		if runErr != nil {
+			return fmt.Errorf("failed to run the task as root with %s: %w", command, runErr)
+		}
+		return fmt.Errorf("failed to read the result of the task run as root: %w", err)
+	}
+
+	if response.Output != nil {
+		ctx.Output.Record(response.Output.Stdout, response.Output.Stderr)
+	}
+	for name, value := range response.Variables {
+		ctx.SetVariable(name, value)
+	}
+	switch {
+	case response.Error != "":
+		if response.Permanent {
+			return Permanent(errors.New(response.Error))
+		}
+		return errors.New(response.Error)
+	case response.Skipped || response.NeedsAttention:
+		return &TaskOutcome{Skipped: response.Skipped, NeedsAttention: response.NeedsAttention, Message: response.Message}
+	}
+	return nil
+}
+
+// ServeBecome is the privileged helper: it reads a BecomeRequest from in, runs the
+// task once and writes the BecomeResponse to out. It runs as root, so the task is
+// not elevated again.
+func (r *ModuleRegistry) ServeBecome(in io.Reader, out io.Writer) error {
+	var request BecomeRequest
+	if err := json.NewDecoder(in).Decode(&request); err != nil {
+		return fmt.Errorf("failed to read the task to run: %w", err)
+	}
+	if request.Task == nil {
+		return errors.New("failed to read the task to run: no task given")
+	}
+
+	ctx := &ExecutionContext{
+		BasePath:        request.BasePath,
+		Variables:       request.Variables,
+		Verbose:         request.Verbose,
+		ShowDiff:        request.ShowDiff,
+		DiffContext:     request.DiffContext,
+		DiffColor:       request.DiffColor,
+		CreateBackups:   request.CreateBackups,
+		BackupDir:       request.BackupDir,
+		TemplatesDir:    request.TemplatesDir,
+		Offline:         request.Offline,
+		SudoCommand:     request.SudoCommand,
+		ForceWildcards:  request.ForceWildcards,
+		AssumeConflict:  request.AssumeConflict,
+		PackageManagers: request.PackageManagers,
+		Elevated:        true,
+		setVariables:    make(map[string]interface{}),
+	}
+
+	response := &BecomeResponse{}
+	module, err := r.GetModuleByAction(request.Task.Action)
+	if err != nil {
+		response.Error, response.Permanent = err.Error(), true
+	} else {
+		result, err := executeAttempt(module, request.Task, ctx)
+		response.Success = result.Success
+		response.Skipped = result.Skipped
+		response.NeedsAttention = result.NeedsAttention
+		response.Message = result.Message
+		response.Output = result.Output
+		if err != nil {
+			response.Error, response.Permanent = err.Error(), IsPermanent(err)
+		}
+	}
+	if len(ctx.setVariables) > 0 {
+		response.Variables = ctx.setVariables
+	}
+
+	return json.NewEncoder(out).Encode(response)
+}
@@ -0,0 +1,163 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// becomeModule records whether it runs elevated, prints output and sets a variable
+type becomeModule struct {
+	flakyModule
+	elevated []bool
+}
+
+func (m *becomeModule) ExecuteTask(task *config.Task, ctx *ExecutionContext) error {
+	m.elevated = append(m.elevated, ctx.Elevated)
+	ctx.Output.Record("installed\n", "")
+	ctx.SetVariable("result", task.Config["name"])
+	return m.flakyModule.ExecuteTask(task, ctx)
+}
+
+// setGOOS replaces the operating system become decides for until the test ends
+func setGOOS(t *testing.T, os string) {
+	previous := goos
+	goos = os
+	t.Cleanup(func() { goos = previous })
+}
+
+func TestPlanTaskBecomeOnWindows(t *testing.T) {
+	setGOOS(t, "windows")
+	registry := NewModuleRegistry()
+	if err := registry.Register(&plannedModule{plan: TaskPlan{Changes: []string{"Set HKLM key"}}}); err != nil {
+		t.Fatal(err)
+	}
+	task := &config.Task{ID: "registry", Action: "flaky", Become: true}
+
+	if _, err := registry.PlanTask(task, &ExecutionContext{}); !errors.Is(err, ErrRequiresElevation) {
+		t.Errorf("PlanTask() error = %v, want %v", err, ErrRequiresElevation)
+	}
+
+	plan, err := registry.PlanTask(task, &ExecutionContext{SkipElevated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip || plan.SkipCode != SkipNeedsElevation || !plan.Become {
+		t.Errorf("PlanTask() with SkipElevated = %+v, want a become task skipped with %q", plan, SkipNeedsElevation)
+	}
+
+	plan, err = registry.PlanTask(task, &ExecutionContext{Elevated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.WillSkip || !plan.Become {
+		t.Errorf("PlanTask() when elevated = %+v, want a become task that runs", plan)
+	}
+}
+
+func TestExecuteTaskBecomeOnWindows(t *testing.T) {
+	setGOOS(t, "windows")
+	module := &becomeModule{}
+	registry := NewModuleRegistry()
+	if err := registry.Register(module); err != nil {
+		t.Fatal(err)
+	}
+	task := &config.Task{ID: "registry", Action: "flaky", Become: true}
+
+	if _, err := registry.ExecuteTask(task, &ExecutionContext{}); !errors.Is(err, ErrRequiresElevation) {
+		t.Errorf("ExecuteTask() error = %v, want %v", err, ErrRequiresElevation)
+	}
+	result, err := registry.ExecuteTask(task, &ExecutionContext{SkipElevated: true})
+	if err != nil || !result.Skipped {
+		t.Errorf("ExecuteTask() with SkipElevated = %+v, %v, want the task skipped", result, err)
+	}
+	if module.runs != 0 {
+		t.Errorf("task ran %d times without elevation, want 0", module.runs)
+	}
+}
+
+func TestExecuteTaskBecome(t *testing.T) {
+	setGOOS(t, "linux")
+	module := &becomeModule{}
+	registry := NewModuleRegistry()
+	if err := registry.Register(module); err != nil {
+		t.Fatal(err)
+	}
+
+	// The helper runs in this process, the way the hidden command would as root
+	var command string
+	var args []string
+	previous := runBecomeHelper
+	runBecomeHelper = func(ctx context.Context, name string, arguments []string, stdin []byte) ([]byte, error) {
+		command, args = name, arguments
+		var out bytes.Buffer
+		err := registry.ServeBecome(bytes.NewReader(stdin), &out)
+		return out.Bytes(), err
+	}
+	t.Cleanup(func() { runBecomeHelper = previous })
+
+	variables := map[string]interface{}{}
+	ctx := &ExecutionContext{Variables: variables, SudoCommand: "doas"}
+	task := &config.Task{ID: "hosts", Action: "flaky", Config: map[string]interface{}{"name": "hosts"}, Become: true}
+	result, err := registry.ExecuteTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command != "doas" || len(args) != 2 || args[1] != BecomeHelperCommand {
+		t.Errorf("helper run as %s %v, want doas <dotfiles> %s", command, args, BecomeHelperCommand)
+	}
+	if !reflect.DeepEqual(module.elevated, []bool{true}) {
+		t.Errorf("task ran with Elevated %v, want once elevated", module.elevated)
+	}
+	if result.Output == nil || result.Output.Stdout != "installed\n" {
+		t.Errorf("ExecuteTask() output = %+v, want the output of the helper", result.Output)
+	}
+	if variables["result"] != "hosts" {
+		t.Errorf("variable set by the task = %v, want it reported back", variables["result"])
+	}
+
+	// A task without become, or in an elevated process, runs in this process
+	command = ""
+	for _, ctx := range []*ExecutionContext{{}, {Elevated: true}, {DryRun: true}} {
+		become := ctx.Elevated || ctx.DryRun
+		if _, err := registry.ExecuteTask(&config.Task{ID: "plain", Action: "flaky", Become: become}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if command != "" {
+		t.Errorf("helper ran for a task that needs no elevation")
+	}
+}
+
+func TestExecuteTaskBecomeError(t *testing.T) {
+	setGOOS(t, "linux")
+	registry := NewModuleRegistry()
+	if err := registry.Register(&becomeModule{flakyModule: flakyModule{failures: 5, err: Permanent(errors.New("permission denied"))}}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	previous := runBecomeHelper
+	runBecomeHelper = func(ctx context.Context, name string, arguments []string, stdin []byte) ([]byte, error) {
+		calls++
+		var out bytes.Buffer
+		err := registry.ServeBecome(bytes.NewReader(stdin), &out)
+		return out.Bytes(), err
+	}
+	t.Cleanup(func() { runBecomeHelper = previous })
+
+	retries := 3
+	task := &config.Task{ID: "hosts", Action: "flaky", Retries: &retries, Become: true}
+	_, err := registry.ExecuteTask(task, &ExecutionContext{})
+	if err == nil || err.Error() != "permission denied" || !IsPermanent(err) {
+		t.Errorf("ExecuteTask() error = %v, want the permanent error of the helper", err)
+	}
+	if calls != 1 {
+		t.Errorf("helper ran %d times, want 1 for a permanent error", calls)
+	}
+}
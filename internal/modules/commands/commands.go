@@ -1,34 +1,59 @@
 package commands
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"runtime"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 )
 
+// registerPattern matches the variable names the output of a command can be registered as
+var registerPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // CommandsModule handles running arbitrary commands with state checking
-type CommandsModule struct{}
+type CommandsModule struct {
+	templateEngine *templating.TemplatingEngine
+}
 
 // CommandConfig represents the configuration for a command
 type CommandConfig struct {
-	Name    string            `json:"name"`
-	When    string            `json:"when"`              // Command to check current state
-	Command string            `json:"command"`           // Command to execute if when check fails
-	Shell   string            `json:"shell,omitempty"`   // Shell to use (optional, auto-detected)
-	WorkDir string            `json:"workdir,omitempty"` // Working directory (optional)
-	Env     map[string]string `json:"env,omitempty"`     // Environment variables (optional)
+	Name     string            `json:"name"`
+	When     string            `json:"when"`                // Command to check current state
+	WhenMode string            `json:"when_mode,omitempty"` // "absent" runs the command when the check fails, "present" when it succeeds
+	Creates  string            `json:"creates,omitempty"`   // Path that exists once the command ran, skipping it
+	Register string            `json:"register,omitempty"`  // Variable the stdout of the command is stored in
+	Command  string            `json:"command"`             // Command to execute if when check fails
+	Shell    string            `json:"shell,omitempty"`     // Shell to use (optional, auto-detected)
+	WorkDir  string            `json:"workdir,omitempty"`   // Working directory (optional)
+	Env      map[string]string `json:"env,omitempty"`       // Environment variables (optional)
+}
+
+// runCheck is the outcome of checking whether a command has to run
+type runCheck struct {
+	Run      bool
+	Reason   string // Why the command is skipped
+	When     string // The when check that ran, empty when none ran
+	ExitCode int    // Exit code of the when check, -1 when it could not be started
+	Output   string // What the when check printed
 }
 
 // New creates a new commands module
 func New() *CommandsModule {
-	return &CommandsModule{}
+	return &CommandsModule{
+		templateEngine: templating.NewTemplatingEngine("."),
+	}
 }
 
 // Name returns the module name
@@ -45,7 +70,7 @@ func (m *CommandsModule) ActionKeys() []string {
 func (m *CommandsModule) ValidateTask(task *config.Task) error {
 	switch task.Action {
 	case "run_command":
-		return m.validateRunCommand(task.Config)
+		return m.validateRunCommand(task)
 	default:
 		return fmt.Errorf("unsupported action: %s", task.Action)
 	}
@@ -72,16 +97,33 @@ func (m *CommandsModule) PlanTask(task *config.Task, ctx *modules.ExecutionConte
 }
 
 // validateRunCommand validates run_command configuration
-func (m *CommandsModule) validateRunCommand(config map[string]interface{}) error {
-	if name, exists := config["name"]; !exists || name == "" {
+func (m *CommandsModule) validateRunCommand(task *config.Task) error {
+	cmdConfig, err := m.parseCommandConfig(task)
+	if err != nil {
+		return err
+	}
+
+	if cmdConfig.Name == "" {
 		return fmt.Errorf("name is required for run_command")
 	}
 
-	if command, exists := config["command"]; !exists || command == "" {
+	if cmdConfig.Command == "" {
 		return fmt.Errorf("command is required for run_command")
 	}
 
-	// when is optional - if not provided, command always runs
+	if _, exists := task.Config["when_mode"]; exists {
+		if cmdConfig.WhenMode != "absent" && cmdConfig.WhenMode != "present" {
+			return fmt.Errorf("when_mode must be 'absent' or 'present', got '%s'", cmdConfig.WhenMode)
+		}
+		if _, hasWhen := task.Config["when"]; !hasWhen {
+			return fmt.Errorf("when_mode requires a when check")
+		}
+	}
+
+	if _, exists := task.Config["register"]; exists && !registerPattern.MatchString(cmdConfig.Register) {
+		return fmt.Errorf("register must be a variable name of letters, digits and underscores, got '%s'", cmdConfig.Register)
+	}
+
 	// shell is optional - will be auto-detected
 	// workdir is optional
 	// env is optional
@@ -93,19 +135,19 @@ func (m *CommandsModule) validateRunCommand(config map[string]interface{}) error
 func (m *CommandsModule) executeRunCommand(task *config.Task, ctx *modules.ExecutionContext) error {
 	log := logger.Get()
 
-	cmdConfig, err := m.parseCommandConfig(task.Config)
+	cmdConfig, err := m.parseCommandConfig(task)
 	if err != nil {
 		return fmt.Errorf("invalid command configuration: %w", err)
 	}
 
-	// Check if we should run the command using 'when' condition
-	shouldRun, err := m.shouldRunCommand(cmdConfig)
+	// Check if we should run the command using 'creates' and the 'when' condition
+	check, err := m.checkCommand(ctx, cmdConfig)
 	if err != nil {
 		return fmt.Errorf("failed to check when condition: %w", err)
 	}
 
-	if !shouldRun {
-		log.Info().Str("command", cmdConfig.Name).Msg("Command skipped - when condition already satisfied")
+	if !check.Run {
+		log.Info().Str("command", cmdConfig.Name).Msgf("Command skipped - %s", check.Reason)
 		return nil
 	}
 
@@ -116,35 +158,42 @@ func (m *CommandsModule) executeRunCommand(task *config.Task, ctx *modules.Execu
 
 	// Execute the command
 	log.Info().Str("command", cmdConfig.Name).Msg("Executing command")
-	err = m.runCommand(cmdConfig)
+	stdout, err := m.runCommand(ctx, cmdConfig)
 	if err != nil {
 		return fmt.Errorf("command failed: %w", err)
 	}
 
+	// Later tasks can use the output in their templates
+	if cmdConfig.Register != "" {
+		ctx.SetVariable(cmdConfig.Register, strings.TrimRight(stdout, "\r\n"))
+	}
+
 	log.Info().Str("command", cmdConfig.Name).Msg("Command executed successfully")
 	return nil
 }
 
 // planRunCommand creates an execution plan for run_command
 func (m *CommandsModule) planRunCommand(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
-	cmdConfig, err := m.parseCommandConfig(task.Config)
+	cmdConfig, err := m.parseCommandConfig(task)
 	if err != nil {
 		return &modules.TaskPlan{
 			TaskID:     task.ID,
 			Action:     task.Action,
 			WillSkip:   true,
 			SkipReason: fmt.Sprintf("Invalid configuration: %v", err),
+			SkipCode:   modules.SkipError,
 		}, nil
 	}
 
 	// Check if we should run the command
-	shouldRun, err := m.shouldRunCommand(cmdConfig)
+	check, err := m.checkCommand(ctx, cmdConfig)
 	if err != nil {
 		return &modules.TaskPlan{
 			TaskID:     task.ID,
 			Action:     task.Action,
 			WillSkip:   true,
 			SkipReason: fmt.Sprintf("Failed to check when condition: %v", err),
+			SkipCode:   modules.SkipError,
 		}, nil
 	}
 
@@ -154,158 +203,178 @@ func (m *CommandsModule) planRunCommand(task *config.Task, ctx *modules.Executio
 		Description: cmdConfig.Name,
 	}
 
-	if shouldRun {
+	if check.Run {
 		plan.Changes = []string{fmt.Sprintf("Execute: %s", cmdConfig.Command)}
+		if cmdConfig.Register != "" {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Register stdout as variable '%s'", cmdConfig.Register))
+		}
 	} else {
 		plan.WillSkip = true
-		plan.SkipReason = "Command already in desired state (when condition satisfied)"
+		plan.SkipReason = check.Reason
+	}
+
+	if ctx.Verbose && check.When != "" {
+		summary := check.summary(cmdConfig)
+		if plan.WillSkip {
+			plan.SkipReason += " - " + summary
+		} else {
+			plan.Changes = append([]string{summary}, plan.Changes...)
+		}
 	}
 
 	return plan, nil
 }
 
 // parseCommandConfig parses the command configuration from task config
-func (m *CommandsModule) parseCommandConfig(config map[string]interface{}) (*CommandConfig, error) {
+func (m *CommandsModule) parseCommandConfig(task *config.Task) (*CommandConfig, error) {
 	cmdConfig := &CommandConfig{}
 
-	// Required fields
-	if name, exists := config["name"]; exists {
-		cmdConfig.Name = name.(string)
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"name", &cmdConfig.Name},
+		{"command", &cmdConfig.Command},
+		{"when", &cmdConfig.When},
+		{"when_mode", &cmdConfig.WhenMode},
+		{"creates", &cmdConfig.Creates},
+		{"register", &cmdConfig.Register},
+		{"shell", &cmdConfig.Shell},
+		{"workdir", &cmdConfig.WorkDir},
 	}
-
-	if command, exists := config["command"]; exists {
-		cmdConfig.Command = command.(string)
+	for _, field := range fields {
+		value, err := modules.GetString(task, field.name)
+		if err != nil {
+			return nil, err
+		}
+		*field.value = value
 	}
-
-	// Optional fields
-	if when, exists := config["when"]; exists {
-		cmdConfig.When = when.(string)
+	if cmdConfig.WhenMode == "" {
+		cmdConfig.WhenMode = "absent"
 	}
 
-	if shell, exists := config["shell"]; exists {
-		cmdConfig.Shell = shell.(string)
+	env, err := modules.GetStringMap(task, "env")
+	if err != nil {
+		return nil, err
 	}
+	cmdConfig.Env = env
 
-	if workdir, exists := config["workdir"]; exists {
-		cmdConfig.WorkDir = workdir.(string)
-	}
+	return cmdConfig, nil
+}
 
-	if env, exists := config["env"]; exists {
-		if envMap, ok := env.(map[string]interface{}); ok {
-			cmdConfig.Env = make(map[string]string)
-			for k, v := range envMap {
-				cmdConfig.Env[k] = v.(string)
-			}
+// checkCommand checks whether the command has to run: not when the path of 'creates'
+// exists, and otherwise depending on the exit code of the 'when' check
+func (m *CommandsModule) checkCommand(ctx *modules.ExecutionContext, cmdConfig *CommandConfig) (*runCheck, error) {
+	if cmdConfig.Creates != "" {
+		path, err := m.templateEngine.ProcessString(cmdConfig.Creates, ctx.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process creates template: %w", err)
+		}
+		if path, err = utils.ExpandPath(path); err != nil {
+			return nil, fmt.Errorf("failed to expand creates path: %w", err)
+		}
+		if _, err := os.Stat(path); err == nil {
+			return &runCheck{Reason: fmt.Sprintf("%s already exists", path)}, nil
 		}
 	}
 
-	return cmdConfig, nil
-}
-
-// shouldRunCommand checks if the command should be executed based on 'when' condition
-func (m *CommandsModule) shouldRunCommand(cmdConfig *CommandConfig) (bool, error) {
 	// If no 'when' condition is specified, always run the command
 	if cmdConfig.When == "" {
-		return true, nil
+		return &runCheck{Run: true}, nil
 	}
 
 	// Execute the 'when' command
+	runCtx := ctx.RunContext()
 	shell := m.getShell(cmdConfig.Shell)
-	cmd := m.createCommand(shell, cmdConfig.When, cmdConfig.WorkDir, cmdConfig.Env)
+	cmd := m.createCommand(runCtx, shell, cmdConfig.When, cmdConfig.WorkDir, cmdConfig.Env)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
 
+	start := time.Now()
 	err := cmd.Run()
-	if err != nil {
+	if ctxErr := contextError(runCtx, cmdConfig.When, start); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	check := &runCheck{When: cmdConfig.When, Output: strings.TrimSpace(output.String())}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		check.ExitCode = exitErr.ExitCode()
+	default:
+		check.ExitCode = -1
+		check.Output = err.Error()
+	}
+
+	if cmdConfig.WhenMode == "present" {
+		// The command acts on something that is there, such as a running service
+		check.Run = check.ExitCode == 0
+		check.Reason = "When condition not met (when_mode present)"
+	} else {
 		// 'when' command failed (non-zero exit), so we should run the main command
-		return true, nil
+		check.Run = check.ExitCode != 0
+		check.Reason = "Command already in desired state (when condition satisfied)"
 	}
+	return check, nil
+}
 
-	// 'when' command succeeded (exit code 0), so we should skip the main command
-	return false, nil
+// summary describes the when check and how it decided whether the command runs
+func (c *runCheck) summary(cmdConfig *CommandConfig) string {
+	summary := fmt.Sprintf("Check '%s' exited with %d (when_mode %s)", c.When, c.ExitCode, cmdConfig.WhenMode)
+	if c.Output != "" {
+		lines := strings.Split(c.Output, "\n")
+		summary += ": " + strings.TrimSpace(lines[0])
+		if len(lines) > 1 {
+			summary += fmt.Sprintf(" (+%d lines)", len(lines)-1)
+		}
+	}
+	return summary
 }
 
-// runCommand executes the main command
-func (m *CommandsModule) runCommand(cmdConfig *CommandConfig) error {
+// runCommand executes the main command. Its output is shown as it runs and recorded
+// for the report, and its stdout is returned.
+func (m *CommandsModule) runCommand(ctx *modules.ExecutionContext, cmdConfig *CommandConfig) (string, error) {
+	runCtx := ctx.RunContext()
 	shell := m.getShell(cmdConfig.Shell)
-	cmd := m.createCommand(shell, cmdConfig.Command, cmdConfig.WorkDir, cmdConfig.Env)
+	cmd := m.createCommand(runCtx, shell, cmdConfig.Command, cmdConfig.WorkDir, cmdConfig.Env)
 
 	// Set up output handling
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 
-	return cmd.Run()
+	start := time.Now()
+	err := cmd.Run()
+	ctx.Output.Record(stdout.String(), stderr.String())
+	if ctxErr := contextError(runCtx, cmdConfig.Command, start); ctxErr != nil {
+		return "", ctxErr
+	}
+	return stdout.String(), err
 }
 
-// getShell returns the appropriate shell command based on platform and preference
-func (m *CommandsModule) getShell(preferredShell string) []string {
-	if preferredShell != "" {
-		// Use specified shell
-		switch preferredShell {
-		case "bash":
-			return []string{"bash", "-c"}
-		case "zsh":
-			return []string{"zsh", "-c"}
-		case "sh":
-			return []string{"sh", "-c"}
-		case "powershell":
-			return []string{"powershell", "-Command"}
-		case "cmd":
-			return []string{"cmd", "/c"}
-		default:
-			// Fallback to auto-detection if unknown shell specified
-		}
+// contextError returns an error naming the command when it was stopped because
+// ctx timed out or was cancelled
+func contextError(ctx context.Context, command string, start time.Time) error {
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch err := ctx.Err(); err {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("command '%s' timed out after %s: %w", command, elapsed, err)
+	case context.Canceled:
+		return fmt.Errorf("command '%s' was cancelled after %s: %w", command, elapsed, err)
 	}
+	return nil
+}
 
-	// Auto-detect based on platform
-	switch runtime.GOOS {
-	case "windows":
-		// Check if PowerShell is available, fallback to cmd
-		if _, err := exec.LookPath("powershell"); err == nil {
-			return []string{"powershell", "-Command"}
-		}
-		return []string{"cmd", "/c"}
-	case "darwin", "linux":
-		// Check for preferred shells in order: bash, zsh, sh
-		shells := []string{"bash", "zsh", "sh"}
-		for _, shell := range shells {
-			if _, err := exec.LookPath(shell); err == nil {
-				return []string{shell, "-c"}
-			}
-		}
-		// Fallback to sh (should always exist on Unix systems)
-		return []string{"sh", "-c"}
-	default:
-		// Unknown platform, try bash
-		return []string{"bash", "-c"}
-	}
+// getShell returns the appropriate shell command based on platform and preference
+func (m *CommandsModule) getShell(preferredShell string) []string {
+	return modules.ShellArgs(preferredShell)
 }
 
 // createCommand creates an exec.Cmd with the specified parameters
-func (m *CommandsModule) createCommand(shell []string, command, workDir string, env map[string]string) *exec.Cmd {
-	// Create command with shell
-	args := append(shell, command)
-	cmd := exec.Command(args[0], args[1:]...)
-
-	// Set working directory if specified
-	if workDir != "" {
-		// Handle tilde expansion
-		if strings.HasPrefix(workDir, "~") {
-			homeDir, err := os.UserHomeDir()
-			if err == nil {
-				workDir = filepath.Join(homeDir, workDir[1:])
-			}
-		}
-		cmd.Dir = workDir
-	}
-
-	// Set environment variables
-	if len(env) > 0 {
-		cmd.Env = os.Environ()
-		for k, v := range env {
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
-		}
-	}
-
-	return cmd
+func (m *CommandsModule) createCommand(ctx context.Context, shell []string, command, workDir string, env map[string]string) *exec.Cmd {
+	return modules.ShellCommand(ctx, shell, command, workDir, env)
 }
 
 // ExplainAction returns documentation for a specific action
@@ -332,7 +401,26 @@ func (m *CommandsModule) ExplainAction(action string) (*modules.ActionDocumentat
 					Name:        "when",
 					Type:        "string",
 					Required:    false,
-					Description: "Command to check current state (execute main command only if this fails)",
+					Description: "Command to check current state (execute main command only if this fails, see when_mode)",
+				},
+				{
+					Name:        "when_mode",
+					Type:        "string",
+					Required:    false,
+					Default:     "absent",
+					Description: "'absent' runs the command when the when check fails, 'present' runs it when the check succeeds",
+				},
+				{
+					Name:        "creates",
+					Type:        "string",
+					Required:    false,
+					Description: "Path the command creates; the command is skipped when it exists. Supports template variables.",
+				},
+				{
+					Name:        "register",
+					Type:        "string",
+					Required:    false,
+					Description: "Variable name the stdout of the command is stored in, without trailing newlines, for templates of later tasks in the same run",
 				},
 				{
 					Name:        "shell",
@@ -371,6 +459,24 @@ func (m *CommandsModule) ExplainAction(action string) (*modules.ActionDocumentat
 						"shell":   "bash",
 					},
 				},
+				{
+					Description: "Restart a service only when it is running",
+					Config: map[string]interface{}{
+						"name":      "Restart syncthing",
+						"when":      "systemctl --user is-active syncthing",
+						"when_mode": "present",
+						"command":   "systemctl --user restart syncthing",
+					},
+				},
+				{
+					Description: "Generate an SSH key once and use the public key in later tasks",
+					Config: map[string]interface{}{
+						"name":     "Generate SSH key",
+						"creates":  "~/.ssh/id_ed25519",
+						"command":  "ssh-keygen -t ed25519 -N '' -f ~/.ssh/id_ed25519 && cat ~/.ssh/id_ed25519.pub",
+						"register": "ssh_public_key",
+					},
+				},
 				{
 					Description: "Run command with environment variables",
 					Config: map[string]interface{}{
@@ -1,7 +1,11 @@
 package commands
 
 import (
+	"context"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
@@ -48,6 +52,37 @@ func TestCommandsModule(t *testing.T) {
 		err = module.ValidateTask(task)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "command is required")
+
+		// Unknown when_mode
+		task.Config = map[string]interface{}{
+			"name":      "Test command",
+			"command":   "echo hello",
+			"when":      "true",
+			"when_mode": "sometimes",
+		}
+		err = module.ValidateTask(task)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "when_mode must be 'absent' or 'present'")
+
+		// when_mode without when
+		task.Config = map[string]interface{}{
+			"name":      "Test command",
+			"command":   "echo hello",
+			"when_mode": "present",
+		}
+		err = module.ValidateTask(task)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "when_mode requires a when check")
+
+		// register that is not a variable name
+		task.Config = map[string]interface{}{
+			"name":     "Test command",
+			"command":  "echo hello",
+			"register": "my-output",
+		}
+		err = module.ValidateTask(task)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "register must be a variable name")
 	})
 
 	t.Run("ExplainAction", func(t *testing.T) {
@@ -69,7 +104,7 @@ func TestCommandsModule(t *testing.T) {
 	})
 
 	t.Run("ParseCommandConfig", func(t *testing.T) {
-		config := map[string]interface{}{
+		taskConfig := map[string]interface{}{
 			"name":    "Test command",
 			"command": "echo hello",
 			"when":    "test -f /nonexistent",
@@ -80,7 +115,7 @@ func TestCommandsModule(t *testing.T) {
 			},
 		}
 
-		cmdConfig, err := module.parseCommandConfig(config)
+		cmdConfig, err := module.parseCommandConfig(&config.Task{Config: taskConfig})
 		assert.NoError(t, err)
 		assert.Equal(t, "Test command", cmdConfig.Name)
 		assert.Equal(t, "echo hello", cmdConfig.Command)
@@ -90,6 +125,26 @@ func TestCommandsModule(t *testing.T) {
 		assert.Equal(t, "test_value", cmdConfig.Env["TEST_VAR"])
 	})
 
+	t.Run("ParseCommandConfigYAMLTypes", func(t *testing.T) {
+		// name: 1.24 and PORT: 8080 are numbers in YAML
+		cmdConfig, err := module.parseCommandConfig(&config.Task{Config: map[string]interface{}{
+			"name":    1.24,
+			"command": "echo hello",
+			"env":     map[string]interface{}{"PORT": 8080, "DEBUG": true},
+		}})
+		assert.NoError(t, err)
+		assert.Equal(t, "1.24", cmdConfig.Name)
+		assert.Equal(t, map[string]string{"PORT": "8080", "DEBUG": "true"}, cmdConfig.Env)
+
+		_, err = module.parseCommandConfig(&config.Task{
+			ID:     "run_command: build",
+			Source: "jobs/index.yaml",
+			Line:   3,
+			Config: map[string]interface{}{"name": "build", "command": []interface{}{"make"}},
+		})
+		assert.EqualError(t, err, `task 'run_command: build' (jobs/index.yaml:3): 'command' must be a string, got a list, quote it in YAML (command: "...")`)
+	})
+
 	t.Run("DryRunExecution", func(t *testing.T) {
 		task := &config.Task{
 			Action: "run_command",
@@ -139,3 +194,153 @@ func TestGetShell(t *testing.T) {
 		assert.Len(t, shell, 2)
 	})
 }
+
+func TestRunCommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+
+	module := New()
+	runCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	task := &config.Task{
+		ID:     "run_command: Slow command",
+		Action: "run_command",
+		Config: map[string]interface{}{
+			"name":    "Slow command",
+			"command": "sleep 10",
+		},
+	}
+
+	start := time.Now()
+	err := module.ExecuteTask(task, &modules.ExecutionContext{Context: runCtx})
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "command 'sleep 10' timed out after")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestWhenMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses true and false")
+	}
+
+	module := New()
+	tests := []struct {
+		name     string
+		when     string
+		whenMode string
+		skip     bool
+	}{
+		{"AbsentCheckFails", "false", "", false},
+		{"AbsentCheckSucceeds", "true", "", true},
+		{"PresentCheckFails", "false", "present", true},
+		{"PresentCheckSucceeds", "true", "present", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := map[string]interface{}{
+				"name":    "Test command",
+				"command": "echo hello",
+				"when":    tt.when,
+			}
+			if tt.whenMode != "" {
+				cfg["when_mode"] = tt.whenMode
+			}
+			task := &config.Task{ID: "run_command: Test command", Action: "run_command", Config: cfg}
+
+			plan, err := module.PlanTask(task, &modules.ExecutionContext{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.skip, plan.WillSkip)
+		})
+	}
+
+	t.Run("VerboseShowsCheck", func(t *testing.T) {
+		task := &config.Task{
+			ID:     "run_command: Test command",
+			Action: "run_command",
+			Config: map[string]interface{}{
+				"name":    "Test command",
+				"command": "echo hello",
+				"when":    "echo missing; exit 3",
+			},
+		}
+
+		plan, err := module.PlanTask(task, &modules.ExecutionContext{Verbose: true})
+		assert.NoError(t, err)
+		assert.False(t, plan.WillSkip)
+		assert.Equal(t, "Check 'echo missing; exit 3' exited with 3 (when_mode absent): missing", plan.Changes[0])
+	})
+}
+
+func TestCreates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses touch")
+	}
+
+	module := New()
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "marker")
+	task := &config.Task{
+		ID:     "run_command: Create marker",
+		Action: "run_command",
+		Config: map[string]interface{}{
+			"name":    "Create marker",
+			"command": "touch " + marker,
+			"creates": "{{ dir }}/marker",
+		},
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{"dir": dir}}
+
+	plan, err := module.PlanTask(task, ctx)
+	assert.NoError(t, err)
+	assert.False(t, plan.WillSkip)
+
+	assert.NoError(t, module.ExecuteTask(task, ctx))
+	assert.FileExists(t, marker)
+
+	plan, err = module.PlanTask(task, ctx)
+	assert.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+	assert.Equal(t, marker+" already exists", plan.SkipReason)
+}
+
+func TestRegisterAndOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses echo redirection")
+	}
+
+	registry := modules.NewModuleRegistry()
+	assert.NoError(t, registry.Register(New()))
+
+	task := &config.Task{
+		ID:     "run_command: Print version",
+		Action: "run_command",
+		Config: map[string]interface{}{
+			"name":     "Print version",
+			"command":  "echo 1.2.3; echo warning >&2",
+			"register": "tool_version",
+		},
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	result, err := registry.ExecuteTask(task, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3", ctx.Variables["tool_version"])
+	if assert.NotNil(t, result.Output) {
+		assert.Equal(t, "1.2.3\n", result.Output.Stdout)
+		assert.Equal(t, "warning\n", result.Output.Stderr)
+	}
+
+	t.Run("NotRegisteredWhenSkipped", func(t *testing.T) {
+		task.Config["when"] = "true"
+		ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+		result, err := registry.ExecuteTask(task, ctx)
+		assert.NoError(t, err)
+		assert.NotContains(t, ctx.Variables, "tool_version")
+		assert.Nil(t, result.Output)
+	})
+}
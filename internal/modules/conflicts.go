@@ -0,0 +1,215 @@
+package modules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/ui"
+)
+
+// DesiredTarget is a file or symlink a task puts in place and what it puts there,
+// or a file a task edits in place
+type DesiredTarget struct {
+	Path    string
+	Kind    string // "file", "symlink" or "edit"
+	Content string // Content of a file, or what a symlink points to. Edits have none.
+	Unknown bool   // Whether the content is only known at apply time, e.g. command output
+
+	// Files copied byte for byte, which can be too large to hold in Content, are
+	// described by their size and hex SHA-256 hash instead
+	Size   int64
+	SHA256 string
+}
+
+// Digest returns the size and hex SHA-256 hash of the file a target puts in place
+func (t *DesiredTarget) Digest() (int64, string) {
+	if t.SHA256 != "" {
+		return t.Size, t.SHA256
+	}
+	sum := sha256.Sum256([]byte(t.Content))
+	return int64(len(t.Content)), hex.EncodeToString(sum[:])
+}
+
+// sameContent reports whether two targets put the same thing in place
+func (t *DesiredTarget) sameContent(other *DesiredTarget) bool {
+	if t.Kind != other.Kind || t.Unknown || other.Unknown {
+		return false
+	}
+	if t.SHA256 == "" && other.SHA256 == "" {
+		return t.Content == other.Content
+	}
+	size, hash := t.Digest()
+	otherSize, otherHash := other.Digest()
+	return size == otherSize && hash == otherHash
+}
+
+// DesiredTargetLister is implemented by modules whose tasks put files or symlinks in
+// place or edit files, so tasks managing the same path can be found before apply
+// runs them
+type DesiredTargetLister interface {
+	// DesiredTargets returns every file and symlink a task puts in place and every
+	// file it edits
+	DesiredTargets(task *config.Task, ctx *ExecutionContext) ([]*DesiredTarget, error)
+}
+
+// DesiredTargets returns the files and symlinks a task puts in place and the files
+// it edits. It returns nil when the module handling the task does neither.
+func (r *ModuleRegistry) DesiredTargets(task *config.Task, ctx *ExecutionContext) ([]*DesiredTarget, error) {
+	module, err := r.GetModuleByAction(task.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := module.(DesiredTargetLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.DesiredTargets(task, taskContext(task, ctx))
+}
+
+// TargetConflict is a path put in place by two tasks
+type TargetConflict struct {
+	Path       string
+	First      *config.Task
+	Second     *config.Task
+	FirstKind  string
+	SecondKind string
+	Identical  bool // Whether both tasks put the same thing in place
+}
+
+// TargetConflictError is returned when tasks put different things at the same path,
+// so the last task to run would win and apply would change the path on every run
+type TargetConflictError struct {
+	Conflicts []*TargetConflict
+}
+
+// Error implements the error interface
+func (e *TargetConflictError) Error() string {
+	if len(e.Conflicts) == 1 {
+		c := e.Conflicts[0]
+		return fmt.Sprintf("target conflict: %s is managed by '%s' (%s) and '%s' (%s)",
+			c.Path, c.First.ID, e.getLocation(c.First), c.Second.ID, e.getLocation(c.Second))
+	}
+	return fmt.Sprintf("target conflict: %d paths are managed by more than one task", len(e.Conflicts))
+}
+
+// PrettyPrint returns a formatted, user-friendly error message
+func (e *TargetConflictError) PrettyPrint() string {
+	var msg strings.Builder
+
+	msg.WriteString("\n")
+	msg.WriteString(ui.Text("🔥 TARGET CONFLICT DETECTED\n"))
+	msg.WriteString(strings.Repeat("=", 50) + "\n\n")
+
+	for _, c := range e.Conflicts {
+		msg.WriteString(fmt.Sprintf("Path: %s\n\n", c.Path))
+		msg.WriteString("Managed by:\n\n")
+		msg.WriteString(ui.Sprintf("📁 Task: %s (%s)\n", c.First.ID, c.FirstKind))
+		msg.WriteString(fmt.Sprintf("   Source: %s\n\n", e.getLocation(c.First)))
+		msg.WriteString(ui.Sprintf("📁 Task: %s (%s)\n", c.Second.ID, c.SecondKind))
+		msg.WriteString(fmt.Sprintf("   Source: %s\n\n", e.getLocation(c.Second)))
+	}
+
+	msg.WriteString(ui.Text("💡 To fix this conflict:\n"))
+	msg.WriteString("   1. Remove one of the tasks, OR\n")
+	msg.WriteString("   2. Give the tasks conditions that never match on the same machine, OR\n")
+	msg.WriteString("   3. Move the differences into variables so a single task manages the path\n\n")
+
+	msg.WriteString("Note: Tasks may only manage the same path when they put the same content in place or only edit it\n")
+
+	return msg.String()
+}
+
+// getLocation returns where a task is defined
+func (e *TargetConflictError) getLocation(task *config.Task) string {
+	if location := task.Location(); location != "" {
+		return location
+	}
+	return "(unknown source)"
+}
+
+// IsTargetConflictError checks if an error is a target conflict error
+func IsTargetConflictError(err error) (*TargetConflictError, bool) {
+	var conflictErr *TargetConflictError
+	if errors.As(err, &conflictErr) {
+		return conflictErr, true
+	}
+	return nil, false
+}
+
+// CheckTargetConflicts finds paths put in place by more than one task, and files
+// edited in place by one task and put in place whole by another, which would undo
+// the edits on every apply. Tasks putting the same content in place are returned
+// as warnings, other conflicts as a *TargetConflictError. Several tasks may edit
+// the same file. Tasks whose targets cannot be determined are left to fail when
+// they are planned.
+func (r *ModuleRegistry) CheckTargetConflicts(tasks []*config.Task, ctx *ExecutionContext) ([]*TargetConflict, error) {
+	type owner struct {
+		task   *config.Task
+		target *DesiredTarget
+	}
+	owners := make(map[string]*owner)
+	editors := make(map[string][]*owner)
+
+	var warnings, conflicts []*TargetConflict
+	for _, task := range tasks {
+		targets, err := r.DesiredTargets(task, ctx)
+		if err != nil {
+			continue
+		}
+		for _, target := range targets {
+			key := targetKey(target.Path)
+			var others []*owner
+			switch first, exists := owners[key]; {
+			case exists:
+				others = []*owner{first}
+			case target.Kind == "edit":
+				// Edits are checked once the task putting the file in place is found
+				editors[key] = append(editors[key], &owner{task: task, target: target})
+			default:
+				owners[key] = &owner{task: task, target: target}
+				others = editors[key]
+			}
+
+			for _, other := range others {
+				if other.task == task {
+					continue
+				}
+				conflict := &TargetConflict{
+					Path:       target.Path,
+					First:      other.task,
+					Second:     task,
+					FirstKind:  other.target.Kind,
+					SecondKind: target.Kind,
+					Identical:  other.target.sameContent(target),
+				}
+				if conflict.Identical {
+					warnings = append(warnings, conflict)
+				} else {
+					conflicts = append(conflicts, conflict)
+				}
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return warnings, &TargetConflictError{Conflicts: conflicts}
+	}
+	return warnings, nil
+}
+
+// targetKey returns the key paths are compared by, ignoring case where the file
+// system usually does
+func targetKey(path string) string {
+	path = filepath.Clean(path)
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.ToLower(path)
+	}
+	return path
+}
@@ -0,0 +1,175 @@
+package modules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// targetModule puts the path and content of its tasks' config in place, or edits
+// the path
+type targetModule struct{}
+
+func (m *targetModule) Name() string                         { return "targets" }
+func (m *targetModule) ActionKeys() []string                 { return []string{"ensure_file", "symlink", "edit"} }
+func (m *targetModule) ValidateTask(task *config.Task) error { return nil }
+func (m *targetModule) ExecuteTask(task *config.Task, ctx *ExecutionContext) error {
+	return nil
+}
+func (m *targetModule) PlanTask(task *config.Task, ctx *ExecutionContext) (*TaskPlan, error) {
+	return &TaskPlan{}, nil
+}
+func (m *targetModule) ExplainAction(action string) (*ActionDocumentation, error) {
+	return nil, fmt.Errorf("not documented")
+}
+func (m *targetModule) ListActions() []*ActionDocumentation { return nil }
+
+func (m *targetModule) DesiredTargets(task *config.Task, ctx *ExecutionContext) ([]*DesiredTarget, error) {
+	kind := "file"
+	switch task.Action {
+	case "symlink":
+		kind = "symlink"
+	case "edit":
+		kind = "edit"
+	}
+	content, _ := task.Config["content"].(string)
+	_, unknown := task.Config["content_command"]
+	target := &DesiredTarget{Path: task.Config["path"].(string), Kind: kind, Content: content, Unknown: unknown}
+	// Copies are described by their size and hash, like copy_file does
+	if source, ok := task.Config["source"].(string); ok {
+		sum := sha256.Sum256([]byte(source))
+		target.Size, target.SHA256 = int64(len(source)), hex.EncodeToString(sum[:])
+	}
+	return []*DesiredTarget{target}, nil
+}
+
+func TestCheckTargetConflicts(t *testing.T) {
+	registry := NewModuleRegistry()
+	if err := registry.Register(&targetModule{}); err != nil {
+		t.Fatal(err)
+	}
+	task := func(id, action, source string, cfg map[string]interface{}) *config.Task {
+		return &config.Task{ID: id, Action: action, Source: source, Line: 3, Config: cfg}
+	}
+	ctx := &ExecutionContext{}
+
+	tests := []struct {
+		name         string
+		tasks        []*config.Task
+		wantWarnings int
+		wantErr      bool
+	}{
+		{
+			name: "different paths",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "a"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.zshrc", "content": "b"}),
+			},
+		},
+		{
+			name: "same content",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "same"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/./.gitconfig", "content": "same"}),
+			},
+			wantWarnings: 1,
+		},
+		{
+			name: "different content",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "a"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "b"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "symlink and file",
+			tasks: []*config.Task{
+				task("a", "symlink", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "same"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "same"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "same copy",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/font.ttf", "source": "glyphs"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/font.ttf", "source": "glyphs"}),
+			},
+			wantWarnings: 1,
+		},
+		{
+			name: "copy of the same content",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "source": "same"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "same"}),
+			},
+			wantWarnings: 1,
+		},
+		{
+			name: "different copies",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "source": "a"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "source": "b"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "edits",
+			tasks: []*config.Task{
+				task("a", "edit", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.bashrc"}),
+				task("b", "edit", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.bashrc"}),
+			},
+		},
+		{
+			name: "file and edit",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "a"}),
+				task("b", "edit", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.gitconfig"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "edit and file",
+			tasks: []*config.Task{
+				task("a", "edit", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content": "a"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "content known at apply time",
+			tasks: []*config.Task{
+				task("a", "ensure_file", "jobs/a.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content_command": "x"}),
+				task("b", "ensure_file", "jobs/b.yaml", map[string]interface{}{"path": "/home/me/.gitconfig", "content_command": "x"}),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := registry.CheckTargetConflicts(tt.tasks, ctx)
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("got %d warnings, want %d", len(warnings), tt.wantWarnings)
+			}
+			conflictErr, isConflict := IsTargetConflictError(err)
+			if isConflict != tt.wantErr {
+				t.Fatalf("CheckTargetConflicts() error = %v, want conflict %t", err, tt.wantErr)
+			}
+			if !isConflict {
+				return
+			}
+			pretty := conflictErr.PrettyPrint()
+			for _, want := range []string{"/home/me/.gitconfig", "jobs/a.yaml:3", "jobs/b.yaml:3", "💡 To fix this conflict"} {
+				if !strings.Contains(pretty, want) {
+					t.Errorf("PrettyPrint() does not contain %q:\n%s", want, pretty)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,450 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// defaultProfiles are the shell profiles user variables are written to on Linux and macOS
+var defaultProfiles = []string{"~/.profile", "~/.zshenv"}
+
+// envNamePattern matches valid environment variable names
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnvModule manages environment variables in shell profiles and the Windows registry
+type EnvModule struct {
+	templateEngine *templating.TemplatingEngine
+	goos           string
+}
+
+// envVar holds the rendered configuration of an ensure_env task
+type envVar struct {
+	Name       string
+	Value      string
+	Scope      string // "user" or "session"
+	PathAppend bool
+	Absent     bool
+	Profiles   []string // Expanded shell profile paths, only used for user scope outside Windows
+}
+
+// New creates a new env module
+func New() *EnvModule {
+	return &EnvModule{
+		templateEngine: templating.NewTemplatingEngine("."),
+		goos:           runtime.GOOS,
+	}
+}
+
+// Name returns the module name
+func (m *EnvModule) Name() string {
+	return "env"
+}
+
+// ActionKeys returns the action keys this module handles
+func (m *EnvModule) ActionKeys() []string {
+	return []string{"ensure_env"}
+}
+
+// ValidateTask validates an ensure_env task configuration
+func (m *EnvModule) ValidateTask(task *config.Task) error {
+	if task.Action != "ensure_env" {
+		return fmt.Errorf("env module only handles 'ensure_env' action, got '%s'", task.Action)
+	}
+
+	for _, field := range []string{"name", "value", "scope", "state"} {
+		if value, exists := task.Config[field]; exists {
+			if _, ok := modules.StringValue(value); !ok {
+				return fmt.Errorf("ensure_env '%s' must be a string", field)
+			}
+		}
+	}
+
+	name, exists := modules.StringValue(task.Config["name"])
+	if !exists {
+		return fmt.Errorf("ensure_env task requires 'name' field")
+	}
+	if !envNamePattern.MatchString(name) {
+		return fmt.Errorf("ensure_env 'name' must be a valid environment variable name, got '%s'", name)
+	}
+
+	state := "present"
+	if stateStr, exists := task.Config["state"]; exists {
+		state, _ = modules.StringValue(stateStr)
+	}
+	if state != "present" && state != "absent" {
+		return fmt.Errorf("ensure_env 'state' must be 'present' or 'absent', got '%s'", state)
+	}
+
+	value, hasValue := task.Config["value"]
+	if !hasValue && state == "present" {
+		return fmt.Errorf("ensure_env with state 'present' requires 'value' field")
+	}
+	if valueStr, _ := modules.StringValue(value); hasValue && strings.ContainsAny(valueStr, "\r\n") {
+		return fmt.Errorf("ensure_env 'value' cannot contain newlines")
+	}
+
+	if scope, exists := modules.StringValue(task.Config["scope"]); exists {
+		if scope != "user" && scope != "session" {
+			return fmt.Errorf("ensure_env 'scope' must be 'user' or 'session', got '%s'", scope)
+		}
+	}
+
+	if pathAppend, exists := task.Config["path_append"]; exists {
+		enabled, ok := modules.BoolValue(pathAppend)
+		if !ok {
+			return fmt.Errorf("ensure_env 'path_append' must be a boolean")
+		}
+		if enabled && !hasValue {
+			return fmt.Errorf("ensure_env with 'path_append' requires 'value' field")
+		}
+	}
+
+	if profiles, exists := task.Config["profiles"]; exists {
+		list, ok := modules.StringSliceValue(profiles)
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("ensure_env 'profiles' must be a non-empty list of paths")
+		}
+	}
+
+	return nil
+}
+
+// parseEnvVar renders the configuration of an ensure_env task
+func (m *EnvModule) parseEnvVar(task *config.Task, ctx *modules.ExecutionContext) (*envVar, error) {
+	name, err := modules.GetString(task, "name")
+	if err != nil {
+		return nil, err
+	}
+	v := &envVar{
+		Name:  name,
+		Scope: "user",
+	}
+	if scope, ok := modules.StringValue(task.Config["scope"]); ok {
+		v.Scope = scope
+	}
+	if state, ok := modules.StringValue(task.Config["state"]); ok {
+		v.Absent = state == "absent"
+	}
+	v.PathAppend, _ = modules.BoolValue(task.Config["path_append"])
+
+	if value, ok := modules.StringValue(task.Config["value"]); ok {
+		rendered, err := m.templateEngine.ProcessString(value, ctx.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process value template for %s: %w", v.Name, err)
+		}
+		v.Value = rendered
+	}
+
+	profiles := defaultProfiles
+	if _, exists := task.Config["profiles"]; exists {
+		if profiles, err = modules.GetStringSlice(task, "profiles"); err != nil {
+			return nil, err
+		}
+	}
+	for _, profile := range profiles {
+		rendered, err := m.templateEngine.ProcessString(profile, ctx.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process profile template: %w", err)
+		}
+		path, err := utils.ExpandPath(rendered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand profile path: %w", err)
+		}
+		v.Profiles = append(v.Profiles, path)
+	}
+
+	return v, nil
+}
+
+// desiredValue returns the value a variable should have given its current value.
+// PATH-like variables keep their other entries.
+func (v *envVar) desiredValue(current, separator string) string {
+	if !v.PathAppend {
+		if v.Absent {
+			return ""
+		}
+		return v.Value
+	}
+
+	var entries []string
+	found := false
+	for _, entry := range strings.Split(current, separator) {
+		if entry == "" {
+			continue
+		}
+		if entry == v.Value {
+			if v.Absent || found {
+				continue
+			}
+			found = true
+		}
+		entries = append(entries, entry)
+	}
+	if !v.Absent && !found {
+		entries = append(entries, v.Value)
+	}
+	return strings.Join(entries, separator)
+}
+
+// describe returns what the task does to the variable
+func (v *envVar) describe() string {
+	switch {
+	case v.PathAppend && v.Absent:
+		return fmt.Sprintf("Remove %s from %s", v.Value, v.Name)
+	case v.PathAppend:
+		return fmt.Sprintf("Append %s to %s", v.Value, v.Name)
+	case v.Absent:
+		return fmt.Sprintf("Unset %s", v.Name)
+	default:
+		return fmt.Sprintf("Set %s=%s", v.Name, v.Value)
+	}
+}
+
+// ExecuteTask executes an ensure_env task
+func (m *EnvModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionContext) error {
+	if ctx.DryRun {
+		return nil // Plan already showed what would happen
+	}
+
+	v, err := m.parseEnvVar(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v.Scope == "session":
+		return executeSessionEnv(v)
+	case m.goos == "windows":
+		return executeRegistryEnv(v, ctx)
+	default:
+		return executeProfileEnv(v, ctx)
+	}
+}
+
+// PlanTask returns what the ensure_env task would do
+func (m *EnvModule) PlanTask(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	v, err := m.parseEnvVar(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: v.describe(),
+		Changes:     []string{},
+	}
+
+	switch {
+	case v.Scope == "session":
+		err = planSessionEnv(v, plan)
+	case m.goos == "windows":
+		err = planRegistryEnv(v, plan)
+	default:
+		err = planProfileEnv(v, plan)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// TaskTargets returns the shell profiles an ensure_env task edits. Variables in
+// the Windows registry cannot be rolled back by restoring files.
+func (m *EnvModule) TaskTargets(task *config.Task, ctx *modules.ExecutionContext) ([]string, error) {
+	v, err := m.parseEnvVar(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case v.Scope == "session":
+		return nil, nil // Only changes the environment of this process
+	case m.goos == "windows":
+		return nil, modules.ErrIrreversible
+	default:
+		return v.Profiles, nil
+	}
+}
+
+// valueChange formats a change from the current to the desired value
+func valueChange(name, current string, isSet bool, desired string, unset bool) string {
+	from := "(unset)"
+	if isSet {
+		from = fmt.Sprintf("'%s'", current)
+	}
+	to := fmt.Sprintf("'%s'", desired)
+	if unset {
+		to = "(unset)"
+	}
+	return fmt.Sprintf("%s: %s -> %s", name, from, to)
+}
+
+// planSessionEnv plans a variable that is only set for the rest of this run
+func planSessionEnv(v *envVar, plan *modules.TaskPlan) error {
+	current, isSet := os.LookupEnv(v.Name)
+	desired := v.desiredValue(current, string(os.PathListSeparator))
+	unset := v.Absent && !v.PathAppend
+
+	if (unset && !isSet) || (!unset && isSet && current == desired) {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("%s is already up to date in this session", v.Name)
+		return nil
+	}
+	plan.Changes = append(plan.Changes, valueChange(v.Name, current, isSet, desired, unset)+" (this session only)")
+	return nil
+}
+
+// executeSessionEnv sets a variable for the rest of this run, so later tasks see it
+func executeSessionEnv(v *envVar) error {
+	if v.Absent && !v.PathAppend {
+		return os.Unsetenv(v.Name)
+	}
+	return os.Setenv(v.Name, v.desiredValue(os.Getenv(v.Name), string(os.PathListSeparator)))
+}
+
+// planRegistryEnv plans a user variable stored in HKCU\Environment
+func planRegistryEnv(v *envVar, plan *modules.TaskPlan) error {
+	current, isSet, err := readUserEnv(v.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from the user environment: %w", v.Name, err)
+	}
+	desired := v.desiredValue(current, ";")
+	unset := v.Absent && !v.PathAppend
+
+	if (unset && !isSet) || (!unset && isSet && current == desired) {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("%s is already up to date in the user environment", v.Name)
+		return nil
+	}
+	plan.Changes = append(plan.Changes, valueChange(v.Name, current, isSet, desired, unset))
+	plan.Changes = append(plan.Changes, `Update HKCU\Environment and notify running applications`)
+	return nil
+}
+
+// executeRegistryEnv writes a user variable to HKCU\Environment and tells running
+// applications the environment changed
+func executeRegistryEnv(v *envVar, ctx *modules.ExecutionContext) error {
+	current, isSet, err := readUserEnv(v.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from the user environment: %w", v.Name, err)
+	}
+	desired := v.desiredValue(current, ";")
+
+	switch {
+	case v.Absent && !v.PathAppend:
+		if !isSet {
+			return nil
+		}
+		err = deleteUserEnv(v.Name)
+	case isSet && current == desired:
+		if ctx.Verbose {
+			fmt.Printf("%s is already up to date\n", v.Name)
+		}
+		return nil
+	default:
+		err = writeUserEnv(v.Name, desired)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s in the user environment: %w", v.Name, err)
+	}
+
+	if err := broadcastEnvChange(); err != nil {
+		return fmt.Errorf("updated %s but failed to notify running applications: %w", v.Name, err)
+	}
+	return nil
+}
+
+// ExplainAction returns documentation for a specific action
+func (m *EnvModule) ExplainAction(action string) (*modules.ActionDocumentation, error) {
+	for _, doc := range m.ListActions() {
+		if doc.Action == action {
+			return doc, nil
+		}
+	}
+	return nil, fmt.Errorf("action '%s' not supported by env module", action)
+}
+
+// ListActions returns documentation for all actions supported by this module
+func (m *EnvModule) ListActions() []*modules.ActionDocumentation {
+	return []*modules.ActionDocumentation{
+		{
+			Action:      "ensure_env",
+			Description: "Sets or removes a user environment variable. On Linux and macOS it is exported from a managed block in your shell profiles, on Windows it is written to HKCU\\Environment.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "name",
+					Type:        "string",
+					Required:    true,
+					Description: "Name of the environment variable",
+				},
+				{
+					Name:        "value",
+					Type:        "string",
+					Required:    false,
+					Description: "Value of the variable, required unless state is absent. Supports template variables. In shell profiles $VARS are expanded by the shell.",
+				},
+				{
+					Name:        "scope",
+					Type:        "string",
+					Required:    false,
+					Default:     "user",
+					Description: "'user' persists the variable for new shells and applications, 'session' only sets it for the remaining tasks of this run",
+				},
+				{
+					Name:        "path_append",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Treat the variable as a PATH-like list and append value to it once instead of replacing it",
+				},
+				{
+					Name:        "state",
+					Type:        "string",
+					Required:    false,
+					Default:     "present",
+					Description: "'present' to set the variable, 'absent' to remove it (or remove value from the list with path_append)",
+				},
+				{
+					Name:        "profiles",
+					Type:        "array",
+					Required:    false,
+					Default:     "[~/.profile, ~/.zshenv]",
+					Description: "Shell profiles the variable is exported from on Linux and macOS",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Set the default editor",
+					Config: map[string]interface{}{
+						"name":  "EDITOR",
+						"value": "nvim",
+					},
+				},
+				{
+					Description: "Add Go binaries to PATH",
+					Config: map[string]interface{}{
+						"name":        "PATH",
+						"value":       "{{ Platform.HomeDir }}/go/bin",
+						"path_append": true,
+					},
+				},
+				{
+					Description: "Remove a variable that is no longer needed",
+					Config: map[string]interface{}{
+						"name":  "JAVA_HOME",
+						"state": "absent",
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,184 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// newProfileTask creates an ensure_env task writing to the given profiles
+func newProfileTask(cfg map[string]interface{}, profiles ...string) *config.Task {
+	list := make([]interface{}, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+	cfg["profiles"] = list
+	return &config.Task{ID: "ensure_env: test", Action: "ensure_env", Config: cfg}
+}
+
+// newLinuxModule creates an env module that writes shell profiles on any platform
+func newLinuxModule() *EnvModule {
+	m := New()
+	m.goos = "linux"
+	return m
+}
+
+func TestValidateEnsureEnv(t *testing.T) {
+	m := New()
+
+	valid := []map[string]interface{}{
+		{"name": "EDITOR", "value": "nvim"},
+		{"name": "PATH", "value": "/opt/bin", "path_append": true},
+		{"name": "JAVA_HOME", "state": "absent"},
+		{"name": "GOFLAGS", "value": "-mod=mod", "scope": "session"},
+	}
+	for _, cfg := range valid {
+		assert.NoError(t, m.ValidateTask(&config.Task{Action: "ensure_env", Config: cfg}), "%v", cfg)
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"missing name":      {"value": "nvim"},
+		"invalid name":      {"name": "MY-VAR", "value": "x"},
+		"missing value":     {"name": "EDITOR"},
+		"newline in value":  {"name": "EDITOR", "value": "a\nb"},
+		"unknown scope":     {"name": "EDITOR", "value": "nvim", "scope": "system"},
+		"unknown state":     {"name": "EDITOR", "value": "nvim", "state": "gone"},
+		"path_append type":  {"name": "PATH", "value": "/opt/bin", "path_append": "yes"},
+		"empty profiles":    {"name": "EDITOR", "value": "nvim", "profiles": []interface{}{}},
+		"path_append value": {"name": "PATH", "state": "absent", "path_append": true},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, m.ValidateTask(&config.Task{Action: "ensure_env", Config: cfg}), name)
+	}
+}
+
+func TestDesiredValue(t *testing.T) {
+	appendBin := &envVar{Name: "PATH", Value: "/opt/bin", PathAppend: true}
+	assert.Equal(t, "/usr/bin:/opt/bin", appendBin.desiredValue("/usr/bin", ":"))
+	assert.Equal(t, "/opt/bin:/usr/bin", appendBin.desiredValue("/opt/bin:/usr/bin", ":"))
+	assert.Equal(t, "/opt/bin", appendBin.desiredValue("", ":"))
+
+	removeBin := &envVar{Name: "PATH", Value: "/opt/bin", PathAppend: true, Absent: true}
+	assert.Equal(t, "/usr/bin:/bin", removeBin.desiredValue("/usr/bin:/opt/bin:/bin", ":"))
+
+	editor := &envVar{Name: "EDITOR", Value: "nvim"}
+	assert.Equal(t, "nvim", editor.desiredValue("vim", ":"))
+}
+
+func TestEnsureEnvProfiles(t *testing.T) {
+	dir := t.TempDir()
+	profile := filepath.Join(dir, ".profile")
+	zshenv := filepath.Join(dir, ".zshenv")
+	require.NoError(t, os.WriteFile(profile, []byte("# my profile\nexport LANG=C\n"), 0600))
+
+	m := newLinuxModule()
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	task := newProfileTask(map[string]interface{}{"name": "EDITOR", "value": "nvim"}, profile, zshenv)
+	require.NoError(t, m.ValidateTask(task))
+
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.False(t, plan.WillSkip)
+	assert.Contains(t, plan.Changes, "Add export of EDITOR to "+profile)
+	assert.Contains(t, plan.Changes, "Create "+zshenv)
+
+	require.NoError(t, m.ExecuteTask(task, ctx))
+
+	data, err := os.ReadFile(profile)
+	require.NoError(t, err)
+	assert.Equal(t, "# my profile\nexport LANG=C\n# BEGIN dotfiles-managed: env EDITOR\nexport EDITOR=\"nvim\"\n# END dotfiles-managed\n", string(data))
+	info, err := os.Stat(profile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.FileExists(t, zshenv)
+
+	t.Run("Idempotent", func(t *testing.T) {
+		plan, err := m.PlanTask(task, ctx)
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+
+		require.NoError(t, m.ExecuteTask(task, ctx))
+		again, err := os.ReadFile(profile)
+		require.NoError(t, err)
+		assert.Equal(t, string(data), string(again))
+	})
+
+	t.Run("UpdateValue", func(t *testing.T) {
+		task.Config["value"] = "hx"
+		plan, err := m.PlanTask(task, ctx)
+		require.NoError(t, err)
+		assert.Contains(t, plan.Changes, "Update export of EDITOR in "+profile)
+
+		require.NoError(t, m.ExecuteTask(task, ctx))
+		data, err := os.ReadFile(profile)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(data), "dotfiles-managed: env EDITOR"))
+		assert.Contains(t, string(data), "export EDITOR=\"hx\"\n")
+	})
+
+	t.Run("Absent", func(t *testing.T) {
+		absent := newProfileTask(map[string]interface{}{"name": "EDITOR", "state": "absent"}, profile, zshenv)
+		require.NoError(t, m.ExecuteTask(absent, ctx))
+
+		data, err := os.ReadFile(profile)
+		require.NoError(t, err)
+		assert.Equal(t, "# my profile\nexport LANG=C\n", string(data))
+
+		plan, err := m.PlanTask(absent, ctx)
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+	})
+}
+
+func TestEnsureEnvPathAppend(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), ".profile")
+	m := newLinuxModule()
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	goBin := newProfileTask(map[string]interface{}{"name": "PATH", "value": "$HOME/go/bin", "path_append": true}, profile)
+	cargoBin := newProfileTask(map[string]interface{}{"name": "PATH", "value": "$HOME/.cargo/bin", "path_append": true}, profile)
+	require.NoError(t, m.ExecuteTask(goBin, ctx))
+	require.NoError(t, m.ExecuteTask(cargoBin, ctx))
+	require.NoError(t, m.ExecuteTask(goBin, ctx))
+
+	data, err := os.ReadFile(profile)
+	require.NoError(t, err)
+	content := string(data)
+	assert.Equal(t, 1, strings.Count(content, "# BEGIN dotfiles-managed: env PATH += $HOME/go/bin\n"))
+	assert.Equal(t, 1, strings.Count(content, "# BEGIN dotfiles-managed: env PATH += $HOME/.cargo/bin\n"))
+	assert.Contains(t, content, "case \":${PATH}:\" in\n  *:\"$HOME/go/bin\":*) ;;\n  *) export PATH=\"${PATH:+${PATH}:}\"\"$HOME/go/bin\" ;;\nesac\n")
+}
+
+func TestEnsureEnvSession(t *testing.T) {
+	t.Setenv("DOTFILES_ENV_TEST", "old")
+	m := New()
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{"editor": "nvim"}}
+
+	task := &config.Task{Action: "ensure_env", Config: map[string]interface{}{
+		"name":  "DOTFILES_ENV_TEST",
+		"value": "{{ editor }}",
+		"scope": "session",
+	}}
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DOTFILES_ENV_TEST: 'old' -> 'nvim' (this session only)"}, plan.Changes)
+
+	require.NoError(t, m.ExecuteTask(task, ctx))
+	assert.Equal(t, "nvim", os.Getenv("DOTFILES_ENV_TEST"))
+
+	plan, err = m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+
+	task.Config["state"] = "absent"
+	require.NoError(t, m.ExecuteTask(task, ctx))
+	_, isSet := os.LookupEnv("DOTFILES_ENV_TEST")
+	assert.False(t, isSet)
+}
@@ -0,0 +1,194 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// profileEdit describes how ensure_env changes a shell profile
+type profileEdit struct {
+	Path    string
+	Kind    string // "add", "update" or "remove"
+	Create  bool   // Whether the profile does not exist yet
+	Content string // New content of the profile
+}
+
+// profileMarkers returns the begin and end markers of the block that exports a variable.
+// PATH-like variables get a block per appended value.
+func profileMarkers(v *envVar) (string, string) {
+	id := v.Name
+	if v.PathAppend {
+		id = fmt.Sprintf("%s += %s", v.Name, v.Value)
+	}
+	return fmt.Sprintf("# BEGIN dotfiles-managed: env %s", id), "# END dotfiles-managed"
+}
+
+// profileLines returns the shell lines that export a variable
+func profileLines(v *envVar) []string {
+	value := shellQuote(v.Value)
+	if !v.PathAppend {
+		return []string{fmt.Sprintf("export %s=%s", v.Name, value)}
+	}
+
+	// Only append when the entry is missing so re-sourcing the profile is harmless
+	return []string{
+		fmt.Sprintf(`case ":${%s}:" in`, v.Name),
+		fmt.Sprintf(`  *:%s:*) ;;`, value),
+		fmt.Sprintf(`  *) export %s="${%s:+${%s}:}"%s ;;`, v.Name, v.Name, v.Name, value),
+		"esac",
+	}
+}
+
+// shellQuote double quotes a value so the shell still expands $VARS in it
+func shellQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// applyProfileBlock returns the profile content with the block of a variable added,
+// updated or removed, and what kind of edit that was. The kind is empty when the
+// profile is already up to date.
+func applyProfileBlock(content string, v *envVar) (string, string, error) {
+	beginMarker, endMarker := profileMarkers(v)
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	begin, end := -1, -1
+	for i, line := range lines {
+		text := strings.TrimSpace(line)
+		if begin < 0 && text == beginMarker {
+			begin = i
+			continue
+		}
+		if begin >= 0 && text == endMarker {
+			end = i
+			break
+		}
+	}
+	if begin >= 0 && end < 0 {
+		return "", "", fmt.Errorf("found '%s' on line %d without a matching '%s'", beginMarker, begin+1, endMarker)
+	}
+
+	if v.Absent {
+		if begin < 0 {
+			return content, "", nil
+		}
+		kept := append(append([]string{}, lines[:begin]...), lines[end+1:]...)
+		return strings.Join(kept, ""), "remove", nil
+	}
+
+	block := []string{beginMarker + "\n"}
+	for _, line := range profileLines(v) {
+		block = append(block, line+"\n")
+	}
+	block = append(block, endMarker+"\n")
+
+	if begin >= 0 {
+		if strings.Join(lines[begin:end+1], "") == strings.Join(block, "") {
+			return content, "", nil
+		}
+		updated := append(append(append([]string{}, lines[:begin]...), block...), lines[end+1:]...)
+		return strings.Join(updated, ""), "update", nil
+	}
+
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+	return strings.Join(append(lines, block...), ""), "add", nil
+}
+
+// profileEdits returns the edits ensure_env makes to each shell profile
+func profileEdits(v *envVar) ([]*profileEdit, error) {
+	var edits []*profileEdit
+	for _, path := range v.Profiles {
+		data, err := os.ReadFile(path)
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !exists && v.Absent {
+			continue
+		}
+
+		content, kind, err := applyProfileBlock(string(data), v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update %s: %w", path, err)
+		}
+		if kind != "" {
+			edits = append(edits, &profileEdit{Path: path, Kind: kind, Create: !exists, Content: content})
+		}
+	}
+	return edits, nil
+}
+
+// planProfileEnv plans a user variable exported from shell profiles
+func planProfileEnv(v *envVar, plan *modules.TaskPlan) error {
+	edits, err := profileEdits(v)
+	if err != nil {
+		return err
+	}
+	if len(edits) == 0 {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("%s is already up to date in %s", v.Name, strings.Join(v.Profiles, ", "))
+		return nil
+	}
+
+	// The value of this shell is the closest thing to the current value
+	current, isSet := os.LookupEnv(v.Name)
+	desired := v.desiredValue(current, string(os.PathListSeparator))
+	unset := v.Absent && !v.PathAppend
+	if (unset && isSet) || (!unset && (!isSet || current != desired)) {
+		plan.Changes = append(plan.Changes, valueChange(v.Name, current, isSet, desired, unset))
+	}
+
+	for _, edit := range edits {
+		if edit.Create {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Create %s", edit.Path))
+		}
+		switch edit.Kind {
+		case "add":
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Add export of %s to %s", v.Name, edit.Path))
+		case "update":
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Update export of %s in %s", v.Name, edit.Path))
+		case "remove":
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Remove export of %s from %s", v.Name, edit.Path))
+		}
+	}
+	return nil
+}
+
+// executeProfileEnv exports a user variable from, or removes it from, shell profiles
+func executeProfileEnv(v *envVar, ctx *modules.ExecutionContext) error {
+	edits, err := profileEdits(v)
+	if err != nil {
+		return err
+	}
+	if len(edits) == 0 && ctx.Verbose {
+		fmt.Printf("%s is already up to date\n", v.Name)
+	}
+
+	for _, edit := range edits {
+		// Preserve the mode of existing profiles
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(edit.Path); err == nil {
+			mode = info.Mode().Perm()
+		} else if err := utils.EnsureDir(filepath.Dir(edit.Path)); err != nil {
+			return fmt.Errorf("failed to create parent directory: %w", err)
+		}
+
+		if ctx.Verbose {
+			fmt.Printf("%s: %s export of %s\n", edit.Path, edit.Kind, v.Name)
+		}
+		if err := os.WriteFile(edit.Path, []byte(edit.Content), mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", edit.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package env
+
+import "errors"
+
+// errNoRegistry is returned when the Windows registry is used on another platform
+var errNoRegistry = errors.New("the Windows registry is not available on this platform")
+
+func readUserEnv(name string) (string, bool, error) {
+	return "", false, errNoRegistry
+}
+
+func writeUserEnv(name, value string) error {
+	return errNoRegistry
+}
+
+func deleteUserEnv(name string) error {
+	return errNoRegistry
+}
+
+func broadcastEnvChange() error {
+	return errNoRegistry
+}
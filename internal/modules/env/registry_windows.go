@@ -0,0 +1,97 @@
+//go:build windows
+
+package env
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// userEnvKey is the registry key holding user environment variables
+const userEnvKey = `Environment`
+
+var (
+	user32                 = windows.NewLazySystemDLL("user32.dll")
+	procSendMessageTimeout = user32.NewProc("SendMessageTimeoutW")
+)
+
+const (
+	hwndBroadcast   = 0xffff
+	wmSettingChange = 0x001a
+	smtoAbortIfHung = 0x0002
+)
+
+// readUserEnv reads a variable from HKCU\Environment
+func readUserEnv(name string) (string, bool, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, userEnvKey, registry.QUERY_VALUE)
+	if err != nil {
+		return "", false, err
+	}
+	defer key.Close()
+
+	value, _, err := key.GetStringValue(name)
+	if errors.Is(err, registry.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// writeUserEnv writes a variable to HKCU\Environment. Values referencing other
+// variables (%VAR%) are stored as REG_EXPAND_SZ so Windows expands them.
+func writeUserEnv(name, value string) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, userEnvKey, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	if strings.Contains(value, "%") {
+		return key.SetExpandStringValue(name, value)
+	}
+	return key.SetStringValue(name, value)
+}
+
+// deleteUserEnv removes a variable from HKCU\Environment
+func deleteUserEnv(name string) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, userEnvKey, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	if err := key.DeleteValue(name); err != nil && !errors.Is(err, registry.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// broadcastEnvChange sends WM_SETTINGCHANGE so Explorer and other running
+// applications pick up the new user environment
+func broadcastEnvChange() error {
+	param, err := windows.UTF16PtrFromString("Environment")
+	if err != nil {
+		return err
+	}
+
+	var result uintptr
+	ret, _, err := procSendMessageTimeout.Call(
+		hwndBroadcast,
+		wmSettingChange,
+		0,
+		uintptr(unsafe.Pointer(param)),
+		smtoAbortIfHung,
+		5000,
+		uintptr(unsafe.Pointer(&result)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// Access control profiles windows_acl accepts
+const (
+	aclPrivate = "private" // Only the current user has access, nothing is inherited
+	aclDefault = "default" // Only what the parent directory passes on
+)
+
+// validateWindowsACL validates the windows_acl option of an action
+func validateWindowsACL(action string, config map[string]interface{}) error {
+	value, exists := config["windows_acl"]
+	if !exists {
+		return nil
+	}
+	switch value {
+	case aclPrivate, aclDefault:
+		return nil
+	default:
+		return fmt.Errorf("%s 'windows_acl' must be '%s' or '%s'", action, aclPrivate, aclDefault)
+	}
+}
+
+// windowsACL returns the access control profile of a task, or an empty string when
+// it has none or does not run on Windows
+func windowsACL(task *config.Task) string {
+	profile, _ := modules.StringValue(task.Config["windows_acl"])
+	if profile != "" && runtime.GOOS != "windows" {
+		logger.Get().Debug().Str("task", task.ID).Str("os", runtime.GOOS).Msg("Ignoring windows_acl, it only applies on Windows")
+		return ""
+	}
+	return profile
+}
+
+// aclChange describes what setting an access control profile changes
+func aclChange(profile string) string {
+	if profile == aclPrivate {
+		return "Restrict access to the current user (windows_acl: private)"
+	}
+	return "Reset access to what the parent directory grants (windows_acl: default)"
+}
+
+// planWindowsACL adds the access control change of an ensure_file or ensure_dir
+// task to its plan. A task that would be skipped because its target is up to date
+// runs when only the access control differs.
+func (m *FilesModule) planWindowsACL(task *config.Task, ctx *modules.ExecutionContext, plan *modules.TaskPlan) (*modules.TaskPlan, error) {
+	if plan == nil || (plan.WillSkip && !upToDateSkipReasons[plan.SkipReason]) {
+		return plan, nil
+	}
+	profile := windowsACL(task)
+	if profile == "" {
+		return plan, nil
+	}
+
+	path, err := m.ownershipTarget(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		matches, err := aclMatches(path, profile)
+		if err != nil || matches {
+			return plan, err
+		}
+	}
+
+	plan.WillSkip = false
+	plan.SkipReason = ""
+	plan.SkipCode = ""
+	plan.Changes = append(plan.Changes, aclChange(profile))
+	return plan, nil
+}
+
+// applyWindowsACL gives the target of an ensure_file or ensure_dir task its access
+// control profile when it does not have it yet
+func (m *FilesModule) applyWindowsACL(task *config.Task, ctx *modules.ExecutionContext, path string) error {
+	profile := windowsACL(task)
+	if profile == "" {
+		return nil
+	}
+
+	matches, err := aclMatches(path, profile)
+	if err != nil || matches {
+		return err
+	}
+	if ctx.Verbose {
+		fmt.Printf("Changing access control: %s (%s)\n", path, profile)
+	}
+	return setACL(path, profile)
+}
+
+// planAttributes adds the ownership and access control changes of an ensure_file
+// or ensure_dir task to its plan
+func (m *FilesModule) planAttributes(task *config.Task, ctx *modules.ExecutionContext, plan *modules.TaskPlan) (*modules.TaskPlan, error) {
+	plan, err := m.planOwnership(task, ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	return m.planWindowsACL(task, ctx, plan)
+}
+
+// applyAttributes gives the target of an ensure_file or ensure_dir task its
+// configured ownership and access control
+func (m *FilesModule) applyAttributes(task *config.Task, ctx *modules.ExecutionContext, path string) error {
+	if err := m.applyOwnership(task, ctx, path); err != nil {
+		return err
+	}
+	return m.applyWindowsACL(task, ctx, path)
+}
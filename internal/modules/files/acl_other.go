@@ -0,0 +1,16 @@
+//go:build !windows
+
+package files
+
+import "errors"
+
+// errNoACL is returned when Windows access control is used on another platform
+var errNoACL = errors.New("windows_acl is only supported on Windows")
+
+func aclMatches(path, profile string) (bool, error) {
+	return false, errNoACL
+}
+
+func setACL(path, profile string) error {
+	return errNoACL
+}
@@ -0,0 +1,90 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestValidateWindowsACL(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "not set", config: map[string]interface{}{}},
+		{name: "private", config: map[string]interface{}{"windows_acl": "private"}},
+		{name: "default", config: map[string]interface{}{"windows_acl": "default"}},
+		{name: "unknown profile", config: map[string]interface{}{"windows_acl": "owner-only"}, wantErr: true},
+		{name: "boolean", config: map[string]interface{}{"windows_acl": true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateWindowsACL("ensure_file", tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateWindowsACL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnsureFileWindowsACL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.ps1")
+	if err := os.WriteFile(path, []byte("Set-Alias g git\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newTask := func(profile string) *config.Task {
+		return &config.Task{
+			ID:     "profile",
+			Action: "ensure_file",
+			Config: map[string]interface{}{"path": path, "content": "Set-Alias g git\n", "windows_acl": profile},
+		}
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+	m := New()
+
+	if runtime.GOOS != "windows" {
+		// The key is ignored, the file is up to date
+		plan, err := m.PlanTask(newTask(aclPrivate), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip {
+			t.Errorf("expected windows_acl to be ignored on %s, got %+v", runtime.GOOS, plan)
+		}
+		if err := m.ExecuteTask(newTask(aclPrivate), ctx); err != nil {
+			t.Errorf("expected windows_acl to be ignored on %s, got %v", runtime.GOOS, err)
+		}
+		return
+	}
+
+	for _, profile := range []string{aclPrivate, aclDefault} {
+		plan, err := m.PlanTask(newTask(profile), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.WillSkip || len(plan.Changes) != 1 || plan.Changes[0] != aclChange(profile) {
+			t.Errorf("expected plan to change the access control to %s, got %+v", profile, plan)
+		}
+
+		if err := m.ExecuteTask(newTask(profile), ctx); err != nil {
+			t.Fatal(err)
+		}
+		if matches, err := aclMatches(path, profile); err != nil || !matches {
+			t.Errorf("aclMatches(%s) = %v, %v after applying it", profile, matches, err)
+		}
+
+		plan, err = m.PlanTask(newTask(profile), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip {
+			t.Errorf("expected plan to skip a file with access control %s, got %+v", profile, plan)
+		}
+	}
+}
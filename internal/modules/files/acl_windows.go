@@ -0,0 +1,132 @@
+//go:build windows
+
+package files
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileAllAccess is the full control icacls grants with F
+const fileAllAccess = windows.STANDARD_RIGHTS_REQUIRED | windows.SYNCHRONIZE | 0x1FF
+
+// currentUserSID returns the SID of the user dotfiles runs as
+func currentUserSID() (*windows.SID, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the current user: %w", err)
+	}
+	return user.User.Sid, nil
+}
+
+// aclMatches returns whether the access control list of path has a profile.
+// private is a protected list with only a full control entry for the current user,
+// default is a list with only inherited entries.
+func aclMatches(path, profile string) (bool, error) {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the access control of %s: %w", path, err)
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		return false, fmt.Errorf("failed to read the access control of %s: %w", path, err)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil || dacl == nil {
+		// A missing list grants everyone access
+		return false, nil
+	}
+
+	aces := make([]*windows.ACCESS_ALLOWED_ACE, dacl.AceCount)
+	for i := range aces {
+		if err := windows.GetAce(dacl, uint32(i), &aces[i]); err != nil {
+			return false, fmt.Errorf("failed to read the access control of %s: %w", path, err)
+		}
+	}
+	protected := control&windows.SE_DACL_PROTECTED != 0
+
+	if profile == aclDefault {
+		for _, ace := range aces {
+			if ace.Header.AceFlags&windows.INHERITED_ACE == 0 {
+				return false, nil
+			}
+		}
+		return !protected, nil
+	}
+
+	if !protected || len(aces) != 1 {
+		return false, nil
+	}
+	ace := aces[0]
+	if ace.Header.AceType != windows.ACCESS_ALLOWED_ACE_TYPE || ace.Mask&fileAllAccess != fileAllAccess {
+		return false, nil
+	}
+	if isDir(path) && ace.Header.AceFlags&(windows.OBJECT_INHERIT_ACE|windows.CONTAINER_INHERIT_ACE) != windows.OBJECT_INHERIT_ACE|windows.CONTAINER_INHERIT_ACE {
+		return false, nil
+	}
+	user, err := currentUserSID()
+	if err != nil {
+		return false, err
+	}
+	return (*windows.SID)(unsafe.Pointer(&ace.SidStart)).Equals(user), nil
+}
+
+// setACL gives path an access control profile, like icacls /inheritance:r
+// /grant:r "%USERNAME%":F for private and icacls /reset for default
+func setACL(path, profile string) error {
+	var (
+		dacl *windows.ACL
+		info windows.SECURITY_INFORMATION = windows.DACL_SECURITY_INFORMATION
+	)
+
+	if profile == aclPrivate {
+		user, err := currentUserSID()
+		if err != nil {
+			return err
+		}
+		inheritance := uint32(windows.NO_INHERITANCE)
+		if isDir(path) {
+			// Files created in the directory are private too
+			inheritance = windows.SUB_CONTAINERS_AND_OBJECTS_INHERIT
+		}
+		if dacl, err = windows.ACLFromEntries([]windows.EXPLICIT_ACCESS{{
+			AccessPermissions: fileAllAccess,
+			AccessMode:        windows.SET_ACCESS,
+			Inheritance:       inheritance,
+			Trustee: windows.TRUSTEE{
+				TrusteeForm:  windows.TRUSTEE_IS_SID,
+				TrusteeType:  windows.TRUSTEE_IS_USER,
+				TrusteeValue: windows.TrusteeValueFromSID(user),
+			},
+		}}, nil); err != nil {
+			return fmt.Errorf("failed to build the access control of %s: %w", path, err)
+		}
+		info |= windows.PROTECTED_DACL_SECURITY_INFORMATION
+	} else {
+		// An empty list that inherits again ends up with what the parent passes on
+		dacl = emptyACL()
+		info |= windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	}
+
+	if err := windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, info, nil, nil, dacl, nil); err != nil {
+		return fmt.Errorf("failed to change the access control of %s: %w", path, err)
+	}
+	return nil
+}
+
+// emptyACL returns an access control list without entries. Windows treats a nil
+// list as granting everyone access, so an empty one has to be built.
+func emptyACL() *windows.ACL {
+	// Revision 2, size 8 bytes, no entries
+	header := []byte{2, 0, 8, 0, 0, 0, 0, 0}
+	return (*windows.ACL)(unsafe.Pointer(&header[0]))
+}
+
+// isDir returns whether path is a directory
+func isDir(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && stat.IsDir()
+}
@@ -0,0 +1,308 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// blockInFileOptions holds the rendered configuration of a block_in_file task
+type blockInFileOptions struct {
+	Block       string
+	State       string
+	BeginMarker string
+	EndMarker   string
+}
+
+// blockEdit describes how block_in_file changes a file
+type blockEdit struct {
+	Kind      string // "add", "update" or "remove"
+	StartLine int    // 1-based line number where the block starts
+	OldLines  []string
+	NewLines  []string
+}
+
+// blockMarkers returns the begin and end markers of a managed block
+func blockMarkers(comment, id string) (string, string) {
+	return fmt.Sprintf("%s BEGIN dotfiles-managed: %s", comment, id),
+		fmt.Sprintf("%s END dotfiles-managed", comment)
+}
+
+// validateBlockInFileTask validates block_in_file task configuration
+func (m *FilesModule) validateBlockInFileTask(config map[string]interface{}) error {
+	if _, exists := config["path"]; !exists {
+		return fmt.Errorf("block_in_file task requires 'path' field")
+	}
+
+	for _, field := range []string{"path", "block", "marker_comment", "state", "name"} {
+		if value, exists := config[field]; exists {
+			if _, ok := modules.StringValue(value); !ok {
+				return fmt.Errorf("block_in_file '%s' must be a string", field)
+			}
+		}
+	}
+
+	state := "present"
+	if stateStr, exists := config["state"]; exists {
+		state, _ = modules.StringValue(stateStr)
+	}
+	if state != "present" && state != "absent" {
+		return fmt.Errorf("block_in_file 'state' must be 'present' or 'absent', got '%s'", state)
+	}
+
+	if _, exists := config["block"]; !exists && state == "present" {
+		return fmt.Errorf("block_in_file with state 'present' requires 'block' field")
+	}
+
+	if comment, exists := config["marker_comment"]; exists {
+		if commentStr, _ := modules.StringValue(comment); strings.TrimSpace(commentStr) == "" {
+			return fmt.Errorf("block_in_file 'marker_comment' cannot be empty")
+		}
+	}
+
+	return nil
+}
+
+// parseBlockInFileOptions renders the options of a block_in_file task
+func (m *FilesModule) parseBlockInFileOptions(task *config.Task, ctx *modules.ExecutionContext) (string, *blockInFileOptions, error) {
+	path, err := m.processField(task, "path", ctx.Variables)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to process path template: %w", err)
+	}
+	path, err = utils.ExpandPath(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	opts := &blockInFileOptions{State: "present"}
+	if state, ok := modules.StringValue(task.Config["state"]); ok {
+		opts.State = state
+	}
+
+	if block, ok := modules.StringValue(task.Config["block"]); ok {
+		opts.Block, err = m.processTemplateWithPathConversion(block, ctx.Variables, false)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to process block template for %s: %w", path, err)
+		}
+	}
+
+	comment := "#"
+	if commentStr, ok := modules.StringValue(task.Config["marker_comment"]); ok {
+		comment = commentStr
+	}
+
+	// An explicit name keeps markers stable when several blocks share a file
+	id := task.ID
+	if name, ok := modules.StringValue(task.Config["name"]); ok && name != "" {
+		id = name
+	}
+	opts.BeginMarker, opts.EndMarker = blockMarkers(comment, id)
+
+	return path, opts, nil
+}
+
+// applyBlockInFile returns the content with the managed block inserted, updated or
+// removed. Only the lines between the markers are touched.
+func applyBlockInFile(content string, opts *blockInFileOptions) (string, *blockEdit, error) {
+	lines, eol := splitLines(content)
+
+	begin, end := -1, -1
+	for i, line := range lines {
+		if begin < 0 && strings.TrimSpace(lineText(line)) == opts.BeginMarker {
+			begin = i
+			continue
+		}
+		if begin >= 0 && strings.TrimSpace(lineText(line)) == opts.EndMarker {
+			end = i
+			break
+		}
+	}
+	if begin >= 0 && end < 0 {
+		return "", nil, fmt.Errorf("found '%s' on line %d without a matching '%s'", opts.BeginMarker, begin+1, opts.EndMarker)
+	}
+
+	var oldLines []string
+	if begin >= 0 {
+		for _, line := range lines[begin+1 : end] {
+			oldLines = append(oldLines, lineText(line))
+		}
+	}
+
+	if opts.State == "absent" {
+		if begin < 0 {
+			return content, nil, nil
+		}
+		removed := append([]string{lineText(lines[begin])}, oldLines...)
+		removed = append(removed, lineText(lines[end]))
+
+		kept := append(append([]string{}, lines[:begin]...), lines[end+1:]...)
+		return strings.Join(kept, ""), &blockEdit{Kind: "remove", StartLine: begin + 1, OldLines: removed}, nil
+	}
+
+	var newLines []string
+	if block := strings.TrimRight(strings.ReplaceAll(opts.Block, "\r\n", "\n"), "\n"); block != "" {
+		newLines = strings.Split(block, "\n")
+	}
+
+	if begin >= 0 {
+		if strings.Join(oldLines, "\n") == strings.Join(newLines, "\n") {
+			return content, nil, nil
+		}
+
+		// Replace only the region between the markers
+		region := make([]string, 0, len(newLines))
+		for _, line := range newLines {
+			region = append(region, line+eol)
+		}
+		updated := append(append(append([]string{}, lines[:begin+1]...), region...), lines[end:]...)
+		return strings.Join(updated, ""), &blockEdit{Kind: "update", StartLine: begin + 2, OldLines: oldLines, NewLines: newLines}, nil
+	}
+
+	// Append a new block at the end of the file
+	if len(lines) > 0 && lineEnding(lines[len(lines)-1]) == "" {
+		lines[len(lines)-1] += eol
+	}
+	added := append(append([]string{opts.BeginMarker}, newLines...), opts.EndMarker)
+	for _, line := range added {
+		lines = append(lines, line+eol)
+	}
+	return strings.Join(lines, ""), &blockEdit{Kind: "add", StartLine: len(lines) - len(added) + 1, NewLines: added}, nil
+}
+
+// describeBlockEdit formats a block edit as plan changes with a diff limited to the block
+func describeBlockEdit(edit *blockEdit) []string {
+	var changes []string
+	switch edit.Kind {
+	case "add":
+		changes = append(changes, fmt.Sprintf("Add block at line %d", edit.StartLine))
+		for i, line := range edit.NewLines {
+			changes = append(changes, fmt.Sprintf("  + %d: %s", edit.StartLine+i, line))
+		}
+	case "remove":
+		changes = append(changes, fmt.Sprintf("Remove block at line %d", edit.StartLine))
+		for i, line := range edit.OldLines {
+			changes = append(changes, fmt.Sprintf("  - %d: %s", edit.StartLine+i, line))
+		}
+	case "update":
+		changes = append(changes, fmt.Sprintf("Update block at line %d", edit.StartLine))
+		for i := 0; i < len(edit.OldLines) || i < len(edit.NewLines); i++ {
+			oldLine, newLine := "", ""
+			hasOld, hasNew := i < len(edit.OldLines), i < len(edit.NewLines)
+			if hasOld {
+				oldLine = edit.OldLines[i]
+			}
+			if hasNew {
+				newLine = edit.NewLines[i]
+			}
+			if hasOld && hasNew && oldLine == newLine {
+				continue
+			}
+			if hasOld {
+				changes = append(changes, fmt.Sprintf("  - %d: %s", edit.StartLine+i, oldLine))
+			}
+			if hasNew {
+				changes = append(changes, fmt.Sprintf("  + %d: %s", edit.StartLine+i, newLine))
+			}
+		}
+	}
+	return changes
+}
+
+// planBlockInFile returns what block_in_file would do
+func (m *FilesModule) planBlockInFile(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	path, opts, err := m.parseBlockInFileOptions(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: fmt.Sprintf("Ensure managed block is %s in %s", opts.State, path),
+		Changes:     []string{},
+	}
+
+	content, exists, err := readEditTarget(path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && opts.State == "absent" {
+		plan.WillSkip = true
+		plan.SkipReason = "File does not exist"
+		return plan, nil
+	}
+
+	_, edit, err := applyBlockInFile(content, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	if edit == nil {
+		plan.WillSkip = true
+		if opts.State == "absent" {
+			plan.SkipReason = "Block is already absent"
+		} else {
+			plan.SkipReason = "Block is up to date"
+		}
+		return plan, nil
+	}
+
+	if !exists {
+		plan.Changes = append(plan.Changes, "Create file")
+	}
+	plan.Changes = append(plan.Changes, describeBlockEdit(edit)...)
+
+	return plan, nil
+}
+
+// executeBlockInFile ensures a managed block is present in or absent from a file
+func (m *FilesModule) executeBlockInFile(task *config.Task, ctx *modules.ExecutionContext) error {
+	path, opts, err := m.parseBlockInFileOptions(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	content, exists, err := readEditTarget(path)
+	if err != nil {
+		return err
+	}
+	if !exists && opts.State == "absent" {
+		return nil
+	}
+
+	newContent, edit, err := applyBlockInFile(content, opts)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	if edit == nil {
+		if ctx.Verbose {
+			fmt.Printf("Block already %s: %s\n", opts.State, path)
+		}
+		return nil
+	}
+
+	// Preserve the mode of the existing file
+	mode := os.FileMode(0644)
+	if exists {
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	} else if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	if ctx.Verbose {
+		for _, change := range describeBlockEdit(edit) {
+			fmt.Printf("%s: %s\n", path, change)
+		}
+	}
+
+	if err := utils.WriteFileAtomic(path, []byte(newContent), mode); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
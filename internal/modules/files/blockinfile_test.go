@@ -0,0 +1,146 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestApplyBlockInFile(t *testing.T) {
+	begin, end := blockMarkers("#", "aliases")
+
+	tests := []struct {
+		name     string
+		content  string
+		opts     *blockInFileOptions
+		expected string
+		kind     string
+	}{
+		{
+			name:     "appends new block",
+			content:  "export A=1\n",
+			opts:     &blockInFileOptions{Block: "alias ll='ls -la'\n", State: "present"},
+			expected: "export A=1\n" + begin + "\nalias ll='ls -la'\n" + end + "\n",
+			kind:     "add",
+		},
+		{
+			name:     "terminates last line before appending",
+			content:  "export A=1",
+			opts:     &blockInFileOptions{Block: "x", State: "present"},
+			expected: "export A=1\n" + begin + "\nx\n" + end + "\n",
+			kind:     "add",
+		},
+		{
+			name:     "updates only the region between markers",
+			content:  "before\n" + begin + "\nold\n" + end + "\nafter\n",
+			opts:     &blockInFileOptions{Block: "new1\nnew2", State: "present"},
+			expected: "before\n" + begin + "\nnew1\nnew2\n" + end + "\nafter\n",
+			kind:     "update",
+		},
+		{
+			name:     "unchanged block",
+			content:  begin + "\na\nb\n" + end + "\n",
+			opts:     &blockInFileOptions{Block: "a\nb\n", State: "present"},
+			expected: begin + "\na\nb\n" + end + "\n",
+		},
+		{
+			name:     "removes block and markers",
+			content:  "before\n" + begin + "\na\n" + end + "\nafter\n",
+			opts:     &blockInFileOptions{State: "absent"},
+			expected: "before\nafter\n",
+			kind:     "remove",
+		},
+		{
+			name:     "absent block is a no-op",
+			content:  "before\n",
+			opts:     &blockInFileOptions{State: "absent"},
+			expected: "before\n",
+		},
+		{
+			name:     "preserves CRLF line endings",
+			content:  "a\r\n" + begin + "\r\nold\r\n" + end + "\r\n",
+			opts:     &blockInFileOptions{Block: "new", State: "present"},
+			expected: "a\r\n" + begin + "\r\nnew\r\n" + end + "\r\n",
+			kind:     "update",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.BeginMarker, tt.opts.EndMarker = begin, end
+
+			result, edit, err := applyBlockInFile(tt.content, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.expected {
+				t.Errorf("applyBlockInFile() = %q, want %q", result, tt.expected)
+			}
+			if (edit == nil && tt.kind != "") || (edit != nil && edit.Kind != tt.kind) {
+				t.Errorf("applyBlockInFile() edit = %+v, want kind %q", edit, tt.kind)
+			}
+
+			// Applying again must not change anything
+			again, edit, err := applyBlockInFile(result, tt.opts)
+			if err != nil || again != result || edit != nil {
+				t.Errorf("second apply was not idempotent: %q, %+v, %v", again, edit, err)
+			}
+		})
+	}
+}
+
+func TestApplyBlockInFileMissingEndMarker(t *testing.T) {
+	begin, end := blockMarkers("//", "settings")
+	opts := &blockInFileOptions{Block: "x", State: "present", BeginMarker: begin, EndMarker: end}
+
+	_, _, err := applyBlockInFile("a\n"+begin+"\nx\n", opts)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error about unterminated block on line 2, got %v", err)
+	}
+}
+
+func TestBlockInFilePlanShowsBlockDiff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".vimrc")
+	if err := os.WriteFile(path, []byte("syntax on\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{"width": 4}}
+	task := &config.Task{
+		ID:     "block_in_file: .vimrc",
+		Action: "block_in_file",
+		Config: map[string]interface{}{
+			"path":           path,
+			"name":           "editor",
+			"marker_comment": `"`,
+			"block":          "set number\nset tabstop={{ width }}",
+		},
+	}
+	if err := m.ValidateTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	expected := "syntax on\n\" BEGIN dotfiles-managed: editor\nset number\nset tabstop=4\n\" END dotfiles-managed\n"
+	if string(content) != expected {
+		t.Fatalf("content = %q, want %q", content, expected)
+	}
+
+	ctx.Variables["width"] = 2
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChanges := []string{"Update block at line 3", "  - 4: set tabstop=4", "  + 4: set tabstop=2"}
+	if strings.Join(plan.Changes, "|") != strings.Join(expectedChanges, "|") {
+		t.Errorf("plan changes = %q, want %q", plan.Changes, expectedChanges)
+	}
+}
@@ -0,0 +1,202 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// errContentAtApplyTime is returned for the content of a content_command task
+// while planning, since commands only run during apply unless --plan-exec is given
+var errContentAtApplyTime = errors.New("content determined at apply time")
+
+// contentCommand is the rendered content_command of an ensure_file task
+type contentCommand struct {
+	Command   string
+	Shell     string
+	CacheKey  string // Output is reused while the key is unchanged, empty to always run
+	CachePath string
+}
+
+// commandOutputRecord is the output of a content_command kept for cache_key
+type commandOutputRecord struct {
+	CacheKey string    `json:"cache_key"`
+	Command  string    `json:"command"`
+	Output   string    `json:"output"`
+	RanAt    time.Time `json:"ran_at"`
+}
+
+// commandCacheDir returns the directory content_command outputs are kept in
+func commandCacheDir(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), "command-output")
+}
+
+// validateContentCommand validates the content_command, shell and cache_key fields
+// of ensure_file
+func validateContentCommand(config map[string]interface{}) error {
+	rawCommand, exists := config["content_command"]
+	if !exists {
+		for _, field := range []string{"shell", "cache_key"} {
+			if _, exists := config[field]; exists {
+				return fmt.Errorf("ensure_file '%s' requires 'content_command'", field)
+			}
+		}
+		return nil
+	}
+
+	if command, ok := modules.StringValue(rawCommand); !ok || strings.TrimSpace(command) == "" {
+		return fmt.Errorf("ensure_file 'content_command' must be a non-empty string")
+	}
+	for _, field := range []string{"content", "content_source", "content_url"} {
+		if _, exists := config[field]; exists {
+			return fmt.Errorf("ensure_file 'content_command' cannot be combined with '%s'", field)
+		}
+	}
+	if _, exists := config["render"]; exists {
+		return fmt.Errorf("ensure_file 'render' cannot be used with 'content_command', its output is written as-is")
+	}
+	for _, field := range []string{"shell", "cache_key"} {
+		if value, exists := config[field]; exists {
+			if _, ok := modules.StringValue(value); !ok {
+				return fmt.Errorf("ensure_file '%s' must be a string", field)
+			}
+		}
+	}
+	return nil
+}
+
+// parseContentCommand renders the content_command and cache_key of a task
+func (m *FilesModule) parseContentCommand(task *config.Task, ctx *modules.ExecutionContext, path string) (*contentCommand, error) {
+	command, err := m.processField(task, "content_command", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process content_command template: %w", err)
+	}
+
+	source := &contentCommand{Command: command}
+	source.Shell, _ = modules.StringValue(task.Config["shell"])
+	if cacheKey, ok := modules.StringValue(task.Config["cache_key"]); ok {
+		source.CacheKey, err = m.processTemplate(cacheKey, ctx.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process cache_key template: %w", err)
+		}
+		source.CachePath = filepath.Join(commandCacheDir(ctx.BasePath), sha256Hex([]byte(path))+".json")
+	}
+	return source, nil
+}
+
+// cached returns the output recorded by an earlier apply when the cache key and
+// the command are unchanged
+func (c *contentCommand) cached() (string, bool) {
+	if c.CacheKey == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(c.CachePath)
+	if err != nil {
+		return "", false
+	}
+	var record commandOutputRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return "", false
+	}
+	if record.CacheKey != c.CacheKey || record.Command != c.Command {
+		return "", false
+	}
+	return record.Output, true
+}
+
+// run runs the command in the dotfiles directory and returns what it prints on
+// stdout. A command exiting non-zero fails with its stderr in the error.
+func (c *contentCommand) run(ctx *modules.ExecutionContext) (string, error) {
+	runCtx := ctx.RunContext()
+	cmd := modules.ShellCommand(runCtx, modules.ShellArgs(c.Shell), c.Command, ctx.BasePath, nil)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		elapsed := time.Since(start).Round(time.Millisecond)
+		switch runCtx.Err() {
+		case context.DeadlineExceeded:
+			return "", fmt.Errorf("content_command '%s' timed out after %s: %w", c.Command, elapsed, runCtx.Err())
+		case context.Canceled:
+			return "", fmt.Errorf("content_command '%s' was cancelled after %s: %w", c.Command, elapsed, runCtx.Err())
+		}
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return "", fmt.Errorf("content_command '%s' failed: %w\nstderr: %s", c.Command, err, output)
+		}
+		return "", fmt.Errorf("content_command '%s' failed: %w", c.Command, err)
+	}
+	return stdout.String(), nil
+}
+
+// record keeps the output for the next apply, when the task has a cache_key
+func (c *contentCommand) record(output string) error {
+	if c.CacheKey == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(&commandOutputRecord{
+		CacheKey: c.CacheKey,
+		Command:  c.Command,
+		Output:   output,
+		RanAt:    time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.EnsureDir(filepath.Dir(c.CachePath)); err != nil {
+		return fmt.Errorf("failed to create command output cache: %w", err)
+	}
+	if err := os.WriteFile(c.CachePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write command output cache: %w", err)
+	}
+	return nil
+}
+
+// commandContent returns the content an ensure_file task with content_command
+// writes to path: the recorded output while the cache_key is unchanged, or the
+// output of running the command. While planning the command only runs with
+// --plan-exec, otherwise errContentAtApplyTime is returned.
+func (m *FilesModule) commandContent(task *config.Task, ctx *modules.ExecutionContext, path string, planning bool) (string, error) {
+	source, err := m.parseContentCommand(task, ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if output, cached := source.cached(); cached {
+		return output, nil
+	}
+	if planning && !ctx.PlanExec {
+		return "", errContentAtApplyTime
+	}
+
+	output, err := source.run(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !planning {
+		if err := source.record(output); err != nil {
+			return "", err
+		}
+	}
+	return output, nil
+}
+
+// recordCommandOutput keeps output as the output of the content_command of a task
+// for path, when the command was run for another path of the task
+func (m *FilesModule) recordCommandOutput(task *config.Task, ctx *modules.ExecutionContext, path, output string) error {
+	source, err := m.parseContentCommand(task, ctx, path)
+	if err != nil {
+		return err
+	}
+	return source.record(output)
+}
@@ -0,0 +1,191 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func contentCommandTask(path, command, cacheKey string) *config.Task {
+	cfg := map[string]interface{}{"path": path, "content_command": command, "shell": "sh"}
+	if cacheKey != "" {
+		cfg["cache_key"] = cacheKey
+	}
+	return &config.Task{ID: "generate", Action: "ensure_file", Config: cfg}
+}
+
+func TestValidateContentCommand(t *testing.T) {
+	m := New()
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		err    string
+	}{
+		{"Valid", map[string]interface{}{"path": "/tmp/x", "content_command": "echo hi", "cache_key": "v1"}, ""},
+		{"Empty", map[string]interface{}{"path": "/tmp/x", "content_command": " "}, "non-empty string"},
+		{"WithContent", map[string]interface{}{"path": "/tmp/x", "content_command": "echo hi", "content": "hi"}, "cannot be combined with 'content'"},
+		{"WithURL", map[string]interface{}{"path": "/tmp/x", "content_command": "echo hi", "content_url": "https://example.com"}, "cannot be combined with 'content_url'"},
+		{"WithRender", map[string]interface{}{"path": "/tmp/x", "content_command": "echo hi", "render": true}, "'render' cannot be used"},
+		{"CacheKeyAlone", map[string]interface{}{"path": "/tmp/x", "cache_key": "v1"}, "'cache_key' requires 'content_command'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.ValidateTask(&config.Task{ID: "generate", Action: "ensure_file", Config: tt.config})
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestEnsureFileContentCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are written for sh")
+	}
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	tmpDir := t.TempDir()
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{"version": "1"}}
+
+	t.Run("WritesOutput", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "generated")
+		task := contentCommandTask(path, "printf 'line\\n'; pwd", "")
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		content, _ := os.ReadFile(path)
+		resolved, _ := filepath.EvalSymlinks(tmpDir)
+		if want := "line\n" + resolved + "\n"; string(content) != want {
+			t.Errorf("content = %q, want %q", content, want)
+		}
+	})
+
+	t.Run("FailureIncludesStderr", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "failed")
+		err := m.ExecuteTask(contentCommandTask(path, "echo broken >&2; exit 3", ""), ctx)
+		if err == nil || !strings.Contains(err.Error(), "stderr: broken") {
+			t.Fatalf("expected error with stderr, got %v", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("a failing command must not create the file")
+		}
+	})
+
+	t.Run("PlanDoesNotRun", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "planned")
+		marker := filepath.Join(tmpDir, "ran")
+		task := contentCommandTask(path, "touch "+marker+"; echo new", "")
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Description != "Ensure file exists from command: touch "+marker+"; echo new -> "+path {
+			t.Errorf("unexpected description: %s", plan.Description)
+		}
+		if len(plan.Changes) < 2 || plan.Changes[0] != "Generate content with command: touch "+marker+"; echo new" ||
+			!strings.HasPrefix(plan.Changes[1], "Content determined at apply time") {
+			t.Errorf("unexpected plan changes: %v", plan.Changes)
+		}
+		if _, err := os.Stat(marker); !os.IsNotExist(err) {
+			t.Fatal("planning must not run the command")
+		}
+
+		// Without running the command an existing file cannot be compared
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		drift, err := m.CheckDrift(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drift.State != modules.DriftUnknown {
+			t.Errorf("drift = %s, want %s", drift.State, modules.DriftUnknown)
+		}
+	})
+
+	t.Run("PlanExec", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "plan-exec")
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		execCtx := *ctx
+		execCtx.PlanExec = true
+
+		plan, err := m.PlanTask(contentCommandTask(path, "echo new", ""), &execCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.WillSkip || !containsChange(plan.Changes, "Update file content") {
+			t.Errorf("expected content update, got %v", plan.Changes)
+		}
+
+		plan, err = m.PlanTask(contentCommandTask(path, "echo old", ""), &execCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip {
+			t.Errorf("expected unchanged file to be skipped, got %v", plan.Changes)
+		}
+	})
+
+	t.Run("CacheKey", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "cached")
+		counter := filepath.Join(tmpDir, "counter")
+		command := "echo run >> " + counter + "; echo generated"
+		runs := func() int {
+			data, _ := os.ReadFile(counter)
+			return strings.Count(string(data), "run")
+		}
+
+		task := contentCommandTask(path, command, "{{ version }}")
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := runs(); got != 1 {
+			t.Errorf("expected the command to run once while the key is unchanged, ran %d times", got)
+		}
+
+		// The recorded output is also what plan compares against
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip {
+			t.Errorf("expected cached output to match the file, got %v", plan.Changes)
+		}
+
+		bumped := *ctx
+		bumped.Variables = map[string]interface{}{"version": "2"}
+		if err := m.ExecuteTask(task, &bumped); err != nil {
+			t.Fatal(err)
+		}
+		if got := runs(); got != 2 {
+			t.Errorf("expected a new cache key to run the command again, ran %d times", got)
+		}
+	})
+}
+
+func containsChange(changes []string, change string) bool {
+	for _, c := range changes {
+		if c == change {
+			return true
+		}
+	}
+	return false
+}
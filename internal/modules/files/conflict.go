@@ -0,0 +1,149 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// on_conflict values of ensure_file, deciding what happens to a target that exists
+// with content apply did not write
+const (
+	ConflictOverwrite    = "overwrite"
+	ConflictKeep         = "keep"
+	ConflictPrompt       = "prompt"
+	ConflictMergeMarkers = "merge-markers"
+)
+
+// ConflictResolutions are the answers to an on_conflict prompt
+var ConflictResolutions = []string{ConflictOverwrite, ConflictKeep, ConflictMergeMarkers}
+
+const (
+	conflictMarkerLocal    = "<<<<<<< local"
+	conflictMarkerSplit    = "======="
+	conflictMarkerDotfiles = ">>>>>>> dotfiles"
+)
+
+// validateOnConflict validates the on_conflict option of ensure_file
+func validateOnConflict(config map[string]interface{}) error {
+	value, exists := config["on_conflict"]
+	if !exists {
+		return nil
+	}
+	onConflict, ok := modules.StringValue(value)
+	if !ok {
+		return fmt.Errorf("ensure_file 'on_conflict' must be a string")
+	}
+	for _, valid := range append([]string{ConflictPrompt}, ConflictResolutions...) {
+		if onConflict == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("ensure_file 'on_conflict' must be one of overwrite, keep, prompt, merge-markers")
+}
+
+// conflictResolution returns how local changes to the target of a task are
+// resolved. Prompts are answered by --assume when it is set.
+func conflictResolution(task *config.Task, ctx *modules.ExecutionContext) string {
+	onConflict, _ := modules.StringValue(task.Config["on_conflict"])
+	switch {
+	case onConflict == "":
+		return ConflictOverwrite
+	case onConflict == ConflictPrompt && ctx.AssumeConflict != "":
+		return ctx.AssumeConflict
+	default:
+		return onConflict
+	}
+}
+
+// planConflict describes how a target with local changes will be resolved. It
+// returns false when the plan has nothing left to add.
+func planConflict(plan *modules.TaskPlan, resolution, existing string) bool {
+	plan.Conflict = resolution
+	switch resolution {
+	case ConflictKeep:
+		plan.WillSkip = true
+		plan.SkipReason = "Kept local changes (on_conflict: keep)"
+		return false
+	case ConflictPrompt:
+		plan.Changes = append(plan.Changes, "Target has local changes, apply will ask what to do (on_conflict: prompt)")
+	case ConflictMergeMarkers:
+		if hasConflictMarkers(existing) {
+			plan.Changes = append(plan.Changes, "Target still has unresolved conflict markers, it needs attention")
+			return false
+		}
+		plan.Changes = append(plan.Changes, "Target has local changes, conflict markers will be written (on_conflict: merge-markers)")
+	default:
+		plan.Conflict = ""
+	}
+	return true
+}
+
+// resolveConflict decides what happens to a target whose content differs from the
+// desired content, asking the user when on_conflict is prompt
+func resolveConflict(task *config.Task, ctx *modules.ExecutionContext, path, existing, desired string) (string, error) {
+	resolution := conflictResolution(task, ctx)
+	if resolution != ConflictPrompt {
+		return resolution, nil
+	}
+	if ctx.Prompt == nil {
+		return "", fmt.Errorf("%s has local changes and apply cannot ask what to do, use --assume overwrite|keep|merge-markers", path)
+	}
+
+	fmt.Printf("   ⚠️  %s has local changes:\n", path)
+	for _, line := range utils.UnifiedDiff(existing, desired, diffOptions(ctx)) {
+		fmt.Printf("      %s\n", line)
+	}
+	return ctx.Prompt(fmt.Sprintf("What should happen to %s?", path), ConflictResolutions)
+}
+
+// hasConflictMarkers reports whether content still contains conflict markers
+// written by merge-markers
+func hasConflictMarkers(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, conflictMarkerLocal) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeMarkers combines local and desired content into one file with conflict
+// markers around the lines that differ, like git does for a merge conflict
+func mergeMarkers(local, desired string) string {
+	localLines := strings.SplitAfter(local, "\n")
+	desiredLines := strings.SplitAfter(desired, "\n")
+
+	prefix := 0
+	for prefix < len(localLines) && prefix < len(desiredLines) && localLines[prefix] == desiredLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(localLines)-prefix && suffix < len(desiredLines)-prefix &&
+		localLines[len(localLines)-1-suffix] == desiredLines[len(desiredLines)-1-suffix] {
+		suffix++
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Join(localLines[:prefix], ""))
+	b.WriteString(conflictMarkerLocal + "\n")
+	writeConflictSide(&b, localLines[prefix:len(localLines)-suffix])
+	b.WriteString(conflictMarkerSplit + "\n")
+	writeConflictSide(&b, desiredLines[prefix:len(desiredLines)-suffix])
+	b.WriteString(conflictMarkerDotfiles + "\n")
+	b.WriteString(strings.Join(localLines[len(localLines)-suffix:], ""))
+	return b.String()
+}
+
+// writeConflictSide writes one side of a conflict, ending it with a newline so
+// the next marker starts on its own line
+func writeConflictSide(b *strings.Builder, lines []string) {
+	side := strings.Join(lines, "")
+	b.WriteString(side)
+	if side != "" && !strings.HasSuffix(side, "\n") {
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,168 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestMergeMarkers(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    string
+		desired  string
+		expected string
+	}{
+		{
+			name:     "wraps differing lines only",
+			local:    "[user]\n  name = me\n  email = local@example.com\n[core]\n",
+			desired:  "[user]\n  name = me\n  email = dotfiles@example.com\n[core]\n",
+			expected: "[user]\n  name = me\n<<<<<<< local\n  email = local@example.com\n=======\n  email = dotfiles@example.com\n>>>>>>> dotfiles\n[core]\n",
+		},
+		{
+			name:     "added lines",
+			local:    "a\n",
+			desired:  "a\nb\n",
+			expected: "a\n<<<<<<< local\n=======\nb\n>>>>>>> dotfiles\n",
+		},
+		{
+			name:     "missing trailing newline",
+			local:    "a\nb",
+			desired:  "a\nc\n",
+			expected: "a\n<<<<<<< local\nb\n=======\nc\n>>>>>>> dotfiles\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergeMarkers(tt.local, tt.desired)
+			if merged != tt.expected {
+				t.Errorf("mergeMarkers() =\n%q\nwant\n%q", merged, tt.expected)
+			}
+			if !hasConflictMarkers(merged) {
+				t.Error("expected merged content to have conflict markers")
+			}
+		})
+	}
+}
+
+func TestEnsureFileOnConflict(t *testing.T) {
+	newTask := func(path, onConflict string) *config.Task {
+		return &config.Task{
+			ID:     "gitconfig",
+			Action: "ensure_file",
+			Config: map[string]interface{}{"path": path, "content": "desired\n", "on_conflict": onConflict},
+		}
+	}
+	writeLocal := func(t *testing.T) string {
+		path := filepath.Join(t.TempDir(), ".gitconfig")
+		if err := os.WriteFile(path, []byte("local edit\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	readFile := func(t *testing.T, path string) string {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	m := New()
+	if err := m.ValidateTask(newTask("x", "ask")); err == nil {
+		t.Error("expected invalid on_conflict to be rejected")
+	}
+
+	t.Run("keep", func(t *testing.T) {
+		path := writeLocal(t)
+		ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+		plan, err := m.PlanTask(newTask(path, ConflictKeep), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip || plan.Conflict != ConflictKeep {
+			t.Errorf("expected plan to keep local changes, got %+v", plan)
+		}
+
+		var outcome *modules.TaskOutcome
+		err = m.ExecuteTask(newTask(path, ConflictKeep), ctx)
+		if !errors.As(err, &outcome) || !outcome.Skipped {
+			t.Fatalf("expected skipped outcome, got %v", err)
+		}
+		if content := readFile(t, path); content != "local edit\n" {
+			t.Errorf("local changes were overwritten: %q", content)
+		}
+	})
+
+	t.Run("merge-markers", func(t *testing.T) {
+		path := writeLocal(t)
+		ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+		var outcome *modules.TaskOutcome
+		err := m.ExecuteTask(newTask(path, ConflictMergeMarkers), ctx)
+		if !errors.As(err, &outcome) || !outcome.NeedsAttention {
+			t.Fatalf("expected outcome needing attention, got %v", err)
+		}
+		expected := "<<<<<<< local\nlocal edit\n=======\ndesired\n>>>>>>> dotfiles\n"
+		if content := readFile(t, path); content != expected {
+			t.Errorf("content = %q, want %q", content, expected)
+		}
+
+		// Unresolved markers are never wrapped in another conflict
+		err = m.ExecuteTask(newTask(path, ConflictMergeMarkers), ctx)
+		if !errors.As(err, &outcome) || !outcome.NeedsAttention {
+			t.Fatalf("expected outcome needing attention, got %v", err)
+		}
+		if content := readFile(t, path); content != expected {
+			t.Errorf("conflict markers were rewritten: %q", content)
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		path := writeLocal(t)
+		ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+		if err := m.ExecuteTask(newTask(path, ConflictPrompt), ctx); err == nil {
+			t.Error("expected an error when nobody can be asked")
+		}
+
+		var asked []string
+		ctx.Prompt = func(question string, choices []string) (string, error) {
+			asked = append(asked, question)
+			return ConflictOverwrite, nil
+		}
+		if err := m.ExecuteTask(newTask(path, ConflictPrompt), ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(asked) != 1 {
+			t.Errorf("expected one prompt, got %v", asked)
+		}
+		if content := readFile(t, path); content != "desired\n" {
+			t.Errorf("content = %q, want overwritten", content)
+		}
+	})
+
+	t.Run("assume", func(t *testing.T) {
+		path := writeLocal(t)
+		ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}, AssumeConflict: ConflictKeep}
+
+		plan, err := m.PlanTask(newTask(path, ConflictPrompt), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip || plan.Conflict != ConflictKeep {
+			t.Errorf("expected --assume to answer the prompt in the plan, got %+v", plan)
+		}
+
+		var outcome *modules.TaskOutcome
+		if err := m.ExecuteTask(newTask(path, ConflictPrompt), ctx); !errors.As(err, &outcome) || !outcome.Skipped {
+			t.Fatalf("expected skipped outcome, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/backup"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// copyFileOptions holds the rendered configuration of a copy_file task
+type copyFileOptions struct {
+	Source string
+	Path   string
+	Mode   os.FileMode
+}
+
+// fileDigest is the size and SHA-256 hash of a file, what copy_file compares
+// files by
+type fileDigest struct {
+	Size int64
+	Hash string
+}
+
+// String returns the "1024 bytes, sha256 9f86d081884c" form plans show
+func (d *fileDigest) String() string {
+	hash := d.Hash
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	return fmt.Sprintf("%d bytes, sha256 %s", d.Size, hash)
+}
+
+// validateCopyFileTask validates copy_file task configuration
+func (m *FilesModule) validateCopyFileTask(config map[string]interface{}) error {
+	value, exists := config["source"]
+	if !exists {
+		return fmt.Errorf("copy_file task requires 'source' field")
+	}
+	if _, ok := modules.StringValue(value); !ok {
+		return fmt.Errorf("copy_file 'source' must be a string")
+	}
+	if err := modules.ValidateTargets("copy_file", config, "path", "paths"); err != nil {
+		return err
+	}
+
+	if mode, exists := config["mode"]; exists {
+		modeStr, ok := mode.(string)
+		if !ok {
+			return fmt.Errorf("copy_file 'mode' must be an octal string like \"0755\"")
+		}
+		if _, err := strconv.ParseUint(modeStr, 8, 32); err != nil {
+			return fmt.Errorf("copy_file 'mode' must be an octal string like \"0755\", got '%s'", modeStr)
+		}
+	}
+
+	for _, field := range []string{"executable", "backup"} {
+		if value, exists := config[field]; exists {
+			if _, ok := modules.BoolValue(value); !ok {
+				return fmt.Errorf("copy_file '%s' must be a boolean", field)
+			}
+		}
+	}
+
+	if err := validateOwnership("copy_file", config); err != nil {
+		return err
+	}
+	if err := modules.ValidateAsRoot("copy_file", config); err != nil {
+		return err
+	}
+	return validateWindowsACL("copy_file", config)
+}
+
+// parseCopyFileOptions renders the configuration of a copy_file task with a single
+// path, SplitTask gives a task for each of its paths
+func (m *FilesModule) parseCopyFileOptions(task *config.Task, ctx *modules.ExecutionContext) (*copyFileOptions, error) {
+	source, err := m.processField(task, "source", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process source template: %w", err)
+	}
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(ctx.BasePath, source)
+	}
+
+	paths, err := m.TaskTargets(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Default to 0644, executable adds the execute bits to the mode
+	mode := os.FileMode(0644)
+	if modeStr, ok := task.Config["mode"].(string); ok {
+		if parsedMode, err := strconv.ParseUint(modeStr, 8, 32); err == nil {
+			mode = os.FileMode(parsedMode)
+		}
+	}
+	if executable, _ := modules.BoolValue(task.Config["executable"]); executable {
+		mode |= 0111
+	}
+
+	return &copyFileOptions{Source: source, Path: paths[0], Mode: mode}, nil
+}
+
+// digest streams a file through SHA-256, so large files are never held in memory
+func (m *FilesModule) digest(path string) (*fileDigest, error) {
+	file, err := m.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDigest{Size: size, Hash: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// sameContent reports whether the file at path has the content of source. A file
+// of another size is not hashed.
+func (m *FilesModule) sameContent(source *fileDigest, path string) (bool, error) {
+	info, err := m.fs.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != source.Size {
+		return false, nil
+	}
+	target, err := m.digest(path)
+	if err != nil {
+		return false, err
+	}
+	return target.Hash == source.Hash, nil
+}
+
+// sourceDigest returns the digest of the source of a copy_file task
+func (m *FilesModule) sourceDigest(opts *copyFileOptions) (*fileDigest, error) {
+	info, err := m.fs.Stat(opts.Source)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("source file does not exist: %s", opts.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("source is a directory, use ensure_tree to copy directories: %s", opts.Source)
+	}
+	digest, err := m.digest(opts.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+	return digest, nil
+}
+
+// planCopyFile returns what copy_file would do. Files are compared by size and
+// hash, a plan never shows a diff of them.
+func (m *FilesModule) planCopyFile(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	opts, err := m.parseCopyFileOptions(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: fmt.Sprintf("Copy file: %s -> %s", opts.Source, opts.Path),
+		Changes:     []string{},
+	}
+
+	if !fileExists(m.fs, opts.Source) {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Source file does not exist: %s", opts.Source)
+		plan.SkipCode = modules.SkipMissingSource
+		return plan, nil
+	}
+	source, err := m.sourceDigest(opts)
+	if err != nil {
+		plan.WillSkip = true
+		plan.SkipReason = err.Error()
+		plan.SkipCode = modules.SkipError
+		return plan, nil
+	}
+
+	info, err := m.fs.Stat(opts.Path)
+	if os.IsNotExist(err) {
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Create file (%s, mode %04o)", source, opts.Mode))
+		if parentDir := filepath.Dir(opts.Path); !fileExists(m.fs, parentDir) {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Create parent directory %s", parentDir))
+		}
+		return plan, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("path is a directory: %s", opts.Path)
+	}
+
+	target, err := m.digest(opts.Path)
+	if err != nil {
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Failed to read existing file, will replace it: %v", err))
+		return plan, nil
+	}
+	if target.Size == source.Size && target.Hash == source.Hash {
+		if goos != "windows" && info.Mode().Perm() != opts.Mode {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Change mode from %04o to %04o", info.Mode().Perm(), opts.Mode))
+			return plan, nil
+		}
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("File exists with the same content (%s)", source)
+		return plan, nil
+	}
+
+	if m.shouldBackup(task, ctx) {
+		backupPath := backup.FileBackupPath(opts.Path, ctx.BackupDir, time.Now())
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Backup existing file to %s", backupPath))
+	}
+	plan.Changes = append(plan.Changes,
+		"Replace file",
+		fmt.Sprintf("  - %s", target),
+		fmt.Sprintf("  + %s", source))
+	return plan, nil
+}
+
+// executeCopyFile copies the source of a copy_file task to its path byte for byte,
+// without rendering it
+func (m *FilesModule) executeCopyFile(task *config.Task, ctx *modules.ExecutionContext) error {
+	opts, err := m.parseCopyFileOptions(task, ctx)
+	if err != nil {
+		return err
+	}
+	source, err := m.sourceDigest(opts)
+	if err != nil {
+		return err
+	}
+
+	targetExists := fileExists(m.fs, opts.Path)
+	if targetExists {
+		same, err := m.sameContent(source, opts.Path)
+		if err != nil {
+			return fmt.Errorf("failed to compare existing file: %w", err)
+		}
+		if same {
+			if ctx.Verbose {
+				fmt.Printf("File content unchanged: %s\n", opts.Path)
+			}
+			if goos != "windows" {
+				if err := m.fs.Chmod(opts.Path, opts.Mode); err != nil {
+					return err
+				}
+			}
+			return m.applyAttributes(task, ctx, opts.Path)
+		}
+
+		// Keep a copy of the file we are about to overwrite
+		if m.shouldBackup(task, ctx) {
+			backupPath, err := backup.BackupFile(opts.Path, ctx.BackupDir, time.Now(), backup.DefaultFileBackupKeep)
+			if err != nil {
+				return fmt.Errorf("failed to back up existing file: %w", err)
+			}
+			if ctx.Verbose {
+				fmt.Printf("Backed up existing file: %s -> %s\n", opts.Path, backupPath)
+			}
+		}
+	}
+
+	if ctx.Verbose {
+		if targetExists {
+			fmt.Printf("Replacing file: %s -> %s (%s, mode: %04o)\n", opts.Source, opts.Path, source, opts.Mode)
+		} else {
+			fmt.Printf("Copying file: %s -> %s (%s, mode: %04o)\n", opts.Source, opts.Path, source, opts.Mode)
+		}
+	}
+
+	created := missingDirs(m.fs, filepath.Dir(opts.Path))
+	if err := ensureDir(m.fs, filepath.Dir(opts.Path)); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := m.fs.CopyFileAtomic(opts.Source, opts.Path, opts.Mode); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", opts.Source, err)
+	}
+	if err := handBack(task, ctx, opts.Path, created); err != nil {
+		return err
+	}
+	return m.applyAttributes(task, ctx, opts.Path)
+}
+
+// checkCopyFileDrift compares the path of a copy_file task with its source
+func (m *FilesModule) checkCopyFileDrift(task *config.Task, ctx *modules.ExecutionContext) (*modules.DriftResult, error) {
+	opts, err := m.parseCopyFileOptions(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &modules.DriftResult{Path: opts.Path}
+	if !fileExists(m.fs, opts.Path) {
+		result.State = modules.DriftMissing
+		return result, nil
+	}
+	source, err := m.sourceDigest(opts)
+	if err != nil {
+		return result, err
+	}
+	same, err := m.sameContent(source, opts.Path)
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", opts.Path, err)
+	}
+	if same {
+		result.State = modules.DriftInSync
+	} else {
+		result.State = modules.DriftOutOfDate
+	}
+	return result, nil
+}
@@ -0,0 +1,242 @@
+package files
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func copyFileTask(taskConfig map[string]interface{}) *config.Task {
+	return &config.Task{ID: "test", Action: "copy_file", Config: taskConfig}
+}
+
+func TestCopyFile(t *testing.T) {
+	home := memHome(t)
+	path := filepath.Join(home, "bin", "tool")
+	// Looks like a template and has CRLF line endings, both must be kept
+	binary := "\x7fELF\x00\x01\xff\xfe{{ name }}\r\n{% if x %}\r\n\x00\x00"
+	// Same size, so only the hash tells them apart
+	changed := strings.Replace(binary, "name", "user", 1)
+
+	t.Run("CopiesByteForByte", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "tool"), binary, 0644)
+		task := copyFileTask(map[string]interface{}{"source": "files/tool", "path": path, "executable": true})
+		if err := m.ValidateTask(task); err != nil {
+			t.Fatal(err)
+		}
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"Create file (34 bytes, sha256 5fdeaefe9745, mode 0755)", "Create parent directory " + filepath.Dir(path)}
+		if strings.Join(plan.Changes, "\n") != strings.Join(want, "\n") {
+			t.Errorf("plan changes = %q, want %q", plan.Changes, want)
+		}
+
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := fsys.content(path); content != binary {
+			t.Errorf("content = %q, want the source as it is", content)
+		}
+		if info, _ := fsys.Stat(path); info.Mode().Perm() != 0755 {
+			t.Errorf("mode = %04o, want 0755", info.Mode().Perm())
+		}
+
+		plan, err = m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip || plan.SkipReason != "File exists with the same content (34 bytes, sha256 5fdeaefe9745)" {
+			t.Errorf("plan after apply = %+v, want the file in sync", plan)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if fsys.writes != 1 {
+			t.Errorf("wrote %d files, want the file copied once", fsys.writes)
+		}
+	})
+
+	t.Run("ReplaceShowsHashes", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "tool"), binary, 0644)
+		fsys.addFile(path, changed, 0644)
+		task := copyFileTask(map[string]interface{}{"source": "files/tool", "path": path})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Changes) != 3 || plan.Changes[0] != "Replace file" ||
+			!strings.HasPrefix(plan.Changes[1], "  - 34 bytes, sha256 ") ||
+			plan.Changes[2] != "  + 34 bytes, sha256 5fdeaefe9745" {
+			t.Errorf("plan changes = %q, want the sizes and hashes of both files", plan.Changes)
+		}
+
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := fsys.content(path); content != binary {
+			t.Errorf("content = %q, want the source", content)
+		}
+	})
+
+	t.Run("ModeOnly", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "tool"), binary, 0644)
+		fsys.addFile(path, binary, 0644)
+		task := copyFileTask(map[string]interface{}{"source": "files/tool", "path": path, "mode": "0700", "executable": true})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Changes) != 1 || plan.Changes[0] != "Change mode from 0644 to 0711" {
+			t.Errorf("plan changes = %q, want only the mode changed", plan.Changes)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if fsys.writes != 0 {
+			t.Errorf("wrote the file to change its mode, want only a chmod")
+		}
+		if info, _ := fsys.Stat(path); info.Mode().Perm() != 0711 {
+			t.Errorf("mode = %04o, want 0711", info.Mode().Perm())
+		}
+	})
+
+	t.Run("WindowsKeepsMode", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		setGOOS(t, "windows")
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "tool"), binary, 0644)
+		fsys.addFile(path, binary, 0644)
+		task := copyFileTask(map[string]interface{}{"source": "files/tool", "path": path, "mode": "0700"})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip {
+			t.Errorf("plan changes = %q, want no mode change on Windows", plan.Changes)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := fsys.Stat(path); fsys.writes != 0 || info.Mode().Perm() != 0644 {
+			t.Errorf("wrote %d files and set mode %04o, want the file left alone", fsys.writes, info.Mode().Perm())
+		}
+	})
+
+	t.Run("Paths", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "tool"), binary, 0644)
+		other := filepath.Join(home, "opt", "tool")
+		fsys.addFile(path, binary, 0644)
+		task := copyFileTask(map[string]interface{}{"source": "files/tool", "paths": []interface{}{path, other}})
+		if err := m.ValidateTask(task); err != nil {
+			t.Fatal(err)
+		}
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes := strings.Join(plan.Changes, "\n")
+		if plan.WillSkip || !strings.Contains(changes, "-> "+path+": skipped") || !strings.Contains(changes, "Create file (34 bytes") {
+			t.Errorf("plan changes = %q, want %s skipped and %s created", plan.Changes, path, other)
+		}
+
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := fsys.content(other); content != binary || fsys.writes != 1 {
+			t.Errorf("content of %s = %q after %d writes, want the source copied there only", other, content, fsys.writes)
+		}
+		targets, err := m.TaskTargets(task, ctx)
+		if err != nil || len(targets) != 2 || targets[1] != other {
+			t.Errorf("TaskTargets() = %v, %v, want both paths", targets, err)
+		}
+		result, err := m.CheckDrift(task, ctx)
+		if err != nil || result.State != modules.DriftInSync {
+			t.Errorf("drift = %+v, %v, want both paths in sync", result, err)
+		}
+	})
+
+	t.Run("MissingSource", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		task := copyFileTask(map[string]interface{}{"source": "files/missing", "path": path})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip || plan.SkipCode != modules.SkipMissingSource {
+			t.Errorf("plan = %+v, want it skipped for the missing source", plan)
+		}
+		if err := m.ExecuteTask(task, ctx); err == nil || !strings.Contains(err.Error(), "source file does not exist") {
+			t.Errorf("ExecuteTask() = %v, want the missing source", err)
+		}
+	})
+
+	t.Run("Drift", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "tool"), binary, 0644)
+		task := copyFileTask(map[string]interface{}{"source": "files/tool", "path": path})
+
+		for _, step := range []struct {
+			content string
+			want    modules.DriftState
+		}{
+			{"", modules.DriftMissing},
+			{binary, modules.DriftInSync},
+			{changed, modules.DriftOutOfDate},
+			{binary[:10], modules.DriftOutOfDate},
+		} {
+			if step.want != modules.DriftMissing {
+				fsys.addFile(path, step.content, 0644)
+			}
+			result, err := m.CheckDrift(task, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.State != step.want || result.Path != path {
+				t.Errorf("drift with %q = %+v, want %s", step.content, result, step.want)
+			}
+		}
+	})
+}
+
+func TestValidateCopyFile(t *testing.T) {
+	m := New()
+	if err := m.ValidateTask(copyFileTask(map[string]interface{}{"source": "files/font.ttf", "path": "~/.fonts/font.ttf", "mode": "0600", "executable": "false"})); err != nil {
+		t.Errorf("ValidateTask() = %v, want no error", err)
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"requires 'source'":              {"path": "~/bin/tool"},
+		"requires 'path' or 'paths'":     {"source": "files/tool"},
+		"'mode' must be an octal string": {"source": "files/tool", "path": "~/bin/tool", "mode": "rwx"},
+		"'executable' must be a boolean": {"source": "files/tool", "path": "~/bin/tool", "executable": "sometimes"},
+		"cannot be used together":        {"source": "files/tool", "path": "~/bin/tool", "paths": []interface{}{"~/opt/tool"}},
+		"must list at least one target":  {"source": "files/tool", "paths": []interface{}{}},
+	}
+	for want, taskConfig := range invalid {
+		err := m.ValidateTask(copyFileTask(taskConfig))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ValidateTask(%v) = %v, want an error containing %q", taskConfig, err, want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/backup"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+const (
+	// downloadTimeout limits a single content_url download including redirects
+	downloadTimeout = 2 * time.Minute
+
+	// maxDownloadRedirects is how many redirects a content_url may follow
+	maxDownloadRedirects = 10
+)
+
+// downloadCacheDir returns the directory content_url downloads are cached in
+func downloadCacheDir(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), "downloads")
+}
+
+// downloadCachePath returns the cache file of a URL
+func downloadCachePath(basePath, rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(downloadCacheDir(basePath), hex.EncodeToString(sum[:]))
+}
+
+// sha256Hex returns the hex encoded SHA-256 checksum of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// validateContentURL validates the content_url and sha256 fields of ensure_file
+func validateContentURL(config map[string]interface{}) error {
+	if rawURL, exists := config["content_url"]; exists {
+		urlStr, ok := modules.StringValue(rawURL)
+		if !ok {
+			return fmt.Errorf("ensure_file 'content_url' must be a string")
+		}
+		// Templated URLs are checked once they are rendered
+		if !strings.Contains(urlStr, "{{") {
+			if err := checkDownloadURL(urlStr); err != nil {
+				return err
+			}
+		}
+		if _, exists := config["render"]; exists {
+			return fmt.Errorf("ensure_file 'render' cannot be used with 'content_url', downloads are written as-is")
+		}
+	}
+
+	if checksum, exists := config["sha256"]; exists {
+		checksumStr, ok := modules.StringValue(checksum)
+		if !ok {
+			return fmt.Errorf("ensure_file 'sha256' must be a string")
+		}
+		if _, exists := config["content_url"]; !exists {
+			return fmt.Errorf("ensure_file 'sha256' requires 'content_url'")
+		}
+		if decoded, err := hex.DecodeString(checksumStr); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("ensure_file 'sha256' must be a 64 character hex checksum, got '%s'", checksumStr)
+		}
+	}
+
+	return nil
+}
+
+// checkDownloadURL makes sure a URL can be downloaded over HTTP(S)
+func checkDownloadURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("ensure_file 'content_url' is not a valid URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("ensure_file 'content_url' must be an http or https URL, got '%s'", rawURL)
+	}
+	return nil
+}
+
+// contentURL holds the rendered content_url and checksum of an ensure_file task
+type contentURL struct {
+	URL       string
+	SHA256    string // lowercase hex, empty when not pinned
+	CachePath string
+}
+
+// parseContentURL renders the content_url of a task
+func (m *FilesModule) parseContentURL(task *config.Task, ctx *modules.ExecutionContext) (*contentURL, error) {
+	rawURL, err := m.processField(task, "content_url", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process content_url template: %w", err)
+	}
+	if err := checkDownloadURL(rawURL); err != nil {
+		return nil, err
+	}
+
+	source := &contentURL{
+		URL:       rawURL,
+		CachePath: downloadCachePath(ctx.BasePath, rawURL),
+	}
+	if checksum, ok := modules.StringValue(task.Config["sha256"]); ok {
+		source.SHA256 = strings.ToLower(checksum)
+	}
+	return source, nil
+}
+
+// cached returns the cached download when it can be used without fetching: only
+// pinned downloads whose cached copy still matches the checksum are trusted
+func (s *contentURL) cached() ([]byte, bool) {
+	if s.SHA256 == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(s.CachePath)
+	if err != nil || sha256Hex(data) != s.SHA256 {
+		return nil, false
+	}
+	return data, true
+}
+
+// matchesFile reports whether the file at path already has the pinned checksum
+func (s *contentURL) matchesFile(fsys fileSystem, path string) bool {
+	if s.SHA256 == "" {
+		return false
+	}
+	data, err := fsys.ReadFile(path)
+	return err == nil && sha256Hex(data) == s.SHA256
+}
+
+// fetch returns the content of the URL, from the cache when possible
+func (s *contentURL) fetch(ctx *modules.ExecutionContext) ([]byte, error) {
+	if data, ok := s.cached(); ok {
+		return data, nil
+	}
+	if ctx.Offline {
+		return nil, fmt.Errorf("cannot download %s in offline mode and it is not cached", s.URL)
+	}
+
+	data, err := downloadURL(ctx.RunContext(), s.URL)
+	if err != nil {
+		return nil, err
+	}
+	if s.SHA256 != "" {
+		if actual := sha256Hex(data); actual != s.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", s.URL, s.SHA256, actual)
+		}
+	}
+
+	if err := writeDownloadCache(s.CachePath, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// downloadURL downloads a URL, following redirects, within downloadTimeout
+func downloadURL(ctx context.Context, rawURL string) ([]byte, error) {
+	client := &http.Client{
+		Timeout: downloadTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxDownloadRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxDownloadRedirects)
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", rawURL, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to download %s: server returned %s", rawURL, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	return data, nil
+}
+
+// writeDownloadCache stores a download in the cache, replacing any previous copy
+func writeDownloadCache(cachePath string, data []byte) error {
+	if err := utils.EnsureDir(filepath.Dir(cachePath)); err != nil {
+		return fmt.Errorf("failed to create download cache: %w", err)
+	}
+
+	// Write to a temporary file first so an interrupted write never leaves a
+	// truncated copy behind
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to write download cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write download cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write download cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		return fmt.Errorf("failed to write download cache: %w", err)
+	}
+	return nil
+}
+
+// planEnsureFileFromURL fills in the plan of an ensure_file task with content_url.
+// Nothing is downloaded while planning.
+func (m *FilesModule) planEnsureFileFromURL(task *config.Task, ctx *modules.ExecutionContext, path string, plan *modules.TaskPlan) (*modules.TaskPlan, error) {
+	source, err := m.parseContentURL(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan.Description = fmt.Sprintf("Ensure file exists from URL: %s -> %s", source.URL, path)
+
+	if source.matchesFile(m.fs, path) {
+		plan.WillSkip = true
+		plan.SkipReason = "File exists with correct content"
+		return plan, nil
+	}
+
+	data, cached := source.cached()
+	if !cached && ctx.Offline {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Offline and %s is not cached", source.URL)
+		plan.SkipCode = modules.SkipOffline
+		return plan, nil
+	}
+
+	targetExists := fileExists(m.fs, path)
+	if cached {
+		if existing, err := m.fs.ReadFile(path); err == nil && string(existing) == string(data) {
+			plan.WillSkip = true
+			plan.SkipReason = "File exists with correct content"
+			return plan, nil
+		}
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Download %s (cached)", source.URL))
+	} else {
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Download %s (not cached)", source.URL))
+	}
+
+	if targetExists {
+		existing, _ := m.fs.ReadFile(path)
+		if !planConflict(plan, conflictResolution(task, ctx), string(existing)) {
+			return plan, nil
+		}
+		if m.shouldBackup(task, ctx) {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Backup existing file to %s", backup.FileBackupPath(path, ctx.BackupDir, time.Now())))
+		}
+		if plan.Conflict == "" {
+			plan.Changes = append(plan.Changes, "Update file content")
+		}
+	} else {
+		plan.Changes = append(plan.Changes, "Create file")
+		if parentDir := filepath.Dir(path); !fileExists(m.fs, parentDir) {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Create parent directory %s", parentDir))
+		}
+	}
+	if source.SHA256 != "" {
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Verify sha256 %s", source.SHA256))
+	}
+
+	return plan, nil
+}
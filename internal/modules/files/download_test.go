@@ -0,0 +1,193 @@
+package files
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// newDownloadServer serves body at /file and redirects /old to it, counting file requests
+func newDownloadServer(t *testing.T, body string) (*httptest.Server, *int32) {
+	t.Helper()
+	var hits int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(body))
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file", http.StatusFound)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func contentURLTask(path, url, checksum string) *config.Task {
+	cfg := map[string]interface{}{"path": path, "content_url": url}
+	if checksum != "" {
+		cfg["sha256"] = checksum
+	}
+	return &config.Task{ID: "download", Action: "ensure_file", Config: cfg}
+}
+
+func TestEnsureFileContentURL(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+	body := "downloaded content\n"
+	checksum := sha256Hex([]byte(body))
+	server, hits := newDownloadServer(t, body)
+
+	tmpDir := t.TempDir()
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+
+	t.Run("DownloadsAndCaches", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "first")
+		task := contentURLTask(path, server.URL+"/file", checksum)
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Changes) == 0 || plan.Changes[0] != "Download "+server.URL+"/file (not cached)" {
+			t.Errorf("unexpected plan changes: %v", plan.Changes)
+		}
+		if atomic.LoadInt32(hits) != 0 {
+			t.Fatal("planning must not download")
+		}
+
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := os.ReadFile(path); string(content) != body {
+			t.Errorf("content = %q, want %q", content, body)
+		}
+		if !strings.HasPrefix(downloadCachePath(tmpDir, server.URL+"/file"), filepath.Join(stateHome, "dotfiles")) {
+			t.Error("cache must live in the state directory of the dotfiles repository")
+		}
+
+		// A second file from the same URL is served from the cache
+		other := filepath.Join(tmpDir, "second")
+		task = contentURLTask(other, server.URL+"/file", checksum)
+		plan, err = m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Changes[0] != "Download "+server.URL+"/file (cached)" {
+			t.Errorf("expected cached download in plan, got %v", plan.Changes)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(hits); got != 1 {
+			t.Errorf("expected 1 download, got %d", got)
+		}
+
+		// Applying again is a no-op
+		plan, err = m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip {
+			t.Errorf("expected plan to skip unchanged file, got %v", plan.Changes)
+		}
+	})
+
+	t.Run("FollowsRedirects", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "redirected")
+		if err := m.ExecuteTask(contentURLTask(path, server.URL+"/old", ""), ctx); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := os.ReadFile(path); string(content) != body {
+			t.Errorf("content = %q, want %q", content, body)
+		}
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "mismatch")
+		err := m.ExecuteTask(contentURLTask(path, server.URL+"/old", strings.Repeat("0", 64)), ctx)
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("expected checksum mismatch, got %v", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("file must not be written on a checksum mismatch")
+		}
+	})
+
+	t.Run("HTTPError", func(t *testing.T) {
+		err := m.ExecuteTask(contentURLTask(filepath.Join(tmpDir, "missing"), server.URL+"/missing", ""), ctx)
+		if err == nil || !strings.Contains(err.Error(), "404") {
+			t.Fatalf("expected 404 error, got %v", err)
+		}
+	})
+
+	t.Run("Offline", func(t *testing.T) {
+		offline := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}, Offline: true}
+		path := filepath.Join(tmpDir, "offline")
+
+		// Uncached downloads are skipped when planning and fail when executed
+		task := contentURLTask(path, server.URL+"/old?uncached", checksum)
+		plan, err := m.PlanTask(task, offline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip || !strings.HasPrefix(plan.SkipReason, "Offline") {
+			t.Errorf("expected offline skip, got %+v", plan)
+		}
+		if err := m.ExecuteTask(task, offline); err == nil || !strings.Contains(err.Error(), "offline mode") {
+			t.Errorf("expected offline error, got %v", err)
+		}
+
+		// Cached downloads still work
+		task = contentURLTask(path, server.URL+"/file", checksum)
+		before := atomic.LoadInt32(hits)
+		if err := m.ExecuteTask(task, offline); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(hits) != before {
+			t.Error("offline apply must not download")
+		}
+	})
+}
+
+func TestValidateContentURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		errMsg string
+	}{
+		{"Valid", map[string]interface{}{"content_url": "https://example.com/f", "sha256": strings.Repeat("a", 64)}, ""},
+		{"Templated", map[string]interface{}{"content_url": "{{ .url }}"}, ""},
+		{"WithContent", map[string]interface{}{"content_url": "https://example.com/f", "content": "x"}, "cannot be combined"},
+		{"Scheme", map[string]interface{}{"content_url": "ftp://example.com/f"}, "http or https"},
+		{"Render", map[string]interface{}{"content_url": "https://example.com/f", "render": true}, "render"},
+		{"BadChecksum", map[string]interface{}{"content_url": "https://example.com/f", "sha256": "abc"}, "64 character"},
+		{"ChecksumWithoutURL", map[string]interface{}{"content": "x", "sha256": strings.Repeat("a", 64)}, "requires 'content_url'"},
+	}
+
+	m := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config["path"] = "/tmp/file"
+			err := m.ValidateTask(&config.Task{Action: "ensure_file", Config: tt.config})
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// setGOOS replaces the operating system directory modes are applied on until the test ends
+func setGOOS(t *testing.T, os string) {
+	previous := goos
+	goos = os
+	t.Cleanup(func() { goos = previous })
+}
+
+func ensureDirTask(taskConfig map[string]interface{}) *config.Task {
+	return &config.Task{ID: "test", Action: "ensure_dir", Config: taskConfig}
+}
+
+func TestEnsureDirRecursiveMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directory modes are not applied on Windows")
+	}
+	tmpDir := t.TempDir()
+	parent := filepath.Join(tmpDir, "home")
+	if err := os.Mkdir(parent, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(parent, 0750); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(parent, ".ssh", "keys", "work")
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+	task := ensureDirTask(map[string]interface{}{"path": path, "mode": "0700", "recursive_mode": true})
+
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0] != "Create 3 directories (mode: 0700)" {
+		t.Errorf("plan changes = %v, want to create 3 directories", plan.Changes)
+	}
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Join(parent, ".ssh"), filepath.Join(parent, ".ssh", "keys"), path} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0700 {
+			t.Errorf("mode of created %s = %04o, want 0700", dir, info.Mode().Perm())
+		}
+	}
+	if info, _ := os.Stat(parent); info.Mode().Perm() != 0750 {
+		t.Errorf("mode of existing parent = %04o, want it left at 0750", info.Mode().Perm())
+	}
+
+	// Without recursive_mode only the directory itself gets the mode, the parent
+	// keeps what MkdirAll gave it
+	reference := filepath.Join(tmpDir, "reference")
+	if err := os.Mkdir(reference, 0777); err != nil {
+		t.Fatal(err)
+	}
+	referenceInfo, err := os.Stat(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(parent, ".local", "bin")
+	task = ensureDirTask(map[string]interface{}{"path": path, "mode": "0777"})
+	if plan, err = m.PlanTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0] != "Create 2 directories" {
+		t.Errorf("plan changes = %v, want to create 2 directories", plan.Changes)
+	}
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0777 {
+		t.Errorf("mode of %s = %04o, want 0777", path, info.Mode().Perm())
+	}
+	if info, _ := os.Stat(filepath.Dir(path)); info.Mode().Perm() != referenceInfo.Mode().Perm() {
+		t.Errorf("mode of created parent = %04o, want %04o as created without chmod", info.Mode().Perm(), referenceInfo.Mode().Perm())
+	}
+}
+
+func TestEnsureDirRecursiveModeOnWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("compares modes, which Windows does not have")
+	}
+	setGOOS(t, "windows")
+	tmpDir := t.TempDir()
+	existing := filepath.Join(tmpDir, "existing")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// What MkdirAll gives a directory without a chmod after it
+	reference := filepath.Join(tmpDir, "reference")
+	if err := os.Mkdir(reference, 0777); err != nil {
+		t.Fatal(err)
+	}
+	referenceInfo, err := os.Stat(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+	path := filepath.Join(existing, "a", "b")
+	task := ensureDirTask(map[string]interface{}{"path": path, "mode": "0777", "recursive_mode": true})
+
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0] != "Create 2 directories" {
+		t.Errorf("plan changes = %v, want to create 2 directories without a mode", plan.Changes)
+	}
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Dir(path), path} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != referenceInfo.Mode().Perm() {
+			t.Errorf("mode of %s = %04o, want %04o as created without chmod", dir, info.Mode().Perm(), referenceInfo.Mode().Perm())
+		}
+	}
+
+	// An existing directory is left as it is
+	task = ensureDirTask(map[string]interface{}{"path": existing, "mode": "0700"})
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(existing); info.Mode().Perm() != 0755 {
+		t.Errorf("mode of existing directory = %04o, want it left at 0755", info.Mode().Perm())
+	}
+}
+
+func TestEnsureDirFileInTheWay(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "nvim")
+	if err := os.WriteFile(path, []byte("not a directory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	backupDir := filepath.Join(tmpDir, "backups")
+	ctx := &modules.ExecutionContext{
+		BasePath:      tmpDir,
+		Variables:     map[string]interface{}{},
+		CreateBackups: true,
+		BackupDir:     backupDir,
+	}
+
+	// Without force the file stays and the error tells how to replace it
+	task := ensureDirTask(map[string]interface{}{"path": path})
+	if _, err := m.PlanTask(task, ctx); err == nil || !strings.Contains(err.Error(), "force: true") {
+		t.Errorf("PlanTask() error = %v, want a hint to set force", err)
+	}
+	err := m.ExecuteTask(task, ctx)
+	if err == nil || !strings.Contains(err.Error(), "force: true") || !modules.IsPermanent(err) {
+		t.Errorf("ExecuteTask() error = %v, want a permanent error with a hint to set force", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		t.Fatalf("file was replaced without force")
+	}
+
+	// With force the file is backed up and replaced by the directory
+	task = ensureDirTask(map[string]interface{}{"path": path, "force": true})
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 2 || !strings.HasPrefix(plan.Changes[0], "Backup existing file to ") || plan.Changes[1] != "Remove existing file and create directory" {
+		t.Errorf("plan changes = %v, want a backup and the removal", plan.Changes)
+	}
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		t.Errorf("%s is not a directory after apply", path)
+	}
+	found := false
+	filepath.Walk(backupDir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			if content, _ := os.ReadFile(p); string(content) == "not a directory" {
+				found = true
+			}
+		}
+		return nil
+	})
+	if !found {
+		t.Errorf("no backup of the replaced file in %s", backupDir)
+	}
+}
+
+func TestValidateEnsureDirOptions(t *testing.T) {
+	m := New()
+	for _, option := range []string{"recursive_mode", "force"} {
+		task := ensureDirTask(map[string]interface{}{"path": "/tmp/x", option: "yes"})
+		if err := m.ValidateTask(task); err == nil || !strings.Contains(err.Error(), option) {
+			t.Errorf("ValidateTask() with %s: \"yes\" error = %v, want an error naming the option", option, err)
+		}
+	}
+}
@@ -1,28 +1,36 @@
 package files
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/backup"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
 	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 )
 
+// goos is the operating system directory modes are applied on, replaced by tests
+var goos = runtime.GOOS
+
 // FilesModule handles file and directory operations
 type FilesModule struct {
 	templateEngine *templating.TemplatingEngine
+	fs             fileSystem // Targets of ensure_file and ensure_dir are read and written with it
 }
 
 // New creates a new files module
 func New() *FilesModule {
 	return &FilesModule{
 		templateEngine: templating.NewTemplatingEngine("."),
+		fs:             osFileSystem{},
 	}
 }
 
@@ -33,7 +41,7 @@ func (m *FilesModule) Name() string {
 
 // ActionKeys returns the action keys this module handles
 func (m *FilesModule) ActionKeys() []string {
-	return []string{"ensure_dir", "ensure_file"}
+	return []string{"ensure_dir", "ensure_file", "ensure_tree", "copy_file", "line_in_file", "block_in_file", "merge_json", "merge_yaml"}
 }
 
 // ValidateTask validates a file task configuration
@@ -43,6 +51,16 @@ func (m *FilesModule) ValidateTask(task *config.Task) error {
 		return m.validateEnsureDirTask(task.Config)
 	case "ensure_file":
 		return m.validateEnsureFileTask(task.Config)
+	case "ensure_tree":
+		return m.validateEnsureTreeTask(task.Config)
+	case "copy_file":
+		return m.validateCopyFileTask(task.Config)
+	case "line_in_file":
+		return m.validateLineInFileTask(task.Config)
+	case "block_in_file":
+		return m.validateBlockInFileTask(task.Config)
+	case "merge_json", "merge_yaml":
+		return m.validateMergeDocTask(task.Action, task.Config)
 	default:
 		return fmt.Errorf("files module does not handle action '%s'", task.Action)
 	}
@@ -58,7 +76,19 @@ func (m *FilesModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionConte
 	case "ensure_dir":
 		return m.executeEnsureDir(task, ctx)
 	case "ensure_file":
-		return m.executeEnsureFile(task, ctx)
+		return m.executeEnsureFileTargets(task, ctx)
+	case "ensure_tree":
+		return m.executeEnsureTree(task, ctx)
+	case "copy_file":
+		return modules.ExecuteTargets(task, m.SplitTask(task), m.targetPath(ctx), func(target *config.Task) error {
+			return m.executeCopyFile(target, ctx)
+		})
+	case "line_in_file":
+		return m.executeLineInFile(task, ctx)
+	case "block_in_file":
+		return m.executeBlockInFile(task, ctx)
+	case "merge_json", "merge_yaml":
+		return m.executeMergeDoc(task, ctx)
 	default:
 		return fmt.Errorf("files module does not handle action '%s'", task.Action)
 	}
@@ -68,9 +98,35 @@ func (m *FilesModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionConte
 func (m *FilesModule) PlanTask(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
 	switch task.Action {
 	case "ensure_dir":
-		return m.planEnsureDir(task, ctx)
+		plan, err := m.planEnsureDir(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return m.planAttributes(task, ctx, plan)
 	case "ensure_file":
-		return m.planEnsureFile(task, ctx)
+		return modules.PlanTargets(task, m.SplitTask(task), func(target *config.Task) (*modules.TaskPlan, error) {
+			plan, err := m.planEnsureFile(target, ctx)
+			if err != nil {
+				return nil, err
+			}
+			return m.planAttributes(target, ctx, plan)
+		})
+	case "ensure_tree":
+		return m.planEnsureTree(task, ctx)
+	case "copy_file":
+		return modules.PlanTargets(task, m.SplitTask(task), func(target *config.Task) (*modules.TaskPlan, error) {
+			plan, err := m.planCopyFile(target, ctx)
+			if err != nil {
+				return nil, err
+			}
+			return m.planAttributes(target, ctx, plan)
+		})
+	case "line_in_file":
+		return m.planLineInFile(task, ctx)
+	case "block_in_file":
+		return m.planBlockInFile(task, ctx)
+	case "merge_json", "merge_yaml":
+		return m.planMergeDoc(task, ctx)
 	default:
 		return nil, fmt.Errorf("files module does not handle action '%s'", task.Action)
 	}
@@ -81,34 +137,45 @@ func (m *FilesModule) validateEnsureDirTask(config map[string]interface{}) error
 	if _, exists := config["path"]; !exists {
 		return fmt.Errorf("ensure_dir task requires 'path' field")
 	}
-	if _, ok := config["path"].(string); !ok {
+	if _, ok := modules.StringValue(config["path"]); !ok {
 		return fmt.Errorf("ensure_dir 'path' must be a string")
 	}
-	return nil
+	if err := validateOwnership("ensure_dir", config); err != nil {
+		return err
+	}
+	if err := modules.ValidateAsRoot("ensure_dir", config); err != nil {
+		return err
+	}
+	for _, option := range []string{"recursive_mode", "force"} {
+		if value, exists := config[option]; exists {
+			if _, ok := modules.BoolValue(value); !ok {
+				return fmt.Errorf("ensure_dir '%s' must be a boolean", option)
+			}
+		}
+	}
+	return validateWindowsACL("ensure_dir", config)
 }
 
 // validateEnsureFileTask validates ensure_file task configuration
 func (m *FilesModule) validateEnsureFileTask(config map[string]interface{}) error {
-	if _, exists := config["path"]; !exists {
-		return fmt.Errorf("ensure_file task requires 'path' field")
-	}
-	if _, ok := config["path"].(string); !ok {
-		return fmt.Errorf("ensure_file 'path' must be a string")
+	if err := modules.ValidateTargets("ensure_file", config, "path", "paths"); err != nil {
+		return err
 	}
 
-	// Check that content and content_source are mutually exclusive
+	// Check that content, content_source and content_url are mutually exclusive
 	hasContent := false
 	hasContentSource := false
+	_, hasContentURL := config["content_url"]
 
 	if content, exists := config["content"]; exists {
-		if _, ok := content.(string); !ok {
+		if _, ok := modules.StringValue(content); !ok {
 			return fmt.Errorf("ensure_file 'content' must be a string")
 		}
 		hasContent = true
 	}
 
 	if contentSource, exists := config["content_source"]; exists {
-		if _, ok := contentSource.(string); !ok {
+		if _, ok := modules.StringValue(contentSource); !ok {
 			return fmt.Errorf("ensure_file 'content_source' must be a string")
 		}
 		hasContentSource = true
@@ -117,14 +184,57 @@ func (m *FilesModule) validateEnsureFileTask(config map[string]interface{}) erro
 	if hasContent && hasContentSource {
 		return fmt.Errorf("ensure_file 'content' and 'content_source' are mutually exclusive")
 	}
+	if hasContentURL && (hasContent || hasContentSource) {
+		return fmt.Errorf("ensure_file 'content_url' cannot be combined with 'content' or 'content_source'")
+	}
+	if err := validateContentURL(config); err != nil {
+		return err
+	}
+	if err := validateContentCommand(config); err != nil {
+		return err
+	}
+	if err := validateOnConflict(config); err != nil {
+		return err
+	}
+	if err := validateContentNormalization(config); err != nil {
+		return err
+	}
+	if err := validateOwnership("ensure_file", config); err != nil {
+		return err
+	}
+	if err := modules.ValidateAsRoot("ensure_file", config); err != nil {
+		return err
+	}
+	if err := validateWindowsACL("ensure_file", config); err != nil {
+		return err
+	}
 
 	// Validate render parameter if present
 	if render, exists := config["render"]; exists {
-		if _, ok := render.(bool); !ok {
+		if _, ok := modules.BoolValue(render); !ok {
 			return fmt.Errorf("ensure_file 'render' must be a boolean")
 		}
 	}
 
+	// Validate engine parameter if present
+	if engine, exists := config["engine"]; exists {
+		switch engine {
+		case "default", "pongo2":
+		default:
+			return fmt.Errorf("ensure_file 'engine' must be 'default' or 'pongo2'")
+		}
+		if render, _ := modules.BoolValue(config["render"]); !render || !hasContentSource {
+			return fmt.Errorf("ensure_file 'engine' requires 'content_source' with 'render: true'")
+		}
+	}
+
+	// Validate backup parameter if present
+	if backupOpt, exists := config["backup"]; exists {
+		if _, ok := modules.BoolValue(backupOpt); !ok {
+			return fmt.Errorf("ensure_file 'backup' must be a boolean")
+		}
+	}
+
 	return nil
 }
 
@@ -133,7 +243,7 @@ func (m *FilesModule) validateEnsureFileTask(config map[string]interface{}) erro
 // executeEnsureDir ensures a directory exists with proper permissions
 func (m *FilesModule) executeEnsureDir(task *config.Task, ctx *modules.ExecutionContext) error {
 	// Process template in path
-	path, err := m.processTemplate(task.Config["path"].(string), ctx.Variables)
+	path, err := m.processField(task, "path", ctx.Variables)
 	if err != nil {
 		return fmt.Errorf("failed to process path template: %w", err)
 	}
@@ -153,32 +263,31 @@ func (m *FilesModule) executeEnsureDir(task *config.Task, ctx *modules.Execution
 			}
 		}
 	}
+	recursiveMode, _ := modules.BoolValue(task.Config["recursive_mode"])
 
 	// Check if directory already exists
-	if stat, err := os.Stat(path); err == nil {
-		if stat.IsDir() {
+	if stat, err := m.fs.Stat(path); err == nil {
+		if !stat.IsDir() {
+			if err := m.removeFileForDir(task, ctx, path); err != nil {
+				return err
+			}
+		} else if goos == "windows" {
 			// On Windows, we just check if directory exists
-			// On Unix, we also check permissions
-			if runtime.GOOS == "windows" {
-				if ctx.Verbose {
-					fmt.Printf("Directory already exists: %s\n", path)
-				}
-				return nil // Nothing to do on Windows
-			} else {
-				// Unix-like systems: check permissions
-				currentMode := stat.Mode().Perm()
-				if currentMode == mode {
-					if ctx.Verbose {
-						fmt.Printf("Directory already exists with correct permissions: %s (mode: %04o)\n", path, mode)
-					}
-					return nil // Nothing to do
-				}
+			if ctx.Verbose {
+				fmt.Printf("Directory already exists: %s\n", path)
+			}
+			return m.applyAttributes(task, ctx, path) // Ownership only warns on Windows
+		} else if stat.Mode().Perm() == mode {
+			// Unix-like systems: check permissions
+			if ctx.Verbose {
+				fmt.Printf("Directory already exists with correct permissions: %s (mode: %04o)\n", path, mode)
 			}
+			return m.applyAttributes(task, ctx, path)
 		}
 	}
 
 	if ctx.Verbose {
-		if runtime.GOOS == "windows" {
+		if goos == "windows" {
 			fmt.Printf("Ensuring directory exists: %s\n", path)
 		} else {
 			fmt.Printf("Ensuring directory exists: %s (mode: %04o)\n", path, mode)
@@ -186,24 +295,104 @@ func (m *FilesModule) executeEnsureDir(task *config.Task, ctx *modules.Execution
 	}
 
 	// Create directory with proper permissions
-	if err := os.MkdirAll(path, mode); err != nil {
+	created := missingDirs(m.fs, path)
+	if err := m.fs.MkdirAll(path, mode); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
+	if ctx.Verbose && len(created) > 1 {
+		fmt.Printf("Created %d directories: %s\n", len(created), strings.Join(created, ", "))
+	}
+
+	// Only set permissions on Unix-like systems. MkdirAll gives the directories it
+	// creates the mode minus the umask, recursive_mode gives every one of them the
+	// mode, directories that already existed keep theirs.
+	if goos != "windows" {
+		chmod := []string{path}
+		if recursiveMode && len(created) > 0 {
+			chmod = created
+		}
+		for _, dir := range chmod {
+			if err := m.fs.Chmod(dir, mode); err != nil {
+				return fmt.Errorf("failed to set directory permissions: %w", err)
+			}
+		}
+	}
 
-	// Only set permissions on Unix-like systems
-	if runtime.GOOS != "windows" {
-		if err := os.Chmod(path, mode); err != nil {
-			return fmt.Errorf("failed to set directory permissions: %w", err)
+	if len(created) > 0 {
+		if err := handBack(task, ctx, path, created[:len(created)-1]); err != nil {
+			return err
 		}
 	}
+	return m.applyAttributes(task, ctx, path)
+}
 
+// removeFileForDir removes the file at the path of an ensure_dir task so the
+// directory can be created, backing it up first like ensure_file does. Without
+// force the task fails instead.
+func (m *FilesModule) removeFileForDir(task *config.Task, ctx *modules.ExecutionContext, path string) error {
+	if force, _ := modules.BoolValue(task.Config["force"]); !force {
+		return modules.Permanent(fmt.Errorf("%s exists and is not a directory, set force: true to replace it with a directory", path))
+	}
+	if m.shouldBackup(task, ctx) {
+		backupPath, err := backup.BackupFile(path, ctx.BackupDir, time.Now(), backup.DefaultFileBackupKeep)
+		if err != nil {
+			return fmt.Errorf("failed to back up existing file: %w", err)
+		}
+		if ctx.Verbose {
+			fmt.Printf("Backed up existing file: %s -> %s\n", path, backupPath)
+		}
+	}
+	if ctx.Verbose {
+		fmt.Printf("Removing file in the way of directory: %s\n", path)
+	}
+	if err := m.fs.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove existing file: %w", err)
+	}
 	return nil
 }
 
-// executeEnsureFile ensures a file exists with optional content
-func (m *FilesModule) executeEnsureFile(task *config.Task, ctx *modules.ExecutionContext) error {
+// renderedContent is the content of an ensure_file task, rendered, downloaded or
+// generated for its first path and written to its other paths as it is
+type renderedContent struct {
+	content string
+	ok      bool
+}
+
+// SplitTask returns a task for every path an ensure_file or copy_file task lists
+// in paths
+func (m *FilesModule) SplitTask(task *config.Task) []*config.Task {
+	if task.Action != "ensure_file" && task.Action != "copy_file" {
+		return []*config.Task{task}
+	}
+	return modules.TargetTasks(task, "path", "paths")
+}
+
+// executeEnsureFileTargets ensures the file of an ensure_file task at every path
+// it lists, getting its content only once
+func (m *FilesModule) executeEnsureFileTargets(task *config.Task, ctx *modules.ExecutionContext) error {
+	rendered := &renderedContent{}
+	return modules.ExecuteTargets(task, m.SplitTask(task), m.targetPath(ctx), func(target *config.Task) error {
+		return m.executeEnsureFile(target, ctx, rendered)
+	})
+}
+
+// targetPath returns a function giving the path of a task for a single target,
+// to name it in messages
+func (m *FilesModule) targetPath(ctx *modules.ExecutionContext) func(target *config.Task) string {
+	return func(target *config.Task) string {
+		paths, err := m.TaskTargets(target, ctx)
+		if err != nil || len(paths) == 0 {
+			return fmt.Sprint(target.Config["path"])
+		}
+		return paths[0]
+	}
+}
+
+// executeEnsureFile ensures a file exists with optional content, the content in
+// rendered when another path of the task got it already
+func (m *FilesModule) executeEnsureFile(task *config.Task, ctx *modules.ExecutionContext, rendered *renderedContent) error {
 	// Process template in path
-	path, err := m.processTemplate(task.Config["path"].(string), ctx.Variables)
+	path, err := m.processField(task, "path", ctx.Variables)
 	if err != nil {
 		return fmt.Errorf("failed to process path template: %w", err)
 	}
@@ -225,16 +414,52 @@ func (m *FilesModule) executeEnsureFile(task *config.Task, ctx *modules.Executio
 	}
 
 	// Ensure parent directory exists
-	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+	created := missingDirs(m.fs, filepath.Dir(path))
+	if err := ensureDir(m.fs, filepath.Dir(path)); err != nil {
 		return fmt.Errorf("failed to create parent directory: %w", err)
 	}
 
-	// Get content from either inline content or content_source
-	content := ""
+	// Get content from content_url, content_source or inline content, unless it was
+	// already rendered for another path of the task
+	content := rendered.content
 
-	if contentSourceStr, exists := task.Config["content_source"]; exists {
+	if rendered.ok {
+		if _, exists := task.Config["content_command"]; exists {
+			// The next apply reuses the output for this path as well
+			if err := m.recordCommandOutput(task, ctx, path, content); err != nil {
+				return err
+			}
+		}
+	} else if _, exists := task.Config["content_url"]; exists {
+		source, err := m.parseContentURL(task, ctx)
+		if err != nil {
+			return err
+		}
+
+		// A pinned file that is already in place needs no download
+		if source.matchesFile(m.fs, path) {
+			if ctx.Verbose {
+				fmt.Printf("File content unchanged: %s\n", path)
+			}
+			if err := m.fs.Chmod(path, mode); err != nil {
+				return err
+			}
+			return m.applyAttributes(task, ctx, path)
+		}
+
+		data, err := source.fetch(ctx)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	} else if _, exists := task.Config["content_command"]; exists {
+		content, err = m.commandContent(task, ctx, path, false)
+		if err != nil {
+			return err
+		}
+	} else if _, exists := task.Config["content_source"]; exists {
 		// Read content from source file
-		contentSourcePath, err := m.processTemplate(contentSourceStr.(string), ctx.Variables)
+		contentSourcePath, err := m.processField(task, "content_source", ctx.Variables)
 		if err != nil {
 			return fmt.Errorf("failed to process content_source template: %w", err)
 		}
@@ -245,42 +470,46 @@ func (m *FilesModule) executeEnsureFile(task *config.Task, ctx *modules.Executio
 		}
 
 		// Check if source file exists
-		if !utils.FileExists(contentSourcePath) {
+		if !fileExists(m.fs, contentSourcePath) {
 			return fmt.Errorf("content source file does not exist: %s", contentSourcePath)
 		}
 
 		// Read the source file
-		contentBytes, err := os.ReadFile(contentSourcePath)
+		contentBytes, err := m.fs.ReadFile(contentSourcePath)
 		if err != nil {
 			return fmt.Errorf("failed to read content source file: %w", err)
 		}
 		content = string(contentBytes)
 
 		// Check if we should render the content as a template
-		if render, exists := task.Config["render"]; exists && render.(bool) {
-			content, err = m.processTemplateWithPathConversion(content, ctx.Variables, false)
+		if render, _ := modules.BoolValue(task.Config["render"]); render {
+			content, err = m.renderContentSource(task, ctx, contentSourcePath, content)
 			if err != nil {
-				return fmt.Errorf("failed to render content template: %w", err)
+				return fmt.Errorf("failed to render content template %s: %w", contentSourcePath, err)
 			}
 		}
+		content = m.normalizeContent(task, content)
 	} else if contentStr, exists := task.Config["content"]; exists {
 		// Use inline content (always process as template for backward compatibility)
-		if contentString, ok := contentStr.(string); ok {
+		if contentString, ok := modules.StringValue(contentStr); ok {
 			content, err = m.processTemplateWithPathConversion(contentString, ctx.Variables, false)
 			if err != nil {
-				return fmt.Errorf("failed to process content template: %w", err)
+				return fmt.Errorf("failed to process content template for %s: %w", path, err)
 			}
+			content = m.normalizeContent(task, content)
 		}
 	}
 	// If neither content nor content_source is specified, content remains empty
+	rendered.content, rendered.ok = content, true
 
 	// Check if file already exists and compare content
-	fileExists := utils.FileExists(path)
+	fileExists := fileExists(m.fs, path)
 	needsUpdate := true
+	var outcome *modules.TaskOutcome
 
 	if fileExists {
 		// Read existing content
-		existingContent, err := os.ReadFile(path)
+		existingContent, err := m.fs.ReadFile(path)
 		if err != nil {
 			return fmt.Errorf("failed to read existing file: %w", err)
 		}
@@ -291,8 +520,27 @@ func (m *FilesModule) executeEnsureFile(task *config.Task, ctx *modules.Executio
 			if ctx.Verbose {
 				fmt.Printf("File content unchanged: %s\n", path)
 			}
-			// Just ensure permissions are correct
-			return os.Chmod(path, mode)
+			// Just ensure permissions and ownership are correct
+			if err := m.fs.Chmod(path, mode); err != nil {
+				return err
+			}
+			return m.applyAttributes(task, ctx, path)
+		}
+
+		// The file has changes apply did not make
+		resolution, err := resolveConflict(task, ctx, path, string(existingContent), content)
+		if err != nil {
+			return err
+		}
+		switch resolution {
+		case ConflictKeep:
+			return &modules.TaskOutcome{Skipped: true, Message: "Kept local changes"}
+		case ConflictMergeMarkers:
+			outcome = &modules.TaskOutcome{NeedsAttention: true, Message: fmt.Sprintf("Resolve the conflict markers in %s", path)}
+			if hasConflictMarkers(string(existingContent)) {
+				return outcome
+			}
+			content = mergeMarkers(string(existingContent), content)
 		}
 	}
 
@@ -303,18 +551,43 @@ func (m *FilesModule) executeEnsureFile(task *config.Task, ctx *modules.Executio
 			} else {
 				if contentSourceStr, exists := task.Config["content_source"]; exists {
 					fmt.Printf("Creating file from source: %s -> %s (mode: %04o)\n", contentSourceStr, path, mode)
+				} else if contentURLStr, exists := task.Config["content_url"]; exists {
+					fmt.Printf("Creating file from URL: %s -> %s (mode: %04o)\n", contentURLStr, path, mode)
+				} else if contentCommandStr, exists := task.Config["content_command"]; exists {
+					fmt.Printf("Creating file from command: %s -> %s (mode: %04o)\n", contentCommandStr, path, mode)
 				} else {
 					fmt.Printf("Creating file: %s (mode: %04o)\n", path, mode)
 				}
 			}
 		}
 
-		// Create or update file with content
-		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		// Keep a copy of the file we are about to overwrite
+		if fileExists && m.shouldBackup(task, ctx) {
+			backupPath, err := backup.BackupFile(path, ctx.BackupDir, time.Now(), backup.DefaultFileBackupKeep)
+			if err != nil {
+				return fmt.Errorf("failed to back up existing file: %w", err)
+			}
+			if ctx.Verbose {
+				fmt.Printf("Backed up existing file: %s -> %s\n", path, backupPath)
+			}
+		}
+
+		// Create or update file with content, replacing it at once so nothing reads
+		// a partly written file and a failed write leaves the old content
+		if err := m.fs.WriteFileAtomic(path, []byte(content), mode); err != nil {
 			return fmt.Errorf("failed to write file: %w", err)
 		}
+		if err := handBack(task, ctx, path, created); err != nil {
+			return err
+		}
 	}
 
+	if err := m.applyAttributes(task, ctx, path); err != nil {
+		return err
+	}
+	if outcome != nil {
+		return outcome
+	}
 	return nil
 }
 
@@ -323,7 +596,7 @@ func (m *FilesModule) executeEnsureFile(task *config.Task, ctx *modules.Executio
 // planEnsureDir returns what ensure_dir would do
 func (m *FilesModule) planEnsureDir(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
 	// Process template in path
-	path, err := m.processTemplate(task.Config["path"].(string), ctx.Variables)
+	path, err := m.processField(task, "path", ctx.Variables)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process path template: %w", err)
 	}
@@ -343,7 +616,7 @@ func (m *FilesModule) planEnsureDir(task *config.Task, ctx *modules.ExecutionCon
 	}
 
 	var description string
-	if runtime.GOOS == "windows" {
+	if goos == "windows" {
 		description = fmt.Sprintf("Ensure directory exists: %s", path)
 	} else {
 		description = fmt.Sprintf("Ensure directory exists: %s (mode: %s)", path, mode)
@@ -357,12 +630,18 @@ func (m *FilesModule) planEnsureDir(task *config.Task, ctx *modules.ExecutionCon
 	}
 
 	// Check if directory already exists
-	if stat, err := os.Stat(path); err == nil {
+	if stat, err := m.fs.Stat(path); err == nil {
 		if !stat.IsDir() {
+			if force, _ := modules.BoolValue(task.Config["force"]); !force {
+				return nil, fmt.Errorf("%s exists and is not a directory, set force: true to replace it with a directory", path)
+			}
+			if m.shouldBackup(task, ctx) {
+				plan.Changes = append(plan.Changes, fmt.Sprintf("Backup existing file to %s", backup.FileBackupPath(path, ctx.BackupDir, time.Now())))
+			}
 			plan.Changes = append(plan.Changes, "Remove existing file and create directory")
 		} else {
 			// On Windows, just check if directory exists
-			if runtime.GOOS == "windows" {
+			if goos == "windows" {
 				plan.WillSkip = true
 				plan.SkipReason = "Directory already exists"
 			} else {
@@ -379,6 +658,13 @@ func (m *FilesModule) planEnsureDir(task *config.Task, ctx *modules.ExecutionCon
 				}
 			}
 		}
+	} else if missing := missingDirs(m.fs, path); len(missing) > 1 {
+		recursiveMode, _ := modules.BoolValue(task.Config["recursive_mode"])
+		if recursiveMode && goos != "windows" {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Create %d directories (mode: %s)", len(missing), mode))
+		} else {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Create %d directories", len(missing)))
+		}
 	} else {
 		plan.Changes = append(plan.Changes, "Create directory")
 	}
@@ -389,7 +675,7 @@ func (m *FilesModule) planEnsureDir(task *config.Task, ctx *modules.ExecutionCon
 // planEnsureFile returns what ensure_file would do
 func (m *FilesModule) planEnsureFile(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
 	// Process template in path
-	path, err := m.processTemplate(task.Config["path"].(string), ctx.Variables)
+	path, err := m.processField(task, "path", ctx.Variables)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process path template: %w", err)
 	}
@@ -403,6 +689,8 @@ func (m *FilesModule) planEnsureFile(task *config.Task, ctx *modules.ExecutionCo
 	description := fmt.Sprintf("Ensure file exists: %s", path)
 	if contentSourceStr, exists := task.Config["content_source"]; exists {
 		description = fmt.Sprintf("Ensure file exists from source: %s -> %s", contentSourceStr, path)
+	} else if contentCommandStr, exists := task.Config["content_command"]; exists {
+		description = fmt.Sprintf("Ensure file exists from command: %s -> %s", contentCommandStr, path)
 	}
 
 	plan := &modules.TaskPlan{
@@ -412,12 +700,17 @@ func (m *FilesModule) planEnsureFile(task *config.Task, ctx *modules.ExecutionCo
 		Changes:     []string{},
 	}
 
+	if _, exists := task.Config["content_url"]; exists {
+		return m.planEnsureFileFromURL(task, ctx, path, plan)
+	}
+
 	// Check if content source exists (if specified)
-	if contentSourceStr, exists := task.Config["content_source"]; exists {
-		contentSourcePath, err := m.processTemplate(contentSourceStr.(string), ctx.Variables)
+	if _, exists := task.Config["content_source"]; exists {
+		contentSourcePath, err := m.processField(task, "content_source", ctx.Variables)
 		if err != nil {
 			plan.WillSkip = true
 			plan.SkipReason = fmt.Sprintf("Failed to process content_source template: %v", err)
+			plan.SkipCode = modules.SkipError
 			return plan, nil
 		}
 
@@ -425,58 +718,42 @@ func (m *FilesModule) planEnsureFile(task *config.Task, ctx *modules.ExecutionCo
 			contentSourcePath = filepath.Join(ctx.BasePath, contentSourcePath)
 		}
 
-		if !utils.FileExists(contentSourcePath) {
+		if !fileExists(m.fs, contentSourcePath) {
 			plan.WillSkip = true
 			plan.SkipReason = fmt.Sprintf("Content source file does not exist: %s", contentSourcePath)
+			plan.SkipCode = modules.SkipMissingSource
 			return plan, nil
 		}
 	}
 
 	// Get content to compare (same logic as execution)
-	desiredContent := ""
-
-	if contentSourceStr, exists := task.Config["content_source"]; exists {
-		contentSourcePath, err := m.processTemplate(contentSourceStr.(string), ctx.Variables)
-		if err != nil {
-			return nil, fmt.Errorf("failed to process content_source template: %w", err)
-		}
-
-		if !filepath.IsAbs(contentSourcePath) {
-			contentSourcePath = filepath.Join(ctx.BasePath, contentSourcePath)
-		}
-
-		if !utils.FileExists(contentSourcePath) {
-			plan.WillSkip = true
-			plan.SkipReason = fmt.Sprintf("Content source file does not exist: %s", contentSourcePath)
-			return plan, nil
+	desiredContent, err := m.ensureFileContent(task, ctx, path)
+	if errors.Is(err, errContentAtApplyTime) {
+		plan.Changes = append(plan.Changes,
+			fmt.Sprintf("Generate content with command: %s", task.Config["content_command"]),
+			"Content determined at apply time (use --plan-exec to run the command now)")
+		if !fileExists(m.fs, path) {
+			plan.Changes = append(plan.Changes, "Create file")
+			if parentDir := filepath.Dir(path); !fileExists(m.fs, parentDir) {
+				plan.Changes = append(plan.Changes, fmt.Sprintf("Create parent directory %s", parentDir))
+			}
 		}
-
-		contentBytes, err := os.ReadFile(contentSourcePath)
-		if err != nil {
+		return plan, nil
+	}
+	if err != nil {
+		var readErr *contentSourceError
+		if errors.As(err, &readErr) {
 			plan.WillSkip = true
-			plan.SkipReason = fmt.Sprintf("Failed to read content source: %v", err)
+			plan.SkipReason = readErr.Error()
+			plan.SkipCode = modules.SkipError
 			return plan, nil
 		}
-		desiredContent = string(contentBytes)
-
-		if render, exists := task.Config["render"]; exists && render.(bool) {
-			desiredContent, err = m.processTemplateWithPathConversion(desiredContent, ctx.Variables, false)
-			if err != nil {
-				return nil, fmt.Errorf("failed to render content template: %w", err)
-			}
-		}
-	} else if contentStr, exists := task.Config["content"]; exists {
-		if contentString, ok := contentStr.(string); ok {
-			desiredContent, err = m.processTemplateWithPathConversion(contentString, ctx.Variables, false)
-			if err != nil {
-				return nil, fmt.Errorf("failed to process content template: %w", err)
-			}
-		}
+		return nil, err
 	}
 
 	// Check if file already exists and compare content
-	if utils.FileExists(path) {
-		existingContent, err := os.ReadFile(path)
+	if fileExists(m.fs, path) {
+		existingContent, err := m.fs.ReadFile(path)
 		if err != nil {
 			plan.Changes = append(plan.Changes, fmt.Sprintf("Failed to read existing file, will recreate: %v", err))
 		} else if string(existingContent) == desiredContent {
@@ -484,11 +761,20 @@ func (m *FilesModule) planEnsureFile(task *config.Task, ctx *modules.ExecutionCo
 			plan.SkipReason = "File exists with correct content"
 			return plan, nil
 		} else {
-			plan.Changes = append(plan.Changes, "Update file content")
+			if !planConflict(plan, conflictResolution(task, ctx), string(existingContent)) {
+				return plan, nil
+			}
+			if m.shouldBackup(task, ctx) {
+				backupPath := backup.FileBackupPath(path, ctx.BackupDir, time.Now())
+				plan.Changes = append(plan.Changes, fmt.Sprintf("Backup existing file to %s", backupPath))
+			}
+			if plan.Conflict == "" {
+				plan.Changes = append(plan.Changes, "Update file content")
+			}
 
 			if ctx.ShowDiff {
 				// Show detailed diff
-				diff := utils.GetDetailedDiff(string(existingContent), desiredContent, 20)
+				diff := utils.UnifiedDiff(string(existingContent), desiredContent, diffOptions(ctx))
 				if len(diff) > 0 {
 					plan.Changes = append(plan.Changes, "  Content diff:")
 					for _, line := range diff {
@@ -507,172 +793,1095 @@ func (m *FilesModule) planEnsureFile(task *config.Task, ctx *modules.ExecutionCo
 		plan.Changes = append(plan.Changes, "Create file")
 		// Check if parent directory needs to be created
 		parentDir := filepath.Dir(path)
-		if !utils.FileExists(parentDir) {
+		if !fileExists(m.fs, parentDir) {
 			plan.Changes = append(plan.Changes, fmt.Sprintf("Create parent directory %s", parentDir))
 		}
 	}
 
+	if contentCommandStr, exists := task.Config["content_command"]; exists {
+		plan.Changes = append([]string{fmt.Sprintf("Generate content with command: %s", contentCommandStr)}, plan.Changes...)
+	}
+
 	return plan, nil
 }
 
 
 
-// ExplainAction returns documentation for a specific action
-func (m *FilesModule) ExplainAction(action string) (*modules.ActionDocumentation, error) {
-	docs := m.ListActions()
-	for _, doc := range docs {
-		if doc.Action == action {
-			return doc, nil
-		}
-	}
-	return nil, fmt.Errorf("action '%s' not supported by files module", action)
+// contentSourceError is returned when the content_source of an ensure_file task cannot be read
+type contentSourceError struct {
+	reason string
 }
 
-// ListActions returns documentation for all actions supported by this module
-func (m *FilesModule) ListActions() []*modules.ActionDocumentation {
-	return []*modules.ActionDocumentation{
-		{
-			Action:      "ensure_dir",
-			Description: "Ensures a directory exists with the specified permissions. Creates the directory and any necessary parent directories if they don't exist.",
-			Parameters: []modules.ActionParameter{
-				{
-					Name:        "path",
-					Type:        "string",
-					Required:    true,
-					Description: "The path to the directory to create. Supports template variables.",
-				},
-				{
-					Name:        "mode",
-					Type:        "string",
-					Required:    false,
-					Default:     "0755",
-					Description: "The file permissions in octal format (Unix/Linux only). On Windows, this parameter is ignored.",
-				},
-			},
-			Examples: []modules.ActionExample{
-				{
-					Description: "Create a basic directory",
-					Config: map[string]interface{}{
-						"path": "{{ .paths.home }}/.config/myapp",
-					},
-				},
-				{
-					Description: "Create a directory with specific permissions",
-					Config: map[string]interface{}{
-						"path": "{{ .paths.home }}/.ssh",
-						"mode": "0700",
-					},
-				},
-			},
-		},
-		{
-			Action:      "ensure_file",
-			Description: "Ensures a file exists with optional content. Creates the file and any necessary parent directories if they don't exist. Content can be provided inline or from a source file, with optional template rendering.",
-			Parameters: []modules.ActionParameter{
-				{
-					Name:        "path",
-					Type:        "string",
-					Required:    true,
-					Description: "The path to the file to create. Supports template variables.",
-				},
-				{
-					Name:        "content",
-					Type:        "string",
-					Required:    false,
-					Default:     "",
-					Description: "The content to write to the file. Supports template variables. Mutually exclusive with content_source.",
-				},
-				{
-					Name:        "content_source",
-					Type:        "string",
-					Required:    false,
-					Description: "Path to a file containing the content to write. Relative to dotfiles repository root. Supports template variables. Mutually exclusive with content.",
-				},
-				{
-					Name:        "render",
-					Type:        "boolean",
-					Required:    false,
-					Default:     "false",
-					Description: "Whether to process the content from content_source as a template. Only applicable when using content_source. Inline content is always rendered as a template for backward compatibility.",
-				},
-				{
-					Name:        "mode",
-					Type:        "string",
-					Required:    false,
-					Default:     "0644",
-					Description: "The file permissions in octal format (Unix/Linux only). On Windows, this parameter is ignored.",
-				},
-			},
-			Examples: []modules.ActionExample{
-				{
-					Description: "Create an empty file",
-					Config: map[string]interface{}{
-						"path": "{{ .paths.home }}/.config/myapp/config.txt",
-					},
-				},
-				{
-					Description: "Create a file with inline content",
-					Config: map[string]interface{}{
-						"path":    "{{ .paths.home }}/.gitconfig",
-						"content": "[user]\n    name = {{ .user.git_name }}\n    email = {{ .user.git_email }}",
-					},
-				},
-				{
-					Description: "Create a file from a template source",
-					Config: map[string]interface{}{
-						"path":           "{{ .paths.home }}/.ssh/config",
-						"content_source": "files/templates/ssh/config.tmpl",
-						"render":         true,
-						"mode":           "0600",
-					},
-				},
-				{
-					Description: "Copy a file without templating",
-					Config: map[string]interface{}{
-						"path":           "{{ .paths.home }}/.config/app/config.json",
-						"content_source": "files/config/app.json",
-						"render":         false,
-					},
-				},
-				{
-					Description: "Create an executable script",
-					Config: map[string]interface{}{
-						"path":    "{{ .paths.home }}/bin/myscript.sh",
-						"content": "#!/bin/bash\necho 'Hello World'",
-						"mode":    "0755",
-					},
-				},
-			},
-		},
+func (e *contentSourceError) Error() string {
+	return e.reason
+}
 
+// ensureFileContent returns the content an ensure_file task with inline content,
+// content_source or content_command writes to path. Commands are not run unless
+// ctx.PlanExec is set or their output is cached, errContentAtApplyTime is returned
+// instead.
+func (m *FilesModule) ensureFileContent(task *config.Task, ctx *modules.ExecutionContext, path string) (string, error) {
+	if _, exists := task.Config["content_command"]; exists {
+		return m.commandContent(task, ctx, path, true)
 	}
-}
+
+	if _, exists := task.Config["content_source"]; exists {
+		contentSourcePath, err := m.processField(task, "content_source", ctx.Variables)
+		if err != nil {
+			return "", fmt.Errorf("failed to process content_source template: %w", err)
+		}
+
+		if !filepath.IsAbs(contentSourcePath) {
+			contentSourcePath = filepath.Join(ctx.BasePath, contentSourcePath)
+		}
+
+		if !fileExists(m.fs, contentSourcePath) {
+			return "", &contentSourceError{fmt.Sprintf("Content source file does not exist: %s", contentSourcePath)}
+		}
+
+		contentBytes, err := m.fs.ReadFile(contentSourcePath)
+		if err != nil {
+			return "", &contentSourceError{fmt.Sprintf("Failed to read content source: %v", err)}
+		}
+		content := string(contentBytes)
+
+		if render, _ := modules.BoolValue(task.Config["render"]); render {
+			content, err = m.renderContentSource(task, ctx, contentSourcePath, content)
+			if err != nil {
+				return "", fmt.Errorf("failed to render content template %s: %w", contentSourcePath, err)
+			}
+		}
+		return m.normalizeContent(task, content), nil
+	}
+
+	if contentStr, exists := task.Config["content"]; exists {
+		if contentString, ok := modules.StringValue(contentStr); ok {
+			content, err := m.processTemplateWithPathConversion(contentString, ctx.Variables, false)
+			if err != nil {
+				return "", fmt.Errorf("failed to process content template for %s: %w", path, err)
+			}
+			return m.normalizeContent(task, content), nil
+		}
+	}
+
+	return "", nil
+}
+
+// CheckDrift compares the targets of an ensure_file or copy_file task with the
+// content apply would write. Other actions are not checked.
+func (m *FilesModule) CheckDrift(task *config.Task, ctx *modules.ExecutionContext) (*modules.DriftResult, error) {
+	if task.Action != "ensure_file" && task.Action != "copy_file" {
+		return nil, fmt.Errorf("drift detection is not supported for action: %s", task.Action)
+	}
+	if targets := m.SplitTask(task); targets[0] != task {
+		return modules.CheckTargetsDrift(targets, func(target *config.Task) (*modules.DriftResult, error) {
+			return m.CheckDrift(target, ctx)
+		})
+	}
+	if task.Action == "copy_file" {
+		return m.checkCopyFileDrift(task, ctx)
+	}
+
+	path, err := m.processField(task, "path", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process path template: %w", err)
+	}
+	path, err = utils.ExpandPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	result := &modules.DriftResult{Path: path}
+	existing, readErr := m.fs.ReadFile(path)
+	if readErr != nil && os.IsNotExist(readErr) {
+		result.State = modules.DriftMissing
+		return result, nil
+	}
+
+	var desired string
+	if _, exists := task.Config["content_url"]; exists {
+		// Never download to check drift, only a pinned or cached copy can be compared
+		source, err := m.parseContentURL(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if source.matchesFile(m.fs, path) {
+			result.State = modules.DriftInSync
+			return result, nil
+		}
+		data, cached := source.cached()
+		if !cached {
+			result.State = modules.DriftUnknown
+			return result, nil
+		}
+		desired = string(data)
+	} else {
+		desired, err = m.ensureFileContent(task, ctx, path)
+		if errors.Is(err, errContentAtApplyTime) {
+			// Only cached command output can be compared without running the command
+			result.State = modules.DriftUnknown
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if readErr != nil {
+		return result, fmt.Errorf("failed to read %s: %w", path, readErr)
+	}
+	if string(existing) == desired {
+		result.State = modules.DriftInSync
+	} else {
+		result.State = modules.DriftOutOfDate
+	}
+	return result, nil
+}
+
+// TaskTargets returns the paths a files task may change so they can be journaled
+// before it runs
+func (m *FilesModule) TaskTargets(task *config.Task, ctx *modules.ExecutionContext) ([]string, error) {
+	if targets := m.SplitTask(task); targets[0] != task {
+		var paths []string
+		for _, target := range targets {
+			targetPaths, err := m.TaskTargets(target, ctx)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, targetPaths...)
+		}
+		return paths, nil
+	}
+
+	switch task.Action {
+	case "ensure_tree":
+		opts, err := m.parseEnsureTreeOptions(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		files, err := m.collectTree(opts, ctx)
+		if err != nil {
+			return nil, err
+		}
+		targets := []string{opts.TargetDir}
+		for _, file := range files {
+			if file.State != "unchanged" {
+				targets = append(targets, file.Target)
+			}
+		}
+		return targets, nil
+	case "line_in_file":
+		path, _, err := m.parseLineInFileOptions(task, ctx)
+		return []string{path}, err
+	case "block_in_file":
+		path, _, err := m.parseBlockInFileOptions(task, ctx)
+		return []string{path}, err
+	case "merge_json", "merge_yaml":
+		path, _, err := m.parseMergeDocOptions(task, ctx)
+		return []string{path}, err
+	}
+
+	path, err := m.processField(task, "path", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process path template: %w", err)
+	}
+	path, err = utils.ExpandPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+	return []string{path}, nil
+}
+
+// ManagedPaths returns the files an ensure_file or copy_file task writes and the
+// files an ensure_tree task copies. Other files tasks only edit files they do not
+// own.
+func (m *FilesModule) ManagedPaths(task *config.Task, ctx *modules.ExecutionContext) ([]string, error) {
+	switch task.Action {
+	case "ensure_file", "copy_file":
+		return m.TaskTargets(task, ctx)
+	case "ensure_tree":
+		opts, err := m.parseEnsureTreeOptions(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		files, err := m.collectTree(opts, ctx)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, file := range files {
+			if file.State != "prune" {
+				paths = append(paths, file.Target)
+			}
+		}
+		return paths, nil
+	}
+	return nil, nil
+}
+
+// DesiredTargets returns the files an ensure_file or copy_file task writes and the
+// files an ensure_tree task copies, with the content they put in place or, for
+// copy_file, its size and hash. The files
+// line_in_file, block_in_file and merge tasks edit are returned as edits.
+func (m *FilesModule) DesiredTargets(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.DesiredTarget, error) {
+	if targets := m.SplitTask(task); targets[0] != task {
+		var desired []*modules.DesiredTarget
+		for _, target := range targets {
+			targetDesired, err := m.DesiredTargets(target, ctx)
+			if err != nil {
+				return nil, err
+			}
+			desired = append(desired, targetDesired...)
+		}
+		return desired, nil
+	}
+
+	switch task.Action {
+	case "ensure_file":
+		paths, err := m.TaskTargets(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		target := &modules.DesiredTarget{Path: paths[0], Kind: "file"}
+		if _, exists := task.Config["content_url"]; exists {
+			source, err := m.parseContentURL(task, ctx)
+			if err != nil {
+				return nil, err
+			}
+			data, cached := source.cached()
+			target.Content, target.Unknown = string(data), !cached
+			return []*modules.DesiredTarget{target}, nil
+		}
+		target.Content, err = m.ensureFileContent(task, ctx, target.Path)
+		if errors.Is(err, errContentAtApplyTime) {
+			target.Unknown = true
+		} else if err != nil {
+			return nil, err
+		}
+		return []*modules.DesiredTarget{target}, nil
+	case "ensure_tree":
+		opts, err := m.parseEnsureTreeOptions(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		files, err := m.collectTree(opts, ctx)
+		if err != nil {
+			return nil, err
+		}
+		var targets []*modules.DesiredTarget
+		for _, file := range files {
+			if file.State != "prune" {
+				targets = append(targets, &modules.DesiredTarget{Path: file.Target, Kind: "file", Content: string(file.Content)})
+			}
+		}
+		return targets, nil
+	case "copy_file":
+		opts, err := m.parseCopyFileOptions(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := m.sourceDigest(opts)
+		if err != nil {
+			return nil, err
+		}
+		return []*modules.DesiredTarget{{Path: opts.Path, Kind: "file", Size: digest.Size, SHA256: digest.Hash}}, nil
+	case "line_in_file", "block_in_file", "merge_json", "merge_yaml":
+		paths, err := m.TaskTargets(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []*modules.DesiredTarget{{Path: paths[0], Kind: "edit"}}, nil
+	}
+	return nil, nil
+}
+
+// shouldBackup reports whether an existing file is backed up before it is overwritten,
+// using the task's backup option and falling back to the create_backups setting
+func (m *FilesModule) shouldBackup(task *config.Task, ctx *modules.ExecutionContext) bool {
+	if backupOpt, exists := task.Config["backup"]; exists {
+		if enabled, ok := modules.BoolValue(backupOpt); ok {
+			return enabled
+		}
+	}
+	return ctx.CreateBackups
+}
+
+// maxDiffLines limits the diff of a single file shown in plans and conflict prompts
+const maxDiffLines = 100
+
+// diffOptions returns how diffs of file contents are shown in this run
+func diffOptions(ctx *modules.ExecutionContext) utils.DiffOptions {
+	return utils.DiffOptions{
+		Context:   ctx.DiffContext,
+		IntraLine: true,
+		Color:     ctx.DiffColor,
+		MaxLines:  maxDiffLines,
+	}
+}
+
+// ExplainAction returns documentation for a specific action
+func (m *FilesModule) ExplainAction(action string) (*modules.ActionDocumentation, error) {
+	docs := m.ListActions()
+	for _, doc := range docs {
+		if doc.Action == action {
+			return doc, nil
+		}
+	}
+	return nil, fmt.Errorf("action '%s' not supported by files module", action)
+}
+
+// ListActions returns documentation for all actions supported by this module
+func (m *FilesModule) ListActions() []*modules.ActionDocumentation {
+	return []*modules.ActionDocumentation{
+		{
+			Action:      "ensure_dir",
+			Description: "Ensures a directory exists with the specified permissions. Creates the directory and any necessary parent directories if they don't exist.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    true,
+					Description: "The path to the directory to create. Supports template variables.",
+				},
+				{
+					Name:        "mode",
+					Type:        "string",
+					Required:    false,
+					Default:     "0755",
+					Description: "The file permissions in octal format (Unix/Linux only). On Windows, this parameter is ignored; use windows_acl to restrict access there.",
+				},
+				{
+					Name:        "recursive_mode",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Apply mode to every directory the task creates on the way to path, not only to path itself, e.g. for ~/.ssh/keys/work. Directories that already exist keep their mode. Without it, the parent directories get the default mode minus the umask (Unix/Linux only).",
+				},
+				{
+					Name:        "force",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Remove a file that is in the way of the directory, backed up like ensure_file backs up files it overwrites. Without force, the task fails when path is a file.",
+				},
+				{
+					Name:        "owner",
+					Type:        "string",
+					Required:    false,
+					Description: "Owner of the directory as a user name or numeric UID (Unix/Linux only). On Windows, this parameter is ignored with a warning.",
+				},
+				{
+					Name:        "group",
+					Type:        "string",
+					Required:    false,
+					Description: "Group of the directory as a group name or numeric GID (Unix/Linux only). On Windows, this parameter is ignored with a warning.",
+				},
+				{
+					Name:        "as_root",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "When dotfiles runs as root through sudo, a directory it creates or updates in the home directory of the user that ran sudo is handed back to that user, together with the parent directories it creates. Set to true to keep them owned by root. owner and group take precedence for the directory itself.",
+				},
+				{
+					Name:        "windows_acl",
+					Type:        "string",
+					Required:    false,
+					Description: "Access control of the directory on Windows (Windows only, ignored elsewhere like mode is ignored on Windows): 'private' gives only the current user full control and stops inheriting from the parent directory, like icacls /inheritance:r /grant:r %USERNAME%:F, files created in it are private too; 'default' resets it to what the parent directory passes on, like icacls /reset. Set mode as well for the same restriction on Unix, e.g. mode '0700' with windows_acl 'private'.",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Create a basic directory",
+					Config: map[string]interface{}{
+						"path": "{{ .paths.home }}/.config/myapp",
+					},
+				},
+				{
+					Description: "Create a directory with specific permissions",
+					Config: map[string]interface{}{
+						"path":        "{{ .paths.home }}/.ssh",
+						"mode":        "0700",
+						"windows_acl": "private",
+					},
+				},
+				{
+					Description: "Create a system directory owned by root",
+					Config: map[string]interface{}{
+						"path":  "/etc/myapp",
+						"owner": "root",
+						"group": "root",
+					},
+				},
+			},
+		},
+		{
+			Action:      "ensure_file",
+			Description: "Ensures a file exists with optional content. Creates the file and any necessary parent directories if they don't exist. Content can be provided inline or from a source file, with optional template rendering.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    false,
+					Description: "The path to the file to create. Supports template variables. Required unless paths is given.",
+				},
+				{
+					Name:        "paths",
+					Type:        "[]string",
+					Required:    false,
+					Description: "Several paths that each get the file. The content is rendered once and written to every path, each path is planned, backed up and reported on its own. Cannot be combined with path.",
+				},
+				{
+					Name:        "content",
+					Type:        "string",
+					Required:    false,
+					Default:     "",
+					Description: "The content to write to the file. Supports template variables. Mutually exclusive with content_source, content_url and content_command.",
+				},
+				{
+					Name:        "content_source",
+					Type:        "string",
+					Required:    false,
+					Description: "Path to a file containing the content to write. Relative to dotfiles repository root. Supports template variables. Mutually exclusive with content.",
+				},
+				{
+					Name:        "content_url",
+					Type:        "string",
+					Required:    false,
+					Description: "HTTP(S) URL to download the content from during apply. Written as-is, never rendered. Downloads are cached in the state directory (settings.state_dir). Mutually exclusive with content and content_source.",
+				},
+				{
+					Name:        "sha256",
+					Type:        "string",
+					Required:    false,
+					Description: "Expected SHA-256 checksum of the content_url download. The task fails on a mismatch, and a cached copy with this checksum is reused instead of downloading again.",
+				},
+				{
+					Name:        "content_command",
+					Type:        "string",
+					Required:    false,
+					Description: "Command whose stdout is written to the file, run during apply in the dotfiles repository with the same shell as run_command. The task fails when it exits non-zero. Plan shows the content as determined at apply time unless --plan-exec is given. Written as-is, never rendered. Supports template variables. Mutually exclusive with content, content_source and content_url.",
+				},
+				{
+					Name:        "shell",
+					Type:        "string",
+					Required:    false,
+					Description: "Shell content_command runs with (bash, zsh, sh, powershell, cmd) - auto-detected if not specified",
+				},
+				{
+					Name:        "cache_key",
+					Type:        "string",
+					Required:    false,
+					Description: "Reuse the output of content_command recorded by an earlier apply while this key and the command are unchanged, e.g. the version of the tool that generates the content. Supports template variables.",
+				},
+				{
+					Name:        "render",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Whether to process the content from content_source as a template. Only applicable when using content_source. Inline content is always rendered as a template for backward compatibility.",
+				},
+				{
+					Name:        "engine",
+					Type:        "string",
+					Required:    false,
+					Default:     "default",
+					Description: "How content_source is rendered when render is true: 'default' renders its content on its own, 'pongo2' renders the file so {% include %}, {% extends %} and {% import %} find templates next to it and in paths.templates_dir (files/templates by default). Files ending in .j2 use pongo2 unless set.",
+				},
+				{
+					Name:        "normalize_line_endings",
+					Type:        "string",
+					Required:    false,
+					Default:     "preserve",
+					Description: "Line endings of the content or content_source written: 'lf', 'crlf' (e.g. PowerShell profiles deployed from Linux) or 'preserve' to write them as they are in the template or source file.",
+				},
+				{
+					Name:        "strip_template_blanks",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Remove blank lines that template conditionals leave behind from the content or content_source: leading and trailing blank lines, repeated blank lines and a blank line after a [section] header. Off by default, also for content, which was always written as it rendered. Leave it off for files whose blank lines matter.",
+				},
+				{
+					Name:        "backup",
+					Type:        "boolean",
+					Required:    false,
+					Description: "Copy an existing file to <path>.dotfiles-bak.<timestamp> in the backup directory before overwriting it. The last 3 backups per file are kept. Defaults to settings.create_backups.",
+				},
+				{
+					Name:        "on_conflict",
+					Type:        "string",
+					Required:    false,
+					Default:     "overwrite",
+					Description: "What to do when the file exists with different content, e.g. after editing it by hand: overwrite, keep (skip the task), prompt (show the diff and ask, answered by apply --assume in non-interactive runs) or merge-markers (write both versions between conflict markers to resolve by hand).",
+				},
+				{
+					Name:        "mode",
+					Type:        "string",
+					Required:    false,
+					Default:     "0644",
+					Description: "The file permissions in octal format (Unix/Linux only). On Windows, this parameter is ignored; use windows_acl to restrict access there.",
+				},
+				{
+					Name:        "owner",
+					Type:        "string",
+					Required:    false,
+					Description: "Owner of the file as a user name or numeric UID (Unix/Linux only). On Windows, this parameter is ignored with a warning.",
+				},
+				{
+					Name:        "group",
+					Type:        "string",
+					Required:    false,
+					Description: "Group of the file as a group name or numeric GID (Unix/Linux only). On Windows, this parameter is ignored with a warning.",
+				},
+				{
+					Name:        "as_root",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "When dotfiles runs as root through sudo, a file it creates or updates in the home directory of the user that ran sudo is handed back to that user, together with the parent directories it creates. Set to true to keep them owned by root. owner and group take precedence for the file itself.",
+				},
+				{
+					Name:        "windows_acl",
+					Type:        "string",
+					Required:    false,
+					Description: "Access control of the file on Windows (Windows only, ignored elsewhere like mode is ignored on Windows): 'private' gives only the current user full control and stops inheriting from the parent directory, like icacls /inheritance:r /grant:r %USERNAME%:F; 'default' resets it to what the parent directory passes on, like icacls /reset. Set mode as well for the same restriction on Unix, e.g. mode '0600' with windows_acl 'private'.",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Create an empty file",
+					Config: map[string]interface{}{
+						"path": "{{ .paths.home }}/.config/myapp/config.txt",
+					},
+				},
+				{
+					Description: "Create a file with inline content",
+					Config: map[string]interface{}{
+						"path":    "{{ .paths.home }}/.gitconfig",
+						"content": "[user]\n    name = {{ .user.git_name }}\n    email = {{ .user.git_email }}",
+					},
+				},
+				{
+					Description: "Write the same file to several places",
+					Config: map[string]interface{}{
+						"paths": []string{
+							"{{ .paths.home }}/.config/Code/User/settings.json",
+							"{{ .paths.home }}/.config/VSCodium/User/settings.json",
+						},
+						"content_source": "files/vscode/settings.json",
+					},
+				},
+				{
+					Description: "Create a file from a template source",
+					Config: map[string]interface{}{
+						"path":           "{{ .paths.home }}/.ssh/config",
+						"content_source": "files/templates/ssh/config.tmpl",
+						"render":         true,
+						"mode":           "0600",
+						"windows_acl":    "private",
+					},
+				},
+				{
+					Description: "Render a template that extends a base layout in files/templates",
+					Config: map[string]interface{}{
+						"path":           "{{ .paths.home }}/.config/alacritty/alacritty.toml",
+						"content_source": "files/templates/alacritty.toml.j2",
+						"render":         true,
+					},
+				},
+				{
+					Description: "Copy a file without templating",
+					Config: map[string]interface{}{
+						"path":           "{{ .paths.home }}/.config/app/config.json",
+						"content_source": "files/config/app.json",
+						"render":         false,
+					},
+				},
+				{
+					Description: "Create an executable script",
+					Config: map[string]interface{}{
+						"path":    "{{ .paths.home }}/bin/myscript.sh",
+						"content": "#!/bin/bash\necho 'Hello World'",
+						"mode":    "0755",
+					},
+				},
+				{
+					Description: "Generate shell completions, only again when the gh version changes",
+					Config: map[string]interface{}{
+						"path":            "{{ .paths.home }}/.zsh/completions/_gh",
+						"content_command": "gh completion -s zsh",
+						"cache_key":       "{{ .versions.gh }}",
+					},
+				},
+				{
+					Description: "Ask before overwriting a file that was edited by hand",
+					Config: map[string]interface{}{
+						"path":           "{{ .paths.home }}/.gitconfig",
+						"content_source": "files/templates/gitconfig.tmpl",
+						"render":         true,
+						"on_conflict":    "prompt",
+					},
+				},
+				{
+					Description: "Deploy a system-wide profile snippet owned by root",
+					Config: map[string]interface{}{
+						"path":           "/etc/profile.d/dotfiles.sh",
+						"content_source": "files/profile.d/dotfiles.sh",
+						"owner":          "root",
+						"group":          "root",
+					},
+				},
+			},
+		},
+		{
+			Action:      "ensure_tree",
+			Description: "Renders or copies every file of a source directory into a target directory, creating matching subdirectories. File modes of the source files are preserved on Unix.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "source_dir",
+					Type:        "string",
+					Required:    true,
+					Description: "Directory to copy from, relative to the dotfiles repository root. Supports template variables.",
+				},
+				{
+					Name:        "target_dir",
+					Type:        "string",
+					Required:    true,
+					Description: "Directory to copy to. Supports template variables.",
+				},
+				{
+					Name:        "render",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Whether to process each file as a template. Binary files are always copied as-is.",
+				},
+				{
+					Name:        "exclude",
+					Type:        "array",
+					Required:    false,
+					Description: "Glob patterns of files and directories to skip, matched against the relative path and each of its elements.",
+				},
+				{
+					Name:        "prune",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Delete files in the target directory that are not in the source directory. Excluded paths are never deleted.",
+				},
+				{
+					Name:        "backup",
+					Type:        "boolean",
+					Required:    false,
+					Description: "Back up files before they are overwritten or pruned, like ensure_file. Defaults to settings.create_backups.",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Render a directory of config fragments",
+					Config: map[string]interface{}{
+						"source_dir": "files/config/nvim",
+						"target_dir": "{{ .paths.home }}/.config/nvim",
+						"render":     true,
+						"exclude":    []interface{}{"*.bak", "lazy-lock.json"},
+					},
+				},
+				{
+					Description: "Mirror a directory and remove files that are no longer in the repository",
+					Config: map[string]interface{}{
+						"source_dir": "files/config/kitty",
+						"target_dir": "{{ .paths.home }}/.config/kitty",
+						"prune":      true,
+					},
+				},
+			},
+		},
+		{
+			Action:      "copy_file",
+			Description: "Copies a file byte for byte, for fonts, binaries and images. The file is streamed, never rendered as a template, and compared by size and SHA-256 instead of its content, so plans show hashes rather than a diff.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "source",
+					Type:        "string",
+					Required:    true,
+					Description: "File to copy, relative to the dotfiles repository root. Supports template variables in the path, the file itself is copied as-is.",
+				},
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    true,
+					Description: "Where to copy the file to. Supports template variables. Parent directories are created.",
+				},
+				{
+					Name:        "mode",
+					Type:        "string",
+					Required:    false,
+					Default:     "0644",
+					Description: "The file permissions in octal format (Unix/Linux only). On Windows, this parameter is ignored; use windows_acl to restrict access there.",
+				},
+				{
+					Name:        "executable",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Add execute permission for everyone who may read the file to mode, so 0644 becomes 0755 (Unix/Linux only).",
+				},
+				{
+					Name:        "backup",
+					Type:        "boolean",
+					Required:    false,
+					Description: "Back up the existing file before it is replaced, like ensure_file. Defaults to settings.create_backups.",
+				},
+				{
+					Name:        "owner",
+					Type:        "string",
+					Required:    false,
+					Description: "Owner of the file as a user name or numeric UID (Unix/Linux only). On Windows, this parameter is ignored with a warning.",
+				},
+				{
+					Name:        "group",
+					Type:        "string",
+					Required:    false,
+					Description: "Group of the file as a group name or numeric GID (Unix/Linux only). On Windows, this parameter is ignored with a warning.",
+				},
+				{
+					Name:        "as_root",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "Keep a file copied into the home directory of the user that ran sudo owned by root, like ensure_file.",
+				},
+				{
+					Name:        "windows_acl",
+					Type:        "string",
+					Required:    false,
+					Description: "Access control of the file on Windows, 'private' or 'default', like ensure_file.",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Install a script into ~/bin",
+					Config: map[string]interface{}{
+						"source":     "files/bin/git-cleanup",
+						"path":       "{{ .paths.home }}/bin/git-cleanup",
+						"executable": true,
+					},
+				},
+				{
+					Description: "Deploy a wallpaper",
+					Config: map[string]interface{}{
+						"source": "files/wallpapers/mountains.jpg",
+						"path":   "{{ .paths.home }}/Pictures/Wallpapers/mountains.jpg",
+					},
+				},
+			},
+		},
+		{
+			Action:      "line_in_file",
+			Description: "Ensures a single line is present in or absent from a file, leaving the rest of the file untouched. Useful for files you only partly manage, like /etc/hosts or an existing .bashrc.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    true,
+					Description: "The path to the file to edit. Supports template variables. Created if missing when state is present.",
+				},
+				{
+					Name:        "line",
+					Type:        "string",
+					Required:    false,
+					Description: "The line to ensure. Required when state is present. Supports template variables.",
+				},
+				{
+					Name:        "state",
+					Type:        "string",
+					Required:    false,
+					Default:     "present",
+					Description: "Whether the line should be present or absent.",
+				},
+				{
+					Name:        "regexp",
+					Type:        "string",
+					Required:    false,
+					Description: "Regular expression for an existing line to replace. When state is absent, every matching line is removed.",
+				},
+				{
+					Name:        "insert_after",
+					Type:        "string",
+					Required:    false,
+					Description: "Regular expression; a new line is inserted after the last matching line. Falls back to the end of the file. Mutually exclusive with insert_before.",
+				},
+				{
+					Name:        "insert_before",
+					Type:        "string",
+					Required:    false,
+					Description: "Regular expression; a new line is inserted before the first matching line. Falls back to the end of the file. Mutually exclusive with insert_after.",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Add a hosts entry",
+					Config: map[string]interface{}{
+						"path": "/etc/hosts",
+						"line": "127.0.0.1 dev.local",
+					},
+				},
+				{
+					Description: "Replace an existing export",
+					Config: map[string]interface{}{
+						"path":   "{{ .paths.home }}/.bashrc",
+						"regexp": "^export EDITOR=",
+						"line":   "export EDITOR=nvim",
+					},
+				},
+				{
+					Description: "Insert a line after an anchor",
+					Config: map[string]interface{}{
+						"path":         "{{ .paths.home }}/.bashrc",
+						"line":         "source ~/.aliases",
+						"insert_after": "^# aliases",
+					},
+				},
+				{
+					Description: "Remove a line",
+					Config: map[string]interface{}{
+						"path":   "{{ .paths.home }}/.bashrc",
+						"regexp": "^alias ll=",
+						"state":  "absent",
+					},
+				},
+			},
+		},
+		{
+			Action:      "block_in_file",
+			Description: "Inserts, updates or removes a multi-line block in a file. The block is delimited by '# BEGIN dotfiles-managed: <id>' and '# END dotfiles-managed' markers and only the lines between them are changed.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    true,
+					Description: "The path to the file to edit. Supports template variables. Created if missing when state is present.",
+				},
+				{
+					Name:        "block",
+					Type:        "string",
+					Required:    false,
+					Description: "The content of the block. Required when state is present. Supports template variables.",
+				},
+				{
+					Name:        "name",
+					Type:        "string",
+					Required:    false,
+					Description: "Identifier used in the begin marker. Defaults to the task ID; set it when a file has more than one block.",
+				},
+				{
+					Name:        "marker_comment",
+					Type:        "string",
+					Required:    false,
+					Default:     "#",
+					Description: "Comment prefix for the markers, e.g. '//' or '\"' for vimrc.",
+				},
+				{
+					Name:        "state",
+					Type:        "string",
+					Required:    false,
+					Default:     "present",
+					Description: "Whether the block should be present or absent. Absent removes the block and its markers.",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Append aliases to an existing .zshrc",
+					Config: map[string]interface{}{
+						"path":  "{{ .paths.home }}/.zshrc",
+						"name":  "aliases",
+						"block": "alias ll='ls -la'\nalias gs='git status'",
+					},
+				},
+				{
+					Description: "Manage settings in a vimrc",
+					Config: map[string]interface{}{
+						"path":           "{{ .paths.home }}/.vimrc",
+						"name":           "editor",
+						"marker_comment": "\"",
+						"block":          "set number\nset expandtab",
+					},
+				},
+				{
+					Description: "Remove a block",
+					Config: map[string]interface{}{
+						"path":  "{{ .paths.home }}/.zshrc",
+						"name":  "aliases",
+						"state": "absent",
+					},
+				},
+			},
+		},
+		{
+			Action:      "merge_json",
+			Description: "Deep merges keys into a JSON file, leaving keys you don't manage untouched. Useful for settings files the application edits itself. Comments, key order and indentation of the existing file are preserved.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    true,
+					Description: "The path to the JSON file. Supports template variables. Created if missing when state is present.",
+				},
+				{
+					Name:        "data",
+					Type:        "map",
+					Required:    false,
+					Description: "The keys and values to merge. Nested maps are merged key by key, other values (including lists) replace the existing value. String values support template variables. Required when state is present.",
+				},
+				{
+					Name:        "key",
+					Type:        "string|array",
+					Required:    false,
+					Description: "Key path or list of key paths to remove when state is absent, in dot notation like 'editor.minimap.enabled'. Keys containing dots are matched as a whole first.",
+				},
+				{
+					Name:        "state",
+					Type:        "string",
+					Required:    false,
+					Default:     "present",
+					Description: "Whether data should be merged (present) or the keys in 'key' removed (absent).",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Set VSCode settings without owning the whole file",
+					Config: map[string]interface{}{
+						"path": "{{ .paths.home }}/.config/Code/User/settings.json",
+						"data": map[string]interface{}{
+							"editor.fontSize": 14,
+							"[go]": map[string]interface{}{
+								"editor.formatOnSave": true,
+							},
+						},
+					},
+				},
+				{
+					Description: "Remove a nested key",
+					Config: map[string]interface{}{
+						"path":  "{{ .paths.home }}/.config/Code/User/settings.json",
+						"key":   "[go].editor.formatOnSave",
+						"state": "absent",
+					},
+				},
+			},
+		},
+		{
+			Action:      "merge_yaml",
+			Description: "Deep merges keys into a YAML file, leaving keys you don't manage untouched. Useful for settings files the application edits itself. Comments and key order are preserved; the file is re-indented with its detected indentation.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    true,
+					Description: "The path to the YAML file. Supports template variables. Created if missing when state is present.",
+				},
+				{
+					Name:        "data",
+					Type:        "map",
+					Required:    false,
+					Description: "The keys and values to merge. Nested maps are merged key by key, other values (including lists) replace the existing value. String values support template variables. Required when state is present.",
+				},
+				{
+					Name:        "key",
+					Type:        "string|array",
+					Required:    false,
+					Description: "Key path or list of key paths to remove when state is absent, in dot notation like 'editor.minimap.enabled'. Keys containing dots are matched as a whole first.",
+				},
+				{
+					Name:        "state",
+					Type:        "string",
+					Required:    false,
+					Default:     "present",
+					Description: "Whether data should be merged (present) or the keys in 'key' removed (absent).",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Set the theme of a tool config",
+					Config: map[string]interface{}{
+						"path": "{{ .paths.home }}/.config/lazygit/config.yml",
+						"data": map[string]interface{}{
+							"gui": map[string]interface{}{
+								"theme": map[string]interface{}{
+									"lightTheme": false,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// contentSourceEngine returns the engine an ensure_file task renders its content_source
+// with: pongo2 when set with engine or for .j2 files, default otherwise
+func contentSourceEngine(task *config.Task, sourcePath string) string {
+	if engine, ok := modules.StringValue(task.Config["engine"]); ok {
+		return engine
+	}
+	if filepath.Ext(sourcePath) == ".j2" {
+		return "pongo2"
+	}
+	return "default"
+}
+
+// renderContentSource renders the content of a content_source file. The default engine
+// renders the content on its own, pongo2 renders the file so its include, extends and
+// import tags find templates next to it and in the templates directory.
+func (m *FilesModule) renderContentSource(task *config.Task, ctx *modules.ExecutionContext, sourcePath, content string) (string, error) {
+	if contentSourceEngine(task, sourcePath) == "pongo2" {
+		return m.templateEngine.ProcessTemplateFileWithSearchPath(sourcePath, templateSearchPath(ctx), ctx.Variables)
+	}
+	return m.templateEngine.ProcessNamedTemplate(content, sourcePath, ctx.Variables)
+}
+
+// templateSearchPath returns the directories the include, extends and import tags of
+// pongo2 templates are searched in
+func templateSearchPath(ctx *modules.ExecutionContext) []string {
+	if ctx.TemplatesDir != "" {
+		return []string{ctx.TemplatesDir}
+	}
+	return []string{filepath.Join(ctx.BasePath, "files", "templates")}
+}
 
 // processTemplate processes a template string with variables using the new templating engine
 func (m *FilesModule) processTemplate(templateStr string, variables map[string]interface{}) (string, error) {
 	return m.processTemplateWithPathConversion(templateStr, variables, true)
 }
 
-// processTemplateWithPathConversion processes a template string with optional path conversion
-func (m *FilesModule) processTemplateWithPathConversion(templateStr string, variables map[string]interface{}, convertPaths bool) (string, error) {
-	result, err := m.templateEngine.ProcessVariableTemplate(templateStr, variables)
+// processField processes a string field of the config of a task as a template
+func (m *FilesModule) processField(task *config.Task, field string, variables map[string]interface{}) (string, error) {
+	value, err := modules.GetString(task, field)
 	if err != nil {
 		return "", err
 	}
+	return m.processTemplate(value, variables)
+}
 
-	// Convert paths to OS-specific format if requested
+// processTemplateWithPathConversion processes a template string with optional path conversion
+func (m *FilesModule) processTemplateWithPathConversion(templateStr string, variables map[string]interface{}, convertPaths bool) (string, error) {
 	if convertPaths {
-		result = filepath.FromSlash(result)
+		return m.templateEngine.ProcessPath(templateStr, variables)
 	}
-
-	return result, nil
+	return m.templateEngine.ProcessString(templateStr, variables)
 }
 
-// cleanupTemplateArtifacts removes empty lines that are artifacts from template conditionals
+// cleanupTemplateArtifacts removes empty lines that are artifacts from template
+// conditionals, for strip_template_blanks. Content using CRLF keeps it, changing
+// line endings is up to normalize_line_endings.
 func (m *FilesModule) cleanupTemplateArtifacts(content string) string {
-	// First, normalize line endings to Unix (LF) to avoid Windows CRLF issues in WSL/Linux
-	content = strings.ReplaceAll(content, "\r\n", "\n")
-	content = strings.ReplaceAll(content, "\r", "\n")
+	eol := "\n"
+	if strings.Contains(content, "\r\n") {
+		eol = "\r\n"
+		content = strings.ReplaceAll(content, "\r\n", "\n")
+	}
 
 	lines := strings.Split(content, "\n")
 	var cleaned []string
@@ -722,5 +1931,5 @@ func (m *FilesModule) cleanupTemplateArtifacts(content string) string {
 		cleaned = cleaned[:len(cleaned)-1]
 	}
 
-	return strings.Join(cleaned, "\n")
+	return strings.Join(cleaned, eol)
 }
@@ -1,8 +1,13 @@
 package files
 
 import (
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
 )
 
 func TestCleanupTemplateArtifacts(t *testing.T) {
@@ -176,3 +181,244 @@ func TestProcessTemplateWithPathConversion(t *testing.T) {
 		})
 	}
 }
+
+func TestEnsureFileBackup(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "config")
+	if err := os.WriteFile(path, []byte("old content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	backupDir := filepath.Join(tmpDir, "backups")
+	ctx := &modules.ExecutionContext{
+		BasePath:      tmpDir,
+		Variables:     map[string]interface{}{},
+		CreateBackups: true,
+		BackupDir:     backupDir,
+	}
+	task := &config.Task{
+		ID:     "test",
+		Action: "ensure_file",
+		Config: map[string]interface{}{"path": path, "content": "new content"},
+	}
+
+	listBackups := func() []string {
+		var found []string
+		filepath.Walk(backupDir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.Contains(filepath.Base(p), ".dotfiles-bak.") {
+				found = append(found, p)
+			}
+			return nil
+		})
+		return found
+	}
+
+	// Planning mentions the backup but must not create it
+	ctx.DryRun = true
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) == 0 || !strings.HasPrefix(plan.Changes[0], "Backup existing file to ") {
+		t.Errorf("expected backup change in plan, got %v", plan.Changes)
+	}
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if backups := listBackups(); len(backups) != 0 {
+		t.Errorf("dry run created backups: %v", backups)
+	}
+
+	// Applying backs up the old content
+	ctx.DryRun = false
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	backups := listBackups()
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, got %v", backups)
+	}
+	if content, _ := os.ReadFile(backups[0]); string(content) != "old content" {
+		t.Errorf("backup content = %q, want %q", content, "old content")
+	}
+
+	// Unchanged content short-circuits without another backup
+	plan, err = m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip {
+		t.Errorf("expected plan to skip unchanged file, got %v", plan.Changes)
+	}
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if backups := listBackups(); len(backups) != 1 {
+		t.Errorf("unchanged content created a backup: %v", backups)
+	}
+}
+
+func TestEnsureFileBackupDisabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "config")
+	if err := os.WriteFile(path, []byte("old content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{
+		BasePath:      tmpDir,
+		Variables:     map[string]interface{}{},
+		CreateBackups: true,
+	}
+	task := &config.Task{
+		ID:     "test",
+		Action: "ensure_file",
+		Config: map[string]interface{}{"path": path, "content": "new content", "backup": false},
+	}
+
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range plan.Changes {
+		if strings.HasPrefix(change, "Backup") {
+			t.Errorf("unexpected backup change: %s", change)
+		}
+	}
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(path + ".dotfiles-bak.*")
+	if len(matches) != 0 {
+		t.Errorf("backup created although disabled: %v", matches)
+	}
+}
+
+func TestEnsureFileCheckDrift(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "files"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "files", "source"), []byte("from source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "synced"), []byte("from source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "changed"), []byte("edited by hand"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		state  modules.DriftState
+	}{
+		{"InSync", map[string]interface{}{"path": filepath.Join(tmpDir, "synced"), "content_source": "files/source"}, modules.DriftInSync},
+		{"OutOfDate", map[string]interface{}{"path": filepath.Join(tmpDir, "changed"), "content": "managed"}, modules.DriftOutOfDate},
+		{"Missing", map[string]interface{}{"path": filepath.Join(tmpDir, "absent"), "content": "managed"}, modules.DriftMissing},
+		{"UncachedDownload", map[string]interface{}{"path": filepath.Join(tmpDir, "changed"), "content_url": "https://example.com/file"}, modules.DriftUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &config.Task{ID: tt.name, Action: "ensure_file", Config: tt.config}
+			result, err := m.CheckDrift(task, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.State != tt.state {
+				t.Errorf("state = %s, want %s", result.State, tt.state)
+			}
+			if result.Path != tt.config["path"] {
+				t.Errorf("path = %s, want %s", result.Path, tt.config["path"])
+			}
+		})
+	}
+}
+
+func TestEnsureFilePongo2Engine(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"files/templates/base.j2":          "# {{ title }}\n{% block body %}{% endblock %}\n",
+		"files/templates/partials/user.j2": "user = {{ name }}",
+		"files/app/config.j2":              "{% extends \"base.j2\" %}{% block body %}{% include \"partials/user.j2\" %}{% endblock %}",
+		"files/app/broken.tmpl":            "line one\n{% include \"partials/missing.j2\" %}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{"title": "app", "name": "menno"}}
+	target := filepath.Join(tmpDir, "out", "config")
+
+	task := &config.Task{ID: "config", Action: "ensure_file", Config: map[string]interface{}{
+		"path": target, "content_source": "files/app/config.j2", "render": true,
+	}}
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.WillSkip {
+		t.Fatalf("plan should create the file: %s", plan.SkipReason)
+	}
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# app\nuser = menno\n"; string(content) != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+
+	// Planning renders with the same engine, so the file is up to date
+	plan, err = m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip {
+		t.Errorf("plan should not change the rendered file: %v", plan.Changes)
+	}
+
+	t.Run("MissingInclude", func(t *testing.T) {
+		task := &config.Task{ID: "broken", Action: "ensure_file", Config: map[string]interface{}{
+			"path": target, "content_source": "files/app/broken.tmpl", "render": true, "engine": "pongo2",
+		}}
+		err := m.ExecuteTask(task, ctx)
+		if err == nil {
+			t.Fatal("expected an error for a missing include")
+		}
+		want := filepath.Join(tmpDir, "files", "app", "broken.tmpl") + "' line 2: template 'partials/missing.j2' not found"
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		invalid := []map[string]interface{}{
+			{"path": target, "content_source": "files/app/config.j2", "render": true, "engine": "jinja"},
+			{"path": target, "content_source": "files/app/config.j2", "engine": "pongo2"},
+			{"path": target, "content": "inline", "render": true, "engine": "pongo2"},
+		}
+		for _, cfg := range invalid {
+			if err := m.ValidateTask(&config.Task{Action: "ensure_file", Config: cfg}); err == nil {
+				t.Errorf("expected %v to be invalid", cfg)
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// fileSystem is what ensure_file, ensure_dir and copy_file read and write their
+// targets with, so tests can run them against an in-memory file system. Backups, ownership and
+// access control use the real file system.
+type fileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	Open(name string) (io.ReadCloser, error)
+	// WriteFileAtomic replaces the file at once, like utils.WriteFileAtomic
+	WriteFileAtomic(name string, data []byte, perm os.FileMode) error
+	// CopyFileAtomic replaces dst with a copy of src at once, like utils.CopyFileAtomic
+	CopyFileAtomic(src, dst string, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Chmod(name string, mode os.FileMode) error
+	Remove(name string) error
+}
+
+// osFileSystem is the file system of the machine
+type osFileSystem struct{}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	return utils.WriteFileAtomic(name, data, perm)
+}
+
+func (osFileSystem) CopyFileAtomic(src, dst string, perm os.FileMode) error {
+	return utils.CopyFileAtomic(src, dst, perm)
+}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// fileExists reports whether path exists, like utils.FileExists
+func fileExists(fsys fileSystem, path string) bool {
+	_, err := fsys.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// ensureDir creates a directory and its parents when they do not exist, like
+// utils.EnsureDir
+func ensureDir(fsys fileSystem, path string) error {
+	if fileExists(fsys, path) {
+		if info, err := fsys.Stat(path); err == nil && info.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("path exists but is not a directory: %s", path)
+	}
+	return fsys.MkdirAll(path, 0755)
+}
+
+// missingDirs returns dir and its parents that do not exist, outermost first, like
+// modules.MissingDirs
+func missingDirs(fsys fileSystem, dir string) []string {
+	var missing []string
+	for {
+		if _, err := fsys.Lstat(dir); err == nil {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return missing
+}
@@ -0,0 +1,444 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// memFile is a file or directory of memFileSystem
+type memFile struct {
+	data []byte
+	mode os.FileMode // Includes os.ModeDir for directories
+}
+
+// memFileSystem is an in-memory fileSystem. Modes are applied as given, there is
+// no umask.
+type memFileSystem struct {
+	files      map[string]*memFile
+	readErrors map[string]error // Errors ReadFile returns for these paths
+	writes     int              // Files written
+}
+
+// newMemFileSystem returns an in-memory file system with the directories dirs
+func newMemFileSystem(dirs ...string) *memFileSystem {
+	fsys := &memFileSystem{files: map[string]*memFile{}, readErrors: map[string]error{}}
+	for _, dir := range dirs {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			panic(err)
+		}
+	}
+	return fsys
+}
+
+// addFile puts a file in the file system, creating its directory
+func (f *memFileSystem) addFile(name, content string, mode os.FileMode) {
+	if err := f.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		panic(err)
+	}
+	f.files[filepath.Clean(name)] = &memFile{data: []byte(content), mode: mode}
+}
+
+// memFileInfo describes a file of memFileSystem
+type memFileInfo struct {
+	name string
+	file *memFile
+}
+
+func (i *memFileInfo) Name() string       { return filepath.Base(i.name) }
+func (i *memFileInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i *memFileInfo) Mode() os.FileMode  { return i.file.mode }
+func (i *memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i *memFileInfo) IsDir() bool        { return i.file.mode.IsDir() }
+func (i *memFileInfo) Sys() interface{}   { return nil }
+
+func (f *memFileSystem) Stat(name string) (os.FileInfo, error) {
+	file, exists := f.files[filepath.Clean(name)]
+	if !exists {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFileInfo{name: name, file: file}, nil
+}
+
+func (f *memFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return f.Stat(name)
+}
+
+func (f *memFileSystem) ReadFile(name string) ([]byte, error) {
+	if err, fails := f.readErrors[filepath.Clean(name)]; fails {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, exists := f.files[filepath.Clean(name)]
+	if !exists {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if file.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+func (f *memFileSystem) WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	parent, exists := f.files[filepath.Dir(filepath.Clean(name))]
+	if !exists || !parent.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if file, exists := f.files[filepath.Clean(name)]; exists && file.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: name, Err: fs.ErrExist}
+	}
+	f.files[filepath.Clean(name)] = &memFile{data: append([]byte(nil), data...), mode: perm}
+	f.writes++
+	return nil
+}
+
+func (f *memFileSystem) Open(name string) (io.ReadCloser, error) {
+	data, err := f.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *memFileSystem) CopyFileAtomic(src, dst string, perm os.FileMode) error {
+	data, err := f.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return f.WriteFileAtomic(dst, data, perm)
+}
+
+func (f *memFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	if file, exists := f.files[path]; exists {
+		if !file.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrExist}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := f.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	f.files[path] = &memFile{mode: os.ModeDir | perm}
+	return nil
+}
+
+func (f *memFileSystem) Chmod(name string, mode os.FileMode) error {
+	file, exists := f.files[filepath.Clean(name)]
+	if !exists {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	file.mode = file.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+func (f *memFileSystem) Remove(name string) error {
+	if _, exists := f.files[filepath.Clean(name)]; !exists {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(f.files, filepath.Clean(name))
+	return nil
+}
+
+// content returns the content of a file and whether it exists
+func (f *memFileSystem) content(name string) (string, bool) {
+	file, exists := f.files[filepath.Clean(name)]
+	if !exists || file.mode.IsDir() {
+		return "", false
+	}
+	return string(file.data), true
+}
+
+// memHome returns the home directory of the in-memory tests, absolute on every
+// platform like expanded task paths are
+func memHome(t *testing.T) string {
+	home, err := filepath.Abs(filepath.FromSlash("/home/user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return home
+}
+
+// newMemModule returns a files module working on fsys
+func newMemModule(t *testing.T, fsys *memFileSystem) (*FilesModule, *modules.ExecutionContext) {
+	setGOOS(t, "linux")
+	m := New()
+	m.fs = fsys
+	ctx := &modules.ExecutionContext{
+		Context:   context.Background(),
+		BasePath:  filepath.Join(memHome(t), "dotfiles"),
+		Variables: map[string]interface{}{"name": "world"},
+	}
+	return m, ctx
+}
+
+func ensureFileTask(taskConfig map[string]interface{}) *config.Task {
+	return &config.Task{ID: "test", Action: "ensure_file", Config: taskConfig}
+}
+
+func TestEnsureFileInMemory(t *testing.T) {
+	home := memHome(t)
+	path := filepath.Join(home, ".config", "app", "config.toml")
+
+	t.Run("SameContent", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		fsys.addFile(path, "hello world\n", 0644)
+		m, ctx := newMemModule(t, fsys)
+		task := ensureFileTask(map[string]interface{}{"path": path, "content": "hello {{ name }}\n"})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !plan.WillSkip || plan.SkipReason != "File exists with correct content" {
+			t.Errorf("plan = %+v, want it to skip the file", plan)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if fsys.writes != 0 {
+			t.Errorf("wrote %d files with the same content, want none", fsys.writes)
+		}
+	})
+
+	t.Run("ModeOnly", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		fsys.addFile(path, "hello world\n", 0600)
+		m, ctx := newMemModule(t, fsys)
+		task := ensureFileTask(map[string]interface{}{"path": path, "content": "hello {{ name }}\n", "mode": "0640"})
+
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if fsys.writes != 0 {
+			t.Errorf("wrote the file to change its mode, want only a chmod")
+		}
+		if info, _ := fsys.Stat(path); info.Mode().Perm() != 0640 {
+			t.Errorf("mode = %04o, want 0640", info.Mode().Perm())
+		}
+	})
+
+	t.Run("UnreadableExistingFile", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		fsys.addFile(path, "secret\n", 0600)
+		fsys.readErrors[path] = fs.ErrPermission
+		m, ctx := newMemModule(t, fsys)
+		task := ensureFileTask(map[string]interface{}{"path": path, "content": "hello\n"})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Changes) == 0 || !strings.HasPrefix(plan.Changes[0], "Failed to read existing file, will recreate") {
+			t.Errorf("plan changes = %q, want the read failure", plan.Changes)
+		}
+
+		err = m.ExecuteTask(task, ctx)
+		if err == nil || !strings.Contains(err.Error(), "failed to read existing file") {
+			t.Errorf("ExecuteTask() error = %v, want the read failure", err)
+		}
+		if fsys.writes != 0 {
+			t.Error("replaced a file that could not be read")
+		}
+	})
+
+	t.Run("ParentDirectories", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		task := ensureFileTask(map[string]interface{}{"path": path, "content": "hello {{ name }}\n", "mode": "0600"})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"Create file", "Create parent directory " + filepath.Dir(path)}
+		if strings.Join(plan.Changes, "\n") != strings.Join(want, "\n") {
+			t.Errorf("plan changes = %q, want %q", plan.Changes, want)
+		}
+
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{filepath.Join(home, ".config"), filepath.Dir(path)} {
+			if info, err := fsys.Stat(dir); err != nil || !info.IsDir() || info.Mode().Perm() != 0755 {
+				t.Errorf("parent directory %s = %v, %v, want a directory with mode 0755", dir, info, err)
+			}
+		}
+		if content, _ := fsys.content(path); content != "hello world\n" {
+			t.Errorf("content = %q, want %q", content, "hello world\n")
+		}
+		if info, _ := fsys.Stat(path); info.Mode().Perm() != 0600 {
+			t.Errorf("mode = %04o, want 0600", info.Mode().Perm())
+		}
+	})
+
+	t.Run("ParentIsAFile", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		fsys.addFile(filepath.Dir(path), "not a directory", 0644)
+		m, ctx := newMemModule(t, fsys)
+
+		err := m.ExecuteTask(ensureFileTask(map[string]interface{}{"path": path, "content": "hello\n"}), ctx)
+		if err == nil || !strings.Contains(err.Error(), "failed to create parent directory") {
+			t.Errorf("ExecuteTask() error = %v, want the parent directory failure", err)
+		}
+	})
+
+	t.Run("CRLF", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		fsys.addFile(path, "[core]\n\tname = world\n", 0644)
+		m, ctx := newMemModule(t, fsys)
+		task := ensureFileTask(map[string]interface{}{"path": path, "content": "[core]\r\n\tname = {{ name }}\r\n"})
+
+		// Only the line endings differ, which is a change
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.WillSkip || len(plan.Changes) == 0 || plan.Changes[0] != "Update file content" {
+			t.Errorf("plan = %+v, want the content updated", plan)
+		}
+
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		want := "[core]\r\n\tname = world\r\n"
+		if content, _ := fsys.content(path); content != want {
+			t.Errorf("content = %q, want the line endings kept, %q", content, want)
+		}
+		if plan, err := m.PlanTask(task, ctx); err != nil || !plan.WillSkip {
+			t.Errorf("plan after apply = %+v, %v, want the file in sync", plan, err)
+		}
+	})
+
+	t.Run("ContentSource", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "gitconfig"), "[user]\n\tname = {{ name }}\n", 0644)
+		gitconfig := filepath.Join(home, ".gitconfig")
+
+		task := ensureFileTask(map[string]interface{}{"path": gitconfig, "content_source": "files/gitconfig"})
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := fsys.content(gitconfig); content != "[user]\n\tname = {{ name }}\n" {
+			t.Errorf("content = %q, want the source as it is", content)
+		}
+
+		task.Config["render"] = true
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := fsys.content(gitconfig); content != "[user]\n\tname = world\n" {
+			t.Errorf("rendered content = %q", content)
+		}
+
+		task.Config["content_source"] = "files/missing"
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.SkipCode != modules.SkipMissingSource {
+			t.Errorf("plan of a missing source = %+v, want it skipped", plan)
+		}
+	})
+
+	t.Run("TemplateFailure", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		fsys.addFile(filepath.Join(ctx.BasePath, "files", "broken"), "{% if name %}\n", 0644)
+
+		for _, taskConfig := range []map[string]interface{}{
+			{"path": path, "content": "{{ name | no_such_filter }}"},
+			{"path": path, "content_source": "files/broken", "render": true},
+		} {
+			task := ensureFileTask(taskConfig)
+			if _, err := m.PlanTask(task, ctx); err == nil {
+				t.Errorf("PlanTask(%v) succeeded, want the template error", taskConfig)
+			}
+			err := m.ExecuteTask(task, ctx)
+			if err == nil || !strings.Contains(err.Error(), "template") {
+				t.Errorf("ExecuteTask(%v) error = %v, want the template error", taskConfig, err)
+			}
+		}
+		if fsys.writes != 0 {
+			t.Errorf("wrote %d files whose template failed", fsys.writes)
+		}
+	})
+}
+
+func TestEnsureDirInMemory(t *testing.T) {
+	home := memHome(t)
+	path := filepath.Join(home, ".ssh", "keys")
+
+	t.Run("Create", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		m, ctx := newMemModule(t, fsys)
+		task := ensureDirTask(map[string]interface{}{"path": path, "mode": "0700"})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Changes) != 1 || plan.Changes[0] != "Create 2 directories" {
+			t.Errorf("plan changes = %q, want 2 directories created", plan.Changes)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := fsys.Stat(path); err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+			t.Errorf("directory = %v, %v, want it with mode 0700", info, err)
+		}
+		if plan, err := m.PlanTask(task, ctx); err != nil || !plan.WillSkip {
+			t.Errorf("plan after apply = %+v, %v, want it skipped", plan, err)
+		}
+	})
+
+	t.Run("ModeOnly", func(t *testing.T) {
+		fsys := newMemFileSystem(path)
+		m, ctx := newMemModule(t, fsys)
+		task := ensureDirTask(map[string]interface{}{"path": path, "mode": "0700"})
+
+		plan, err := m.PlanTask(task, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Changes) != 1 || plan.Changes[0] != "Update permissions from 0755 to 0700" {
+			t.Errorf("plan changes = %q, want the permissions updated", plan.Changes)
+		}
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := fsys.Stat(path); info.Mode().Perm() != 0700 {
+			t.Errorf("mode = %04o, want 0700", info.Mode().Perm())
+		}
+	})
+
+	t.Run("FileInTheWay", func(t *testing.T) {
+		fsys := newMemFileSystem(home)
+		fsys.addFile(path, "not a directory", 0644)
+		m, ctx := newMemModule(t, fsys)
+		task := ensureDirTask(map[string]interface{}{"path": path})
+
+		if err := m.ExecuteTask(task, ctx); err == nil || !strings.Contains(err.Error(), "set force: true") {
+			t.Errorf("ExecuteTask() error = %v, want force to be required", err)
+		}
+
+		task.Config["force"] = true
+		if err := m.ExecuteTask(task, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := fsys.Stat(path); err != nil || !info.IsDir() {
+			t.Errorf("path = %v, %v, want the file replaced with a directory", info, err)
+		}
+	})
+}
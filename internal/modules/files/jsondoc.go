@@ -0,0 +1,586 @@
+package files
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// jsonNode is a value in a JSON document with its position in the text, so the
+// document can be edited without reformatting the parts that do not change
+type jsonNode struct {
+	Kind    byte          // '{' for objects, '[' for arrays and 's' for other values
+	Start   int           // Offset of the first byte of the value
+	End     int           // Offset after the last byte of the value
+	Members []*jsonMember // Members of an object in document order
+	Value   interface{}   // Decoded value, numbers are json.Number
+}
+
+// jsonMember is a key and value of a JSON object
+type jsonMember struct {
+	Key   string
+	Start int // Offset of the key
+	Value *jsonNode
+}
+
+// member returns the index and member of an object with key, or -1 and nil
+func (n *jsonNode) member(key string) (int, *jsonMember) {
+	for i, member := range n.Members {
+		if member.Key == key {
+			return i, member
+		}
+	}
+	return -1, nil
+}
+
+// jsonSyntaxError is a parse error with the line and column it occurred at
+type jsonSyntaxError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *jsonSyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// jsonParser parses JSON with the extensions editors like VSCode allow in settings
+// files: // and /* */ comments and trailing commas
+type jsonParser struct {
+	src string
+	pos int
+}
+
+// parseJSONDocument parses a JSON document. An empty document returns nil.
+func parseJSONDocument(src string) (*jsonNode, error) {
+	p := &jsonParser{src: src}
+	if strings.HasPrefix(src, "\ufeff") {
+		p.pos = len("\ufeff")
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos == len(src) {
+		return nil, nil
+	}
+	node, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos != len(src) {
+		return nil, p.errorf("unexpected %s after the document", p.describe())
+	}
+	return node, nil
+}
+
+// errorf returns a syntax error at the current position
+func (p *jsonParser) errorf(format string, args ...interface{}) error {
+	line, column := 1, 1
+	for _, r := range p.src[:p.pos] {
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return &jsonSyntaxError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+// describe names the character at the current position for error messages
+func (p *jsonParser) describe() string {
+	if p.pos >= len(p.src) {
+		return "end of file"
+	}
+	return fmt.Sprintf("'%c'", p.src[p.pos])
+}
+
+// skip skips whitespace and comments
+func (p *jsonParser) skip() error {
+	for p.pos < len(p.src) {
+		switch {
+		case strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0:
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				return p.errorf("unterminated comment")
+			}
+			p.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// value parses any JSON value
+func (p *jsonParser) value() (*jsonNode, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value, got end of file")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"':
+		start := p.pos
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Kind: 's', Start: start, End: p.pos, Value: s}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	default:
+		for literal, value := range map[string]interface{}{"true": true, "false": false, "null": nil} {
+			if strings.HasPrefix(p.src[p.pos:], literal) {
+				start := p.pos
+				p.pos += len(literal)
+				return &jsonNode{Kind: 's', Start: start, End: p.pos, Value: value}, nil
+			}
+		}
+		return nil, p.errorf("expected a value, got %s", p.describe())
+	}
+}
+
+// object parses an object
+func (p *jsonParser) object() (*jsonNode, error) {
+	node := &jsonNode{Kind: '{', Start: p.pos}
+	decoded := make(map[string]interface{})
+	p.pos++
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == '}' {
+			break
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != '"' {
+			return nil, p.errorf("expected a key or '}', got %s", p.describe())
+		}
+
+		keyStart := p.pos
+		key, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+			return nil, p.errorf("expected ':' after key \"%s\", got %s", key, p.describe())
+		}
+		p.pos++
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		node.Members = append(node.Members, &jsonMember{Key: key, Start: keyStart, Value: value})
+		decoded[key] = value.Value
+
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != '}' {
+			return nil, p.errorf("expected ',' or '}' after the value of \"%s\", got %s", key, p.describe())
+		}
+		break
+	}
+	p.pos++
+	node.End = p.pos
+	node.Value = decoded
+	return node, nil
+}
+
+// array parses an array. Its items are not edited, so only the decoded value is kept.
+func (p *jsonParser) array() (*jsonNode, error) {
+	node := &jsonNode{Kind: '[', Start: p.pos}
+	decoded := []interface{}{}
+	p.pos++
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == ']' {
+			break
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, item.Value)
+
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+			return nil, p.errorf("expected ',' or ']', got %s", p.describe())
+		}
+		break
+	}
+	p.pos++
+	node.End = p.pos
+	node.Value = decoded
+	return node, nil
+}
+
+// str parses a string and returns its decoded value
+func (p *jsonParser) str() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '\n':
+			return "", p.errorf("unterminated string")
+		case '"':
+			p.pos++
+			var s string
+			if err := json.Unmarshal([]byte(p.src[start:p.pos]), &s); err != nil {
+				p.pos = start
+				return "", p.errorf("invalid string: %v", err)
+			}
+			return s, nil
+		}
+		p.pos++
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+// number parses a number
+func (p *jsonParser) number() (*jsonNode, error) {
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte("+-0123456789.eE", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	if !json.Valid([]byte(text)) {
+		p.pos = start
+		return nil, p.errorf("invalid number %s", text)
+	}
+	return &jsonNode{Kind: 's', Start: start, End: p.pos, Value: json.Number(text)}, nil
+}
+
+// jsonEdit replaces the text between Start and End of a document
+type jsonEdit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// jsonFormat is the formatting style new values are written in, detected from the
+// existing document
+type jsonFormat struct {
+	Indent  string // One level of indentation
+	Compact bool   // Whether the document is written on a single line
+}
+
+// detectJSONFormat detects the indentation of a document from its first indented
+// line. Documents without any indentation use two spaces.
+func detectJSONFormat(src string) *jsonFormat {
+	format := &jsonFormat{Indent: "  ", Compact: !strings.Contains(strings.TrimSpace(src), "\n")}
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || len(trimmed) == len(line) {
+			continue
+		}
+		format.Indent = line[:len(line)-len(trimmed)]
+		break
+	}
+	return format
+}
+
+// marshal formats a value for insertion at the given indentation
+func (f *jsonFormat) marshal(value interface{}, indent string) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if !f.Compact {
+		encoder.SetIndent(indent, f.Indent)
+	}
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// lineIndent returns the leading whitespace of the line containing offset
+func lineIndent(src string, offset int) string {
+	start := strings.LastIndexByte(src[:offset], '\n') + 1
+	line := src[start:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// memberIndent returns the indentation of the members of an object
+func (f *jsonFormat) memberIndent(src string, object *jsonNode) string {
+	if len(object.Members) > 0 {
+		first := object.Members[0].Start
+		lineStart := strings.LastIndexByte(src[:first], '\n') + 1
+		if strings.TrimSpace(src[lineStart:first]) == "" {
+			return src[lineStart:first]
+		}
+	}
+	return lineIndent(src, object.Start) + f.Indent
+}
+
+// mergeJSON deep merges data into the object of a document. Objects are merged key
+// by key, any other value replaces the existing one.
+func mergeJSON(src string, object *jsonNode, data map[string]interface{}, path []string, format *jsonFormat) ([]jsonEdit, []*docChange, error) {
+	var edits []jsonEdit
+	var changes []*docChange
+	var added []string
+	indent := format.memberIndent(src, object)
+
+	// Objects written on a single line in an indented document stay on one line
+	objectFormat, separator, comma := format, ": ", ","
+	if format.Compact {
+		separator = ":"
+	} else if len(object.Members) > 0 && !strings.Contains(src[object.Start:object.End], "\n") {
+		objectFormat, comma = &jsonFormat{Indent: format.Indent, Compact: true}, ", "
+	}
+
+	for _, key := range sortedDataKeys(data) {
+		value := data[key]
+		keyPath := append(append([]string{}, path...), key)
+		_, member := object.member(key)
+
+		if member == nil {
+			text, err := objectFormat.marshal(value, indent)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to format %s: %w", joinKeyPath(keyPath), err)
+			}
+			quoted, _ := objectFormat.marshal(key, indent)
+			added = append(added, quoted+separator+text)
+			changes = append(changes, &docChange{Kind: "add", Path: keyPath, New: value})
+			continue
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok && member.Value.Kind == '{' {
+			nestedEdits, nestedChanges, err := mergeJSON(src, member.Value, nested, keyPath, format)
+			if err != nil {
+				return nil, nil, err
+			}
+			edits = append(edits, nestedEdits...)
+			changes = append(changes, nestedChanges...)
+			continue
+		}
+
+		if reflect.DeepEqual(member.Value.Value, value) {
+			continue
+		}
+		text, err := objectFormat.marshal(value, lineIndent(src, member.Start))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to format %s: %w", joinKeyPath(keyPath), err)
+		}
+		edits = append(edits, jsonEdit{Start: member.Value.Start, End: member.Value.End, Text: text})
+		changes = append(changes, &docChange{Kind: "update", Path: keyPath, Old: member.Value.Value, New: value})
+	}
+
+	if len(added) == 0 {
+		return edits, changes, nil
+	}
+
+	// New keys go after the last member, or fill an empty object
+	if objectFormat.Compact {
+		text := strings.Join(added, comma)
+		if len(object.Members) == 0 {
+			return append(edits, jsonEdit{Start: object.Start + 1, End: object.End - 1, Text: text}), changes, nil
+		}
+		last := object.Members[len(object.Members)-1].Value.End
+		return append(edits, jsonEdit{Start: last, End: last, Text: comma + text}), changes, nil
+	}
+
+	text := "\n" + indent + strings.Join(added, ",\n"+indent)
+	if len(object.Members) == 0 {
+		text += "\n" + lineIndent(src, object.Start)
+		return append(edits, jsonEdit{Start: object.Start + 1, End: object.End - 1, Text: text}), changes, nil
+	}
+
+	// Comments after the last value stay on its line
+	last := object.Members[len(object.Members)-1].Value.End
+	lineEnd, hasComma := endOfValueLine(src, last)
+	if lineEnd == last {
+		return append(edits, jsonEdit{Start: last, End: last, Text: "," + text}), changes, nil
+	}
+	if !hasComma {
+		edits = append(edits, jsonEdit{Start: last, End: last, Text: ","})
+	}
+	return append(edits, jsonEdit{Start: lineEnd, End: lineEnd, Text: text}), changes, nil
+}
+
+// endOfValueLine returns where the line of a value ends when only a comma and
+// comments follow the value on it, or the end of the value otherwise, and whether the
+// value is followed by a comma
+func endOfValueLine(src string, end int) (int, bool) {
+	pos := end
+	hasComma := false
+	for pos < len(src) {
+		switch {
+		case src[pos] == ' ' || src[pos] == '\t':
+			pos++
+		case src[pos] == ',' && !hasComma:
+			hasComma = true
+			pos++
+		case strings.HasPrefix(src[pos:], "//"):
+			newline := strings.IndexByte(src[pos:], '\n')
+			if newline < 0 {
+				return len(src), hasComma
+			}
+			pos += newline
+		case strings.HasPrefix(src[pos:], "/*"):
+			close := strings.Index(src[pos:], "*/")
+			if close < 0 || strings.Contains(src[pos:pos+close], "\n") {
+				return end, false
+			}
+			pos += close + 2
+		case src[pos] == '\r' || src[pos] == '\n':
+			return pos, hasComma
+		default:
+			return end, false
+		}
+	}
+	return pos, hasComma
+}
+
+// removeJSONKeys removes the members at key paths from a document. Paths that do
+// not exist are ignored.
+func removeJSONKeys(object *jsonNode, keys []string) ([]jsonEdit, []*docChange) {
+	type removal struct {
+		Parent *jsonNode
+		Index  int
+		Path   []string
+	}
+	var removals []removal
+	var paths [][]string
+	for _, key := range keys {
+		parent, index, path := resolveJSONKey(object, strings.Split(key, "."), nil)
+		if parent != nil {
+			removals = append(removals, removal{Parent: parent, Index: index, Path: path})
+			paths = append(paths, path)
+		}
+	}
+
+	removed := make(map[*jsonNode]map[int]bool)
+	var changes []*docChange
+	for _, r := range removals {
+		// Keys inside a removed key go with it
+		if removed[r.Parent][r.Index] || insideRemovedPath(r.Path, paths) {
+			continue
+		}
+		if removed[r.Parent] == nil {
+			removed[r.Parent] = make(map[int]bool)
+		}
+		removed[r.Parent][r.Index] = true
+		changes = append(changes, &docChange{Kind: "remove", Path: r.Path, Old: r.Parent.Members[r.Index].Value.Value})
+	}
+
+	var edits []jsonEdit
+	for parent, indexes := range removed {
+		edits = append(edits, removeJSONMembers(parent, indexes)...)
+	}
+	return edits, changes
+}
+
+// insideRemovedPath reports whether one of paths is a parent of path
+func insideRemovedPath(path []string, paths [][]string) bool {
+	for _, other := range paths {
+		if len(other) < len(path) && reflect.DeepEqual(other, path[:len(other)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveJSONKey finds the member a dot separated key path refers to. Keys may contain
+// dots themselves, like "editor.fontSize" in VSCode settings, so the longest key
+// that exists wins at every level.
+func resolveJSONKey(object *jsonNode, parts, path []string) (*jsonNode, int, []string) {
+	for i := len(parts); i > 0; i-- {
+		key := strings.Join(parts[:i], ".")
+		index, member := object.member(key)
+		if member == nil {
+			continue
+		}
+		keyPath := append(append([]string{}, path...), key)
+		if i == len(parts) {
+			return object, index, keyPath
+		}
+		if member.Value.Kind == '{' {
+			if parent, index, found := resolveJSONKey(member.Value, parts[i:], keyPath); parent != nil {
+				return parent, index, found
+			}
+		}
+	}
+	return nil, -1, nil
+}
+
+// removeJSONMembers returns the edits removing members of an object with their
+// separating commas. Every run of removed members is cut from the end of the member
+// before it, or up to the member after it when the run starts the object.
+func removeJSONMembers(object *jsonNode, indexes map[int]bool) []jsonEdit {
+	var edits []jsonEdit
+	for i := 0; i < len(object.Members); {
+		if !indexes[i] {
+			i++
+			continue
+		}
+		end := i
+		for end+1 < len(object.Members) && indexes[end+1] {
+			end++
+		}
+		switch {
+		case i > 0:
+			edits = append(edits, jsonEdit{Start: object.Members[i-1].Value.End, End: object.Members[end].Value.End})
+		case end+1 < len(object.Members):
+			edits = append(edits, jsonEdit{Start: object.Members[0].Start, End: object.Members[end+1].Start})
+		default:
+			edits = append(edits, jsonEdit{Start: object.Start + 1, End: object.End - 1})
+		}
+		i = end + 1
+	}
+	return edits
+}
+
+// applyJSONEdits applies non-overlapping edits to a document
+func applyJSONEdits(src string, edits []jsonEdit) string {
+	sorted := append([]jsonEdit{}, edits...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Start != sorted[j].Start {
+			return sorted[i].Start > sorted[j].Start
+		}
+		return sorted[i].End > sorted[j].End
+	})
+	for _, edit := range sorted {
+		src = src[:edit.Start] + edit.Text + src[edit.End:]
+	}
+	return src
+}
@@ -0,0 +1,350 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// lineInFileOptions holds the rendered configuration of a line_in_file task
+type lineInFileOptions struct {
+	Line         string
+	State        string
+	Regexp       *regexp.Regexp
+	InsertAfter  *regexp.Regexp
+	InsertBefore *regexp.Regexp
+}
+
+// lineEdit describes a single line that line_in_file adds, replaces or removes
+type lineEdit struct {
+	Kind    string // "add", "replace" or "remove"
+	LineNum int    // 1-based line number in the original file, or of the new line for additions
+	OldLine string
+	NewLine string
+}
+
+// validateLineInFileTask validates line_in_file task configuration
+func (m *FilesModule) validateLineInFileTask(config map[string]interface{}) error {
+	if _, exists := config["path"]; !exists {
+		return fmt.Errorf("line_in_file task requires 'path' field")
+	}
+	if _, ok := modules.StringValue(config["path"]); !ok {
+		return fmt.Errorf("line_in_file 'path' must be a string")
+	}
+
+	for _, field := range []string{"line", "state", "regexp", "insert_after", "insert_before"} {
+		if value, exists := config[field]; exists {
+			if _, ok := modules.StringValue(value); !ok {
+				return fmt.Errorf("line_in_file '%s' must be a string", field)
+			}
+		}
+	}
+
+	state := "present"
+	if stateStr, exists := config["state"]; exists {
+		state, _ = modules.StringValue(stateStr)
+	}
+	if state != "present" && state != "absent" {
+		return fmt.Errorf("line_in_file 'state' must be 'present' or 'absent', got '%s'", state)
+	}
+
+	_, hasLine := config["line"]
+	_, hasRegexp := config["regexp"]
+	if state == "present" && !hasLine {
+		return fmt.Errorf("line_in_file with state 'present' requires 'line' field")
+	}
+	if state == "absent" && !hasLine && !hasRegexp {
+		return fmt.Errorf("line_in_file with state 'absent' requires 'line' or 'regexp' field")
+	}
+
+	_, hasAfter := config["insert_after"]
+	_, hasBefore := config["insert_before"]
+	if hasAfter && hasBefore {
+		return fmt.Errorf("line_in_file 'insert_after' and 'insert_before' are mutually exclusive")
+	}
+
+	for _, field := range []string{"regexp", "insert_after", "insert_before"} {
+		if pattern, exists := config[field]; exists {
+			patternStr, _ := modules.StringValue(pattern)
+			if _, err := regexp.Compile(patternStr); err != nil {
+				return fmt.Errorf("line_in_file '%s' is not a valid regular expression: %w", field, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseLineInFileOptions renders and compiles the options of a line_in_file task
+func (m *FilesModule) parseLineInFileOptions(task *config.Task, ctx *modules.ExecutionContext) (string, *lineInFileOptions, error) {
+	path, err := m.processField(task, "path", ctx.Variables)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to process path template: %w", err)
+	}
+	path, err = utils.ExpandPath(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	opts := &lineInFileOptions{State: "present"}
+	if state, ok := modules.StringValue(task.Config["state"]); ok {
+		opts.State = state
+	}
+	if line, ok := modules.StringValue(task.Config["line"]); ok {
+		opts.Line, err = m.processTemplateWithPathConversion(line, ctx.Variables, false)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to process line template for %s: %w", path, err)
+		}
+		// A line never contains its own line ending
+		opts.Line = strings.TrimRight(opts.Line, "\r\n")
+	}
+
+	patterns := map[string]**regexp.Regexp{
+		"regexp":        &opts.Regexp,
+		"insert_after":  &opts.InsertAfter,
+		"insert_before": &opts.InsertBefore,
+	}
+	for field, target := range patterns {
+		if pattern, ok := modules.StringValue(task.Config[field]); ok {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid '%s' pattern: %w", field, err)
+			}
+			*target = compiled
+		}
+	}
+
+	return path, opts, nil
+}
+
+// applyLineInFile returns the content with the line ensured present or absent and
+// the edits that were made. Only edited lines are touched, so existing line endings
+// are preserved and new lines use the file's dominant line ending.
+func applyLineInFile(content string, opts *lineInFileOptions) (string, []lineEdit) {
+	lines, eol := splitLines(content)
+
+	if opts.State == "absent" {
+		var kept []string
+		var edits []lineEdit
+		for i, line := range lines {
+			matches := lineText(line) == opts.Line && opts.Regexp == nil
+			if opts.Regexp != nil {
+				matches = opts.Regexp.MatchString(lineText(line))
+			}
+			if matches {
+				edits = append(edits, lineEdit{Kind: "remove", LineNum: i + 1, OldLine: lineText(line)})
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if len(edits) == 0 {
+			return content, nil
+		}
+		return strings.Join(kept, ""), edits
+	}
+
+	// Replace the last line matching regexp
+	if opts.Regexp != nil {
+		for i := len(lines) - 1; i >= 0; i-- {
+			if !opts.Regexp.MatchString(lineText(lines[i])) {
+				continue
+			}
+			if lineText(lines[i]) == opts.Line {
+				return content, nil
+			}
+			edit := lineEdit{Kind: "replace", LineNum: i + 1, OldLine: lineText(lines[i]), NewLine: opts.Line}
+			lines[i] = opts.Line + lineEnding(lines[i])
+			return strings.Join(lines, ""), []lineEdit{edit}
+		}
+	}
+
+	// Nothing to do when the exact line already exists
+	for _, line := range lines {
+		if lineText(line) == opts.Line {
+			return content, nil
+		}
+	}
+
+	insertAt := len(lines)
+	switch {
+	case opts.InsertAfter != nil:
+		for i := len(lines) - 1; i >= 0; i-- {
+			if opts.InsertAfter.MatchString(lineText(lines[i])) {
+				insertAt = i + 1
+				break
+			}
+		}
+	case opts.InsertBefore != nil:
+		for i, line := range lines {
+			if opts.InsertBefore.MatchString(lineText(line)) {
+				insertAt = i
+				break
+			}
+		}
+	}
+
+	// Terminate the previous line if the file does not end with a newline
+	if insertAt > 0 && lineEnding(lines[insertAt-1]) == "" {
+		lines[insertAt-1] += eol
+	}
+
+	newLines := make([]string, 0, len(lines)+1)
+	newLines = append(newLines, lines[:insertAt]...)
+	newLines = append(newLines, opts.Line+eol)
+	newLines = append(newLines, lines[insertAt:]...)
+
+	return strings.Join(newLines, ""), []lineEdit{{Kind: "add", LineNum: insertAt + 1, NewLine: opts.Line}}
+}
+
+// splitLines splits content into lines that keep their own line endings and
+// returns the line ending new lines should use
+func splitLines(content string) ([]string, string) {
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	eol := "\n"
+	if strings.Contains(content, "\r\n") {
+		eol = "\r\n"
+	}
+	return lines, eol
+}
+
+// lineText returns a line without its line ending
+func lineText(line string) string {
+	return strings.TrimRight(line, "\r\n")
+}
+
+// lineEnding returns the line ending of a line, empty for the last line of a file without one
+func lineEnding(line string) string {
+	return line[len(lineText(line)):]
+}
+
+// describeLineEdits formats edits as plan changes with a small diff
+func describeLineEdits(edits []lineEdit) []string {
+	var changes []string
+	for _, edit := range edits {
+		switch edit.Kind {
+		case "add":
+			changes = append(changes, fmt.Sprintf("Add line %d", edit.LineNum))
+			changes = append(changes, fmt.Sprintf("  + %d: %s", edit.LineNum, edit.NewLine))
+		case "replace":
+			changes = append(changes, fmt.Sprintf("Replace line %d", edit.LineNum))
+			changes = append(changes, fmt.Sprintf("  - %d: %s", edit.LineNum, edit.OldLine))
+			changes = append(changes, fmt.Sprintf("  + %d: %s", edit.LineNum, edit.NewLine))
+		case "remove":
+			changes = append(changes, fmt.Sprintf("Remove line %d", edit.LineNum))
+			changes = append(changes, fmt.Sprintf("  - %d: %s", edit.LineNum, edit.OldLine))
+		}
+	}
+	return changes
+}
+
+// readEditTarget reads a file that is edited in place, treating a missing file as empty
+func readEditTarget(path string) (string, bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read file: %w", err)
+	}
+	return string(data), true, nil
+}
+
+// planLineInFile returns what line_in_file would do
+func (m *FilesModule) planLineInFile(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	path, opts, err := m.parseLineInFileOptions(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("Ensure line is %s in %s", opts.State, path)
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: description,
+		Changes:     []string{},
+	}
+
+	content, exists, err := readEditTarget(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists && opts.State == "absent" {
+		plan.WillSkip = true
+		plan.SkipReason = "File does not exist"
+		return plan, nil
+	}
+
+	_, edits := applyLineInFile(content, opts)
+	if len(edits) == 0 {
+		plan.WillSkip = true
+		if opts.State == "absent" {
+			plan.SkipReason = "Line is already absent"
+		} else {
+			plan.SkipReason = "Line is already present"
+		}
+		return plan, nil
+	}
+
+	if !exists {
+		plan.Changes = append(plan.Changes, "Create file")
+	}
+	plan.Changes = append(plan.Changes, describeLineEdits(edits)...)
+
+	return plan, nil
+}
+
+// executeLineInFile ensures a line is present in or absent from a file
+func (m *FilesModule) executeLineInFile(task *config.Task, ctx *modules.ExecutionContext) error {
+	path, opts, err := m.parseLineInFileOptions(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	content, exists, err := readEditTarget(path)
+	if err != nil {
+		return err
+	}
+	if !exists && opts.State == "absent" {
+		return nil
+	}
+
+	newContent, edits := applyLineInFile(content, opts)
+	if len(edits) == 0 {
+		if ctx.Verbose {
+			fmt.Printf("Line already %s: %s\n", opts.State, path)
+		}
+		return nil
+	}
+
+	// Preserve the mode of the existing file
+	mode := os.FileMode(0644)
+	if exists {
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	} else if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	if ctx.Verbose {
+		for _, change := range describeLineEdits(edits) {
+			fmt.Printf("%s: %s\n", path, change)
+		}
+	}
+
+	if err := utils.WriteFileAtomic(path, []byte(newContent), mode); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestApplyLineInFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		opts     *lineInFileOptions
+		expected string
+		edits    int
+	}{
+		{
+			name:     "appends missing line",
+			content:  "a\nb\n",
+			opts:     &lineInFileOptions{Line: "c", State: "present"},
+			expected: "a\nb\nc\n",
+			edits:    1,
+		},
+		{
+			name:     "terminates last line before appending",
+			content:  "a\nb",
+			opts:     &lineInFileOptions{Line: "c", State: "present"},
+			expected: "a\nb\nc\n",
+			edits:    1,
+		},
+		{
+			name:     "keeps existing line",
+			content:  "a\nb\n",
+			opts:     &lineInFileOptions{Line: "b", State: "present"},
+			expected: "a\nb\n",
+		},
+		{
+			name:     "replaces last regexp match",
+			content:  "export EDITOR=vi\nfoo\nexport EDITOR=nano\n",
+			opts:     &lineInFileOptions{Line: "export EDITOR=nvim", State: "present", Regexp: regexp.MustCompile(`^export EDITOR=`)},
+			expected: "export EDITOR=vi\nfoo\nexport EDITOR=nvim\n",
+			edits:    1,
+		},
+		{
+			name:     "regexp match already correct",
+			content:  "export EDITOR=nvim\n",
+			opts:     &lineInFileOptions{Line: "export EDITOR=nvim", State: "present", Regexp: regexp.MustCompile(`^export EDITOR=`)},
+			expected: "export EDITOR=nvim\n",
+		},
+		{
+			name:     "inserts after last anchor",
+			content:  "# aliases\nalias a=b\n# aliases\nalias c=d\n",
+			opts:     &lineInFileOptions{Line: "source x", State: "present", InsertAfter: regexp.MustCompile(`^# aliases`)},
+			expected: "# aliases\nalias a=b\n# aliases\nsource x\nalias c=d\n",
+			edits:    1,
+		},
+		{
+			name:     "inserts before first anchor",
+			content:  "a\n# end\nb\n# end\n",
+			opts:     &lineInFileOptions{Line: "x", State: "present", InsertBefore: regexp.MustCompile(`^# end`)},
+			expected: "a\nx\n# end\nb\n# end\n",
+			edits:    1,
+		},
+		{
+			name:     "missing anchor appends",
+			content:  "a\n",
+			opts:     &lineInFileOptions{Line: "x", State: "present", InsertAfter: regexp.MustCompile(`^nope`)},
+			expected: "a\nx\n",
+			edits:    1,
+		},
+		{
+			name:     "removes exact line",
+			content:  "a\nb\na\n",
+			opts:     &lineInFileOptions{Line: "a", State: "absent"},
+			expected: "b\n",
+			edits:    2,
+		},
+		{
+			name:     "removes regexp matches",
+			content:  "alias ll=ls\nalias la=ls\nfoo\n",
+			opts:     &lineInFileOptions{State: "absent", Regexp: regexp.MustCompile(`^alias l`)},
+			expected: "foo\n",
+			edits:    2,
+		},
+		{
+			name:     "absent line is a no-op",
+			content:  "a\n",
+			opts:     &lineInFileOptions{Line: "b", State: "absent"},
+			expected: "a\n",
+		},
+		{
+			name:     "creates content for empty file",
+			content:  "",
+			opts:     &lineInFileOptions{Line: "a", State: "present"},
+			expected: "a\n",
+			edits:    1,
+		},
+		{
+			name:     "preserves CRLF line endings",
+			content:  "a\r\nb\r\n",
+			opts:     &lineInFileOptions{Line: "c", State: "present", InsertAfter: regexp.MustCompile(`^a$`)},
+			expected: "a\r\nc\r\nb\r\n",
+			edits:    1,
+		},
+		{
+			name:     "replace keeps mixed line endings",
+			content:  "a\r\nb\nc\r\n",
+			opts:     &lineInFileOptions{Line: "B", State: "present", Regexp: regexp.MustCompile(`^b$`)},
+			expected: "a\r\nB\nc\r\n",
+			edits:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, edits := applyLineInFile(tt.content, tt.opts)
+			if result != tt.expected {
+				t.Errorf("applyLineInFile() = %q, want %q", result, tt.expected)
+			}
+			if len(edits) != tt.edits {
+				t.Errorf("applyLineInFile() made %d edits, want %d", len(edits), tt.edits)
+			}
+
+			// Applying again must not change anything
+			again, edits := applyLineInFile(result, tt.opts)
+			if again != result || len(edits) != 0 {
+				t.Errorf("second apply was not idempotent: %q, %d edits", again, len(edits))
+			}
+		})
+	}
+}
+
+func TestLineInFileIdempotentAndPreservesMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+	task := &config.Task{
+		ID:     "hosts",
+		Action: "line_in_file",
+		Config: map[string]interface{}{"path": path, "line": "127.0.0.1 dev.local"},
+	}
+	if err := m.ValidateTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.WillSkip || len(plan.Changes) != 2 || plan.Changes[1] != "  + 2: 127.0.0.1 dev.local" {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip {
+		t.Errorf("second plan should skip, got %v", plan.Changes)
+	}
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "127.0.0.1 localhost\n127.0.0.1 dev.local\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(path)
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("mode = %04o, want 0600", info.Mode().Perm())
+		}
+	}
+}
+
+func TestValidateLineInFileTask(t *testing.T) {
+	m := &FilesModule{}
+
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{"valid present", map[string]interface{}{"path": "/tmp/x", "line": "a"}, false},
+		{"valid absent by regexp", map[string]interface{}{"path": "/tmp/x", "regexp": "^a", "state": "absent"}, false},
+		{"missing path", map[string]interface{}{"line": "a"}, true},
+		{"present without line", map[string]interface{}{"path": "/tmp/x"}, true},
+		{"absent without line or regexp", map[string]interface{}{"path": "/tmp/x", "state": "absent"}, true},
+		{"invalid state", map[string]interface{}{"path": "/tmp/x", "line": "a", "state": "latest"}, true},
+		{"invalid regexp", map[string]interface{}{"path": "/tmp/x", "line": "a", "regexp": "("}, true},
+		{"both anchors", map[string]interface{}{"path": "/tmp/x", "line": "a", "insert_after": "x", "insert_before": "y"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.validateLineInFileTask(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLineInFileTask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package files
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxChangeValueLength limits how much of a value plan output shows
+const maxChangeValueLength = 60
+
+// mergeDocOptions holds the rendered configuration of a merge_json or merge_yaml task
+type mergeDocOptions struct {
+	Format string                 // "json" or "yaml"
+	State  string                 // "present" merges Data, "absent" removes Keys
+	Data   map[string]interface{} // Rendered data to merge
+	Keys   []string               // Dot separated key paths to remove
+}
+
+// docChange describes a single key merge_json or merge_yaml adds, updates or removes
+type docChange struct {
+	Kind string // "add", "update" or "remove"
+	Path []string
+	Old  interface{}
+	New  interface{}
+}
+
+// String formats the change for plan output, e.g. "Update editor.fontSize: 13 → 14"
+func (c *docChange) String() string {
+	switch c.Kind {
+	case "add":
+		return fmt.Sprintf("Add %s: %s", joinKeyPath(c.Path), formatDocValue(c.New))
+	case "update":
+		return fmt.Sprintf("Update %s: %s → %s", joinKeyPath(c.Path), formatDocValue(c.Old), formatDocValue(c.New))
+	default:
+		return fmt.Sprintf("Remove %s: %s", joinKeyPath(c.Path), formatDocValue(c.Old))
+	}
+}
+
+// joinKeyPath formats a key path in dot notation
+func joinKeyPath(path []string) string {
+	return strings.Join(path, ".")
+}
+
+// formatDocValue formats a value on a single line, shortened when it is long
+func formatDocValue(value interface{}) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	text := fmt.Sprintf("%v", value)
+	if err := encoder.Encode(jsonCompatible(value)); err == nil {
+		text = strings.TrimSuffix(buf.String(), "\n")
+	}
+	if utf8.RuneCountInString(text) > maxChangeValueLength {
+		text = string([]rune(text)[:maxChangeValueLength-1]) + "…"
+	}
+	return text
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values YAML decodes
+// non-string keys into, so they can be formatted as JSON
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprintf("%v", key)] = jsonCompatible(item)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[key] = jsonCompatible(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = jsonCompatible(item)
+		}
+		return converted
+	}
+	return value
+}
+
+// sortedDataKeys returns the keys of data in alphabetical order, which is the order
+// new keys are added in
+func sortedDataKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateMergeDocTask validates merge_json and merge_yaml task configuration
+func (m *FilesModule) validateMergeDocTask(action string, config map[string]interface{}) error {
+	path, exists := config["path"]
+	if !exists {
+		return fmt.Errorf("%s task requires 'path' field", action)
+	}
+	if _, ok := modules.StringValue(path); !ok {
+		return fmt.Errorf("%s 'path' must be a string", action)
+	}
+
+	state := "present"
+	if value, exists := config["state"]; exists {
+		stateStr, ok := modules.StringValue(value)
+		if !ok {
+			return fmt.Errorf("%s 'state' must be a string", action)
+		}
+		state = stateStr
+	}
+	if state != "present" && state != "absent" {
+		return fmt.Errorf("%s 'state' must be 'present' or 'absent', got '%s'", action, state)
+	}
+
+	data, hasData := config["data"]
+	key, hasKey := config["key"]
+	if state == "present" {
+		if !hasData {
+			return fmt.Errorf("%s with state 'present' requires 'data' field", action)
+		}
+		if _, ok := data.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s 'data' must be a map of keys to values", action)
+		}
+		if hasKey {
+			return fmt.Errorf("%s 'key' is only used with state 'absent', use 'data' to set keys", action)
+		}
+		return nil
+	}
+
+	if !hasKey {
+		return fmt.Errorf("%s with state 'absent' requires 'key' field", action)
+	}
+	if hasData {
+		return fmt.Errorf("%s 'data' is not used with state 'absent', list the keys to remove in 'key'", action)
+	}
+	if _, err := parseKeyPaths(key); err != nil {
+		return fmt.Errorf("%s %w", action, err)
+	}
+	return nil
+}
+
+// parseKeyPaths converts the key field, a single key path or a list of them, to a list
+func parseKeyPaths(value interface{}) ([]string, error) {
+	var keys []string
+	switch v := value.(type) {
+	case string:
+		keys = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			key, ok := modules.StringValue(item)
+			if !ok {
+				return nil, fmt.Errorf("'key' must be a list of key paths, got %v", item)
+			}
+			keys = append(keys, key)
+		}
+	default:
+		return nil, fmt.Errorf("'key' must be a key path or a list of key paths, got %T", value)
+	}
+	for _, key := range keys {
+		if strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("'key' must not contain empty key paths")
+		}
+	}
+	return keys, nil
+}
+
+// parseMergeDocOptions renders the options of a merge_json or merge_yaml task
+func (m *FilesModule) parseMergeDocOptions(task *config.Task, ctx *modules.ExecutionContext) (string, *mergeDocOptions, error) {
+	path, err := m.processField(task, "path", ctx.Variables)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to process path template: %w", err)
+	}
+	path, err = utils.ExpandPath(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	opts := &mergeDocOptions{Format: strings.TrimPrefix(task.Action, "merge_"), State: "present"}
+	if state, ok := modules.StringValue(task.Config["state"]); ok {
+		opts.State = state
+	}
+
+	if opts.State == "absent" {
+		opts.Keys, err = parseKeyPaths(task.Config["key"])
+		return path, opts, err
+	}
+
+	data, ok := task.Config["data"].(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("%s 'data' must be a map of keys to values", task.Action)
+	}
+	rendered, err := m.renderDocData(data, ctx.Variables)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to process data template for %s: %w", path, err)
+	}
+
+	// Round trip through the document format so values compare equal to what is
+	// read back from the file
+	if opts.Format == "json" {
+		opts.Data, err = normalizeJSONData(rendered)
+	} else {
+		opts.Data, err = normalizeYAMLData(rendered)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data for %s: %w", path, err)
+	}
+	return path, opts, nil
+}
+
+// renderDocData renders the templates in the string values of data. Keys are not
+// rendered, and rendered values stay strings.
+func (m *FilesModule) renderDocData(value interface{}, variables map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return m.processTemplateWithPathConversion(v, variables, false)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			renderedItem, err := m.renderDocData(item, variables)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			rendered[key] = renderedItem
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			renderedItem, err := m.renderDocData(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = renderedItem
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+// normalizeJSONData converts data to the types the JSON parser decodes
+func normalizeJSONData(data interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(jsonCompatible(data))
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var normalized map[string]interface{}
+	if err := decoder.Decode(&normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// normalizeYAMLData converts data to the types YAML decodes
+func normalizeYAMLData(data interface{}) (map[string]interface{}, error) {
+	encoded, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	if err := yaml.Unmarshal(encoded, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// applyMergeDoc returns the content of a document with the data merged into it, or
+// the keys removed, and the changes that were made. The existing document is never
+// replaced when it cannot be parsed.
+func applyMergeDoc(path, content string, opts *mergeDocOptions) (string, []*docChange, error) {
+	if opts.Format == "yaml" {
+		return applyMergeYAML(path, content, opts)
+	}
+
+	root, err := parseJSONDocument(content)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	format := detectJSONFormat(content)
+	if root == nil {
+		if opts.State == "absent" {
+			return content, nil, nil
+		}
+		// An empty file is written from scratch
+		content = "{}\n"
+		root = &jsonNode{Kind: '{', Start: 0, End: 2, Value: map[string]interface{}{}}
+		format.Compact = false
+	}
+	if root.Kind != '{' {
+		return "", nil, fmt.Errorf("failed to parse %s: the document must be an object", path)
+	}
+
+	var edits []jsonEdit
+	var changes []*docChange
+	if opts.State == "absent" {
+		edits, changes = removeJSONKeys(root, opts.Keys)
+	} else {
+		edits, changes, err = mergeJSON(content, root, opts.Data, nil, format)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if len(changes) == 0 {
+		return content, nil, nil
+	}
+
+	merged := applyJSONEdits(content, edits)
+	if _, err := parseJSONDocument(merged); err != nil {
+		return "", nil, fmt.Errorf("merging into %s produced invalid JSON: %w", path, err)
+	}
+	return merged, changes, nil
+}
+
+// applyMergeYAML is applyMergeDoc for YAML documents
+func applyMergeYAML(path, content string, opts *mergeDocOptions) (string, []*docChange, error) {
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var changes []*docChange
+	if opts.State == "absent" {
+		changes, err = removeYAMLKeys(doc.Content[0], opts.Keys)
+	} else {
+		changes, err = mergeYAML(doc.Content[0], opts.Data, nil)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if len(changes) == 0 {
+		return content, nil, nil
+	}
+
+	merged, err := encodeYAMLDocument(doc, yamlIndent(content))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to format %s: %w", path, err)
+	}
+	return merged, changes, nil
+}
+
+// planMergeDoc returns what merge_json or merge_yaml would do
+func (m *FilesModule) planMergeDoc(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	path, opts, err := m.parseMergeDocOptions(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("Merge keys into %s", path)
+	if opts.State == "absent" {
+		description = fmt.Sprintf("Remove keys from %s", path)
+	}
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: description,
+		Changes:     []string{},
+	}
+
+	content, exists, err := readEditTarget(path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && opts.State == "absent" {
+		plan.WillSkip = true
+		plan.SkipReason = "File does not exist"
+		return plan, nil
+	}
+
+	_, changes, err := applyMergeDoc(path, content, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		plan.WillSkip = true
+		if opts.State == "absent" {
+			plan.SkipReason = "Keys are already absent"
+		} else {
+			plan.SkipReason = "Keys are already up to date"
+		}
+		return plan, nil
+	}
+
+	if !exists {
+		plan.Changes = append(plan.Changes, "Create file")
+	}
+	for _, change := range changes {
+		plan.Changes = append(plan.Changes, change.String())
+	}
+	return plan, nil
+}
+
+// executeMergeDoc merges data into or removes keys from a JSON or YAML file
+func (m *FilesModule) executeMergeDoc(task *config.Task, ctx *modules.ExecutionContext) error {
+	path, opts, err := m.parseMergeDocOptions(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	content, exists, err := readEditTarget(path)
+	if err != nil {
+		return err
+	}
+	if !exists && opts.State == "absent" {
+		return nil
+	}
+
+	merged, changes, err := applyMergeDoc(path, content, opts)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		if ctx.Verbose {
+			fmt.Printf("Keys already %s: %s\n", opts.State, path)
+		}
+		return nil
+	}
+
+	// Preserve the mode of the existing file
+	mode := os.FileMode(0644)
+	if exists {
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	} else if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	if ctx.Verbose {
+		for _, change := range changes {
+			fmt.Printf("%s: %s\n", path, change)
+		}
+	}
+
+	if err := utils.WriteFileAtomic(path, []byte(merged), mode); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// mustNormalize normalizes merge data like parseMergeDocOptions does
+func mustNormalize(t *testing.T, format string, data map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	normalize := normalizeJSONData
+	if format == "yaml" {
+		normalize = normalizeYAMLData
+	}
+	normalized, err := normalize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return normalized
+}
+
+func TestApplyMergeJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		data     map[string]interface{}
+		keys     []string
+		expected string
+		changes  []string
+	}{
+		{
+			name:    "updates and adds keys keeping comments and indentation",
+			content: "{\n    // Appearance\n    \"editor.fontSize\": 13,\n    \"workbench.colorTheme\": \"Dark\" /* set by the app */\n}\n",
+			data:    map[string]interface{}{"editor.fontSize": 14, "editor.tabSize": 2},
+			expected: "{\n    // Appearance\n    \"editor.fontSize\": 14,\n    \"workbench.colorTheme\": \"Dark\", /* set by the app */\n" +
+				"    \"editor.tabSize\": 2\n}\n",
+			changes: []string{"Update editor.fontSize: 13 → 14", "Add editor.tabSize: 2"},
+		},
+		{
+			name:     "merges nested objects",
+			content:  "{\n\t\"[go]\": {\n\t\t\"editor.tabSize\": 4\n\t},\n\t\"files.autoSave\": \"off\"\n}",
+			data:     map[string]interface{}{"[go]": map[string]interface{}{"editor.formatOnSave": true}},
+			expected: "{\n\t\"[go]\": {\n\t\t\"editor.tabSize\": 4,\n\t\t\"editor.formatOnSave\": true\n\t},\n\t\"files.autoSave\": \"off\"\n}",
+			changes:  []string{"Add [go].editor.formatOnSave: true"},
+		},
+		{
+			name:     "fills an empty object",
+			content:  "{\n  \"a\": {}\n}\n",
+			data:     map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1, "<x>"}}},
+			expected: "{\n  \"a\": {\n    \"b\": [\n      1,\n      \"<x>\"\n    ]\n  }\n}\n",
+			changes:  []string{`Add a.b: [1,"<x>"]`},
+		},
+		{
+			name:     "keeps a single line document on one line",
+			content:  `{"a": 1, "b": {"c": 2}}`,
+			data:     map[string]interface{}{"b": map[string]interface{}{"c": 3}, "d": "x"},
+			expected: `{"a": 1, "b": {"c": 3},"d":"x"}`,
+			changes:  []string{"Update b.c: 2 → 3", `Add d: "x"`},
+		},
+		{
+			name:     "keeps a single line object on one line",
+			content:  "{\n  \"a\": {\"b\": 1},\n  \"c\": 2\n}\n",
+			data:     map[string]interface{}{"a": map[string]interface{}{"d": []interface{}{1, 2}}},
+			expected: "{\n  \"a\": {\"b\": 1, \"d\": [1,2]},\n  \"c\": 2\n}\n",
+			changes:  []string{"Add a.d: [1,2]"},
+		},
+		{
+			name:     "replaces values of another type",
+			content:  "{\n  \"a\": [1, 2],\n  \"b\": 1\n}\n",
+			data:     map[string]interface{}{"a": map[string]interface{}{"x": 1}},
+			expected: "{\n  \"a\": {\n    \"x\": 1\n  },\n  \"b\": 1\n}\n",
+			changes:  []string{`Update a: [1,2] → {"x":1}`},
+		},
+		{
+			name:     "creates an empty document",
+			content:  "",
+			data:     map[string]interface{}{"a": 1},
+			expected: "{\n  \"a\": 1\n}\n",
+			changes:  []string{"Add a: 1"},
+		},
+		{
+			name:     "removes keys with dots in their name",
+			content:  "{\n  \"editor.fontSize\": 14,\n  \"[go]\": {\n    \"editor.tabSize\": 4\n  },\n  \"x\": 1\n}\n",
+			keys:     []string{"editor.fontSize", "[go].editor.tabSize", "missing.key"},
+			expected: "{\n  \"[go]\": {},\n  \"x\": 1\n}\n",
+			changes:  []string{"Remove editor.fontSize: 14", "Remove [go].editor.tabSize: 4"},
+		},
+		{
+			name:     "removes consecutive keys",
+			content:  "{\n  \"a\": 1,\n  \"b\": 2,\n  \"c\": 3,\n  \"d\": 4\n}\n",
+			keys:     []string{"b", "c", "d"},
+			expected: "{\n  \"a\": 1\n}\n",
+			changes:  []string{"Remove b: 2", "Remove c: 3", "Remove d: 4"},
+		},
+		{
+			name:     "removes a key and a key inside it",
+			content:  "{\n  \"a\": {\"b\": 1},\n  \"c\": 3\n}\n",
+			keys:     []string{"a.b", "a"},
+			expected: "{\n  \"c\": 3\n}\n",
+			changes:  []string{`Remove a: {"b":1}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &mergeDocOptions{Format: "json", State: "present", Data: mustNormalize(t, "json", tt.data)}
+			if tt.keys != nil {
+				opts = &mergeDocOptions{Format: "json", State: "absent", Keys: tt.keys}
+			}
+
+			result, changes, err := applyMergeDoc("settings.json", tt.content, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.expected {
+				t.Errorf("applyMergeDoc() = %q, want %q", result, tt.expected)
+			}
+			var described []string
+			for _, change := range changes {
+				described = append(described, change.String())
+			}
+			if strings.Join(described, "\n") != strings.Join(tt.changes, "\n") {
+				t.Errorf("changes = %q, want %q", described, tt.changes)
+			}
+
+			// Applying again must not change anything
+			again, changes, err := applyMergeDoc("settings.json", result, opts)
+			if err != nil || again != result || len(changes) != 0 {
+				t.Errorf("second apply was not idempotent: %q, %d changes, %v", again, len(changes), err)
+			}
+		})
+	}
+}
+
+func TestApplyMergeJSONParseError(t *testing.T) {
+	opts := &mergeDocOptions{Format: "json", State: "present", Data: map[string]interface{}{"a": "b"}}
+	_, _, err := applyMergeDoc("settings.json", "{\n  \"a\": 1\n  \"b\": 2\n}\n", opts)
+	if err == nil || err.Error() != `failed to parse settings.json: line 3, column 3: expected ',' or '}' after the value of "a", got '"'` {
+		t.Errorf("applyMergeDoc() error = %v", err)
+	}
+
+	_, _, err = applyMergeDoc("settings.json", "[1, 2]", opts)
+	if err == nil || !strings.Contains(err.Error(), "must be an object") {
+		t.Errorf("applyMergeDoc() error = %v", err)
+	}
+}
+
+func TestApplyMergeYAML(t *testing.T) {
+	content := "# lazygit\ngui:\n    theme:\n        lightTheme: true # set by hand\n    showIcons: false\ngit:\n    paging:\n        pager: less\n"
+
+	opts := &mergeDocOptions{Format: "yaml", State: "present", Data: mustNormalize(t, "yaml", map[string]interface{}{
+		"gui": map[string]interface{}{
+			"theme":     map[string]interface{}{"lightTheme": false},
+			"showIcons": false,
+			"language":  "en",
+		},
+	})}
+	result, changes, err := applyMergeDoc("config.yml", content, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# lazygit\ngui:\n    theme:\n        lightTheme: false # set by hand\n    showIcons: false\n    language: en\ngit:\n    paging:\n        pager: less\n"
+	if result != expected {
+		t.Errorf("applyMergeDoc() = %q, want %q", result, expected)
+	}
+	if len(changes) != 2 || changes[0].String() != `Add gui.language: "en"` || changes[1].String() != "Update gui.theme.lightTheme: true → false" {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+
+	again, changes, err := applyMergeDoc("config.yml", result, opts)
+	if err != nil || again != result || len(changes) != 0 {
+		t.Errorf("second apply was not idempotent: %q, %d changes, %v", again, len(changes), err)
+	}
+
+	absent := &mergeDocOptions{Format: "yaml", State: "absent", Keys: []string{"git.paging", "gui.nope"}}
+	result, changes, err = applyMergeDoc("config.yml", result, absent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "# lazygit\ngui:\n    theme:\n        lightTheme: false # set by hand\n    showIcons: false\n    language: en\ngit: {}\n" {
+		t.Errorf("applyMergeDoc() = %q", result)
+	}
+	if len(changes) != 1 || changes[0].String() != `Remove git.paging: {"pager":"less"}` {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+
+	_, _, err = applyMergeDoc("config.yml", "gui:\n  - a\n b: c\n", opts)
+	if err == nil || !strings.Contains(err.Error(), "failed to parse config.yml: yaml: line") {
+		t.Errorf("applyMergeDoc() error = %v", err)
+	}
+}
+
+func TestMergeJSONTask(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Code", "settings.json")
+
+	m := New()
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{"font": map[string]interface{}{"family": "JetBrains Mono"}}}
+	task := &config.Task{
+		ID:     "vscode",
+		Action: "merge_json",
+		Config: map[string]interface{}{
+			"path": path,
+			"data": map[string]interface{}{"editor.fontFamily": "{{ font.family }}", "editor.fontSize": 14},
+		},
+	}
+	if err := m.ValidateTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Create file", `Add editor.fontFamily: "JetBrains Mono"`, "Add editor.fontSize: 14"}
+	if strings.Join(plan.Changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("plan changes = %q, want %q", plan.Changes, expected)
+	}
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{\n  \"editor.fontFamily\": \"JetBrains Mono\",\n  \"editor.fontSize\": 14,\n  \"window.zoomLevel\": 1\n}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip || plan.SkipReason != "Keys are already up to date" {
+		t.Errorf("second plan should skip, got %v", plan.Changes)
+	}
+
+	absent := &config.Task{
+		ID:     "vscode zoom",
+		Action: "merge_json",
+		Config: map[string]interface{}{"path": path, "key": "window.zoomLevel", "state": "absent"},
+	}
+	if err := m.ExecuteTask(absent, ctx); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	if string(content) != "{\n  \"editor.fontFamily\": \"JetBrains Mono\",\n  \"editor.fontSize\": 14\n}\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(path)
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("mode = %04o, want 0600", info.Mode().Perm())
+		}
+	}
+
+	// A file that cannot be parsed is never overwritten
+	if err := os.WriteFile(path, []byte("{ broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ExecuteTask(task, ctx); err == nil || !strings.Contains(err.Error(), "line 1, column 3") {
+		t.Errorf("ExecuteTask() error = %v", err)
+	}
+	content, _ = os.ReadFile(path)
+	if string(content) != "{ broken" {
+		t.Errorf("file was changed to %q", content)
+	}
+}
+
+func TestValidateMergeDocTask(t *testing.T) {
+	m := &FilesModule{}
+
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{"valid present", map[string]interface{}{"path": "/tmp/x.json", "data": map[string]interface{}{"a": 1}}, false},
+		{"valid absent", map[string]interface{}{"path": "/tmp/x.json", "key": []interface{}{"a.b", "c"}, "state": "absent"}, false},
+		{"missing path", map[string]interface{}{"data": map[string]interface{}{"a": 1}}, true},
+		{"present without data", map[string]interface{}{"path": "/tmp/x.json"}, true},
+		{"data not a map", map[string]interface{}{"path": "/tmp/x.json", "data": "a"}, true},
+		{"key with present", map[string]interface{}{"path": "/tmp/x.json", "data": map[string]interface{}{"a": 1}, "key": "a"}, true},
+		{"absent without key", map[string]interface{}{"path": "/tmp/x.json", "state": "absent"}, true},
+		{"absent with data", map[string]interface{}{"path": "/tmp/x.json", "state": "absent", "key": "a", "data": map[string]interface{}{"a": 1}}, true},
+		{"empty key", map[string]interface{}{"path": "/tmp/x.json", "state": "absent", "key": ""}, true},
+		{"invalid state", map[string]interface{}{"path": "/tmp/x.json", "state": "merged"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.validateMergeDocTask("merge_json", tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMergeDocTask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// normalize_line_endings values of ensure_file
+const (
+	LineEndingsPreserve = "preserve"
+	LineEndingsLF       = "lf"
+	LineEndingsCRLF     = "crlf"
+)
+
+// validateContentNormalization validates the normalize_line_endings and
+// strip_template_blanks options of ensure_file
+func validateContentNormalization(config map[string]interface{}) error {
+	lineEndings, hasLineEndings := config["normalize_line_endings"]
+	if hasLineEndings {
+		switch lineEndings {
+		case LineEndingsPreserve, LineEndingsLF, LineEndingsCRLF:
+		default:
+			return fmt.Errorf("ensure_file 'normalize_line_endings' must be one of lf, crlf, preserve")
+		}
+	}
+	strip, hasStrip := config["strip_template_blanks"]
+	if hasStrip {
+		if _, ok := modules.BoolValue(strip); !ok {
+			return fmt.Errorf("ensure_file 'strip_template_blanks' must be a boolean")
+		}
+	}
+	if hasLineEndings || hasStrip {
+		// Downloads and command output are written as they are
+		for _, field := range []string{"content_url", "content_command"} {
+			if _, exists := config[field]; exists {
+				return fmt.Errorf("ensure_file 'normalize_line_endings' and 'strip_template_blanks' apply to content and content_source, not '%s'", field)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeContent applies the strip_template_blanks and normalize_line_endings
+// options of an ensure_file task to its content or content_source. Plan and apply
+// both use it, so a normalized file is not planned as changed on every run.
+func (m *FilesModule) normalizeContent(task *config.Task, content string) string {
+	if strip, _ := modules.BoolValue(task.Config["strip_template_blanks"]); strip {
+		content = m.cleanupTemplateArtifacts(content)
+	}
+	lineEndings, _ := modules.StringValue(task.Config["normalize_line_endings"])
+	switch lineEndings {
+	case LineEndingsLF:
+		content = strings.ReplaceAll(content, "\r\n", "\n")
+	case LineEndingsCRLF:
+		content = strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\n", "\r\n")
+	}
+	return content
+}
@@ -0,0 +1,128 @@
+package files
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLineEndings(t *testing.T) {
+	home := memHome(t)
+	crlfTemplate := "# profile\r\nSet-Alias g git\r\n$env:NAME = '{{ name }}'\r\n"
+	lfTemplate := "[user]\n\tname = {{ name }}\n"
+
+	tests := []struct {
+		name        string
+		template    string
+		lineEndings string
+		want        string
+	}{
+		{"CRLFTemplatePreserved", crlfTemplate, "", "# profile\r\nSet-Alias g git\r\n$env:NAME = 'world'\r\n"},
+		{"CRLFTemplateToLF", crlfTemplate, "lf", "# profile\nSet-Alias g git\n$env:NAME = 'world'\n"},
+		{"LFTemplatePreserved", lfTemplate, "preserve", "[user]\n\tname = world\n"},
+		{"LFTemplateToCRLF", lfTemplate, "crlf", "[user]\r\n\tname = world\r\n"},
+		{"MixedToCRLF", "a\r\nb\n", "crlf", "a\r\nb\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFileSystem(home)
+			m, ctx := newMemModule(t, fsys)
+			fsys.addFile(filepath.Join(ctx.BasePath, "files", "template"), tt.template, 0644)
+			path := filepath.Join(home, "profile")
+
+			for _, source := range []map[string]interface{}{
+				{"path": path, "content": tt.template},
+				{"path": path, "content_source": "files/template", "render": true},
+			} {
+				if tt.lineEndings != "" {
+					source["normalize_line_endings"] = tt.lineEndings
+				}
+				task := ensureFileTask(source)
+				if err := m.ValidateTask(task); err != nil {
+					t.Fatal(err)
+				}
+				if err := m.ExecuteTask(task, ctx); err != nil {
+					t.Fatal(err)
+				}
+				if content, _ := fsys.content(path); content != tt.want {
+					t.Errorf("content of %v = %q, want %q", source, content, tt.want)
+				}
+
+				// Plan normalizes like apply, the file is not changed again
+				plan, err := m.PlanTask(task, ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !plan.WillSkip {
+					t.Errorf("plan after apply of %v = %q, want the file in sync", source, plan.Changes)
+				}
+			}
+		})
+	}
+}
+
+func TestStripTemplateBlanks(t *testing.T) {
+	home := memHome(t)
+	path := filepath.Join(home, ".gitconfig")
+	template := "[user]\n\tname = {{ name }}\n\n\n{% if work %}[work]{% endif %}\n\n[core]\n\teditor = vim\n"
+
+	fsys := newMemFileSystem(home)
+	m, ctx := newMemModule(t, fsys)
+	ctx.Variables["work"] = false
+
+	// Blank lines are written as the template renders them unless stripped
+	task := ensureFileTask(map[string]interface{}{"path": path, "content": template})
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := fsys.content(path); content != "[user]\n\tname = world\n\n\n\n\n[core]\n\teditor = vim\n" {
+		t.Errorf("content = %q, want the blank lines kept", content)
+	}
+
+	task.Config["strip_template_blanks"] = true
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := fsys.content(path); content != "[user]\n\tname = world\n\n[core]\n\teditor = vim" {
+		t.Errorf("stripped content = %q", content)
+	}
+	if plan, err := m.PlanTask(task, ctx); err != nil || !plan.WillSkip {
+		t.Errorf("plan after apply = %+v, %v, want the file in sync", plan, err)
+	}
+
+	// Stripping blanks keeps CRLF line endings
+	task.Config["content"] = strings.ReplaceAll(template, "\n", "\r\n")
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := fsys.content(path); content != "[user]\r\n\tname = world\r\n\r\n[core]\r\n\teditor = vim" {
+		t.Errorf("stripped CRLF content = %q", content)
+	}
+}
+
+func TestValidateContentNormalization(t *testing.T) {
+	m := New()
+	valid := []map[string]interface{}{
+		{"path": "~/.profile", "content": "x", "normalize_line_endings": "crlf"},
+		{"path": "~/.profile", "content_source": "files/profile", "strip_template_blanks": true},
+		{"path": "~/.profile", "content": "x", "strip_template_blanks": "false", "normalize_line_endings": "preserve"},
+	}
+	for _, taskConfig := range valid {
+		if err := m.ValidateTask(ensureFileTask(taskConfig)); err != nil {
+			t.Errorf("ValidateTask(%v) = %v, want no error", taskConfig, err)
+		}
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"must be one of lf, crlf, preserve": {"path": "~/.profile", "content": "x", "normalize_line_endings": "windows"},
+		"'strip_template_blanks' must be":   {"path": "~/.profile", "content": "x", "strip_template_blanks": "sometimes"},
+		"not 'content_url'":                 {"path": "~/.profile", "content_url": "https://example.com/profile", "normalize_line_endings": "lf"},
+		"not 'content_command'":             {"path": "~/.profile", "content_command": "echo", "strip_template_blanks": true},
+	}
+	for want, taskConfig := range invalid {
+		err := m.ValidateTask(ensureFileTask(taskConfig))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ValidateTask(%v) = %v, want an error containing %q", taskConfig, err, want)
+		}
+	}
+}
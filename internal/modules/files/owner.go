@@ -0,0 +1,228 @@
+package files
+
+import (
+	"fmt"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// ownership is the owner and group an ensure_file or ensure_dir task sets. An ID
+// is -1 when the task leaves it alone, like os.Chown expects.
+type ownership struct {
+	UID   int
+	GID   int
+	Owner string // As configured, for messages
+	Group string
+}
+
+// String returns the ownership in chown syntax, e.g. "root:root" or ":wheel"
+func (o *ownership) String() string {
+	if o.Group == "" {
+		return o.Owner
+	}
+	return o.Owner + ":" + o.Group
+}
+
+// validateOwnership validates the owner and group options of an action
+func validateOwnership(action string, config map[string]interface{}) error {
+	for _, field := range []string{"owner", "group"} {
+		value, exists := config[field]
+		if !exists {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if strings.TrimSpace(v) == "" {
+				return fmt.Errorf("%s '%s' must not be empty", action, field)
+			}
+		case int:
+			if v < 0 {
+				return fmt.Errorf("%s '%s' must not be negative", action, field)
+			}
+		default:
+			return fmt.Errorf("%s '%s' must be a name or numeric ID", action, field)
+		}
+	}
+	return nil
+}
+
+// parseOwnership resolves the owner and group of a task to numeric IDs. It returns
+// nil when the task sets neither.
+func (m *FilesModule) parseOwnership(task *config.Task, ctx *modules.ExecutionContext) (*ownership, error) {
+	owner, err := m.ownershipField(task, ctx, "owner")
+	if err != nil {
+		return nil, err
+	}
+	group, err := m.ownershipField(task, ctx, "group")
+	if err != nil {
+		return nil, err
+	}
+	if owner == "" && group == "" {
+		return nil, nil
+	}
+
+	o := &ownership{UID: -1, GID: -1, Owner: owner, Group: group}
+	if owner != "" {
+		if o.UID, err = lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("unknown owner '%s': %w", owner, err)
+		}
+	}
+	if group != "" {
+		if o.GID, err = lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("unknown group '%s': %w", group, err)
+		}
+	}
+	return o, nil
+}
+
+// ownershipField returns the owner or group of a task as a string, processing
+// templates in names
+func (m *FilesModule) ownershipField(task *config.Task, ctx *modules.ExecutionContext, field string) (string, error) {
+	switch value := task.Config[field].(type) {
+	case int:
+		return strconv.Itoa(value), nil
+	case string:
+		processed, err := m.processTemplate(value, ctx.Variables)
+		if err != nil {
+			return "", fmt.Errorf("failed to process %s template: %w", field, err)
+		}
+		return strings.TrimSpace(processed), nil
+	default:
+		return "", nil
+	}
+}
+
+// lookupID returns a numeric ID as is and resolves a name with lookup
+func lookupID(nameOrID string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// ownershipTarget returns the expanded path of an ensure_file or ensure_dir task
+func (m *FilesModule) ownershipTarget(task *config.Task, ctx *modules.ExecutionContext) (string, error) {
+	path, err := m.processField(task, "path", ctx.Variables)
+	if err != nil {
+		return "", fmt.Errorf("failed to process path template: %w", err)
+	}
+	return utils.ExpandPath(path)
+}
+
+// ownershipChange returns the chown needed to give path its configured ownership,
+// or nil when it already has it. A path that does not exist yet gets the owner and
+// group of the running process once created.
+func ownershipChange(o *ownership, path string) (*ownership, error) {
+	uid, gid, err := currentOwnership(path)
+	if err != nil {
+		return nil, err
+	}
+	if (o.UID == -1 || o.UID == uid) && (o.GID == -1 || o.GID == gid) {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// upToDateSkipReasons are the skip reasons of targets that are up to date apart
+// from their ownership
+var upToDateSkipReasons = map[string]bool{
+	"File exists with correct content":                  true,
+	"Directory already exists":                          true,
+	"Directory already exists with correct permissions": true,
+}
+
+// planOwnership adds the chown an ensure_file or ensure_dir task will do to its
+// plan. A task that would be skipped because its target is up to date runs when
+// only the ownership differs.
+func (m *FilesModule) planOwnership(task *config.Task, ctx *modules.ExecutionContext, plan *modules.TaskPlan) (*modules.TaskPlan, error) {
+	if plan == nil || runtime.GOOS == "windows" {
+		return plan, nil
+	}
+	if plan.WillSkip && !upToDateSkipReasons[plan.SkipReason] {
+		return plan, nil
+	}
+
+	o, err := m.parseOwnership(task, ctx)
+	if err != nil || o == nil {
+		return plan, err
+	}
+	path, err := m.ownershipTarget(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	change, err := ownershipChange(o, path)
+	if err != nil || change == nil {
+		return plan, err
+	}
+
+	plan.WillSkip = false
+	plan.SkipReason = ""
+	plan.SkipCode = ""
+	plan.Changes = append(plan.Changes, fmt.Sprintf("chown %s", change))
+	return plan, nil
+}
+
+// hasOwnership reports whether a task sets an owner or group
+func hasOwnership(task *config.Task) bool {
+	_, hasOwner := task.Config["owner"]
+	_, hasGroup := task.Config["group"]
+	return hasOwner || hasGroup
+}
+
+// handBack gives path and the directories created for it to the user that ran
+// dotfiles with sudo. A path whose task sets owner or group keeps that ownership.
+func handBack(task *config.Task, ctx *modules.ExecutionContext, path string, created []string) error {
+	paths := created
+	if !hasOwnership(task) {
+		paths = append(paths, path)
+	}
+	return modules.HandBackToInvokingUser(task, ctx, paths...)
+}
+
+// applyOwnership gives the target of an ensure_file or ensure_dir task its
+// configured owner and group. Ownership is not supported on Windows.
+func (m *FilesModule) applyOwnership(task *config.Task, ctx *modules.ExecutionContext, path string) error {
+	if !hasOwnership(task) {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		fmt.Printf("   ⚠️  owner and group are not supported on Windows, ignored for %s\n", path)
+		return nil
+	}
+
+	o, err := m.parseOwnership(task, ctx)
+	if err != nil || o == nil {
+		return err
+	}
+	change, err := ownershipChange(o, path)
+	if err != nil || change == nil {
+		return err
+	}
+
+	if ctx.Verbose {
+		fmt.Printf("Changing ownership: %s (%s)\n", path, change)
+	}
+	return chownPath(path, change)
+}
@@ -0,0 +1,192 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestValidateOwnership(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "names", config: map[string]interface{}{"owner": "root", "group": "wheel"}},
+		{name: "numeric IDs", config: map[string]interface{}{"owner": 0, "group": 0}},
+		{name: "owner only", config: map[string]interface{}{"owner": "root"}},
+		{name: "empty owner", config: map[string]interface{}{"owner": " "}, wantErr: true},
+		{name: "negative group", config: map[string]interface{}{"group": -1}, wantErr: true},
+		{name: "list", config: map[string]interface{}{"owner": []interface{}{"root"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOwnership("ensure_file", tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateOwnership() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOwnershipString(t *testing.T) {
+	tests := map[string]*ownership{
+		"root:root": {Owner: "root", Group: "root"},
+		"root":      {Owner: "root"},
+		":wheel":    {Group: "wheel"},
+	}
+	for want, o := range tests {
+		if got := o.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestEnsureFileOwnership(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ownership is not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "profile.sh")
+	if err := os.WriteFile(path, []byte("export A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newTask := func(owner, group interface{}) *config.Task {
+		return &config.Task{
+			ID:     "profile",
+			Action: "ensure_file",
+			Config: map[string]interface{}{"path": path, "content": "export A=1\n", "owner": owner, "group": group},
+		}
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+	m := New()
+
+	// The current owner needs no change
+	uid, gid := os.Getuid(), os.Getgid()
+	plan, err := m.PlanTask(newTask(uid, strconv.Itoa(gid)), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip {
+		t.Errorf("expected plan to skip a file with the right content and owner, got %+v", plan)
+	}
+
+	// A different owner is changed even though the content is correct
+	other := uid + 4242
+	plan, err = m.PlanTask(newTask(other, gid), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "chown " + strconv.Itoa(other) + ":" + strconv.Itoa(gid)
+	if plan.WillSkip || len(plan.Changes) != 1 || plan.Changes[0] != want {
+		t.Errorf("expected plan to %q, got %+v", want, plan)
+	}
+
+	err = m.ExecuteTask(newTask(other, gid), ctx)
+	if os.Geteuid() == 0 {
+		if err != nil {
+			t.Fatalf("chown as root failed: %v", err)
+		}
+		gotUID, _, err := currentOwnership(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotUID != other {
+			t.Errorf("owner = %d, want %d", gotUID, other)
+		}
+	} else if err == nil || !strings.Contains(err.Error(), "elevated") {
+		t.Errorf("expected a hint to run elevated, got %v", err)
+	}
+
+	if _, err := m.PlanTask(newTask("no-such-user-dotfiles", nil), ctx); err == nil {
+		t.Error("expected an unknown owner to fail")
+	}
+}
+
+// sudoPrivileges is a process that runs as root through sudo, recording the
+// paths it hands back
+type sudoPrivileges struct {
+	home    string
+	chowned []string
+}
+
+func (p *sudoPrivileges) Geteuid() int                       { return 0 }
+func (p *sudoPrivileges) HomeDir(uid string) (string, error) { return p.home, nil }
+
+func (p *sudoPrivileges) Getenv(key string) string {
+	return map[string]string{"SUDO_UID": "1000", "SUDO_GID": "1000", "SUDO_USER": "menno"}[key]
+}
+
+func (p *sudoPrivileges) Lchown(path string, uid, gid int) error {
+	p.chowned = append(p.chowned, path)
+	return nil
+}
+
+func TestHandBackUnderSudo(t *testing.T) {
+	home := t.TempDir()
+	tests := []struct {
+		name   string
+		action string
+		config map[string]interface{}
+		want   []string
+	}{
+		{
+			name:   "ensure_file with new parents",
+			action: "ensure_file",
+			config: map[string]interface{}{"path": filepath.Join(home, "a", "b", "file"), "content": "x"},
+			want:   []string{filepath.Join(home, "a"), filepath.Join(home, "a", "b"), filepath.Join(home, "a", "b", "file")},
+		},
+		{
+			name:   "ensure_dir",
+			action: "ensure_dir",
+			config: map[string]interface{}{"path": filepath.Join(home, "dir", "sub")},
+			want:   []string{filepath.Join(home, "dir"), filepath.Join(home, "dir", "sub")},
+		},
+		{
+			name:   "as_root",
+			action: "ensure_file",
+			config: map[string]interface{}{"path": filepath.Join(home, "root", "file"), "content": "x", "as_root": true},
+		},
+		{
+			name:   "outside the home directory",
+			action: "ensure_file",
+			config: map[string]interface{}{"path": filepath.Join(t.TempDir(), "file"), "content": "x"},
+		},
+	}
+
+	m := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &sudoPrivileges{home: home}
+			defer modules.SetPrivileges(p)()
+
+			task := &config.Task{ID: tt.name, Action: tt.action, Config: tt.config}
+			if err := m.ValidateTask(task); err != nil {
+				t.Fatal(err)
+			}
+			ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+			if err := m.ExecuteTask(task, ctx); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(p.chowned, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("handed back %v, want %v", p.chowned, tt.want)
+			}
+
+			// An unchanged target is not handed back again
+			p.chowned = nil
+			if err := m.ExecuteTask(task, ctx); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.chowned) != 0 {
+				t.Errorf("unchanged target handed back: %v", p.chowned)
+			}
+		})
+	}
+}
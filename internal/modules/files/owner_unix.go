@@ -0,0 +1,39 @@
+//go:build !windows
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// currentOwnership returns the owner and group of path, or those of the running
+// process when path does not exist
+func currentOwnership(path string) (int, int, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return os.Geteuid(), os.Getegid(), nil
+	}
+	if err != nil {
+		return -1, -1, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, fmt.Errorf("cannot read the owner of %s on this platform", path)
+	}
+	return int(stat.Uid), int(stat.Gid), nil
+}
+
+// chownPath changes the owner and group of path
+func chownPath(path string, o *ownership) error {
+	if err := os.Chown(path, o.UID, o.GID); err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return fmt.Errorf("not permitted to chown %s to %s, run dotfiles elevated (e.g. with sudo) to change ownership", path, o)
+		}
+		return fmt.Errorf("failed to chown %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package files
+
+import "errors"
+
+// errNoOwnership is returned when Unix ownership is used on Windows
+var errNoOwnership = errors.New("owner and group are not supported on Windows")
+
+func currentOwnership(path string) (int, int, error) {
+	return -1, -1, errNoOwnership
+}
+
+func chownPath(path string, o *ownership) error {
+	return errNoOwnership
+}
@@ -0,0 +1,69 @@
+package files
+
+import (
+	"path/filepath"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// PreflightChecks returns the checks a files task needs: its source exists, the
+// directory of its target is writable and a content_url can be downloaded
+func (m *FilesModule) PreflightChecks(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.PreflightCheck, error) {
+	var checks []*modules.PreflightCheck
+
+	switch task.Action {
+	case "ensure_tree":
+		opts, err := m.parseEnsureTreeOptions(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []*modules.PreflightCheck{
+			modules.SourceExistsCheck("source_dir", opts.SourceDir),
+			modules.WritableDirCheck(opts.TargetDir),
+		}, nil
+	case "copy_file":
+		opts, err := m.parseCopyFileOptions(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []*modules.PreflightCheck{
+			modules.SourceExistsCheck("source", opts.Source),
+			modules.WritableDirCheck(filepath.Dir(opts.Path)),
+		}, nil
+	case "ensure_file":
+		templates, err := m.TaskTemplates(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, source := range templates {
+			if source.Path != "" {
+				checks = append(checks, modules.SourceExistsCheck(source.Field, source.Path))
+			}
+		}
+
+		// Offline, downloads are skipped instead of failing
+		if _, ok := modules.StringValue(task.Config["content_url"]); ok && !ctx.Offline {
+			source, err := m.parseContentURL(task, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if _, cached := source.cached(); !cached {
+				check, err := modules.NetworkCheck(source.URL)
+				if err != nil {
+					return nil, err
+				}
+				checks = append(checks, check)
+			}
+		}
+	}
+
+	targets, err := m.TaskTargets(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		checks = append(checks, modules.WritableDirCheck(filepath.Dir(target)))
+	}
+	return checks, nil
+}
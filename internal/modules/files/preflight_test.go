@@ -0,0 +1,116 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestPreflightChecks(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeTree(t, tmpDir, map[string]string{
+		"files/bashrc": "export EDITOR=vim\n",
+		"blocker":      "not a directory\n",
+	})
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+
+	run := func(t *testing.T, task *config.Task) map[string]*modules.PreflightReport {
+		t.Helper()
+		checks, err := m.PreflightChecks(task, ctx)
+		if err != nil {
+			t.Fatalf("PreflightChecks() error = %v", err)
+		}
+		reports := make(map[string]*modules.PreflightReport)
+		for _, report := range modules.RunPreflightChecks(context.Background(), checks, nil) {
+			reports[report.Name] = report
+		}
+		return reports
+	}
+
+	t.Run("existing source and new directory", func(t *testing.T) {
+		target := filepath.Join(tmpDir, "home", ".config", "bash", "bashrc")
+		reports := run(t, &config.Task{Action: "ensure_file", Config: map[string]interface{}{
+			"path":           target,
+			"content_source": "files/bashrc",
+		}})
+
+		source := reports["Source "+filepath.Join(tmpDir, "files", "bashrc")]
+		if source == nil || source.Status != modules.CheckPass {
+			t.Errorf("source check = %+v, want pass", source)
+		}
+		writable := reports["Write access to "+filepath.Dir(target)]
+		if writable == nil || writable.Status != modules.CheckPass || !strings.Contains(writable.Message, "will be created") {
+			t.Errorf("write access check = %+v, want pass for a directory that will be created", writable)
+		}
+		if entries, _ := os.ReadDir(tmpDir); len(entries) != 2 {
+			t.Errorf("checks left %d entries in %s, want the 2 it had", len(entries), tmpDir)
+		}
+	})
+
+	t.Run("missing source and blocked target", func(t *testing.T) {
+		reports := run(t, &config.Task{Action: "ensure_file", Config: map[string]interface{}{
+			"path":           filepath.Join(tmpDir, "blocker", "bashrc"),
+			"content_source": "files/missing",
+		}})
+
+		source := reports["Source "+filepath.Join(tmpDir, "files", "missing")]
+		if source == nil || source.Status != modules.CheckFail || !strings.Contains(source.Hint, "content_source") {
+			t.Errorf("source check = %+v, want a failure pointing at content_source", source)
+		}
+		writable := reports["Write access to "+filepath.Join(tmpDir, "blocker")]
+		if writable == nil || writable.Status != modules.CheckFail {
+			t.Errorf("write access check = %+v, want fail when the parent is a file", writable)
+		}
+	})
+
+	t.Run("downloads need the network unless offline", func(t *testing.T) {
+		task := &config.Task{Action: "ensure_file", Config: map[string]interface{}{
+			"path":        filepath.Join(tmpDir, "home", "font.zip"),
+			"content_url": "https://downloads.example.com/font.zip",
+		}}
+
+		checks, err := m.PreflightChecks(task, ctx)
+		if err != nil {
+			t.Fatalf("PreflightChecks() error = %v", err)
+		}
+		if !hasCheck(checks, "network:downloads.example.com:443") {
+			t.Errorf("checks %v do not include the connection to downloads.example.com", checkKeys(checks))
+		}
+
+		offline := *ctx
+		offline.Offline = true
+		checks, err = m.PreflightChecks(task, &offline)
+		if err != nil {
+			t.Fatalf("PreflightChecks() error = %v", err)
+		}
+		if hasCheck(checks, "network:downloads.example.com:443") {
+			t.Errorf("offline checks %v include a connection check", checkKeys(checks))
+		}
+	})
+}
+
+// checkKeys returns the keys of pre-flight checks
+func checkKeys(checks []*modules.PreflightCheck) []string {
+	keys := make([]string, len(checks))
+	for i, check := range checks {
+		keys[i] = check.Key
+	}
+	return keys
+}
+
+// hasCheck reports whether a check with the key is among the checks
+func hasCheck(checks []*modules.PreflightCheck, key string) bool {
+	for _, check := range checks {
+		if check.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+func TestValidateEnsureFilePaths(t *testing.T) {
+	m := New()
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		err    string
+	}{
+		{"Paths", map[string]interface{}{"paths": []interface{}{"~/a", "~/b"}, "content": "x"}, ""},
+		{"Empty", map[string]interface{}{"paths": []interface{}{}, "content": "x"}, "at least one target"},
+		{"PathAndPaths", map[string]interface{}{"path": "~/a", "paths": []interface{}{"~/b"}}, "cannot be used together"},
+		{"NotStrings", map[string]interface{}{"paths": []interface{}{"~/a", 1}}, "list of strings"},
+		{"Duplicate", map[string]interface{}{"paths": []interface{}{"~/a", "~/a"}}, "more than once"},
+		{"Neither", map[string]interface{}{"content": "x"}, "requires 'path' or 'paths'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.ValidateTask(&config.Task{ID: tt.name, Action: "ensure_file", Config: tt.config})
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEnsureFilePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	synced := filepath.Join(tmpDir, "synced")
+	missing := filepath.Join(tmpDir, "nested", "missing")
+	if err := os.WriteFile(synced, []byte("managed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+	task := &config.Task{
+		ID:     "settings",
+		Action: "ensure_file",
+		Config: map[string]interface{}{"paths": []interface{}{synced, missing}, "content": "managed"},
+	}
+
+	// Every path is planned on its own
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.WillSkip {
+		t.Fatal("a missing path must not be skipped")
+	}
+	want := []string{
+		"Ensure file exists: " + synced + ": skipped, File exists with correct content",
+		"Ensure file exists: " + missing,
+		"  Create file",
+		"  Create parent directory " + filepath.Dir(missing),
+	}
+	if strings.Join(plan.Changes, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changes = %q, want %q", plan.Changes, want)
+	}
+
+	targets, err := m.TaskTargets(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0] != synced || targets[1] != missing {
+		t.Errorf("targets = %v, want both paths", targets)
+	}
+
+	drift, err := m.CheckDrift(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift.Path != missing || drift.State != modules.DriftMissing {
+		t.Errorf("drift = %s %s, want the missing path", drift.Path, drift.State)
+	}
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(missing); string(content) != "managed" {
+		t.Errorf("content = %q, want %q", content, "managed")
+	}
+
+	plan, err = m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip || plan.SkipReason != "File exists with correct content" {
+		t.Errorf("expected every path to be skipped, got %v (%s)", plan.Changes, plan.SkipReason)
+	}
+}
+
+func TestEnsureFilePathsRunCommandOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are written for sh")
+	}
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	tmpDir := t.TempDir()
+	counter := filepath.Join(tmpDir, "runs")
+	paths := []interface{}{filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"), filepath.Join(tmpDir, "c")}
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+	task := &config.Task{
+		ID:     "generated",
+		Action: "ensure_file",
+		Config: map[string]interface{}{
+			"paths":           paths,
+			"content_command": "echo run >> " + counter + "; echo generated",
+			"cache_key":       "1",
+		},
+	}
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if runs, _ := os.ReadFile(counter); string(runs) != "run\n" {
+		t.Errorf("command ran %d times, want once", strings.Count(string(runs), "run"))
+	}
+	for _, path := range paths {
+		if content, _ := os.ReadFile(path.(string)); string(content) != "generated\n" {
+			t.Errorf("%s content = %q, want %q", path, content, "generated\n")
+		}
+	}
+
+	// The output is cached for every path, so none of them waits for apply
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip {
+		t.Errorf("expected cached output to match every path, got %v", plan.Changes)
+	}
+}
+
+func TestEnsureFilePathsKeepLocalChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	edited := filepath.Join(tmpDir, "edited")
+	created := filepath.Join(tmpDir, "created")
+	if err := os.WriteFile(edited, []byte("edited by hand"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+	task := &config.Task{
+		ID:     "settings",
+		Action: "ensure_file",
+		Config: map[string]interface{}{"paths": []interface{}{edited, created}, "content": "managed", "on_conflict": ConflictKeep},
+	}
+
+	// A path with local changes is kept without stopping the other paths
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatalf("expected the task to succeed, got %v", err)
+	}
+	if content, _ := os.ReadFile(edited); string(content) != "edited by hand" {
+		t.Errorf("local changes were overwritten: %q", content)
+	}
+	if content, _ := os.ReadFile(created); string(content) != "managed" {
+		t.Errorf("content = %q, want %q", content, "managed")
+	}
+}
+
+func TestEditTargetsConflictWithWholeFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	bashrc := filepath.Join(tmpDir, ".bashrc")
+	registry := modules.NewModuleRegistry()
+	if err := registry.Register(New()); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+	task := func(id, action string, cfg map[string]interface{}) *config.Task {
+		cfg["path"] = bashrc
+		return &config.Task{ID: id, Action: action, Config: cfg}
+	}
+	lineTask := task("path", "line_in_file", map[string]interface{}{"line": "export PATH=$HOME/bin:$PATH"})
+	blockTask := task("aliases", "block_in_file", map[string]interface{}{"block": "alias ll='ls -l'"})
+	fileTask := task("bashrc", "ensure_file", map[string]interface{}{"content": "# bashrc\n"})
+
+	// Tasks editing the same file leave each other's edits alone
+	if _, err := registry.CheckTargetConflicts([]*config.Task{lineTask, blockTask}, ctx); err != nil {
+		t.Errorf("CheckTargetConflicts() of two edits = %v, want no conflict", err)
+	}
+
+	// Writing the whole file undoes the edits on every apply
+	_, err := registry.CheckTargetConflicts([]*config.Task{lineTask, blockTask, fileTask}, ctx)
+	conflictErr, ok := modules.IsTargetConflictError(err)
+	if !ok {
+		t.Fatalf("CheckTargetConflicts() = %v, want a conflict", err)
+	}
+	if len(conflictErr.Conflicts) != 2 {
+		t.Fatalf("got %d conflicts, want one for each edit", len(conflictErr.Conflicts))
+	}
+	for i, edit := range []*config.Task{lineTask, blockTask} {
+		if c := conflictErr.Conflicts[i]; c.First != edit || c.Second != fileTask || c.FirstKind != "edit" || c.SecondKind != "file" {
+			t.Errorf("conflict = %s (%s) and %s (%s), want %s and the ensure_file task", c.First.ID, c.FirstKind, c.Second.ID, c.SecondKind, edit.ID)
+		}
+	}
+
+	// Edits after the file is put in place are reported as well
+	_, err = registry.CheckTargetConflicts([]*config.Task{fileTask, lineTask}, ctx)
+	if conflictErr, ok := modules.IsTargetConflictError(err); !ok || conflictErr.Conflicts[0].SecondKind != "edit" {
+		t.Errorf("CheckTargetConflicts() = %v, want the line_in_file task to conflict", err)
+	}
+}
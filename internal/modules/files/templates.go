@@ -0,0 +1,100 @@
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// TaskTemplates returns the inline templates of a files task and the source files it
+// reads, marking which of them are rendered
+func (m *FilesModule) TaskTemplates(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.TemplateSource, error) {
+	switch task.Action {
+	case "ensure_file":
+		if contentSource, ok := modules.StringValue(task.Config["content_source"]); ok {
+			sourcePath, err := m.processTemplate(contentSource, ctx.Variables)
+			if err != nil {
+				return nil, fmt.Errorf("failed to process content_source template: %w", err)
+			}
+			if !filepath.IsAbs(sourcePath) {
+				sourcePath = filepath.Join(ctx.BasePath, sourcePath)
+			}
+			render, _ := modules.BoolValue(task.Config["render"])
+			source := &modules.TemplateSource{Field: "content_source", Path: sourcePath, Render: render}
+			if render && contentSourceEngine(task, sourcePath) == "pongo2" {
+				source.SearchPath = templateSearchPath(ctx)
+			}
+			return []*modules.TemplateSource{source}, nil
+		}
+		// Inline content is always rendered
+		if content, ok := modules.StringValue(task.Config["content"]); ok {
+			return []*modules.TemplateSource{{Field: "content", Content: content, Render: true}}, nil
+		}
+	case "line_in_file", "block_in_file":
+		field := "line"
+		if task.Action == "block_in_file" {
+			field = "block"
+		}
+		if content, ok := modules.StringValue(task.Config[field]); ok {
+			return []*modules.TemplateSource{{Field: field, Content: content, Render: true}}, nil
+		}
+	case "merge_json", "merge_yaml":
+		if data, ok := task.Config["data"].(map[string]interface{}); ok {
+			return docDataTemplates(data, "data"), nil
+		}
+	case "ensure_tree":
+		opts, err := m.parseEnsureTreeOptions(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var sources []*modules.TemplateSource
+		err = filepath.WalkDir(opts.SourceDir, func(sourcePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(opts.SourceDir, sourcePath)
+			if err != nil {
+				return err
+			}
+			if rel != "." && opts.excluded(filepath.ToSlash(rel)) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.IsDir() {
+				sources = append(sources, &modules.TemplateSource{Field: "source_dir", Path: sourcePath, Render: opts.Render})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source directory %s: %w", opts.SourceDir, err)
+		}
+		return sources, nil
+	}
+
+	return nil, nil
+}
+
+// docDataTemplates returns the string values of merge_json and merge_yaml data, which
+// are rendered as templates
+func docDataTemplates(value interface{}, field string) []*modules.TemplateSource {
+	var sources []*modules.TemplateSource
+	switch v := value.(type) {
+	case string:
+		sources = append(sources, &modules.TemplateSource{Field: field, Content: v, Render: true})
+	case map[string]interface{}:
+		for _, key := range sortedDataKeys(v) {
+			sources = append(sources, docDataTemplates(v[key], field+"."+key)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			sources = append(sources, docDataTemplates(item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+	}
+	return sources
+}
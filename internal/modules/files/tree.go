@@ -0,0 +1,400 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/backup"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// treeOptions holds the rendered configuration of an ensure_tree task
+type treeOptions struct {
+	SourceDir string
+	TargetDir string
+	Render    bool
+	Prune     bool
+	Exclude   []string
+}
+
+// treeFile is a single file of an ensure_tree task
+type treeFile struct {
+	RelPath  string // slash separated path relative to the source and target directories
+	Target   string
+	State    string // "new", "changed", "unchanged" or "prune"
+	Content  []byte
+	Existing []byte
+	Mode     os.FileMode
+}
+
+// validateEnsureTreeTask validates ensure_tree task configuration
+func (m *FilesModule) validateEnsureTreeTask(config map[string]interface{}) error {
+	for _, field := range []string{"source_dir", "target_dir"} {
+		value, exists := config[field]
+		if !exists {
+			return fmt.Errorf("ensure_tree task requires '%s' field", field)
+		}
+		if _, ok := modules.StringValue(value); !ok {
+			return fmt.Errorf("ensure_tree '%s' must be a string", field)
+		}
+	}
+
+	for _, field := range []string{"render", "prune", "backup"} {
+		if value, exists := config[field]; exists {
+			if _, ok := modules.BoolValue(value); !ok {
+				return fmt.Errorf("ensure_tree '%s' must be a boolean", field)
+			}
+		}
+	}
+
+	if exclude, exists := config["exclude"]; exists {
+		patterns, ok := exclude.([]interface{})
+		if !ok {
+			return fmt.Errorf("ensure_tree 'exclude' must be a list of glob patterns")
+		}
+		for _, pattern := range patterns {
+			patternStr, ok := pattern.(string)
+			if !ok {
+				return fmt.Errorf("ensure_tree 'exclude' must be a list of glob patterns")
+			}
+			if _, err := path.Match(patternStr, ""); err != nil {
+				return fmt.Errorf("ensure_tree 'exclude' pattern '%s' is invalid: %w", patternStr, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseEnsureTreeOptions renders the configuration of an ensure_tree task
+func (m *FilesModule) parseEnsureTreeOptions(task *config.Task, ctx *modules.ExecutionContext) (*treeOptions, error) {
+	sourceDir, err := m.processField(task, "source_dir", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process source_dir template: %w", err)
+	}
+	if !filepath.IsAbs(sourceDir) {
+		sourceDir = filepath.Join(ctx.BasePath, sourceDir)
+	}
+
+	targetDir, err := m.processField(task, "target_dir", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process target_dir template: %w", err)
+	}
+	targetDir, err = utils.ExpandPath(targetDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand target_dir: %w", err)
+	}
+
+	opts := &treeOptions{
+		SourceDir: sourceDir,
+		TargetDir: targetDir,
+	}
+	opts.Render, _ = modules.BoolValue(task.Config["render"])
+	opts.Prune, _ = modules.BoolValue(task.Config["prune"])
+	exclude, err := modules.GetStringSlice(task, "exclude")
+	if err != nil {
+		return nil, err
+	}
+	opts.Exclude = exclude
+
+	return opts, nil
+}
+
+// excluded reports whether a slash separated relative path matches one of the
+// exclude patterns. Patterns are matched against the whole path and against
+// every path element, so "*.bak" and ".git" exclude at any depth.
+func (o *treeOptions) excluded(relPath string) bool {
+	for _, pattern := range o.Exclude {
+		if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+		for _, element := range strings.Split(relPath, "/") {
+			if matched, _ := path.Match(pattern, element); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectTree compares the source directory of an ensure_tree task with its target
+func (m *FilesModule) collectTree(opts *treeOptions, ctx *modules.ExecutionContext) ([]*treeFile, error) {
+	if !utils.IsDirectory(opts.SourceDir) {
+		return nil, fmt.Errorf("source directory does not exist: %s", opts.SourceDir)
+	}
+
+	var files []*treeFile
+	sourceFiles := make(map[string]bool)
+
+	err := filepath.WalkDir(opts.SourceDir, func(sourcePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(opts.SourceDir, sourcePath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if opts.excluded(rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		file, err := m.treeFileFromSource(opts, ctx, sourcePath, rel)
+		if err != nil {
+			return err
+		}
+		sourceFiles[rel] = true
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to process source directory %s: %w", opts.SourceDir, err)
+	}
+
+	if opts.Prune && utils.IsDirectory(opts.TargetDir) {
+		err := filepath.WalkDir(opts.TargetDir, func(targetPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(opts.TargetDir, targetPath)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+
+			// Excluded paths are never managed, so they are never pruned either
+			if opts.excluded(rel) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() || sourceFiles[rel] {
+				return nil
+			}
+
+			files = append(files, &treeFile{RelPath: rel, Target: targetPath, State: "prune"})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read target directory %s: %w", opts.TargetDir, err)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].RelPath < files[j].RelPath
+	})
+	return files, nil
+}
+
+// treeFileFromSource renders a source file and compares it with its target
+func (m *FilesModule) treeFileFromSource(opts *treeOptions, ctx *modules.ExecutionContext, sourcePath, rel string) (*treeFile, error) {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Binary files are always copied as-is
+	if opts.Render && utf8.Valid(content) {
+		rendered, err := m.processTemplateWithPathConversion(string(content), ctx.Variables, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %w", sourcePath, err)
+		}
+		content = []byte(rendered)
+	}
+
+	file := &treeFile{
+		RelPath: rel,
+		Target:  filepath.Join(opts.TargetDir, filepath.FromSlash(rel)),
+		Content: content,
+		Mode:    info.Mode().Perm(),
+		State:   "new",
+	}
+
+	existing, err := os.ReadFile(file.Target)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Target, err)
+	}
+	file.Existing = existing
+	file.State = "changed"
+
+	if bytes.Equal(existing, content) && treeModeMatches(file.Target, file.Mode) {
+		file.State = "unchanged"
+	}
+	return file, nil
+}
+
+// treeModeMatches reports whether the target already has the mode of its source.
+// Modes are only compared on Unix.
+func treeModeMatches(target string, mode os.FileMode) bool {
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	info, err := os.Stat(target)
+	return err == nil && info.Mode().Perm() == mode
+}
+
+// summarizeTree returns the "3 new, 2 changed, 25 unchanged" summary of a tree
+func summarizeTree(files []*treeFile) (string, bool) {
+	counts := make(map[string]int)
+	for _, file := range files {
+		counts[file.State]++
+	}
+
+	summary := fmt.Sprintf("%d new, %d changed, %d unchanged", counts["new"], counts["changed"], counts["unchanged"])
+	if counts["prune"] > 0 {
+		summary += fmt.Sprintf(", %d to prune", counts["prune"])
+	}
+	return summary, counts["new"]+counts["changed"]+counts["prune"] > 0
+}
+
+// planEnsureTree returns what ensure_tree would do
+func (m *FilesModule) planEnsureTree(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	opts, err := m.parseEnsureTreeOptions(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: fmt.Sprintf("Ensure tree: %s -> %s", opts.SourceDir, opts.TargetDir),
+		Changes:     []string{},
+	}
+
+	if !utils.IsDirectory(opts.SourceDir) {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Source directory does not exist: %s", opts.SourceDir)
+		plan.SkipCode = modules.SkipMissingSource
+		return plan, nil
+	}
+
+	files, err := m.collectTree(opts, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, hasChanges := summarizeTree(files)
+	if !hasChanges {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Tree is up to date (%d files)", len(files))
+		return plan, nil
+	}
+	plan.Changes = append(plan.Changes, summary)
+
+	if !ctx.ShowDiff {
+		return plan, nil
+	}
+
+	for _, file := range files {
+		switch file.State {
+		case "new":
+			plan.Changes = append(plan.Changes, fmt.Sprintf("  + %s", file.RelPath))
+		case "prune":
+			plan.Changes = append(plan.Changes, fmt.Sprintf("  - %s", file.RelPath))
+		case "changed":
+			plan.Changes = append(plan.Changes, fmt.Sprintf("  ~ %s", file.RelPath))
+			if bytes.Equal(file.Existing, file.Content) {
+				plan.Changes = append(plan.Changes, fmt.Sprintf("    Change mode to %04o", file.Mode))
+				continue
+			}
+			for _, line := range utils.UnifiedDiff(string(file.Existing), string(file.Content), diffOptions(ctx)) {
+				plan.Changes = append(plan.Changes, fmt.Sprintf("    %s", line))
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// executeEnsureTree renders or copies a source directory into the target directory
+func (m *FilesModule) executeEnsureTree(task *config.Task, ctx *modules.ExecutionContext) error {
+	opts, err := m.parseEnsureTreeOptions(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	files, err := m.collectTree(opts, ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.EnsureDir(opts.TargetDir); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	for _, file := range files {
+		if file.State == "unchanged" {
+			continue
+		}
+
+		// Keep a copy of files we are about to overwrite or delete
+		if file.State != "new" && m.shouldBackup(task, ctx) {
+			backupPath, err := backup.BackupFile(file.Target, ctx.BackupDir, time.Now(), backup.DefaultFileBackupKeep)
+			if err != nil {
+				return fmt.Errorf("failed to back up existing file: %w", err)
+			}
+			if ctx.Verbose {
+				fmt.Printf("Backed up existing file: %s -> %s\n", file.Target, backupPath)
+			}
+		}
+
+		if file.State == "prune" {
+			if ctx.Verbose {
+				fmt.Printf("Removing file not in source: %s\n", file.Target)
+			}
+			if err := os.Remove(file.Target); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", file.Target, err)
+			}
+			continue
+		}
+
+		if ctx.Verbose {
+			if file.State == "new" {
+				fmt.Printf("Creating file: %s (mode: %04o)\n", file.Target, file.Mode)
+			} else {
+				fmt.Printf("Updating file: %s (mode: %04o)\n", file.Target, file.Mode)
+			}
+		}
+
+		if err := utils.EnsureDir(filepath.Dir(file.Target)); err != nil {
+			return fmt.Errorf("failed to create parent directory: %w", err)
+		}
+		if err := utils.WriteFileAtomic(file.Target, file.Content, file.Mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.Target, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// writeTree creates files below dir, keyed by slash separated relative path
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEnsureTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "files", "nvim")
+	targetDir := filepath.Join(tmpDir, "target")
+
+	writeTree(t, sourceDir, map[string]string{
+		"init.lua":             "vim.g.theme = '{{ theme }}'\n",
+		"lua/plugins.lua":      "return {}\n",
+		"lua/options.lua":      "vim.o.number = true\n",
+		"notes.bak":            "scratch\n",
+		"cache/lazy-lock.json": "{}\n",
+	})
+	writeTree(t, targetDir, map[string]string{
+		"lua/options.lua": "vim.o.number = false\n",
+		"lua/plugins.lua": "return {}\n",
+		"stale.lua":       "old\n",
+		"local.bak":       "kept\n",
+	})
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(filepath.Join(sourceDir, "lua", "plugins.lua"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := New()
+	ctx := &modules.ExecutionContext{
+		BasePath:  tmpDir,
+		Variables: map[string]interface{}{"theme": "dark"},
+	}
+	task := &config.Task{
+		ID:     "tree",
+		Action: "ensure_tree",
+		Config: map[string]interface{}{
+			"source_dir": "files/nvim",
+			"target_dir": targetDir,
+			"render":     true,
+			"prune":      true,
+			"exclude":    []interface{}{"*.bak", "cache"},
+		},
+	}
+	if err := m.ValidateTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSummary := "1 new, 2 changed, 0 unchanged, 1 to prune"
+	if runtime.GOOS == "windows" {
+		wantSummary = "1 new, 1 changed, 1 unchanged, 1 to prune"
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0] != wantSummary {
+		t.Errorf("plan changes = %v, want [%s]", plan.Changes, wantSummary)
+	}
+
+	// Per-file detail is only shown with --show-diff
+	ctx.ShowDiff = true
+	plan, err = m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := strings.Join(plan.Changes, "\n")
+	for _, want := range []string{"+ init.lua", "~ lua/options.lua", "- stale.lua"} {
+		if !strings.Contains(details, want) {
+			t.Errorf("expected %q in plan details:\n%s", want, details)
+		}
+	}
+	ctx.ShowDiff = false
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(targetDir, "init.lua")); string(content) != "vim.g.theme = 'dark'\n" {
+		t.Errorf("init.lua = %q, expected rendered template", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(targetDir, "lua", "options.lua")); string(content) != "vim.o.number = true\n" {
+		t.Errorf("options.lua = %q, expected source content", content)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "stale.lua")); !os.IsNotExist(err) {
+		t.Error("stale.lua should have been pruned")
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "local.bak")); err != nil {
+		t.Error("excluded files must never be pruned")
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "cache")); !os.IsNotExist(err) {
+		t.Error("excluded directories must not be copied")
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(targetDir, "lua", "plugins.lua"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("plugins.lua mode = %04o, want 0600", info.Mode().Perm())
+		}
+	}
+
+	// A second run has nothing to do
+	plan, err = m.PlanTask(task, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.WillSkip {
+		t.Errorf("expected up to date tree to be skipped, got %v", plan.Changes)
+	}
+}
+
+func TestEnsureTreeWithoutPruneKeepsExtraFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeTree(t, filepath.Join(tmpDir, "src"), map[string]string{"a.conf": "{{ raw }}"})
+	targetDir := filepath.Join(tmpDir, "dst")
+	writeTree(t, targetDir, map[string]string{"extra.conf": "mine"})
+
+	m := New()
+	ctx := &modules.ExecutionContext{BasePath: tmpDir, Variables: map[string]interface{}{}}
+	task := &config.Task{
+		ID:     "tree",
+		Action: "ensure_tree",
+		Config: map[string]interface{}{"source_dir": "src", "target_dir": targetDir},
+	}
+
+	if err := m.ExecuteTask(task, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(targetDir, "a.conf")); string(content) != "{{ raw }}" {
+		t.Errorf("a.conf = %q, expected raw copy without render", content)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "extra.conf")); err != nil {
+		t.Error("files not in the source must be kept without prune")
+	}
+}
+
+func TestValidateEnsureTreeTask(t *testing.T) {
+	m := New()
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		errMsg string
+	}{
+		{"MissingSource", map[string]interface{}{"target_dir": "/tmp/x"}, "requires 'source_dir'"},
+		{"ExcludeNotList", map[string]interface{}{"source_dir": "a", "target_dir": "b", "exclude": "*.bak"}, "list of glob patterns"},
+		{"BadPattern", map[string]interface{}{"source_dir": "a", "target_dir": "b", "exclude": []interface{}{"["}}, "invalid"},
+		{"PruneNotBool", map[string]interface{}{"source_dir": "a", "target_dir": "b", "prune": "yes"}, "must be a boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.ValidateTask(&config.Task{Action: "ensure_tree", Config: tt.config})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
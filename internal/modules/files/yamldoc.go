@@ -0,0 +1,177 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAMLDocument parses a YAML document whose root is a mapping. An empty
+// document returns an empty mapping.
+func parseYAMLDocument(content string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}, nil
+	}
+	root := doc.Content[0]
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		doc.Content[0] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: root.HeadComment}
+		return &doc, nil
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: the document must be a mapping of keys to values", root.Line)
+	}
+	return &doc, nil
+}
+
+// yamlIndent detects the indentation of a YAML document from its first indented
+// key. Documents without nested keys use two spaces.
+func yamlIndent(content string) int {
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || len(trimmed) == len(line) || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		return len(line) - len(trimmed)
+	}
+	return 2
+}
+
+// encodeYAMLDocument formats a document with the given indentation
+func encodeYAMLDocument(doc *yaml.Node, indent int) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// yamlMappingValue returns the index of the key node of a mapping with key, or -1
+func yamlMappingValue(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeYAML deep merges data into a mapping, keeping the comments of the values it
+// replaces. Mappings are merged key by key, any other value replaces the existing one.
+func mergeYAML(mapping *yaml.Node, data map[string]interface{}, path []string) ([]*docChange, error) {
+	var changes []*docChange
+	for _, key := range sortedDataKeys(data) {
+		value := data[key]
+		keyPath := append(append([]string{}, path...), key)
+		index := yamlMappingValue(mapping, key)
+
+		if index < 0 {
+			var node yaml.Node
+			if err := node.Encode(value); err != nil {
+				return nil, fmt.Errorf("failed to format %s: %w", joinKeyPath(keyPath), err)
+			}
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &node)
+			changes = append(changes, &docChange{Kind: "add", Path: keyPath, New: value})
+			continue
+		}
+
+		existing := mapping.Content[index+1]
+		if nested, ok := value.(map[string]interface{}); ok && existing.Kind == yaml.MappingNode {
+			nestedChanges, err := mergeYAML(existing, nested, keyPath)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, nestedChanges...)
+			continue
+		}
+
+		var old interface{}
+		if err := existing.Decode(&old); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", joinKeyPath(keyPath), err)
+		}
+		if reflect.DeepEqual(old, value) {
+			continue
+		}
+
+		var node yaml.Node
+		if err := node.Encode(value); err != nil {
+			return nil, fmt.Errorf("failed to format %s: %w", joinKeyPath(keyPath), err)
+		}
+		node.HeadComment, node.LineComment, node.FootComment = existing.HeadComment, existing.LineComment, existing.FootComment
+		mapping.Content[index+1] = &node
+		changes = append(changes, &docChange{Kind: "update", Path: keyPath, Old: old, New: value})
+	}
+	return changes, nil
+}
+
+// removeYAMLKeys removes the values at dot separated key paths from a mapping. Like
+// for JSON, the longest key that exists wins at every level.
+func removeYAMLKeys(mapping *yaml.Node, keys []string) ([]*docChange, error) {
+	type removal struct {
+		Parent *yaml.Node
+		Key    *yaml.Node
+		Path   []string
+	}
+	var removals []removal
+	var paths [][]string
+	for _, key := range keys {
+		parent, index, path := resolveYAMLKey(mapping, strings.Split(key, "."), nil)
+		if parent != nil {
+			removals = append(removals, removal{Parent: parent, Key: parent.Content[index], Path: path})
+			paths = append(paths, path)
+		}
+	}
+
+	var changes []*docChange
+	for _, r := range removals {
+		index := -1
+		for i := 0; i+1 < len(r.Parent.Content); i += 2 {
+			if r.Parent.Content[i] == r.Key {
+				index = i
+			}
+		}
+		// Keys inside a removed key go with it
+		if index < 0 || insideRemovedPath(r.Path, paths) {
+			continue
+		}
+		var old interface{}
+		if err := r.Parent.Content[index+1].Decode(&old); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", joinKeyPath(r.Path), err)
+		}
+		r.Parent.Content = append(r.Parent.Content[:index], r.Parent.Content[index+2:]...)
+		changes = append(changes, &docChange{Kind: "remove", Path: r.Path, Old: old})
+	}
+	return changes, nil
+}
+
+// resolveYAMLKey finds the mapping and key index a dot separated key path refers to
+func resolveYAMLKey(mapping *yaml.Node, parts, path []string) (*yaml.Node, int, []string) {
+	for i := len(parts); i > 0; i-- {
+		key := strings.Join(parts[:i], ".")
+		index := yamlMappingValue(mapping, key)
+		if index < 0 {
+			continue
+		}
+		keyPath := append(append([]string{}, path...), key)
+		if i == len(parts) {
+			return mapping, index, keyPath
+		}
+		if value := mapping.Content[index+1]; value.Kind == yaml.MappingNode {
+			if parent, index, found := resolveYAMLKey(value, parts[i:], keyPath); parent != nil {
+				return parent, index, found
+			}
+		}
+	}
+	return nil, -1, nil
+}
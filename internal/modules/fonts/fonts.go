@@ -0,0 +1,533 @@
+package fonts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// FontsModule installs fonts into the per-user font directory
+type FontsModule struct {
+	templateEngine *templating.TemplatingEngine
+	goos           string
+}
+
+// font holds the rendered configuration of an install_font task
+type font struct {
+	Source  string   // Rendered source, also the key of the task in the manifest
+	URL     string   // Set when the fonts are downloaded
+	Path    string   // Local file or directory, resolved against the dotfiles directory
+	SHA256  string   // Lowercase hex checksum of the download, empty when not pinned
+	Family  string   // Font family that counts as installed when the system has it
+	Include []string // Patterns font file names must match
+	Absent  bool
+	Dir     string // Per-user font directory
+}
+
+// New creates a new fonts module
+func New() *FontsModule {
+	return &FontsModule{
+		templateEngine: templating.NewTemplatingEngine("."),
+		goos:           runtime.GOOS,
+	}
+}
+
+// Name returns the module name
+func (m *FontsModule) Name() string {
+	return "fonts"
+}
+
+// ActionKeys returns the action keys this module handles
+func (m *FontsModule) ActionKeys() []string {
+	return []string{"install_font"}
+}
+
+// ValidateTask validates an install_font task configuration
+func (m *FontsModule) ValidateTask(task *config.Task) error {
+	if task.Action != "install_font" {
+		return fmt.Errorf("fonts module only handles 'install_font' action, got '%s'", task.Action)
+	}
+
+	for _, field := range []string{"source", "family", "state", "sha256"} {
+		if value, exists := task.Config[field]; exists {
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("install_font '%s' must be a string", field)
+			}
+		}
+	}
+
+	source, exists := task.Config["source"]
+	if !exists || source.(string) == "" {
+		return fmt.Errorf("install_font task requires 'source' field")
+	}
+
+	if state, exists := task.Config["state"]; exists && state != "present" && state != "absent" {
+		return fmt.Errorf("install_font 'state' must be 'present' or 'absent', got '%s'", state)
+	}
+
+	if checksum, exists := task.Config["sha256"]; exists {
+		if !isURL(source.(string)) {
+			return fmt.Errorf("install_font 'sha256' can only be used with a URL source")
+		}
+		if decoded, err := hex.DecodeString(checksum.(string)); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("install_font 'sha256' must be a 64 character hex checksum, got '%s'", checksum)
+		}
+	}
+
+	if include, exists := task.Config["include"]; exists {
+		patterns, ok := include.([]interface{})
+		if !ok {
+			return fmt.Errorf("install_font 'include' must be a list of glob patterns")
+		}
+		for _, pattern := range patterns {
+			patternStr, ok := pattern.(string)
+			if !ok {
+				return fmt.Errorf("install_font 'include' must be a list of glob patterns")
+			}
+			if _, err := path.Match(patternStr, ""); err != nil {
+				return fmt.Errorf("install_font 'include' pattern '%s' is invalid: %w", patternStr, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isURL reports whether a font source is downloaded
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// parseFont renders the configuration of an install_font task
+func (m *FontsModule) parseFont(task *config.Task, ctx *modules.ExecutionContext) (*font, error) {
+	rawSource, err := modules.GetString(task, "source")
+	if err != nil {
+		return nil, err
+	}
+	source, err := m.templateEngine.ProcessString(rawSource, ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process source template: %w", err)
+	}
+
+	f := &font{Source: source}
+	if isURL(source) {
+		f.URL = source
+	} else {
+		f.Path = filepath.FromSlash(source)
+		if !filepath.IsAbs(f.Path) {
+			f.Path = filepath.Join(ctx.BasePath, f.Path)
+		}
+	}
+
+	if family, ok := modules.StringValue(task.Config["family"]); ok {
+		f.Family, err = m.templateEngine.ProcessString(family, ctx.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process family template: %w", err)
+		}
+	}
+	if checksum, ok := modules.StringValue(task.Config["sha256"]); ok {
+		f.SHA256 = strings.ToLower(checksum)
+	}
+	if f.Include, err = modules.GetStringSlice(task, "include"); err != nil {
+		return nil, err
+	}
+	if state, ok := modules.StringValue(task.Config["state"]); ok {
+		f.Absent = state == "absent"
+	}
+
+	f.Dir, err = userFontDir(m.goos)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// userFontDir returns the directory fonts are installed in for the current user
+func userFontDir(goos string) (string, error) {
+	if goos == "windows" {
+		localAppData := os.Getenv("LOCALAPPDATA")
+		if localAppData == "" {
+			return "", fmt.Errorf("LOCALAPPDATA is not set, cannot find the user font directory")
+		}
+		return filepath.Join(localAppData, "Microsoft", "Windows", "Fonts"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	if goos == "darwin" {
+		return filepath.Join(home, "Library", "Fonts"), nil
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "fonts"), nil
+	}
+	return filepath.Join(home, ".local", "share", "fonts"), nil
+}
+
+// familyInstalled reports whether the system already has a font family. Registered
+// font names include the style, so "JetBrainsMono Nerd Font Bold" counts as well.
+func familyInstalled(family string) (bool, error) {
+	families, err := installedFamilies()
+	if err != nil {
+		return false, err
+	}
+	family = strings.ToLower(family)
+	for _, installed := range families {
+		installed = strings.ToLower(installed)
+		if installed == family || strings.HasPrefix(installed, family+" ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PlanTask returns what the install_font task would do
+func (m *FontsModule) PlanTask(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	f, err := m.parseFont(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: fmt.Sprintf("Install fonts from %s into %s", f.Source, f.Dir),
+		Changes:     []string{},
+	}
+
+	fontManifest, err := loadManifest(ctx.BasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Absent {
+		plan.Description = fmt.Sprintf("Remove fonts installed from %s", f.Source)
+		for _, file := range fontManifest.Fonts[f.Source] {
+			if utils.FileExists(file) {
+				plan.Changes = append(plan.Changes, fmt.Sprintf("Remove %s", file))
+			}
+		}
+		if len(plan.Changes) == 0 {
+			plan.WillSkip = true
+			plan.SkipReason = "No fonts from this source are installed"
+		}
+		return plan, nil
+	}
+
+	if f.Family != "" {
+		installed, err := familyInstalled(f.Family)
+		if err != nil {
+			return nil, err
+		}
+		if installed {
+			plan.WillSkip = true
+			plan.SkipReason = fmt.Sprintf("Font family '%s' is already installed", f.Family)
+			return plan, nil
+		}
+	}
+
+	files, err := readFonts(f, ctx, false)
+	if errors.Is(err, errNotDownloaded) {
+		// Nothing is downloaded while planning, so only the manifest tells whether
+		// an earlier apply installed these fonts
+		if fontManifest.installed(f.Source) {
+			plan.WillSkip = true
+			plan.SkipReason = "Fonts are already installed"
+			return plan, nil
+		}
+		if ctx.Offline {
+			plan.WillSkip = true
+			plan.SkipReason = fmt.Sprintf("Offline and %s is not cached", f.URL)
+			plan.SkipCode = modules.SkipOffline
+			return plan, nil
+		}
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Download %s (not cached)", f.URL))
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Install the fonts it contains into %s", f.Dir))
+		plan.Changes = append(plan.Changes, m.refreshChange())
+		return plan, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		switch target := filepath.Join(f.Dir, file.Name); {
+		case !utils.FileExists(target):
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Install %s", target))
+		case !fileHasContent(target, file.Data):
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Update %s", target))
+		}
+	}
+	if len(plan.Changes) == 0 {
+		plan.WillSkip = true
+		plan.SkipReason = "Fonts are already installed"
+		return plan, nil
+	}
+	plan.Changes = append(plan.Changes, m.refreshChange())
+	return plan, nil
+}
+
+// PreflightChecks returns the checks an install_font task needs: its source exists
+// or can be downloaded and the font directory is writable
+func (m *FontsModule) PreflightChecks(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.PreflightCheck, error) {
+	f, err := m.parseFont(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if f.Absent {
+		return nil, nil
+	}
+
+	var checks []*modules.PreflightCheck
+	if f.URL == "" {
+		checks = append(checks, modules.SourceExistsCheck("source", f.Path))
+	} else if _, err := fetchFont(f, ctx, false); errors.Is(err, errNotDownloaded) && !ctx.Offline {
+		check, err := modules.NetworkCheck(f.URL)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return append(checks, modules.WritableDirCheck(f.Dir)), nil
+}
+
+// refreshChange describes how the new fonts are made known to the system
+func (m *FontsModule) refreshChange() string {
+	switch m.goos {
+	case "windows":
+		return `Register the fonts in HKCU and notify running applications`
+	case "darwin":
+		return "Fonts are picked up by macOS automatically"
+	default:
+		return "Refresh the font cache with fc-cache"
+	}
+}
+
+// fileHasContent reports whether the file at path contains exactly data
+func fileHasContent(path string, data []byte) bool {
+	existing, err := os.ReadFile(path)
+	return err == nil && bytes.Equal(existing, data)
+}
+
+// ExecuteTask executes an install_font task
+func (m *FontsModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionContext) error {
+	if ctx.DryRun {
+		return nil // Plan already showed what would happen
+	}
+
+	f, err := m.parseFont(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	fontManifest, err := loadManifest(ctx.BasePath)
+	if err != nil {
+		return err
+	}
+
+	if f.Absent {
+		return m.removeFonts(f, fontManifest, ctx)
+	}
+	return m.installFonts(f, fontManifest, ctx)
+}
+
+// installFonts writes the fonts of a source into the font directory and records
+// them in the manifest
+func (m *FontsModule) installFonts(f *font, fontManifest *manifest, ctx *modules.ExecutionContext) error {
+	files, err := readFonts(f, ctx, true)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.EnsureDir(f.Dir); err != nil {
+		return fmt.Errorf("failed to create font directory %s: %w", f.Dir, err)
+	}
+
+	changed := false
+	installed := make(map[string]bool)
+	var targets []string
+	for _, file := range files {
+		target := filepath.Join(f.Dir, file.Name)
+		targets = append(targets, target)
+		installed[target] = true
+
+		if !fileHasContent(target, file.Data) {
+			if err := os.WriteFile(target, file.Data, 0644); err != nil {
+				return fmt.Errorf("failed to install font %s: %w", target, err)
+			}
+			if err := registerFont(target); err != nil {
+				return err
+			}
+			changed = true
+			if ctx.Verbose {
+				fmt.Printf("Installed %s\n", target)
+			}
+		}
+	}
+
+	// Fonts an earlier apply installed from this source that it no longer provides,
+	// for example after changing include, are removed
+	for _, previous := range fontManifest.Fonts[f.Source] {
+		if installed[previous] {
+			continue
+		}
+		if err := removeFont(previous); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	fontManifest.Fonts[f.Source] = targets
+	if err := fontManifest.save(ctx.BasePath); err != nil {
+		return err
+	}
+
+	if changed {
+		return m.refreshFonts(f.Dir, ctx)
+	}
+	return nil
+}
+
+// removeFonts removes the fonts the manifest lists for a source
+func (m *FontsModule) removeFonts(f *font, fontManifest *manifest, ctx *modules.ExecutionContext) error {
+	files, exists := fontManifest.Fonts[f.Source]
+	if !exists {
+		return nil
+	}
+
+	for _, file := range files {
+		if err := removeFont(file); err != nil {
+			return err
+		}
+		if ctx.Verbose {
+			fmt.Printf("Removed %s\n", file)
+		}
+	}
+
+	delete(fontManifest.Fonts, f.Source)
+	if err := fontManifest.save(ctx.BasePath); err != nil {
+		return err
+	}
+	return m.refreshFonts(f.Dir, ctx)
+}
+
+// removeFont unregisters and deletes an installed font file
+func removeFont(file string) error {
+	if err := unregisterFont(file); err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove font %s: %w", file, err)
+	}
+	return nil
+}
+
+// refreshFonts makes the system pick up installed or removed fonts. On Linux this
+// rebuilds the fontconfig cache when fc-cache is installed.
+func (m *FontsModule) refreshFonts(dir string, ctx *modules.ExecutionContext) error {
+	switch m.goos {
+	case "windows":
+		return notifyFontChange()
+	case "darwin":
+		return nil
+	}
+
+	if _, err := exec.LookPath("fc-cache"); err != nil {
+		return nil
+	}
+	output, err := exec.CommandContext(ctx.RunContext(), "fc-cache", "-f", dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to refresh the font cache: %w\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ExplainAction returns documentation for a specific action
+func (m *FontsModule) ExplainAction(action string) (*modules.ActionDocumentation, error) {
+	for _, doc := range m.ListActions() {
+		if doc.Action == action {
+			return doc, nil
+		}
+	}
+	return nil, fmt.Errorf("action '%s' not supported by fonts module", action)
+}
+
+// ListActions returns documentation for all actions supported by this module
+func (m *FontsModule) ListActions() []*modules.ActionDocumentation {
+	return []*modules.ActionDocumentation{
+		{
+			Action:      "install_font",
+			Description: "Installs fonts for the current user: into ~/.local/share/fonts on Linux (refreshing fc-cache), ~/Library/Fonts on macOS and %LOCALAPPDATA%\\Microsoft\\Windows\\Fonts on Windows, where they are registered in HKCU. Installed files are recorded in fonts/manifest.yaml of the state directory (settings.state_dir) so state: absent removes exactly those.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "source",
+					Type:        "string",
+					Required:    true,
+					Description: "Font file (.ttf, .otf, .ttc), zip archive or directory in the dotfiles repository, or an HTTP(S) URL to a font file or zip archive. Downloads are cached in the state directory. Supports template variables.",
+				},
+				{
+					Name:        "include",
+					Type:        "array",
+					Required:    false,
+					Description: "Glob patterns font file names must match, to install only some fonts of an archive or directory",
+				},
+				{
+					Name:        "family",
+					Type:        "string",
+					Required:    false,
+					Description: "Font family name. When the system already has this family the task is skipped, even if it was installed some other way.",
+				},
+				{
+					Name:        "sha256",
+					Type:        "string",
+					Required:    false,
+					Description: "Expected SHA-256 checksum of the download, only for URL sources",
+				},
+				{
+					Name:        "state",
+					Type:        "string",
+					Required:    false,
+					Default:     "present",
+					Description: "'present' to install the fonts, 'absent' to remove the fonts this source installed before",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Install a Nerd Font from its release archive",
+					Config: map[string]interface{}{
+						"source":  "https://github.com/ryanoasis/nerd-fonts/releases/download/v3.2.1/JetBrainsMono.zip",
+						"family":  "JetBrainsMono Nerd Font",
+						"include": []interface{}{"JetBrainsMonoNerdFont-*.ttf"},
+					},
+				},
+				{
+					Description: "Install the fonts kept in the dotfiles repository",
+					Config: map[string]interface{}{
+						"source": "files/fonts",
+					},
+				},
+				{
+					Description: "Remove fonts installed from a source",
+					Config: map[string]interface{}{
+						"source": "files/fonts/OldFont.ttf",
+						"state":  "absent",
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,244 @@
+package fonts
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// linuxFontEnv points HOME at a temporary directory and puts fake fc-cache and
+// fc-list commands on PATH. fc-cache records its arguments in the returned file and
+// fc-list prints families.
+func linuxFontEnv(t *testing.T, families string) (fontDir, fcCacheLog string) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("installs into the Linux font directory")
+	}
+
+	home := t.TempDir()
+	bin := t.TempDir()
+	fcCacheLog = filepath.Join(bin, "fc-cache.log")
+
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "fc-cache"), []byte("#!/bin/sh\necho \"$@\" >> "+fcCacheLog+"\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "fc-list"), []byte("#!/bin/sh\nprintf '"+families+"'\n"), 0755))
+
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("PATH", bin)
+	return filepath.Join(home, ".local", "share", "fonts"), fcCacheLog
+}
+
+// newLinuxModule creates a fonts module that installs like on Linux
+func newLinuxModule() *FontsModule {
+	m := New()
+	m.goos = "linux"
+	return m
+}
+
+// newFontTask creates an install_font task
+func newFontTask(cfg map[string]interface{}) *config.Task {
+	return &config.Task{ID: "install_font: test", Action: "install_font", Config: cfg}
+}
+
+// writeZip creates a zip archive with the given files
+func writeZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		writer, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = writer.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestValidateInstallFont(t *testing.T) {
+	m := New()
+
+	valid := []map[string]interface{}{
+		{"source": "files/fonts"},
+		{"source": "files/fonts/Hack.zip", "include": []interface{}{"Hack-*.ttf"}, "family": "Hack"},
+		{"source": "https://example.com/font.zip", "sha256": "3a6e2f1d5b7c9e0a4f8d2c6b1e5a9d3f7c0b4e8a2d6f1c5b9e3a7d0f4c8b2e6a"},
+		{"source": "files/fonts/Old.ttf", "state": "absent"},
+	}
+	for _, cfg := range valid {
+		assert.NoError(t, m.ValidateTask(newFontTask(cfg)), "%v", cfg)
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"missing source":       {"family": "Hack"},
+		"source type":          {"source": 42},
+		"unknown state":        {"source": "files/fonts", "state": "gone"},
+		"sha256 without URL":   {"source": "files/fonts/Hack.zip", "sha256": "3a6e2f1d5b7c9e0a4f8d2c6b1e5a9d3f7c0b4e8a2d6f1c5b9e3a7d0f4c8b2e6a"},
+		"short sha256":         {"source": "https://example.com/font.zip", "sha256": "abc"},
+		"include type":         {"source": "files/fonts", "include": "*.ttf"},
+		"invalid include glob": {"source": "files/fonts", "include": []interface{}{"[*.ttf"}},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, m.ValidateTask(newFontTask(cfg)), name)
+	}
+}
+
+func TestInstallFontLinux(t *testing.T) {
+	fontDir, fcCacheLog := linuxFontEnv(t, "")
+	basePath := t.TempDir()
+	sourceDir := filepath.Join(basePath, "files", "fonts")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "mono"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "Hack-Regular.ttf"), []byte("regular"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "mono", "Hack-Bold.otf"), []byte("bold"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "LICENSE.md"), []byte("license"), 0644))
+
+	m := newLinuxModule()
+	ctx := &modules.ExecutionContext{BasePath: basePath}
+	task := newFontTask(map[string]interface{}{"source": "files/fonts"})
+
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.False(t, plan.WillSkip)
+	assert.Equal(t, []string{
+		"Install " + filepath.Join(fontDir, "Hack-Bold.otf"),
+		"Install " + filepath.Join(fontDir, "Hack-Regular.ttf"),
+		"Refresh the font cache with fc-cache",
+	}, plan.Changes)
+
+	require.NoError(t, m.ExecuteTask(task, ctx))
+	content, err := os.ReadFile(filepath.Join(fontDir, "Hack-Regular.ttf"))
+	require.NoError(t, err)
+	assert.Equal(t, "regular", string(content))
+	assert.FileExists(t, filepath.Join(fontDir, "Hack-Bold.otf"))
+	assert.NoFileExists(t, filepath.Join(fontDir, "LICENSE.md"))
+
+	log, err := os.ReadFile(fcCacheLog)
+	require.NoError(t, err)
+	assert.Equal(t, "-f "+fontDir+"\n", string(log))
+
+	fontManifest, err := loadManifest(basePath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(fontDir, "Hack-Bold.otf"), filepath.Join(fontDir, "Hack-Regular.ttf")}, fontManifest.Fonts["files/fonts"])
+
+	// Running again changes nothing
+	plan, err = m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+	assert.Equal(t, "Fonts are already installed", plan.SkipReason)
+
+	// A font the user installed themselves is left alone when removing
+	require.NoError(t, os.WriteFile(filepath.Join(fontDir, "Other.ttf"), []byte("other"), 0644))
+
+	absent := newFontTask(map[string]interface{}{"source": "files/fonts", "state": "absent"})
+	plan, err = m.PlanTask(absent, ctx)
+	require.NoError(t, err)
+	assert.Len(t, plan.Changes, 2)
+
+	require.NoError(t, m.ExecuteTask(absent, ctx))
+	assert.NoFileExists(t, filepath.Join(fontDir, "Hack-Regular.ttf"))
+	assert.NoFileExists(t, filepath.Join(fontDir, "Hack-Bold.otf"))
+	assert.FileExists(t, filepath.Join(fontDir, "Other.ttf"))
+
+	fontManifest, err = loadManifest(basePath)
+	require.NoError(t, err)
+	assert.Empty(t, fontManifest.Fonts)
+
+	plan, err = m.PlanTask(absent, ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+}
+
+func TestInstallFontFromZip(t *testing.T) {
+	fontDir, _ := linuxFontEnv(t, "")
+	basePath := t.TempDir()
+	archive := writeZip(t, map[string]string{
+		"JetBrainsMonoNerdFont-Regular.ttf":     "regular",
+		"JetBrainsMonoNerdFontMono-Regular.ttf": "mono",
+		"README.md":                             "readme",
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "JetBrainsMono.zip"), archive, 0644))
+
+	m := newLinuxModule()
+	ctx := &modules.ExecutionContext{BasePath: basePath}
+
+	require.NoError(t, m.ExecuteTask(newFontTask(map[string]interface{}{"source": "JetBrainsMono.zip"}), ctx))
+	assert.FileExists(t, filepath.Join(fontDir, "JetBrainsMonoNerdFont-Regular.ttf"))
+	assert.FileExists(t, filepath.Join(fontDir, "JetBrainsMonoNerdFontMono-Regular.ttf"))
+	assert.NoFileExists(t, filepath.Join(fontDir, "README.md"))
+
+	// Narrowing include removes the fonts this source no longer provides
+	task := newFontTask(map[string]interface{}{
+		"source":  "JetBrainsMono.zip",
+		"include": []interface{}{"JetBrainsMonoNerdFont-*.ttf"},
+	})
+	require.NoError(t, m.ExecuteTask(task, ctx))
+	assert.FileExists(t, filepath.Join(fontDir, "JetBrainsMonoNerdFont-Regular.ttf"))
+	assert.NoFileExists(t, filepath.Join(fontDir, "JetBrainsMonoNerdFontMono-Regular.ttf"))
+
+	task.Config["include"] = []interface{}{"*.woff"}
+	_, err := m.PlanTask(task, ctx)
+	assert.ErrorContains(t, err, "no font files found")
+}
+
+func TestInstallFontFamilyInstalled(t *testing.T) {
+	linuxFontEnv(t, `DejaVu Sans\nJetBrainsMono Nerd Font,JetBrainsMono NF\n`)
+	basePath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "font.ttf"), []byte("font"), 0644))
+
+	m := newLinuxModule()
+	ctx := &modules.ExecutionContext{BasePath: basePath}
+
+	plan, err := m.PlanTask(newFontTask(map[string]interface{}{"source": "font.ttf", "family": "jetbrainsmono nf"}), ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+	assert.Equal(t, "Font family 'jetbrainsmono nf' is already installed", plan.SkipReason)
+
+	plan, err = m.PlanTask(newFontTask(map[string]interface{}{"source": "font.ttf", "family": "Fira Code"}), ctx)
+	require.NoError(t, err)
+	assert.False(t, plan.WillSkip)
+}
+
+func TestInstallFontFromURL(t *testing.T) {
+	fontDir, _ := linuxFontEnv(t, "")
+	archive := writeZip(t, map[string]string{"fonts/Hack-Regular.ttf": "regular"})
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	m := newLinuxModule()
+	ctx := &modules.ExecutionContext{BasePath: t.TempDir()}
+	task := newFontTask(map[string]interface{}{"source": server.URL + "/Hack.zip"})
+
+	// Planning never downloads
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Download "+server.URL+"/Hack.zip (not cached)", plan.Changes[0])
+	assert.Equal(t, 0, requests)
+
+	require.NoError(t, m.ExecuteTask(task, ctx))
+	assert.FileExists(t, filepath.Join(fontDir, "Hack-Regular.ttf"))
+	assert.Equal(t, 1, requests)
+
+	plan, err = m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+
+	pinned := newFontTask(map[string]interface{}{
+		"source": server.URL + "/Other.zip",
+		"sha256": "0000000000000000000000000000000000000000000000000000000000000000",
+	})
+	assert.ErrorContains(t, m.ExecuteTask(pinned, ctx), "checksum mismatch")
+}
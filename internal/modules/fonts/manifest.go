@@ -0,0 +1,71 @@
+package fonts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// manifest records the font files install_font installed, so state: absent removes
+// exactly those files and nothing the user installed themselves
+type manifest struct {
+	Fonts map[string][]string `yaml:"fonts"` // Installed font files by task source
+}
+
+// manifestPath returns where the font manifest of a dotfiles repository is stored
+func manifestPath(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), "fonts", "manifest.yaml")
+}
+
+// loadManifest reads the font manifest, returning an empty one when nothing was installed yet
+func loadManifest(basePath string) (*manifest, error) {
+	m := &manifest{Fonts: make(map[string][]string)}
+	data, err := os.ReadFile(manifestPath(basePath))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font manifest: %w", err)
+	}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse font manifest %s: %w", manifestPath(basePath), err)
+	}
+	if m.Fonts == nil {
+		m.Fonts = make(map[string][]string)
+	}
+	return m, nil
+}
+
+// save writes the font manifest
+func (m *manifest) save(basePath string) error {
+	path := manifestPath(basePath)
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create font manifest directory: %w", err)
+	}
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode font manifest: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write font manifest: %w", err)
+	}
+	return nil
+}
+
+// installed reports whether every file recorded for source still exists
+func (m *manifest) installed(source string) bool {
+	files := m.Fonts[source]
+	if len(files) == 0 {
+		return false
+	}
+	for _, file := range files {
+		if !utils.FileExists(file) {
+			return false
+		}
+	}
+	return true
+}
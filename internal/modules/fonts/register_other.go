@@ -0,0 +1,46 @@
+//go:build !windows
+
+package fonts
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// installedFamilies returns the font families fontconfig knows about, or nil when
+// fc-list is not installed
+func installedFamilies() ([]string, error) {
+	if _, err := exec.LookPath("fc-list"); err != nil {
+		return nil, nil
+	}
+	output, err := exec.Command("fc-list", ":", "family").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list font families: %w", err)
+	}
+
+	// Every line lists the names of one family separated by commas
+	var families []string
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, family := range strings.Split(line, ",") {
+			if family = strings.TrimSpace(family); family != "" {
+				families = append(families, family)
+			}
+		}
+	}
+	return families, nil
+}
+
+// registerFont does nothing, fonts in the per-user font directory are found without
+// registering them
+func registerFont(path string) error {
+	return nil
+}
+
+func unregisterFont(path string) error {
+	return nil
+}
+
+func notifyFontChange() error {
+	return nil
+}
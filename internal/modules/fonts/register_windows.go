@@ -0,0 +1,128 @@
+//go:build windows
+
+package fonts
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// fontsKey is the registry key fonts are registered under, in HKLM for every user
+// and in HKCU for fonts installed per user
+const fontsKey = `Software\Microsoft\Windows NT\CurrentVersion\Fonts`
+
+var (
+	gdi32                  = windows.NewLazySystemDLL("gdi32.dll")
+	procAddFontResource    = gdi32.NewProc("AddFontResourceW")
+	procRemoveFontResource = gdi32.NewProc("RemoveFontResourceW")
+	user32                 = windows.NewLazySystemDLL("user32.dll")
+	procSendMessageTimeout = user32.NewProc("SendMessageTimeoutW")
+)
+
+const (
+	hwndBroadcast   = 0xffff
+	wmFontChange    = 0x001d
+	smtoAbortIfHung = 0x0002
+)
+
+// fontValueName returns the registry value name of a font file
+func fontValueName(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if strings.EqualFold(filepath.Ext(path), ".otf") {
+		return name + " (OpenType)"
+	}
+	return name + " (TrueType)"
+}
+
+// installedFamilies returns the names of the fonts registered for every user and
+// for the current user
+func installedFamilies() ([]string, error) {
+	var families []string
+	for _, root := range []registry.Key{registry.LOCAL_MACHINE, registry.CURRENT_USER} {
+		key, err := registry.OpenKey(root, fontsKey, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		names, err := key.ReadValueNames(-1)
+		key.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list installed fonts: %w", err)
+		}
+
+		// Value names look like "Cascadia Code Regular & Bold (TrueType)"
+		for _, name := range names {
+			if i := strings.LastIndex(name, " ("); i > 0 {
+				name = name[:i]
+			}
+			families = append(families, strings.Split(name, " & ")...)
+		}
+	}
+	return families, nil
+}
+
+// registerFont registers a per-user font in HKCU and loads it into this session
+func registerFont(path string) error {
+	key, _, err := registry.CreateKey(registry.CURRENT_USER, fontsKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open the font registry: %w", err)
+	}
+	defer key.Close()
+
+	if err := key.SetStringValue(fontValueName(path), path); err != nil {
+		return fmt.Errorf("failed to register %s: %w", path, err)
+	}
+
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	if ret, _, err := procAddFontResource.Call(uintptr(unsafe.Pointer(pathPtr))); ret == 0 {
+		return fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return nil
+}
+
+// unregisterFont unloads a per-user font and removes it from HKCU
+func unregisterFont(path string) error {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	// Fails when the font is not loaded, which is fine since it is removed anyway
+	procRemoveFontResource.Call(uintptr(unsafe.Pointer(pathPtr)))
+
+	key, err := registry.OpenKey(registry.CURRENT_USER, fontsKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open the font registry: %w", err)
+	}
+	defer key.Close()
+
+	if err := key.DeleteValue(fontValueName(path)); err != nil && !errors.Is(err, registry.ErrNotExist) {
+		return fmt.Errorf("failed to unregister %s: %w", path, err)
+	}
+	return nil
+}
+
+// notifyFontChange sends WM_FONTCHANGE so running applications pick up the fonts
+func notifyFontChange() error {
+	var result uintptr
+	ret, _, err := procSendMessageTimeout.Call(
+		hwndBroadcast,
+		wmFontChange,
+		0,
+		0,
+		smtoAbortIfHung,
+		5000,
+		uintptr(unsafe.Pointer(&result)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
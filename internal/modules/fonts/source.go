@@ -0,0 +1,238 @@
+package fonts
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// downloadTimeout limits a single font download including redirects
+const downloadTimeout = 5 * time.Minute
+
+// fontExtensions are the font file types install_font installs
+var fontExtensions = map[string]bool{".ttf": true, ".otf": true, ".ttc": true}
+
+// zipMagic is the signature every zip archive starts with
+var zipMagic = []byte("PK\x03\x04")
+
+// errNotDownloaded is returned when the fonts of a URL are needed while planning and
+// the URL has not been downloaded yet
+var errNotDownloaded = errors.New("font source has not been downloaded yet")
+
+// fontFile is a single font file provided by the source of an install_font task
+type fontFile struct {
+	Name string // File name inside the font directory
+	Data []byte
+}
+
+// isFontFile reports whether name has one of the font extensions
+func isFontFile(name string) bool {
+	return fontExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// downloadCachePath returns the cache file of a URL, shared with ensure_file content_url
+func downloadCachePath(basePath, rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(config.StateDir(basePath), "downloads", hex.EncodeToString(sum[:]))
+}
+
+// sha256Hex returns the hex encoded SHA-256 checksum of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readFonts returns the font files the source of a task provides. URLs are only
+// downloaded when download is true, otherwise errNotDownloaded is returned for a URL
+// that is not cached yet.
+func readFonts(f *font, ctx *modules.ExecutionContext, download bool) ([]*fontFile, error) {
+	var files []*fontFile
+	switch {
+	case f.URL != "":
+		data, err := fetchFont(f, ctx, download)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := url.Parse(f.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid font URL %s: %w", f.URL, err)
+		}
+		files, err = fontsFromData(path.Base(parsed.Path), data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fonts from %s: %w", f.URL, err)
+		}
+	case utils.IsDirectory(f.Path):
+		err := filepath.WalkDir(f.Path, func(sourcePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !isFontFile(sourcePath) {
+				return err
+			}
+			data, err := os.ReadFile(sourcePath)
+			if err != nil {
+				return err
+			}
+			files = append(files, &fontFile{Name: filepath.Base(sourcePath), Data: data})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read font directory %s: %w", f.Path, err)
+		}
+	default:
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read font source: %w", err)
+		}
+		files, err = fontsFromData(filepath.Base(f.Path), data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fonts from %s: %w", f.Path, err)
+		}
+	}
+
+	files = filterFonts(files, f.Include)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no font files found in %s", f.Source)
+	}
+	return files, nil
+}
+
+// fontsFromData returns the fonts in a zip archive, or the single font file name
+// when data is not an archive
+func fontsFromData(name string, data []byte) ([]*fontFile, error) {
+	if !bytes.HasPrefix(data, zipMagic) {
+		if !isFontFile(name) {
+			return nil, fmt.Errorf("%s is not a font file or zip archive", name)
+		}
+		return []*fontFile{{Name: name, Data: data}}, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	var files []*fontFile
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || !isFontFile(entry.Name) {
+			continue
+		}
+		reader, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", entry.Name, err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", entry.Name, err)
+		}
+		// Archives may keep fonts in subdirectories, the font directory is flat
+		files = append(files, &fontFile{Name: path.Base(entry.Name), Data: content})
+	}
+	return files, nil
+}
+
+// filterFonts keeps the fonts matching one of the include patterns, sorted by name.
+// The first font wins when an archive contains the same file name twice.
+func filterFonts(files []*fontFile, include []string) []*fontFile {
+	seen := make(map[string]bool)
+	var filtered []*fontFile
+	for _, file := range files {
+		if seen[file.Name] || !matchesInclude(file.Name, include) {
+			continue
+		}
+		seen[file.Name] = true
+		filtered = append(filtered, file)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].Name < filtered[j].Name
+	})
+	return filtered
+}
+
+// matchesInclude reports whether name matches one of the patterns, or there are none
+func matchesInclude(name string, include []string) bool {
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchFont returns the content of a font URL. A cached download is used when it
+// still matches the pinned checksum, or when no checksum is pinned.
+func fetchFont(f *font, ctx *modules.ExecutionContext, download bool) ([]byte, error) {
+	cachePath := downloadCachePath(ctx.BasePath, f.URL)
+	if data, err := os.ReadFile(cachePath); err == nil && (f.SHA256 == "" || sha256Hex(data) == f.SHA256) {
+		return data, nil
+	}
+	if !download {
+		return nil, errNotDownloaded
+	}
+	if ctx.Offline {
+		return nil, fmt.Errorf("cannot download %s in offline mode and it is not cached", f.URL)
+	}
+
+	data, err := downloadURL(ctx.RunContext(), f.URL)
+	if err != nil {
+		return nil, err
+	}
+	if f.SHA256 != "" {
+		if actual := sha256Hex(data); actual != f.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", f.URL, f.SHA256, actual)
+		}
+	}
+
+	if err := utils.EnsureDir(filepath.Dir(cachePath)); err != nil {
+		return nil, fmt.Errorf("failed to create download cache: %w", err)
+	}
+	if err := os.WriteFile(cachePath, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write download cache: %w", err)
+	}
+	return data, nil
+}
+
+// downloadURL downloads a URL within downloadTimeout
+func downloadURL(ctx context.Context, rawURL string) ([]byte, error) {
+	client := &http.Client{Timeout: downloadTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", rawURL, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to download %s: server returned %s", rawURL, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	return data, nil
+}
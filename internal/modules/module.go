@@ -1,9 +1,15 @@
 package modules
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"time"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
 )
 
 // ActionParameter describes a parameter for an action
@@ -55,22 +61,164 @@ type Module interface {
 
 // ExecutionContext provides context for task execution
 type ExecutionContext struct {
-	BasePath    string                 // Base directory of dotfiles repo
-	Variables   map[string]interface{} // Processed variables
-	DryRun      bool                   // Whether this is a dry run
-	Verbose     bool                   // Whether to output verbose information
-	ShowDiff    bool                   // Whether to show detailed diffs of file changes
-	HideSkipped bool                   // Whether to hide skipped jobs from output
+	BasePath       string                 // Base directory of dotfiles repo
+	Variables      map[string]interface{} // Processed variables
+	DryRun         bool                   // Whether this is a dry run
+	PlanExec       bool                   // Whether planning runs content_command to compare its output
+	Verbose        bool                   // Whether to output verbose information
+	ShowDiff       bool                   // Whether to show detailed diffs of file changes
+	DiffContext    int                    // Unchanged lines shown around each change in diffs
+	DiffColor      bool                   // Whether diffs are colored for a terminal
+	HideSkipped    bool                   // Whether to hide skipped jobs from output
+	CreateBackups  bool                   // Whether to back up files before overwriting them by default
+	BackupDir      string                 // Directory for backups of overwritten files
+	TemplatesDir   string                 // Directory includes of pongo2 file templates are searched in, empty for files/templates
+	Offline        bool                   // Whether network access (e.g. downloads) is disabled
+	SudoCommand    string                 // Command package managers escalate with, empty for sudo
+	ForceWildcards bool                   // Whether wildcard uninstalls may remove more packages than max_removals
+	Elevated       bool                   // Whether the process runs as root or Administrator, tasks with become run directly
+	SkipElevated   bool                   // Whether tasks with become are skipped instead of failing when they cannot be elevated
+	Context        context.Context        // Cancelled when the run is aborted or the task times out
+	DefaultTimeout time.Duration          // Timeout for tasks without their own timeout, 0 for none
+	DefaultRetry   RetryPolicy            // Retry policy for what tasks leave out of their own
+	AssumeConflict string                 // Answer to on_conflict prompts, empty to ask
+	Prompt         PromptFunc             // Asks the user to make a choice, nil when nobody can be asked
+	Output         *TaskOutput            // Where the task records what its commands printed, set by ExecuteTask
+
+	PackageManagers config.PackageManagerSettings // Global package manager preferences from settings.package_managers
+
+	runVariables map[string]interface{} // Variables of the run when Variables has those of the task's imports merged in
+	setVariables map[string]interface{} // Variables set by the task, recorded by the privileged helper to report them back
+}
+
+// SetVariable sets a variable for the templates of the tasks that run after this one
+func (ctx *ExecutionContext) SetVariable(name string, value interface{}) {
+	if ctx.Variables != nil {
+		ctx.Variables[name] = value
+	}
+	if ctx.runVariables != nil {
+		ctx.runVariables[name] = value
+	}
+	if ctx.setVariables != nil {
+		ctx.setVariables[name] = value
+	}
+}
+
+// TaskOutput is what the commands of a task printed
+type TaskOutput struct {
+	Stdout string `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+}
+
+// Record appends the output of a command. It does nothing when ExecuteTask did not
+// ask for the output, so modules can always call it.
+func (o *TaskOutput) Record(stdout, stderr string) {
+	if o == nil {
+		return
+	}
+	o.Stdout += stdout
+	o.Stderr += stderr
+}
+
+// PromptFunc asks the user to pick one of the choices and returns it
+type PromptFunc func(question string, choices []string) (string, error)
+
+// RunContext returns the context commands of the task should run under
+func (c *ExecutionContext) RunContext() context.Context {
+	if c.Context == nil {
+		return context.Background()
+	}
+	return c.Context
 }
 
 // TaskPlan describes what a task would do
 type TaskPlan struct {
-	TaskID      string   `json:"task_id"`
-	Action      string   `json:"action"`
-	Description string   `json:"description"`
-	Changes     []string `json:"changes"`
-	WillSkip    bool     `json:"will_skip"`
-	SkipReason  string   `json:"skip_reason"`
+	TaskID      string         `json:"task_id"`
+	Action      string         `json:"action"`
+	Description string         `json:"description"`
+	Changes     []string       `json:"changes"`
+	WillSkip    bool           `json:"will_skip"`
+	SkipReason  string         `json:"skip_reason"`
+	SkipCode    SkipReasonCode `json:"skip_code,omitempty"` // Why the task is skipped, empty when it is not
+	Conflict    string         `json:"conflict,omitempty"`  // How local changes to the target are resolved, empty without local changes
+	Become      bool           `json:"become,omitempty"`    // Whether the task runs with root or Administrator rights
+	Manager     string         `json:"manager,omitempty"`   // Package manager the task runs, empty when it runs none or several
+}
+
+// SkipReasonCode classifies why a task is skipped, next to the SkipReason written for people
+type SkipReasonCode string
+
+const (
+	SkipAlreadySatisfied SkipReasonCode = "already_satisfied" // The target is already in the desired state
+	SkipConditionFalse   SkipReasonCode = "condition_false"   // The condition of the task is false
+	SkipPlatformMismatch SkipReasonCode = "platform_mismatch" // The task does not apply to this platform
+	SkipMissingSource    SkipReasonCode = "missing_source"    // The source the task reads does not exist
+	SkipOffline          SkipReasonCode = "offline"           // The task needs the network and runs offline
+	SkipNeedsElevation   SkipReasonCode = "needs_elevation"   // The task has become and the process cannot be elevated
+	SkipError            SkipReasonCode = "error"             // The task cannot run as configured
+)
+
+// SkipReasonCodes lists every skip code in the order plan output shows them
+var SkipReasonCodes = []SkipReasonCode{SkipAlreadySatisfied, SkipConditionFalse, SkipPlatformMismatch, SkipMissingSource, SkipOffline, SkipNeedsElevation, SkipError}
+
+// DriftState describes how the target of a task compares to what apply would produce
+type DriftState string
+
+const (
+	DriftInSync    DriftState = "in_sync"     // Target matches the desired state
+	DriftMissing   DriftState = "missing"     // Target does not exist
+	DriftOutOfDate DriftState = "out_of_date" // Target exists but differs
+	DriftBroken    DriftState = "broken"      // Target is a symlink to a missing file
+	DriftUnknown   DriftState = "unknown"     // Desired state cannot be determined without side effects
+)
+
+// DriftResult is the drift of a single task target
+type DriftResult struct {
+	Path  string     `json:"path"`
+	State DriftState `json:"state"`
+}
+
+// DriftChecker is implemented by modules that can detect drift of their task targets
+type DriftChecker interface {
+	// CheckDrift compares the target of a task with its desired state without changing anything
+	CheckDrift(task *config.Task, ctx *ExecutionContext) (*DriftResult, error)
+}
+
+// ErrIrreversible is returned by TaskTargets for tasks that change more than files,
+// so restoring their targets would not undo them
+var ErrIrreversible = errors.New("task cannot be rolled back by restoring files")
+
+// TargetLister is implemented by modules whose tasks change files, so apply can
+// journal the previous state of those files and roll a failed apply back
+type TargetLister interface {
+	// TaskTargets returns every path a task may create, change or remove
+	TaskTargets(task *config.Task, ctx *ExecutionContext) ([]string, error)
+}
+
+// ManagedPathLister is implemented by modules whose tasks put files or symlinks in
+// place, so paths left behind by a removed task can be cleaned up
+type ManagedPathLister interface {
+	// ManagedPaths returns the files and symlinks a task puts in place
+	ManagedPaths(task *config.Task, ctx *ExecutionContext) ([]string, error)
+}
+
+// TemplateSource is a template a task renders, or a source file it copies as-is
+type TemplateSource struct {
+	Field   string // Task field the source comes from, such as content or content_source
+	Path    string // File the source is read from, empty for inline templates
+	Content string // Template content of inline templates
+	Render  bool   // Whether the source is rendered as a template
+
+	// Directories the include, extends and import tags of a file rendered with the
+	// pongo2 engine are searched in, nil when the file is rendered on its own
+	SearchPath []string
+}
+
+// TemplateLister is implemented by modules whose tasks render templates, so they can
+// be checked without running the task
+type TemplateLister interface {
+	// TaskTemplates returns every template and source file a task reads
+	TaskTemplates(task *config.Task, ctx *ExecutionContext) ([]*TemplateSource, error)
 }
 
 // TaskResult represents the result of executing a task
@@ -81,6 +229,95 @@ type TaskResult struct {
 	Changes []string `json:"changes"`
 	Skipped bool     `json:"skipped"`
 	Message string   `json:"message"`
+
+	NeedsAttention bool `json:"needs_attention"` // Whether the user has to finish the task by hand
+
+	Output *TaskOutput `json:"output,omitempty"` // What the commands of the task printed, nil when they printed nothing
+
+	AttemptErrors []error `json:"attempt_errors,omitempty"` // Errors of the attempts that failed when the task was retried
+}
+
+// TaskOutcome is returned by ExecuteTask when a task ran without failing but did
+// not do what was planned, e.g. because the user chose to keep local changes
+type TaskOutcome struct {
+	Skipped        bool
+	NeedsAttention bool
+	Message        string
+}
+
+func (o *TaskOutcome) Error() string {
+	return o.Message
+}
+
+// PermanentError marks an error of ExecuteTask that running the task again cannot
+// fix, e.g. invalid configuration or a package that does not exist, so the task is
+// not retried
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as not worth retrying, nil stays nil
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent returns whether err or an error it wraps is a PermanentError
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// maxRetryDelay caps the wait before a retry when it doubles after every retry
+const maxRetryDelay = 10 * time.Minute
+
+// RetryPolicy is how often a failed task runs again and how long it waits in between
+type RetryPolicy struct {
+	Retries int           // Times the task runs again after failing, 0 to not retry
+	Delay   time.Duration // Wait before the first retry
+	Backoff bool          // Whether the wait doubles after every retry
+}
+
+// delay returns the wait before a retry, 1 being the first
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.Delay
+	for i := 1; p.Backoff && i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, max(p.Delay, maxRetryDelay))
+}
+
+// TaskRetryPolicy returns the retry policy of a task: its retries, retry_delay and
+// retry_backoff, with the defaults for what it leaves out
+func TaskRetryPolicy(task *config.Task, defaults RetryPolicy) (RetryPolicy, error) {
+	policy := defaults
+	if task.Retries != nil {
+		if *task.Retries < 0 {
+			return policy, fmt.Errorf("task 'retries' must be 0 or more, got %d", *task.Retries)
+		}
+		policy.Retries = *task.Retries
+	}
+	if task.RetryDelay != "" {
+		delay, err := time.ParseDuration(task.RetryDelay)
+		if err != nil || delay < 0 {
+			return policy, fmt.Errorf("task 'retry_delay' must be a duration like \"10s\", got '%s'", task.RetryDelay)
+		}
+		policy.Delay = delay
+	}
+	if task.RetryBackoff != nil {
+		policy.Backoff = *task.RetryBackoff
+	}
+	return policy, nil
 }
 
 // ModuleRegistry manages available modules
@@ -160,10 +397,86 @@ func (r *ModuleRegistry) ValidateTask(task *config.Task) error {
 	if err != nil {
 		return err
 	}
+	if _, err := ParseTaskTimeout(task); err != nil {
+		return err
+	}
+	if _, err := TaskRetryPolicy(task, RetryPolicy{}); err != nil {
+		return err
+	}
+	if err := checkModuleParameterTypes(module, task); err != nil {
+		return err
+	}
 	return module.ValidateTask(task)
 }
 
-// ExecuteTask executes a task using the appropriate module
+// checkModuleParameterTypes checks the config of a task against the documentation
+// of its action in module
+func checkModuleParameterTypes(module Module, task *config.Task) error {
+	doc, err := module.ExplainAction(task.Action)
+	if err != nil {
+		return nil
+	}
+	return CheckParameterTypes(task, doc)
+}
+
+// ParseTaskTimeout returns the timeout configured on a task, or 0 when it has none
+func ParseTaskTimeout(task *config.Task) (time.Duration, error) {
+	if task.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(task.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("task 'timeout' must be a positive duration like \"5m\", got '%s'", task.Timeout)
+	}
+	return timeout, nil
+}
+
+// withTaskTimeout returns a copy of ctx whose Context expires after the task's
+// timeout, or the default timeout when the task has none
+func withTaskTimeout(task *config.Task, ctx *ExecutionContext) (*ExecutionContext, time.Duration, context.CancelFunc, error) {
+	timeout, err := ParseTaskTimeout(task)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if timeout == 0 {
+		timeout = ctx.DefaultTimeout
+	}
+
+	taskCtx := *taskContext(task, ctx)
+	taskCtx.Context = ctx.RunContext()
+	if timeout <= 0 {
+		return &taskCtx, 0, func() {}, nil
+	}
+
+	var cancel context.CancelFunc
+	taskCtx.Context, cancel = context.WithTimeout(taskCtx.Context, timeout)
+	return &taskCtx, timeout, cancel, nil
+}
+
+// taskContext returns the context modules handle a task with, whose variables
+// include the variables of the imports the task is in
+func taskContext(task *config.Task, ctx *ExecutionContext) *ExecutionContext {
+	if len(task.Variables) == 0 {
+		return ctx
+	}
+	taskCtx := *ctx
+	taskCtx.Variables = task.ScopedVariables(ctx.Variables)
+	taskCtx.runVariables = ctx.Variables
+	return &taskCtx
+}
+
+// timeoutError makes sure errors of tasks that ran out of time say so, even when
+// the module did not report the expired context itself
+func timeoutError(taskCtx *ExecutionContext, timeout time.Duration, err error) error {
+	if !errors.Is(taskCtx.Context.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("task timed out after %s: %w: %w", timeout, context.DeadlineExceeded, err)
+}
+
+// ExecuteTask executes a task using the appropriate module. A task that fails runs
+// again as often as its retry policy allows, unless its error is a PermanentError
+// or the run was aborted. Planning is never retried.
 func (r *ModuleRegistry) ExecuteTask(task *config.Task, ctx *ExecutionContext) (*TaskResult, error) {
 	module, err := r.GetModuleByAction(task.Action)
 	if err != nil {
@@ -174,7 +487,7 @@ func (r *ModuleRegistry) ExecuteTask(task *config.Task, ctx *ExecutionContext) (
 		}, err
 	}
 
-	err = module.ExecuteTask(task, ctx)
+	policy, err := TaskRetryPolicy(task, ctx.DefaultRetry)
 	if err != nil {
 		return &TaskResult{
 			TaskID:  task.ID,
@@ -183,19 +496,250 @@ func (r *ModuleRegistry) ExecuteTask(task *config.Task, ctx *ExecutionContext) (
 		}, err
 	}
 
+	// A list where a string belongs fails before the module runs
+	if err := checkModuleParameterTypes(module, task); err != nil {
+		err = Permanent(err)
+		return &TaskResult{
+			TaskID:  task.ID,
+			Success: false,
+			Error:   err,
+		}, err
+	}
+
+	log := logger.Get()
+	attempts := policy.Retries + 1
+	var attemptErrors []error
+	for attempt := 1; ; attempt++ {
+		result, err := executeAttempt(module, task, ctx)
+		if err == nil {
+			if len(attemptErrors) > 0 {
+				log.Info().Str("task", task.ID).Msgf("Task succeeded on attempt %d/%d", attempt, attempts)
+				result.AttemptErrors = attemptErrors
+			}
+			return result, nil
+		}
+		if policy.Retries == 0 {
+			return result, err
+		}
+
+		attemptErrors = append(attemptErrors, err)
+		if attempt == attempts || IsPermanent(err) || ctx.RunContext().Err() != nil {
+			if attempt > 1 {
+				err = fmt.Errorf("failed after %d attempts: %w", attempt, err)
+				result.Error = err
+			}
+			result.AttemptErrors = attemptErrors
+			return result, err
+		}
+
+		delay := policy.delay(attempt)
+		log.Warn().Err(err).Str("task", task.ID).Msgf("Attempt %d/%d failed, starting attempt %d/%d in %s", attempt, attempts, attempt+1, attempts, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.RunContext().Done():
+			result.AttemptErrors = attemptErrors
+			return result, err
+		}
+	}
+}
+
+// executeAttempt runs a task once, with its own timeout
+func executeAttempt(module Module, task *config.Task, ctx *ExecutionContext) (*TaskResult, error) {
+	taskCtx, timeout, cancel, err := withTaskTimeout(task, ctx)
+	if err != nil {
+		err = Permanent(err)
+		return &TaskResult{
+			TaskID:  task.ID,
+			Success: false,
+			Error:   err,
+		}, err
+	}
+	defer cancel()
+
+	taskCtx.Output = &TaskOutput{}
+	switch {
+	case taskCtx.DryRun || !task.Become || taskCtx.Elevated:
+		err = executeModule(module, task, taskCtx)
+	case goos == "windows":
+		err = elevationUnavailable(task, taskCtx)
+	default:
+		err = executeBecome(task, taskCtx)
+	}
+	var output *TaskOutput
+	if *taskCtx.Output != (TaskOutput{}) {
+		output = taskCtx.Output
+	}
+
+	var outcome *TaskOutcome
+	if errors.As(err, &outcome) {
+		return &TaskResult{
+			TaskID:         task.ID,
+			Success:        true,
+			Skipped:        outcome.Skipped,
+			NeedsAttention: outcome.NeedsAttention,
+			Message:        outcome.Message,
+			Output:         output,
+		}, nil
+	}
+	if err != nil {
+		err = timeoutError(taskCtx, timeout, err)
+		return &TaskResult{
+			TaskID:  task.ID,
+			Success: false,
+			Error:   err,
+			Output:  output,
+		}, err
+	}
+
 	return &TaskResult{
 		TaskID:  task.ID,
 		Success: true,
+		Output:  output,
 	}, nil
 }
 
+// executeModule executes a task with its module
+func executeModule(module Module, task *config.Task, ctx *ExecutionContext) (err error) {
+	defer recoverTaskPanic(module, task, &err)
+	return module.ExecuteTask(task, ctx)
+}
+
+// planModule plans a task with its module
+func planModule(module Module, task *config.Task, ctx *ExecutionContext) (plan *TaskPlan, err error) {
+	defer recoverTaskPanic(module, task, &err)
+	return module.PlanTask(task, ctx)
+}
+
+// recoverTaskPanic turns a panic of a module handling a task into an error, so a
+// bug fails that task instead of ending the run with a Go stack trace
+func recoverTaskPanic(module Module, task *config.Task, err *error) {
+	if recovered := recover(); recovered != nil {
+		logger.Get().Debug().Str("task", task.ID).Str("stack", string(debug.Stack())).Msg("Module panicked")
+		*err = Permanent(fmt.Errorf("%s module failed on task '%s' (%s): %v", module.Name(), task.ID, task.Location(), recovered))
+	}
+}
+
 // PlanTask creates an execution plan for a task using the appropriate module
 func (r *ModuleRegistry) PlanTask(task *config.Task, ctx *ExecutionContext) (*TaskPlan, error) {
 	module, err := r.GetModuleByAction(task.Action)
 	if err != nil {
 		return nil, err
 	}
-	return module.PlanTask(task, ctx)
+	if err := checkModuleParameterTypes(module, task); err != nil {
+		return nil, Permanent(err)
+	}
+
+	taskCtx, timeout, cancel, err := withTaskTimeout(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// Windows cannot elevate a single task, so a task that needs it fails before
+	// anything runs
+	if task.Become && !ctx.Elevated && goos == "windows" {
+		if !ctx.SkipElevated {
+			return nil, Permanent(ErrRequiresElevation)
+		}
+		return &TaskPlan{
+			TaskID:     task.ID,
+			Action:     task.Action,
+			WillSkip:   true,
+			SkipReason: "requires elevation, skipped with --skip-elevated",
+			SkipCode:   SkipNeedsElevation,
+			Become:     true,
+		}, nil
+	}
+
+	plan, err := planModule(module, task, taskCtx)
+	if err != nil {
+		return plan, timeoutError(taskCtx, timeout, err)
+	}
+	if plan == nil {
+		return nil, nil
+	}
+	plan.Become = task.Become
+
+	// Never show secret values in plan or diff output
+	plan.Description = templating.RedactSecrets(plan.Description)
+	plan.SkipReason = templating.RedactSecrets(plan.SkipReason)
+	if plan.WillSkip && plan.SkipCode == "" {
+		// Modules only classify skips that are not about the target being up to date
+		plan.SkipCode = SkipAlreadySatisfied
+	}
+	for i, change := range plan.Changes {
+		plan.Changes[i] = templating.RedactSecrets(change)
+	}
+
+	return plan, nil
+}
+
+// CheckDrift compares the target of a task with its desired state. ok is false
+// when the module handling the task cannot detect drift.
+func (r *ModuleRegistry) CheckDrift(task *config.Task, ctx *ExecutionContext) (result *DriftResult, ok bool, err error) {
+	module, err := r.GetModuleByAction(task.Action)
+	if err != nil {
+		return nil, false, err
+	}
+
+	checker, ok := module.(DriftChecker)
+	if !ok {
+		return nil, false, nil
+	}
+
+	result, err = checker.CheckDrift(task, taskContext(task, ctx))
+	return result, true, err
+}
+
+// TaskTargets returns the paths a task may change. ok is false when the changes
+// of the task cannot be rolled back by restoring those paths.
+func (r *ModuleRegistry) TaskTargets(task *config.Task, ctx *ExecutionContext) (targets []string, ok bool, err error) {
+	module, err := r.GetModuleByAction(task.Action)
+	if err != nil {
+		return nil, false, err
+	}
+
+	lister, ok := module.(TargetLister)
+	if !ok {
+		return nil, false, nil
+	}
+
+	targets, err = lister.TaskTargets(task, taskContext(task, ctx))
+	if errors.Is(err, ErrIrreversible) {
+		return nil, false, nil
+	}
+	return targets, err == nil, err
+}
+
+// ManagedPaths returns the files and symlinks a task puts in place. It returns nil
+// when the module handling the task does not own any paths.
+func (r *ModuleRegistry) ManagedPaths(task *config.Task, ctx *ExecutionContext) ([]string, error) {
+	module, err := r.GetModuleByAction(task.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := module.(ManagedPathLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.ManagedPaths(task, taskContext(task, ctx))
+}
+
+// TaskTemplates returns the templates and source files a task reads. It returns nil
+// when the module handling the task does not render templates.
+func (r *ModuleRegistry) TaskTemplates(task *config.Task, ctx *ExecutionContext) ([]*TemplateSource, error) {
+	module, err := r.GetModuleByAction(task.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := module.(TemplateLister)
+	if !ok {
+		return nil, nil
+	}
+
+	return lister.TaskTemplates(task, taskContext(task, ctx))
 }
 
 // ExplainAction returns documentation for a specific action
@@ -0,0 +1,178 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// flakyModule fails the first failures times it executes a task
+type flakyModule struct {
+	failures int
+	err      error
+	runs     int
+}
+
+func (m *flakyModule) Name() string                                       { return "flaky" }
+func (m *flakyModule) ActionKeys() []string                               { return []string{"flaky"} }
+func (m *flakyModule) ValidateTask(task *config.Task) error               { return nil }
+func (m *flakyModule) ListActions() []*ActionDocumentation                { return nil }
+func (m *flakyModule) ExplainAction(string) (*ActionDocumentation, error) { return nil, nil }
+
+func (m *flakyModule) PlanTask(task *config.Task, ctx *ExecutionContext) (*TaskPlan, error) {
+	return &TaskPlan{TaskID: task.ID}, nil
+}
+
+func (m *flakyModule) ExecuteTask(task *config.Task, ctx *ExecutionContext) error {
+	m.runs++
+	if m.runs <= m.failures {
+		return m.err
+	}
+	return nil
+}
+
+func TestExecuteTaskRetries(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	tests := []struct {
+		name     string
+		module   *flakyModule
+		retries  *int
+		defaults RetryPolicy
+		wantRuns int
+		wantErr  string
+	}{
+		{name: "no retries", module: &flakyModule{failures: 1, err: errors.New("mirror sync in progress")}, wantRuns: 1, wantErr: "mirror sync in progress"},
+		{name: "succeeds on a retry", module: &flakyModule{failures: 2, err: errors.New("mirror sync in progress")}, retries: intPtr(2), wantRuns: 3},
+		{name: "runs out of retries", module: &flakyModule{failures: 5, err: errors.New("mirror sync in progress")}, retries: intPtr(2), wantRuns: 3, wantErr: "failed after 3 attempts: mirror sync in progress"},
+		{name: "default retries", module: &flakyModule{failures: 1, err: errors.New("mirror sync in progress")}, defaults: RetryPolicy{Retries: 1}, wantRuns: 2},
+		{name: "task disables retries", module: &flakyModule{failures: 1, err: errors.New("mirror sync in progress")}, retries: intPtr(0), defaults: RetryPolicy{Retries: 3}, wantRuns: 1, wantErr: "mirror sync in progress"},
+		{name: "permanent error", module: &flakyModule{failures: 5, err: Permanent(errors.New("package not found"))}, retries: intPtr(3), wantRuns: 1, wantErr: "package not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewModuleRegistry()
+			if err := registry.Register(tt.module); err != nil {
+				t.Fatal(err)
+			}
+			task := &config.Task{ID: "flaky", Action: "flaky", Retries: tt.retries}
+			ctx := &ExecutionContext{Context: context.Background(), DefaultRetry: tt.defaults}
+
+			result, err := registry.ExecuteTask(task, ctx)
+			if tt.module.runs != tt.wantRuns {
+				t.Errorf("ExecuteTask() ran the task %d times, want %d", tt.module.runs, tt.wantRuns)
+			}
+			if tt.wantErr == "" {
+				if err != nil || !result.Success {
+					t.Errorf("ExecuteTask() = %+v, %v, want success", result, err)
+				}
+			} else if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ExecuteTask() error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.wantRuns > 1 && len(result.AttemptErrors) != min(tt.wantRuns, tt.module.failures) {
+				t.Errorf("ExecuteTask() recorded %d failed attempts, want %d", len(result.AttemptErrors), min(tt.wantRuns, tt.module.failures))
+			}
+		})
+	}
+}
+
+func TestExecuteTaskRetryAborted(t *testing.T) {
+	registry := NewModuleRegistry()
+	module := &flakyModule{failures: 5, err: errors.New("connection reset")}
+	if err := registry.Register(module); err != nil {
+		t.Fatal(err)
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	ctx := &ExecutionContext{Context: runCtx, DefaultRetry: RetryPolicy{Retries: 3, Delay: time.Hour}}
+
+	_, err := registry.ExecuteTask(&config.Task{ID: "flaky", Action: "flaky"}, ctx)
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("ExecuteTask() error = %v, want the error of the first attempt", err)
+	}
+	if module.runs != 1 {
+		t.Errorf("ExecuteTask() ran the task %d times after the run was aborted, want 1", module.runs)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Retries: 5, Delay: 5 * time.Second}
+	if got := policy.delay(3); got != 5*time.Second {
+		t.Errorf("delay(3) = %s without backoff, want 5s", got)
+	}
+
+	policy.Backoff = true
+	for retry, want := range map[int]time.Duration{1: 5 * time.Second, 2: 10 * time.Second, 3: 20 * time.Second, 20: maxRetryDelay} {
+		if got := policy.delay(retry); got != want {
+			t.Errorf("delay(%d) = %s with backoff, want %s", retry, got, want)
+		}
+	}
+}
+
+func TestTaskRetryPolicy(t *testing.T) {
+	retries, backoff := 2, true
+	defaults := RetryPolicy{Retries: 1, Delay: 5 * time.Second}
+
+	policy, err := TaskRetryPolicy(&config.Task{Retries: &retries, RetryDelay: "1m", RetryBackoff: &backoff}, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RetryPolicy{Retries: 2, Delay: time.Minute, Backoff: true}); policy != want {
+		t.Errorf("TaskRetryPolicy() = %+v, want %+v", policy, want)
+	}
+
+	if policy, _ := TaskRetryPolicy(&config.Task{}, defaults); policy != defaults {
+		t.Errorf("TaskRetryPolicy() = %+v, want the defaults %+v", policy, defaults)
+	}
+
+	if _, err := TaskRetryPolicy(&config.Task{RetryDelay: "later"}, defaults); err == nil {
+		t.Error("TaskRetryPolicy() should reject an invalid retry_delay")
+	}
+	negative := -1
+	if _, err := TaskRetryPolicy(&config.Task{Retries: &negative}, defaults); err == nil {
+		t.Error("TaskRetryPolicy() should reject negative retries")
+	}
+}
+
+// plannedModule returns a copy of plan for every task
+type plannedModule struct {
+	flakyModule
+	plan TaskPlan
+}
+
+func (m *plannedModule) PlanTask(task *config.Task, ctx *ExecutionContext) (*TaskPlan, error) {
+	plan := m.plan
+	return &plan, nil
+}
+
+func TestPlanTaskSkipCode(t *testing.T) {
+	tests := []struct {
+		name string
+		plan TaskPlan
+		want SkipReasonCode
+	}{
+		{name: "not skipped", plan: TaskPlan{Changes: []string{"Create ~/.gitconfig"}}},
+		{name: "unclassified skip", plan: TaskPlan{WillSkip: true, SkipReason: "File exists with correct content"}, want: SkipAlreadySatisfied},
+		{name: "classified skip", plan: TaskPlan{WillSkip: true, SkipReason: "Source file does not exist", SkipCode: SkipMissingSource}, want: SkipMissingSource},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewModuleRegistry()
+			if err := registry.Register(&plannedModule{plan: tt.plan}); err != nil {
+				t.Fatal(err)
+			}
+			plan, err := registry.PlanTask(&config.Task{ID: "planned", Action: "flaky"}, &ExecutionContext{Context: context.Background()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.SkipCode != tt.want {
+				t.Errorf("PlanTask() skip code = %q, want %q", plan.SkipCode, tt.want)
+			}
+		})
+	}
+}
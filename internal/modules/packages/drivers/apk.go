@@ -2,11 +2,14 @@ package drivers
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"runtime"
 	"strings"
 )
 
+// apkRepositoriesFile is where APK keeps its repositories, one URL per line
+var apkRepositoriesFile = "/etc/apk/repositories"
+
 // ApkDriver implements PackageDriver for APK package manager (Alpine Linux)
 type ApkDriver struct {
 	*BaseDriver
@@ -26,43 +29,26 @@ func (d *ApkDriver) IsPackageInstalled(packageName string) (bool, error) {
 
 // fetchAllInstalledPackages fetches all installed packages from APK
 func (d *ApkDriver) fetchAllInstalledPackages() (map[string]bool, error) {
-	output, err := d.RunCommand("list", "--installed")
+	output, err := d.RunCommand("info")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list installed packages: %w", err)
 	}
+	return parseApkInfoNames(output), nil
+}
 
+// parseApkInfoNames parses the output of `apk info`, which lists the names of the
+// installed packages one per line
+func parseApkInfoNames(output string) map[string]bool {
 	packages := make(map[string]bool)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+	for _, line := range strings.Split(output, "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || strings.HasPrefix(name, "WARNING:") || strings.ContainsAny(name, " :") {
 			continue
 		}
-
-		// Skip warning lines
-		if strings.HasPrefix(line, "WARNING:") || strings.HasPrefix(line, "fetch") {
-			continue
-		}
-
-		// APK output format: "package-name-version arch {repo} (description)"
-		// We need to extract just the package name part
-		if strings.Contains(line, " ") {
-			parts := strings.Fields(line)
-			if len(parts) >= 1 {
-				packageInfo := parts[0]
-				// Extract package name by removing version and architecture
-				// Format is typically: package-name-version-r0
-				packageName := d.extractPackageName(packageInfo)
-				if packageName != "" {
-					packages[packageName] = true
-					packages[strings.ToLower(packageName)] = true
-				}
-			}
-		}
+		packages[name] = true
+		packages[strings.ToLower(name)] = true
 	}
-
-	return packages, nil
+	return packages
 }
 
 // extractPackageName extracts the package name from APK's package info string
@@ -90,25 +76,34 @@ func (d *ApkDriver) extractPackageName(packageInfo string) string {
 	return packageInfo
 }
 
-// InstallPackage installs a package using APK
+// InstallPackage installs a package using APK. --no-cache fetches a fresh index
+// without keeping it, so no `apk update` is needed first.
 func (d *ApkDriver) InstallPackage(packageName string) error {
-	// Update package index first
-	_, updateErr := d.RunCommandWithSudo("update")
-	if updateErr != nil {
-		// Log warning but continue - update might fail due to permissions
-		// but installation might still work
+	output, err := d.RunPrivileged("add", "--no-cache", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via APK: %w\nOutput: %s", packageName, err, output)
 	}
+	return nil
+}
 
-	output, err := d.RunCommandWithSudo("add", packageName)
+// InstallPackageVersion installs a specific package version using APK (pkg=version)
+func (d *ApkDriver) InstallPackageVersion(packageName, version string) error {
+	target := fmt.Sprintf("%s=%s", packageName, version)
+	output, err := d.RunPrivileged("add", "--no-cache", target)
 	if err != nil {
-		return fmt.Errorf("failed to install package %s via APK: %w\nOutput: %s", packageName, err, output)
+		return fmt.Errorf("failed to install package %s via APK: %w\nOutput: %s", target, err, output)
 	}
 	return nil
 }
 
+// SupportsVersionPinning reports that APK can install specific versions
+func (d *ApkDriver) SupportsVersionPinning() bool {
+	return true
+}
+
 // UninstallPackage uninstalls a package using APK
 func (d *ApkDriver) UninstallPackage(packageName string) error {
-	output, err := d.RunCommandWithSudo("del", packageName)
+	output, err := d.RunPrivileged("del", packageName)
 	if err != nil {
 		return fmt.Errorf("failed to uninstall package %s via APK: %w\nOutput: %s", packageName, err, output)
 	}
@@ -121,80 +116,86 @@ func (d *ApkDriver) SearchPackage(packageName string) ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to search for package %s: %w", packageName, err)
 	}
+	return d.parseApkSearch(output), nil
+}
 
+// parseApkSearch parses the package names from the output of `apk search`, which
+// prints one "name-version-release" per line, followed by a description with -v
+func (d *ApkDriver) parseApkSearch(output string) []string {
 	var packages []string
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Skip warning and informational lines
-		if strings.HasPrefix(line, "WARNING:") || strings.HasPrefix(line, "fetch") {
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "WARNING:") || strings.HasPrefix(fields[0], "fetch") {
 			continue
 		}
-
-		// APK search output format: "package-name-version description"
-		if strings.Contains(line, " ") {
-			parts := strings.Fields(line)
-			if len(parts) >= 1 {
-				packageInfo := parts[0]
-				packageName := d.extractPackageName(packageInfo)
-				if packageName != "" {
-					packages = append(packages, packageName)
-				}
-			}
+		name := d.extractPackageName(fields[0])
+		if name != "" && !seen[name] {
+			seen[name] = true
+			packages = append(packages, name)
 		}
 	}
-
-	return packages, nil
+	return packages
 }
 
 // GetPackageInfo gets information about an installed package
 func (d *ApkDriver) GetPackageInfo(packageName string) (map[string]string, error) {
-	output, err := d.RunCommand("info", packageName)
+	output, err := d.RunCommand("info", "-a", packageName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
 	}
 
-	info := make(map[string]string)
-	lines := strings.Split(output, "\n")
+	info := d.parseApkInfo(output)
+	if len(info) == 0 {
+		return nil, fmt.Errorf("package %s not found", packageName)
+	}
 
-	for _, line := range lines {
+	info["manager"] = "apk"
+	return info, nil
+}
+
+// parseApkInfo parses the output of `apk info -a <pkg>`, stanzas headed by
+// "<name>-<version> <field>:" and separated by blank lines, into the name, version,
+// description, webpage, size and license of the package
+func (d *ApkDriver) parseApkInfo(output string) map[string]string {
+	info := make(map[string]string)
+	field := ""
+	for _, line := range strings.Split(output, "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" {
+			field = ""
 			continue
 		}
 
-		// Parse APK info output
-		if strings.Contains(line, ":") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-
-				switch strings.ToLower(key) {
-				case "package":
-					info["name"] = value
-				case "version":
-					info["version"] = value
-				case "architecture":
-					info["architecture"] = value
-				case "description":
-					info["description"] = value
+		if field == "" {
+			nameVersion, header, found := strings.Cut(line, " ")
+			if !found || !strings.HasSuffix(header, ":") {
+				continue
+			}
+			if _, exists := info["name"]; !exists {
+				name := d.extractPackageName(nameVersion)
+				info["name"] = name
+				if version := strings.TrimPrefix(nameVersion, name+"-"); version != nameVersion {
+					info["version"] = version
 				}
 			}
+			field = strings.TrimSuffix(header, ":")
+			continue
 		}
-	}
 
-	if len(info) == 0 {
-		return nil, fmt.Errorf("package %s not found", packageName)
+		// Only the first line of a stanza is kept, e.g. not every dependency
+		switch field {
+		case "description", "webpage", "license":
+			if _, exists := info[field]; !exists {
+				info[field] = line
+			}
+		case "installed size":
+			if _, exists := info["size"]; !exists {
+				info["size"] = line
+			}
+		}
 	}
-
-	info["manager"] = "apk"
-	return info, nil
+	return info
 }
 
 // GetAllInstalledPackages returns a map of all installed packages
@@ -202,30 +203,91 @@ func (d *ApkDriver) GetAllInstalledPackages() (map[string]bool, error) {
 	return d.fetchAllInstalledPackages()
 }
 
-// RunCommandWithSudo executes an APK command with sudo privileges
-func (d *ApkDriver) RunCommandWithSudo(args ...string) (string, error) {
-	// Check if we're already running as root
-	if d.isRunningAsRoot() {
-		return d.RunCommand(args...)
+// apkRepositoryLine returns the line an add_repo value adds to the repositories
+// file: a repository URL, or "<tag> <url>" for a tagged repository (written as
+// "@tag url", install its packages as "name@tag")
+func apkRepositoryLine(repoName string) (string, error) {
+	fields := strings.Fields(repoName)
+	switch len(fields) {
+	case 1:
+		return fields[0], nil
+	case 2:
+		return "@" + strings.TrimPrefix(fields[0], "@") + " " + fields[1], nil
+	default:
+		return "", fmt.Errorf("repository must be \"<url>\" or \"<tag> <url>\", got %q", repoName)
 	}
+}
 
-	// Prepend sudo to the command
-	sudoArgs := append([]string{d.executable}, args...)
-	cmd := exec.Command("sudo", sudoArgs...)
-	output, err := cmd.CombinedOutput()
-	return strings.TrimSpace(string(output)), err
+// parseApkRepositories returns the repositories in the content of the repositories
+// file, ignoring comments and whitespace differences
+func parseApkRepositories(content string) map[string]bool {
+	repos := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repos[strings.Join(strings.Fields(line), " ")] = true
+	}
+	return repos
 }
 
-// isRunningAsRoot checks if the current process is running as root
-func (d *ApkDriver) isRunningAsRoot() bool {
-	output, err := exec.Command("id", "-u").Output()
+// IsRepositoryAvailable checks if a repository is listed in /etc/apk/repositories
+func (d *ApkDriver) IsRepositoryAvailable(repoName string) (bool, error) {
+	line, err := apkRepositoryLine(repoName)
 	if err != nil {
-		return false
+		return false, err
+	}
+	content, err := os.ReadFile(apkRepositoriesFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", apkRepositoriesFile, err)
 	}
-	return strings.TrimSpace(string(output)) == "0"
+	return parseApkRepositories(string(content))[line], nil
 }
 
-// IsAvailable overrides the base implementation to check platform compatibility and sudo
+// EnsureRepository appends a repository to /etc/apk/repositories unless it is
+// listed already and updates the package index
+func (d *ApkDriver) EnsureRepository(repoName string) error {
+	line, err := apkRepositoryLine(repoName)
+	if err != nil {
+		return err
+	}
+	available, err := d.IsRepositoryAvailable(repoName)
+	if err != nil {
+		return fmt.Errorf("failed to check repository availability: %w", err)
+	}
+	if available {
+		return nil
+	}
+
+	// Start on a new line when the file does not end with one
+	text := line + "\n"
+	if content, err := os.ReadFile(apkRepositoriesFile); err == nil && len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		text = "\n" + text
+	}
+	output, err := d.RunPrivilegedCommand("sh", "-c", `printf '%s' "$1" >> "$2"`, "sh", text, apkRepositoriesFile)
+	if err != nil {
+		return fmt.Errorf("failed to add repository %s: %w\nOutput: %s", line, err, output)
+	}
+
+	if d.Offline() {
+		return nil
+	}
+	if output, err := d.RunPrivileged("update"); err != nil {
+		return fmt.Errorf("repository %s added but failed to update package index: %w\nOutput: %s", line, err, output)
+	}
+	return nil
+}
+
+// NeedsPrivilege reports that APK installs and removes packages as root
+func (d *ApkDriver) NeedsPrivilege() bool {
+	return true
+}
+
+// IsAvailable overrides the base implementation to check platform compatibility and privileges
 func (d *ApkDriver) IsAvailable() bool {
 	// APK is only available on Linux (specifically Alpine Linux)
 	if runtime.GOOS != "linux" {
@@ -237,12 +299,6 @@ func (d *ApkDriver) IsAvailable() bool {
 		return false
 	}
 
-	// Check if we can run apk commands (either as root or with sudo)
-	if d.isRunningAsRoot() {
-		return true
-	}
-
-	// Check if sudo is available (needed for install/remove operations)
-	_, err := exec.LookPath("sudo")
-	return err == nil
+	// Install/remove operations need root or a way to become root
+	return d.Privilege().Available()
 }
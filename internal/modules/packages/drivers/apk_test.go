@@ -1,6 +1,8 @@
 package drivers
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -73,57 +75,139 @@ func TestApkDriver_Name(t *testing.T) {
 }
 
 func TestApkDriver_ParseInstalledPackages(t *testing.T) {
-	driver := NewApkDriver()
+	// Captured from `apk info` on Alpine 3.19
+	output := `WARNING: opening /var/cache/apk: No such file or directory
+alpine-baselayout
+alpine-baselayout-data
+busybox
+ca-certificates-bundle
+git
+py3-pip
+Build-Base
+`
+	packages := parseApkInfoNames(output)
 
-	packages := make(map[string]bool)
-	lines := []string{
-		"curl-7.88.1-r1 x86_64 {curl} (curl and libcurl)",
-		"git-2.39.0-r1 x86_64 {git} (Fast, scalable, distributed revision control system)",
-		"nodejs-18.14.2-r0 x86_64 {nodejs} (JavaScript runtime built on V8 engine)",
-		"py3-pip-22.3.1-r1 x86_64 {py3-pip} (Tool for installing Python packages)",
-		"build-base-0.5-r3 x86_64 {build-base} (Meta package for build base)",
-		"alpine-base-3.17.0-r0 x86_64 {alpine-base} (Meta package for minimal alpine base)",
-	}
-
-	// Simulate the parsing logic
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	for _, expected := range []string{"alpine-baselayout", "alpine-baselayout-data", "busybox", "git", "py3-pip", "Build-Base", "build-base"} {
+		if !packages[expected] {
+			t.Errorf("Expected package %q not found in parsed packages", expected)
 		}
+	}
+	if len(packages) != 8 {
+		t.Errorf("Expected 8 entries, got %d: %v", len(packages), packages)
+	}
+}
 
-		if strings.Contains(line, " ") {
-			parts := strings.Fields(line)
-			if len(parts) >= 1 {
-				packageInfo := parts[0]
-				packageName := driver.extractPackageName(packageInfo)
-				if packageName != "" {
-					packages[packageName] = true
-					packages[strings.ToLower(packageName)] = true
-				}
-			}
+func TestApkDriver_ParseSearch(t *testing.T) {
+	driver := NewApkDriver()
+
+	// Captured from `apk search git` and `apk search -v python3`
+	output := `git-2.43.0-r0
+git-doc-2.43.0-r0
+git-lfs-3.4.0-r4
+libgit2-1.7.1-r0
+python3-3.11.8-r0 - A high-level scripting language
+python3-3.11.6-r1 - A high-level scripting language
+`
+	expected := []string{"git", "git-doc", "git-lfs", "libgit2", "python3"}
+	result := driver.parseApkSearch(output)
+	if strings.Join(result, ",") != strings.Join(expected, ",") {
+		t.Errorf("parseApkSearch() = %v, want %v", result, expected)
+	}
+}
+
+func TestApkDriver_ParseInfo(t *testing.T) {
+	driver := NewApkDriver()
+
+	// Captured from `apk info -a git`
+	output := `git-2.43.0-r0 description:
+Distributed version control system
+
+git-2.43.0-r0 webpage:
+https://www.git-scm.com/
+
+git-2.43.0-r0 installed size:
+13 MiB
+
+git-2.43.0-r0 depends on:
+ca-certificates-bundle
+so:libc.musl-x86_64.so.1
+so:libcurl.so.4
+
+git-2.43.0-r0 provides:
+cmd:git=2.43.0-r0
+
+git-2.43.0-r0 license:
+GPL-2.0-or-later
+
+`
+	info := driver.parseApkInfo(output)
+	expected := map[string]string{
+		"name":        "git",
+		"version":     "2.43.0-r0",
+		"description": "Distributed version control system",
+		"webpage":     "https://www.git-scm.com/",
+		"size":        "13 MiB",
+		"license":     "GPL-2.0-or-later",
+	}
+	for key, want := range expected {
+		if info[key] != want {
+			t.Errorf("info[%q] = %q, want %q", key, info[key], want)
 		}
 	}
+	if len(info) != len(expected) {
+		t.Errorf("Unexpected fields in %v", info)
+	}
 
-	expectedPackages := []string{
-		"curl", "git", "nodejs", "py3-pip", "build-base", "alpine-base",
+	if info := driver.parseApkInfo(""); len(info) != 0 {
+		t.Errorf("Expected no info for empty output, got %v", info)
 	}
+}
 
-	for _, expected := range expectedPackages {
-		if !packages[expected] {
-			t.Errorf("Expected package %q not found in parsed packages", expected)
-		}
-		if !packages[strings.ToLower(expected)] {
-			t.Errorf("Expected lowercase package %q not found in parsed packages", strings.ToLower(expected))
+func TestApkRepositories(t *testing.T) {
+	lines := map[string]string{
+		"https://dl-cdn.alpinelinux.org/alpine/edge/community":          "https://dl-cdn.alpinelinux.org/alpine/edge/community",
+		"testing https://dl-cdn.alpinelinux.org/alpine/edge/testing":    "@testing https://dl-cdn.alpinelinux.org/alpine/edge/testing",
+		"@testing   https://dl-cdn.alpinelinux.org/alpine/edge/testing": "@testing https://dl-cdn.alpinelinux.org/alpine/edge/testing",
+	}
+	for repo, want := range lines {
+		line, err := apkRepositoryLine(repo)
+		if err != nil || line != want {
+			t.Errorf("apkRepositoryLine(%q) = %q, %v, want %q", repo, line, err, want)
 		}
 	}
+	if _, err := apkRepositoryLine("a b c"); err == nil {
+		t.Error("Expected an error for a repository with three fields")
+	}
+
+	file := filepath.Join(t.TempDir(), "repositories")
+	original := apkRepositoriesFile
+	apkRepositoriesFile = file
+	defer func() { apkRepositoriesFile = original }()
+
+	driver := NewApkDriver()
+	if available, err := driver.IsRepositoryAvailable("https://dl-cdn.alpinelinux.org/alpine/v3.19/main"); err != nil || available {
+		t.Errorf("Expected no repositories without the file, got %v, %v", available, err)
+	}
 
-	// Verify we don't have false positives
-	if packages["7.88.1"] { // version number shouldn't be a package
-		t.Error("Version number incorrectly identified as package name")
+	content := `#/media/cdrom/apks
+https://dl-cdn.alpinelinux.org/alpine/v3.19/main
+#https://dl-cdn.alpinelinux.org/alpine/v3.19/community
+@testing  https://dl-cdn.alpinelinux.org/alpine/edge/testing
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
 	}
-	if packages["x86_64"] { // architecture shouldn't be a package
-		t.Error("Architecture incorrectly identified as package name")
+	tests := map[string]bool{
+		"https://dl-cdn.alpinelinux.org/alpine/v3.19/main":           true,
+		"https://dl-cdn.alpinelinux.org/alpine/v3.19/community":      false,
+		"testing https://dl-cdn.alpinelinux.org/alpine/edge/testing": true,
+		"https://dl-cdn.alpinelinux.org/alpine/edge/testing":         false,
+	}
+	for repo, want := range tests {
+		available, err := driver.IsRepositoryAvailable(repo)
+		if err != nil || available != want {
+			t.Errorf("IsRepositoryAvailable(%q) = %v, %v, want %v", repo, available, err, want)
+		}
 	}
 }
 
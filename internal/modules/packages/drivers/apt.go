@@ -3,13 +3,17 @@ package drivers
 import (
 	"fmt"
 	"os/exec"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 // AptDriver implements PackageDriver for APT package manager (Debian/Ubuntu)
 type AptDriver struct {
 	*BaseDriver
+	refreshMutex sync.Mutex
+	skipRefresh  bool // Set while InstallPackages installs with the lists it updated
 }
 
 // NewAptDriver creates a new APT driver
@@ -97,23 +101,100 @@ func (d *AptDriver) fetchAllInstalledPackagesDpkg() (map[string]bool, error) {
 
 // InstallPackage installs a package using APT
 func (d *AptDriver) InstallPackage(packageName string) error {
-	// Update package list first (only if not updated recently)
-	_, updateErr := d.RunCommandWithSudo("update")
-	if updateErr != nil {
-		// Log warning but continue - update might fail due to permissions
-		// but installation might still work
-	}
+	d.refresh()
 
-	output, err := d.RunCommandWithSudo("install", "-y", packageName)
+	output, err := d.RunPrivileged(append(d.installArgs(), packageName)...)
 	if err != nil {
 		return fmt.Errorf("failed to install package %s via APT: %w\nOutput: %s", packageName, err, output)
 	}
 	return nil
 }
 
+// aptNotFound matches the lines APT blames a package it cannot install with
+var aptNotFound = regexp.MustCompile(`^E: (?:Unable to locate package (\S+)|Package '([^']+)' has no installation candidate|Couldn't find any package by (?:glob|regex) '([^']+)')`)
+
+// InstallPackages installs packages with a single apt install. APT installs
+// nothing when one of them is unknown.
+func (d *AptDriver) InstallPackages(packageNames []string) error {
+	d.refresh()
+
+	output, err := d.RunPrivileged(append(d.installArgs(), packageNames...)...)
+	if err != nil {
+		return batchError("APT", packageNames, output, aptNotFound, err)
+	}
+	return nil
+}
+
+// InstallPackageVersion installs a specific package version using APT (pkg=version)
+func (d *AptDriver) InstallPackageVersion(packageName, version string) error {
+	d.refresh()
+
+	target := fmt.Sprintf("%s=%s", packageName, version)
+	output, err := d.RunPrivileged(append(d.installArgs(), "--allow-downgrades", target)...)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via APT: %w\nOutput: %s", target, err, output)
+	}
+	return nil
+}
+
+// refresh updates the package lists before installing, unless InstallPackages
+// updated them for the packages being installed
+func (d *AptDriver) refresh() {
+	d.refreshMutex.Lock()
+	skip := d.skipRefresh
+	d.refreshMutex.Unlock()
+	if !skip {
+		d.RefreshPackageLists()
+	}
+}
+
+// RefreshPackageLists updates the package lists, unless offline. Failing to update
+// them does not stop the install, the lists may still be recent enough.
+func (d *AptDriver) RefreshPackageLists() {
+	if d.Offline() {
+		return
+	}
+	_, _ = d.RunPrivileged("update")
+}
+
+// SetRefresh sets whether installing packages runs apt update first
+func (d *AptDriver) SetRefresh(refresh bool) {
+	d.refreshMutex.Lock()
+	defer d.refreshMutex.Unlock()
+	d.skipRefresh = !refresh
+}
+
+// installArgs returns the arguments of apt installing packages, before the
+// packages. Offline, APT only installs package files it downloaded before.
+func (d *AptDriver) installArgs() []string {
+	if d.Offline() {
+		return []string{"install", "-y", "--no-download"}
+	}
+	return []string{"install", "-y"}
+}
+
+// IsPackageCached reports whether the package files installing a package needs
+// are in the APT cache. apt-get prints the URI of every file it would download.
+func (d *AptDriver) IsPackageCached(packageName, version string) (bool, error) {
+	target := packageName
+	if version != "" {
+		target = fmt.Sprintf("%s=%s", packageName, version)
+	}
+	output, err := d.RunExternalCommand("apt-get", "-qq", "--print-uris", "install", target)
+	if err != nil {
+		return false, fmt.Errorf("failed to check the APT cache for %s: %w\nOutput: %s", target, err, output)
+	}
+	return strings.TrimSpace(output) == "", nil
+}
+
+// SupportsVersionPinning reports that APT can install specific versions
+func (d *AptDriver) SupportsVersionPinning() bool {
+	return true
+}
+
 // UninstallPackage uninstalls a package using APT
 func (d *AptDriver) UninstallPackage(packageName string) error {
-	output, err := d.RunCommandWithSudo("remove", "-y", packageName)
+	output, err := d.RunPrivileged("remove", "-y", packageName)
 	if err != nil {
 		return fmt.Errorf("failed to uninstall package %s via APT: %w\nOutput: %s", packageName, err, output)
 	}
@@ -213,15 +294,6 @@ func (d *AptDriver) GetAllInstalledPackages() (map[string]bool, error) {
 	return d.fetchAllInstalledPackages()
 }
 
-// RunCommandWithSudo executes an APT command with sudo privileges
-func (d *AptDriver) RunCommandWithSudo(args ...string) (string, error) {
-	// Prepend sudo to the command
-	sudoArgs := append([]string{d.executable}, args...)
-	cmd := exec.Command("sudo", sudoArgs...)
-	output, err := cmd.CombinedOutput()
-	return strings.TrimSpace(string(output)), err
-}
-
 // EnsureRepository ensures a PPA or repository is available
 func (d *AptDriver) EnsureRepository(repoName string) error {
 	// Check if add-apt-repository is available
@@ -244,10 +316,10 @@ func (d *AptDriver) EnsureRepository(repoName string) error {
 	var output string
 	if strings.HasPrefix(repoName, "ppa:") {
 		// Handle PPA
-		output, err = d.RunCommandWithSudo("add-apt-repository", "-y", repoName)
+		output, err = d.RunPrivilegedCommand("add-apt-repository", "-y", repoName)
 	} else {
 		// Handle regular repository (assume it's a complete sources.list line)
-		output, err = d.RunCommandWithSudo("add-apt-repository", "-y", repoName)
+		output, err = d.RunPrivilegedCommand("add-apt-repository", "-y", repoName)
 	}
 
 	if err != nil {
@@ -255,7 +327,10 @@ func (d *AptDriver) EnsureRepository(repoName string) error {
 	}
 
 	// Update package list after adding repository
-	_, updateErr := d.RunCommandWithSudo("update")
+	if d.Offline() {
+		return nil
+	}
+	_, updateErr := d.RunPrivileged("update")
 	if updateErr != nil {
 		// Log warning but don't fail - the repository was added successfully
 		return fmt.Errorf("repository %s added but failed to update package list: %w", repoName, updateErr)
@@ -317,7 +392,12 @@ func (d *AptDriver) isRepositoryInSources(repoLine string) (bool, error) {
 	return strings.TrimSpace(string(output)) != "", nil
 }
 
-// IsAvailable overrides the base implementation to check platform compatibility and sudo
+// NeedsPrivilege reports that APT installs and removes packages as root
+func (d *AptDriver) NeedsPrivilege() bool {
+	return true
+}
+
+// IsAvailable overrides the base implementation to check platform compatibility and privileges
 func (d *AptDriver) IsAvailable() bool {
 	// APT is only available on Linux
 	if runtime.GOOS != "linux" {
@@ -329,14 +409,13 @@ func (d *AptDriver) IsAvailable() bool {
 		return false
 	}
 
-	// Check if sudo is available (needed for install/remove operations)
-	_, err := exec.LookPath("sudo")
-	if err != nil {
+	// Install/remove operations need root or a way to become root
+	if !d.Privilege().Available() {
 		return false
 	}
 
 	// Check if add-apt-repository is available (needed for repository management)
-	_, err = exec.LookPath("add-apt-repository")
+	_, err := exec.LookPath("add-apt-repository")
 	if err != nil {
 		return false
 	}
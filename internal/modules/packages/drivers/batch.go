@@ -0,0 +1,173 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// BatchDriver is implemented by drivers that install several packages with one
+// command, paying for the lock and dependency resolution of the package manager once
+type BatchDriver interface {
+	// InstallPackages installs packages together. When some of them fail it returns
+	// a *BatchInstallError naming them if the output of the package manager tells
+	// which, or any other error when it does not.
+	InstallPackages(packageNames []string) error
+}
+
+// Refresher is implemented by drivers that update their package lists before they
+// install, like apt update. InstallPackages updates them once and installs without
+// updating them again, also when a failed batch is installed again or one by one.
+type Refresher interface {
+	// RefreshPackageLists updates the package lists
+	RefreshPackageLists()
+	// SetRefresh sets whether installing packages updates the package lists first
+	SetRefresh(refresh bool)
+}
+
+// BatchInstallError is returned when some of the packages installed together failed
+type BatchInstallError struct {
+	Failed map[string]error // Why each package that failed did
+}
+
+func (e *BatchInstallError) Error() string {
+	names := e.names()
+
+	if len(names) == 1 {
+		return e.Failed[names[0]].Error()
+	}
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = fmt.Sprintf("%s: %v", name, e.Failed[name])
+	}
+	return fmt.Sprintf("failed to install %d packages:\n%s", len(names), strings.Join(messages, "\n"))
+}
+
+// Unwrap returns the errors of the packages that failed
+func (e *BatchInstallError) Unwrap() []error {
+	names := e.names()
+	errs := make([]error, len(names))
+	for i, name := range names {
+		errs[i] = e.Failed[name]
+	}
+	return errs
+}
+
+// names returns the packages that failed in alphabetical order
+func (e *BatchInstallError) names() []string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InstallPackages installs packages with as few commands as the driver allows. When
+// a batch fails and the output does not say which package broke it, the packages
+// are installed one by one to find out. The error is a *BatchInstallError naming
+// every package that failed. The package lists are updated once for all of them.
+func InstallPackages(driver PackageDriver, packageNames []string) error {
+	if refresher, ok := driver.(Refresher); ok && len(packageNames) > 0 {
+		refresher.RefreshPackageLists()
+		refresher.SetRefresh(false)
+		defer refresher.SetRefresh(true)
+	}
+	return installBatch(driver, packageNames)
+}
+
+// installBatch installs packages like InstallPackages, without updating the package
+// lists itself
+func installBatch(driver PackageDriver, packageNames []string) error {
+	batch, ok := driver.(BatchDriver)
+	if !ok || len(packageNames) < 2 {
+		return installEach(driver, packageNames)
+	}
+
+	err := batch.InstallPackages(packageNames)
+	if err == nil {
+		return nil
+	}
+	failed := attributeFailures(packageNames, err)
+	if len(failed) == 0 {
+		// The output does not tell which package broke the batch, installing them
+		// one by one does
+		return installEach(driver, packageNames)
+	}
+
+	// Package managers such as APT install nothing when one package is unknown, so
+	// the others are installed again without the ones that failed
+	var rest []string
+	for _, name := range packageNames {
+		if _, ok := failed[name]; !ok {
+			rest = append(rest, name)
+		}
+	}
+	var restErr *BatchInstallError
+	if err := installBatch(driver, rest); errors.As(err, &restErr) {
+		maps.Copy(failed, restErr.Failed)
+	}
+	return &BatchInstallError{Failed: failed}
+}
+
+// attributeFailures returns the failures of a batch install by package, empty when
+// the driver could not tell which packages failed
+func attributeFailures(packageNames []string, err error) map[string]error {
+	failed := make(map[string]error)
+	var batchErr *BatchInstallError
+	if !errors.As(err, &batchErr) {
+		return failed
+	}
+	for _, name := range packageNames {
+		if packageErr, ok := batchErr.Failed[name]; ok {
+			failed[name] = packageErr
+		}
+	}
+	return failed
+}
+
+// installEach installs packages one at a time, carrying on past failures
+func installEach(driver PackageDriver, packageNames []string) error {
+	failed := make(map[string]error)
+	for _, name := range packageNames {
+		if err := driver.InstallPackage(name); err != nil {
+			failed[name] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchInstallError{Failed: failed}
+	}
+	return nil
+}
+
+// batchError returns the error of a failed batch install. Packages named by a line
+// of output that pattern matches, with the name as its first non-empty group, are
+// blamed for the failure with that line; output naming none gives a plain error.
+func batchError(label string, packageNames []string, output string, pattern *regexp.Regexp, err error) error {
+	failed := make(map[string]error)
+	for _, line := range strings.Split(output, "\n") {
+		match := pattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		culprit := ""
+		for _, group := range match[1:] {
+			if group != "" {
+				culprit = group
+				break
+			}
+		}
+		for _, name := range packageNames {
+			if strings.EqualFold(name, culprit) {
+				failed[name] = fmt.Errorf("failed to install package %s via %s: %s", name, label, strings.TrimSpace(line))
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return fmt.Errorf("failed to install packages %s via %s: %w\nOutput: %s", strings.Join(packageNames, ", "), label, err, output)
+	}
+	return &BatchInstallError{Failed: failed}
+}
@@ -0,0 +1,202 @@
+package drivers
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fakeDriver is a package manager that knows the packages in available and
+// installs one package per command
+type fakeDriver struct {
+	*BaseDriver
+	available map[string]bool
+	installed []string
+	commands  int
+}
+
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{
+		BaseDriver: NewBaseDriver("fake", "true"),
+		available:  map[string]bool{"git": true, "curl": true, "jq": true},
+	}
+}
+
+func (d *fakeDriver) IsPackageInstalled(packageName string) (bool, error)          { return false, nil }
+func (d *fakeDriver) UninstallPackage(packageName string) error                    { return nil }
+func (d *fakeDriver) SearchPackage(packageName string) ([]string, error)           { return nil, nil }
+func (d *fakeDriver) GetPackageInfo(packageName string) (map[string]string, error) { return nil, nil }
+func (d *fakeDriver) GetAllInstalledPackages() (map[string]bool, error)            { return nil, nil }
+
+func (d *fakeDriver) InstallPackage(packageName string) error {
+	return d.install([]string{packageName}, true)
+}
+
+// install installs nothing when one of the packages is unknown, like APT. blame is
+// whether the output names the unknown packages.
+func (d *fakeDriver) install(packageNames []string, blame bool) error {
+	d.commands++
+	var output []string
+	for _, name := range packageNames {
+		if !d.available[name] {
+			output = append(output, "E: Unable to locate package "+name)
+		}
+	}
+	if len(output) == 0 {
+		d.installed = append(d.installed, packageNames...)
+		return nil
+	}
+	if !blame {
+		output = []string{"E: Sub-process /usr/bin/dpkg returned an error code (1)"}
+	}
+	return batchError("fake", packageNames, strings.Join(output, "\n"), aptNotFound, errors.New("exit status 100"))
+}
+
+// batchingDriver is a fakeDriver that installs several packages with one command
+type batchingDriver struct {
+	*fakeDriver
+	blame bool
+}
+
+func (d *batchingDriver) InstallPackages(packageNames []string) error {
+	return d.install(packageNames, d.blame)
+}
+
+// refreshingDriver is a batchingDriver that updates its package lists before every
+// install like APT, unless told not to
+type refreshingDriver struct {
+	*batchingDriver
+	skipRefresh bool
+	refreshes   int
+}
+
+func (d *refreshingDriver) RefreshPackageLists()    { d.refreshes++ }
+func (d *refreshingDriver) SetRefresh(refresh bool) { d.skipRefresh = !refresh }
+
+func (d *refreshingDriver) InstallPackage(packageName string) error {
+	if !d.skipRefresh {
+		d.RefreshPackageLists()
+	}
+	return d.batchingDriver.InstallPackage(packageName)
+}
+
+func (d *refreshingDriver) InstallPackages(packageNames []string) error {
+	if !d.skipRefresh {
+		d.RefreshPackageLists()
+	}
+	return d.batchingDriver.InstallPackages(packageNames)
+}
+
+func TestInstallPackages(t *testing.T) {
+	t.Run("OneCommand", func(t *testing.T) {
+		driver := &batchingDriver{fakeDriver: newFakeDriver(), blame: true}
+		if err := InstallPackages(driver, []string{"git", "curl", "jq"}); err != nil {
+			t.Fatal(err)
+		}
+		if driver.commands != 1 || len(driver.installed) != 3 {
+			t.Errorf("expected 3 packages installed with 1 command, got %v with %d", driver.installed, driver.commands)
+		}
+	})
+
+	t.Run("BlamedPackage", func(t *testing.T) {
+		driver := &batchingDriver{fakeDriver: newFakeDriver(), blame: true}
+		err := InstallPackages(driver, []string{"git", "gti", "curl"})
+		var batchErr *BatchInstallError
+		if !errors.As(err, &batchErr) || len(batchErr.Failed) != 1 || batchErr.Failed["gti"] == nil {
+			t.Fatalf("expected gti to be blamed, got %v", err)
+		}
+		if want := "failed to install package gti via fake: E: Unable to locate package gti"; err.Error() != want {
+			t.Errorf("error = %q, want %q", err.Error(), want)
+		}
+		// The others are installed again together
+		if driver.commands != 2 || !reflect.DeepEqual(driver.installed, []string{"git", "curl"}) {
+			t.Errorf("expected git and curl installed with a second command, got %v with %d", driver.installed, driver.commands)
+		}
+	})
+
+	t.Run("UnknownCulprit", func(t *testing.T) {
+		driver := &batchingDriver{fakeDriver: newFakeDriver()}
+		err := InstallPackages(driver, []string{"git", "gti", "curl"})
+		var batchErr *BatchInstallError
+		if !errors.As(err, &batchErr) || len(batchErr.Failed) != 1 || batchErr.Failed["gti"] == nil {
+			t.Fatalf("expected gti to be found by installing one by one, got %v", err)
+		}
+		if driver.commands != 4 || !reflect.DeepEqual(driver.installed, []string{"git", "curl"}) {
+			t.Errorf("expected every package installed on its own, got %v with %d commands", driver.installed, driver.commands)
+		}
+	})
+
+	t.Run("RefreshesOnce", func(t *testing.T) {
+		for _, blame := range []bool{true, false} {
+			driver := &refreshingDriver{batchingDriver: &batchingDriver{fakeDriver: newFakeDriver(), blame: blame}}
+			err := InstallPackages(driver, []string{"git", "gti", "curl", "jq"})
+			var batchErr *BatchInstallError
+			if !errors.As(err, &batchErr) || len(batchErr.Failed) != 1 {
+				t.Fatalf("expected gti to fail, got %v", err)
+			}
+			// The batch is installed again or one by one with the lists updated before it
+			if driver.refreshes != 1 {
+				t.Errorf("blame %v: updated the package lists %d times, want once", blame, driver.refreshes)
+			}
+			if driver.skipRefresh {
+				t.Errorf("blame %v: installing a single package no longer updates the package lists", blame)
+			}
+		}
+
+		driver := &refreshingDriver{batchingDriver: &batchingDriver{fakeDriver: newFakeDriver()}}
+		if err := InstallPackages(driver, nil); err != nil || driver.refreshes != 0 {
+			t.Errorf("updated the package lists %d times for no packages, %v", driver.refreshes, err)
+		}
+	})
+
+	t.Run("WithoutBatching", func(t *testing.T) {
+		driver := newFakeDriver()
+		err := InstallPackages(driver, []string{"git", "gti", "jq", "jqq"})
+		var batchErr *BatchInstallError
+		if !errors.As(err, &batchErr) || len(batchErr.Failed) != 2 {
+			t.Fatalf("expected gti and jqq to fail, got %v", err)
+		}
+		if !strings.HasPrefix(err.Error(), "failed to install 2 packages:\ngti: ") {
+			t.Errorf("unexpected error %q", err.Error())
+		}
+		if driver.commands != 4 || !reflect.DeepEqual(driver.installed, []string{"git", "jq"}) {
+			t.Errorf("expected git and jq installed one by one, got %v with %d commands", driver.installed, driver.commands)
+		}
+	})
+}
+
+func TestBatchErrorAttribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern *regexp.Regexp
+		output  string
+		want    []string
+	}{
+		{"apt", aptNotFound, "Reading package lists...\nE: Unable to locate package gti\nE: Package 'vim-gtk' has no installation candidate", []string{"gti", "vim-gtk"}},
+		{"dnf", rpmNotFound, "Last metadata expiration check: 0:12:01 ago.\nNo match for argument: gti\nError: Unable to find a match: gti", []string{"gti"}},
+		{"yum", rpmNotFound, "No package gti available.\nPackage git installed", []string{"gti"}},
+		{"brew", brewNotFound, "Warning: No available formula with the name \"gti\". Did you mean git?", []string{"gti"}},
+		{"brew 4", brewNotFound, "Error: No formulae or casks found for gti.", []string{"gti"}},
+		{"scoop", scoopNotFound, "Couldn't find manifest for 'gti'.\nInstalling 'git' (2.45.1) [64bit]", []string{"gti"}},
+		{"unrelated", aptNotFound, "E: Could not get lock /var/lib/dpkg/lock-frontend", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := batchError("fake", []string{"git", "gti", "vim-gtk"}, tt.output, tt.pattern, errors.New("exit status 1"))
+
+			var batchErr *BatchInstallError
+			if !errors.As(err, &batchErr) {
+				if tt.want != nil {
+					t.Fatalf("expected %v to be blamed, got %v", tt.want, err)
+				}
+				return
+			}
+			if got := batchErr.names(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("blamed %v, want %v (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+)
+
+// Bootstrap describes how a package manager that is not installed yet is installed
+// with its official install script
+type Bootstrap struct {
+	Manager string   // Name of the driver of the package manager
+	Command string   // Program that downloads and runs the install script
+	Args    []string // Arguments of the command
+	Env     []string // Environment the install script needs besides the current one
+	Paths   []string // Directories the package manager's executables are installed to
+}
+
+// bootstrapAliases maps the names a package manager can be bootstrapped by to its driver
+var bootstrapAliases = map[string]string{
+	"scoop":      "scoop",
+	"homebrew":   "homebrew",
+	"brew":       "homebrew",
+	"chocolatey": "chocolatey",
+	"choco":      "chocolatey",
+	"cargo":      "cargo",
+	"rust":       "cargo",
+	"rustup":     "cargo",
+}
+
+// BootstrapManagerName returns the driver name of a package manager that can be
+// bootstrapped, or false when there is no install script for it
+func BootstrapManagerName(name string) (string, bool) {
+	manager, exists := bootstrapAliases[strings.ToLower(name)]
+	return manager, exists
+}
+
+// GetBootstrap returns how to install a package manager on the current system
+func GetBootstrap(name string) (*Bootstrap, error) {
+	home, _ := os.UserHomeDir()
+	return bootstrapFor(name, runtime.GOOS, os.Getenv, home)
+}
+
+// bootstrapFor returns how to install a package manager on a platform
+func bootstrapFor(name, goos string, getenv func(string) string, home string) (*Bootstrap, error) {
+	manager, exists := BootstrapManagerName(name)
+	if !exists {
+		return nil, fmt.Errorf("cannot install package manager '%s', supported are scoop, homebrew, chocolatey and cargo (rustup)", name)
+	}
+
+	envDir := func(key string, fallback ...string) string {
+		if dir := getenv(key); dir != "" {
+			return dir
+		}
+		return filepath.Join(fallback...)
+	}
+
+	switch manager {
+	case "scoop":
+		if goos != "windows" {
+			return nil, fmt.Errorf("scoop can only be installed on Windows")
+		}
+		return &Bootstrap{
+			Manager: manager,
+			Command: "powershell",
+			Args:    powershellArgs("Invoke-RestMethod -Uri https://get.scoop.sh | Invoke-Expression"),
+			Paths:   []string{filepath.Join(envDir("SCOOP", home, "scoop"), "shims")},
+		}, nil
+	case "chocolatey":
+		if goos != "windows" {
+			return nil, fmt.Errorf("chocolatey can only be installed on Windows")
+		}
+		return &Bootstrap{
+			Manager: manager,
+			Command: "powershell",
+			Args: powershellArgs("[System.Net.ServicePointManager]::SecurityProtocol = [System.Net.ServicePointManager]::SecurityProtocol -bor 3072; " +
+				"Invoke-Expression ((New-Object System.Net.WebClient).DownloadString('https://community.chocolatey.org/install.ps1'))"),
+			Paths: []string{filepath.Join(envDir("ChocolateyInstall", envDir("ProgramData", `C:\ProgramData`), "chocolatey"), "bin")},
+		}, nil
+	case "homebrew":
+		if goos == "windows" {
+			return nil, fmt.Errorf("homebrew cannot be installed on Windows")
+		}
+		var paths []string
+		for _, prefix := range platform.HomebrewPrefixes(goos, home) {
+			paths = append(paths, filepath.Join(prefix, "bin"))
+		}
+		return &Bootstrap{
+			Manager: manager,
+			Command: "/bin/bash",
+			Args:    []string{"-c", `script="$(curl -fsSL https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh)" && /bin/bash -c "$script"`},
+			// The script asks for confirmation unless it runs non-interactively
+			Env:   []string{"NONINTERACTIVE=1"},
+			Paths: paths,
+		}, nil
+	default: // cargo, installed with rustup
+		bootstrap := &Bootstrap{
+			Manager: manager,
+			Paths:   []string{filepath.Join(envDir("CARGO_HOME", home, ".cargo"), "bin")},
+		}
+		if goos == "windows" {
+			bootstrap.Command = "powershell"
+			bootstrap.Args = powershellArgs("$installer = Join-Path $env:TEMP 'rustup-init.exe'; " +
+				"Invoke-WebRequest -Uri https://win.rustup.rs/x86_64 -OutFile $installer; " +
+				"& $installer -y; exit $LASTEXITCODE")
+		} else {
+			bootstrap.Command = "sh"
+			bootstrap.Args = []string{"-c", "curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y"}
+		}
+		return bootstrap, nil
+	}
+}
+
+// powershellArgs returns the arguments to run a script with PowerShell without
+// loading the profile or being stopped by the execution policy
+func powershellArgs(script string) []string {
+	return []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command", script}
+}
+
+// Run downloads and runs the install script with its output going to the user as
+// it runs. The script is killed when ctx is cancelled or its deadline passes.
+func (b *Bootstrap) Run(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, b.Command, b.Args...)
+	cmd.WaitDelay = commandWaitDelay
+	cmd.Env = append(os.Environ(), b.Env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		elapsed := time.Since(start).Round(time.Millisecond)
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return fmt.Errorf("install script of %s timed out after %s: %w", b.Manager, elapsed, ctx.Err())
+		case context.Canceled:
+			return fmt.Errorf("install script of %s was cancelled after %s: %w", b.Manager, elapsed, ctx.Err())
+		}
+		return fmt.Errorf("install script of %s failed: %w", b.Manager, err)
+	}
+	return nil
+}
+
+// Refresh makes package managers installed during this run available to the
+// remaining tasks: directories they were installed to are added to PATH, which
+// availability is looked up in, and cached package lists are dropped
+func (r *DriverRegistry) Refresh(paths ...string) {
+	current := filepath.SplitList(os.Getenv("PATH"))
+	onPath := make(map[string]bool, len(current))
+	for _, dir := range current {
+		onPath[filepath.Clean(dir)] = true
+	}
+
+	var added []string
+	for _, dir := range paths {
+		dir = filepath.Clean(dir)
+		if onPath[dir] {
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		onPath[dir] = true
+		added = append(added, dir)
+	}
+	if len(added) > 0 {
+		os.Setenv("PATH", strings.Join(append(added, current...), string(os.PathListSeparator)))
+	}
+
+	for _, driver := range r.drivers {
+		if cached, ok := driver.(CacheInvalidator); ok {
+			cached.InvalidateCache()
+		}
+	}
+}
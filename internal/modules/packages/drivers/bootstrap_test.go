@@ -0,0 +1,104 @@
+package drivers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBootstrapFor(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	home := filepath.Join("home", "user")
+
+	tests := []struct {
+		name    string
+		manager string
+		goos    string
+		vars    map[string]string
+		want    string // Driver name
+		path    string // First directory added to PATH
+		wantErr string
+	}{
+		{"scoop", "scoop", "windows", nil, "scoop", filepath.Join(home, "scoop", "shims"), ""},
+		{"scoop custom dir", "scoop", "windows", map[string]string{"SCOOP": filepath.Join("D", "scoop")}, "scoop", filepath.Join("D", "scoop", "shims"), ""},
+		{"scoop on linux", "scoop", "linux", nil, "", "", "scoop can only be installed on Windows"},
+		{"choco alias", "choco", "windows", map[string]string{"ProgramData": "PD"}, "chocolatey", filepath.Join("PD", "chocolatey", "bin"), ""},
+		{"brew on macOS", "brew", "darwin", nil, "homebrew", "/opt/homebrew/bin", ""},
+		{"homebrew on linux", "homebrew", "linux", nil, "homebrew", "/home/linuxbrew/.linuxbrew/bin", ""},
+		{"homebrew on windows", "homebrew", "windows", nil, "", "", "homebrew cannot be installed on Windows"},
+		{"rustup", "rustup", "linux", nil, "cargo", filepath.Join(home, ".cargo", "bin"), ""},
+		{"rustup custom cargo home", "RUSTUP", "windows", map[string]string{"CARGO_HOME": "cargo"}, "cargo", filepath.Join("cargo", "bin"), ""},
+		{"unsupported", "apt", "linux", nil, "", "", "cannot install package manager 'apt'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bootstrap, err := bootstrapFor(tt.manager, tt.goos, env(tt.vars), home)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bootstrap.Manager != tt.want {
+				t.Errorf("expected manager %s, got %s", tt.want, bootstrap.Manager)
+			}
+			if len(bootstrap.Paths) == 0 || bootstrap.Paths[0] != tt.path {
+				t.Errorf("expected path %s, got %v", tt.path, bootstrap.Paths)
+			}
+			if bootstrap.Command == "" || len(bootstrap.Args) == 0 {
+				t.Errorf("expected an install command, got %q %v", bootstrap.Command, bootstrap.Args)
+			}
+		})
+	}
+}
+
+func TestDriverRegistryRefresh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as executable")
+	}
+
+	dir := t.TempDir()
+	t.Setenv("PATH", os.Getenv("PATH"))
+
+	registry := NewDriverRegistry()
+	driver := &CargoDriver{BaseDriver: NewBaseDriver("fresh", "dotfiles-test-fresh-manager")}
+	registry.RegisterDriver(driver)
+	driver.GetCache().SetPackages(map[string]bool{"git": true})
+
+	if driver.IsAvailable() {
+		t.Fatal("expected the manager not to be available before it is installed")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dotfiles-test-fresh-manager"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	registry.Refresh(dir, filepath.Join(dir, "missing"))
+	if !driver.IsAvailable() {
+		t.Error("expected the manager to be available after a refresh")
+	}
+	if _, err := exec.LookPath("dotfiles-test-fresh-manager"); err != nil {
+		t.Errorf("expected the directory on PATH: %v", err)
+	}
+	if strings.Contains(os.Getenv("PATH"), "missing") {
+		t.Error("expected directories that do not exist to be left off PATH")
+	}
+	if driver.GetCache().IsValid() {
+		t.Error("expected the package cache to be invalidated")
+	}
+
+	// Directories already on PATH are not added again
+	before := os.Getenv("PATH")
+	registry.Refresh(dir)
+	if os.Getenv("PATH") != before {
+		t.Errorf("expected PATH to be unchanged, got %s", os.Getenv("PATH"))
+	}
+}
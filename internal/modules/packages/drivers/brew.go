@@ -2,25 +2,39 @@ package drivers
 
 import (
 	"fmt"
-	"runtime"
+	"regexp"
 	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
 )
 
-// BrewDriver implements PackageDriver for Homebrew package manager (macOS)
+// BrewDriver implements PackageDriver for Homebrew package manager (macOS and Linux)
 type BrewDriver struct {
 	*BaseDriver
 }
 
-// NewBrewDriver creates a new Homebrew driver
+// NewBrewDriver creates a new Homebrew driver. brew is run from its default prefix
+// when it is not in PATH, as for linuxbrew before the shell profile sets it up.
 func NewBrewDriver() *BrewDriver {
+	executable := "brew"
+	if brew := platform.FindBrew(); brew != "" {
+		executable = brew
+	}
 	return &BrewDriver{
-		BaseDriver: NewBaseDriver("homebrew", "brew"),
+		BaseDriver: NewBaseDriver("homebrew", executable),
 	}
 }
 
-// IsPackageInstalled checks if a package is installed via Homebrew
+// IsPackageInstalled checks if a package is installed via Homebrew, as a formula or
+// as a cask
 func (d *BrewDriver) IsPackageInstalled(packageName string) (bool, error) {
-	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+	return d.IsPackageInstalledCached(brewShortName(packageName), d.fetchAllInstalledPackages)
+}
+
+// brewShortName strips the tap from a fully qualified name such as
+// "homebrew/cask/firefox", since brew list only prints the short name
+func brewShortName(packageName string) string {
+	return packageName[strings.LastIndex(packageName, "/")+1:]
 }
 
 // fetchAllInstalledPackages fetches all installed packages from Homebrew
@@ -72,11 +86,57 @@ func (d *BrewDriver) InstallPackage(packageName string) error {
 	return nil
 }
 
+// brewNotFound matches the lines Homebrew blames a formula it does not know with
+var brewNotFound = regexp.MustCompile(`No available formula with the name "([^"]+)"|No formulae or casks found for ([^\s.]+)`)
+
+// InstallPackages installs formulae with a single brew install
+func (d *BrewDriver) InstallPackages(packageNames []string) error {
+	output, err := d.RunCommand(append([]string{"install"}, packageNames...)...)
+	if err != nil {
+		return batchError("Homebrew", packageNames, output, brewNotFound, err)
+	}
+	return nil
+}
+
+// InstallPackageVersion installs a versioned formula using Homebrew (pkg@version).
+// This only works for formulae that publish versioned variants such as python@3.12
+func (d *BrewDriver) InstallPackageVersion(packageName, version string) error {
+	target := fmt.Sprintf("%s@%s", packageName, version)
+	output, err := d.RunCommand("install", target)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via Homebrew (is there a versioned formula?): %w\nOutput: %s", target, err, output)
+	}
+	return nil
+}
+
+// SupportsVersionPinning reports that Homebrew can install versioned formulae
+func (d *BrewDriver) SupportsVersionPinning() bool {
+	return true
+}
+
+// InstallCask installs an application as a cask using Homebrew
+func (d *BrewDriver) InstallCask(packageName string) error {
+	output, err := d.RunCommand("install", "--cask", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to install cask %s via Homebrew: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
+// UninstallCask uninstalls a cask using Homebrew
+func (d *BrewDriver) UninstallCask(packageName string) error {
+	output, err := d.RunCommand("uninstall", "--cask", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to uninstall cask %s via Homebrew: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
 // UninstallPackage uninstalls a package using Homebrew
 func (d *BrewDriver) UninstallPackage(packageName string) error {
 	// Check if it's a formula or cask first
 	isFormula, _ := d.isFormulaInstalled(packageName)
-	isCask, _ := d.isCaskInstalled(packageName)
+	isCask, _ := d.IsCaskInstalled(packageName)
 
 	if isFormula {
 		output, err := d.RunCommand("uninstall", packageName)
@@ -104,23 +164,23 @@ func (d *BrewDriver) isFormulaInstalled(packageName string) (bool, error) {
 
 	lines := strings.Split(output, "\n")
 	for _, line := range lines {
-		if strings.TrimSpace(line) == packageName {
+		if strings.TrimSpace(line) == brewShortName(packageName) {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
-// isCaskInstalled checks if a package is installed as a cask
-func (d *BrewDriver) isCaskInstalled(packageName string) (bool, error) {
+// IsCaskInstalled checks if a package is installed as a cask
+func (d *BrewDriver) IsCaskInstalled(packageName string) (bool, error) {
 	output, err := d.RunCommand("list", "--cask")
 	if err != nil {
-		return false, err
+		return false, fmt.Errorf("failed to list installed casks: %w", err)
 	}
 
 	lines := strings.Split(output, "\n")
 	for _, line := range lines {
-		if strings.TrimSpace(line) == packageName {
+		if strings.TrimSpace(line) == brewShortName(packageName) {
 			return true, nil
 		}
 	}
@@ -222,12 +282,73 @@ func (d *BrewDriver) GetAllInstalledPackages() (map[string]bool, error) {
 	return d.fetchAllInstalledPackages()
 }
 
-// IsAvailable overrides the base implementation to check platform compatibility
-func (d *BrewDriver) IsAvailable() bool {
-	// Homebrew is primarily for macOS and Linux, not Windows
-	if runtime.GOOS == "windows" {
-		return false
+// parseBrewTap splits "user/repo url" into the tap name and its optional git URL.
+// Taps on GitHub named homebrew-<repo> can be added by name alone.
+func parseBrewTap(repoName string) (string, string, error) {
+	fields := strings.Fields(repoName)
+	if len(fields) == 0 || len(fields) > 2 || strings.Count(fields[0], "/") != 1 {
+		return "", "", fmt.Errorf("homebrew tap must be \"<user>/<repo>\" or \"<user>/<repo> <url>\", got %q", repoName)
 	}
+	if len(fields) == 2 {
+		return fields[0], fields[1], nil
+	}
+	return fields[0], "", nil
+}
+
+// parseBrewTapList parses the output of brew tap into a set of lowercase tap names
+func parseBrewTapList(output string) map[string]bool {
+	taps := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			taps[strings.ToLower(line)] = true
+		}
+	}
+	return taps
+}
 
-	return d.BaseDriver.IsAvailable()
+// EnsureRepository ensures a Homebrew tap is available, adding it from its git URL
+// when one is given
+func (d *BrewDriver) EnsureRepository(repoName string) error {
+	name, url, err := parseBrewTap(repoName)
+	if err != nil {
+		return err
+	}
+
+	available, err := d.IsRepositoryAvailable(name)
+	if err != nil {
+		return err
+	}
+	if available {
+		return nil
+	}
+
+	args := []string{"tap", name}
+	if url != "" {
+		args = append(args, url)
+	}
+	output, err := d.RunCommand(args...)
+	if err != nil {
+		return fmt.Errorf("failed to add Homebrew tap %s: %w\nOutput: %s", name, err, output)
+	}
+	return nil
+}
+
+// IsRepositoryAvailable checks if a Homebrew tap is already added
+func (d *BrewDriver) IsRepositoryAvailable(repoName string) (bool, error) {
+	name, _, err := parseBrewTap(repoName)
+	if err != nil {
+		return false, err
+	}
+
+	output, err := d.RunCommand("tap")
+	if err != nil {
+		return false, fmt.Errorf("failed to list Homebrew taps: %w", err)
+	}
+	return parseBrewTapList(output)[strings.ToLower(name)], nil
+}
+
+// IsAvailable reports whether brew is in PATH or in a default Homebrew prefix
+func (d *BrewDriver) IsAvailable() bool {
+	return platform.FindBrew() != ""
 }
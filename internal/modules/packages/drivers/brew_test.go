@@ -0,0 +1,52 @@
+package drivers
+
+import (
+	"testing"
+)
+
+func TestParseBrewTap(t *testing.T) {
+	tests := []struct {
+		input   string
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{input: "homebrew/cask-fonts", name: "homebrew/cask-fonts"},
+		{input: "myorg/tools git@github.com:myorg/homebrew-tools.git", name: "myorg/tools", url: "git@github.com:myorg/homebrew-tools.git"},
+		{input: "tools", wantErr: true},
+		{input: "myorg/tools url extra", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			name, url, err := parseBrewTap(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBrewTap(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if name != tt.name || url != tt.url {
+				t.Errorf("parseBrewTap(%q) = %q, %q, want %q, %q", tt.input, name, url, tt.name, tt.url)
+			}
+		})
+	}
+}
+
+func TestParseBrewTapList(t *testing.T) {
+	taps := parseBrewTapList("homebrew/bundle\nMyOrg/Tools\n\n")
+	if len(taps) != 2 || !taps["homebrew/bundle"] || !taps["myorg/tools"] {
+		t.Errorf("parseBrewTapList() = %v", taps)
+	}
+}
+
+func TestBrewShortName(t *testing.T) {
+	tests := map[string]string{
+		"git":                   "git",
+		"homebrew/cask/firefox": "firefox",
+		"myorg/tools/mytool":    "mytool",
+	}
+	for input, want := range tests {
+		if got := brewShortName(input); got != want {
+			t.Errorf("brewShortName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
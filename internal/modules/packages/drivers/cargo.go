@@ -30,40 +30,56 @@ func (d *CargoDriver) fetchAllInstalledPackages() (map[string]bool, error) {
 	}
 
 	packages := make(map[string]bool)
-	lines := strings.Split(output, "\n")
+	for name := range parseCargoInstallList(output) {
+		packages[name] = true
+		packages[strings.ToLower(name)] = true
+	}
+	return packages, nil
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+// parseCargoInstallList parses the output of `cargo install --list` into crate
+// versions by name. Every crate has a "name v1.2.3:" header line, or
+// "name v1.2.3 (source):" for crates from git or a path, followed by its
+// binaries on indented lines.
+func parseCargoInstallList(output string) map[string]string {
+	crates := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || line[0] == ' ' || line[0] == '\t' || !strings.HasSuffix(line, ":") {
 			continue
 		}
 
-		// Cargo output format: "packagename v1.2.3:"
-		// followed by indented binary paths
-		if !strings.HasPrefix(line, " ") && strings.Contains(line, " v") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				packageName := parts[0]
-				// Remove trailing colon if present
-				packageName = strings.TrimSuffix(packageName, ":")
-				packages[packageName] = true
-				packages[strings.ToLower(packageName)] = true
-			}
+		fields := strings.Fields(strings.TrimSuffix(line, ":"))
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "v") {
+			continue
 		}
+		crates[fields[0]] = strings.TrimPrefix(fields[1], "v")
 	}
-
-	return packages, nil
+	return crates
 }
 
-// InstallPackage installs a package using Cargo
+// InstallPackage installs a package using Cargo. Crates are built from source,
+// which can take minutes, so the build output is shown while it runs.
 func (d *CargoDriver) InstallPackage(packageName string) error {
-	output, err := d.RunCommand("install", packageName)
-	if err != nil {
-		return fmt.Errorf("failed to install package %s via Cargo: %w\nOutput: %s", packageName, err, output)
+	if err := d.RunCommandStreaming("install", packageName); err != nil {
+		return fmt.Errorf("failed to install package %s via Cargo: %w", packageName, err)
 	}
 	return nil
 }
 
+// InstallPackageVersion installs a specific crate version using Cargo
+func (d *CargoDriver) InstallPackageVersion(packageName, version string) error {
+	if err := d.RunCommandStreaming("install", packageName, "--version", version); err != nil {
+		return fmt.Errorf("failed to install package %s %s via Cargo: %w", packageName, version, err)
+	}
+	return nil
+}
+
+// SupportsVersionPinning reports that Cargo can install specific versions
+func (d *CargoDriver) SupportsVersionPinning() bool {
+	return true
+}
+
 // UninstallPackage uninstalls a package using Cargo
 func (d *CargoDriver) UninstallPackage(packageName string) error {
 	output, err := d.RunCommand("uninstall", packageName)
@@ -79,25 +95,20 @@ func (d *CargoDriver) SearchPackage(packageName string) ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to search for package %s: %w", packageName, err)
 	}
+	return parseCargoSearch(output), nil
+}
 
+// parseCargoSearch parses the crate names from the output of `cargo search`, whose
+// results look like `name = "1.2.3"    # description`
+func parseCargoSearch(output string) []string {
 	var packages []string
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Cargo search output format: "packagename = "version" # description"
-		parts := strings.Split(line, " = ")
-		if len(parts) >= 2 {
-			packageName := strings.TrimSpace(parts[0])
-			packages = append(packages, packageName)
+	for _, line := range strings.Split(output, "\n") {
+		name, _, found := strings.Cut(strings.TrimSpace(line), " = ")
+		if found && name != "" && !strings.ContainsAny(name, " #") {
+			packages = append(packages, name)
 		}
 	}
-
-	return packages, nil
+	return packages
 }
 
 // GetPackageInfo gets information about an installed package
@@ -107,42 +118,16 @@ func (d *CargoDriver) GetPackageInfo(packageName string) (map[string]string, err
 		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
 	}
 
-	info := make(map[string]string)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Look for the package in the output
-		if !strings.HasPrefix(line, " ") && strings.Contains(line, " v") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				pkgName := strings.TrimSuffix(parts[0], ":")
-				if strings.EqualFold(pkgName, packageName) {
-					info["name"] = pkgName
-					version := parts[1]
-					// Remove 'v' prefix if present
-					if strings.HasPrefix(version, "v") {
-						version = version[1:]
-					}
-					// Remove trailing colon if present
-					version = strings.TrimSuffix(version, ":")
-					info["version"] = version
-					info["manager"] = "cargo"
-					break
-				}
-			}
+	for name, version := range parseCargoInstallList(output) {
+		if strings.EqualFold(name, packageName) {
+			return map[string]string{
+				"name":    name,
+				"version": version,
+				"manager": "cargo",
+			}, nil
 		}
 	}
-
-	if len(info) == 0 {
-		return nil, fmt.Errorf("package %s not found", packageName)
-	}
-
-	return info, nil
+	return nil, fmt.Errorf("package %s not found", packageName)
 }
 
 // GetAllInstalledPackages returns a map of all installed packages
@@ -0,0 +1,53 @@
+package drivers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCargoDriver_Name(t *testing.T) {
+	driver := NewCargoDriver()
+	if driver.Name() != "cargo" {
+		t.Errorf("Name() = %q, want %q", driver.Name(), "cargo")
+	}
+}
+
+func TestParseCargoInstallList(t *testing.T) {
+	output := "cargo-watch v8.5.2:\n" +
+		"    cargo-watch\n" +
+		"ripgrep v14.1.0:\n" +
+		"    rg\n" +
+		"starship v1.19.0 (https://github.com/starship/starship#a1b2c3d4):\n" +
+		"    starship\n" +
+		"tool v0.1.0 (/home/user/src/tool):\r\n" +
+		"    tool\r\n"
+
+	got := parseCargoInstallList(output)
+	want := map[string]string{
+		"cargo-watch": "8.5.2",
+		"ripgrep":     "14.1.0",
+		"starship":    "1.19.0",
+		"tool":        "0.1.0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCargoInstallList() = %v, want %v", got, want)
+	}
+
+	if got := parseCargoInstallList(""); len(got) != 0 {
+		t.Errorf("expected no crates for empty output, got %v", got)
+	}
+}
+
+func TestParseCargoSearch(t *testing.T) {
+	output := `ripgrep = "14.1.0"           # ripgrep is a line-oriented search tool
+ripgrep_all = "0.10.6"      # rga: ripgrep, but also search in PDFs
+grep-cli = "0.1.10"         # Utilities for search oriented command line applications.
+... and 120 crates more (use --limit N to see more)
+note: to learn more about a package, run ` + "`cargo info <name>`"
+
+	got := parseCargoSearch(output)
+	want := []string{"ripgrep", "ripgrep_all", "grep-cli"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCargoSearch() = %v, want %v", got, want)
+	}
+}
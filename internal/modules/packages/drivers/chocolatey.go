@@ -1,71 +1,148 @@
 package drivers
 
 import (
+	"errors"
 	"fmt"
-	"os/exec"
+	"os"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// errChocolateyNotElevated is returned for installs that need an elevated shell
+var errChocolateyNotElevated = errors.New("Chocolatey is installed for all users and needs an elevated shell to change packages: run dotfiles from a terminal opened as Administrator, or set settings.sudo_command to an installed sudo such as gsudo")
+
 // ChocolateyDriver implements PackageDriver for Chocolatey package manager
 type ChocolateyDriver struct {
 	*BaseDriver
+	elevated func() bool         // Whether the process runs in an elevated shell
+	getenv   func(string) string // Looks up where Chocolatey is installed
+
+	listArgsOnce sync.Once
+	listArgs     []string // Arguments listing the installed packages, detected once
 }
 
 // NewChocolateyDriver creates a new Chocolatey driver
 func NewChocolateyDriver() *ChocolateyDriver {
 	return &ChocolateyDriver{
 		BaseDriver: NewBaseDriver("chocolatey", "choco"),
+		elevated:   processElevated,
+		getenv:     os.Getenv,
 	}
 }
 
 // RunCommand executes a command with elevated permissions for Chocolatey when needed
 func (d *ChocolateyDriver) RunCommand(args ...string) (string, error) {
-	// Only use elevation for operations that require it
-	if len(args) > 0 {
-		needsElevation := false
-		switch args[0] {
-		case "install", "upgrade", "uninstall":
-			needsElevation = true
-		case "list", "search", "info":
-			needsElevation = false
-		default:
-			needsElevation = false
+	// Only operations that change packages need elevation
+	if len(args) == 0 || !slices.Contains([]string{"install", "upgrade", "uninstall"}, args[0]) {
+		return d.BaseDriver.RunCommand(args...)
+	}
+
+	// Chocolatey installed for the current user changes packages without elevation
+	if d.machineWide() && !d.elevated() {
+		// Check if sudo (or the configured sudo_command) is available (Windows 11+ or gsudo)
+		if !d.Privilege().CanEscalate() {
+			return "", errChocolateyNotElevated
 		}
+		output, err := d.RunPrivilegedCommand("choco", args...)
+		return output, chocolateyElevationError(output, err)
+	}
+
+	// Add flags to bypass confirmation prompts if not already present
+	enhancedArgs := make([]string, len(args))
+	copy(enhancedArgs, args)
+	switch args[0] {
+	case "install", "upgrade":
+		enhancedArgs = addFlagIfNotPresent(enhancedArgs, "--force")
+		enhancedArgs = addFlagIfNotPresent(enhancedArgs, "--accept-license")
+	case "uninstall":
+		enhancedArgs = addFlagIfNotPresent(enhancedArgs, "--force")
+	}
+
+	output, err := d.RunExternalCommand("choco", enhancedArgs...)
+	return output, chocolateyElevationError(output, err)
+}
 
-		if needsElevation {
-			// Check if sudo is available (Windows 11+ or via WSL)
-			if _, err := exec.LookPath("sudo"); err == nil {
-				// Use sudo to run chocolatey with elevation
-				sudoArgs := append([]string{"choco"}, args...)
-				cmd := exec.Command("sudo", sudoArgs...)
-				output, err := cmd.CombinedOutput()
-				return strings.TrimSpace(string(output)), err
-			} else {
-				// Fallback: enhance args to handle UAC and permission issues
-				enhancedArgs := make([]string, len(args))
-				copy(enhancedArgs, args)
-
-				// Add force flags for install/uninstall operations to bypass prompts
-				switch args[0] {
-				case "install", "upgrade":
-					// Add flags to bypass confirmation prompts if not already present
-					enhancedArgs = addFlagIfNotPresent(enhancedArgs, "--force")
-					enhancedArgs = addFlagIfNotPresent(enhancedArgs, "--accept-license")
-				case "uninstall":
-					// Add flags to bypass confirmation prompts if not already present
-					enhancedArgs = addFlagIfNotPresent(enhancedArgs, "--force")
-				}
-
-				cmd := exec.Command("choco", enhancedArgs...)
-				output, err := cmd.CombinedOutput()
-				return strings.TrimSpace(string(output)), err
-			}
+// machineWide reports whether Chocolatey is installed for all users, in ProgramData
+// by default, rather than in the profile of the current user
+func (d *ChocolateyDriver) machineWide() bool {
+	root := normalizeWindowsPath(d.getenv("ChocolateyInstall"))
+	if root == "" {
+		return true
+	}
+	for _, env := range []string{"USERPROFILE", "LOCALAPPDATA"} {
+		if dir := normalizeWindowsPath(d.getenv(env)); dir != "" && strings.HasPrefix(root+`\`, dir+`\`) {
+			return false
 		}
 	}
+	return true
+}
+
+// normalizeWindowsPath makes Windows paths comparable: lower case, with backslashes
+// and without a trailing separator
+func normalizeWindowsPath(path string) string {
+	return strings.TrimRight(strings.ToLower(strings.ReplaceAll(path, "/", `\`)), `\`)
+}
+
+// chocolateyElevationError replaces the access denied output of Chocolatey run
+// without elevation with errChocolateyNotElevated
+func chocolateyElevationError(output string, err error) error {
+	if err == nil {
+		return nil
+	}
+	lower := strings.ToLower(output)
+	if strings.Contains(lower, "not running from an elevated command shell") ||
+		(strings.Contains(lower, "access to the path") && strings.Contains(lower, "is denied")) {
+		return errChocolateyNotElevated
+	}
+	return err
+}
+
+// chocolateyFailure describes a failed Chocolatey command, leaving out the output
+// when it was only about missing elevation
+func chocolateyFailure(operation, output string, err error) error {
+	if errors.Is(err, errChocolateyNotElevated) {
+		return fmt.Errorf("failed to %s via Chocolatey: %w", operation, err)
+	}
+	return fmt.Errorf("failed to %s via Chocolatey: %w\nOutput: %s", operation, err, output)
+}
+
+// installedListArgs returns the arguments listing the installed packages one per
+// line. Chocolatey 2 only lists installed packages and removed --local-only, which
+// older versions need to not search the sources, so the version is detected once.
+func (d *ChocolateyDriver) installedListArgs() []string {
+	d.listArgsOnce.Do(func() {
+		version, _ := d.BaseDriver.RunCommand("--version")
+		d.listArgs = chocolateyListArgs(version)
+	})
+	return d.listArgs
+}
+
+// chocolateyListArgs returns the arguments listing the installed packages for the
+// output of choco --version, assuming Chocolatey 2 when it is unknown
+func chocolateyListArgs(version string) []string {
+	major, _, _ := strings.Cut(strings.TrimSpace(version), ".")
+	if n, err := strconv.Atoi(major); err == nil && n < 2 {
+		return []string{"list", "--local-only", "--limit-output"}
+	}
+	return []string{"list", "--limit-output"}
+}
 
-	// For read-only operations, use the base implementation
-	return d.BaseDriver.RunCommand(args...)
+// parseChocolateyList parses the "name|version" lines of choco list --limit-output
+// into the installed packages, by name and by lower case name
+func parseChocolateyList(output string) map[string]string {
+	packages := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, version, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			continue
+		}
+		packages[name] = version
+		packages[strings.ToLower(name)] = version
+	}
+	return packages
 }
 
 // addFlagIfNotPresent adds a flag to args if it's not already present
@@ -80,60 +157,20 @@ func addFlagIfNotPresent(args []string, flag string) []string {
 
 // IsPackageInstalled checks if a package is installed via Chocolatey
 func (d *ChocolateyDriver) IsPackageInstalled(packageName string) (bool, error) {
-	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+	return d.IsPackageInstalledCached(strings.ToLower(packageName), d.fetchAllInstalledPackages)
 }
 
-// fetchAllInstalledPackages fetches all installed packages from Chocolatey
+// fetchAllInstalledPackages lists all installed packages with a single choco call
 func (d *ChocolateyDriver) fetchAllInstalledPackages() (map[string]bool, error) {
-	output, err := d.RunCommand("list")
+	output, err := d.RunCommand(d.installedListArgs()...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list installed packages: %w", err)
 	}
 
 	packages := make(map[string]bool)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Skip header lines and validation output
-		if strings.Contains(line, "Chocolatey v") {
-			continue
-		}
-		if strings.Contains(line, "validations performed") {
-			continue
-		}
-		if strings.Contains(line, "Validation Warnings:") {
-			continue
-		}
-		if strings.HasPrefix(line, " - ") {
-			continue // Skip validation warning details
-		}
-
-		// Check if we've reached the package list section
-		// After validation messages, actual packages are listed
-		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			// Verify this looks like a package line (name + version)
-			packageName := parts[0]
-			version := parts[1]
-
-			// Skip if it looks like a summary line
-			if strings.Contains(line, "packages installed") {
-				continue
-			}
-
-			// Version should look like a version number (contains dots or numbers)
-			if strings.Contains(version, ".") || strings.ContainsAny(version, "0123456789") {
-				packages[packageName] = true
-				packages[strings.ToLower(packageName)] = true
-			}
-		}
+	for name := range parseChocolateyList(output) {
+		packages[name] = true
 	}
-
 	return packages, nil
 }
 
@@ -141,16 +178,30 @@ func (d *ChocolateyDriver) fetchAllInstalledPackages() (map[string]bool, error)
 func (d *ChocolateyDriver) InstallPackage(packageName string) error {
 	output, err := d.RunCommand("install", packageName, "-y", "--no-progress")
 	if err != nil {
-		return fmt.Errorf("failed to install package %s via Chocolatey: %w\nOutput: %s", packageName, err, output)
+		return chocolateyFailure("install package "+packageName, output, err)
 	}
 	return nil
 }
 
+// InstallPackageVersion installs a specific package version using Chocolatey
+func (d *ChocolateyDriver) InstallPackageVersion(packageName, version string) error {
+	output, err := d.RunCommand("install", packageName, "--version", version, "--allow-downgrade", "-y", "--no-progress")
+	if err != nil {
+		return chocolateyFailure(fmt.Sprintf("install package %s %s", packageName, version), output, err)
+	}
+	return nil
+}
+
+// SupportsVersionPinning reports that Chocolatey can install specific versions
+func (d *ChocolateyDriver) SupportsVersionPinning() bool {
+	return true
+}
+
 // UninstallPackage uninstalls a package using Chocolatey
 func (d *ChocolateyDriver) UninstallPackage(packageName string) error {
 	output, err := d.RunCommand("uninstall", packageName, "-y")
 	if err != nil {
-		return fmt.Errorf("failed to uninstall package %s via Chocolatey: %w\nOutput: %s", packageName, err, output)
+		return chocolateyFailure("uninstall package "+packageName, output, err)
 	}
 	return nil
 }
@@ -182,41 +233,28 @@ func (d *ChocolateyDriver) SearchPackage(packageName string) ([]string, error) {
 
 // GetPackageInfo gets information about an installed package
 func (d *ChocolateyDriver) GetPackageInfo(packageName string) (map[string]string, error) {
-	output, err := d.RunCommand("list", "--local-only", packageName, "--exact")
+	args := append(slices.Clone(d.installedListArgs()), packageName, "--exact")
+	output, err := d.RunCommand(args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
 	}
 
-	info := make(map[string]string)
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Look for the package in the output
-		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			if strings.EqualFold(parts[0], packageName) {
-				info["name"] = parts[0]
-				info["version"] = parts[1]
-				info["manager"] = "chocolatey"
-				break
-			}
+	for name, version := range parseChocolateyList(output) {
+		if strings.EqualFold(name, packageName) {
+			return map[string]string{
+				"name":    name,
+				"version": version,
+				"manager": "chocolatey",
+			}, nil
 		}
 	}
-
-	if len(info) == 0 {
-		return nil, fmt.Errorf("package %s not found", packageName)
-	}
-
-	return info, nil
+	return nil, fmt.Errorf("package %s not found", packageName)
 }
 
-// GetAllInstalledPackages returns a map of all installed packages
+// GetAllInstalledPackages returns a map of all installed packages, from the cache
+// IsPackageInstalled fills when it is valid
 func (d *ChocolateyDriver) GetAllInstalledPackages() (map[string]bool, error) {
-	return d.fetchAllInstalledPackages()
+	return d.GetAllInstalledPackagesCached(d.fetchAllInstalledPackages)
 }
 
 // IsAvailable overrides the base implementation to check platform compatibility
@@ -0,0 +1,100 @@
+package drivers
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestChocolateyListArgs(t *testing.T) {
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{"1.4.0", []string{"list", "--local-only", "--limit-output"}},
+		{"0.12.1\n", []string{"list", "--local-only", "--limit-output"}},
+		{"2.2.2", []string{"list", "--limit-output"}},
+		{"", []string{"list", "--limit-output"}},
+	}
+
+	for _, tt := range tests {
+		if got := chocolateyListArgs(tt.version); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("chocolateyListArgs(%q) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseChocolateyList(t *testing.T) {
+	output := `Chocolatey v2.2.2
+chocolatey|2.2.2
+Git|2.43.0
+7zip.install|23.1.0
+Validation Warnings:
+ - A pending system reboot request has been detected|
+`
+	want := map[string]string{
+		"chocolatey":   "2.2.2",
+		"Git":          "2.43.0",
+		"git":          "2.43.0",
+		"7zip.install": "23.1.0",
+	}
+	if got := parseChocolateyList(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChocolateyList() = %v, want %v", got, want)
+	}
+}
+
+func TestChocolateyMachineWide(t *testing.T) {
+	env := map[string]string{
+		"USERPROFILE":  `C:\Users\menno`,
+		"LOCALAPPDATA": `C:\Users\menno\AppData\Local`,
+	}
+	d := NewChocolateyDriver()
+	d.getenv = func(key string) string { return env[key] }
+
+	tests := []struct {
+		install string
+		want    bool
+	}{
+		{"", true},
+		{`C:\ProgramData\chocolatey`, true},
+		{`c:\users\menno\chocolatey\`, false},
+		{`C:/Users/menno/AppData/Local/chocolatey`, false},
+		{`C:\Users\mennov\chocolatey`, true},
+	}
+
+	for _, tt := range tests {
+		env["ChocolateyInstall"] = tt.install
+		if got := d.machineWide(); got != tt.want {
+			t.Errorf("machineWide() with ChocolateyInstall %q = %v, want %v", tt.install, got, tt.want)
+		}
+	}
+}
+
+func TestChocolateyElevation(t *testing.T) {
+	d := NewChocolateyDriver()
+	d.getenv = func(string) string { return "" }
+	d.elevated = func() bool { return false }
+	d.SetPrivilege(&Privilege{
+		command:  "sudo",
+		geteuid:  func() int { return 1000 },
+		lookPath: func(string) (string, error) { return "", exec.ErrNotFound },
+	})
+
+	err := d.InstallPackage("git")
+	if !errors.Is(err, errChocolateyNotElevated) {
+		t.Fatalf("InstallPackage() error = %v, want errChocolateyNotElevated", err)
+	}
+	if want := "failed to install package git via Chocolatey: " + errChocolateyNotElevated.Error(); err.Error() != want {
+		t.Errorf("InstallPackage() error = %q, want %q", err, want)
+	}
+
+	denied := "Chocolatey v2.2.2\nAccess to the path 'C:\\ProgramData\\chocolatey\\lib-bad' is denied."
+	if err := chocolateyElevationError(denied, errors.New("exit status 1")); !errors.Is(err, errChocolateyNotElevated) {
+		t.Errorf("chocolateyElevationError() = %v, want errChocolateyNotElevated", err)
+	}
+	other := errors.New("exit status 1")
+	if err := chocolateyElevationError("The package was not found with the source(s) listed.", other); err != other {
+		t.Errorf("chocolateyElevationError() = %v, want the original error", err)
+	}
+}
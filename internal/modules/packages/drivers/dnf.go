@@ -1,210 +1,70 @@
 package drivers
 
 import (
-	"fmt"
-	"os/exec"
-	"runtime"
 	"strings"
+	"sync"
 )
 
 // DnfDriver implements PackageDriver for DNF package manager (Fedora)
 type DnfDriver struct {
-	*BaseDriver
+	*rpmDriver
+
+	versionOnce sync.Once
+	dnf5        bool
 }
 
 // NewDnfDriver creates a new DNF driver
 func NewDnfDriver() *DnfDriver {
 	return &DnfDriver{
-		BaseDriver: NewBaseDriver("dnf", "dnf"),
+		rpmDriver: newRPMDriver("dnf", "DNF", "--installed"),
 	}
 }
 
-// IsPackageInstalled checks if a package is installed via DNF
-func (d *DnfDriver) IsPackageInstalled(packageName string) (bool, error) {
-	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+// isDnf5 reports whether dnf is dnf5, whose config-manager takes subcommands
+func (d *DnfDriver) isDnf5() bool {
+	d.versionOnce.Do(func() {
+		output, err := d.RunCommand("--version")
+		d.dnf5 = err == nil && isDnf5Version(output)
+	})
+	return d.dnf5
 }
 
-// fetchAllInstalledPackages fetches all installed packages from DNF
-func (d *DnfDriver) fetchAllInstalledPackages() (map[string]bool, error) {
-	output, err := d.RunCommand("list", "installed")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list installed packages: %w", err)
-	}
-
-	packages := make(map[string]bool)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Skip header lines and error messages
-		if strings.Contains(line, "Installed Packages") || strings.Contains(line, "Error:") {
-			continue
-		}
-
-		// DNF output format: "packagename.arch version repository"
-		parts := strings.Fields(line)
-		if len(parts) >= 3 {
-			// Extract package name (before the dot for architecture)
-			fullName := parts[0]
-			pkgName := strings.Split(fullName, ".")[0]
-			packages[pkgName] = true
-			packages[strings.ToLower(pkgName)] = true
-		}
-	}
-
-	return packages, nil
+// isDnf5Version reports whether `dnf --version` output comes from dnf5, which
+// prints "dnf5 version 5.2.5.0" where dnf4 prints "4.21.1"
+func isDnf5Version(output string) bool {
+	output = strings.TrimSpace(output)
+	return strings.HasPrefix(output, "dnf5") || strings.HasPrefix(output, "5.")
 }
 
-// InstallPackage installs a package using DNF
-func (d *DnfDriver) InstallPackage(packageName string) error {
-	output, err := d.RunCommandWithSudo("install", "-y", packageName)
-	if err != nil {
-		return fmt.Errorf("failed to install package %s via DNF: %w\nOutput: %s", packageName, err, output)
-	}
-	return nil
-}
-
-// UninstallPackage uninstalls a package using DNF
-func (d *DnfDriver) UninstallPackage(packageName string) error {
-	output, err := d.RunCommandWithSudo("remove", "-y", packageName)
-	if err != nil {
-		return fmt.Errorf("failed to uninstall package %s via DNF: %w\nOutput: %s", packageName, err, output)
-	}
-	return nil
-}
-
-// SearchPackage searches for packages using DNF
-func (d *DnfDriver) SearchPackage(packageName string) ([]string, error) {
-	output, err := d.RunCommand("search", packageName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search for package %s: %w", packageName, err)
-	}
-
-	var packages []string
-	lines := strings.Split(output, "\n")
-	inResults := false
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Look for the results section
-		if strings.Contains(line, "========================") {
-			inResults = true
-			continue
+// EnsureRepository ensures a repository is available. "<url>" adds a .repo file,
+// "<id> <url>" adds a repository with that id and base URL and "<id>" enables an
+// existing repository. dnf4 needs the config-manager plugin from dnf-plugins-core.
+func (d *DnfDriver) EnsureRepository(repoName string) error {
+	return d.ensureRepository(repoName, func(id, url string) (string, []string) {
+		if d.isDnf5() {
+			return "dnf", dnf5RepoArgs(id, url)
 		}
-
-		// Skip informational lines
-		if !inResults || strings.Contains(line, "Search Results") || strings.Contains(line, "Name") {
-			continue
-		}
-
-		// DNF search output format: "packagename.arch : description"
-		if strings.Contains(line, " : ") {
-			parts := strings.Split(line, " : ")
-			if len(parts) >= 1 {
-				// Extract package name (before the dot for architecture)
-				fullName := strings.TrimSpace(parts[0])
-				pkgName := strings.Split(fullName, ".")[0]
-				packages = append(packages, pkgName)
-			}
-		}
-	}
-
-	return packages, nil
+		return "dnf", dnf4RepoArgs(id, url)
+	})
 }
 
-// GetPackageInfo gets information about an installed package
-func (d *DnfDriver) GetPackageInfo(packageName string) (map[string]string, error) {
-	output, err := d.RunCommand("list", "installed", packageName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
-	}
-
-	info := make(map[string]string)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Skip header lines
-		if strings.Contains(line, "Installed Packages") {
-			continue
-		}
-
-		// Check if this line contains our package
-		if strings.Contains(line, packageName) {
-			parts := strings.Fields(line)
-			if len(parts) >= 3 {
-				// Extract package name (before the dot for architecture)
-				fullName := parts[0]
-				pkgName := strings.Split(fullName, ".")[0]
-				if strings.EqualFold(pkgName, packageName) {
-					info["name"] = pkgName
-					info["version"] = parts[1]
-					info["repository"] = parts[2]
-					info["manager"] = "dnf"
-
-					// Extract architecture if present
-					if strings.Contains(fullName, ".") {
-						archParts := strings.Split(fullName, ".")
-						if len(archParts) >= 2 {
-							info["architecture"] = archParts[len(archParts)-1]
-						}
-					}
-					break
-				}
-			}
-		}
-	}
-
-	if len(info) == 0 {
-		return nil, fmt.Errorf("package %s not found", packageName)
+// dnf5RepoArgs returns the dnf5 arguments that add or enable a repository
+func dnf5RepoArgs(id, url string) []string {
+	switch {
+	case url == "":
+		return []string{"config-manager", "setopt", id + ".enabled=1"}
+	case id == "" || strings.HasSuffix(url, ".repo"):
+		return []string{"config-manager", "addrepo", "--from-repofile=" + url}
+	default:
+		return []string{"config-manager", "addrepo", "--id=" + id, "--set=baseurl=" + url}
 	}
-
-	return info, nil
 }
 
-// IsAvailable overrides the base implementation to check platform compatibility and sudo
-func (d *DnfDriver) IsAvailable() bool {
-	// DNF is only available on Linux
-	if runtime.GOOS != "linux" {
-		return false
-	}
-
-	// Check if dnf is available
-	if !d.BaseDriver.IsAvailable() {
-		return false
-	}
-
-	// Check if sudo is available (needed for install/remove operations)
-	_, err := exec.LookPath("sudo")
-	if err != nil {
-		return false
+// dnf4RepoArgs returns the dnf4 arguments that add or enable a repository. dnf4
+// derives the id of a repository added from a base URL from the URL itself.
+func dnf4RepoArgs(id, url string) []string {
+	if url == "" {
+		return []string{"config-manager", "--set-enabled", id}
 	}
-
-	return true
-}
-
-// GetAllInstalledPackages returns a map of all installed packages
-func (d *DnfDriver) GetAllInstalledPackages() (map[string]bool, error) {
-	return d.fetchAllInstalledPackages()
-}
-
-// RunCommandWithSudo executes a DNF command with sudo privileges
-func (d *DnfDriver) RunCommandWithSudo(args ...string) (string, error) {
-	// Prepend sudo to the command
-	sudoArgs := append([]string{d.executable}, args...)
-	cmd := exec.Command("sudo", sudoArgs...)
-	output, err := cmd.CombinedOutput()
-	return strings.TrimSpace(string(output)), err
+	return []string{"config-manager", "--add-repo", url}
 }
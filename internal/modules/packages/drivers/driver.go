@@ -1,9 +1,13 @@
 package drivers
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +27,13 @@ type PackageDriver interface {
 	// InstallPackage installs a package
 	InstallPackage(packageName string) error
 
+	// InstallPackageVersion installs a specific version of a package, replacing any
+	// installed version. Drivers that cannot pin versions return *ErrVersionPinUnsupported
+	InstallPackageVersion(packageName, version string) error
+
+	// SupportsVersionPinning reports whether InstallPackageVersion is implemented
+	SupportsVersionPinning() bool
+
 	// UninstallPackage uninstalls a package
 	UninstallPackage(packageName string) error
 
@@ -40,23 +51,123 @@ type PackageDriver interface {
 
 	// IsRepositoryAvailable checks if a repository/bucket/tap is already available
 	IsRepositoryAvailable(repoName string) (bool, error)
+
+	// SetContext sets the context package manager commands run under; commands
+	// are killed when it is cancelled or its deadline passes
+	SetContext(ctx context.Context)
+
+	// SetPrivilege sets how commands that need root are run
+	SetPrivilege(privilege *Privilege)
+
+	// SetOffline sets whether commands may access the network. Offline drivers do
+	// not refresh their package lists.
+	SetOffline(offline bool)
+}
+
+// CaskDriver is implemented by drivers that install desktop applications separately
+// from other packages, like Homebrew casks
+type CaskDriver interface {
+	// IsCaskInstalled checks if an application is installed as a cask
+	IsCaskInstalled(packageName string) (bool, error)
+
+	// InstallCask installs an application as a cask
+	InstallCask(packageName string) error
+
+	// UninstallCask uninstalls a cask
+	UninstallCask(packageName string) error
+}
+
+// PrivilegedDriver is implemented by drivers that run as root to install packages,
+// so missing privileges can be reported before anything is installed
+type PrivilegedDriver interface {
+	// Privilege returns how commands that need root are run
+	Privilege() *Privilege
+	// NeedsPrivilege reports whether installing and removing packages needs root
+	NeedsPrivilege() bool
+}
+
+// CachedInstaller is implemented by drivers that can install packages offline from
+// the package files they downloaded before
+type CachedInstaller interface {
+	// IsPackageCached reports whether installing a package, at version when it is
+	// not empty, needs no downloads
+	IsPackageCached(packageName, version string) (bool, error)
+}
+
+// ErrOffline is returned when installing a package needs downloads in offline mode
+type ErrOffline struct {
+	Manager string
+	Package string
+}
+
+// Error implements the error interface
+func (e *ErrOffline) Error() string {
+	return fmt.Sprintf("offline mode: installing %s with %s needs downloads", e.Package, e.Manager)
+}
+
+// CheckOfflineInstall returns *ErrOffline unless the driver can install the package
+// from its cache, for installing it in offline mode
+func CheckOfflineInstall(driver PackageDriver, packageName, version string) error {
+	if cached, ok := driver.(CachedInstaller); ok {
+		if isCached, err := cached.IsPackageCached(packageName, version); err == nil && isCached {
+			return nil
+		}
+	}
+	return &ErrOffline{Manager: driver.Name(), Package: packageName}
+}
+
+// ErrVersionPinUnsupported is returned when a driver cannot install a specific package version
+type ErrVersionPinUnsupported struct {
+	Manager string
+}
+
+// Error implements the error interface
+func (e *ErrVersionPinUnsupported) Error() string {
+	return fmt.Sprintf("version pinning not supported by %s", e.Manager)
 }
 
+// commandWaitDelay is how long a killed command's output is still read
+const commandWaitDelay = 2 * time.Second
+
 // BaseDriver provides common functionality for all package drivers
 type BaseDriver struct {
 	name       string
 	executable string
 	cache      *PackageCache
+	ctx        context.Context
+	ctxMutex   sync.RWMutex
+	privilege  *Privilege
+	offline    bool
+}
+
+// CacheInvalidator is implemented by drivers that cache the installed packages, so
+// the packages module can make them list the packages again after installing or
+// removing one
+type CacheInvalidator interface {
+	// InvalidateCache drops the cached packages
+	InvalidateCache()
 }
 
-// PackageCache manages cached package information
+// PackageCache manages cached package information. Callers asking for the packages
+// while they are being listed wait for that listing instead of starting another,
+// some package managers like winget cannot list them twice at the same time.
 type PackageCache struct {
 	installedPackages map[string]bool
 	lastUpdated       time.Time
 	cacheDuration     time.Duration
+	generation        int           // Incremented on invalidation, so listings started before are not cached
+	inflight          *packageFetch // Listing in progress, nil when none is
 	mutex             sync.RWMutex
 }
 
+// packageFetch is a listing of the installed packages that callers share
+type packageFetch struct {
+	done       chan struct{} // Closed when the listing finished
+	generation int           // Generation of the cache the listing started in
+	packages   map[string]bool
+	err        error
+}
+
 // NewPackageCache creates a new package cache
 func NewPackageCache(duration time.Duration) *PackageCache {
 	return &PackageCache{
@@ -69,6 +180,11 @@ func NewPackageCache(duration time.Duration) *PackageCache {
 func (c *PackageCache) IsValid() bool {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
+	return c.valid()
+}
+
+// valid reports whether the cache is still valid, with the mutex held
+func (c *PackageCache) valid() bool {
 	return time.Since(c.lastUpdated) < c.cacheDuration
 }
 
@@ -80,6 +196,27 @@ func (c *PackageCache) GetPackage(packageName string) (bool, bool) {
 	return installed, exists
 }
 
+// lookup returns whether a package is installed, valid is false when the cache is
+// not valid
+func (c *PackageCache) lookup(packageName string) (installed, valid bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if !c.valid() {
+		return false, false
+	}
+	return c.installedPackages[packageName], true
+}
+
+// GetPackages returns a copy of every cached package, false when the cache is not valid
+func (c *PackageCache) GetPackages() (map[string]bool, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if !c.valid() {
+		return nil, false
+	}
+	return copyPackages(c.installedPackages), true
+}
+
 // SetPackages updates the entire package cache
 func (c *PackageCache) SetPackages(packages map[string]bool) {
 	c.mutex.Lock()
@@ -88,12 +225,80 @@ func (c *PackageCache) SetPackages(packages map[string]bool) {
 	c.lastUpdated = time.Now()
 }
 
-// InvalidateCache clears the cache
+// InvalidateCache clears the cache. A listing in progress is not cached when it
+// finishes, it may have started before the packages changed. It is not abandoned
+// either: callers arriving now wait for it and then list the packages once more,
+// so two listings never run at the same time.
 func (c *PackageCache) InvalidateCache() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	c.installedPackages = make(map[string]bool)
 	c.lastUpdated = time.Time{}
+	c.generation++
+}
+
+// Fetch returns a copy of every installed package, listing them with
+// fetchAllPackages when the cache is not valid. Concurrent callers share one listing.
+func (c *PackageCache) Fetch(fetchAllPackages func() (map[string]bool, error)) (map[string]bool, error) {
+	for retried := false; ; retried = true {
+		c.mutex.Lock()
+		if c.valid() {
+			packages := copyPackages(c.installedPackages)
+			c.mutex.Unlock()
+			return packages, nil
+		}
+
+		generation := c.generation
+		fetch := c.inflight
+		if fetch == nil {
+			fetch = &packageFetch{done: make(chan struct{}), generation: generation}
+			c.inflight = fetch
+			c.mutex.Unlock()
+			c.runFetch(fetch, fetchAllPackages)
+		} else {
+			c.mutex.Unlock()
+			<-fetch.done
+		}
+
+		// A listing that started before the cache was invalidated may miss what
+		// changed since this caller arrived, it lists the packages again once
+		if fetch.generation != generation && !retried {
+			continue
+		}
+		if fetch.err != nil {
+			return nil, fetch.err
+		}
+		return copyPackages(fetch.packages), nil
+	}
+}
+
+// runFetch lists the installed packages for the callers sharing fetch and caches
+// them, unless the cache was invalidated since the listing started
+func (c *PackageCache) runFetch(fetch *packageFetch, fetchAllPackages func() (map[string]bool, error)) {
+	defer close(fetch.done)
+	defer func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.inflight == fetch {
+			c.inflight = nil
+		}
+		if fetch.err == nil && fetch.packages != nil && c.generation == fetch.generation {
+			c.installedPackages = fetch.packages
+			c.lastUpdated = time.Now()
+		}
+	}()
+	// Callers sharing a listing that panics get an error rather than no packages
+	fetch.err = fmt.Errorf("listing installed packages did not finish")
+	fetch.packages, fetch.err = fetchAllPackages()
+}
+
+// copyPackages returns a copy of packages, so callers cannot change the cache
+func copyPackages(packages map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(packages))
+	for name, installed := range packages {
+		copied[name] = installed
+	}
+	return copied
 }
 
 // NewBaseDriver creates a new base driver
@@ -116,17 +321,121 @@ func (d *BaseDriver) IsAvailable() bool {
 	return err == nil
 }
 
+// SetContext sets the context package manager commands run under
+func (d *BaseDriver) SetContext(ctx context.Context) {
+	d.ctxMutex.Lock()
+	defer d.ctxMutex.Unlock()
+	d.ctx = ctx
+}
+
+// Context returns the context package manager commands run under
+func (d *BaseDriver) Context() context.Context {
+	d.ctxMutex.RLock()
+	defer d.ctxMutex.RUnlock()
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// SetPrivilege sets how commands that need root are run
+func (d *BaseDriver) SetPrivilege(privilege *Privilege) {
+	d.ctxMutex.Lock()
+	defer d.ctxMutex.Unlock()
+	d.privilege = privilege
+}
+
+// SetOffline sets whether package manager commands may access the network
+func (d *BaseDriver) SetOffline(offline bool) {
+	d.ctxMutex.Lock()
+	defer d.ctxMutex.Unlock()
+	d.offline = offline
+}
+
+// Offline reports whether package manager commands may not access the network
+func (d *BaseDriver) Offline() bool {
+	d.ctxMutex.RLock()
+	defer d.ctxMutex.RUnlock()
+	return d.offline
+}
+
+// Privilege returns how commands that need root are run, defaulting to sudo
+func (d *BaseDriver) Privilege() *Privilege {
+	d.ctxMutex.RLock()
+	defer d.ctxMutex.RUnlock()
+	if d.privilege == nil {
+		return NewPrivilege("")
+	}
+	return d.privilege
+}
+
+// RunPrivileged executes the package manager with root privileges
+func (d *BaseDriver) RunPrivileged(args ...string) (string, error) {
+	return d.RunPrivilegedCommand(d.executable, args...)
+}
+
+// RunPrivilegedCommand executes any command with root privileges
+func (d *BaseDriver) RunPrivilegedCommand(name string, args ...string) (string, error) {
+	name, args = d.Privilege().Wrap(name, args...)
+	return d.RunExternalCommand(name, args...)
+}
+
 // RunCommand executes a command and returns the output
 func (d *BaseDriver) RunCommand(args ...string) (string, error) {
-	cmd := exec.Command(d.executable, args...)
+	return d.RunExternalCommand(d.executable, args...)
+}
+
+// RunExternalCommand executes any command (e.g. sudo) under the driver's context
+// and returns the combined output
+func (d *BaseDriver) RunExternalCommand(name string, args ...string) (string, error) {
+	cmd := d.Command(name, args...)
+	start := time.Now()
 	output, err := cmd.CombinedOutput()
-	return strings.TrimSpace(string(output)), err
+	return strings.TrimSpace(string(output)), d.CommandError(cmd, start, err)
+}
+
+// RunCommandStreaming executes the package manager with its output going to the
+// user as it runs, for commands that take minutes such as builds from source
+func (d *BaseDriver) RunCommandStreaming(args ...string) error {
+	cmd := d.Command(d.executable, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	start := time.Now()
+	return d.CommandError(cmd, start, cmd.Run())
 }
 
 // RunCommandQuiet executes a command and only returns success/failure
 func (d *BaseDriver) RunCommandQuiet(args ...string) error {
-	cmd := exec.Command(d.executable, args...)
-	return cmd.Run()
+	cmd := d.Command(d.executable, args...)
+	start := time.Now()
+	return d.CommandError(cmd, start, cmd.Run())
+}
+
+// Command creates a command bound to the driver's context
+func (d *BaseDriver) Command(name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(d.Context(), name, args...)
+	// Don't wait forever for children (e.g. of sudo) that keep the output open
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+// CommandError explains errors of commands that were stopped by the driver's
+// context, naming the command and how long it ran
+func (d *BaseDriver) CommandError(cmd *exec.Cmd, start time.Time, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	commandLine := strings.Join(cmd.Args, " ")
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	switch ctxErr := d.Context().Err(); ctxErr {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("command '%s' timed out after %s: %w", commandLine, elapsed, ctxErr)
+	case context.Canceled:
+		return fmt.Errorf("command '%s' was cancelled after %s: %w", commandLine, elapsed, ctxErr)
+	}
+	return err
 }
 
 // CheckCommandSuccess runs a command and returns true if it succeeds
@@ -139,27 +448,31 @@ func (d *BaseDriver) GetCache() *PackageCache {
 	return d.cache
 }
 
-// IsPackageInstalledCached checks if a package is installed using cache when possible
+// InvalidateCache drops the cached installed packages, so they are listed again the
+// next time a package is checked
+func (d *BaseDriver) InvalidateCache() {
+	d.cache.InvalidateCache()
+}
+
+// IsPackageInstalledCached checks if a package is installed using cache when possible.
+// The cache holds every installed package, so packages missing from a valid cache
+// are not installed and do not list the packages again.
 func (d *BaseDriver) IsPackageInstalledCached(packageName string, fetchAllPackages func() (map[string]bool, error)) (bool, error) {
-	// Check cache first
-	if d.cache.IsValid() {
-		if installed, exists := d.cache.GetPackage(packageName); exists {
-			return installed, nil
-		}
+	if installed, valid := d.cache.lookup(packageName); valid {
+		return installed, nil
 	}
 
-	// Cache miss or invalid - fetch all packages
-	packages, err := fetchAllPackages()
+	packages, err := d.GetAllInstalledPackagesCached(fetchAllPackages)
 	if err != nil {
 		return false, err
 	}
+	return packages[packageName], nil
+}
 
-	// Update cache
-	d.cache.SetPackages(packages)
-
-	// Return result for requested package
-	installed, exists := packages[packageName]
-	return exists && installed, nil
+// GetAllInstalledPackagesCached returns every installed package, listing them with
+// fetchAllPackages only when the cache is not valid
+func (d *BaseDriver) GetAllInstalledPackagesCached(fetchAllPackages func() (map[string]bool, error)) (map[string]bool, error) {
+	return d.cache.Fetch(fetchAllPackages)
 }
 
 // EnsureRepository provides a default implementation that does nothing
@@ -176,10 +489,23 @@ func (d *BaseDriver) IsRepositoryAvailable(repoName string) (bool, error) {
 	return false, fmt.Errorf("repository management not supported by %s driver", d.name)
 }
 
+// InstallPackageVersion provides a default implementation for drivers without version pinning
+// Package drivers should override this together with SupportsVersionPinning
+func (d *BaseDriver) InstallPackageVersion(packageName, version string) error {
+	return &ErrVersionPinUnsupported{Manager: d.name}
+}
+
+// SupportsVersionPinning provides a default implementation that returns false
+func (d *BaseDriver) SupportsVersionPinning() bool {
+	return false
+}
+
 // DriverRegistry manages available package drivers
 type DriverRegistry struct {
 	drivers map[string]PackageDriver
 	aliases map[string]string // maps alias names to driver names
+	prefer  []string          // drivers picked before the platform order, from settings.package_managers
+	exclude []string          // drivers never picked unless a task lists them in only
 }
 
 // NewDriverRegistry creates a new driver registry
@@ -199,6 +525,9 @@ func NewDriverRegistry() *DriverRegistry {
 	registry.RegisterDriver(NewDnfDriver())
 	registry.RegisterDriver(NewBrewDriver())
 	registry.RegisterDriver(NewCargoDriver())
+	registry.RegisterDriver(NewPipxDriver())
+	registry.RegisterDriver(NewNpmDriver())
+	registry.RegisterDriver(NewFlatpakDriver())
 
 	// Register common aliases
 	registry.RegisterAlias("choco", "chocolatey")
@@ -213,6 +542,57 @@ func (r *DriverRegistry) RegisterDriver(driver PackageDriver) {
 	r.drivers[driver.Name()] = driver
 }
 
+// SetContext sets the context commands of every registered driver run under
+func (r *DriverRegistry) SetContext(ctx context.Context) {
+	for _, driver := range r.drivers {
+		driver.SetContext(ctx)
+	}
+}
+
+// SetPrivilege sets how every registered driver runs commands that need root
+func (r *DriverRegistry) SetPrivilege(privilege *Privilege) {
+	for _, driver := range r.drivers {
+		driver.SetPrivilege(privilege)
+	}
+}
+
+// SetOffline sets whether every registered driver may access the network
+func (r *DriverRegistry) SetOffline(offline bool) {
+	for _, driver := range r.drivers {
+		driver.SetOffline(offline)
+	}
+}
+
+// SetPreferences sets the global package manager preferences: drivers in prefer are
+// picked before the platform order and drivers in exclude are not picked at all,
+// unless a task lists them in only. Names may be aliases.
+func (r *DriverRegistry) SetPreferences(prefer, exclude []string) {
+	r.prefer = r.canonicalNames(prefer)
+	r.exclude = r.canonicalNames(exclude)
+}
+
+// canonicalNames resolves aliases in a list of driver names
+func (r *DriverRegistry) canonicalNames(names []string) []string {
+	var canonical []string
+	for _, name := range names {
+		if driverName, isAlias := r.aliases[name]; isAlias {
+			name = driverName
+		}
+		canonical = append(canonical, name)
+	}
+	return canonical
+}
+
+// IsExcluded reports whether a driver is excluded by the global preferences
+func (r *DriverRegistry) IsExcluded(name string) bool {
+	return slices.Contains(r.exclude, r.canonicalNames([]string{name})[0])
+}
+
+// ExcludedDrivers returns the names of the drivers excluded by the global preferences
+func (r *DriverRegistry) ExcludedDrivers() []string {
+	return r.exclude
+}
+
 // RegisterAlias registers an alias for a driver name
 func (r *DriverRegistry) RegisterAlias(alias, driverName string) {
 	r.aliases[alias] = driverName
@@ -237,7 +617,9 @@ func (r *DriverRegistry) GetDriver(name string) (PackageDriver, error) {
 	return nil, fmt.Errorf("driver not found: %s", name)
 }
 
-// GetAvailableDrivers returns all available drivers on the current system
+// GetAvailableDrivers returns all available drivers on the current system, in the
+// order they are picked: globally preferred drivers first, then the platform order.
+// Globally excluded drivers are left out.
 func (r *DriverRegistry) GetAvailableDrivers() []PackageDriver {
 	var available []PackageDriver
 
@@ -247,45 +629,44 @@ func (r *DriverRegistry) GetAvailableDrivers() []PackageDriver {
 	case "windows":
 		driverOrder = []string{
 			"winget", "chocolatey", "scoop", // Windows-native managers first
-			"cargo",                         // Cross-platform managers
+			"cargo", "pipx", "npm", // Cross-platform managers
 		}
 	case "darwin":
 		driverOrder = []string{
-			"homebrew",                      // macOS-native manager first
-			"cargo",                         // Cross-platform managers
+			"homebrew",             // macOS-native manager first
+			"cargo", "pipx", "npm", // Cross-platform managers
 		}
 	case "linux":
 		driverOrder = []string{
-			"apt", "apk", "dnf", "yum",     // Linux-native managers first
-			"cargo",                         // Cross-platform managers
+			"apt", "apk", "dnf", "yum", // Linux-native managers first
+			"flatpak",              // Desktop applications
+			"homebrew",             // User-space packages (linuxbrew)
+			"cargo", "pipx", "npm", // Cross-platform managers
 		}
 	default:
 		driverOrder = []string{
-			"cargo",                         // Cross-platform fallback
+			"cargo", "pipx", "npm", // Cross-platform fallback
 		}
 	}
 
-	// Add drivers in the platform-specific order
-	for _, driverName := range driverOrder {
-		if driver, exists := r.drivers[driverName]; exists && driver.IsAvailable() {
-			available = append(available, driver)
+	// Add any remaining drivers not in the order list by name, so the order does
+	// not depend on how they were registered
+	var remaining []string
+	for name := range r.drivers {
+		if !slices.Contains(driverOrder, name) {
+			remaining = append(remaining, name)
 		}
 	}
+	sort.Strings(remaining)
 
-	// Add any remaining available drivers not in the order list
-	// This ensures we don't skip any available drivers
-	for _, driver := range r.drivers {
-		if driver.IsAvailable() {
-			found := false
-			for _, existing := range available {
-				if existing.Name() == driver.Name() {
-					found = true
-					break
-				}
-			}
-			if !found {
-				available = append(available, driver)
-			}
+	order := append(append(append([]string{}, r.prefer...), driverOrder...), remaining...)
+	for _, driverName := range order {
+		driver, exists := r.drivers[driverName]
+		if !exists || !driver.IsAvailable() || slices.Contains(r.exclude, driverName) {
+			continue
+		}
+		if !slices.ContainsFunc(available, func(existing PackageDriver) bool { return existing.Name() == driverName }) {
+			available = append(available, driver)
 		}
 	}
 
@@ -301,7 +682,8 @@ func (r *DriverRegistry) GetAvailableDriverNames() []string {
 	return names
 }
 
-// GetPreferredDriver returns the most preferred available driver from a list
+// GetPreferredDriver returns the most preferred available driver from a list, which
+// takes precedence over the global preferences. Globally excluded drivers are skipped.
 func (r *DriverRegistry) GetPreferredDriver(preferences []string) (PackageDriver, error) {
 	available := r.GetAvailableDrivers()
 	if len(available) == 0 {
@@ -311,7 +693,7 @@ func (r *DriverRegistry) GetPreferredDriver(preferences []string) (PackageDriver
 	// Try preferred drivers first (supporting aliases)
 	for _, preference := range preferences {
 		driver, err := r.GetDriver(preference)
-		if err != nil {
+		if err != nil || r.IsExcluded(driver.Name()) {
 			continue
 		}
 		if driver.IsAvailable() {
@@ -323,7 +705,8 @@ func (r *DriverRegistry) GetPreferredDriver(preferences []string) (PackageDriver
 	return available[0], nil
 }
 
-// GetOnlyDriver returns a driver from the allowed list, with no fallback
+// GetOnlyDriver returns a driver from the allowed list, with no fallback. The list
+// overrides the global exclusions.
 func (r *DriverRegistry) GetOnlyDriver(allowedManagers []string) (PackageDriver, error) {
 	if len(allowedManagers) == 0 {
 		return nil, fmt.Errorf("no package managers specified in 'only' list")
@@ -1,8 +1,15 @@
 package drivers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // MockPackageDriver is a test implementation that fully mocks package driver behavior
@@ -104,8 +111,8 @@ vim 9.0.0
 	"winget": {
 		name:         "Git.Git",
 		installed:    true,
-		installCmd:   []string{"install", "--exact", "--silent", "--accept-package-agreements", "--accept-source-agreements", "Git.Git"},
-		uninstallCmd: []string{"uninstall", "--exact", "--silent", "Git.Git"},
+		installCmd:   []string{"install", "--id", "Git.Git", "--exact", "--silent", "--accept-package-agreements", "--disable-interactivity", "--accept-source-agreements"},
+		uninstallCmd: []string{"uninstall", "--id", "Git.Git", "--exact", "--silent", "--disable-interactivity"},
 		listOutput: `Name      Id        Version      Available Source
 ----------------------------------------------------
 Git       Git.Git   2.42.0.1                 winget
@@ -534,3 +541,293 @@ func BenchmarkPackageCheck(b *testing.B) {
 		_, _ = mockDriver.IsPackageInstalled("git")
 	}
 }
+
+func TestRunCommandTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	driver := NewBaseDriver("sleep", "sleep")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	driver.SetContext(ctx)
+
+	start := time.Now()
+	_, err := driver.RunCommand("10")
+	if err == nil {
+		t.Fatal("expected command to time out")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("command was not killed when the context expired (ran %s)", time.Since(start))
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error to wrap context.DeadlineExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "command 'sleep 10' timed out after") {
+		t.Errorf("expected error to name the command, got %v", err)
+	}
+
+	// Without a context commands run normally
+	driver.SetContext(nil)
+	if _, err := driver.RunCommand("0"); err != nil {
+		t.Errorf("expected command to succeed, got %v", err)
+	}
+}
+
+// TestDriverRegistryPreferences tests the global package manager preferences
+func TestDriverRegistryPreferences(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	registry := &DriverRegistry{drivers: make(map[string]PackageDriver), aliases: make(map[string]string)}
+	for _, name := range []string{"scoop", "winget", "chocolatey", "zeta", "alpha"} {
+		registry.RegisterDriver(&CargoDriver{BaseDriver: NewBaseDriver(name, "sh")})
+	}
+	registry.RegisterAlias("choco", "chocolatey")
+	names := func() string {
+		return strings.Join(registry.GetAvailableDriverNames(), ",")
+	}
+
+	t.Run("DeterministicOrder", func(t *testing.T) {
+		first := names()
+		for i := 0; i < 10; i++ {
+			if got := names(); got != first {
+				t.Fatalf("Expected the same order every time, got %s and %s", first, got)
+			}
+		}
+	})
+
+	registry.SetPreferences([]string{"scoop", "winget"}, []string{"choco"})
+
+	t.Run("PreferAndExclude", func(t *testing.T) {
+		got := registry.GetAvailableDriverNames()
+		if len(got) != 4 || got[0] != "scoop" || got[1] != "winget" {
+			t.Errorf("Expected scoop and winget first, got %v", got)
+		}
+		for _, name := range got {
+			if name == "chocolatey" {
+				t.Errorf("Expected chocolatey to be excluded, got %v", got)
+			}
+		}
+		if !registry.IsExcluded("choco") || !registry.IsExcluded("chocolatey") {
+			t.Errorf("Expected chocolatey to be excluded by its alias")
+		}
+	})
+
+	t.Run("TaskPreferenceOverrides", func(t *testing.T) {
+		driver, err := registry.GetPreferredDriver([]string{"alpha", "scoop"})
+		if err != nil || driver.Name() != "alpha" {
+			t.Errorf("Expected alpha, got %v (%v)", driver, err)
+		}
+	})
+
+	t.Run("ExcludedTaskPreferenceSkipped", func(t *testing.T) {
+		driver, err := registry.GetPreferredDriver([]string{"chocolatey"})
+		if err != nil || driver.Name() != "scoop" {
+			t.Errorf("Expected scoop, got %v (%v)", driver, err)
+		}
+	})
+
+	t.Run("OnlyOverridesExclusion", func(t *testing.T) {
+		driver, err := registry.GetOnlyDriver([]string{"choco"})
+		if err != nil || driver == nil || driver.Name() != "chocolatey" {
+			t.Errorf("Expected chocolatey, got %v (%v)", driver, err)
+		}
+	})
+}
+
+// cachingMockDriver lists its installed packages through the BaseDriver cache, like
+// the real drivers. Listings wait for release when it is set.
+type cachingMockDriver struct {
+	*BaseDriver
+	mutex     sync.Mutex
+	installed map[string]bool
+	fetches   atomic.Int32
+	running   atomic.Int32 // Listings running now
+	overlap   atomic.Bool  // Set when two listings ran at the same time
+	release   chan struct{}
+}
+
+func newCachingMockDriver() *cachingMockDriver {
+	return &cachingMockDriver{BaseDriver: NewBaseDriver("mock", "mock"), installed: make(map[string]bool)}
+}
+
+func (d *cachingMockDriver) IsPackageInstalled(packageName string) (bool, error) {
+	return d.IsPackageInstalledCached(packageName, d.fetchAllPackages)
+}
+
+// install installs a package and invalidates the cache, like the packages module
+func (d *cachingMockDriver) install(packageName string) {
+	d.mutex.Lock()
+	d.installed[packageName] = true
+	d.mutex.Unlock()
+	d.InvalidateCache()
+}
+
+func (d *cachingMockDriver) fetchAllPackages() (map[string]bool, error) {
+	d.fetches.Add(1)
+	if d.running.Add(1) > 1 {
+		d.overlap.Store(true)
+	}
+	defer d.running.Add(-1)
+	d.mutex.Lock()
+	packages := make(map[string]bool, len(d.installed))
+	for name, installed := range d.installed {
+		packages[name] = installed
+	}
+	d.mutex.Unlock()
+
+	if d.release != nil {
+		<-d.release
+	}
+	return packages, nil
+}
+
+// waitForFetches waits until a driver started n listings
+func waitForFetches(t *testing.T, d *cachingMockDriver, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.fetches.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d listings, got %d", n, d.fetches.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPackageCacheSharesFetch(t *testing.T) {
+	driver := newCachingMockDriver()
+	driver.install("git")
+	driver.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if installed, err := driver.IsPackageInstalled("git"); err != nil || !installed {
+				t.Errorf("IsPackageInstalled(git) = %v, %v", installed, err)
+			}
+		}()
+	}
+	waitForFetches(t, driver, 1)
+	close(driver.release)
+	wg.Wait()
+
+	if fetches := driver.fetches.Load(); fetches != 1 {
+		t.Errorf("expected callers to share 1 listing, got %d", fetches)
+	}
+}
+
+func TestPackageCacheInstallThenCheck(t *testing.T) {
+	driver := newCachingMockDriver()
+	driver.release = make(chan struct{})
+
+	// git is installed while a check is listing the packages
+	checked := make(chan bool)
+	go func() {
+		installed, _ := driver.IsPackageInstalled("git")
+		checked <- installed
+	}()
+	waitForFetches(t, driver, 1)
+	driver.install("git")
+	close(driver.release)
+	<-checked
+
+	// The listing from before the install is not cached
+	if installed, err := driver.IsPackageInstalled("git"); err != nil || !installed {
+		t.Errorf("expected git to be installed after installing it, got %v, %v", installed, err)
+	}
+	if fetches := driver.fetches.Load(); fetches != 2 {
+		t.Errorf("expected the packages to be listed again after the install, got %d listings", fetches)
+	}
+}
+
+func TestPackageCacheInvalidateDuringFetch(t *testing.T) {
+	driver := newCachingMockDriver()
+	driver.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		driver.IsPackageInstalled("git")
+	}()
+	waitForFetches(t, driver, 1)
+
+	// Callers arriving after the install wait for the listing in progress instead
+	// of starting another next to it, and then list the packages once more
+	driver.install("git")
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if installed, err := driver.IsPackageInstalled("git"); err != nil || !installed {
+				t.Errorf("expected git to be installed after installing it, got %v, %v", installed, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if fetches := driver.fetches.Load(); fetches != 1 {
+		t.Errorf("expected callers to wait for the listing in progress, got %d listings", fetches)
+	}
+	close(driver.release)
+	wg.Wait()
+
+	if fetches := driver.fetches.Load(); fetches > 2 {
+		t.Errorf("expected the packages to be listed at most twice, got %d listings", fetches)
+	}
+	if driver.overlap.Load() {
+		t.Error("two listings ran at the same time")
+	}
+}
+
+func TestPackageCacheConcurrentInstalls(t *testing.T) {
+	driver := newCachingMockDriver()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := driver.IsPackageInstalled("other"); err != nil {
+				t.Error(err)
+			}
+			driver.install(name)
+			if installed, err := driver.IsPackageInstalled(name); err != nil || !installed {
+				t.Errorf("expected %s to be installed after installing it, got %v, %v", name, installed, err)
+			}
+		}(fmt.Sprintf("package-%d", i))
+	}
+	wg.Wait()
+}
+
+func TestOffline(t *testing.T) {
+	apt := NewAptDriver()
+	registry := NewDriverRegistry()
+	registry.RegisterDriver(apt)
+
+	if got := strings.Join(apt.installArgs(), " "); got != "install -y" {
+		t.Errorf("installArgs() = %q, want %q", got, "install -y")
+	}
+	registry.SetOffline(true)
+	if !apt.Offline() {
+		t.Fatal("SetOffline() did not make the registered drivers offline")
+	}
+	// Offline, APT must not download the packages it installs
+	if got := strings.Join(apt.installArgs(), " "); got != "install -y --no-download" {
+		t.Errorf("installArgs() offline = %q, want %q", got, "install -y --no-download")
+	}
+	registry.SetOffline(false)
+	if apt.Offline() {
+		t.Error("SetOffline(false) left the drivers offline")
+	}
+
+	// Drivers without a cache of package files cannot install offline
+	err := CheckOfflineInstall(NewScoopDriver(), "git", "")
+	var offlineErr *ErrOffline
+	if !errors.As(err, &offlineErr) || err.Error() != "offline mode: installing git with scoop needs downloads" {
+		t.Errorf("CheckOfflineInstall() = %v, want an ErrOffline", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package drivers
+
+import "os"
+
+// processElevated reports whether the process runs as root
+func processElevated() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package drivers
+
+import "golang.org/x/sys/windows"
+
+// processElevated reports whether the process runs in an elevated (Administrator) shell
+func processElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
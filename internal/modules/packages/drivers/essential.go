@@ -0,0 +1,54 @@
+package drivers
+
+import "path/filepath"
+
+// rpmEssentialPackages are the packages an RPM based system does not boot or
+// update without
+var rpmEssentialPackages = []string{
+	"rpm", "rpm-libs", "dnf", "dnf-data", "yum", "libdnf*", "python3", "python3-libs", "python3-dnf",
+	"glibc", "glibc-common", "glibc-minimal-langpack", "filesystem", "setup", "basesystem",
+	"bash", "coreutils*", "systemd", "systemd-libs", "systemd-udev", "util-linux*", "shadow-utils",
+	"sudo", "kernel*", "grub2*", "shim*", "dracut*", "openssl-libs", "ca-certificates",
+}
+
+// essentialPackages are the packages per package manager that removing breaks the
+// system or the package manager itself. Entries may be wildcard patterns.
+var essentialPackages = map[string][]string{
+	"apt": {
+		"apt", "apt-utils", "libapt-pkg*", "dpkg", "debconf", "debianutils", "base-files", "base-passwd",
+		"bash", "dash", "coreutils", "diffutils", "findutils", "grep", "gzip", "sed", "tar", "login",
+		"passwd", "mount", "util-linux", "hostname", "init", "init-system-helpers", "sysvinit-utils",
+		"systemd", "systemd-sysv", "libsystemd*", "udev", "libc6", "libc-bin", "libgcc-s1", "libstdc++6",
+		"perl-base", "python3", "python3-minimal", "python3.*-minimal", "libpython3*-minimal",
+		"ncurses-base", "ncurses-bin", "sudo", "gpgv", "ubuntu-keyring", "debian-archive-keyring",
+		"ca-certificates", "linux-image-*", "linux-generic*", "grub*", "shim-signed",
+	},
+	"apk": {
+		"alpine-base", "alpine-baselayout*", "alpine-keys", "apk-tools", "busybox*", "musl", "musl-utils",
+		"libc-utils", "openrc", "ca-certificates*", "linux-lts", "linux-virt",
+	},
+	"dnf":        rpmEssentialPackages,
+	"yum":        rpmEssentialPackages,
+	"chocolatey": {"chocolatey", "chocolatey-core.extension"},
+	"winget":     {"Microsoft.AppInstaller", "Microsoft.UI.Xaml*", "Microsoft.VCLibs*"},
+	"npm":        {"npm", "corepack"},
+	"flatpak":    {"org.freedesktop.Platform*", "org.gnome.Platform*", "org.kde.Platform*"},
+}
+
+// EssentialPackages returns the packages of a package manager that wildcard names
+// never match, as patterns
+func EssentialPackages(manager string) []string {
+	return essentialPackages[manager]
+}
+
+// IsEssentialPackage reports whether removing a package with a package manager
+// would break the system or the package manager itself. Wildcard uninstalls skip
+// these packages; naming one explicitly still removes it.
+func IsEssentialPackage(manager, name string) bool {
+	for _, pattern := range essentialPackages[manager] {
+		if matched, err := filepath.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package drivers
+
+import "testing"
+
+func TestIsEssentialPackage(t *testing.T) {
+	tests := []struct {
+		manager, name string
+		want          bool
+	}{
+		{"apt", "dpkg", true},
+		{"apt", "python3-minimal", true},
+		{"apt", "python3.12-minimal", true},
+		{"apt", "linux-image-6.8.0-45-generic", true},
+		{"apt", "python3-pip", false},
+		{"dnf", "glibc", true},
+		{"yum", "kernel-core", true},
+		{"apk", "busybox-binsh", true},
+		{"homebrew", "git", false},
+		{"unknown", "dpkg", false},
+	}
+	for _, tt := range tests {
+		if got := IsEssentialPackage(tt.manager, tt.name); got != tt.want {
+			t.Errorf("IsEssentialPackage(%q, %q) = %v, want %v", tt.manager, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package drivers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// flatpakDefaultRemote is the remote applications are installed from
+const flatpakDefaultRemote = "flathub"
+
+// FlatpakDriver implements PackageDriver for Flatpak applications on Linux
+type FlatpakDriver struct {
+	*BaseDriver
+}
+
+// NewFlatpakDriver creates a new Flatpak driver
+func NewFlatpakDriver() *FlatpakDriver {
+	return &FlatpakDriver{
+		BaseDriver: NewBaseDriver("flatpak", "flatpak"),
+	}
+}
+
+// IsPackageInstalled checks if an application is installed via Flatpak
+func (d *FlatpakDriver) IsPackageInstalled(packageName string) (bool, error) {
+	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+}
+
+// fetchAllInstalledPackages fetches all installed application IDs from Flatpak
+func (d *FlatpakDriver) fetchAllInstalledPackages() (map[string]bool, error) {
+	output, err := d.RunCommand("list", "--app", "--columns=application")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+	return parseFlatpakList(output), nil
+}
+
+// parseFlatpakList parses the output of `flatpak list --app --columns=application`
+func parseFlatpakList(output string) map[string]bool {
+	packages := make(map[string]bool)
+	for _, appID := range parseFlatpakColumn(output) {
+		packages[appID] = true
+		packages[strings.ToLower(appID)] = true
+	}
+	return packages
+}
+
+// parseFlatpakColumn returns the application IDs of single column flatpak output
+func parseFlatpakColumn(output string) []string {
+	var appIDs []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		// Skip empty lines and the header printed when attached to a terminal
+		if line == "" || line == "Application ID" {
+			continue
+		}
+		appIDs = append(appIDs, strings.Fields(line)[0])
+	}
+	return appIDs
+}
+
+// InstallPackage installs an application from Flathub using Flatpak
+func (d *FlatpakDriver) InstallPackage(packageName string) error {
+	output, err := d.RunCommand("install", "-y", "--noninteractive", flatpakDefaultRemote, packageName)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via flatpak: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
+// UninstallPackage uninstalls an application using Flatpak
+func (d *FlatpakDriver) UninstallPackage(packageName string) error {
+	output, err := d.RunCommand("uninstall", "-y", "--noninteractive", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to uninstall package %s via flatpak: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
+// SearchPackage searches the configured remotes for applications
+func (d *FlatpakDriver) SearchPackage(packageName string) ([]string, error) {
+	output, err := d.RunCommand("search", "--columns=application", packageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for package %s: %w", packageName, err)
+	}
+
+	return parseFlatpakColumn(output), nil
+}
+
+// GetPackageInfo gets information about an installed application
+func (d *FlatpakDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	output, err := d.RunCommand("info", packageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
+	}
+
+	info := parseFlatpakInfo(output)
+	info["name"] = packageName
+	info["manager"] = "flatpak"
+	return info, nil
+}
+
+// parseFlatpakInfo parses the "Key: value" lines of `flatpak info`
+func parseFlatpakInfo(output string) map[string]string {
+	info := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found || key == "" {
+			continue
+		}
+		// "Installed size" becomes "installed_size"
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), " ", "_")
+		info[key] = strings.TrimSpace(value)
+	}
+	return info
+}
+
+// GetAllInstalledPackages returns a map of all installed applications
+func (d *FlatpakDriver) GetAllInstalledPackages() (map[string]bool, error) {
+	return d.fetchAllInstalledPackages()
+}
+
+// parseFlatpakRemote splits "name url" into the remote name and its optional URL
+func parseFlatpakRemote(repoName string) (string, string, error) {
+	fields := strings.Fields(repoName)
+	switch len(fields) {
+	case 1:
+		return fields[0], "", nil
+	case 2:
+		return fields[0], fields[1], nil
+	default:
+		return "", "", fmt.Errorf("flatpak remote must be \"<name>\" or \"<name> <url>\", got %q", repoName)
+	}
+}
+
+// EnsureRepository ensures a Flatpak remote is configured
+func (d *FlatpakDriver) EnsureRepository(repoName string) error {
+	name, url, err := parseFlatpakRemote(repoName)
+	if err != nil {
+		return err
+	}
+
+	available, err := d.IsRepositoryAvailable(name)
+	if err != nil {
+		return err
+	}
+	if available {
+		return nil
+	}
+
+	if url == "" {
+		return fmt.Errorf("flatpak remote %s is not configured and no URL was given (use \"%s <url>\")", name, name)
+	}
+
+	output, err := d.RunCommand("remote-add", "--if-not-exists", name, url)
+	if err != nil {
+		return fmt.Errorf("failed to add flatpak remote %s: %w\nOutput: %s", name, err, output)
+	}
+	return nil
+}
+
+// IsRepositoryAvailable checks if a Flatpak remote is already configured
+func (d *FlatpakDriver) IsRepositoryAvailable(repoName string) (bool, error) {
+	name, _, err := parseFlatpakRemote(repoName)
+	if err != nil {
+		return false, err
+	}
+
+	output, err := d.RunCommand("remotes", "--columns=name")
+	if err != nil {
+		return false, fmt.Errorf("failed to list flatpak remotes: %w", err)
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
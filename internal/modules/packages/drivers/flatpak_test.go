@@ -0,0 +1,88 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlatpakDriver_Name(t *testing.T) {
+	driver := NewFlatpakDriver()
+	if driver.Name() != "flatpak" {
+		t.Errorf("Name() = %q, want %q", driver.Name(), "flatpak")
+	}
+}
+
+func TestParseFlatpakList(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "flatpak_list.txt"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	packages := parseFlatpakList(string(data))
+
+	for _, appID := range []string{"com.spotify.Client", "com.spotify.client", "org.mozilla.firefox", "org.videolan.VLC"} {
+		if !packages[appID] {
+			t.Errorf("expected %s to be installed", appID)
+		}
+	}
+	if packages["Application ID"] || packages["Application"] {
+		t.Error("header line should not be parsed as a package")
+	}
+	if len(packages) != 5 {
+		t.Errorf("expected 5 entries (including lowercase aliases), got %d: %v", len(packages), packages)
+	}
+
+	// Wildcard patterns in the packages module match against these IDs
+	matched, err := filepath.Match("org.mozilla.*", "org.mozilla.firefox")
+	if err != nil || !matched {
+		t.Errorf("expected reverse-DNS ID to match wildcard pattern")
+	}
+}
+
+func TestParseFlatpakInfo(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "flatpak_info.txt"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	info := parseFlatpakInfo(string(data))
+
+	expected := map[string]string{
+		"id":      "org.mozilla.firefox",
+		"version": "126.0",
+		"origin":  "flathub",
+		"branch":  "stable",
+		"date":    "2024-05-14 10:21:07 +0000",
+	}
+	for key, want := range expected {
+		if info[key] != want {
+			t.Errorf("info[%q] = %q, want %q", key, info[key], want)
+		}
+	}
+}
+
+func TestParseFlatpakRemote(t *testing.T) {
+	tests := []struct {
+		input   string
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"flathub", "flathub", "", false},
+		{"flathub https://flathub.org/repo/flathub.flatpakrepo", "flathub", "https://flathub.org/repo/flathub.flatpakrepo", false},
+		{"", "", "", true},
+		{"a b c", "", "", true},
+	}
+
+	for _, tt := range tests {
+		name, url, err := parseFlatpakRemote(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFlatpakRemote(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if name != tt.name || url != tt.url {
+			t.Errorf("parseFlatpakRemote(%q) = (%q, %q), want (%q, %q)", tt.input, name, url, tt.name, tt.url)
+		}
+	}
+}
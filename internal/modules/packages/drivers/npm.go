@@ -0,0 +1,187 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NpmDriver implements PackageDriver for globally installed npm packages
+type NpmDriver struct {
+	*BaseDriver
+}
+
+// npmGlobalList represents the output of `npm list -g --depth=0 --json`
+type npmGlobalList struct {
+	Dependencies map[string]struct {
+		Version string `json:"version"`
+	} `json:"dependencies"`
+}
+
+// NewNpmDriver creates a new npm driver
+func NewNpmDriver() *NpmDriver {
+	return &NpmDriver{
+		BaseDriver: NewBaseDriver("npm", "npm"),
+	}
+}
+
+// IsPackageInstalled checks if a package is installed globally via npm
+func (d *NpmDriver) IsPackageInstalled(packageName string) (bool, error) {
+	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+}
+
+// fetchAllInstalledPackages fetches all globally installed packages from npm
+func (d *NpmDriver) fetchAllInstalledPackages() (map[string]bool, error) {
+	list, err := d.listGlobal()
+	if err != nil {
+		return nil, err
+	}
+
+	packages := make(map[string]bool)
+	for name := range list.Dependencies {
+		packages[name] = true
+		packages[strings.ToLower(name)] = true
+	}
+
+	return packages, nil
+}
+
+// listGlobal returns the globally installed top-level packages
+func (d *NpmDriver) listGlobal() (*npmGlobalList, error) {
+	output, err := d.runJSON("list", "-g", "--depth=0", "--json")
+	if err != nil && len(output) == 0 {
+		return nil, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+
+	// npm exits non-zero for problems like extraneous packages but still prints the list
+	list, parseErr := parseNpmGlobalList(output)
+	if parseErr != nil {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list installed packages: %w", err)
+		}
+		return nil, parseErr
+	}
+	return list, nil
+}
+
+// parseNpmGlobalList parses the JSON output of `npm list -g --json`
+func parseNpmGlobalList(data []byte) (*npmGlobalList, error) {
+	var list npmGlobalList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse npm list output: %w", err)
+	}
+	return &list, nil
+}
+
+// runJSON runs npm and returns stdout only, keeping warnings on stderr out of the JSON
+func (d *NpmDriver) runJSON(args ...string) ([]byte, error) {
+	cmd := d.Command(d.executable, args...)
+	start := time.Now()
+	output, err := cmd.Output()
+	return output, d.CommandError(cmd, start, err)
+}
+
+// InstallPackage installs a package globally using npm
+func (d *NpmDriver) InstallPackage(packageName string) error {
+	output, err := d.RunCommand("install", "-g", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via npm: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
+// InstallPackageVersion installs a specific package version globally using npm (pkg@version)
+func (d *NpmDriver) InstallPackageVersion(packageName, version string) error {
+	target := fmt.Sprintf("%s@%s", packageName, version)
+	output, err := d.RunCommand("install", "-g", target)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via npm: %w\nOutput: %s", target, err, output)
+	}
+	return nil
+}
+
+// SupportsVersionPinning reports that npm can install specific versions
+func (d *NpmDriver) SupportsVersionPinning() bool {
+	return true
+}
+
+// UninstallPackage uninstalls a global package using npm
+func (d *NpmDriver) UninstallPackage(packageName string) error {
+	output, err := d.RunCommand("uninstall", "-g", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to uninstall package %s via npm: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
+// SearchPackage searches for packages using npm
+func (d *NpmDriver) SearchPackage(packageName string) ([]string, error) {
+	output, err := d.runJSON("search", "--json", packageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for package %s: %w", packageName, err)
+	}
+
+	var results []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(output, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse npm search output: %w", err)
+	}
+
+	var packages []string
+	for _, result := range results {
+		packages = append(packages, result.Name)
+	}
+
+	return packages, nil
+}
+
+// GetPackageInfo gets information about a package from the npm registry,
+// including the installed version when the package is installed globally
+func (d *NpmDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	output, err := d.runJSON("view", packageName, "--json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
+	}
+
+	var view struct {
+		Name        string `json:"name"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+		Homepage    string `json:"homepage"`
+	}
+	if err := json.Unmarshal(output, &view); err != nil {
+		return nil, fmt.Errorf("failed to parse npm view output for %s: %w", packageName, err)
+	}
+	if view.Name == "" {
+		return nil, fmt.Errorf("package %s not found", packageName)
+	}
+
+	info := map[string]string{
+		"name":           view.Name,
+		"latest_version": view.Version,
+		"manager":        "npm",
+	}
+	if view.Description != "" {
+		info["description"] = view.Description
+	}
+	if view.Homepage != "" {
+		info["homepage"] = view.Homepage
+	}
+
+	// Report the installed version rather than the latest when available
+	info["version"] = view.Version
+	if list, err := d.listGlobal(); err == nil {
+		if dep, exists := list.Dependencies[view.Name]; exists && dep.Version != "" {
+			info["version"] = dep.Version
+		}
+	}
+
+	return info, nil
+}
+
+// GetAllInstalledPackages returns a map of all installed packages
+func (d *NpmDriver) GetAllInstalledPackages() (map[string]bool, error) {
+	return d.fetchAllInstalledPackages()
+}
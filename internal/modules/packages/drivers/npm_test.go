@@ -0,0 +1,67 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNpmDriver_Name(t *testing.T) {
+	driver := NewNpmDriver()
+	if driver.Name() != "npm" {
+		t.Errorf("Name() = %q, want %q", driver.Name(), "npm")
+	}
+}
+
+func TestParseNpmGlobalList(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "npm_list_global.json"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	list, err := parseNpmGlobalList(data)
+	if err != nil {
+		t.Fatalf("parseNpmGlobalList() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"@angular/cli": "17.3.7",
+		"eslint":       "8.57.0",
+		"npm":          "10.5.0",
+		"prettier":     "3.2.5",
+		"typescript":   "5.4.5",
+	}
+
+	if len(list.Dependencies) != len(expected) {
+		t.Fatalf("expected %d packages, got %d", len(expected), len(list.Dependencies))
+	}
+
+	for name, version := range expected {
+		dep, exists := list.Dependencies[name]
+		if !exists {
+			t.Errorf("expected package %s to be listed", name)
+			continue
+		}
+		if dep.Version != version {
+			t.Errorf("package %s version = %q, want %q", name, dep.Version, version)
+		}
+	}
+
+	if _, exists := list.Dependencies["angular/cli"]; exists {
+		t.Error("scoped package name should keep its @scope prefix")
+	}
+}
+
+func TestParseNpmGlobalList_Empty(t *testing.T) {
+	list, err := parseNpmGlobalList([]byte(`{"name": "lib"}`))
+	if err != nil {
+		t.Fatalf("parseNpmGlobalList() error = %v", err)
+	}
+	if len(list.Dependencies) != 0 {
+		t.Errorf("expected no packages, got %d", len(list.Dependencies))
+	}
+
+	if _, err := parseNpmGlobalList([]byte("npm ERR! code ENOENT")); err == nil {
+		t.Error("expected error for non-JSON output")
+	}
+}
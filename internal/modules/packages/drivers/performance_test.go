@@ -201,6 +201,34 @@ func TestCacheExpiration(t *testing.T) {
 	}
 }
 
+func TestCacheMissingPackages(t *testing.T) {
+	driver := NewFastMockDriver("test")
+	driver.SetPackageInstalled("git", true)
+
+	// Packages that are not installed are not listed, the cache still knows about them
+	for _, pkg := range []string{"git", "vim", "curl", "vim"} {
+		installed, err := driver.IsPackageInstalled(pkg)
+		if err != nil {
+			t.Fatalf("Error checking package %s: %v", pkg, err)
+		}
+		if installed != (pkg == "git") {
+			t.Errorf("IsPackageInstalled(%s) = %v", pkg, installed)
+		}
+	}
+
+	packages, err := driver.GetAllInstalledPackagesCached(driver.fetchAllPackages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !packages["git"] || len(packages) != 1 {
+		t.Errorf("Expected the cached packages, got %v", packages)
+	}
+
+	if driver.commandCallCount != 1 {
+		t.Errorf("Expected 1 command call for packages missing from the cache, got %d", driver.commandCallCount)
+	}
+}
+
 func TestConcurrentAccess(t *testing.T) {
 	driver := NewFastMockDriver("test")
 	driver.SetPackageInstalled("git", true)
@@ -0,0 +1,181 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PipxDriver implements PackageDriver for pipx managed Python applications
+type PipxDriver struct {
+	*BaseDriver
+}
+
+// pipxList represents the output of `pipx list --json`
+type pipxList struct {
+	PipxSpecVersion string              `json:"pipx_spec_version"`
+	Venvs           map[string]pipxVenv `json:"venvs"`
+}
+
+// pipxVenv represents a single virtual environment managed by pipx
+type pipxVenv struct {
+	Metadata struct {
+		MainPackage   pipxPackage `json:"main_package"`
+		PythonVersion string      `json:"python_version"`
+	} `json:"metadata"`
+}
+
+// pipxPackage represents the package information stored in a pipx venv
+type pipxPackage struct {
+	Package        string   `json:"package"`
+	PackageVersion string   `json:"package_version"`
+	PackageOrURL   string   `json:"package_or_url"`
+	Apps           []string `json:"apps"`
+}
+
+// NewPipxDriver creates a new pipx driver
+func NewPipxDriver() *PipxDriver {
+	return &PipxDriver{
+		BaseDriver: NewBaseDriver("pipx", "pipx"),
+	}
+}
+
+// IsAvailable checks if pipx is available on the system
+func (d *PipxDriver) IsAvailable() bool {
+	if !d.BaseDriver.IsAvailable() {
+		return false
+	}
+	return d.CheckCommandSuccess("--version")
+}
+
+// IsPackageInstalled checks if a package is installed via pipx
+func (d *PipxDriver) IsPackageInstalled(packageName string) (bool, error) {
+	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+}
+
+// fetchAllInstalledPackages fetches all installed packages from pipx
+func (d *PipxDriver) fetchAllInstalledPackages() (map[string]bool, error) {
+	list, err := d.listInstalled()
+	if err != nil {
+		return nil, err
+	}
+	return list.installedPackages(), nil
+}
+
+// installedPackages returns the venv and main package names in the list
+func (l *pipxList) installedPackages() map[string]bool {
+	packages := make(map[string]bool)
+	for venvName, venv := range l.Venvs {
+		packages[venvName] = true
+		packages[strings.ToLower(venvName)] = true
+
+		if name := venv.Metadata.MainPackage.Package; name != "" {
+			packages[name] = true
+			packages[strings.ToLower(name)] = true
+		}
+	}
+
+	return packages
+}
+
+// listInstalled runs `pipx list --json` and parses the result
+func (d *PipxDriver) listInstalled() (*pipxList, error) {
+	output, err := d.RunCommand("list", "--json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+	return parsePipxList([]byte(output))
+}
+
+// parsePipxList parses the JSON output of `pipx list --json`
+func parsePipxList(data []byte) (*pipxList, error) {
+	// pipx may print warnings before the JSON document
+	if start := strings.IndexByte(string(data), '{'); start > 0 {
+		data = data[start:]
+	}
+
+	var list pipxList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse pipx list output: %w", err)
+	}
+	if list.Venvs == nil {
+		list.Venvs = make(map[string]pipxVenv)
+	}
+	return &list, nil
+}
+
+// InstallPackage installs a package using pipx
+func (d *PipxDriver) InstallPackage(packageName string) error {
+	output, err := d.RunCommand("install", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via pipx: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
+// InstallPackageVersion installs a specific package version using pipx (pkg==version)
+func (d *PipxDriver) InstallPackageVersion(packageName, version string) error {
+	target := fmt.Sprintf("%s==%s", packageName, version)
+	output, err := d.RunCommand("install", "--force", target)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via pipx: %w\nOutput: %s", target, err, output)
+	}
+	return nil
+}
+
+// SupportsVersionPinning reports that pipx can install specific versions
+func (d *PipxDriver) SupportsVersionPinning() bool {
+	return true
+}
+
+// UninstallPackage uninstalls a package using pipx
+func (d *PipxDriver) UninstallPackage(packageName string) error {
+	output, err := d.RunCommand("uninstall", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to uninstall package %s via pipx: %w\nOutput: %s", packageName, err, output)
+	}
+	return nil
+}
+
+// SearchPackage is not supported by pipx as PyPI removed its search API
+func (d *PipxDriver) SearchPackage(packageName string) ([]string, error) {
+	return nil, fmt.Errorf("package search is not supported by pipx")
+}
+
+// GetPackageInfo gets information about an installed package
+func (d *PipxDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	list, err := d.listInstalled()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
+	}
+
+	for venvName, venv := range list.Venvs {
+		main := venv.Metadata.MainPackage
+		if !strings.EqualFold(venvName, packageName) && !strings.EqualFold(main.Package, packageName) {
+			continue
+		}
+
+		info := map[string]string{
+			"name":    main.Package,
+			"version": main.PackageVersion,
+			"manager": "pipx",
+		}
+		if info["name"] == "" {
+			info["name"] = venvName
+		}
+		if venv.Metadata.PythonVersion != "" {
+			info["python_version"] = venv.Metadata.PythonVersion
+		}
+		if len(main.Apps) > 0 {
+			info["apps"] = strings.Join(main.Apps, ", ")
+		}
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("package %s not found", packageName)
+}
+
+// GetAllInstalledPackages returns a map of all installed packages
+func (d *PipxDriver) GetAllInstalledPackages() (map[string]bool, error) {
+	return d.fetchAllInstalledPackages()
+}
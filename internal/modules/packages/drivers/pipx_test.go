@@ -0,0 +1,102 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPipxDriver_Name(t *testing.T) {
+	driver := NewPipxDriver()
+	if driver.Name() != "pipx" {
+		t.Errorf("Name() = %q, want %q", driver.Name(), "pipx")
+	}
+}
+
+func TestParsePipxList(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "pipx_list.json"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	list, err := parsePipxList(data)
+	if err != nil {
+		t.Fatalf("parsePipxList() error = %v", err)
+	}
+
+	if len(list.Venvs) != 3 {
+		t.Fatalf("expected 3 venvs, got %d", len(list.Venvs))
+	}
+
+	black, exists := list.Venvs["black"]
+	if !exists {
+		t.Fatal("expected black venv to be present")
+	}
+	if black.Metadata.MainPackage.Package != "black" {
+		t.Errorf("main package = %q, want %q", black.Metadata.MainPackage.Package, "black")
+	}
+	if black.Metadata.MainPackage.PackageVersion != "24.4.2" {
+		t.Errorf("package version = %q, want %q", black.Metadata.MainPackage.PackageVersion, "24.4.2")
+	}
+	if black.Metadata.PythonVersion != "Python 3.12.3" {
+		t.Errorf("python version = %q, want %q", black.Metadata.PythonVersion, "Python 3.12.3")
+	}
+	if len(list.Venvs["httpie"].Metadata.MainPackage.Apps) != 3 {
+		t.Errorf("expected httpie to expose 3 apps, got %v", list.Venvs["httpie"].Metadata.MainPackage.Apps)
+	}
+
+	packages := list.installedPackages()
+	for _, name := range []string{"black", "httpie", "pre-commit"} {
+		if !packages[name] {
+			t.Errorf("expected %s to be reported as installed", name)
+		}
+	}
+	if packages["http"] {
+		t.Error("app names should not be reported as packages")
+	}
+}
+
+func TestParsePipxList_EdgeCases(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantVenvs int
+		wantErr   bool
+	}{
+		{
+			name:      "no packages installed",
+			input:     `{"pipx_spec_version": "0.1", "venvs": {}}`,
+			wantVenvs: 0,
+		},
+		{
+			name:      "missing venvs key",
+			input:     `{"pipx_spec_version": "0.1"}`,
+			wantVenvs: 0,
+		},
+		{
+			name:      "warning printed before json",
+			input:     "⚠️ Note: some warning\n{\"venvs\": {\"ruff\": {\"metadata\": {\"main_package\": {\"package\": \"ruff\"}}}}}",
+			wantVenvs: 1,
+		},
+		{
+			name:    "invalid output",
+			input:   "not json",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := parsePipxList([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePipxList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(list.Venvs) != tt.wantVenvs {
+				t.Errorf("expected %d venvs, got %d", tt.wantVenvs, len(list.Venvs))
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package drivers
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// DefaultSudoCommand is the command used to run package managers as root
+const DefaultSudoCommand = "sudo"
+
+// Privilege decides how package manager commands that need root are run. It is
+// shared by all drivers so they escalate the same way.
+type Privilege struct {
+	command  string
+	geteuid  func() int
+	lookPath func(string) (string, error)
+}
+
+// NewPrivilege creates a privilege policy that escalates with command, or with
+// sudo when command is empty
+func NewPrivilege(command string) *Privilege {
+	if command == "" {
+		command = DefaultSudoCommand
+	}
+	return &Privilege{
+		command:  command,
+		geteuid:  os.Geteuid,
+		lookPath: exec.LookPath,
+	}
+}
+
+// Command returns the escalation command, e.g. "sudo" or "doas"
+func (p *Privilege) Command() string {
+	return p.command
+}
+
+// IsRoot reports whether the process already runs as root. Always false on Windows.
+func (p *Privilege) IsRoot() bool {
+	return p.geteuid() == 0
+}
+
+// CanEscalate reports whether the escalation command is installed
+func (p *Privilege) CanEscalate() bool {
+	_, err := p.lookPath(p.command)
+	return err == nil
+}
+
+// Available reports whether privileged commands can be run at all: either the
+// process is root or the escalation command is installed
+func (p *Privilege) Available() bool {
+	return p.IsRoot() || p.CanEscalate()
+}
+
+// HasCredentials reports whether privileged commands run without asking for a
+// password: the process is root, or the escalation command has cached credentials
+// or needs none. It asks the escalation command with -n, which sudo and doas know.
+func (p *Privilege) HasCredentials(ctx context.Context) bool {
+	if p.IsRoot() {
+		return true
+	}
+	return exec.CommandContext(ctx, p.command, "-n", "true").Run() == nil
+}
+
+// Wrap returns the command line that runs name with root privileges. Commands
+// are run directly when the process is already root.
+func (p *Privilege) Wrap(name string, args ...string) (string, []string) {
+	if p.IsRoot() {
+		return name, args
+	}
+	return p.command, append([]string{name}, args...)
+}
@@ -0,0 +1,149 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// newTestPrivilege creates a privilege policy with a fake euid and the given
+// commands installed
+func newTestPrivilege(command string, euid int, installed ...string) *Privilege {
+	p := NewPrivilege(command)
+	p.geteuid = func() int { return euid }
+	p.lookPath = func(name string) (string, error) {
+		for _, cmd := range installed {
+			if cmd == name {
+				return "/usr/bin/" + name, nil
+			}
+		}
+		return "", fmt.Errorf("%s: executable file not found in $PATH", name)
+	}
+	return p
+}
+
+func TestPrivilege(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		euid        int
+		installed   []string
+		available   bool
+		wantName    string
+		wantArgs    []string
+		wantCommand string
+	}{
+		{
+			name:        "root skips sudo",
+			euid:        0,
+			available:   true,
+			wantName:    "apt-get",
+			wantArgs:    []string{"install", "-y", "git"},
+			wantCommand: "sudo",
+		},
+		{
+			name:        "non-root uses sudo",
+			euid:        1000,
+			installed:   []string{"sudo"},
+			available:   true,
+			wantName:    "sudo",
+			wantArgs:    []string{"apt-get", "install", "-y", "git"},
+			wantCommand: "sudo",
+		},
+		{
+			name:        "non-root without sudo",
+			euid:        1000,
+			available:   false,
+			wantName:    "sudo",
+			wantArgs:    []string{"apt-get", "install", "-y", "git"},
+			wantCommand: "sudo",
+		},
+		{
+			name:        "sudo_command override",
+			command:     "doas",
+			euid:        1000,
+			installed:   []string{"doas"},
+			available:   true,
+			wantName:    "doas",
+			wantArgs:    []string{"apt-get", "install", "-y", "git"},
+			wantCommand: "doas",
+		},
+		{
+			name:        "override is not satisfied by sudo",
+			command:     "doas",
+			euid:        1000,
+			installed:   []string{"sudo"},
+			available:   false,
+			wantName:    "doas",
+			wantArgs:    []string{"apt-get", "install", "-y", "git"},
+			wantCommand: "doas",
+		},
+		{
+			name:        "root ignores override",
+			command:     "doas",
+			euid:        0,
+			available:   true,
+			wantName:    "apt-get",
+			wantArgs:    []string{"install", "-y", "git"},
+			wantCommand: "doas",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPrivilege(tt.command, tt.euid, tt.installed...)
+
+			if p.Command() != tt.wantCommand {
+				t.Errorf("Command() = %q, want %q", p.Command(), tt.wantCommand)
+			}
+			if p.Available() != tt.available {
+				t.Errorf("Available() = %v, want %v", p.Available(), tt.available)
+			}
+
+			name, args := p.Wrap("apt-get", "install", "-y", "git")
+			if name != tt.wantName || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("Wrap() = %s %v, want %s %v", name, args, tt.wantName, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestBaseDriverPrivilege(t *testing.T) {
+	driver := NewAptDriver()
+	if driver.Privilege().Command() != DefaultSudoCommand {
+		t.Errorf("default privilege command = %q, want %q", driver.Privilege().Command(), DefaultSudoCommand)
+	}
+
+	registry := NewDriverRegistry()
+	registry.RegisterDriver(driver)
+	registry.SetPrivilege(NewPrivilege("doas"))
+	if driver.Privilege().Command() != "doas" {
+		t.Errorf("privilege command after SetPrivilege = %q, want %q", driver.Privilege().Command(), "doas")
+	}
+
+	registry.SetPrivilege(nil)
+	if driver.Privilege().Command() != DefaultSudoCommand {
+		t.Errorf("privilege command after reset = %q, want %q", driver.Privilege().Command(), DefaultSudoCommand)
+	}
+}
+
+func TestPrivilegeHasCredentials(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("true is not installed")
+	}
+	ctx := context.Background()
+
+	if !newTestPrivilege("missing-sudo", 0).HasCredentials(ctx) {
+		t.Error("root should not need credentials")
+	}
+	// "true -n true" succeeds like sudo with cached credentials, "false -n true"
+	// fails like sudo asking for a password
+	if !newTestPrivilege("true", 1000).HasCredentials(ctx) {
+		t.Error("HasCredentials() = false for an escalation command that succeeds")
+	}
+	if newTestPrivilege("false", 1000).HasCredentials(ctx) {
+		t.Error("HasCredentials() = true for an escalation command that fails")
+	}
+}
@@ -0,0 +1,349 @@
+package drivers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// rpmReposDir is where DNF and YUM keep their .repo files
+var rpmReposDir = "/etc/yum.repos.d"
+
+// rpmDriver holds the logic the DNF and YUM drivers share
+type rpmDriver struct {
+	*BaseDriver
+	label     string   // Name of the manager in error messages, e.g. "DNF"
+	listFlags []string // Arguments of the list command selecting installed packages
+}
+
+// rpmPackage is a package in the output of `dnf list` or `yum list`
+type rpmPackage struct {
+	Name       string
+	Arch       string
+	Version    string
+	Repository string
+}
+
+// newRPMDriver creates the shared part of an RPM based driver
+func newRPMDriver(name, label string, listFlags ...string) *rpmDriver {
+	return &rpmDriver{
+		BaseDriver: NewBaseDriver(name, name),
+		label:      label,
+		listFlags:  listFlags,
+	}
+}
+
+// parseRPMList parses the output of `dnf list --installed` and `yum list installed`.
+// dnf4 and yum print "name.arch version @repo" and wrap the line after names
+// that do not fit their column, dnf5 never wraps and prints repositories without @.
+func parseRPMList(output string) []*rpmPackage {
+	var packages []*rpmPackage
+	wrapped := ""
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if wrapped != "" {
+			fields = append([]string{wrapped}, fields...)
+			wrapped = ""
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		name, arch, ok := splitRPMNameArch(fields[0])
+		if !ok {
+			continue // Headers such as "Installed Packages" and "Last metadata expiration check"
+		}
+		if len(fields) == 1 {
+			wrapped = fields[0]
+			continue
+		}
+		if len(fields) < 3 {
+			continue
+		}
+
+		packages = append(packages, &rpmPackage{
+			Name:       name,
+			Arch:       arch,
+			Version:    fields[1],
+			Repository: strings.TrimPrefix(fields[2], "@"),
+		})
+	}
+	return packages
+}
+
+// splitRPMNameArch splits "python3.11.x86_64" into its name and architecture
+func splitRPMNameArch(nameArch string) (string, string, bool) {
+	dot := strings.LastIndex(nameArch, ".")
+	if dot <= 0 || dot == len(nameArch)-1 || strings.ContainsAny(nameArch, ":/*") {
+		return "", "", false
+	}
+	return nameArch[:dot], nameArch[dot+1:], true
+}
+
+// parseRPMSearch returns the package names in the output of `dnf search`. dnf4 and
+// yum print "name.arch : summary" below section headers, dnf5 "name.arch: summary".
+func parseRPMSearch(output string) []string {
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			continue
+		}
+		nameArch := strings.TrimSpace(line[:colon])
+		if strings.ContainsAny(nameArch, " \t") {
+			continue // Headers such as "Matched fields: name"
+		}
+		if name, _, ok := splitRPMNameArch(nameArch); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseRPMRepolist returns the ids of the repositories in the output of
+// `dnf repolist`. Only rows below the "repo id" header are read, since yum
+// prints plugin messages above it and a total below. yum also adds the
+// release to ids, e.g. "base/7/x86_64".
+func parseRPMRepolist(output string) map[string]bool {
+	repos := make(map[string]bool)
+	inTable := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "repo" && fields[1] == "id" {
+			inTable = true
+			continue
+		}
+		if !inTable || len(fields) == 0 || fields[0] == "repolist:" {
+			continue
+		}
+		id := strings.TrimLeft(fields[0], "!*")
+		if slash := strings.Index(id, "/"); slash > 0 {
+			id = id[:slash]
+		}
+		repos[id] = true
+	}
+	return repos
+}
+
+// parseRPMRepo splits an add_repo value into a repository id and the URL of a
+// .repo file or base URL. Either can be empty: "epel" enables an existing
+// repository and "https://example.com/app.repo" adds a .repo file.
+func parseRPMRepo(repoName string) (string, string, error) {
+	fields := strings.Fields(repoName)
+	switch {
+	case len(fields) == 1 && strings.Contains(fields[0], "://"):
+		return "", fields[0], nil
+	case len(fields) == 1:
+		return fields[0], "", nil
+	case len(fields) == 2 && strings.Contains(fields[1], "://"):
+		return fields[0], fields[1], nil
+	default:
+		return "", "", fmt.Errorf("repository must be \"<id>\", \"<url>\" or \"<id> <url>\", got %q", repoName)
+	}
+}
+
+// IsPackageInstalled checks if a package is installed
+func (d *rpmDriver) IsPackageInstalled(packageName string) (bool, error) {
+	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+}
+
+// fetchAllInstalledPackages fetches all installed packages
+func (d *rpmDriver) fetchAllInstalledPackages() (map[string]bool, error) {
+	output, err := d.RunCommand(append([]string{"list"}, d.listFlags...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+
+	packages := make(map[string]bool)
+	for _, pkg := range parseRPMList(output) {
+		packages[pkg.Name] = true
+		packages[strings.ToLower(pkg.Name)] = true
+	}
+	return packages, nil
+}
+
+// GetAllInstalledPackages returns a map of all installed packages
+func (d *rpmDriver) GetAllInstalledPackages() (map[string]bool, error) {
+	return d.fetchAllInstalledPackages()
+}
+
+// InstallPackage installs a package
+func (d *rpmDriver) InstallPackage(packageName string) error {
+	output, err := d.RunPrivileged("install", "-y", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via %s: %w\nOutput: %s", packageName, d.label, err, output)
+	}
+	return nil
+}
+
+// rpmNotFound matches the lines DNF and YUM blame a package they cannot find with
+var rpmNotFound = regexp.MustCompile(`No match for argument: (\S+)|No package (\S+) available`)
+
+// InstallPackages installs packages with a single install command. YUM installs
+// the packages it finds and succeeds, so its output is checked for the others.
+func (d *rpmDriver) InstallPackages(packageNames []string) error {
+	output, err := d.RunPrivileged(append([]string{"install", "-y"}, packageNames...)...)
+	if err == nil && rpmNotFound.MatchString(output) {
+		err = fmt.Errorf("not every package was found")
+	}
+	if err != nil {
+		return batchError(d.label, packageNames, output, rpmNotFound, err)
+	}
+	return nil
+}
+
+// InstallPackageVersion installs a specific package version (pkg-version),
+// falling back to a downgrade when a newer version is installed
+func (d *rpmDriver) InstallPackageVersion(packageName, version string) error {
+	target := fmt.Sprintf("%s-%s", packageName, version)
+	output, err := d.RunPrivileged("install", "-y", target)
+	if err != nil {
+		output, err = d.RunPrivileged("downgrade", "-y", target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via %s: %w\nOutput: %s", target, d.label, err, output)
+	}
+	return nil
+}
+
+// SupportsVersionPinning reports that specific versions can be installed
+func (d *rpmDriver) SupportsVersionPinning() bool {
+	return true
+}
+
+// UninstallPackage uninstalls a package
+func (d *rpmDriver) UninstallPackage(packageName string) error {
+	output, err := d.RunPrivileged("remove", "-y", packageName)
+	if err != nil {
+		return fmt.Errorf("failed to uninstall package %s via %s: %w\nOutput: %s", packageName, d.label, err, output)
+	}
+	return nil
+}
+
+// SearchPackage searches for packages
+func (d *rpmDriver) SearchPackage(packageName string) ([]string, error) {
+	output, err := d.RunCommand("search", packageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for package %s: %w", packageName, err)
+	}
+	return parseRPMSearch(output), nil
+}
+
+// GetPackageInfo gets information about an installed package
+func (d *rpmDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	args := append(append([]string{"list"}, d.listFlags...), packageName)
+	output, err := d.RunCommand(args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
+	}
+
+	for _, pkg := range parseRPMList(output) {
+		if strings.EqualFold(pkg.Name, packageName) {
+			return map[string]string{
+				"name":         pkg.Name,
+				"version":      pkg.Version,
+				"repository":   pkg.Repository,
+				"architecture": pkg.Arch,
+				"manager":      d.Name(),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("package %s not found", packageName)
+}
+
+// IsRepositoryAvailable checks whether a repository is enabled or whether a .repo
+// file already refers to its URL
+func (d *rpmDriver) IsRepositoryAvailable(repoName string) (bool, error) {
+	id, url, err := parseRPMRepo(repoName)
+	if err != nil {
+		return false, err
+	}
+
+	if id == "" {
+		return rpmRepoFileExists(url)
+	}
+
+	output, err := d.RunCommand("repolist", "--enabled")
+	if err != nil {
+		return false, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	if parseRPMRepolist(output)[id] {
+		return true, nil
+	}
+
+	// dnf4 and yum name repositories added from a base URL after the URL
+	if url != "" {
+		return rpmRepoFileExists(url)
+	}
+	return false, nil
+}
+
+// rpmRepoFileExists reports whether a .repo file was added from url or refers to it
+func rpmRepoFileExists(url string) (bool, error) {
+	if strings.HasSuffix(url, ".repo") {
+		if _, err := os.Stat(filepath.Join(rpmReposDir, filepath.Base(url))); err == nil {
+			return true, nil
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(rpmReposDir, "*.repo"))
+	if err != nil {
+		return false, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(data), url) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ensureRepository adds a repository with the given command unless it is already available
+func (d *rpmDriver) ensureRepository(repoName string, command func(id, url string) (string, []string)) error {
+	id, url, err := parseRPMRepo(repoName)
+	if err != nil {
+		return err
+	}
+
+	available, err := d.IsRepositoryAvailable(repoName)
+	if err != nil {
+		return fmt.Errorf("failed to check repository availability: %w", err)
+	}
+	if available {
+		return nil
+	}
+
+	name, args := command(id, url)
+	output, err := d.RunPrivilegedCommand(name, args...)
+	if err != nil {
+		return fmt.Errorf("failed to add repository %s: %w\nOutput: %s", repoName, err, output)
+	}
+	return nil
+}
+
+// NeedsPrivilege reports that DNF and YUM installs and removes packages as root
+func (d *rpmDriver) NeedsPrivilege() bool {
+	return true
+}
+
+// IsAvailable overrides the base implementation to check platform compatibility and privileges
+func (d *rpmDriver) IsAvailable() bool {
+	// RPM based managers are only available on Linux
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	if !d.BaseDriver.IsAvailable() {
+		return false
+	}
+
+	// Install/remove operations need root or a way to become root
+	return d.Privilege().Available()
+}
@@ -0,0 +1,214 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readFixture(t *testing.T, fixture string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return string(data)
+}
+
+func TestParseRPMList(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []rpmPackage
+	}{
+		{
+			fixture: "dnf4_list_installed.txt",
+			want: []rpmPackage{
+				{Name: "NetworkManager", Arch: "x86_64", Version: "1:1.42.6-1.fc38", Repository: "updates"},
+				{Name: "git", Arch: "x86_64", Version: "2.41.0-1.fc38", Repository: "updates"},
+				{Name: "python3.11", Arch: "x86_64", Version: "3.11.4-1.fc38", Repository: "updates"},
+				{Name: "texlive-collection-latexrecommended", Arch: "noarch", Version: "9:svn54074-62.fc38", Repository: "fedora"},
+				{Name: "zsh", Arch: "x86_64", Version: "5.9-5.fc38", Repository: "anaconda"},
+			},
+		},
+		{
+			fixture: "dnf5_list_installed.txt",
+			want: []rpmPackage{
+				{Name: "NetworkManager", Arch: "x86_64", Version: "1:1.46.0-1.fc40", Repository: "<unknown>"},
+				{Name: "git", Arch: "x86_64", Version: "2.45.2-2.fc40", Repository: "updates"},
+				{Name: "python3.12", Arch: "x86_64", Version: "3.12.4-1.fc40", Repository: "updates"},
+				{Name: "texlive-collection-latexrecommended", Arch: "noarch", Version: "9:svn54074-71.fc40", Repository: "fedora"},
+			},
+		},
+		{
+			fixture: "yum_list_installed.txt",
+			want: []rpmPackage{
+				{Name: "bash", Arch: "x86_64", Version: "4.2.46-35.el7_9", Repository: "updates"},
+				{Name: "git", Arch: "x86_64", Version: "1.8.3.1-25.el7_9", Repository: "updates"},
+				{Name: "perl-Git", Arch: "noarch", Version: "1.8.3.1-25.el7_9", Repository: "updates"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			packages := parseRPMList(readFixture(t, tt.fixture))
+			if len(packages) != len(tt.want) {
+				t.Fatalf("expected %d packages, got %d: %v", len(tt.want), len(packages), packages)
+			}
+			for i, want := range tt.want {
+				if *packages[i] != want {
+					t.Errorf("package %d = %+v, want %+v", i, *packages[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRPMSearch(t *testing.T) {
+	want := []string{"git", "git-all"}
+	for _, fixture := range []string{"dnf4_search.txt", "dnf5_search.txt"} {
+		t.Run(fixture, func(t *testing.T) {
+			if names := parseRPMSearch(readFixture(t, fixture)); !reflect.DeepEqual(names, want) {
+				t.Errorf("parseRPMSearch() = %v, want %v", names, want)
+			}
+		})
+	}
+}
+
+func TestParseRPMRepolist(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    map[string]bool
+	}{
+		{
+			fixture: "dnf_repolist.txt",
+			want:    map[string]bool{"docker-ce-stable": true, "fedora": true, "updates": true},
+		},
+		{
+			fixture: "yum_repolist.txt",
+			want:    map[string]bool{"base": true, "epel": true, "updates": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			if repos := parseRPMRepolist(readFixture(t, tt.fixture)); !reflect.DeepEqual(repos, tt.want) {
+				t.Errorf("parseRPMRepolist() = %v, want %v", repos, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRPMRepo(t *testing.T) {
+	tests := []struct {
+		repo    string
+		id      string
+		url     string
+		wantErr bool
+	}{
+		{repo: "epel", id: "epel"},
+		{repo: "https://download.docker.com/linux/fedora/docker-ce.repo", url: "https://download.docker.com/linux/fedora/docker-ce.repo"},
+		{repo: "vscode https://packages.microsoft.com/yumrepos/vscode", id: "vscode", url: "https://packages.microsoft.com/yumrepos/vscode"},
+		{repo: "vscode packages", wantErr: true},
+		{repo: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			id, url, err := parseRPMRepo(tt.repo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRPMRepo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if id != tt.id || url != tt.url {
+				t.Errorf("parseRPMRepo() = %q, %q, want %q, %q", id, url, tt.id, tt.url)
+			}
+		})
+	}
+}
+
+func TestDnfRepoArgs(t *testing.T) {
+	const repoFile = "https://download.docker.com/linux/fedora/docker-ce.repo"
+	const baseURL = "https://packages.microsoft.com/yumrepos/vscode"
+
+	tests := []struct {
+		name    string
+		id, url string
+		dnf5    []string
+		dnf4    []string
+	}{
+		{
+			name: "enable",
+			id:   "epel",
+			dnf5: []string{"config-manager", "setopt", "epel.enabled=1"},
+			dnf4: []string{"config-manager", "--set-enabled", "epel"},
+		},
+		{
+			name: "repo file",
+			url:  repoFile,
+			dnf5: []string{"config-manager", "addrepo", "--from-repofile=" + repoFile},
+			dnf4: []string{"config-manager", "--add-repo", repoFile},
+		},
+		{
+			name: "base url",
+			id:   "vscode",
+			url:  baseURL,
+			dnf5: []string{"config-manager", "addrepo", "--id=vscode", "--set=baseurl=" + baseURL},
+			dnf4: []string{"config-manager", "--add-repo", baseURL},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if args := dnf5RepoArgs(tt.id, tt.url); !reflect.DeepEqual(args, tt.dnf5) {
+				t.Errorf("dnf5RepoArgs() = %v, want %v", args, tt.dnf5)
+			}
+			if args := dnf4RepoArgs(tt.id, tt.url); !reflect.DeepEqual(args, tt.dnf4) {
+				t.Errorf("dnf4RepoArgs() = %v, want %v", args, tt.dnf4)
+			}
+		})
+	}
+}
+
+func TestIsDnf5Version(t *testing.T) {
+	tests := map[string]bool{
+		"dnf5 version 5.2.5.0\ndnf5 plugin API version 2.0\n": true,
+		"5.1.17\n": true,
+		"4.21.1\n  Installed: dnf-0:4.21.1-1.fc40.noarch\n": false,
+	}
+	for output, want := range tests {
+		if got := isDnf5Version(output); got != want {
+			t.Errorf("isDnf5Version(%q) = %v, want %v", output, got, want)
+		}
+	}
+}
+
+func TestRPMRepoFileExists(t *testing.T) {
+	dir := t.TempDir()
+	original := rpmReposDir
+	rpmReposDir = dir
+	defer func() { rpmReposDir = original }()
+
+	if err := os.WriteFile(filepath.Join(dir, "docker-ce.repo"), []byte("[docker-ce-stable]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := "[packages.microsoft.com_yumrepos_vscode]\nbaseurl=https://packages.microsoft.com/yumrepos/vscode\n"
+	if err := os.WriteFile(filepath.Join(dir, "packages.microsoft.com_yumrepos_vscode.repo"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"https://download.docker.com/linux/fedora/docker-ce.repo": true,
+		"https://packages.microsoft.com/yumrepos/vscode":          true,
+		"https://example.com/other.repo":                          false,
+	}
+	for url, want := range tests {
+		exists, err := rpmRepoFileExists(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != want {
+			t.Errorf("rpmRepoFileExists(%q) = %v, want %v", url, exists, want)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package drivers
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"regexp"
 	"runtime"
 	"strings"
 )
@@ -18,9 +21,21 @@ func NewScoopDriver() *ScoopDriver {
 	}
 }
 
-// IsPackageInstalled checks if a package is installed via Scoop
+// IsPackageInstalled checks if a package is installed via Scoop. A name with a
+// version ("app@1.2.3") is only installed when that version is.
 func (d *ScoopDriver) IsPackageInstalled(packageName string) (bool, error) {
-	return d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+	app := scoopAppName(packageName)
+	installed, err := d.IsPackageInstalledCached(app, d.fetchAllInstalledPackages)
+	_, version := splitScoopApp(packageName)
+	if err != nil || !installed || version == "" {
+		return installed, err
+	}
+
+	info, err := d.GetPackageInfo(app)
+	if err != nil {
+		return false, err
+	}
+	return info["version"] == version, nil
 }
 
 // fetchAllInstalledPackages fetches all installed packages from Scoop
@@ -31,38 +46,188 @@ func (d *ScoopDriver) fetchAllInstalledPackages() (map[string]bool, error) {
 	}
 
 	packages := make(map[string]bool)
-	lines := strings.Split(output, "\n")
+	for _, app := range parseScoopList(output) {
+		packages[app.Name] = true
+		packages[strings.ToLower(app.Name)] = true
+	}
+	return packages, nil
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+// scoopApp is an installed app as reported by `scoop list`
+type scoopApp struct {
+	Name    string
+	Version string
+	Bucket  string
+}
+
+// parseScoopList parses `scoop list` output. Older Scoop versions print
+// "name version [bucket]" lines, newer ones print a table with a header
+// ("Name Version Source Updated Info") underlined by dashes.
+func parseScoopList(output string) []*scoopApp {
+	var apps []*scoopApp
+	for _, fields := range scoopRows(output) {
+		if len(fields) < 2 {
 			continue
 		}
+		app := &scoopApp{Name: fields[0], Version: fields[1]}
+		if len(fields) >= 3 {
+			app.Bucket = strings.Trim(fields[2], "[]")
+		}
+		apps = append(apps, app)
+	}
+	return apps
+}
+
+// parseScoopBucketList parses `scoop bucket list` output into bucket names and
+// their sources. Older Scoop versions print only names, newer ones print a table
+// ("Name Source Updated Manifests").
+func parseScoopBucketList(output string) map[string]string {
+	buckets := make(map[string]string)
+	for _, fields := range scoopRows(output) {
+		source := ""
+		if len(fields) >= 2 {
+			source = fields[1]
+		}
+		buckets[strings.ToLower(fields[0])] = source
+	}
+	return buckets
+}
 
-		// Skip header lines
-		if strings.Contains(line, "Installed apps:") || strings.Contains(line, "Name") {
+// scoopRows splits Scoop list output into the fields of each row, leaving out
+// blank lines, headers, table underlines and warnings
+func scoopRows(output string) [][]string {
+	var rows [][]string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
 			continue
 		}
-
-		// Scoop output format: "name version [bucket] *extras"
-		parts := strings.Fields(line)
-		if len(parts) >= 1 {
-			packageName := parts[0]
-			packages[packageName] = true
-			packages[strings.ToLower(packageName)] = true
+		switch {
+		case len(fields) <= 2 && strings.HasSuffix(fields[len(fields)-1], ":"): // "Installed apps:"
+			continue
+		case fields[0] == "Name" && len(fields) > 1 && (fields[1] == "Version" || fields[1] == "Source"):
+			continue
+		case strings.Trim(fields[0], "-") == "":
+			continue
+		case fields[0] == "WARN" || fields[0] == "ERROR":
+			continue
 		}
+		rows = append(rows, fields)
 	}
+	return rows
+}
 
-	return packages, nil
+// splitScoopApp splits "app@1.2.3" into the app and its version
+func splitScoopApp(packageName string) (string, string) {
+	if i := strings.LastIndex(packageName, "@"); i > 0 {
+		return packageName[:i], packageName[i+1:]
+	}
+	return packageName, ""
 }
 
-// InstallPackage installs a package using Scoop
+// scoopAppName returns the name `scoop list` reports an app under, without the
+// bucket ("mybucket/app") and version ("app@1.2.3")
+func scoopAppName(packageName string) string {
+	app, _ := splitScoopApp(packageName)
+	return app[strings.LastIndex(app, "/")+1:]
+}
+
+// InstallPackage installs a package using Scoop. Names with a version
+// ("app@1.2.3") install that version.
 func (d *ScoopDriver) InstallPackage(packageName string) error {
+	if app, version := splitScoopApp(packageName); version != "" {
+		return d.InstallPackageVersion(app, version)
+	}
+
 	output, err := d.RunCommand("install", packageName)
 	if err != nil {
 		return fmt.Errorf("failed to install package %s via Scoop: %w\nOutput: %s", packageName, err, output)
 	}
+	return checkScoopInstallOutput(packageName, output)
+}
+
+// scoopNotFound matches the lines Scoop blames an app it has no manifest for with
+var scoopNotFound = regexp.MustCompile(`(?i)couldn't find manifest for '([^']+)'`)
+
+// InstallPackages installs apps with a single scoop install. Apps with a version
+// ("app@1.2.3") are installed on their own, like InstallPackage does.
+func (d *ScoopDriver) InstallPackages(packageNames []string) error {
+	var apps, versioned []string
+	for _, name := range packageNames {
+		if _, version := splitScoopApp(name); version != "" {
+			versioned = append(versioned, name)
+		} else {
+			apps = append(apps, name)
+		}
+	}
+
+	failed := make(map[string]error)
+	var batchErr *BatchInstallError
+	if errors.As(installEach(d, versioned), &batchErr) {
+		maps.Copy(failed, batchErr.Failed)
+	}
+	if len(apps) > 0 {
+		err := d.installApps(apps)
+		if err != nil && !errors.As(err, &batchErr) {
+			// The output does not tell which app failed
+			err = installEach(d, apps)
+		}
+		if errors.As(err, &batchErr) {
+			maps.Copy(failed, batchErr.Failed)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &BatchInstallError{Failed: failed}
+	}
+	return nil
+}
+
+// installApps installs apps without a version with a single scoop install
+func (d *ScoopDriver) installApps(apps []string) error {
+	output, err := d.RunCommand(append([]string{"install"}, apps...)...)
+	if err == nil {
+		// Scoop reports most errors with exit code 0
+		err = checkScoopInstallOutput(strings.Join(apps, " "), output)
+	}
+	if err == nil && !scoopNotFound.MatchString(output) {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("not every app was found")
+	}
+	return batchError("Scoop", apps, output, scoopNotFound, err)
+}
 
+// InstallPackageVersion installs a specific package version using Scoop
+// (app@version). Scoop refuses to install an app that is already installed, so a
+// different installed version is uninstalled first.
+func (d *ScoopDriver) InstallPackageVersion(packageName, version string) error {
+	installed, err := d.IsPackageInstalledCached(scoopAppName(packageName), d.fetchAllInstalledPackages)
+	if err != nil {
+		return err
+	}
+	if installed {
+		if err := d.UninstallPackage(packageName); err != nil {
+			return err
+		}
+	}
+
+	spec := packageName + "@" + version
+	output, err := d.RunCommand("install", spec)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s via Scoop: %w\nOutput: %s", spec, err, output)
+	}
+	return checkScoopInstallOutput(spec, output)
+}
+
+// SupportsVersionPinning reports that Scoop can install specific versions
+func (d *ScoopDriver) SupportsVersionPinning() bool {
+	return true
+}
+
+// checkScoopInstallOutput checks for errors Scoop reports with exit code 0
+func checkScoopInstallOutput(packageName, output string) error {
 	// Check for common error patterns in Scoop output even when exit code is 0
 	outputLower := strings.ToLower(output)
 	if strings.Contains(outputLower, "couldn't find manifest") {
@@ -80,6 +245,7 @@ func (d *ScoopDriver) InstallPackage(packageName string) error {
 
 // UninstallPackage uninstalls a package using Scoop
 func (d *ScoopDriver) UninstallPackage(packageName string) error {
+	packageName = scoopAppName(packageName)
 	output, err := d.RunCommand("uninstall", packageName)
 	if err != nil {
 		return fmt.Errorf("failed to uninstall package %s via Scoop: %w\nOutput: %s", packageName, err, output)
@@ -127,47 +293,28 @@ func (d *ScoopDriver) SearchPackage(packageName string) ([]string, error) {
 
 // GetPackageInfo gets information about an installed package
 func (d *ScoopDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	packageName = scoopAppName(packageName)
 	output, err := d.RunCommand("list", packageName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
 	}
 
-	info := make(map[string]string)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Skip header lines
-		if strings.Contains(line, "Installed apps:") || strings.Contains(line, "Name") {
-			continue
-		}
-
-		// Look for the package in the output
-		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			if strings.EqualFold(parts[0], packageName) {
-				info["name"] = parts[0]
-				info["version"] = parts[1]
-				info["manager"] = "scoop"
-				if len(parts) >= 3 {
-					// Extract bucket name (remove brackets)
-					bucket := strings.Trim(parts[2], "[]")
-					info["bucket"] = bucket
-				}
-				break
+	// `scoop list <query>` matches substrings, so look for the exact app
+	for _, app := range parseScoopList(output) {
+		if strings.EqualFold(app.Name, packageName) {
+			info := map[string]string{
+				"name":    app.Name,
+				"version": app.Version,
+				"manager": "scoop",
 			}
+			if app.Bucket != "" {
+				info["bucket"] = app.Bucket
+			}
+			return info, nil
 		}
 	}
 
-	if len(info) == 0 {
-		return nil, fmt.Errorf("package %s not found", packageName)
-	}
-
-	return info, nil
+	return nil, fmt.Errorf("package %s not found", packageName)
 }
 
 // GetAllInstalledPackages returns a map of all installed packages
@@ -175,77 +322,61 @@ func (d *ScoopDriver) GetAllInstalledPackages() (map[string]bool, error) {
 	return d.fetchAllInstalledPackages()
 }
 
-// EnsureRepository ensures a Scoop bucket is available
-func (d *ScoopDriver) EnsureRepository(bucketName string) error {
-	// Check if bucket is already added
-	output, err := d.RunCommand("bucket", "list")
-	if err != nil {
-		return fmt.Errorf("failed to list Scoop buckets: %w", err)
+// parseScoopBucket splits "name url" into the bucket name and its optional git URL.
+// Known buckets such as extras can be added by name alone.
+func parseScoopBucket(repoName string) (string, string, error) {
+	fields := strings.Fields(repoName)
+	switch len(fields) {
+	case 1:
+		return fields[0], "", nil
+	case 2:
+		return fields[0], fields[1], nil
+	default:
+		return "", "", fmt.Errorf("scoop bucket must be \"<name>\" or \"<name> <url>\", got %q", repoName)
 	}
+}
 
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Skip header lines
-		if strings.Contains(line, "Name") && strings.Contains(line, "Source") {
-			continue
-		}
-		if strings.Contains(line, "----") {
-			continue
-		}
-
-		// Parse the first column (bucket name)
-		parts := strings.Fields(line)
-		if len(parts) >= 1 && parts[0] == bucketName {
-			// Bucket is already added
-			return nil
-		}
+// EnsureRepository ensures a Scoop bucket is available, adding it from its git URL
+// when one is given
+func (d *ScoopDriver) EnsureRepository(repoName string) error {
+	name, url, err := parseScoopBucket(repoName)
+	if err != nil {
+		return err
 	}
 
-	// Add the bucket
-	output, err = d.RunCommand("bucket", "add", bucketName)
+	available, err := d.IsRepositoryAvailable(name)
 	if err != nil {
-		return fmt.Errorf("failed to add Scoop bucket %s: %w\nOutput: %s", bucketName, err, output)
+		return err
+	}
+	if available {
+		return nil
 	}
 
+	args := []string{"bucket", "add", name}
+	if url != "" {
+		args = append(args, url)
+	}
+	output, err := d.RunCommand(args...)
+	if err != nil {
+		return fmt.Errorf("failed to add Scoop bucket %s: %w\nOutput: %s", name, err, output)
+	}
 	return nil
 }
 
 // IsRepositoryAvailable checks if a Scoop bucket is already available
-func (d *ScoopDriver) IsRepositoryAvailable(bucketName string) (bool, error) {
-	// Get list of available buckets
-	output, err := d.RunCommand("bucket", "list")
+func (d *ScoopDriver) IsRepositoryAvailable(repoName string) (bool, error) {
+	name, _, err := parseScoopBucket(repoName)
 	if err != nil {
-		return false, fmt.Errorf("failed to list Scoop buckets: %w", err)
+		return false, err
 	}
 
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Skip header lines
-		if strings.Contains(line, "Name") && strings.Contains(line, "Source") {
-			continue
-		}
-		if strings.Contains(line, "----") {
-			continue
-		}
-
-		// Parse the first column (bucket name)
-		parts := strings.Fields(line)
-		if len(parts) >= 1 && parts[0] == bucketName {
-			return true, nil
-		}
+	output, err := d.RunCommand("bucket", "list")
+	if err != nil {
+		return false, fmt.Errorf("failed to list Scoop buckets: %w", err)
 	}
 
-	return false, nil
+	_, exists := parseScoopBucketList(output)[strings.ToLower(name)]
+	return exists, nil
 }
 
 // IsAvailable overrides the base implementation to check platform compatibility
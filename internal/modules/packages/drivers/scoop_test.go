@@ -0,0 +1,160 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScoopDriver_Name(t *testing.T) {
+	driver := NewScoopDriver()
+	if driver.Name() != "scoop" {
+		t.Errorf("Name() = %q, want %q", driver.Name(), "scoop")
+	}
+	if !driver.SupportsVersionPinning() {
+		t.Error("expected scoop to support version pinning")
+	}
+}
+
+func TestParseScoopList(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []scoopApp
+	}{
+		{
+			fixture: "scoop_list.txt",
+			want: []scoopApp{
+				{Name: "7zip", Version: "23.01", Bucket: "main"},
+				{Name: "git", Version: "2.41.0.windows.1", Bucket: "main"},
+				{Name: "nodejs", Version: "20.5.0", Bucket: "main"},
+				{Name: "NameOf", Version: "1.0", Bucket: "mybucket"},
+			},
+		},
+		{
+			fixture: "scoop_list_legacy.txt",
+			want: []scoopApp{
+				{Name: "7zip", Version: "19.00", Bucket: "main"},
+				{Name: "git", Version: "2.23.0.windows.1", Bucket: "main"},
+				{Name: "vscode", Version: "1.38.1", Bucket: "extras"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatalf("failed to read fixture: %v", err)
+			}
+
+			apps := parseScoopList(string(data))
+			if len(apps) != len(tt.want) {
+				t.Fatalf("expected %d apps, got %d: %v", len(tt.want), len(apps), apps)
+			}
+			for i, want := range tt.want {
+				if *apps[i] != want {
+					t.Errorf("app %d = %+v, want %+v", i, *apps[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseScoopBucketList(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    map[string]string
+	}{
+		{
+			fixture: "scoop_bucket_list.txt",
+			want: map[string]string{
+				"main":     "https://github.com/ScoopInstaller/Main",
+				"extras":   "https://github.com/ScoopInstaller/Extras",
+				"mybucket": "https://github.com/me/scoop-bucket",
+			},
+		},
+		{
+			fixture: "scoop_bucket_list_legacy.txt",
+			want:    map[string]string{"main": "", "extras": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatalf("failed to read fixture: %v", err)
+			}
+
+			buckets := parseScoopBucketList(string(data))
+			if len(buckets) != len(tt.want) {
+				t.Fatalf("expected %d buckets, got %d: %v", len(tt.want), len(buckets), buckets)
+			}
+			for name, source := range tt.want {
+				if got, exists := buckets[name]; !exists || got != source {
+					t.Errorf("buckets[%q] = %q (exists %v), want %q", name, got, exists, source)
+				}
+			}
+		})
+	}
+}
+
+func TestParseScoopBucket(t *testing.T) {
+	tests := []struct {
+		input   string
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"extras", "extras", "", false},
+		{"mybucket https://github.com/me/bucket", "mybucket", "https://github.com/me/bucket", false},
+		{"", "", "", true},
+		{"a b c", "", "", true},
+	}
+
+	for _, tt := range tests {
+		name, url, err := parseScoopBucket(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseScoopBucket(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if name != tt.name || url != tt.url {
+			t.Errorf("parseScoopBucket(%q) = (%q, %q), want (%q, %q)", tt.input, name, url, tt.name, tt.url)
+		}
+	}
+}
+
+func TestSplitScoopApp(t *testing.T) {
+	tests := []struct {
+		input   string
+		app     string
+		version string
+	}{
+		{"git", "git", ""},
+		{"nodejs@20.5.0", "nodejs", "20.5.0"},
+		{"@scope", "@scope", ""},
+	}
+
+	for _, tt := range tests {
+		app, version := splitScoopApp(tt.input)
+		if app != tt.app || version != tt.version {
+			t.Errorf("splitScoopApp(%q) = (%q, %q), want (%q, %q)", tt.input, app, version, tt.app, tt.version)
+		}
+	}
+}
+
+func TestScoopAppName(t *testing.T) {
+	tests := map[string]string{
+		"git":                      "git",
+		"nodejs@20.5.0":            "nodejs",
+		"mybucket/my-tool":         "my-tool",
+		"mybucket/my-tool@1.2.3":   "my-tool",
+		"extras/vscode@1.90.0-rc1": "vscode",
+	}
+
+	for input, want := range tests {
+		if got := scoopAppName(input); got != want {
+			t.Errorf("scoopAppName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
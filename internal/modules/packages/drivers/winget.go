@@ -3,8 +3,11 @@ package drivers
 import (
 	"fmt"
 	"os/exec"
+	"regexp"
 	"runtime"
 	"strings"
+
+	"golang.org/x/text/width"
 )
 
 // WingetDriver implements PackageDriver for Windows Package Manager (winget)
@@ -19,126 +22,184 @@ func NewWingetDriver() *WingetDriver {
 	}
 }
 
+// wingetNoInput keeps winget from waiting for input or agreements that nobody answers
+var wingetNoInput = []string{"--disable-interactivity", "--accept-source-agreements"}
+
+// msstoreProductID matches Microsoft Store product IDs like "9NBLGGH4NNS1"
+var msstoreProductID = regexp.MustCompile(`^[0-9A-Z]{12}$`)
+
+// wingetSourceName matches the source a package spec names after "@"
+var wingetSourceName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// wingetSpec is a package as configured: an ID ("Git.Git", "Python.Python.3.12"),
+// a name with spaces ("Visual Studio Code") or a moniker ("vscode"), optionally
+// followed by the source to use ("9NBLGGH4NNS1@msstore")
+type wingetSpec struct {
+	Query  string
+	Source string
+}
+
+// parseWingetSpec splits a package name into the package and its source
+func parseWingetSpec(packageName string) wingetSpec {
+	packageName = strings.TrimSpace(packageName)
+	if i := strings.LastIndex(packageName, "@"); i > 0 && wingetSourceName.MatchString(packageName[i+1:]) {
+		return wingetSpec{Query: packageName[:i], Source: packageName[i+1:]}
+	}
+	return wingetSpec{Query: packageName}
+}
+
+// isID reports whether the package is a winget ID rather than a name or moniker
+func (s wingetSpec) isID() bool {
+	return !strings.Contains(s.Query, " ") && (strings.Contains(s.Query, ".") || msstoreProductID.MatchString(s.Query))
+}
+
+// args returns the arguments selecting the package. IDs and names match exactly, so
+// "Python.Python.3.1" does not select Python 3.12.
+func (s wingetSpec) args() []string {
+	var args []string
+	switch {
+	case s.isID():
+		args = []string{"--id", s.Query, "--exact"}
+	case strings.Contains(s.Query, " "):
+		args = []string{"--name", s.Query, "--exact"}
+	default:
+		args = []string{s.Query}
+	}
+	if s.Source != "" {
+		args = append(args, "--source", s.Source)
+	}
+	return args
+}
+
+// exactArgs returns the arguments selecting exactly the package, also when it is a
+// moniker. Monikers only match loosely on install so winget can resolve names too.
+func (s wingetSpec) exactArgs() []string {
+	args := s.args()
+	if !s.isID() && !strings.Contains(s.Query, " ") {
+		args = append(args, "--exact")
+	}
+	return args
+}
+
+// matches reports whether a row of winget output is the package
+func (s wingetSpec) matches(pkg *wingetPackage) bool {
+	if s.Source != "" && pkg.Source != "" && !strings.EqualFold(s.Source, pkg.Source) {
+		return false
+	}
+	return strings.EqualFold(pkg.ID, s.Query) || strings.EqualFold(pkg.Name, s.Query)
+}
+
+// wingetPackage is a row of `winget list` or `winget search` output
+type wingetPackage struct {
+	Name      string
+	ID        string
+	Version   string
+	Available string
+	Source    string
+}
+
 // IsPackageInstalled checks if a package is installed via Winget
 func (d *WingetDriver) IsPackageInstalled(packageName string) (bool, error) {
+	spec := parseWingetSpec(packageName)
+
 	// First try the cached approach
-	installed, err := d.IsPackageInstalledCached(packageName, d.fetchAllInstalledPackages)
+	installed, err := d.IsPackageInstalledCached(spec.Query, d.fetchAllInstalledPackages)
 	if err == nil && installed {
 		return true, nil
 	}
 
-	// If not found in cache, try direct lookup using winget show
-	// This handles cases where package names/monikers don't appear in winget list
-	return d.isPackageInstalledDirect(packageName)
+	// If not found in cache, ask winget about the package itself. This handles
+	// monikers that don't appear in winget list and IDs it truncated.
+	return d.isPackageInstalledDirect(spec)
 }
 
 // fetchAllInstalledPackages fetches all installed packages from Winget
 func (d *WingetDriver) fetchAllInstalledPackages() (map[string]bool, error) {
-	output, err := d.RunCommand("list")
+	output, err := d.RunCommand(append([]string{"list"}, wingetNoInput...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list installed packages: %w", err)
 	}
+	return wingetInstalledKeys(parseWingetTable(output)), nil
+}
 
-	packages := make(map[string]bool)
-	lines := strings.Split(output, "\n")
-	headerPassed := false
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// wingetInstalledKeys returns the names installed packages can be looked up by:
+// their names and IDs, and the product part of IDs ("nepnep.neofetch-win" -> "neofetch")
+func wingetInstalledKeys(packages []*wingetPackage) map[string]bool {
+	keys := make(map[string]bool)
+	add := func(key string) {
+		if key != "" && !strings.HasSuffix(key, "…") {
+			keys[key] = true
+			keys[strings.ToLower(key)] = true
 		}
+	}
 
-		// Skip header lines and empty lines
-		if strings.Contains(line, "Name") && strings.Contains(line, "Id") && strings.Contains(line, "Version") {
-			continue
-		}
-		if strings.Contains(line, "---") {
-			headerPassed = true
-			continue
-		}
-		if !headerPassed {
-			continue
-		}
+	for _, pkg := range packages {
+		add(pkg.Name)
+		add(pkg.ID)
+		add(trimWingetSuffixes(strings.ToLower(pkg.Name)))
 
-		// Skip lines that don't look like package entries
-		if strings.HasPrefix(line, "The following packages") ||
-		   strings.HasPrefix(line, "No installed packages") ||
-		   strings.Contains(line, "packages found") {
-			continue
+		// The last part of versioned IDs like "Python.Python.3.12" is not a name
+		if i := strings.LastIndex(pkg.ID, "."); i >= 0 && !isNumeric(pkg.ID[i+1:]) {
+			add(trimWingetSuffixes(pkg.ID[i+1:]))
 		}
+	}
+	return keys
+}
 
-		// Winget output format: "Name Id Version Available Source"
-		// Split by multiple whitespace to handle varying spacing
-		fields := strings.Fields(line)
-		if len(fields) >= 2 {
-			name := fields[0]
-			id := fields[1]
-
-			// Store both name and ID for lookups, including case variations
-			packages[name] = true
-			packages[id] = true
-			packages[strings.ToLower(name)] = true
-			packages[strings.ToLower(id)] = true
-
-			// Extract base name from ID for better matching (e.g. "nepnep.neofetch-win" -> "neofetch")
-			if strings.Contains(id, ".") {
-				idParts := strings.Split(id, ".")
-				if len(idParts) > 1 {
-					baseName := idParts[len(idParts)-1] // Take the last part
-					// Remove common suffixes like "-win", "-cli", etc.
-					baseName = strings.TrimSuffix(baseName, "-win")
-					baseName = strings.TrimSuffix(baseName, "-cli")
-					baseName = strings.TrimSuffix(baseName, "-windows")
-					packages[baseName] = true
-					packages[strings.ToLower(baseName)] = true
-				}
-			}
+// trimWingetSuffixes removes common suffixes like "-win" and "-cli" from a name
+func trimWingetSuffixes(name string) string {
+	name = strings.TrimSuffix(name, "-win")
+	name = strings.TrimSuffix(name, "-cli")
+	return strings.TrimSuffix(name, "-windows")
+}
 
-			// Also check if name contains common patterns (remove suffixes)
-			cleanName := strings.TrimSuffix(strings.ToLower(name), "-win")
-			cleanName = strings.TrimSuffix(cleanName, "-cli")
-			cleanName = strings.TrimSuffix(cleanName, "-windows")
-			if cleanName != strings.ToLower(name) {
-				packages[cleanName] = true
-			}
+// isNumeric reports whether s only consists of digits
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
 		}
 	}
-
-	return packages, nil
+	return true
 }
 
-// isPackageInstalledDirect checks if a package is installed by trying to get its info
-func (d *WingetDriver) isPackageInstalledDirect(packageName string) (bool, error) {
-	// Try to get package info - if it succeeds and shows as installed, package exists
-	output, err := d.RunCommand("show", packageName)
+// isPackageInstalledDirect checks if a package is installed by listing only that package
+func (d *WingetDriver) isPackageInstalledDirect(spec wingetSpec) (bool, error) {
+	packages, err := d.listPackage(spec)
 	if err != nil {
-		// If winget show fails, package is not available/installed
-		return false, nil
-	}
-
-	// Check if the output indicates the package is installed
-	outputLower := strings.ToLower(output)
-	if strings.Contains(outputLower, "no package found") ||
-	   strings.Contains(outputLower, "no packages found") {
+		// winget exits with an error when nothing matches
 		return false, nil
 	}
+	return len(packages) > 0, nil
+}
 
-	// If we can show the package info, try to check if it's in the installed list
-	// by running a more specific list command
-	listOutput, listErr := d.RunCommand("list", "--exact", packageName)
-	if listErr != nil {
-		return false, nil
+// listPackage runs `winget list` for a single package
+func (d *WingetDriver) listPackage(spec wingetSpec) ([]*wingetPackage, error) {
+	args := append([]string{"list"}, spec.exactArgs()...)
+	output, err := d.RunCommand(append(args, wingetNoInput...)...)
+	if err != nil {
+		return nil, err
 	}
+	return parseWingetTable(output), nil
+}
 
-	// If the list command returns the package, it's installed
-	listLower := strings.ToLower(listOutput)
-	return !strings.Contains(listLower, "no installed packages") &&
-	       !strings.Contains(listLower, "no packages found") &&
-	       strings.Contains(listLower, strings.ToLower(packageName)), nil
+// wingetAlreadyInstalled reports whether install output means the package is installed
+// and there is nothing to upgrade. Packages installed with another install technology
+// (e.g. an MSI where winget now has an MSIX) can't be upgraded in place either.
+func wingetAlreadyInstalled(output string) bool {
+	outputLower := strings.ToLower(output)
+	return strings.Contains(outputLower, "already installed") ||
+		strings.Contains(outputLower, "no available upgrade found") ||
+		strings.Contains(outputLower, "trying to upgrade the installed package") ||
+		strings.Contains(outputLower, "no newer package versions are available") ||
+		strings.Contains(outputLower, "install technology is different")
 }
 
-// InstallPackage installs a package using Winget
+// InstallPackage installs a package using Winget. Packages can name their source,
+// e.g. "9NBLGGH4NNS1@msstore" for apps only published in the Microsoft Store.
 func (d *WingetDriver) InstallPackage(packageName string) error {
 	// First check if the package is already installed
 	isInstalled, err := d.IsPackageInstalled(packageName)
@@ -149,7 +210,32 @@ func (d *WingetDriver) InstallPackage(packageName string) error {
 		return nil
 	}
 
-	output, err := d.RunCommand("install", "--silent", "--accept-package-agreements", "--accept-source-agreements", packageName)
+	return d.install(packageName)
+}
+
+// InstallPackages installs packages one after the other, winget installs one
+// package per command. The packages were checked to be missing, so unlike
+// InstallPackage it does not ask winget about each of them again, and the source
+// agreements accepted by the first command are not asked for again.
+func (d *WingetDriver) InstallPackages(packageNames []string) error {
+	failed := make(map[string]error)
+	for _, name := range packageNames {
+		if err := d.install(name); err != nil {
+			failed[name] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchInstallError{Failed: failed}
+	}
+	return nil
+}
+
+// install installs a package that is not installed yet
+func (d *WingetDriver) install(packageName string) error {
+	spec := parseWingetSpec(packageName)
+	args := append([]string{"install"}, spec.args()...)
+	args = append(args, "--silent", "--accept-package-agreements")
+	output, err := d.RunCommand(append(args, wingetNoInput...)...)
 	if err != nil {
 		// Check if this is the "already installed" error
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -159,13 +245,7 @@ func (d *WingetDriver) InstallPackage(packageName string) error {
 			}
 		}
 
-		// Check output for already installed messages
-		outputLower := strings.ToLower(output)
-		if strings.Contains(outputLower, "already installed") ||
-		   strings.Contains(outputLower, "existing package already installed") ||
-		   strings.Contains(outputLower, "no available upgrade found") ||
-		   strings.Contains(outputLower, "trying to upgrade the installed package") ||
-		   strings.Contains(outputLower, "no newer package versions are available") {
+		if wingetAlreadyInstalled(output) {
 			return nil // Not an error, package is already installed
 		}
 
@@ -174,9 +254,32 @@ func (d *WingetDriver) InstallPackage(packageName string) error {
 	return nil
 }
 
+// InstallPackageVersion installs a specific package version using Winget
+func (d *WingetDriver) InstallPackageVersion(packageName, version string) error {
+	spec := parseWingetSpec(packageName)
+	args := append([]string{"install"}, spec.args()...)
+	args = append(args, "--silent", "--accept-package-agreements", "--force", "--version", version)
+	output, err := d.RunCommand(append(args, wingetNoInput...)...)
+	if err != nil {
+		if strings.Contains(strings.ToLower(output), "install technology is different") {
+			return fmt.Errorf("failed to install package %s %s via Winget: the installed version uses another install technology, uninstall it first\nOutput: %s", packageName, version, output)
+		}
+		return fmt.Errorf("failed to install package %s %s via Winget: %w\nOutput: %s", packageName, version, err, output)
+	}
+	return nil
+}
+
+// SupportsVersionPinning reports that Winget can install specific versions
+func (d *WingetDriver) SupportsVersionPinning() bool {
+	return true
+}
+
 // UninstallPackage uninstalls a package using Winget
 func (d *WingetDriver) UninstallPackage(packageName string) error {
-	output, err := d.RunCommand("uninstall", "--exact", "--silent", packageName)
+	spec := parseWingetSpec(packageName)
+	args := append([]string{"uninstall"}, spec.exactArgs()...)
+	args = append(args, "--silent", "--disable-interactivity")
+	output, err := d.RunCommand(args...)
 	if err != nil {
 		return fmt.Errorf("failed to uninstall package %s via Winget: %w\nOutput: %s", packageName, err, output)
 	}
@@ -185,98 +288,299 @@ func (d *WingetDriver) UninstallPackage(packageName string) error {
 
 // SearchPackage searches for packages using Winget
 func (d *WingetDriver) SearchPackage(packageName string) ([]string, error) {
-	output, err := d.RunCommand("search", packageName)
+	spec := parseWingetSpec(packageName)
+	args := []string{"search", spec.Query}
+	if spec.Source != "" {
+		args = append(args, "--source", spec.Source)
+	}
+	output, err := d.RunCommand(append(args, wingetNoInput...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search for package %s: %w", packageName, err)
 	}
 
 	var packages []string
-	lines := strings.Split(output, "\n")
-	headerPassed := false
+	for _, pkg := range parseWingetTable(output) {
+		packages = append(packages, pkg.ID)
+	}
+	return packages, nil
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// GetPackageInfo gets information about an installed package
+func (d *WingetDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	spec := parseWingetSpec(packageName)
+	packages, err := d.listPackage(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("package %s not found", packageName)
+	}
+
+	// Monikers match packages by another name, prefer a row that is the package itself
+	pkg := packages[0]
+	for _, candidate := range packages {
+		if spec.matches(candidate) {
+			pkg = candidate
+			break
 		}
+	}
+	return map[string]string{
+		"name":    pkg.Name,
+		"id":      pkg.ID,
+		"version": pkg.Version,
+		"source":  pkg.Source,
+		"manager": "winget",
+	}, nil
+}
+
+// GetAllInstalledPackages returns a map of all installed packages
+func (d *WingetDriver) GetAllInstalledPackages() (map[string]bool, error) {
+	return d.fetchAllInstalledPackages()
+}
+
+// IsAvailable overrides the base implementation to check platform compatibility
+func (d *WingetDriver) IsAvailable() bool {
+	// Winget is only available on Windows
+	if runtime.GOOS != "windows" {
+		return false
+	}
+
+	return d.BaseDriver.IsAvailable()
+}
+
+// parseWingetTable parses the tables `winget list` and `winget search` print. Columns
+// are found from the positions of the header names, so names with spaces and columns
+// left empty (e.g. Source of apps installed outside winget) parse correctly. Positions
+// are display columns: wide characters like CJK take two.
+func parseWingetTable(output string) []*wingetPackage {
+	lines := wingetLines(output)
 
-		// Skip until we pass the header
-		if strings.Contains(line, "---") {
-			headerPassed = true
+	var packages []*wingetPackage
+	for i := 1; i < len(lines); i++ {
+		if !isWingetSeparator(lines[i]) {
 			continue
 		}
-		if !headerPassed {
+		columns := wingetColumns(lines[i-1])
+		if len(columns) < 2 {
 			continue
 		}
+		tableWidth := displayWidth(lines[i])
+
+		var previous string
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if line == "" || (i+1 < len(lines) && isWingetSeparator(lines[i+1])) {
+				break
+			}
 
-		// Extract package ID from the line
-		fields := strings.Fields(line)
-		if len(fields) >= 2 {
-			// Usually the second field is the package ID
-			packages = append(packages, fields[1])
+			// A row wider than the table wrapped onto the next line
+			if previous != "" && displayWidth(previous) > tableWidth && isWingetContinuation(line, columns) {
+				line = previous + line
+				packages = packages[:len(packages)-1]
+			}
+			previous = line
+
+			if pkg := parseWingetRow(line, columns); pkg.ID != "" {
+				packages = append(packages, pkg)
+			} else {
+				previous = ""
+			}
 		}
+		i--
 	}
+	return packages
+}
 
-	return packages, nil
+// wingetLines splits output into lines without the progress spinner winget draws
+// with carriage returns before printing the table
+func wingetLines(output string) []string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		if j := strings.LastIndex(line, "\r"); j >= 0 {
+			line = line[j+1:]
+		}
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return lines
 }
 
-// GetPackageInfo gets information about an installed package
-func (d *WingetDriver) GetPackageInfo(packageName string) (map[string]string, error) {
-	output, err := d.RunCommand("list", "--exact", packageName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get package info for %s: %w", packageName, err)
+// isWingetSeparator reports whether a line is the dashes under a table header
+func isWingetSeparator(line string) bool {
+	return len(line) >= 3 && strings.Trim(line, "-") == ""
+}
+
+// wingetColumn is a column of a winget table
+type wingetColumn struct {
+	Name  string
+	Start int // Display column the values start at
+}
+
+// wingetColumns returns the columns of a header line
+func wingetColumns(header string) []wingetColumn {
+	var columns []wingetColumn
+	position := 0
+	inWord := false
+	for _, r := range header {
+		if r == ' ' {
+			inWord = false
+		} else if !inWord {
+			inWord = true
+			columns = append(columns, wingetColumn{Start: position})
+		}
+		if inWord {
+			columns[len(columns)-1].Name += string(r)
+		}
+		position += runeWidth(r)
 	}
+	return columns
+}
 
-	info := make(map[string]string)
-	lines := strings.Split(output, "\n")
-	headerPassed := false
+// isWingetContinuation reports whether a line is the rest of a wrapped row: it ends
+// before the second column or does not have a space in front of it
+func isWingetContinuation(line string, columns []wingetColumn) bool {
+	cells := displayCells(line)
+	start := columns[1].Start
+	return len(cells) <= start || cells[start-1] != ' '
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// parseWingetRow splits a row at the column positions. A value longer than its column
+// (IDs are not always cut off) pushes the rest of the row to the right, those values
+// are matched to the nearest columns instead.
+func parseWingetRow(line string, columns []wingetColumn) *wingetPackage {
+	cells := displayCells(line)
+
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		if column.Start >= len(cells) {
+			break
+		}
+		end := len(cells)
+		if i+1 < len(columns) {
+			end = min(columns[i+1].Start, len(cells))
+		}
+		if end < len(cells) && cells[end-1] != ' ' && cells[end] != ' ' {
+			for end < len(cells) && cells[end] != ' ' {
+				end++
+			}
+			values[i] = strings.TrimSpace(cellsString(cells[column.Start:end]))
+			assignShiftedValues(values, columns[i+1:], cells, end)
+			break
+		}
+		values[i] = strings.TrimSpace(cellsString(cells[column.Start:end]))
+	}
+
+	pkg := &wingetPackage{}
+	for i, column := range columns {
+		switch strings.ToLower(column.Name) {
+		case "name":
+			pkg.Name = values[i]
+		case "id":
+			pkg.ID = values[i]
+		case "version":
+			pkg.Version = values[i]
+		case "available":
+			pkg.Available = values[i]
+		case "source":
+			pkg.Source = values[i]
 		}
+	}
 
-		// Skip until we pass the header
-		if strings.Contains(line, "---") {
-			headerPassed = true
-			continue
+	// Localized headers: the first three columns are always name, ID and version
+	if pkg.Name == "" && pkg.ID == "" {
+		pkg.Name = values[0]
+		pkg.ID = values[1]
+		if len(values) > 2 {
+			pkg.Version = values[2]
 		}
-		if !headerPassed {
+	}
+	return pkg
+}
+
+// assignShiftedValues matches the words of a row from display column from onwards to
+// the nearest of columns, keeping their order
+func assignShiftedValues(values []string, columns []wingetColumn, cells []rune, from int) {
+	type word struct {
+		start int
+		text  string
+	}
+	var words []word
+	for i := from; i < len(cells); i++ {
+		if cells[i] == ' ' {
 			continue
 		}
+		start := i
+		for i < len(cells) && cells[i] != ' ' {
+			i++
+		}
+		words = append(words, word{start: start, text: cellsString(cells[start:i])})
+	}
 
-		// Check if this line contains our package
-		if strings.Contains(strings.ToLower(line), strings.ToLower(packageName)) {
-			fields := strings.Fields(line)
-			if len(fields) >= 3 {
-				info["name"] = fields[0]
-				info["id"] = fields[1]
-				info["version"] = fields[2]
-				info["manager"] = "winget"
-				break
+	offset := len(values) - len(columns)
+	next := 0
+	for k, w := range words {
+		last := len(columns) - (len(words) - k)
+		if last < next {
+			return // More words than columns, values with spaces can't be told apart
+		}
+		best := next
+		for c := next + 1; c <= last; c++ {
+			if abs(w.start-columns[c].Start) < abs(w.start-columns[best].Start) {
+				best = c
 			}
 		}
+		values[offset+best] = w.text
+		next = best + 1
 	}
+}
 
-	if len(info) == 0 {
-		return nil, fmt.Errorf("package %s not found", packageName)
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
 	}
+	return n
+}
 
-	return info, nil
+// displayCells returns a rune per display column of s. The second column of a wide
+// rune is a zero rune.
+func displayCells(s string) []rune {
+	var cells []rune
+	for _, r := range s {
+		cells = append(cells, r)
+		if runeWidth(r) == 2 {
+			cells = append(cells, 0)
+		}
+	}
+	return cells
 }
 
-// GetAllInstalledPackages returns a map of all installed packages
-func (d *WingetDriver) GetAllInstalledPackages() (map[string]bool, error) {
-	return d.fetchAllInstalledPackages()
+// cellsString turns display cells back into a string
+func cellsString(cells []rune) string {
+	var b strings.Builder
+	for _, r := range cells {
+		if r != 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
 }
 
-// IsAvailable overrides the base implementation to check platform compatibility
-func (d *WingetDriver) IsAvailable() bool {
-	// Winget is only available on Windows
-	if runtime.GOOS != "windows" {
-		return false
+// displayWidth returns the number of display columns of s
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
 	}
+	return n
+}
 
-	return d.BaseDriver.IsAvailable()
+// runeWidth returns the number of display columns of r
+func runeWidth(r rune) int {
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	default:
+		return 1
+	}
 }
@@ -0,0 +1,143 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readWingetFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return string(data)
+}
+
+func TestParseWingetTable(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []wingetPackage
+	}{
+		{
+			fixture: "winget_list.txt",
+			want: []wingetPackage{
+				{Name: "7-Zip 23.01 (x64)", ID: "7zip.7zip", Version: "23.01", Source: "winget"},
+				{Name: "Git", ID: "Git.Git", Version: "2.43.0", Available: "2.44.0", Source: "winget"},
+				{Name: "Python 3.12.1 (64-bit)", ID: "Python.Python.3.12", Version: "3.12.1150.0", Source: "winget"},
+				{Name: "Microsoft Visual C++ 2015-2022 Redis…", ID: "Microsoft.VCRedist.2015+.x64", Version: "14.38.33130.0", Available: "14.40.33810.0", Source: "winget"},
+				{Name: "카카오톡", ID: "Kakao.KakaoTalk", Version: "3.4.0.3254", Source: "winget"},
+				{Name: "Café Notes", ID: "Example.CafeNotes", Version: "1.0", Source: "winget"},
+				{Name: "WhatsApp", ID: "9NKSQGP7F2NH", Version: "2.2410.1.0", Source: "msstore"},
+				{Name: "Microsoft Edge", ID: "Microsoft.Edge", Version: "122.0.2365.92"},
+				{Name: "App Installer", ID: "Microsoft.DesktopAppInstaller_8wek…", Version: "1.22.10582.0"},
+			},
+		},
+		{
+			fixture: "winget_list_wrapped.txt",
+			want: []wingetPackage{
+				{Name: "Git", ID: "Git.Git", Version: "2.43.0", Source: "winget"},
+				{Name: "Windows Software D…", ID: "Microsoft.WindowsSDK.10.0.22621", Version: "10.0.22621.2428", Source: "winget"},
+				{Name: "JetBrains Toolbox", ID: "JetBrains.Toolbox", Version: "2.2.2.20062", Source: "winget"},
+			},
+		},
+		{
+			fixture: "winget_list_none.txt",
+		},
+		{
+			fixture: "winget_search.txt",
+			want: []wingetPackage{
+				{Name: "Microsoft PowerToys", ID: "Microsoft.PowerToys", Version: "0.79.0", Source: "winget"},
+				{Name: "PowerToys Run Plugin", ID: "Example.PTRun", Version: "1.2.0", Source: "winget"},
+				{Name: "Microsoft PowerToys", ID: "XP89DCGQ3K6VLD", Version: "Unknown", Source: "msstore"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			packages := parseWingetTable(readWingetFixture(t, tt.fixture))
+			if len(packages) != len(tt.want) {
+				t.Fatalf("expected %d packages, got %d: %+v", len(tt.want), len(packages), packages)
+			}
+			for i, want := range tt.want {
+				if *packages[i] != want {
+					t.Errorf("package %d = %+v, want %+v", i, *packages[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWingetInstalledKeys(t *testing.T) {
+	keys := wingetInstalledKeys(parseWingetTable(readWingetFixture(t, "winget_list.txt")))
+
+	for _, key := range []string{"Git.Git", "git.git", "git", "Python.Python.3.12", "9NKSQGP7F2NH", "카카오톡", "KakaoTalk", "7zip"} {
+		if !keys[key] {
+			t.Errorf("expected %q to be installed", key)
+		}
+	}
+	// Truncated IDs and the version part of versioned IDs are not names
+	for _, key := range []string{"12", "Microsoft.DesktopAppInstaller_8wek…", "Python.Python.3.1"} {
+		if keys[key] {
+			t.Errorf("expected %q not to be a key", key)
+		}
+	}
+}
+
+func TestWingetSpecArgs(t *testing.T) {
+	tests := []struct {
+		packageName string
+		args        []string
+		exactArgs   []string
+	}{
+		{
+			packageName: "Git.Git",
+			args:        []string{"--id", "Git.Git", "--exact"},
+			exactArgs:   []string{"--id", "Git.Git", "--exact"},
+		},
+		{
+			packageName: "Python.Python.3.12",
+			args:        []string{"--id", "Python.Python.3.12", "--exact"},
+			exactArgs:   []string{"--id", "Python.Python.3.12", "--exact"},
+		},
+		{
+			packageName: "9NKSQGP7F2NH@msstore",
+			args:        []string{"--id", "9NKSQGP7F2NH", "--exact", "--source", "msstore"},
+			exactArgs:   []string{"--id", "9NKSQGP7F2NH", "--exact", "--source", "msstore"},
+		},
+		{
+			packageName: "Visual Studio Code",
+			args:        []string{"--name", "Visual Studio Code", "--exact"},
+			exactArgs:   []string{"--name", "Visual Studio Code", "--exact"},
+		},
+		{
+			packageName: "vscode",
+			args:        []string{"vscode"},
+			exactArgs:   []string{"vscode", "--exact"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.packageName, func(t *testing.T) {
+			spec := parseWingetSpec(tt.packageName)
+			if got := spec.args(); !reflect.DeepEqual(got, tt.args) {
+				t.Errorf("args() = %q, want %q", got, tt.args)
+			}
+			if got := spec.exactArgs(); !reflect.DeepEqual(got, tt.exactArgs) {
+				t.Errorf("exactArgs() = %q, want %q", got, tt.exactArgs)
+			}
+		})
+	}
+}
+
+func TestWingetAlreadyInstalled(t *testing.T) {
+	if !wingetAlreadyInstalled("A newer version was found, but the install technology is different from the current version installed. Please uninstall the package and install the newer version.") {
+		t.Error("expected a different install technology to count as installed")
+	}
+	if wingetAlreadyInstalled("No package found matching input criteria.") {
+		t.Error("expected a missing package not to count as installed")
+	}
+}
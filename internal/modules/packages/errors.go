@@ -0,0 +1,61 @@
+package packages
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// notFoundPattern matches what package managers print when asked for a package
+// that does not exist. Running the task again cannot fix a typo in its name.
+var notFoundPattern = regexp.MustCompile(`(?i)` +
+	`unable to locate package` + // apt
+	`|no match for argument` + // dnf
+	`|no package \S+ available` + // yum
+	`|no provider of` + // zypper
+	`|target not found` + // pacman
+	`|unable to select packages` + // apk
+	`|no available formula|no formulae or casks found` + // brew
+	`|no package found matching` + // winget
+	`|package was not found with the source` + // chocolatey
+	`|couldn't find manifest|not found in (available )?scoop` + // scoop
+	`|could not find ` + "`" + // cargo
+	`|no matching distribution found` + // pipx
+	`|\bE404\b` + // npm
+	`|nothing matches`, // flatpak
+)
+
+// permanentError marks errors that retrying a package task cannot fix, such as a
+// package that does not exist, as permanent. Errors of several packages are only
+// permanent when every one of them is.
+func permanentError(err error) error {
+	if err == nil || modules.IsPermanent(err) {
+		return err
+	}
+	if cannotRetry(err) {
+		return modules.Permanent(err)
+	}
+	return err
+}
+
+// cannotRetry returns whether err, or every error it joins, is one that retrying
+// does not fix
+func cannotRetry(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, packageErr := range errs {
+			if !cannotRetry(packageErr) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+	// The message of an error wrapping the errors of several packages names them all
+	for wrapped := errors.Unwrap(err); wrapped != nil; wrapped = errors.Unwrap(wrapped) {
+		if _, ok := wrapped.(interface{ Unwrap() []error }); ok {
+			return cannotRetry(wrapped)
+		}
+	}
+	return modules.IsPermanent(err) || notFoundPattern.MatchString(err.Error())
+}
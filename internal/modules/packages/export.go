@@ -0,0 +1,145 @@
+package packages
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// ExportedPackage is a package the jobs install with one package manager
+type ExportedPackage struct {
+	Name    string // Name of the package for the package manager
+	Version string // Pinned version, empty for any version
+	Cask    bool   // Whether the package is a Homebrew cask
+}
+
+// PackageExport lists what the jobs manage with one package manager, as configured
+// rather than as installed on this machine
+type PackageExport struct {
+	Manager      string
+	Repositories []string // Repositories as "<name>" or "<name> <url>"
+	Packages     []*ExportedPackage
+	Wildcards    []string // Wildcard names, which only resolve when installing
+}
+
+// ExportPackages collects the repositories and packages tasks add with a package
+// manager. Packages restricted to other managers with only are left out, packages
+// that prefer another manager are included since the manager is picked on the
+// machine that applies them. Repositories are only included when their task names
+// the manager or no manager at all, because repository names are manager specific.
+// Package name candidates are chosen with variables.
+func (m *PackagesModule) ExportPackages(tasks []*config.Task, manager string, variables map[string]interface{}) (*PackageExport, error) {
+	driver, err := m.driverRegistry.GetDriver(manager)
+	if err != nil {
+		return nil, fmt.Errorf("unknown package manager '%s'", manager)
+	}
+	export := &PackageExport{Manager: driver.Name()}
+
+	seen := make(map[string]bool)
+	addPackage := func(pkg *PackageConfig) error {
+		if pkg.State != "present" || (len(pkg.Only) > 0 && !m.listsManager(pkg.Only, export.Manager)) {
+			return nil
+		}
+		name, err := m.getPackageNameForManager(pkg, export.Manager, variables)
+		if err != nil {
+			return err
+		}
+		if m.isWildcardPattern(name) {
+			export.Wildcards = append(export.Wildcards, name)
+			return nil
+		}
+		key := fmt.Sprintf("%s %s %t", name, pkg.Version, pkg.Cask)
+		if !seen[key] {
+			seen[key] = true
+			export.Packages = append(export.Packages, &ExportedPackage{Name: name, Version: pkg.Version, Cask: pkg.Cask})
+		}
+		return nil
+	}
+
+	for _, task := range tasks {
+		switch task.Action {
+		case "install_package", "manage_packages":
+			for _, pkg := range taskPackages(task) {
+				if err := addPackage(pkg); err != nil {
+					return nil, err
+				}
+			}
+		case "add_repo":
+			only, prefer := toStringSlice(task.Config["only"]), toStringSlice(task.Config["prefer"])
+			if (len(only) == 0 && len(prefer) == 0) || m.listsManager(only, export.Manager) || m.listsManager(prefer, export.Manager) {
+				repo := repositorySpec(task.Config)
+				if !seen[repo] {
+					seen[repo] = true
+					export.Repositories = append(export.Repositories, repo)
+				}
+			}
+		}
+	}
+
+	sort.Strings(export.Repositories)
+	sort.Slice(export.Packages, func(i, j int) bool {
+		if export.Packages[i].Cask != export.Packages[j].Cask {
+			return !export.Packages[i].Cask
+		}
+		return export.Packages[i].Name < export.Packages[j].Name
+	})
+	sort.Strings(export.Wildcards)
+	return export, nil
+}
+
+// listsManager reports whether a list of package manager names or aliases includes
+// the manager
+func (m *PackagesModule) listsManager(managers []string, manager string) bool {
+	for _, name := range managers {
+		if driver, err := m.driverRegistry.GetDriver(name); err == nil && driver.Name() == manager {
+			return true
+		}
+	}
+	return false
+}
+
+// Format formats the export as a Brewfile for Homebrew, so it can be compared with
+// the output of brew bundle dump, or as one package per line for other managers
+func (e *PackageExport) Format() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Packages managed with %s by dotfiles\n", e.Manager)
+
+	if e.Manager == "homebrew" {
+		for _, repo := range e.Repositories {
+			if name, url, found := strings.Cut(repo, " "); found {
+				fmt.Fprintf(&b, "tap %q, %q\n", name, url)
+			} else {
+				fmt.Fprintf(&b, "tap %q\n", repo)
+			}
+		}
+		for _, pkg := range e.Packages {
+			switch {
+			case pkg.Cask:
+				fmt.Fprintf(&b, "cask %q\n", pkg.Name)
+			case pkg.Version != "":
+				// Homebrew pins versions with versioned formulae
+				fmt.Fprintf(&b, "brew %q\n", pkg.Name+"@"+pkg.Version)
+			default:
+				fmt.Fprintf(&b, "brew %q\n", pkg.Name)
+			}
+		}
+	} else {
+		for _, repo := range e.Repositories {
+			fmt.Fprintf(&b, "# repository: %s\n", repo)
+		}
+		for _, pkg := range e.Packages {
+			if pkg.Version != "" {
+				fmt.Fprintf(&b, "%s %s\n", pkg.Name, pkg.Version)
+			} else {
+				fmt.Fprintln(&b, pkg.Name)
+			}
+		}
+	}
+
+	for _, pattern := range e.Wildcards {
+		fmt.Fprintf(&b, "# %s is a wildcard and resolves to packages when installing\n", pattern)
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package packages
+
+import (
+	"fmt"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+)
+
+// PackageNameCandidate is one of the names a package may have for a package
+// manager, used when its condition is true
+type PackageNameCandidate struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition,omitempty"` // Condition like a task condition, empty to always match
+}
+
+// parseManagerNames reads the managers of a package, which map a package manager
+// to a name or to a list of candidate names. Names go into pkg.Managers, lists into
+// pkg.ManagerCandidates.
+func parseManagerNames(pkg *PackageConfig, managers interface{}) {
+	mgrsMap, ok := managers.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for manager, value := range mgrsMap {
+		switch value := value.(type) {
+		case string:
+			if pkg.Managers == nil {
+				pkg.Managers = make(map[string]string)
+			}
+			pkg.Managers[manager] = value
+		case []interface{}:
+			candidates := make([]PackageNameCandidate, 0, len(value))
+			for _, item := range value {
+				if entry, ok := item.(map[string]interface{}); ok {
+					name, _ := entry["name"].(string)
+					condition, _ := entry["condition"].(string)
+					candidates = append(candidates, PackageNameCandidate{Name: name, Condition: condition})
+				}
+			}
+			if pkg.ManagerCandidates == nil {
+				pkg.ManagerCandidates = make(map[string][]PackageNameCandidate)
+			}
+			pkg.ManagerCandidates[manager] = candidates
+		}
+	}
+}
+
+// validatePackageManagers validates the optional managers field of a package
+func validatePackageManagers(config map[string]interface{}) error {
+	managers, exists := config["managers"]
+	if !exists {
+		return nil
+	}
+
+	mgrsMap, ok := managers.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("managers must map package managers to package names")
+	}
+	for manager, value := range mgrsMap {
+		switch value := value.(type) {
+		case string:
+			if value == "" {
+				return fmt.Errorf("managers.%s cannot be empty", manager)
+			}
+		case []interface{}:
+			if len(value) == 0 {
+				return fmt.Errorf("managers.%s cannot be an empty list", manager)
+			}
+			for i, item := range value {
+				entry, ok := item.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("managers.%s[%d] must be an object with name and an optional condition", manager, i)
+				}
+				if name, ok := entry["name"].(string); !ok || name == "" {
+					return fmt.Errorf("managers.%s[%d]: name is required", manager, i)
+				}
+				if condition, exists := entry["condition"]; exists {
+					if _, ok := condition.(string); !ok {
+						return fmt.Errorf("managers.%s[%d]: condition must be a string", manager, i)
+					}
+				}
+				for key := range entry {
+					if key != "name" && key != "condition" {
+						return fmt.Errorf("managers.%s[%d]: unknown field '%s', only name and condition are supported", manager, i, key)
+					}
+				}
+			}
+		default:
+			return fmt.Errorf("managers.%s must be a package name or a list of {name, condition} objects, got %v", manager, value)
+		}
+	}
+
+	return nil
+}
+
+// getPackageNameForManager gets the package name for a specific manager. Candidate
+// names are tried in order and the first whose condition is true wins, evaluated
+// with variables. Without a matching candidate the generic name is used.
+func (m *PackagesModule) getPackageNameForManager(pkg *PackageConfig, manager string, variables map[string]interface{}) (string, error) {
+	if name, exists := pkg.Managers[manager]; exists {
+		return name, nil
+	}
+
+	candidates, exists := pkg.ManagerCandidates[manager]
+	if !exists {
+		return pkg.Name, nil // fallback to generic name
+	}
+
+	log := logger.Get()
+	engine := m.templateEngine
+	if engine == nil {
+		engine = templating.NewTemplatingEngine(".")
+	}
+	for i, candidate := range candidates {
+		matched, err := engine.EvaluateCondition(candidate.Condition, variables)
+		if err != nil {
+			return "", fmt.Errorf("package %s: failed to evaluate condition of managers.%s[%d]: %w", pkg.Name, manager, i, err)
+		}
+		if matched {
+			log.Debug().
+				Str("package", pkg.Name).
+				Str("manager", manager).
+				Int("candidate", i).
+				Str("package_name", candidate.Name).
+				Str("condition", candidate.Condition).
+				Msg("Package name candidate matched")
+			return candidate.Name, nil
+		}
+	}
+
+	log.Debug().
+		Str("package", pkg.Name).
+		Str("manager", manager).
+		Int("candidates", len(candidates)).
+		Msg("No package name candidate matched, using the package name")
+	return pkg.Name, nil
+}
@@ -1,9 +1,11 @@
 package packages
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
@@ -11,12 +13,16 @@ import (
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages/drivers"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
 )
 
 // PackagesModule handles package management operations
 type PackagesModule struct {
 	platformInfo    *platform.PlatformInfo
 	driverRegistry  *drivers.DriverRegistry
+	templateEngine  *templating.TemplatingEngine // evaluates the conditions of package name candidates
+	lookPath        func(string) (string, error) // finds commands for check_system_wide, exec.LookPath when nil
 }
 
 // PackageConfig represents a package configuration
@@ -24,12 +30,26 @@ type PackageConfig struct {
 	Name            string            `json:"name"`
 	State           string            `json:"state"`             // "present" or "absent"
 	Managers        map[string]string `json:"managers"`          // package manager specific names
+	ManagerCandidates map[string][]PackageNameCandidate `json:"manager_candidates,omitempty"` // package manager specific names chosen by condition, first match wins
 	Prefer          []string          `json:"prefer"`            // preferred package manager order
 	Only            []string          `json:"only"`              // only allow these package managers (no fallback)
 	CheckSystemWide bool              `json:"check_system_wide"` // check if command is available system-wide before installing
-
+	Commands        []string          `json:"command"`           // commands check_system_wide looks for, empty for the package name
+	Version         string            `json:"version"`           // pinned version, empty for any version
+	MaxMatches      int               `json:"max_matches"`       // cap on packages a wildcard name may install
+	MaxRemovals     int               `json:"max_removals"`      // cap on packages a wildcard name may uninstall
+	Confirm         bool              `json:"confirm"`           // allow a wildcard name to uninstall more than max_removals
+	Cask            bool              `json:"cask"`              // install as a Homebrew cask
 }
 
+// defaultWildcardMaxMatches is how many packages a wildcard install may resolve to
+// unless max_matches is set
+const defaultWildcardMaxMatches = 10
+
+// defaultWildcardMaxRemovals is how many packages a wildcard uninstall may remove
+// unless max_removals is set or the removal is confirmed
+const defaultWildcardMaxRemovals = 10
+
 // PackageStatus represents the current status of a package
 type PackageStatus struct {
 	Name          string `json:"name"`
@@ -38,7 +58,16 @@ type PackageStatus struct {
 	DesiredState  string `json:"desired_state"`  // "present" or "absent"
 	CurrentState  string `json:"current_state"`  // "installed", "not_installed", or "unknown"
 	NeedsAction   bool   `json:"needs_action"`   // Whether action is required
-	ActionNeeded  string `json:"action_needed"`  // "install", "uninstall", or "none"
+	ActionNeeded  string `json:"action_needed"`  // "install", "uninstall", "upgrade", "downgrade", or "none"
+	InstalledVersion string `json:"installed_version,omitempty"` // Installed version when a version is pinned
+	DesiredVersion   string `json:"desired_version,omitempty"`   // Requested version, empty for any version
+	MatchedPackages  []string `json:"matched_packages,omitempty"`  // Packages a wildcard name resolved to that need action
+	Cask             bool     `json:"cask,omitempty"`              // Whether the package is managed as a cask
+	KeptPackages     []string `json:"kept_packages,omitempty"`     // Essential packages a wildcard name matched that are never uninstalled
+	Error            string   `json:"error,omitempty"`             // Why the status could not be gathered
+
+	config *PackageConfig // The package as configured
+	err    error          // Error gathering the status, as returned by gatherPackageStatus
 }
 
 // New creates a new packages module
@@ -47,6 +76,8 @@ func New() *PackagesModule {
 	return &PackagesModule{
 		platformInfo:   platformInfo,
 		driverRegistry: drivers.NewDriverRegistry(),
+		templateEngine: templating.NewTemplatingEngine("."),
+		lookPath:       exec.LookPath,
 	}
 }
 
@@ -57,18 +88,25 @@ func (m *PackagesModule) Name() string {
 
 // ActionKeys returns the action keys this module handles
 func (m *PackagesModule) ActionKeys() []string {
-	return []string{"install_package", "uninstall_package", "manage_packages", "add_repo"}
+	return []string{"install_package", "uninstall_package", "manage_packages", "add_repo", "ensure_package_manager"}
 }
 
 // ValidateTask validates a package task configuration
 func (m *PackagesModule) ValidateTask(task *config.Task) error {
 	switch task.Action {
-	case "install_package", "uninstall_package":
+	case "install_package":
+		return m.validateSinglePackageTask(task.Config)
+	case "uninstall_package":
+		if _, exists := task.Config["version"]; exists {
+			return fmt.Errorf("version cannot be used with uninstall_package")
+		}
 		return m.validateSinglePackageTask(task.Config)
 	case "manage_packages":
 		return m.validateMultiplePackagesTask(task.Config)
 	case "add_repo":
 		return m.validateAddRepoTask(task.Config)
+	case "ensure_package_manager":
+		return validateEnsurePackageManagerTask(task.Config)
 	default:
 		return fmt.Errorf("packages module does not handle action '%s'", task.Action)
 	}
@@ -76,65 +114,75 @@ func (m *PackagesModule) ValidateTask(task *config.Task) error {
 
 // ExecuteTask executes a package task
 func (m *PackagesModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionContext) error {
+	// Package manager commands are killed when the task times out
+	defer m.setDriverContext(ctx)()
+
+	var err error
 	switch task.Action {
 	case "install_package":
-		return m.executeInstallPackage(task, ctx)
+		err = m.executeInstallPackage(task, ctx)
 	case "uninstall_package":
-		return m.executeUninstallPackage(task, ctx)
+		err = m.executeUninstallPackage(task, ctx)
 	case "manage_packages":
-		return m.executeManagePackages(task, ctx)
+		err = m.executeManagePackages(task, ctx)
 	case "add_repo":
-		return m.executeAddRepo(task, ctx)
+		err = m.executeAddRepo(task, ctx)
+	case "ensure_package_manager":
+		err = m.executeEnsurePackageManager(task, ctx)
 	default:
-		return fmt.Errorf("packages module does not handle action '%s'", task.Action)
+		return modules.Permanent(fmt.Errorf("packages module does not handle action '%s'", task.Action))
 	}
+	// Retrying does not make a package that does not exist appear
+	return permanentError(err)
 }
 
 // PlanTask returns what the task would do without executing it
 func (m *PackagesModule) PlanTask(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	defer m.setDriverContext(ctx)()
+
 	switch task.Action {
-	case "install_package":
-		return m.planInstallPackage(task, ctx)
-	case "uninstall_package":
-		return m.planUninstallPackage(task, ctx)
+	case "install_package", "uninstall_package":
+		return m.planPackageStatus(m.packageStatuses(task, ctx)[0], ctx)
 	case "manage_packages":
 		return m.planManagePackages(task, ctx)
 	case "add_repo":
 		return m.planAddRepo(task, ctx)
+	case "ensure_package_manager":
+		return m.planEnsurePackageManager(task, ctx)
 	default:
 		return nil, fmt.Errorf("packages module does not handle action '%s'", task.Action)
 	}
 }
 
+// setDriverContext makes package manager commands run under the context and
+// sudo_command of ctx, offline when it is, picks managers by its package manager
+// preferences and returns a function that restores the defaults
+func (m *PackagesModule) setDriverContext(ctx *modules.ExecutionContext) func() {
+	if m.driverRegistry == nil {
+		return func() {}
+	}
+	m.driverRegistry.SetContext(ctx.RunContext())
+	m.driverRegistry.SetPrivilege(drivers.NewPrivilege(ctx.SudoCommand))
+	m.driverRegistry.SetOffline(ctx.Offline)
+	m.driverRegistry.SetPreferences(ctx.PackageManagers.Prefer, ctx.PackageManagers.Exclude)
+	return func() {
+		m.driverRegistry.SetContext(nil)
+		m.driverRegistry.SetPrivilege(nil)
+		m.driverRegistry.SetOffline(false)
+		m.driverRegistry.SetPreferences(nil, nil)
+	}
+}
+
 // validateSinglePackageTask validates configuration for install_package and uninstall_package
 func (m *PackagesModule) validateSinglePackageTask(config map[string]interface{}) error {
 	if name, exists := config["name"]; !exists || name == "" {
 		return fmt.Errorf("package name is required")
 	}
 
-	// Validate package manager preferences if specified
-	if prefer, exists := config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			for _, mgr := range preferList {
-				if mgrStr, ok := mgr.(string); ok {
-					if !m.isValidPackageManager(mgrStr) {
-						return fmt.Errorf("invalid package manager: %s", mgrStr)
-					}
-				}
-			}
-		}
-	}
-
-	// Validate package manager restrictions if specified
-	if only, exists := config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			for _, mgr := range onlyList {
-				if mgrStr, ok := mgr.(string); ok {
-					if !m.isValidPackageManager(mgrStr) {
-						return fmt.Errorf("invalid package manager: %s", mgrStr)
-					}
-				}
-			}
+	// Validate package manager preferences and restrictions if specified
+	for _, field := range []string{"prefer", "only"} {
+		if err := m.validateManagerList(config, field); err != nil {
+			return err
 		}
 	}
 
@@ -145,6 +193,142 @@ func (m *PackagesModule) validateSinglePackageTask(config map[string]interface{}
 		}
 	}
 
+	if err := validateMaxMatches(config); err != nil {
+		return err
+	}
+	if err := validateWildcardRemoval(config); err != nil {
+		return err
+	}
+	if err := validatePackageCask(config); err != nil {
+		return err
+	}
+	if err := validatePackageCommand(config); err != nil {
+		return err
+	}
+	if err := validatePackageManagers(config); err != nil {
+		return err
+	}
+
+	return validatePackageVersion(config, "present")
+}
+
+// validateMaxMatches validates the optional max_matches field of a package
+func validateMaxMatches(config map[string]interface{}) error {
+	maxMatches, exists := config["max_matches"]
+	if !exists {
+		return nil
+	}
+
+	if value, ok := modules.IntValue(maxMatches); !ok || value < 1 {
+		return fmt.Errorf("max_matches must be a positive number, got %v", maxMatches)
+	}
+	if name, ok := modules.StringValue(config["name"]); ok && !strings.ContainsAny(name, "*?") {
+		return fmt.Errorf("max_matches can only be used with wildcard package names")
+	}
+
+	return nil
+}
+
+// validateWildcardRemoval validates the optional max_removals and confirm fields of
+// a package
+func validateWildcardRemoval(config map[string]interface{}) error {
+	maxRemovals, hasMaxRemovals := config["max_removals"]
+	confirm, hasConfirm := config["confirm"]
+	if !hasMaxRemovals && !hasConfirm {
+		return nil
+	}
+
+	if value, ok := modules.IntValue(maxRemovals); hasMaxRemovals && (!ok || value < 1) {
+		return fmt.Errorf("max_removals must be a positive number, got %v", maxRemovals)
+	}
+	if _, ok := modules.BoolValue(confirm); hasConfirm && !ok {
+		return fmt.Errorf("confirm must be a boolean, got %v", confirm)
+	}
+	if name, ok := modules.StringValue(config["name"]); ok && !strings.ContainsAny(name, "*?") {
+		return fmt.Errorf("max_removals and confirm can only be used with wildcard package names")
+	}
+
+	return nil
+}
+
+// validatePackageCask validates the optional cask field of a package
+func validatePackageCask(config map[string]interface{}) error {
+	cask, exists := config["cask"]
+	if !exists {
+		return nil
+	}
+
+	isCask, ok := modules.BoolValue(cask)
+	if !ok {
+		return fmt.Errorf("cask must be true or false, got %v", cask)
+	}
+	if !isCask {
+		return nil
+	}
+	if _, hasVersion := config["version"]; hasVersion {
+		return fmt.Errorf("version cannot be used with casks")
+	}
+	if name, ok := modules.StringValue(config["name"]); ok && strings.ContainsAny(name, "*?") {
+		return fmt.Errorf("cask cannot be used with wildcard package names")
+	}
+
+	return nil
+}
+
+// validatePackageCommand validates the optional command field of a package, the
+// command or list of commands check_system_wide looks for
+func validatePackageCommand(config map[string]interface{}) error {
+	command, exists := config["command"]
+	if !exists {
+		return nil
+	}
+
+	switch value := command.(type) {
+	case string:
+		if value == "" {
+			return fmt.Errorf("command cannot be empty")
+		}
+	case []interface{}:
+		if len(value) == 0 {
+			return fmt.Errorf("command list cannot be empty")
+		}
+		for _, item := range value {
+			if name, ok := item.(string); !ok || name == "" {
+				return fmt.Errorf("command must be a list of command names, got %v", item)
+			}
+		}
+	default:
+		return fmt.Errorf("command must be a command name or a list of command names, got %v", command)
+	}
+	if checkSystemWide, _ := modules.BoolValue(config["check_system_wide"]); !checkSystemWide {
+		return fmt.Errorf("command is only used with check_system_wide: true")
+	}
+
+	return nil
+}
+
+// validatePackageVersion validates the optional version field of a package
+func validatePackageVersion(config map[string]interface{}, state string) error {
+	version, exists := config["version"]
+	if !exists {
+		return nil
+	}
+
+	// Numbers with a fraction lose digits in YAML, 1.10 is read as 1.1
+	versionStr, ok := modules.StringValue(version)
+	if _, isFloat := version.(float64); !ok || isFloat {
+		return fmt.Errorf("version must be a string, quote it in YAML (e.g. version: \"%v\")", version)
+	}
+	if versionStr == "" {
+		return fmt.Errorf("version cannot be empty")
+	}
+	if state == "absent" {
+		return fmt.Errorf("version cannot be used with state 'absent'")
+	}
+	if name, ok := modules.StringValue(config["name"]); ok && strings.ContainsAny(name, "*?") {
+		return fmt.Errorf("version cannot be used with wildcard package names")
+	}
+
 	return nil
 }
 
@@ -176,35 +360,16 @@ func (m *PackagesModule) validateMultiplePackagesTask(config map[string]interfac
 
 		// Validate state if specified
 		if state, exists := pkgConfig["state"]; exists {
-			stateStr, ok := state.(string)
+			stateStr, ok := modules.StringValue(state)
 			if !ok || (stateStr != "present" && stateStr != "absent") {
 				return fmt.Errorf("package %d: state must be 'present' or 'absent'", i)
 			}
 		}
 
-		// Validate package manager preferences if specified
-		if prefer, exists := pkgConfig["prefer"]; exists {
-			if preferList, ok := prefer.([]interface{}); ok {
-				for _, mgr := range preferList {
-					if mgrStr, ok := mgr.(string); ok {
-						if !m.isValidPackageManager(mgrStr) {
-							return fmt.Errorf("package %d: invalid package manager: %s", i, mgrStr)
-						}
-					}
-				}
-			}
-		}
-
-		// Validate package manager restrictions if specified
-		if only, exists := pkgConfig["only"]; exists {
-			if onlyList, ok := only.([]interface{}); ok {
-				for _, mgr := range onlyList {
-					if mgrStr, ok := mgr.(string); ok {
-						if !m.isValidPackageManager(mgrStr) {
-							return fmt.Errorf("package %d: invalid package manager: %s", i, mgrStr)
-						}
-					}
-				}
+		// Validate package manager preferences and restrictions if specified
+		for _, field := range []string{"prefer", "only"} {
+			if err := m.validateManagerList(pkgConfig, field); err != nil {
+				return fmt.Errorf("package %d: %w", i, err)
 			}
 		}
 
@@ -214,53 +379,92 @@ func (m *PackagesModule) validateMultiplePackagesTask(config map[string]interfac
 				return fmt.Errorf("package %d: cannot specify both 'prefer' and 'only' options", i)
 			}
 		}
+
+		state, _ := modules.StringValue(pkgConfig["state"])
+		if err := validatePackageVersion(pkgConfig, state); err != nil {
+			return fmt.Errorf("package %d: %w", i, err)
+		}
+		if err := validateMaxMatches(pkgConfig); err != nil {
+			return fmt.Errorf("package %d: %w", i, err)
+		}
+		if err := validateWildcardRemoval(pkgConfig); err != nil {
+			return fmt.Errorf("package %d: %w", i, err)
+		}
+		if err := validatePackageCask(pkgConfig); err != nil {
+			return fmt.Errorf("package %d: %w", i, err)
+		}
+		if err := validatePackageCommand(pkgConfig); err != nil {
+			return fmt.Errorf("package %d: %w", i, err)
+		}
+		if err := validatePackageManagers(pkgConfig); err != nil {
+			return fmt.Errorf("package %d: %w", i, err)
+		}
 	}
 
 	return nil
 }
 
-// executeInstallPackage installs a single package
-func (m *PackagesModule) executeInstallPackage(task *config.Task, ctx *modules.ExecutionContext) error {
+// parsePackageConfig builds a PackageConfig from a task or manage_packages entry.
+// State defaults to "present" when not specified.
+func parsePackageConfig(cfg map[string]interface{}) *PackageConfig {
 	pkg := &PackageConfig{
-		Name:  task.Config["name"].(string),
 		State: "present",
 	}
 
-	if managers, exists := task.Config["managers"]; exists {
-		if mgrsMap, ok := managers.(map[string]interface{}); ok {
-			pkg.Managers = make(map[string]string)
-			for k, v := range mgrsMap {
-				pkg.Managers[k] = v.(string)
-			}
-		}
+	if name, ok := modules.StringValue(cfg["name"]); ok {
+		pkg.Name = name
 	}
 
-	if prefer, exists := task.Config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			pkg.Prefer = make([]string, len(preferList))
-			for i, p := range preferList {
-				pkg.Prefer[i] = p.(string)
-			}
-		}
+	if state, ok := modules.StringValue(cfg["state"]); ok {
+		pkg.State = state
 	}
 
-	if only, exists := task.Config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			pkg.Only = make([]string, len(onlyList))
-			for i, o := range onlyList {
-				pkg.Only[i] = o.(string)
-			}
-		}
+	if version, ok := modules.StringValue(cfg["version"]); ok {
+		pkg.Version = version
 	}
 
-	// Parse check_system_wide
-	if checkSystemWide, exists := task.Config["check_system_wide"]; exists {
-		pkg.CheckSystemWide = checkSystemWide.(bool)
+	if maxMatches, ok := modules.IntValue(cfg["max_matches"]); ok {
+		pkg.MaxMatches = maxMatches
 	}
 
+	if maxRemovals, ok := modules.IntValue(cfg["max_removals"]); ok {
+		pkg.MaxRemovals = maxRemovals
+	}
+
+	if confirm, ok := modules.BoolValue(cfg["confirm"]); ok {
+		pkg.Confirm = confirm
+	}
+
+	if managers, exists := cfg["managers"]; exists {
+		parseManagerNames(pkg, managers)
+	}
+
+	pkg.Prefer = toStringSlice(cfg["prefer"])
+	pkg.Only = toStringSlice(cfg["only"])
+
+	if checkSystemWide, ok := modules.BoolValue(cfg["check_system_wide"]); ok {
+		pkg.CheckSystemWide = checkSystemWide
+	}
 
+	pkg.Commands = toStringSlice(cfg["command"])
 
-	return m.ensurePackageState(pkg, ctx)
+	if cask, ok := modules.BoolValue(cfg["cask"]); ok {
+		pkg.Cask = cask
+	}
+
+	return pkg
+}
+
+// toStringSlice converts a YAML list or a single string into a string slice, nil
+// when it is neither
+func toStringSlice(value interface{}) []string {
+	list, _ := modules.StringSliceValue(value)
+	return list
+}
+
+// executeInstallPackage installs a single package
+func (m *PackagesModule) executeInstallPackage(task *config.Task, ctx *modules.ExecutionContext) error {
+	return m.ensurePackageState(m.packageStatuses(task, ctx)[0], ctx)
 }
 
 // executeAddRepo adds a repository/bucket/tap to a package manager
@@ -268,37 +472,28 @@ func (m *PackagesModule) executeAddRepo(task *config.Task, ctx *modules.Executio
 	log := logger.Get()
 
 	// Get required repository name
-	repoName, exists := task.Config["name"]
-	if !exists {
+	if _, exists := task.Config["name"]; !exists {
 		return fmt.Errorf("name is required for add_repo action")
 	}
 
-	repo := repoName.(string)
+	repo := repositorySpec(task.Config)
 
 	// Parse only/prefer to determine which package manager to use
 	var driver drivers.PackageDriver
 	var err error
 
-	if only, exists := task.Config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			onlyStrings := make([]string, len(onlyList))
-			for i, o := range onlyList {
-				onlyStrings[i] = o.(string)
-			}
-			driver, err = m.driverRegistry.GetOnlyDriver(onlyStrings)
-		} else {
-			return fmt.Errorf("only must be a list of strings")
+	if _, exists := task.Config["only"]; exists {
+		var only []string
+		if only, err = modules.GetStringSlice(task, "only"); err != nil {
+			return err
 		}
-	} else if prefer, exists := task.Config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			preferStrings := make([]string, len(preferList))
-			for i, p := range preferList {
-				preferStrings[i] = p.(string)
-			}
-			driver, err = m.driverRegistry.GetPreferredDriver(preferStrings)
-		} else {
-			return fmt.Errorf("prefer must be a list of strings")
+		driver, err = m.driverRegistry.GetOnlyDriver(only)
+	} else if _, exists := task.Config["prefer"]; exists {
+		var prefer []string
+		if prefer, err = modules.GetStringSlice(task, "prefer"); err != nil {
+			return err
 		}
+		driver, err = m.driverRegistry.GetPreferredDriver(prefer)
 	} else {
 		// Use default available driver
 		available := m.driverRegistry.GetAvailableDrivers()
@@ -322,6 +517,9 @@ func (m *PackagesModule) executeAddRepo(task *config.Task, ctx *modules.Executio
 		fmt.Printf("Would add repository: %s (using %s)\n", repo, driver.Name())
 		return nil
 	}
+	if ctx.Offline {
+		return fmt.Errorf("cannot add repository %s in offline mode", repo)
+	}
 
 	fmt.Printf("Adding repository: %s (using %s)\n", repo, driver.Name())
 
@@ -341,158 +539,210 @@ func (m *PackagesModule) validateAddRepoTask(config map[string]interface{}) erro
 	}
 
 	// Validate name type
-	if _, ok := config["name"].(string); !ok {
+	name, ok := modules.StringValue(config["name"])
+	if !ok {
 		return fmt.Errorf("name must be a string")
 	}
 
-	// Validate only field if present
-	if only, exists := config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			for _, manager := range onlyList {
-				if managerStr, ok := manager.(string); ok {
-					if !m.isValidPackageManager(managerStr) {
-						return fmt.Errorf("unsupported package manager in 'only': %s", managerStr)
-					}
-				} else {
-					return fmt.Errorf("all items in 'only' must be strings")
-				}
-			}
-		} else {
-			return fmt.Errorf("only must be a list of strings")
+	// Validate url field if present
+	if url, exists := config["url"]; exists {
+		urlStr, ok := url.(string)
+		if !ok {
+			return fmt.Errorf("url must be a string")
+		}
+		if urlStr == "" || strings.ContainsAny(urlStr, " \t") {
+			return fmt.Errorf("url must be a non-empty URL without spaces")
+		}
+		if strings.ContainsAny(strings.TrimSpace(name), " \t") {
+			return fmt.Errorf("name must not contain spaces when url is set")
 		}
 	}
 
-	// Validate prefer field if present
-	if prefer, exists := config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			for _, manager := range preferList {
-				if managerStr, ok := manager.(string); ok {
-					if !m.isValidPackageManager(managerStr) {
-						return fmt.Errorf("unsupported package manager in 'prefer': %s", managerStr)
-					}
-				} else {
-					return fmt.Errorf("all items in 'prefer' must be strings")
-				}
-			}
-		} else {
-			return fmt.Errorf("prefer must be a list of strings")
+	// Validate only and prefer fields if present
+	for _, field := range []string{"only", "prefer"} {
+		if err := m.validateManagerList(config, field); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// executeUninstallPackage uninstalls a single package
-func (m *PackagesModule) executeUninstallPackage(task *config.Task, ctx *modules.ExecutionContext) error {
-	pkg := &PackageConfig{
-		Name:  task.Config["name"].(string),
-		State: "absent",
+// validateManagerList validates a list of package managers like prefer and only. A
+// single package manager is a list of one.
+func (m *PackagesModule) validateManagerList(config map[string]interface{}, field string) error {
+	value, exists := config[field]
+	if !exists {
+		return nil
 	}
-
-	if managers, exists := task.Config["managers"]; exists {
-		if mgrsMap, ok := managers.(map[string]interface{}); ok {
-			pkg.Managers = make(map[string]string)
-			for k, v := range mgrsMap {
-				pkg.Managers[k] = v.(string)
-			}
+	managers, ok := modules.StringSliceValue(value)
+	if !ok {
+		return fmt.Errorf("%s must be a list of package managers", field)
+	}
+	for _, manager := range managers {
+		if !m.isValidPackageManager(manager) {
+			return fmt.Errorf("invalid package manager in '%s': %s", field, manager)
 		}
 	}
+	return nil
+}
 
-	if prefer, exists := task.Config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			pkg.Prefer = make([]string, len(preferList))
-			for i, p := range preferList {
-				pkg.Prefer[i] = p.(string)
-			}
-		}
+// repositorySpec returns the repository an add_repo task adds. A url is appended
+// to the name as "<name> <url>", the form drivers that add repositories from a
+// URL (Scoop buckets, Homebrew taps, Flatpak remotes, DNF/YUM and APK repositories) parse.
+func repositorySpec(cfg map[string]interface{}) string {
+	name, _ := modules.StringValue(cfg["name"])
+	if url, ok := cfg["url"].(string); ok && url != "" {
+		return strings.TrimSpace(name) + " " + url
 	}
+	return name
+}
+
+// executeUninstallPackage uninstalls a single package
+func (m *PackagesModule) executeUninstallPackage(task *config.Task, ctx *modules.ExecutionContext) error {
+	return m.ensurePackageState(m.packageStatuses(task, ctx)[0], ctx)
+}
 
-	if only, exists := task.Config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			pkg.Only = make([]string, len(onlyList))
-			for i, o := range onlyList {
-				pkg.Only[i] = o.(string)
+// executeManagePackages manages multiple packages. Packages that only have to be
+// installed are installed together, with one command per package manager when it
+// can install several at once. A package that fails does not stop the others.
+func (m *PackagesModule) executeManagePackages(task *config.Task, ctx *modules.ExecutionContext) error {
+	var errs []error
+	var managers []string
+	batches := make(map[string][]*PackageStatus)
+	for _, status := range m.packageStatuses(task, ctx) {
+		if status.err != nil {
+			errs = append(errs, fmt.Errorf("failed to manage package %s: failed to check package status: %w", status.Name, status.err))
+			continue
+		}
+		if !ctx.DryRun && m.canBatchInstall(status) {
+			if err := m.checkOffline(status, ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to manage package %s: %w", status.Name, err))
+				continue
+			}
+			if _, exists := batches[status.Manager]; !exists {
+				managers = append(managers, status.Manager)
 			}
+			batches[status.Manager] = append(batches[status.Manager], status)
+			continue
+		}
+		if err := m.applyPackageStatus(status, ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to manage package %s: %w", status.Name, err))
 		}
 	}
 
-	// Parse check_system_wide
-	if checkSystemWide, exists := task.Config["check_system_wide"]; exists {
-		pkg.CheckSystemWide = checkSystemWide.(bool)
+	for _, manager := range managers {
+		errs = append(errs, m.installBatch(manager, batches[manager])...)
 	}
+	return errors.Join(errs...)
+}
 
-	return m.ensurePackageState(pkg, ctx)
+// canBatchInstall returns whether a package only has to be installed by name, so it
+// can be installed together with others
+func (m *PackagesModule) canBatchInstall(status *PackageStatus) bool {
+	return status.NeedsAction && status.ActionNeeded == "install" && status.DesiredVersion == "" &&
+		len(status.MatchedPackages) == 0 && !status.Cask && !m.isWildcardPattern(status.PackageName)
 }
 
-// executeManagePackages manages multiple packages
-func (m *PackagesModule) executeManagePackages(task *config.Task, ctx *modules.ExecutionContext) error {
-	packages := task.Config["packages"].([]interface{})
+// installBatch installs packages a package manager has to install together and
+// returns the error of each package that failed
+func (m *PackagesModule) installBatch(manager string, statuses []*PackageStatus) []error {
+	driver, err := m.driverRegistry.GetDriver(manager)
+	if err != nil {
+		return []error{fmt.Errorf("failed to get driver for %s: %w", manager, err)}
+	}
+	// The installed packages the driver cached are outdated after this
+	defer invalidatePackageCache(driver)
 
-	for _, pkg := range packages {
-		pkgConfig := pkg.(map[string]interface{})
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = status.PackageName
+	}
+	if len(names) == 1 {
+		fmt.Printf("Installing package: %s (using %s)\n", names[0], manager)
+	} else {
+		fmt.Printf("Installing %d packages: %s (using %s)\n", len(names), strings.Join(names, ", "), manager)
+	}
 
-		packageObj := &PackageConfig{
-			Name:  pkgConfig["name"].(string),
-			State: "present", // default state
+	err = drivers.InstallPackages(driver, names)
+	var batchErr *drivers.BatchInstallError
+	if !errors.As(err, &batchErr) {
+		if err != nil {
+			return []error{err}
 		}
-
-		// Parse state
-		if state, exists := pkgConfig["state"]; exists {
-			packageObj.State = state.(string)
+		return nil
+	}
+	var errs []error
+	for _, status := range statuses {
+		if packageErr, failed := batchErr.Failed[status.PackageName]; failed {
+			errs = append(errs, fmt.Errorf("failed to manage package %s: %w", status.Name, packageErr))
 		}
+	}
+	return errs
+}
 
-		// Parse managers map
-		if managers, exists := pkgConfig["managers"]; exists {
-			if mgrsMap, ok := managers.(map[string]interface{}); ok {
-				packageObj.Managers = make(map[string]string)
-				for k, v := range mgrsMap {
-					packageObj.Managers[k] = v.(string)
-				}
-			}
+// taskPackages returns the packages an install_package, uninstall_package or
+// manage_packages task manages, with their desired state
+func taskPackages(task *config.Task) []*PackageConfig {
+	switch task.Action {
+	case "install_package", "uninstall_package":
+		pkg := parsePackageConfig(task.Config)
+		pkg.State = "present"
+		if task.Action == "uninstall_package" {
+			pkg.State = "absent"
 		}
-
-		// Parse prefer list
-		if prefer, exists := pkgConfig["prefer"]; exists {
-			if preferList, ok := prefer.([]interface{}); ok {
-				packageObj.Prefer = make([]string, len(preferList))
-				for i, p := range preferList {
-					packageObj.Prefer[i] = p.(string)
-				}
+		return []*PackageConfig{pkg}
+	case "manage_packages":
+		entries, _ := task.Config["packages"].([]interface{})
+		packages := make([]*PackageConfig, 0, len(entries))
+		for _, entry := range entries {
+			if cfg, ok := entry.(map[string]interface{}); ok {
+				packages = append(packages, parsePackageConfig(cfg))
 			}
 		}
+		return packages
+	}
+	return nil
+}
 
-		// Parse only list
-		if only, exists := pkgConfig["only"]; exists {
-			if onlyList, ok := only.([]interface{}); ok {
-				packageObj.Only = make([]string, len(onlyList))
-				for i, o := range onlyList {
-					packageObj.Only[i] = o.(string)
-				}
-			}
-		}
+// PackageStatuses gathers the desired and installed state of every package a
+// package task manages, in the order the task lists them. A package whose status
+// could not be gathered has Error set and the state that is known.
+func (m *PackagesModule) PackageStatuses(task *config.Task, ctx *modules.ExecutionContext) []*PackageStatus {
+	defer m.setDriverContext(ctx)()
+	return m.packageStatuses(task, ctx)
+}
 
-		// Parse check_system_wide
-		if checkSystemWide, exists := pkgConfig["check_system_wide"]; exists {
-			packageObj.CheckSystemWide = checkSystemWide.(bool)
+// packageStatuses gathers the status of every package a task manages
+func (m *PackagesModule) packageStatuses(task *config.Task, ctx *modules.ExecutionContext) []*PackageStatus {
+	packages := taskPackages(task)
+	statuses := make([]*PackageStatus, len(packages))
+	for i, pkg := range packages {
+		status, err := m.gatherPackageStatus(pkg, ctx.Variables)
+		status.config = pkg
+		if err == nil {
+			err = checkWildcardRemovals(status, ctx)
 		}
-
-		if err := m.ensurePackageState(packageObj, ctx); err != nil {
-			return fmt.Errorf("failed to manage package %s: %w", packageObj.Name, err)
+		if err != nil {
+			status.err = err
+			status.Error = err.Error()
 		}
+		statuses[i] = status
 	}
-
-	return nil
+	return statuses
 }
 
-// gatherPackageStatus gathers current status information for a package
-func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig) (*PackageStatus, error) {
+// gatherPackageStatus gathers current status information for a package, with the
+// variables package name candidates are chosen with
+func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig, variables map[string]interface{}) (*PackageStatus, error) {
 	log := logger.Get()
 
 	// Check if package is available system-wide first (if enabled)
 	if pkg.CheckSystemWide && pkg.State == "present" && !m.isWildcardPattern(pkg.Name) {
-		if m.isCommandAvailable(pkg.Name) {
+		if command, found := m.findSystemCommand(pkg); found {
 			log.Debug().
 				Str("package", pkg.Name).
+				Str("command", command).
 				Msg("Package found system-wide, skipping package manager check")
 			return &PackageStatus{
 				Name:         pkg.Name,
@@ -506,7 +756,7 @@ func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig) (*PackageStatus
 		}
 	}
 
-	driver, packageName, err := m.selectPackageDriver(pkg)
+	driver, packageName, err := m.selectPackageDriver(pkg, variables)
 	if err != nil {
 		return &PackageStatus{
 			Name:         pkg.Name,
@@ -524,7 +774,16 @@ func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig) (*PackageStatus
 		return m.gatherWildcardPackageStatus(pkg, driver, packageName)
 	}
 
-	isInstalled, err := driver.IsPackageInstalled(packageName)
+	// Casks are only a Homebrew concept, other managers install the package as usual
+	caskDriver, isCask := driver.(drivers.CaskDriver)
+	isCask = isCask && pkg.Cask
+
+	var isInstalled bool
+	if isCask {
+		isInstalled, err = caskDriver.IsCaskInstalled(packageName)
+	} else {
+		isInstalled, err = driver.IsPackageInstalled(packageName)
+	}
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -543,12 +802,19 @@ func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig) (*PackageStatus
 	}
 
 	status := &PackageStatus{
-		Name:         pkg.Name,
-		PackageName:  packageName,
-		Manager:      driver.Name(),
-		DesiredState: pkg.State,
-		NeedsAction:  false,
-		ActionNeeded: "none",
+		Name:           pkg.Name,
+		PackageName:    packageName,
+		Manager:        driver.Name(),
+		DesiredState:   pkg.State,
+		NeedsAction:    false,
+		ActionNeeded:   "none",
+		DesiredVersion: pkg.Version,
+		Cask:           isCask,
+	}
+
+	// Fail early rather than silently installing the latest version
+	if pkg.Version != "" && pkg.State == "present" && !driver.SupportsVersionPinning() {
+		return status, &drivers.ErrVersionPinUnsupported{Manager: driver.Name()}
 	}
 
 	if isInstalled {
@@ -556,6 +822,10 @@ func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig) (*PackageStatus
 		if pkg.State == "absent" {
 			status.NeedsAction = true
 			status.ActionNeeded = "uninstall"
+		} else if pkg.Version != "" {
+			if err := m.compareInstalledVersion(status, driver, pkg.Version); err != nil {
+				return status, err
+			}
 		}
 	} else {
 		status.CurrentState = "not_installed"
@@ -570,6 +840,8 @@ func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig) (*PackageStatus
 		Str("manager", status.Manager).
 		Str("current_state", status.CurrentState).
 		Str("desired_state", status.DesiredState).
+		Str("installed_version", status.InstalledVersion).
+		Str("desired_version", status.DesiredVersion).
 		Bool("needs_action", status.NeedsAction).
 		Str("action_needed", status.ActionNeeded).
 		Msg("Package status gathered")
@@ -577,42 +849,109 @@ func (m *PackagesModule) gatherPackageStatus(pkg *PackageConfig) (*PackageStatus
 	return status, nil
 }
 
-// ensurePackageState ensures a package is in the desired state
-func (m *PackagesModule) ensurePackageState(pkg *PackageConfig, ctx *modules.ExecutionContext) error {
-	log := logger.Get()
-
-	status, err := m.gatherPackageStatus(pkg)
+// compareInstalledVersion compares the installed version of a package against the
+// requested version and marks the status for upgrade or downgrade when they differ
+func (m *PackagesModule) compareInstalledVersion(status *PackageStatus, driver drivers.PackageDriver, version string) error {
+	info, err := driver.GetPackageInfo(status.PackageName)
 	if err != nil {
-		return fmt.Errorf("failed to check package status: %w", err)
+		return fmt.Errorf("failed to get installed version of %s: %w", status.PackageName, err)
+	}
+
+	installed := info["version"]
+	if installed == "" {
+		return fmt.Errorf("%s did not report an installed version for %s", driver.Name(), status.PackageName)
+	}
+	status.InstalledVersion = installed
+
+	if utils.VersionMatches(installed, version) {
+		return nil
+	}
+
+	status.NeedsAction = true
+	if utils.CompareVersions(installed, version) < 0 {
+		status.ActionNeeded = "upgrade"
+	} else {
+		status.ActionNeeded = "downgrade"
+	}
+	return nil
+}
+
+// ensurePackageState ensures a package whose status was gathered is in the desired
+// state
+func (m *PackagesModule) ensurePackageState(status *PackageStatus, ctx *modules.ExecutionContext) error {
+	if status.err != nil {
+		return fmt.Errorf("failed to check package status: %w", status.err)
 	}
+	return m.applyPackageStatus(status, ctx)
+}
+
+// applyPackageStatus installs, upgrades or uninstalls a package whose status was
+// gathered as needed
+func (m *PackagesModule) applyPackageStatus(status *PackageStatus, ctx *modules.ExecutionContext) error {
+	log := logger.Get()
 
 	log.Debug().
-		Str("package", pkg.Name).
+		Str("package", status.Name).
 		Str("manager", status.Manager).
 		Bool("needs_action", status.NeedsAction).
 		Bool("dry_run", ctx.DryRun).
 		Msg("Ensuring package state")
 
 	if status.NeedsAction {
+		if !ctx.DryRun {
+			if err := m.checkOffline(status, ctx); err != nil {
+				return err
+			}
+		}
+
+		target := status.PackageName
+		if status.DesiredVersion != "" {
+			target = fmt.Sprintf("%s %s", status.PackageName, status.DesiredVersion)
+		} else if len(status.MatchedPackages) > 0 {
+			target = fmt.Sprintf("%s (%s)", status.PackageName, strings.Join(status.MatchedPackages, ", "))
+		} else if status.Cask {
+			target = fmt.Sprintf("%s (cask)", status.PackageName)
+		}
+
 		if ctx.DryRun {
-			fmt.Printf("Would %s package: %s (using %s)\n", status.ActionNeeded, status.PackageName, status.Manager)
+			fmt.Printf("Would %s package: %s (using %s)\n", status.ActionNeeded, target, status.Manager)
 		} else {
 			fmt.Printf("%s package: %s (using %s)\n",
-				map[string]string{"install": "Installing", "uninstall": "Uninstalling"}[status.ActionNeeded],
-				status.PackageName, status.Manager)
+				map[string]string{
+					"install":   "Installing",
+					"uninstall": "Uninstalling",
+					"upgrade":   "Upgrading",
+					"downgrade": "Downgrading",
+				}[status.ActionNeeded],
+				target, status.Manager)
 		}
 		if !ctx.DryRun {
 			driver, err := m.driverRegistry.GetDriver(status.Manager)
 			if err != nil {
 				return fmt.Errorf("failed to get driver for %s: %w", status.Manager, err)
 			}
+			// The installed packages the driver cached are outdated after this
+			defer invalidatePackageCache(driver)
 
-			if status.ActionNeeded == "install" {
+			switch status.ActionNeeded {
+			case "install", "upgrade", "downgrade":
+				if len(status.MatchedPackages) > 0 {
+					return m.installWildcardPackages(driver, status)
+				}
+				if status.DesiredVersion != "" {
+					return driver.InstallPackageVersion(status.PackageName, status.DesiredVersion)
+				}
+				if caskDriver, ok := driver.(drivers.CaskDriver); ok && status.Cask {
+					return caskDriver.InstallCask(status.PackageName)
+				}
 				return driver.InstallPackage(status.PackageName)
-			} else if status.ActionNeeded == "uninstall" {
+			case "uninstall":
 				// Handle wildcard patterns for uninstall
 				if m.isWildcardPattern(status.PackageName) {
-					return m.uninstallWildcardPackages(driver, status.PackageName, ctx)
+					return m.uninstallWildcardPackages(driver, status)
+				}
+				if caskDriver, ok := driver.(drivers.CaskDriver); ok && status.Cask {
+					return caskDriver.UninstallCask(status.PackageName)
 				}
 				return driver.UninstallPackage(status.PackageName)
 			}
@@ -628,8 +967,42 @@ func (m *PackagesModule) ensurePackageState(pkg *PackageConfig, ctx *modules.Exe
 	return nil
 }
 
-// selectPackageDriver selects the best package driver for a package
-func (m *PackagesModule) selectPackageDriver(pkg *PackageConfig) (drivers.PackageDriver, string, error) {
+// checkOffline returns *drivers.ErrOffline when ctx is offline and the packages a
+// status has to install are not cached by their package manager
+func (m *PackagesModule) checkOffline(status *PackageStatus, ctx *modules.ExecutionContext) error {
+	if !ctx.Offline || !status.NeedsAction || status.ActionNeeded == "uninstall" {
+		return nil
+	}
+	driver, err := m.driverRegistry.GetDriver(status.Manager)
+	if err != nil {
+		return fmt.Errorf("failed to get driver for %s: %w", status.Manager, err)
+	}
+	if status.Cask {
+		return &drivers.ErrOffline{Manager: driver.Name(), Package: status.PackageName}
+	}
+	names := status.MatchedPackages
+	if len(names) == 0 {
+		names = []string{status.PackageName}
+	}
+	for _, name := range names {
+		if err := drivers.CheckOfflineInstall(driver, name, status.DesiredVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidatePackageCache makes a driver list its installed packages again the next
+// time it is asked about one
+func invalidatePackageCache(driver drivers.PackageDriver) {
+	if cached, ok := driver.(drivers.CacheInvalidator); ok {
+		cached.InvalidateCache()
+	}
+}
+
+// selectPackageDriver selects the best package driver for a package and the name of
+// the package for it
+func (m *PackagesModule) selectPackageDriver(pkg *PackageConfig, variables map[string]interface{}) (drivers.PackageDriver, string, error) {
 	log := logger.Get()
 
 	if m.driverRegistry == nil {
@@ -643,20 +1016,23 @@ func (m *PackagesModule) selectPackageDriver(pkg *PackageConfig) (drivers.Packag
 	if len(pkg.Only) > 0 {
 		driver, err = m.driverRegistry.GetOnlyDriver(pkg.Only)
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to find required package manager for %s: %w", pkg.Name, err)
+			return nil, "", modules.Permanent(fmt.Errorf("failed to find required package manager for %s: %w", pkg.Name, err))
 		}
 	} else {
 		driver, err = m.driverRegistry.GetPreferredDriver(pkg.Prefer)
 		if err != nil {
-			return nil, "", err
+			return nil, "", modules.Permanent(err)
 		}
 	}
 
 	if driver == nil {
-		return nil, "", fmt.Errorf("no suitable package manager found for %s", pkg.Name)
+		return nil, "", modules.Permanent(fmt.Errorf("no suitable package manager found for %s", pkg.Name))
 	}
 
-	packageName := m.getPackageNameForManager(pkg, driver.Name())
+	packageName, err := m.getPackageNameForManager(pkg, driver.Name(), variables)
+	if err != nil {
+		return nil, "", modules.Permanent(err)
+	}
 
 	// Log driver selection for debugging
 	log.Debug().
@@ -670,25 +1046,16 @@ func (m *PackagesModule) selectPackageDriver(pkg *PackageConfig) (drivers.Packag
 	return driver, packageName, nil
 }
 
-// getPackageNameForManager gets the package name for a specific manager
-func (m *PackagesModule) getPackageNameForManager(pkg *PackageConfig, manager string) string {
-	if pkg.Managers != nil {
-		if name, exists := pkg.Managers[manager]; exists {
-			return name
-		}
-	}
-	return pkg.Name // fallback to generic name
-}
-
-
-
 // isValidPackageManager checks if a package manager name is valid
 func (m *PackagesModule) isValidPackageManager(manager string) bool {
 	validManagers := []string{
 		"winget", "chocolatey", "scoop",    // Windows
 		"homebrew",                         // macOS
 		"apt", "apk", "yum", "dnf",        // Linux
+		"flatpak",                         // Linux (desktop applications)
 		"cargo",                           // Cross-platform (Rust)
+		"pipx",                            // Cross-platform (Python)
+		"npm",                             // Cross-platform (Node.js)
 	}
 
 	for _, valid := range validManagers {
@@ -700,90 +1067,8 @@ func (m *PackagesModule) isValidPackageManager(manager string) bool {
 }
 
 // Planning functions for dry-run support
-func (m *PackagesModule) planInstallPackage(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
-	pkg := &PackageConfig{
-		Name:  task.Config["name"].(string),
-		State: "present",
-	}
-
-	if managers, exists := task.Config["managers"]; exists {
-		if mgrsMap, ok := managers.(map[string]interface{}); ok {
-			pkg.Managers = make(map[string]string)
-			for k, v := range mgrsMap {
-				pkg.Managers[k] = v.(string)
-			}
-		}
-	}
-
-	if prefer, exists := task.Config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			pkg.Prefer = make([]string, len(preferList))
-			for i, p := range preferList {
-				pkg.Prefer[i] = p.(string)
-			}
-		}
-	}
-
-	if only, exists := task.Config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			pkg.Only = make([]string, len(onlyList))
-			for i, o := range onlyList {
-				pkg.Only[i] = o.(string)
-			}
-		}
-	}
-
-	// Parse check_system_wide
-	if checkSystemWide, exists := task.Config["check_system_wide"]; exists {
-		pkg.CheckSystemWide = checkSystemWide.(bool)
-	}
-
-	return m.planPackageChange(pkg, ctx)
-}
-
-func (m *PackagesModule) planUninstallPackage(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
-	pkg := &PackageConfig{
-		Name:  task.Config["name"].(string),
-		State: "absent",
-	}
-
-	if managers, exists := task.Config["managers"]; exists {
-		if mgrsMap, ok := managers.(map[string]interface{}); ok {
-			pkg.Managers = make(map[string]string)
-			for k, v := range mgrsMap {
-				pkg.Managers[k] = v.(string)
-			}
-		}
-	}
-
-	if prefer, exists := task.Config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			pkg.Prefer = make([]string, len(preferList))
-			for i, p := range preferList {
-				pkg.Prefer[i] = p.(string)
-			}
-		}
-	}
-
-	if only, exists := task.Config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			pkg.Only = make([]string, len(onlyList))
-			for i, o := range onlyList {
-				pkg.Only[i] = o.(string)
-			}
-		}
-	}
-
-	// Parse check_system_wide
-	if checkSystemWide, exists := task.Config["check_system_wide"]; exists {
-		pkg.CheckSystemWide = checkSystemWide.(bool)
-	}
-
-	return m.planPackageChange(pkg, ctx)
-}
-
 func (m *PackagesModule) planManagePackages(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
-	packages := task.Config["packages"].([]interface{})
+	packages := m.packageStatuses(task, ctx)
 
 	plan := &modules.TaskPlan{
 		TaskID:      task.ID,
@@ -795,67 +1080,35 @@ func (m *PackagesModule) planManagePackages(task *config.Task, ctx *modules.Exec
 
 	actionablePackages := 0
 	skippedPackages := 0
+	var offlinePackages []string
 
-	for _, pkg := range packages {
-		pkgConfig := pkg.(map[string]interface{})
-
-		packageObj := &PackageConfig{
-			Name:  pkgConfig["name"].(string),
-			State: "present", // default state
-		}
-
-		// Parse state
-		if state, exists := pkgConfig["state"]; exists {
-			packageObj.State = state.(string)
-		}
-
-		// Parse managers map
-		if managers, exists := pkgConfig["managers"]; exists {
-			if mgrsMap, ok := managers.(map[string]interface{}); ok {
-				packageObj.Managers = make(map[string]string)
-				for k, v := range mgrsMap {
-					packageObj.Managers[k] = v.(string)
-				}
-			}
-		}
-
-		// Parse prefer list
-		if prefer, exists := pkgConfig["prefer"]; exists {
-			if preferList, ok := prefer.([]interface{}); ok {
-				packageObj.Prefer = make([]string, len(preferList))
-				for i, p := range preferList {
-					packageObj.Prefer[i] = p.(string)
-				}
-			}
-		}
-
-		// Parse only list
-		if only, exists := pkgConfig["only"]; exists {
-			if onlyList, ok := only.([]interface{}); ok {
-				packageObj.Only = make([]string, len(onlyList))
-				for i, o := range onlyList {
-					packageObj.Only[i] = o.(string)
-				}
-			}
-		}
-
-		// Parse check_system_wide
-		if checkSystemWide, exists := pkgConfig["check_system_wide"]; exists {
-			packageObj.CheckSystemWide = checkSystemWide.(bool)
-		}
-
-		pkgPlan, err := m.planPackageChange(packageObj, ctx)
+	for i, status := range packages {
+		pkgPlan, err := m.planPackageStatus(status, ctx)
 		if err != nil {
 			plan.WillSkip = true
-			plan.SkipReason = fmt.Sprintf("Failed to plan package %s: %v", packageObj.Name, err)
+			plan.SkipReason = fmt.Sprintf("Failed to plan package %s: %v", status.Name, err)
+			plan.SkipCode = modules.SkipError
 			return plan, nil
 		}
 
+		// The task only runs one manager when every package uses the same
+		if i == 0 {
+			plan.Manager = pkgPlan.Manager
+		} else if plan.Manager != pkgPlan.Manager {
+			plan.Manager = ""
+		}
+
 		if !pkgPlan.WillSkip {
 			plan.Changes = append(plan.Changes, pkgPlan.Changes...)
 			actionablePackages++
 		} else {
 			skippedPackages++
+			if pkgPlan.SkipCode == modules.SkipOffline {
+				offlinePackages = append(offlinePackages, status.PackageName)
+			}
+			if plan.SkipCode == "" {
+				plan.SkipCode = pkgPlan.SkipCode
+			}
 			// Collect skip reasons for individual packages
 			if plan.SkipReason == "" {
 				plan.SkipReason = pkgPlan.SkipReason
@@ -873,6 +1126,11 @@ func (m *PackagesModule) planManagePackages(task *config.Task, ctx *modules.Exec
 			plan.SkipReason = "No packages to process"
 		}
 	} else {
+		plan.SkipCode = ""
+		// The packages that are installed do not hide the ones that fail offline
+		if len(offlinePackages) > 0 {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Cannot install %s offline, not cached", strings.Join(offlinePackages, ", ")))
+		}
 		// Update description to show actionable vs skipped counts
 		if skippedPackages > 0 {
 			plan.Description = fmt.Sprintf("Manage %d packages (%d changes, %d already correct)",
@@ -885,38 +1143,119 @@ func (m *PackagesModule) planManagePackages(task *config.Task, ctx *modules.Exec
 	return plan, nil
 }
 
-func (m *PackagesModule) planPackageChange(pkg *PackageConfig, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+// planPackageStatus returns what has to change for a package whose status was
+// gathered
+func (m *PackagesModule) planPackageStatus(status *PackageStatus, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	pkg := status.config
 	plan := &modules.TaskPlan{
 		TaskID:      fmt.Sprintf("package-%s", pkg.Name),
 		Action:      fmt.Sprintf("ensure_%s", pkg.State),
 		Description: fmt.Sprintf("Ensure package %s is %s", pkg.Name, pkg.State),
 		Changes:     []string{},
 		WillSkip:    false,
+		Manager:     status.Manager,
 	}
 
-	status, err := m.gatherPackageStatus(pkg)
-	if err != nil {
+	if err := status.err; err != nil {
+		var pinErr *drivers.ErrVersionPinUnsupported
+		if errors.As(err, &pinErr) {
+			return nil, fmt.Errorf("cannot install %s %s: %w", pkg.Name, pkg.Version, err)
+		}
 		return nil, fmt.Errorf("cannot determine package status: %w", err)
 	}
+	exclusion := m.exclusionNote(pkg, status.Manager)
+
+	var offlineErr *drivers.ErrOffline
+	if err := m.checkOffline(status, ctx); errors.As(err, &offlineErr) {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Offline and %s is not cached by %s", offlineErr.Package, offlineErr.Manager)
+		plan.SkipCode = modules.SkipOffline
+		return plan, nil
+	}
 
 	if status.NeedsAction {
-		actionVerb := map[string]string{
-			"install":   "Install",
-			"uninstall": "Uninstall",
-		}[status.ActionNeeded]
-		plan.Changes = append(plan.Changes, fmt.Sprintf("%s package %s using %s", actionVerb, status.PackageName, status.Manager))
+		switch status.ActionNeeded {
+		case "upgrade", "downgrade":
+			actionVerb := map[string]string{
+				"upgrade":   "Upgrade",
+				"downgrade": "Downgrade",
+			}[status.ActionNeeded]
+			plan.Changes = append(plan.Changes, fmt.Sprintf("%s %s %s → %s using %s",
+				actionVerb, status.PackageName, status.InstalledVersion, status.DesiredVersion, status.Manager))
+		case "install":
+			if len(status.MatchedPackages) > 0 {
+				for _, match := range status.MatchedPackages {
+					plan.Changes = append(plan.Changes, fmt.Sprintf("Install package %s using %s (matches %s)", match, status.Manager, status.PackageName))
+				}
+				break
+			}
+			target := status.PackageName
+			if status.DesiredVersion != "" {
+				target = fmt.Sprintf("%s %s", status.PackageName, status.DesiredVersion)
+			} else if status.Cask {
+				target = fmt.Sprintf("%s (cask)", status.PackageName)
+			}
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Install package %s using %s", target, status.Manager))
+		default:
+			if len(status.MatchedPackages) > 0 {
+				for _, match := range status.MatchedPackages {
+					plan.Changes = append(plan.Changes, fmt.Sprintf("Uninstall package %s using %s (matches %s)", match, status.Manager, status.PackageName))
+				}
+				break
+			}
+			target := status.PackageName
+			if status.Cask {
+				target = fmt.Sprintf("%s (cask)", status.PackageName)
+			}
+			plan.Changes = append(plan.Changes, fmt.Sprintf("Uninstall package %s using %s", target, status.Manager))
+		}
 	} else {
 		plan.WillSkip = true
-		if status.DesiredState == "present" {
+		if status.DesiredState == "present" && status.InstalledVersion != "" {
+			plan.SkipReason = fmt.Sprintf("Package %s %s already installed via %s", status.PackageName, status.InstalledVersion, status.Manager)
+		} else if status.DesiredState == "present" {
 			plan.SkipReason = fmt.Sprintf("Package %s already installed via %s", status.PackageName, status.Manager)
 		} else {
 			plan.SkipReason = fmt.Sprintf("Package %s already absent", status.PackageName)
 		}
+		if exclusion != "" {
+			plan.SkipReason += fmt.Sprintf(" (%s)", exclusion)
+		}
+		if len(status.KeptPackages) > 0 {
+			plan.SkipReason += fmt.Sprintf(" (keeps essential packages %s)", strings.Join(status.KeptPackages, ", "))
+		}
+	}
+	if status.NeedsAction && len(status.KeptPackages) > 0 {
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Keep essential packages %s (match %s)", strings.Join(status.KeptPackages, ", "), status.PackageName))
+	}
+	if status.NeedsAction && exclusion != "" {
+		plan.Changes = append(plan.Changes, exclusion)
 	}
 
 	return plan, nil
 }
 
+// exclusionNote explains why a package is not managed with the manager it prefers,
+// when settings.package_managers excludes that manager. It is empty otherwise.
+func (m *PackagesModule) exclusionNote(pkg *PackageConfig, manager string) string {
+	if len(pkg.Only) > 0 || manager == "system" {
+		return ""
+	}
+	for _, preference := range pkg.Prefer {
+		driver, err := m.driverRegistry.GetDriver(preference)
+		if err != nil {
+			continue
+		}
+		if driver.Name() == manager {
+			return ""
+		}
+		if m.driverRegistry.IsExcluded(driver.Name()) && driver.IsAvailable() {
+			return fmt.Sprintf("%s is preferred but excluded by settings.package_managers, using %s", driver.Name(), manager)
+		}
+	}
+	return ""
+}
+
 // ExplainAction returns documentation for a specific action
 func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentation, error) {
 	switch action {
@@ -933,9 +1272,9 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 				},
 				{
 					Name:        "managers",
-					Type:        "map[string]string",
+					Type:        "map",
 					Required:    false,
-					Description: "Package manager specific names (e.g., {\"winget\": \"Git.Git\", \"brew\": \"git\"})",
+					Description: "Package manager specific names (e.g., {\"winget\": \"Git.Git\", \"brew\": \"git\"}), or a list of {name, condition} candidates per manager where the first whose condition is true wins",
 				},
 				{
 					Name:        "prefer",
@@ -949,6 +1288,39 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 					Required:    false,
 					Description: "Only allow these package managers, no fallbacks (e.g., [\"cargo\", \"apt\"])",
 				},
+				{
+					Name:        "version",
+					Type:        "string",
+					Required:    false,
+					Description: "Pin the package to this version. Installed versions that differ are upgraded or downgraded. Not every package manager supports pinning",
+				},
+				{
+					Name:        "max_matches",
+					Type:        "int",
+					Required:    false,
+					Default:     "10",
+					Description: "For wildcard names like 'python3.*': the most packages the pattern may resolve to. The pattern is resolved by searching the package manager and every match is installed",
+				},
+				{
+					Name:        "cask",
+					Type:        "bool",
+					Required:    false,
+					Default:     "false",
+					Description: "Install the package as a Homebrew cask (brew install --cask). Other package managers ignore it",
+				},
+				{
+					Name:        "check_system_wide",
+					Type:        "bool",
+					Required:    false,
+					Default:     "false",
+					Description: "Skip the package when its command is already in PATH, e.g. when it was installed by hand",
+				},
+				{
+					Name:        "command",
+					Type:        "string or []string",
+					Required:    false,
+					Description: "Command check_system_wide looks for when the package installs a binary with another name (e.g. 'rg' for ripgrep). Any command of a list counts. Defaults to the package name",
+				},
 			},
 			Examples: []modules.ActionExample{
 				{
@@ -975,6 +1347,30 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 						"only": []string{"cargo", "apt"},
 					},
 				},
+				{
+					Description: "Install every available fonts-noto-* package via apt",
+					Config: map[string]interface{}{
+						"name":        "fonts-noto-*",
+						"only":        []string{"apt"},
+						"max_matches": 20,
+					},
+				},
+				{
+					Description: "Install Visual Studio Code as a Homebrew cask",
+					Config: map[string]interface{}{
+						"name": "visual-studio-code",
+						"cask": true,
+						"only": []string{"homebrew"},
+					},
+				},
+				{
+					Description: "Pin terraform to a specific version via Homebrew",
+					Config: map[string]interface{}{
+						"name":    "terraform",
+						"version": "1.7.5",
+						"only":    []string{"homebrew"},
+					},
+				},
 			},
 		}, nil
 	case "uninstall_package":
@@ -990,9 +1386,9 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 				},
 				{
 					Name:        "managers",
-					Type:        "map[string]string",
+					Type:        "map",
 					Required:    false,
-					Description: "Package manager specific names",
+					Description: "Package manager specific names, or a list of {name, condition} candidates per manager",
 				},
 				{
 					Name:        "prefer",
@@ -1006,6 +1402,27 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 					Required:    false,
 					Description: "Only allow these package managers, no fallbacks",
 				},
+				{
+					Name:        "cask",
+					Type:        "bool",
+					Required:    false,
+					Default:     "false",
+					Description: "Uninstall a Homebrew cask. Other package managers ignore it",
+				},
+				{
+					Name:        "max_removals",
+					Type:        "int",
+					Required:    false,
+					Default:     "10",
+					Description: "For wildcard names like 'python2*': the most installed packages the pattern may uninstall. Above it the task fails listing the matches. Essential packages of the package manager, like dpkg or systemd for apt, are never uninstalled by a wildcard",
+				},
+				{
+					Name:        "confirm",
+					Type:        "bool",
+					Required:    false,
+					Default:     "false",
+					Description: "For wildcard names: uninstall every match even when there are more than max_removals, like apply --force-wildcards does for all tasks",
+				},
 			},
 			Examples: []modules.ActionExample{
 				{
@@ -1014,6 +1431,14 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 						"name": "git",
 					},
 				},
+				{
+					Description: "Uninstall every installed Python 2 package via apt",
+					Config: map[string]interface{}{
+						"name":         "python2*",
+						"only":         []string{"apt"},
+						"max_removals": 30,
+					},
+				},
 			},
 		}, nil
 	case "manage_packages":
@@ -1061,6 +1486,12 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 					Required:    true,
 					Description: "Name of the repository/bucket/tap to add",
 				},
+				{
+					Name:        "url",
+					Type:        "string",
+					Required:    false,
+					Description: "Git URL of a Scoop bucket or Homebrew tap, URL of a Flatpak remote, base URL of a DNF/YUM repository or URL of an APK repository tagged with the name, for repositories the package manager does not know by name",
+				},
 				{
 					Name:        "only",
 					Type:        "[]string",
@@ -1082,6 +1513,14 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 						"only": []string{"scoop"},
 					},
 				},
+				{
+					Description: "Add a Scoop bucket from its git URL",
+					Config: map[string]interface{}{
+						"name": "mybucket",
+						"url":  "https://github.com/me/scoop-bucket",
+						"only": []string{"scoop"},
+					},
+				},
 				{
 					Description: "Add Homebrew tap",
 					Config: map[string]interface{}{
@@ -1089,6 +1528,49 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 						"prefer": []string{"homebrew"},
 					},
 				},
+				{
+					Description: "Add a private Homebrew tap from its git URL",
+					Config: map[string]interface{}{
+						"name": "myorg/tools",
+						"url":  "git@github.com:myorg/homebrew-tools.git",
+						"only": []string{"homebrew"},
+					},
+				},
+			},
+		}, nil
+	case "ensure_package_manager":
+		return &modules.ActionDocumentation{
+			Action:      "ensure_package_manager",
+			Description: "Install a package manager that is missing with its official install script, so later tasks can use it",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "name",
+					Type:        "string",
+					Required:    true,
+					Description: "Package manager to install: scoop, homebrew, chocolatey or cargo (installed with rustup)",
+				},
+				{
+					Name:        "allow_install_script",
+					Type:        "bool",
+					Required:    true,
+					Description: "Must be true, confirms that the install script may be downloaded and run",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Install Scoop on Windows",
+					Config: map[string]interface{}{
+						"name":                 "scoop",
+						"allow_install_script": true,
+					},
+				},
+				{
+					Description: "Install Rust and cargo with rustup",
+					Config: map[string]interface{}{
+						"name":                 "rustup",
+						"allow_install_script": true,
+					},
+				},
 			},
 		}, nil
 	default:
@@ -1098,7 +1580,7 @@ func (m *PackagesModule) ExplainAction(action string) (*modules.ActionDocumentat
 
 // ListActions returns documentation for all actions supported by this module
 func (m *PackagesModule) ListActions() []*modules.ActionDocumentation {
-	actions := []string{"install_package", "uninstall_package", "manage_packages", "add_repo"}
+	actions := m.ActionKeys()
 	docs := make([]*modules.ActionDocumentation, len(actions))
 
 	for i, action := range actions {
@@ -1109,10 +1591,40 @@ func (m *PackagesModule) ListActions() []*modules.ActionDocumentation {
 	return docs
 }
 
-// isCommandAvailable checks if a command is available system-wide in PATH
+// findSystemCommand looks for the commands of a package in PATH, or for the package
+// name when it has none, and returns the first one found
+func (m *PackagesModule) findSystemCommand(pkg *PackageConfig) (string, bool) {
+	commands := pkg.Commands
+	if len(commands) == 0 {
+		commands = []string{pkg.Name}
+	}
+	for _, command := range commands {
+		if m.isCommandAvailable(command) {
+			return command, true
+		}
+	}
+	return "", false
+}
+
+// isCommandAvailable checks if a command is available system-wide in PATH. On
+// Windows the command is also looked for with the .exe and .cmd extensions.
 func (m *PackagesModule) isCommandAvailable(command string) bool {
-	_, err := exec.LookPath(command)
-	return err == nil
+	lookPath := m.lookPath
+	if lookPath == nil {
+		lookPath = exec.LookPath
+	}
+
+	candidates := []string{command}
+	extension := strings.ToLower(filepath.Ext(command))
+	if m.platformInfo != nil && m.platformInfo.OS == "windows" && extension != ".exe" && extension != ".cmd" {
+		candidates = append(candidates, command+".exe", command+".cmd")
+	}
+	for _, candidate := range candidates {
+		if _, err := lookPath(candidate); err == nil {
+			return true
+		}
+	}
+	return false
 }
 
 // isWildcardPattern checks if a package name contains wildcard characters
@@ -1161,55 +1673,145 @@ func (m *PackagesModule) gatherWildcardPackageStatus(pkg *PackageConfig, driver
 	hasMatches := len(matchingPackages) > 0
 
 	if pkg.State == "present" {
-		if hasMatches {
+		available, err := m.resolveWildcardInstall(pkg, driver, pattern)
+		if err != nil {
+			status.CurrentState = "unknown"
+			return status, err
+		}
+
+		for _, name := range available {
+			if !allPackages[name] {
+				status.MatchedPackages = append(status.MatchedPackages, name)
+			}
+		}
+
+		if len(status.MatchedPackages) == 0 {
 			status.CurrentState = "installed"
-			// For wildcard install: if any packages match, consider it satisfied
 		} else {
-			status.CurrentState = "not_installed"
+			if hasMatches {
+				status.CurrentState = "installed"
+			} else {
+				status.CurrentState = "not_installed"
+			}
 			status.NeedsAction = true
 			status.ActionNeeded = "install"
 		}
 	} else if pkg.State == "absent" {
+		// Essential packages are never removed by a wildcard, however broad it is
+		sort.Strings(matchingPackages)
+		for _, name := range matchingPackages {
+			if drivers.IsEssentialPackage(driver.Name(), name) {
+				status.KeptPackages = append(status.KeptPackages, name)
+			} else {
+				status.MatchedPackages = append(status.MatchedPackages, name)
+			}
+		}
+
 		if hasMatches {
 			status.CurrentState = "installed"
-			status.NeedsAction = true
-			status.ActionNeeded = "uninstall"
 		} else {
 			status.CurrentState = "not_installed"
-			// Already in desired state - no action needed
+		}
+		if len(status.MatchedPackages) > 0 {
+			status.NeedsAction = true
+			status.ActionNeeded = "uninstall"
 		}
 	}
 
 	return status, nil
 }
 
-// uninstallWildcardPackages handles uninstalling packages that match a wildcard pattern
-func (m *PackagesModule) uninstallWildcardPackages(driver drivers.PackageDriver, pattern string, ctx *modules.ExecutionContext) error {
-	// Get all installed packages
-	allPackages, err := driver.GetAllInstalledPackages()
+// checkWildcardRemovals fails a wildcard uninstall that would remove more packages
+// than max_removals, unless it is confirmed with confirm: true or --force-wildcards
+func checkWildcardRemovals(status *PackageStatus, ctx *modules.ExecutionContext) error {
+	pkg := status.config
+	if status.ActionNeeded != "uninstall" || len(status.MatchedPackages) == 0 || pkg.Confirm || ctx.ForceWildcards {
+		return nil
+	}
+
+	maxRemovals := pkg.MaxRemovals
+	if maxRemovals == 0 {
+		maxRemovals = defaultWildcardMaxRemovals
+	}
+	if len(status.MatchedPackages) <= maxRemovals {
+		return nil
+	}
+	return modules.Permanent(fmt.Errorf("wildcard pattern %s would uninstall %d packages via %s, more than max_removals (%d): %s; set confirm: true to uninstall all of them",
+		status.PackageName, len(status.MatchedPackages), status.Manager, maxRemovals, strings.Join(status.MatchedPackages, ", ")))
+}
+
+// wildcardSearchTerm returns the literal prefix of a wildcard pattern, which is
+// what the package manager is searched for
+func wildcardSearchTerm(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// resolveWildcardInstall searches the package manager for the packages a wildcard
+// name matches. It fails when nothing matches or when more packages match than
+// the max_matches safety cap allows.
+func (m *PackagesModule) resolveWildcardInstall(pkg *PackageConfig, driver drivers.PackageDriver, pattern string) ([]string, error) {
+	term := strings.TrimRight(wildcardSearchTerm(pattern), ".-_")
+	if term == "" {
+		return nil, fmt.Errorf("wildcard pattern %s must start with a literal prefix to search %s for packages", pattern, driver.Name())
+	}
+
+	results, err := driver.SearchPackage(term)
 	if err != nil {
-		return fmt.Errorf("failed to get installed packages for wildcard uninstall: %w", err)
+		return nil, fmt.Errorf("failed to resolve wildcard pattern %s: %w", pattern, err)
 	}
 
-	// Find matching packages
-	var matchingPackages []string
-	for packageName := range allPackages {
-		matched, err := filepath.Match(pattern, packageName)
-		if err != nil {
-			continue // Skip invalid patterns
+	seen := make(map[string]bool)
+	var matches []string
+	for _, name := range results {
+		if seen[name] {
+			continue
 		}
-		if matched {
-			matchingPackages = append(matchingPackages, packageName)
+		if matched, err := filepath.Match(pattern, name); err == nil && matched {
+			seen[name] = true
+			matches = append(matches, name)
 		}
 	}
+	sort.Strings(matches)
 
-	if len(matchingPackages) == 0 {
-		fmt.Printf("No packages found matching pattern: %s\n", pattern)
-		return nil
+	if len(matches) == 0 {
+		return nil, modules.Permanent(fmt.Errorf("no packages available via %s match wildcard pattern %s", driver.Name(), pattern))
+	}
+
+	maxMatches := pkg.MaxMatches
+	if maxMatches == 0 {
+		maxMatches = defaultWildcardMaxMatches
+	}
+	if len(matches) > maxMatches {
+		return nil, modules.Permanent(fmt.Errorf("wildcard pattern %s matches %d packages via %s, more than max_matches (%d): %s",
+			pattern, len(matches), driver.Name(), maxMatches, strings.Join(matches, ", ")))
+	}
+
+	return matches, nil
+}
+
+// installWildcardPackages installs the packages a wildcard name resolved to
+func (m *PackagesModule) installWildcardPackages(driver drivers.PackageDriver, status *PackageStatus) error {
+	for _, pkgName := range status.MatchedPackages {
+		fmt.Printf("Installing matched package: %s (using %s)\n", pkgName, driver.Name())
+	}
+	if err := drivers.InstallPackages(driver, status.MatchedPackages); err != nil {
+		return fmt.Errorf("failed to install packages matching %s: %w", status.PackageName, err)
+	}
+	return nil
+}
+
+// uninstallWildcardPackages uninstalls the installed packages a wildcard name
+// matched, which leave out essential packages
+func (m *PackagesModule) uninstallWildcardPackages(driver drivers.PackageDriver, status *PackageStatus) error {
+	if len(status.KeptPackages) > 0 {
+		fmt.Printf("Keeping essential packages matching %s: %s\n", status.PackageName, strings.Join(status.KeptPackages, ", "))
 	}
 
 	// Uninstall each matching package
-	for _, pkgName := range matchingPackages {
+	for _, pkgName := range status.MatchedPackages {
 		fmt.Printf("Uninstalling matched package: %s (using %s)\n", pkgName, driver.Name())
 		err := driver.UninstallPackage(pkgName)
 		if err != nil {
@@ -1222,7 +1824,7 @@ func (m *PackagesModule) uninstallWildcardPackages(driver drivers.PackageDriver,
 
 // planAddRepo returns what adding a repository would do without executing it
 func (m *PackagesModule) planAddRepo(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
-	repoName := task.Config["name"].(string)
+	repoName := repositorySpec(task.Config)
 
 	plan := &modules.TaskPlan{
 		TaskID:      task.ID,
@@ -1236,22 +1838,18 @@ func (m *PackagesModule) planAddRepo(task *config.Task, ctx *modules.ExecutionCo
 	var driver drivers.PackageDriver
 	var err error
 
-	if only, exists := task.Config["only"]; exists {
-		if onlyList, ok := only.([]interface{}); ok {
-			onlyStrings := make([]string, len(onlyList))
-			for i, o := range onlyList {
-				onlyStrings[i] = o.(string)
-			}
-			driver, err = m.driverRegistry.GetOnlyDriver(onlyStrings)
+	if _, exists := task.Config["only"]; exists {
+		var only []string
+		if only, err = modules.GetStringSlice(task, "only"); err != nil {
+			return nil, err
 		}
-	} else if prefer, exists := task.Config["prefer"]; exists {
-		if preferList, ok := prefer.([]interface{}); ok {
-			preferStrings := make([]string, len(preferList))
-			for i, p := range preferList {
-				preferStrings[i] = p.(string)
-			}
-			driver, err = m.driverRegistry.GetPreferredDriver(preferStrings)
+		driver, err = m.driverRegistry.GetOnlyDriver(only)
+	} else if _, exists := task.Config["prefer"]; exists {
+		var prefer []string
+		if prefer, err = modules.GetStringSlice(task, "prefer"); err != nil {
+			return nil, err
 		}
+		driver, err = m.driverRegistry.GetPreferredDriver(prefer)
 	} else {
 		available := m.driverRegistry.GetAvailableDrivers()
 		if len(available) > 0 {
@@ -1262,25 +1860,142 @@ func (m *PackagesModule) planAddRepo(task *config.Task, ctx *modules.ExecutionCo
 	if err != nil || driver == nil {
 		plan.WillSkip = true
 		plan.SkipReason = "No suitable package manager available"
+		plan.SkipCode = modules.SkipPlatformMismatch
 		return plan, nil
 	}
 
+	plan.Manager = driver.Name()
+
 	// Check if repository is already available
 	isAvailable, err := driver.IsRepositoryAvailable(repoName)
+	if err == nil && isAvailable {
+		// Repository is already available, no action needed
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Repository %s already available in %s", repoName, driver.Name())
+		return plan, nil
+	}
+	if ctx.Offline {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Offline and repository %s is not in %s", repoName, driver.Name())
+		plan.SkipCode = modules.SkipOffline
+		return plan, nil
+	}
 	if err != nil {
 		// If we can't check availability, assume we need to add it
 		plan.Changes = append(plan.Changes, fmt.Sprintf("Add repository %s using %s (unable to verify current state)", repoName, driver.Name()))
 		return plan, nil
 	}
 
-	if isAvailable {
-		// Repository is already available, no action needed
+	plan.Changes = append(plan.Changes, fmt.Sprintf("Add repository %s using %s", repoName, driver.Name()))
+
+	return plan, nil
+}
+
+// validateEnsurePackageManagerTask validates an ensure_package_manager task configuration
+func validateEnsurePackageManagerTask(config map[string]interface{}) error {
+	name, ok := modules.StringValue(config["name"])
+	if !ok || name == "" {
+		return fmt.Errorf("name is required for ensure_package_manager action")
+	}
+	if _, exists := drivers.BootstrapManagerName(name); !exists {
+		return fmt.Errorf("cannot install package manager '%s', supported are scoop, homebrew, chocolatey and cargo (rustup)", name)
+	}
+
+	// Install scripts are downloaded and run as the user, which has to be allowed explicitly
+	if allow, _ := modules.BoolValue(config["allow_install_script"]); !allow {
+		return fmt.Errorf("installing %s downloads and runs its install script, set allow_install_script: true to allow it", name)
+	}
+
+	return nil
+}
+
+// executeEnsurePackageManager installs a package manager that is not available yet
+func (m *PackagesModule) executeEnsurePackageManager(task *config.Task, ctx *modules.ExecutionContext) error {
+	log := logger.Get()
+	name, err := modules.GetString(task, "name")
+	if err != nil {
+		return err
+	}
+
+	driver, err := m.bootstrapDriver(name)
+	if err != nil {
+		return err
+	}
+	if driver.IsAvailable() {
+		log.Debug().Str("manager", driver.Name()).Msg("Package manager already installed")
+		return nil
+	}
+
+	bootstrap, err := drivers.GetBootstrap(name)
+	if err != nil {
+		return err
+	}
+
+	if ctx.DryRun {
+		fmt.Printf("Would install %s (not present)\n", driver.Name())
+		return nil
+	}
+	if ctx.Offline {
+		return fmt.Errorf("cannot install %s in offline mode", driver.Name())
+	}
+
+	fmt.Printf("Installing %s (not present)\n", driver.Name())
+	if err := bootstrap.Run(ctx.RunContext()); err != nil {
+		return err
+	}
+
+	// Later tasks of this run use the new package manager
+	m.driverRegistry.Refresh(bootstrap.Paths...)
+	if !driver.IsAvailable() {
+		return fmt.Errorf("%s was installed but is not found on PATH, add %s to PATH", driver.Name(), strings.Join(bootstrap.Paths, " or "))
+	}
+
+	return nil
+}
+
+// planEnsurePackageManager returns whether a package manager would be installed
+func (m *PackagesModule) planEnsurePackageManager(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	name, err := modules.GetString(task, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: fmt.Sprintf("Ensure package manager %s is installed", name),
+		Changes:     []string{},
+		WillSkip:    false,
+	}
+
+	driver, err := m.bootstrapDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	if driver.IsAvailable() {
 		plan.WillSkip = true
-		plan.SkipReason = fmt.Sprintf("Repository %s already available in %s", repoName, driver.Name())
+		plan.SkipReason = fmt.Sprintf("Package manager %s already installed", driver.Name())
+		return plan, nil
+	}
+	if _, err := drivers.GetBootstrap(name); err != nil {
+		return nil, err
+	}
+	if ctx.Offline {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Offline and %s is not installed", driver.Name())
+		plan.SkipCode = modules.SkipOffline
 		return plan, nil
 	}
 
-	plan.Changes = append(plan.Changes, fmt.Sprintf("Add repository %s using %s", repoName, driver.Name()))
-
+	plan.Changes = append(plan.Changes, fmt.Sprintf("Install %s (not present)", driver.Name()))
 	return plan, nil
 }
+
+// bootstrapDriver returns the driver of a package manager ensure_package_manager installs
+func (m *PackagesModule) bootstrapDriver(name string) (drivers.PackageDriver, error) {
+	manager, exists := drivers.BootstrapManagerName(name)
+	if !exists {
+		return nil, fmt.Errorf("cannot install package manager '%s', supported are scoop, homebrew, chocolatey and cargo (rustup)", name)
+	}
+	return m.driverRegistry.GetDriver(manager)
+}
@@ -1,9 +1,19 @@
 package packages
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages/drivers"
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
 )
@@ -181,3 +191,994 @@ func TestPackageConfigParsing(t *testing.T) {
 		assert.Equal(t, []string{"cargo", "homebrew"}, pkg.Only)
 	})
 }
+
+func TestParsePackageConfig(t *testing.T) {
+	t.Run("ParsesAllFields", func(t *testing.T) {
+		pkg := parsePackageConfig(map[string]interface{}{
+			"name":              "terraform",
+			"state":             "present",
+			"version":           "1.7.5",
+			"managers":          map[string]interface{}{"winget": "Hashicorp.Terraform"},
+			"only":              []interface{}{"homebrew"},
+			"check_system_wide": true,
+		})
+
+		assert.Equal(t, "terraform", pkg.Name)
+		assert.Equal(t, "present", pkg.State)
+		assert.Equal(t, "1.7.5", pkg.Version)
+		assert.Equal(t, map[string]string{"winget": "Hashicorp.Terraform"}, pkg.Managers)
+		assert.Equal(t, []string{"homebrew"}, pkg.Only)
+		assert.Empty(t, pkg.Prefer)
+		assert.True(t, pkg.CheckSystemWide)
+	})
+
+	t.Run("DefaultsToPresent", func(t *testing.T) {
+		pkg := parsePackageConfig(map[string]interface{}{"name": "git"})
+		assert.Equal(t, "present", pkg.State)
+		assert.Empty(t, pkg.Version)
+	})
+}
+
+func TestPackageVersionValidation(t *testing.T) {
+	module := &PackagesModule{driverRegistry: drivers.NewDriverRegistry()}
+
+	t.Run("AcceptsQuotedVersion", func(t *testing.T) {
+		err := module.validateSinglePackageTask(map[string]interface{}{
+			"name":    "terraform",
+			"version": "1.7.5",
+			"only":    []interface{}{"homebrew"},
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("RejectsNumericVersion", func(t *testing.T) {
+		err := module.validateSinglePackageTask(map[string]interface{}{
+			"name":    "python",
+			"version": 3.1,
+		})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "version must be a string")
+	})
+
+	t.Run("RejectsVersionWithWildcard", func(t *testing.T) {
+		err := module.validateSinglePackageTask(map[string]interface{}{
+			"name":    "python3*",
+			"version": "3.12",
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsVersionForAbsentPackages", func(t *testing.T) {
+		err := module.validateMultiplePackagesTask(map[string]interface{}{
+			"packages": []interface{}{
+				map[string]interface{}{
+					"name":    "terraform",
+					"state":   "absent",
+					"version": "1.7.5",
+				},
+			},
+		})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "package 0")
+	})
+}
+
+func TestVersionPinUnsupportedError(t *testing.T) {
+	driver := drivers.NewFlatpakDriver()
+	assert.False(t, driver.SupportsVersionPinning())
+
+	err := driver.InstallPackageVersion("org.mozilla.firefox", "126.0")
+	var pinErr *drivers.ErrVersionPinUnsupported
+	assert.ErrorAs(t, err, &pinErr)
+	assert.Equal(t, "version pinning not supported by flatpak", err.Error())
+}
+
+// sleepingDriver is a fake package manager whose commands never finish in time
+type sleepingDriver struct {
+	*drivers.BaseDriver
+}
+
+func (d *sleepingDriver) IsPackageInstalled(packageName string) (bool, error) {
+	_, err := d.RunCommand("10")
+	return false, err
+}
+
+func (d *sleepingDriver) InstallPackage(packageName string) error {
+	_, err := d.RunCommand("10")
+	return err
+}
+
+func (d *sleepingDriver) UninstallPackage(packageName string) error {
+	_, err := d.RunCommand("10")
+	return err
+}
+
+func (d *sleepingDriver) SearchPackage(packageName string) ([]string, error) {
+	return nil, nil
+}
+
+func (d *sleepingDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (d *sleepingDriver) GetAllInstalledPackages() (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
+func TestPackageTaskTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	driverRegistry := drivers.NewDriverRegistry()
+	driverRegistry.RegisterDriver(&sleepingDriver{BaseDriver: drivers.NewBaseDriver("sleep", "sleep")})
+
+	registry := modules.NewModuleRegistry()
+	assert.NoError(t, registry.Register(&PackagesModule{
+		platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+		driverRegistry: driverRegistry,
+	}))
+
+	task := &config.Task{
+		ID:      "install_package: slowpkg",
+		Action:  "install_package",
+		Config:  map[string]interface{}{"name": "slowpkg", "only": []interface{}{"sleep"}},
+		Timeout: "200ms",
+	}
+
+	start := time.Now()
+	_, err := registry.ExecuteTask(task, &modules.ExecutionContext{Variables: map[string]interface{}{}})
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "command 'sleep 10' timed out after")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	t.Run("DefaultTimeout", func(t *testing.T) {
+		task := &config.Task{
+			ID:     "install_package: slowpkg",
+			Action: "install_package",
+			Config: map[string]interface{}{"name": "slowpkg", "only": []interface{}{"sleep"}},
+		}
+		_, err := registry.ExecuteTask(task, &modules.ExecutionContext{DefaultTimeout: 200 * time.Millisecond})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("InvalidTimeout", func(t *testing.T) {
+		task := &config.Task{Action: "install_package", Config: map[string]interface{}{"name": "git"}, Timeout: "soon"}
+		assert.Error(t, registry.ValidateTask(task))
+	})
+}
+
+// wildcardDriver is a fake package manager with a fixed set of available packages
+type wildcardDriver struct {
+	*drivers.BaseDriver
+	available []string
+	installed map[string]bool
+}
+
+func (d *wildcardDriver) IsPackageInstalled(packageName string) (bool, error) {
+	return d.installed[packageName], nil
+}
+
+func (d *wildcardDriver) InstallPackage(packageName string) error {
+	d.installed[packageName] = true
+	return nil
+}
+
+func (d *wildcardDriver) UninstallPackage(packageName string) error {
+	delete(d.installed, packageName)
+	return nil
+}
+
+func (d *wildcardDriver) SearchPackage(packageName string) ([]string, error) {
+	var results []string
+	for _, name := range d.available {
+		if strings.Contains(name, packageName) {
+			results = append(results, name)
+		}
+	}
+	return results, nil
+}
+
+func (d *wildcardDriver) GetPackageInfo(packageName string) (map[string]string, error) {
+	return map[string]string{"name": packageName}, nil
+}
+
+func (d *wildcardDriver) GetAllInstalledPackages() (map[string]bool, error) {
+	return d.installed, nil
+}
+
+func TestWildcardInstall(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	newModule := func() (*PackagesModule, *wildcardDriver) {
+		driver := &wildcardDriver{
+			BaseDriver: drivers.NewBaseDriver("fake", "sh"),
+			available:  []string{"python3.11", "python3.12", "python3.12-venv", "python3-pip", "libpython3.12"},
+			installed:  map[string]bool{"python3.11": true},
+		}
+		driverRegistry := drivers.NewDriverRegistry()
+		driverRegistry.RegisterDriver(driver)
+		return &PackagesModule{
+			platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+			driverRegistry: driverRegistry,
+		}, driver
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+	task := func(cfg map[string]interface{}) *config.Task {
+		cfg["only"] = []interface{}{"fake"}
+		return &config.Task{ID: "wildcard", Action: "install_package", Config: cfg}
+	}
+
+	t.Run("PlansAndInstallsEachMatch", func(t *testing.T) {
+		m, driver := newModule()
+		installTask := task(map[string]interface{}{"name": "python3.*"})
+
+		plan, err := m.PlanTask(installTask, ctx)
+		require.NoError(t, err)
+		assert.False(t, plan.WillSkip)
+		assert.Equal(t, []string{
+			"Install package python3.12 using fake (matches python3.*)",
+			"Install package python3.12-venv using fake (matches python3.*)",
+		}, plan.Changes)
+
+		require.NoError(t, m.ExecuteTask(installTask, ctx))
+		assert.True(t, driver.installed["python3.12"])
+		assert.True(t, driver.installed["python3.12-venv"])
+		assert.False(t, driver.installed["libpython3.12"])
+
+		plan, err = m.PlanTask(installTask, ctx)
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+	})
+
+	t.Run("NoMatches", func(t *testing.T) {
+		m, _ := newModule()
+		_, err := m.PlanTask(task(map[string]interface{}{"name": "ruby*"}), ctx)
+		assert.ErrorContains(t, err, "no packages available via fake match wildcard pattern ruby*")
+	})
+
+	t.Run("MaxMatches", func(t *testing.T) {
+		m, driver := newModule()
+		err := m.ExecuteTask(task(map[string]interface{}{"name": "python3*", "max_matches": 2}), ctx)
+		assert.ErrorContains(t, err, "matches 4 packages via fake, more than max_matches (2)")
+		assert.Len(t, driver.installed, 1)
+	})
+
+	t.Run("LeadingWildcard", func(t *testing.T) {
+		m, _ := newModule()
+		_, err := m.PlanTask(task(map[string]interface{}{"name": "*-venv"}), ctx)
+		assert.ErrorContains(t, err, "must start with a literal prefix")
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		m, _ := newModule()
+		validate := func(cfg map[string]interface{}) error {
+			return m.ValidateTask(&config.Task{Action: "install_package", Config: cfg})
+		}
+		assert.NoError(t, validate(map[string]interface{}{"name": "python3.*", "max_matches": 5}))
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "python3.*", "max_matches": 0}), "positive number")
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "git", "max_matches": 5}), "wildcard package names")
+	})
+}
+
+func TestWildcardUninstall(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	newModule := func() (*PackagesModule, *wildcardDriver) {
+		installed := map[string]bool{"dpkg": true, "python3-minimal": true, "python3.12-minimal": true}
+		for i := 1; i <= 12; i++ {
+			installed[fmt.Sprintf("python3-lib%02d", i)] = true
+		}
+		driver := &wildcardDriver{BaseDriver: drivers.NewBaseDriver("apt", "sh"), installed: installed}
+		driverRegistry := drivers.NewDriverRegistry()
+		driverRegistry.RegisterDriver(driver)
+		return &PackagesModule{
+			platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+			driverRegistry: driverRegistry,
+		}, driver
+	}
+	task := func(cfg map[string]interface{}) *config.Task {
+		cfg["only"] = []interface{}{"apt"}
+		return &config.Task{ID: "wildcard", Action: "uninstall_package", Config: cfg}
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	t.Run("KeepsEssentialPackages", func(t *testing.T) {
+		m, driver := newModule()
+		uninstallTask := task(map[string]interface{}{"name": "python3*-minimal"})
+
+		plan, err := m.PlanTask(uninstallTask, ctx)
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+		assert.Contains(t, plan.SkipReason, "keeps essential packages python3-minimal, python3.12-minimal")
+
+		require.NoError(t, m.ExecuteTask(uninstallTask, ctx))
+		assert.True(t, driver.installed["python3-minimal"])
+	})
+
+	t.Run("ListsEveryRemoval", func(t *testing.T) {
+		m, driver := newModule()
+		uninstallTask := task(map[string]interface{}{"name": "python3-lib0?"})
+
+		plan, err := m.PlanTask(uninstallTask, ctx)
+		require.NoError(t, err)
+		assert.Len(t, plan.Changes, 9)
+		assert.Equal(t, "Uninstall package python3-lib01 using apt (matches python3-lib0?)", plan.Changes[0])
+
+		require.NoError(t, m.ExecuteTask(uninstallTask, ctx))
+		assert.False(t, driver.installed["python3-lib09"])
+		assert.True(t, driver.installed["python3-lib10"])
+	})
+
+	t.Run("MaxRemovals", func(t *testing.T) {
+		m, driver := newModule()
+		broad := task(map[string]interface{}{"name": "python3*"})
+
+		_, err := m.PlanTask(broad, ctx)
+		assert.ErrorContains(t, err, "would uninstall 12 packages via apt, more than max_removals (10)")
+		err = m.ExecuteTask(broad, ctx)
+		assert.ErrorContains(t, err, "set confirm: true")
+		assert.Len(t, driver.installed, 15)
+
+		plan, err := m.PlanTask(task(map[string]interface{}{"name": "python3*", "max_removals": 12}), ctx)
+		require.NoError(t, err)
+		assert.Len(t, plan.Changes, 13, "12 removals and the essential packages that are kept")
+	})
+
+	t.Run("ConfirmAndForce", func(t *testing.T) {
+		m, driver := newModule()
+		require.NoError(t, m.ExecuteTask(task(map[string]interface{}{"name": "python3*", "confirm": true}), ctx))
+		assert.Equal(t, map[string]bool{"dpkg": true, "python3-minimal": true, "python3.12-minimal": true}, driver.installed)
+
+		m, driver = newModule()
+		forced := &modules.ExecutionContext{Variables: map[string]interface{}{}, ForceWildcards: true}
+		require.NoError(t, m.ExecuteTask(task(map[string]interface{}{"name": "*"}), forced))
+		assert.Equal(t, map[string]bool{"dpkg": true, "python3-minimal": true, "python3.12-minimal": true}, driver.installed)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		m, _ := newModule()
+		validate := func(cfg map[string]interface{}) error {
+			return m.ValidateTask(&config.Task{Action: "uninstall_package", Config: cfg})
+		}
+		assert.NoError(t, validate(map[string]interface{}{"name": "python2*", "max_removals": 30, "confirm": false}))
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "python2*", "max_removals": 0}), "positive number")
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "python2*", "confirm": "yes"}), "boolean")
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "git", "confirm": true}), "wildcard package names")
+	})
+}
+
+func TestAddRepoURL(t *testing.T) {
+	module := &PackagesModule{driverRegistry: drivers.NewDriverRegistry()}
+
+	t.Run("Valid", func(t *testing.T) {
+		cfg := map[string]interface{}{
+			"name": "mybucket",
+			"url":  "https://github.com/me/scoop-bucket",
+			"only": []interface{}{"scoop"},
+		}
+		require.NoError(t, module.validateAddRepoTask(cfg))
+		assert.Equal(t, "mybucket https://github.com/me/scoop-bucket", repositorySpec(cfg))
+	})
+
+	t.Run("NameOnly", func(t *testing.T) {
+		cfg := map[string]interface{}{"name": "extras"}
+		require.NoError(t, module.validateAddRepoTask(cfg))
+		assert.Equal(t, "extras", repositorySpec(cfg))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		invalid := []map[string]interface{}{
+			{"name": "mybucket", "url": 42},
+			{"name": "mybucket", "url": ""},
+			{"name": "my bucket", "url": "https://github.com/me/scoop-bucket"},
+		}
+		for _, cfg := range invalid {
+			assert.Error(t, module.validateAddRepoTask(cfg), "%v", cfg)
+		}
+	})
+}
+
+func TestPackageCaskValidation(t *testing.T) {
+	module := &PackagesModule{driverRegistry: drivers.NewDriverRegistry()}
+
+	require.NoError(t, module.validateSinglePackageTask(map[string]interface{}{"name": "firefox", "cask": true}))
+	assert.True(t, parsePackageConfig(map[string]interface{}{"name": "firefox", "cask": true}).Cask)
+
+	invalid := map[string]map[string]interface{}{
+		"cask must be true or false":                      {"name": "firefox", "cask": "yes"},
+		"version cannot be used with casks":               {"name": "firefox", "cask": true, "version": "120.0"},
+		"cask cannot be used with wildcard package names": {"name": "font-*", "cask": true},
+	}
+	for message, cfg := range invalid {
+		err := module.validateSinglePackageTask(cfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), message)
+	}
+
+	err := module.validateMultiplePackagesTask(map[string]interface{}{
+		"packages": []interface{}{map[string]interface{}{"name": "firefox", "cask": 1}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "package 0: cask must be true or false")
+}
+
+func TestExportPackages(t *testing.T) {
+	module := &PackagesModule{driverRegistry: drivers.NewDriverRegistry()}
+
+	tasks := []*config.Task{
+		{Action: "add_repo", Config: map[string]interface{}{"name": "myorg/tools", "url": "git@github.com:myorg/homebrew-tools.git", "only": []interface{}{"homebrew"}}},
+		{Action: "add_repo", Config: map[string]interface{}{"name": "extras", "only": []interface{}{"scoop"}}},
+		{Action: "install_package", Config: map[string]interface{}{"name": "nodejs", "managers": map[string]interface{}{"homebrew": "node"}}},
+		{Action: "install_package", Config: map[string]interface{}{"name": "visual-studio-code", "cask": true, "only": []interface{}{"brew"}}},
+		{Action: "install_package", Config: map[string]interface{}{"name": "Git.Git", "only": []interface{}{"winget"}}},
+		{Action: "manage_packages", Config: map[string]interface{}{"packages": []interface{}{
+			map[string]interface{}{"name": "terraform", "version": "1.7"},
+			map[string]interface{}{"name": "old-tool", "state": "absent"},
+			map[string]interface{}{"name": "font-*", "prefer": []interface{}{"homebrew"}},
+			map[string]interface{}{"name": "nodejs", "managers": map[string]interface{}{"homebrew": "node"}},
+		}}},
+		{Action: "ensure_file", Config: map[string]interface{}{"path": "~/.zshrc"}},
+	}
+
+	export, err := module.ExportPackages(tasks, "brew", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "homebrew", export.Manager)
+	assert.Equal(t, `# Packages managed with homebrew by dotfiles
+tap "myorg/tools", "git@github.com:myorg/homebrew-tools.git"
+brew "node"
+brew "terraform@1.7"
+cask "visual-studio-code"
+# font-* is a wildcard and resolves to packages when installing
+`, export.Format())
+
+	export, err = module.ExportPackages(tasks, "winget", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "# Packages managed with winget by dotfiles\nGit.Git\nnodejs\nterraform 1.7\n# font-* is a wildcard and resolves to packages when installing\n", export.Format())
+
+	_, err = module.ExportPackages(tasks, "pacman", nil)
+	assert.EqualError(t, err, "unknown package manager 'pacman'")
+}
+
+func TestPackageNameCandidates(t *testing.T) {
+	module := &PackagesModule{driverRegistry: drivers.NewDriverRegistry()}
+	cfg := map[string]interface{}{
+		"name": "java",
+		"managers": map[string]interface{}{
+			"apt": []interface{}{
+				map[string]interface{}{"name": "openjdk-17-jdk", "condition": `Platform.Distro == "Ubuntu"`},
+				map[string]interface{}{"name": "openjdk-21-jdk", "condition": `Platform.Distro == "Debian" && Platform.DistroVersion == "13"`},
+				map[string]interface{}{"name": "default-jdk"},
+			},
+			"homebrew": "openjdk",
+			"dnf": []interface{}{
+				map[string]interface{}{"name": "java-21-openjdk", "condition": `Platform.Distro == "Fedora"`},
+			},
+		},
+	}
+	require.NoError(t, module.validateSinglePackageTask(cfg))
+	pkg := parsePackageConfig(cfg)
+	assert.Equal(t, map[string]string{"homebrew": "openjdk"}, pkg.Managers)
+	assert.Len(t, pkg.ManagerCandidates["apt"], 3)
+
+	on := func(distro, version string) map[string]interface{} {
+		return map[string]interface{}{"Platform": map[string]interface{}{"Distro": distro, "DistroVersion": version}}
+	}
+	for _, tt := range []struct {
+		manager   string
+		variables map[string]interface{}
+		want      string
+	}{
+		{"apt", on("Ubuntu", "22.04"), "openjdk-17-jdk"},
+		{"apt", on("Debian", "13"), "openjdk-21-jdk"},
+		{"apt", on("Debian", "12"), "default-jdk"}, // A candidate without condition always matches
+		{"homebrew", on("", ""), "openjdk"},        // Plain names are used as before
+		{"dnf", on("Rocky", "9"), "java"},          // Without a match the package name is used
+		{"winget", on("", ""), "java"},
+	} {
+		name, err := module.getPackageNameForManager(pkg, tt.manager, tt.variables)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, name, "%s on %v", tt.manager, tt.variables)
+	}
+
+	// A condition that cannot be evaluated fails instead of picking another name
+	broken := parsePackageConfig(map[string]interface{}{"name": "java", "managers": map[string]interface{}{
+		"apt": []interface{}{map[string]interface{}{"name": "openjdk-17-jdk", "condition": "Platform.Distro =="}},
+	}})
+	_, err := module.getPackageNameForManager(broken, "apt", on("Ubuntu", "22.04"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "managers.apt[0]")
+
+	// Export picks the candidates for the variables it is given
+	export, err := module.ExportPackages([]*config.Task{{Action: "install_package", Config: cfg}}, "apt", on("Ubuntu", "24.04"))
+	require.NoError(t, err)
+	assert.Equal(t, []*ExportedPackage{{Name: "openjdk-17-jdk"}}, export.Packages)
+}
+
+func TestPackageNameCandidatesValidation(t *testing.T) {
+	module := &PackagesModule{driverRegistry: drivers.NewDriverRegistry()}
+	for message, managers := range map[string]interface{}{
+		"managers must map package managers to package names": "apt",
+		"managers.apt cannot be an empty list":                map[string]interface{}{"apt": []interface{}{}},
+		"managers.apt[0] must be an object":                   map[string]interface{}{"apt": []interface{}{"openjdk"}},
+		"managers.apt[1]: name is required":                   map[string]interface{}{"apt": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"condition": "true"}}},
+		"managers.apt[0]: condition must be a string":         map[string]interface{}{"apt": []interface{}{map[string]interface{}{"name": "a", "condition": true}}},
+		"managers.apt[0]: unknown field 'when'":               map[string]interface{}{"apt": []interface{}{map[string]interface{}{"name": "a", "when": "true"}}},
+		"managers.apt must be a package name or a list":       map[string]interface{}{"apt": 17},
+	} {
+		err := module.validateSinglePackageTask(map[string]interface{}{"name": "java", "managers": managers})
+		require.Error(t, err, message)
+		assert.Contains(t, err.Error(), message)
+	}
+
+	err := module.validateMultiplePackagesTask(map[string]interface{}{
+		"packages": []interface{}{map[string]interface{}{"name": "java", "managers": map[string]interface{}{"apt": []interface{}{"openjdk"}}}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "package 0: managers.apt[0] must be an object")
+}
+
+func TestEnsurePackageManager(t *testing.T) {
+	newModule := func(executable string) *PackagesModule {
+		driverRegistry := drivers.NewDriverRegistry()
+		driverRegistry.RegisterDriver(&wildcardDriver{BaseDriver: drivers.NewBaseDriver("cargo", executable)})
+		return &PackagesModule{
+			platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+			driverRegistry: driverRegistry,
+		}
+	}
+	task := func(cfg map[string]interface{}) *config.Task {
+		return &config.Task{ID: "bootstrap", Action: "ensure_package_manager", Config: cfg}
+	}
+	allowed := map[string]interface{}{"name": "rustup", "allow_install_script": true}
+
+	t.Run("Validation", func(t *testing.T) {
+		m := newModule("cargo")
+		assert.NoError(t, m.ValidateTask(task(allowed)))
+		assert.ErrorContains(t, m.ValidateTask(task(map[string]interface{}{"name": "scoop"})), "set allow_install_script: true")
+		assert.ErrorContains(t, m.ValidateTask(task(map[string]interface{}{"name": "scoop", "allow_install_script": "yes"})), "set allow_install_script: true")
+		assert.ErrorContains(t, m.ValidateTask(task(map[string]interface{}{"name": "apt", "allow_install_script": true})), "cannot install package manager 'apt'")
+		assert.ErrorContains(t, m.ValidateTask(task(map[string]interface{}{"allow_install_script": true})), "name is required")
+	})
+
+	t.Run("AlreadyInstalled", func(t *testing.T) {
+		if _, err := exec.LookPath("sh"); err != nil {
+			t.Skip("sh is not available")
+		}
+		m := newModule("sh")
+		plan, err := m.PlanTask(task(allowed), &modules.ExecutionContext{})
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+		assert.Equal(t, "Package manager cargo already installed", plan.SkipReason)
+		assert.NoError(t, m.ExecuteTask(task(allowed), &modules.ExecutionContext{}))
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		m := newModule("dotfiles-test-missing-manager")
+		plan, err := m.PlanTask(task(allowed), &modules.ExecutionContext{})
+		require.NoError(t, err)
+		assert.False(t, plan.WillSkip)
+		assert.Equal(t, []string{"Install cargo (not present)"}, plan.Changes)
+
+		assert.NoError(t, m.ExecuteTask(task(allowed), &modules.ExecutionContext{DryRun: true}))
+		assert.EqualError(t, m.ExecuteTask(task(allowed), &modules.ExecutionContext{Offline: true}), "cannot install cargo in offline mode")
+
+		plan, err = m.PlanTask(task(allowed), &modules.ExecutionContext{Offline: true})
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+	})
+}
+
+func TestPreflightChecks(t *testing.T) {
+	newModule := func(executable string) *PackagesModule {
+		driverRegistry := drivers.NewDriverRegistry()
+		driverRegistry.RegisterDriver(&wildcardDriver{BaseDriver: drivers.NewBaseDriver("cargo", executable), installed: map[string]bool{}})
+		return &PackagesModule{
+			platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+			driverRegistry: driverRegistry,
+		}
+	}
+	install := &config.Task{ID: "ripgrep", Action: "install_package", Config: map[string]interface{}{"name": "ripgrep", "only": []interface{}{"cargo"}}}
+	bootstrap := &config.Task{ID: "rustup", Action: "ensure_package_manager", Config: map[string]interface{}{"name": "rustup", "allow_install_script": true}}
+
+	run := func(t *testing.T, m *PackagesModule, tasks ...*config.Task) []*modules.PreflightReport {
+		t.Helper()
+		var checks []*modules.PreflightCheck
+		for _, task := range tasks {
+			taskChecks, err := m.PreflightChecks(task, &modules.ExecutionContext{})
+			require.NoError(t, err)
+			checks = append(checks, taskChecks...)
+		}
+		return modules.RunPreflightChecks(context.Background(), checks, nil)
+	}
+
+	t.Run("Installed", func(t *testing.T) {
+		if _, err := exec.LookPath("sh"); err != nil {
+			t.Skip("sh is not available")
+		}
+		reports := run(t, newModule("sh"), install)
+		require.Len(t, reports, 1)
+		assert.Equal(t, "Package manager cargo", reports[0].Name)
+		assert.Equal(t, modules.CheckPass, reports[0].Status)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		reports := run(t, newModule("dotfiles-test-missing-manager"), install)
+		require.Len(t, reports, 1)
+		assert.Equal(t, modules.CheckFail, reports[0].Status)
+		assert.Equal(t, "Install cargo or add an ensure_package_manager task before this one", reports[0].Hint)
+	})
+
+	t.Run("InstalledByEarlierTask", func(t *testing.T) {
+		reports := run(t, newModule("dotfiles-test-missing-manager"), bootstrap, install)
+		require.Len(t, reports, 1)
+		assert.Equal(t, modules.CheckPass, reports[0].Status)
+		assert.Equal(t, "cargo is not installed yet, ensure_package_manager installs it", reports[0].Message)
+	})
+}
+
+func TestGlobalPackageManagerPreferences(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	driverRegistry := drivers.NewDriverRegistry()
+	driverRegistry.RegisterDriver(&wildcardDriver{BaseDriver: drivers.NewBaseDriver("first", "sh"), installed: map[string]bool{}})
+	driverRegistry.RegisterDriver(&wildcardDriver{BaseDriver: drivers.NewBaseDriver("second", "sh"), installed: map[string]bool{}})
+	m := &PackagesModule{
+		platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+		driverRegistry: driverRegistry,
+	}
+	ctx := &modules.ExecutionContext{
+		Variables:       map[string]interface{}{},
+		PackageManagers: config.PackageManagerSettings{Prefer: []string{"second"}, Exclude: []string{"first"}},
+	}
+	task := func(cfg map[string]interface{}) *config.Task {
+		cfg["name"] = "git"
+		return &config.Task{ID: "install_package: git", Action: "install_package", Config: cfg}
+	}
+
+	t.Run("GlobalPreference", func(t *testing.T) {
+		plan, err := m.PlanTask(task(map[string]interface{}{}), ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Install package git using second"}, plan.Changes)
+	})
+
+	t.Run("ExcludedTaskPreference", func(t *testing.T) {
+		plan, err := m.PlanTask(task(map[string]interface{}{"prefer": []interface{}{"first"}}), ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"Install package git using second",
+			"first is preferred but excluded by settings.package_managers, using second",
+		}, plan.Changes)
+	})
+
+	t.Run("OnlyOverridesExclusion", func(t *testing.T) {
+		plan, err := m.PlanTask(task(map[string]interface{}{"only": []interface{}{"first"}}), ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Install package git using first"}, plan.Changes)
+	})
+
+	t.Run("PreferencesReset", func(t *testing.T) {
+		_, err := m.PlanTask(task(map[string]interface{}{}), ctx)
+		require.NoError(t, err)
+		assert.False(t, driverRegistry.IsExcluded("first"))
+	})
+}
+
+func TestCheckSystemWideCommand(t *testing.T) {
+	newModule := func(os string, onPath ...string) (*PackagesModule, *[]string) {
+		var looked []string
+		return &PackagesModule{
+			platformInfo:   &platform.PlatformInfo{OS: os, Arch: "amd64"},
+			driverRegistry: drivers.NewDriverRegistry(),
+			lookPath: func(name string) (string, error) {
+				looked = append(looked, name)
+				for _, command := range onPath {
+					if command == name {
+						return "/usr/bin/" + name, nil
+					}
+				}
+				return "", exec.ErrNotFound
+			},
+		}, &looked
+	}
+	pkg := func(cfg map[string]interface{}) *PackageConfig {
+		cfg["check_system_wide"] = true
+		pkg := parsePackageConfig(cfg)
+		pkg.State = "present"
+		return pkg
+	}
+
+	t.Run("PackageName", func(t *testing.T) {
+		m, _ := newModule("linux", "git")
+		status, err := m.gatherPackageStatus(pkg(map[string]interface{}{"name": "git"}), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "system", status.Manager)
+		assert.False(t, status.NeedsAction)
+	})
+
+	t.Run("RenamedBinary", func(t *testing.T) {
+		m, looked := newModule("linux", "rg")
+		command, found := m.findSystemCommand(pkg(map[string]interface{}{"name": "ripgrep", "command": "rg"}))
+		assert.True(t, found)
+		assert.Equal(t, "rg", command)
+		assert.Equal(t, []string{"rg"}, *looked)
+	})
+
+	t.Run("AnyCommandOfList", func(t *testing.T) {
+		m, looked := newModule("linux", "fdfind")
+		command, found := m.findSystemCommand(pkg(map[string]interface{}{"name": "fd-find", "command": []interface{}{"fd", "fdfind"}}))
+		assert.True(t, found)
+		assert.Equal(t, "fdfind", command)
+		assert.Equal(t, []string{"fd", "fdfind"}, *looked)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		m, _ := newModule("linux", "ripgrep")
+		_, found := m.findSystemCommand(pkg(map[string]interface{}{"name": "ripgrep", "command": "rg"}))
+		assert.False(t, found)
+	})
+
+	t.Run("WindowsExtensions", func(t *testing.T) {
+		m, looked := newModule("windows", "code.cmd")
+		command, found := m.findSystemCommand(pkg(map[string]interface{}{"name": "vscode", "command": "code"}))
+		assert.True(t, found)
+		assert.Equal(t, "code", command)
+		assert.Equal(t, []string{"code", "code.exe", "code.cmd"}, *looked)
+
+		m, looked = newModule("windows")
+		m.findSystemCommand(pkg(map[string]interface{}{"name": "go", "command": "go.exe"}))
+		assert.Equal(t, []string{"go.exe"}, *looked)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		m, _ := newModule("linux")
+		validate := func(cfg map[string]interface{}) error {
+			return m.ValidateTask(&config.Task{Action: "install_package", Config: cfg})
+		}
+
+		assert.NoError(t, validate(map[string]interface{}{"name": "ripgrep", "check_system_wide": true, "command": "rg"}))
+		assert.NoError(t, validate(map[string]interface{}{"name": "fd-find", "check_system_wide": true, "command": []interface{}{"fd", "fdfind"}}))
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "ripgrep", "command": "rg"}), "only used with check_system_wide")
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "ripgrep", "check_system_wide": true, "command": 1}), "command must be")
+		assert.ErrorContains(t, validate(map[string]interface{}{"name": "ripgrep", "check_system_wide": true, "command": []interface{}{}}), "cannot be empty")
+
+		err := m.ValidateTask(&config.Task{Action: "manage_packages", Config: map[string]interface{}{
+			"packages": []interface{}{map[string]interface{}{"name": "ripgrep", "command": []interface{}{"rg", 2}, "check_system_wide": true}},
+		}})
+		assert.ErrorContains(t, err, "package 0: command must be a list of command names")
+	})
+}
+
+func TestPermanentError(t *testing.T) {
+	assert.NoError(t, permanentError(nil))
+
+	notFound := []string{
+		"failed to install package rigrep via APT: exit status 100\nOutput: E: Unable to locate package rigrep",
+		"failed to install package rigrep via Winget: exit status 1\nOutput: No package found matching input criteria.",
+		"failed to install package rigrep via DNF: exit status 1\nOutput: No match for argument: rigrep",
+		"failed to install package rigrep via Homebrew: exit status 1\nOutput: Error: No available formula with the name \"rigrep\".",
+	}
+	for _, message := range notFound {
+		assert.True(t, modules.IsPermanent(permanentError(errors.New(message))), message)
+	}
+
+	transient := errors.New("failed to install package ripgrep via APT: exit status 100\nOutput: E: Could not get lock /var/lib/dpkg/lock-frontend")
+	assert.False(t, modules.IsPermanent(permanentError(transient)))
+}
+
+// batchDriver is a wildcardDriver that installs several packages with one command
+type batchDriver struct {
+	*wildcardDriver
+	batches [][]string
+}
+
+func (d *batchDriver) InstallPackages(packageNames []string) error {
+	d.batches = append(d.batches, packageNames)
+	failed := make(map[string]error)
+	for _, name := range packageNames {
+		if !slices.Contains(d.available, name) {
+			failed[name] = fmt.Errorf("failed to install package %s via fake: E: Unable to locate package %s", name, name)
+		}
+	}
+	if len(failed) > 0 {
+		return &drivers.BatchInstallError{Failed: failed}
+	}
+	for _, name := range packageNames {
+		d.installed[name] = true
+	}
+	return nil
+}
+
+func TestManagePackagesBatch(t *testing.T) {
+	newModule := func() (*PackagesModule, *batchDriver) {
+		driver := &batchDriver{wildcardDriver: &wildcardDriver{
+			BaseDriver: drivers.NewBaseDriver("fake", "sh"),
+			available:  []string{"git", "curl", "jq", "ripgrep"},
+			installed:  map[string]bool{"git": true},
+		}}
+		driverRegistry := drivers.NewDriverRegistry()
+		driverRegistry.RegisterDriver(driver)
+		return &PackagesModule{
+			platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+			driverRegistry: driverRegistry,
+		}, driver
+	}
+	task := func(names ...string) *config.Task {
+		var packages []interface{}
+		for _, name := range names {
+			packages = append(packages, map[string]interface{}{"name": name, "only": []interface{}{"fake"}})
+		}
+		return &config.Task{ID: "manage_packages", Action: "manage_packages", Config: map[string]interface{}{"packages": packages}}
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	t.Run("OneCommand", func(t *testing.T) {
+		m, driver := newModule()
+		require.NoError(t, m.ExecuteTask(task("git", "curl", "jq", "ripgrep"), ctx))
+		assert.Equal(t, [][]string{{"curl", "jq", "ripgrep"}}, driver.batches)
+		assert.True(t, driver.installed["ripgrep"])
+	})
+
+	t.Run("UnknownPackage", func(t *testing.T) {
+		m, driver := newModule()
+		err := m.ExecuteTask(task("curl", "rigrep", "jq"), ctx)
+		assert.EqualError(t, err, "failed to manage package rigrep: failed to install package rigrep via fake: E: Unable to locate package rigrep")
+		assert.True(t, modules.IsPermanent(err), "a package that does not exist is not worth retrying")
+		// The packages that exist are installed without it
+		assert.Equal(t, [][]string{{"curl", "rigrep", "jq"}, {"curl", "jq"}}, driver.batches)
+		assert.True(t, driver.installed["jq"])
+	})
+}
+
+func TestPackageStatuses(t *testing.T) {
+	driver := &wildcardDriver{
+		BaseDriver: drivers.NewBaseDriver("fake", "sh"),
+		available:  []string{"git", "curl"},
+		installed:  map[string]bool{"git": true, "nano": true},
+	}
+	driverRegistry := drivers.NewDriverRegistry()
+	driverRegistry.RegisterDriver(driver)
+	m := &PackagesModule{
+		platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+		driverRegistry: driverRegistry,
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	task := &config.Task{ID: "packages", Action: "manage_packages", Config: map[string]interface{}{
+		"packages": []interface{}{
+			map[string]interface{}{"name": "git", "only": []interface{}{"fake"}},
+			map[string]interface{}{"name": "ripgrep", "managers": map[string]interface{}{"fake": "rg"}, "only": []interface{}{"fake"}},
+			map[string]interface{}{"name": "nano", "state": "absent", "only": []interface{}{"fake"}},
+			map[string]interface{}{"name": "htop", "only": []interface{}{"missing"}},
+		},
+	}}
+
+	statuses := m.PackageStatuses(task, ctx)
+	require.Len(t, statuses, 4)
+	type row struct{ name, manager, desired, current, action string }
+	var rows []row
+	for _, status := range statuses {
+		rows = append(rows, row{status.PackageName, status.Manager, status.DesiredState, status.CurrentState, status.ActionNeeded})
+	}
+	assert.Equal(t, []row{
+		{"git", "fake", "present", "installed", "none"},
+		{"rg", "fake", "present", "not_installed", "install"},
+		{"nano", "fake", "absent", "installed", "uninstall"},
+		{"htop", "none", "present", "unknown", "none"},
+	}, rows)
+	assert.Empty(t, statuses[0].Error)
+	assert.Contains(t, statuses[3].Error, "no suitable package manager found for htop")
+
+	uninstall := &config.Task{ID: "nano", Action: "uninstall_package", Config: map[string]interface{}{"name": "nano", "only": []interface{}{"fake"}}}
+	statuses = m.PackageStatuses(uninstall, ctx)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "uninstall", statuses[0].ActionNeeded)
+}
+
+// cachedDriver has the package files of some packages cached, so it can install
+// them offline
+type cachedDriver struct {
+	*batchDriver
+	cached         map[string]bool
+	offlineInstall bool
+}
+
+func (d *cachedDriver) IsPackageCached(packageName, version string) (bool, error) {
+	return d.cached[packageName], nil
+}
+
+func (d *cachedDriver) InstallPackage(packageName string) error {
+	d.offlineInstall = d.Offline()
+	return d.batchDriver.InstallPackage(packageName)
+}
+
+func TestOfflinePackages(t *testing.T) {
+	newModule := func() (*PackagesModule, *cachedDriver) {
+		driver := &cachedDriver{
+			batchDriver: &batchDriver{wildcardDriver: &wildcardDriver{
+				BaseDriver: drivers.NewBaseDriver("fake", "sh"),
+				available:  []string{"git", "curl", "jq"},
+				installed:  map[string]bool{"git": true},
+			}},
+			cached: map[string]bool{"jq": true},
+		}
+		driverRegistry := drivers.NewDriverRegistry()
+		driverRegistry.RegisterDriver(driver)
+		return &PackagesModule{
+			platformInfo:   &platform.PlatformInfo{OS: "linux", Arch: "amd64"},
+			driverRegistry: driverRegistry,
+		}, driver
+	}
+	install := func(name string) *config.Task {
+		return &config.Task{ID: name, Action: "install_package", Config: map[string]interface{}{"name": name, "only": []interface{}{"fake"}}}
+	}
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}, Offline: true}
+
+	t.Run("NotCached", func(t *testing.T) {
+		m, driver := newModule()
+		plan, err := m.PlanTask(install("curl"), ctx)
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+		assert.Equal(t, modules.SkipOffline, plan.SkipCode)
+		assert.Equal(t, "Offline and curl is not cached by fake", plan.SkipReason)
+
+		err = m.ExecuteTask(install("curl"), ctx)
+		var offlineErr *drivers.ErrOffline
+		assert.ErrorAs(t, err, &offlineErr)
+		assert.EqualError(t, err, "offline mode: installing curl with fake needs downloads")
+		assert.False(t, driver.installed["curl"])
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		m, driver := newModule()
+		plan, err := m.PlanTask(install("jq"), ctx)
+		require.NoError(t, err)
+		assert.False(t, plan.WillSkip)
+		require.NoError(t, m.ExecuteTask(install("jq"), ctx))
+		assert.True(t, driver.installed["jq"])
+	})
+
+	t.Run("Installed", func(t *testing.T) {
+		m, _ := newModule()
+		plan, err := m.PlanTask(install("git"), ctx)
+		require.NoError(t, err)
+		assert.Empty(t, plan.SkipCode)
+		require.NoError(t, m.ExecuteTask(install("git"), ctx))
+	})
+
+	t.Run("ManagePackages", func(t *testing.T) {
+		m, driver := newModule()
+		task := &config.Task{ID: "manage_packages", Action: "manage_packages", Config: map[string]interface{}{"packages": []interface{}{
+			map[string]interface{}{"name": "curl", "only": []interface{}{"fake"}},
+			map[string]interface{}{"name": "jq", "only": []interface{}{"fake"}},
+		}}}
+
+		plan, err := m.PlanTask(task, ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Install package jq using fake", "Cannot install curl offline, not cached"}, plan.Changes)
+
+		// The cached packages are installed without the others, with the driver offline
+		err = m.ExecuteTask(task, ctx)
+		assert.EqualError(t, err, "failed to manage package curl: offline mode: installing curl with fake needs downloads")
+		assert.True(t, driver.installed["jq"])
+		assert.False(t, driver.installed["curl"])
+		assert.True(t, driver.offlineInstall)
+		assert.False(t, driver.Offline(), "the driver is online again after the task")
+	})
+
+	t.Run("AddRepo", func(t *testing.T) {
+		m, _ := newModule()
+		task := &config.Task{ID: "add_repo", Action: "add_repo", Config: map[string]interface{}{"name": "ppa:git-core/ppa", "only": []interface{}{"fake"}}}
+		plan, err := m.PlanTask(task, ctx)
+		require.NoError(t, err)
+		assert.Equal(t, modules.SkipOffline, plan.SkipCode)
+		assert.ErrorContains(t, m.ExecuteTask(task, ctx), "cannot add repository ppa:git-core/ppa in offline mode")
+	})
+}
@@ -0,0 +1,138 @@
+package packages
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules/packages/drivers"
+)
+
+// managerCheckTimeout limits how long a package manager may take to list its packages
+const managerCheckTimeout = 2 * time.Minute
+
+// PreflightChecks returns the checks a package task needs: the package managers it
+// uses are installed, respond and can run as root
+func (m *PackagesModule) PreflightChecks(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.PreflightCheck, error) {
+	defer m.setDriverContext(ctx)()
+
+	switch task.Action {
+	case "ensure_package_manager":
+		name, err := modules.GetString(task, "name")
+		if err != nil {
+			return nil, err
+		}
+		return m.bootstrapCheck(name, ctx)
+	case "add_repo":
+		return []*modules.PreflightCheck{m.managerCheck(parsePackageConfig(task.Config), "repository "+repositorySpec(task.Config), ctx)}, nil
+	case "manage_packages":
+		var checks []*modules.PreflightCheck
+		entries, _ := task.Config["packages"].([]interface{})
+		for _, item := range entries {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			pkg := parsePackageConfig(entry)
+			checks = append(checks, m.managerCheck(pkg, pkg.Name, ctx))
+		}
+		return checks, nil
+	default:
+		pkg := parsePackageConfig(task.Config)
+		return []*modules.PreflightCheck{m.managerCheck(pkg, pkg.Name, ctx)}, nil
+	}
+}
+
+// bootstrapCheck returns the check of a package manager ensure_package_manager
+// installs. It shares its key with the check of the driver, so packages installed
+// with a package manager an earlier task installs do not fail the check.
+func (m *PackagesModule) bootstrapCheck(name string, ctx *modules.ExecutionContext) ([]*modules.PreflightCheck, error) {
+	driver, err := m.bootstrapDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	if driver.IsAvailable() {
+		return []*modules.PreflightCheck{m.driverCheck(driver, ctx)}, nil
+	}
+	return []*modules.PreflightCheck{{
+		Key:  "package-manager:" + driver.Name(),
+		Name: fmt.Sprintf("Package manager %s", driver.Name()),
+		Run: func(context.Context) *modules.CheckResult {
+			if _, err := drivers.GetBootstrap(name); err != nil {
+				return modules.Failed("Remove the task or limit it to a supported platform with a condition", "%v", err)
+			}
+			if ctx.Offline {
+				return modules.Failed("Run without --offline so it can be installed", "%s is not installed and cannot be installed offline", driver.Name())
+			}
+			return modules.Passed("%s is not installed yet, ensure_package_manager installs it", driver.Name())
+		},
+	}}, nil
+}
+
+// managerCheck returns the check of the package manager a package or repository is
+// managed with. The check fails when no allowed package manager is available.
+func (m *PackagesModule) managerCheck(pkg *PackageConfig, subject string, ctx *modules.ExecutionContext) *modules.PreflightCheck {
+	driver, _, err := m.selectPackageDriver(pkg, ctx.Variables)
+	if err == nil {
+		return m.driverCheck(driver, ctx)
+	}
+
+	check := &modules.PreflightCheck{
+		Key:  "package-manager-for:" + subject,
+		Name: fmt.Sprintf("Package manager for %s", subject),
+	}
+	hint := "Install a package manager supported on this system"
+	if len(pkg.Only) > 0 {
+		hint = fmt.Sprintf("Install %s or add an ensure_package_manager task before this one", strings.Join(pkg.Only, " or "))
+		// An ensure_package_manager task for the only allowed manager shares the key
+		if len(pkg.Only) == 1 {
+			if only, err := m.driverRegistry.GetDriver(pkg.Only[0]); err == nil {
+				check.Key = "package-manager:" + only.Name()
+			}
+		}
+	}
+	check.Run = func(context.Context) *modules.CheckResult {
+		return modules.Failed(hint, "%v", err)
+	}
+	return check
+}
+
+// driverCheck returns the check that a package manager is installed, lists its
+// installed packages, which also fills the cache apply uses, and can run as root
+// without stopping to ask for a password
+func (m *PackagesModule) driverCheck(driver drivers.PackageDriver, ctx *modules.ExecutionContext) *modules.PreflightCheck {
+	name := driver.Name()
+	return &modules.PreflightCheck{
+		Key:  "package-manager:" + name,
+		Name: fmt.Sprintf("Package manager %s", name),
+		Run: func(runCtx context.Context) *modules.CheckResult {
+			runCtx, cancel := context.WithTimeout(runCtx, managerCheckTimeout)
+			defer cancel()
+			checkCtx := *ctx
+			checkCtx.Context = runCtx
+			defer m.setDriverContext(&checkCtx)()
+
+			privileged, ok := driver.(drivers.PrivilegedDriver)
+			needsRoot := ok && privileged.NeedsPrivilege()
+			if needsRoot && !privileged.Privilege().Available() {
+				command := privileged.Privilege().Command()
+				return modules.Failed(fmt.Sprintf("Run apply as root, install %s or set settings.sudo_command", command), "%s needs root and %s is not installed", name, command)
+			}
+			if !driver.IsAvailable() {
+				return modules.Failed(fmt.Sprintf("Install %s or add an ensure_package_manager task", name), "%s is not installed", name)
+			}
+			if _, err := driver.GetAllInstalledPackages(); err != nil {
+				return modules.Failed(fmt.Sprintf("Check that %s works when you run it yourself", name), "%s does not respond: %v", name, err)
+			}
+			if needsRoot && !privileged.Privilege().HasCredentials(runCtx) {
+				command := privileged.Privilege().Command()
+				return modules.Warned(fmt.Sprintf("Run '%s true' first to enter it before apply starts", command),
+					"%s needs root, %s will ask for your password", name, command)
+			}
+			return modules.Passed("%s is installed and responds", name)
+		},
+	}
+}
@@ -0,0 +1,223 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// networkCheckTimeout limits how long a connectivity check waits for a host
+const networkCheckTimeout = 5 * time.Second
+
+// CheckStatus is the outcome of a pre-flight check
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass" // Apply can do what the check is about
+	CheckWarn CheckStatus = "warn" // Apply can go ahead but may need attention, e.g. a password
+	CheckFail CheckStatus = "fail" // Tasks depending on the check will fail
+)
+
+// CheckResult is the outcome of running a pre-flight check
+type CheckResult struct {
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+	Hint    string      `json:"hint,omitempty"` // How to fix a warning or failure
+}
+
+// PreflightCheck checks something tasks need from the system before apply changes
+// anything, e.g. that a package manager is available or a directory writable
+type PreflightCheck struct {
+	Key  string // Identifies what is checked, tasks needing the same thing share one check
+	Name string // Describes what is checked, e.g. "Package manager apt"
+	Run  func(ctx context.Context) *CheckResult
+}
+
+// PreflightChecker is implemented by modules whose tasks depend on the system, so
+// dotfiles doctor and apply --preflight can find problems before anything changes
+type PreflightChecker interface {
+	// PreflightChecks returns the checks a task needs to pass to succeed
+	PreflightChecks(task *config.Task, ctx *ExecutionContext) ([]*PreflightCheck, error)
+}
+
+// PreflightChecks returns the checks of a task. It returns nil when the module
+// handling the task has no checks.
+func (r *ModuleRegistry) PreflightChecks(task *config.Task, ctx *ExecutionContext) ([]*PreflightCheck, error) {
+	module, err := r.GetModuleByAction(task.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	checker, ok := module.(PreflightChecker)
+	if !ok {
+		return nil, nil
+	}
+	return checker.PreflightChecks(task, taskContext(task, ctx))
+}
+
+// Passed returns a passing check result
+func Passed(format string, args ...interface{}) *CheckResult {
+	return &CheckResult{Status: CheckPass, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warned returns a check result that warns with a hint on how to fix it
+func Warned(hint string, format string, args ...interface{}) *CheckResult {
+	return &CheckResult{Status: CheckWarn, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+// Failed returns a failing check result with a hint on how to fix it
+func Failed(hint string, format string, args ...interface{}) *CheckResult {
+	return &CheckResult{Status: CheckFail, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+// SourceExistsCheck checks that a file or directory a task reads from exists
+func SourceExistsCheck(field, path string) *PreflightCheck {
+	return &PreflightCheck{
+		Key:  "source:" + path,
+		Name: fmt.Sprintf("Source %s", path),
+		Run: func(context.Context) *CheckResult {
+			if _, err := os.Stat(path); err != nil {
+				if os.IsNotExist(err) {
+					return Failed(fmt.Sprintf("Create the file or fix the task's '%s'", field), "%s does not exist", path)
+				}
+				return Failed("Check the permissions of the file", "cannot read %s: %v", path, err)
+			}
+			return Passed("%s exists", path)
+		},
+	}
+}
+
+// WritableDirCheck checks that files can be created in a directory. A directory that
+// does not exist yet is created by the task, so its closest existing parent is
+// checked instead.
+func WritableDirCheck(dir string) *PreflightCheck {
+	dir = filepath.Clean(dir)
+	return &PreflightCheck{
+		Key:  "writable:" + dir,
+		Name: fmt.Sprintf("Write access to %s", dir),
+		Run: func(context.Context) *CheckResult {
+			existing := dir
+			for {
+				info, err := os.Stat(existing)
+				if err == nil {
+					if !info.IsDir() {
+						return Failed("Remove the file or change the task's target", "%s is a file, not a directory", existing)
+					}
+					break
+				}
+				parent := filepath.Dir(existing)
+				if parent == existing {
+					return Failed("Check the task's target", "no parent of %s exists", dir)
+				}
+				existing = parent
+			}
+
+			// Writing a probe also catches read-only file systems and full disks
+			probe, err := os.CreateTemp(existing, ".dotfiles-preflight-*")
+			if err == nil {
+				_, err = probe.Write([]byte("preflight"))
+				probe.Close()
+				os.Remove(probe.Name())
+			}
+			if err != nil {
+				return Failed(fmt.Sprintf("Fix the permissions of %s or free up disk space", existing), "cannot write to %s: %v", existing, err)
+			}
+			if existing != dir {
+				return Passed("%s is writable, %s will be created", existing, dir)
+			}
+			return Passed("%s is writable", dir)
+		},
+	}
+}
+
+// NetworkCheck checks that the host of a URL can be reached. Hosts are checked
+// once, however many URLs of theirs tasks download.
+func NetworkCheck(rawURL string) (*PreflightCheck, error) {
+	address, err := networkAddress(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &PreflightCheck{
+		Key:  "network:" + address,
+		Name: fmt.Sprintf("Connection to %s", address),
+		Run: func(ctx context.Context) *CheckResult {
+			dialer := net.Dialer{Timeout: networkCheckTimeout}
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err != nil {
+				return Failed("Check your network connection, or use --offline to skip downloads", "cannot connect to %s: %v", address, err)
+			}
+			conn.Close()
+			return Passed("%s is reachable", address)
+		},
+	}, nil
+}
+
+// networkAddress returns the host and port a URL connects to, including git's
+// scp-like "user@host:path" addresses
+func networkAddress(rawURL string) (string, error) {
+	if !strings.Contains(rawURL, "://") {
+		if userHost, _, found := strings.Cut(rawURL, ":"); found && strings.Contains(userHost, "@") {
+			_, host, _ := strings.Cut(userHost, "@")
+			return net.JoinHostPort(host, "22"), nil
+		}
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	if parsed.Hostname() == "" {
+		return "", fmt.Errorf("URL %s has no host", rawURL)
+	}
+	port := parsed.Port()
+	if port == "" {
+		switch parsed.Scheme {
+		case "http":
+			port = "80"
+		case "ssh", "git+ssh":
+			port = "22"
+		case "git":
+			port = "9418"
+		default:
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(parsed.Hostname(), port), nil
+}
+
+// PreflightReport is a check that ran and its result
+type PreflightReport struct {
+	Name string `json:"name"`
+	*CheckResult
+}
+
+// RunPreflightChecks runs every check once, in the order they were first returned,
+// and passes each result to report as soon as it is known. A check that returns no
+// result fails.
+func RunPreflightChecks(ctx context.Context, checks []*PreflightCheck, report func(*PreflightReport)) []*PreflightReport {
+	seen := make(map[string]bool)
+	var reports []*PreflightReport
+	for _, check := range checks {
+		if seen[check.Key] {
+			continue
+		}
+		seen[check.Key] = true
+
+		result := check.Run(ctx)
+		if result == nil {
+			result = Failed("", "check returned no result")
+		}
+		entry := &PreflightReport{Name: check.Name, CheckResult: result}
+		reports = append(reports, entry)
+		if report != nil {
+			report(entry)
+		}
+	}
+	return reports
+}
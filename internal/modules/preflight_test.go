@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNetworkAddress(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/user/dotfiles.git", "github.com:443"},
+		{"http://mirror.example.com/font.zip", "mirror.example.com:80"},
+		{"https://example.com:8443/file", "example.com:8443"},
+		{"ssh://git@gitlab.com/user/dotfiles.git", "gitlab.com:22"},
+		{"git@github.com:user/dotfiles.git", "github.com:22"},
+	}
+	for _, tt := range tests {
+		got, err := networkAddress(tt.url)
+		if err != nil {
+			t.Errorf("networkAddress(%q) error = %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("networkAddress(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+
+	if _, err := networkAddress("/srv/git/dotfiles"); err == nil {
+		t.Error("networkAddress() of a local path should fail")
+	}
+}
+
+func TestRunPreflightChecks(t *testing.T) {
+	runs := 0
+	check := func(key string, result *CheckResult) *PreflightCheck {
+		return &PreflightCheck{Key: key, Name: key, Run: func(context.Context) *CheckResult {
+			runs++
+			return result
+		}}
+	}
+
+	var reported []string
+	reports := RunPreflightChecks(context.Background(), []*PreflightCheck{
+		check("apt", Passed("apt responds")),
+		check("source", nil),
+		check("apt", Failed("", "not run again")),
+	}, func(report *PreflightReport) {
+		reported = append(reported, report.Name)
+	})
+
+	if runs != 2 {
+		t.Errorf("ran %d checks, want 2 since checks sharing a key run once", runs)
+	}
+	if len(reports) != 2 || len(reported) != 2 {
+		t.Fatalf("got %d reports and %d reported, want 2", len(reports), len(reported))
+	}
+	if reports[0].Status != CheckPass {
+		t.Errorf("first check status = %s, want the first result of the key", reports[0].Status)
+	}
+	if reports[1].Status != CheckFail {
+		t.Errorf("check without result status = %s, want %s", reports[1].Status, CheckFail)
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// launchctlNotFound is the exit code of launchctl print for services that are not loaded
+const launchctlNotFound = 113
+
+// launchdManager manages launchd user agents with launchctl. The name of a service
+// is its label, its job is defined in ~/Library/LaunchAgents/<label>.plist.
+type launchdManager struct {
+	runner *runner
+	uid    int
+	home   string
+}
+
+// Name returns the name of the manager
+func (m *launchdManager) Name() string {
+	return "launchd"
+}
+
+// domain returns the launchd domain of the user's agents
+func (m *launchdManager) domain() string {
+	return fmt.Sprintf("gui/%d", m.uid)
+}
+
+// target returns the service target of a label in the user's domain
+func (m *launchdManager) target(svc *service) string {
+	return m.domain() + "/" + svc.Name
+}
+
+// plistPath returns the file the job of a user agent is defined in
+func (m *launchdManager) plistPath(svc *service) string {
+	return filepath.Join(m.home, "Library", "LaunchAgents", svc.Name+".plist")
+}
+
+// checkScope fails for system services, which are launch daemons owned by root
+func (m *launchdManager) checkScope(svc *service) error {
+	if svc.Scope != "user" {
+		return fmt.Errorf("launchd services can only be managed with scope 'user'")
+	}
+	return nil
+}
+
+// Status returns whether an agent is loaded and running, and whether it is loaded
+// at login: its plist is in ~/Library/LaunchAgents and it is not disabled
+func (m *launchdManager) Status(svc *service) (*serviceStatus, error) {
+	if err := m.checkScope(svc); err != nil {
+		return nil, err
+	}
+
+	status := &serviceStatus{State: "not loaded"}
+	result, err := m.runner.output("launchctl", "print", m.target(svc))
+	if err != nil {
+		return nil, err
+	}
+	switch result.ExitCode {
+	case 0:
+		status.Exists = true
+		status.State, status.Active = parseLaunchctlPrint(result.Stdout)
+	case launchctlNotFound:
+	default:
+		return nil, result.check("launchctl", []string{"print", m.target(svc)})
+	}
+
+	_, err = os.Stat(m.plistPath(svc))
+	hasPlist := err == nil
+	status.Exists = status.Exists || hasPlist
+
+	result, err = m.runner.output("launchctl", "print-disabled", m.domain())
+	if err != nil {
+		return nil, err
+	}
+	if err := result.check("launchctl", []string{"print-disabled", m.domain()}); err != nil {
+		return nil, err
+	}
+	status.Enabled = hasPlist && !parseLaunchctlDisabled(result.Stdout)[svc.Name]
+	return status, nil
+}
+
+// parseLaunchctlPrint returns the state of a loaded service from launchctl print,
+// and whether it is running
+func parseLaunchctlPrint(output string) (string, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), " = ")
+		if found && key == "state" {
+			return value, value == "running"
+		}
+	}
+	return "loaded", false
+}
+
+// parseLaunchctlDisabled returns the labels launchctl print-disabled lists as
+// disabled. Older versions of macOS print true for disabled services instead of
+// "disabled".
+func parseLaunchctlDisabled(output string) map[string]bool {
+	disabled := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		label, value, found := strings.Cut(strings.TrimSpace(line), " => ")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		disabled[strings.Trim(label, `"`)] = value == "disabled" || value == "true"
+	}
+	return disabled
+}
+
+// Reload does nothing, launchctl reads the plist of an agent when it is loaded
+func (m *launchdManager) Reload(svc *service) error {
+	return nil
+}
+
+// Start loads an agent when it is not loaded yet and starts it
+func (m *launchdManager) Start(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	if err := m.load(svc); err != nil {
+		return err
+	}
+	return m.runner.exec(false, "launchctl", "kickstart", m.target(svc))
+}
+
+// Stop unloads an agent, which stops it without keep-alive restarting it
+func (m *launchdManager) Stop(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	result, err := m.runner.output("launchctl", "print", m.target(svc))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode == launchctlNotFound {
+		return nil
+	}
+	return m.runner.exec(false, "launchctl", "bootout", m.target(svc))
+}
+
+// Enable makes an agent load at login. Its plist has to be in ~/Library/LaunchAgents.
+func (m *launchdManager) Enable(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	if _, err := os.Stat(m.plistPath(svc)); err != nil {
+		return fmt.Errorf("launch agent %s has no plist at %s", svc.Name, m.plistPath(svc))
+	}
+	return m.runner.exec(false, "launchctl", "enable", m.target(svc))
+}
+
+// Disable stops an agent from loading at login
+func (m *launchdManager) Disable(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	return m.runner.exec(false, "launchctl", "disable", m.target(svc))
+}
+
+// load bootstraps an agent from its plist when it is not loaded
+func (m *launchdManager) load(svc *service) error {
+	result, err := m.runner.output("launchctl", "print", m.target(svc))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != launchctlNotFound {
+		return nil
+	}
+	if _, err := os.Stat(m.plistPath(svc)); err != nil {
+		return fmt.Errorf("launch agent %s is not loaded and has no plist at %s", svc.Name, m.plistPath(svc))
+	}
+	return m.runner.exec(false, "launchctl", "bootstrap", m.domain(), m.plistPath(svc))
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const launchctlPrintRunning = `gui/501/homebrew.mxcl.syncthing = {
+	active count = 1
+	path = /Users/menno/Library/LaunchAgents/homebrew.mxcl.syncthing.plist
+	type = LaunchAgent
+	state = running
+
+	program = /opt/homebrew/opt/syncthing/bin/syncthing
+	endpoints = {
+	}
+	pid = 1234
+}
+`
+
+const launchctlPrintDisabled = `disabled services = {
+	"com.apple.Siri.agent" => disabled
+	"homebrew.mxcl.syncthing" => enabled
+	"com.docker.helper" => true
+	"org.example.agent" => false
+}
+`
+
+func TestParseLaunchctlPrint(t *testing.T) {
+	state, running := parseLaunchctlPrint(launchctlPrintRunning)
+	assert.Equal(t, "running", state)
+	assert.True(t, running)
+
+	state, running = parseLaunchctlPrint("gui/501/org.example.agent = {\n\tstate = not running\n}\n")
+	assert.Equal(t, "not running", state)
+	assert.False(t, running)
+}
+
+func TestParseLaunchctlDisabled(t *testing.T) {
+	disabled := parseLaunchctlDisabled(launchctlPrintDisabled)
+	assert.True(t, disabled["com.apple.Siri.agent"])
+	assert.False(t, disabled["homebrew.mxcl.syncthing"])
+	assert.True(t, disabled["com.docker.helper"], "older versions print true for disabled services")
+	assert.False(t, disabled["org.example.agent"])
+}
+
+func TestLaunchdStatus(t *testing.T) {
+	home := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "Library", "LaunchAgents"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(home, "Library", "LaunchAgents", "homebrew.mxcl.syncthing.plist"), []byte("<plist/>"), 0644))
+
+	fake := &fakeCommands{results: map[string]*commandResult{
+		"launchctl print gui/501/homebrew.mxcl.syncthing": {Stdout: launchctlPrintRunning},
+		"launchctl print gui/501/org.example.agent":       {Stderr: "Could not find service \"org.example.agent\" in domain for user gui: 501\n", ExitCode: launchctlNotFound},
+		"launchctl print-disabled gui/501":                {Stdout: launchctlPrintDisabled},
+	}}
+	m := &launchdManager{runner: &runner{ctx: context.Background(), run: fake.run}, uid: 501, home: home}
+
+	status, err := m.Status(&service{Name: "homebrew.mxcl.syncthing", Scope: "user"})
+	require.NoError(t, err)
+	assert.Equal(t, serviceStatus{Exists: true, Active: true, Enabled: true, State: "running"}, *status)
+
+	status, err = m.Status(&service{Name: "org.example.agent", Scope: "user"})
+	require.NoError(t, err)
+	assert.False(t, status.Exists, "agents that are not loaded and have no plist do not exist")
+
+	_, err = m.Status(&service{Name: "com.docker.vmnetd", Scope: "system"})
+	assert.ErrorContains(t, err, "scope 'user'")
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultSudoCommand is the command system services are managed with when apply
+// does not run as root
+const defaultSudoCommand = "sudo"
+
+// serviceManager starts, stops, enables and disables services with the service
+// manager of the system
+type serviceManager interface {
+	// Name returns the name of the manager as used in the manager field
+	Name() string
+
+	// Status returns the current state of a service
+	Status(svc *service) (*serviceStatus, error)
+
+	// Reload makes the manager pick up services installed since it last looked
+	Reload(svc *service) error
+
+	Start(svc *service) error
+	Stop(svc *service) error
+	Enable(svc *service) error
+	Disable(svc *service) error
+}
+
+// serviceStatus is the current state of a service
+type serviceStatus struct {
+	Exists  bool   // Whether the manager knows the service
+	Active  bool   // Whether the service is running
+	Enabled bool   // Whether the service starts at boot or login
+	State   string // State as reported by the manager, e.g. "inactive" or "STOPPED"
+}
+
+// commandResult is the outcome of a command that ran
+type commandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// runFunc runs a command and returns its result. Commands exiting non-zero are
+// not an error, only commands that could not be run at all.
+type runFunc func(ctx context.Context, name string, args ...string) (*commandResult, error)
+
+// runCommand runs a command and captures its output
+func runCommand(ctx context.Context, name string, args ...string) (*commandResult, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	result := &commandResult{Stdout: stdout.String(), Stderr: stderr.String()}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("command '%s' timed out after %s: %w", strings.Join(cmd.Args, " "), time.Since(start).Round(time.Millisecond), ctx.Err())
+	case ctx.Err() == context.Canceled:
+		return nil, fmt.Errorf("command '%s' was cancelled after %s: %w", strings.Join(cmd.Args, " "), time.Since(start).Round(time.Millisecond), ctx.Err())
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	default:
+		return nil, fmt.Errorf("failed to run %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// runner runs the commands of a service manager for one task
+type runner struct {
+	ctx         context.Context
+	run         runFunc
+	sudoCommand string
+	isRoot      bool
+}
+
+// newRunner creates a runner that escalates with sudoCommand, or sudo when it is empty
+func newRunner(ctx context.Context, sudoCommand string) *runner {
+	if sudoCommand == "" {
+		sudoCommand = defaultSudoCommand
+	}
+	return &runner{
+		ctx:         ctx,
+		run:         runCommand,
+		sudoCommand: sudoCommand,
+		isRoot:      os.Geteuid() == 0,
+	}
+}
+
+// output runs a command and returns its result, whatever its exit code
+func (r *runner) output(name string, args ...string) (*commandResult, error) {
+	return r.run(r.ctx, name, args...)
+}
+
+// exec runs a command that has to succeed. Privileged commands are run with the
+// sudo command unless the process is root.
+func (r *runner) exec(privileged bool, name string, args ...string) error {
+	if privileged && !r.isRoot {
+		name, args = r.sudoCommand, append([]string{name}, args...)
+	}
+	result, err := r.run(r.ctx, name, args...)
+	if err != nil {
+		return err
+	}
+	return result.check(name, args)
+}
+
+// check returns an error naming the command and its output when it exited non-zero
+func (c *commandResult) check(name string, args []string) error {
+	if c.ExitCode == 0 {
+		return nil
+	}
+	commandLine := strings.Join(append([]string{name}, args...), " ")
+	output := strings.TrimSpace(strings.TrimSpace(c.Stderr) + "\n" + strings.TrimSpace(c.Stdout))
+	if output == "" {
+		return fmt.Errorf("command '%s' failed with exit code %d", commandLine, c.ExitCode)
+	}
+	return fmt.Errorf("command '%s' failed with exit code %d: %s", commandLine, c.ExitCode, output)
+}
+
+// newManager returns the service manager to use on goos, or the one named by the
+// task's manager field
+func newManager(name, goos string, r *runner) (serviceManager, error) {
+	if name == "" {
+		switch goos {
+		case "windows":
+			name = "sc"
+		case "darwin":
+			name = "launchd"
+		default:
+			name = "systemd"
+		}
+	}
+
+	switch name {
+	case "systemd":
+		return &systemdManager{runner: r}, nil
+	case "launchd":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine home directory: %w", err)
+		}
+		return &launchdManager{runner: r, uid: os.Getuid(), home: home}, nil
+	case "sc":
+		return &scManager{runner: r}, nil
+	default:
+		return nil, fmt.Errorf("unknown service manager '%s'", name)
+	}
+}
+
+// managerCommands are the commands each manager needs
+var managerCommands = map[string]string{
+	"systemd": "systemctl",
+	"launchd": "launchctl",
+	"sc":      "sc",
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scServiceNotFound is the exit code of sc for services that are not installed
+const scServiceNotFound = 1060
+
+// scManager manages Windows services with sc.exe. Windows services belong to the
+// system, so changing them needs an elevated shell.
+type scManager struct {
+	runner *runner
+}
+
+// Name returns the name of the manager
+func (m *scManager) Name() string {
+	return "sc"
+}
+
+// checkScope fails for user services, which Windows services are not
+func (m *scManager) checkScope(svc *service) error {
+	if svc.Scope != "system" {
+		return fmt.Errorf("Windows services can only be managed with scope 'system'")
+	}
+	return nil
+}
+
+// Status returns the state of a service from sc query and its start type from sc qc
+func (m *scManager) Status(svc *service) (*serviceStatus, error) {
+	if err := m.checkScope(svc); err != nil {
+		return nil, err
+	}
+
+	result, err := m.runner.output("sc", "query", svc.Name)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode == scServiceNotFound {
+		return &serviceStatus{State: "not installed"}, nil
+	}
+	if err := result.check("sc", []string{"query", svc.Name}); err != nil {
+		return nil, err
+	}
+	state, err := parseScField(result.Stdout, "STATE")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err = m.runner.output("sc", "qc", svc.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := result.check("sc", []string{"qc", svc.Name}); err != nil {
+		return nil, err
+	}
+	startType, err := parseScField(result.Stdout, "START_TYPE")
+	if err != nil {
+		return nil, err
+	}
+
+	status := &serviceStatus{Exists: true, State: state}
+	switch state {
+	case "RUNNING", "START_PENDING", "CONTINUE_PENDING":
+		status.Active = true
+	}
+	switch startType {
+	case "AUTO_START", "BOOT_START", "SYSTEM_START":
+		status.Enabled = true
+	}
+	return status, nil
+}
+
+// parseScField returns the symbolic value of a field printed by sc query or sc qc,
+// e.g. RUNNING for "STATE : 4  RUNNING"
+func parseScField(output, field string) (string, error) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(key) != field {
+			continue
+		}
+		// The numeric value comes first, the symbolic name second
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			break
+		}
+		return fields[1], nil
+	}
+	return "", fmt.Errorf("sc did not report the %s of the service: %q", field, strings.TrimSpace(output))
+}
+
+// Reload does nothing, installed services are known to sc right away
+func (m *scManager) Reload(svc *service) error {
+	return nil
+}
+
+// Start starts a service
+func (m *scManager) Start(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	return m.runner.exec(false, "sc", "start", svc.Name)
+}
+
+// Stop stops a service
+func (m *scManager) Stop(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	return m.runner.exec(false, "sc", "stop", svc.Name)
+}
+
+// Enable makes a service start automatically at boot
+func (m *scManager) Enable(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	return m.runner.exec(false, "sc", "config", svc.Name, "start=", "auto")
+}
+
+// Disable makes a service only start when it is started by hand, like a service
+// that was never enabled
+func (m *scManager) Disable(svc *service) error {
+	if err := m.checkScope(svc); err != nil {
+		return err
+	}
+	return m.runner.exec(false, "sc", "config", svc.Name, "start=", "demand")
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const scQueryRunning = `
+SERVICE_NAME: Spooler
+        TYPE               : 110  WIN32_OWN_PROCESS  (interactive)
+        STATE              : 4  RUNNING
+                                (STOPPABLE, NOT_PAUSABLE, ACCEPTS_SHUTDOWN)
+        WIN32_EXIT_CODE    : 0  (0x0)
+        SERVICE_EXIT_CODE  : 0  (0x0)
+        CHECKPOINT         : 0x0
+        WAIT_HINT          : 0x0
+`
+
+const scQcDelayed = `[SC] QueryServiceConfig SUCCESS
+
+SERVICE_NAME: Spooler
+        TYPE               : 110  WIN32_OWN_PROCESS  (interactive)
+        START_TYPE         : 2   AUTO_START  (DELAYED)
+        ERROR_CONTROL      : 1   NORMAL
+        BINARY_PATH_NAME   : C:\Windows\System32\spoolsv.exe
+        LOAD_ORDER_GROUP   : SpoolerGroup
+        TAG                : 0
+        DISPLAY_NAME       : Print Spooler
+        DEPENDENCIES       : RPCSS
+                           : http
+        SERVICE_START_NAME : LocalSystem
+`
+
+func TestParseScField(t *testing.T) {
+	state, err := parseScField(scQueryRunning, "STATE")
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", state)
+
+	startType, err := parseScField(scQcDelayed, "START_TYPE")
+	require.NoError(t, err)
+	assert.Equal(t, "AUTO_START", startType)
+
+	_, err = parseScField("[SC] OpenService FAILED 5:\r\n\r\nAccess is denied.\r\n", "STATE")
+	assert.Error(t, err)
+}
+
+func TestScStatus(t *testing.T) {
+	fake := &fakeCommands{results: map[string]*commandResult{
+		"sc query Spooler": {Stdout: scQueryRunning},
+		"sc qc Spooler":    {Stdout: scQcDelayed},
+		"sc query Missing": {Stdout: "[SC] EnumQueryServicesStatus:OpenService FAILED 1060:\r\n\r\nThe specified service does not exist as an installed service.\r\n", ExitCode: scServiceNotFound},
+	}}
+	m := &scManager{runner: &runner{ctx: context.Background(), run: fake.run}}
+
+	status, err := m.Status(&service{Name: "Spooler", Scope: "system"})
+	require.NoError(t, err)
+	assert.Equal(t, serviceStatus{Exists: true, Active: true, Enabled: true, State: "RUNNING"}, *status)
+
+	status, err = m.Status(&service{Name: "Missing", Scope: "system"})
+	require.NoError(t, err)
+	assert.False(t, status.Exists)
+
+	require.NoError(t, m.Disable(&service{Name: "Spooler", Scope: "system"}))
+	assert.Equal(t, "sc config Spooler start= demand", fake.calls[len(fake.calls)-1])
+}
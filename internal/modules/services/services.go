@@ -0,0 +1,366 @@
+package services
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+)
+
+// ServicesModule starts, stops, enables and disables services with systemd, launchd
+// or the Windows service manager
+type ServicesModule struct {
+	templateEngine *templating.TemplatingEngine
+	goos           string
+	lookPath       func(string) (string, error)
+	newRunner      func(ctx *modules.ExecutionContext) *runner
+}
+
+// service holds the rendered configuration of an ensure_service task
+type service struct {
+	Name    string
+	Manager string // Empty for the manager of the platform
+	Scope   string // "user" or "system"
+	State   string // "started", "stopped" or empty to leave it as it is
+	Enabled *bool  // Nil to leave it as it is
+}
+
+// New creates a new services module
+func New() *ServicesModule {
+	return &ServicesModule{
+		templateEngine: templating.NewTemplatingEngine("."),
+		goos:           runtime.GOOS,
+		lookPath:       exec.LookPath,
+		newRunner: func(ctx *modules.ExecutionContext) *runner {
+			return newRunner(ctx.RunContext(), ctx.SudoCommand)
+		},
+	}
+}
+
+// Name returns the module name
+func (m *ServicesModule) Name() string {
+	return "services"
+}
+
+// ActionKeys returns the action keys this module handles
+func (m *ServicesModule) ActionKeys() []string {
+	return []string{"ensure_service"}
+}
+
+// ValidateTask validates an ensure_service task configuration
+func (m *ServicesModule) ValidateTask(task *config.Task) error {
+	if task.Action != "ensure_service" {
+		return fmt.Errorf("services module only handles 'ensure_service' action, got '%s'", task.Action)
+	}
+
+	for _, field := range []string{"name", "state", "scope", "manager"} {
+		if value, exists := task.Config[field]; exists {
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("ensure_service '%s' must be a string", field)
+			}
+		}
+	}
+
+	if name, exists := task.Config["name"]; !exists || name == "" {
+		return fmt.Errorf("ensure_service task requires 'name' field")
+	}
+	if state, exists := task.Config["state"]; exists && state != "started" && state != "stopped" {
+		return fmt.Errorf("ensure_service 'state' must be 'started' or 'stopped', got '%s'", state)
+	}
+	if enabled, exists := task.Config["enabled"]; exists {
+		if _, ok := modules.BoolValue(enabled); !ok {
+			return fmt.Errorf("ensure_service 'enabled' must be a boolean")
+		}
+	}
+	if scope, exists := task.Config["scope"]; exists && scope != "user" && scope != "system" {
+		return fmt.Errorf("ensure_service 'scope' must be 'user' or 'system', got '%s'", scope)
+	}
+	if manager, exists := task.Config["manager"]; exists {
+		if _, known := managerCommands[manager.(string)]; !known {
+			return fmt.Errorf("ensure_service 'manager' must be 'systemd', 'launchd' or 'sc', got '%s'", manager)
+		}
+	}
+
+	return nil
+}
+
+// parseService renders the configuration of an ensure_service task. Without state
+// and enabled the service is started and enabled.
+func (m *ServicesModule) parseService(task *config.Task, ctx *modules.ExecutionContext) (*service, error) {
+	rawName, err := modules.GetString(task, "name")
+	if err != nil {
+		return nil, err
+	}
+	name, err := m.templateEngine.ProcessString(rawName, ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process name template: %w", err)
+	}
+
+	svc := &service{Name: name}
+	svc.Manager, _ = modules.StringValue(task.Config["manager"])
+	svc.State, _ = modules.StringValue(task.Config["state"])
+	if enabled, ok := modules.BoolValue(task.Config["enabled"]); ok {
+		svc.Enabled = &enabled
+	}
+	if svc.State == "" && svc.Enabled == nil {
+		enabled := true
+		svc.State, svc.Enabled = "started", &enabled
+	}
+
+	// Windows services always belong to the system
+	svc.Scope = "user"
+	if svc.Manager == "sc" || (svc.Manager == "" && m.goos == "windows") {
+		svc.Scope = "system"
+	}
+	if scope, ok := modules.StringValue(task.Config["scope"]); ok {
+		svc.Scope = scope
+	}
+	return svc, nil
+}
+
+// describe returns what the task does to the service
+func (s *service) describe() string {
+	description := fmt.Sprintf("Ensure %s service %s", s.Scope, s.Name)
+	if s.State != "" {
+		description += " is " + s.State
+	}
+	if s.Enabled != nil {
+		if s.State != "" {
+			description += " and"
+		}
+		if *s.Enabled {
+			description += " enabled"
+		} else {
+			description += " disabled"
+		}
+	}
+	return description
+}
+
+// manager returns the service manager of a task, failing when its command is not installed
+func (m *ServicesModule) manager(svc *service, ctx *modules.ExecutionContext) (serviceManager, error) {
+	manager, err := newManager(svc.Manager, m.goos, m.newRunner(ctx))
+	if err != nil {
+		return nil, err
+	}
+	command := managerCommands[manager.Name()]
+	if _, err := m.lookPath(command); err != nil {
+		return nil, fmt.Errorf("%s is not available, %s services cannot be managed on this system", command, manager.Name())
+	}
+	return manager, nil
+}
+
+// status returns the current state of a service
+func status(manager serviceManager, svc *service) (*serviceStatus, error) {
+	status, err := manager.Status(svc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s service %s: %w", svc.Scope, svc.Name, err)
+	}
+	return status, nil
+}
+
+// changes returns what has to change for a service to reach its desired state, in
+// the order they are made: enabling before starting, so launchd agents can be loaded
+func (s *service) changes(status *serviceStatus) []string {
+	var changes []string
+	if s.Enabled != nil && *s.Enabled != status.Enabled {
+		if *s.Enabled {
+			changes = append(changes, fmt.Sprintf("Enable %s", s.Name))
+		} else {
+			changes = append(changes, fmt.Sprintf("Disable %s", s.Name))
+		}
+	}
+	switch {
+	case s.State == "started" && !status.Active:
+		changes = append(changes, fmt.Sprintf("Start %s", s.Name))
+	case s.State == "stopped" && status.Active:
+		changes = append(changes, fmt.Sprintf("Stop %s", s.Name))
+	}
+	return changes
+}
+
+// ExecuteTask executes an ensure_service task
+func (m *ServicesModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionContext) error {
+	if ctx.DryRun {
+		return nil // Plan already showed what would happen
+	}
+
+	svc, err := m.parseService(task, ctx)
+	if err != nil {
+		return err
+	}
+	manager, err := m.manager(svc, ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := status(manager, svc)
+	if err != nil {
+		return err
+	}
+
+	// A unit file written by an earlier task is only known after a reload
+	if !current.Exists {
+		if err := manager.Reload(svc); err != nil {
+			return fmt.Errorf("failed to reload %s services: %w", manager.Name(), err)
+		}
+		if current, err = status(manager, svc); err != nil {
+			return err
+		}
+		if !current.Exists {
+			return fmt.Errorf("%s service %s does not exist", svc.Scope, svc.Name)
+		}
+	}
+
+	if svc.Enabled != nil && *svc.Enabled != current.Enabled {
+		if ctx.Verbose {
+			fmt.Printf("Setting %s service %s enabled: %t\n", svc.Scope, svc.Name, *svc.Enabled)
+		}
+		if *svc.Enabled {
+			err = manager.Enable(svc)
+		} else {
+			err = manager.Disable(svc)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to change whether %s is enabled: %w", svc.Name, err)
+		}
+	}
+
+	switch {
+	case svc.State == "started" && !current.Active:
+		if ctx.Verbose {
+			fmt.Printf("Starting %s service %s\n", svc.Scope, svc.Name)
+		}
+		if err := manager.Start(svc); err != nil {
+			return fmt.Errorf("failed to start %s: %w", svc.Name, err)
+		}
+	case svc.State == "stopped" && current.Active:
+		if ctx.Verbose {
+			fmt.Printf("Stopping %s service %s\n", svc.Scope, svc.Name)
+		}
+		if err := manager.Stop(svc); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", svc.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// PlanTask returns what the ensure_service task would do, from the current state
+// of the service
+func (m *ServicesModule) PlanTask(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	svc, err := m.parseService(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID:      task.ID,
+		Action:      task.Action,
+		Description: svc.describe(),
+		Changes:     []string{},
+	}
+
+	manager, err := m.manager(svc, ctx)
+	if err != nil {
+		return nil, err
+	}
+	current, err := status(manager, svc)
+	if err != nil {
+		return nil, err
+	}
+
+	if !current.Exists {
+		// The service may be installed by an earlier task
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Service %s is not installed yet, reload %s services", svc.Name, manager.Name()))
+		plan.Changes = append(plan.Changes, svc.changes(&serviceStatus{})...)
+		return plan, nil
+	}
+
+	plan.Changes = svc.changes(current)
+	if len(plan.Changes) == 0 {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("Service is already in the desired state (%s)", current.State)
+	}
+	return plan, nil
+}
+
+// ExplainAction returns documentation for a specific action
+func (m *ServicesModule) ExplainAction(action string) (*modules.ActionDocumentation, error) {
+	for _, doc := range m.ListActions() {
+		if doc.Action == action {
+			return doc, nil
+		}
+	}
+	return nil, fmt.Errorf("action '%s' not supported by services module", action)
+}
+
+// ListActions returns documentation for all actions supported by this module
+func (m *ServicesModule) ListActions() []*modules.ActionDocumentation {
+	return []*modules.ActionDocumentation{
+		{
+			Action:      "ensure_service",
+			Description: "Starts or stops a service and enables or disables it, with systemd on Linux, launchd on macOS and the service manager on Windows. Without state and enabled the service is started and enabled.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "name",
+					Type:        "string",
+					Required:    true,
+					Description: "Name of the service: a systemd unit, a launchd label or a Windows service name. Supports template variables.",
+				},
+				{
+					Name:        "state",
+					Type:        "string",
+					Required:    false,
+					Description: "'started' or 'stopped', left as it is when not set",
+				},
+				{
+					Name:        "enabled",
+					Type:        "boolean",
+					Required:    false,
+					Description: "Whether the service starts at boot, or at login for user services. Left as it is when not set.",
+				},
+				{
+					Name:        "scope",
+					Type:        "string",
+					Required:    false,
+					Default:     "user",
+					Description: "'user' for services of your account (systemctl --user, launch agents), 'system' for system services managed as root with settings.sudo_command. Windows services are always 'system'.",
+				},
+				{
+					Name:        "manager",
+					Type:        "string",
+					Required:    false,
+					Description: "Service manager to use instead of the one of the platform: 'systemd', 'launchd' or 'sc'",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Start syncthing now and at every login",
+					Config: map[string]interface{}{
+						"name": "syncthing",
+					},
+				},
+				{
+					Description: "Enable the docker daemon without starting it now",
+					Config: map[string]interface{}{
+						"name":    "docker",
+						"scope":   "system",
+						"enabled": true,
+					},
+				},
+				{
+					Description: "Stop and disable a launch agent on macOS",
+					Config: map[string]interface{}{
+						"name":    "homebrew.mxcl.syncthing",
+						"state":   "stopped",
+						"enabled": false,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// fakeCommands answers commands with canned results and records the ones that ran
+type fakeCommands struct {
+	results map[string]*commandResult
+	calls   []string
+}
+
+// run returns the result for a command line, or success without output
+func (f *fakeCommands) run(ctx context.Context, name string, args ...string) (*commandResult, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, line)
+	if result, ok := f.results[line]; ok {
+		return result, nil
+	}
+	return &commandResult{}, nil
+}
+
+// newTestModule creates a services module on goos that runs commands with fake
+func newTestModule(goos string, fake *fakeCommands) *ServicesModule {
+	m := New()
+	m.goos = goos
+	m.lookPath = func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	m.newRunner = func(ctx *modules.ExecutionContext) *runner {
+		return &runner{ctx: context.Background(), run: fake.run, sudoCommand: "sudo"}
+	}
+	return m
+}
+
+// newServiceTask creates an ensure_service task
+func newServiceTask(cfg map[string]interface{}) *config.Task {
+	return &config.Task{ID: "ensure_service: test", Action: "ensure_service", Config: cfg}
+}
+
+// systemdShow returns the output of systemctl show for a unit
+func systemdShow(loadState, activeState, unitFileState string) *commandResult {
+	return &commandResult{Stdout: fmt.Sprintf("LoadState=%s\nActiveState=%s\nUnitFileState=%s\n", loadState, activeState, unitFileState)}
+}
+
+func TestValidateEnsureService(t *testing.T) {
+	m := New()
+
+	valid := []map[string]interface{}{
+		{"name": "syncthing"},
+		{"name": "docker", "scope": "system", "enabled": true},
+		{"name": "cups", "state": "stopped", "enabled": false},
+		{"name": "homebrew.mxcl.syncthing", "manager": "launchd"},
+	}
+	for _, cfg := range valid {
+		assert.NoError(t, m.ValidateTask(newServiceTask(cfg)), "%v", cfg)
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"missing name":    {"state": "started"},
+		"empty name":      {"name": ""},
+		"name type":       {"name": 1},
+		"unknown state":   {"name": "syncthing", "state": "running"},
+		"enabled type":    {"name": "syncthing", "enabled": "yes"},
+		"unknown scope":   {"name": "syncthing", "scope": "global"},
+		"unknown manager": {"name": "syncthing", "manager": "openrc"},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, m.ValidateTask(newServiceTask(cfg)), name)
+	}
+}
+
+func TestParseServiceDefaults(t *testing.T) {
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{"unit": "syncthing"}}
+
+	svc, err := newTestModule("linux", &fakeCommands{}).parseService(newServiceTask(map[string]interface{}{"name": "{{ unit }}"}), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "syncthing", svc.Name)
+	assert.Equal(t, "started", svc.State)
+	require.NotNil(t, svc.Enabled)
+	assert.True(t, *svc.Enabled)
+	assert.Equal(t, "user", svc.Scope)
+
+	svc, err = newTestModule("linux", &fakeCommands{}).parseService(newServiceTask(map[string]interface{}{"name": "cups", "state": "stopped"}), ctx)
+	require.NoError(t, err)
+	assert.Nil(t, svc.Enabled, "enabled is left alone when only state is set")
+
+	svc, err = newTestModule("windows", &fakeCommands{}).parseService(newServiceTask(map[string]interface{}{"name": "Spooler"}), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "system", svc.Scope, "Windows services belong to the system")
+}
+
+func TestPlanEnsureService(t *testing.T) {
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}, DryRun: true}
+
+	t.Run("Changes", func(t *testing.T) {
+		fake := &fakeCommands{results: map[string]*commandResult{
+			"systemctl --user show syncthing --property=LoadState,ActiveState,UnitFileState": systemdShow("loaded", "inactive", "disabled"),
+		}}
+		plan, err := newTestModule("linux", fake).PlanTask(newServiceTask(map[string]interface{}{"name": "syncthing"}), ctx)
+		require.NoError(t, err)
+		assert.False(t, plan.WillSkip)
+		assert.Equal(t, []string{"Enable syncthing", "Start syncthing"}, plan.Changes)
+		assert.Equal(t, "Ensure user service syncthing is started and enabled", plan.Description)
+		assert.Len(t, fake.calls, 1, "planning only queries the service")
+	})
+
+	t.Run("UpToDate", func(t *testing.T) {
+		fake := &fakeCommands{results: map[string]*commandResult{
+			"systemctl show docker --property=LoadState,ActiveState,UnitFileState": systemdShow("loaded", "active", "enabled"),
+		}}
+		plan, err := newTestModule("linux", fake).PlanTask(newServiceTask(map[string]interface{}{"name": "docker", "scope": "system"}), ctx)
+		require.NoError(t, err)
+		assert.True(t, plan.WillSkip)
+		assert.Empty(t, plan.Changes)
+	})
+
+	t.Run("NotInstalled", func(t *testing.T) {
+		fake := &fakeCommands{results: map[string]*commandResult{
+			"systemctl --user show syncthing --property=LoadState,ActiveState,UnitFileState": systemdShow("not-found", "inactive", ""),
+		}}
+		plan, err := newTestModule("linux", fake).PlanTask(newServiceTask(map[string]interface{}{"name": "syncthing"}), ctx)
+		require.NoError(t, err)
+		require.Len(t, plan.Changes, 3)
+		assert.Contains(t, plan.Changes[0], "not installed yet")
+	})
+
+	t.Run("ManagerMissing", func(t *testing.T) {
+		m := newTestModule("linux", &fakeCommands{})
+		m.lookPath = func(name string) (string, error) { return "", fmt.Errorf("not found") }
+		_, err := m.PlanTask(newServiceTask(map[string]interface{}{"name": "syncthing"}), ctx)
+		assert.ErrorContains(t, err, "systemctl is not available")
+	})
+}
+
+func TestExecuteEnsureService(t *testing.T) {
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	t.Run("SystemUsesSudo", func(t *testing.T) {
+		fake := &fakeCommands{results: map[string]*commandResult{
+			"systemctl show docker --property=LoadState,ActiveState,UnitFileState": systemdShow("loaded", "active", "disabled"),
+		}}
+		err := newTestModule("linux", fake).ExecuteTask(newServiceTask(map[string]interface{}{"name": "docker", "scope": "system", "state": "stopped", "enabled": true}), ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"systemctl show docker --property=LoadState,ActiveState,UnitFileState",
+			"sudo systemctl enable docker",
+			"sudo systemctl stop docker",
+		}, fake.calls)
+	})
+
+	t.Run("ReloadsMissingUnit", func(t *testing.T) {
+		fake := &fakeCommands{results: map[string]*commandResult{
+			"systemctl --user show syncthing --property=LoadState,ActiveState,UnitFileState": systemdShow("not-found", "inactive", ""),
+		}}
+		err := newTestModule("linux", fake).ExecuteTask(newServiceTask(map[string]interface{}{"name": "syncthing"}), ctx)
+		assert.ErrorContains(t, err, "user service syncthing does not exist")
+		assert.Contains(t, fake.calls, "systemctl --user daemon-reload")
+	})
+
+	t.Run("FailureIncludesOutput", func(t *testing.T) {
+		fake := &fakeCommands{results: map[string]*commandResult{
+			"sc query Spooler": {Stdout: "SERVICE_NAME: Spooler\n        STATE              : 1  STOPPED\n"},
+			"sc qc Spooler":    {Stdout: "SERVICE_NAME: Spooler\n        START_TYPE         : 2   AUTO_START\n"},
+			"sc start Spooler": {Stdout: "[SC] StartService FAILED 5:\n\nAccess is denied.\n", ExitCode: 5},
+		}}
+		err := newTestModule("windows", fake).ExecuteTask(newServiceTask(map[string]interface{}{"name": "Spooler"}), ctx)
+		assert.ErrorContains(t, err, "failed to start Spooler")
+		assert.ErrorContains(t, err, "Access is denied.")
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		fake := &fakeCommands{}
+		err := newTestModule("linux", fake).ExecuteTask(newServiceTask(map[string]interface{}{"name": "syncthing"}), &modules.ExecutionContext{DryRun: true})
+		require.NoError(t, err)
+		assert.Empty(t, fake.calls)
+	})
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// systemdManager manages systemd units with systemctl. User units are managed
+// with --user, system units as root.
+type systemdManager struct {
+	runner *runner
+}
+
+// Name returns the name of the manager
+func (m *systemdManager) Name() string {
+	return "systemd"
+}
+
+// systemctl returns the arguments of a systemctl command for the scope of svc
+func (m *systemdManager) systemctl(svc *service, args ...string) []string {
+	if svc.Scope == "user" {
+		return append([]string{"--user"}, args...)
+	}
+	return args
+}
+
+// Status returns the state of a unit as reported by systemctl show
+func (m *systemdManager) Status(svc *service) (*serviceStatus, error) {
+	args := m.systemctl(svc, "show", svc.Name, "--property=LoadState,ActiveState,UnitFileState")
+	result, err := m.runner.output("systemctl", args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := result.check("systemctl", args); err != nil {
+		return nil, err
+	}
+	return parseSystemdShow(result.Stdout)
+}
+
+// parseSystemdShow parses the properties printed by systemctl show
+func parseSystemdShow(output string) (*serviceStatus, error) {
+	properties := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, found := strings.Cut(strings.TrimSpace(line), "="); found {
+			properties[key] = value
+		}
+	}
+
+	loadState, ok := properties["LoadState"]
+	if !ok {
+		return nil, fmt.Errorf("unexpected output of systemctl show: %q", strings.TrimSpace(output))
+	}
+	if loadState == "masked" {
+		return nil, fmt.Errorf("unit is masked, unmask it with systemctl unmask first")
+	}
+
+	status := &serviceStatus{
+		Exists: loadState != "not-found",
+		State:  properties["ActiveState"],
+	}
+	switch properties["ActiveState"] {
+	case "active", "activating", "reloading":
+		status.Active = true
+	}
+	switch properties["UnitFileState"] {
+	case "enabled", "enabled-runtime", "alias":
+		status.Enabled = true
+	}
+	return status, nil
+}
+
+// Reload makes systemd load unit files written since it last looked
+func (m *systemdManager) Reload(svc *service) error {
+	return m.runner.exec(svc.Scope == "system", "systemctl", m.systemctl(svc, "daemon-reload")...)
+}
+
+// Start starts a unit
+func (m *systemdManager) Start(svc *service) error {
+	return m.runner.exec(svc.Scope == "system", "systemctl", m.systemctl(svc, "start", svc.Name)...)
+}
+
+// Stop stops a unit
+func (m *systemdManager) Stop(svc *service) error {
+	return m.runner.exec(svc.Scope == "system", "systemctl", m.systemctl(svc, "stop", svc.Name)...)
+}
+
+// Enable makes a unit start at boot, or at login for user units
+func (m *systemdManager) Enable(svc *service) error {
+	return m.runner.exec(svc.Scope == "system", "systemctl", m.systemctl(svc, "enable", svc.Name)...)
+}
+
+// Disable stops a unit from starting at boot or login
+func (m *systemdManager) Disable(svc *service) error {
+	return m.runner.exec(svc.Scope == "system", "systemctl", m.systemctl(svc, "disable", svc.Name)...)
+}
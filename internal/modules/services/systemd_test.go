@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSystemdShow(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    serviceStatus
+		wantErr string
+	}{
+		{
+			name:   "running and enabled",
+			output: "LoadState=loaded\nActiveState=active\nUnitFileState=enabled\n",
+			want:   serviceStatus{Exists: true, Active: true, Enabled: true, State: "active"},
+		},
+		{
+			name:   "stopped and disabled",
+			output: "LoadState=loaded\nActiveState=inactive\nUnitFileState=disabled\n",
+			want:   serviceStatus{Exists: true, State: "inactive"},
+		},
+		{
+			name:   "failed static unit",
+			output: "LoadState=loaded\nActiveState=failed\nUnitFileState=static\n",
+			want:   serviceStatus{Exists: true, State: "failed"},
+		},
+		{
+			name:   "starting",
+			output: "LoadState=loaded\nActiveState=activating\nUnitFileState=enabled-runtime\n",
+			want:   serviceStatus{Exists: true, Active: true, Enabled: true, State: "activating"},
+		},
+		{
+			name:   "not installed",
+			output: "LoadState=not-found\nActiveState=inactive\nUnitFileState=\n",
+			want:   serviceStatus{State: "inactive"},
+		},
+		{
+			name:    "masked",
+			output:  "LoadState=masked\nActiveState=inactive\nUnitFileState=masked\n",
+			wantErr: "masked",
+		},
+		{
+			name:    "no properties",
+			output:  "Failed to connect to bus: No medium found\n",
+			wantErr: "unexpected output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := parseSystemdShow(tt.output)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *status)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ShellArgs returns the shell and its flag to run a command line with, e.g.
+// {"bash", "-c"}. An empty or unknown preferred shell is auto-detected: PowerShell
+// or cmd on Windows, bash, zsh or sh elsewhere.
+func ShellArgs(preferredShell string) []string {
+	if preferredShell != "" {
+		// Use specified shell
+		switch preferredShell {
+		case "bash":
+			return []string{"bash", "-c"}
+		case "zsh":
+			return []string{"zsh", "-c"}
+		case "sh":
+			return []string{"sh", "-c"}
+		case "powershell":
+			return []string{"powershell", "-Command"}
+		case "cmd":
+			return []string{"cmd", "/c"}
+		default:
+			// Fallback to auto-detection if unknown shell specified
+		}
+	}
+
+	// Auto-detect based on platform
+	switch runtime.GOOS {
+	case "windows":
+		// Check if PowerShell is available, fallback to cmd
+		if _, err := exec.LookPath("powershell"); err == nil {
+			return []string{"powershell", "-Command"}
+		}
+		return []string{"cmd", "/c"}
+	case "darwin", "linux":
+		// Check for preferred shells in order: bash, zsh, sh
+		shells := []string{"bash", "zsh", "sh"}
+		for _, shell := range shells {
+			if _, err := exec.LookPath(shell); err == nil {
+				return []string{shell, "-c"}
+			}
+		}
+		// Fallback to sh (should always exist on Unix systems)
+		return []string{"sh", "-c"}
+	default:
+		// Unknown platform, try bash
+		return []string{"bash", "-c"}
+	}
+}
+
+// ShellCommand creates a command that runs a command line with a shell returned by
+// ShellArgs. It is killed when ctx is done.
+func ShellCommand(ctx context.Context, shell []string, command, workDir string, env map[string]string) *exec.Cmd {
+	args := append(append([]string{}, shell...), command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+
+	// Set working directory if specified
+	if workDir != "" {
+		// Handle tilde expansion
+		if strings.HasPrefix(workDir, "~") {
+			homeDir, err := os.UserHomeDir()
+			if err == nil {
+				workDir = filepath.Join(homeDir, workDir[1:])
+			}
+		}
+		cmd.Dir = workDir
+	}
+
+	// Set environment variables
+	if len(env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range env {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+
+	return cmd
+}
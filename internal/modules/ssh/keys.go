@@ -0,0 +1,428 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+const (
+	defaultRSABits = 4096
+	minRSABits     = 2048
+)
+
+// keyTypes maps the key types ensure_ssh_key accepts to the SSH names of their
+// public keys
+var keyTypes = map[string]string{
+	"ed25519": gossh.KeyAlgoED25519,
+	"rsa":     gossh.KeyAlgoRSA,
+}
+
+// keyVariableName replaces the characters of key file names that cannot be used in
+// variable names
+var keyVariableName = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// sshKey is the configuration of an ensure_ssh_key task
+type sshKey struct {
+	Path       string
+	Type       string
+	Bits       int
+	Comment    string
+	Passphrase string
+	Register   string
+}
+
+// existingKey describes a key that was already generated
+type existingKey struct {
+	Type      string // ed25519 or rsa, or the SSH name of other key types
+	Bits      int    // Size of rsa keys
+	PublicKey string // Public key in authorized_keys format, with the comment
+	Created   string // When the private key was written
+	Mode      os.FileMode
+	HasPublic bool // Whether the .pub file exists
+}
+
+// describe returns e.g. "ed25519, created 2023-04-01"
+func (k *existingKey) describe() string {
+	keyType := k.Type
+	if k.Bits > 0 {
+		keyType = fmt.Sprintf("%s %d", k.Type, k.Bits)
+	}
+	return fmt.Sprintf("%s, created %s", keyType, k.Created)
+}
+
+// validateKeyTask validates an ensure_ssh_key task
+func validateKeyTask(task *config.Task) error {
+	if err := validateStrings(task, "path", "type", "comment", "passphrase", "register"); err != nil {
+		return err
+	}
+
+	keyType, _ := task.Config["type"].(string)
+	if _, ok := keyTypes[keyType]; keyType != "" && !ok {
+		return fmt.Errorf("ensure_ssh_key 'type' must be 'ed25519' or 'rsa', got '%s'", keyType)
+	}
+
+	if value, exists := task.Config["bits"]; exists {
+		bits, ok := intValue(value)
+		if !ok {
+			return fmt.Errorf("ensure_ssh_key 'bits' must be an integer")
+		}
+		if keyType != "rsa" {
+			return fmt.Errorf("ensure_ssh_key 'bits' can only be set for rsa keys")
+		}
+		if bits < minRSABits {
+			return fmt.Errorf("ensure_ssh_key 'bits' must be at least %d", minRSABits)
+		}
+	}
+
+	if register, ok := task.Config["register"].(string); ok && !registerPattern.MatchString(register) {
+		return fmt.Errorf("ensure_ssh_key 'register' must be a variable name, got '%s'", register)
+	}
+	return nil
+}
+
+// intValue converts the numbers YAML and JSON decode to an int
+func intValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), v == float64(int(v))
+	default:
+		return 0, false
+	}
+}
+
+// parseKey reads the configuration of an ensure_ssh_key task, processing its
+// templates and filling in the defaults
+func (m *SSHModule) parseKey(task *config.Task, ctx *modules.ExecutionContext) (*sshKey, error) {
+	key := &sshKey{Type: "ed25519", Bits: defaultRSABits}
+	if keyType, ok := modules.StringValue(task.Config["type"]); ok && keyType != "" {
+		key.Type = keyType
+	}
+	if bits, ok := intValue(task.Config["bits"]); ok {
+		key.Bits = bits
+	}
+	key.Register, _ = modules.StringValue(task.Config["register"])
+
+	path, err := m.render(task, ctx, "path", "~/.ssh/id_"+key.Type)
+	if err != nil {
+		return nil, err
+	}
+	if key.Path, err = utils.ExpandPath(path); err != nil {
+		return nil, fmt.Errorf("failed to expand key path: %w", err)
+	}
+
+	if key.Comment, err = m.render(task, ctx, "comment", defaultComment()); err != nil {
+		return nil, err
+	}
+	if key.Passphrase, err = m.render(task, ctx, "passphrase", ""); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// defaultComment returns user@hostname, like ssh-keygen
+func defaultComment() string {
+	name := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+		if i := strings.LastIndex(name, `\`); i >= 0 {
+			name = name[i+1:] // Windows user names include the domain
+		}
+	}
+	hostname, _ := os.Hostname()
+	return name + "@" + hostname
+}
+
+// planKey returns whether an ensure_ssh_key task would generate a key
+func (m *SSHModule) planKey(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	key, err := m.parseKey(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID: task.ID,
+		Action: "ensure_ssh_key",
+	}
+
+	existing, err := m.inspectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		plan.Description = fmt.Sprintf("would generate %s key %s", key.describe(), key.Path)
+		plan.Changes = []string{
+			fmt.Sprintf("Generate %s key %s", key.describe(), key.Path),
+			fmt.Sprintf("Write public key %s.pub", key.Path),
+		}
+		return plan, nil
+	}
+
+	// Later tasks can use the key in their plans too
+	m.exposeKey(ctx, key, existing.PublicKey)
+
+	plan.Description = fmt.Sprintf("key exists (%s): %s", existing.describe(), key.Path)
+	plan.Changes = m.keyRepairs(key, existing)
+	if existing.Type != key.Type {
+		plan.Changes = nil
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("%s is a %s key, not %s, and is not replaced", key.Path, existing.Type, key.Type)
+	} else if len(plan.Changes) == 0 {
+		plan.WillSkip = true
+		plan.SkipReason = fmt.Sprintf("key exists (%s)", existing.describe())
+	}
+	return plan, nil
+}
+
+// describe returns e.g. "ed25519" or "rsa 4096"
+func (k *sshKey) describe() string {
+	if k.Type == "rsa" {
+		return fmt.Sprintf("rsa %d", k.Bits)
+	}
+	return k.Type
+}
+
+// keyRepairs lists what has to change about a key that exists
+func (m *SSHModule) keyRepairs(key *sshKey, existing *existingKey) []string {
+	var changes []string
+	if m.goos != "windows" && existing.Mode.Perm() != 0600 {
+		changes = append(changes, fmt.Sprintf("Change permissions of %s from %04o to 0600", key.Path, existing.Mode.Perm()))
+	}
+	if !existing.HasPublic {
+		changes = append(changes, fmt.Sprintf("Write public key %s.pub", key.Path))
+	}
+	return changes
+}
+
+// executeKey generates the key of an ensure_ssh_key task when it does not exist, or
+// repairs the permissions of the key that does
+func (m *SSHModule) executeKey(task *config.Task, ctx *modules.ExecutionContext) error {
+	key, err := m.parseKey(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	existing, err := m.inspectKey(key)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		publicKey, err := m.generateKey(key)
+		if err != nil {
+			return err
+		}
+		m.exposeKey(ctx, key, publicKey)
+		return nil
+	}
+
+	m.exposeKey(ctx, key, existing.PublicKey)
+	if existing.Type != key.Type {
+		return &modules.TaskOutcome{
+			NeedsAttention: true,
+			Message:        fmt.Sprintf("%s is a %s key, not %s, and was not replaced", key.Path, existing.Type, key.Type),
+		}
+	}
+
+	changes := m.keyRepairs(key, existing)
+	if len(changes) == 0 {
+		return &modules.TaskOutcome{Skipped: true, Message: fmt.Sprintf("key exists (%s)", existing.describe())}
+	}
+	if m.goos != "windows" && existing.Mode.Perm() != 0600 {
+		if err := os.Chmod(key.Path, 0600); err != nil {
+			return fmt.Errorf("failed to change permissions of %s: %w", key.Path, err)
+		}
+	}
+	if !existing.HasPublic {
+		if err := os.WriteFile(key.Path+".pub", []byte(existing.PublicKey+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write public key: %w", err)
+		}
+	}
+	return nil
+}
+
+// inspectKey reads the key of an ensure_ssh_key task, returning nil when it does not
+// exist
+func (m *SSHModule) inspectKey(key *sshKey) (*existingKey, error) {
+	stat, err := os.Stat(key.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", key.Path, err)
+	}
+	if stat.IsDir() {
+		return nil, fmt.Errorf("key path %s is a directory", key.Path)
+	}
+
+	existing := &existingKey{
+		Created: stat.ModTime().Format("2006-01-02"),
+		Mode:    stat.Mode(),
+	}
+
+	publicKey, comment, err := readPublicKey(key.Path + ".pub")
+	if err == nil {
+		existing.HasPublic = true
+	} else if os.IsNotExist(err) {
+		// Derive the public key from the private key
+		if publicKey, err = derivePublicKey(key.Path); err != nil {
+			return nil, err
+		}
+		comment = key.Comment
+	} else {
+		return nil, fmt.Errorf("failed to read public key %s.pub: %w", key.Path, err)
+	}
+
+	existing.Type = publicKey.Type()
+	for name, algorithm := range keyTypes {
+		if algorithm == existing.Type {
+			existing.Type = name
+		}
+	}
+	if cryptoKey, ok := publicKey.(gossh.CryptoPublicKey); ok {
+		if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok {
+			existing.Bits = rsaKey.N.BitLen()
+		}
+	}
+	existing.PublicKey = authorizedKey(publicKey, comment)
+	return existing, nil
+}
+
+// readPublicKey reads a public key file in authorized_keys format
+func readPublicKey(path string) (gossh.PublicKey, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	publicKey, comment, _, _, err := gossh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return publicKey, comment, nil
+}
+
+// derivePublicKey returns the public key of a private key file. The public key of an
+// encrypted key can be read without its passphrase.
+func derivePublicKey(path string) (gossh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+
+	signer, err := gossh.ParsePrivateKey(data)
+	var missing *gossh.PassphraseMissingError
+	switch {
+	case err == nil:
+		return signer.PublicKey(), nil
+	case errors.As(err, &missing) && missing.PublicKey != nil:
+		return missing.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("%s is not a private key ssh can read: %w", path, err)
+	}
+}
+
+// authorizedKey formats a public key as a line of authorized_keys
+func authorizedKey(publicKey gossh.PublicKey, comment string) string {
+	line := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(publicKey)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+// generateKey writes a new key pair, returning its public key
+func (m *SSHModule) generateKey(key *sshKey) (string, error) {
+	var (
+		private crypto.PrivateKey
+		public  crypto.PublicKey
+	)
+	switch key.Type {
+	case "rsa":
+		rsaKey, err := rsa.GenerateKey(rand.Reader, key.Bits)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate key: %w", err)
+		}
+		private, public = rsaKey, &rsaKey.PublicKey
+	default:
+		edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate key: %w", err)
+		}
+		private, public = edPrivate, edPublic
+	}
+
+	var block *pem.Block
+	var err error
+	if key.Passphrase != "" {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(private, key.Comment, []byte(key.Passphrase))
+	} else {
+		block, err = gossh.MarshalPrivateKey(private, key.Comment)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	publicKey, err := gossh.NewPublicKey(public)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	authorized := authorizedKey(publicKey, key.Comment)
+
+	if err := os.MkdirAll(filepath.Dir(key.Path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create directory for key: %w", err)
+	}
+
+	// Never overwrite a key that was written since it was inspected
+	file, err := os.OpenFile(key.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create key %s: %w", key.Path, err)
+	}
+	_, err = file.Write(pem.EncodeToMemory(block))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(key.Path)
+		return "", fmt.Errorf("failed to write key %s: %w", key.Path, err)
+	}
+
+	if err := os.WriteFile(key.Path+".pub", []byte(authorized+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to write public key: %w", err)
+	}
+	return authorized, nil
+}
+
+// exposeKey makes the public key available to the templates of later tasks as
+// ssh_keys.<file name>, and as the register variable when the task sets one
+func (m *SSHModule) exposeKey(ctx *modules.ExecutionContext, key *sshKey, publicKey string) {
+	keys := map[string]interface{}{}
+	if existing, ok := ctx.Variables["ssh_keys"].(map[string]interface{}); ok {
+		for name, value := range existing {
+			keys[name] = value
+		}
+	}
+	keys[keyVariableName.ReplaceAllString(filepath.Base(key.Path), "_")] = publicKey
+	ctx.SetVariable("ssh_keys", keys)
+
+	if key.Register != "" {
+		ctx.SetVariable(key.Register, publicKey)
+	}
+}
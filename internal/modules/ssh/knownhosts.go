@@ -0,0 +1,295 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// knownHost is the configuration of an ensure_known_host task
+type knownHost struct {
+	Host        string
+	Port        string
+	Key         string
+	Fingerprint string
+	Path        string
+}
+
+// address returns the host as known_hosts and ssh-keyscan name it
+func (h *knownHost) address() string {
+	if h.Port == "22" {
+		return h.Host
+	}
+	return "[" + h.Host + "]:" + h.Port
+}
+
+// hostKeyStatus is whether a key of a host is in known_hosts
+type hostKeyStatus int
+
+const (
+	hostKeyMissing hostKeyStatus = iota
+	hostKeyKnown
+	hostKeyConflict // known_hosts has another key of the same type for the host
+)
+
+// validateKnownHostTask validates an ensure_known_host task
+func validateKnownHostTask(task *config.Task) error {
+	if err := validateStrings(task, "host", "key", "fingerprint", "path"); err != nil {
+		return err
+	}
+
+	host, _ := task.Config["host"].(string)
+	if host == "" {
+		return fmt.Errorf("ensure_known_host requires 'host' parameter")
+	}
+
+	if key, ok := task.Config["key"].(string); ok && !strings.Contains(key, "{{") {
+		if _, _, _, _, err := gossh.ParseAuthorizedKey([]byte(key)); err != nil {
+			return fmt.Errorf("ensure_known_host 'key' must be a public key like 'ssh-ed25519 AAAA...': %v", err)
+		}
+	}
+
+	if fingerprint, ok := task.Config["fingerprint"].(string); ok && !strings.HasPrefix(fingerprint, "SHA256:") {
+		return fmt.Errorf("ensure_known_host 'fingerprint' must be a SHA256 fingerprint like 'SHA256:...', as ssh-keygen -lf prints them")
+	}
+	return nil
+}
+
+// parseKnownHost reads the configuration of an ensure_known_host task, processing
+// its templates and filling in the defaults
+func (m *SSHModule) parseKnownHost(task *config.Task, ctx *modules.ExecutionContext) (*knownHost, error) {
+	host := &knownHost{}
+
+	address, err := m.render(task, ctx, "host", "")
+	if err != nil {
+		return nil, err
+	}
+	host.Host, host.Port, err = splitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if host.Key, err = m.render(task, ctx, "key", ""); err != nil {
+		return nil, err
+	}
+	host.Fingerprint, _ = modules.StringValue(task.Config["fingerprint"])
+
+	path, err := m.render(task, ctx, "path", "~/.ssh/known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	if host.Path, err = utils.ExpandPath(path); err != nil {
+		return nil, fmt.Errorf("failed to expand known_hosts path: %w", err)
+	}
+	return host, nil
+}
+
+// splitHostPort splits host:port, defaulting to port 22. IPv6 addresses with a port
+// are written as [address]:port.
+func splitHostPort(address string) (string, string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", "", fmt.Errorf("ensure_known_host 'host' is empty")
+	}
+	if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+		return address, "22", nil // IPv6 address without port
+	}
+	if !strings.Contains(address, ":") {
+		return address, "22", nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid host '%s': %w", address, err)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return "", "", fmt.Errorf("invalid port in host '%s'", address)
+	}
+	return host, port, nil
+}
+
+// hostKeys returns the keys of a host that should be in known_hosts: the configured
+// key, or the scanned keys matching the fingerprint
+func (m *SSHModule) hostKeys(host *knownHost, ctx *modules.ExecutionContext) ([]gossh.PublicKey, error) {
+	var data []byte
+	if host.Key != "" {
+		data = []byte(host.Key)
+	} else {
+		if ctx.Offline {
+			return nil, fmt.Errorf("cannot fetch the keys of %s with ssh-keyscan while offline, set 'key' to add it", host.Host)
+		}
+		output, err := m.keyscan(ctx.RunContext(), host.Host, host.Port)
+		if err != nil {
+			return nil, err
+		}
+		data = output
+	}
+
+	var keys []gossh.PublicKey
+	var scanned []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if host.Key == "" {
+			// ssh-keyscan prints known_hosts lines, starting with the host
+			if _, rest, found := strings.Cut(line, " "); found {
+				line = rest
+			}
+		}
+		key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for %s: %w", host.Host, err)
+		}
+
+		fingerprint := gossh.FingerprintSHA256(key)
+		scanned = append(scanned, fmt.Sprintf("%s %s", key.Type(), fingerprint))
+		if host.Fingerprint == "" || fingerprint == host.Fingerprint {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(scanned) == 0 {
+		return nil, fmt.Errorf("ssh-keyscan found no keys for %s", host.address())
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key of %s has fingerprint %s, it has: %s", host.address(), host.Fingerprint, strings.Join(scanned, ", "))
+	}
+	return keys, nil
+}
+
+// checkHostKey returns whether known_hosts has a key of a host
+func checkHostKey(host *knownHost, key gossh.PublicKey) (hostKeyStatus, error) {
+	if !utils.FileExists(host.Path) {
+		return hostKeyMissing, nil
+	}
+
+	callback, err := knownhosts.New(host.Path)
+	if err != nil {
+		return hostKeyMissing, fmt.Errorf("failed to read %s: %w", host.Path, err)
+	}
+
+	port, _ := strconv.Atoi(host.Port)
+	err = callback(net.JoinHostPort(host.Host, host.Port), &net.TCPAddr{IP: net.IPv4zero, Port: port}, key)
+	if err == nil {
+		return hostKeyKnown, nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return hostKeyMissing, fmt.Errorf("failed to check %s: %w", host.Path, err)
+	}
+	for _, want := range keyErr.Want {
+		if want.Key.Type() == key.Type() {
+			return hostKeyConflict, nil
+		}
+	}
+	return hostKeyMissing, nil
+}
+
+// missingHostKeys returns the keys of a host that are not in known_hosts yet. A key
+// that conflicts with the one known_hosts has is an error, it is never replaced.
+func (m *SSHModule) missingHostKeys(host *knownHost, ctx *modules.ExecutionContext) ([]gossh.PublicKey, error) {
+	keys, err := m.hostKeys(host, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []gossh.PublicKey
+	for _, key := range keys {
+		status, err := checkHostKey(host, key)
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case hostKeyConflict:
+			return nil, fmt.Errorf("%s has another %s key for %s, remove it with 'ssh-keygen -R %s' if the key of the host changed", host.Path, key.Type(), host.address(), host.address())
+		case hostKeyMissing:
+			missing = append(missing, key)
+		}
+	}
+	return missing, nil
+}
+
+// planKnownHost returns whether an ensure_known_host task would add keys
+func (m *SSHModule) planKnownHost(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	host, err := m.parseKnownHost(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &modules.TaskPlan{
+		TaskID: task.ID,
+		Action: "ensure_known_host",
+	}
+
+	missing, err := m.missingHostKeys(host, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		plan.Description = fmt.Sprintf("%s is known in %s", host.address(), host.Path)
+		plan.WillSkip = true
+		plan.SkipReason = "host key already known"
+		return plan, nil
+	}
+
+	plan.Description = fmt.Sprintf("would add %s to %s", host.address(), host.Path)
+	for _, key := range missing {
+		plan.Changes = append(plan.Changes, fmt.Sprintf("Add %s key %s", key.Type(), gossh.FingerprintSHA256(key)))
+	}
+	return plan, nil
+}
+
+// executeKnownHost appends the keys of a host that are missing to known_hosts
+func (m *SSHModule) executeKnownHost(task *config.Task, ctx *modules.ExecutionContext) error {
+	host, err := m.parseKnownHost(task, ctx)
+	if err != nil {
+		return err
+	}
+
+	missing, err := m.missingHostKeys(host, ctx)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return &modules.TaskOutcome{Skipped: true, Message: "host key already known"}
+	}
+
+	var lines bytes.Buffer
+	if data, err := os.ReadFile(host.Path); err == nil && len(data) > 0 && data[len(data)-1] != '\n' {
+		lines.WriteString("\n")
+	}
+	for _, key := range missing {
+		lines.WriteString(knownhosts.Line([]string{host.address()}, key) + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(host.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", host.Path, err)
+	}
+	file, err := os.OpenFile(host.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", host.Path, err)
+	}
+	_, err = file.Write(lines.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", host.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating"
+)
+
+// SSHModule generates SSH keys and adds the keys of hosts to known_hosts
+type SSHModule struct {
+	templateEngine *templating.TemplatingEngine
+	goos           string
+	keyscan        func(ctx context.Context, host, port string) ([]byte, error)
+}
+
+// registerPattern matches the variable names public keys can be registered as
+var registerPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// New creates a new SSH module
+func New() *SSHModule {
+	return &SSHModule{
+		templateEngine: templating.NewTemplatingEngine("."),
+		goos:           runtime.GOOS,
+		keyscan:        runKeyscan,
+	}
+}
+
+// Name returns the module name
+func (m *SSHModule) Name() string {
+	return "ssh"
+}
+
+// ActionKeys returns the action keys this module handles
+func (m *SSHModule) ActionKeys() []string {
+	return []string{"ensure_ssh_key", "ensure_known_host"}
+}
+
+// ValidateTask validates an ensure_ssh_key or ensure_known_host task configuration
+func (m *SSHModule) ValidateTask(task *config.Task) error {
+	switch task.Action {
+	case "ensure_ssh_key":
+		return validateKeyTask(task)
+	case "ensure_known_host":
+		return validateKnownHostTask(task)
+	default:
+		return fmt.Errorf("ssh module does not handle action '%s'", task.Action)
+	}
+}
+
+// validateStrings checks that the fields of a task that are set are strings
+func validateStrings(task *config.Task, fields ...string) error {
+	for _, field := range fields {
+		if value, exists := task.Config[field]; exists {
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("%s '%s' must be a string", task.Action, field)
+			}
+		}
+	}
+	return nil
+}
+
+// ExecuteTask executes a task
+func (m *SSHModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionContext) error {
+	if ctx.DryRun {
+		return nil // Plan already showed what would happen
+	}
+
+	switch task.Action {
+	case "ensure_ssh_key":
+		return m.executeKey(task, ctx)
+	case "ensure_known_host":
+		return m.executeKnownHost(task, ctx)
+	default:
+		return fmt.Errorf("ssh module does not handle action '%s'", task.Action)
+	}
+}
+
+// PlanTask returns what a task would do
+func (m *SSHModule) PlanTask(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	switch task.Action {
+	case "ensure_ssh_key":
+		return m.planKey(task, ctx)
+	case "ensure_known_host":
+		return m.planKnownHost(task, ctx)
+	default:
+		return nil, fmt.Errorf("ssh module does not handle action '%s'", task.Action)
+	}
+}
+
+// TaskTargets returns the files a task writes, so a rollback restores them
+func (m *SSHModule) TaskTargets(task *config.Task, ctx *modules.ExecutionContext) ([]string, error) {
+	switch task.Action {
+	case "ensure_ssh_key":
+		key, err := m.parseKey(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []string{key.Path, key.Path + ".pub"}, nil
+	case "ensure_known_host":
+		host, err := m.parseKnownHost(task, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []string{host.Path}, nil
+	default:
+		return nil, fmt.Errorf("ssh module does not handle action '%s'", task.Action)
+	}
+}
+
+// render processes the templates in a string field of a task, returning def when
+// the field is not set
+func (m *SSHModule) render(task *config.Task, ctx *modules.ExecutionContext, field, def string) (string, error) {
+	value, ok := modules.StringValue(task.Config[field])
+	if !ok || value == "" {
+		return def, nil
+	}
+	result, err := m.templateEngine.ProcessString(value, ctx.Variables)
+	if err != nil {
+		return "", fmt.Errorf("failed to process %s template: %w", field, err)
+	}
+	return result, nil
+}
+
+// runKeyscan fetches the host keys of a host with ssh-keyscan
+func runKeyscan(ctx context.Context, host, port string) ([]byte, error) {
+	if _, err := exec.LookPath("ssh-keyscan"); err != nil {
+		return nil, fmt.Errorf("ssh-keyscan is not available, set 'key' to add the key of %s", host)
+	}
+
+	output, err := exec.CommandContext(ctx, "ssh-keyscan", "-p", port, host).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh-keyscan %s failed: %w", host, err)
+	}
+	return output, nil
+}
+
+// ExplainAction returns documentation for a specific action
+func (m *SSHModule) ExplainAction(action string) (*modules.ActionDocumentation, error) {
+	for _, doc := range m.ListActions() {
+		if doc.Action == action {
+			return doc, nil
+		}
+	}
+	return nil, fmt.Errorf("action '%s' not supported by ssh module", action)
+}
+
+// ListActions returns documentation for all actions supported by this module
+func (m *SSHModule) ListActions() []*modules.ActionDocumentation {
+	return []*modules.ActionDocumentation{
+		{
+			Action:      "ensure_ssh_key",
+			Description: "Generates an SSH key pair when it does not exist yet and makes sure the private key is only readable by you. Existing keys are never replaced. The public key is available to later tasks as ssh_keys.<file name>.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    false,
+					Default:     "~/.ssh/id_<type>",
+					Description: "Path of the private key, the public key is written next to it with .pub appended. Supports template variables.",
+				},
+				{
+					Name:        "type",
+					Type:        "string",
+					Required:    false,
+					Default:     "ed25519",
+					Description: "'ed25519' or 'rsa'",
+				},
+				{
+					Name:        "bits",
+					Type:        "integer",
+					Required:    false,
+					Default:     "4096",
+					Description: "Size of rsa keys, at least 2048",
+				},
+				{
+					Name:        "comment",
+					Type:        "string",
+					Required:    false,
+					Default:     "<user>@<hostname>",
+					Description: "Comment of the key. Supports template variables.",
+				},
+				{
+					Name:        "passphrase",
+					Type:        "string",
+					Required:    false,
+					Description: "Passphrase the private key is encrypted with, e.g. '{{ secret(\"ssh_passphrase\") }}'. Without it the key is not encrypted.",
+				},
+				{
+					Name:        "register",
+					Type:        "string",
+					Required:    false,
+					Description: "Variable the public key is also stored in for the templates of later tasks",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Generate ~/.ssh/id_ed25519 on a new machine",
+					Config:      map[string]interface{}{},
+				},
+				{
+					Description: "Generate an encrypted key for work and use it in a later template",
+					Config: map[string]interface{}{
+						"path":       "~/.ssh/work",
+						"comment":    "{{ user.email }}",
+						"passphrase": "{{ secret(\"ssh_passphrase\") }}",
+						"register":   "work_public_key",
+					},
+				},
+				{
+					Description: "Generate an RSA key for servers that don't accept ed25519",
+					Config: map[string]interface{}{
+						"path": "~/.ssh/legacy_rsa",
+						"type": "rsa",
+						"bits": 4096,
+					},
+				},
+			},
+		},
+		{
+			Action:      "ensure_known_host",
+			Description: "Adds the key of a host to known_hosts unless it is already there, so the first connection does not ask to trust it. Without 'key' the keys are fetched with ssh-keyscan; set 'fingerprint' to only trust the key the host is supposed to have.",
+			Parameters: []modules.ActionParameter{
+				{
+					Name:        "host",
+					Type:        "string",
+					Required:    true,
+					Description: "Host name or address, with ':port' for ports other than 22. Supports template variables.",
+				},
+				{
+					Name:        "key",
+					Type:        "string",
+					Required:    false,
+					Description: "Key of the host in authorized_keys format, e.g. 'ssh-ed25519 AAAA...'. Fetched with ssh-keyscan when not set.",
+				},
+				{
+					Name:        "fingerprint",
+					Type:        "string",
+					Required:    false,
+					Description: "SHA256 fingerprint the key must have, e.g. 'SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU'. Only the scanned key with this fingerprint is added.",
+				},
+				{
+					Name:        "path",
+					Type:        "string",
+					Required:    false,
+					Default:     "~/.ssh/known_hosts",
+					Description: "known_hosts file to add the key to",
+				},
+			},
+			Examples: []modules.ActionExample{
+				{
+					Description: "Trust the ed25519 key GitHub publishes",
+					Config: map[string]interface{}{
+						"host":        "github.com",
+						"fingerprint": "SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU",
+					},
+				},
+				{
+					Description: "Add the key of a server on another port",
+					Config: map[string]interface{}{
+						"host": "git.example.com:2222",
+						"key":  "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl",
+					},
+				},
+			},
+		},
+	}
+}
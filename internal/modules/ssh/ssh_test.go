@@ -0,0 +1,233 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// newTask creates a task of the ssh module
+func newTask(action string, cfg map[string]interface{}) *config.Task {
+	return &config.Task{ID: action + ": test", Action: action, Config: cfg}
+}
+
+// newHostKey returns a new ed25519 host key
+func newHostKey(t *testing.T) gossh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := gossh.NewPublicKey(public)
+	require.NoError(t, err)
+	return key
+}
+
+func TestValidateTask(t *testing.T) {
+	m := New()
+
+	valid := []*config.Task{
+		newTask("ensure_ssh_key", map[string]interface{}{}),
+		newTask("ensure_ssh_key", map[string]interface{}{"path": "~/.ssh/work", "type": "rsa", "bits": 3072, "register": "work_key"}),
+		newTask("ensure_known_host", map[string]interface{}{"host": "github.com", "fingerprint": "SHA256:abc"}),
+		newTask("ensure_known_host", map[string]interface{}{"host": "example.com:2222", "key": "{{ host_key }}"}),
+	}
+	for _, task := range valid {
+		assert.NoError(t, m.ValidateTask(task), "%v", task.Config)
+	}
+
+	invalid := map[string]*config.Task{
+		"unknown type":     newTask("ensure_ssh_key", map[string]interface{}{"type": "dsa"}),
+		"bits for ed25519": newTask("ensure_ssh_key", map[string]interface{}{"bits": 4096}),
+		"too few bits":     newTask("ensure_ssh_key", map[string]interface{}{"type": "rsa", "bits": 1024}),
+		"register name":    newTask("ensure_ssh_key", map[string]interface{}{"register": "my-key"}),
+		"missing host":     newTask("ensure_known_host", map[string]interface{}{}),
+		"invalid key":      newTask("ensure_known_host", map[string]interface{}{"host": "github.com", "key": "not a key"}),
+		"md5 fingerprint":  newTask("ensure_known_host", map[string]interface{}{"host": "github.com", "fingerprint": "MD5:16:27"}),
+		"passphrase type":  newTask("ensure_ssh_key", map[string]interface{}{"passphrase": 1234}),
+		"host type":        newTask("ensure_known_host", map[string]interface{}{"host": 22}),
+	}
+	for name, task := range invalid {
+		assert.Error(t, m.ValidateTask(task), name)
+	}
+}
+
+func TestEnsureSSHKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".ssh", "id_ed25519")
+	m := New()
+	task := newTask("ensure_ssh_key", map[string]interface{}{"path": path, "comment": "{{ user }}@laptop", "register": "public_key"})
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{"user": "menno"}}
+
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.False(t, plan.WillSkip)
+	assert.Equal(t, "would generate ed25519 key "+path, plan.Description)
+
+	require.NoError(t, m.ExecuteTask(task, ctx))
+
+	public, err := os.ReadFile(path + ".pub")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(public), "ssh-ed25519 "))
+	assert.True(t, strings.HasSuffix(string(public), " menno@laptop\n"))
+	assert.Equal(t, strings.TrimSpace(string(public)), ctx.Variables["public_key"])
+	assert.Equal(t, map[string]interface{}{"id_ed25519": strings.TrimSpace(string(public))}, ctx.Variables["ssh_keys"])
+
+	private, err := os.ReadFile(path)
+	require.NoError(t, err)
+	_, err = gossh.ParsePrivateKey(private)
+	require.NoError(t, err)
+	if m.goos != "windows" {
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	}
+
+	plan, err = m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+	assert.Contains(t, plan.Description, "key exists (ed25519, created ")
+
+	var outcome *modules.TaskOutcome
+	require.ErrorAs(t, m.ExecuteTask(task, ctx), &outcome)
+	assert.True(t, outcome.Skipped)
+
+	unchanged, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, private, unchanged)
+}
+
+func TestEnsureSSHKeyPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	m := New()
+	task := newTask("ensure_ssh_key", map[string]interface{}{"path": path, "passphrase": "{{ passphrase }}"})
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{"passphrase": "correct horse"}}
+	require.NoError(t, m.ExecuteTask(task, ctx))
+
+	private, err := os.ReadFile(path)
+	require.NoError(t, err)
+	_, err = gossh.ParsePrivateKey(private)
+	var missing *gossh.PassphraseMissingError
+	require.ErrorAs(t, err, &missing)
+	_, err = gossh.ParsePrivateKeyWithPassphrase(private, []byte("correct horse"))
+	require.NoError(t, err)
+
+	// The public key of an encrypted key is read without its passphrase
+	require.NoError(t, os.Remove(path+".pub"))
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Write public key " + path + ".pub"}, plan.Changes)
+}
+
+func TestEnsureSSHKeyRepairs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_rsa")
+	m := New()
+	m.goos = "linux"
+	task := newTask("ensure_ssh_key", map[string]interface{}{"path": path, "type": "rsa", "bits": 2048})
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+	require.NoError(t, m.ExecuteTask(task, ctx))
+
+	require.NoError(t, os.Chmod(path, 0644))
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.Contains(t, plan.Description, "key exists (rsa 2048, created ")
+	assert.Equal(t, []string{"Change permissions of " + path + " from 0644 to 0600"}, plan.Changes)
+
+	require.NoError(t, m.ExecuteTask(task, ctx))
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	// A key of another type is never replaced
+	other := newTask("ensure_ssh_key", map[string]interface{}{"path": path})
+	plan, err = m.PlanTask(other, ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.WillSkip)
+	assert.Contains(t, plan.SkipReason, "is a rsa key, not ed25519")
+
+	var outcome *modules.TaskOutcome
+	require.ErrorAs(t, m.ExecuteTask(other, ctx), &outcome)
+	assert.True(t, outcome.NeedsAttention)
+}
+
+func TestEnsureKnownHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	key := newHostKey(t)
+	other := newHostKey(t)
+	scans := 0
+
+	m := New()
+	m.keyscan = func(ctx context.Context, host, port string) ([]byte, error) {
+		scans++
+		assert.Equal(t, "git.example.com", host)
+		assert.Equal(t, "2222", port)
+		return []byte("# git.example.com:2222 SSH-2.0-OpenSSH\n" +
+			"[git.example.com]:2222 " + string(gossh.MarshalAuthorizedKey(key)) +
+			"[git.example.com]:2222 " + string(gossh.MarshalAuthorizedKey(other))), nil
+	}
+	task := newTask("ensure_known_host", map[string]interface{}{
+		"host":        "git.example.com:2222",
+		"fingerprint": gossh.FingerprintSHA256(key),
+		"path":        path,
+	})
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}}
+
+	plan, err := m.PlanTask(task, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Add ssh-ed25519 key " + gossh.FingerprintSHA256(key)}, plan.Changes)
+
+	require.NoError(t, m.ExecuteTask(task, ctx))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "[git.example.com]:2222 "+string(gossh.MarshalAuthorizedKey(key)), string(content))
+
+	var outcome *modules.TaskOutcome
+	require.ErrorAs(t, m.ExecuteTask(task, ctx), &outcome)
+	assert.True(t, outcome.Skipped)
+	assert.Equal(t, 3, scans)
+
+	// The pinned fingerprint matches none of the keys
+	task.Config["fingerprint"] = "SHA256:nope"
+	_, err = m.PlanTask(task, ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no key of [git.example.com]:2222 has fingerprint SHA256:nope")
+
+	// Another key of the same type for the host is not added next to the known one
+	conflict := newTask("ensure_known_host", map[string]interface{}{
+		"host": "git.example.com:2222",
+		"key":  string(gossh.MarshalAuthorizedKey(other)),
+		"path": path,
+	})
+	_, err = m.PlanTask(conflict, ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ssh-keygen -R [git.example.com]:2222")
+}
+
+func TestEnsureKnownHostOffline(t *testing.T) {
+	m := New()
+	m.keyscan = func(ctx context.Context, host, port string) ([]byte, error) {
+		t.Fatal("ssh-keyscan ran while offline")
+		return nil, nil
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	ctx := &modules.ExecutionContext{Variables: map[string]interface{}{}, Offline: true}
+
+	_, err := m.PlanTask(newTask("ensure_known_host", map[string]interface{}{"host": "github.com", "path": path}), ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "offline")
+
+	key := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(newHostKey(t))))
+	require.NoError(t, m.ExecuteTask(newTask("ensure_known_host", map[string]interface{}{"host": "github.com", "key": key, "path": path}), ctx))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "github.com "+key+"\n", string(content))
+}
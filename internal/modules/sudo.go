@@ -0,0 +1,170 @@
+package modules
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// Privileges is what tasks need to know about the process to hand the paths they
+// write back to the user that ran dotfiles with sudo. Tests replace it with
+// SetPrivileges, so no test has to run as root.
+type Privileges interface {
+	Geteuid() int
+	Getenv(key string) string
+	HomeDir(uid string) (string, error)
+	Lchown(path string, uid, gid int) error
+}
+
+// osPrivileges is the Privileges of the running process
+type osPrivileges struct{}
+
+func (osPrivileges) Geteuid() int                           { return os.Geteuid() }
+func (osPrivileges) Getenv(key string) string               { return os.Getenv(key) }
+func (osPrivileges) Lchown(path string, uid, gid int) error { return os.Lchown(path, uid, gid) }
+
+func (osPrivileges) HomeDir(uid string) (string, error) {
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return "", err
+	}
+	return u.HomeDir, nil
+}
+
+var privileges = struct {
+	sync.Mutex
+	current Privileges
+}{current: osPrivileges{}}
+
+// SetPrivileges replaces the Privileges of the process and returns a function that
+// restores the previous ones
+func SetPrivileges(p Privileges) (restore func()) {
+	privileges.Lock()
+	defer privileges.Unlock()
+	previous := privileges.current
+	privileges.current = p
+	return func() {
+		privileges.Lock()
+		defer privileges.Unlock()
+		privileges.current = previous
+	}
+}
+
+func currentPrivileges() Privileges {
+	privileges.Lock()
+	defer privileges.Unlock()
+	return privileges.current
+}
+
+// SudoUser is the user that ran dotfiles with sudo
+type SudoUser struct {
+	UID  int
+	GID  int
+	Name string
+	Home string
+}
+
+// String returns the user as name (uid:gid)
+func (u *SudoUser) String() string {
+	name := u.Name
+	if name == "" {
+		name = strconv.Itoa(u.UID)
+	}
+	return fmt.Sprintf("%s (%d:%d)", name, u.UID, u.GID)
+}
+
+// owns reports whether path is in the home directory of the user
+func (u *SudoUser) owns(path string) bool {
+	if u.Home == "" {
+		return false
+	}
+	rel, err := filepath.Rel(u.Home, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// InvokingUser returns the user that ran dotfiles with sudo, or nil when dotfiles
+// does not run as root or was not started through sudo by another user
+func InvokingUser() *SudoUser {
+	p := currentPrivileges()
+	if p.Geteuid() != 0 {
+		return nil
+	}
+	uid, err := strconv.Atoi(p.Getenv("SUDO_UID"))
+	if err != nil || uid == 0 {
+		return nil
+	}
+	gid, err := strconv.Atoi(p.Getenv("SUDO_GID"))
+	if err != nil {
+		return nil
+	}
+	home, err := p.HomeDir(strconv.Itoa(uid))
+	if err != nil {
+		return nil
+	}
+	return &SudoUser{UID: uid, GID: gid, Name: p.Getenv("SUDO_USER"), Home: home}
+}
+
+// ValidateAsRoot validates the as_root option of an action
+func ValidateAsRoot(action string, config map[string]interface{}) error {
+	if value, exists := config["as_root"]; exists {
+		if _, ok := BoolValue(value); !ok {
+			return fmt.Errorf("%s 'as_root' must be a boolean", action)
+		}
+	}
+	return nil
+}
+
+// MissingDirs returns the directories of dir that do not exist yet, outermost
+// first, so the directories a task is about to create can be handed back as well
+func MissingDirs(dir string) []string {
+	var missing []string
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return missing
+}
+
+// HandBackToInvokingUser gives the paths a task created or updated to the user
+// that ran dotfiles with sudo, so files in their home directory are not left owned
+// by root. Paths outside that home directory keep their owner, and so do the
+// paths of tasks with as_root: true.
+func HandBackToInvokingUser(task *config.Task, ctx *ExecutionContext, paths ...string) error {
+	if asRoot, _ := BoolValue(task.Config["as_root"]); asRoot {
+		return nil
+	}
+	invoking := InvokingUser()
+	if invoking == nil {
+		return nil
+	}
+
+	p := currentPrivileges()
+	for _, path := range paths {
+		if !invoking.owns(path) {
+			if ctx.Verbose {
+				fmt.Printf("Keeping root ownership of %s, it is outside the home directory of %s\n", path, invoking)
+			}
+			continue
+		}
+		if ctx.Verbose {
+			fmt.Printf("Handing ownership back: %s (%s)\n", path, invoking)
+		}
+		if err := p.Lchown(path, invoking.UID, invoking.GID); err != nil {
+			return fmt.Errorf("failed to hand %s back to %s: %w", path, invoking, err)
+		}
+	}
+	return nil
+}
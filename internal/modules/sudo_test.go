@@ -0,0 +1,116 @@
+package modules
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// fakePrivileges is a process that may run as root through sudo
+type fakePrivileges struct {
+	euid    int
+	env     map[string]string
+	home    string
+	chowned []string
+}
+
+func (p *fakePrivileges) Geteuid() int             { return p.euid }
+func (p *fakePrivileges) Getenv(key string) string { return p.env[key] }
+
+func (p *fakePrivileges) HomeDir(uid string) (string, error) {
+	if p.home == "" {
+		return "", errors.New("unknown user")
+	}
+	return p.home, nil
+}
+
+func (p *fakePrivileges) Lchown(path string, uid, gid int) error {
+	p.chowned = append(p.chowned, path)
+	return nil
+}
+
+func sudoEnv() map[string]string {
+	return map[string]string{"SUDO_UID": "1000", "SUDO_GID": "1000", "SUDO_USER": "menno"}
+}
+
+func TestInvokingUser(t *testing.T) {
+	home := filepath.FromSlash("/home/menno")
+	tests := []struct {
+		name string
+		p    *fakePrivileges
+		want *SudoUser
+	}{
+		{name: "sudo", p: &fakePrivileges{euid: 0, env: sudoEnv(), home: home}, want: &SudoUser{UID: 1000, GID: 1000, Name: "menno", Home: home}},
+		{name: "not root", p: &fakePrivileges{euid: 1000, env: sudoEnv(), home: home}},
+		{name: "root without sudo", p: &fakePrivileges{euid: 0, env: map[string]string{}, home: home}},
+		{name: "sudo by root", p: &fakePrivileges{euid: 0, env: map[string]string{"SUDO_UID": "0", "SUDO_GID": "0"}, home: home}},
+		{name: "unknown user", p: &fakePrivileges{euid: 0, env: sudoEnv()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer SetPrivileges(tt.p)()
+			if got := InvokingUser(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InvokingUser() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandBackToInvokingUser(t *testing.T) {
+	home := filepath.FromSlash("/home/menno")
+	inHome := filepath.Join(home, ".config", "app.conf")
+	outside := filepath.FromSlash("/etc/app.conf")
+	sibling := filepath.FromSlash("/home/menno2/app.conf")
+
+	tests := []struct {
+		name   string
+		euid   int
+		config map[string]interface{}
+		want   []string
+	}{
+		{name: "paths in home", config: map[string]interface{}{}, want: []string{inHome}},
+		{name: "as_root", config: map[string]interface{}{"as_root": true}},
+		{name: "not under sudo", euid: 1000, config: map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakePrivileges{euid: tt.euid, env: sudoEnv(), home: home}
+			defer SetPrivileges(p)()
+
+			task := &config.Task{ID: "app", Action: "ensure_file", Config: tt.config}
+			if err := HandBackToInvokingUser(task, &ExecutionContext{}, inHome, outside, sibling); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.chowned, tt.want) {
+				t.Errorf("chowned %v, want %v", p.chowned, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingDirs(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "a", "b", "c")
+
+	want := []string{filepath.Join(base, "a"), filepath.Join(base, "a", "b"), dir}
+	if got := MissingDirs(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingDirs() = %v, want %v", got, want)
+	}
+	if got := MissingDirs(base); len(got) != 0 {
+		t.Errorf("MissingDirs() of an existing directory = %v, want none", got)
+	}
+}
+
+func TestValidateAsRoot(t *testing.T) {
+	if err := ValidateAsRoot("ensure_file", map[string]interface{}{"as_root": true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateAsRoot("ensure_file", map[string]interface{}{"as_root": "yes"}); err == nil {
+		t.Error("expected an error for a non-boolean as_root")
+	}
+}
@@ -43,19 +43,15 @@ func (m *SymlinksModule) ValidateTask(task *config.Task) error {
 	if !exists {
 		return fmt.Errorf("symlink task requires 'src' field")
 	}
-	if _, ok := src.(string); !ok {
+	if _, ok := modules.StringValue(src); !ok {
 		return fmt.Errorf("symlink 'src' must be a string")
 	}
 
-	dst, exists := task.Config["dst"]
-	if !exists {
-		return fmt.Errorf("symlink task requires 'dst' field")
-	}
-	if _, ok := dst.(string); !ok {
-		return fmt.Errorf("symlink 'dst' must be a string")
+	if err := modules.ValidateTargets("symlink", task.Config, "dst", "dsts"); err != nil {
+		return err
 	}
 
-	return nil
+	return modules.ValidateAsRoot("symlink", task.Config)
 }
 
 // ExecuteTask executes a symlink task
@@ -64,13 +60,37 @@ func (m *SymlinksModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionCo
 		return nil // Plan already showed what would happen
 	}
 
+	return modules.ExecuteTargets(task, m.SplitTask(task), m.targetPath(ctx), func(target *config.Task) error {
+		return m.executeSymlink(target, ctx)
+	})
+}
+
+// SplitTask returns a task for every destination a symlink task lists in dsts
+func (m *SymlinksModule) SplitTask(task *config.Task) []*config.Task {
+	return modules.TargetTasks(task, "dst", "dsts")
+}
+
+// targetPath returns a function giving the destination of a task for a single
+// destination, to name it in messages
+func (m *SymlinksModule) targetPath(ctx *modules.ExecutionContext) func(target *config.Task) string {
+	return func(target *config.Task) string {
+		targets, err := m.TaskTargets(target, ctx)
+		if err != nil {
+			return fmt.Sprint(target.Config["dst"])
+		}
+		return targets[0]
+	}
+}
+
+// executeSymlink creates the symlink of a task for a single destination
+func (m *SymlinksModule) executeSymlink(task *config.Task, ctx *modules.ExecutionContext) error {
 	// Process templates in src and dst paths
-	src, err := m.processTemplate(task.Config["src"].(string), ctx.Variables)
+	src, err := m.processField(task, "src", ctx.Variables)
 	if err != nil {
 		return fmt.Errorf("failed to process src template: %w", err)
 	}
 
-	dst, err := m.processTemplate(task.Config["dst"].(string), ctx.Variables)
+	dst, err := m.processField(task, "dst", ctx.Variables)
 	if err != nil {
 		return fmt.Errorf("failed to process dst template: %w", err)
 	}
@@ -92,7 +112,7 @@ func (m *SymlinksModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionCo
 	}
 
 	// Handle backup if requested
-	backup, _ := task.Config["backup"].(bool)
+	backup, _ := modules.BoolValue(task.Config["backup"])
 	if backup && utils.FileExists(dst) {
 		backupPath := dst + ".backup"
 		if ctx.Verbose {
@@ -103,15 +123,9 @@ func (m *SymlinksModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionCo
 		}
 	}
 
-	// Remove existing file/link if it exists
-	if utils.FileExists(dst) {
-		if err := os.Remove(dst); err != nil {
-			return fmt.Errorf("failed to remove existing file: %w", err)
-		}
-	}
-
 	// Ensure destination directory exists
 	dstDir := filepath.Dir(dst)
+	created := modules.MissingDirs(dstDir)
 	if err := utils.EnsureDir(dstDir); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
@@ -121,22 +135,30 @@ func (m *SymlinksModule) ExecuteTask(task *config.Task, ctx *modules.ExecutionCo
 		fmt.Printf("Creating symlink: %s -> %s\n", src, dst)
 	}
 
-	if err := os.Symlink(src, dst); err != nil {
+	// The link replaces an existing file or link at once, dst is never missing
+	if err := utils.ReplaceSymlink(src, dst); err != nil {
 		return fmt.Errorf("failed to create symlink: %w", err)
 	}
 
-	return nil
+	return modules.HandBackToInvokingUser(task, ctx, append(created, dst)...)
 }
 
 // PlanTask returns what the symlink task would do
 func (m *SymlinksModule) PlanTask(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
+	return modules.PlanTargets(task, m.SplitTask(task), func(target *config.Task) (*modules.TaskPlan, error) {
+		return m.planSymlink(target, ctx)
+	})
+}
+
+// planSymlink returns what the symlink task for a single destination would do
+func (m *SymlinksModule) planSymlink(task *config.Task, ctx *modules.ExecutionContext) (*modules.TaskPlan, error) {
 	// Process templates in src and dst paths
-	src, err := m.processTemplate(task.Config["src"].(string), ctx.Variables)
+	src, err := m.processField(task, "src", ctx.Variables)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process src template: %w", err)
 	}
 
-	dst, err := m.processTemplate(task.Config["dst"].(string), ctx.Variables)
+	dst, err := m.processField(task, "dst", ctx.Variables)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process dst template: %w", err)
 	}
@@ -163,6 +185,7 @@ func (m *SymlinksModule) PlanTask(task *config.Task, ctx *modules.ExecutionConte
 	if !utils.FileExists(src) {
 		plan.WillSkip = true
 		plan.SkipReason = fmt.Sprintf("Source file does not exist: %s", src)
+		plan.SkipCode = modules.SkipMissingSource
 		return plan, nil
 	}
 
@@ -179,7 +202,7 @@ func (m *SymlinksModule) PlanTask(task *config.Task, ctx *modules.ExecutionConte
 			}
 		} else {
 			// Existing file/directory
-			backup, _ := task.Config["backup"].(bool)
+			backup, _ := modules.BoolValue(task.Config["backup"])
 			if backup {
 				plan.Changes = append(plan.Changes, fmt.Sprintf("Backup existing file to %s.backup", dst))
 			}
@@ -198,15 +221,173 @@ func (m *SymlinksModule) PlanTask(task *config.Task, ctx *modules.ExecutionConte
 	return plan, nil
 }
 
-// processTemplate processes a template string with variables using the new templating engine
-func (m *SymlinksModule) processTemplate(templateStr string, variables map[string]interface{}) (string, error) {
-	result, err := m.templateEngine.ProcessVariableTemplate(templateStr, variables)
+// CheckDrift compares the destination of a symlink task with the link apply would create
+func (m *SymlinksModule) CheckDrift(task *config.Task, ctx *modules.ExecutionContext) (*modules.DriftResult, error) {
+	if targets := m.SplitTask(task); targets[0] != task {
+		return modules.CheckTargetsDrift(targets, func(target *config.Task) (*modules.DriftResult, error) {
+			return m.CheckDrift(target, ctx)
+		})
+	}
+
+	src, err := m.processField(task, "src", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process src template: %w", err)
+	}
+
+	dst, err := m.processField(task, "dst", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process dst template: %w", err)
+	}
+
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(ctx.BasePath, src)
+	}
+
+	dst, err = utils.ExpandPath(dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand destination path: %w", err)
+	}
+
+	result := &modules.DriftResult{Path: dst}
+	info, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		result.State = modules.DriftMissing
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to inspect %s: %w", dst, err)
+	}
+
+	// A regular file or directory is replaced by apply
+	if info.Mode()&os.ModeSymlink == 0 {
+		result.State = modules.DriftOutOfDate
+		return result, nil
+	}
+
+	linkTarget, err := os.Readlink(dst)
+	if err != nil {
+		return result, fmt.Errorf("failed to read symlink %s: %w", dst, err)
+	}
+
+	switch {
+	case !utils.FileExists(dst):
+		result.State = modules.DriftBroken
+	case linkTarget == src:
+		result.State = modules.DriftInSync
+	default:
+		result.State = modules.DriftOutOfDate
+	}
+	return result, nil
+}
+
+// TaskTargets returns the destinations of a symlink task and, when they are backed
+// up, the paths of their backups
+func (m *SymlinksModule) TaskTargets(task *config.Task, ctx *modules.ExecutionContext) ([]string, error) {
+	if targets := m.SplitTask(task); targets[0] != task {
+		var paths []string
+		for _, target := range targets {
+			targetPaths, err := m.TaskTargets(target, ctx)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, targetPaths...)
+		}
+		return paths, nil
+	}
+
+	dst, err := m.processField(task, "dst", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process dst template: %w", err)
+	}
+	dst, err = utils.ExpandPath(dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand destination path: %w", err)
+	}
+
+	targets := []string{dst}
+	if backup, _ := modules.BoolValue(task.Config["backup"]); backup {
+		targets = append(targets, dst+".backup")
+	}
+	return targets, nil
+}
+
+// ManagedPaths returns the destinations of a symlink task
+func (m *SymlinksModule) ManagedPaths(task *config.Task, ctx *modules.ExecutionContext) ([]string, error) {
+	var paths []string
+	for _, target := range m.SplitTask(task) {
+		targets, err := m.TaskTargets(target, ctx)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, targets[0])
+	}
+	return paths, nil
+}
+
+// DesiredTargets returns the destinations of a symlink task and the source they point to
+func (m *SymlinksModule) DesiredTargets(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.DesiredTarget, error) {
+	src, err := m.processField(task, "src", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process src template: %w", err)
+	}
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(ctx.BasePath, src)
+	}
+
+	paths, err := m.ManagedPaths(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	var desired []*modules.DesiredTarget
+	for _, path := range paths {
+		desired = append(desired, &modules.DesiredTarget{Path: path, Kind: "symlink", Content: src})
+	}
+	return desired, nil
+}
+
+// TaskTemplates returns the source file of a symlink, which is linked as-is and
+// never rendered
+func (m *SymlinksModule) TaskTemplates(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.TemplateSource, error) {
+	src, err := m.processField(task, "src", ctx.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process src template: %w", err)
+	}
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(ctx.BasePath, src)
+	}
+	return []*modules.TemplateSource{{Field: "src", Path: src}}, nil
+}
+
+// PreflightChecks returns the checks a symlink task needs: its source exists and
+// the directories of its destinations are writable
+func (m *SymlinksModule) PreflightChecks(task *config.Task, ctx *modules.ExecutionContext) ([]*modules.PreflightCheck, error) {
+	sources, err := m.TaskTemplates(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := m.ManagedPaths(task, ctx)
+	if err != nil {
+		return nil, err
+	}
+	checks := []*modules.PreflightCheck{modules.SourceExistsCheck("src", sources[0].Path)}
+	for _, path := range paths {
+		checks = append(checks, modules.WritableDirCheck(filepath.Dir(path)))
+	}
+	return checks, nil
+}
+
+// processField processes a path field of the config of a task as a template
+func (m *SymlinksModule) processField(task *config.Task, field string, variables map[string]interface{}) (string, error) {
+	value, err := modules.GetString(task, field)
 	if err != nil {
 		return "", err
 	}
+	return m.processTemplate(value, variables)
+}
 
-	// Ensure OS-specific path separators
-	return filepath.FromSlash(result), nil
+// processTemplate processes a template of a path with OS-specific path separators
+func (m *SymlinksModule) processTemplate(templateStr string, variables map[string]interface{}) (string, error) {
+	return m.templateEngine.ProcessPath(templateStr, variables)
 }
 
 // ExplainAction returns documentation for a specific action
@@ -236,8 +417,14 @@ func (m *SymlinksModule) ListActions() []*modules.ActionDocumentation {
 				{
 					Name:        "dst",
 					Type:        "string",
-					Required:    true,
-					Description: "The destination path where the symlink will be created. Supports template variables and path expansion (e.g., ~ for home directory).",
+					Required:    false,
+					Description: "The destination path where the symlink will be created. Supports template variables and path expansion (e.g., ~ for home directory). Required unless dsts is given.",
+				},
+				{
+					Name:        "dsts",
+					Type:        "[]string",
+					Required:    false,
+					Description: "Several destination paths that each get a symlink to src, planned and reported per destination. Cannot be combined with dst.",
 				},
 				{
 					Name:        "backup",
@@ -246,6 +433,13 @@ func (m *SymlinksModule) ListActions() []*modules.ActionDocumentation {
 					Default:     "false",
 					Description: "Whether to create a backup of existing files before creating the symlink. Backup files are named with a .backup suffix.",
 				},
+				{
+					Name:        "as_root",
+					Type:        "boolean",
+					Required:    false,
+					Default:     "false",
+					Description: "When dotfiles runs as root through sudo, symlinks and directories created in the home directory of the user that ran sudo are handed back to that user. Set to true to keep them owned by root.",
+				},
 			},
 			Examples: []modules.ActionExample{
 				{
@@ -263,6 +457,16 @@ func (m *SymlinksModule) ListActions() []*modules.ActionDocumentation {
 						"backup": true,
 					},
 				},
+				{
+					Description: "Link one file into several places",
+					Config: map[string]interface{}{
+						"src": "files/config/editorconfig",
+						"dsts": []string{
+							"{{ .paths.home }}/.editorconfig",
+							"{{ .paths.home }}/projects/.editorconfig",
+						},
+					},
+				},
 				{
 					Description: "Symlink an entire directory",
 					Config: map[string]interface{}{
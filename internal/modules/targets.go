@@ -0,0 +1,216 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// TaskSplitter is implemented by modules whose tasks can put the same thing in
+// place at several targets, so output that lists targets can show each of them
+type TaskSplitter interface {
+	// SplitTask returns a task for every target of task, or task itself when it has
+	// a single target
+	SplitTask(task *config.Task) []*config.Task
+}
+
+// SplitTask returns a task for every target of a task with several targets. Other
+// tasks are returned as they are.
+func (r *ModuleRegistry) SplitTask(task *config.Task) []*config.Task {
+	module, err := r.GetModuleByAction(task.Action)
+	if err != nil {
+		return []*config.Task{task}
+	}
+	splitter, ok := module.(TaskSplitter)
+	if !ok {
+		return []*config.Task{task}
+	}
+	return splitter.SplitTask(task)
+}
+
+// ValidateTargets validates the target of an action that takes one target in
+// single, like path, or several in plural, like paths. Exactly one of them must be
+// set, plural to a list of distinct strings that is not empty.
+func ValidateTargets(action string, config map[string]interface{}, single, plural string) error {
+	value, hasPlural := config[plural]
+	if _, hasSingle := config[single]; hasSingle {
+		if hasPlural {
+			return fmt.Errorf("%s '%s' and '%s' cannot be used together", action, single, plural)
+		}
+		if _, ok := StringValue(config[single]); !ok {
+			return fmt.Errorf("%s '%s' must be a string", action, single)
+		}
+		return nil
+	}
+	if !hasPlural {
+		return fmt.Errorf("%s task requires '%s' or '%s' field", action, single, plural)
+	}
+
+	targets, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s '%s' must be a list of strings", action, plural)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("%s '%s' must list at least one target", action, plural)
+	}
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		name, ok := target.(string)
+		if !ok || name == "" {
+			return fmt.Errorf("%s '%s' must be a list of strings", action, plural)
+		}
+		if seen[name] {
+			return fmt.Errorf("%s '%s' lists %s more than once", action, plural, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// TargetTasks returns a task for every target a task lists in plural, with the
+// target in single and appended to its ID. A task without plural is returned as it
+// is.
+func TargetTasks(task *config.Task, single, plural string) []*config.Task {
+	targets, ok := task.Config[plural].([]interface{})
+	if !ok {
+		return []*config.Task{task}
+	}
+
+	tasks := make([]*config.Task, 0, len(targets))
+	for _, target := range targets {
+		targetTask := *task
+		targetTask.ID = fmt.Sprintf("%s → %v", task.ID, target)
+		targetTask.Config = make(map[string]interface{}, len(task.Config))
+		for key, value := range task.Config {
+			if key != plural {
+				targetTask.Config[key] = value
+			}
+		}
+		targetTask.Config[single] = target
+		tasks = append(tasks, &targetTask)
+	}
+	return tasks
+}
+
+// PlanTargets plans every target of a task with planTarget and combines their
+// plans. Each target is listed with its own changes or why it is skipped, the task
+// is only skipped when every target is.
+func PlanTargets(task *config.Task, targets []*config.Task, planTarget func(target *config.Task) (*TaskPlan, error)) (*TaskPlan, error) {
+	if len(targets) == 1 && targets[0] == task {
+		return planTarget(task)
+	}
+
+	plan := &TaskPlan{
+		TaskID:   task.ID,
+		Action:   task.Action,
+		Changes:  []string{},
+		WillSkip: true,
+	}
+	var skipReasons []string
+	for _, target := range targets {
+		targetPlan, err := planTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		if plan.Description == "" {
+			plan.Description = targetPlan.Description
+		}
+		if plan.Conflict == "" {
+			plan.Conflict = targetPlan.Conflict
+		}
+
+		if targetPlan.WillSkip {
+			plan.Changes = append(plan.Changes, fmt.Sprintf("%s: skipped, %s", targetPlan.Description, targetPlan.SkipReason))
+			if plan.SkipCode == "" || plan.SkipCode == SkipAlreadySatisfied {
+				plan.SkipCode = targetPlan.SkipCode
+			}
+			skipReasons = append(skipReasons, targetPlan.SkipReason)
+			continue
+		}
+		plan.WillSkip = false
+		plan.Changes = append(plan.Changes, targetPlan.Description)
+		for _, change := range targetPlan.Changes {
+			plan.Changes = append(plan.Changes, "  "+change)
+		}
+	}
+
+	switch more := len(targets) - 1; {
+	case more == 1:
+		plan.Description += " (and 1 more target)"
+	case more > 1:
+		plan.Description += fmt.Sprintf(" (and %d more targets)", more)
+	}
+	if plan.WillSkip {
+		plan.SkipReason = strings.Join(uniqueStrings(skipReasons), "; ")
+	} else {
+		plan.SkipCode = ""
+	}
+	return plan, nil
+}
+
+// ExecuteTargets executes every target of a task with executeTarget and combines
+// their outcomes: the task needs attention when a target does and is skipped when
+// every target is. It stops at the first target that fails, naming it with name.
+func ExecuteTargets(task *config.Task, targets []*config.Task, name func(target *config.Task) string, executeTarget func(target *config.Task) error) error {
+	if len(targets) == 1 && targets[0] == task {
+		return executeTarget(task)
+	}
+
+	var attention, skipped []string
+	for _, target := range targets {
+		err := executeTarget(target)
+		var outcome *TaskOutcome
+		switch {
+		case errors.As(err, &outcome) && outcome.NeedsAttention:
+			attention = append(attention, outcome.Message)
+		case errors.As(err, &outcome) && outcome.Skipped:
+			skipped = append(skipped, fmt.Sprintf("%s: %s", name(target), outcome.Message))
+		case err != nil:
+			return fmt.Errorf("%s: %w", name(target), err)
+		}
+	}
+
+	switch {
+	case len(attention) > 0:
+		return &TaskOutcome{NeedsAttention: true, Message: strings.Join(append(attention, skipped...), "; ")}
+	case len(skipped) == len(targets):
+		return &TaskOutcome{Skipped: true, Message: strings.Join(skipped, "; ")}
+	}
+	return nil
+}
+
+// CheckTargetsDrift checks the drift of every target of a task with checkTarget
+// and returns the first target that is not in sync, or the first target when all
+// of them are
+func CheckTargetsDrift(targets []*config.Task, checkTarget func(target *config.Task) (*DriftResult, error)) (*DriftResult, error) {
+	var first *DriftResult
+	for _, target := range targets {
+		result, err := checkTarget(target)
+		if err != nil {
+			return result, err
+		}
+		if result.State != DriftInSync {
+			return result, nil
+		}
+		if first == nil {
+			first = result
+		}
+	}
+	return first, nil
+}
+
+// uniqueStrings returns values without repeated strings, in the order they first
+// appear
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,289 @@
+package modules
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// ConfigTypeError is returned when a field of a task config has a type the action
+// cannot use, e.g. a list where it expects a string
+type ConfigTypeError struct {
+	TaskID   string
+	Location string // Where the task is defined, like jobs/index.yaml:12
+	Field    string
+	Want     string // What the field must be, like "a string"
+	Value    interface{}
+}
+
+// Error implements the error interface
+func (e *ConfigTypeError) Error() string {
+	task := fmt.Sprintf("task '%s'", e.TaskID)
+	if e.Location != "" {
+		task += fmt.Sprintf(" (%s)", e.Location)
+	}
+	return fmt.Sprintf("%s: %s", task, e.Problem())
+}
+
+// Problem describes what is wrong with the field, without the task
+func (e *ConfigTypeError) Problem() string {
+	problem := fmt.Sprintf("'%s' must be %s, got %s", e.Field, e.Want, describeValue(e.Value))
+	if e.Want == "a string" {
+		// Unquoted templates like {{ name }} are read as maps
+		problem += fmt.Sprintf(", quote it in YAML (%s: \"...\")", e.Field)
+	}
+	return problem
+}
+
+// describeValue returns the YAML type of a value with the value, for errors
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nothing"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	case int, int64, uint64, float64:
+		return fmt.Sprintf("number %v", v)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "a map"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// typeError returns the error of a field of a task that is not what an action wants
+func typeError(task *config.Task, field, want string, value interface{}) error {
+	return &ConfigTypeError{TaskID: task.ID, Location: task.Location(), Field: field, Want: want, Value: value}
+}
+
+// StringValue converts the scalars YAML decodes to the string they were written as,
+// so `name: 1.24` is "1.24". ok is false for lists and maps.
+func StringValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// GetString returns a string field of the config of a task, "" when it is not set.
+// Numbers and booleans are converted to the text they were written as.
+func GetString(task *config.Task, field string) (string, error) {
+	value, exists := task.Config[field]
+	if !exists {
+		return "", nil
+	}
+	s, ok := StringValue(value)
+	if !ok {
+		return "", typeError(task, field, "a string", value)
+	}
+	return s, nil
+}
+
+// BoolValue converts a boolean YAML value, accepting the quoted strings "true" and
+// "false" too. Other strings like "yes" are not booleans in YAML 1.2 and ok is false.
+func BoolValue(value interface{}) (b, ok bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// GetBool returns a boolean field of the config of a task, false when it is not
+// set. Quoted booleans are converted like BoolValue does.
+func GetBool(task *config.Task, field string) (bool, error) {
+	value, exists := task.Config[field]
+	if !exists {
+		return false, nil
+	}
+	b, ok := BoolValue(value)
+	if !ok {
+		return false, typeError(task, field, "true or false", value)
+	}
+	return b, nil
+}
+
+// StringSliceValue converts a list of scalars to the strings they were written as,
+// like GetStringSlice does. A single string is a list of one.
+func StringSliceValue(value interface{}) ([]string, bool) {
+	if s, ok := value.(string); ok {
+		return []string{s}, true
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		s, ok := StringValue(item)
+		if !ok {
+			return nil, false
+		}
+		list[i] = s
+	}
+	return list, true
+}
+
+// GetStringSlice returns a list of strings field of the config of a task, nil when
+// it is not set. A single string is a list of one.
+func GetStringSlice(task *config.Task, field string) ([]string, error) {
+	value, exists := task.Config[field]
+	if !exists {
+		return nil, nil
+	}
+	if s, ok := value.(string); ok {
+		return []string{s}, nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, typeError(task, field, "a list of strings", value)
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		s, ok := StringValue(item)
+		if !ok {
+			return nil, typeError(task, fmt.Sprintf("%s[%d]", field, i), "a string", item)
+		}
+		list[i] = s
+	}
+	return list, nil
+}
+
+// GetStringMap returns a map of strings field of the config of a task, nil when it
+// is not set. Numbers and booleans in it are converted like GetString does.
+func GetStringMap(task *config.Task, field string) (map[string]string, error) {
+	value, exists := task.Config[field]
+	if !exists {
+		return nil, nil
+	}
+	items, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, typeError(task, field, "a map of strings", value)
+	}
+	result := make(map[string]string, len(items))
+	for key, item := range items {
+		s, ok := StringValue(item)
+		if !ok {
+			return nil, typeError(task, field+"."+key, "a string", item)
+		}
+		result[key] = s
+	}
+	return result, nil
+}
+
+// IntValue converts a whole number YAML value to an int. Numbers with a fraction
+// and quoted numbers are not whole numbers and ok is false.
+func IntValue(value interface{}) (int, bool) {
+	if !isWholeNumber(value) {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// isWholeNumber reports whether value is a number without a fraction
+func isWholeNumber(value interface{}) bool {
+	switch v := value.(type) {
+	case int, int64, uint64:
+		return true
+	case float64:
+		return v == float64(int64(v))
+	default:
+		return false
+	}
+}
+
+// CheckParameterTypes checks that the fields of the config of a task have the types
+// the documentation of its action gives them, so a YAML typo like
+// `check_system_wide: maybe` fails validation instead of the task. Fields that
+// are not documented or have types that are not checked are left to the module.
+func CheckParameterTypes(task *config.Task, doc *ActionDocumentation) error {
+	if doc == nil {
+		return nil
+	}
+	parameters := make(map[string]ActionParameter, len(doc.Parameters))
+	for _, parameter := range doc.Parameters {
+		parameters[parameter.Name] = parameter
+	}
+
+	fields := make([]string, 0, len(task.Config))
+	for field := range task.Config {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		parameter, documented := parameters[field]
+		if !documented {
+			continue
+		}
+		if err := checkParameterType(task, field, parameter.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParameterType checks the type of a field of the config of a task
+func checkParameterType(task *config.Task, field, parameterType string) error {
+	value := task.Config[field]
+	var err error
+	switch parameterType {
+	case "string":
+		_, err = GetString(task, field)
+	case "bool", "boolean":
+		_, err = GetBool(task, field)
+	case "int", "integer":
+		if !isWholeNumber(value) {
+			err = typeError(task, field, "a whole number", value)
+		}
+	case "[]string", "array", "string or []string", "string|array":
+		_, err = GetStringSlice(task, field)
+	case "map[string]string":
+		_, err = GetStringMap(task, field)
+	case "map", "object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			err = typeError(task, field, "a map", value)
+		}
+	case "[]object":
+		if _, ok := value.([]interface{}); !ok {
+			err = typeError(task, field, "a list", value)
+		}
+	}
+	return err
+}
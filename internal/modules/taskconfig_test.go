@@ -0,0 +1,223 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+func newConfigTask(cfg map[string]interface{}) *config.Task {
+	return &config.Task{ID: "install_package: go", Action: "install_package", Source: "jobs/index.yaml", Line: 4, Config: cfg}
+}
+
+func TestGetString(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{value: "go", want: "go"},
+		{value: 1.24, want: "1.24"},
+		{value: 1.10, want: "1.1"},
+		{value: 42, want: "42"},
+		{value: true, want: "true"},
+		{value: []interface{}{"go"}, wantErr: true},
+		{value: map[string]interface{}{"name": nil}, wantErr: true},
+		{value: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := GetString(newConfigTask(map[string]interface{}{"name": tt.value}), "name")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("GetString(%#v) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if got, err := GetString(newConfigTask(nil), "name"); got != "" || err != nil {
+		t.Errorf("GetString() of an unset field = %q, %v, want no value and no error", got, err)
+	}
+
+	// Unquoted templates like {{ name }} are maps in YAML
+	_, err := GetString(newConfigTask(map[string]interface{}{"name": map[string]interface{}{"name": nil}}), "name")
+	want := `task 'install_package: go' (jobs/index.yaml:4): 'name' must be a string, got a map, quote it in YAML (name: "...")`
+	if err == nil || err.Error() != want {
+		t.Errorf("GetString() error = %v, want %q", err, want)
+	}
+	var typeErr *ConfigTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field != "name" {
+		t.Errorf("GetString() error = %#v, want a ConfigTypeError of the field", err)
+	}
+}
+
+func TestGetBool(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    bool
+		wantErr bool
+	}{
+		{value: true, want: true},
+		{value: false, want: false},
+		{value: "true", want: true},
+		{value: "False", want: false},
+		{value: "yes", wantErr: true},
+		{value: 1, wantErr: true},
+		{value: []interface{}{true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := GetBool(newConfigTask(map[string]interface{}{"check_system_wide": tt.value}), "check_system_wide")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("GetBool(%#v) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetStringSlice(t *testing.T) {
+	got, err := GetStringSlice(newConfigTask(map[string]interface{}{"only": []interface{}{"apt", 3.12}}), "only")
+	if err != nil || !reflect.DeepEqual(got, []string{"apt", "3.12"}) {
+		t.Errorf("GetStringSlice() = %q, %v", got, err)
+	}
+
+	got, err = GetStringSlice(newConfigTask(map[string]interface{}{"only": "apt"}), "only")
+	if err != nil || !reflect.DeepEqual(got, []string{"apt"}) {
+		t.Errorf("GetStringSlice() of a single string = %q, %v, want a list of one", got, err)
+	}
+
+	_, err = GetStringSlice(newConfigTask(map[string]interface{}{"only": []interface{}{"apt", []interface{}{"dnf"}}}), "only")
+	if err == nil || !strings.Contains(err.Error(), "'only[1]' must be a string, got a list") {
+		t.Errorf("GetStringSlice() error = %v, want it to name the item", err)
+	}
+	if _, err := GetStringSlice(newConfigTask(map[string]interface{}{"only": true}), "only"); err == nil {
+		t.Error("GetStringSlice() of a boolean succeeded, want an error")
+	}
+}
+
+func TestGetStringMap(t *testing.T) {
+	got, err := GetStringMap(newConfigTask(map[string]interface{}{"env": map[string]interface{}{"PORT": 8080, "NAME": "web"}}), "env")
+	if err != nil || !reflect.DeepEqual(got, map[string]string{"PORT": "8080", "NAME": "web"}) {
+		t.Errorf("GetStringMap() = %v, %v", got, err)
+	}
+
+	_, err = GetStringMap(newConfigTask(map[string]interface{}{"env": map[string]interface{}{"PATH": []interface{}{"/bin"}}}), "env")
+	if err == nil || !strings.Contains(err.Error(), "'env.PATH' must be a string, got a list") {
+		t.Errorf("GetStringMap() error = %v, want it to name the key", err)
+	}
+	if _, err := GetStringMap(newConfigTask(map[string]interface{}{"env": "PORT=8080"}), "env"); err == nil {
+		t.Error("GetStringMap() of a string succeeded, want an error")
+	}
+}
+
+func TestCheckParameterTypes(t *testing.T) {
+	doc := &ActionDocumentation{
+		Action: "install_package",
+		Parameters: []ActionParameter{
+			{Name: "name", Type: "string"},
+			{Name: "check_system_wide", Type: "bool"},
+			{Name: "command", Type: "string or []string"},
+			{Name: "max_matches", Type: "int"},
+			{Name: "managers", Type: "map"},
+		},
+	}
+
+	valid := []map[string]interface{}{
+		{"name": "go"},
+		{"name": 1.24, "check_system_wide": "true", "command": "go"},
+		{"command": []interface{}{"go", "gofmt"}, "max_matches": 3, "managers": map[string]interface{}{"apt": "golang"}},
+		{"undocumented": []interface{}{}},
+	}
+	for _, cfg := range valid {
+		if err := CheckParameterTypes(newConfigTask(cfg), doc); err != nil {
+			t.Errorf("CheckParameterTypes(%v) = %v, want no error", cfg, err)
+		}
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"'name' must be a string":             {"name": []interface{}{"go"}},
+		"'check_system_wide' must be true or": {"check_system_wide": "maybe"},
+		"'command[0]' must be a string":       {"command": []interface{}{map[string]interface{}{}}},
+		"'max_matches' must be a whole":       {"max_matches": 2.5},
+		"'managers' must be a map":            {"managers": "apt"},
+	}
+	for want, cfg := range invalid {
+		err := CheckParameterTypes(newConfigTask(cfg), doc)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("CheckParameterTypes(%v) = %v, want an error containing %q", cfg, err, want)
+		}
+	}
+}
+
+// documentedModule documents a single string parameter of its action
+type documentedModule struct {
+	flakyModule
+}
+
+func (m *documentedModule) ExplainAction(string) (*ActionDocumentation, error) {
+	return &ActionDocumentation{Action: "flaky", Parameters: []ActionParameter{{Name: "name", Type: "string"}}}, nil
+}
+
+func TestExecuteTaskChecksParameterTypes(t *testing.T) {
+	registry := NewModuleRegistry()
+	module := &documentedModule{}
+	if err := registry.Register(module); err != nil {
+		t.Fatal(err)
+	}
+	task := &config.Task{ID: "flaky", Action: "flaky", Config: map[string]interface{}{"name": []interface{}{"go"}}}
+	ctx := &ExecutionContext{Context: context.Background(), DefaultRetry: RetryPolicy{Retries: 3}}
+
+	var typeErr *ConfigTypeError
+	if _, err := registry.ExecuteTask(task, ctx); !errors.As(err, &typeErr) {
+		t.Errorf("ExecuteTask() error = %v, want a ConfigTypeError", err)
+	}
+	if module.runs != 0 {
+		t.Errorf("ExecuteTask() ran a task with an invalid config %d times", module.runs)
+	}
+	if _, err := registry.PlanTask(task, ctx); !errors.As(err, &typeErr) {
+		t.Errorf("PlanTask() error = %v, want a ConfigTypeError", err)
+	}
+	if err := registry.ValidateTask(task); !errors.As(err, &typeErr) {
+		t.Errorf("ValidateTask() error = %v, want a ConfigTypeError", err)
+	}
+}
+
+// panickingModule panics on every task like a module with an unchecked type assertion
+type panickingModule struct {
+	flakyModule
+}
+
+func (m *panickingModule) PlanTask(task *config.Task, ctx *ExecutionContext) (*TaskPlan, error) {
+	_ = task.Config["name"].(string)
+	return nil, nil
+}
+
+func (m *panickingModule) ExecuteTask(task *config.Task, ctx *ExecutionContext) error {
+	m.runs++
+	_ = task.Config["name"].(string)
+	return nil
+}
+
+func TestModulePanicFailsTask(t *testing.T) {
+	registry := NewModuleRegistry()
+	module := &panickingModule{}
+	if err := registry.Register(module); err != nil {
+		t.Fatal(err)
+	}
+	task := &config.Task{ID: "flaky", Action: "flaky", Source: "jobs/index.yaml", Line: 7, Config: map[string]interface{}{"name": 1.24}}
+	ctx := &ExecutionContext{Context: context.Background(), DefaultRetry: RetryPolicy{Retries: 3}}
+
+	result, err := registry.ExecuteTask(task, ctx)
+	if err == nil || !strings.Contains(err.Error(), "flaky module failed on task 'flaky' (jobs/index.yaml:7)") {
+		t.Errorf("ExecuteTask() error = %v, want the panic as an error naming the task", err)
+	}
+	if result == nil || result.Success {
+		t.Errorf("ExecuteTask() result = %+v, want a failed task", result)
+	}
+	if module.runs != 1 {
+		t.Errorf("ExecuteTask() retried a panicking task, ran it %d times", module.runs)
+	}
+
+	if _, err := registry.PlanTask(task, ctx); err == nil || !strings.Contains(err.Error(), "flaky module failed on task 'flaky'") {
+		t.Errorf("PlanTask() error = %v, want the panic as an error naming the task", err)
+	}
+}
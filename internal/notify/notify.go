@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+// Message is what a notification tells about an apply run
+type Message struct {
+	Title   string // Short summary, the title of desktop notifications
+	Body    string // Counts of the run, the text of desktop notifications
+	Payload []byte // JSON apply report webhooks post
+}
+
+// Sender delivers notifications
+type Sender struct {
+	Client *http.Client // Client webhooks are posted with
+	GOOS   string       // Platform desktop notifications are shown on
+}
+
+// NewSender creates a sender for the current platform
+func NewSender() *Sender {
+	return &Sender{
+		Client: http.DefaultClient,
+		GOOS:   runtime.GOOS,
+	}
+}
+
+// ShouldSend reports whether a notification is sent for a run that changed and/or
+// failed something. Without notify_on it is sent on changes and failures.
+func ShouldSend(notification *config.Notification, changed, failed bool) bool {
+	events := notification.NotifyOn
+	if len(events) == 0 {
+		events = []string{"changes", "failures"}
+	}
+	return slices.Contains(events, "always") ||
+		(changed && slices.Contains(events, "changes")) ||
+		(failed && slices.Contains(events, "failures"))
+}
+
+// Send delivers a message to a notification target
+func (s *Sender) Send(ctx context.Context, notification *config.Notification, message *Message) error {
+	switch notification.Type {
+	case "webhook":
+		return s.sendWebhook(ctx, notification, message)
+	case "desktop":
+		return s.sendDesktop(ctx, message)
+	default:
+		return fmt.Errorf("unknown notification type '%s'", notification.Type)
+	}
+}
+
+// sendWebhook posts the JSON payload of a message to the URL of a webhook
+func (s *Sender) sendWebhook(ctx context.Context, notification *config.Notification, message *Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notification.URL, bytes.NewReader(message.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dotfiles")
+	if token := os.ExpandEnv(notification.Token); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sendDesktop shows a message as a desktop notification
+func (s *Sender) sendDesktop(ctx context.Context, message *Message) error {
+	name, args, err := desktopCommand(s.GOOS, message.Title, message.Body)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%s is not available, desktop notifications cannot be shown", name)
+	}
+
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// desktopCommand returns the command that shows a desktop notification: notify-send
+// on Linux, osascript on macOS and a PowerShell toast on Windows
+func desktopCommand(goos, title, body string) (string, []string, error) {
+	switch goos {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return "notify-send", []string{"--app-name=dotfiles", title, body}, nil
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		return "osascript", []string{"-e", script}, nil
+	case "windows":
+		script := fmt.Sprintf(windowsToastScript, powerShellString(title), powerShellString(body))
+		return "powershell", []string{"-NoProfile", "-NonInteractive", "-Command", script}, nil
+	default:
+		return "", nil, fmt.Errorf("desktop notifications are not supported on %s", goos)
+	}
+}
+
+// windowsToastScript shows a toast notification with a title and a body
+const windowsToastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode(%s)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode(%s)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('dotfiles').Show($toast)`
+
+// appleScriptString quotes a string for AppleScript
+func appleScriptString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// powerShellString quotes a string for PowerShell
+func powerShellString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+)
+
+func TestShouldSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		notifyOn []string
+		changed  bool
+		failed   bool
+		want     bool
+	}{
+		{"DefaultNothingHappened", nil, false, false, false},
+		{"DefaultChanges", nil, true, false, true},
+		{"DefaultFailures", nil, false, true, true},
+		{"FailuresOnlyIgnoresChanges", []string{"failures"}, true, false, false},
+		{"ChangesOnlyIgnoresFailures", []string{"changes"}, false, true, false},
+		{"Always", []string{"always"}, false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification := &config.Notification{Type: "desktop", NotifyOn: tt.notifyOn}
+			assert.Equal(t, tt.want, ShouldSend(notification, tt.changed, tt.failed))
+		})
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	var received struct {
+		method, contentType, authorization string
+		body                               string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received.method = r.Method
+		received.contentType = r.Header.Get("Content-Type")
+		received.authorization = r.Header.Get("Authorization")
+		received.body = string(body)
+		if r.URL.Path == "/broken" {
+			http.Error(w, "no such hook", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("DOTFILES_TEST_TOKEN", "secret")
+	sender := NewSender()
+	message := &Message{Title: "title", Body: "body", Payload: []byte(`{"status":"success"}`)}
+
+	err := sender.Send(context.Background(), &config.Notification{Type: "webhook", URL: server.URL + "/hook", Token: "$DOTFILES_TEST_TOKEN"}, message)
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPost, received.method)
+	assert.Equal(t, "application/json", received.contentType)
+	assert.Equal(t, "Bearer secret", received.authorization)
+	assert.Equal(t, `{"status":"success"}`, received.body)
+
+	t.Run("WithoutToken", func(t *testing.T) {
+		err := sender.Send(context.Background(), &config.Notification{Type: "webhook", URL: server.URL + "/hook"}, message)
+		require.NoError(t, err)
+		assert.Empty(t, received.authorization)
+	})
+
+	t.Run("ErrorStatus", func(t *testing.T) {
+		err := sender.Send(context.Background(), &config.Notification{Type: "webhook", URL: server.URL + "/broken"}, message)
+		assert.ErrorContains(t, err, "webhook responded with 404 Not Found: no such hook")
+	})
+}
+
+func TestDesktopCommand(t *testing.T) {
+	name, args, err := desktopCommand("linux", "dotfiles apply failed", "1 failed")
+	require.NoError(t, err)
+	assert.Equal(t, "notify-send", name)
+	assert.Equal(t, []string{"--app-name=dotfiles", "dotfiles apply failed", "1 failed"}, args)
+
+	name, args, err = desktopCommand("darwin", `say "hi"`, `back\slash`)
+	require.NoError(t, err)
+	assert.Equal(t, "osascript", name)
+	assert.Equal(t, []string{"-e", `display notification "back\\slash" with title "say \"hi\""`}, args)
+
+	name, args, err = desktopCommand("windows", "it's done", "body")
+	require.NoError(t, err)
+	assert.Equal(t, "powershell", name)
+	assert.Contains(t, args[len(args)-1], "CreateTextNode('it''s done')")
+
+	_, _, err = desktopCommand("plan9", "title", "body")
+	assert.ErrorContains(t, err, "not supported on plan9")
+}
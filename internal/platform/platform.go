@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"runtime"
 	"strings"
 )
@@ -16,11 +17,14 @@ type PlatformInfo struct {
 	Shell                   string            `json:"shell"`
 	PackageManagers         []string          `json:"package_managers"`
 	AvailablePackageManagers []string         `json:"available_package_managers"`
+	HomebrewPrefix          string            `json:"homebrew_prefix"` // Where Homebrew is installed, empty without Homebrew
 	HomeDir                 string            `json:"home_dir"`
 	ConfigDir               string            `json:"config_dir"`
 	IsElevated              bool              `json:"is_elevated"`
 	IsRoot                  bool              `json:"is_root"`
 	IsWSL                   bool              `json:"is_wsl"`
+	WSLVersion              int               `json:"wsl_version"` // 1 or 2, 0 when unknown or not WSL
+	IsContainer             bool              `json:"is_container"`
 	Distro                  string            `json:"distro"`
 	DistroVersion           string            `json:"distro_version"`
 	DistroCodename          string            `json:"distro_codename"`
@@ -53,13 +57,15 @@ func GetPlatformInfo() (*PlatformInfo, error) {
 	// Detect package managers
 	info.PackageManagers = detectPackageManagers(info.OS)
 	info.AvailablePackageManagers = info.PackageManagers // Alias for template compatibility
+	info.HomebrewPrefix = detectHomebrewPrefix(info.OS, systemEnv)
 
 	// Check if running with elevated privileges
 	info.IsElevated = isElevated(info.OS)
 	info.IsRoot = (info.OS != "windows" && os.Geteuid() == 0)
 
-	// Check if running in WSL
-	info.IsWSL = isWSL(info.OS)
+	// Check if running in WSL or a container
+	info.IsWSL, info.WSLVersion = detectWSL(info.OS, systemEnv)
+	info.IsContainer = detectContainer(info.OS, systemEnv)
 
 	// Get system information
 	info.KernelVersion = getKernelVersion()
@@ -132,7 +138,7 @@ func detectPackageManagers(osName string) []string {
 			managers = append(managers, "scoop")
 		}
 	case "darwin":
-		if commandExists("brew") {
+		if findBrew(osName, systemEnv) != "" {
 			managers = append(managers, "homebrew")
 		}
 		if commandExists("port") {
@@ -163,17 +169,87 @@ func detectPackageManagers(osName string) []string {
 		if commandExists("apk") {
 			managers = append(managers, "apk")
 		}
+		// Homebrew on Linux installs user-space packages next to the distribution's
+		if findBrew(osName, systemEnv) != "" {
+			managers = append(managers, "homebrew")
+		}
+		if commandExists("flatpak") {
+			managers = append(managers, "flatpak")
+		}
+	}
+
+	// Cross-platform package managers
+	if commandExists("pipx") {
+		managers = append(managers, "pipx")
+	}
+	if commandExists("npm") {
+		managers = append(managers, "npm")
 	}
 
 	return managers
 }
 
+// DetectPackageManagers returns the package managers available on the current system
+func DetectPackageManagers() []string {
+	return detectPackageManagers(runtime.GOOS)
+}
+
 // commandExists checks if a command is available in the system PATH
 func commandExists(command string) bool {
 	_, err := exec.LookPath(command)
 	return err == nil
 }
 
+// HomebrewPrefixes returns where Homebrew installs itself by default on an OS: the
+// linuxbrew prefixes on Linux, /opt/homebrew on Apple silicon and /usr/local on
+// Intel Macs. brew is in their bin directory.
+func HomebrewPrefixes(osName, homeDir string) []string {
+	switch osName {
+	case "linux":
+		return []string{"/home/linuxbrew/.linuxbrew", path.Join(homeDir, ".linuxbrew")}
+	case "darwin":
+		return []string{"/opt/homebrew", "/usr/local"}
+	}
+	return nil
+}
+
+// FindBrew returns the path of the brew executable, or "" when Homebrew is not
+// installed
+func FindBrew() string {
+	return findBrew(runtime.GOOS, systemEnv)
+}
+
+// findBrew looks up brew in PATH and in the default Homebrew prefixes, which are
+// only in PATH once the shell profile sets them up
+func findBrew(osName string, env *detectionEnv) string {
+	if osName == "windows" {
+		return ""
+	}
+	if brew, err := env.lookPath("brew"); err == nil {
+		return brew
+	}
+	for _, prefix := range HomebrewPrefixes(osName, env.getenv("HOME")) {
+		if brew := path.Join(prefix, "bin", "brew"); env.exists(brew) {
+			return brew
+		}
+	}
+	return ""
+}
+
+// detectHomebrewPrefix returns the prefix Homebrew is installed in as brew --prefix
+// prints it, or "" when Homebrew is not installed
+func detectHomebrewPrefix(osName string, env *detectionEnv) string {
+	brew := findBrew(osName, env)
+	if brew == "" {
+		return ""
+	}
+	if output, err := env.output(brew, "--prefix"); err == nil && strings.TrimSpace(output) != "" {
+		return strings.TrimSpace(output)
+	}
+	// brew is in the bin directory of its prefix
+	return path.Dir(path.Dir(brew))
+}
+
 // getConfigDir returns the appropriate configuration directory for the OS
 func getConfigDir(osName, homeDir string) string {
 	switch osName {
@@ -412,39 +488,102 @@ func getUnameInfo() map[string]string {
 	return info
 }
 
-// isWSL checks if the current process is running in Windows Subsystem for Linux
-func isWSL(osName string) bool {
+// detectionEnv is what WSL, container and Homebrew detection read from the system,
+// so tests can fake it
+type detectionEnv struct {
+	readFile func(path string) ([]byte, error)
+	getenv   func(key string) string
+	exists   func(path string) bool
+	lookPath func(file string) (string, error)
+	output   func(name string, args ...string) (string, error)
+}
+
+// systemEnv reads the real files and environment
+var systemEnv = &detectionEnv{
+	readFile: os.ReadFile,
+	getenv:   os.Getenv,
+	exists: func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	},
+	lookPath: exec.LookPath,
+	output: func(name string, args ...string) (string, error) {
+		output, err := exec.Command(name, args...).Output()
+		return string(output), err
+	},
+}
+
+// detectWSL checks if the current process is running in Windows Subsystem for Linux
+// and returns the WSL version, or 0 when it cannot be told from the kernel
+func detectWSL(osName string, env *detectionEnv) (bool, int) {
 	// WSL only applies to Linux systems
 	if osName != "linux" {
-		return false
+		return false, 0
+	}
+
+	// WSL 2 runs its own kernel, named e.g. 5.15.90.1-microsoft-standard-WSL2, WSL 1
+	// reports a release like 4.4.0-19041-Microsoft
+	if content, err := env.readFile("/proc/sys/kernel/osrelease"); err == nil {
+		release := strings.ToLower(string(content))
+		if strings.Contains(release, "wsl2") {
+			return true, 2
+		}
+		if strings.Contains(release, "microsoft") {
+			return true, 1
+		}
 	}
 
 	// Check for WSL environment variable
-	if os.Getenv("WSL_DISTRO_NAME") != "" {
-		return true
+	if env.getenv("WSL_DISTRO_NAME") != "" {
+		return true, 0
 	}
 
 	// Check /proc/version for Microsoft/WSL indicators
-	if content, err := os.ReadFile("/proc/version"); err == nil {
+	if content, err := env.readFile("/proc/version"); err == nil {
 		versionStr := strings.ToLower(string(content))
 		if strings.Contains(versionStr, "microsoft") || strings.Contains(versionStr, "wsl") {
-			return true
+			return true, 0
 		}
 	}
 
-	// Check kernel release information
-	if content, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
-		releaseStr := strings.ToLower(string(content))
-		if strings.Contains(releaseStr, "microsoft") || strings.Contains(releaseStr, "wsl") {
-			return true
-		}
+	// Check for typical WSL mount point
+	if env.exists("/mnt/c") {
+		return true, 0
 	}
 
-	// Check for typical WSL mount point
-	if _, err := os.Stat("/mnt/c"); err == nil {
+	return false, 0
+}
+
+// containerCgroupHints are found in /proc/1/cgroup inside containers
+var containerCgroupHints = []string{"docker", "kubepods", "containerd", "libpod", "lxc"}
+
+// detectContainer checks if the current process is running in a container, such as
+// Docker, Podman, LXC, systemd-nspawn or a Kubernetes pod
+func detectContainer(osName string, env *detectionEnv) bool {
+	if osName != "linux" {
+		return false
+	}
+
+	// Docker and Podman leave a marker file in the root of the container
+	if env.exists("/.dockerenv") || env.exists("/run/.containerenv") {
 		return true
 	}
 
+	// systemd-nspawn, LXC and Podman set container for the init process
+	if env.getenv("container") != "" || env.getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+
+	// The cgroup of init names the container runtime on cgroup v1 hosts
+	if content, err := env.readFile("/proc/1/cgroup"); err == nil {
+		cgroup := string(content)
+		for _, hint := range containerCgroupHints {
+			if strings.Contains(cgroup, hint) {
+				return true
+			}
+		}
+	}
+
 	return false
 }
 
@@ -477,6 +616,12 @@ func GetPackageManagerInstallCommand(manager string) string {
 		return "xbps-install"
 	case "apk":
 		return "apk add"
+	case "flatpak":
+		return "flatpak install flathub"
+	case "pipx":
+		return "pipx install"
+	case "npm":
+		return "npm install -g"
 	default:
 		return ""
 	}
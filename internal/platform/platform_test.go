@@ -0,0 +1,164 @@
+package platform
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// fakeEnv returns detection inputs with the given files, environment variables and
+// paths that exist
+func fakeEnv(files map[string]string, vars map[string]string, paths ...string) *detectionEnv {
+	return &detectionEnv{
+		readFile: func(path string) ([]byte, error) {
+			if content, exists := files[path]; exists {
+				return []byte(content), nil
+			}
+			return nil, os.ErrNotExist
+		},
+		getenv: func(key string) string {
+			return vars[key]
+		},
+		exists: func(path string) bool {
+			for _, p := range paths {
+				if p == path {
+					return true
+				}
+			}
+			_, exists := files[path]
+			return exists
+		},
+		lookPath: func(file string) (string, error) {
+			return "", exec.ErrNotFound
+		},
+		output: func(name string, args ...string) (string, error) {
+			return "", exec.ErrNotFound
+		},
+	}
+}
+
+func TestDetectWSL(t *testing.T) {
+	tests := []struct {
+		name    string
+		osName  string
+		env     *detectionEnv
+		wantWSL bool
+		version int
+	}{
+		{
+			name:    "WSL 2 kernel",
+			osName:  "linux",
+			env:     fakeEnv(map[string]string{"/proc/sys/kernel/osrelease": "5.15.90.1-microsoft-standard-WSL2\n"}, nil),
+			wantWSL: true,
+			version: 2,
+		},
+		{
+			name:    "WSL 1 kernel",
+			osName:  "linux",
+			env:     fakeEnv(map[string]string{"/proc/sys/kernel/osrelease": "4.4.0-19041-Microsoft\n"}, nil),
+			wantWSL: true,
+			version: 1,
+		},
+		{
+			name:    "distro name without kernel hint",
+			osName:  "linux",
+			env:     fakeEnv(nil, map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}),
+			wantWSL: true,
+		},
+		{
+			name:   "plain Linux",
+			osName: "linux",
+			env:    fakeEnv(map[string]string{"/proc/sys/kernel/osrelease": "6.8.0-45-generic\n", "/proc/version": "Linux version 6.8.0-45-generic"}, nil),
+		},
+		{
+			name:   "not Linux",
+			osName: "windows",
+			env:    fakeEnv(nil, map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isWSL, version := detectWSL(tt.osName, tt.env)
+			if isWSL != tt.wantWSL || version != tt.version {
+				t.Errorf("detectWSL() = %v, %d, want %v, %d", isWSL, version, tt.wantWSL, tt.version)
+			}
+		})
+	}
+}
+
+func TestDetectContainer(t *testing.T) {
+	tests := []struct {
+		name   string
+		osName string
+		env    *detectionEnv
+		want   bool
+	}{
+		{"docker marker", "linux", fakeEnv(nil, nil, "/.dockerenv"), true},
+		{"podman marker", "linux", fakeEnv(nil, nil, "/run/.containerenv"), true},
+		{"systemd-nspawn", "linux", fakeEnv(nil, map[string]string{"container": "systemd-nspawn"}), true},
+		{"kubernetes", "linux", fakeEnv(nil, map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"}), true},
+		{"docker cgroup", "linux", fakeEnv(map[string]string{"/proc/1/cgroup": "12:pids:/docker/3f2a9c\n"}, nil), true},
+		{"host cgroup", "linux", fakeEnv(map[string]string{"/proc/1/cgroup": "0::/init.scope\n"}, nil), false},
+		{"not Linux", "darwin", fakeEnv(nil, nil, "/.dockerenv"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectContainer(tt.osName, tt.env); got != tt.want {
+				t.Errorf("detectContainer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindBrew(t *testing.T) {
+	inPath := fakeEnv(nil, map[string]string{"HOME": "/home/menno"}, "/home/linuxbrew/.linuxbrew/bin/brew")
+	inPath.lookPath = func(file string) (string, error) {
+		return "/usr/local/bin/" + file, nil
+	}
+
+	tests := []struct {
+		name   string
+		osName string
+		env    *detectionEnv
+		want   string
+	}{
+		{"in PATH", "linux", inPath, "/usr/local/bin/brew"},
+		{"linuxbrew prefix", "linux", fakeEnv(nil, map[string]string{"HOME": "/home/menno"}, "/home/linuxbrew/.linuxbrew/bin/brew"), "/home/linuxbrew/.linuxbrew/bin/brew"},
+		{"linuxbrew in home", "linux", fakeEnv(nil, map[string]string{"HOME": "/home/menno"}, "/home/menno/.linuxbrew/bin/brew"), "/home/menno/.linuxbrew/bin/brew"},
+		{"Apple silicon prefix", "darwin", fakeEnv(nil, nil, "/opt/homebrew/bin/brew"), "/opt/homebrew/bin/brew"},
+		{"macOS prefix on Linux", "linux", fakeEnv(nil, nil, "/opt/homebrew/bin/brew"), ""},
+		{"not installed", "linux", fakeEnv(nil, nil), ""},
+		{"Windows", "windows", inPath, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findBrew(tt.osName, tt.env); got != tt.want {
+				t.Errorf("findBrew() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectHomebrewPrefix(t *testing.T) {
+	env := fakeEnv(nil, nil, "/home/linuxbrew/.linuxbrew/bin/brew")
+	if got := detectHomebrewPrefix("linux", env); got != "/home/linuxbrew/.linuxbrew" {
+		t.Errorf("detectHomebrewPrefix() without brew --prefix = %q, want the prefix brew is in", got)
+	}
+
+	env.output = func(name string, args ...string) (string, error) {
+		if name != "/home/linuxbrew/.linuxbrew/bin/brew" || len(args) != 1 || args[0] != "--prefix" {
+			t.Errorf("ran %s %v, want brew --prefix", name, args)
+		}
+		return "/home/linuxbrew/custom\n", nil
+	}
+	if got := detectHomebrewPrefix("linux", env); got != "/home/linuxbrew/custom" {
+		t.Errorf("detectHomebrewPrefix() = %q, want what brew --prefix prints", got)
+	}
+
+	if got := detectHomebrewPrefix("linux", fakeEnv(nil, nil)); got != "" {
+		t.Errorf("detectHomebrewPrefix() without Homebrew = %q, want empty", got)
+	}
+}
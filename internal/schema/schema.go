@@ -0,0 +1,262 @@
+package schema
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/jobs"
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+)
+
+// Draft is the JSON Schema version of the generated schemas, the one YAML language
+// servers support best
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// Schema is a JSON Schema document, or a schema within one
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"` // false, or the schema of the other properties
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// Encode writes a schema as indented JSON. Properties are sorted by name, so the
+// same schema is always written the same way.
+func Encode(w io.Writer, s *Schema) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// ref returns a schema referring to a definition of the document
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/definitions/" + name}
+}
+
+// names returns the schema of a field holding a single name or a list of names,
+// like profiles, tags and notify
+func names(description string) *Schema {
+	return &Schema{Description: description, AnyOf: []*Schema{{Type: "string"}, {Type: "array", Items: &Schema{Type: "string"}}}}
+}
+
+// importDescriptions describe the fields of config.ImportFile by their YAML name
+var importDescriptions = map[string]string{
+	"path":      "File to import, relative to the importing file or to the root of repo. May use templates",
+	"repo":      "Git repository the file is in",
+	"ref":       "Branch, tag or commit of repo",
+	"condition": "Only import the file when the condition is true",
+	"profiles":  "Only import the file when one of these profiles is selected",
+	"variables": "Variables of the import, for the jobs and variables it contains",
+}
+
+// importSpecType is the type of an import as written, a path or an import object
+var importSpecType = reflect.TypeOf((*config.ImportSpec)(nil)).Elem()
+
+// structSchema returns the schema of the object a struct is decoded from, with a
+// property per field by its YAML name. descriptions describe the fields.
+func structSchema(t reflect.Type, descriptions map[string]string) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		property := typeSchema(field.Type)
+		property.Description = descriptions[name]
+		s.Properties[name] = property
+	}
+	return s
+}
+
+// typeSchema returns the schema of the values a Go type is decoded from
+func typeSchema(t reflect.Type) *Schema {
+	switch {
+	case t == importSpecType:
+		return &Schema{AnyOf: []*Schema{{Type: "string"}, ref("import")}}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		// Lists of names are parsed with config.ParseProfiles and friends, which take a single name too
+		return names("")
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	default:
+		return &Schema{}
+	}
+}
+
+// importSchema returns the schema of an import object, from config.ImportFile
+func importSchema() *Schema {
+	s := structSchema(reflect.TypeOf(config.ImportFile{}), importDescriptions)
+	s.Description = "A file to import"
+	s.Required = []string{"path"} // config.NormalizeImports rejects imports without one
+	return s
+}
+
+// Variables returns the schema of variables/index.yaml, from config.VariableIndex
+func Variables() *Schema {
+	s := structSchema(reflect.TypeOf(config.VariableIndex{}), map[string]string{
+		"imports":   "Variable files to load, in order. Later files override earlier ones",
+		"variables": "Variables defined in the index itself",
+	})
+	s.Schema = Draft
+	s.Title = "dotfiles variables index"
+	s.Description = "variables/index.yaml of a dotfiles repository"
+	s.Definitions = map[string]*Schema{"import": importSchema()}
+	return s
+}
+
+// taskProperties are the properties every task has besides the parameters of its
+// action, as the jobs parser extracts them
+func taskProperties() map[string]*Schema {
+	return map[string]*Schema{
+		"condition":     {Type: "string", Description: "Only run the task when the condition is true"},
+		"timeout":       {Type: "string", Description: "Stop the task when it runs longer, e.g. \"5m\""},
+		"retries":       {Type: "integer", Description: "Times the task runs again after failing"},
+		"retry_delay":   {Type: "string", Description: "Wait before running the task again, e.g. \"10s\""},
+		"retry_backoff": {Type: "boolean", Description: "Double the wait after every retry"},
+		"become":        {Type: "boolean", Description: "Run the task with root or Administrator rights"},
+		"profiles":      names("Only run the task when one of these profiles is selected"),
+		"tags":          names("Tags to select the task with --tags and --skip-tags"),
+		"notify":        names("Handlers to run when the task changes something"),
+	}
+}
+
+// handlerProperties are the task properties handlers support
+var handlerProperties = []string{"timeout", "retries", "retry_delay", "retry_backoff", "become"}
+
+// parameterSchema returns the schema of an action parameter from its documented type
+func parameterSchema(parameter modules.ActionParameter) *Schema {
+	var s *Schema
+	switch strings.ToLower(parameter.Type) {
+	case "string":
+		s = &Schema{Type: "string"}
+	case "bool", "boolean":
+		s = &Schema{Type: "boolean"}
+	case "int", "integer":
+		s = &Schema{Type: "integer"}
+	case "[]string":
+		s = &Schema{Type: "array", Items: &Schema{Type: "string"}}
+	case "[]object":
+		s = &Schema{Type: "array", Items: &Schema{Type: "object"}}
+	case "array":
+		s = &Schema{Type: "array"}
+	case "map", "object":
+		s = &Schema{Type: "object"}
+	case "map[string]string":
+		s = &Schema{Type: "object", AdditionalProperties: &Schema{Type: "string"}}
+	case "string or []string", "string|array":
+		s = names("")
+	default:
+		s = &Schema{}
+	}
+	s.Description = parameter.Description
+	return s
+}
+
+// actionSchema returns the schema of a task of an action. Parameters that are not
+// documented are allowed, modules ignore what they do not use.
+func actionSchema(action *modules.ActionDocumentation) *Schema {
+	s := &Schema{Type: "object", Description: action.Description, Properties: taskProperties()}
+	for _, parameter := range action.Parameters {
+		s.Properties[parameter.Name] = parameterSchema(parameter)
+		if parameter.Required {
+			s.Required = append(s.Required, parameter.Name)
+		}
+	}
+	return s
+}
+
+// handlerSchema returns the schema of a handler configuration, a run_command task
+// that gets its name from the handler
+func handlerSchema(runCommand *modules.ActionDocumentation) *Schema {
+	s := &Schema{Type: "object", Description: "A run_command configuration without name", Properties: make(map[string]*Schema)}
+	common := taskProperties()
+	for _, name := range handlerProperties {
+		s.Properties[name] = common[name]
+	}
+	if runCommand == nil {
+		return s
+	}
+	for _, parameter := range runCommand.Parameters {
+		if parameter.Name == "name" {
+			continue
+		}
+		s.Properties[parameter.Name] = parameterSchema(parameter)
+		if parameter.Required {
+			s.Required = append(s.Required, parameter.Name)
+		}
+	}
+	return s
+}
+
+// Jobs returns the schema of jobs files, jobs/index.yaml and the files it imports,
+// for the actions documented by the registered modules
+func Jobs(actions []*modules.ActionDocumentation) *Schema {
+	sorted := append([]*modules.ActionDocumentation(nil), actions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Action < sorted[j].Action })
+
+	s := &Schema{
+		Schema:      Draft,
+		Title:       "dotfiles jobs file",
+		Description: "jobs/index.yaml of a dotfiles repository, or a jobs file it imports",
+		Type:        "object",
+		Properties: map[string]*Schema{
+			"imports": {
+				Type:        "array",
+				Description: "Jobs files to import. Their jobs run before the jobs of this file",
+				Items:       &Schema{AnyOf: []*Schema{{Type: "string"}, ref("import")}},
+			},
+			"handlers": {
+				Type:                 "object",
+				Description:          "Commands that run once after the tasks notifying them changed something, by name",
+				AdditionalProperties: &Schema{AnyOf: []*Schema{{Type: "string"}, ref("handler")}},
+			},
+		},
+		AdditionalProperties: false,
+		Definitions:          map[string]*Schema{"import": importSchema()},
+	}
+
+	var runCommand *modules.ActionDocumentation
+	for _, action := range sorted {
+		if action.Action == "run_command" {
+			runCommand = action
+		}
+		s.Definitions[action.Action] = actionSchema(action)
+
+		// An action takes one task, or a list of them
+		task := []*Schema{ref(action.Action)}
+		if _, ok := jobs.ShorthandKey(action.Action); ok {
+			task = append(task, &Schema{Type: "string"})
+		}
+		s.Properties[action.Action] = &Schema{
+			Description: action.Description,
+			AnyOf:       append(task, &Schema{Type: "array", Items: &Schema{AnyOf: task}}),
+		}
+	}
+	s.Definitions["handler"] = handlerSchema(runCommand)
+
+	return s
+}
@@ -0,0 +1,137 @@
+package schema
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/modules"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testActions() []*modules.ActionDocumentation {
+	return []*modules.ActionDocumentation{
+		{
+			Action: "symlink",
+			Parameters: []modules.ActionParameter{
+				{Name: "src", Type: "string", Required: true},
+				{Name: "dst", Type: "string", Required: true},
+			},
+		},
+		{
+			Action: "install_package",
+			Parameters: []modules.ActionParameter{
+				{Name: "name", Type: "string", Required: true},
+				{Name: "prefer", Type: "[]string"},
+			},
+		},
+		{
+			Action: "run_command",
+			Parameters: []modules.ActionParameter{
+				{Name: "name", Type: "string", Required: true},
+				{Name: "command", Type: "string", Required: true},
+			},
+		},
+	}
+}
+
+func TestJobsIsDeterministic(t *testing.T) {
+	actions := testActions()
+	reversed := []*modules.ActionDocumentation{actions[2], actions[1], actions[0]}
+
+	var first, second bytes.Buffer
+	if err := Encode(&first, Jobs(actions)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&second, Jobs(reversed)); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("schema depends on the order of the actions:\n%s\n%s", first.String(), second.String())
+	}
+}
+
+func TestValidateJobs(t *testing.T) {
+	s := Jobs(testActions())
+
+	tests := []struct {
+		name     string
+		document string
+		want     []string
+	}{
+		{
+			name: "valid",
+			document: `imports:
+  - shell.yaml
+  - path: "platforms/{{ .Platform.OS }}.yaml"
+    profiles: work
+install_package:
+  - git
+  - name: code
+    prefer: [winget]
+    tags: [editor]
+    retries: 2
+symlink:
+  src: files/tmux.conf
+  dst: ~/.tmux.conf
+handlers:
+  reload: tmux source-file ~/.tmux.conf
+  restart:
+    command: systemctl restart app
+    become: true
+`,
+		},
+		{
+			name:     "unknown action",
+			document: "install: [git]\n",
+			want:     []string{"document: unknown property 'install'"},
+		},
+		{
+			name:     "missing parameter",
+			document: "symlink:\n  - src: files/a\n",
+			want:     []string{"symlink.0: missing required property 'dst'"},
+		},
+		{
+			name:     "wrong type",
+			document: "install_package:\n  - name: git\n    retries: lots\n",
+			want:     []string{"install_package.0.retries: expected integer, got string"},
+		},
+		{
+			name:     "no shorthand",
+			document: "symlink: files/a\n",
+			want:     []string{"symlink: expected object or array, got string"},
+		},
+		{
+			name:     "import without path",
+			document: "imports:\n  - condition: \"true\"\n",
+			want:     []string{"imports.0: missing required property 'path'"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var document interface{}
+			if err := yaml.Unmarshal([]byte(tt.document), &document); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Validate(document); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariables(t *testing.T) {
+	s := Variables()
+
+	var document interface{}
+	if err := yaml.Unmarshal([]byte("imports:\n  - global.yaml\nvariables:\n  user:\n    name: menno\nvars: {}\n"), &document); err != nil {
+		t.Fatal(err)
+	}
+	got := s.Validate(document)
+	if len(got) != 1 || !strings.Contains(got[0], "unknown property 'vars'") {
+		t.Errorf("Validate() = %q, want only the unknown property vars", got)
+	}
+}
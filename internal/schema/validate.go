@@ -0,0 +1,143 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Validate checks a document decoded from YAML or JSON against the schema, with
+// the keywords the schemas of this package use, and returns every mismatch as
+// "path: problem" with the dotted path of the value
+func (s *Schema) Validate(document interface{}) []string {
+	var problems []string
+	s.validate(s, document, "", &problems)
+	return problems
+}
+
+// validate checks value at path against s, resolving references in root
+func (s *Schema) validate(root *Schema, value interface{}, path string, problems *[]string) {
+	report := func(format string, args ...interface{}) {
+		location := path
+		if location == "" {
+			location = "document"
+		}
+		*problems = append(*problems, fmt.Sprintf("%s: %s", location, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Ref != "" {
+		definition := root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+		if definition == nil {
+			report("unknown reference %s", s.Ref)
+			return
+		}
+		definition.validate(root, value, path, problems)
+		return
+	}
+
+	if len(s.AnyOf) > 0 {
+		var types []string
+		for _, option := range s.AnyOf {
+			var optionProblems []string
+			option.validate(root, value, path, &optionProblems)
+			if len(optionProblems) == 0 {
+				return
+			}
+			// A value of the right type reports what is wrong with it, rather than that nothing matches
+			if option.resolve(root).Type == typeOf(value) {
+				*problems = append(*problems, optionProblems...)
+				return
+			}
+			types = append(types, option.resolve(root).Type)
+		}
+		report("expected %s, got %s", strings.Join(types, " or "), typeOf(value))
+		return
+	}
+
+	if s.Type != "" && !typeMatches(s.Type, value) {
+		report("expected %s, got %s", s.Type, typeOf(value))
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, exists := v[name]; !exists {
+				report("missing required property '%s'", name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, exists := s.Properties[key]; exists {
+				property.validate(root, v[key], join(path, key), problems)
+				continue
+			}
+			switch additional := s.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					report("unknown property '%s'", key)
+				}
+			case *Schema:
+				additional.validate(root, v[key], join(path, key), problems)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(root, item, join(path, fmt.Sprint(i)), problems)
+			}
+		}
+	}
+}
+
+// resolve returns the definition a reference refers to, or s when it is none
+func (s *Schema) resolve(root *Schema) *Schema {
+	if definition := root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]; s.Ref != "" && definition != nil {
+		return definition
+	}
+	return s
+}
+
+// join appends a key to a dotted path
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// typeOf returns the JSON Schema type of a decoded value
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, uint64:
+		return "integer"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// typeMatches reports whether a value is of a JSON Schema type
+func typeMatches(expected string, value interface{}) bool {
+	actual := typeOf(value)
+	return actual == expected || (expected == "number" && actual == "integer")
+}
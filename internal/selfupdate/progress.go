@@ -0,0 +1,52 @@
+package selfupdate
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is how often download progress is written
+const progressInterval = 200 * time.Millisecond
+
+// progressWriter counts the bytes written to it and writes how far a download is,
+// e.g. "Downloading dotfiles-linux-amd64: 4.2 MB / 9.8 MB (42%)", updated in place
+type progressWriter struct {
+	out     io.Writer
+	name    string
+	total   int64
+	written int64
+	drawn   time.Time
+}
+
+func newProgressWriter(out io.Writer, name string, total int64) *progressWriter {
+	return &progressWriter{out: out, name: name, total: total}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if time.Since(p.drawn) >= progressInterval {
+		p.draw()
+	}
+	return len(b), nil
+}
+
+// finish writes the final progress and ends its line
+func (p *progressWriter) finish() {
+	p.draw()
+	fmt.Fprintln(p.out)
+}
+
+func (p *progressWriter) draw() {
+	p.drawn = time.Now()
+	if p.total > 0 {
+		fmt.Fprintf(p.out, "\rDownloading %s: %s / %s (%d%%)", p.name, formatSize(p.written), formatSize(p.total), p.written*100/p.total)
+		return
+	}
+	fmt.Fprintf(p.out, "\rDownloading %s: %s", p.name, formatSize(p.written))
+}
+
+// formatSize formats a number of bytes in MB with one decimal
+func formatSize(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1000*1000))
+}
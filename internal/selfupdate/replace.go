@@ -0,0 +1,39 @@
+package selfupdate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Executable returns the path of the running binary, with symlinks resolved so the
+// binary itself is replaced rather than a link to it
+func Executable() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the dotfiles executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	return executable, nil
+}
+
+// Replace replaces the binary at executable with data, keeping its permissions.
+// The running process keeps running the old binary, the next run starts the new
+// one.
+func Replace(executable string, data []byte) error {
+	info, err := os.Stat(executable)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", executable, err)
+	}
+	if err := replaceExecutable(executable, data, info.Mode().Perm()); err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return fmt.Errorf("failed to replace %s: %w (run the update as the owner of the binary, e.g. with sudo)", executable, err)
+		}
+		return fmt.Errorf("failed to replace %s: %w", executable, err)
+	}
+	return nil
+}
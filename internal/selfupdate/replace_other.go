@@ -0,0 +1,15 @@
+//go:build !windows
+
+package selfupdate
+
+import (
+	"os"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// replaceExecutable renames the new binary over the old one. The running process
+// keeps the old binary open, so it is replaced while it runs.
+func replaceExecutable(executable string, data []byte, perm os.FileMode) error {
+	return utils.WriteFileAtomic(executable, data, perm)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package selfupdate
+
+import (
+	"os"
+)
+
+// replaceExecutable moves the binary aside and puts the new one in its place.
+// Windows does not replace or remove the binary of a running process, but does
+// rename it. The old binary is removed by the next update, once it no longer runs.
+func replaceExecutable(executable string, data []byte, perm os.FileMode) error {
+	old := executable + ".old"
+	os.Remove(old)
+
+	next := executable + ".new"
+	if err := os.WriteFile(next, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(executable, old); err != nil {
+		os.Remove(next)
+		return err
+	}
+	if err := os.Rename(next, executable); err != nil {
+		// Put the old binary back, dotfiles has to keep working
+		os.Rename(old, executable)
+		os.Remove(next)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// DefaultAPIURL is the GitHub API of the repository dotfiles is released from
+const DefaultAPIURL = "https://api.github.com/repos/vleeuwenmenno/dotfiles-cp"
+
+// ChecksumsAsset is the release asset listing the SHA256 of every binary, in the
+// format of sha256sum
+const ChecksumsAsset = "SHA256SUMS"
+
+// requestTimeout limits every request of an update, downloads included
+const requestTimeout = 5 * time.Minute
+
+// ErrNoAsset is returned when a release has no binary for the platform
+var ErrNoAsset = errors.New("no release binary for this platform")
+
+// Release is a GitHub release
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// Asset returns the asset of the release with name, nil when there is none
+func (r *Release) Asset(name string) *Asset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// AssetName returns the name of the release binary for a platform, as build.go
+// names it, e.g. dotfiles-linux-amd64 or dotfiles-windows-arm64.exe
+func AssetName(goos, goarch string) string {
+	name := "dotfiles-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// IsNewer reports whether release version latest is newer than current. Builds
+// without a release version, like dev, are always older.
+func IsNewer(latest, current string) bool {
+	if current == "" || current == "dev" {
+		return true
+	}
+	return utils.CompareVersions(latest, current) > 0
+}
+
+// Updater finds and downloads releases
+type Updater struct {
+	APIURL   string       // GitHub API of the repository, DefaultAPIURL by default
+	Token    string       // GitHub token sent with API requests, raising the rate limit
+	Client   *http.Client // Client requests are made with
+	Progress io.Writer    // Where download progress is written, nil for none
+}
+
+// NewUpdater returns an updater for the releases of dotfiles that authenticates
+// with GITHUB_TOKEN when it is set
+func NewUpdater() *Updater {
+	return &Updater{
+		APIURL: DefaultAPIURL,
+		Token:  os.Getenv("GITHUB_TOKEN"),
+		Client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Release returns the release with tag version, or the latest release when
+// version is empty. A version without the leading v is found too.
+func (u *Updater) Release(ctx context.Context, version string) (*Release, error) {
+	path := "/releases/latest"
+	if version != "" {
+		if !strings.HasPrefix(version, "v") {
+			version = "v" + version
+		}
+		path = "/releases/tags/" + version
+	}
+
+	resp, err := u.get(ctx, u.APIURL+path, "application/vnd.github+json", true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && version != "":
+		return nil, fmt.Errorf("release %s does not exist", version)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.New("no release has been published yet")
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("GitHub refused the request (%s), set GITHUB_TOKEN to raise the rate limit", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to look up the release: GitHub returned %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to read the release: %w", err)
+	}
+	return &release, nil
+}
+
+// Download downloads the binary of release for a platform and verifies it against
+// the SHA256SUMS of the release. It returns ErrNoAsset when the release has no
+// binary for the platform. Releases without SHA256SUMS are refused.
+func (u *Updater) Download(ctx context.Context, release *Release, goos, goarch string) ([]byte, error) {
+	name := AssetName(goos, goarch)
+	asset := release.Asset(name)
+	if asset == nil {
+		return nil, fmt.Errorf("%w: %s has no %s", ErrNoAsset, release.Tag, name)
+	}
+	sumsAsset := release.Asset(ChecksumsAsset)
+	if sumsAsset == nil {
+		return nil, fmt.Errorf("%s has no %s to verify the download with", release.Tag, ChecksumsAsset)
+	}
+
+	sums, err := u.download(ctx, sumsAsset, false)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := checksum(sums, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s of %s: %w", ChecksumsAsset, release.Tag, err)
+	}
+
+	data, err := u.download(ctx, asset, true)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", name, expected, actual)
+	}
+	return data, nil
+}
+
+// checksum returns the SHA256 of name in the output of sha256sum
+func checksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Binary mode marks the name with a *
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// download downloads an asset, reporting progress when showProgress is set
+func (u *Updater) download(ctx context.Context, asset *Asset, showProgress bool) ([]byte, error) {
+	resp, err := u.get(ctx, asset.URL, "application/octet-stream", false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: server returned %s", asset.Name, resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	if showProgress && u.Progress != nil {
+		total := resp.ContentLength
+		if total <= 0 {
+			total = asset.Size
+		}
+		progress := newProgressWriter(u.Progress, asset.Name, total)
+		defer progress.finish()
+		body = io.TeeReader(resp.Body, progress)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	return data, nil
+}
+
+// get requests url. The token is only sent to the API, downloads are redirected
+// to other hosts.
+func (u *Updater) get(ctx context.Context, url, accept string, api bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	req.Header.Set("Accept", accept)
+	if api && u.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s: %w", url, err)
+	}
+	return resp, nil
+}
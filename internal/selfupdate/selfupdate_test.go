@@ -0,0 +1,172 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// releaseServer serves the GitHub API of a repository with release v1.2.0, which
+// has a binary for linux/amd64. sums is its SHA256SUMS, "" for the right one.
+func releaseServer(t *testing.T, binary []byte, sums string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var authorizations []string
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	if sums == "" {
+		sum := sha256.Sum256(binary)
+		sums = hex.EncodeToString(sum[:]) + "  dotfiles-linux-amd64\n"
+	}
+	release := Release{Tag: "v1.2.0", Assets: []Asset{
+		{Name: "dotfiles-linux-amd64", URL: server.URL + "/download/dotfiles-linux-amd64", Size: int64(len(binary))},
+		{Name: ChecksumsAsset, URL: server.URL + "/download/SHA256SUMS"},
+	}}
+	serveRelease := func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(release)
+	}
+	mux.HandleFunc("/releases/latest", serveRelease)
+	mux.HandleFunc("/releases/tags/v1.2.0", serveRelease)
+	mux.HandleFunc("/download/dotfiles-linux-amd64", func(w http.ResponseWriter, r *http.Request) { w.Write(binary) })
+	mux.HandleFunc("/download/SHA256SUMS", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(sums)) })
+	return server, &authorizations
+}
+
+func TestRelease(t *testing.T) {
+	server, authorizations := releaseServer(t, []byte("binary"), "")
+	updater := &Updater{APIURL: server.URL, Token: "secret"}
+
+	for _, version := range []string{"", "v1.2.0", "1.2.0"} {
+		release, err := updater.Release(context.Background(), version)
+		if err != nil {
+			t.Fatalf("Release(%q) error: %v", version, err)
+		}
+		if release.Tag != "v1.2.0" || len(release.Assets) != 2 {
+			t.Errorf("Release(%q) = %+v, want v1.2.0 with 2 assets", version, release)
+		}
+	}
+	for _, authorization := range *authorizations {
+		if authorization != "Bearer secret" {
+			t.Errorf("Authorization = %q, want the token", authorization)
+		}
+	}
+
+	if _, err := updater.Release(context.Background(), "v9.9.9"); err == nil || !strings.Contains(err.Error(), "v9.9.9 does not exist") {
+		t.Errorf("Release() of a missing version error = %v, want it reported missing", err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho new\n")
+	server, _ := releaseServer(t, binary, "")
+	var progress bytes.Buffer
+	updater := &Updater{APIURL: server.URL, Progress: &progress}
+	release, err := updater.Release(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := updater.Download(context.Background(), release, "linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, binary) {
+		t.Errorf("Download() = %q, want %q", data, binary)
+	}
+	if !strings.Contains(progress.String(), "Downloading dotfiles-linux-amd64") {
+		t.Errorf("progress = %q, want the download reported", progress.String())
+	}
+
+	if _, err := updater.Download(context.Background(), release, "freebsd", "amd64"); !errors.Is(err, ErrNoAsset) {
+		t.Errorf("Download() for a platform without binary error = %v, want %v", err, ErrNoAsset)
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	for name, sums := range map[string]string{
+		"mismatch": strings.Repeat("0", 64) + "  dotfiles-linux-amd64\n",
+		"missing":  strings.Repeat("0", 64) + "  dotfiles-darwin-arm64\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, _ := releaseServer(t, []byte("binary"), sums)
+			updater := &Updater{APIURL: server.URL}
+			release, err := updater.Release(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := updater.Download(context.Background(), release, "linux", "amd64"); err == nil {
+				t.Errorf("Download() = %q, want the checksum to fail", data)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	sums := []byte("ABC123  dotfiles-linux-amd64\ndef456 *dotfiles-windows-amd64.exe\n")
+	if sum, err := checksum(sums, "dotfiles-linux-amd64"); err != nil || sum != "abc123" {
+		t.Errorf("checksum() = %q, %v, want abc123", sum, err)
+	}
+	if sum, err := checksum(sums, "dotfiles-windows-amd64.exe"); err != nil || sum != "def456" {
+		t.Errorf("checksum() of a binary mode entry = %q, %v, want def456", sum, err)
+	}
+}
+
+func TestAssetName(t *testing.T) {
+	if name := AssetName("linux", "arm64"); name != "dotfiles-linux-arm64" {
+		t.Errorf("AssetName() = %q", name)
+	}
+	if name := AssetName("windows", "amd64"); name != "dotfiles-windows-amd64.exe" {
+		t.Errorf("AssetName() on windows = %q", name)
+	}
+}
+
+func TestIsNewer(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		want            bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.2.0", "1.2.0", false},
+		{"v1.2.0", "v1.3.0", false},
+		{"v1.2.0", "dev", true},
+	}
+	for _, tt := range tests {
+		if got := IsNewer(tt.latest, tt.current); got != tt.want {
+			t.Errorf("IsNewer(%q, %q) = %v, want %v", tt.latest, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	executable := filepath.Join(t.TempDir(), "dotfiles")
+	if err := os.WriteFile(executable, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Replace(executable, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(executable); string(data) != "new" {
+		t.Errorf("executable = %q, want the new binary", data)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %v, want the mode of the old binary", info.Mode().Perm())
+	}
+}
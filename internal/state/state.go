@@ -0,0 +1,223 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the name of the state file in the state directory of a repository
+const File = "state.yaml"
+
+// Kind is what apply put at a managed path
+type Kind string
+
+const (
+	KindFile    Kind = "file"
+	KindSymlink Kind = "symlink"
+)
+
+// Status is how a path compares to what apply put there
+type Status string
+
+const (
+	StatusUnchanged Status = "unchanged" // Still what apply put there
+	StatusModified  Status = "modified"  // Changed since, e.g. edited by the user
+	StatusGone      Status = "gone"      // Removed since
+)
+
+// Entry is a file or symlink put in place by a task
+type Entry struct {
+	Path       string `yaml:"path" json:"path"`
+	Kind       Kind   `yaml:"kind" json:"kind"`
+	Task       string `yaml:"task" json:"task"` // Name of the task that put the path in place
+	SHA256     string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
+	LinkTarget string `yaml:"link_target,omitempty" json:"link_target,omitempty"`
+}
+
+// State records every path the jobs put in place on this machine, so paths left
+// behind by removed or renamed tasks can be cleaned up later
+type State struct {
+	UpdatedAt time.Time `yaml:"updated_at"`
+	Entries   []*Entry  `yaml:"entries"`
+
+	path string
+}
+
+// Path returns the path of the state file of a dotfiles repository
+func Path(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), File)
+}
+
+// Load reads the state of a dotfiles repository. A repository that was never
+// applied has an empty state.
+func Load(basePath string) (*State, error) {
+	s := &State{Entries: []*Entry{}, path: Path(basePath)}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state %s: %w", s.path, err)
+	}
+	return s, nil
+}
+
+// Record saves what is at a path now as put in place by a task. Paths that do not
+// exist, e.g. because the task failed, are forgotten.
+func (s *State) Record(task, path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	s.Forget(path)
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	entry := &Entry{Path: path, Task: task}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		entry.Kind = KindSymlink
+		if entry.LinkTarget, err = os.Readlink(path); err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", path, err)
+		}
+	case info.Mode().IsRegular():
+		entry.Kind = KindFile
+		if entry.SHA256, err = hashFile(path); err != nil {
+			return fmt.Errorf("failed to hash %s: %w", path, err)
+		}
+	default:
+		return nil // Only files and symlinks are cleaned up
+	}
+
+	s.Entries = append(s.Entries, entry)
+	return nil
+}
+
+// Forget removes a path from the state
+func (s *State) Forget(path string) {
+	entries := s.Entries[:0]
+	for _, entry := range s.Entries {
+		if entry.Path != path {
+			entries = append(entries, entry)
+		}
+	}
+	s.Entries = entries
+}
+
+// Orphans returns the entries whose path is not in managed, the absolute paths the
+// current jobs put in place
+func (s *State) Orphans(managed map[string]bool) []*Entry {
+	var orphans []*Entry
+	for _, entry := range s.Entries {
+		if !managed[entry.Path] {
+			orphans = append(orphans, entry)
+		}
+	}
+	return orphans
+}
+
+// Save writes the state to disk, replacing the file atomically
+func (s *State) Save(now time.Time) error {
+	s.UpdatedAt = now
+	sort.Slice(s.Entries, func(i, j int) bool {
+		return s.Entries[i].Path < s.Entries[j].Path
+	})
+
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	if err := utils.EnsureDir(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// Check compares a path with what apply put there
+func (e *Entry) Check() (Status, error) {
+	info, err := os.Lstat(e.Path)
+	if os.IsNotExist(err) {
+		return StatusGone, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", e.Path, err)
+	}
+
+	switch e.Kind {
+	case KindSymlink:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return StatusModified, nil
+		}
+		target, err := os.Readlink(e.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink %s: %w", e.Path, err)
+		}
+		if target != e.LinkTarget {
+			return StatusModified, nil
+		}
+	case KindFile:
+		if !info.Mode().IsRegular() {
+			return StatusModified, nil
+		}
+		hash, err := hashFile(e.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash %s: %w", e.Path, err)
+		}
+		if hash != e.SHA256 {
+			return StatusModified, nil
+		}
+	default:
+		return "", fmt.Errorf("unknown state entry kind '%s'", e.Kind)
+	}
+	return StatusUnchanged, nil
+}
+
+// Remove deletes the path. A symlink is removed, never what it points to.
+func (e *Entry) Remove() error {
+	if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// hashFile returns the hex encoded SHA-256 checksum of a file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
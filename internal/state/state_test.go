@@ -0,0 +1,115 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndSave(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+	home := t.TempDir()
+
+	file := filepath.Join(home, ".vimrc")
+	require.NoError(t, os.WriteFile(file, []byte("set number\n"), 0644))
+
+	s, err := Load(basePath)
+	require.NoError(t, err)
+	require.NoError(t, s.Record("vimrc", file))
+	require.NoError(t, s.Record("missing", filepath.Join(home, "missing")))
+	require.NoError(t, s.Record("dir", home))
+	require.Len(t, s.Entries, 1)
+	assert.Equal(t, KindFile, s.Entries[0].Kind)
+	assert.Equal(t, "vimrc", s.Entries[0].Task)
+
+	// Recording a path again replaces its entry
+	require.NoError(t, s.Record("editor", file))
+	require.Len(t, s.Entries, 1)
+	assert.Equal(t, "editor", s.Entries[0].Task)
+
+	require.NoError(t, s.Save(time.Now()))
+	loaded, err := Load(basePath)
+	require.NoError(t, err)
+	require.Len(t, loaded.Entries, 1)
+	assert.Equal(t, s.Entries[0], loaded.Entries[0])
+}
+
+func TestLoadWithoutState(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	s, err := Load(t.TempDir())
+	require.NoError(t, err)
+	assert.Empty(t, s.Entries)
+}
+
+func TestOrphansAndCheck(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	home := t.TempDir()
+	kept := filepath.Join(home, "kept")
+	removed := filepath.Join(home, "removed")
+	edited := filepath.Join(home, "edited")
+	for _, path := range []string{kept, removed, edited} {
+		require.NoError(t, os.WriteFile(path, []byte("managed\n"), 0644))
+	}
+
+	s, err := Load(t.TempDir())
+	require.NoError(t, err)
+	for _, path := range []string{kept, removed, edited} {
+		require.NoError(t, s.Record("task", path))
+	}
+
+	orphans := s.Orphans(map[string]bool{kept: true})
+	require.Len(t, orphans, 2)
+	assert.Equal(t, removed, orphans[0].Path)
+	assert.Equal(t, edited, orphans[1].Path)
+
+	status, err := orphans[0].Check()
+	require.NoError(t, err)
+	assert.Equal(t, StatusUnchanged, status)
+
+	require.NoError(t, os.WriteFile(edited, []byte("edited\n"), 0644))
+	status, err = orphans[1].Check()
+	require.NoError(t, err)
+	assert.Equal(t, StatusModified, status)
+
+	require.NoError(t, orphans[0].Remove())
+	status, err = orphans[0].Check()
+	require.NoError(t, err)
+	assert.Equal(t, StatusGone, status)
+
+	s.Forget(kept)
+	assert.Len(t, s.Entries, 2)
+}
+
+func TestCheckSymlink(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	home := t.TempDir()
+	source := filepath.Join(home, "source")
+	require.NoError(t, os.WriteFile(source, []byte("content\n"), 0644))
+	link := filepath.Join(home, "link")
+	require.NoError(t, os.Symlink(source, link))
+
+	s, err := Load(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, s.Record("link", link))
+	require.Len(t, s.Entries, 1)
+	assert.Equal(t, KindSymlink, s.Entries[0].Kind)
+	assert.Equal(t, source, s.Entries[0].LinkTarget)
+
+	status, err := s.Entries[0].Check()
+	require.NoError(t, err)
+	assert.Equal(t, StatusUnchanged, status)
+
+	// Removing the symlink leaves the file it points to alone
+	require.NoError(t, s.Entries[0].Remove())
+	assert.NoFileExists(t, link)
+	assert.FileExists(t, source)
+}
@@ -0,0 +1,65 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// SummaryFile is the name of the file in the state directory apply and plan write
+// what they found to, so dotfiles status --porcelain does not have to find it again
+const SummaryFile = "status.json"
+
+// Summary is what the last apply or plan found. It is kept small, status
+// --porcelain reads it on every shell prompt.
+type Summary struct {
+	WrittenAt time.Time `json:"written_at"`
+	Command   string    `json:"command"`          // "apply" or "plan"
+	Commit    string    `json:"commit,omitempty"` // Commit of the dotfiles the jobs were read from
+	Drift     int       `json:"drift"`            // Tasks that would change something, or failed to, when it was written
+}
+
+// SummaryPath returns the path of the summary of a dotfiles repository
+func SummaryPath(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), SummaryFile)
+}
+
+// LoadSummary reads the summary of a dotfiles repository, nil when apply and plan
+// have not written one yet
+func LoadSummary(basePath string) (*Summary, error) {
+	path := SummaryPath(basePath)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status summary: %w", err)
+	}
+	summary := &Summary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return nil, fmt.Errorf("failed to parse status summary %s: %w", path, err)
+	}
+	return summary, nil
+}
+
+// SaveSummary writes the summary of a dotfiles repository, replacing the one
+// written before
+func SaveSummary(basePath string, summary *Summary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status summary: %w", err)
+	}
+	path := SummaryPath(basePath)
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := utils.WriteFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write status summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package state
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+
+	summary, err := LoadSummary(basePath)
+	require.NoError(t, err)
+	assert.Nil(t, summary, "no summary before apply or plan wrote one")
+
+	written := &Summary{WrittenAt: time.Now().Truncate(time.Second), Command: "apply", Commit: "1a2b3c", Drift: 2}
+	require.NoError(t, SaveSummary(basePath, written))
+	summary, err = LoadSummary(basePath)
+	require.NoError(t, err)
+	require.NotNil(t, summary)
+	assert.True(t, written.WrittenAt.Equal(summary.WrittenAt))
+	assert.Equal(t, "apply", summary.Command)
+	assert.Equal(t, "1a2b3c", summary.Commit)
+	assert.Equal(t, 2, summary.Drift)
+
+	require.NoError(t, os.WriteFile(SummaryPath(basePath), []byte("{"), 0644))
+	_, err = LoadSummary(basePath)
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/config"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// TimingsFile is the name of the file in the state directory apply writes how long
+// it and its slowest tasks took to, for dotfiles status --verbose
+const TimingsFile = "timings.json"
+
+// SlowestTasks is how many of the slowest tasks are kept and shown
+const SlowestTasks = 5
+
+// TaskTiming is how long a task took to plan and execute
+type TaskTiming struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"` // ID with its templates rendered
+	Action     string `json:"action"`
+	Group      string `json:"group"`            // Module of the task, with its package manager like "packages/winget"
+	DurationMs int64  `json:"duration_ms"`      // Planning and executing together
+	PlanMs     int64  `json:"plan_ms"`          // Planning
+	ExecuteMs  int64  `json:"execute_ms"`       // Executing, 0 when the task was not executed
+	Status     string `json:"status,omitempty"` // Status of the task in the apply report
+	Source     string `json:"source,omitempty"` // Where the task is defined, as "file:line"
+}
+
+// GroupTiming is how long the tasks of a module, or of a package manager, took
+// together
+type GroupTiming struct {
+	Group      string `json:"group" yaml:"group"` // Module, with the package manager like "packages/winget"
+	Tasks      int    `json:"tasks" yaml:"tasks"`
+	DurationMs int64  `json:"duration_ms" yaml:"duration_ms"`
+}
+
+// Timings is how long the last apply took
+type Timings struct {
+	StartedAt  time.Time      `json:"started_at"`
+	DurationMs int64          `json:"duration_ms"`
+	Slowest    []*TaskTiming  `json:"slowest"` // The slowest tasks, slowest first
+	Groups     []*GroupTiming `json:"groups"`  // Every module and package manager, slowest first
+}
+
+// Duration returns how long the apply took
+func (t *Timings) Duration() time.Duration {
+	return time.Duration(t.DurationMs) * time.Millisecond
+}
+
+// NewTimings collects the timings of an apply that started at startedAt and ran for
+// duration: its slowest tasks and the total per group
+func NewTimings(startedAt time.Time, duration time.Duration, tasks []*TaskTiming) *Timings {
+	return &Timings{
+		StartedAt:  startedAt,
+		DurationMs: duration.Milliseconds(),
+		Slowest:    SlowestTaskTimings(tasks, SlowestTasks),
+		Groups:     GroupTimings(tasks),
+	}
+}
+
+// SlowestTaskTimings returns the n slowest tasks, slowest first. Tasks that took
+// as long keep their order.
+func SlowestTaskTimings(tasks []*TaskTiming, n int) []*TaskTiming {
+	slowest := append([]*TaskTiming(nil), tasks...)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].DurationMs > slowest[j].DurationMs
+	})
+	if len(slowest) > n {
+		slowest = slowest[:n]
+	}
+	return slowest
+}
+
+// GroupTimings adds up the timings of tasks per group, slowest first and by name
+// when groups took as long
+func GroupTimings(tasks []*TaskTiming) []*GroupTiming {
+	byGroup := make(map[string]*GroupTiming)
+	var groups []*GroupTiming
+	for _, task := range tasks {
+		group, exists := byGroup[task.Group]
+		if !exists {
+			group = &GroupTiming{Group: task.Group}
+			byGroup[task.Group] = group
+			groups = append(groups, group)
+		}
+		group.Tasks++
+		group.DurationMs += task.DurationMs
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].DurationMs != groups[j].DurationMs {
+			return groups[i].DurationMs > groups[j].DurationMs
+		}
+		return groups[i].Group < groups[j].Group
+	})
+	return groups
+}
+
+// TimingsPath returns the path of the timings of a dotfiles repository
+func TimingsPath(basePath string) string {
+	return filepath.Join(config.StateDir(basePath), TimingsFile)
+}
+
+// LoadTimings reads how long the last apply of a dotfiles repository took, nil
+// when no apply recorded it yet
+func LoadTimings(basePath string) (*Timings, error) {
+	path := TimingsPath(basePath)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apply timings: %w", err)
+	}
+	timings := &Timings{}
+	if err := json.Unmarshal(data, timings); err != nil {
+		return nil, fmt.Errorf("failed to parse apply timings %s: %w", path, err)
+	}
+	return timings, nil
+}
+
+// SaveTimings writes how long the last apply of a dotfiles repository took,
+// replacing the timings written before
+func SaveTimings(basePath string, timings *Timings) error {
+	data, err := json.MarshalIndent(timings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal apply timings: %w", err)
+	}
+	path := TimingsPath(basePath)
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := utils.WriteFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write apply timings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimings(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	basePath := t.TempDir()
+
+	tasks := []*TaskTiming{
+		{ID: "git", Group: "packages/apt", DurationMs: 1200},
+		{ID: "vscode", Group: "packages/winget", DurationMs: 90000},
+		{ID: "gitconfig", Group: "files", DurationMs: 5},
+		{ID: "powertoys", Group: "packages/winget", DurationMs: 60000},
+		{ID: "bashrc", Group: "symlinks", DurationMs: 1},
+		{ID: "nvim", Group: "files", DurationMs: 5},
+		{ID: "zshrc", Group: "symlinks", DurationMs: 1},
+	}
+	started := time.Now().Add(-3 * time.Minute).Truncate(time.Second)
+	timings := NewTimings(started, 3*time.Minute, tasks)
+
+	var slowest []string
+	for _, task := range timings.Slowest {
+		slowest = append(slowest, task.ID)
+	}
+	assert.Equal(t, []string{"vscode", "powertoys", "git", "gitconfig", "nvim"}, slowest, "slowest first, ties in task order")
+
+	assert.Equal(t, []*GroupTiming{
+		{Group: "packages/winget", Tasks: 2, DurationMs: 150000},
+		{Group: "packages/apt", Tasks: 1, DurationMs: 1200},
+		{Group: "files", Tasks: 2, DurationMs: 10},
+		{Group: "symlinks", Tasks: 2, DurationMs: 2},
+	}, timings.Groups)
+
+	loaded, err := LoadTimings(basePath)
+	require.NoError(t, err)
+	assert.Nil(t, loaded, "no timings before an apply recorded them")
+
+	require.NoError(t, SaveTimings(basePath, timings))
+	loaded, err = LoadTimings(basePath)
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	assert.Equal(t, 3*time.Minute, loaded.Duration())
+	assert.True(t, started.Equal(loaded.StartedAt))
+	assert.Len(t, loaded.Slowest, SlowestTasks)
+	assert.Equal(t, timings.Groups, loaded.Groups)
+}
@@ -0,0 +1,174 @@
+package templating
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/expr-lang/expr"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/platform"
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// packageManagers returns the package managers on the system. Detection runs once
+// per process, the first time a condition asks for it.
+var packageManagers = sync.OnceValue(platform.DetectPackageManagers)
+
+// packageManagerAliases maps command names to the package manager names platform
+// detection reports
+var packageManagerAliases = map[string]string{
+	"brew":  "homebrew",
+	"choco": "chocolatey",
+}
+
+// conditionFunctions returns the helper functions available in conditions
+func conditionFunctions() []expr.Option {
+	return []expr.Option{
+		expr.Function("commandExists", func(params ...any) (any, error) {
+			return commandExists(params[0].(string)), nil
+		}, new(func(string) bool)),
+		expr.Function("hasPackageManager", func(params ...any) (any, error) {
+			return hasPackageManager(params[0].(string)), nil
+		}, new(func(string) bool)),
+		expr.Function("fileExists", func(params ...any) (any, error) {
+			return fileExists(params[0].(string))
+		}, new(func(string) bool)),
+		expr.Function("versionCompare", func(params ...any) (any, error) {
+			return versionCompare(params[0], params[1]), nil
+		}, new(func(any, any) int)),
+		expr.Function("versionAtLeast", func(params ...any) (any, error) {
+			return versionAtLeast(params[0], params[1]), nil
+		}, new(func(any, any) bool)),
+		expr.Function("commandVersion", func(params ...any) (any, error) {
+			return commandVersion(params[0]), nil
+		}, new(func(any) string)),
+	}
+}
+
+// commandExists reports whether a command is found in PATH
+func commandExists(command string) bool {
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+// hasPackageManager reports whether a package manager was detected on the system
+func hasPackageManager(name string) bool {
+	name = strings.ToLower(name)
+	if alias, ok := packageManagerAliases[name]; ok {
+		name = alias
+	}
+	for _, manager := range packageManagers() {
+		if manager == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fileExists reports whether a file or directory exists, expanding a leading ~
+func fileExists(path string) (bool, error) {
+	expanded, err := utils.ExpandPath(path)
+	if err != nil {
+		return false, fmt.Errorf("fileExists(%q): %w", path, err)
+	}
+	_, err = os.Stat(expanded)
+	return err == nil, nil
+}
+
+// ConditionHelp describes the helper functions available in conditions
+func ConditionHelp() []string {
+	return []string{
+		`commandExists("docker") - a command is in PATH`,
+		`hasPackageManager("apt") - a package manager was detected (see Platform.PackageManagers)`,
+		`fileExists("~/.ssh/id_ed25519") - a file or directory exists, ~ is expanded`,
+		`versionAtLeast(Platform.DistroVersion, "22.04") - a version is the same or newer, "12 (bookworm)" is read as 12`,
+		`versionCompare("2.40", "2.9") - -1, 0 or 1 when the first version is older, the same or newer`,
+		`commandVersion("git") - the version "git --version" prints, empty when git is not installed`,
+	}
+}
+
+// SubstituteConditionVariables returns a condition with every variable it reads
+// replaced by its value, to show why it evaluates the way it does. References that
+// do not resolve, function calls and string literals are kept as they are.
+func SubstituteConditionVariables(condition string, variables map[string]interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(condition); {
+		c := condition[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := i + 1
+			for end < len(condition) && condition[end] != c {
+				if condition[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(condition))
+			b.WriteString(condition[i:end])
+			i = end
+		case isIdentStart(c) && (i == 0 || condition[i-1] != '.' && !isIdentPart(condition[i-1])):
+			end := i
+			for end < len(condition) && (isIdentPart(condition[end]) || condition[end] == '.' && end+1 < len(condition) && isIdentStart(condition[end+1])) {
+				end++
+			}
+			reference := condition[i:end]
+			value, ok := resolveVariable(variables, strings.Split(reference, "."))
+			if ok && !strings.HasPrefix(strings.TrimLeft(condition[end:], " "), "(") {
+				b.WriteString(formatConditionValue(value))
+			} else {
+				b.WriteString(reference)
+			}
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// resolveVariable returns the value at a dotted path in the variables
+func resolveVariable(variables map[string]interface{}, path []string) (interface{}, bool) {
+	current := reflect.ValueOf(variables)
+	for _, element := range path {
+		for current.Kind() == reflect.Interface || current.Kind() == reflect.Pointer {
+			if current.IsNil() {
+				return nil, false
+			}
+			current = current.Elem()
+		}
+
+		switch current.Kind() {
+		case reflect.Map:
+			if current.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			current = current.MapIndex(reflect.ValueOf(element).Convert(current.Type().Key()))
+		case reflect.Struct:
+			current = current.FieldByName(element)
+		default:
+			return nil, false
+		}
+		if !current.IsValid() || !current.CanInterface() {
+			return nil, false
+		}
+	}
+	return current.Interface(), true
+}
+
+// formatConditionValue formats a variable value the way it would be written in a condition
+func formatConditionValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
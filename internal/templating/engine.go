@@ -1,9 +1,12 @@
 package templating
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -14,6 +17,34 @@ import (
 	"github.com/vleeuwenmenno/dotfiles-cp/internal/templating/filters"
 )
 
+// secretStore is shared by all engines so secrets resolve once per run and
+// every resolved value can be redacted from output
+var secretStore = filters.NewSecretStore("", "")
+
+// ConfigureSecrets sets where the secret() template function looks up secrets
+func ConfigureSecrets(secretsFile, secretCommand string) {
+	secretStore.Configure(secretsFile, secretCommand)
+}
+
+// RedactSecrets replaces any secret value resolved so far in text
+func RedactSecrets(text string) string {
+	return secretStore.Redact(text)
+}
+
+// Engine renders the templates and evaluates the conditions of variables, jobs and
+// files, so they all support the same syntax and functions
+type Engine interface {
+	// ProcessString renders a template
+	ProcessString(content string, variables map[string]interface{}) (string, error)
+	// ProcessPath renders a template of a path, with slashes converted to the
+	// separator of the operating system
+	ProcessPath(path string, variables map[string]interface{}) (string, error)
+	// EvaluateCondition evaluates a condition, which is true when it is empty
+	EvaluateCondition(condition string, variables map[string]interface{}) (bool, error)
+}
+
+var _ Engine = (*TemplatingEngine)(nil)
+
 // TemplatingEngine provides hybrid templating:
 // - Expr for simple conditions (fast, type-safe)
 // - Pongo2 for complex templating (full Jinja2-like power)
@@ -62,18 +93,22 @@ func NewTemplatingEngine(basePath string) *TemplatingEngine {
 
 // EvaluateCondition evaluates job conditions using Expr
 // Perfect for simple, fast boolean conditions
-// Examples: Platform.OS == "linux" && !Platform.IsElevated
+// Examples: Platform.OS == "linux" && !Platform.IsElevated, commandExists("docker")
 func (e *TemplatingEngine) EvaluateCondition(condition string, variables map[string]interface{}) (bool, error) {
 	if condition == "" {
 		return true, nil
 	}
+	e.RecordCondition(condition, variables)
 
 	program, err := e.getOrCompileExpr(condition, expr.AsBool())
+	if err != nil && IsLegacyCondition(condition) {
+		return evaluateLegacyCondition(condition, variables)
+	}
 	if err != nil {
 		return false, fmt.Errorf("failed to compile condition '%s': %w", condition, err)
 	}
 
-	result, err := expr.Run(program, variables)
+	result, err := expr.Run(program, withEnv(condition, variables))
 	if err != nil {
 		return false, fmt.Errorf("failed to evaluate condition '%s': %w", condition, err)
 	}
@@ -85,18 +120,47 @@ func (e *TemplatingEngine) EvaluateCondition(condition string, variables map[str
 	return false, fmt.Errorf("condition '%s' did not evaluate to boolean, got %T", condition, result)
 }
 
-// ProcessTemplate processes complex templates using Pongo2
-// Perfect for file templating with full Jinja2-like syntax
-// Examples: {% if Platform.OS == "linux" %}...{% endif %}, {% for item in list %}...{% endfor %}
-func (e *TemplatingEngine) ProcessTemplate(templateContent string, variables map[string]interface{}) (string, error) {
+// ProcessString processes templates using Pongo2, from file content to variable
+// values and task parameters
+// Examples: {{ User.Home }}, {% if Platform.OS == "linux" %}...{% endif %}, {% for item in list %}...{% endfor %}
+func (e *TemplatingEngine) ProcessString(templateContent string, variables map[string]interface{}) (string, error) {
+	return e.ProcessNamedTemplate(templateContent, inlineTemplateName, variables)
+}
+
+// ProcessPath processes a template of a path like ProcessString and converts its
+// slashes to the separator of the operating system
+func (e *TemplatingEngine) ProcessPath(path string, variables map[string]interface{}) (string, error) {
+	result, err := e.ProcessString(path, variables)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(result), nil
+}
+
+// inlineTemplateName names templates that are not read from a file in errors
+const inlineTemplateName = "<inline template>"
+
+// ProcessNamedTemplate processes template content like ProcessString, naming the
+// template in errors, e.g. after the file the content was read from. Content in Go
+// template syntax is rendered as a Go template.
+func (e *TemplatingEngine) ProcessNamedTemplate(templateContent, name string, variables map[string]interface{}) (string, error) {
 	template, err := e.pongo2Set.FromString(templateContent)
+	if err != nil && IsLegacyTemplate(templateContent) {
+		return processLegacyTemplate(templateContent, name, variables)
+	}
 	if err != nil {
-		return "", e.enhanceTemplateError(err, templateContent, "<inline template>")
+		return "", e.enhanceTemplateError(err, templateContent, name)
 	}
 
-	result, err := template.Execute(pongo2.Context(variables))
+	context, done := templateContext(templateContent, variables)
+	result, err := template.Execute(context)
+	if name == inlineTemplateName {
+		done("")
+	} else {
+		done(name)
+	}
 	if err != nil {
-		return "", e.enhanceTemplateError(err, templateContent, "<inline template>")
+		return "", e.enhanceTemplateError(err, templateContent, name)
 	}
 
 	return result, nil
@@ -112,11 +176,16 @@ func (e *TemplatingEngine) ProcessTemplateFile(templatePath string, variables ma
 	}
 
 	template, err := e.pongo2Set.FromFile(templatePath)
+	if err != nil && IsLegacyTemplate(templateContent) {
+		return processLegacyTemplate(templateContent, templatePath, variables)
+	}
 	if err != nil {
 		return "", e.enhanceTemplateError(err, templateContent, templatePath)
 	}
 
-	result, err := template.Execute(pongo2.Context(variables))
+	context, done := templateContext(templateContent, variables)
+	result, err := template.Execute(context)
+	done(templatePath)
 	if err != nil {
 		return "", e.enhanceTemplateError(err, templateContent, templatePath)
 	}
@@ -124,12 +193,6 @@ func (e *TemplatingEngine) ProcessTemplateFile(templatePath string, variables ma
 	return result, nil
 }
 
-// ProcessVariableTemplate processes variable templates using Pongo2
-// Used in the pillar-like variable system for complex variable processing
-func (e *TemplatingEngine) ProcessVariableTemplate(templateStr string, variables map[string]interface{}) (string, error) {
-	return e.ProcessTemplate(templateStr, variables)
-}
-
 // getOrCompileExpr compiles and caches Expr programs for conditions
 func (e *TemplatingEngine) getOrCompileExpr(expression string, options ...expr.Option) (*vm.Program, error) {
 	cacheKey := fmt.Sprintf("%s:%d", expression, len(options))
@@ -138,10 +201,11 @@ func (e *TemplatingEngine) getOrCompileExpr(expression string, options ...expr.O
 		return program, nil
 	}
 
-	// Use built-in Expr operators and options
+	// Use built-in Expr operators, the condition helpers and options
 	allOptions := append([]expr.Option{
 		expr.AllowUndefinedVariables(),
-	}, options...)
+	}, conditionFunctions()...)
+	allOptions = append(allOptions, options...)
 
 	program, err := expr.Compile(expression, allOptions...)
 	if err != nil {
@@ -157,6 +221,26 @@ func (e *TemplatingEngine) IsTemplateContent(content string) bool {
 	return strings.Contains(content, "{{") || strings.Contains(content, "{%")
 }
 
+// templateDelimiters are the sequences that start a tag, expression or comment
+var templateDelimiters = []string{"{{", "{%", "{#"}
+
+// EscapeTemplate escapes every sequence in content that would start template
+// syntax, so rendering the result gives content back unchanged
+func EscapeTemplate(content string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(content); i++ {
+		if content[i] == '{' && i+1 < len(content) {
+			if delimiter := content[i : i+2]; slices.Contains(templateDelimiters, delimiter) {
+				escaped.WriteString(`{{ "` + delimiter + `" }}`)
+				i++
+				continue
+			}
+		}
+		escaped.WriteByte(content[i])
+	}
+	return escaped.String()
+}
+
 // enhanceTemplateError provides detailed error information with context
 func (e *TemplatingEngine) enhanceTemplateError(err error, templateContent, templatePath string) error {
 	errStr := err.Error()
@@ -191,7 +275,7 @@ func (e *TemplatingEngine) enhanceTemplateError(err error, templateContent, temp
 				// Add column indicator for error line
 				if i == lineNum && colNum > 0 {
 					spaces := strings.Repeat(" ", colNum+6) // Account for line number and prefix
-					errorMsg.WriteString(fmt.Sprintf("     %s^\n", spaces))
+					errorMsg.WriteString(fmt.Sprintf("%s^\n", spaces))
 				}
 			}
 		}
@@ -209,7 +293,7 @@ func (e *TemplatingEngine) enhanceTemplateError(err error, templateContent, temp
 			errorMsg.WriteString("  • Use 'if dict.key' instead of 'dict.get(key)' in Pongo2\n")
 		}
 
-		return fmt.Errorf(errorMsg.String())
+		return errors.New(errorMsg.String())
 	}
 
 	// Fallback for cases where we can't extract detailed info
@@ -253,14 +337,31 @@ func max(a, b int) int {
 
 // registerFilters registers all custom filters with the template set
 func (e *TemplatingEngine) registerFilters() {
+	e.registerFiltersOn(e.pongo2Set)
+}
+
+// registerFiltersOn registers all custom filters with a template set
+func (e *TemplatingEngine) registerFiltersOn(set *pongo2.TemplateSet) {
+	for name, function := range pathFunctions {
+		set.Globals[name] = function
+	}
+	for name, function := range versionFunctions {
+		set.Globals[name] = function
+	}
+
 	// Register 1Password filter
 	onePasswordFilter := filters.NewOnePasswordFilter()
-	onePasswordFilter.Register(e.pongo2Set)
+	onePasswordFilter.Register(set)
+
+	// Register secret lookup
+	secretFilter := filters.NewSecretFilter(secretStore)
+	secretFilter.Register(set)
 }
 
 // GetSyntaxHelp returns help text for users about syntax
 func (e *TemplatingEngine) GetSyntaxHelp() string {
 	onePasswordFilter := filters.NewOnePasswordFilter()
+	secretFilter := filters.NewSecretFilter(secretStore)
 
 	return `Templating Syntax:
 
@@ -270,16 +371,40 @@ Job Conditions (Expr):
   "docker" in Platform.Tags
   Platform.Distro matches "Ubuntu"
   Platform.Version matches "^22\\."
+  commandExists("docker") && hasPackageManager("apt")
+  fileExists("~/.ssh/id_ed25519")
+  versionAtLeast(Platform.DistroVersion, "22.04")
+  versionAtLeast(commandVersion("git"), "2.40")
 
 File Templates (Pongo2/Jinja2):
   {{ Platform.OS }}
   {% if Platform.OS == "linux" %}...{% endif %}
   {% for pkg in Packages %}{{ pkg }}{% endfor %}
   {{ Platform.OS|upper }}
+  {{ pathJoin(User.Home, ".config", "app") }}
 
 Variable Templates (Pongo2/Jinja2):
   {{ Platform.OS }}-config
   {% if Platform.IsElevated %}admin{% else %}user{% endif %}
 
-` + onePasswordFilter.GetSyntaxHelp()
+Path Functions:
+  pathJoin(paths...)  Join path components with the separator of the operating system
+  pathSep()           The separator of the operating system
+  pathClean(path)     Clean a path
+
+Version Functions:
+  versionCompare(a, b)          -1, 0 or 1 when version a is older, the same or newer than b
+  versionAtLeast(version, min)  Whether version is the same as or newer than min. Text
+                                around a version is ignored, "12 (bookworm)" is 12
+  commandVersion(command)       The version "command --version" prints, empty when the
+                                command is not installed. Runs once per command
+
+Environment Functions:
+  env(name, default)  An environment variable, or default when it is not set or empty.
+                      Names are case-insensitive on Windows, like Env.AppData.
+
+Go template syntax ({{ .Platform.OS }}, eq .Platform.OS "linux") is deprecated. It is
+still rendered and evaluated, with a warning on how to migrate.
+
+` + onePasswordFilter.GetSyntaxHelp() + "\n" + secretFilter.GetSyntaxHelp()
 }
@@ -3,6 +3,7 @@ package templating
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,12 +15,15 @@ func TestTemplatingEngine_EvaluateCondition(t *testing.T) {
 
 	variables := map[string]interface{}{
 		"Platform": map[string]interface{}{
-			"OS":         "linux",
-			"Distro":     "Alpine Linux",
-			"IsElevated": false,
-			"IsRoot":     true,
-			"Tags":       []string{"docker", "alpine"},
-			"Version":    "3.18",
+			"OS":          "linux",
+			"Distro":      "Alpine Linux",
+			"IsElevated":  false,
+			"IsRoot":      true,
+			"IsWSL":       true,
+			"WSLVersion":  2,
+			"IsContainer": false,
+			"Tags":        []string{"docker", "alpine"},
+			"Version":     "3.18",
 		},
 		"User": map[string]interface{}{
 			"Name": "testuser",
@@ -47,6 +51,16 @@ func TestTemplatingEngine_EvaluateCondition(t *testing.T) {
 			condition: `!Platform.IsElevated`,
 			expected:  true,
 		},
+		{
+			name:      "WSL detection",
+			condition: `Platform.IsWSL && Platform.WSLVersion == 2`,
+			expected:  true,
+		},
+		{
+			name:      "container negation",
+			condition: `!Platform.IsContainer`,
+			expected:  true,
+		},
 		{
 			name:      "complex AND condition",
 			condition: `Platform.OS == "linux" && Platform.Distro == "Alpine Linux"`,
@@ -163,7 +177,7 @@ func TestTemplatingEngine_ProcessTemplate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := engine.ProcessTemplate(tt.template, variables)
+			result, err := engine.ProcessString(tt.template, variables)
 			require.NoError(t, err)
 			assert.Equal(t, tt.expected, result)
 		})
@@ -216,6 +230,22 @@ packages:
 	assert.Equal(t, expected, result)
 }
 
+func TestTemplatingEngine_ProcessNamedTemplate(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+
+	result, err := engine.ProcessNamedTemplate("user: {{ User.Name }}", "files/config.tmpl", map[string]interface{}{
+		"User": map[string]interface{}{"Name": "testuser"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "user: testuser", result)
+
+	_, err = engine.ProcessNamedTemplate("a: 1\nb: {{ User.Name }\nc: 3", "files/config.tmpl", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "template error in 'files/config.tmpl'")
+	assert.Contains(t, err.Error(), "→   2: b: {{ User.Name }\n"+strings.Repeat(" ", 23)+"^\n")
+	assert.Contains(t, err.Error(), "{{ }} or {% %}")
+}
+
 func TestTemplatingEngine_ProcessVariableTemplate(t *testing.T) {
 	engine := NewTemplatingEngine(t.TempDir())
 
@@ -250,7 +280,7 @@ func TestTemplatingEngine_ProcessVariableTemplate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := engine.ProcessVariableTemplate(tt.template, variables)
+			result, err := engine.ProcessString(tt.template, variables)
 			require.NoError(t, err)
 			assert.Equal(t, tt.expected, result)
 		})
@@ -327,3 +357,102 @@ func TestTemplatingEngine_GetSyntaxHelp(t *testing.T) {
 	assert.Contains(t, help, "File Templates")
 	assert.Contains(t, help, "Variable Templates")
 }
+
+func TestConditionHelpers(t *testing.T) {
+	original := packageManagers
+	packageManagers = func() []string { return []string{"apt", "homebrew"} }
+	defer func() { packageManagers = original }()
+
+	assert.True(t, hasPackageManager("apt"))
+	assert.True(t, hasPackageManager("brew"), "command names resolve to detected manager names")
+	assert.False(t, hasPackageManager("pacman"))
+
+	binary, err := os.Executable()
+	require.NoError(t, err)
+	t.Setenv("PATH", filepath.Dir(binary))
+	assert.True(t, commandExists(filepath.Base(binary)))
+	assert.False(t, commandExists("definitely-not-a-command"))
+
+	// The fixture goes in a home directory of its own, not in the real one
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".dotfiles-condition"), nil, 0644))
+
+	exists, err := fileExists("~/.dotfiles-condition")
+	require.NoError(t, err)
+	assert.True(t, exists, "~ is expanded")
+	exists, err = fileExists("~/.dotfiles-condition-missing")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestTemplatingEngine_EvaluateConditionHelpers(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+
+	original := packageManagers
+	packageManagers = func() []string { return []string{"apt"} }
+	defer func() { packageManagers = original }()
+
+	variables := map[string]interface{}{"Platform": map[string]interface{}{"OS": "linux"}}
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{`Platform.OS == "linux" && hasPackageManager("apt")`, true},
+		{`commandExists("definitely-not-a-command")`, false},
+		{`!fileExists("~/.dotfiles-condition-missing")`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			result, err := engine.EvaluateCondition(tt.condition, variables)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+
+	_, err := engine.EvaluateCondition(`commandExists(42)`, variables)
+	assert.Error(t, err, "helpers only accept strings")
+}
+
+func TestEscapeTemplate(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+
+	contents := []string{
+		"plain text without templates\n",
+		"PS1='{{user}}@{{host}} '\n",
+		"{% if x %}{# comment #}{{{ nested }}}\n",
+		"trailing brace {",
+	}
+	for _, content := range contents {
+		escaped := EscapeTemplate(content)
+		rendered, err := engine.ProcessString(escaped, map[string]interface{}{"user": "nobody"})
+		require.NoError(t, err, escaped)
+		assert.Equal(t, content, rendered)
+	}
+}
+
+func TestSubstituteConditionVariables(t *testing.T) {
+	variables := map[string]interface{}{
+		"Platform": map[string]interface{}{"OS": "linux", "IsElevated": false},
+		"work":     true,
+		"git":      map[string]string{"email": "menno@example.com"},
+		"version":  3,
+	}
+
+	tests := []struct {
+		condition string
+		expected  string
+	}{
+		{`Platform.OS == "windows"`, `"linux" == "windows"`},
+		{`work && !Platform.IsElevated`, `true && !false`},
+		{`version >= 2 and git.email endsWith "@example.com"`, `3 >= 2 and "menno@example.com" endsWith "@example.com"`},
+		{`commandExists("docker") || missing.value`, `commandExists("docker") || missing.value`},
+		{`Platform.OS == "Platform.OS work"`, `"linux" == "Platform.OS work"`},
+		{`Platform.Missing == nil`, `Platform.Missing == nil`},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, SubstituteConditionVariables(tt.condition, variables), tt.condition)
+	}
+}
@@ -0,0 +1,120 @@
+package templating
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// goos is the operating system environment variable names are looked up for,
+// replaced by tests. Windows matches them regardless of case.
+var goos = runtime.GOOS
+
+// envFunctionName is the name of the function templates and conditions look up
+// environment variables with, like env("EDITOR", "vim")
+const envFunctionName = "env"
+
+// envReferenceRegex matches references to environment variables in templates and
+// conditions in every syntax: Env.APPDATA, .Env.APPDATA and Env["APPDATA"]
+var envReferenceRegex = regexp.MustCompile(`\bEnv(?:\.([A-Za-z_][A-Za-z0-9_]*)|\[\s*["']([^"']+)["']\s*\])`)
+
+// environment holds the Env section of the template context, the environment
+// variables by name. On Windows names are case-insensitive, so get finds APPDATA as
+// AppData too.
+type environment map[string]string
+
+// envSection returns the Env section of variables, nil when it has none
+func envSection(variables map[string]interface{}) environment {
+	switch env := variables["Env"].(type) {
+	case map[string]string:
+		return env
+	case map[string]interface{}:
+		section := make(environment, len(env))
+		for name, value := range env {
+			section[name] = fmt.Sprint(value)
+		}
+		return section
+	}
+	return nil
+}
+
+// get looks up an environment variable. Names that are only set in another case
+// are found on Windows, where the first of them in sorted order wins.
+func (e environment) get(name string) (string, bool) {
+	if value, ok := e[name]; ok {
+		return value, true
+	}
+	if goos != "windows" {
+		return "", false
+	}
+	match := ""
+	for key := range e {
+		if strings.EqualFold(key, name) && (match == "" || key < match) {
+			match = key
+		}
+	}
+	if match == "" {
+		return "", false
+	}
+	return e[match], true
+}
+
+// envFunction returns the env function for variables, which gives the environment
+// variable of the Env section named by its first argument, or the optional second
+// argument when the variable is not set or empty
+func envFunction(variables map[string]interface{}) func(name interface{}, fallback ...interface{}) (string, error) {
+	env := envSection(variables)
+	return func(name interface{}, fallback ...interface{}) (string, error) {
+		if len(fallback) > 1 {
+			return "", fmt.Errorf("env takes a name and an optional default, got %d arguments", 1+len(fallback))
+		}
+		if value, _ := env.get(fmt.Sprint(name)); value != "" {
+			return value, nil
+		}
+		if len(fallback) == 1 && fallback[0] != nil {
+			return fmt.Sprint(fallback[0]), nil
+		}
+		return "", nil
+	}
+}
+
+// withEnv returns the variables to render content with: variables with the env
+// function, unless a variable is named env, and, on Windows, with the environment
+// variables content refers to in another case than they are set in added under
+// the name it uses, so Env.AppData resolves to APPDATA. variables itself is not
+// changed.
+func withEnv(content string, variables map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(variables)+1)
+	for key, value := range variables {
+		result[key] = value
+	}
+	if _, exists := variables[envFunctionName]; !exists {
+		result[envFunctionName] = envFunction(variables)
+	}
+
+	env := envSection(variables)
+	if goos != "windows" || env == nil {
+		return result
+	}
+	var aliases environment
+	for _, match := range envReferenceRegex.FindAllStringSubmatch(content, -1) {
+		name := match[1] + match[2]
+		if _, exists := env[name]; exists {
+			continue
+		}
+		if value, ok := env.get(name); ok {
+			if aliases == nil {
+				aliases = make(environment, len(env)+1)
+				for key, value := range env {
+					aliases[key] = value
+				}
+			}
+			aliases[name] = value
+		}
+	}
+	if aliases != nil {
+		result["Env"] = map[string]string(aliases)
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package templating
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setGOOS replaces the operating system environment variables are looked up for
+// until the test ends
+func setGOOS(t *testing.T, os string) {
+	previous := goos
+	goos = os
+	t.Cleanup(func() { goos = previous })
+}
+
+// fakeEnvVariables returns variables with a fake Env section, so tests do not
+// depend on the environment they run in
+func fakeEnvVariables() map[string]interface{} {
+	return map[string]interface{}{
+		"Env": map[string]string{
+			"APPDATA": `C:\Users\me\AppData\Roaming`,
+			"EDITOR":  "nvim",
+			"EMPTY":   "",
+		},
+	}
+}
+
+func TestEnvCaseInsensitiveOnWindows(t *testing.T) {
+	setGOOS(t, "windows")
+	engine := NewTemplatingEngine(t.TempDir())
+	variables := fakeEnvVariables()
+	want := `C:\Users\me\AppData\Roaming`
+
+	for _, template := range []string{
+		"{{ Env.APPDATA }}",
+		"{{ Env.AppData }}",
+		"{{ Env.appdata }}",
+		`{{ Env["AppData"] }}`,
+		"{{ .Env.AppData }}",
+		"{{ .Env.appdata }}",
+	} {
+		result, err := engine.ProcessString(template, variables)
+		require.NoError(t, err, template)
+		assert.Equal(t, want, result, template)
+	}
+
+	for _, condition := range []string{
+		`Env.AppData == "` + `C:\\Users\\me\\AppData\\Roaming"`,
+		`Env.appdata != ""`,
+		`ne .Env.AppData ""`,
+	} {
+		result, err := engine.EvaluateCondition(condition, variables)
+		require.NoError(t, err, condition)
+		assert.True(t, result, condition)
+	}
+
+	// The Env section itself is left as it is
+	assert.Len(t, variables["Env"], 3)
+}
+
+func TestEnvCaseSensitiveElsewhere(t *testing.T) {
+	setGOOS(t, "linux")
+	engine := NewTemplatingEngine(t.TempDir())
+	variables := fakeEnvVariables()
+
+	result, err := engine.ProcessString("[{{ Env.AppData }}][{{ Env.APPDATA }}]", variables)
+	require.NoError(t, err)
+	assert.Equal(t, `[][C:\Users\me\AppData\Roaming]`, result)
+
+	result, err = engine.ProcessString(`[{{ env("appdata") }}]`, variables)
+	require.NoError(t, err)
+	assert.Equal(t, "[]", result)
+}
+
+func TestEnvFunction(t *testing.T) {
+	for _, goos := range []string{"linux", "windows"} {
+		t.Run(goos, func(t *testing.T) {
+			setGOOS(t, goos)
+			engine := NewTemplatingEngine(t.TempDir())
+			variables := fakeEnvVariables()
+
+			tests := []struct {
+				pongo2, goTemplate, want string
+			}{
+				{`{{ env("EDITOR") }}`, `{{ env "EDITOR" }}`, "nvim"},
+				{`{{ env("EDITOR", "vim") }}`, `{{ env "EDITOR" "vim" }}`, "nvim"},
+				{`{{ env("VISUAL", "vim") }}`, `{{ env "VISUAL" "vim" }}`, "vim"},
+				{`{{ env("EMPTY", "vim") }}`, `{{ env "EMPTY" "vim" }}`, "vim"},
+				{`[{{ env("VISUAL") }}]`, `[{{ env "VISUAL" }}]`, "[]"},
+			}
+			for _, tt := range tests {
+				result, err := engine.ProcessString(tt.pongo2, variables)
+				require.NoError(t, err, tt.pongo2)
+				assert.Equal(t, tt.want, result, tt.pongo2)
+
+				result, err = engine.ProcessString(tt.goTemplate, variables)
+				require.NoError(t, err, tt.goTemplate)
+				assert.Equal(t, tt.want, result, tt.goTemplate)
+			}
+
+			result, err := engine.EvaluateCondition(`env("VISUAL", "vim") == "vim"`, variables)
+			require.NoError(t, err)
+			assert.True(t, result)
+		})
+	}
+
+	setGOOS(t, "windows")
+	engine := NewTemplatingEngine(t.TempDir())
+	result, err := engine.ProcessString(`{{ env("AppData") }}`, fakeEnvVariables())
+	require.NoError(t, err)
+	assert.Equal(t, `C:\Users\me\AppData\Roaming`, result)
+
+	_, err = engine.ProcessString(`{{ env("A", "b", "c") }}`, fakeEnvVariables())
+	assert.Error(t, err)
+}
+
+func TestEnvInTemplateFile(t *testing.T) {
+	setGOOS(t, "windows")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"data": "{{ Env.AppData }}", "editor": "{{ env("editor") }}"}`), 0644))
+
+	engine := NewTemplatingEngine(dir)
+	want := `{"data": "C:\Users\me\AppData\Roaming", "editor": "nvim"}`
+
+	result, err := engine.ProcessTemplateFile(path, fakeEnvVariables())
+	require.NoError(t, err)
+	assert.Equal(t, want, result)
+
+	result, err = engine.ProcessTemplateFileWithSearchPath(path, nil, fakeEnvVariables())
+	require.NoError(t, err)
+	assert.Equal(t, want, result)
+
+	assert.Empty(t, engine.CheckTemplate(`{{ Env.AppData }}{{ env("X", "y") }}`, fakeEnvVariables()))
+}
+
+func TestEnvFunctionNotRecorded(t *testing.T) {
+	recorder := NewUsageRecorder()
+	RecordUsages(recorder)
+	defer RecordUsages(nil)
+
+	engine := NewTemplatingEngine(t.TempDir())
+	result, err := engine.ProcessString(`{{ env("EDITOR") }} {{ Env.EDITOR }}`, fakeEnvVariables())
+	require.NoError(t, err)
+	assert.Equal(t, "nvim nvim", result)
+
+	var keys []string
+	for _, usage := range recorder.Usages() {
+		keys = append(keys, usage.Key)
+	}
+	assert.Equal(t, []string{"Env.EDITOR"}, keys)
+}
@@ -0,0 +1,237 @@
+package filters
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/flosch/pongo2/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// SecretEnvPrefix is the prefix of environment variables that provide secrets
+const SecretEnvPrefix = "DOTFILES_SECRET_"
+
+// redactedSecret replaces secret values in plan and diff output
+const redactedSecret = "********"
+
+// SecretStore resolves named secrets from the environment, a secrets file or an
+// external command and remembers resolved values so they can be redacted
+type SecretStore struct {
+	mu          sync.Mutex
+	secretsFile string
+	command     string
+	fileSecrets map[string]string
+	fileLoaded  bool
+	resolved    map[string]string
+}
+
+// NewSecretStore creates a new secret store
+func NewSecretStore(secretsFile, command string) *SecretStore {
+	return &SecretStore{
+		secretsFile: secretsFile,
+		command:     command,
+		resolved:    make(map[string]string),
+	}
+}
+
+// Configure sets the secrets file and command, dropping any cached lookups
+func (s *SecretStore) Configure(secretsFile, command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.secretsFile = secretsFile
+	s.command = command
+	s.fileSecrets = nil
+	s.fileLoaded = false
+	s.resolved = make(map[string]string)
+}
+
+// Resolve looks up a secret by name. The environment variable DOTFILES_SECRET_<NAME>
+// wins over the secrets file, which wins over the secret command.
+func (s *SecretStore) Resolve(name string) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", fmt.Errorf("secret name cannot be empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value, exists := s.resolved[name]; exists {
+		return value, nil
+	}
+
+	value, err := s.lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	s.resolved[name] = value
+	return value, nil
+}
+
+// lookup walks the secret sources in order of precedence
+func (s *SecretStore) lookup(name string) (string, error) {
+	envVar := SecretEnvVar(name)
+	if value := os.Getenv(envVar); value != "" {
+		return value, nil
+	}
+
+	if err := s.loadSecretsFile(); err != nil {
+		return "", err
+	}
+	if value, exists := s.fileSecrets[name]; exists && value != "" {
+		return value, nil
+	}
+
+	if s.command != "" {
+		return s.runCommand(name)
+	}
+
+	sources := []string{envVar}
+	if s.secretsFile != "" {
+		sources = append(sources, s.secretsFile)
+	}
+	return "", fmt.Errorf("secret '%s' not found (checked %s); set it or configure settings.secret_command",
+		name, strings.Join(sources, ", "))
+}
+
+// loadSecretsFile reads the secrets file once, a missing file is not an error
+func (s *SecretStore) loadSecretsFile() error {
+	if s.fileLoaded {
+		return nil
+	}
+	s.fileLoaded = true
+	s.fileSecrets = make(map[string]string)
+
+	if s.secretsFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.secretsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read secrets file %s: %w", s.secretsFile, err)
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse secrets file %s: %w", s.secretsFile, err)
+	}
+
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("secret '%s' in %s must be a plain value", key, s.secretsFile)
+		default:
+			s.fileSecrets[key] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
+
+// runCommand runs the secret command, replacing {} with the secret name or
+// appending the name when the command has no placeholder
+func (s *SecretStore) runCommand(name string) (string, error) {
+	fields := strings.Fields(s.command)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("secret_command is empty")
+	}
+
+	args := make([]string, 0, len(fields))
+	substituted := false
+	for _, field := range fields[1:] {
+		if strings.Contains(field, "{}") {
+			field = strings.ReplaceAll(field, "{}", name)
+			substituted = true
+		}
+		args = append(args, field)
+	}
+	if !substituted {
+		args = append(args, name)
+	}
+
+	cmd := exec.Command(fields[0], args...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("secret command failed for '%s': %s", name, strings.TrimSpace(string(exitError.Stderr)))
+		}
+		return "", fmt.Errorf("failed to run secret command for '%s': %w", name, err)
+	}
+
+	value := strings.TrimRight(string(output), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("secret command returned an empty value for '%s'", name)
+	}
+	return value, nil
+}
+
+// Redact replaces every resolved secret value in text
+func (s *SecretStore) Redact(text string) string {
+	s.mu.Lock()
+	values := make([]string, 0, len(s.resolved))
+	for _, value := range s.resolved {
+		values = append(values, value)
+	}
+	s.mu.Unlock()
+
+	// Replace longer values first so a secret containing another is fully hidden
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		text = strings.ReplaceAll(text, value, redactedSecret)
+	}
+	return text
+}
+
+// SecretEnvVar returns the environment variable that provides a secret,
+// e.g. "github-token" becomes DOTFILES_SECRET_GITHUB_TOKEN
+func SecretEnvVar(name string) string {
+	var b strings.Builder
+	b.WriteString(SecretEnvPrefix)
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// SecretFilter provides the secret() function for Pongo2 templates
+type SecretFilter struct {
+	store *SecretStore
+}
+
+// NewSecretFilter creates a new secret filter backed by the given store
+func NewSecretFilter(store *SecretStore) *SecretFilter {
+	return &SecretFilter{store: store}
+}
+
+// Register registers the secret function with the given template set
+func (f *SecretFilter) Register(templateSet *pongo2.TemplateSet) {
+	templateSet.Globals["secret"] = f.store.Resolve
+}
+
+// GetSyntaxHelp returns help text for the secret function
+func (f *SecretFilter) GetSyntaxHelp() string {
+	return `Secrets:
+  {{ secret("github_token") }}
+
+Lookup order:
+  1. Environment variable DOTFILES_SECRET_<NAME> (e.g. DOTFILES_SECRET_GITHUB_TOKEN)
+  2. settings.secrets_file (default ~/.config/dotfiles/secrets.yaml)
+  3. settings.secret_command, with {} replaced by the name (e.g. "op read op://Private/{}/credential")
+
+Missing secrets fail the run, and secret values are redacted in plan output.
+`
+}
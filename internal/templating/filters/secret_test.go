@@ -0,0 +1,84 @@
+package filters
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretEnvVar(t *testing.T) {
+	assert.Equal(t, "DOTFILES_SECRET_GITHUB_TOKEN", SecretEnvVar("github_token"))
+	assert.Equal(t, "DOTFILES_SECRET_NPM_TOKEN", SecretEnvVar("npm-token"))
+	assert.Equal(t, "DOTFILES_SECRET_A_B_C", SecretEnvVar("a.b/c"))
+}
+
+func TestSecretStoreResolveOrder(t *testing.T) {
+	secretsFile := filepath.Join(t.TempDir(), "secrets.yaml")
+	require.NoError(t, os.WriteFile(secretsFile, []byte("api_key: from-file\nport: 8080\n"), 0600))
+
+	store := NewSecretStore(secretsFile, "")
+
+	value, err := store.Resolve("api_key")
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", value)
+
+	value, err = store.Resolve("port")
+	require.NoError(t, err)
+	assert.Equal(t, "8080", value)
+
+	t.Setenv("DOTFILES_SECRET_API_KEY", "from-env")
+	store.Configure(secretsFile, "")
+	value, err = store.Resolve("api_key")
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", value)
+}
+
+func TestSecretStoreCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses echo from a POSIX environment")
+	}
+
+	store := NewSecretStore("", "echo value-of-{}")
+	value, err := store.Resolve("token")
+	require.NoError(t, err)
+	assert.Equal(t, "value-of-token", value)
+
+	store.Configure("", "echo")
+	value, err = store.Resolve("token")
+	require.NoError(t, err)
+	assert.Equal(t, "token", value)
+}
+
+func TestSecretStoreMissing(t *testing.T) {
+	secretsFile := filepath.Join(t.TempDir(), "missing.yaml")
+	store := NewSecretStore(secretsFile, "")
+
+	_, err := store.Resolve("does_not_exist")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does_not_exist")
+	assert.Contains(t, err.Error(), "DOTFILES_SECRET_DOES_NOT_EXIST")
+	assert.Contains(t, err.Error(), secretsFile)
+
+	_, err = store.Resolve("")
+	assert.Error(t, err)
+}
+
+func TestSecretStoreRedact(t *testing.T) {
+	t.Setenv("DOTFILES_SECRET_TOKEN", "s3cr3t-value")
+	store := NewSecretStore("", "")
+
+	assert.Equal(t, "token=s3cr3t-value", "token="+mustResolve(t, store, "token"))
+	assert.Equal(t, "token=********", store.Redact("token=s3cr3t-value"))
+	assert.Equal(t, "nothing to hide", store.Redact("nothing to hide"))
+}
+
+func mustResolve(t *testing.T, store *SecretStore, name string) string {
+	t.Helper()
+	value, err := store.Resolve(name)
+	require.NoError(t, err)
+	return value
+}
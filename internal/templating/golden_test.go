@@ -0,0 +1,148 @@
+package templating
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+var updateGolden = flag.Bool("update", false, "Write the golden files of the template tests")
+
+// goldenPlatforms are the platforms the golden templates are rendered for
+var goldenPlatforms = map[string]map[string]interface{}{
+	"linux":   {"OS": "linux", "Arch": "amd64"},
+	"windows": {"OS": "windows", "Arch": "arm64"},
+}
+
+// goldenVariables returns the variables of testdata/golden/variables.yaml on a platform
+func goldenVariables(t *testing.T, platform string) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "golden", "variables.yaml"))
+	require.NoError(t, err)
+	var variables map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(data, &variables))
+	variables["Platform"] = goldenPlatforms[platform]
+	return variables
+}
+
+// TestGoldenTemplates renders every sample template in testdata/golden, written in
+// Pongo2 as <name>.tmpl and in Go template syntax as <name>.legacy.tmpl, and checks
+// both against <name>.<platform>.golden. Run with -update to write the golden files
+// from the Pongo2 templates.
+func TestGoldenTemplates(t *testing.T) {
+	templates, err := filepath.Glob(filepath.Join("testdata", "golden", "*.legacy.tmpl"))
+	require.NoError(t, err)
+	require.NotEmpty(t, templates)
+
+	for _, legacyPath := range templates {
+		name := strings.TrimSuffix(filepath.Base(legacyPath), ".legacy.tmpl")
+		for platform := range goldenPlatforms {
+			t.Run(name+"/"+platform, func(t *testing.T) {
+				engine := NewTemplatingEngine(t.TempDir())
+				variables := goldenVariables(t, platform)
+				goldenPath := filepath.Join("testdata", "golden", name+"."+platform+".golden")
+
+				content, err := os.ReadFile(filepath.Join("testdata", "golden", name+".tmpl"))
+				require.NoError(t, err)
+				require.False(t, IsLegacyTemplate(string(content)), "%s.tmpl is in Go template syntax", name)
+				rendered, err := engine.ProcessString(string(content), variables)
+				require.NoError(t, err)
+				rendered = filepath.ToSlash(rendered)
+
+				legacy, err := os.ReadFile(legacyPath)
+				require.NoError(t, err)
+				require.True(t, IsLegacyTemplate(string(legacy)), "%s is not in Go template syntax", legacyPath)
+				legacyRendered, err := engine.ProcessString(string(legacy), variables)
+				require.NoError(t, err)
+				legacyRendered = filepath.ToSlash(legacyRendered)
+
+				if *updateGolden {
+					require.NoError(t, os.WriteFile(goldenPath, []byte(rendered), 0644))
+				}
+				golden, err := os.ReadFile(goldenPath)
+				require.NoError(t, err)
+				assert.Equal(t, string(golden), rendered, "%s.tmpl", name)
+				assert.Equal(t, string(golden), legacyRendered, "%s.legacy.tmpl", name)
+			})
+		}
+	}
+}
+
+func TestLegacyConditions(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+
+	tests := []struct {
+		legacy    string
+		condition string
+	}{
+		{`eq .Platform.OS "linux"`, `Platform.OS == "linux"`},
+		{`ne .Platform.OS "windows"`, `Platform.OS != "windows"`},
+		{`.Platform.IsElevated`, `Platform.IsElevated`},
+		{`not .Platform.IsElevated`, `!Platform.IsElevated`},
+		{`and (eq .Platform.OS "linux") (not .Platform.IsElevated)`, `Platform.OS == "linux" && !Platform.IsElevated`},
+		{`or (eq .Platform.OS "darwin") (eq .Platform.OS "windows")`, `Platform.OS == "darwin" || Platform.OS == "windows"`},
+		{`eq .Env.HOME "/home/jane"`, `Env.HOME == "/home/jane"`},
+		{`ne .colors.theme "dark"`, `colors.theme != "dark"`},
+	}
+
+	for platform := range goldenPlatforms {
+		variables := goldenVariables(t, platform)
+		variables["Platform"].(map[string]interface{})["IsElevated"] = platform == "windows"
+
+		for _, tt := range tests {
+			t.Run(platform+"/"+tt.condition, func(t *testing.T) {
+				assert.True(t, IsLegacyCondition(tt.legacy))
+				assert.False(t, IsLegacyCondition(tt.condition))
+
+				want, err := engine.EvaluateCondition(tt.condition, variables)
+				require.NoError(t, err)
+				got, err := engine.EvaluateCondition(tt.legacy, variables)
+				require.NoError(t, err)
+				assert.Equal(t, want, got, "%s evaluates differently from %s", tt.legacy, tt.condition)
+			})
+		}
+	}
+}
+
+func TestLegacyTemplateDetection(t *testing.T) {
+	tests := []struct {
+		content string
+		legacy  bool
+	}{
+		{"{{ .User.Home }}", true},
+		{"{{- .User.Home -}}", true},
+		{`{{ pathJoin .User.Home "x" }}`, true},
+		{`{{ if eq .Platform.OS "linux" }}x{{ end }}`, true},
+		{"{{ range $k, $v := .aliases }}{{ $k }}{{ end }}", true},
+		{"{{ User.Home }}", false},
+		{`{{ pathJoin(User.Home, "x") }}`, false},
+		{`{% if Platform.OS == "linux" %}x{% endif %}`, false},
+		{"plain text with a .dot", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.legacy, IsLegacyTemplate(tt.content), tt.content)
+	}
+
+	// Templates that are valid Pongo2 are never rendered as Go templates
+	engine := NewTemplatingEngine(t.TempDir())
+	result, err := engine.ProcessString("{{ not_legacy }}{{ pathSep() }}", map[string]interface{}{"not_legacy": "ok"})
+	require.NoError(t, err)
+	assert.Equal(t, "ok"+string(filepath.Separator), result)
+
+	issues := engine.CheckTemplate("{{ .User.Home }}", map[string]interface{}{})
+	require.Len(t, issues, 1)
+	assert.True(t, issues[0].Warning, "legacy templates are a warning, not an error")
+}
+
+func TestProcessPath(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+	result, err := engine.ProcessPath("{{ home }}/.config/app", map[string]interface{}{"home": "/home/jane"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash("/home/jane/.config/app"), result)
+}
@@ -0,0 +1,159 @@
+package templating
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// searchPathLoader loads the templates include, extends and import tags refer to,
+// looking next to the template using them first and then in a list of directories
+type searchPathLoader struct {
+	dirs []string
+}
+
+// Abs returns the first existing candidate for name, or the first candidate when
+// none exists so loading it reports the template as missing
+func (l *searchPathLoader) Abs(base, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	var candidates []string
+	if base != "" {
+		candidates = append(candidates, filepath.Join(filepath.Dir(base), name))
+	}
+	for _, dir := range l.dirs {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	if len(candidates) == 0 {
+		return name
+	}
+
+	for _, candidate := range candidates {
+		if utils.FileExists(candidate) {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+// Get reads a template
+func (l *searchPathLoader) Get(path string) (io.Reader, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+// newSearchPathSet creates a template set loading templates with a searchPathLoader,
+// with the same functions as the other templates
+func (e *TemplatingEngine) newSearchPathSet(searchPath []string) *pongo2.TemplateSet {
+	set := pongo2.NewSet("dotfiles-files", &searchPathLoader{dirs: searchPath})
+	e.registerFiltersOn(set)
+	return set
+}
+
+// ProcessTemplateFileWithSearchPath renders a template file whose include, extends
+// and import tags refer to templates next to it or in the directories of searchPath
+func (e *TemplatingEngine) ProcessTemplateFileWithSearchPath(templatePath string, searchPath []string, variables map[string]interface{}) (string, error) {
+	set := e.newSearchPathSet(searchPath)
+
+	// Read the content for the environment variables it refers to
+	content, readErr := os.ReadFile(templatePath)
+	template, err := set.FromFile(templatePath)
+	if err != nil {
+		if readErr == nil && IsLegacyTemplate(string(content)) {
+			return processLegacyTemplate(string(content), templatePath, variables)
+		}
+		return "", e.searchPathTemplateError(err, templatePath, searchPath)
+	}
+
+	context, done := templateContext(string(content), variables)
+	result, err := template.Execute(context)
+	done(templatePath)
+	if err != nil {
+		return "", e.searchPathTemplateError(err, templatePath, searchPath)
+	}
+
+	return result, nil
+}
+
+// CheckTemplateFile checks a template file like CheckTemplate, loading the templates
+// its include, extends and import tags refer to like ProcessTemplateFileWithSearchPath
+func (e *TemplatingEngine) CheckTemplateFile(templatePath string, searchPath []string, variables map[string]interface{}) []*TemplateIssue {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return []*TemplateIssue{{Message: fmt.Sprintf("failed to read template: %v", err)}}
+	}
+
+	if _, err := e.newSearchPathSet(searchPath).FromFile(templatePath); err != nil {
+		if IsLegacyTemplate(string(content)) {
+			return []*TemplateIssue{legacyTemplateIssue()}
+		}
+		var pongoErr *pongo2.Error
+		if !errors.As(err, &pongoErr) {
+			return []*TemplateIssue{e.syntaxIssue(err)}
+		}
+
+		missing := missingTemplateError(err, searchPath)
+		errorFile := pongoErr.Filename
+		if missing != "" {
+			errorFile = pongoErr.Token.Filename
+		}
+		if errorFile != templatePath {
+			// The error is in a template the file uses, its position is in that file
+			return []*TemplateIssue{{Message: e.searchPathTemplateError(err, templatePath, searchPath).Error()}}
+		}
+		if missing != "" {
+			return []*TemplateIssue{{Line: pongoErr.Line, Column: pongoErr.Column, Message: missing}}
+		}
+		return []*TemplateIssue{e.syntaxIssue(err)}
+	}
+	return e.checkReferences(string(content), variables)
+}
+
+// searchPathTemplateError describes an error rendering a template file. Templates
+// that are missing are reported with the file and line using them, other errors with
+// the context of the file they are in, which can be a template the file uses.
+func (e *TemplatingEngine) searchPathTemplateError(err error, templatePath string, searchPath []string) error {
+	var pongoErr *pongo2.Error
+	if !errors.As(err, &pongoErr) {
+		return fmt.Errorf("template error in '%s': %w", templatePath, err)
+	}
+
+	if missing := missingTemplateError(err, searchPath); missing != "" {
+		return fmt.Errorf("template error in '%s' line %d: %s", pongoErr.Token.Filename, pongoErr.Line, missing)
+	}
+
+	path := templatePath
+	if pongoErr.Filename != "" && pongoErr.Filename != "<string>" {
+		path = pongoErr.Filename
+	}
+	content, _ := os.ReadFile(path)
+	return e.enhanceTemplateError(err, string(content), path)
+}
+
+// missingTemplateError describes a template an include, extends or import tag refers
+// to that does not exist, it returns an empty string for other errors
+func missingTemplateError(err error, searchPath []string) string {
+	var pongoErr *pongo2.Error
+	if !errors.As(err, &pongoErr) || pongoErr.Sender != "fromfile" || pongoErr.Token == nil {
+		return ""
+	}
+
+	searched := "next to it"
+	if len(searchPath) > 0 {
+		searched += " or in " + strings.Join(searchPath, ", ")
+	}
+	return fmt.Sprintf("template '%s' not found %s", pongoErr.Token.Val, searched)
+}
@@ -0,0 +1,91 @@
+package templating
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTemplates writes templates relative to dir
+func writeTemplates(t *testing.T, dir string, templates map[string]string) {
+	t.Helper()
+	for name, content := range templates {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestTemplatingEngine_ProcessTemplateFileWithSearchPath(t *testing.T) {
+	tempDir := t.TempDir()
+	templatesDir := filepath.Join(tempDir, "templates")
+	writeTemplates(t, tempDir, map[string]string{
+		"templates/base.j2":       "[{{ section }}]\n{% block body %}default{% endblock %}\n",
+		"templates/shared.j2":     "shared from templates",
+		"app/config.j2":           `{% extends "base.j2" %}{% block body %}{% include "local.j2" %}, {% include "shared.j2" %}{% endblock %}`,
+		"app/local.j2":            "local to {{ section }}",
+		"app/missing.j2":          "first\n\n{% include \"partials/nope.j2\" %}",
+		"app/nested.j2":           `{% include "uses_missing.j2" %}`,
+		"app/uses_missing.j2":     "one\n{% include \"gone.j2\" %}",
+		"templates/syntax.j2":     "{% if %}",
+		"app/includes_syntax.j2":  `{% include "syntax.j2" %}`,
+		"app/without_includes.j2": "plain {{ section }}",
+	})
+	engine := NewTemplatingEngine(".")
+	variables := map[string]interface{}{"section": "core"}
+
+	t.Run("IncludeAndExtends", func(t *testing.T) {
+		result, err := engine.ProcessTemplateFileWithSearchPath(filepath.Join(tempDir, "app", "config.j2"), []string{templatesDir}, variables)
+		require.NoError(t, err)
+		assert.Equal(t, "[core]\nlocal to core, shared from templates\n", result)
+	})
+
+	t.Run("WithoutIncludes", func(t *testing.T) {
+		result, err := engine.ProcessTemplateFileWithSearchPath(filepath.Join(tempDir, "app", "without_includes.j2"), nil, variables)
+		require.NoError(t, err)
+		assert.Equal(t, "plain core", result)
+	})
+
+	t.Run("MissingInclude", func(t *testing.T) {
+		path := filepath.Join(tempDir, "app", "missing.j2")
+		_, err := engine.ProcessTemplateFileWithSearchPath(path, []string{templatesDir}, variables)
+		require.Error(t, err)
+		assert.Equal(t, "template error in '"+path+"' line 3: template 'partials/nope.j2' not found next to it or in "+templatesDir, err.Error())
+	})
+
+	t.Run("MissingNestedInclude", func(t *testing.T) {
+		_, err := engine.ProcessTemplateFileWithSearchPath(filepath.Join(tempDir, "app", "nested.j2"), []string{templatesDir}, variables)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "template error in '"+filepath.Join(tempDir, "app", "uses_missing.j2")+"' line 2: template 'gone.j2' not found")
+	})
+
+	t.Run("SyntaxErrorInInclude", func(t *testing.T) {
+		_, err := engine.ProcessTemplateFileWithSearchPath(filepath.Join(tempDir, "app", "includes_syntax.j2"), []string{templatesDir}, variables)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "template error in '"+filepath.Join(templatesDir, "syntax.j2")+"'")
+	})
+}
+
+func TestCheckTemplateFile(t *testing.T) {
+	tempDir := t.TempDir()
+	templatesDir := filepath.Join(tempDir, "templates")
+	writeTemplates(t, tempDir, map[string]string{
+		"templates/partial.j2": "{{ user.name }}",
+		"app/valid.j2":         "{% include \"partial.j2\" %} {{ user.emial }}",
+		"app/missing.j2":       "ok\n{% include \"nope.j2\" %}",
+	})
+	engine := NewTemplatingEngine(".")
+	variables := map[string]interface{}{"user": map[string]interface{}{"name": "Menno", "email": "menno@example.com"}}
+
+	issues := engine.CheckTemplateFile(filepath.Join(tempDir, "app", "valid.j2"), []string{templatesDir}, variables)
+	require.Len(t, issues, 1)
+	assert.Equal(t, "undefined variable 'user.emial'", issues[0].Message)
+
+	issues = engine.CheckTemplateFile(filepath.Join(tempDir, "app", "missing.j2"), []string{templatesDir}, variables)
+	require.Len(t, issues, 1)
+	assert.Equal(t, 2, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "template 'nope.j2' not found")
+}
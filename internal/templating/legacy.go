@@ -0,0 +1,145 @@
+package templating
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/internal/logger"
+)
+
+// Go template syntax, like {{ .Platform.OS }} and eq .Platform.OS "linux", is what
+// templates and conditions were written in before they were rendered with Pongo2 and
+// evaluated with Expr. It is still rendered and evaluated during a deprecation
+// window, with a warning on how to migrate.
+
+// LegacyTemplateHint explains how to migrate Go templates to Pongo2
+const LegacyTemplateHint = `Go template syntax is deprecated and will stop working in a later release. New syntax examples:
+  Old: {{ .User.Home }}
+  New: {{ User.Home }}
+  Old: {{ pathJoin .User.Home "Projects" }}
+  New: {{ pathJoin(User.Home, "Projects") }}
+  Old: {{ if eq .Platform.OS "linux" }}...{{ end }}
+  New: {% if Platform.OS == "linux" %}...{% endif %}`
+
+// LegacyConditionHint explains how to migrate Go template conditions to Expr
+const LegacyConditionHint = `legacy template syntax detected. New syntax examples:
+  Old: eq .Platform.OS "linux"
+  New: Platform.OS == "linux"
+  Old: and (eq .Platform.OS "linux") (not .Platform.IsElevated)
+  New: Platform.OS == "linux" && !Platform.IsElevated`
+
+// legacyTemplateRegex matches the actions of Go templates, which start with a field
+// of the data or with a keyword or function of Go templates
+var legacyTemplateRegex = regexp.MustCompile(`\{\{-?\s*(\.[A-Za-z_]|(if|else|end|range|with|eq|ne|lt|le|gt|ge|and|or|not|printf|index|len|pathJoin|pathSep|pathClean|env|versionCompare|versionAtLeast|commandVersion)\s)`)
+
+// legacyConditionRegex matches conditions in Go template syntax, which start with a
+// comparison or boolean function or refer to fields with a leading dot
+var legacyConditionRegex = regexp.MustCompile(`^\s*\(?\s*(eq|ne|lt|le|gt|ge|and|or|not)\s|(^|[\s(!])\.[A-Za-z_]`)
+
+// IsLegacyTemplate reports whether content is written in Go template syntax
+func IsLegacyTemplate(content string) bool {
+	return legacyTemplateRegex.MatchString(content)
+}
+
+// IsLegacyCondition reports whether a condition is written in Go template syntax
+func IsLegacyCondition(condition string) bool {
+	return legacyConditionRegex.MatchString(condition)
+}
+
+// pathFunctions are the path functions of templates, in both syntaxes
+var pathFunctions = map[string]interface{}{
+	"pathJoin":  pathJoin,
+	"pathSep":   func() string { return string(filepath.Separator) },
+	"pathClean": filepath.Clean,
+}
+
+// pathJoin joins path elements with the separator of the operating system.
+// Undefined variables are empty elements, like they render as nothing.
+func pathJoin(elements ...interface{}) string {
+	parts := make([]string, len(elements))
+	for i, element := range elements {
+		if element != nil {
+			parts[i] = fmt.Sprint(element)
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+// legacyFunctions returns the functions of Go templates and conditions: the path
+// and version functions and the condition helpers
+func legacyFunctions() template.FuncMap {
+	functions := template.FuncMap{
+		"commandExists":     commandExists,
+		"hasPackageManager": hasPackageManager,
+		"fileExists":        fileExists,
+		// Like in Sprig, {{ .key | default "value" }} gives value when key is empty
+		"default": func(fallback interface{}, value ...interface{}) interface{} {
+			if len(value) == 0 || value[0] == nil || value[0] == "" {
+				return fallback
+			}
+			return value[0]
+		},
+	}
+	for name, function := range pathFunctions {
+		functions[name] = function
+	}
+	for name, function := range versionFunctions {
+		functions[name] = function
+	}
+	return functions
+}
+
+// warnedLegacy holds the templates and conditions a deprecation warning was logged
+// for, so each is only warned about once
+var warnedLegacy sync.Map
+
+// warnLegacy logs that what is written in Go template syntax is deprecated
+func warnLegacy(kind, content, hint string) {
+	if _, warned := warnedLegacy.LoadOrStore(kind+"\x00"+content, true); warned {
+		return
+	}
+	logger.Get().Warn().Str(kind, content).Msg(hint)
+}
+
+// processLegacyTemplate renders content as a Go template. The warning names the
+// template file, or shows inline templates themselves.
+func processLegacyTemplate(content, name string, variables map[string]interface{}) (string, error) {
+	if name == inlineTemplateName {
+		warnLegacy("template", content, LegacyTemplateHint)
+	} else {
+		warnLegacy("template", name, LegacyTemplateHint)
+	}
+	return executeGoTemplate(content, name, variables)
+}
+
+// executeGoTemplate renders content as a Go template with the legacy functions and
+// the env function of variables
+func executeGoTemplate(content, name string, variables map[string]interface{}) (string, error) {
+	variables = withEnv(content, variables)
+	functions := legacyFunctions()
+	functions[envFunctionName] = envFunction(variables)
+	tmpl, err := template.New(name).Option("missingkey=zero").Funcs(functions).Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("template error in '%s': %w", name, err)
+	}
+	var result strings.Builder
+	if err := tmpl.Execute(&result, variables); err != nil {
+		return "", fmt.Errorf("template error in '%s': %w", name, err)
+	}
+	return result.String(), nil
+}
+
+// evaluateLegacyCondition evaluates a condition in Go template syntax
+func evaluateLegacyCondition(condition string, variables map[string]interface{}) (bool, error) {
+	warnLegacy("condition", condition, "Go template conditions are deprecated and will stop working in a later release, "+LegacyConditionHint)
+
+	result, err := executeGoTemplate("{{ if "+condition+" }}true{{ else }}false{{ end }}", "condition", variables)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate condition '%s': %w", condition, err)
+	}
+	return result == "true", nil
+}
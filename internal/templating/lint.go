@@ -0,0 +1,372 @@
+package templating
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+
+	"github.com/vleeuwenmenno/dotfiles-cp/pkg/utils"
+)
+
+// TemplateIssue is a problem CheckTemplate found in a template
+type TemplateIssue struct {
+	Line       int
+	Column     int
+	Message    string
+	Suggestion string // Nearest existing variable for undefined references
+	Warning    bool   // Warnings do not make the template fail
+}
+
+// String formats the issue with its position and suggestion
+func (i *TemplateIssue) String() string {
+	message := i.Message
+	if i.Suggestion != "" {
+		message += fmt.Sprintf(", did you mean '%s'?", i.Suggestion)
+	}
+	if i.Line > 0 {
+		return fmt.Sprintf("line %d: %s", i.Line, message)
+	}
+	return message
+}
+
+// templateTagRegex matches variable tags, block tags and comments
+var templateTagRegex = regexp.MustCompile(`(?s)\{\{-?(.*?)-?\}\}|\{%-?(.*?)-?%\}|\{#.*?#\}`)
+
+// templateKeywords are words of the template language that are never variables
+var templateKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "is": true, "as": true,
+	"true": true, "false": true, "True": true, "False": true,
+	"none": true, "None": true, "nil": true,
+	"reversed": true, "sorted": true, "only": true, "with": true, "forloop": true,
+}
+
+// uncheckedTags are block tags whose arguments are names or file paths rather than
+// expressions that read variables
+var uncheckedTags = map[string]bool{
+	"macro": true, "import": true, "include": true, "extends": true, "block": true,
+	"ssi": true, "templatetag": true, "now": true, "lorem": true, "autoescape": true,
+	"filter": true, "comment": true, "spaceless": true, "else": true,
+}
+
+// CheckTemplate parses a template with the same engine that renders it and returns
+// every syntax error and reference to a variable that is not defined. Variable
+// references are only checked when variables is not nil.
+func (e *TemplatingEngine) CheckTemplate(content string, variables map[string]interface{}) []*TemplateIssue {
+	if _, err := e.pongo2Set.FromString(content); err != nil {
+		if IsLegacyTemplate(content) {
+			return []*TemplateIssue{legacyTemplateIssue()}
+		}
+		return []*TemplateIssue{e.syntaxIssue(err)}
+	}
+	return e.checkReferences(content, variables)
+}
+
+// legacyTemplateIssue is the warning of a template in Go template syntax, which is
+// rendered but not checked
+func legacyTemplateIssue() *TemplateIssue {
+	return &TemplateIssue{Message: LegacyTemplateHint, Warning: true}
+}
+
+// syntaxIssue turns an error parsing a template into an issue at its position
+func (e *TemplatingEngine) syntaxIssue(err error) *TemplateIssue {
+	issue := &TemplateIssue{Message: err.Error()}
+	var pongoErr *pongo2.Error
+	if errors.As(err, &pongoErr) {
+		issue.Line, issue.Column = pongoErr.Line, pongoErr.Column
+		if pongoErr.OrigError != nil {
+			issue.Message = pongoErr.OrigError.Error()
+		}
+	} else {
+		issue.Line, issue.Column = e.extractErrorPosition(err.Error())
+	}
+	return issue
+}
+
+// checkReferences returns a template's references to variables that are not
+// defined, nothing when variables is nil
+func (e *TemplatingEngine) checkReferences(content string, variables map[string]interface{}) []*TemplateIssue {
+	if variables == nil {
+		return nil
+	}
+	variables = withEnv(content, variables)
+
+	tags := templateTagRegex.FindAllStringSubmatchIndex(content, -1)
+	locals := templateLocals(content, tags)
+
+	var issues []*TemplateIssue
+	reported := make(map[string]bool)
+	skipUntil := ""
+	for _, tag := range tags {
+		var expression, tagName string
+		switch {
+		case tag[2] >= 0:
+			expression = content[tag[2]:tag[3]]
+		case tag[4] >= 0:
+			expression = strings.TrimSpace(content[tag[4]:tag[5]])
+			tagName, expression, _ = strings.Cut(expression, " ")
+		default:
+			continue
+		}
+
+		// Nothing inside a comment block is rendered
+		if skipUntil != "" {
+			if tagName == skipUntil {
+				skipUntil = ""
+			}
+			continue
+		}
+		if tagName == "comment" {
+			skipUntil = "endcomment"
+			continue
+		}
+		if uncheckedTags[tagName] || strings.HasPrefix(tagName, "end") {
+			continue
+		}
+
+		switch tagName {
+		case "for":
+			// Only the sequence after "in" is read, the names before it are loop variables
+			if _, sequence, ok := strings.Cut(expression, " in "); ok {
+				expression = sequence
+			}
+		case "set":
+			_, expression, _ = strings.Cut(expression, "=")
+		}
+
+		// Referencing a variable in a condition or with a default is how templates
+		// handle optional variables, so those references are only warnings
+		guarded := tagName == "if" || tagName == "elif"
+		line := 1 + strings.Count(content[:tag[0]], "\n")
+
+		for _, ref := range variableReferences(expression) {
+			if locals[ref.path[0]] || e.isGlobal(ref.path[0]) {
+				continue
+			}
+			missing, suggestion := lookupVariable(variables, ref.path)
+			if missing == "" || reported[missing] {
+				continue
+			}
+			reported[missing] = true
+			issues = append(issues, &TemplateIssue{
+				Line:       line,
+				Message:    fmt.Sprintf("undefined variable '%s'", missing),
+				Suggestion: suggestion,
+				Warning:    guarded || ref.defaulted,
+			})
+		}
+	}
+
+	return issues
+}
+
+// isGlobal reports whether name is a function every template can call, such as secret
+func (e *TemplatingEngine) isGlobal(name string) bool {
+	if _, ok := e.pongo2Set.Globals[name]; ok {
+		return true
+	}
+	_, ok := pongo2.Globals[name]
+	return ok
+}
+
+// templateLocals returns the names templates define themselves with for, set, with
+// and macro, which are valid references even though they are not variables
+func templateLocals(content string, tags [][]int) map[string]bool {
+	locals := make(map[string]bool)
+	for _, tag := range tags {
+		if tag[4] < 0 {
+			continue
+		}
+		tagName, args, _ := strings.Cut(strings.TrimSpace(content[tag[4]:tag[5]]), " ")
+		switch tagName {
+		case "for":
+			names, _, _ := strings.Cut(args, " in ")
+			for _, name := range strings.Split(names, ",") {
+				locals[strings.TrimSpace(name)] = true
+			}
+		case "set":
+			name, _, _ := strings.Cut(args, "=")
+			locals[strings.TrimSpace(name)] = true
+		case "with":
+			for _, field := range strings.Fields(args) {
+				if name, _, ok := strings.Cut(field, "="); ok {
+					locals[name] = true
+				}
+			}
+			if _, name, ok := strings.Cut(args, " as "); ok {
+				locals[strings.TrimSpace(name)] = true
+			}
+		case "macro":
+			name, params, _ := strings.Cut(args, "(")
+			locals[strings.TrimSpace(name)] = true
+			params, _, _ = strings.Cut(params, ")")
+			for _, param := range strings.Split(params, ",") {
+				param, _, _ = strings.Cut(param, "=")
+				locals[strings.TrimSpace(param)] = true
+			}
+		case "import", "cycle":
+			if _, names, ok := strings.Cut(args, " as "); ok {
+				for _, name := range strings.Split(names, ",") {
+					locals[strings.TrimSpace(name)] = true
+				}
+			}
+		}
+	}
+	return locals
+}
+
+// variableReference is a dotted variable path read by a template expression
+type variableReference struct {
+	path      []string
+	defaulted bool // The reference is followed by the default filter
+}
+
+// variableReferences returns the variable paths an expression reads. String
+// literals, filter names, keywords and method calls are skipped.
+func variableReferences(expression string) []variableReference {
+	var refs []variableReference
+	previous := byte(0)
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == '"' || c == '\'':
+			// Skip string literals, including escaped quotes
+			i++
+			for i < len(expression) && expression[i] != c {
+				if expression[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			previous = c
+		case isIdentStart(c):
+			var path []string
+			for {
+				segmentStart := i
+				for i < len(expression) && isIdentPart(expression[i]) {
+					i++
+				}
+				path = append(path, expression[segmentStart:i])
+				if i+1 < len(expression) && expression[i] == '.' && isIdentPart(expression[i+1]) {
+					i++
+					continue
+				}
+				break
+			}
+			rest := strings.TrimLeft(expression[i:], " ")
+			// Filter names and attributes of call results are not variables
+			skip := previous == '|' || previous == '.' || templateKeywords[path[0]]
+			if !skip && strings.HasPrefix(rest, "(") {
+				// A call reads its receiver, the last element is the method
+				path = path[:len(path)-1]
+				skip = len(path) == 0
+			}
+			if !skip {
+				refs = append(refs, variableReference{
+					path:      path,
+					defaulted: strings.HasPrefix(rest, "|default"),
+				})
+			}
+			previous = 'a'
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			// Skip number literals such as 1.5
+			for i < len(expression) && (isIdentPart(expression[i]) || expression[i] == '.') {
+				i++
+			}
+			previous = '0'
+		default:
+			previous = c
+			i++
+		}
+	}
+	return refs
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// lookupVariable resolves a dotted path in the variables. It returns the part of the
+// path up to the first element that does not exist, or "" when the path resolves,
+// together with the nearest existing key at that level.
+func lookupVariable(variables map[string]interface{}, path []string) (missing, suggestion string) {
+	current := reflect.ValueOf(variables)
+	for i, element := range path {
+		for current.Kind() == reflect.Interface || current.Kind() == reflect.Pointer {
+			if current.IsNil() {
+				return "", ""
+			}
+			current = current.Elem()
+		}
+
+		var keys []string
+		switch current.Kind() {
+		case reflect.Map:
+			if current.Type().Key().Kind() != reflect.String {
+				return "", ""
+			}
+			value := current.MapIndex(reflect.ValueOf(element).Convert(current.Type().Key()))
+			if value.IsValid() {
+				current = value
+				continue
+			}
+			for _, key := range current.MapKeys() {
+				keys = append(keys, key.String())
+			}
+		case reflect.Struct:
+			if value := current.FieldByName(element); value.IsValid() {
+				current = value
+				continue
+			}
+			// Methods and unexported fields cannot be checked
+			return "", ""
+		case reflect.Slice, reflect.Array:
+			index, err := strconv.Atoi(element)
+			if err != nil || index < 0 || index >= current.Len() {
+				return "", ""
+			}
+			current = current.Index(index)
+			continue
+		default:
+			// Scalars have no keys, pongo2 resolves their attributes to nothing
+			return "", ""
+		}
+
+		prefix := strings.Join(path[:i], ".")
+		missing = strings.Join(path[:i+1], ".")
+		if nearest := nearestKey(element, keys); nearest != "" {
+			if prefix != "" {
+				nearest = prefix + "." + nearest
+			}
+			suggestion = nearest
+		}
+		return missing, suggestion
+	}
+	return "", ""
+}
+
+// nearestKey returns the key closest to name, or "" when no key is close enough to
+// be a likely typo. Case differences are ignored, so "user" suggests "User".
+func nearestKey(name string, keys []string) string {
+	sort.Strings(keys)
+	best, bestDistance := "", max(2, len(name)/3)+1
+	for _, key := range keys {
+		distance := utils.EditDistance(strings.ToLower(name), strings.ToLower(key))
+		if distance < bestDistance {
+			best, bestDistance = key, distance
+		}
+	}
+	return best
+}
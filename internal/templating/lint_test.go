@@ -0,0 +1,73 @@
+package templating
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTemplate_SyntaxError(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+
+	issues := engine.CheckTemplate("name = {{ User.Name }}\n{% if User.Name %}\nmissing endif\n", nil)
+	require.Len(t, issues, 1)
+	assert.False(t, issues[0].Warning)
+	assert.Greater(t, issues[0].Line, 0)
+
+	issues = engine.CheckTemplate("line one\n{{ User.Name | nosuchfilter }}\n", nil)
+	require.Len(t, issues, 1)
+	assert.Equal(t, 2, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "nosuchfilter")
+}
+
+func TestCheckTemplate_UndefinedVariables(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+	variables := map[string]interface{}{
+		"Platform": map[string]interface{}{"OS": "linux"},
+		"user": map[string]interface{}{
+			"name":  "Menno",
+			"email": "menno@example.com",
+		},
+		"editors": []interface{}{"vim", "code"},
+	}
+
+	content := `[user]
+	name = {{ user.name }}
+	email = {{ user.emial }}
+{% for editor in editors %}{{ editor|upper }} {{ forloop.Counter }}{% endfor %}
+{% set greeting = "hi " + user.name %}{{ greeting }}
+{{ secret("token") }} {{ "literal.value" }} {{ 1.5 }}
+{% if platform.OS == "linux" %}linux{% endif %}
+{{ user.shell|default:"bash" }}
+{# {{ commented.out }} #}
+{{ Platform.OS.lower }}
+`
+	issues := engine.CheckTemplate(content, variables)
+	require.Len(t, issues, 3)
+
+	assert.Equal(t, 3, issues[0].Line)
+	assert.Equal(t, "undefined variable 'user.emial'", issues[0].Message)
+	assert.Equal(t, "user.email", issues[0].Suggestion)
+	assert.False(t, issues[0].Warning)
+	assert.Equal(t, "line 3: undefined variable 'user.emial', did you mean 'user.email'?", issues[0].String())
+
+	assert.Equal(t, "undefined variable 'platform'", issues[1].Message)
+	assert.Equal(t, "Platform", issues[1].Suggestion)
+	assert.True(t, issues[1].Warning)
+
+	assert.Equal(t, "undefined variable 'user.shell'", issues[2].Message)
+	assert.Empty(t, issues[2].Suggestion)
+	assert.True(t, issues[2].Warning)
+}
+
+func TestCheckTemplate_Valid(t *testing.T) {
+	engine := NewTemplatingEngine(t.TempDir())
+	variables := map[string]interface{}{
+		"aliases": map[string]interface{}{"ll": "ls -la"},
+	}
+
+	content := `{% for name, command in aliases.items() %}alias {{ name }}='{{ command }}'
+{% endfor %}{% macro line(text, prefix="# ") %}{{ prefix }}{{ text }}{% endmacro %}{{ line("done") }}`
+	assert.Empty(t, engine.CheckTemplate(content, variables))
+}
@@ -0,0 +1,216 @@
+package templating
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+	"github.com/flosch/pongo2/v6"
+)
+
+// VariableUsage is a variable a template or condition read
+type VariableUsage struct {
+	Key      string `json:"key"`                // Dotted key that was read, e.g. "editor.default"
+	Scope    string `json:"scope"`              // What read it, e.g. the ID of a task
+	Source   string `json:"source,omitempty"`   // Where the scope is defined, as "file:line"
+	Template string `json:"template,omitempty"` // Template file it was read in, empty for inline templates and conditions
+}
+
+// UsageRecorder collects the variables templates and conditions read while it
+// records, see RecordUsages
+type UsageRecorder struct {
+	mu     sync.Mutex
+	scope  string
+	source string
+	seen   map[VariableUsage]bool
+	usages []VariableUsage
+}
+
+// NewUsageRecorder creates a recorder without usages
+func NewUsageRecorder() *UsageRecorder {
+	return &UsageRecorder{seen: make(map[VariableUsage]bool)}
+}
+
+// SetScope attributes the variables read from now on to scope, defined at source
+func (r *UsageRecorder) SetScope(scope, source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scope, r.source = scope, source
+}
+
+// Usages returns the usages recorded so far, each once, in the order they were read
+func (r *UsageRecorder) Usages() []VariableUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]VariableUsage(nil), r.usages...)
+}
+
+// add records the keys one template or condition read. Keys that were only read
+// to get to a key below them, like user for user.name, are left out.
+func (r *UsageRecorder) add(read map[string]bool, template string) {
+	keys := make([]string, 0, len(read))
+	for key := range read {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		if readBelow(read, key) {
+			continue
+		}
+		usage := VariableUsage{Key: key, Scope: r.scope, Source: r.source, Template: template}
+		if !r.seen[usage] {
+			r.seen[usage] = true
+			r.usages = append(r.usages, usage)
+		}
+	}
+}
+
+// readBelow reports whether a key below key was read
+func readBelow(read map[string]bool, key string) bool {
+	for other := range read {
+		if strings.HasPrefix(other, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// recording is the recorder templates and conditions report the variables they
+// read to, nil when they are not recorded
+var recording struct {
+	sync.Mutex
+	recorder *UsageRecorder
+}
+
+// RecordUsages makes every engine report the variables its templates and
+// conditions read to r. nil stops recording.
+func RecordUsages(r *UsageRecorder) {
+	recording.Lock()
+	defer recording.Unlock()
+	recording.recorder = r
+}
+
+// SetUsageScope attributes the variables read from now on to scope, defined at
+// source, when variables are recorded
+func SetUsageScope(scope, source string) {
+	if r := activeRecorder(); r != nil {
+		r.SetScope(scope, source)
+	}
+}
+
+// activeRecorder returns the recorder set by RecordUsages, nil when there is none
+func activeRecorder() *UsageRecorder {
+	recording.Lock()
+	defer recording.Unlock()
+	return recording.recorder
+}
+
+// templateContext returns the context to render content with and a function to
+// call after rendering it. When variables are recorded, every variable in the
+// context is a function pongo2 calls to resolve it, which records the key, and done
+// reports the keys read in template.
+func templateContext(content string, variables map[string]interface{}) (context pongo2.Context, done func(template string)) {
+	values := withEnv(content, variables)
+	r := activeRecorder()
+	if r == nil {
+		return pongo2.Context(values), func(string) {}
+	}
+
+	read := make(map[string]bool)
+	context = make(pongo2.Context, len(values))
+	for key, value := range values {
+		if _, isVariable := variables[key]; !isVariable {
+			// Functions like env are not variables
+			context[key] = value
+			continue
+		}
+		context[key] = recordingValue(key, value, read)
+	}
+	return context, func(template string) { r.add(read, template) }
+}
+
+// recordingValue returns a function resolving to value that records path in read.
+// Maps resolve to maps of such functions, so the keys read below them are recorded
+// too.
+func recordingValue(path string, value interface{}, read map[string]bool) func() interface{} {
+	return func() interface{} {
+		read[path] = true
+
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return value
+		}
+		values := make(map[string]interface{}, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			key := iter.Key().String()
+			values[key] = recordingValue(path+"."+key, iter.Value().Interface(), read)
+		}
+		return values
+	}
+}
+
+// RecordCondition records the variables a condition reads, when variables are
+// recorded, without evaluating it
+func (e *TemplatingEngine) RecordCondition(condition string, variables map[string]interface{}) {
+	r := activeRecorder()
+	if r == nil || condition == "" {
+		return
+	}
+
+	read := make(map[string]bool)
+	for _, reference := range conditionReferences(condition) {
+		if _, ok := resolveVariable(variables, strings.Split(reference, ".")); ok {
+			read[reference] = true
+		}
+	}
+	r.add(read, "")
+}
+
+// conditionReferences returns the dotted variable references of a condition, like
+// Platform.OS, from its syntax tree. Conditions that do not parse have none.
+func conditionReferences(condition string) []string {
+	tree, err := parser.Parse(condition)
+	if err != nil {
+		return nil
+	}
+	collector := &referenceCollector{}
+	ast.Walk(&tree.Node, collector)
+	return collector.references
+}
+
+// referenceCollector collects the identifiers and member accesses of a syntax tree
+type referenceCollector struct {
+	references []string
+}
+
+func (c *referenceCollector) Visit(node *ast.Node) {
+	if reference, ok := memberPath(*node); ok {
+		c.references = append(c.references, reference)
+	}
+}
+
+// memberPath returns the dotted path of an identifier or a chain of member accesses
+// with constant names, like Platform.OS or user["name"]
+func memberPath(node ast.Node) (string, bool) {
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		return n.Value, true
+	case *ast.MemberNode:
+		property, ok := n.Property.(*ast.StringNode)
+		if !ok || n.Method {
+			return "", false
+		}
+		parent, ok := memberPath(n.Node)
+		if !ok {
+			return "", false
+		}
+		return parent + "." + property.Value, true
+	}
+	return "", false
+}
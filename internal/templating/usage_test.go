@@ -0,0 +1,98 @@
+package templating
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordUsages records the usages of the engines until the test ends
+func recordUsages(t *testing.T) *UsageRecorder {
+	t.Helper()
+	r := NewUsageRecorder()
+	RecordUsages(r)
+	t.Cleanup(func() { RecordUsages(nil) })
+	return r
+}
+
+// usageKeys returns the keys of usages in the order they were recorded
+func usageKeys(usages []VariableUsage) []string {
+	keys := make([]string, 0, len(usages))
+	for _, usage := range usages {
+		keys = append(keys, usage.Key)
+	}
+	return keys
+}
+
+func TestRecordTemplateUsages(t *testing.T) {
+	engine := NewTemplatingEngine(".")
+	variables := map[string]interface{}{
+		"editor":  map[string]interface{}{"default": "code", "terminal": "vim"},
+		"aliases": map[string]interface{}{"ll": "ls -la"},
+		"Env":     map[string]string{"HOME": "/home/user"},
+		"unused":  "value",
+		"enabled": true,
+	}
+
+	t.Run("ReadsNestedKeys", func(t *testing.T) {
+		r := recordUsages(t)
+		SetUsageScope("ensure_file: ~/.editor", "jobs/index.yaml:3")
+
+		result, err := engine.ProcessString("{{ editor.default }} in {{ Env.HOME }}{% if enabled %}!{% endif %}", variables)
+		require.NoError(t, err)
+		assert.Equal(t, "code in /home/user!", result)
+
+		assert.ElementsMatch(t, []string{"editor.default", "Env.HOME", "enabled"}, usageKeys(r.Usages()))
+		for _, usage := range r.Usages() {
+			assert.Equal(t, "ensure_file: ~/.editor", usage.Scope)
+			assert.Equal(t, "jobs/index.yaml:3", usage.Source)
+			assert.Empty(t, usage.Template, "inline templates have no file")
+		}
+	})
+
+	t.Run("ReadsLoopedMaps", func(t *testing.T) {
+		r := recordUsages(t)
+
+		result, err := engine.ProcessString("{% for name, command in aliases %}{{ name }}={{ command }}{% endfor %}", variables)
+		require.NoError(t, err)
+		assert.Equal(t, "ll=ls -la", result)
+		assert.Equal(t, []string{"aliases.ll"}, usageKeys(r.Usages()))
+	})
+
+	t.Run("TemplateFile", func(t *testing.T) {
+		r := recordUsages(t)
+		dir := t.TempDir()
+		writeTemplates(t, dir, map[string]string{"editor.j2": "{{ editor.terminal }}"})
+		path := filepath.Join(dir, "editor.j2")
+
+		_, err := engine.ProcessTemplateFile(path, variables)
+		require.NoError(t, err)
+		assert.Equal(t, []VariableUsage{{Key: "editor.terminal", Template: path}}, r.Usages())
+	})
+
+	t.Run("NotRecording", func(t *testing.T) {
+		r := NewUsageRecorder()
+		result, err := engine.ProcessString("{{ editor.default }}", variables)
+		require.NoError(t, err)
+		assert.Equal(t, "code", result)
+		assert.Empty(t, r.Usages())
+	})
+}
+
+func TestRecordConditionUsages(t *testing.T) {
+	engine := NewTemplatingEngine(".")
+	variables := map[string]interface{}{
+		"Platform": map[string]interface{}{"OS": "linux"},
+		"git":      map[string]interface{}{"signing_key": ""},
+	}
+	r := recordUsages(t)
+
+	_, err := engine.EvaluateCondition(`Platform.OS == "linux" && git["signing_key"] != "" || commandExists("git")`, variables)
+	require.NoError(t, err)
+
+	// Functions and keys that are not defined are not variables that were read
+	engine.RecordCondition("missing.key == 1", variables)
+	assert.Equal(t, []string{"Platform.OS", "git.signing_key"}, usageKeys(r.Usages()))
+}
@@ -0,0 +1,159 @@
+package templating
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// versionRegex matches the first version-looking token of a string, like 22.04 in
+// "22.04" and 2.43.0 in "git version 2.43.0.windows.1", with an optional
+// pre-release suffix like -rc1
+var versionRegex = regexp.MustCompile(`(\d+(?:\.\d+)*)(?:-([0-9A-Za-z.]+))?`)
+
+// commandVersionTimeout limits how long commandVersion waits for a command
+const commandVersionTimeout = 10 * time.Second
+
+// versionFunctions are the version functions of templates and conditions, in every
+// syntax
+var versionFunctions = map[string]interface{}{
+	"versionCompare": versionCompare,
+	"versionAtLeast": versionAtLeast,
+	"commandVersion": commandVersion,
+}
+
+// version is a parsed version: its numeric parts and its pre-release suffix
+type version struct {
+	parts      []int
+	prerelease string
+}
+
+// parseVersion finds the first version in s, tolerating what distributions and
+// tools put around it like "12 (bookworm)" or "v2.40.1". ok is false when s holds no
+// version.
+func parseVersion(s string) (v version, ok bool) {
+	match := versionRegex.FindStringSubmatch(s)
+	if match == nil {
+		return version{}, false
+	}
+	for _, part := range strings.Split(match[1], ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return version{}, false
+		}
+		v.parts = append(v.parts, number)
+	}
+	v.prerelease = match[2]
+	return v, true
+}
+
+// compare returns -1, 0 or 1 when v is older than, the same as or newer than other.
+// Missing parts count as 0, so 22.04 is 22.4.0, and a pre-release is older than the
+// release it leads up to.
+func (v version) compare(other version) int {
+	for i := 0; i < len(v.parts) || i < len(other.parts); i++ {
+		a, b := 0, 0
+		if i < len(v.parts) {
+			a = v.parts[i]
+		}
+		if i < len(other.parts) {
+			b = other.parts[i]
+		}
+		if a != b {
+			return sign(a - b)
+		}
+	}
+	switch {
+	case v.prerelease == other.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case other.prerelease == "":
+		return -1
+	}
+	return strings.Compare(v.prerelease, other.prerelease)
+}
+
+// sign returns -1, 0 or 1 for negative, zero and positive n
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// versionCompare returns -1, 0 or 1 when version a is older than, the same as or
+// newer than version b. Values without a version, like an empty
+// Platform.DistroVersion, are older than every version.
+func versionCompare(a, b interface{}) int {
+	va, okA := parseVersion(versionString(a))
+	vb, okB := parseVersion(versionString(b))
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	return va.compare(vb)
+}
+
+// versionAtLeast reports whether current holds a version that is the same as or
+// newer than minimum
+func versionAtLeast(current, minimum interface{}) bool {
+	if _, ok := parseVersion(versionString(current)); !ok {
+		return false
+	}
+	return versionCompare(current, minimum) >= 0
+}
+
+// versionString returns a value as the string a version is parsed from, empty for
+// undefined variables
+func versionString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// commandVersions caches the version of every command commandVersion ran, so each
+// command runs once per process
+var commandVersions sync.Map
+
+// runVersionCommand runs a command with --version and returns what it prints,
+// replaced by tests
+var runVersionCommand = func(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandVersionTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, command, "--version").CombinedOutput()
+	return string(output), err
+}
+
+// commandVersion returns the version a command prints with --version, like 2.43.0
+// for git, or an empty string when the command is not installed or prints no
+// version. The command runs once, later calls reuse its version.
+func commandVersion(command interface{}) string {
+	name := versionString(command)
+	if cached, ok := commandVersions.Load(name); ok {
+		return cached.(string)
+	}
+
+	found := ""
+	if name != "" {
+		// A command that is not installed or fails has no version
+		if output, err := runVersionCommand(name); err == nil {
+			found = versionRegex.FindString(output)
+		}
+	}
+	actual, _ := commandVersions.LoadOrStore(name, found)
+	return actual.(string)
+}
@@ -0,0 +1,120 @@
+package templating
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCommandVersions replaces the commands commandVersion runs with output, by
+// command, until the test ends. Commands without output are not installed.
+func fakeCommandVersions(t *testing.T, output map[string]string) *int {
+	previous := runVersionCommand
+	runs := 0
+	runVersionCommand = func(command string) (string, error) {
+		runs++
+		if out, ok := output[command]; ok {
+			return out, nil
+		}
+		return "", errors.New("executable file not found in $PATH")
+	}
+	commandVersions.Range(func(key, _ interface{}) bool {
+		commandVersions.Delete(key)
+		return true
+	})
+	t.Cleanup(func() {
+		runVersionCommand = previous
+		commandVersions.Range(func(key, _ interface{}) bool {
+			commandVersions.Delete(key)
+			return true
+		})
+	})
+	return &runs
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"22.04", "22.04", 0},
+		{"22.04", "22.4", 0},
+		{"22.04", "20.04", 1},
+		{"2.9", "2.40", -1},
+		{"2.40", "2.40.0", 0},
+		{"2.40.1", "2.40", 1},
+		{"12 (bookworm)", "11", 1},
+		{"v1.2.3", "1.2.3", 0},
+		{"git version 2.43.0.windows.1", "2.43", 0},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0-rc2", "1.0.0-rc1", 1},
+		{"", "1", -1},
+		{"rolling", "", 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, versionCompare(tt.a, tt.b), "versionCompare(%q, %q)", tt.a, tt.b)
+		assert.Equal(t, -tt.want, versionCompare(tt.b, tt.a), "versionCompare(%q, %q)", tt.b, tt.a)
+	}
+
+	assert.True(t, versionAtLeast("22.04", "22.04"))
+	assert.True(t, versionAtLeast("24.04", "22.04"))
+	assert.False(t, versionAtLeast("20.04", "22.04"))
+	assert.False(t, versionAtLeast("", "0"), "no version is never at least a version")
+	assert.False(t, versionAtLeast(nil, "1"), "undefined variables are no version")
+	assert.True(t, versionAtLeast(13, "12"), "numbers are versions too")
+}
+
+func TestCommandVersion(t *testing.T) {
+	runs := fakeCommandVersions(t, map[string]string{
+		"git":  "git version 2.43.0\n",
+		"node": "v20.11.1\n",
+		"odd":  "no version here\n",
+	})
+
+	assert.Equal(t, "2.43.0", commandVersion("git"))
+	assert.Equal(t, "2.43.0", commandVersion("git"))
+	assert.Equal(t, 1, *runs, "a command runs once")
+
+	assert.Equal(t, "20.11.1", commandVersion("node"))
+	assert.Equal(t, "", commandVersion("odd"))
+	assert.Equal(t, "", commandVersion("missing"))
+}
+
+func TestVersionFunctionsInConditionsAndTemplates(t *testing.T) {
+	fakeCommandVersions(t, map[string]string{"git": "git version 2.43.0\n"})
+	engine := NewTemplatingEngine(t.TempDir())
+	variables := map[string]interface{}{
+		"Platform": map[string]interface{}{"Distro": "Debian GNU/Linux", "DistroVersion": "12 (bookworm)"},
+	}
+
+	conditions := map[string]bool{
+		`versionAtLeast(Platform.DistroVersion, "12")`:           true,
+		`versionAtLeast(Platform.DistroVersion, "22.04")`:        false,
+		`versionCompare(Platform.DistroVersion, "11.7") == 1`:    true,
+		`versionAtLeast(commandVersion("git"), "2.40")`:          true,
+		`versionAtLeast(commandVersion("not-installed"), "1.0")`: false,
+		`versionAtLeast(Platform.Missing, "1.0")`:                false,
+		`versionAtLeast .Platform.DistroVersion "12"`:            true,
+		`eq (versionCompare (commandVersion "git") "2.43.0") 0`:  true,
+	}
+	for condition, want := range conditions {
+		result, err := engine.EvaluateCondition(condition, variables)
+		require.NoError(t, err, condition)
+		assert.Equal(t, want, result, condition)
+	}
+
+	templates := map[string]string{
+		`{% if versionAtLeast(Platform.DistroVersion, "12") %}new{% else %}old{% endif %}`: "new",
+		`git {{ commandVersion("git") }}, {{ versionCompare("2.9", "2.40") }}`:             "git 2.43.0, -1",
+		`{{ if versionAtLeast (commandVersion "git") "2.50" }}new{{ else }}old{{ end }}`:   "old",
+	}
+	for template, want := range templates {
+		result, err := engine.ProcessString(template, variables)
+		require.NoError(t, err, template)
+		assert.Equal(t, want, result, template)
+	}
+
+	assert.Empty(t, engine.CheckTemplate(`{{ versionCompare("1", "2") }}{{ commandVersion("git") }}`, variables))
+}
@@ -0,0 +1,114 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// captureDrainTimeout is how long Stop waits for output after the capture ended.
+// Background processes started by a task can keep the pipe open indefinitely.
+const captureDrainTimeout = 2 * time.Second
+
+// Capture collects everything written to os.Stdout and os.Stderr, by this
+// process and by commands it runs, until Stop
+type Capture struct {
+	stdout *os.File
+	stderr *os.File
+
+	reader *os.File
+	writer *os.File
+	output lockedBuffer
+	done   chan struct{}
+}
+
+// lockedBuffer is a buffer the pipe can still be copied into after Stop gave up
+// waiting for it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// take returns the buffered output and empties the buffer
+func (b *lockedBuffer) take() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := bytes.Clone(b.buf.Bytes())
+	b.buf.Reset()
+	return data
+}
+
+// StartCapture redirects os.Stdout and os.Stderr until Stop is called
+func StartCapture() (*Capture, error) {
+	c := &Capture{stdout: os.Stdout, stderr: os.Stderr}
+	if err := c.start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Stop restores os.Stdout and os.Stderr and returns the captured output
+func (c *Capture) Stop() string {
+	c.finish()
+	return string(c.output.take())
+}
+
+// Passthrough writes what was captured so far to the real output and runs fn
+// without capturing, e.g. to ask the user something
+func (c *Capture) Passthrough(fn func()) {
+	c.finish()
+	c.stdout.Write(c.output.take())
+
+	fn()
+
+	if err := c.start(); err != nil {
+		fmt.Fprintf(c.stderr, "failed to capture output: %v\n", err)
+	}
+}
+
+// start redirects the output into a new pipe
+func (c *Capture) start() error {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	c.reader, c.writer = reader, writer
+	c.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		io.Copy(&c.output, reader)
+	}(c.done)
+
+	os.Stdout = writer
+	os.Stderr = writer
+	return nil
+}
+
+// finish restores the real output and waits until the pipe is drained
+func (c *Capture) finish() {
+	if c.writer == nil {
+		return
+	}
+	os.Stdout = c.stdout
+	os.Stderr = c.stderr
+	c.writer.Close()
+
+	select {
+	case <-c.done:
+	case <-time.After(captureDrainTimeout):
+		// Another process still holds the pipe open, whatever it writes later
+		// is dropped once the reader is closed
+	}
+	c.reader.Close()
+	c.reader, c.writer = nil, nil
+}
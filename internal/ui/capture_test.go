@@ -0,0 +1,64 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	capture, err := StartCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("from the process")
+	fmt.Fprintln(os.Stderr, "to stderr")
+	if runtime.GOOS != "windows" {
+		cmd := exec.Command("echo", "from a command")
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			capture.Stop()
+			t.Fatal(err)
+		}
+	}
+	output := capture.Stop()
+
+	if os.Stdout != stdout || os.Stderr != stderr {
+		t.Fatal("expected os.Stdout and os.Stderr to be restored")
+	}
+	for _, want := range []string{"from the process", "to stderr"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in captured output %q", want, output)
+		}
+	}
+	if runtime.GOOS != "windows" && !strings.Contains(output, "from a command") {
+		t.Errorf("expected command output in %q", output)
+	}
+}
+
+func TestCapturePassthrough(t *testing.T) {
+	capture, err := StartCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Print("before ")
+
+	var passedStdout *os.File
+	capture.Passthrough(func() {
+		passedStdout = os.Stdout
+	})
+	fmt.Print("after")
+	output := capture.Stop()
+
+	if passedStdout != capture.stdout {
+		t.Error("expected the real stdout during Passthrough")
+	}
+	if output != "after" {
+		t.Errorf("captured output = %q, want only what was written after Passthrough", output)
+	}
+}
@@ -0,0 +1,35 @@
+package ui
+
+import "os"
+
+// Palette colors terminal output, or leaves it plain when colors are disabled
+type Palette struct {
+	enabled bool
+}
+
+// NewPalette enables colors when out is a terminal, NO_COLOR is not set and
+// colors were not turned off with Configure
+func NewPalette(out *os.File) *Palette {
+	if ColorDisabled() || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return &Palette{}
+	}
+	return &Palette{enabled: IsTerminal(out)}
+}
+
+// Enabled reports whether the palette colors its output
+func (p *Palette) Enabled() bool {
+	return p.enabled
+}
+
+func (p *Palette) color(code, s string) string {
+	if !p.enabled {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+func (p *Palette) Bold(s string) string   { return p.color("1", s) }
+func (p *Palette) Dim(s string) string    { return p.color("2", s) }
+func (p *Palette) Red(s string) string    { return p.color("31", s) }
+func (p *Palette) Green(s string) string  { return p.color("32", s) }
+func (p *Palette) Yellow(s string) string { return p.color("33", s) }
@@ -0,0 +1,157 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	progressBarWidth = 20
+	progressInterval = 100 * time.Millisecond
+
+	// progressElapsedAfter is how long a task runs before the bar shows for how long
+	progressElapsedAfter = 3 * time.Second
+)
+
+// spinnerFrames are the frames of Spinner
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Spinner cycles through the frames of a terminal spinner
+type Spinner struct {
+	frame int
+}
+
+// Next returns the next frame of the spinner
+func (s *Spinner) Next() string {
+	frame := spinnerFrames[s.frame%len(spinnerFrames)]
+	s.frame++
+	return frame
+}
+
+// Progress draws a single line progress bar that is updated in place, e.g.
+// "⠋ [████░░░░] task 12/63 — Install ripgrep (install_package)". The bar is
+// only drawn between Start and Stop, anything printed in between has to wait
+// for Stop so it does not end up on the line of the bar.
+type Progress struct {
+	out   io.Writer
+	width int
+	total int
+
+	mu      sync.Mutex
+	current int
+	label   string
+	started time.Time
+	spinner Spinner
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewProgress returns a progress bar for total tasks drawn on out, or nil when
+// out is not a terminal
+func NewProgress(out *os.File, total int) *Progress {
+	if os.Getenv("TERM") == "dumb" || !IsTerminal(out) {
+		return nil
+	}
+	return newProgress(out, TerminalWidth(out), total)
+}
+
+func newProgress(out io.Writer, width, total int) *Progress {
+	return &Progress{out: out, width: width, total: total}
+}
+
+// Start draws the bar for task current of the total and keeps its spinner
+// turning until Stop
+func (p *Progress) Start(current int, label string) {
+	p.mu.Lock()
+	p.current = current
+	p.label = label
+	p.started = time.Now()
+	p.mu.Unlock()
+	p.Resume()
+}
+
+// Resume draws the bar of the current task again after Stop, e.g. once a
+// prompt was answered
+func (p *Progress) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		return
+	}
+	p.draw()
+
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.spin(p.stop, p.done)
+}
+
+// Stop removes the bar so the line can be used for other output
+func (p *Progress) Stop() {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+	p.mu.Unlock()
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	<-done
+	fmt.Fprintf(p.out, "\r%s\r", strings.Repeat(" ", p.width-1))
+}
+
+// spin redraws the bar until stop is closed
+func (p *Progress) spin(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// draw writes the bar over the current line, the caller holds p.mu
+func (p *Progress) draw() {
+	fmt.Fprintf(p.out, "\r%s", p.line())
+}
+
+// line renders the bar, padded to overwrite everything drawn before and cut
+// off so it never wraps onto a second line
+func (p *Progress) line() string {
+	filled := 0
+	if p.total > 0 {
+		filled = progressBarWidth * (p.current - 1) / p.total
+	}
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", progressBarWidth-filled)
+
+	line := fmt.Sprintf("%s [%s] task %d/%d — %s", p.spinner.Next(), bar, p.current, p.total, p.label)
+	if elapsed := time.Since(p.started); elapsed >= progressElapsedAfter {
+		line += fmt.Sprintf(" (%s)", elapsed.Round(time.Second))
+	}
+	return fitLine(line, p.width-1)
+}
+
+// fitLine cuts line to width runes or pads it with spaces to width runes
+func fitLine(line string, width int) string {
+	length := utf8.RuneCountInString(line)
+	if length > width {
+		runes := []rune(line)
+		if width > 1 {
+			return string(runes[:width-1]) + "…"
+		}
+		return string(runes[:width])
+	}
+	return line + strings.Repeat(" ", width-length)
+}
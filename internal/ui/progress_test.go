@@ -0,0 +1,69 @@
+package ui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestProgressLine(t *testing.T) {
+	p := newProgress(&bytes.Buffer{}, 80, 4)
+	p.current = 3
+	p.label = "Install ripgrep (install_package)"
+
+	line := p.line()
+	if !strings.Contains(line, "[██████████░░░░░░░░░░] task 3/4 — Install ripgrep (install_package)") {
+		t.Errorf("unexpected line %q", line)
+	}
+	if got := utf8.RuneCountInString(line); got != 79 {
+		t.Errorf("line is %d runes wide, want 79", got)
+	}
+}
+
+func TestProgressStartStop(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgress(&out, 60, 2)
+
+	p.Start(1, "first")
+	p.Stop()
+	p.Stop()
+
+	if !strings.Contains(out.String(), "task 1/2 — first") {
+		t.Errorf("expected the bar to be drawn, got %q", out.String())
+	}
+	if !strings.HasSuffix(out.String(), "\r"+strings.Repeat(" ", 59)+"\r") {
+		t.Errorf("expected the bar to be cleared, got %q", out.String())
+	}
+}
+
+func TestFitLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		width int
+		want  string
+	}{
+		{line: "task 1/2", width: 10, want: "task 1/2  "},
+		{line: "task 1/2", width: 8, want: "task 1/2"},
+		{line: "task 1/2 — long label", width: 10, want: "task 1/2 …"},
+		{line: "task", width: 1, want: "t"},
+	}
+	for _, tt := range tests {
+		if got := fitLine(tt.line, tt.width); got != tt.want {
+			t.Errorf("fitLine(%q, %d) = %q, want %q", tt.line, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestSpinner(t *testing.T) {
+	var s Spinner
+	first := s.Next()
+	for i := 1; i < len(spinnerFrames); i++ {
+		if s.Next() == first {
+			t.Fatalf("frame %d repeats the first frame", i)
+		}
+	}
+	if s.Next() != first {
+		t.Error("expected the spinner to start over")
+	}
+}
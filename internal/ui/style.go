@@ -0,0 +1,92 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// style holds the output settings chosen on the command line
+var style = struct {
+	sync.Mutex
+	noColor bool
+	ascii   bool
+}{}
+
+// Configure sets whether output is colored and whether it uses emoji and other
+// non-ASCII glyphs. Colors are also left out when NO_COLOR is set or the output
+// is not a terminal.
+func Configure(noColor, ascii bool) {
+	style.Lock()
+	defer style.Unlock()
+	style.noColor = noColor
+	style.ascii = ascii
+}
+
+// ColorDisabled reports whether colors were turned off with --no-color
+func ColorDisabled() bool {
+	style.Lock()
+	defer style.Unlock()
+	return style.noColor
+}
+
+// ASCII reports whether output is limited to ASCII
+func ASCII() bool {
+	style.Lock()
+	defer style.Unlock()
+	return style.ascii
+}
+
+// asciiGlyphs replaces the glyphs used in output with plain text. Emoji become a
+// bracketed prefix; an emoji padded with an extra space for its width keeps a
+// single space.
+var asciiGlyphs = strings.NewReplacer(
+	"📁 ", "[config] ",
+	"⏳ ", "[busy] ",
+	"📝 ", "[drift] ",
+	"📥 ", "[imports] ",
+	"🔄 ", "[git] ",
+	"⚡ ", "[remote] ",
+	"🔧 ", "[system] ",
+	"⚠️  ", "[warn] ",
+	"⚠️ ", "[warn] ",
+	"🔥 ", "[error] ",
+	"💡 ", "[hint] ",
+	"📋 ", "[plan] ",
+	"📦 ", "[module] ",
+	"📊 ", "[summary] ",
+	"🔎 ", "[explain] ",
+	"🏷️  ", "[profiles] ",
+	"🔖 ", "[tags] ",
+	"⏭️  ", "[skip] ",
+	"└── ", "`-- ",
+	"├── ", "|-- ",
+	"│", "|",
+	"─", "-",
+	"→", "->",
+)
+
+// Text returns s as it should be printed: unchanged, or with its glyphs
+// replaced by plain text in ASCII mode
+func Text(s string) string {
+	if !ASCII() {
+		return s
+	}
+	return asciiGlyphs.Replace(s)
+}
+
+// Sprintf formats like fmt.Sprintf after passing format through Text, so the
+// glyphs of the message are replaced but the values it prints are not
+func Sprintf(format string, args ...interface{}) string {
+	return fmt.Sprintf(Text(format), args...)
+}
+
+// Printf prints like fmt.Printf after passing format through Text
+func Printf(format string, args ...interface{}) {
+	fmt.Print(Sprintf(format, args...))
+}
+
+// Println prints s through Text followed by a newline
+func Println(s string) {
+	fmt.Println(Text(s))
+}
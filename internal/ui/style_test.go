@@ -0,0 +1,66 @@
+package ui
+
+import "testing"
+
+func TestText(t *testing.T) {
+	status := "📁 Configuration: 12 files managed (3 symlinks, 1 broken)\n" +
+		"  └── Last applied: 2024-01-02 15:04 UTC\n" +
+		"🔄 Git: main branch, clean\n" +
+		"⚡ Remote: Up to date\n" +
+		"🔧 Integration: bash, apt\n" +
+		"🔄 Recent Remote Commits:\n" +
+		"  ├── abc123 Update zshrc\n" +
+		"⚠️  1 broken symlinks detected\n"
+
+	t.Cleanup(func() { Configure(false, false) })
+	tests := []struct {
+		name  string
+		ascii bool
+		want  string
+	}{
+		{name: "emoji", want: status},
+		{
+			name:  "ascii",
+			ascii: true,
+			want: "[config] Configuration: 12 files managed (3 symlinks, 1 broken)\n" +
+				"  `-- Last applied: 2024-01-02 15:04 UTC\n" +
+				"[git] Git: main branch, clean\n" +
+				"[remote] Remote: Up to date\n" +
+				"[system] Integration: bash, apt\n" +
+				"[git] Recent Remote Commits:\n" +
+				"  |-- abc123 Update zshrc\n" +
+				"[warn] 1 broken symlinks detected\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Configure(false, tt.ascii)
+			if got := Text(status); got != tt.want {
+				t.Errorf("Text() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSprintfKeepsValues(t *testing.T) {
+	t.Cleanup(func() { Configure(false, false) })
+	Configure(false, true)
+
+	got := Sprintf("📦 %s → %s\n", "📁 notes", "done")
+	if want := "[module] 📁 notes -> done\n"; got != want {
+		t.Errorf("Sprintf() = %q, want %q", got, want)
+	}
+}
+
+func TestConfigureDisablesColor(t *testing.T) {
+	t.Cleanup(func() { Configure(false, false) })
+	Configure(true, false)
+
+	p := NewPalette(nil)
+	if p.Enabled() {
+		t.Error("expected colors to be disabled by Configure")
+	}
+	if got := p.Red("x"); got != "x" {
+		t.Errorf("Red() = %q, want plain text", got)
+	}
+}
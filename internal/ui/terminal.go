@@ -0,0 +1,18 @@
+package ui
+
+import "os"
+
+// IsTerminal reports whether f is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	_, ok := terminalWidth(f)
+	return ok
+}
+
+// TerminalWidth returns the width of the terminal f writes to, or 80 when it
+// cannot be determined
+func TerminalWidth(f *os.File) int {
+	if width, ok := terminalWidth(f); ok && width > 0 {
+		return width
+	}
+	return 80
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package ui
+
+import "os"
+
+// terminalWidth never detects a terminal on platforms without one
+func terminalWidth(f *os.File) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// The steps of atomic writes that can fail after the new content is written,
+// replaced by tests to simulate a failure before the target is replaced
+var (
+	syncFile    = (*os.File).Sync
+	replaceFile = renameReplacing
+)
+
+// tempName returns a name in the directory of path for a file that replaces it
+func tempName(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".dotfiles-"+strconv.FormatUint(rand.Uint64(), 36))
+}
+
+// WriteFileAtomic replaces the file at path with data. The data is written to a
+// temporary file in the same directory, synced to disk, given perm and the owner of
+// the file it replaces, and renamed over path. Programs reading path see either
+// the old or the new content, never part of it, and path is left as it was when
+// anything fails. When path is a symlink, the file it points to is replaced.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeAtomic(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// CopyFileAtomic replaces the file at dst with a copy of src, like
+// WriteFileAtomic. The content is streamed, so large files are never held in
+// memory, and copied byte for byte.
+func CopyFileAtomic(src, dst string, perm os.FileMode) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return writeAtomic(dst, perm, func(w io.Writer) error {
+		_, err := io.Copy(w, source)
+		return err
+	})
+}
+
+// writeAtomic replaces the file at path with what write writes to it
+func writeAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+	}
+	existing, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	tmp, err := os.OpenFile(tempName(path), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := syncFile(tmp); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if existing != nil {
+		keepOwner(tmp.Name(), existing)
+	}
+
+	if err := replaceFile(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	committed = true
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// ReplaceSymlink makes dst a symbolic link to src. The link is created at a
+// temporary name next to dst and renamed over it, so dst is never missing while
+// it is replaced. An empty directory at dst is removed first, a rename cannot
+// replace it.
+func ReplaceSymlink(src, dst string) error {
+	if info, err := os.Lstat(dst); err == nil && info.IsDir() {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+
+	tmp := tempName(dst)
+	if err := os.Symlink(src, tmp); err != nil {
+		return err
+	}
+	if err := replaceFile(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
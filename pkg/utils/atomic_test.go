@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// failReplace makes replacing files fail until the test ends, after the new
+// content was written and synced
+func failReplace(t *testing.T) {
+	previous := replaceFile
+	replaceFile = func(oldpath, newpath string) error { return errors.New("crashed") }
+	t.Cleanup(func() { replaceFile = previous })
+}
+
+// assertOnlyFiles fails when dir holds other files than names, like temporary files
+// that were left behind
+func assertOnlyFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(names) {
+		var found []string
+		for _, entry := range entries {
+			found = append(found, entry.Name())
+		}
+		t.Errorf("directory holds %v, want %v", found, names)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".bashrc")
+
+	if err := WriteFileAtomic(path, []byte("export A=1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte("export A=2\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "export A=2\n" {
+		t.Errorf("content = %q, want the second write", data)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %04o, want 0640", info.Mode().Perm())
+	}
+	assertOnlyFiles(t, dir, ".bashrc")
+}
+
+func TestWriteFileAtomicFailureKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".bashrc")
+	if err := os.WriteFile(path, []byte("original\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	failReplace(t)
+	if err := WriteFileAtomic(path, []byte("new\n"), 0644); err == nil {
+		t.Fatal("WriteFileAtomic() succeeded, want the error of the rename")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "original\n" {
+		t.Errorf("content after a failed write = %q, want the original", data)
+	}
+	assertOnlyFiles(t, dir, ".bashrc")
+}
+
+func TestWriteFileAtomicSyncFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+
+	previous := syncFile
+	syncFile = func(*os.File) error { return errors.New("disk full") }
+	t.Cleanup(func() { syncFile = previous })
+
+	if err := WriteFileAtomic(path, []byte("new\n"), 0644); err == nil {
+		t.Fatal("WriteFileAtomic() succeeded, want the error of the sync")
+	}
+	if FileExists(path) {
+		t.Errorf("%s was created by a failed write", path)
+	}
+	assertOnlyFiles(t, dir)
+}
+
+func TestWriteFileAtomicThroughSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs privileges on Windows")
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, "gitconfig")
+	link := filepath.Join(dir, ".gitconfig")
+	if err := os.WriteFile(target, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(link, []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !IsSymlink(link) {
+		t.Errorf("%s was replaced by a file, want the file it points to replaced", link)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "new\n" {
+		t.Errorf("content of the link target = %q, want the new content", data)
+	}
+}
+
+func TestCopyFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "font.ttf")
+	dst := filepath.Join(dir, "fonts", "font.ttf")
+	data := []byte("\x00\x01\x00\x00\xff\xfe\r\n{{ name }}\r\n\x00")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyFileAtomic(src, dst, 0755); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(copied) != string(data) {
+		t.Errorf("content = %q, want the source byte for byte", copied)
+	}
+	if info, err := os.Stat(dst); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %04o, want 0755", info.Mode().Perm())
+	}
+	assertOnlyFiles(t, filepath.Dir(dst), "font.ttf")
+
+	// A failed copy leaves the target as it was
+	failReplace(t)
+	if err := os.WriteFile(src, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyFileAtomic(src, dst, 0755); err == nil {
+		t.Fatal("CopyFileAtomic() succeeded, want the error of the rename")
+	}
+	if copied, _ := os.ReadFile(dst); string(copied) != string(data) {
+		t.Errorf("content after a failed copy = %q, want the previous copy", copied)
+	}
+	assertOnlyFiles(t, filepath.Dir(dst), "font.ttf")
+
+	if err := CopyFileAtomic(filepath.Join(dir, "missing"), dst, 0644); !os.IsNotExist(err) {
+		t.Errorf("CopyFileAtomic() of a missing source = %v, want a not exist error", err)
+	}
+}
+
+func TestReplaceSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs privileges on Windows")
+	}
+	dir := t.TempDir()
+	link := filepath.Join(dir, "link")
+	if err := os.WriteFile(link, []byte("a file in the way\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{filepath.Join(dir, "first"), filepath.Join(dir, "second")} {
+		if err := ReplaceSymlink(src, link); err != nil {
+			t.Fatal(err)
+		}
+		if target, err := os.Readlink(link); err != nil || target != src {
+			t.Errorf("link points to %q (%v), want %q", target, err, src)
+		}
+	}
+
+	failReplace(t)
+	if err := ReplaceSymlink(filepath.Join(dir, "third"), link); err == nil {
+		t.Fatal("ReplaceSymlink() succeeded, want the error of the rename")
+	}
+	if target, _ := os.Readlink(link); target != filepath.Join(dir, "second") {
+		t.Errorf("link points to %q after a failed replace, want the previous target", target)
+	}
+	assertOnlyFiles(t, dir, "link")
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// renameReplacing renames oldpath to newpath, replacing newpath when it exists
+func renameReplacing(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// keepOwner gives path the owner and group of the file it replaces. It does
+// nothing when that is not permitted, the new file then belongs to the user
+// dotfiles runs as.
+func keepOwner(path string, replaced os.FileInfo) {
+	stat, ok := replaced.Sys().(*syscall.Stat_t)
+	if !ok || (int(stat.Uid) == os.Geteuid() && int(stat.Gid) == os.Getegid()) {
+		return
+	}
+	os.Lchown(path, int(stat.Uid), int(stat.Gid))
+}
+
+// syncDir syncs a directory, so a file renamed into it survives a crash. Errors
+// are ignored, not every file system supports it.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// renameAttempts is how often renameReplacing tries to replace a file that is in use
+const renameAttempts = 5
+
+// renameReplacing renames oldpath to newpath, replacing newpath when it exists.
+// Windows refuses to replace a file another program has open without sharing
+// delete access, like an editor or a shell reading its profile, so that is tried
+// again a few times with a growing delay.
+func renameReplacing(oldpath, newpath string) error {
+	delay := 50 * time.Millisecond
+	var err error
+	for attempt := 0; attempt < renameAttempts; attempt++ {
+		if err = os.Rename(oldpath, newpath); err == nil || !inUse(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
+
+// inUse reports whether err means another program has the file open
+func inUse(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) ||
+		errors.Is(err, windows.ERROR_LOCK_VIOLATION) ||
+		errors.Is(err, windows.ERROR_ACCESS_DENIED)
+}
+
+// keepOwner does nothing on Windows, where files get the access control of the
+// directory they are created in
+func keepOwner(path string, replaced os.FileInfo) {}
+
+// syncDir does nothing on Windows, which cannot sync directories
+func syncDir(dir string) {}
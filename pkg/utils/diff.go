@@ -0,0 +1,428 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxDiffSize is the content size above which no line diff is produced
+	MaxDiffSize = 1 << 20
+
+	// maxDiffEdits bounds the work of a single diff. Inputs that differ in more
+	// places than this are shown as replacing everything after the edits found.
+	maxDiffEdits = 2000
+
+	// elideRunes is how much unchanged text stays around changes in long lines
+	elideRunes = 30
+
+	// longLine is the length above which unchanged parts of changed lines are elided
+	longLine = 120
+)
+
+const (
+	ansiReset   = "\033[0m"
+	ansiRed     = "\033[31m"
+	ansiGreen   = "\033[32m"
+	ansiCyan    = "\033[36m"
+	ansiReverse = "\033[7m"
+	ansiNoRev   = "\033[27m"
+)
+
+// ansiPattern matches ANSI color escape codes
+var ansiPattern = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// DiffOptions controls the output of UnifiedDiff
+type DiffOptions struct {
+	Context   int  // Unchanged lines shown around each change
+	IntraLine bool // Mark the changed words within changed lines
+	Color     bool // Color the diff with ANSI escape codes for a terminal
+	MaxLines  int  // Maximum number of lines returned, 0 for no limit
+}
+
+// diffOp is a single step of an edit script
+type diffOp struct {
+	Kind byte // ' ' for equal, '-' for deleted and '+' for inserted
+	A, B int  // Index in the old and new sequence
+}
+
+// UnifiedDiff returns a unified diff of two contents, one line per element, with
+// hunk headers but without the ---/+++ file header. Binary content and content
+// larger than MaxDiffSize is summarized in a single line instead.
+func UnifiedDiff(oldContent, newContent string, opts DiffOptions) []string {
+	if oldContent == newContent {
+		return nil
+	}
+	if len(oldContent) > MaxDiffSize || len(newContent) > MaxDiffSize || IsBinary(oldContent) || IsBinary(newContent) {
+		return []string{fmt.Sprintf("binary or large file differs (%d bytes → %d bytes)", len(oldContent), len(newContent))}
+	}
+
+	oldLines := splitDiffLines(oldContent)
+	newLines := splitDiffLines(newContent)
+	ops := diffSequences(oldLines, newLines)
+
+	var lines []string
+	for _, hunk := range diffHunks(ops, max(opts.Context, 0)) {
+		lines = append(lines, formatHunk(hunk, oldLines, newLines, opts)...)
+	}
+
+	if opts.MaxLines > 0 && len(lines) > opts.MaxLines {
+		hidden := len(lines) - opts.MaxLines
+		lines = append(lines[:opts.MaxLines], fmt.Sprintf("... (%d more diff lines not shown)", hidden))
+	}
+	return lines
+}
+
+// IsBinary reports whether content looks like binary data, which like git is
+// decided by a NUL byte in the first 8000 bytes
+func IsBinary(content string) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return strings.IndexByte(content, 0) >= 0
+}
+
+// StripANSI removes ANSI color escape codes from s
+func StripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// splitDiffLines splits content into lines. A final newline does not start an
+// empty line, a missing one is shown like diff does.
+func splitDiffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.Split(content, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file"
+	return lines
+}
+
+// diffSequences returns the shortest edit script turning a into b using the Myers
+// algorithm. Common prefixes and suffixes are matched up front.
+func diffSequences(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{Kind: ' ', A: i, B: i})
+	}
+	for _, op := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		op.A += prefix
+		op.B += prefix
+		ops = append(ops, op)
+	}
+	for i := suffix; i > 0; i-- {
+		ops = append(ops, diffOp{Kind: ' ', A: len(a) - i, B: len(b) - i})
+	}
+	return ops
+}
+
+// myers computes the edit script of a and b. After maxDiffEdits edits the rest of
+// a is deleted and the rest of b inserted, so pathological inputs stay cheap.
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 2
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	limit := min(n+m, maxDiffEdits)
+	endD, endK := -1, 0
+	for d := 0; d <= limit && endD < 0; d++ {
+		// Step d only reads diagonals -d-1 to d+1 of the previous step
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				endD, endK = d, k
+				break
+			}
+		}
+	}
+
+	if endD < 0 {
+		// Too many differences: give up and replace the whole range
+		var ops []diffOp
+		for i := 0; i < n; i++ {
+			ops = append(ops, diffOp{Kind: '-', A: i, B: 0})
+		}
+		for j := 0; j < m; j++ {
+			ops = append(ops, diffOp{Kind: '+', A: n, B: j})
+		}
+		return ops
+	}
+
+	// Walk the trace backwards to recover the path
+	var reversed []diffOp
+	x, y, k := n, m, endK
+	for d := endD; d > 0; d-- {
+		previous, base := trace[d], d+1
+		var prevK int
+		if k == -d || (k != d && previous[base+k-1] < previous[base+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := previous[base+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, diffOp{Kind: ' ', A: x, B: y})
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, diffOp{Kind: '+', A: x, B: y})
+		} else {
+			x--
+			reversed = append(reversed, diffOp{Kind: '-', A: x, B: y})
+		}
+		k = prevK
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, diffOp{Kind: ' ', A: x, B: y})
+	}
+
+	ops := make([]diffOp, len(reversed))
+	for i, op := range reversed {
+		ops[len(reversed)-1-i] = op
+	}
+	return ops
+}
+
+// diffHunks groups an edit script into hunks with context unchanged lines around
+// every change
+func diffHunks(ops []diffOp, context int) [][]diffOp {
+	var hunks [][]diffOp
+	start, end := -1, -1
+	for i, op := range ops {
+		if op.Kind == ' ' {
+			continue
+		}
+		from := max(i-context, 0)
+		if start >= 0 && from > end+1 {
+			hunks = append(hunks, ops[start:end+1])
+			start = -1
+		}
+		if start < 0 {
+			start = from
+		}
+		end = min(i+context, len(ops)-1)
+	}
+	if start >= 0 {
+		hunks = append(hunks, ops[start:end+1])
+	}
+	return hunks
+}
+
+// formatHunk formats a hunk with its @@ header
+func formatHunk(hunk []diffOp, oldLines, newLines []string, opts DiffOptions) []string {
+	oldStart, newStart := hunk[0].A, hunk[0].B
+	oldCount, newCount := 0, 0
+	for _, op := range hunk {
+		if op.Kind != '+' {
+			oldCount++
+		}
+		if op.Kind != '-' {
+			newCount++
+		}
+	}
+
+	lines := []string{colorize(fmt.Sprintf("@@ -%s +%s @@", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount)), ansiCyan, opts.Color)}
+	for i := 0; i < len(hunk); {
+		if hunk[i].Kind == ' ' {
+			lines = append(lines, " "+oldLines[hunk[i].A])
+			i++
+			continue
+		}
+
+		// A block of deleted lines followed by inserted lines is a change
+		var deleted, inserted []string
+		for ; i < len(hunk) && hunk[i].Kind == '-'; i++ {
+			deleted = append(deleted, oldLines[hunk[i].A])
+		}
+		for ; i < len(hunk) && hunk[i].Kind == '+'; i++ {
+			inserted = append(inserted, newLines[hunk[i].B])
+		}
+
+		if opts.IntraLine {
+			for j := 0; j < len(deleted) && j < len(inserted); j++ {
+				deleted[j], inserted[j] = wordDiff(deleted[j], inserted[j], opts.Color)
+			}
+		}
+
+		for _, line := range deleted {
+			lines = append(lines, colorize("-"+line, ansiRed, opts.Color))
+		}
+		for _, line := range inserted {
+			lines = append(lines, colorize("+"+line, ansiGreen, opts.Color))
+		}
+	}
+	return lines
+}
+
+// hunkRange formats the line range of a hunk header
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// colorize wraps s in an ANSI color when enabled
+func colorize(s, color string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// wordDiff marks the words that differ between a removed and an added line. With
+// color the changed words are shown in reverse video, otherwise they are wrapped in
+// [-removed-] and {+added+}. Lines with nothing in common are left unmarked.
+func wordDiff(oldLine, newLine string, color bool) (string, string) {
+	oldWords, newWords := splitWords(oldLine), splitWords(newLine)
+	ops := diffSequences(oldWords, newWords)
+
+	common := false
+	for _, op := range ops {
+		if op.Kind == ' ' && strings.TrimSpace(oldWords[op.A]) != "" {
+			common = true
+			break
+		}
+	}
+	if !common {
+		return oldLine, newLine
+	}
+
+	removedStart, removedEnd, addedStart, addedEnd := "[-", "-]", "{+", "+}"
+	if color {
+		removedStart, removedEnd, addedStart, addedEnd = ansiReverse, ansiNoRev, ansiReverse, ansiNoRev
+	}
+
+	var oldSpans, newSpans []wordSpan
+	for _, op := range ops {
+		switch op.Kind {
+		case ' ':
+			oldSpans = appendSpan(oldSpans, oldWords[op.A], false)
+			newSpans = appendSpan(newSpans, newWords[op.B], false)
+		case '-':
+			oldSpans = appendSpan(oldSpans, oldWords[op.A], true)
+		case '+':
+			newSpans = appendSpan(newSpans, newWords[op.B], true)
+		}
+	}
+
+	long := utf8.RuneCountInString(oldLine) > longLine || utf8.RuneCountInString(newLine) > longLine
+	return joinSpans(oldSpans, removedStart, removedEnd, long), joinSpans(newSpans, addedStart, addedEnd, long)
+}
+
+// wordSpan is a run of words that are either all changed or all unchanged
+type wordSpan struct {
+	Text    string
+	Changed bool
+}
+
+// appendSpan adds a word to the last span when it has the same state
+func appendSpan(spans []wordSpan, word string, changed bool) []wordSpan {
+	if len(spans) > 0 && spans[len(spans)-1].Changed == changed {
+		spans[len(spans)-1].Text += word
+		return spans
+	}
+	return append(spans, wordSpan{Text: word, Changed: changed})
+}
+
+// joinSpans joins spans, marking the changed ones. In long lines unchanged spans are
+// shortened to the text right around the changes.
+func joinSpans(spans []wordSpan, start, end string, long bool) string {
+	var b strings.Builder
+	for i, span := range spans {
+		if span.Changed {
+			b.WriteString(start + span.Text + end)
+			continue
+		}
+		text := span.Text
+		if long {
+			text = elide(text, i > 0, i < len(spans)-1)
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// elide shortens unchanged text, keeping elideRunes runes next to the changes
+// before and after it
+func elide(text string, keepStart, keepEnd bool) string {
+	runes := []rune(text)
+	switch {
+	case keepStart && keepEnd:
+		if len(runes) > 2*elideRunes+1 {
+			return string(runes[:elideRunes]) + "…" + string(runes[len(runes)-elideRunes:])
+		}
+	case keepStart:
+		if len(runes) > elideRunes+1 {
+			return string(runes[:elideRunes]) + "…"
+		}
+	case keepEnd:
+		if len(runes) > elideRunes+1 {
+			return "…" + string(runes[len(runes)-elideRunes:])
+		}
+	}
+	return text
+}
+
+// splitWords splits a line into words, runs of whitespace and single punctuation
+// characters, so "key": "value" diffs per token
+func splitWords(line string) []string {
+	var words []string
+	runes := []rune(line)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case isWordRune(runes[i]):
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+		case unicode.IsSpace(runes[i]):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		}
+		words = append(words, string(runes[i:j]))
+		i = j
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	oldContent := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newContent := "a\nb\nc\nD\ne\nf\ng\nh\ni\nj\nk\n"
+
+	tests := []struct {
+		name     string
+		context  int
+		expected []string
+	}{
+		{
+			name:    "default context",
+			context: 3,
+			expected: []string{
+				"@@ -1,10 +1,11 @@",
+				" a", " b", " c", "-d", "+D", " e", " f", " g", " h", " i", " j", "+k",
+			},
+		},
+		{
+			name:    "no context",
+			context: 0,
+			expected: []string{
+				"@@ -4 +4 @@", "-d", "+D",
+				"@@ -10,0 +11 @@", "+k",
+			},
+		},
+		{
+			name:    "one line of context",
+			context: 1,
+			expected: []string{
+				"@@ -3,3 +3,3 @@", " c", "-d", "+D", " e",
+				"@@ -10 +10,2 @@", " j", "+k",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := UnifiedDiff(oldContent, newContent, DiffOptions{Context: tt.context})
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: UnifiedDiff() = %q, want %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestUnifiedDiffInsertedLines(t *testing.T) {
+	// A naive positional diff would report every line after the insertion
+	got := UnifiedDiff("one\ntwo\nthree\n", "zero\none\ntwo\nthree\n", DiffOptions{Context: 0})
+	expected := []string{"@@ -0,0 +1 @@", "+zero"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+
+	if got := UnifiedDiff("same\n", "same\n", DiffOptions{}); got != nil {
+		t.Errorf("UnifiedDiff() of equal content = %q, want nil", got)
+	}
+
+	got = UnifiedDiff("a\n", "a", DiffOptions{})
+	expected = []string{"@@ -1 +1 @@", "-a", "+a\n\\ No newline at end of file"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+}
+
+func TestUnifiedDiffIntraLine(t *testing.T) {
+	oldContent := `{"editor.fontSize": 14, "editor.tabSize": 2}`
+	newContent := `{"editor.fontSize": 16, "editor.tabSize": 2}`
+
+	got := UnifiedDiff(oldContent, newContent, DiffOptions{IntraLine: true})
+	expected := []string{
+		"@@ -1 +1 @@",
+		`-{"editor.fontSize": [-14-], "editor.tabSize": 2}` + "\n\\ No newline at end of file",
+		`+{"editor.fontSize": {+16+}, "editor.tabSize": 2}` + "\n\\ No newline at end of file",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+
+	// Lines with nothing in common are not marked
+	got = UnifiedDiff("foo\n", "bar\n", DiffOptions{IntraLine: true})
+	expected = []string{"@@ -1 +1 @@", "-foo", "+bar"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+
+	// Long lines only keep the text around the change
+	long := strings.Repeat("x", 200)
+	got = UnifiedDiff(long+" old "+long+"\n", long+" new "+long+"\n", DiffOptions{IntraLine: true})
+	expected = []string{
+		"@@ -1 +1 @@",
+		"-…" + strings.Repeat("x", 29) + " [-old-] " + strings.Repeat("x", 29) + "…",
+		"+…" + strings.Repeat("x", 29) + " {+new+} " + strings.Repeat("x", 29) + "…",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+}
+
+func TestUnifiedDiffColor(t *testing.T) {
+	got := UnifiedDiff("a b\n", "a c\n", DiffOptions{IntraLine: true, Color: true})
+	expected := []string{
+		"\033[36m@@ -1 +1 @@\033[0m",
+		"\033[31m-a \033[7mb\033[27m\033[0m",
+		"\033[32m+a \033[7mc\033[27m\033[0m",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+
+	for i, line := range got {
+		if stripped := StripANSI(line); stripped != []string{"@@ -1 +1 @@", "-a b", "+a c"}[i] {
+			t.Errorf("StripANSI(%q) = %q", line, stripped)
+		}
+	}
+}
+
+func TestUnifiedDiffLimits(t *testing.T) {
+	got := UnifiedDiff("a\nb\nc\n", "x\ny\nz\n", DiffOptions{MaxLines: 3})
+	expected := []string{"@@ -1,3 +1,3 @@", "-a", "-b", "... (4 more diff lines not shown)"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+
+	got = UnifiedDiff("text\n", "bin\x00ary", DiffOptions{})
+	expected = []string{"binary or large file differs (5 bytes → 7 bytes)"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, expected)
+	}
+
+	large := strings.Repeat("line\n", MaxDiffSize/5+1)
+	got = UnifiedDiff("", large, DiffOptions{})
+	if len(got) != 1 || !strings.HasPrefix(got[0], "binary or large file differs") {
+		t.Errorf("UnifiedDiff() of large content = %q", got)
+	}
+}
+
+func TestUnifiedDiffManyEdits(t *testing.T) {
+	// Past maxDiffEdits the diff falls back to replacing everything, which is
+	// still a valid diff
+	var oldLines, newLines []string
+	for i := 0; i < maxDiffEdits; i++ {
+		oldLines = append(oldLines, "old", "same")
+		newLines = append(newLines, "new", "same")
+	}
+	got := UnifiedDiff(strings.Join(oldLines, "\n"), strings.Join(newLines, "\n"), DiffOptions{})
+
+	removed, added := 0, 0
+	for _, line := range got {
+		switch line[0] {
+		case '-':
+			removed++
+		case '+':
+			added++
+		}
+	}
+	if removed == 0 || removed != added {
+		t.Errorf("UnifiedDiff() removed %d and added %d lines", removed, added)
+	}
+}
@@ -239,58 +239,13 @@ func GetContentDiffSummary(oldContent, newContent string) []string {
 	return changes
 }
 
-// GetDetailedDiff returns a detailed diff showing actual content changes
+// GetDetailedDiff returns a unified diff with three lines of context and intra-line
+// markers, limited to maxLines lines. Use UnifiedDiff for more control.
 func GetDetailedDiff(oldContent, newContent string, maxLines int) []string {
 	if maxLines <= 0 {
 		maxLines = 20 // Default limit
 	}
-
-	var diff []string
-	oldLines := strings.Split(oldContent, "\n")
-	newLines := strings.Split(newContent, "\n")
-
-	// Simple line-by-line diff implementation
-	maxLength := len(oldLines)
-	if len(newLines) > maxLength {
-		maxLength = len(newLines)
-	}
-
-	diffCount := 0
-	for i := 0; i < maxLength && diffCount < maxLines; i++ {
-		oldLine := ""
-		newLine := ""
-
-		if i < len(oldLines) {
-			oldLine = oldLines[i]
-		}
-		if i < len(newLines) {
-			newLine = newLines[i]
-		}
-
-		if oldLine != newLine {
-			diffCount++
-			lineNum := i + 1
-
-			if oldLine != "" {
-				diff = append(diff, fmt.Sprintf("- %d: %s", lineNum, oldLine))
-			}
-			if newLine != "" {
-				diff = append(diff, fmt.Sprintf("+ %d: %s", lineNum, newLine))
-			}
-			if oldLine == "" {
-				diff = append(diff, fmt.Sprintf("+ %d: (new line)", lineNum))
-			}
-			if newLine == "" {
-				diff = append(diff, fmt.Sprintf("- %d: (deleted line)", lineNum))
-			}
-		}
-	}
-
-	if diffCount >= maxLines && maxLength > maxLines {
-		diff = append(diff, fmt.Sprintf("... (showing first %d changes, %d more changes not shown)", maxLines, maxLength-maxLines))
-	}
-
-	return diff
+	return UnifiedDiff(oldContent, newContent, DiffOptions{Context: 3, IntraLine: true, MaxLines: maxLines})
 }
 
 // ToJSONString converts any value to a pretty-printed JSON string
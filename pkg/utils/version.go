@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CompareVersions compares two version strings segment by segment.
+// It returns -1 if a < b, 0 if they are equal and 1 if a > b.
+// Numeric segments are compared as numbers, everything else lexically,
+// so "1.10.0" > "1.9.3" and "2.34.1-1ubuntu1" > "2.34.1".
+func CompareVersions(a, b string) int {
+	aParts := splitVersion(normalizeVersion(a))
+	bParts := splitVersion(normalizeVersion(b))
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		if i >= len(aParts) {
+			return -1
+		}
+		if i >= len(bParts) {
+			return 1
+		}
+
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			// Numbers sort after pre-release tags like "rc"
+			return 1
+		case bErr == nil:
+			return -1
+		default:
+			if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return 0
+}
+
+// VersionMatches reports whether an installed version satisfies a requested version.
+// A request matches exactly or as a prefix followed by a packaging suffix, so
+// "2.34.1" matches "2.34.1-1ubuntu1" and "1:2.34.1" but not "2.34.10".
+func VersionMatches(installed, requested string) bool {
+	installed = normalizeVersion(installed)
+	requested = normalizeVersion(requested)
+
+	if installed == requested {
+		return true
+	}
+	if !strings.HasPrefix(installed, requested) {
+		return false
+	}
+
+	next := rune(installed[len(requested)])
+	return !unicode.IsDigit(next) && !unicode.IsLetter(next)
+}
+
+// normalizeVersion strips a leading "v" and a Debian style epoch ("1:")
+func normalizeVersion(version string) string {
+	version = strings.TrimSpace(version)
+	if idx := strings.Index(version, ":"); idx > 0 {
+		if _, err := strconv.Atoi(version[:idx]); err == nil {
+			version = version[idx+1:]
+		}
+	}
+	if len(version) > 1 && (version[0] == 'v' || version[0] == 'V') && unicode.IsDigit(rune(version[1])) {
+		version = version[1:]
+	}
+	return version
+}
+
+// splitVersion splits a version into alternating numeric and non-numeric segments
+func splitVersion(version string) []string {
+	var parts []string
+	var current strings.Builder
+	var currentIsDigit bool
+
+	flush := func() {
+		if current.Len() > 0 {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range version {
+		if !unicode.IsDigit(r) && !unicode.IsLetter(r) {
+			flush()
+			continue
+		}
+		isDigit := unicode.IsDigit(r)
+		if current.Len() > 0 && isDigit != currentIsDigit {
+			flush()
+		}
+		currentIsDigit = isDigit
+		current.WriteRune(r)
+	}
+	flush()
+
+	return parts
+}
@@ -0,0 +1,50 @@
+package utils
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.7.5", "1.7.5", 0},
+		{"1.6.2", "1.7.5", -1},
+		{"1.10.0", "1.9.3", 1},
+		{"v2.0.0", "2.0.0", 0},
+		{"1:2.34.1", "2.34.1", 0},
+		{"2.34.1-1ubuntu1", "2.34.1", 1},
+		{"1.0.0-rc1", "1.0.0-1", -1},
+		{"5.4", "5.4.5", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_vs_"+tt.b, func(t *testing.T) {
+			if result := CompareVersions(tt.a, tt.b); result != tt.expected {
+				t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVersionMatches(t *testing.T) {
+	tests := []struct {
+		installed, requested string
+		expected             bool
+	}{
+		{"1.7.5", "1.7.5", true},
+		{"2.34.1-1ubuntu1", "2.34.1", true},
+		{"1:2.34.1-1ubuntu1", "2.34.1", true},
+		{"7.88.1-r1", "7.88.1", true},
+		{"v3.2.2", "3.2.2", true},
+		{"2.34.10", "2.34.1", false},
+		{"1.6.2", "1.7.5", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.installed+"_"+tt.requested, func(t *testing.T) {
+			if result := VersionMatches(tt.installed, tt.requested); result != tt.expected {
+				t.Errorf("VersionMatches(%q, %q) = %v, want %v", tt.installed, tt.requested, result, tt.expected)
+			}
+		})
+	}
+}